@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var bundleOutput string
+
+func newSupportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Download a support bundle (admin key required) to attach to an issue",
+		Args:  cobra.NoArgs,
+		RunE:  runSupportBundle,
+	}
+	cmd.Flags().StringVarP(&bundleOutput, "output", "o", "support-bundle.zip", "File to write the bundle to")
+	return cmd
+}
+
+func runSupportBundle(_ *cobra.Command, _ []string) error {
+	if local {
+		return fmt.Errorf("support-bundle is not available with --local: there is no server")
+	}
+	n, err := downloadSupportBundle(serverURL, apiKey, bundleOutput)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%d bytes)\n", bundleOutput, n)
+	return nil
+}
+
+// downloadSupportBundle saves GET /admin/support-bundle to path. The file is
+// only replaced once the whole bundle has arrived.
+func downloadSupportBundle(server, key, path string) (int64, error) {
+	req, _ := http.NewRequest("GET", server+"/admin/support-bundle", nil)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+
+	client := httpClient(2 * time.Minute)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError("support bundle failed", resp)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".support-bundle-*")
+	if err != nil {
+		return 0, fmt.Errorf("creating output file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	n, err := io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("downloading bundle: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return n, nil
+}
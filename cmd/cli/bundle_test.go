@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadSupportBundle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/support-bundle" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-API-Key") != "admin-key" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"admin access required","code":"ADMIN_REQUIRED"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK\x03\x04bundle"))
+	}))
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+
+	n, err := downloadSupportBundle(ts.URL, "admin-key", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "PK\x03\x04bundle" || n != int64(len(data)) {
+		t.Errorf("wrote %q, reported %d bytes", data, n)
+	}
+
+	// A refused download reports the API error and keeps the old file.
+	_, err = downloadSupportBundle(ts.URL, "user-key", path)
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_REQUIRED") {
+		t.Errorf("err = %v, want the API error", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "PK\x03\x04bundle" {
+		t.Errorf("failed download replaced the file with %q", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files behind, want only the bundle", len(entries))
+	}
+}
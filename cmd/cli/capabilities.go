@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// capabilities is the part of GET /capabilities the CLI checks.
+type capabilities struct {
+	Languages []struct {
+		Name     string `json:"name"`
+		Disabled bool   `json:"disabled"`
+	} `json:"languages"`
+	Features []struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+		Reason  string `json:"reason"`
+	} `json:"features"`
+}
+
+// fetchCapabilities reads GET /capabilities. It returns nil, and no error,
+// when the server can't answer: too old to have the endpoint, or refusing
+// the key, which the request itself will report.
+func fetchCapabilities(server, key string) (*capabilities, error) {
+	req, err := http.NewRequest("GET", server+"/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := httpClient(10 * time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	var caps capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, nil
+	}
+	return &caps, nil
+}
+
+// feature reports whether the server lists the feature name, and whether
+// it is enabled. A nil capabilities lists nothing.
+func (c *capabilities) feature(name string) (enabled, listed bool) {
+	if c == nil {
+		return false, false
+	}
+	for _, f := range c.Features {
+		if f.Name == name {
+			return f.Enabled, true
+		}
+	}
+	return false, false
+}
+
+// checkCapabilities fails fast when the server doesn't run lang or doesn't
+// support one of features, rather than after sending the request. A server
+// too old to have GET /capabilities is assumed to support everything; the
+// request itself will say if it doesn't.
+func checkCapabilities(server, key, lang string, features ...string) error {
+	caps, err := fetchCapabilities(server, key)
+	if err != nil || caps == nil {
+		return err
+	}
+
+	runs := false
+	for _, l := range caps.Languages {
+		if l.Name == lang {
+			if l.Disabled {
+				return fmt.Errorf("server has turned off %s", lang)
+			}
+			runs = true
+		}
+	}
+	if !runs {
+		return fmt.Errorf("server does not run %s", lang)
+	}
+	for _, name := range features {
+		for _, f := range caps.Features {
+			if f.Name == name && !f.Enabled {
+				return fmt.Errorf("server does not support %s: %s", name, f.Reason)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckCapabilities(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{
+			"languages": [{"name": "python"}, {"name": "node", "disabled": true}],
+			"features": [
+				{"name": "streaming", "enabled": true},
+				{"name": "claude_workdir", "enabled": false, "reason": "work_dir needs the claude runtime"}
+			]
+		}`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		lang     string
+		features []string
+		want     string
+	}{
+		{"python", []string{"streaming"}, ""},
+		{"node", nil, "turned off node"},
+		{"claude", nil, "does not run claude"},
+		{"python", []string{"claude_workdir"}, "does not support claude_workdir: work_dir needs"},
+	} {
+		err := checkCapabilities(srv.URL, "key", tc.lang, tc.features...)
+		if (tc.want == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %v: err = %v, want %q", tc.lang, tc.features, err, tc.want)
+		}
+	}
+
+	// A server that can't answer is left to answer the request itself.
+	if err := checkCapabilities(srv.URL, "wrong", "claude"); err != nil {
+		t.Errorf("unauthorized: %v", err)
+	}
+	old := httptest.NewServer(http.NotFoundHandler())
+	defer old.Close()
+	if err := checkCapabilities(old.URL, "key", "claude", "streaming"); err != nil {
+		t.Errorf("older server: %v", err)
+	}
+}
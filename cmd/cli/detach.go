@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/storage"
+)
+
+var (
+	detach       bool
+	asyncExec    bool
+	waitTimeout  time.Duration
+	waitInterval time.Duration
+	waitFormat   string
+)
+
+// detachGrace is how long exec --detach waits for the server to refuse an
+// execution held open in the background before taking it as accepted.
+const detachGrace = 2 * time.Second
+
+// waitNotFoundGrace is how long wait keeps polling an execution that is
+// neither running nor recorded before deciding there is no such execution.
+const waitNotFoundGrace = 30 * time.Second
+
+func newWaitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wait <id>",
+		Short: "Wait for an execution to finish, print its result and exit with its exit code",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runWait,
+	}
+	cmd.Flags().DurationVar(&waitTimeout, "timeout", 0, "Give up after this long; 0 waits for as long as it takes")
+	cmd.Flags().DurationVar(&waitInterval, "interval", time.Second, "How often to poll the server")
+	cmd.Flags().StringVar(&waitFormat, "format", "json", "Print the result as json, or text: its stdout and stderr")
+	return cmd
+}
+
+func newLogsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logs <id>",
+		Short: "Print the stored stdout and stderr of a finished execution",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runLogs,
+	}
+}
+
+// newHoldCmd is the background process of exec --detach on a server without
+// asynchronous execution. It reads a holdJob on stdin.
+func newHoldCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    "hold",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE:   runHold,
+	}
+}
+
+// holdJob is an execution request that a background sandbox-cli holds open
+// for exec --detach. A server without asynchronous execution runs an
+// execution only as long as its request, so something has to keep the
+// request open once exec has returned.
+type holdJob struct {
+	Endpoint    string         `json:"endpoint"`
+	ID          string         `json:"id"`
+	HTTPTimeout time.Duration  `json:"http_timeout"`
+	Payload     map[string]any `json:"payload"`
+}
+
+// submitDetached starts the execution in payload without waiting for it
+// and returns its ID. A server with the async feature runs it on its own;
+// otherwise the request is held open by a background sandbox-cli, under an
+// ID chosen here and sent as X-Request-ID, which the server takes as the
+// execution's.
+func submitDetached(payload map[string]any, httpTimeout time.Duration) (string, error) {
+	caps, err := fetchCapabilities(serverURL, apiKey)
+	if err != nil {
+		return "", err
+	}
+	if async, _ := caps.feature("async"); async {
+		return submitAsync(payload)
+	}
+
+	// A server too old to list its features still streams.
+	endpoint := "/execute/stream"
+	if streaming, listed := caps.feature("streaming"); listed && !streaming {
+		endpoint = "/execute"
+	}
+	job := holdJob{Endpoint: endpoint, ID: uuid.New().String(), HTTPTimeout: httpTimeout, Payload: payload}
+	if err := startHolder(job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// submitAsync sends payload to POST /execute/async, which answers with the
+// execution's ID as soon as it is queued.
+func submitAsync(payload map[string]any) (string, error) {
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", serverURL+"/execute/async", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := httpClient(30 * time.Second).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return "", responseError("execution refused", resp)
+	}
+	var accepted struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil || accepted.ID == "" {
+		return "", fmt.Errorf("decoding response: no execution ID")
+	}
+	return accepted.ID, nil
+}
+
+// startHolder runs job in the background and returns once the server has
+// taken it, or refused it, or detachGrace has passed without either. A
+// variable so tests can hold the request in-process.
+var startHolder = spawnHolder
+
+// spawnHolder starts sandbox-cli hold with job on its stdin. The holder
+// reports on file descriptor 3, closed once it has said whether the server
+// took the execution, so nothing it writes after this process has exited
+// can fail.
+func spawnHolder(job holdJob) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding sandbox-cli to run in the background: %w", err)
+	}
+	data, _ := json.Marshal(job)
+	stdin, jobw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	statusr, statusw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(self, "hold")
+	// The settings already resolved, so the holder doesn't resolve them
+	// again, or run a profile's key_command.
+	cmd.Env = append(os.Environ(), "SANDBOX_SERVER="+serverURL, "SANDBOX_API_KEY="+apiKey, "SANDBOX_CA_CERT="+caCert)
+	cmd.Stdin = stdin
+	cmd.ExtraFiles = []*os.File{statusw}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting background request: %w", err)
+	}
+	stdin.Close()
+	statusw.Close()
+	defer statusr.Close()
+	cmd.Process.Release()
+	_, err = jobw.Write(data)
+	jobw.Close()
+	if err != nil {
+		return fmt.Errorf("starting background request: %w", err)
+	}
+	return awaitHolder(statusr, detachGrace)
+}
+
+// awaitHolder reads the holder's verdict from status: nil once the server
+// took the execution, or when it hasn't answered within grace.
+func awaitHolder(status io.Reader, grace time.Duration) error {
+	verdict := make(chan string, 1)
+	go func() {
+		line, err := bufio.NewReader(status).ReadString('\n')
+		if err != nil && line == "" {
+			line = "error: background request exited without sending the execution"
+		}
+		verdict <- strings.TrimSpace(line)
+	}()
+	select {
+	case line := <-verdict:
+		if msg, failed := strings.CutPrefix(line, "error: "); failed {
+			return errors.New(msg)
+		}
+		return nil
+	case <-time.After(grace):
+		return nil // still running, or queued; wait will tell
+	}
+}
+
+func runHold(_ *cobra.Command, _ []string) error {
+	// Closing the terminal exec --detach ran in doesn't end the execution.
+	signal.Ignore(syscall.SIGHUP)
+	status := os.NewFile(3, "status")
+	var job holdJob
+	if err := json.NewDecoder(os.Stdin).Decode(&job); err != nil {
+		fmt.Fprintf(status, "error: reading the execution to send: %v\n", err)
+		status.Close()
+		return err
+	}
+	return holdExecution(job, status)
+}
+
+// holdExecution sends job's request and reads the response to its end,
+// which is when the execution ends. The first line written to status is
+// "ok" when the server took the execution, or "error: " and why not; status
+// is then closed. The output is left to the audit log, where wait and logs
+// read it.
+func holdExecution(job holdJob, status io.WriteCloser) error {
+	report := func(format string, args ...any) {
+		fmt.Fprintf(status, format+"\n", args...)
+		status.Close()
+	}
+	body, _ := json.Marshal(job.Payload)
+	req, err := http.NewRequest("POST", serverURL+job.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		report("error: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", job.ID)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := httpClient(job.HTTPTimeout).Do(req)
+	if err != nil {
+		report("error: request failed: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := responseError("execution refused", resp)
+		report("error: %v", err)
+		return err
+	}
+	report("ok")
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+func runWait(_ *cobra.Command, args []string) error {
+	if local {
+		return fmt.Errorf("wait is not available with --local: local executions are not detached")
+	}
+	if waitFormat != "json" && waitFormat != "text" {
+		return fmt.Errorf("--format must be json or text, got %q", waitFormat)
+	}
+	id := args[0]
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+	}
+
+	body, err := waitExecution(ctx, httpClient(10*time.Second), id, waitInterval)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s has not finished after %s; wait again with: sandbox-cli wait %s", id, waitTimeout, id)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("stopped waiting; %s carries on, wait again with: sandbox-cli wait %s", id, id)
+	case err != nil:
+		return err
+	}
+	exitCode, err := printExecution(os.Stdout, os.Stderr, body, waitFormat)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+	return nil
+}
+
+// waitExecution polls GET /executions/{id} until the execution has been
+// recorded, which it is once it has ended, and returns the record. While it
+// isn't, a server that runs executions asynchronously answers with the
+// status queued or running; an older one doesn't know, and GET
+// /executions/{id}/progress tells an execution still running, or just
+// finished, from one the server has never heard of. Stopping and waiting
+// again picks up where this left off: the server keeps the state.
+func waitExecution(ctx context.Context, client *http.Client, id string, interval time.Duration) ([]byte, error) {
+	var unseen time.Time // when the execution was found neither recorded nor running
+	for {
+		resp, body, err := getExecution(ctx, client, "/executions/"+id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			if !stillGoing(body) {
+				return body, nil
+			}
+			unseen = time.Time{}
+			if err := sleepCtx(ctx, interval); err != nil {
+				return nil, err
+			}
+			continue
+		case resp.StatusCode != http.StatusNotFound:
+			var apiErr apierror.Response
+			if json.Unmarshal(body, &apiErr) == nil && apiErr.Code == apierror.CodeDBUnavailable {
+				return nil, fmt.Errorf("the server keeps no execution records, so there is no result to wait for")
+			}
+			return nil, bodyError("wait failed", resp, body)
+		}
+
+		progress, _, err := getExecution(ctx, client, "/executions/"+id+"/progress")
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case progress.StatusCode == http.StatusOK:
+			unseen = time.Time{}
+		case unseen.IsZero():
+			unseen = time.Now()
+		case time.Since(unseen) > waitNotFoundGrace:
+			return nil, fmt.Errorf("execution %s not found", id)
+		}
+
+		if err := sleepCtx(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// stillGoing reports whether body, a 200 from GET /executions/{id}, is the
+// status of an execution that hasn't ended rather than its record.
+func stillGoing(body []byte) bool {
+	var status struct {
+		Status string `json:"status"`
+	}
+	json.Unmarshal(body, &status)
+	return status.Status == "queued" || status.Status == "running"
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// getExecution GETs path and reads the whole response.
+func getExecution(ctx context.Context, client *http.Client, path string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response: %w", err)
+	}
+	return resp, body, nil
+}
+
+// printExecution prints a stored execution record as json, or as text: its
+// stdout and stderr. It returns the execution's exit code.
+func printExecution(stdout, stderr io.Writer, body []byte, format string) (int, error) {
+	var exec storage.Execution
+	if err := json.Unmarshal(body, &exec); err != nil {
+		return 0, fmt.Errorf("decoding response: %w", err)
+	}
+	if format == "text" {
+		io.WriteString(stdout, exec.Output)
+		io.WriteString(stderr, exec.Stderr)
+		return exec.ExitCode, nil
+	}
+	if _, err := printJSON(stdout, body); err != nil {
+		return 0, err
+	}
+	return exec.ExitCode, nil
+}
+
+func runLogs(_ *cobra.Command, args []string) error {
+	if local {
+		return fmt.Errorf("logs is not available with --local: local executions are not recorded")
+	}
+	id := args[0]
+	resp, body, err := getExecution(context.Background(), httpClient(10*time.Second), "/executions/"+id)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no stored output for %s: it is still running, or unknown", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return bodyError("logs failed", resp, body)
+	}
+	_, err = printExecution(os.Stdout, os.Stderr, body, "text")
+	return err
+}
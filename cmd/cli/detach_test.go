@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useServer points the CLI at url for the rest of the test.
+func useServer(t *testing.T, url string) {
+	old := serverURL
+	serverURL = url
+	t.Cleanup(func() { serverURL = old })
+}
+
+// holdInProcess holds detached requests in this process rather than a
+// background sandbox-cli.
+func holdInProcess(t *testing.T) {
+	old := startHolder
+	startHolder = func(job holdJob) error {
+		r, w := io.Pipe()
+		go holdExecution(job, w)
+		return awaitHolder(r, time.Second)
+	}
+	t.Cleanup(func() { startHolder = old })
+}
+
+func TestSubmitDetached_Async(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/capabilities":
+			w.Write([]byte(`{"features": [{"name": "async", "enabled": true}, {"name": "streaming", "enabled": true}]}`))
+		case "/execute/async":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id": "2b1c3e0a-4f5d-4c6b-8a7e-9d0f1e2a3b4c", "state": "queued"}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	useServer(t, srv.URL)
+	old := startHolder
+	startHolder = func(holdJob) error { t.Error("held a request the server runs on its own"); return nil }
+	defer func() { startHolder = old }()
+
+	id, err := submitDetached(map[string]any{"code": "print(1)", "language": "python"}, time.Minute)
+	if err != nil || id != "2b1c3e0a-4f5d-4c6b-8a7e-9d0f1e2a3b4c" {
+		t.Errorf("submitDetached = %q, %v", id, err)
+	}
+}
+
+func TestSubmitDetached_HeldRequest(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		features string
+		got      []string // endpoint and X-Request-ID of each execution
+		refuse   bool
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/capabilities":
+			if features == "" {
+				w.WriteHeader(http.StatusNotFound) // a server from before /capabilities
+				return
+			}
+			w.Write([]byte(`{"languages": [{"name": "python"}], "features": ` + features + `}`))
+			return
+		}
+		got = append(got, r.URL.Path, r.Header.Get("X-Request-ID"))
+		if refuse {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": "rate limit exceeded", "code": "RATE_LIMITED"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		mu.Unlock()
+		<-release // the execution runs until the holder has gone
+		mu.Lock()
+	}))
+	defer srv.Close()
+	defer close(release)
+	useServer(t, srv.URL)
+	holdInProcess(t)
+
+	tests := []struct {
+		name     string
+		features string
+		refuse   bool
+		endpoint string
+		wantErr  string
+	}{
+		{"no capabilities", "", false, "/execute/stream", ""},
+		{"streaming", `[{"name": "streaming", "enabled": true}]`, false, "/execute/stream", ""},
+		{"streaming turned off", `[{"name": "streaming", "enabled": false}]`, false, "/execute", ""},
+		{"refused", "", true, "/execute/stream", "rate limit exceeded (RATE_LIMITED)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			features, refuse, got = tt.features, tt.refuse, nil
+			mu.Unlock()
+
+			id, err := submitDetached(map[string]any{"code": "print(1)", "language": "python"}, time.Minute)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("submitDetached = %q, %v; want an error about %s", id, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(got) != 2 || got[0] != tt.endpoint || got[1] != id {
+				t.Errorf("sent %v, want %s with the printed ID %s as X-Request-ID", got, tt.endpoint, id)
+			}
+		})
+	}
+}
+
+func TestAwaitHolder(t *testing.T) {
+	if err := awaitHolder(strings.NewReader("ok\n"), time.Second); err != nil {
+		t.Errorf("ok: %v", err)
+	}
+	if err := awaitHolder(strings.NewReader("error: execution refused: boom\n"), time.Second); err == nil || err.Error() != "execution refused: boom" {
+		t.Errorf("refused: %v", err)
+	}
+	if err := awaitHolder(strings.NewReader(""), time.Second); err == nil {
+		t.Error("a holder that exited without a word was taken as accepted")
+	}
+	// A server that hasn't answered by the grace period is taken as running.
+	r, w := io.Pipe()
+	defer w.Close()
+	if err := awaitHolder(r, 10*time.Millisecond); err != nil {
+		t.Errorf("silent holder: %v", err)
+	}
+}
+
+func TestWaitExecution(t *testing.T) {
+	const id = "2b1c3e0a-4f5d-4c6b-8a7e-9d0f1e2a3b4c"
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/executions/" + id:
+			switch n := polls.Add(1); {
+			case n <= 3:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": "execution not found", "code": "NOT_FOUND"}`))
+				return
+			case n <= 6: // a server that runs executions asynchronously
+				w.Write([]byte(`{"id": "` + id + `", "status": "running"}`))
+				return
+			}
+			w.Write([]byte(`{"id": "` + id + `", "exit_code": 3, "output": "out\n", "stderr": "err\n", "status": "completed"}`))
+		case "/executions/" + id + "/progress":
+			w.Write([]byte(`{"state": "running"}`))
+		case "/executions/00000000-0000-4000-8000-000000000000":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "database not configured", "code": "DB_UNAVAILABLE"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	useServer(t, srv.URL)
+	client := srv.Client()
+
+	// Interrupted while it runs, then picked up again.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err := waitExecution(ctx, client, id, 5*time.Millisecond)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) || polls.Load() == 0 {
+		t.Fatalf("interrupted wait: %v after %d polls", err, polls.Load())
+	}
+	body, err := waitExecution(context.Background(), client, id, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := printExecution(&stdout, &stderr, body, "text")
+	if err != nil || exitCode != 3 || stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("text: exit %d, %v, stdout %q, stderr %q", exitCode, err, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if exitCode, err := printExecution(&stdout, &stderr, body, "json"); err != nil || exitCode != 3 || !strings.Contains(stdout.String(), `"exit_code": 3`) {
+		t.Errorf("json: exit %d, %v:\n%s", exitCode, err, stdout.String())
+	}
+
+	if _, err := waitExecution(context.Background(), client, "00000000-0000-4000-8000-000000000000", time.Millisecond); err == nil || !strings.Contains(err.Error(), "no execution records") {
+		t.Errorf("server without a database: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	sse "safe-agent-sandbox/pkg/stream"
+)
+
+// Exit codes, by what went wrong. A program that ran and exited non-zero
+// exits with its own code instead.
+const (
+	exitFailure   = 1  // anything not classed below, such as an unreadable input file
+	exitConfig    = 64 // bad flags, settings or profile, or a request the server refused as invalid
+	exitServer    = 69 // the server is unreachable, busy or failed
+	exitExecution = 70 // the server couldn't run the code, it timed out, or it was blocked
+	exitAuth      = 77 // the API key is missing or not allowed
+)
+
+const exitCodesHelp = `Exit codes:
+  0    success
+  N    the program's own non-zero exit code
+  1    any other failure
+  64   configuration error: bad flags, settings or profile, or a request the server refused as invalid
+  69   server error: unreachable, rate limited, out of capacity, or failed
+  70   execution failure: the code couldn't be run, timed out, or was blocked
+  77   authentication error: a missing or rejected API key`
+
+// codeExits classes the error codes that aren't server errors.
+var codeExits = map[apierror.Code]int{
+	apierror.CodeInvalidRequest:      exitConfig,
+	apierror.CodeValidationError:     exitConfig,
+	apierror.CodeMethodNotAllowed:    exitConfig,
+	apierror.CodeChaosDisabled:       exitConfig,
+	apierror.CodeFeatureDisabled:     exitConfig,
+	apierror.CodeNetworkDenied:       exitConfig,
+	apierror.CodeInvalidDeadline:     exitConfig,
+	apierror.CodeClaudeTokenDisabled: exitConfig,
+	apierror.CodeWorkdirTooLarge:     exitConfig,
+	apierror.CodeWorkdirDirty:        exitConfig,
+	apierror.CodeCodeTooLarge:        exitConfig,
+	apierror.CodeUploadsDisabled:     exitConfig,
+	apierror.CodeNotFound:            exitConfig,
+	apierror.CodeCodeUnavailable:     exitConfig,
+	apierror.CodeInvalidPath:         exitConfig,
+	apierror.CodeWorkspacesDisabled:  exitConfig,
+	apierror.CodeWorkspaceNotFound:   exitConfig,
+	apierror.CodeWorkspaceTooLarge:   exitConfig,
+	apierror.CodeBundlesDisabled:     exitConfig,
+	apierror.CodeBundleNotFound:      exitConfig,
+	apierror.CodeInvalidBundle:       exitConfig,
+	apierror.CodeBundleTooLarge:      exitConfig,
+	apierror.CodeBundleNotApproved:   exitAuth,
+	apierror.CodeJobNotFound:         exitConfig,
+
+	apierror.CodeAuthRequired:     exitAuth,
+	apierror.CodeAdminRequired:    exitAuth,
+	apierror.CodeCredentialDenied: exitAuth,
+	apierror.CodeDebugTraceDenied: exitAuth,
+
+	apierror.CodeSecurityBlocked:      exitExecution,
+	apierror.CodeSeccompNotApplied:    exitExecution,
+	apierror.CodeCodeIntegrityFailure: exitExecution,
+	apierror.CodeExecutionFailed:      exitExecution,
+	apierror.CodeExecutionTimeout:     exitExecution,
+	apierror.CodeIdleOutputTimeout:    exitExecution,
+	apierror.CodeExecutionCancelled:   exitExecution,
+	apierror.CodeExecutionKilled:      exitExecution,
+}
+
+// codeHints says what to do about an error code, where there is more to
+// say than its message.
+var codeHints = map[apierror.Code]string{
+	apierror.CodeAuthRequired:            "set SANDBOX_API_KEY or pass --api-key",
+	apierror.CodeAdminRequired:           "use a key listed in the server's security.admin_keys",
+	apierror.CodeCredentialDenied:        "check the credential's name, and that this key is among its keys",
+	apierror.CodeDebugTraceDenied:        "ask the server's operator to add this key to sandbox.debug_trace.keys",
+	apierror.CodeRunnerUnavailable:       "the server has no sandbox backend; run `sandbox-cli health` to see its state",
+	apierror.CodeDBUnavailable:           "the server has no database for this; run `sandbox-cli health` to see its state",
+	apierror.CodeAuditUnavailable:        "the server can't record executions right now, so it runs none; retry shortly",
+	apierror.CodeLimitNotEnforced:        "the server's host can't enforce its resource limits; ask its operator, retrying won't help",
+	apierror.CodeExecutionTimeout:        "raise --timeout, or give a later --deadline",
+	apierror.CodeSetupTimeout:            "the server was slow to pull the image or start the container, not your code; retry shortly",
+	apierror.CodeIdleOutputTimeout:       "the program wrote nothing for too long; have it print progress",
+	apierror.CodeInvalidDeadline:         "check the --deadline, and your clock against the server_time below",
+	apierror.CodeSecurityBlocked:         "the code matched a critical sandbox escape pattern and was not run",
+	apierror.CodeClaudeImageIncompatible: "rebuild the claude image from deployments/docker/Dockerfile.claude",
+	apierror.CodeLanguageSaturated:       "every slot for the language is busy; retry shortly",
+	apierror.CodeClaudeLimitReached:      "the server is running all the claude sessions it allows; retry shortly",
+	apierror.CodeKeyConcurrencyLimit:     "this key has all the executions it may run at once going; retry once one ends",
+	apierror.CodeDailyQuotaExceeded:      "this key has run its executions for the day; the quota resets at midnight UTC",
+	apierror.CodeWorkdirDirty:            "commit or stash the changes in --dir first, so claude's can be told from yours",
+}
+
+// serverError is an error response from the server, with what the CLI was
+// doing when it got it.
+type serverError struct {
+	what       string
+	status     int
+	resp       apierror.Response // zero when the body isn't an error response
+	body       []byte
+	requestID  string
+	retryAfter time.Duration
+}
+
+// responseError reads an error response into an error prefixed with what.
+func responseError(what string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	return bodyError(what, resp, body)
+}
+
+// bodyError is responseError for a body already read.
+func bodyError(what string, resp *http.Response, body []byte) error {
+	e := &serverError{what: what, status: resp.StatusCode, body: body, requestID: resp.Header.Get("X-Request-ID")}
+	var apiErr apierror.Response
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		e.resp = apiErr
+		if apiErr.RequestID != "" {
+			e.requestID = apiErr.RequestID
+		}
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.retryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+func (e *serverError) Error() string {
+	if e.resp.Error != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.what, e.resp.Error, e.resp.Code)
+	}
+	return fmt.Sprintf("%s: HTTP %d", e.what, e.status)
+}
+
+// known reports whether the body was an error response with a code the
+// CLI knows.
+func (e *serverError) known() bool {
+	return e.resp.Error != "" && e.resp.Code.Known()
+}
+
+// configError marks an error in the CLI's flags, environment or profile.
+type configError struct{ err error }
+
+func (e configError) Error() string { return e.err.Error() }
+func (e configError) Unwrap() error { return e.err }
+
+// exitCodeFor picks the exit code for an error a command returned.
+func exitCodeFor(err error) int {
+	var (
+		server    *serverError
+		failed    *sse.Error
+		config    configError
+		transport *url.Error
+	)
+	switch {
+	case errors.As(err, &server):
+		if server.known() {
+			return exitForCode(server.resp.Code)
+		}
+		return exitForStatus(server.status)
+	case errors.As(err, &failed):
+		if exit, ok := codeExits[apierror.Code(failed.Code)]; ok {
+			return exit
+		}
+		return exitExecution
+	case errors.As(err, &config):
+		return exitConfig
+	case errors.As(err, &transport):
+		return exitServer
+	}
+	return exitFailure
+}
+
+func exitForCode(code apierror.Code) int {
+	if exit, ok := codeExits[code]; ok {
+		return exit
+	}
+	return exitServer
+}
+
+// exitForStatus classes a response without a code the CLI knows.
+func exitForStatus(status int) int {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return exitAuth
+	case status >= 400 && status < 500 && status != http.StatusTooManyRequests:
+		return exitConfig
+	default:
+		return exitServer
+	}
+}
+
+// palette colors the parts of a rendered error; its zero value doesn't.
+type palette struct {
+	on bool
+}
+
+func (p palette) paint(code, s string) string {
+	if !p.on {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func (p palette) error(s string) string { return p.paint("1;31", s) }
+func (p palette) hint(s string) string  { return p.paint("33", s) }
+func (p palette) dim(s string) string   { return p.paint("2", s) }
+
+// colorEnabled reports whether to color output to a stream: only a
+// terminal, and not with NO_COLOR set or TERM=dumb.
+func colorEnabled(getenv func(string) string, terminal bool) bool {
+	return terminal && getenv("NO_COLOR") == "" && getenv("TERM") != "dumb"
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// maxRawBody caps how much of a body without an error the CLI knows is shown.
+const maxRawBody = 2048
+
+// renderError writes err for a person: what failed, what to do about it,
+// the details the server sent and the request ID to quote for support.
+// A body the CLI can't interpret is shown as it came.
+func renderError(w io.Writer, err error, p palette) {
+	var (
+		server *serverError
+		failed *sse.Error
+	)
+	switch {
+	case errors.As(err, &server) && server.known():
+		r := server.resp
+		fmt.Fprintf(w, "%s %s: %s %s\n", p.error("error:"), server.what, r.Error, p.dim("("+string(r.Code)+")"))
+		if hint, ok := codeHints[r.Code]; ok {
+			fmt.Fprintf(w, "  %s\n", p.hint(hint))
+		}
+		if server.retryAfter > 0 {
+			fmt.Fprintf(w, "  %s\n", p.hint(fmt.Sprintf("retry in %s", server.retryAfter)))
+		}
+		renderDetails(w, r.Details)
+		renderRequestID(w, server.requestID, p)
+	case errors.As(err, &server):
+		fmt.Fprintf(w, "%s %s: HTTP %d\n", p.error("error:"), server.what, server.status)
+		if body := strings.TrimSpace(string(server.body)); body != "" {
+			if len(body) > maxRawBody {
+				body = body[:maxRawBody] + "..."
+			}
+			fmt.Fprintln(w, body)
+		}
+		renderRequestID(w, server.requestID, p)
+	case errors.As(err, &failed):
+		fmt.Fprintf(w, "%s %s %s\n", p.error("error:"), strings.TrimSuffix(err.Error(), " ("+failed.Code+")"), p.dim("("+failed.Code+")"))
+		if hint, ok := codeHints[apierror.Code(failed.Code)]; ok {
+			fmt.Fprintf(w, "  %s\n", p.hint(hint))
+		}
+		renderRequestID(w, failed.RequestID, p)
+	default:
+		fmt.Fprintf(w, "%s %v\n", p.error("error:"), err)
+	}
+}
+
+// renderDetails lists an error's details, one per line by key.
+func renderDetails(w io.Writer, details map[string]any) {
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := details[k]
+		switch v.(type) {
+		case map[string]any, []any:
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(w, "  %s: %s\n", k, data)
+		default:
+			fmt.Fprintf(w, "  %s: %v\n", k, v)
+		}
+	}
+}
+
+func renderRequestID(w io.Writer, id string, p palette) {
+	if id != "" {
+		fmt.Fprintf(w, "  %s\n", p.dim("request ID: "+id))
+	}
+}
+
+// countdown redraws the time left until d has passed, for a terminal.
+func countdown(w io.Writer, d time.Duration) {
+	for left := d; left > 0; left -= time.Second {
+		fmt.Fprintf(w, "\r\033[Kretry in %s", left)
+		time.Sleep(min(time.Second, left))
+	}
+	fmt.Fprint(w, "\r\033[Kretry now\n")
+}
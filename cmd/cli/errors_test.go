@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sse "safe-agent-sandbox/pkg/stream"
+)
+
+// errorResponse is the response a server sends with status and body.
+func errorResponse(status int, body string, header map[string]string) *http.Response {
+	rec := httptest.NewRecorder()
+	for k, v := range header {
+		rec.Header().Set(k, v)
+	}
+	rec.WriteHeader(status)
+	rec.WriteString(body)
+	return rec.Result()
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"auth required", responseError("x", errorResponse(401, `{"error":"unauthorized","code":"AUTH_REQUIRED"}`, nil)), exitAuth},
+		{"credential denied", responseError("x", errorResponse(403, `{"error":"no","code":"CREDENTIAL_DENIED"}`, nil)), exitAuth},
+		{"validation", responseError("x", errorResponse(400, `{"error":"bad limits","code":"VALIDATION_ERROR"}`, nil)), exitConfig},
+		{"rate limited", responseError("x", errorResponse(429, `{"error":"slow down","code":"RATE_LIMITED"}`, nil)), exitServer},
+		{"runner unavailable", responseError("x", errorResponse(503, `{"error":"no backend","code":"RUNNER_UNAVAILABLE"}`, nil)), exitServer},
+		{"security blocked", responseError("x", errorResponse(403, `{"error":"blocked","code":"SECURITY_BLOCKED"}`, nil)), exitExecution},
+		{"execution timeout", responseError("x", errorResponse(504, `{"error":"timed out","code":"EXECUTION_TIMEOUT"}`, nil)), exitExecution},
+		{"unknown code, 4xx", responseError("x", errorResponse(409, `{"error":"new","code":"SOMETHING_NEW"}`, nil)), exitConfig},
+		{"non-JSON 401", responseError("x", errorResponse(401, "Unauthorized\n", nil)), exitAuth},
+		{"non-JSON 502", responseError("x", errorResponse(502, "<html>Bad Gateway</html>", nil)), exitServer},
+		{"stream error", fmt.Errorf("execution failed: %w", &sse.Error{Message: "timed out", Code: "IDLE_OUTPUT_TIMEOUT"}), exitExecution},
+		{"stream error, unknown code", fmt.Errorf("execution failed: %w", &sse.Error{Message: "?", Code: "NEW"}), exitExecution},
+		{"bad flag", configError{errors.New("invalid deadline")}, exitConfig},
+		{"unreachable", fmt.Errorf("request failed: %w", &url.Error{Op: "Post", URL: "http://localhost:8080/execute", Err: errors.New("connection refused")}), exitServer},
+		{"other", errors.New("reading file: no such file"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCodeFor(tt.err); got != tt.want {
+			t.Errorf("%s: exit %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []string
+		not  []string
+	}{
+		{
+			"auth hint and request ID",
+			responseError("execution refused", errorResponse(401, `{"error":"unauthorized","code":"AUTH_REQUIRED","request_id":"req-1"}`, nil)),
+			[]string{"error: execution refused: unauthorized (AUTH_REQUIRED)", "set SANDBOX_API_KEY or pass --api-key", "request ID: req-1"},
+			[]string{`{"error"`},
+		},
+		{
+			"retry after",
+			responseError("execution refused", errorResponse(429, `{"error":"rate limit exceeded","code":"RATE_LIMITED","request_id":"req-2"}`, map[string]string{"Retry-After": "12"})),
+			[]string{"rate limit exceeded (RATE_LIMITED)", "retry in 12s"},
+			nil,
+		},
+		{
+			"validation details",
+			responseError("execution refused", errorResponse(400, `{"error":"invalid limits","code":"VALIDATION_ERROR","details":{"memory_mb":"over 4096","field":{"name":"limits"}}}`, nil)),
+			[]string{"invalid limits (VALIDATION_ERROR)", "  field: {\"name\":\"limits\"}\n  memory_mb: over 4096"},
+			nil,
+		},
+		{
+			"runner unavailable",
+			responseError("execution refused", errorResponse(503, `{"error":"sandbox backend unavailable","code":"RUNNER_UNAVAILABLE"}`, nil)),
+			[]string{"sandbox-cli health"},
+			nil,
+		},
+		{
+			"unknown code shows the body",
+			responseError("list failed", errorResponse(418, `{"error":"teapot","code":"TEAPOT"}`, map[string]string{"X-Request-ID": "req-3"})),
+			[]string{"error: list failed: HTTP 418", `{"error":"teapot","code":"TEAPOT"}`, "request ID: req-3"},
+			nil,
+		},
+		{
+			"non-JSON body",
+			responseError("execution refused", errorResponse(502, "<html>Bad Gateway</html>", nil)),
+			[]string{"HTTP 502", "<html>Bad Gateway</html>"},
+			nil,
+		},
+		{
+			"stream error",
+			fmt.Errorf("execution failed: %w", &sse.Error{Message: "execution timed out", Code: "EXECUTION_TIMEOUT", RequestID: "req-4"}),
+			[]string{"error: execution failed: execution timed out (EXECUTION_TIMEOUT)", "raise --timeout", "request ID: req-4"},
+			nil,
+		},
+		{
+			"plain error",
+			errors.New("reading file: no such file"),
+			[]string{"error: reading file: no such file\n"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		renderError(&buf, tt.err, palette{})
+		got := buf.String()
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: rendered %q, missing %q", tt.name, got, want)
+			}
+		}
+		for _, not := range tt.not {
+			if strings.Contains(got, not) {
+				t.Errorf("%s: rendered %q, want no %q", tt.name, got, not)
+			}
+		}
+		if strings.Contains(got, "\033[") {
+			t.Errorf("%s: colored without a palette: %q", tt.name, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	renderError(&buf, errors.New("boom"), palette{on: true})
+	if got := buf.String(); got != "\033[1;31merror:\033[0m boom\n" {
+		t.Errorf("colored: %q", got)
+	}
+
+	long := responseError("x", errorResponse(500, strings.Repeat("a", 3*maxRawBody), nil))
+	buf.Reset()
+	renderError(&buf, long, palette{})
+	if buf.Len() > maxRawBody+100 {
+		t.Errorf("rendered %d bytes of a raw body, want it capped", buf.Len())
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	tests := []struct {
+		name     string
+		vars     map[string]string
+		terminal bool
+		want     bool
+	}{
+		{"terminal", map[string]string{"TERM": "xterm-256color"}, true, true},
+		{"not a terminal", map[string]string{"TERM": "xterm-256color"}, false, false},
+		{"NO_COLOR", map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}, true, false},
+		{"dumb terminal", map[string]string{"TERM": "dumb"}, true, false},
+	}
+	for _, tt := range tests {
+		if got := colorEnabled(env(tt.vars), tt.terminal); got != tt.want {
+			t.Errorf("%s: colorEnabled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountdown(t *testing.T) {
+	var buf bytes.Buffer
+	countdown(&buf, 300*time.Millisecond)
+	if got := buf.String(); !strings.Contains(got, "retry in 300ms") || !strings.HasSuffix(got, "retry now\n") {
+		t.Errorf("countdown wrote %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"safe-agent-sandbox/internal/api"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+const defaultConfigPath = "configs/config.yaml"
+
+// localConfig finds the config for --local runs the way the server does:
+// --config, then $CONFIG_PATH, then configs/config.yaml in the working
+// directory, falling back to the defaults when none exists.
+func localConfig() (*config.Config, error) {
+	path := configPath
+	if path == "" {
+		path = os.Getenv("CONFIG_PATH")
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigPath); err != nil {
+			return config.DefaultConfig(), nil
+		}
+		path = defaultConfigPath
+	}
+	return config.Load(path)
+}
+
+// executeLocal runs req with an in-process Docker runner instead of a server.
+// It returns the exit code of the sandboxed program.
+func executeLocal(ctx context.Context, req sandbox.ExecutionRequest, stream bool) (int, error) {
+	if req.Language == "claude" {
+		return 0, fmt.Errorf("claude is not supported with --local: it needs the server's auth proxy, run it through sandbox-server instead")
+	}
+
+	cfg, err := localConfig()
+	if err != nil {
+		return 0, err
+	}
+	runner, err := sandbox.NewLocalDockerRunner(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("local mode: %w", err)
+	}
+	defer runner.Close()
+
+	return runLocal(ctx, runner, req, stream, os.Stdout, os.Stderr)
+}
+
+// runLocal prints results in the same formats as the remote path: the
+// response JSON, or with stream the raw output as it is produced.
+func runLocal(ctx context.Context, backend sandbox.Backend, req sandbox.ExecutionRequest, stream bool, stdout, stderr io.Writer) (int, error) {
+	if stream {
+		result, err := backend.ExecuteStreaming(ctx, req, stdout, stderr)
+		if result == nil {
+			return 0, fmt.Errorf("execution failed: %w", err)
+		}
+		reportEnd(stderr, string(result.ExitClass), result.Duration.String())
+		return result.ExitCode, nil
+	}
+
+	result, err := backend.Execute(ctx, req)
+	if result == nil {
+		return 0, fmt.Errorf("execution failed: %w", err)
+	}
+	body, _ := json.Marshal(api.NewExecutionResponse(result, req.SharedMounts))
+	if _, err := printJSON(stdout, body); err != nil {
+		return 0, err
+	}
+	return result.ExitCode, nil
+}
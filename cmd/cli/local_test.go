@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+func TestLocalConfig_Discovery(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("CONFIG_PATH", "")
+	configPath = ""
+	t.Cleanup(func() { configPath = "" })
+
+	cfg, err := localConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Sandbox.MaxConcurrent != config.DefaultConfig().Sandbox.MaxConcurrent {
+		t.Errorf("no config file: got %+v, want defaults", cfg.Sandbox)
+	}
+
+	if err := os.MkdirAll("configs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(defaultConfigPath, []byte("sandbox:\n  max_concurrent: 3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = localConfig(); err != nil || cfg.Sandbox.MaxConcurrent != 3 {
+		t.Errorf("configs/config.yaml: max_concurrent = %d, %v", cfg.Sandbox.MaxConcurrent, err)
+	}
+
+	explicit := filepath.Join(t.TempDir(), "sandbox.yaml")
+	if err := os.WriteFile(explicit, []byte("sandbox:\n  max_concurrent: 7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", explicit)
+	if cfg, err = localConfig(); err != nil || cfg.Sandbox.MaxConcurrent != 7 {
+		t.Errorf("$CONFIG_PATH: max_concurrent = %d, %v", cfg.Sandbox.MaxConcurrent, err)
+	}
+
+	configPath = filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := localConfig(); err == nil {
+		t.Error("--config pointing at a missing file was ignored")
+	}
+}
+
+func TestExecuteLocal_ClaudeUnsupported(t *testing.T) {
+	_, err := executeLocal(context.Background(), sandbox.ExecutionRequest{Language: "claude", Code: "hi"}, false)
+	if err == nil || !strings.Contains(err.Error(), "not supported with --local") {
+		t.Errorf("err = %v, want a clear unsupported error", err)
+	}
+}
+
+// outputLog records writes to stdout and stderr in arrival order.
+type outputLog struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+type taggedWriter struct {
+	log *outputLog
+	tag string
+}
+
+func (w taggedWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	w.log.chunks = append(w.log.chunks, w.tag+":"+string(p))
+	return len(p), nil
+}
+
+func TestRunLocal_StreamsInOrder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping docker test in short mode")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Docker not installed, skipping")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("Docker daemon not running, skipping")
+	}
+
+	runner, err := sandbox.NewLocalDockerRunner(config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	var out outputLog
+	exitCode, err := runLocal(context.Background(), runner, sandbox.ExecutionRequest{
+		Language: "bash",
+		Code:     "echo hello; sleep 0.5; echo warning >&2; sleep 0.5; echo world; exit 3",
+		Timeout:  30 * time.Second,
+		Limits:   sandbox.DefaultLimits(),
+	}, true, taggedWriter{&out, "stdout"}, taggedWriter{&out, "stderr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 3 {
+		t.Errorf("exit code = %d, want 3", exitCode)
+	}
+
+	got := strings.Join(out.chunks, "")
+	want := "stdout:hello\nstderr:warning\nstdout:world\n"
+	if got != want {
+		t.Errorf("streamed output = %q, want %q", got, want)
+	}
+}
@@ -2,65 +2,112 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+
+	"safe-agent-sandbox/internal/runtime"
+	"safe-agent-sandbox/internal/sandbox"
+	sse "safe-agent-sandbox/pkg/stream"
 )
 
 var (
-	serverURL string
-	apiKey    string
-	timeout   string
-	language  string
-	memoryMB  int64
-	workDir   string
+	serverURL  string
+	apiKey     string
+	timeout    string
+	deadline   string
+	language   string
+	memoryMB   int64
+	workDir    string
+	local      bool
+	configPath string
+	stream     bool
+	stdinFile  string
 )
 
 func main() {
 	root := &cobra.Command{
 		Use:   "sandbox-cli",
 		Short: "CLI client for safe-agent-sandbox",
+		Long:  "CLI client for safe-agent-sandbox.\n\n" + exitCodesHelp,
+		// Flags, environment and the profile are resolved before any
+		// command runs. Past them, a failure is no reason to show usage.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applySettings(cmd, args); err != nil {
+				return configError{err}
+			}
+			cmd.SilenceUsage = true
+			return nil
+		},
+		// Errors are rendered by main, from the server's error codes.
+		SilenceErrors: true,
 	}
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error { return configError{err} })
 
-	root.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:8080", "Server URL")
-	root.PersistentFlags().StringVar(&apiKey, "api-key", os.Getenv("SANDBOX_API_KEY"), "API key")
+	root.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:8080", "Server URL (or $SANDBOX_SERVER)")
+	root.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key (or $SANDBOX_API_KEY)")
+	root.PersistentFlags().StringVar(&caCert, "ca-cert", "", "PEM CA certificate to trust for the server (or $SANDBOX_CA_CERT)")
+	root.PersistentFlags().StringVar(&profileName, "profile", "", "Profile from ~/.config/sandbox-cli/config.yaml (or $SANDBOX_PROFILE)")
+	root.PersistentFlags().BoolVar(&local, "local", false, "Run in-process with Docker instead of calling a server")
+	root.PersistentFlags().StringVar(&configPath, "config", "", "Config file for --local (default: $CONFIG_PATH or configs/config.yaml)")
 
 	execCmd := &cobra.Command{
-		Use:   "exec [code]",
-		Short: "Execute code in a sandbox",
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  runExec,
+		Use:     "exec [code]",
+		Short:   "Execute code in a sandbox",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkDeadline,
+		RunE:    runExec,
 	}
-	execCmd.Flags().StringVar(&timeout, "timeout", "10s", "Execution timeout")
-	execCmd.Flags().StringVarP(&language, "language", "l", "python", "Language (python, node, bash)")
+	execCmd.Flags().StringVar(&timeout, "timeout", "", "Execution timeout (default the language's: 30s for go, 10s for the rest)")
+	execCmd.Flags().StringVar(&deadline, "deadline", "", "Finish by this RFC3339 time instead of after --timeout")
+	execCmd.Flags().StringVarP(&language, "language", "l", "python", "Language (python, node, bash, go, ruby, deno, bun; aliases such as py and js work too)")
 	execCmd.Flags().Int64Var(&memoryMB, "memory", 256, "Memory limit in MB")
+	execCmd.Flags().BoolVar(&stream, "stream", false, "Stream output as it is produced")
+	execCmd.Flags().BoolVar(&detach, "detach", false, "Print the execution ID and exit; see wait and logs")
+	execCmd.Flags().BoolVar(&asyncExec, "async", false, "Like --detach, but only on a server that runs executions asynchronously")
+	execCmd.Flags().StringVar(&stdinFile, "stdin-file", "", "File to pass to the program as its stdin")
 	root.AddCommand(execCmd)
 
 	execFileCmd := &cobra.Command{
-		Use:   "exec-file [file]",
-		Short: "Execute code from a file",
-		Args:  cobra.ExactArgs(1),
-		RunE:  runExecFile,
+		Use:     "exec-file [file]",
+		Short:   "Execute code from a file",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkDeadline,
+		RunE:    runExecFile,
 	}
-	execFileCmd.Flags().StringVar(&timeout, "timeout", "10s", "Execution timeout")
+	execFileCmd.Flags().StringVar(&timeout, "timeout", "", "Execution timeout (default the language's: 30s for go, 10s for the rest)")
+	execFileCmd.Flags().StringVar(&deadline, "deadline", "", "Finish by this RFC3339 time instead of after --timeout")
 	execFileCmd.Flags().StringVarP(&language, "language", "l", "", "Language (auto-detected from extension)")
 	execFileCmd.Flags().Int64Var(&memoryMB, "memory", 256, "Memory limit in MB")
+	execFileCmd.Flags().BoolVar(&stream, "stream", false, "Stream output as it is produced")
+	execFileCmd.Flags().BoolVar(&detach, "detach", false, "Print the execution ID and exit; see wait and logs")
+	execFileCmd.Flags().BoolVar(&asyncExec, "async", false, "Like --detach, but only on a server that runs executions asynchronously")
+	execFileCmd.Flags().StringVar(&stdinFile, "stdin-file", "", "File to pass to the program as its stdin")
 	root.AddCommand(execFileCmd)
 
 	claudeCmd := &cobra.Command{
-		Use:   "claude [prompt]",
-		Short: "Run Claude Code in a sandboxed container",
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  runClaude,
+		Use:     "claude [prompt]",
+		Short:   "Run Claude Code in a sandboxed container",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkDeadline,
+		RunE:    runClaude,
 	}
 	claudeCmd.Flags().StringVar(&workDir, "dir", "", "Project directory to mount (default: current directory)")
 	claudeCmd.Flags().StringVar(&timeout, "timeout", "5m", "Execution timeout")
+	claudeCmd.Flags().StringVar(&deadline, "deadline", "", "Finish by this RFC3339 time instead of after --timeout")
 	claudeCmd.Flags().Int64Var(&memoryMB, "memory", 1024, "Memory limit in MB")
 	root.AddCommand(claudeCmd)
 
@@ -76,11 +123,47 @@ func main() {
 		RunE:  runList,
 	})
 
+	root.AddCommand(&cobra.Command{
+		Use:   "kill <id>",
+		Short: "Kill a running execution",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runKill,
+	})
+
+	root.AddCommand(newWaitCmd())
+	root.AddCommand(newLogsCmd())
+	root.AddCommand(newHoldCmd())
+	root.AddCommand(newReportCmd())
+	root.AddCommand(newSupportBundleCmd())
+	root.AddCommand(newVerifyCmd())
+	root.AddCommand(newConfigCmd())
+
 	if err := root.Execute(); err != nil {
-		os.Exit(1)
+		terminal := isTerminal(os.Stderr)
+		renderError(os.Stderr, err, palette{on: colorEnabled(os.Getenv, terminal)})
+		var server *serverError
+		if errors.As(err, &server) && server.retryAfter > 0 && terminal {
+			countdown(os.Stderr, server.retryAfter)
+		}
+		os.Exit(exitCodeFor(err))
 	}
 }
 
+// checkDeadline rejects a --deadline that isn't RFC3339 or comes with an
+// explicit --timeout. The server converts a deadline against its own clock.
+func checkDeadline(cmd *cobra.Command, _ []string) error {
+	if deadline == "" {
+		return nil
+	}
+	if cmd.Flags().Changed("timeout") {
+		return configError{fmt.Errorf("--timeout and --deadline are mutually exclusive")}
+	}
+	if _, err := time.Parse(time.RFC3339, deadline); err != nil {
+		return configError{fmt.Errorf("invalid deadline: %w", err)}
+	}
+	return nil
+}
+
 func runExec(cmd *cobra.Command, args []string) error {
 	var code string
 
@@ -138,49 +221,145 @@ func runExecFile(cmd *cobra.Command, args []string) error {
 	}
 
 	if language == "" {
-		switch ext := fileExtension(args[0]); ext {
-		case ".py":
-			language = "python"
-		case ".js":
-			language = "node"
-		case ".sh":
-			language = "bash"
-		default:
-			return fmt.Errorf("cannot detect language for extension %q, use --language flag", ext)
+		detected, err := detectLanguage(fileExtension(args[0]))
+		if err != nil {
+			return err
 		}
+		language = detected
 	}
 
 	return executeCode(string(data), language, "")
 }
 
 func executeCode(code, lang, projectDir string) error {
+	// Aliases such as py are sent as the runtime's name, so profiles,
+	// local mode and older servers all see one spelling.
+	if name, ok := runtime.NewRegistry().Canonical(lang); ok {
+		lang = name
+	}
+	limits := applyProfileLimits(sandbox.ResourceLimits{MemoryMB: memoryMB, CPUShares: 512, PidsLimit: 50, DiskMB: 100})
+	if lang == "claude" {
+		limits = sandbox.ResourceLimits{MemoryMB: memoryMB, CPUShares: 2048, PidsLimit: 200, DiskMB: 500}
+	}
+
+	if (detach || asyncExec) && (stream || local) {
+		return configError{fmt.Errorf("--detach and --async can't be combined with --stream or --local")}
+	}
+
+	var input string
+	if stdinFile != "" {
+		data, err := os.ReadFile(stdinFile)
+		if err != nil {
+			return configError{fmt.Errorf("reading --stdin-file: %w", err)}
+		}
+		input = string(data)
+	}
+
+	var finishBy time.Time
+	if deadline != "" {
+		finishBy, _ = time.Parse(time.RFC3339, deadline) // checked by checkDeadline
+	}
+
+	if local {
+		d := sandbox.DefaultTimeout(lang)
+		if timeout != "" {
+			var err error
+			if d, err = time.ParseDuration(timeout); err != nil {
+				return configError{fmt.Errorf("invalid timeout: %w", err)}
+			}
+		}
+		if !finishBy.IsZero() {
+			if d = time.Until(finishBy); d <= 0 {
+				return fmt.Errorf("deadline %s has passed", deadline)
+			}
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		exitCode, err := executeLocal(ctx, sandbox.ExecutionRequest{
+			Code:     code,
+			Language: lang,
+			Timeout:  d,
+			Limits:   limits,
+			Stdin:    input,
+			// The runner gives claude no network of its own; the server's
+			// network policy would, so local runs do it here.
+			NetworkEnabled: lang == "claude",
+		}, stream)
+		stop()
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+		return nil
+	}
+
+	if lang == "binary" {
+		code = base64.StdEncoding.EncodeToString([]byte(code)) // the API takes executables base64-encoded
+	}
 	payload := map[string]any{
 		"code":     code,
 		"language": lang,
 		"timeout":  timeout,
-		"limits": map[string]any{
-			"memory_mb":  memoryMB,
-			"cpu_shares": 512,
-			"pids_limit": 50,
-			"disk_mb":    100,
-		},
+		"limits":   limits,
+	}
+	if timeout == "" { // the server's default for the language
+		delete(payload, "timeout")
+	}
+	if !finishBy.IsZero() {
+		delete(payload, "timeout")
+		payload["deadline"] = deadline
+	}
+	var features []string
+	if lang == "claude" && projectDir != "" {
+		payload["work_dir"] = projectDir
+		features = append(features, "claude_workdir")
+	}
+	if stream {
+		features = append(features, "streaming")
+	}
+	if input != "" {
+		payload["stdin"] = input
+		features = append(features, "stdin")
+	}
+	// Claude sessions and streams are long to wait for just to be refused.
+	if lang == "claude" || len(features) > 0 {
+		if err := checkCapabilities(serverURL, apiKey, lang, features...); err != nil {
+			return err
+		}
 	}
 
+	// The server's max timeout, plus its setup_timeout and cleanup_grace.
+	httpTimeout := 3 * time.Minute
 	if lang == "claude" {
-		payload["limits"] = map[string]any{
-			"memory_mb":  memoryMB,
-			"cpu_shares": 2048,
-			"pids_limit": 200,
-			"disk_mb":    500,
+		httpTimeout = 6 * time.Minute
+	}
+	if !finishBy.IsZero() {
+		httpTimeout = max(httpTimeout, time.Until(finishBy)+10*time.Second)
+	}
+
+	if detach || asyncExec {
+		var id string
+		var err error
+		if asyncExec {
+			id, err = submitAsync(payload)
+		} else {
+			id, err = submitDetached(payload, httpTimeout)
 		}
-		if projectDir != "" {
-			payload["work_dir"] = projectDir
+		if err != nil {
+			return err
 		}
+		fmt.Println(id)
+		return nil
 	}
 
 	body, _ := json.Marshal(payload)
 
-	req, err := http.NewRequest("POST", serverURL+"/execute", bytes.NewReader(body))
+	endpoint := "/execute"
+	if stream {
+		endpoint = "/execute/stream"
+	}
+	req, err := http.NewRequest("POST", serverURL+endpoint, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -189,24 +368,45 @@ func executeCode(code, lang, projectDir string) error {
 		req.Header.Set("X-API-Key", apiKey)
 	}
 
-	httpTimeout := 70 * time.Second
+	client := httpClient(httpTimeout)
+
+	// Claude runs take minutes; pick the execution ID up front so the
+	// progress endpoint can be polled while the request is outstanding.
+	stopSpinner := func() {}
 	if lang == "claude" {
-		httpTimeout = 6 * time.Minute
+		execID := uuid.New().String()
+		req.Header.Set("X-Request-ID", execID)
+		stopSpinner = startSpinner(execID)
 	}
-	client := &http.Client{Timeout: httpTimeout}
 	resp, err := client.Do(req)
+	stopSpinner()
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	var result map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("decoding response: %w", err)
+	if stream && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		exitCode, err := readStream(resp.Body, os.Stdout, os.Stderr)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+		return nil
 	}
 
-	formatted, _ := json.MarshalIndent(result, "", "  ")
-	fmt.Println(string(formatted))
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return bodyError("execution refused", resp, data)
+	}
+	result, err := printJSON(os.Stdout, data)
+	if err != nil {
+		return err
+	}
 
 	if exitCode, ok := result["exit_code"].(float64); ok && exitCode != 0 {
 		os.Exit(int(exitCode))
@@ -215,8 +415,142 @@ func executeCode(code, lang, projectDir string) error {
 	return nil
 }
 
+// printJSON pretty-prints a JSON object response and returns it decoded.
+func printJSON(w io.Writer, body []byte) (map[string]any, error) {
+	var result map[string]any
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	formatted, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Fprintln(w, string(formatted))
+	return result, nil
+}
+
+// readStream copies the output events of an /execute/stream response to
+// stdout and stderr and returns the exit code from the done event.
+func readStream(r io.Reader, stdout, stderr io.Writer) (int, error) {
+	events := sse.NewReader(r)
+	for {
+		e, err := events.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("stream ended without a result")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading stream: %w", err)
+		}
+		switch e.Type {
+		case sse.EventStdout:
+			io.WriteString(stdout, e.Data)
+		case sse.EventStderr:
+			io.WriteString(stderr, e.Data)
+		case sse.EventError:
+			var failed sse.Error
+			if e.Decode(&failed) != nil {
+				return 0, fmt.Errorf("execution failed: %s", e.Data) // servers before pkg/stream sent plain text
+			}
+			return 0, fmt.Errorf("execution failed: %w", &failed)
+		case sse.EventCancelled:
+			var cancelled sse.Cancelled
+			if err := e.Decode(&cancelled); err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("execution cancelled with cancellation group %s", cancelled.CancellationGroup)
+		case sse.EventDone:
+			var done sse.Done
+			if err := e.Decode(&done); err != nil {
+				return 0, err
+			}
+			reportEnd(stderr, done.ExitClass, done.Duration)
+			return done.ExitCode, nil
+		}
+	}
+}
+
+// reportEnd notes on stderr when a streamed execution was killed rather than
+// exiting on its own, since the output alone doesn't show it.
+func reportEnd(w io.Writer, exitClass, duration string) {
+	if exitClass != "" && exitClass != string(sandbox.ExitUser) {
+		fmt.Fprintf(w, "execution ended: %s after %s\n", exitClass, duration)
+	}
+}
+
+// startSpinner redraws a progress line on stderr until the returned function
+// is called. It does nothing when stderr is not a terminal.
+func startSpinner(execID string) func() {
+	if !isTerminal(os.Stderr) {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		frames := []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+		client := httpClient(2 * time.Second)
+		line := "starting"
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for i := 0; ; i++ {
+			if i%10 == 0 {
+				if p, ok := fetchProgress(client, execID); ok {
+					line = p
+				}
+			}
+			fmt.Fprintf(os.Stderr, "\r\033[K%c %s", frames[i%len(frames)], line)
+			select {
+			case <-stop:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// fetchProgress renders GET /executions/{id}/progress as a one-line summary.
+func fetchProgress(client *http.Client, execID string) (string, bool) {
+	req, err := http.NewRequest("GET", serverURL+"/executions/"+execID+"/progress", nil)
+	if err != nil {
+		return "", false
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", false
+	}
+
+	var p struct {
+		Turn        int    `json:"turn"`
+		LastTool    string `json:"last_tool"`
+		FilesEdited int    `json:"files_edited"`
+		Elapsed     string `json:"elapsed"`
+		Timeout     string `json:"timeout"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return "", false
+	}
+	line := fmt.Sprintf("turn %d", p.Turn)
+	if p.LastTool != "" {
+		line += " · " + p.LastTool
+	}
+	return fmt.Sprintf("%s · %d files edited · %s / %s", line, p.FilesEdited, p.Elapsed, p.Timeout), true
+}
+
 func runHealth(_ *cobra.Command, _ []string) error {
-	resp, err := http.Get(serverURL + "/health")
+	if local {
+		return fmt.Errorf("health is not available with --local: there is no server")
+	}
+	resp, err := httpClient(10 * time.Second).Get(serverURL + "/health")
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
@@ -232,17 +566,22 @@ func runHealth(_ *cobra.Command, _ []string) error {
 }
 
 func runList(_ *cobra.Command, _ []string) error {
+	if local {
+		return fmt.Errorf("list is not available with --local: local executions are not recorded")
+	}
 	req, _ := http.NewRequest("GET", serverURL+"/executions", nil)
 	if apiKey != "" {
 		req.Header.Set("X-API-Key", apiKey)
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := httpClient(10 * time.Second).Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("list failed", resp)
+	}
 
 	var result any
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -253,6 +592,53 @@ func runList(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+func runKill(_ *cobra.Command, args []string) error {
+	if local {
+		return fmt.Errorf("kill is not available with --local: local executions run in the foreground")
+	}
+	req, _ := http.NewRequest("DELETE", serverURL+"/executions/"+url.PathEscape(args[0]), nil)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	resp, err := httpClient(30 * time.Second).Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError("kill failed", resp)
+	}
+
+	var result any
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	formatted, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(formatted))
+	return nil
+}
+
+// detectLanguage maps a file extension to a registered code runtime. Claude is
+// excluded since its input is a prompt, not a program. Extensions shared by
+// several runtimes (.ts: deno, bun) require an explicit --language.
+func detectLanguage(ext string) (string, error) {
+	var candidates []string
+	for _, lang := range runtime.NewRegistry().LanguagesForExtension(ext) {
+		if lang != "claude" {
+			candidates = append(candidates, lang)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("cannot detect language for extension %q, use --language flag", ext)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("extension %q is ambiguous (%s), use --language flag", ext, strings.Join(candidates, ", "))
+	}
+}
+
 func fileExtension(path string) string {
 	for i := len(path) - 1; i >= 0; i-- {
 		if path[i] == '.' {
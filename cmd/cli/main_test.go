@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	sse "safe-agent-sandbox/pkg/stream"
+)
+
+func TestReadStream(t *testing.T) {
+	body := "event: stdout\ndata: one\ndata: two\ndata: \n\n" +
+		"event: progress\ndata: {\"turn\":1}\n\n" +
+		"event: stderr\ndata: oops\ndata: \n\n" +
+		"event: done\ndata: {\"id\":\"x\",\"exit_code\":2,\"exit_class\":\"timeout_kill\",\"duration\":\"10s\"}\n\n"
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := readStream(strings.NewReader(body), &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 2 {
+		t.Errorf("exit code = %d, want 2", exitCode)
+	}
+	if stdout.String() != "one\ntwo\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if stderr.String() != "oops\nexecution ended: timeout_kill after 10s\n" {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	_, err = readStream(strings.NewReader("event: error\ndata: {\"error\":\"execution timed out\",\"code\":\"EXECUTION_TIMEOUT\"}\n\n"), &stdout, &stderr)
+	var failed *sse.Error
+	if !errors.As(err, &failed) || failed.Code != "EXECUTION_TIMEOUT" {
+		t.Errorf("error event: err = %v", err)
+	}
+	if _, err := readStream(strings.NewReader("event: error\ndata: execution failed\n\n"), &stdout, &stderr); err == nil {
+		t.Error("plain-text error event not reported")
+	}
+	if _, err := readStream(strings.NewReader("event: stdout\ndata: partial\n"), &stdout, &stderr); err == nil {
+		t.Error("truncated stream not reported")
+	}
+}
+
+func TestCheckDeadline(t *testing.T) {
+	defer func() { deadline = "" }()
+	tests := []struct {
+		deadline string
+		timeout  bool // --timeout given too
+		wantErr  string
+	}{
+		{"", true, ""},
+		{"2026-03-01T12:00:00Z", false, ""},
+		{"2026-03-01T13:00:00+01:00", false, ""},
+		{"2026-03-01T12:00:00Z", true, "mutually exclusive"},
+		{"tomorrow", false, "invalid deadline"},
+	}
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		cmd.Flags().StringVar(&timeout, "timeout", "10s", "")
+		if tt.timeout {
+			cmd.Flags().Set("timeout", "5s")
+		}
+		deadline = tt.deadline
+		err := checkDeadline(cmd, nil)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("deadline %q, timeout set %v: err = %v, want %q", tt.deadline, tt.timeout, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// keyCommandTimeout bounds a profile's key_command, which may wait on a
+// secret manager's unlock prompt.
+const keyCommandTimeout = time.Minute
+
+var (
+	profileName string
+	caCert      string
+	// transport is what httpClient's clients use: nil for the default, or
+	// one trusting the resolved CA certificate.
+	transport http.RoundTripper
+	// profileLimits are the profile's limits for code executions. Zero
+	// fields keep the built-in ones.
+	profileLimits sandbox.ResourceLimits
+)
+
+// cliConfig is the CLI's config file, ~/.config/sandbox-cli/config.yaml.
+type cliConfig struct {
+	DefaultProfile string              `yaml:"default_profile,omitempty"`
+	Profiles       map[string]*profile `yaml:"profiles,omitempty"`
+}
+
+// profile is a named set of defaults. Every field is optional.
+type profile struct {
+	Server     string `yaml:"server,omitempty"`
+	APIKey     string `yaml:"api_key,omitempty"`
+	KeyCommand string `yaml:"key_command,omitempty"` // run with sh -c; its stdout is the key
+	CACert     string `yaml:"ca_cert,omitempty"`     // PEM file trusted on top of the system roots
+	Language   string `yaml:"language,omitempty"`
+	Timeout    string `yaml:"timeout,omitempty"`
+	Limits     struct {
+		MemoryMB  int64 `yaml:"memory_mb,omitempty"`
+		CPUShares int64 `yaml:"cpu_shares,omitempty"`
+		PidsLimit int64 `yaml:"pids_limit,omitempty"`
+		DiskMB    int64 `yaml:"disk_mb,omitempty"`
+	} `yaml:"limits,omitempty"`
+}
+
+func (p *profile) validate() error {
+	if p.APIKey != "" && p.KeyCommand != "" {
+		return fmt.Errorf("api_key and key_command are mutually exclusive")
+	}
+	if p.Timeout != "" {
+		if _, err := time.ParseDuration(p.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if p.Limits.MemoryMB < 0 || p.Limits.CPUShares < 0 || p.Limits.PidsLimit < 0 || p.Limits.DiskMB < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+// cliConfigPath is $XDG_CONFIG_HOME/sandbox-cli/config.yaml, or
+// ~/.config/sandbox-cli/config.yaml.
+func cliConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("finding the CLI config: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "sandbox-cli", "config.yaml"), nil
+}
+
+// loadCLIConfig reads the config file at path. A missing file is an empty
+// config.
+func loadCLIConfig(path string) (*cliConfig, error) {
+	cfg := &cliConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil {
+			cfg.Profiles[name] = &profile{}
+			continue
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+	}
+	if cfg.DefaultProfile != "" && cfg.Profiles[cfg.DefaultProfile] == nil {
+		return nil, fmt.Errorf("%s: default_profile %s is not defined", path, cfg.DefaultProfile)
+	}
+	return cfg, nil
+}
+
+// save writes the config to path, readable only by its owner since it may
+// hold API keys.
+func (c *cliConfig) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// settings are what a command runs with once resolved.
+type settings struct {
+	Profile  string // "" when none is in use
+	Server   string
+	APIKey   string
+	CACert   string
+	Language string
+	Timeout  string
+	MemoryMB int64
+	Limits   sandbox.ResourceLimits
+}
+
+// resolve works out cmd's settings. Each comes from, in order: its flag if
+// given, its environment variable, the selected profile, and the flag's
+// default. The profile is --profile, $SANDBOX_PROFILE or default_profile.
+//
+// The profile's language, timeout and limits are defaults for code
+// executions only: claude keeps its own, and a command whose --language
+// defaults to detecting the language keeps detecting it. The key_command
+// runs only when the key comes from the profile and a server will be
+// called.
+func resolve(ctx context.Context, cmd *cobra.Command, getenv func(string) string, cfg *cliConfig) (settings, error) {
+	flags := cmd.Flags()
+	var s settings
+
+	changed := func(name string) bool {
+		f := flags.Lookup(name)
+		return f != nil && f.Changed
+	}
+	switch {
+	case changed("profile"):
+		s.Profile, _ = flags.GetString("profile")
+	case getenv("SANDBOX_PROFILE") != "":
+		s.Profile = getenv("SANDBOX_PROFILE")
+	default:
+		s.Profile = cfg.DefaultProfile
+	}
+	p := &profile{}
+	if s.Profile != "" {
+		if p = cfg.Profiles[s.Profile]; p == nil {
+			return s, fmt.Errorf("profile %s is not defined", s.Profile)
+		}
+	}
+
+	// pick returns the flag, environment or profile value, in that order,
+	// or the flag's default.
+	pick := func(flag, env, fromProfile string) string {
+		if changed(flag) {
+			v, _ := flags.GetString(flag)
+			return v
+		}
+		if v := getenv(env); v != "" {
+			return v
+		}
+		if fromProfile != "" {
+			return fromProfile
+		}
+		v, _ := flags.GetString(flag)
+		return v
+	}
+	server, err := serverBase(pick("server", "SANDBOX_SERVER", p.Server))
+	if err != nil {
+		return s, err
+	}
+	s.Server = server
+	s.CACert = pick("ca-cert", "SANDBOX_CA_CERT", p.CACert)
+	s.APIKey = pick("api-key", "SANDBOX_API_KEY", p.APIKey)
+	if isLocal, _ := flags.GetBool("local"); s.APIKey == "" && p.KeyCommand != "" && !isLocal {
+		key, err := runKeyCommand(ctx, p.KeyCommand)
+		if err != nil {
+			return s, fmt.Errorf("profile %s: %w", s.Profile, err)
+		}
+		s.APIKey = key
+	}
+
+	code := cmd.Name() != "claude"
+	if f := flags.Lookup("language"); f != nil {
+		s.Language = f.Value.String()
+		if code && !f.Changed && f.DefValue != "" && p.Language != "" {
+			s.Language = p.Language
+		}
+	}
+	if f := flags.Lookup("timeout"); f != nil {
+		s.Timeout = f.Value.String()
+		if code && !f.Changed && p.Timeout != "" {
+			s.Timeout = p.Timeout
+		}
+	}
+	if f := flags.Lookup("memory"); f != nil {
+		s.MemoryMB, _ = flags.GetInt64("memory")
+		if code && !f.Changed && p.Limits.MemoryMB != 0 {
+			s.MemoryMB = p.Limits.MemoryMB
+		}
+	}
+	if code {
+		s.Limits = sandbox.ResourceLimits{CPUShares: p.Limits.CPUShares, PidsLimit: p.Limits.PidsLimit, DiskMB: p.Limits.DiskMB}
+	}
+	return s, nil
+}
+
+// serverBase checks the server URL and drops a trailing slash, so API paths
+// can be appended to it: http://host/sandbox/ + /execute must not become
+// http://host/sandbox//execute behind a reverse proxy.
+func serverBase(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("server %q is not an http or https URL", server)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("server %q: a server URL has no query or fragment", server)
+	}
+	return strings.TrimRight(server, "/"), nil
+}
+
+// runKeyCommand runs command with sh -c and returns its trimmed stdout.
+// stderr and stdin stay connected so a secret manager can prompt. The key
+// is only ever held in memory.
+func runKeyCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, keyCommandTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	c := exec.CommandContext(ctx, "sh", "-c", command)
+	c.Stdout = &stdout
+	c.Stderr = os.Stderr
+	c.Stdin = os.Stdin
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("key_command failed: %w", err)
+	}
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", fmt.Errorf("key_command printed no key")
+	}
+	return key, nil
+}
+
+// applySettings resolves the settings for cmd and sets the globals the
+// commands read. It runs before every command but those under config.
+func applySettings(cmd *cobra.Command, _ []string) error {
+	path, err := cliConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadCLIConfig(path)
+	if err != nil {
+		return err
+	}
+	s, err := resolve(cmd.Context(), cmd, os.Getenv, cfg)
+	if err != nil {
+		return err
+	}
+
+	serverURL, apiKey, caCert = s.Server, s.APIKey, s.CACert
+	if cmd.Flags().Lookup("language") != nil {
+		language = s.Language
+	}
+	if cmd.Flags().Lookup("timeout") != nil {
+		timeout = s.Timeout
+	}
+	if cmd.Flags().Lookup("memory") != nil {
+		memoryMB = s.MemoryMB
+	}
+	profileLimits = s.Limits
+
+	transport = nil
+	if caCert != "" {
+		t, err := caTransport(caCert)
+		if err != nil {
+			return err
+		}
+		transport = t
+	}
+	return nil
+}
+
+// caTransport returns a transport trusting the PEM certificates in path as
+// well as the system roots.
+func caTransport(path string) (*http.Transport, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", path)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return t, nil
+}
+
+// httpClient returns a client for calls to the server.
+func httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// applyProfileLimits overrides limits with the profile's non-zero ones.
+func applyProfileLimits(limits sandbox.ResourceLimits) sandbox.ResourceLimits {
+	if profileLimits.CPUShares != 0 {
+		limits.CPUShares = profileLimits.CPUShares
+	}
+	if profileLimits.PidsLimit != 0 {
+		limits.PidsLimit = profileLimits.PidsLimit
+	}
+	if profileLimits.DiskMB != 0 {
+		limits.DiskMB = profileLimits.DiskMB
+	}
+	return limits
+}
+
+// maskKey hides all but the last four characters of an API key, or all of
+// a short one.
+func maskKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) < 12 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// profileFields are the keys `config set` takes.
+var profileFields = []string{"server", "api_key", "key_command", "ca_cert", "language", "timeout",
+	"limits.memory_mb", "limits.cpu_shares", "limits.pids_limit", "limits.disk_mb"}
+
+// set sets one of p's fields from its profileFields name. An empty value
+// clears it.
+func (p *profile) set(field, value string) error {
+	limit := func(dst *int64) error {
+		if value == "" {
+			*dst = 0
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number", field)
+		}
+		*dst = n
+		return nil
+	}
+	var err error
+	switch field {
+	case "server":
+		p.Server = value
+	case "api_key":
+		p.APIKey = value
+	case "key_command":
+		p.KeyCommand = value
+	case "ca_cert":
+		if value != "" {
+			if value, err = filepath.Abs(value); err != nil {
+				return err
+			}
+		}
+		p.CACert = value
+	case "language":
+		p.Language = value
+	case "timeout":
+		p.Timeout = value
+	case "limits.memory_mb":
+		err = limit(&p.Limits.MemoryMB)
+	case "limits.cpu_shares":
+		err = limit(&p.Limits.CPUShares)
+	case "limits.pids_limit":
+		err = limit(&p.Limits.PidsLimit)
+	case "limits.disk_mb":
+		err = limit(&p.Limits.DiskMB)
+	default:
+		return fmt.Errorf("unknown field %s (one of %s)", field, strings.Join(profileFields, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	return p.validate()
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the CLI's profiles in ~/.config/sandbox-cli/config.yaml",
+		// Managing profiles needs none resolved, nor their key commands run.
+		PersistentPreRunE: func(*cobra.Command, []string) error { return nil },
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, _, err := loadConfigFile()
+			if err != nil {
+				return err
+			}
+			writeProfiles(cmd.OutOrStdout(), cfg)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "view [profile]",
+		Short: "Show a profile, or the whole config, with keys masked",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _, err := loadConfigFile()
+			if err != nil {
+				return err
+			}
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return viewConfig(cmd.OutOrStdout(), cfg, name)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <profile> <field> <value>",
+		Short: "Set a field of a profile, creating it; an empty value clears the field",
+		Long:  "Set a field of a profile, creating it; an empty value clears the field.\n\nFields: " + strings.Join(profileFields, ", "),
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, path, err := loadConfigFile()
+			if err != nil {
+				return err
+			}
+			p := cfg.Profiles[args[0]]
+			if p == nil {
+				p = &profile{}
+			}
+			if err := p.set(args[1], args[2]); err != nil {
+				return err
+			}
+			if cfg.Profiles == nil {
+				cfg.Profiles = make(map[string]*profile)
+			}
+			cfg.Profiles[args[0]] = p
+			return cfg.save(path)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "use <profile>",
+		Short: "Make a profile the default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, path, err := loadConfigFile()
+			if err != nil {
+				return err
+			}
+			if cfg.Profiles[args[0]] == nil {
+				return fmt.Errorf("profile %s is not defined", args[0])
+			}
+			cfg.DefaultProfile = args[0]
+			return cfg.save(path)
+		},
+	})
+	return cmd
+}
+
+func loadConfigFile() (*cliConfig, string, error) {
+	path, err := cliConfigPath()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, err := loadCLIConfig(path)
+	return cfg, path, err
+}
+
+// writeProfiles lists the profiles, the default marked with *.
+func writeProfiles(w io.Writer, cfg *cliConfig) {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tSERVER\tKEY")
+	for _, name := range names {
+		p := cfg.Profiles[name]
+		mark := ""
+		if name == cfg.DefaultProfile {
+			mark = "*"
+		}
+		key := maskKey(p.APIKey)
+		if p.KeyCommand != "" {
+			key = "(key_command)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, name, p.Server, key)
+	}
+	tw.Flush()
+}
+
+// viewConfig writes the named profile, or the whole config when name is
+// empty, as YAML with API keys masked.
+func viewConfig(w io.Writer, cfg *cliConfig, name string) error {
+	masked := func(p *profile) *profile {
+		c := *p
+		c.APIKey = maskKey(c.APIKey)
+		return &c
+	}
+	var out any
+	if name != "" {
+		p := cfg.Profiles[name]
+		if p == nil {
+			return fmt.Errorf("profile %s is not defined", name)
+		}
+		out = masked(p)
+	} else {
+		view := &cliConfig{DefaultProfile: cfg.DefaultProfile, Profiles: make(map[string]*profile, len(cfg.Profiles))}
+		for n, p := range cfg.Profiles {
+			view.Profiles[n] = masked(p)
+		}
+		out = view
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const testConfig = `default_profile: local
+profiles:
+  local:
+    server: http://localhost:8080
+  staging:
+    server: https://sandbox.staging.internal
+    api_key: sk-staging-0123456789abcd
+    language: node
+    timeout: 30s
+    limits:
+      memory_mb: 512
+      pids_limit: 100
+  prod:
+    server: https://sandbox.internal
+    key_command: printf 'sk-prod-from-vault\n'
+`
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCLIConfig(t *testing.T) {
+	cfg, err := loadCLIConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging := cfg.Profiles["staging"]
+	if cfg.DefaultProfile != "local" || len(cfg.Profiles) != 3 || staging.Timeout != "30s" || staging.Limits.MemoryMB != 512 {
+		t.Errorf("parsed %+v, staging %+v", cfg, staging)
+	}
+
+	if cfg, err := loadCLIConfig(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || len(cfg.Profiles) != 0 {
+		t.Errorf("missing file: %+v, %v", cfg, err)
+	}
+	for data, want := range map[string]string{
+		"profiles:\n  a:\n    sever: x\n":                            "sever",
+		"profiles:\n  a:\n    api_key: k\n    key_command: echo k\n": "mutually exclusive",
+		"profiles:\n  a:\n    timeout: soon\n":                       "invalid timeout",
+		"profiles:\n  a:\n    limits:\n      memory_mb: -1\n":        "negative",
+		"default_profile: b\nprofiles:\n  a: {}\n":                   "default_profile b",
+	} {
+		if _, err := loadCLIConfig(writeConfig(t, data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want %q", data, err, want)
+		}
+	}
+}
+
+// testCmd is a command with exec's flags, named name, parsed from args.
+func testCmd(t *testing.T, name string, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{Use: name}
+	f := cmd.Flags()
+	f.String("server", "http://localhost:8080", "")
+	f.String("api-key", "", "")
+	f.String("ca-cert", "", "")
+	f.String("profile", "", "")
+	f.Bool("local", false, "")
+	f.String("timeout", "10s", "")
+	f.Int64("memory", 256, "")
+	if name != "claude" {
+		f.StringP("language", "l", "python", "")
+	}
+	if err := f.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestResolve_Precedence(t *testing.T) {
+	cfg, err := loadCLIConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		cmd     *cobra.Command
+		profile string
+		server  string
+		key     string
+		lang    string
+		timeout string
+		memory  int64
+	}{
+		{"built-in defaults", nil, testCmd(t, "exec"), "local", "http://localhost:8080", "", "python", "10s", 256},
+		{"env selects profile", map[string]string{"SANDBOX_PROFILE": "staging"}, testCmd(t, "exec"),
+			"staging", "https://sandbox.staging.internal", "sk-staging-0123456789abcd", "node", "30s", 512},
+		{"flag beats env profile", map[string]string{"SANDBOX_PROFILE": "local"}, testCmd(t, "exec", "--profile", "staging"),
+			"staging", "https://sandbox.staging.internal", "sk-staging-0123456789abcd", "node", "30s", 512},
+		{"env beats profile", map[string]string{"SANDBOX_SERVER": "https://env", "SANDBOX_API_KEY": "sk-env"},
+			testCmd(t, "exec", "--profile", "staging"), "staging", "https://env", "sk-env", "node", "30s", 512},
+		{"flags beat all", map[string]string{"SANDBOX_SERVER": "https://env", "SANDBOX_API_KEY": "sk-env"},
+			testCmd(t, "exec", "--profile", "staging", "--server", "https://flag", "--api-key", "sk-flag", "-l", "bash", "--timeout", "5s", "--memory", "64"),
+			"staging", "https://flag", "sk-flag", "bash", "5s", 64},
+		{"claude keeps its defaults", nil, testCmd(t, "claude", "--profile", "staging"),
+			"staging", "https://sandbox.staging.internal", "sk-staging-0123456789abcd", "", "10s", 256},
+		{"key_command", nil, testCmd(t, "exec", "--profile", "prod"), "prod", "https://sandbox.internal", "sk-prod-from-vault", "python", "10s", 256},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env = tc.env
+			s, err := resolve(context.Background(), tc.cmd, getenv, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Profile != tc.profile || s.Server != tc.server || s.APIKey != tc.key || s.Language != tc.lang || s.Timeout != tc.timeout || s.MemoryMB != tc.memory {
+				t.Errorf("got %+v", s)
+			}
+		})
+	}
+
+	env = nil
+	if s, _ := resolve(context.Background(), testCmd(t, "exec", "--profile", "staging"), getenv, cfg); s.Limits.PidsLimit != 100 || s.Limits.CPUShares != 0 {
+		t.Errorf("staging limits = %+v", s.Limits)
+	}
+	if _, err := resolve(context.Background(), testCmd(t, "exec", "--profile", "qa"), getenv, cfg); err == nil || !strings.Contains(err.Error(), "qa") {
+		t.Errorf("undefined profile: err = %v", err)
+	}
+	// exec-file detects the language rather than taking the profile's.
+	cmd := &cobra.Command{Use: "exec-file"}
+	cmd.Flags().String("language", "", "")
+	if s, _ := resolve(context.Background(), cmd, getenv, &cliConfig{DefaultProfile: "p", Profiles: map[string]*profile{"p": {Language: "node"}}}); s.Language != "" {
+		t.Errorf("exec-file language = %q, want none", s.Language)
+	}
+}
+
+func TestRunKeyCommand(t *testing.T) {
+	dir := t.TempDir()
+	fake := filepath.Join(dir, "vault")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\n[ \"$1\" = read ] || exit 2\necho \"  sk-$2-secret\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	key, err := runKeyCommand(context.Background(), fake+" read prod")
+	if err != nil || key != "sk-prod-secret" {
+		t.Errorf("key = %q, %v", key, err)
+	}
+	if _, err := runKeyCommand(context.Background(), fake+" write prod"); err == nil {
+		t.Error("failing key_command not reported")
+	}
+	if _, err := runKeyCommand(context.Background(), "true"); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("empty output: err = %v", err)
+	}
+
+	// The command isn't run when the key comes from elsewhere or no server
+	// is called.
+	marker := filepath.Join(dir, "ran")
+	cfg := &cliConfig{Profiles: map[string]*profile{"p": {KeyCommand: "touch " + marker + "; echo k"}}}
+	for _, args := range [][]string{{"--profile", "p", "--api-key", "sk-flag"}, {"--profile", "p", "--local"}} {
+		if _, err := resolve(context.Background(), testCmd(t, "exec", args...), func(string) string { return "" }, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("%v: key_command ran", args)
+		}
+	}
+}
+
+func TestServerBase(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://localhost:8080":             "http://localhost:8080",
+		"https://ingress.example/sandbox/":  "https://ingress.example/sandbox",
+		"https://ingress.example/sandbox//": "https://ingress.example/sandbox",
+		"https://ingress.example/a/sandbox": "https://ingress.example/a/sandbox",
+	} {
+		if got, err := serverBase(in); err != nil || got != want {
+			t.Errorf("serverBase(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"localhost:8080", "ftp://host", "http://", "http://host/?x=1", "http://host/#top"} {
+		if _, err := serverBase(in); err == nil {
+			t.Errorf("serverBase(%q) accepted", in)
+		}
+	}
+}
+
+func TestConfigView_MasksKeys(t *testing.T) {
+	cfg, err := loadCLIConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "staging"} {
+		var out bytes.Buffer
+		if err := viewConfig(&out, cfg, name); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out.String(), "sk-staging") || !strings.Contains(out.String(), "api_key: '****abcd'") {
+			t.Errorf("view %q:\n%s", name, out.String())
+		}
+	}
+	if cfg.Profiles["staging"].APIKey != "sk-staging-0123456789abcd" {
+		t.Error("view changed the loaded profile")
+	}
+
+	var out bytes.Buffer
+	writeProfiles(&out, cfg)
+	for _, want := range []string{"*  local", "****abcd", "(key_command)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("list missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "sk-staging") {
+		t.Errorf("list shows the key:\n%s", out.String())
+	}
+	if maskKey("short") != "****" {
+		t.Errorf("short key masked as %q", maskKey("short"))
+	}
+}
+
+func TestProfileSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sandbox-cli", "config.yaml")
+	cfg := &cliConfig{Profiles: map[string]*profile{"p": {}}}
+	p := cfg.Profiles["p"]
+	for _, kv := range [][2]string{{"server", "https://x"}, {"api_key", "sk-1"}, {"limits.disk_mb", "200"}, {"timeout", "1m"}} {
+		if err := p.set(kv[0], kv[1]); err != nil {
+			t.Fatalf("set %s: %v", kv[0], err)
+		}
+	}
+	for _, kv := range [][2]string{{"key_command", "echo k"}, {"timeout", "later"}, {"limits.memory_mb", "lots"}, {"color", "red"}} {
+		q := *p
+		if err := q.set(kv[0], kv[1]); err == nil {
+			t.Errorf("set %s %q accepted", kv[0], kv[1])
+		}
+	}
+	if err := cfg.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("saved config: %v, %v", info, err)
+	}
+	got, err := loadCLIConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := got.Profiles["p"]; q.Server != "https://x" || q.APIKey != "sk-1" || q.Limits.DiskMB != 200 || q.Timeout != "1m" {
+		t.Errorf("reloaded %+v", q)
+	}
+
+	// Clearing the key makes room for a key_command.
+	if err := p.set("api_key", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.set("key_command", "echo k"); err != nil {
+		t.Errorf("key_command after clearing api_key: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"safe-agent-sandbox/internal/storage"
+)
+
+var (
+	reportFrom    string
+	reportTo      string
+	reportGroupBy string
+)
+
+func newReportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Show aggregated usage by day, language or API key",
+		Args:  cobra.NoArgs,
+		RunE:  runReport,
+	}
+	cmd.Flags().StringVar(&reportFrom, "from", "", "Start, as YYYY-MM-DD (UTC) or RFC 3339 (default: a week before --to)")
+	cmd.Flags().StringVar(&reportTo, "to", "", "End, as YYYY-MM-DD (inclusive, UTC) or RFC 3339 (default: now)")
+	cmd.Flags().StringVar(&reportGroupBy, "group-by", "day", "Group rows by day, language or api_key")
+	return cmd
+}
+
+func runReport(_ *cobra.Command, _ []string) error {
+	if local {
+		return fmt.Errorf("report is not available with --local: local executions are not recorded")
+	}
+	params := url.Values{"group_by": {reportGroupBy}}
+	if reportFrom != "" {
+		params.Set("from", reportFrom)
+	}
+	if reportTo != "" {
+		params.Set("to", reportTo)
+	}
+	req, _ := http.NewRequest("GET", serverURL+"/reports/usage?"+params.Encode(), nil)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	client := httpClient(30 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("report failed", resp)
+	}
+
+	var report storage.UsageReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	writeReport(os.Stdout, &report)
+	return nil
+}
+
+// writeReport renders a usage report as a table with a totals line.
+func writeReport(w io.Writer, report *storage.UsageReport) {
+	fmt.Fprintf(w, "usage from %s to %s by %s\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339), report.GroupBy)
+	if report.Partial {
+		fmt.Fprintln(w, "partial: the server has no database, so only executions since it started are counted")
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\texecutions\tsuccess\tp95 ms\tclaude min\tsecurity events\t\n", report.GroupBy)
+	row := func(key string, r storage.UsageRow) {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%.0f\t%.1f\t%d\t\n",
+			key, r.Executions, 100*r.SuccessRate, r.P95DurationMS, r.ClaudeMinutes, r.SecurityEvents)
+	}
+	for _, r := range report.Rows {
+		key := r.Key
+		if key == "" {
+			key = "-"
+		} else if report.GroupBy == storage.GroupByAPIKey && len(key) > 12 {
+			key = key[:12] // a hash prefix is enough to tell keys apart
+		}
+		row(key, r)
+	}
+	row("total", report.Totals)
+	tw.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/storage"
+)
+
+func TestWriteReport(t *testing.T) {
+	report := &storage.UsageReport{
+		From:    time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		GroupBy: storage.GroupByAPIKey,
+		Rows: []storage.UsageRow{
+			{Key: "", Executions: 1, Succeeded: 1, SuccessRate: 1, P95DurationMS: 120},
+			{Key: strings.Repeat("ab", 32), Executions: 3, Succeeded: 2, SuccessRate: 2.0 / 3, P95DurationMS: 9020, ClaudeMinutes: 1.5, SecurityEvents: 2},
+		},
+		Totals:  storage.UsageRow{Executions: 4, Succeeded: 3, SuccessRate: 0.75, P95DurationMS: 8500, ClaudeMinutes: 1.5, SecurityEvents: 2},
+		Partial: true,
+	}
+
+	var buf bytes.Buffer
+	writeReport(&buf, report)
+	out := buf.String()
+
+	// Compare columns, not the padding tabwriter chose.
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	normalized := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"usage from 2026-03-01T00:00:00Z to 2026-03-03T00:00:00Z by api_key",
+		"partial:",
+		"- 1 100.0% 120 0.0 0",
+		"abababababab 3 66.7% 9020 1.5 2",
+		"total 4 75.0% 8500 1.5 2",
+	} {
+		if !strings.Contains(normalized, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, strings.Repeat("ab", 7)) {
+		t.Errorf("API key hash not shortened:\n%s", out)
+	}
+}
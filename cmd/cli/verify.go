@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"safe-agent-sandbox/pkg/conformance"
+)
+
+var (
+	verifyFeatures  []string
+	verifyScenarios string
+	verifyTimeout   string
+)
+
+func newVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Run the isolation conformance scenarios against the server",
+		Long: "Runs each conformance scenario through POST /execute and checks escape attempts are blocked\n" +
+			"and benign code still runs. Exits non-zero if any scenario fails or can't be run.",
+		Args: cobra.NoArgs,
+		RunE: runVerify,
+	}
+	cmd.Flags().StringSliceVar(&verifyFeatures, "feature", nil, "Feature the deployment has, enabling the scenarios that need it (network)")
+	cmd.Flags().StringVar(&verifyScenarios, "scenarios", "", "JSON file of extra scenarios to run after the built-in ones")
+	cmd.Flags().StringVar(&verifyTimeout, "timeout", conformance.DefaultTimeout.String(), "Execution timeout per scenario")
+	return cmd
+}
+
+func runVerify(cmd *cobra.Command, _ []string) error {
+	if local {
+		return fmt.Errorf("verify is not available with --local: it checks a server")
+	}
+	d, err := time.ParseDuration(verifyTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	scenarios := conformance.Catalog()
+	if verifyScenarios != "" {
+		f, err := os.Open(verifyScenarios)
+		if err != nil {
+			return fmt.Errorf("reading scenarios: %w", err)
+		}
+		extra, err := conformance.LoadScenarios(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		scenarios = append(scenarios, extra...)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	exec := &conformance.HTTPExecutor{URL: serverURL, APIKey: apiKey, Timeout: d, Client: httpClient(d + 30*time.Second)}
+	return verify(ctx, cmd.OutOrStdout(), exec, scenarios, conformance.Options{Features: verifyFeatures})
+}
+
+// verify runs the scenarios and writes the report, failing on any scenario
+// that didn't pass or skip.
+func verify(ctx context.Context, w io.Writer, exec conformance.Executor, scenarios []conformance.Scenario, opts conformance.Options) error {
+	report := conformance.Run(ctx, exec, scenarios, opts)
+	report.WriteText(w)
+	if !report.OK() {
+		return fmt.Errorf("conformance failed: %d scenarios failed, %d could not be run",
+			report.Count(conformance.StatusFail), report.Count(conformance.StatusError))
+	}
+	return nil
+}
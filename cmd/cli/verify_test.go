@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/pkg/conformance"
+)
+
+// conformingServer answers /execute as an isolating sandbox would: benign
+// code prints what it's asked to, attempts print nothing. escapes names the
+// scenario code that gets through anyway.
+func conformingServer(t *testing.T, escapes string) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Language string `json:"language"`
+			Code     string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		out := "blocked: Operation not permitted\n"
+		switch {
+		case req.Language == "node":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"execution x: validate: unsupported language: node","code":"VALIDATION_ERROR"}`))
+			return
+		case strings.Contains(req.Code, "conformance ok"):
+			out = "conformance ok\n"
+		case strings.Contains(req.Code, "tmpfs works"):
+			out = "tmpfs works\n"
+		case escapes != "" && strings.Contains(req.Code, escapes):
+			out = "ESCAPE: connected to 1.1.1.1:53\n"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"output": out, "exit_code": 0, "exit_class": "user_exit"})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestVerify(t *testing.T) {
+	ts := conformingServer(t, "")
+	var out bytes.Buffer
+	if err := verify(context.Background(), &out, &conformance.HTTPExecutor{URL: ts.URL}, conformance.Catalog(), conformance.Options{}); err != nil {
+		t.Fatalf("verify: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{
+		"PASS  critical  read_etc_shadow",
+		"SKIP  high      node_runs",
+		"SKIP  medium    network_when_enabled  needs network",
+		"passed, 0 failed,",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestVerify_Regression(t *testing.T) {
+	ts := conformingServer(t, `("1.1.1.1", 53), timeout=3`)
+	var out bytes.Buffer
+	err := verify(context.Background(), &out, &conformance.HTTPExecutor{URL: ts.URL}, conformance.Catalog(), conformance.Options{})
+	if err == nil || !strings.Contains(err.Error(), "1 scenarios failed") {
+		t.Fatalf("err = %v, want one failure\n%s", err, out.String())
+	}
+	for _, want := range []string{
+		"FAIL  critical  network_egress",
+		"output: ESCAPE: connected to 1.1.1.1:53",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"safe-agent-sandbox/internal/api"
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// Outcome codes for failures that aren't API errors. API errors are reported
+// by their code (RATE_LIMITED, EXECUTION_TIMEOUT, ...).
+const (
+	codeTransport        = "TRANSPORT_ERROR"   // the request didn't complete
+	codeBadResponse      = "BAD_RESPONSE"      // the response couldn't be decoded
+	codeStreamFailed     = "STREAM_FAILED"     // the stream ended with an error event or no result
+	codeUnexpectedExit   = "UNEXPECTED_EXIT"   // exit code differs from the corpus entry's
+	codeUnexpectedOutput = "UNEXPECTED_OUTPUT" // output misses an expected substring
+)
+
+// client drives the sandbox HTTP API.
+type client struct {
+	server  string
+	apiKey  string
+	timeout string // execution timeout sent with each request
+	http    *http.Client
+}
+
+// execute runs one corpus entry and validates the result against it.
+func (c *client) execute(ctx context.Context, e entry, stream bool) outcome {
+	o := outcome{Language: e.Language, Stream: stream}
+	start := time.Now()
+	exitCode, output, code := c.do(ctx, e, stream)
+	o.Latency = time.Since(start)
+
+	switch {
+	case code != "":
+		o.Code = code
+	case exitCode != e.ExitCode:
+		o.Code = codeUnexpectedExit
+	default:
+		for _, want := range e.OutputContains {
+			if !strings.Contains(output, want) {
+				o.Code = codeUnexpectedOutput
+				break
+			}
+		}
+	}
+	return o
+}
+
+// do returns the exit code and stdout of the execution, or an outcome code.
+func (c *client) do(ctx context.Context, e entry, stream bool) (int, string, string) {
+	body, _ := json.Marshal(map[string]any{
+		"language": e.Language,
+		"code":     e.Code,
+		"timeout":  c.timeout,
+	})
+	endpoint := "/execute"
+	if stream {
+		endpoint = "/execute/stream"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.server+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", codeTransport
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, "", codeTransport
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", errorCode(resp)
+	}
+	if stream {
+		return readStream(resp.Body)
+	}
+
+	var result api.ExecutionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, "", codeBadResponse
+	}
+	return result.ExitCode, result.Output, ""
+}
+
+// errorCode names a non-200 response by its API error code, falling back to
+// the HTTP status for responses that aren't from the API (a proxy, say).
+func errorCode(resp *http.Response) string {
+	var apiErr apierror.Response
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err == nil && apiErr.Code != "" {
+		return string(apiErr.Code)
+	}
+	return fmt.Sprintf("HTTP_%d", resp.StatusCode)
+}
+
+// readStream collects stdout from an /execute/stream response until the done
+// event.
+func readStream(r io.Reader) (int, string, string) {
+	events := stream.NewReader(r)
+	var stdout strings.Builder
+	for {
+		e, err := events.Next()
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return 0, "", codeStreamFailed
+		case errors.Is(err, stream.ErrEventTooLarge):
+			return 0, "", codeBadResponse
+		case err != nil:
+			return 0, "", codeTransport
+		}
+		switch e.Type {
+		case stream.EventStdout:
+			stdout.WriteString(e.Data)
+		case stream.EventError:
+			return 0, "", codeStreamFailed
+		case stream.EventDone:
+			var done stream.Done
+			if err := e.Decode(&done); err != nil {
+				return 0, "", codeBadResponse
+			}
+			return done.ExitCode, stdout.String(), ""
+		}
+	}
+}
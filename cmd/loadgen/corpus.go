@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// entry is one program in the corpus and what a correct run of it returns.
+type entry struct {
+	Name           string
+	Language       string
+	Code           string
+	ExitCode       int
+	OutputContains []string
+}
+
+// expectation is the optional sidecar next to a corpus file: hello.py.json
+// describes hello.py. Without one the language comes from the extension and
+// the run must exit 0.
+type expectation struct {
+	Language       string   `json:"language"`
+	ExitCode       int      `json:"exit_code"`
+	OutputContains []string `json:"output_contains"`
+}
+
+// defaultCorpus is used without --corpus: one quick program per common
+// language, each with a known output.
+func defaultCorpus() []entry {
+	return []entry{
+		{Name: "sum.py", Language: "python", Code: "print(sum(range(101)))", OutputContains: []string{"5050"}},
+		{Name: "answer.js", Language: "node", Code: "console.log(6 * 7)", OutputContains: []string{"42"}},
+		{Name: "echo.sh", Language: "bash", Code: `echo "hello from bash"`, OutputContains: []string{"hello from bash"}},
+	}
+}
+
+// loadCorpus reads every program in dir, skipping sidecars and hidden files.
+func loadCorpus(dir string, runtimes *runtime.Registry) ([]entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading corpus: %w", err)
+	}
+	var entries []entry
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".json") {
+			continue
+		}
+		code, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("reading corpus: %w", err)
+		}
+		e := entry{Name: name, Code: string(code)}
+
+		var exp expectation
+		sidecar, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(sidecar, &exp); err != nil {
+				return nil, fmt.Errorf("corpus %s.json: %w", name, err)
+			}
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("reading corpus: %w", err)
+		}
+		e.ExitCode, e.OutputContains = exp.ExitCode, exp.OutputContains
+
+		e.Language = exp.Language
+		if e.Language == "" {
+			langs := runtimes.LanguagesForExtension(filepath.Ext(name))
+			if len(langs) != 1 {
+				return nil, fmt.Errorf("corpus %s: can't tell the language from the extension, set it in %s.json", name, name)
+			}
+			e.Language = langs[0]
+		}
+		if _, err := runtimes.Get(e.Language); err != nil {
+			return nil, fmt.Errorf("corpus %s: %w", name, err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("corpus %s has no programs", dir)
+	}
+	return entries, nil
+}
+
+// mix picks corpus entries so that languages appear in the configured ratio.
+type mix struct {
+	languages []string
+	weights   []float64 // cumulative, ending at 1
+	entries   map[string][]entry
+}
+
+// newMix builds a mix from "python=6,node=3,bash=1". An empty spec weights
+// every corpus language equally.
+func newMix(spec string, corpus []entry) (*mix, error) {
+	m := &mix{entries: make(map[string][]entry)}
+	for _, e := range corpus {
+		m.entries[e.Language] = append(m.entries[e.Language], e)
+	}
+
+	ratios := make(map[string]float64)
+	if spec == "" {
+		for lang := range m.entries {
+			ratios[lang] = 1
+		}
+	}
+	for _, part := range strings.Split(spec, ",") {
+		if part == "" {
+			continue
+		}
+		lang, w, ok := strings.Cut(part, "=")
+		weight, err := strconv.ParseFloat(w, 64)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid mix %q: want language=weight", part)
+		}
+		if len(m.entries[lang]) == 0 {
+			return nil, fmt.Errorf("mix includes %s but the corpus has no %s programs", lang, lang)
+		}
+		ratios[lang] = weight
+	}
+
+	var total float64
+	for lang, w := range ratios {
+		if w > 0 {
+			m.languages = append(m.languages, lang)
+			total += w
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix %q has no positive weights", spec)
+	}
+	sort.Strings(m.languages)
+	var cum float64
+	for _, lang := range m.languages {
+		cum += ratios[lang] / total
+		m.weights = append(m.weights, cum)
+	}
+	m.weights[len(m.weights)-1] = 1
+	return m, nil
+}
+
+// pick returns a random entry: r chooses the language, a second draw the
+// program within it.
+func (m *mix) pick(r float64) entry {
+	i := sort.SearchFloat64s(m.weights, r)
+	i = min(i, len(m.languages)-1)
+	programs := m.entries[m.languages[i]]
+	return programs[rand.IntN(len(programs))]
+}
@@ -0,0 +1,153 @@
+// Command loadgen drives a sandbox server with a synthetic workload and
+// reports latency, errors and throughput, failing when SLO thresholds are
+// breached. It is meant for pre-release soak runs and CI gates.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// Exit codes.
+const (
+	exitSLOBreached = 1
+	exitUsage       = 2
+)
+
+type options struct {
+	server         string
+	apiKey         string
+	corpusDir      string
+	mix            string
+	rate           float64
+	concurrency    int
+	duration       time.Duration
+	ramp           string
+	streamFraction float64
+	timeout        string
+	sloP95         time.Duration
+	sloErrorRate   float64
+	jsonPath       string
+}
+
+func main() {
+	var opts options
+	root := &cobra.Command{
+		Use:   "loadgen",
+		Short: "Synthetic load generator for safe-agent-sandbox",
+		Long: `Sends a configurable mix of executions to a sandbox server and reports
+latency percentiles, errors by code and throughput. Exits 1 when an SLO
+threshold is breached, 2 on usage errors.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			breached, err := run(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+			if breached {
+				os.Exit(exitSLOBreached)
+			}
+			return nil
+		},
+	}
+
+	f := root.Flags()
+	f.StringVar(&opts.server, "server", "http://localhost:8080", "Server URL")
+	f.StringVar(&opts.apiKey, "api-key", os.Getenv("SANDBOX_API_KEY"), "API key")
+	f.StringVar(&opts.corpusDir, "corpus", "", "Directory of programs to run (default: a built-in python/node/bash corpus)")
+	f.StringVar(&opts.mix, "mix", "", "Language ratios, e.g. python=6,node=3,bash=1 (default: equal)")
+	f.Float64Var(&opts.rate, "rate", 0, "Open loop: requests per second at peak")
+	f.IntVar(&opts.concurrency, "concurrency", 0, "Closed loop: concurrent workers at peak (default 10 when --rate is unset)")
+	f.DurationVar(&opts.duration, "duration", time.Minute, "How long to send requests")
+	f.StringVar(&opts.ramp, "ramp", "none", "Ramp-up profile: none, linear:<duration> or step:<n>x<duration>")
+	f.Float64Var(&opts.streamFraction, "stream-fraction", 0, "Fraction of requests sent to /execute/stream (0-1)")
+	f.StringVar(&opts.timeout, "timeout", "10s", "Execution timeout sent with each request")
+	f.DurationVar(&opts.sloP95, "slo-p95", 0, "Fail if p95 latency exceeds this (0 disables)")
+	f.Float64Var(&opts.sloErrorRate, "slo-max-error-rate", noErrorRateSLO, "Fail if the error rate (0-1) exceeds this (negative disables)")
+	f.StringVar(&opts.jsonPath, "json", "", "Also write the report as JSON to this file")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := root.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+}
+
+// run executes the load described by opts, prints the report and returns
+// whether an SLO was breached.
+func run(ctx context.Context, opts options) (bool, error) {
+	if opts.rate > 0 && opts.concurrency > 0 {
+		return false, fmt.Errorf("--rate and --concurrency are mutually exclusive")
+	}
+	if opts.rate < 0 || opts.concurrency < 0 || opts.duration <= 0 {
+		return false, fmt.Errorf("--rate, --concurrency and --duration must be positive")
+	}
+	if opts.rate == 0 && opts.concurrency == 0 {
+		opts.concurrency = 10
+	}
+	if opts.streamFraction < 0 || opts.streamFraction > 1 {
+		return false, fmt.Errorf("--stream-fraction must be between 0 and 1")
+	}
+	execTimeout, err := time.ParseDuration(opts.timeout)
+	if err != nil {
+		return false, fmt.Errorf("invalid --timeout: %w", err)
+	}
+	r, err := parseRamp(opts.ramp)
+	if err != nil {
+		return false, err
+	}
+
+	corpus := defaultCorpus()
+	if opts.corpusDir != "" {
+		if corpus, err = loadCorpus(opts.corpusDir, runtime.NewRegistry()); err != nil {
+			return false, err
+		}
+	}
+	m, err := newMix(opts.mix, corpus)
+	if err != nil {
+		return false, err
+	}
+
+	l := &load{
+		client: &client{
+			server:  strings.TrimRight(opts.server, "/"), // a URL with a path, e.g. behind a reverse proxy
+			apiKey:  opts.apiKey,
+			timeout: opts.timeout,
+			// Leave room past the execution timeout for queueing and startup.
+			http: &http.Client{Timeout: execTimeout + 30*time.Second},
+		},
+		mix:            m,
+		streamFraction: opts.streamFraction,
+		ramp:           r,
+		duration:       opts.duration,
+	}
+
+	start := time.Now()
+	if opts.rate > 0 {
+		l.openLoop(ctx, opts.rate)
+	} else {
+		l.closedLoop(ctx, opts.concurrency)
+	}
+	sum := summarize(l.outcomes, time.Since(start))
+	sum.SLO = slo{P95: opts.sloP95, MaxErrorRate: opts.sloErrorRate}.evaluate(sum)
+
+	writeTable(os.Stdout, sum)
+	if opts.jsonPath != "" {
+		data, _ := json.MarshalIndent(sum, "", "  ")
+		if err := os.WriteFile(opts.jsonPath, append(data, '\n'), 0o644); err != nil {
+			return false, fmt.Errorf("writing JSON report: %w", err)
+		}
+	}
+	return sum.SLO != nil && !sum.SLO.Passed, nil
+}
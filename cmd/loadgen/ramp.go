@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ramp shapes load over a run: it scales the target request rate (open loop)
+// or worker count (closed loop) up to the configured peak over a ramp-up
+// period, then holds the peak for the rest of the run.
+type ramp struct {
+	kind  string        // "none", "linear" or "step"
+	steps int           // number of equal steps, for "step"
+	over  time.Duration // ramp-up period
+}
+
+// parseRamp reads "none", "linear:<period>" or "step:<n>x<step duration>",
+// e.g. "linear:2m" or "step:4x30s" (four 30s steps, at 25%, 50%, 75% and
+// 100% of the peak).
+func parseRamp(s string) (ramp, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "", "none":
+		return ramp{kind: "none"}, nil
+	case "linear":
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return ramp{}, fmt.Errorf("invalid linear ramp %q: want linear:<duration>", s)
+		}
+		return ramp{kind: "linear", over: d}, nil
+	case "step":
+		n, length, ok := strings.Cut(arg, "x")
+		steps, err := strconv.Atoi(n)
+		if !ok || err != nil || steps < 1 {
+			return ramp{}, fmt.Errorf("invalid step ramp %q: want step:<n>x<duration>", s)
+		}
+		d, err := time.ParseDuration(length)
+		if err != nil || d <= 0 {
+			return ramp{}, fmt.Errorf("invalid step ramp %q: want step:<n>x<duration>", s)
+		}
+		return ramp{kind: "step", steps: steps, over: time.Duration(steps) * d}, nil
+	default:
+		return ramp{}, fmt.Errorf("unknown ramp %q (want none, linear:<duration> or step:<n>x<duration>)", s)
+	}
+}
+
+// level returns the target at elapsed: a request rate or worker count
+// between 0 and peak.
+func (r ramp) level(peak float64, elapsed time.Duration) float64 {
+	if elapsed >= r.over {
+		return peak
+	}
+	switch r.kind {
+	case "linear":
+		return peak * elapsed.Seconds() / r.over.Seconds()
+	case "step":
+		stepLen := r.over / time.Duration(r.steps)
+		step := int(elapsed/stepLen) + 1
+		return peak * float64(step) / float64(r.steps)
+	default:
+		return peak
+	}
+}
+
+// dueBy returns how many requests an open-loop run at peak requests per
+// second should have sent by elapsed: the integral of level over [0, elapsed].
+func (r ramp) dueBy(peak float64, elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+	over := r.over.Seconds()
+	switch r.kind {
+	case "linear":
+		if t < over {
+			return peak * t * t / (2 * over)
+		}
+		return peak*over/2 + peak*(t-over)
+	case "step":
+		stepLen := over / float64(r.steps)
+		var due float64
+		for k := 1; k <= r.steps; k++ {
+			start := float64(k-1) * stepLen
+			if t <= start {
+				return due
+			}
+			due += peak * float64(k) / float64(r.steps) * (math.Min(t, start+stepLen) - start)
+		}
+		return due + peak*math.Max(0, t-over)
+	default:
+		return peak * t
+	}
+}
+
+// workers returns how many closed-loop workers should be running at elapsed.
+// At least one always runs so a linear ramp doesn't idle at the start.
+func (r ramp) workers(peak int, elapsed time.Duration) int {
+	n := int(math.Ceil(r.level(float64(peak), elapsed) - 1e-9))
+	return min(max(n, 1), peak)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseRamp(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ramp
+		wantErr bool
+	}{
+		{"none", ramp{kind: "none"}, false},
+		{"", ramp{kind: "none"}, false},
+		{"linear:2m", ramp{kind: "linear", over: 2 * time.Minute}, false},
+		{"step:4x30s", ramp{kind: "step", steps: 4, over: 2 * time.Minute}, false},
+		{"linear", ramp{}, true},
+		{"linear:-1s", ramp{}, true},
+		{"step:0x30s", ramp{}, true},
+		{"step:4", ramp{}, true},
+		{"step:4xforever", ramp{}, true},
+		{"sine:1m", ramp{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRamp(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRamp(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRamp(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRampLevel(t *testing.T) {
+	linear := ramp{kind: "linear", over: 10 * time.Second}
+	step := ramp{kind: "step", steps: 4, over: 40 * time.Second}
+	none := ramp{kind: "none"}
+
+	tests := []struct {
+		name    string
+		r       ramp
+		elapsed time.Duration
+		want    float64
+	}{
+		{"none at start", none, 0, 100},
+		{"linear at start", linear, 0, 0},
+		{"linear halfway", linear, 5 * time.Second, 50},
+		{"linear at end", linear, 10 * time.Second, 100},
+		{"linear after", linear, time.Minute, 100},
+		{"first step", step, 0, 25},
+		{"first step end", step, 9999 * time.Millisecond, 25},
+		{"third step", step, 25 * time.Second, 75},
+		{"last step", step, 39 * time.Second, 100},
+		{"after steps", step, time.Minute, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.level(100, tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("level = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRampDueBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       ramp
+		elapsed time.Duration
+		want    float64
+	}{
+		{"none", ramp{kind: "none"}, 3 * time.Second, 30},
+		// Linear: the area under a line from 0 to 10 req/s over 10s is 50.
+		{"linear halfway", ramp{kind: "linear", over: 10 * time.Second}, 5 * time.Second, 12.5},
+		{"linear at end", ramp{kind: "linear", over: 10 * time.Second}, 10 * time.Second, 50},
+		{"linear after", ramp{kind: "linear", over: 10 * time.Second}, 12 * time.Second, 70},
+		// Steps of 10s at 2.5, 5, 7.5 and 10 req/s.
+		{"step mid first", ramp{kind: "step", steps: 4, over: 40 * time.Second}, 4 * time.Second, 10},
+		{"step into second", ramp{kind: "step", steps: 4, over: 40 * time.Second}, 12 * time.Second, 35},
+		{"step at end", ramp{kind: "step", steps: 4, over: 40 * time.Second}, 40 * time.Second, 250},
+		{"step after", ramp{kind: "step", steps: 4, over: 40 * time.Second}, 45 * time.Second, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.dueBy(10, tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("dueBy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// dueBy is the integral of level, so it never decreases.
+	r := ramp{kind: "step", steps: 3, over: 9 * time.Second}
+	prev := 0.0
+	for ms := 0; ms <= 12000; ms += 7 {
+		due := r.dueBy(10, time.Duration(ms)*time.Millisecond)
+		if due < prev {
+			t.Fatalf("dueBy decreased at %dms: %v < %v", ms, due, prev)
+		}
+		prev = due
+	}
+}
+
+func TestRampWorkers(t *testing.T) {
+	linear := ramp{kind: "linear", over: 10 * time.Second}
+	tests := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 1},
+		{time.Second, 1},
+		{3 * time.Second, 3},
+		{3100 * time.Millisecond, 4},
+		{10 * time.Second, 10},
+		{time.Hour, 10},
+	}
+	for _, tt := range tests {
+		if got := linear.workers(10, tt.elapsed); got != tt.want {
+			t.Errorf("workers at %s = %d, want %d", tt.elapsed, got, tt.want)
+		}
+	}
+}
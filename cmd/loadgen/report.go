@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// outcome is the result of one request.
+type outcome struct {
+	Language string
+	Stream   bool
+	Latency  time.Duration
+	Code     string // empty on success; otherwise an API error code or a loadgen check (see client.go)
+}
+
+// summary is the report for a run, written as JSON and as a table.
+type summary struct {
+	Requests     int               `json:"requests"`
+	Errors       int               `json:"errors"`
+	ErrorRate    float64           `json:"error_rate"`
+	Duration     string            `json:"duration"`
+	Throughput   float64           `json:"throughput_rps"`
+	Latency      latencies         `json:"latency_ms"`
+	ErrorsByCode map[string]int    `json:"errors_by_code,omitempty"`
+	Languages    []languageSummary `json:"languages"`
+	SLO          *sloReport        `json:"slo,omitempty"`
+}
+
+type languageSummary struct {
+	Language  string    `json:"language"`
+	Requests  int       `json:"requests"`
+	Streamed  int       `json:"streamed"`
+	Errors    int       `json:"errors"`
+	ErrorRate float64   `json:"error_rate"`
+	Latency   latencies `json:"latency_ms"`
+}
+
+// latencies are in milliseconds, the unit people read these tables in.
+type latencies struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// summarize aggregates outcomes from a run that took elapsed.
+func summarize(outcomes []outcome, elapsed time.Duration) summary {
+	s := summary{
+		Requests: len(outcomes),
+		Duration: elapsed.Round(time.Millisecond).String(),
+		Latency:  latencyPercentiles(outcomes),
+	}
+	if elapsed > 0 {
+		s.Throughput = float64(len(outcomes)) / elapsed.Seconds()
+	}
+
+	byLang := make(map[string][]outcome)
+	for _, o := range outcomes {
+		byLang[o.Language] = append(byLang[o.Language], o)
+		if o.Code == "" {
+			continue
+		}
+		s.Errors++
+		if s.ErrorsByCode == nil {
+			s.ErrorsByCode = make(map[string]int)
+		}
+		s.ErrorsByCode[o.Code]++
+	}
+	s.ErrorRate = rate(s.Errors, s.Requests)
+
+	langs := make([]string, 0, len(byLang))
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		ls := languageSummary{Language: lang, Requests: len(byLang[lang]), Latency: latencyPercentiles(byLang[lang])}
+		for _, o := range byLang[lang] {
+			if o.Stream {
+				ls.Streamed++
+			}
+			if o.Code != "" {
+				ls.Errors++
+			}
+		}
+		ls.ErrorRate = rate(ls.Errors, ls.Requests)
+		s.Languages = append(s.Languages, ls)
+	}
+	return s
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func latencyPercentiles(outcomes []outcome) latencies {
+	if len(outcomes) == 0 {
+		return latencies{}
+	}
+	sorted := make([]time.Duration, len(outcomes))
+	for i, o := range outcomes {
+		sorted[i] = o.Latency
+	}
+	slices.Sort(sorted)
+	return latencies{
+		P50: ms(percentile(sorted, 50)),
+		P90: ms(percentile(sorted, 90)),
+		P95: ms(percentile(sorted, 95)),
+		P99: ms(percentile(sorted, 99)),
+		Max: ms(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile uses the nearest-rank method on sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// writeTable prints the summary for humans.
+func writeTable(w io.Writer, s summary) {
+	fmt.Fprintf(w, "%d requests in %s (%.1f req/s), %d errors (%.2f%%)\n\n",
+		s.Requests, s.Duration, s.Throughput, s.Errors, 100*s.ErrorRate)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "language\trequests\tstreamed\terrors\tp50 ms\tp90 ms\tp95 ms\tp99 ms\tmax ms\t")
+	row := func(name string, requests, streamed, errors int, l latencies) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			name, requests, streamed, errors, l.P50, l.P90, l.P95, l.P99, l.Max)
+	}
+	var streamed int
+	for _, l := range s.Languages {
+		row(l.Language, l.Requests, l.Streamed, l.Errors, l.Latency)
+		streamed += l.Streamed
+	}
+	row("total", s.Requests, streamed, s.Errors, s.Latency)
+	tw.Flush()
+
+	if len(s.ErrorsByCode) > 0 {
+		codes := make([]string, 0, len(s.ErrorsByCode))
+		for code := range s.ErrorsByCode {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		fmt.Fprintln(w, "\nerrors by code:")
+		for _, code := range codes {
+			fmt.Fprintf(w, "  %-24s %d\n", code, s.ErrorsByCode[code])
+		}
+	}
+
+	if s.SLO != nil {
+		fmt.Fprintln(w)
+		if s.SLO.Passed {
+			fmt.Fprintln(w, "SLO: pass")
+		}
+		for _, v := range s.SLO.Violations {
+			fmt.Fprintf(w, "SLO breached: %s\n", v)
+		}
+	}
+}
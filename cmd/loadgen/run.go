@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// scheduleTick is how often the open-loop scheduler sends the requests
+// that have come due.
+const scheduleTick = 5 * time.Millisecond
+
+// load drives one run and collects its outcomes.
+type load struct {
+	client         *client
+	mix            *mix
+	streamFraction float64
+	ramp           ramp
+	duration       time.Duration
+
+	mu       sync.Mutex
+	outcomes []outcome
+}
+
+// fire sends one request. Requests outlive ctx so an interrupted run still
+// reports the ones already in flight.
+func (l *load) fire(ctx context.Context) {
+	e := l.mix.pick(rand.Float64())
+	stream := rand.Float64() < l.streamFraction
+	o := l.client.execute(context.WithoutCancel(ctx), e, stream)
+	l.mu.Lock()
+	l.outcomes = append(l.outcomes, o)
+	l.mu.Unlock()
+}
+
+// openLoop sends requests at the ramped rate whether or not earlier ones
+// have been answered, so a slow server shows up as latency rather than as
+// fewer requests.
+func (l *load) openLoop(ctx context.Context, peakRate float64) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	tick := time.NewTicker(scheduleTick)
+	defer tick.Stop()
+
+	sent := 0
+	for elapsed := time.Duration(0); elapsed < l.duration; elapsed = time.Since(start) {
+		for due := int(l.ramp.dueBy(peakRate, elapsed)); sent < due; sent++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.fire(ctx)
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-tick.C:
+		}
+	}
+	wg.Wait()
+}
+
+// closedLoop runs up to peak workers that each send their next request as
+// soon as the previous one is answered. Workers above the ramped count idle.
+func (l *load) closedLoop(ctx context.Context, peak int) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < peak; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				elapsed := time.Since(start)
+				if elapsed >= l.duration {
+					return
+				}
+				if i >= l.ramp.workers(peak, elapsed) {
+					select {
+					case <-ctx.Done():
+					case <-time.After(50 * time.Millisecond):
+					}
+					continue
+				}
+				l.fire(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// slo holds the thresholds a run must meet. A zero P95 or a negative
+// MaxErrorRate is not checked; a MaxErrorRate of 0 allows no errors.
+type slo struct {
+	P95          time.Duration
+	MaxErrorRate float64
+}
+
+// noErrorRateSLO disables the error rate check.
+const noErrorRateSLO = -1
+
+type sloReport struct {
+	P95Ms        float64  `json:"p95_ms,omitempty"`
+	MaxErrorRate *float64 `json:"max_error_rate,omitempty"`
+	Passed       bool     `json:"passed"`
+	Violations   []string `json:"violations,omitempty"`
+}
+
+func (s slo) enabled() bool {
+	return s.P95 > 0 || s.MaxErrorRate >= 0
+}
+
+// evaluate checks a run's summary against the thresholds. A run with no
+// completed requests breaches any configured SLO, since nothing was measured.
+func (s slo) evaluate(sum summary) *sloReport {
+	if !s.enabled() {
+		return nil
+	}
+	r := &sloReport{P95Ms: ms(s.P95)}
+	if s.MaxErrorRate >= 0 {
+		r.MaxErrorRate = &s.MaxErrorRate
+	}
+	if sum.Requests == 0 {
+		r.Violations = append(r.Violations, "no requests completed")
+		return r
+	}
+	if s.P95 > 0 && sum.Latency.P95 > ms(s.P95) {
+		r.Violations = append(r.Violations, fmt.Sprintf("p95 latency %.1fms exceeds %.1fms", sum.Latency.P95, ms(s.P95)))
+	}
+	if s.MaxErrorRate >= 0 && sum.ErrorRate > s.MaxErrorRate {
+		r.Violations = append(r.Violations, fmt.Sprintf("error rate %.2f%% exceeds %.2f%%", 100*sum.ErrorRate, 100*s.MaxErrorRate))
+	}
+	r.Passed = len(r.Violations) == 0
+	return r
+}
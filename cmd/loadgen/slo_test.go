@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// syntheticOutcomes returns n python requests with latencies 1ms..n ms, the
+// first errors of which fail with code.
+func syntheticOutcomes(n, errors int, code string) []outcome {
+	outcomes := make([]outcome, n)
+	for i := range outcomes {
+		outcomes[i] = outcome{Language: "python", Latency: time.Duration(i+1) * time.Millisecond}
+		if i < errors {
+			outcomes[i].Code = code
+		}
+	}
+	return outcomes
+}
+
+func TestSummarize(t *testing.T) {
+	outcomes := syntheticOutcomes(100, 3, "RATE_LIMITED")
+	outcomes = append(outcomes,
+		outcome{Language: "node", Stream: true, Latency: 500 * time.Millisecond, Code: codeUnexpectedOutput},
+		outcome{Language: "node", Latency: 2 * time.Millisecond},
+	)
+	s := summarize(outcomes, 2*time.Second)
+
+	if s.Requests != 102 || s.Errors != 4 {
+		t.Errorf("requests %d errors %d, want 102 and 4", s.Requests, s.Errors)
+	}
+	if s.Throughput != 51 {
+		t.Errorf("throughput = %v, want 51", s.Throughput)
+	}
+	if s.ErrorsByCode["RATE_LIMITED"] != 3 || s.ErrorsByCode[codeUnexpectedOutput] != 1 {
+		t.Errorf("errors by code = %v", s.ErrorsByCode)
+	}
+	if len(s.Languages) != 2 || s.Languages[0].Language != "node" || s.Languages[0].Streamed != 1 {
+		t.Fatalf("languages = %+v", s.Languages)
+	}
+	py := s.Languages[1]
+	if py.Latency.P50 != 50 || py.Latency.P95 != 95 || py.Latency.P99 != 99 || py.Latency.Max != 100 {
+		t.Errorf("python latency = %+v", py.Latency)
+	}
+	if py.ErrorRate != 0.03 {
+		t.Errorf("python error rate = %v, want 0.03", py.ErrorRate)
+	}
+	if s.Latency.Max != 500 {
+		t.Errorf("overall max = %v, want 500", s.Latency.Max)
+	}
+
+	if empty := summarize(nil, time.Second); empty.Requests != 0 || empty.Latency != (latencies{}) {
+		t.Errorf("empty summary = %+v", empty)
+	}
+}
+
+func TestSLOEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		slo      slo
+		outcomes []outcome
+		want     []string // substrings of the violations, in order
+	}{
+		{"disabled", slo{MaxErrorRate: noErrorRateSLO}, syntheticOutcomes(100, 50, "EXECUTION_FAILED"), nil},
+		{"within both", slo{P95: 100 * time.Millisecond, MaxErrorRate: 0.05}, syntheticOutcomes(100, 5, "RATE_LIMITED"), []string{}},
+		{"p95 breached", slo{P95: 90 * time.Millisecond, MaxErrorRate: noErrorRateSLO}, syntheticOutcomes(100, 0, ""), []string{"p95 latency 95.0ms exceeds 90.0ms"}},
+		{"error rate breached", slo{MaxErrorRate: 0.01}, syntheticOutcomes(100, 2, "RATE_LIMITED"), []string{"error rate 2.00% exceeds 1.00%"}},
+		{"zero errors allowed", slo{MaxErrorRate: 0}, syntheticOutcomes(1000, 1, "RATE_LIMITED"), []string{"error rate 0.10%"}},
+		{"both breached", slo{P95: time.Millisecond, MaxErrorRate: 0}, syntheticOutcomes(10, 1, "X"), []string{"p95", "error rate"}},
+		{"nothing measured", slo{P95: time.Second, MaxErrorRate: noErrorRateSLO}, nil, []string{"no requests completed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := tt.slo.evaluate(summarize(tt.outcomes, time.Second))
+			if tt.want == nil {
+				if report != nil {
+					t.Errorf("report = %+v, want none with no thresholds", report)
+				}
+				return
+			}
+			if report == nil {
+				t.Fatal("no report")
+			}
+			if report.Passed != (len(tt.want) == 0) || len(report.Violations) != len(tt.want) {
+				t.Fatalf("passed %v violations %q, want %q", report.Passed, report.Violations, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(report.Violations[i], want) {
+					t.Errorf("violation %d = %q, want it to mention %q", i, report.Violations[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	s := summarize(syntheticOutcomes(20, 1, "RATE_LIMITED"), time.Second)
+	s.SLO = slo{MaxErrorRate: 0}.evaluate(s)
+	var b strings.Builder
+	writeTable(&b, s)
+	for _, want := range []string{"20 requests", "python", "total", "RATE_LIMITED", "SLO breached: error rate"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, b.String())
+		}
+	}
+}
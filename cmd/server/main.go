@@ -5,9 +5,16 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"syscall"
 	"time"
 
@@ -20,9 +27,25 @@ import (
 	authproxy "safe-agent-sandbox/internal/proxy"
 	"safe-agent-sandbox/internal/sandbox"
 	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/internal/upgrade"
 )
 
 func main() {
+	validate := flag.Bool("validate-config", false, "check the config file given as the argument (default $CONFIG_PATH or configs/config.yaml), print the report as JSON and exit: 0 clean, 1 warnings only, 2 errors")
+	offline := flag.Bool("offline", false, "with --validate-config, don't check that the paths the config names exist")
+	printSchema := flag.Bool("print-config-schema", false, "print the config file's JSON Schema and exit")
+	flag.Parse()
+
+	if *printSchema {
+		schema, err := config.Schema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		_, _ = os.Stdout.Write(schema)
+		return
+	}
+
 	// Structured logging
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
 	if os.Getenv("ENV") != "production" {
@@ -34,12 +57,23 @@ func main() {
 	if configPath == "" {
 		configPath = "configs/config.yaml"
 	}
+	if *validate {
+		if flag.NArg() > 0 {
+			configPath = flag.Arg(0)
+		}
+		os.Exit(validateConfig(os.Stdout, configPath, *offline))
+	}
 
 	var cfg *config.Config
 	var err error
 
 	if _, statErr := os.Stat(configPath); statErr == nil {
 		cfg, err = config.Load(configPath)
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			invalid.Report.Log()
+			log.Fatal().Str("path", configPath).Msg("invalid config")
+		}
 		if err != nil {
 			log.Fatal().Err(err).Str("path", configPath).Msg("failed to load config")
 		}
@@ -59,15 +93,24 @@ func main() {
 	// Initialize metrics
 	metrics := monitor.NewMetrics()
 
-	// Initialize sandbox backend (auto-detects containerd vs Docker)
-	var backend sandbox.Backend
-	backend, err = sandbox.NewBackend(ctx, cfg)
+	// A process an upgrade started serves on the sockets of the one it
+	// replaces, whose auth proxy state comes first down the handover pipe.
+	inherited, err := upgrade.Inherit()
 	if err != nil {
-		log.Warn().Err(err).Msg("no sandbox backend available (execution will fail)")
-		// Continue startup so health/metrics endpoints work for debugging
+		log.Fatal().Err(err).Msg("failed to take over the old process's sockets")
+	}
+	var proxyState authproxy.State
+	if inherited != nil {
+		if err := inherited.Receive(&proxyState); err != nil {
+			log.Fatal().Err(err).Msg("failed to read the old process's auth proxy state")
+		}
+		cfg.Sandbox.Successor = true
+		metrics.RecordHandover(upgrade.OutcomeInherited)
+		log.Info().Str("handover", upgrade.OutcomeInherited).Int("old_pid", os.Getppid()).Msg("upgrade: taking over from the old process")
 	}
 
-	// Start auth proxy if configured (token never enters containers).
+	// Start auth proxy if configured (token never enters containers). It
+	// comes first so the backend gets its secret.
 	var proxy *authproxy.AuthProxy
 	if cfg.AuthProxy.Port > 0 {
 		token := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN")
@@ -81,53 +124,203 @@ func main() {
 		// Generate a per-startup shared secret. Containers present this as
 		// their x-api-key; the proxy validates it before forwarding with
 		// the real token. If it leaks from a container, it is useless
-		// against api.anthropic.com directly.
-		secretBytes := make([]byte, 32)
-		if _, err := rand.Read(secretBytes); err != nil {
-			log.Fatal().Err(err).Msg("failed to generate proxy secret")
+		// against api.anthropic.com directly. After an upgrade it is the
+		// old process's, which its containers still present.
+		proxySecret := proxyState.Secret
+		if proxySecret == "" {
+			secretBytes := make([]byte, 32)
+			if _, err := rand.Read(secretBytes); err != nil {
+				log.Fatal().Err(err).Msg("failed to generate proxy secret")
+			}
+			proxySecret = hex.EncodeToString(secretBytes)
 		}
-		proxySecret := hex.EncodeToString(secretBytes)
 		cfg.AuthProxy.Secret = proxySecret
 
-		proxy = authproxy.NewWithRPM(cfg.AuthProxy.Port, token, proxySecret, cfg.AuthProxy.MaxProxyRPM)
+		proxy = authproxy.NewWithRPM(cfg.AuthProxy.Port, token, proxySecret, cfg.AuthProxy.MaxProxyRPM, authproxy.TransportConfig{
+			MaxIdleConns:          cfg.Security.MaxConcurrentClaude,
+			ResponseHeaderTimeout: cfg.AuthProxy.ResponseHeaderTimeout,
+			IdleConnTimeout:       cfg.AuthProxy.IdleConnTimeout,
+			DNSCacheTTL:           cfg.AuthProxy.DNSCacheTTL,
+			Observer:              metrics,
+		})
+		proxy.SetThrottleObserver(metrics)
+		if inherited != nil {
+			if err := proxy.Inherit(proxyState); err != nil {
+				log.Fatal().Err(err).Msg("failed to take over the old process's auth proxy")
+			}
+			if ln := inherited.Listeners()[listenerAuthProxy]; ln != nil {
+				proxy.SetListener(ln)
+			}
+		}
+		removeCA, err := setProxyBoundary(ctx, proxy, &cfg.AuthProxy)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to configure auth proxy")
+		}
+		defer removeCA()
 		if err := proxy.Start(); err != nil {
-			log.Fatal().Err(err).Int("port", cfg.AuthProxy.Port).Msg("failed to start auth proxy")
+			log.Fatal().Err(err).Str("bind", cfg.AuthProxy.ListenIP).Int("port", cfg.AuthProxy.Port).Msg("failed to start auth proxy")
 		}
-		log.Info().Int("port", cfg.AuthProxy.Port).Msg("auth proxy listening")
+		log.Info().Str("bind", cfg.AuthProxy.ListenIP).Int("port", cfg.AuthProxy.Port).
+			Bool("acl", cfg.AuthProxy.ACL.Enabled).Bool("tls", cfg.AuthProxy.TLS).
+			Bool("per_execution_secrets", cfg.AuthProxy.PerExecutionSecrets).Msg("auth proxy listening")
+	}
+
+	// Initialize sandbox backend (auto-detects containerd vs Docker)
+	var backend sandbox.Backend
+	backend, err = sandbox.NewBackend(ctx, cfg, metrics)
+	if err != nil {
+		log.Warn().Err(err).Msg("no sandbox backend available (execution will fail)")
+		// Continue startup so health/metrics endpoints work for debugging
+	}
+
+	// Report every config warning in one block, including the ones only
+	// the backend can see.
+	report := cfg.Check()
+	if checker, ok := backend.(sandbox.ConfigChecker); ok {
+		report.Warn(checker.ConfigWarnings(ctx, cfg)...)
 	}
+	report.Log()
 
 	// Initialize database (optional — runs without it for development)
 	var db *storage.DB
 	if cfg.Database.DSN != "" {
 		db, err = storage.New(ctx, cfg.Database.DSN)
-		if err != nil {
+		switch {
+		case err != nil && cfg.Database.Required:
+			log.Fatal().Err(err).Msg("database unavailable and database.required is set")
+		case err != nil:
 			log.Warn().Err(err).Msg("database unavailable, audit logging disabled")
-		} else {
+		default:
 			defer db.Close()
 		}
 	}
 
+	// Open the file audit sink, if configured
+	var fileSink *storage.FileSink
+	if slices.Contains(cfg.Database.Sinks, "file") {
+		fileSink, err = openFileSink(cfg.Database.FileSink)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", cfg.Database.FileSink.Path).Msg("failed to open audit file")
+		}
+		defer fileSink.Close()
+	}
+
 	// Initialize audit writer (buffered, reliable logging)
 	var auditWriter *storage.AuditWriter
-	if db != nil {
-		auditWriter = storage.NewAuditWriter(db, 10000)
+	toPostgres := db != nil && slices.Contains(cfg.Database.Sinks, "postgres")
+	if toPostgres || fileSink != nil {
+		auditWriter = storage.NewAuditWriter(10000)
+		if toPostgres {
+			auditWriter.AddSink("postgres", db)
+		}
+		if fileSink != nil {
+			auditWriter.AddSink("file", fileSink)
+		}
+		auditWriter.SetBatching(cfg.Database.AuditBatch.MaxRows, cfg.Database.AuditBatch.MaxWait)
+		auditWriter.SetObserver(metrics)
 		auditWriter.Start()
 		defer auditWriter.Flush(10 * time.Second)
 	}
 
 	// Create and start HTTP server
 	server := api.NewServer(cfg, backend, db, auditWriter, metrics)
+	server.SetConfigWarnings(report.Warnings)
+	if db == nil && fileSink != nil && cfg.Database.FileSink.ServeExecutions {
+		server.SetExecutionLister(fileSink.ListExecutions)
+	}
+	if proxy != nil {
+		server.SetTokenBroker(proxy)
+	}
+	if err := server.StartJobs(); err != nil {
+		log.Fatal().Err(err).Msg("failed to load jobs")
+	}
+	var sockets map[string]net.Listener
+	if inherited != nil {
+		sockets = inherited.Listeners()
+	}
+	if err := server.Listen(sockets); err != nil {
+		log.Fatal().Err(err).Str("addr", cfg.Address()).Msg("failed to listen")
+	}
+	if inherited != nil {
+		go followOldProcess(inherited, proxy)
+		if err := inherited.Ready(); err != nil {
+			log.Fatal().Err(err).Msg("upgrade: the old process is gone")
+		}
+		log.Info().Str("handover", "ready").Msg("upgrade: serving; the old process stops accepting and drains")
+	}
 
-	// Graceful shutdown
+	// SIGHUP re-reads the kill switches (security.disabled_*) and the
+	// claude pricing from the config file; nothing else is reloaded.
 	go func() {
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		for range hupCh {
+			reloaded, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Str("path", configPath).Msg("config reload failed, keeping the current feature flags")
+				continue
+			}
+			server.ReloadFlags(reloaded.Security)
+			server.ReloadPricing(reloaded.Security)
+			log.Info().Str("path", configPath).Strs("disabled_languages", reloaded.Security.DisabledLanguages).
+				Strs("disabled_features", reloaded.Security.DisabledFeatures).Msg("feature flags reloaded")
+		}
+	}()
+
+	// The upgrade signal hands the listening sockets to a new binary; once
+	// it is serving, this process shuts down, draining its executions for
+	// up to server.upgrade.drain_timeout rather than shutdown_timeout.
+	handedOver := make(chan struct{})
+	if upgrade.Signal != nil {
+		go func() {
+			usrCh := make(chan os.Signal, 1)
+			signal.Notify(usrCh, upgrade.Signal)
+			for range usrCh {
+				if err := handOver(cfg.Server.Upgrade, server, proxy); err != nil {
+					metrics.RecordHandover(upgrade.OutcomeAborted)
+					log.Error().Err(err).Str("handover", upgrade.OutcomeAborted).Msg("upgrade abandoned; still serving")
+					continue
+				}
+				metrics.RecordHandover(upgrade.OutcomeHandedOver)
+				log.Info().Str("handover", upgrade.OutcomeHandedOver).Msg("upgrade: the new process is serving; draining")
+				signal.Stop(usrCh)
+				close(handedOver)
+				return
+			}
+		}()
+	}
+
+	// Graceful shutdown. Start returns as soon as it begins, so main waits
+	// for it before its deferred audit flush.
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		sig := <-sigCh
 
-		log.Info().Str("signal", sig.String()).Msg("shutting down")
+		drain := cfg.Server.ShutdownTimeout
+		select {
+		case sig := <-sigCh:
+			log.Info().Str("signal", sig.String()).Msg("shutting down")
+		case <-handedOver:
+			drain = cfg.Server.Upgrade.DrainTimeout
+		}
 
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+		if drain > 0 {
+			shutdownCtx, shutdownCancel = context.WithTimeout(context.Background(), drain)
+		}
 		defer shutdownCancel()
+		// A signal during an upgrade's drain ends it: what is still
+		// running is interrupted and recorded.
+		go func() {
+			select {
+			case sig := <-sigCh:
+				log.Warn().Str("signal", sig.String()).Msg("ending the drain early")
+				shutdownCancel()
+			case <-shutdownCtx.Done():
+			}
+		}()
 
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Error().Err(err).Msg("HTTP server shutdown error")
@@ -158,6 +351,162 @@ func main() {
 	if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal().Err(err).Msg("server failed")
 	}
+	<-shutdownDone
 
 	log.Info().Msg("server stopped")
 }
+
+// listenerAuthProxy names the auth proxy's socket in a handover.
+const listenerAuthProxy = "auth_proxy"
+
+// handOver starts the new binary on the server's and the auth proxy's
+// sockets and waits for it to be serving. It is sent the proxy's state,
+// and then the proxy's registrations for as long as this process lives, so
+// it can serve this one's containers too; once it is serving, the proxy
+// stops accepting, leaving them all to it, and the caller drains the
+// server. If the new process fails first, nothing has changed.
+func handOver(c config.UpgradeConfig, server *api.Server, proxy *authproxy.AuthProxy) error {
+	binary := c.Binary
+	if binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("finding this binary: %w", err)
+		}
+		binary = exe
+	}
+	var listeners []upgrade.Listener
+	for name, ln := range server.Listeners() {
+		listeners = append(listeners, upgrade.Listener{Name: name, Listener: ln})
+	}
+	if proxy != nil {
+		listeners = append(listeners, upgrade.Listener{Name: listenerAuthProxy, Listener: proxy.Listener()})
+	}
+	u := upgrade.Upgrader{Binary: binary, Args: os.Args[1:], ReadyTimeout: c.ReadyTimeout}
+	h, err := u.Start(listeners)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("handover", "started").Str("binary", binary).Int("pid", h.Pid()).Msg("upgrade: new process started")
+
+	stop := func() {}
+	if proxy != nil {
+		stop, err = proxy.HandOver(h.Send)
+	} else {
+		err = h.Send(authproxy.State{})
+	}
+	if err != nil {
+		h.Cancel()
+		return err
+	}
+	if err := h.Wait(); err != nil {
+		stop()
+		return err
+	}
+	if proxy != nil {
+		if err := proxy.StopAccepting(); err != nil {
+			log.Warn().Err(err).Msg("upgrade: closing the auth proxy's listener")
+		}
+	}
+	return nil
+}
+
+// followOldProcess applies the registrations the old process's auth proxy
+// sends until it exits.
+func followOldProcess(in *upgrade.Inherited, proxy *authproxy.AuthProxy) {
+	var err error
+	if proxy != nil {
+		err = proxy.Follow(in.Receive)
+	} else {
+		for err == nil {
+			err = in.Receive(new(authproxy.Registration))
+		}
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("upgrade: lost the old process's auth proxy registrations")
+		return
+	}
+	log.Info().Str("handover", "complete").Msg("upgrade: the old process has exited")
+}
+
+// setProxyBoundary applies auth_proxy's bind, acl, tls and
+// per_execution_secrets to proxy, recording the address it listens on and
+// the CA file it writes in p for the backend. The func it returns removes
+// the CA file.
+func setProxyBoundary(ctx context.Context, proxy *authproxy.AuthProxy, p *config.AuthProxyConfig) (func(), error) {
+	ip, err := sandbox.AuthProxyBind(p.Bind, func() (sandbox.DockerBridge, error) {
+		return sandbox.InspectDockerBridge(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.ListenIP = ip.String()
+	proxy.SetBind(ip)
+
+	if p.ACL.Enabled {
+		var static []netip.Prefix
+		for _, cidr := range p.ACL.AllowedCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("auth_proxy.acl.allowed_cidrs: %w", err)
+			}
+			static = append(static, prefix.Masked())
+		}
+		acl := authproxy.NewSourceACL(static, func(ctx context.Context) ([]netip.Prefix, error) {
+			b, err := sandbox.InspectDockerBridge(ctx)
+			return b.Subnets, err
+		})
+		if err := acl.Reload(ctx); err != nil {
+			log.Warn().Err(err).Msg("auth proxy ACL: Docker's bridge subnets unavailable; only auth_proxy.acl.allowed_cidrs are accepted until they can be read")
+		}
+		go acl.Run(ctx, p.ACL.Refresh, func(err error) {
+			log.Warn().Err(err).Msg("auth proxy ACL: re-reading Docker's bridge subnets failed; keeping the last ones")
+		})
+		proxy.SetSourceACL(acl)
+	}
+
+	if p.PerExecutionSecrets {
+		proxy.RequireExecutionSecrets()
+	}
+
+	removeCA := func() {}
+	if p.TLS {
+		caPEM, err := proxy.UseTLS(ip)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := os.MkdirTemp("", "sandbox-proxy-ca-")
+		if err != nil {
+			return nil, fmt.Errorf("auth proxy CA: %w", err)
+		}
+		removeCA = func() { _ = os.RemoveAll(dir) }
+		p.CAFile = filepath.Join(dir, "ca.pem")
+		// Claude containers run as an unprivileged user that must read it.
+		if err := os.WriteFile(p.CAFile, caPEM, 0o644); err != nil { // #nosec G306 -- a public certificate
+			removeCA()
+			return nil, fmt.Errorf("auth proxy CA: %w", err)
+		}
+	}
+	return removeCA, nil
+}
+
+// openFileSink opens the "file" audit sink, reading its HMAC key from the
+// environment variable the config names.
+func openFileSink(c config.FileSinkConfig) (*storage.FileSink, error) {
+	var key []byte
+	if c.HMACKeyEnv != "" {
+		key = []byte(os.Getenv(c.HMACKeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("database.file_sink.hmac_key_env names %s, which is unset or empty", c.HMACKeyEnv)
+		}
+	}
+	return storage.NewFileSink(storage.FileSinkOptions{
+		Path:     c.Path,
+		MaxBytes: c.MaxBytes,
+		MaxAge:   c.MaxAge,
+		Fsync:    c.Fsync,
+		HMACKey:  key,
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// Exit codes of --validate-config.
+const (
+	validConfig    = 0
+	configWarnings = 1
+	configErrors   = 2
+)
+
+// validateConfig checks the config file at path for --validate-config and
+// writes the report to w as JSON. It returns the exit code: validConfig,
+// configWarnings when there are only warnings, or configErrors, which
+// includes a file that can't be read or parsed.
+func validateConfig(w io.Writer, path string, offline bool) int {
+	report, err := config.CheckFile(path, offline)
+	if err != nil {
+		report = &config.Report{Errors: []string{err.Error()}}
+	}
+	out := struct {
+		Path    string `json:"path"`
+		Offline bool   `json:"offline,omitempty"`
+		Valid   bool   `json:"valid"`
+		*config.Report
+	}{path, offline, len(report.Errors) == 0, report}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(out)
+
+	switch {
+	case len(report.Errors) > 0:
+		return configErrors
+	case len(report.Warnings) > 0:
+		return configWarnings
+	}
+	return validConfig
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string // contents; empty means no file at all
+		offline bool
+		want    int
+		wantMsg string
+	}{
+		{"clean", "security:\n  allowed_keys: [key]\n", false, validConfig, ""},
+		{"unknown key", "security:\n  allowed_keys: [key]\n  alowed_keys: [key]\n", false, configWarnings, `line 3: unknown key "alowed_keys" is ignored`},
+		{"missing workdir root", "security:\n  allowed_keys: [key]\nsandbox:\n  allowed_workdir_roots: [/nonexistent/root]\n", false, configWarnings, "/nonexistent/root"},
+		{"missing workdir root offline", "security:\n  allowed_keys: [key]\nsandbox:\n  allowed_workdir_roots: [/nonexistent/root]\n", true, validConfig, ""},
+		{"bad backend", "security:\n  allowed_keys: [key]\nsandbox:\n  backend: podman\n", true, configErrors, `sandbox.backend must be auto, containerd or docker, got "podman"`},
+		{"bad port", "security:\n  allowed_keys: [key]\nserver:\n  port: 70000\n", true, configErrors, "server.port"},
+		{"unparsable", "server: [\n", false, configErrors, "parsing config"},
+		{"missing file", "", false, configErrors, "no such file"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.yaml")
+			if tt.file != "" {
+				path = filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			code := validateConfig(&out, path, tt.offline)
+			if code != tt.want {
+				t.Errorf("exit %d, want %d:\n%s", code, tt.want, out.String())
+			}
+
+			var report struct {
+				Path     string   `json:"path"`
+				Offline  bool     `json:"offline"`
+				Valid    bool     `json:"valid"`
+				Errors   []string `json:"errors"`
+				Warnings []string `json:"warnings"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+				t.Fatalf("output isn't JSON: %v\n%s", err, out.String())
+			}
+			if report.Path != path || report.Offline != tt.offline || report.Valid != (tt.want != configErrors) {
+				t.Errorf("test %d: report %+v", i, report)
+			}
+			all := strings.Join(append(report.Errors, report.Warnings...), "\n")
+			if tt.wantMsg == "" && all != "" {
+				t.Errorf("unexpected findings:\n%s", all)
+			}
+			if !strings.Contains(all, tt.wantMsg) {
+				t.Errorf("findings don't mention %q:\n%s", tt.wantMsg, all)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// Anomaly flags: the security event types security.anomaly reports.
+const (
+	flagNewLanguage    = "new_language_for_key"
+	flagDuration       = "duration_outlier"
+	flagFirstNetwork   = "first_network_use"
+	flagDetectionSpike = "detection_rate_spike"
+)
+
+const (
+	// durationSamples is how many recent durations are kept per caller and
+	// language for the p95 duration_outlier compares against.
+	durationSamples = 64
+	// minDurationSamples is how many a language needs before its p95 means
+	// anything.
+	minDurationSamples = 10
+	// baselineIdleTTL is how long a caller's history is kept after its last
+	// execution.
+	baselineIdleTTL = 30 * 24 * time.Hour
+)
+
+// anomalyFlagger keeps a summary of each caller's executions in memory and
+// flags those that depart from it. Callers are identified as workspaceOwner
+// does. Everything on the request path is a map lookup and a sort of at
+// most durationSamples durations.
+type anomalyFlagger struct {
+	cfg config.AnomalyConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	baselines map[string]*baseline
+	dirty     map[string]bool // changed since the last save
+}
+
+// baseline is one caller's history. It is saved as JSON.
+type baseline struct {
+	Executions  int                          `json:"executions"`
+	Languages   map[string]*languageBaseline `json:"languages"`
+	NetworkUses int                          `json:"network_uses"`
+	Detections  int                          `json:"detections"` // executions with a high or critical detection
+	LastSeen    time.Time                    `json:"last_seen"`
+}
+
+type languageBaseline struct {
+	Executions  int     `json:"executions"`
+	DurationsMS []int64 `json:"durations_ms"` // the last durationSamples, oldest first
+}
+
+// newAnomalyFlagger returns nil when security.anomaly is off.
+func newAnomalyFlagger(cfg config.AnomalyConfig) *anomalyFlagger {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &anomalyFlagger{
+		cfg:       cfg,
+		now:       time.Now,
+		baselines: make(map[string]*baseline),
+		dirty:     make(map[string]bool),
+	}
+}
+
+// anomalyRun is what the flagger looks at of a finished execution.
+type anomalyRun struct {
+	language string
+	network  bool
+	duration time.Duration
+	events   []sandbox.SecurityEvent
+}
+
+// observe returns the anomaly flags for run against owner's history, then
+// adds run to it. A caller with fewer than min_executions behind it gets no
+// flags.
+func (a *anomalyFlagger) observe(owner string, run anomalyRun) []sandbox.SecurityEvent {
+	detected := slices.ContainsFunc(run.events, func(e sandbox.SecurityEvent) bool {
+		sev, ok := monitor.ParseSeverity(e.Severity)
+		return ok && sev >= monitor.SeverityHigh
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.baselines[owner]
+	if !ok {
+		b = &baseline{Languages: make(map[string]*languageBaseline)}
+		a.baselines[owner] = b
+	}
+
+	var flags []sandbox.SecurityEvent
+	flag := func(typ, format string, args ...any) {
+		flags = append(flags, sandbox.SecurityEvent{
+			Type: typ, Source: sandbox.SourceBaseline, Severity: monitor.SeverityLow.String(),
+			Detail: fmt.Sprintf(format, args...),
+		})
+	}
+	lang := b.Languages[run.language]
+	if b.Executions >= a.cfg.MinExecutions {
+		if lang == nil {
+			flag(flagNewLanguage, "first %s execution in the caller's %d", run.language, b.Executions)
+		} else if len(lang.DurationsMS) >= minDurationSamples {
+			p95 := time.Duration(percentile(lang.DurationsMS, 0.95)) * time.Millisecond
+			if run.duration >= a.cfg.MinOutlierDuration && float64(run.duration) > a.cfg.DurationFactor*float64(p95) {
+				flag(flagDuration, "ran %s; the caller's %s executions have a p95 of %s", run.duration.Round(time.Millisecond), run.language, p95)
+			}
+		}
+		if run.network && b.NetworkUses == 0 {
+			flag(flagFirstNetwork, "first network-enabled execution in the caller's %d", b.Executions)
+		}
+		if detected && float64(b.Detections) <= a.cfg.DetectionRate*float64(b.Executions) {
+			flag(flagDetectionSpike, "high-severity detections; %d of the caller's %d executions had any", b.Detections, b.Executions)
+		}
+	}
+
+	if lang == nil {
+		lang = &languageBaseline{}
+		b.Languages[run.language] = lang
+	}
+	lang.Executions++
+	lang.DurationsMS = append(lang.DurationsMS, run.duration.Milliseconds())
+	if n := len(lang.DurationsMS); n > durationSamples {
+		lang.DurationsMS = slices.Delete(lang.DurationsMS, 0, n-durationSamples)
+	}
+	b.Executions++
+	if run.network {
+		b.NetworkUses++
+	}
+	if detected {
+		b.Detections++
+	}
+	b.LastSeen = a.now()
+	a.dirty[owner] = true
+	return flags
+}
+
+// percentile returns the q-th percentile of samples, nearest rank.
+func percentile(samples []int64, q float64) int64 {
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	i := int(q*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// snapshot returns the baselines changed since the last snapshot, and
+// forgets callers idle past baselineIdleTTL.
+func (a *anomalyFlagger) snapshot() []storage.Baseline {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for owner, b := range a.baselines {
+		if now.Sub(b.LastSeen) >= baselineIdleTTL {
+			delete(a.baselines, owner)
+			delete(a.dirty, owner)
+		}
+	}
+	out := make([]storage.Baseline, 0, len(a.dirty))
+	for owner := range a.dirty {
+		stats, err := json.Marshal(a.baselines[owner])
+		if err != nil {
+			continue
+		}
+		out = append(out, storage.Baseline{Identity: owner, Stats: stats, UpdatedAt: a.baselines[owner].LastSeen})
+	}
+	clear(a.dirty)
+	return out
+}
+
+// restore loads saved baselines, skipping any that don't parse.
+func (a *anomalyFlagger) restore(saved []storage.Baseline) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range saved {
+		var b baseline
+		if err := json.Unmarshal(s.Stats, &b); err != nil || b.Languages == nil {
+			continue
+		}
+		a.baselines[s.Identity] = &b
+	}
+}
+
+// persist saves the changed baselines to db every persist_interval until
+// ctx is done, and once more then. Baselines that fail to save are tried
+// again next time.
+func (a *anomalyFlagger) persist(ctx context.Context, db *storage.DB) {
+	save := func() {
+		changed := a.snapshot()
+		saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.SaveBaselines(saveCtx, changed); err != nil {
+			log.Warn().Err(err).Int("callers", len(changed)).Msg("could not save anomaly baselines")
+			a.mu.Lock()
+			for _, b := range changed {
+				if _, ok := a.baselines[b.Identity]; ok {
+					a.dirty[b.Identity] = true
+				}
+			}
+			a.mu.Unlock()
+		}
+	}
+	ticker := time.NewTicker(a.cfg.PersistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			save()
+		case <-ctx.Done():
+			save()
+			return
+		}
+	}
+}
+
+// flagAnomalies adds the anomaly flags for result to its security events
+// and counts them. Chaos runs are neither flagged nor learned from.
+func (h *Handlers) flagAnomalies(req *sandbox.ExecutionRequest, owner string, result *sandbox.ExecutionResult) {
+	if h.anomalies == nil || result == nil || result.Chaos {
+		return
+	}
+	flags := h.anomalies.observe(owner, anomalyRun{
+		language: req.Language,
+		network:  req.NetworkEnabled,
+		duration: result.Duration,
+		events:   result.SecurityEvents,
+	})
+	for _, f := range flags {
+		h.metrics.RecordAnomaly(f.Type)
+	}
+	result.SecurityEvents = append(result.SecurityEvents, flags...)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+func testAnomalyFlagger() (*anomalyFlagger, *time.Time) {
+	cfg := config.DefaultConfig().Security.Anomaly
+	cfg.MinExecutions = 5
+	a := newAnomalyFlagger(cfg)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+func flagTypes(events []sandbox.SecurityEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, e.Type)
+	}
+	return out
+}
+
+// warm gives owner n two-second python executions without network or
+// detections.
+func warm(t *testing.T, a *anomalyFlagger, owner string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if flags := a.observe(owner, anomalyRun{language: "python", duration: 2 * time.Second}); len(flags) > 0 {
+			t.Fatalf("execution %d like the rest flagged %v", i+1, flagTypes(flags))
+		}
+	}
+}
+
+func TestAnomalyFlagger_Stats(t *testing.T) {
+	a, _ := testAnomalyFlagger()
+	for i := 0; i < durationSamples+6; i++ {
+		a.observe("k", anomalyRun{language: "python", duration: time.Duration(i) * time.Millisecond})
+	}
+	a.observe("k", anomalyRun{language: "bash", network: true, duration: time.Second,
+		events: []sandbox.SecurityEvent{{Type: "secret_access", Severity: "critical"}}})
+	a.observe("k", anomalyRun{language: "bash", events: []sandbox.SecurityEvent{{Type: "proc_access", Severity: "medium"}}})
+
+	b := a.baselines["k"]
+	if b.Executions != durationSamples+8 || b.NetworkUses != 1 || b.Detections != 1 {
+		t.Errorf("baseline = %+v", b)
+	}
+	py := b.Languages["python"]
+	if py.Executions != durationSamples+6 || len(py.DurationsMS) != durationSamples || py.DurationsMS[0] != 6 {
+		t.Errorf("python: %d executions, %d samples starting at %v; want the last %d kept",
+			py.Executions, len(py.DurationsMS), py.DurationsMS[0], durationSamples)
+	}
+	if b.Languages["bash"].Executions != 2 {
+		t.Errorf("bash = %+v", b.Languages["bash"])
+	}
+}
+
+func TestAnomalyFlagger_Flags(t *testing.T) {
+	high := []sandbox.SecurityEvent{{Type: "secret_access", Severity: "high"}}
+	for _, tc := range []struct {
+		name string
+		run  anomalyRun
+		want []string
+	}{
+		{"usual", anomalyRun{language: "python", duration: 3 * time.Second}, nil},
+		{"new language", anomalyRun{language: "bash", duration: time.Second}, []string{flagNewLanguage}},
+		{"duration outlier", anomalyRun{language: "python", duration: 5 * time.Minute}, []string{flagDuration}},
+		// Just past five times the 2s p95, and at min_outlier_duration.
+		{"just over", anomalyRun{language: "python", duration: 10*time.Second + time.Millisecond}, []string{flagDuration}},
+		{"first network", anomalyRun{language: "python", network: true, duration: time.Second}, []string{flagFirstNetwork}},
+		{"detections", anomalyRun{language: "python", duration: time.Second, events: high}, []string{flagDetectionSpike}},
+		{"everything", anomalyRun{language: "bash", network: true, duration: 5 * time.Minute, events: high},
+			[]string{flagNewLanguage, flagFirstNetwork, flagDetectionSpike}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, _ := testAnomalyFlagger()
+			warm(t, a, "k", 20)
+			flags := a.observe("k", tc.run)
+			got := flagTypes(flags)
+			if len(got) != len(tc.want) {
+				t.Fatalf("flags = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("flags = %v, want %v", got, tc.want)
+				}
+			}
+			for _, f := range flags {
+				if f.Source != sandbox.SourceBaseline || f.Severity != "low" || f.Detail == "" {
+					t.Errorf("flag = %+v", f)
+				}
+			}
+		})
+	}
+
+	// Under min_outlier_duration nothing is an outlier, however many times
+	// the p95.
+	a, _ := testAnomalyFlagger()
+	for i := 0; i < 20; i++ {
+		a.observe("k", anomalyRun{language: "python", duration: 10 * time.Millisecond})
+	}
+	if flags := a.observe("k", anomalyRun{language: "python", duration: 9 * time.Second}); len(flags) > 0 {
+		t.Errorf("9s against a 10ms p95 flagged %v", flagTypes(flags))
+	}
+
+	// Once detections are usual for a caller they stop being a spike.
+	a, _ = testAnomalyFlagger()
+	for i := 0; i < 10; i++ {
+		a.observe("k", anomalyRun{language: "python", duration: time.Second, events: high})
+	}
+	if flags := a.observe("k", anomalyRun{language: "python", duration: time.Second, events: high}); len(flags) > 0 {
+		t.Errorf("a caller that always trips detections flagged %v", flagTypes(flags))
+	}
+}
+
+func TestAnomalyFlagger_ColdStart(t *testing.T) {
+	a, _ := testAnomalyFlagger()
+	warm(t, a, "k", 4)
+	if flags := a.observe("k", anomalyRun{language: "bash", network: true, duration: time.Hour}); len(flags) > 0 {
+		t.Errorf("fifth execution flagged %v, want none before min_executions", flagTypes(flags))
+	}
+	if flags := a.observe("k", anomalyRun{language: "ruby", duration: time.Second}); len(flags) != 1 || flags[0].Type != flagNewLanguage {
+		t.Errorf("sixth execution flagged %v, want new_language_for_key", flagTypes(flags))
+	}
+	// Each caller has its own history.
+	if flags := a.observe("other", anomalyRun{language: "ruby", network: true, duration: time.Hour}); len(flags) > 0 {
+		t.Errorf("another caller's first execution flagged %v", flagTypes(flags))
+	}
+}
+
+func TestFlagAnomalies(t *testing.T) {
+	h := newTestHandlers(nil)
+	h.anomalies, _ = testAnomalyFlagger()
+	warm(t, h.anomalies, "k", 10)
+
+	req := &sandbox.ExecutionRequest{Language: "bash"}
+	chaos := &sandbox.ExecutionResult{Chaos: true, Duration: time.Second}
+	h.flagAnomalies(req, "k", chaos)
+	if len(chaos.SecurityEvents) != 0 || h.anomalies.baselines["k"].Executions != 10 {
+		t.Errorf("a chaos run was flagged or learned from: %+v", chaos.SecurityEvents)
+	}
+
+	prior := sandbox.SecurityEvent{Type: "secret_access", Source: sandbox.SourceCode, Severity: "medium"}
+	result := &sandbox.ExecutionResult{Duration: time.Second, SecurityEvents: []sandbox.SecurityEvent{prior}}
+	h.flagAnomalies(req, "k", result)
+	if got := flagTypes(result.SecurityEvents); len(got) != 2 || got[0] != "secret_access" || got[1] != flagNewLanguage {
+		t.Errorf("security events = %v", got)
+	}
+}
+
+func TestAnomalyFlagger_Persistence(t *testing.T) {
+	a, now := testAnomalyFlagger()
+	warm(t, a, "k", 12)
+	a.observe("k", anomalyRun{language: "bash", network: true, duration: time.Second})
+	warm(t, a, "idle", 1)
+
+	saved := a.snapshot()
+	if len(saved) != 2 {
+		t.Fatalf("snapshot has %d baselines, want 2", len(saved))
+	}
+	if again := a.snapshot(); len(again) != 0 {
+		t.Errorf("second snapshot has %d baselines, want none unchanged since the first", len(again))
+	}
+
+	b, _ := testAnomalyFlagger()
+	b.restore(append(saved, storage.Baseline{Identity: "garbled", Stats: json.RawMessage(`{"executions":`)}))
+	if _, ok := b.baselines["garbled"]; ok {
+		t.Error("an unparseable baseline was restored")
+	}
+	got, want := b.baselines["k"], a.baselines["k"]
+	if got.Executions != want.Executions || got.NetworkUses != 1 || len(got.Languages) != 2 ||
+		len(got.Languages["python"].DurationsMS) != 12 || !got.LastSeen.Equal(want.LastSeen) {
+		t.Errorf("restored %+v, saved %+v", got, want)
+	}
+	// The restored history is warm: its usual run is quiet, a new one isn't.
+	if flags := b.observe("k", anomalyRun{language: "python", network: true, duration: time.Second}); len(flags) != 0 {
+		t.Errorf("restored caller flagged %v", flagTypes(flags))
+	}
+	if flags := b.observe("k", anomalyRun{language: "ruby", duration: time.Second}); len(flags) != 1 {
+		t.Errorf("restored caller's new language flagged %v", flagTypes(flags))
+	}
+
+	// A caller idle past the TTL is forgotten at the next snapshot.
+	*now = now.Add(baselineIdleTTL - time.Hour)
+	a.observe("k", anomalyRun{language: "python", duration: time.Second})
+	*now = now.Add(time.Hour)
+	if saved := a.snapshot(); len(saved) != 1 || saved[0].Identity != "k" {
+		t.Errorf("snapshot = %+v, want only the active caller", saved)
+	}
+	if _, ok := a.baselines["idle"]; ok {
+		t.Error("idle caller kept past the TTL")
+	}
+}
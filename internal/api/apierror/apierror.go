@@ -0,0 +1,249 @@
+// Package apierror defines the API's error codes and the single writer that
+// renders them. Every error response has the same JSON shape and carries the
+// request ID, whether it comes from a handler or from middleware.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Code is a stable, machine-readable error identifier. Clients may switch on
+// it; never rename or repurpose an existing code.
+type Code string
+
+const (
+	CodeInvalidRequest          Code = "INVALID_REQUEST"
+	CodeValidationError         Code = "VALIDATION_ERROR"
+	CodeMethodNotAllowed        Code = "METHOD_NOT_ALLOWED"
+	CodeAuthRequired            Code = "AUTH_REQUIRED"
+	CodeAdminRequired           Code = "ADMIN_REQUIRED"
+	CodeRateLimited             Code = "RATE_LIMITED"
+	CodeCostBudgetExceeded      Code = "COST_BUDGET_EXCEEDED"
+	CodeClaudeLimitReached      Code = "CLAUDE_LIMIT_REACHED"
+	CodeTaskLimitReached        Code = "TASK_LIMIT_REACHED"
+	CodeKeyConcurrencyLimit     Code = "KEY_CONCURRENCY_LIMIT"
+	CodeDailyQuotaExceeded      Code = "DAILY_QUOTA_EXCEEDED"
+	CodeCancellationGroupFull   Code = "CANCELLATION_GROUP_FULL"
+	CodeLanguageSaturated       Code = "LANGUAGE_SATURATED"
+	CodeProxyRateLimited        Code = "PROXY_RATE_LIMITED"
+	CodeWorkdirBusy             Code = "WORKDIR_BUSY"
+	CodeWorkdirTooLarge         Code = "WORKDIR_TOO_LARGE"
+	CodeWorkdirDirty            Code = "WORKDIR_DIRTY"
+	CodeSecurityBlocked         Code = "SECURITY_BLOCKED"
+	CodeSeccompNotApplied       Code = "SECCOMP_NOT_APPLIED"
+	CodeSeccompProfileTampered  Code = "SECCOMP_PROFILE_TAMPERED"
+	CodeCodeIntegrityFailure    Code = "CODE_INTEGRITY_FAILURE"
+	CodeLimitNotEnforced        Code = "LIMIT_NOT_ENFORCED"
+	CodeClaudeImageIncompatible Code = "CLAUDE_IMAGE_INCOMPATIBLE"
+	CodeImagePullSuspended      Code = "IMAGE_PULL_SUSPENDED"
+	CodeChaosDisabled           Code = "CHAOS_DISABLED"
+	CodeFeatureDisabled         Code = "FEATURE_DISABLED"
+	CodeNetworkDenied           Code = "NETWORK_DENIED"
+	CodeInvalidDeadline         Code = "INVALID_DEADLINE"
+	CodeClaudeTokenDisabled     Code = "CLAUDE_TOKEN_DISABLED"
+	CodeCredentialDenied        Code = "CREDENTIAL_DENIED"
+	CodeDebugTraceDenied        Code = "DEBUG_TRACE_DENIED"
+	CodeNotFound                Code = "NOT_FOUND"
+	CodeCodeUnavailable         Code = "CODE_UNAVAILABLE"
+	CodeCodeTooLarge            Code = "CODE_TOO_LARGE"
+	CodeUploadsDisabled         Code = "UPLOADS_DISABLED"
+	CodeDBUnavailable           Code = "DB_UNAVAILABLE"
+	CodeAuditUnavailable        Code = "AUDIT_UNAVAILABLE"
+	CodeRunnerUnavailable       Code = "RUNNER_UNAVAILABLE"
+	CodeStreamingUnsupported    Code = "STREAMING_UNSUPPORTED"
+	CodeUnknownStreamVersion    Code = "UNKNOWN_STREAM_VERSION"
+	CodeExecutionFailed         Code = "EXECUTION_FAILED"
+	CodeExecutionTimeout        Code = "EXECUTION_TIMEOUT"
+	CodeExecutionCancelled      Code = "EXECUTION_CANCELLED"
+	CodeExecutionKilled         Code = "EXECUTION_KILLED"
+	CodeSetupTimeout            Code = "SETUP_TIMEOUT"
+	CodeIdleOutputTimeout       Code = "IDLE_OUTPUT_TIMEOUT"
+	CodeInternal                Code = "INTERNAL"
+	CodeInvalidPath             Code = "INVALID_PATH"
+	CodeWorkspacesDisabled      Code = "WORKSPACES_DISABLED"
+	CodeWorkspaceNotFound       Code = "WORKSPACE_NOT_FOUND"
+	CodeWorkspaceTooLarge       Code = "WORKSPACE_TOO_LARGE"
+	CodeWorkspaceLimit          Code = "WORKSPACE_LIMIT"
+	CodeBundlesDisabled         Code = "BUNDLES_DISABLED"
+	CodeBundleNotFound          Code = "BUNDLE_NOT_FOUND"
+	CodeInvalidBundle           Code = "INVALID_BUNDLE"
+	CodeBundleTooLarge          Code = "BUNDLE_TOO_LARGE"
+	CodeBundleNotApproved       Code = "BUNDLE_NOT_APPROVED"
+	CodeBundleCorrupt           Code = "BUNDLE_CORRUPT"
+	CodeJobNotFound             Code = "JOB_NOT_FOUND"
+	CodeJobRunning              Code = "JOB_RUNNING"
+)
+
+type catalogEntry struct {
+	status      int
+	description string
+}
+
+var catalog = map[Code]catalogEntry{
+	CodeInvalidRequest:          {http.StatusBadRequest, "The request body or parameters are malformed or missing required fields."},
+	CodeValidationError:         {http.StatusBadRequest, "The sandbox rejected the execution request (limits, language, env vars, mounts)."},
+	CodeMethodNotAllowed:        {http.StatusMethodNotAllowed, "The endpoint does not support this HTTP method."},
+	CodeAuthRequired:            {http.StatusUnauthorized, "A valid API key is required (X-API-Key or Authorization: Bearer)."},
+	CodeAdminRequired:           {http.StatusForbidden, "The endpoint needs a caller listed in security.admin_keys."},
+	CodeRateLimited:             {http.StatusTooManyRequests, "Too many requests from this client; retry after the Retry-After delay."},
+	CodeCostBudgetExceeded:      {http.StatusTooManyRequests, "The caller has spent its hourly execution budget; details.reset_at says when the execution fits again."},
+	CodeClaudeLimitReached:      {http.StatusTooManyRequests, "The server is running its maximum number of claude sessions."},
+	CodeTaskLimitReached:        {http.StatusTooManyRequests, "The caller has run security.max_task_executions executions under this task_id; details.task_id names it."},
+	CodeKeyConcurrencyLimit:     {http.StatusTooManyRequests, "The caller has its max_concurrent executions running, from security.key_limits or default_key_limit."},
+	CodeDailyQuotaExceeded:      {http.StatusTooManyRequests, "The caller has run its daily_quota executions this UTC day, from security.key_limits or default_key_limit; details.reset_at is the next midnight UTC."},
+	CodeCancellationGroupFull:   {http.StatusTooManyRequests, "The caller has security.max_cancellation_group_size executions live under this cancellation_group; details.cancellation_group names it."},
+	CodeLanguageSaturated:       {http.StatusTooManyRequests, "Every concurrency slot for the language is busy; retry after the Retry-After delay."},
+	CodeProxyRateLimited:        {http.StatusTooManyRequests, "The auth proxy's requests-per-minute cap is reached; returned to claude inside the container."},
+	CodeWorkdirBusy:             {http.StatusConflict, "Another execution has the work_dir mounted read-write; details.exec_id names it."},
+	CodeWorkdirTooLarge:         {http.StatusRequestEntityTooLarge, "The claude work_dir is over sandbox.workdir_size; details has the files and bytes counted and the limits. partial means the walk stopped early and the counts are a lower bound."},
+	CodeWorkdirDirty:            {http.StatusConflict, "The claude work_dir is a git repository with uncommitted changes and security.claude_workdir_git is require_clean; details has its head, branch and the first paths changed."},
+	CodeSecurityBlocked:         {http.StatusForbidden, "The code matched a critical sandbox escape pattern and was not run."},
+	CodeSeccompNotApplied:       {http.StatusInternalServerError, "The container started without a seccomp filter, so the code was not run; check the container runtime."},
+	CodeSeccompProfileTampered:  {http.StatusInternalServerError, "The seccomp profile file the container would have run under had changed on disk since the server wrote it, so the code was not run; the file was rewritten, so a retry runs, but find out what changed it."},
+	CodeCodeIntegrityFailure:    {http.StatusInternalServerError, "The code file the container would have run didn't match the code the server hashed, so it was not run; retry, and check the host's temp dir and storage driver if it persists."},
+	CodeLimitNotEnforced:        {http.StatusServiceUnavailable, "The host doesn't enforce some of the resource limits the execution would run under, and sandbox.limit_enforcement is strict, so it was not run; details.not_enforced names the mechanisms."},
+	CodeClaudeImageIncompatible: {http.StatusServiceUnavailable, "The claude runtime image failed its contract check and can't run this request; details.missing lists what it lacks. Rebuild it from deployments/docker/Dockerfile.claude."},
+	CodeImagePullSuspended:      {http.StatusServiceUnavailable, "The runtime's disk is short of space, so executions whose image would have to be pulled are refused until image GC frees enough; images already present still run."},
+	CodeChaosDisabled:           {http.StatusBadRequest, "A chaos failure was requested but chaos mode is disabled on this server."},
+	CodeFeatureDisabled:         {http.StatusForbidden, "An operator has disabled the language or feature the request uses; details.kind and details.flag name it, details.message says why."},
+	CodeNetworkDenied:           {http.StatusForbidden, "security.network_policy doesn't allow claude a network and its claude setting is refuse; claude can't run without one."},
+	CodeInvalidDeadline:         {http.StatusBadRequest, "The deadline has passed or is further out than the language's maximum timeout; details.server_time is the server's clock, to check for skew against."},
+	CodeClaudeTokenDisabled:     {http.StatusBadRequest, "claude_token or claude_credential was sent but security.claude_tokens is disabled on this server."},
+	CodeCredentialDenied:        {http.StatusForbidden, "The claude_credential does not exist or the caller is not among its keys."},
+	CodeDebugTraceDenied:        {http.StatusForbidden, "debug_trace was asked for but the caller is not among sandbox.debug_trace.keys."},
+	CodeNotFound:                {http.StatusNotFound, "The requested execution or task does not exist."},
+	CodeCodeUnavailable:         {http.StatusForbidden, "include=code was asked for but the code can't be shown: the execution is another caller's, the caller is in security.privacy_mode, or database.store_code didn't keep it."},
+	CodeCodeTooLarge:            {http.StatusRequestEntityTooLarge, "The uploaded code exceeds sandbox.max_upload_code_bytes and was discarded unread, or an executable exceeds sandbox.binary.max_bytes; details.max_upload_code_bytes or details.max_binary_bytes is the cap."},
+	CodeUploadsDisabled:         {http.StatusNotFound, "POST /execute/upload is turned off on this server (sandbox.max_upload_code_bytes is 0)."},
+	CodeDBUnavailable:           {http.StatusServiceUnavailable, "The endpoint needs the database, which is not configured or unreachable."},
+	CodeAuditUnavailable:        {http.StatusServiceUnavailable, "database.required is set and the audit trail can't be sure to record the execution, so it was not run; details.reason is database (unreachable) or buffer (falling behind). Reads still work."},
+	CodeRunnerUnavailable:       {http.StatusServiceUnavailable, "No sandbox backend is available to run code."},
+	CodeStreamingUnsupported:    {http.StatusInternalServerError, "The connection does not support streaming responses."},
+	CodeUnknownStreamVersion:    {http.StatusNotAcceptable, "Accept-Stream-Version asked for a stream format version the server doesn't speak; details.supported lists the ones it does."},
+	CodeExecutionFailed:         {http.StatusInternalServerError, "The sandbox failed to run the code for an internal reason."},
+	CodeExecutionTimeout:        {http.StatusGatewayTimeout, "The execution timed out before producing a result."},
+	CodeExecutionCancelled:      {http.StatusConflict, "DELETE /cancellation-groups/{name} killed the execution before it finished; details.cancellation_group names the group."},
+	CodeExecutionKilled:         {http.StatusConflict, "DELETE /executions/{id} killed the execution before its container started; one killed while running returns its result with exit_class manual_kill."},
+	CodeSetupTimeout:            {http.StatusServiceUnavailable, "Pulling the image or creating the container took longer than sandbox.setup_timeout, so the code never ran; the request's timeout wasn't used, and a retry may succeed."},
+	CodeIdleOutputTimeout:       {http.StatusGatewayTimeout, "The execution wrote nothing for its idle_output_timeout, or a claude stream for sandbox.claude_idle_output_timeout, and was aborted; claude's is sent as a stream error event."},
+	CodeInternal:                {http.StatusInternalServerError, "An unexpected server error occurred."},
+	CodeInvalidPath:             {http.StatusBadRequest, "A workspace file path is absolute, contains .., or is otherwise invalid."},
+	CodeWorkspacesDisabled:      {http.StatusNotFound, "Workspaces are not enabled on this server."},
+	CodeWorkspaceNotFound:       {http.StatusNotFound, "The workspace or file does not exist, has expired, or belongs to another API key."},
+	CodeWorkspaceTooLarge:       {http.StatusRequestEntityTooLarge, "The upload exceeds the per-file or per-workspace size cap."},
+	CodeWorkspaceLimit:          {http.StatusInsufficientStorage, "The server has reached its maximum number of live workspaces."},
+	CodeBundlesDisabled:         {http.StatusNotFound, "Code bundles are not enabled on this server (sandbox.bundles.store is unset)."},
+	CodeBundleNotFound:          {http.StatusNotFound, "No bundle has the digest: it was never uploaded, or went unused past sandbox.bundles.ttl and was removed."},
+	CodeInvalidBundle:           {http.StatusBadRequest, "The upload is not a tar.gz of regular files and directories with relative paths and a manifest.json naming an entrypoint in it and a language that can run bundles."},
+	CodeBundleTooLarge:          {http.StatusRequestEntityTooLarge, "The upload, or its files once unpacked, exceeds sandbox.bundles.max_bytes or max_files."},
+	CodeBundleNotApproved:       {http.StatusForbidden, "sandbox.bundles.require_approval is set and the bundle has not been approved with POST /admin/bundles/{digest}."},
+	CodeBundleCorrupt:           {http.StatusInternalServerError, "The stored bundle no longer matches its digest, so it was not run; an operator should look into the store and the bundle be uploaded again."},
+	CodeJobNotFound:             {http.StatusNotFound, "No job in the server's jobs config has the name."},
+	CodeJobRunning:              {http.StatusConflict, "The job's last run hasn't finished; details.exec_id names it."},
+}
+
+// Status returns the HTTP status for the code, or 500 for an unknown code.
+func (c Code) Status() int {
+	if e, ok := catalog[c]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Known reports whether the code is in the catalog.
+func (c Code) Known() bool {
+	_, ok := catalog[c]
+	return ok
+}
+
+// CatalogEntry describes one code for GET /errors.
+type CatalogEntry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// Catalog returns every code, sorted.
+func Catalog() []CatalogEntry {
+	entries := make([]CatalogEntry, 0, len(catalog))
+	for code, e := range catalog {
+		entries = append(entries, CatalogEntry{Code: code, Status: e.status, Description: e.description})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Code < entries[j].Code })
+	return entries
+}
+
+// Error is an API error ready to be written.
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+	Details map[string]any
+}
+
+// New builds an error with the catalog status for code.
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Status: code.Status(), Message: msg}
+}
+
+// Newf is New with a formatted message.
+func Newf(code Code, format string, args ...any) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// WithDetails attaches structured details, returned under "details".
+func (e *Error) WithDetails(details map[string]any) *Error {
+	e.Details = details
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Response is the JSON body of every error response.
+type Response struct {
+	Error     string         `json:"error"`
+	Code      Code           `json:"code"`
+	RequestID string         `json:"request_id"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// WriteError renders e with the request ID from r's context.
+func WriteError(w http.ResponseWriter, r *http.Request, e *Error) {
+	if !e.Code.Known() {
+		log.Error().Str("code", string(e.Code)).Msg("uncataloged API error code")
+	}
+	resp := Response{
+		Error:     e.Message,
+		Code:      e.Code,
+		RequestID: RequestIDFromContext(r.Context()),
+		Details:   e.Details,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Msg("failed to encode error response")
+	}
+}
+
+type contextKey struct{}
+
+// ContextWithRequestID stores the request ID that WriteError reports.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID, or "" if none was set.
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// declaredCodes parses apierror.go and returns every constant of type Code,
+// keyed by identifier.
+func declaredCodes(t *testing.T) map[string]Code {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "apierror.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make(map[string]Code)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if ident, ok := vs.Type.(*ast.Ident); !ok || ident.Name != "Code" {
+				continue
+			}
+			for i, name := range vs.Names {
+				lit := vs.Values[i].(*ast.BasicLit)
+				v, _ := strconv.Unquote(lit.Value)
+				codes[name.Name] = Code(v)
+			}
+		}
+	}
+	return codes
+}
+
+func TestCatalog_CoversEveryCode(t *testing.T) {
+	codes := declaredCodes(t)
+	if len(codes) == 0 {
+		t.Fatal("found no Code constants")
+	}
+	for name, code := range codes {
+		if !code.Known() {
+			t.Errorf("%s (%s) is declared but missing from the catalog", name, code)
+		}
+	}
+	if len(codes) != len(Catalog()) {
+		t.Errorf("catalog has %d entries, %d codes declared", len(Catalog()), len(codes))
+	}
+}
+
+func TestCatalog_Entries(t *testing.T) {
+	entries := Catalog()
+	for i, e := range entries {
+		if e.Status < 400 || e.Status > 599 {
+			t.Errorf("%s: status %d is not an error status", e.Code, e.Status)
+		}
+		if e.Description == "" {
+			t.Errorf("%s: missing description", e.Code)
+		}
+		if i > 0 && entries[i-1].Code >= e.Code {
+			t.Errorf("catalog not sorted at %s", e.Code)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(ContextWithRequestID(req.Context(), "req-123"))
+	rec := httptest.NewRecorder()
+
+	WriteError(rec, req, New(CodeWorkspaceTooLarge, "too big").WithDetails(map[string]any{"limit": 10}))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != CodeWorkspaceTooLarge || resp.Error != "too big" || resp.RequestID != "req-123" {
+		t.Errorf("resp = %+v", resp)
+	}
+	if resp.Details["limit"] != float64(10) {
+		t.Errorf("details = %v", resp.Details)
+	}
+}
+
+func TestFromSandbox(t *testing.T) {
+	wrap := func(err error) error {
+		return &sandbox.ExecutionError{ExecID: "x", Op: "test", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{fmt.Errorf("%w: code is empty", sandbox.ErrInvalidRequest), CodeValidationError},
+		{sandbox.ErrUnsupportedLang, CodeValidationError},
+		{wrap(sandbox.ErrRateLimited), CodeRateLimited},
+		{wrap(&sandbox.SaturationError{Pool: "claude", RetryAfter: time.Minute}), CodeLanguageSaturated},
+		{wrap(&sandbox.WorkdirBusyError{Path: "/srv/project", Holder: "exec-1"}), CodeWorkdirBusy},
+		{wrap(&sandbox.WorkdirTooLargeError{Path: "/srv/monorepo", Size: sandbox.WorkdirSize{Files: 300000}, MaxFiles: 200000}), CodeWorkdirTooLarge},
+		{sandbox.ErrSecurityViolation, CodeSecurityBlocked},
+		{wrap(sandbox.ErrSeccompNotApplied), CodeSeccompNotApplied},
+		{wrap(sandbox.ErrSeccompProfileTampered), CodeSeccompProfileTampered},
+		{wrap(sandbox.ErrCodeIntegrity), CodeCodeIntegrityFailure},
+		{wrap(&sandbox.LimitNotEnforcedError{Gaps: []string{sandbox.LimitMemorySwap}}), CodeLimitNotEnforced},
+		{wrap(&sandbox.ClaudeContractError{Image: "sandbox-claude:latest", Missing: []string{"flag:--max-turns"}}), CodeClaudeImageIncompatible},
+		{wrap(fmt.Errorf("%w: python:3.12-slim is not present", sandbox.ErrImagePullSuspended)), CodeImagePullSuspended},
+		{sandbox.ErrTimeout, CodeExecutionTimeout},
+		{sandbox.ErrContainerdDown, CodeRunnerUnavailable},
+		{wrap(errors.New("pull failed: registry secret leaked")), CodeExecutionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			got := FromSandbox(tt.err)
+			if got.Code != tt.want {
+				t.Errorf("FromSandbox(%v) = %s, want %s", tt.err, got.Code, tt.want)
+			}
+			if got.Status != tt.want.Status() {
+				t.Errorf("status = %d, want %d", got.Status, tt.want.Status())
+			}
+		})
+	}
+
+	if busy := FromSandbox(wrap(&sandbox.WorkdirBusyError{Holder: "exec-1"})); busy.Details["exec_id"] != "exec-1" {
+		t.Errorf("WORKDIR_BUSY details = %v, want the holding exec_id", busy.Details)
+	}
+	large := FromSandbox(wrap(&sandbox.WorkdirTooLargeError{Size: sandbox.WorkdirSize{Files: 10, Bytes: 6 << 30}, MaxBytes: 5 << 30}))
+	if large.Details["bytes"] != int64(6<<30) || large.Details["max_bytes"] != int64(5<<30) {
+		t.Errorf("WORKDIR_TOO_LARGE details = %v, want the measured size and the limit", large.Details)
+	}
+
+	incompatible := FromSandbox(wrap(&sandbox.ClaudeContractError{Image: "sandbox-claude:latest", Missing: []string{"env:ANTHROPIC_BASE_URL"}}))
+	if missing, _ := incompatible.Details["missing"].([]string); len(missing) != 1 || missing[0] != "env:ANTHROPIC_BASE_URL" {
+		t.Errorf("CLAUDE_IMAGE_INCOMPATIBLE details = %v, want what the image is missing", incompatible.Details)
+	}
+
+	unenforced := FromSandbox(wrap(&sandbox.LimitNotEnforcedError{Gaps: []string{sandbox.LimitMemorySwap, sandbox.LimitPids}}))
+	if gaps, _ := unenforced.Details["not_enforced"].([]string); len(gaps) != 2 || gaps[1] != sandbox.LimitPids {
+		t.Errorf("LIMIT_NOT_ENFORCED details = %v, want the mechanisms", unenforced.Details)
+	}
+
+	if msg := FromSandbox(errors.New("internal detail")).Message; msg != "execution failed" {
+		t.Errorf("unmapped error leaked message %q", msg)
+	}
+}
@@ -0,0 +1,92 @@
+package apierror
+
+import (
+	"errors"
+	"strings"
+
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// FromSandbox maps an error returned by a backend without a result to an API
+// error. Validation errors keep their message; anything unrecognised becomes
+// a generic EXECUTION_FAILED so internals don't leak to callers.
+func FromSandbox(err error) *Error {
+	switch {
+	case errors.Is(err, sandbox.ErrInvalidRequest), errors.Is(err, sandbox.ErrUnsupportedLang):
+		return New(CodeValidationError, err.Error())
+	case errors.Is(err, sandbox.ErrRateLimited):
+		return New(CodeRateLimited, "rate limit exceeded")
+	case errors.Is(err, sandbox.ErrLanguageSaturated):
+		var sat *sandbox.SaturationError
+		if errors.As(err, &sat) {
+			return Newf(CodeLanguageSaturated, "all %s slots are busy", sat.Pool).
+				WithDetails(map[string]any{"pool": sat.Pool})
+		}
+		return New(CodeLanguageSaturated, "all slots for the language are busy")
+	case errors.Is(err, sandbox.ErrWorkdirBusy):
+		var busy *sandbox.WorkdirBusyError
+		if errors.As(err, &busy) {
+			return Newf(CodeWorkdirBusy, "work_dir is in use by execution %s", busy.Holder).
+				WithDetails(map[string]any{"exec_id": busy.Holder})
+		}
+		return New(CodeWorkdirBusy, "work_dir is in use by another execution")
+	case errors.Is(err, sandbox.ErrWorkdirTooLarge):
+		var large *sandbox.WorkdirTooLargeError
+		if errors.As(err, &large) {
+			return Newf(CodeWorkdirTooLarge, "work_dir holds %d files, %d bytes", large.Size.Files, large.Size.Bytes).
+				WithDetails(map[string]any{
+					"files":     large.Size.Files,
+					"bytes":     large.Size.Bytes,
+					"partial":   large.Size.Partial,
+					"max_files": large.MaxFiles,
+					"max_bytes": large.MaxBytes,
+				})
+		}
+		return New(CodeWorkdirTooLarge, "work_dir is too large")
+	case errors.Is(err, sandbox.ErrWorkdirDirty):
+		var dirty *sandbox.WorkdirDirtyError
+		if errors.As(err, &dirty) {
+			return Newf(CodeWorkdirDirty, "work_dir has uncommitted changes: %s", strings.Join(dirty.Git.Changed, ", ")).
+				WithDetails(map[string]any{
+					"head":    dirty.Git.Head,
+					"branch":  dirty.Git.Branch,
+					"changed": dirty.Git.Changed,
+				})
+		}
+		return New(CodeWorkdirDirty, "work_dir has uncommitted changes")
+	case errors.Is(err, sandbox.ErrSecurityViolation):
+		return New(CodeSecurityBlocked, "request blocked by security policy")
+	case errors.Is(err, sandbox.ErrSeccompNotApplied):
+		return New(CodeSeccompNotApplied, "container started without a seccomp filter; code was not run")
+	case errors.Is(err, sandbox.ErrSeccompProfileTampered):
+		return New(CodeSeccompProfileTampered, "seccomp profile file failed verification; code was not run")
+	case errors.Is(err, sandbox.ErrCodeIntegrity):
+		return New(CodeCodeIntegrityFailure, "code file failed its integrity check; code was not run")
+	case errors.Is(err, sandbox.ErrLimitNotEnforced):
+		var gaps *sandbox.LimitNotEnforcedError
+		if errors.As(err, &gaps) {
+			return Newf(CodeLimitNotEnforced, "the host doesn't enforce the %s limits; code was not run", strings.Join(gaps.Gaps, ", ")).
+				WithDetails(map[string]any{"not_enforced": gaps.Gaps})
+		}
+		return New(CodeLimitNotEnforced, "the host doesn't enforce the execution's limits; code was not run")
+	case errors.Is(err, sandbox.ErrClaudeImageIncompatible):
+		var ce *sandbox.ClaudeContractError
+		if errors.As(err, &ce) {
+			return Newf(CodeClaudeImageIncompatible, "claude image %s is incompatible with this server", ce.Image).
+				WithDetails(map[string]any{"image": ce.Image, "missing": ce.Missing})
+		}
+		return New(CodeClaudeImageIncompatible, "claude image is incompatible with this server")
+	case errors.Is(err, sandbox.ErrImagePullSuspended):
+		return New(CodeImagePullSuspended, "the runtime is low on disk space and the image would have to be pulled")
+	case errors.Is(err, sandbox.ErrIdleTimeout):
+		return New(CodeIdleOutputTimeout, "execution wrote no output for its idle_output_timeout")
+	case errors.Is(err, sandbox.ErrSetupTimeout):
+		return New(CodeSetupTimeout, "the sandbox took too long to pull the image or create the container; the code did not run")
+	case errors.Is(err, sandbox.ErrTimeout):
+		return New(CodeExecutionTimeout, "execution timed out")
+	case errors.Is(err, sandbox.ErrContainerdDown), errors.Is(err, sandbox.ErrPoolExhausted):
+		return New(CodeRunnerUnavailable, "sandbox backend unavailable")
+	default:
+		return New(CodeExecutionFailed, "execution failed")
+	}
+}
@@ -0,0 +1,58 @@
+package apierror
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Throttle scopes: which limit a 429 ran into.
+const (
+	ScopeIP       = "ip"       // security.rate_limit_rps, per IP or client certificate
+	ScopeKey      = "key"      // the API key's security.cost_budget
+	ScopeClaude   = "claude"   // security.max_concurrent_claude
+	ScopeLanguage = "language" // a language's sandbox.concurrency pool
+	ScopeProxy    = "proxy"    // auth_proxy.max_proxy_rpm
+	ScopeTask     = "task"     // security.max_task_executions
+
+	ScopeCancellationGroup = "cancellation_group" // security.max_cancellation_group_size
+
+	// The caller's security.key_limits entry, or default_key_limit.
+	ScopeKeyRate        = "key_rate"        // its rps, with security.rate_limit_by: key
+	ScopeKeyConcurrency = "key_concurrency" // its max_concurrent
+	ScopeKeyQuota       = "key_quota"       // its daily_quota
+)
+
+// minRetryAfter is the shortest delay a 429 asks for; Retry-After can't say
+// less than a second anyway.
+const minRetryAfter = time.Second
+
+// Throttle is what every 429 tells the client about the limit it hit, so
+// one backoff policy works for all of them. Limit and Remaining are in the
+// limit's own unit: requests, sessions, slots or cost.
+type Throttle struct {
+	Scope      string
+	RetryAfter time.Duration // from the limiter's state; at least minRetryAfter
+	Limit      float64
+	Remaining  float64
+}
+
+// WriteThrottled writes e, a 429, with t's delay in the Retry-After header,
+// in whole seconds rounded up, and t in e's details as scope,
+// retry_after_ms, limit and remaining, beside whatever they already hold.
+func WriteThrottled(w http.ResponseWriter, r *http.Request, e *Error, t Throttle) {
+	retryAfter := max(t.RetryAfter, minRetryAfter).Round(time.Millisecond)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	details := maps.Clone(e.Details)
+	if details == nil {
+		details = make(map[string]any, 4)
+	}
+	details["scope"] = t.Scope
+	details["retry_after_ms"] = retryAfter.Milliseconds()
+	details["limit"] = t.Limit
+	details["remaining"] = t.Remaining
+	WriteError(w, r, e.WithDetails(details))
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/state"
+)
+
+// contextKeyAsyncStarted carries the func(execID string) that an
+// asynchronous execution's startExecution calls once it is registered.
+const contextKeyAsyncStarted contextKey = "async_started"
+
+// defaultAsyncJobTTL is server.async_job_ttl's default, which NewHandlers
+// keeps until NewServer applies the config.
+const defaultAsyncJobTTL = time.Hour
+
+// asyncExecutions tracks the executions run asynchronously: the ones still
+// going, for shutdown to wait for, and for server.async_job_ttl after one
+// ends, the error it ended with when it has no record to serve instead.
+type asyncExecutions struct {
+	ttl time.Duration
+	now func() time.Time
+	wg  sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*asyncJob
+}
+
+type asyncJob struct {
+	owner  string
+	ended  time.Time // zero while it runs
+	status int       // the status its handler answered with, once ended
+	body   []byte    // the error it answered with; nil for a result
+}
+
+func newAsyncExecutions(ttl time.Duration) *asyncExecutions {
+	return &asyncExecutions{ttl: ttl, now: time.Now, jobs: make(map[string]*asyncJob)}
+}
+
+// begin records id as running for owner, and forgets the jobs that ended
+// more than ttl ago.
+func (a *asyncExecutions) begin(id, owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for jobID, job := range a.jobs {
+		if !job.ended.IsZero() && now.Sub(job.ended) > a.ttl {
+			delete(a.jobs, jobID)
+		}
+	}
+	a.jobs[id] = &asyncJob{owner: owner}
+}
+
+// end records what id's handler answered. A result isn't kept: its record
+// is what GET /executions/{id} serves.
+func (a *asyncExecutions) end(id string, resp *asyncResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	job, ok := a.jobs[id]
+	if !ok {
+		return
+	}
+	job.ended, job.status = a.now(), resp.statusCode()
+	if job.status != http.StatusOK {
+		job.body = bytes.Clone(resp.body.Bytes())
+	}
+}
+
+// get returns owner's job id, unless it ended more than ttl ago. A nil
+// *asyncExecutions has no jobs.
+func (a *asyncExecutions) get(id, owner string) (asyncJob, bool) {
+	if a == nil {
+		return asyncJob{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	job, ok := a.jobs[id]
+	if !ok || job.owner != owner || (!job.ended.IsZero() && a.now().Sub(job.ended) > a.ttl) {
+		return asyncJob{}, false
+	}
+	return *job, true
+}
+
+// wait waits for the running jobs to end, or for ctx to be done.
+func (a *asyncExecutions) wait(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// asyncResponse is what an asynchronous execution's handler writes: passed
+// on to the client when the execution is refused before it is queued, and
+// kept as its job's error when it ends without a result. Once it has
+// started, a result's body is dropped as it is written.
+type asyncResponse struct {
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	started bool
+}
+
+func (a *asyncResponse) Header() http.Header { return a.header }
+
+func (a *asyncResponse) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+}
+
+func (a *asyncResponse) Write(p []byte) (int, error) {
+	a.WriteHeader(http.StatusOK)
+	if a.started && a.status == http.StatusOK {
+		return len(p), nil
+	}
+	return a.body.Write(p)
+}
+
+func (a *asyncResponse) statusCode() int {
+	if a.status == 0 {
+		return http.StatusOK
+	}
+	return a.status
+}
+
+// replay writes the response to w.
+func (a *asyncResponse) replay(w http.ResponseWriter) {
+	for k, v := range a.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(a.statusCode())
+	w.Write(a.body.Bytes()) // #nosec G104 -- the client hung up; nothing to do
+}
+
+// asyncQuery reports whether r asks for an asynchronous execution with
+// ?async=true.
+func asyncQuery(r *http.Request) bool {
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+	return async
+}
+
+// asyncStarted tells an asynchronous execution's handler, if r is one's,
+// that it is registered as id.
+func asyncStarted(r *http.Request, id string) {
+	if started, ok := r.Context().Value(contextKeyAsyncStarted).(func(string)); ok {
+		started(id)
+	}
+}
+
+// HandleExecuteAsync serves POST /execute/async, which is POST /execute
+// with "async": true.
+func (h *Handlers) HandleExecuteAsync(w http.ResponseWriter, r *http.Request) {
+	var req ExecutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	h.executeAsync(w, r, req)
+}
+
+// executeAsync runs req as POST /execute would, but apart from r: it
+// answers 202 with the execution's ID once it is queued, and the execution
+// carries on after the client has gone. A request refused before then gets
+// its refusal. The caller's claude session, if it is one, is held until the
+// execution ends rather than until this returns.
+func (h *Handlers) executeAsync(w http.ResponseWriter, r *http.Request, req ExecutionRequest) {
+	if !h.requireCapability(w, r, "async") {
+		return
+	}
+	req.Async = true
+
+	var id string
+	resp := &asyncResponse{header: make(http.Header)}
+	started, done := make(chan struct{}), make(chan struct{})
+	owner := workspaceOwner(r)
+	ctx := context.WithoutCancel(r.Context())
+	// The connection's write deadline is nothing to the execution now.
+	ctx = context.WithValue(ctx, contextKeyWriteDeadline, nil)
+	ctx = context.WithValue(ctx, contextKeyAsyncStarted, func(execID string) {
+		id, resp.started = execID, true
+		h.async.begin(id, owner)
+		close(started)
+	})
+	run := r.Clone(ctx)
+	leave := takeClaudeSlot(r.Context())
+
+	h.async.wg.Add(1)
+	go func() {
+		defer h.async.wg.Done()
+		defer leave()
+		defer close(done)
+		h.executeRequest(resp, run, req)
+		if id != "" {
+			h.async.end(id, resp)
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-done:
+		select {
+		case <-started: // it has already ended; GET /executions/{id} has how
+		default:
+			resp.replay(w)
+			return
+		}
+	}
+	log.Info().Str("exec_id", id).Str("language", req.Language).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("asynchronous execution queued")
+	writeJSON(w, http.StatusAccepted, AsyncExecutionResponse{ID: id, Status: string(state.Queued)})
+}
+
+// writeUnrecorded answers GET /executions/{id} for an execution with no
+// record: its state while it is queued or running, the error an
+// asynchronous one ended with, or not found. An asynchronous execution
+// with a result is running until its record can be read: the audit writer
+// batches its writes, and the result isn't kept.
+func (h *Handlers) writeUnrecorded(w http.ResponseWriter, r *http.Request, id string) {
+	owner := workspaceOwner(r)
+	job, isJob := h.async.get(id, owner)
+	if isJob && !job.ended.IsZero() && job.status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(job.status)
+		w.Write(job.body) // #nosec G104 -- the client hung up; nothing to do
+		return
+	}
+	if p, ok := h.executions.get(id, owner); ok && (p.State == string(state.Queued) || p.State == string(state.Running)) {
+		writeJSON(w, http.StatusOK, AsyncExecutionResponse{ID: id, Status: p.State})
+		return
+	}
+	if isJob && (job.ended.IsZero() || job.status == http.StatusOK) {
+		writeJSON(w, http.StatusOK, AsyncExecutionResponse{ID: id, Status: string(state.Running)})
+		return
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "execution not found"))
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+)
+
+func newAsyncHandlers(backend *groupBackend) *Handlers {
+	h := newTestHandlers(backend)
+	h.async = newAsyncExecutions(time.Hour)
+	h.retainExecutions(1000)
+	return h
+}
+
+func decodeAccepted(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var accepted AsyncExecutionResponse
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil || accepted.Status != "queued" || !validUUID.MatchString(accepted.ID) {
+		t.Fatalf("accepted %+v, %v", accepted, err)
+	}
+	return accepted.ID
+}
+
+func TestDefaultAsyncJobTTL(t *testing.T) {
+	if got := config.DefaultConfig().Server.AsyncJobTTL; got != defaultAsyncJobTTL {
+		t.Errorf("server.async_job_ttl defaults to %s, NewHandlers to %s", got, defaultAsyncJobTTL)
+	}
+}
+
+func TestExecuteAsync(t *testing.T) {
+	backend := &groupBackend{started: make(chan string, 1)}
+	h := newAsyncHandlers(backend)
+
+	// The client hangs up as soon as it has the ID.
+	ctx, hangUp := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/execute?async=true", strings.NewReader(`{"language":"python","code":"sleep"}`))
+	rec := httptest.NewRecorder()
+	h.HandleExecute(rec, req.WithContext(ctx))
+	hangUp()
+	id := decodeAccepted(t, rec)
+	<-backend.started
+
+	if got := getExecution(t, h, "/executions/"+id); got.ID != id || got.Status != state.Running {
+		t.Errorf("while running: %+v", got)
+	}
+	if code, _ := killExecution(t, h, "", id); code != http.StatusAccepted {
+		t.Fatalf("kill: status %d", code)
+	}
+	if err := h.async.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := getExecution(t, h, "/executions/"+id); got.Status != state.Killed || got.Source != "memory" {
+		t.Errorf("once killed: %+v", got)
+	}
+}
+
+func TestExecuteAsync_Ended(t *testing.T) {
+	t.Run("completed", func(t *testing.T) {
+		h := newAsyncHandlers(&groupBackend{})
+		id := decodeAccepted(t, postAs(t, h.HandleExecuteAsync, "", ExecutionRequest{Language: "python", Code: "print(1)"}))
+		h.async.wait(context.Background())
+		if got := getExecution(t, h, "/executions/"+id); got.Status != state.Completed {
+			t.Errorf("record %+v", got)
+		}
+	})
+
+	t.Run("completed, its record not yet written", func(t *testing.T) {
+		h := newAsyncHandlers(&groupBackend{})
+		written := false
+		retained := h.getExecution
+		h.getExecution = func(ctx context.Context, id string, withCode bool) (*storage.Execution, error) {
+			if !written {
+				return nil, errors.New("still in the audit writer's batch")
+			}
+			return retained(ctx, id, withCode)
+		}
+		id := decodeAccepted(t, postAs(t, h.HandleExecuteAsync, "", ExecutionRequest{Language: "python", Code: "print(1)"}))
+		h.async.wait(context.Background())
+		if got := getExecution(t, h, "/executions/"+id); got.Status != state.Running {
+			t.Errorf("before its record: %+v", got)
+		}
+		written = true
+		if got := getExecution(t, h, "/executions/"+id); got.Status != state.Completed {
+			t.Errorf("once recorded: %+v", got)
+		}
+	})
+
+	t.Run("refused before it is queued", func(t *testing.T) {
+		h := newAsyncHandlers(&groupBackend{})
+		rec := postAs(t, h.HandleExecute, "", ExecutionRequest{Language: "python", Async: true})
+		if resp := decodeError(t, rec); rec.Code != http.StatusBadRequest || resp.Error != "code is required" {
+			t.Errorf("status %d %+v", rec.Code, resp)
+		}
+	})
+
+	t.Run("without a result", func(t *testing.T) {
+		backend := &groupBackend{started: make(chan string, 1)}
+		h := newAsyncHandlers(backend)
+		id := decodeAccepted(t, postAs(t, h.HandleExecuteAsync, "", ExecutionRequest{Language: "python", Code: "wait"}))
+		<-backend.started
+		if got := getExecution(t, h, "/executions/"+id); got.Status != state.Queued {
+			t.Errorf("while queued: %+v", got)
+		}
+		killExecution(t, h, "", id)
+		h.async.wait(context.Background())
+
+		get := func() *httptest.ResponseRecorder {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /executions/{id}", h.HandleGetExecution)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/executions/"+id, nil))
+			return rec
+		}
+		rec := get()
+		if resp := decodeError(t, rec); rec.Code != http.StatusConflict || resp.Code != "EXECUTION_KILLED" {
+			t.Errorf("ended: status %d %+v", rec.Code, resp)
+		}
+		h.async.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		if rec := get(); rec.Code != http.StatusNotFound {
+			t.Errorf("after the TTL: status %d", rec.Code)
+		}
+	})
+}
+
+func TestExecuteStream_RefusesAsync(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x"}}
+	h := newTestHandlers(backend)
+	h.async = newAsyncExecutions(time.Hour)
+	for _, target := range []string{"/execute/stream?async=true", "/execute/stream"} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"language":"python","code":"print(1)","async":true}`))
+		rec := httptest.NewRecorder()
+		h.HandleExecuteStream(rec, req)
+		if resp := decodeError(t, rec); rec.Code != http.StatusBadRequest || resp.Code != "INVALID_REQUEST" || !strings.Contains(resp.Error, "/execute/async") {
+			t.Errorf("%s: status %d %+v", target, rec.Code, resp)
+		}
+	}
+	if backend.req.Language != "" {
+		t.Error("an async stream reached the backend")
+	}
+}
+
+func TestConcurrentClaude_AsyncTakesSlot(t *testing.T) {
+	var leave func()
+	handler := concurrentClaude(newClaudeGate(1), nil)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		leave = takeClaudeSlot(r.Context())
+	}))
+	send := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/execute/async", strings.NewReader(`{"language":"claude","code":"Say hi."}`)))
+		return rec.Code
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first: status %d", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Errorf("while the first runs on: status %d", code)
+	}
+	leave()
+	if code := send(); code != http.StatusOK {
+		t.Errorf("once it has ended: status %d", code)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+)
+
+const (
+	auditCheckInterval = time.Second // how long a database health check stands for
+	auditPingTimeout   = time.Second
+	auditFailures      = 2   // failed checks in a row that start the refusals
+	auditRecoveries    = 3   // good checks in a row that end them
+	auditBufferHigh    = 0.9 // audit buffer fill that starts the refusals
+	auditBufferLow     = 0.5 // and that ends them
+)
+
+// Why executions are refused under database.required, the reason label of
+// sandbox_audit_refusals_total.
+const (
+	auditReasonDatabase = "database"
+	auditReasonBuffer   = "buffer"
+)
+
+// auditGate holds executions back, under database.required, while the
+// audit trail can't be sure to record them. The database's health is
+// checked at most once per auditCheckInterval, and both signals have
+// hysteresis so a blip doesn't turn the refusals on and off.
+type auditGate struct {
+	ping func(ctx context.Context) bool // nil without a postgres sink
+	fill func() float64                 // the fullest audit buffer, 0 to 1; nil without a writer
+	now  func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	streak  int // checks in a row that disagreed with dbDown
+	dbDown  bool
+	full    bool
+}
+
+func newAuditGate(ping func(ctx context.Context) bool, fill func() float64) *auditGate {
+	return &auditGate{ping: ping, fill: fill, now: time.Now}
+}
+
+// refusal returns why executions are refused, auditReasonDatabase or
+// auditReasonBuffer, or "" when they may run. A nil gate never refuses.
+func (g *auditGate) refusal() string {
+	if g == nil {
+		return ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now := g.now(); g.ping != nil && now.Sub(g.checked) >= auditCheckInterval {
+		g.checked = now
+		ctx, cancel := context.WithTimeout(context.Background(), auditPingTimeout)
+		healthy := g.ping(ctx)
+		cancel()
+		g.streak++
+		if healthy != g.dbDown {
+			g.streak = 0
+		}
+		if (g.dbDown && g.streak >= auditRecoveries) || (!g.dbDown && g.streak >= auditFailures) {
+			g.dbDown, g.streak = !g.dbDown, 0
+			if g.dbDown {
+				log.Error().Msg("audit database unreachable; refusing executions (database.required)")
+			} else {
+				log.Info().Msg("audit database reachable again; accepting executions")
+			}
+		}
+	}
+	if g.fill != nil {
+		switch fill := g.fill(); {
+		case !g.full && fill >= auditBufferHigh:
+			g.full = true
+			log.Error().Float64("fill", fill).Msg("audit buffer nearly full; refusing executions (database.required)")
+		case g.full && fill <= auditBufferLow:
+			g.full = false
+			log.Info().Float64("fill", fill).Msg("audit buffer drained; accepting executions")
+		}
+	}
+	switch {
+	case g.dbDown:
+		return auditReasonDatabase
+	case g.full:
+		return auditReasonBuffer
+	}
+	return ""
+}
+
+// checkAudit refuses the execution with AUDIT_UNAVAILABLE while the audit
+// gate holds executions back.
+func (h *Handlers) checkAudit(w http.ResponseWriter, r *http.Request) bool {
+	reason := h.audit.refusal()
+	if reason == "" {
+		return true
+	}
+	h.metrics.AuditRefused(reason)
+	msg := "the audit database is unreachable, and database.required keeps executions from running unrecorded"
+	if reason == auditReasonBuffer {
+		msg = "the audit log is too far behind to be sure of recording the execution, and database.required keeps executions from running unrecorded"
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeAuditUnavailable, msg).WithDetails(map[string]any{"reason": reason}))
+	return false
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// fakeAudit is a database health and buffer fill for an auditGate to read,
+// on a clock the test moves.
+type fakeAudit struct {
+	healthy bool
+	fill    float64
+	pings   int
+	clock   *testClock
+}
+
+func newFakeAudit() (*fakeAudit, *auditGate) {
+	f := &fakeAudit{healthy: true, clock: &testClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}}
+	g := newAuditGate(func(context.Context) bool { f.pings++; return f.healthy }, func() float64 { return f.fill })
+	g.now = f.clock.now
+	return f, g
+}
+
+func TestAuditGate_DatabaseHysteresis(t *testing.T) {
+	f, g := newFakeAudit()
+	steps := []struct {
+		healthy bool
+		advance time.Duration
+		want    string
+	}{
+		{true, 0, ""},
+		// One failed check is a blip; the second in a row starts the refusals.
+		{false, time.Second, ""},
+		{false, 500 * time.Millisecond, ""}, // the last check still stands
+		{false, 500 * time.Millisecond, auditReasonDatabase},
+		{true, time.Second, auditReasonDatabase},
+		{false, time.Second, auditReasonDatabase},
+		// It takes three good checks in a row to end them.
+		{true, time.Second, auditReasonDatabase},
+		{true, time.Second, auditReasonDatabase},
+		{true, time.Second, ""},
+		{false, time.Second, ""},
+		{true, time.Second, ""},
+	}
+	for i, s := range steps {
+		f.healthy = s.healthy
+		f.clock.advance(s.advance)
+		if got := g.refusal(); got != s.want {
+			t.Errorf("step %d (healthy %v): refusal %q, want %q", i, s.healthy, got, s.want)
+		}
+	}
+	if f.pings != len(steps)-1 {
+		t.Errorf("%d pings for %d steps, one within the check interval", f.pings, len(steps))
+	}
+}
+
+func TestAuditGate_BufferHysteresis(t *testing.T) {
+	f, g := newFakeAudit()
+	for i, s := range []struct {
+		fill float64
+		want string
+	}{
+		{0.5, ""},
+		{0.89, ""},
+		{0.9, auditReasonBuffer},
+		{0.7, auditReasonBuffer},
+		{0.51, auditReasonBuffer},
+		{0.5, ""},
+		{0.8, ""},
+	} {
+		f.fill = s.fill
+		if got := g.refusal(); got != s.want {
+			t.Errorf("step %d (fill %g): refusal %q, want %q", i, s.fill, got, s.want)
+		}
+	}
+
+	var none *auditGate
+	if got := none.refusal(); got != "" {
+		t.Errorf("without database.required: refusal %q", got)
+	}
+}
+
+func TestHandleExecute_AuditRequired(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"key"}
+	s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, nil, nil, monitor.NewMetrics())
+	handler := s.httpServer.Handler
+	execute := func(path string) *http.Response {
+		return doRequest(handler, http.MethodPost, path, "key", strings.NewReader(`{"language":"python","code":"print(1)"}`)).Result()
+	}
+	health := func() (int, HealthResponse) {
+		rec := doRequest(handler, http.MethodGet, "/health", "", nil)
+		var resp HealthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	// Without database.required nothing changes.
+	if resp := execute("/execute"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("not required: status %d", resp.StatusCode)
+	}
+	if code, resp := health(); code != http.StatusOK || resp.Status != "ok" || resp.Audit != nil {
+		t.Errorf("not required: health %d %+v", code, resp)
+	}
+
+	f, g := newFakeAudit()
+	s.handlers.audit = g
+	f.fill = 0.95
+	for _, path := range []string{"/execute", "/execute/stream"} {
+		resp := execute(path)
+		var body apierror.Response
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || body.Code != apierror.CodeAuditUnavailable || body.Details["reason"] != auditReasonBuffer {
+			t.Errorf("%s: status %d, %+v", path, resp.StatusCode, body)
+		}
+	}
+	if got := metricValue(t, s.handlers.metrics.AuditRefusals.WithLabelValues(auditReasonBuffer)); got != 2 {
+		t.Errorf("audit_refusals{buffer} = %g, want 2", got)
+	}
+	if code, resp := health(); code != http.StatusServiceUnavailable || resp.Status != "refusing" || resp.Audit == nil || resp.Audit.Reason != auditReasonBuffer {
+		t.Errorf("refusing: health %d %+v", code, resp)
+	}
+
+	// Reads don't go through the gate.
+	if rec := doRequest(handler, http.MethodGet, "/capabilities", "key", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /capabilities while refusing: status %d", rec.Code)
+	}
+
+	f.fill = 0.2
+	if resp := execute("/execute"); resp.StatusCode != http.StatusOK {
+		t.Errorf("drained: status %d", resp.StatusCode)
+	}
+	if code, resp := health(); code != http.StatusOK || resp.Audit == nil || resp.Audit.Refusing {
+		t.Errorf("drained: health %d %+v", code, resp)
+	}
+}
+
+func TestRunJob_AuditRequired(t *testing.T) {
+	s := NewServer(jobConfig(t), &mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}, nil, nil, monitor.NewMetrics())
+	if err := s.StartJobs(); err != nil {
+		t.Fatal(err)
+	}
+	f, g := newFakeAudit()
+	s.handlers.audit = g
+	f.healthy = false
+	for range auditFailures {
+		f.clock.advance(auditCheckInterval)
+		g.refusal()
+	}
+
+	rec := doRequest(s.Handler(), http.MethodPost, "/admin/jobs/vacuum/run", canaryAdminKey, nil)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), string(apierror.CodeAuditUnavailable)) {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(s.handlers.jobs.running) != 0 {
+		t.Errorf("a run started: %v", s.handlers.jobs.running)
+	}
+}
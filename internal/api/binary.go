@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"safe-agent-sandbox/internal/api/apierror"
+)
+
+const (
+	// binaryLanguage is the runtime whose code is an executable. Requests
+	// send it base64-encoded in code, or as is in an upload's code part.
+	binaryLanguage = "binary"
+	// analysisNotApplicable is an execution's analysis when its code is an
+	// executable, which the escape detector can't read.
+	analysisNotApplicable = "not_applicable"
+)
+
+// analysisOf is the analysis a response reports for language: empty when
+// the escape detector read the code, analysisNotApplicable when it can't.
+func analysisOf(language string) string {
+	if language == binaryLanguage {
+		return analysisNotApplicable
+	}
+	return ""
+}
+
+// executable returns the code the runner gets for req: a binary
+// execution's base64 decoded into the executable, and any other's as it
+// is. It writes the error response and returns false when the code isn't
+// base64 or decodes to more than sandbox.binary.max_bytes.
+func (h *Handlers) executable(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (string, bool) {
+	if req.Language != binaryLanguage || req.Code == "" {
+		return req.Code, true
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(req.Code))) > h.binaryMaxBytes+2 {
+		h.writeExecutableTooLarge(w, r)
+		return "", false
+	}
+	exe, err := base64.StdEncoding.DecodeString(req.Code)
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "code must be the executable, base64-encoded, for language binary"))
+		return "", false
+	}
+	if int64(len(exe)) > h.binaryMaxBytes {
+		h.writeExecutableTooLarge(w, r)
+		return "", false
+	}
+	return string(exe), true
+}
+
+func (h *Handlers) writeExecutableTooLarge(w http.ResponseWriter, r *http.Request) {
+	apierror.WriteError(w, r, apierror.Newf(apierror.CodeCodeTooLarge,
+		"executable is over the %d byte limit", h.binaryMaxBytes).
+		WithDetails(map[string]any{"max_binary_bytes": h.binaryMaxBytes}))
+}
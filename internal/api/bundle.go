@@ -0,0 +1,249 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	goruntime "runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/redact"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// maxSupportBundleBytes caps the uncompressed contents of a support bundle.
+// A file that doesn't fit is cut short, and the ones after it are left out;
+// manifest.json says which.
+const maxSupportBundleBytes = 32 << 20
+
+// supportBundle assembles GET /admin/support-bundle: what a bug report about
+// the server needs, with every secret the masker knows masked. Code, output
+// and caller identities never enter it: lifecycle events carry none, and the
+// masker covers the config, the backend's host description and the profiles'
+// text.
+type supportBundle struct {
+	cfg      *config.Config
+	masker   *redact.Masker
+	backend  sandbox.Backend // nil when none was available at startup
+	gatherer prometheus.Gatherer
+	events   func() []lifecycleEvent
+	profile  func(name string, w io.Writer) error // writes the named runtime/pprof profile
+	started  time.Time
+	maxBytes int
+}
+
+// bundleManifest is the bundle's manifest.json.
+type bundleManifest struct {
+	CreatedAt time.Time    `json:"created_at"`
+	MaxBytes  int          `json:"max_bytes"`
+	Files     []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Name      string `json:"name"`
+	Bytes     int    `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"` // cut at the size cap
+	Error     string `json:"error,omitempty"`     // why the file is missing or partial
+}
+
+// buildReport is the bundle's build.json.
+type buildReport struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings,omitempty"` // vcs revision, build flags
+	Deps      []string          `json:"deps,omitempty"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	NumCPU    int               `json:"num_cpu"`
+	Backend   string            `json:"backend"`
+	StartedAt time.Time         `json:"started_at"`
+	Uptime    string            `json:"uptime"`
+}
+
+// write builds the bundle as a zip archive into w.
+func (b *supportBundle) write(ctx context.Context, w io.Writer) error {
+	diagnostics := sync.OnceValue(func() sandbox.Diagnostics { return b.diagnostics(ctx) })
+	files := []struct {
+		name   string
+		binary bool // not text, so not masked
+		build  func() ([]byte, error)
+	}{
+		{"build.json", false, b.buildInfo},
+		{"config.yaml", false, func() ([]byte, error) { return yaml.Marshal(b.masker.Config(b.cfg)) }},
+		{"backend.json", false, func() ([]byte, error) {
+			d := diagnostics()
+			d.Images = nil
+			return json.MarshalIndent(d, "", "  ")
+		}},
+		{"images.json", false, func() ([]byte, error) { return json.MarshalIndent(diagnostics().Images, "", "  ") }},
+		{"events.json", false, func() ([]byte, error) { return json.MarshalIndent(b.events(), "", "  ") }},
+		{"metrics.json", false, b.metrics},
+		{"goroutines.txt", false, func() ([]byte, error) { return b.profileBytes("goroutine") }},
+		{"heap.pb.gz", true, func() ([]byte, error) { return b.profileBytes("heap") }},
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := bundleManifest{CreatedAt: time.Now().UTC(), MaxBytes: b.maxBytes}
+	left := b.maxBytes
+	for _, f := range files {
+		entry := bundleFile{Name: f.name}
+		if left <= 0 {
+			entry.Error = "left out: the bundle reached its size cap"
+			manifest.Files = append(manifest.Files, entry)
+			continue
+		}
+		data, err := f.build()
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if !f.binary {
+			data = b.masker.Bytes(data)
+		}
+		if len(data) > left {
+			data, entry.Truncated = data[:left], true
+		}
+		if len(data) > 0 {
+			fw, err := zw.Create(f.name)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+		}
+		entry.Bytes = len(data)
+		left -= len(data)
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(b.masker.Bytes(data)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (b *supportBundle) diagnostics(ctx context.Context) sandbox.Diagnostics {
+	if b.backend == nil {
+		return sandbox.Diagnostics{Errors: []string{"no sandbox backend is available"}}
+	}
+	d, ok := b.backend.(sandbox.Diagnoser)
+	if !ok {
+		return sandbox.Diagnostics{Backend: b.backend.Name(), Errors: []string{"the backend does not report diagnostics"}}
+	}
+	return d.Diagnostics(ctx)
+}
+
+func (b *supportBundle) buildInfo() ([]byte, error) {
+	report := buildReport{
+		GoVersion: goruntime.Version(),
+		OS:        goruntime.GOOS,
+		Arch:      goruntime.GOARCH,
+		NumCPU:    goruntime.NumCPU(),
+		StartedAt: b.started.UTC(),
+		Uptime:    time.Since(b.started).Round(time.Second).String(),
+	}
+	if b.backend != nil {
+		report.Backend = b.backend.Name()
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Path, report.Version = info.Main.Path, info.Main.Version
+		report.Settings = make(map[string]string, len(info.Settings))
+		for _, s := range info.Settings {
+			report.Settings[s.Key] = s.Value
+		}
+		for _, dep := range info.Deps {
+			report.Deps = append(report.Deps, dep.Path+"@"+dep.Version)
+		}
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
+
+func (b *supportBundle) metrics() ([]byte, error) {
+	families, err := b.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+	data, marshalErr := json.MarshalIndent(families, "", "  ")
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return data, err // a partial gather still goes in
+}
+
+func (b *supportBundle) profileBytes(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := b.profile(name, &buf)
+	return buf.Bytes(), err
+}
+
+// writeProfile writes a runtime/pprof profile: goroutines as full stack
+// dumps, which read without tooling, and anything else in pprof's format.
+func writeProfile(name string, w io.Writer) error {
+	p := pprof.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("no %s profile", name)
+	}
+	debugLevel := 0
+	if name == "goroutine" {
+		debugLevel = 2
+	}
+	return p.WriteTo(w, debugLevel)
+}
+
+// handleSupportBundle serves the support bundle as a zip download. It is
+// built in memory, so a failure is still reported as an error response.
+func (s *Server) handleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := s.bundle.write(r.Context(), &buf); err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInternal, "building support bundle: %v", err))
+		return
+	}
+	name := "support-bundle-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// handleAdminConfig serves the effective config with its secrets masked,
+// preceded by the startup config warnings as comments.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := yaml.Marshal(s.masker.Config(s.cfg))
+	if err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInternal, "encoding config: %v", err))
+		return
+	}
+	if len(s.configWarnings) > 0 {
+		var header bytes.Buffer
+		header.WriteString("# config warnings:\n")
+		for _, warning := range s.configWarnings {
+			header.WriteString("#   " + strings.ReplaceAll(warning, "\n", " ") + "\n")
+		}
+		data = append(header.Bytes(), data...)
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(s.masker.Bytes(data))
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/redact"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+// diagnosingBackend is a mockBackend that reports diagnostics.
+type diagnosingBackend struct {
+	mockBackend
+	diag sandbox.Diagnostics
+}
+
+func (b *diagnosingBackend) Diagnostics(context.Context) sandbox.Diagnostics { return b.diag }
+
+// Canary secrets planted where a support bundle might pick them up.
+const (
+	canaryUserKey      = "canary-user-key-0001"
+	canaryAdminKey     = "canary-admin-key-0002"
+	canaryExemptKey    = "canary-exempt-key-0003"
+	canaryDBPassword   = "canary-db-pass-0004"
+	canaryTracePass    = "canary-trace-pass-0005"
+	canaryProxySecret  = "canary-proxy-secret-0006"
+	canaryEnvToken     = "canary-oauth-token-0007"
+	canaryDaemonProxy  = "canary-daemon-proxy-0008"
+	canaryCode         = "canary_code_0009"
+	canaryOutput       = "canary-output-0010"
+	canaryStderr       = "canary-stderr-0011"
+	canaryRegistryAuth = "canary-registry-auth-0012"
+)
+
+func canaryConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{canaryUserKey, canaryAdminKey}
+	cfg.Security.AdminKeys = []string{canaryAdminKey}
+	cfg.Security.CostBudget.ExemptKeys = []string{canaryExemptKey}
+	cfg.Database.DSN = "postgres://sandbox:" + canaryDBPassword + "@db:5432/sandbox"
+	cfg.Tracing.Endpoint = "https://otel:" + canaryTracePass + "@collector:4318"
+	cfg.AuthProxy.Secret = canaryProxySecret
+	return cfg
+}
+
+// readBundle returns the files of a zip archive by name, gunzipping the
+// .gz ones so their contents can be scanned too.
+func readBundle(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("bundle is not a zip archive: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = rc
+		if strings.HasSuffix(f.Name, ".gz") {
+			if r, err = gzip.NewReader(rc); err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+		}
+		content, err := io.ReadAll(r)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestSupportBundle_ExcludesCanarySecrets(t *testing.T) {
+	t.Setenv("CLAUDE_CODE_OAUTH_TOKEN", canaryEnvToken)
+	backend := &diagnosingBackend{
+		mockBackend: mockBackend{result: &sandbox.ExecutionResult{Output: canaryOutput, Stderr: canaryStderr, ExitClass: sandbox.ExitUser}},
+		diag: sandbox.Diagnostics{
+			Backend: "docker",
+			Version: "Server: 27.1.1",
+			Info:    "HTTPProxy: http://proxy:" + canaryDaemonProxy + "@proxy.internal:3128\nLabels: key=" + canaryUserKey + "\nRegistryConfig: password=" + canaryRegistryAuth,
+			Images:  []sandbox.ImageDigest{{Language: "python", Image: "python:3.12-slim", Digest: "sha256:abc"}},
+		},
+	}
+	s := NewServer(canaryConfig(), backend, nil, nil, monitor.NewMetrics())
+	handler := s.Handler()
+
+	// An execution leaves its lifecycle events behind.
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"language":"python","code":"print('`+canaryCode+`')"}`))
+	req.Header.Set("X-API-Key", canaryUserKey)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("execute status = %d: %s", w.Code, w.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/support-bundle", nil)
+	req.Header.Set("X-API-Key", canaryAdminKey)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("support bundle status = %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "support-bundle-") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	files := readBundle(t, w.Body.Bytes())
+	for _, name := range []string{"manifest.json", "build.json", "config.yaml", "backend.json", "images.json", "events.json", "metrics.json", "goroutines.txt", "heap.pb.gz"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle has no %s", name)
+		}
+	}
+	for name, content := range files {
+		for _, canary := range []string{
+			canaryUserKey, canaryAdminKey, canaryExemptKey, canaryDBPassword, canaryTracePass, canaryProxySecret,
+			canaryEnvToken, canaryDaemonProxy, canaryCode, canaryOutput, canaryStderr, canaryRegistryAuth,
+		} {
+			if strings.Contains(content, canary) {
+				t.Errorf("%s contains %s", name, canary)
+			}
+		}
+	}
+
+	// What the bundle is for is still there.
+	var events []lifecycleEvent
+	if err := json.Unmarshal([]byte(files["events.json"]), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Event != "started" || events[1].Event != "finished" || events[1].ExitClass != string(sandbox.ExitUser) {
+		t.Errorf("events = %+v, want the execution's start and finish", events)
+	}
+	if !strings.Contains(files["images.json"], "sha256:abc") || !strings.Contains(files["backend.json"], "27.1.1") {
+		t.Errorf("backend diagnostics missing:\n%s\n%s", files["backend.json"], files["images.json"])
+	}
+	if !strings.Contains(files["metrics.json"], "sandbox_executions_total") {
+		t.Error("metrics.json has no execution counter")
+	}
+	if !strings.Contains(files["goroutines.txt"], "goroutine ") {
+		t.Error("goroutines.txt is not a goroutine dump")
+	}
+	if !strings.Contains(files["config.yaml"], redact.Mask) {
+		t.Errorf("config.yaml has no masked fields:\n%s", files["config.yaml"])
+	}
+}
+
+// fakeProfile writes a stand-in profile, gzipped like pprof's for heap.
+func fakeProfile(name string, w io.Writer) error {
+	text := "fake " + name + " profile " + strings.Repeat(".", 200)
+	if name != "heap" {
+		_, err := io.WriteString(w, text)
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := io.WriteString(zw, text); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func testBundle(backend sandbox.Backend, maxBytes int) *supportBundle {
+	cfg := canaryConfig()
+	registry := newExecutionRegistry(nil)
+	registry.start("exec-1", "owner", "python", "", time.Second, nil)
+	registry.finish("exec-1", state.Timeout, &sandbox.ExecutionResult{ExitClass: sandbox.ExitTimeoutKill})
+	return &supportBundle{
+		cfg:      cfg,
+		masker:   redact.NewMasker(cfg),
+		backend:  backend,
+		gatherer: monitor.NewMetrics().Registry,
+		events:   registry.lifecycle,
+		profile:  fakeProfile,
+		started:  time.Now().Add(-time.Minute),
+		maxBytes: maxBytes,
+	}
+}
+
+func bundleManifestOf(t *testing.T, files map[string]string) bundleManifest {
+	t.Helper()
+	var m bundleManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &m); err != nil {
+		t.Fatalf("manifest.json: %v", err)
+	}
+	return m
+}
+
+func TestSupportBundle_Assembly(t *testing.T) {
+	backend := &diagnosingBackend{diag: sandbox.Diagnostics{
+		Backend: "containerd",
+		Version: "v1.7.29 abc123",
+		Images:  []sandbox.ImageDigest{{Language: "node", Image: "node:22", Error: "not found"}},
+	}}
+	var buf bytes.Buffer
+	if err := testBundle(backend, maxSupportBundleBytes).write(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	files := readBundle(t, buf.Bytes())
+
+	if got := files["goroutines.txt"]; !strings.HasPrefix(got, "fake goroutine profile") {
+		t.Errorf("goroutines.txt = %q", got)
+	}
+	if got := files["heap.pb.gz"]; !strings.HasPrefix(got, "fake heap profile") || strings.Contains(files["backend.json"], "images") {
+		t.Errorf("heap.pb.gz = %q, backend.json = %s", got, files["backend.json"])
+	}
+	var images []sandbox.ImageDigest
+	if err := json.Unmarshal([]byte(files["images.json"]), &images); err != nil || len(images) != 1 || images[0].Error != "not found" {
+		t.Errorf("images.json = %s (%v)", files["images.json"], err)
+	}
+	if !strings.Contains(files["events.json"], `"exit_class": "timeout_kill"`) {
+		t.Errorf("events.json = %s", files["events.json"])
+	}
+	var build buildReport
+	if err := json.Unmarshal([]byte(files["build.json"]), &build); err != nil || build.Backend != "docker" || build.GoVersion == "" {
+		t.Errorf("build.json = %s (%v)", files["build.json"], err)
+	}
+
+	m := bundleManifestOf(t, files)
+	if len(m.Files) != 8 {
+		t.Fatalf("manifest = %+v, want eight files", m.Files)
+	}
+	for _, f := range m.Files {
+		if f.Truncated || f.Error != "" || f.Bytes != len(files[f.Name]) && f.Name != "heap.pb.gz" {
+			t.Errorf("manifest entry %+v, file is %d bytes", f, len(files[f.Name]))
+		}
+	}
+}
+
+func TestSupportBundle_NoBackend(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testBundle(nil, maxSupportBundleBytes).write(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	files := readBundle(t, buf.Bytes())
+	if !strings.Contains(files["backend.json"], "no sandbox backend") {
+		t.Errorf("backend.json = %s", files["backend.json"])
+	}
+}
+
+func TestSupportBundle_SizeCap(t *testing.T) {
+	const maxBytes = 2048
+	var buf bytes.Buffer
+	if err := testBundle(&mockBackend{}, maxBytes).write(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	files := readBundle(t, buf.Bytes())
+	m := bundleManifestOf(t, files)
+
+	total, truncated, leftOut := 0, 0, 0
+	for _, f := range m.Files {
+		total += len(files[f.Name])
+		if f.Truncated {
+			truncated++
+		}
+		if strings.Contains(f.Error, "size cap") {
+			leftOut++
+			if _, ok := files[f.Name]; ok {
+				t.Errorf("%s left out but present", f.Name)
+			}
+		}
+	}
+	if total > maxBytes {
+		t.Errorf("bundle holds %d bytes, cap is %d", total, maxBytes)
+	}
+	if truncated != 1 || leftOut == 0 {
+		t.Errorf("manifest = %+v, want one file cut short and the rest left out", m.Files)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	cfg := canaryConfig()
+	handler := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics()).Handler()
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/admin/config", "/admin/support-bundle"} {
+		if w := get(path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a key = %d, want 401", path, w.Code)
+		}
+		w := get(path, canaryUserKey)
+		var resp apierror.Response
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusForbidden || resp.Code != apierror.CodeAdminRequired {
+			t.Errorf("%s with a non-admin key = %d %s, want 403 %s", path, w.Code, resp.Code, apierror.CodeAdminRequired)
+		}
+		if w := get(path, canaryAdminKey); w.Code != http.StatusOK {
+			t.Errorf("%s with the admin key = %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	w := get("/admin/config", canaryAdminKey)
+	body := w.Body.String()
+	if !strings.Contains(body, "admin_keys:") || strings.Contains(body, canaryAdminKey) || strings.Contains(body, canaryDBPassword) {
+		t.Errorf("/admin/config:\n%s", body)
+	}
+}
+
+func TestConfigWarnings(t *testing.T) {
+	cfg := canaryConfig()
+	s := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics())
+	warnings := []string{
+		"pool.enabled has no effect with the docker backend",
+		"database.dsn has sslmode=disable: " + canaryDBPassword,
+	}
+	s.SetConfigWarnings(warnings)
+	handler := s.Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", canaryAdminKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	var health HealthResponse
+	if err := json.NewDecoder(get("/health").Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(health.ConfigWarnings, warnings) {
+		t.Errorf("/health config_warnings = %q, want %q", health.ConfigWarnings, warnings)
+	}
+
+	body := get("/admin/config").Body.String()
+	if !strings.HasPrefix(body, "# config warnings:\n#   pool.enabled has no effect with the docker backend\n#   database.dsn") {
+		t.Errorf("/admin/config doesn't start with the warnings:\n%s", body)
+	}
+	if strings.Contains(body, canaryDBPassword) {
+		t.Error("/admin/config warnings aren't masked")
+	}
+	var parsed config.Config
+	if err := yaml.Unmarshal([]byte(body), &parsed); err != nil || parsed.Server.Port != cfg.Server.Port {
+		t.Errorf("/admin/config with warnings isn't the config YAML: %v", err)
+	}
+
+	// A clean config adds nothing to either.
+	s.SetConfigWarnings(nil)
+	handler = s.Handler()
+	if body := get("/health").Body.String(); strings.Contains(body, "config_warnings") {
+		t.Errorf("/health = %s", body)
+	}
+	if body := get("/admin/config").Body.String(); strings.HasPrefix(body, "#") {
+		t.Errorf("/admin/config = %s", body)
+	}
+}
+
+func TestAdminMiddleware_NoAdminKeys(t *testing.T) {
+	cfg := canaryConfig()
+	cfg.Security.AdminKeys = nil
+	handler := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics()).Handler()
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	req.Header.Set("X-API-Key", canaryAdminKey)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 with no admin keys configured", w.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/codebundle"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// newBundleStore opens the bundle store sandbox.bundles configures. Any
+// language but claude, which has no entrypoint to run, may be declared.
+func newBundleStore(cfg config.BundlesConfig, db *storage.DB) (*codebundle.Store, error) {
+	var backend codebundle.Backend
+	switch cfg.Store {
+	case "disk":
+		var err error
+		if backend, err = codebundle.NewDiskBackend(cfg.Dir); err != nil {
+			return nil, err
+		}
+	case "database":
+		if db == nil {
+			return nil, errors.New("sandbox.bundles.store is database but there is no database")
+		}
+		backend = codebundle.NewDBBackend(db)
+	default:
+		return nil, fmt.Errorf("unknown bundle store %q", cfg.Store)
+	}
+	languages := slices.DeleteFunc(runtime.NewRegistry().Languages(), func(l string) bool { return l == "claude" })
+	return codebundle.NewStore(backend, codebundle.Options{
+		MaxBytes:  cfg.MaxBytes,
+		MaxFiles:  cfg.MaxFiles,
+		TTL:       cfg.TTL,
+		Languages: languages,
+	}), nil
+}
+
+// isBundleUpload reports whether r is a bundle upload, which is capped by
+// sandbox.bundles.max_bytes rather than the global request body limit.
+func isBundleUpload(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Path == pathBundles
+}
+
+// HandleCreateBundle serves POST /bundles: a tar.gz of files with a
+// manifest.json, stored under the digest of its canonical form. Uploading
+// the same files again returns the bundle already stored.
+func (h *Handlers) HandleCreateBundle(w http.ResponseWriter, r *http.Request) {
+	if !h.requireCapability(w, r, "bundles") {
+		return
+	}
+
+	// The global body cap is skipped for uploads (see MaxBodyMiddleware); the
+	// store enforces the real limits, this just stops reading early.
+	body := http.MaxBytesReader(w, r.Body, h.bundles.MaxBytes()+1)
+	b, err := h.bundles.Add(r.Context(), body)
+	if err != nil {
+		writeBundleError(w, err, r)
+		return
+	}
+
+	log.Info().Str("digest", b.Digest).Str("language", b.Manifest.Language).Int("files", b.Files).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("bundle uploaded")
+	writeJSON(w, http.StatusCreated, bundleResponse(b))
+}
+
+// bundleFlagsRequest is the body of POST /admin/bundles/{digest}; a flag
+// left out is left as it is.
+type bundleFlagsRequest struct {
+	Approved *bool `json:"approved"`
+	Pinned   *bool `json:"pinned"`
+}
+
+// HandleBundleFlags serves POST /admin/bundles/{digest}, which approves or
+// pins a bundle, or takes either back. An approved bundle may run under
+// sandbox.bundles.require_approval; a pinned one is never removed for going
+// unused.
+func (h *Handlers) HandleBundleFlags(w http.ResponseWriter, r *http.Request) {
+	if !h.requireCapability(w, r, "bundles") {
+		return
+	}
+
+	var req bundleFlagsRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+	b, err := h.bundles.SetFlags(r.Context(), r.PathValue("digest"), req.Approved, req.Pinned)
+	if err != nil {
+		writeBundleError(w, err, r)
+		return
+	}
+
+	log.Info().Str("digest", b.Digest).Bool("approved", b.Approved).Bool("pinned", b.Pinned).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("bundle flags changed")
+	writeJSON(w, http.StatusOK, bundleResponse(b))
+}
+
+// resolveBundle turns an execution's bundle_digest into what it runs: the
+// manifest's language and entrypoint, and the bundle's files, read and
+// verified against the digest, for prepare to unpack. It writes the error
+// response and returns false when the request must be refused.
+func (h *Handlers) resolveBundle(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) bool {
+	if req.BundleDigest == "" {
+		return true
+	}
+	if !h.requireCapability(w, r, "bundles") {
+		return false
+	}
+	switch {
+	case req.Code != "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "bundle_digest and code are mutually exclusive"))
+		return false
+	case req.WorkspaceID != "" || req.WorkDir != "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "bundle_digest takes /workspace for the bundle's files; it can't be used with workspace_id or work_dir"))
+		return false
+	}
+
+	b, err := h.bundles.Stat(r.Context(), req.BundleDigest)
+	if err != nil {
+		writeBundleError(w, err, r)
+		return false
+	}
+	if h.requireApproval && !b.Approved {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeBundleNotApproved, "the bundle has not been approved to run").
+			WithDetails(map[string]any{"digest": b.Digest}))
+		return false
+	}
+	if req.Language != "" && req.Language != b.Manifest.Language {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "language %q doesn't match the bundle's %q; leave it out", req.Language, b.Manifest.Language))
+		return false
+	}
+
+	contents, err := h.bundles.Open(r.Context(), req.BundleDigest)
+	if err != nil {
+		writeBundleError(w, err, r)
+		return false
+	}
+	req.Language = contents.Manifest.Language
+	req.Code = contents.Entrypoint()
+	if req.Language == binaryLanguage {
+		req.Code = base64.StdEncoding.EncodeToString([]byte(req.Code))
+	}
+	req.bundle = contents
+	return true
+}
+
+// unpackBundle writes a bundle's files to a new directory under the
+// workspace root, where the runners accept a workspace, for the execution
+// to mount at /workspace. The caller removes it with removeBundleDir.
+// Leftovers are uuid-named like workspaces, so the workspace store clears
+// them at startup.
+func (h *Handlers) unpackBundle(w http.ResponseWriter, r *http.Request, contents *codebundle.Contents) (string, bool) {
+	dir := filepath.Join(h.workspaces.Root(), uuid.New().String())
+	err := os.Mkdir(dir, 0o700)
+	if err == nil {
+		err = contents.Extract(dir)
+	}
+	if err != nil {
+		removeBundleDir(dir)
+		log.Error().Err(err).Str("digest", contents.Digest).Str("request_id", RequestIDFromContext(r.Context())).Msg("failed to unpack bundle")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "failed to unpack the bundle"))
+		return "", false
+	}
+	return dir, true
+}
+
+func removeBundleDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warn().Err(err).Str("dir", dir).Msg("failed to remove unpacked bundle")
+	}
+}
+
+func bundleResponse(b codebundle.Bundle) BundleResponse {
+	return BundleResponse{
+		Digest:     b.Digest,
+		Entrypoint: b.Manifest.Entrypoint,
+		Language:   b.Manifest.Language,
+		SizeBytes:  b.Size,
+		Files:      b.Files,
+		Approved:   b.Approved,
+		Pinned:     b.Pinned,
+		CreatedAt:  b.CreatedAt,
+		LastUsedAt: b.LastUsedAt,
+	}
+}
+
+// writeBundleError maps bundle store errors to API errors.
+func writeBundleError(w http.ResponseWriter, err error, r *http.Request) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, codebundle.ErrNotFound):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeBundleNotFound, "bundle not found"))
+	case errors.Is(err, codebundle.ErrTooLarge), errors.As(err, &maxBytesErr):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeBundleTooLarge, err.Error()))
+	case errors.Is(err, codebundle.ErrInvalid):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidBundle, err.Error()))
+	case errors.Is(err, codebundle.ErrCorrupt):
+		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("stored bundle failed verification")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeBundleCorrupt, "the stored bundle does not match its digest"))
+	default:
+		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("bundle operation failed")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "bundle operation failed"))
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// workspaceBackend reads the files in each execution's workspace while it
+// runs, since a bundle's are removed after.
+type workspaceBackend struct {
+	mockBackend
+	files map[string]string
+}
+
+func (b *workspaceBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	b.files = map[string]string{}
+	filepath.WalkDir(req.Workspace, func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			data, _ := os.ReadFile(p)
+			rel, _ := filepath.Rel(req.Workspace, p)
+			b.files[rel] = string(data)
+		}
+		return nil
+	})
+	return b.mockBackend.Execute(ctx, req)
+}
+
+func newBundleServer(t *testing.T, requireApproval bool) (*Server, *workspaceBackend) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Workspaces.Root = t.TempDir()
+	cfg.Sandbox.Workspaces.MaxFileBytes = 2 << 20
+	cfg.Sandbox.Workspaces.MaxTotalBytes = 3 << 20
+	cfg.Sandbox.Bundles.Store = "disk"
+	cfg.Sandbox.Bundles.Dir = t.TempDir()
+	cfg.Sandbox.Bundles.RequireApproval = requireApproval
+	cfg.Security.AllowedKeys = []string{"key", "admin"}
+	cfg.Security.AdminKeys = []string{"admin"}
+	cfg.Security.RateLimitRPS = 1000
+	cfg.Security.RateLimitBurst = 1000
+
+	backend := &workspaceBackend{mockBackend: mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}}
+	s := NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	t.Cleanup(s.handlers.workspaces.Close)
+	t.Cleanup(s.handlers.bundles.Close)
+	return s, backend
+}
+
+// tarGz packs files, name then contents, into a tar.gz.
+func tarGz(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(files); i += 2 {
+		if err := tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(files[i+1]))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func uploadBundle(t *testing.T, h http.Handler, archive []byte) BundleResponse {
+	t.Helper()
+	rec := doRequest(h, http.MethodPost, "/bundles", "key", bytes.NewReader(archive))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var b BundleResponse
+	if err := json.NewDecoder(rec.Body).Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func executeBundle(h http.Handler, body string) *httptest.ResponseRecorder {
+	return doRequest(h, http.MethodPost, "/execute", "key", strings.NewReader(body))
+}
+
+func TestBundles_Execute(t *testing.T) {
+	s, backend := newBundleServer(t, false)
+	h := s.httpServer.Handler
+	b := uploadBundle(t, h, tarGz(t,
+		"manifest.json", `{"entrypoint": "main.py", "language": "python"}`,
+		"main.py", "from lib import greet\ngreet()",
+		"lib/__init__.py", "def greet(): print('hi')",
+	))
+	if b.Entrypoint != "main.py" || b.Language != "python" || b.Files != 3 || !strings.HasPrefix(b.Digest, "sha256:") {
+		t.Errorf("uploaded %+v", b)
+	}
+
+	rec := executeBundle(h, `{"bundle_digest": "`+b.Digest+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", rec.Code, rec.Body.String())
+	}
+	req := backend.req
+	if req.Language != "python" || req.Code != "from lib import greet\ngreet()" || backend.files["lib/__init__.py"] != "def greet(): print('hi')" {
+		t.Errorf("ran %s %q with files %v", req.Language, req.Code, backend.files)
+	}
+	if _, err := os.Stat(req.Workspace); !os.IsNotExist(err) {
+		t.Errorf("unpacked bundle left at %s: %v", req.Workspace, err)
+	}
+
+	for body, want := range map[string]apierror.Code{
+		`{"bundle_digest": "` + b.Digest + `", "code": "print(1)"}`:                    "INVALID_REQUEST",
+		`{"bundle_digest": "` + b.Digest + `", "language": "node"}`:                    "INVALID_REQUEST",
+		`{"bundle_digest": "sha256:` + strings.Repeat("0", 64) + `"}`:                  "BUNDLE_NOT_FOUND",
+		`{"bundle_digest": "` + b.Digest + `", "workspace_id": "x", "language": "py"}`: "INVALID_REQUEST",
+	} {
+		if resp := decodeError(t, executeBundle(h, body)); resp.Code != want {
+			t.Errorf("%s: %+v, want %s", body, resp, want)
+		}
+	}
+
+	rec = doRequest(h, http.MethodPost, "/bundles", "key", bytes.NewReader(tarGz(t, "manifest.json", `{"entrypoint": "main.py", "language": "python"}`, "../main.py", "x")))
+	if resp := decodeError(t, rec); resp.Code != "INVALID_BUNDLE" {
+		t.Errorf("traversal: %+v", resp)
+	}
+}
+
+func TestBundles_Corrupt(t *testing.T) {
+	s, backend := newBundleServer(t, false)
+	h := s.httpServer.Handler
+	b := uploadBundle(t, h, tarGz(t, "manifest.json", `{"entrypoint": "main.py", "language": "python"}`, "main.py", "print('reviewed')"))
+
+	archive := filepath.Join(s.cfg.Sandbox.Bundles.Dir, strings.TrimPrefix(b.Digest, "sha256:")+".tar")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(archive, bytes.Replace(data, []byte("reviewed"), []byte("tampered"), 1), 0o600)
+
+	backend.req = sandbox.ExecutionRequest{}
+	rec := executeBundle(h, `{"bundle_digest": "`+b.Digest+`"}`)
+	if resp := decodeError(t, rec); rec.Code != http.StatusInternalServerError || resp.Code != "BUNDLE_CORRUPT" {
+		t.Errorf("status %d %+v, want BUNDLE_CORRUPT", rec.Code, resp)
+	}
+	if backend.req.Code != "" {
+		t.Errorf("ran %q", backend.req.Code)
+	}
+}
+
+func TestBundles_RequireApproval(t *testing.T) {
+	s, _ := newBundleServer(t, true)
+	h := s.httpServer.Handler
+	b := uploadBundle(t, h, tarGz(t, "manifest.json", `{"entrypoint": "main.py", "language": "python"}`, "main.py", "print(1)"))
+	run := `{"bundle_digest": "` + b.Digest + `"}`
+
+	rec := executeBundle(h, run)
+	if resp := decodeError(t, rec); rec.Code != http.StatusForbidden || resp.Code != "BUNDLE_NOT_APPROVED" || resp.Details["digest"] != b.Digest {
+		t.Fatalf("unapproved: status %d %+v", rec.Code, resp)
+	}
+
+	approve := `{"approved": true}`
+	if rec := doRequest(h, http.MethodPost, "/admin/bundles/"+b.Digest, "key", strings.NewReader(approve)); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by a non-admin: status %d", rec.Code)
+	}
+	rec = doRequest(h, http.MethodPost, "/admin/bundles/"+b.Digest, "admin", strings.NewReader(approve))
+	var approved BundleResponse
+	json.NewDecoder(rec.Body).Decode(&approved)
+	if rec.Code != http.StatusOK || !approved.Approved || approved.Pinned {
+		t.Fatalf("approve: status %d %+v", rec.Code, approved)
+	}
+	if rec := executeBundle(h, run); rec.Code != http.StatusOK {
+		t.Errorf("approved: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(h, http.MethodPost, "/admin/bundles/"+b.Digest, "admin", strings.NewReader(`{"approved": false}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d", rec.Code)
+	}
+	if resp := decodeError(t, executeBundle(h, run)); resp.Code != "BUNDLE_NOT_APPROVED" {
+		t.Errorf("revoked: %+v", resp)
+	}
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/version"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// capability is an optional part of the API: something a request can use
+// that a deployment may not support. capabilities is the one list of them;
+// GET /capabilities documents it and the handlers enforce it, so the two
+// can't disagree. A capability named like a kill switch feature
+// (config.Features) can also be turned off by one.
+type capability struct {
+	name        string
+	description string
+	fields      []string // request fields that use it, dotted for nested ones
+	routes      []string // routes that are it
+	// used reports whether a request to an execute route uses it. Nil for
+	// a capability that is only a route; its handler calls
+	// requireCapability.
+	used func(r *http.Request, req *ExecutionRequest) bool
+	// enabled reports whether the deployment supports it, kill switches
+	// aside.
+	enabled func(h *Handlers) bool
+	// code and reason are the error for using it when it isn't enabled.
+	code   apierror.Code
+	reason string
+}
+
+func always(*Handlers) bool { return true }
+
+var capabilities = []capability{
+	{
+		name:        "streaming",
+		description: "Output as server-sent events while the execution runs.",
+		routes:      []string{"POST /execute/stream"},
+		enabled:     always,
+	},
+	{
+		name:        "uploads",
+		description: "Code sent as a multipart upload, up to limits.max_upload_code_bytes.",
+		routes:      []string{"POST /execute/upload"},
+		enabled:     func(h *Handlers) bool { return h.maxUploadBytes > 0 },
+		code:        apierror.CodeUploadsDisabled,
+		reason:      "code uploads are not enabled on this server",
+	},
+	{
+		name:        "async",
+		description: "Executions answered with their ID as soon as they are queued, their result read from GET /executions/{id}.",
+		fields:      []string{"async"},
+		routes:      []string{"POST /execute/async"},
+		used:        func(r *http.Request, req *ExecutionRequest) bool { return req.Async || asyncQuery(r) },
+		enabled:     func(h *Handlers) bool { return h.async != nil && h.getExecution != nil },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "asynchronous executions need execution records, which this server doesn't keep",
+	},
+	{
+		name:        "network_enabled",
+		description: "Network access for the sandboxed code.",
+		fields:      []string{"permissions.network.enabled"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.Perms.Network.Enabled },
+		enabled:     always,
+	},
+	{
+		name:        "claude_workdir",
+		description: "A host directory mounted into a claude session.",
+		fields:      []string{"work_dir"},
+		used: func(_ *http.Request, req *ExecutionRequest) bool {
+			return req.Language == "claude" && req.WorkDir != ""
+		},
+		enabled: func(h *Handlers) bool { return runsClaude(h.backend) },
+		code:    apierror.CodeInvalidRequest,
+		reason:  "work_dir needs the claude runtime, which this backend does not run",
+	},
+	{
+		name:        "workspaces",
+		description: "Server-managed directories that persist across executions.",
+		fields:      []string{"workspace_id"},
+		routes:      []string{"POST /workspaces", "DELETE /workspaces/{id}", "GET /workspaces/{id}/files", "GET /workspaces/{id}/files/{path}", "PUT /workspaces/{id}/files/{path}"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.WorkspaceID != "" },
+		enabled:     func(h *Handlers) bool { return h.workspaces != nil },
+		code:        apierror.CodeWorkspacesDisabled,
+		reason:      "workspaces are not enabled on this server",
+	},
+	{
+		name:        "bundles",
+		description: "Content-addressed code bundles, uploaded once and run by digest.",
+		fields:      []string{"bundle_digest"},
+		routes:      []string{"POST /bundles"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.BundleDigest != "" },
+		enabled:     func(h *Handlers) bool { return h.bundles != nil },
+		code:        apierror.CodeBundlesDisabled,
+		reason:      "code bundles are not enabled on this server",
+	},
+	{
+		name:        "shared_mounts",
+		description: "Read-only directories from sandbox.shared_mounts.",
+		fields:      []string{"shared_mounts"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return len(req.SharedMounts) > 0 },
+		enabled:     func(h *Handlers) bool { return len(h.sharedMounts) > 0 },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "no shared mounts are configured on this server",
+	},
+	{
+		name:        "claude_caches",
+		description: "Persistent npm and pip caches for claude sessions.",
+		fields:      []string{"use_caches"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.UseCaches },
+		enabled:     func(h *Handlers) bool { return h.claudeCaches && runsClaude(h.backend) },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "no claude caches are configured on this server",
+	},
+	{
+		name:        "claude_tokens",
+		description: "Claude sessions billed to the caller's own Anthropic token.",
+		fields:      []string{"claude_token", "claude_credential"},
+		used: func(_ *http.Request, req *ExecutionRequest) bool {
+			return req.ClaudeToken != "" || req.ClaudeCredential != ""
+		},
+		enabled: func(h *Handlers) bool { return h.claudeTokens != nil },
+		code:    apierror.CodeClaudeTokenDisabled,
+		reason:  "caller tokens are not enabled on this server",
+	},
+	{
+		name:        "chaos",
+		description: "Synthesized failures instead of running code, also asked for with the X-Sandbox-Chaos header.",
+		fields:      []string{"chaos"},
+		used: func(r *http.Request, req *ExecutionRequest) bool {
+			return req.Chaos != nil || r.Header.Get(chaosHeader) != ""
+		},
+		enabled: func(h *Handlers) bool { return h.chaosEnabled },
+		code:    apierror.CodeChaosDisabled,
+		reason:  "chaos mode is disabled on this server",
+	},
+	{
+		name:        "idle_output_timeout",
+		description: "Stopping an execution that writes no output for a while, up to limits.max_idle_output_timeout.",
+		fields:      []string{"idle_output_timeout"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.IdleOutputTimeout.Duration != 0 },
+		enabled:     func(h *Handlers) bool { return h.maxIdleTimeout > 0 },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "idle_output_timeout is disabled on this server",
+	},
+	{
+		name:        "stdin",
+		description: "Data written to the program's standard input, up to sandbox.max_stdin_bytes.",
+		fields:      []string{"stdin"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.Stdin != "" },
+		enabled:     func(h *Handlers) bool { return h.maxStdinBytes > 0 },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "stdin is disabled on this server",
+	},
+	{
+		name:        "debug_trace",
+		description: "The code run under strace, for callers in sandbox.debug_trace.keys, with a summary of the syscalls that failed.",
+		fields:      []string{"debug_trace"},
+		used:        func(_ *http.Request, req *ExecutionRequest) bool { return req.DebugTrace },
+		enabled:     func(h *Handlers) bool { return h.debugTracers != nil && runsClaude(h.backend) },
+		code:        apierror.CodeInvalidRequest,
+		reason:      "debug traces are not enabled on this server",
+	},
+}
+
+func lookupCapability(name string) *capability {
+	for i := range capabilities {
+		if capabilities[i].name == name {
+			return &capabilities[i]
+		}
+	}
+	return nil
+}
+
+// runsClaude reports whether backend can run the claude runtime.
+func runsClaude(backend sandbox.Backend) bool {
+	return backend != nil && backend.Name() == "docker"
+}
+
+// refuse writes the error for using c while the deployment doesn't support
+// it.
+func (c *capability) refuse(w http.ResponseWriter, r *http.Request) {
+	apierror.WriteError(w, r, apierror.New(c.code, c.reason).WithDetails(map[string]any{"capability": c.name}))
+}
+
+// checkCapabilities refuses req when it uses a capability the deployment
+// doesn't support, or one a kill switch has turned off.
+func (h *Handlers) checkCapabilities(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) bool {
+	for i := range capabilities {
+		c := &capabilities[i]
+		if c.used == nil || !c.used(r, req) {
+			continue
+		}
+		if !c.enabled(h) {
+			c.refuse(w, r)
+			return false
+		}
+		if !h.checkFeature(w, r, c.name) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireCapability is checkCapabilities for a route that is a capability.
+func (h *Handlers) requireCapability(w http.ResponseWriter, r *http.Request, name string) bool {
+	c := lookupCapability(name)
+	if !c.enabled(h) {
+		c.refuse(w, r)
+		return false
+	}
+	return h.checkFeature(w, r, name)
+}
+
+// requestFields are the top-level fields of an ExecutionRequest.
+var requestFields = sync.OnceValue(func() []string {
+	var fields []string
+	t := reflect.TypeFor[ExecutionRequest]()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+})
+
+// HandleCapabilities serves GET /capabilities: what this deployment
+// supports, for clients to check before sending a request that would be
+// refused. Everything in it reflects the config and kill switches in force.
+func (h *Handlers) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	var flags *featureFlags
+	if h.flags != nil {
+		flags = h.flags.load()
+	}
+	resp := CapabilitiesResponse{
+		Build: version.Get(),
+		Limits: LimitCapabilities{
+			Default:            newResourceLimits(sandbox.DefaultLimits()),
+			Max:                newResourceLimits(sandbox.MaxLimits()),
+			MaxUploadCodeBytes: h.maxUploadBytes,
+			MaxBinaryBytes:     h.binaryMaxBytes,
+		},
+	}
+	// The default ulimits are the ones derived for the default limits.
+	resp.Limits.Default.Ulimits = newUlimits(sandbox.DefaultLimits().EffectiveUlimits(h.maxUlimits))
+	resp.Limits.Max.Ulimits = newUlimits(h.maxUlimits)
+	if h.backend != nil {
+		resp.Backend = h.backend.Name()
+	}
+	if h.maxIdleTimeout > 0 {
+		resp.Limits.MaxIdleOutputTimeout = h.maxIdleTimeout.String()
+	}
+	if checker, ok := h.backend.(sandbox.LimitEnforcementChecker); ok {
+		if enforcement, on := checker.LimitEnforcement(r.Context()); on {
+			resp.Limits.Enforcement = &enforcement
+		}
+	}
+
+	for _, lang := range knownLanguages.Languages() {
+		if lang == "claude" && !runsClaude(h.backend) {
+			continue
+		}
+		resp.Languages = append(resp.Languages, LanguageCapability{
+			Name:       lang,
+			Aliases:    knownLanguages.AliasesOf(lang),
+			MaxTimeout: sandbox.MaxTimeout(lang).String(),
+			Disabled:   flags != nil && flags.languageDisabled(lang),
+
+			DefaultLimits: newResourceLimits(h.defaultLimits(lang)),
+		})
+	}
+
+	unavailable := map[string]bool{}
+	for _, c := range capabilities {
+		fc := FeatureCapability{
+			Name:        c.name,
+			Description: c.description,
+			Fields:      c.fields,
+			Routes:      c.routes,
+			Enabled:     c.enabled(h),
+		}
+		switch {
+		case !fc.Enabled:
+			fc.Reason = c.reason
+		case flags != nil && flags.featureDisabled(c.name):
+			fc.Enabled = false
+			fc.Reason = "turned off by a kill switch"
+			if flags.Message != "" {
+				fc.Reason += ": " + flags.Message
+			}
+		}
+		if c.name == "streaming" {
+			resp.Streaming.SSE = fc.Enabled
+			if fc.Enabled {
+				resp.Streaming.Versions = stream.Versions
+			}
+		}
+		if !fc.Enabled {
+			for _, f := range c.fields {
+				unavailable[f] = true
+			}
+		}
+		resp.Features = append(resp.Features, fc)
+	}
+	for _, f := range requestFields() {
+		if !unavailable[f] {
+			resp.Fields = append(resp.Fields, f)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func newResourceLimits(l sandbox.ResourceLimits) ResourceLimits {
+	return ResourceLimits{CPUShares: l.CPUShares, MemoryMB: l.MemoryMB, PidsLimit: l.PidsLimit, DiskMB: l.DiskMB}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/codebundle"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/workspace"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// containerdBackend is a mockBackend that can't run claude.
+type containerdBackend struct{ mockBackend }
+
+func (*containerdBackend) Name() string { return "containerd" }
+
+// capabilityProbes use each capability: enable turns it on, and the request
+// goes to route.
+var capabilityProbes = map[string]struct {
+	enable func(h *Handlers)
+	route  func(h *Handlers) http.HandlerFunc
+	req    ExecutionRequest
+	header string // X-Sandbox-Chaos
+}{
+	"streaming":       {route: func(h *Handlers) http.HandlerFunc { return h.HandleExecuteStream }, req: ExecutionRequest{Language: "python", Code: "1"}},
+	"uploads":         {enable: func(h *Handlers) { h.maxUploadBytes = 1 << 20 }, route: func(h *Handlers) http.HandlerFunc { return h.HandleExecuteUpload }},
+	"network_enabled": {req: ExecutionRequest{Language: "python", Code: "1", Perms: Permissions{Network: NetworkPermissions{Enabled: true}}}},
+	"claude_workdir":  {req: ExecutionRequest{Language: "claude", Code: "List the files.", WorkDir: "/srv/app"}},
+	"workspaces":      {enable: func(h *Handlers) { h.workspaces = &workspace.Store{} }, req: ExecutionRequest{Language: "python", Code: "1", WorkspaceID: "x"}},
+	"bundles":         {enable: func(h *Handlers) { h.bundles = &codebundle.Store{} }, req: ExecutionRequest{BundleDigest: "sha256:0"}},
+	"shared_mounts":   {enable: func(h *Handlers) { h.sharedMounts = []string{"datasets"} }, req: ExecutionRequest{Language: "python", Code: "1", SharedMounts: []string{"datasets"}}},
+	"claude_caches":   {enable: func(h *Handlers) { h.claudeCaches = true }, req: ExecutionRequest{Language: "claude", Code: "Say hi.", UseCaches: true}},
+	"claude_tokens":   {enable: func(h *Handlers) { h.claudeTokens = &claudeTokens{} }, req: ExecutionRequest{Language: "claude", Code: "Say hi.", ClaudeCredential: "team"}},
+	"chaos":           {enable: func(h *Handlers) { h.chaosEnabled = true }, req: ExecutionRequest{Language: "python", Code: "1"}, header: "timeout"},
+	"idle_output_timeout": {enable: func(h *Handlers) { h.maxIdleTimeout = 1 << 40 },
+		req: ExecutionRequest{Language: "python", Code: "1", IdleOutputTimeout: Duration{1 << 30}}},
+	"stdin": {enable: func(h *Handlers) { h.maxStdinBytes = 1 << 20 },
+		req: ExecutionRequest{Language: "python", Code: "1", Stdin: "x"}},
+	"async": {enable: func(h *Handlers) { h.async = newAsyncExecutions(time.Hour); h.retainExecutions(1000) },
+		req: ExecutionRequest{Language: "python", Code: "1", Async: true}},
+	"debug_trace": {enable: func(h *Handlers) { h.debugTracers = map[string]bool{"": true} },
+		req: ExecutionRequest{Language: "python", Code: "1", DebugTrace: true}},
+}
+
+// TestCapabilityRegistry checks every capability is documented, enforced
+// and probed below, and that the kill switch features are all capabilities.
+func TestCapabilityRegistry(t *testing.T) {
+	fields := requestFields()
+	seen := map[string]bool{}
+	for _, c := range capabilities {
+		if seen[c.name] {
+			t.Errorf("%s registered twice", c.name)
+		}
+		seen[c.name] = true
+		if c.description == "" || c.enabled == nil {
+			t.Errorf("%s: no description or enabled", c.name)
+		}
+		if c.used == nil && len(c.routes) == 0 {
+			t.Errorf("%s: neither used by requests nor a route, so nothing enforces it", c.name)
+		}
+		if c.code != "" && (c.reason == "" || c.code.Status() < 400) {
+			t.Errorf("%s: refusal %s %q", c.name, c.code, c.reason)
+		}
+		for _, f := range c.fields {
+			top, _, _ := strings.Cut(f, ".")
+			if !slices.Contains(fields, top) {
+				t.Errorf("%s: field %s is not in ExecutionRequest", c.name, f)
+			}
+		}
+		if _, ok := capabilityProbes[c.name]; !ok {
+			t.Errorf("%s: no probe in capabilityProbes", c.name)
+		}
+	}
+	for _, feature := range config.Features {
+		if !seen[feature] {
+			t.Errorf("kill switch feature %s is not a capability", feature)
+		}
+	}
+}
+
+// TestCapabilities_Enforced sends each capability's probe to a server
+// without it, and to one with it turned off by a kill switch, and checks
+// GET /capabilities says the same as the refusal.
+func TestCapabilities_Enforced(t *testing.T) {
+	ok := &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}
+	for _, c := range capabilities {
+		t.Run(c.name, func(t *testing.T) {
+			p := capabilityProbes[c.name]
+			send := func(h *Handlers) *httptest.ResponseRecorder {
+				route := h.HandleExecute
+				if p.route != nil {
+					route = p.route(h)
+				}
+				body, _ := json.Marshal(p.req)
+				req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(string(body)))
+				if p.header != "" {
+					req.Header.Set(chaosHeader, p.header)
+				}
+				rec := httptest.NewRecorder()
+				route(rec, req)
+				return rec
+			}
+			feature := func(h *Handlers) FeatureCapability {
+				rec := httptest.NewRecorder()
+				h.HandleCapabilities(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+				var doc CapabilitiesResponse
+				if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range doc.Features {
+					if f.Name == c.name {
+						for _, field := range f.Fields {
+							if !f.Enabled && slices.Contains(doc.Fields, field) {
+								t.Errorf("fields lists %s of a disabled feature", field)
+							}
+						}
+						return f
+					}
+				}
+				t.Fatalf("%s missing from GET /capabilities", c.name)
+				return FeatureCapability{}
+			}
+
+			off := newTestHandlers(&containerdBackend{mockBackend{result: ok}})
+			if !c.enabled(off) {
+				rec := send(off)
+				resp := decodeError(t, rec)
+				if resp.Code != c.code || resp.Details["capability"] != c.name {
+					t.Errorf("unsupported: status %d %+v, want %s", rec.Code, resp, c.code)
+				}
+				if f := feature(off); f.Enabled || f.Reason != c.reason {
+					t.Errorf("unsupported: documented as %+v", f)
+				}
+			}
+
+			sec := config.DefaultConfig().Security
+			switchable := slices.Contains(config.Features, c.name)
+			if switchable {
+				sec.DisabledFeatures = []string{c.name}
+			}
+			on := newFlagHandlers(&mockBackend{result: ok}, sec)
+			if p.enable != nil {
+				p.enable(on)
+			}
+			if !c.enabled(on) {
+				t.Fatal("probe does not enable it")
+			}
+			if !switchable {
+				if rec := send(on); rec.Code != http.StatusOK {
+					t.Errorf("enabled: status %d %s", rec.Code, rec.Body.String())
+				}
+				if f := feature(on); !f.Enabled {
+					t.Errorf("enabled: documented as %+v", f)
+				}
+				return
+			}
+			rec := send(on)
+			if resp := decodeError(t, rec); resp.Code != "FEATURE_DISABLED" || resp.Details["flag"] != c.name {
+				t.Errorf("kill switch: status %d %+v", rec.Code, resp)
+			}
+			if f := feature(on); f.Enabled || !strings.Contains(f.Reason, "kill switch") {
+				t.Errorf("kill switch: documented as %+v", f)
+			}
+		})
+	}
+}
+
+func TestHandleCapabilities(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"key"}
+	cfg.Security.DisabledLanguages = []string{"node"}
+	cfg.Sandbox.Chaos.Enabled = true
+	cfg.Sandbox.MaxUploadCodeBytes = 8 << 20
+	cfg.Sandbox.RuntimeLimits = map[string]config.DefaultLimits{"python": {MemoryMB: 512}}
+	s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x"}}, nil, nil, monitor.NewMetrics())
+
+	if rec := doRequest(s.httpServer.Handler, http.MethodGet, "/capabilities", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d", rec.Code)
+	}
+	rec := doRequest(s.httpServer.Handler, http.MethodGet, "/capabilities", "key", nil)
+	var doc CapabilitiesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || doc.Backend != "docker" || doc.Build.GoVersion == "" || !doc.Streaming.SSE || doc.Streaming.WebSocket || !slices.Equal(doc.Streaming.Versions, stream.Versions) {
+		t.Errorf("status %d, %+v", rec.Code, doc)
+	}
+	languages := map[string]LanguageCapability{}
+	for _, l := range doc.Languages {
+		languages[l.Name] = l
+	}
+	if languages["claude"].MaxTimeout != "30m0s" || languages["python"].MaxTimeout != "1m0s" || !languages["node"].Disabled || languages["python"].Disabled ||
+		strings.Join(languages["python"].Aliases, ",") != "py,python3" {
+		t.Errorf("languages = %+v", doc.Languages)
+	}
+	features := map[string]FeatureCapability{}
+	for _, f := range doc.Features {
+		features[f.Name] = f
+	}
+	if !features["chaos"].Enabled || !features["uploads"].Enabled || features["workspaces"].Enabled || features["workspaces"].Reason == "" {
+		t.Errorf("features = %+v", doc.Features)
+	}
+	if !slices.Contains(doc.Fields, "chaos") || !slices.Contains(doc.Fields, "code") || slices.Contains(doc.Fields, "workspace_id") {
+		t.Errorf("fields = %v", doc.Fields)
+	}
+	if doc.Limits.Max.MemoryMB != 16384 || doc.Limits.Default.MemoryMB != 256 || doc.Limits.MaxUploadCodeBytes != 8<<20 {
+		t.Errorf("limits = %+v", doc.Limits)
+	}
+	if languages["node"].DefaultLimits.MemoryMB != 512 || languages["bash"].DefaultLimits.MemoryMB != 128 ||
+		languages["claude"].DefaultLimits.PidsLimit != 500 || languages["python"].DefaultLimits.MemoryMB != 512 {
+		t.Errorf("default limits = %+v", doc.Languages)
+	}
+
+	// A backend that can't run claude doesn't offer it.
+	h := newTestHandlers(&containerdBackend{})
+	rec = httptest.NewRecorder()
+	h.HandleCapabilities(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if strings.Contains(rec.Body.String(), `"name":"claude"`) || !strings.Contains(rec.Body.String(), `"backend":"containerd"`) {
+		t.Errorf("containerd: %s", rec.Body.String())
+	}
+}
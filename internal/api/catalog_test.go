@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+)
+
+// catalogConstants maps apierror Code identifiers to their values.
+func catalogConstants(t *testing.T) map[string]apierror.Code {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), filepath.Join("apierror", "apierror.go"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consts := make(map[string]apierror.Code)
+	ast.Inspect(f, func(n ast.Node) bool {
+		vs, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range vs.Names {
+			if i < len(vs.Values) {
+				if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					v, _ := strconv.Unquote(lit.Value)
+					consts[name.Name] = apierror.Code(v)
+				}
+			}
+		}
+		return true
+	})
+	return consts
+}
+
+// TestSourcesUseOnlyCatalogedCodes scans the api package for error responses
+// that bypass the catalog: http.Error with hand-written JSON, ad-hoc
+// apierror.Code("...") conversions, or references to codes not in the catalog.
+func TestSourcesUseOnlyCatalogedCodes(t *testing.T) {
+	consts := catalogConstants(t)
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				pkg, _ := sel.X.(*ast.Ident)
+				if pkg == nil {
+					return true
+				}
+				if pkg.Name == "http" && sel.Sel.Name == "Error" {
+					t.Errorf("%s: http.Error bypasses apierror.WriteError", fset.Position(n.Pos()))
+				}
+				if pkg.Name == "apierror" && sel.Sel.Name == "Code" {
+					t.Errorf("%s: ad-hoc apierror.Code conversion", fset.Position(n.Pos()))
+				}
+			case *ast.SelectorExpr:
+				pkg, _ := n.X.(*ast.Ident)
+				if pkg == nil || pkg.Name != "apierror" || !strings.HasPrefix(n.Sel.Name, "Code") || n.Sel.Name == "Code" {
+					return true
+				}
+				code, ok := consts[n.Sel.Name]
+				if !ok || !code.Known() {
+					t.Errorf("%s: %s is not in the catalog", fset.Position(n.Pos()), n.Sel.Name)
+				}
+			case *ast.BasicLit:
+				if n.Kind == token.STRING && strings.Contains(n.Value, `"code":`) {
+					t.Errorf("%s: hand-written error JSON", fset.Position(n.Pos()))
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestHandleErrorCatalog(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	rec := httptest.NewRecorder()
+	h.HandleErrorCatalog(rec, httptest.NewRequest(http.MethodGet, "/errors", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var entries []apierror.CatalogEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(apierror.Catalog()) {
+		t.Errorf("got %d entries, want %d", len(entries), len(apierror.Catalog()))
+	}
+	for _, e := range entries {
+		if e.Code == apierror.CodeRateLimited && e.Status != http.StatusTooManyRequests {
+			t.Errorf("RATE_LIMITED status = %d", e.Status)
+		}
+	}
+}
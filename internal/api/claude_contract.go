@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// claudeContractResponse is a ClaudeContractStatus with its verdict.
+type claudeContractResponse struct {
+	sandbox.ClaudeContractStatus
+	Compatible bool `json:"compatible"`
+}
+
+// HandleClaudeContract serves GET /admin/claude-contract, the last contract
+// check of the claude image, and POST /admin/claude-contract, which runs it
+// again now rather than when the image's digest next changes.
+func (h *Handlers) HandleClaudeContract(w http.ResponseWriter, r *http.Request) {
+	checker, ok := h.backend.(sandbox.ClaudeContractChecker)
+	if !ok {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "the backend does not check the claude image"))
+		return
+	}
+	status := checker.ClaudeContract(r.Context(), r.Method == http.MethodPost)
+	writeJSON(w, http.StatusOK, claudeContractResponse{ClaudeContractStatus: status, Compatible: status.Compatible()})
+}
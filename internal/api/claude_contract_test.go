@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// contractBackend reports a fixed contract check and whether it was forced.
+type contractBackend struct {
+	mockBackend
+	status  sandbox.ClaudeContractStatus
+	recheck bool
+}
+
+func (b *contractBackend) ClaudeContract(_ context.Context, recheck bool) sandbox.ClaudeContractStatus {
+	b.recheck = recheck
+	return b.status
+}
+
+func TestHandleClaudeContract(t *testing.T) {
+	backend := &contractBackend{status: sandbox.ClaudeContractStatus{
+		Image: "sandbox-claude:latest", Digest: "sha256:aaa",
+		Manifest: &sandbox.ClaudeManifest{Contract: 1, ClaudeVersion: "1.0.30"},
+		Missing:  []string{"env:ANTHROPIC_BASE_URL"},
+	}}
+	h := newTestHandlers(backend)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rec := httptest.NewRecorder()
+		h.HandleClaudeContract(rec, httptest.NewRequest(method, "/admin/claude-contract", nil))
+		var resp claudeContractResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK || resp.Compatible || resp.Digest != "sha256:aaa" || len(resp.Missing) != 1 {
+			t.Errorf("%s: status %d, %+v", method, rec.Code, resp)
+		}
+		if backend.recheck != (method == http.MethodPost) {
+			t.Errorf("%s: recheck %v", method, backend.recheck)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newTestHandlers(&mockBackend{}).HandleClaudeContract(rec, httptest.NewRequest(http.MethodGet, "/admin/claude-contract", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("backend without the check: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+)
+
+// TokenBroker is what caller tokens need from the auth proxy: a secret that
+// stands in for a token until it is revoked. *proxy.AuthProxy implements it.
+type TokenBroker interface {
+	Register(token string) (string, error)
+	Revoke(secret string)
+}
+
+// validClaudeToken matches Anthropic API keys (sk-ant-api03-...) and OAuth
+// tokens (sk-ant-oat01-...). Anything else is refused before it reaches the
+// proxy, so a typo fails here rather than as a 401 deep in a claude session.
+var validClaudeToken = regexp.MustCompile(`^sk-ant-(api|oat)[0-9]{2}-[A-Za-z0-9_-]{20,400}$`)
+
+// claudeTokens resolves a request's claude_token or claude_credential to a
+// token and registers it with the broker for the length of one execution.
+type claudeTokens struct {
+	broker      TokenBroker // nil until SetTokenBroker; requests are then refused
+	credentials map[string]claudeCredential
+}
+
+type claudeCredential struct {
+	token  string
+	owners map[string]bool // ownerHash of each allowed key
+}
+
+// newClaudeTokens reads the credentials' tokens from the environment. It
+// returns nil when caller tokens are disabled. A credential whose variable
+// is unset or not a token is left out, so using it is denied.
+func newClaudeTokens(cfg config.ClaudeTokensConfig) *claudeTokens {
+	if !cfg.Enabled {
+		return nil
+	}
+	t := &claudeTokens{credentials: make(map[string]claudeCredential, len(cfg.Credentials))}
+	for name, c := range cfg.Credentials {
+		token := os.Getenv(c.TokenEnv)
+		if !validClaudeToken.MatchString(token) {
+			log.Warn().Str("credential", name).Str("token_env", c.TokenEnv).Msg("claude credential unavailable: its variable is unset or not an Anthropic token")
+			continue
+		}
+		cred := claudeCredential{token: token, owners: make(map[string]bool, len(c.Keys))}
+		for _, key := range c.Keys {
+			cred.owners[ownerHash(key)] = true
+		}
+		t.credentials[name] = cred
+	}
+	return t
+}
+
+// claudeProxySecret registers the token req asks for and returns the
+// secret the container presents instead, with the func that revokes it.
+// Without claude_token or claude_credential the server's token is used,
+// under a secret of the execution's own with per-execution secrets and
+// otherwise under the shared one, for which it returns "". It writes the
+// error response and returns false on failure; the token itself never
+// appears in one.
+func (h *Handlers) claudeProxySecret(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (string, func(), bool) {
+	none := func() {}
+	fail := func(err *apierror.Error) (string, func(), bool) {
+		apierror.WriteError(w, r, err)
+		return "", none, false
+	}
+	if req.ClaudeToken == "" && req.ClaudeCredential == "" {
+		if req.Language != "claude" || h.execSecrets == nil {
+			return "", none, true
+		}
+		return h.registerProxySecret(w, r, h.execSecrets, "")
+	}
+	switch {
+	case req.Language != "claude":
+		return fail(apierror.New(apierror.CodeInvalidRequest, "claude_token and claude_credential are only accepted for claude"))
+	case req.ClaudeToken != "" && req.ClaudeCredential != "":
+		return fail(apierror.New(apierror.CodeInvalidRequest, "claude_token and claude_credential are mutually exclusive"))
+	}
+
+	token := req.ClaudeToken
+	if token != "" {
+		if !validClaudeToken.MatchString(token) {
+			return fail(apierror.New(apierror.CodeInvalidRequest, "claude_token is not an Anthropic API key or OAuth token"))
+		}
+	} else {
+		cred, ok := h.claudeTokens.credentials[req.ClaudeCredential]
+		if !ok || !cred.owners[workspaceOwner(r)] {
+			return fail(apierror.Newf(apierror.CodeCredentialDenied, "claude credential %q is not available to this caller", req.ClaudeCredential))
+		}
+		token = cred.token
+	}
+
+	broker := h.claudeTokens.broker
+	if broker == nil {
+		return fail(apierror.New(apierror.CodeClaudeTokenDisabled, "caller tokens need the auth proxy, which is not running"))
+	}
+	return h.registerProxySecret(w, r, broker, token)
+}
+
+// registerProxySecret registers token with broker for one execution; an
+// empty token stands for the server's.
+func (h *Handlers) registerProxySecret(w http.ResponseWriter, r *http.Request, broker TokenBroker, token string) (string, func(), bool) {
+	secret, err := broker.Register(token)
+	if err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInternal, "registering claude token: %v", err))
+		return "", func() {}, false
+	}
+	return secret, func() { broker.Revoke(secret) }, true
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+const (
+	callerToken = "sk-ant-REDACTED"
+	teamToken   = "sk-ant-REDACTED"
+)
+
+// fakeBroker hands out numbered secrets and remembers which are live.
+type fakeBroker struct {
+	mu      sync.Mutex
+	n       int
+	live    map[string]string // secret -> token
+	revoked []string
+}
+
+func (b *fakeBroker) Register(token string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.n++
+	secret := fmt.Sprintf("exec-secret-%d", b.n)
+	if b.live == nil {
+		b.live = make(map[string]string)
+	}
+	b.live[secret] = token
+	return secret, nil
+}
+
+func (b *fakeBroker) Revoke(secret string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.live, secret)
+	b.revoked = append(b.revoked, secret)
+}
+
+func newTokenHandlers(backend sandbox.Backend, broker TokenBroker) *Handlers {
+	h := newTestHandlers(backend)
+	h.claudeTokens = &claudeTokens{
+		broker: broker,
+		credentials: map[string]claudeCredential{
+			"team": {token: teamToken, owners: map[string]bool{ownerHash("team-key"): true}},
+		},
+	}
+	return h
+}
+
+// postAs posts body to handler as the given caller.
+func postAs(t *testing.T, handler http.HandlerFunc, caller string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/execute", bytes.NewReader(b))
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, caller))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestClaudeToken_RegisteredForTheExecution(t *testing.T) {
+	for _, tt := range []struct {
+		name, caller string
+		body         ExecutionRequest
+		wantToken    string
+	}{
+		{"own token", "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: callerToken}, callerToken},
+		{"credential", "team-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeCredential: "team"}, teamToken},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			var seen string // token live under the secret while the backend ran
+			backend := &mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}
+			check := &hookBackend{mockBackend: backend, hook: func(req sandbox.ExecutionRequest) {
+				broker.mu.Lock()
+				seen = broker.live[req.ProxySecret]
+				broker.mu.Unlock()
+			}}
+			h := newTokenHandlers(check, broker)
+
+			for _, handler := range []http.HandlerFunc{h.HandleExecute, h.HandleExecuteStream} {
+				seen = ""
+				w := postAs(t, handler, tt.caller, tt.body)
+				if w.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", w.Code, w.Body)
+				}
+				if seen != tt.wantToken {
+					t.Errorf("backend ran with secret %q for token %q, want %q", backend.req.ProxySecret, seen, tt.wantToken)
+				}
+			}
+			if len(broker.live) != 0 {
+				t.Errorf("secrets still registered after the executions: %v", broker.live)
+			}
+			if len(broker.revoked) != 2 || broker.revoked[0] == broker.revoked[1] {
+				t.Errorf("revoked %v, want one fresh secret per execution", broker.revoked)
+			}
+		})
+	}
+}
+
+// hookBackend calls hook with each request before answering like mockBackend.
+type hookBackend struct {
+	*mockBackend
+	hook func(sandbox.ExecutionRequest)
+}
+
+func (b *hookBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	b.hook(req)
+	return b.mockBackend.Execute(ctx, req)
+}
+
+func (b *hookBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, stdout, stderr io.Writer) (*sandbox.ExecutionResult, error) {
+	b.hook(req)
+	return b.mockBackend.ExecuteStreaming(ctx, req, stdout, stderr)
+}
+
+func TestClaudeToken_RevokedWhenTheExecutionFails(t *testing.T) {
+	broker := &fakeBroker{}
+	backend := &mockBackend{err: fmt.Errorf("%w: boom", sandbox.ErrInvalidRequest)}
+	h := newTokenHandlers(backend, broker)
+	w := postAs(t, h.HandleExecute, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: callerToken})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if len(broker.live) != 0 || len(broker.revoked) != 1 {
+		t.Errorf("live %v, revoked %v", broker.live, broker.revoked)
+	}
+}
+
+func TestClaudeToken_ServerTokenWhenAbsent(t *testing.T) {
+	broker := &fakeBroker{}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}
+	h := newTokenHandlers(backend, broker)
+	if w := postAs(t, h.HandleExecute, "user-key", ExecutionRequest{Language: "claude", Code: "hi"}); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if backend.req.ProxySecret != "" || broker.n != 0 {
+		t.Errorf("proxy secret %q, %d registrations", backend.req.ProxySecret, broker.n)
+	}
+}
+
+func TestExecutionSecrets(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AuthProxy.Port = 8081
+	cfg.AuthProxy.PerExecutionSecrets = true
+	broker := &fakeBroker{}
+	var seen string
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}
+	check := &hookBackend{mockBackend: backend, hook: func(req sandbox.ExecutionRequest) {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		token, ok := broker.live[req.ProxySecret]
+		seen = fmt.Sprintf("%q %t", token, ok)
+	}}
+	s := NewServer(cfg, check, nil, nil, monitor.NewMetrics())
+	s.SetTokenBroker(broker)
+	h := s.handlers
+
+	// Every claude execution gets a secret of its own for the server's
+	// token, live while it runs.
+	for _, handler := range []http.HandlerFunc{h.HandleExecute, h.HandleExecuteStream} {
+		if w := postAs(t, handler, "user-key", ExecutionRequest{Language: "claude", Code: "hi"}); w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if seen != `"" true` {
+			t.Errorf("backend ran with secret %q: registered token %s", backend.req.ProxySecret, seen)
+		}
+	}
+	if len(broker.live) != 0 || len(broker.revoked) != 2 || broker.revoked[0] == broker.revoked[1] {
+		t.Errorf("live %v, revoked %v", broker.live, broker.revoked)
+	}
+
+	// Nothing else is given one.
+	if w := postAs(t, h.HandleExecute, "user-key", ExecutionRequest{Language: "python", Code: "print(1)", Perms: Permissions{Network: NetworkPermissions{Enabled: true}}}); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if backend.req.ProxySecret != "" || broker.n != 2 {
+		t.Errorf("python: proxy secret %q, %d registrations", backend.req.ProxySecret, broker.n)
+	}
+}
+
+func TestClaudeToken_Rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		noBroker bool
+		caller   string
+		body     ExecutionRequest
+		wantCode apierror.Code
+	}{
+		{"disabled", true, false, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: callerToken}, apierror.CodeClaudeTokenDisabled},
+		{"disabled credential", true, false, "team-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeCredential: "team"}, apierror.CodeClaudeTokenDisabled},
+		{"no proxy", false, true, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: callerToken}, apierror.CodeClaudeTokenDisabled},
+		{"not claude", false, false, "user-key", ExecutionRequest{Language: "python", Code: "hi", ClaudeToken: callerToken}, apierror.CodeInvalidRequest},
+		{"both", false, false, "team-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: callerToken, ClaudeCredential: "team"}, apierror.CodeInvalidRequest},
+		{"not a token", false, false, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: "hunter2-not-a-token"}, apierror.CodeInvalidRequest},
+		{"wrong prefix", false, false, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeToken: "sk-proj-0123456789abcdef0123456789"}, apierror.CodeInvalidRequest},
+		{"unknown credential", false, false, "team-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeCredential: "other"}, apierror.CodeCredentialDenied},
+		{"credential of another caller", false, false, "user-key", ExecutionRequest{Language: "claude", Code: "hi", ClaudeCredential: "team"}, apierror.CodeCredentialDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			backend := &mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}
+			h := newTokenHandlers(backend, broker)
+			if tt.disabled {
+				h.claudeTokens = nil
+			}
+			if tt.noBroker {
+				h.claudeTokens.broker = nil
+			}
+
+			w := postAs(t, h.HandleExecute, tt.caller, tt.body)
+			var resp apierror.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode || w.Code != tt.wantCode.Status() {
+				t.Errorf("got %d %s, want %s", w.Code, resp.Code, tt.wantCode)
+			}
+			if backend.req.Code != "" || broker.n != 0 {
+				t.Error("rejected request reached the backend or the proxy")
+			}
+			if tt.body.ClaudeToken != "" && strings.Contains(w.Body.String(), tt.body.ClaudeToken) {
+				t.Errorf("error response echoes the token: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestValidClaudeToken(t *testing.T) {
+	for token, want := range map[string]bool{
+		callerToken:          true,
+		teamToken:            true,
+		"sk-ant-api03-short": false,
+		"sk-ant-REDACTED":     false,
+		"sk-ant-REDACTED 0123456789":    false,
+		" sk-ant-REDACTED":    false,
+		"sk-ant-REDACTED\n":   false,
+		"sk-ant-REDACTED": false,
+	} {
+		if got := validClaudeToken.MatchString(token); got != want {
+			t.Errorf("validClaudeToken(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestNewClaudeTokens(t *testing.T) {
+	if newClaudeTokens(config.ClaudeTokensConfig{}) != nil {
+		t.Error("disabled config built a resolver")
+	}
+	t.Setenv("TEAM_CLAUDE_TOKEN", teamToken)
+	t.Setenv("BAD_CLAUDE_TOKEN", "not-a-token")
+	tokens := newClaudeTokens(config.ClaudeTokensConfig{Enabled: true, Credentials: map[string]config.ClaudeCredential{
+		"team":  {TokenEnv: "TEAM_CLAUDE_TOKEN", Keys: []string{"team-key"}},
+		"bad":   {TokenEnv: "BAD_CLAUDE_TOKEN", Keys: []string{"team-key"}},
+		"unset": {TokenEnv: "UNSET_CLAUDE_TOKEN", Keys: []string{"team-key"}},
+	}})
+	if len(tokens.credentials) != 1 {
+		t.Fatalf("credentials = %v, want only team", tokens.credentials)
+	}
+	if c := tokens.credentials["team"]; c.token != teamToken || !c.owners[ownerHash("team-key")] {
+		t.Errorf("team = %+v", c)
+	}
+}
+
+// TestClaudeToken_NeverLeaks runs executions with a caller token through the
+// whole server and looks for it everywhere it could end up: logs, responses,
+// the audit fallback, lifecycle events and the request the backend gets.
+func TestClaudeToken_NeverLeaks(t *testing.T) {
+	const canary = "sk-ant-REDACTED"
+	var logs bytes.Buffer
+	defer func(l zerolog.Logger) { log.Logger = l }(log.Logger)
+	log.Logger = zerolog.New(&logs).Level(zerolog.TraceLevel)
+
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"user-key"}
+	cfg.Security.ClaudeTokens.Enabled = true
+	cfg.AuthProxy.Port = 8081
+	backend := &mockBackend{result: &sandbox.ExecutionResult{
+		ExitClass: sandbox.ExitUser,
+		Argv:      []string{"claude", "-p"},
+	}}
+	s := NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	broker := &fakeBroker{}
+	s.SetTokenBroker(broker)
+	handler := s.Handler()
+
+	var bodies []string
+	for _, path := range []string{"/execute", "/execute/stream"} {
+		for _, language := range []string{"claude", "python"} { // python is rejected
+			body := `{"language":"` + language + `","code":"hi","claude_token":"` + canary + `"}`
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			req.Header.Set("X-API-Key", "user-key")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			bodies = append(bodies, w.Body.String())
+		}
+	}
+	if broker.n != 2 {
+		t.Fatalf("%d registrations, want one per claude execution", broker.n)
+	}
+	if len(broker.live) != 0 {
+		t.Errorf("secrets left registered: %v", broker.live)
+	}
+
+	sent, _ := json.Marshal(backend.req)
+	audit, _ := json.Marshal(s.handlers.recent.snapshot())
+	events, _ := json.Marshal(s.handlers.executions.lifecycle())
+	places := map[string]string{
+		"logs":         logs.String(),
+		"responses":    strings.Join(bodies, "\n"),
+		"backend":      string(sent) + " " + backend.req.ProxySecret,
+		"audit":        string(audit),
+		"events":       string(events),
+		"admin config": adminConfig(t, s),
+	}
+	for place, content := range places {
+		if strings.Contains(content, canary) {
+			t.Errorf("%s contain the caller's token", place)
+		}
+	}
+}
+
+func adminConfig(t *testing.T, s *Server) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleAdminConfig(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	return w.Body.String()
+}
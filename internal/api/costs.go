@@ -0,0 +1,273 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+const (
+	// costWindow is the sliding window security.cost_budget.hourly covers.
+	costWindow = time.Hour
+	// costReferenceTimeout is the timeout at which an execution costs its
+	// language's cost: the handlers' default.
+	costReferenceTimeout = 10 * time.Second
+	// costRemainingHeader carries the caller's budget left after the
+	// execution was charged.
+	costRemainingHeader = "X-Sandbox-Cost-Remaining"
+	// costIdentityLabel is how many hex characters of the API key hash name
+	// a caller in the cost metric.
+	costIdentityLabel = 12
+)
+
+// costLimiter charges each execution a cost score against its caller's
+// hourly budget. Callers are identified as workspaceOwner does, so spend
+// rebuilt from the audit log's api_key_hash lands on the same caller.
+type costLimiter struct {
+	budget        float64
+	languageCosts map[string]float64
+	referenceMB   int64
+	exempt        map[string]bool // owner hashes
+	metrics       *monitor.Metrics
+	now           func() time.Time
+
+	mu        sync.Mutex
+	charges   map[string][]costCharge // oldest first
+	lastSweep time.Time
+}
+
+type costCharge struct {
+	at   time.Time
+	cost float64
+}
+
+// newCostLimiter returns nil when cfg has no budget.
+func newCostLimiter(cfg config.CostBudgetConfig, metrics *monitor.Metrics) *costLimiter {
+	if cfg.Hourly <= 0 {
+		return nil
+	}
+	c := &costLimiter{
+		budget:        cfg.Hourly,
+		languageCosts: cfg.LanguageCosts,
+		referenceMB:   sandbox.DefaultLimits().MemoryMB,
+		exempt:        make(map[string]bool, len(cfg.ExemptKeys)),
+		metrics:       metrics,
+		now:           time.Now,
+		charges:       make(map[string][]costCharge),
+	}
+	for _, key := range cfg.ExemptKeys {
+		c.exempt[ownerHash(key)] = true
+	}
+	return c
+}
+
+// score is the cost of running language with timeout and memoryMB: the
+// language's cost at the reference timeout and memory, scaled linearly.
+func (c *costLimiter) score(language string, timeout time.Duration, memoryMB int64) float64 {
+	cost, ok := c.languageCosts[language]
+	if !ok {
+		cost = 1
+	}
+	return cost * (timeout.Seconds() / costReferenceTimeout.Seconds()) * (float64(memoryMB) / float64(c.referenceMB))
+}
+
+// costBudgetError is a rejected charge.
+type costBudgetError struct {
+	cost      float64
+	remaining float64
+	resetAt   time.Time // zero when the cost exceeds the whole budget
+}
+
+// charge records cost against owner unless that would exceed the budget.
+// It returns the budget left after the charge, or the rejection.
+func (c *costLimiter) charge(owner string, cost float64) (float64, *costBudgetError) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+
+	charges := c.expire(owner, now)
+	spent := sumCharges(charges)
+	if spent+cost > c.budget {
+		rejected := &costBudgetError{cost: cost, remaining: math.Max(c.budget-spent, 0)}
+		if cost <= c.budget {
+			rejected.resetAt = c.resetAt(charges, spent, cost)
+		}
+		return rejected.remaining, rejected
+	}
+	c.charges[owner] = append(charges, costCharge{at: now, cost: cost})
+	c.report(owner, spent+cost)
+	return c.budget - spent - cost, nil
+}
+
+// refund takes back owner's latest charge of cost. Charges of equal cost are
+// interchangeable but for when they expire, which is close enough.
+func (c *costLimiter) refund(owner string, cost float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	charges := c.charges[owner]
+	for i := len(charges) - 1; i >= 0; i-- {
+		if charges[i].cost == cost {
+			c.charges[owner] = append(charges[:i:i], charges[i+1:]...)
+			c.report(owner, sumCharges(c.charges[owner]))
+			return
+		}
+	}
+}
+
+// seed restores charges read back from the audit log.
+func (c *costLimiter) seed(records []storage.CostRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.now().Add(-costWindow)
+	for _, rec := range records {
+		if rec.At.After(cutoff) {
+			c.charges[rec.APIKeyHash] = append(c.charges[rec.APIKeyHash], costCharge{at: rec.At, cost: rec.Cost})
+		}
+	}
+	for owner := range c.charges {
+		c.report(owner, sumCharges(c.charges[owner]))
+	}
+}
+
+// resetAt is when enough of charges will have left the window for cost to
+// fit.
+func (c *costLimiter) resetAt(charges []costCharge, spent, cost float64) time.Time {
+	for _, ch := range charges {
+		spent -= ch.cost
+		if spent+cost <= c.budget {
+			return ch.at.Add(costWindow)
+		}
+	}
+	return c.now()
+}
+
+// expire drops owner's charges older than the window and returns the rest.
+// Callers hold c.mu.
+func (c *costLimiter) expire(owner string, now time.Time) []costCharge {
+	charges := c.charges[owner]
+	cutoff := now.Add(-costWindow)
+	i := 0
+	for i < len(charges) && !charges[i].at.After(cutoff) {
+		i++
+	}
+	if i > 0 {
+		charges = charges[i:]
+		c.charges[owner] = charges
+		c.report(owner, sumCharges(charges))
+	}
+	return charges
+}
+
+// sweep forgets callers with nothing left in the window, once per window,
+// so the map doesn't grow with every identity ever seen. Callers hold c.mu.
+func (c *costLimiter) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < costWindow {
+		return
+	}
+	c.lastSweep = now
+	for owner := range c.charges {
+		if len(c.expire(owner, now)) == 0 {
+			delete(c.charges, owner)
+			if c.metrics != nil {
+				c.metrics.CostSpent.DeleteLabelValues(costIdentity(owner))
+			}
+		}
+	}
+}
+
+func (c *costLimiter) report(owner string, spent float64) {
+	if c.metrics != nil {
+		c.metrics.SetCostSpent(costIdentity(owner), spent)
+	}
+}
+
+func sumCharges(charges []costCharge) float64 {
+	var sum float64
+	for _, ch := range charges {
+		sum += ch.cost
+	}
+	return sum
+}
+
+// costIdentity names owner in the cost metric.
+func costIdentity(owner string) string {
+	if owner == "" {
+		return "anonymous"
+	}
+	return owner[:min(costIdentityLabel, len(owner))]
+}
+
+// chargeExecution charges the request's caller for an execution and sets
+// the remaining budget header. It returns the cost charged, 0 without a
+// budget or for exempt callers. It writes the error response and returns
+// false when the budget is spent.
+func (h *Handlers) chargeExecution(w http.ResponseWriter, r *http.Request, language string, timeout time.Duration, memoryMB int64) (float64, bool) {
+	owner := workspaceOwner(r)
+	if h.costs == nil || h.costs.exempt[owner] {
+		return 0, true
+	}
+	cost := h.costs.score(language, timeout, memoryMB)
+	remaining, rejected := h.costs.charge(owner, cost)
+	w.Header().Set(costRemainingHeader, formatCost(remaining))
+	if rejected == nil {
+		return cost, true
+	}
+
+	h.metrics.CostBudgetRejected()
+	details := map[string]any{"cost": roundCost(rejected.cost)}
+	msg := fmt.Sprintf("execution cost %s exceeds the hourly budget of %s", formatCost(rejected.cost), formatCost(h.costs.budget))
+	// An execution the whole budget can't cover never fits; a window from
+	// now is as good an answer as any.
+	retryAfter := costWindow
+	if !rejected.resetAt.IsZero() {
+		details["reset_at"] = rejected.resetAt.UTC().Format(time.RFC3339)
+		retryAfter = rejected.resetAt.Sub(h.costs.now())
+		msg = fmt.Sprintf("hourly cost budget exceeded: execution costs %s, %s left", formatCost(rejected.cost), formatCost(rejected.remaining))
+	}
+	writeThrottled(w, r, h.metrics, apierror.New(apierror.CodeCostBudgetExceeded, msg).WithDetails(details), apierror.Throttle{
+		Scope: apierror.ScopeKey, RetryAfter: retryAfter, Limit: roundCost(h.costs.budget), Remaining: roundCost(rejected.remaining),
+	})
+	return 0, false
+}
+
+// refundExecution takes back the charge for an execution that never started
+// a container.
+func (h *Handlers) refundExecution(r *http.Request, cost float64) {
+	if h.costs != nil && cost > 0 {
+		h.costs.refund(workspaceOwner(r), cost)
+	}
+}
+
+// turnedAway reports whether a backend error without a result means no
+// container was started.
+func turnedAway(err error) bool {
+	for _, target := range []error{
+		sandbox.ErrInvalidRequest, sandbox.ErrUnsupportedLang, sandbox.ErrRateLimited,
+		sandbox.ErrLanguageSaturated, sandbox.ErrWorkdirBusy, sandbox.ErrSecurityViolation,
+		sandbox.ErrContainerdDown, sandbox.ErrPoolExhausted, sandbox.ErrImagePullSuspended,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func roundCost(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func formatCost(v float64) string {
+	return strconv.FormatFloat(roundCost(v), 'f', -1, 64)
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// testClock is a settable clock for the cost limiter.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestCostLimiter(t *testing.T, cfg config.CostBudgetConfig) (*costLimiter, *testClock) {
+	t.Helper()
+	clock := &testClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	c := newCostLimiter(cfg, monitor.NewMetrics())
+	if c == nil {
+		t.Fatal("newCostLimiter returned nil for a budget")
+	}
+	c.now = clock.now
+	return c, clock
+}
+
+func TestNewCostLimiter_Disabled(t *testing.T) {
+	if c := newCostLimiter(config.CostBudgetConfig{}, nil); c != nil {
+		t.Error("limiter built without a budget")
+	}
+}
+
+func TestCostLimiter_Score(t *testing.T) {
+	c, _ := newTestCostLimiter(t, config.CostBudgetConfig{
+		Hourly:        100,
+		LanguageCosts: map[string]float64{"claude": 20, "bash": 0.5},
+	})
+	tests := []struct {
+		language string
+		timeout  time.Duration
+		memoryMB int64
+		want     float64
+	}{
+		{"python", 10 * time.Second, 256, 1}, // unlisted languages cost 1
+		{"bash", 10 * time.Second, 256, 0.5},
+		{"claude", 10 * time.Second, 256, 20},
+		{"python", 60 * time.Second, 256, 6},
+		{"python", 10 * time.Second, 1024, 4},
+		{"bash", 5 * time.Second, 128, 0.125},
+		{"claude", 30 * time.Minute, 512, 20 * 180 * 2},
+	}
+	for _, tt := range tests {
+		if got := c.score(tt.language, tt.timeout, tt.memoryMB); got != tt.want {
+			t.Errorf("score(%s, %s, %dMB) = %g, want %g", tt.language, tt.timeout, tt.memoryMB, got, tt.want)
+		}
+	}
+}
+
+func TestCostLimiter_WindowSlides(t *testing.T) {
+	c, clock := newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+
+	if remaining, err := c.charge("a", 4); err != nil || remaining != 6 {
+		t.Fatalf("first charge: remaining %g, err %v", remaining, err)
+	}
+	clock.advance(20 * time.Minute)
+	if remaining, err := c.charge("a", 5); err != nil || remaining != 1 {
+		t.Fatalf("second charge: remaining %g, err %v", remaining, err)
+	}
+
+	clock.advance(10 * time.Minute)
+	remaining, rejected := c.charge("a", 3)
+	if rejected == nil {
+		t.Fatal("charge over budget accepted")
+	}
+	if remaining != 1 || rejected.remaining != 1 || rejected.cost != 3 {
+		t.Errorf("rejection remaining %g/%g cost %g, want 1 and 3", remaining, rejected.remaining, rejected.cost)
+	}
+	// The first charge leaves the window an hour after it was made, which
+	// frees enough for 3.
+	if want := clock.t.Add(-30 * time.Minute).Add(time.Hour); !rejected.resetAt.Equal(want) {
+		t.Errorf("reset at %s, want %s", rejected.resetAt, want)
+	}
+	if remaining, err := c.charge("b", 3); err != nil || remaining != 7 {
+		t.Errorf("other caller: remaining %g, err %v", remaining, err)
+	}
+
+	clock.advance(30 * time.Minute) // exactly an hour after the first charge
+	if remaining, err := c.charge("a", 3); err != nil || remaining != 2 {
+		t.Errorf("after the first charge expired: remaining %g, err %v", remaining, err)
+	}
+	clock.advance(2 * time.Hour)
+	if remaining, err := c.charge("a", 10); err != nil || remaining != 0 {
+		t.Errorf("after the window emptied: remaining %g, err %v", remaining, err)
+	}
+}
+
+func TestCostLimiter_ResetNeedsSeveralCharges(t *testing.T) {
+	c, clock := newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+	start := clock.t
+	for range 5 {
+		if _, err := c.charge("a", 2); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Minute)
+	}
+	_, rejected := c.charge("a", 5)
+	if rejected == nil {
+		t.Fatal("charge over budget accepted")
+	}
+	// Three charges of 2 must expire before 5 fits in 10.
+	if want := start.Add(2 * time.Minute).Add(time.Hour); !rejected.resetAt.Equal(want) {
+		t.Errorf("reset at %s, want %s", rejected.resetAt, want)
+	}
+}
+
+func TestCostLimiter_OverWholeBudget(t *testing.T) {
+	c, _ := newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+	_, rejected := c.charge("a", 11)
+	if rejected == nil {
+		t.Fatal("charge over the whole budget accepted")
+	}
+	if !rejected.resetAt.IsZero() {
+		t.Errorf("reset at %s for a charge that never fits", rejected.resetAt)
+	}
+}
+
+func TestCostLimiter_Refund(t *testing.T) {
+	c, _ := newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+	c.charge("a", 2)
+	c.charge("a", 3)
+	c.refund("a", 3)
+	c.refund("a", 7) // never charged
+	if remaining, err := c.charge("a", 0); err != nil || remaining != 8 {
+		t.Errorf("after refund: remaining %g, err %v", remaining, err)
+	}
+}
+
+func TestCostLimiter_Seed(t *testing.T) {
+	c, clock := newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+	c.seed([]storage.CostRecord{
+		{APIKeyHash: "a", At: clock.t.Add(-2 * time.Hour), Cost: 9}, // outside the window
+		{APIKeyHash: "a", At: clock.t.Add(-50 * time.Minute), Cost: 4},
+		{APIKeyHash: "b", At: clock.t.Add(-time.Minute), Cost: 1},
+	})
+	if remaining, _ := c.charge("a", 1); remaining != 5 {
+		t.Errorf("a remaining %g, want 5", remaining)
+	}
+	clock.advance(10 * time.Minute)
+	if remaining, _ := c.charge("a", 1); remaining != 8 {
+		t.Errorf("a remaining after the seeded charge expired %g, want 8", remaining)
+	}
+	if got := metricValue(t, c.metrics.CostSpent.WithLabelValues("b")); got != 1 {
+		t.Errorf("cost_spent{identity=b} = %g, want 1", got)
+	}
+}
+
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Gauge != nil {
+		return out.Gauge.GetValue()
+	}
+	return out.Counter.GetValue()
+}
+
+// costRequest posts an execution as caller, "" for unauthenticated.
+func costRequest(t *testing.T, handler http.HandlerFunc, caller string, body map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/execute", bytes.NewReader(b))
+	if caller != "" {
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, caller))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleExecute_CostBudget(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x"}}
+	h := newTestHandlers(backend)
+	var clock *testClock
+	h.costs, clock = newTestCostLimiter(t, config.CostBudgetConfig{
+		Hourly:        10,
+		LanguageCosts: map[string]float64{"bash": 0.5},
+		ExemptKeys:    []string{"admin-key"},
+	})
+	h.costs.metrics = h.metrics
+
+	run := func(caller, language, timeout string) *httptest.ResponseRecorder {
+		return costRequest(t, h.HandleExecute, caller, map[string]any{"language": language, "code": "x", "timeout": timeout})
+	}
+
+	rec := run("key-a", "python", "40s")
+	if rec.Code != http.StatusOK || rec.Header().Get(costRemainingHeader) != "6" {
+		t.Fatalf("status %d, %s %q, want 200 and 6", rec.Code, costRemainingHeader, rec.Header().Get(costRemainingHeader))
+	}
+	// bash's default 128MB costs half of what the reference 256MB would.
+	rec = run("key-a", "bash", "10s")
+	if got := rec.Header().Get(costRemainingHeader); got != "5.75" {
+		t.Errorf("%s = %q, want 5.75", costRemainingHeader, got)
+	}
+
+	clock.advance(10 * time.Minute)
+	rec = run("key-a", "python", "60s")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over budget: status %d, want 429", rec.Code)
+	}
+	var resp apierror.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != apierror.CodeCostBudgetExceeded {
+		t.Errorf("code %s, want %s", resp.Code, apierror.CodeCostBudgetExceeded)
+	}
+	wantReset := clock.t.Add(-10 * time.Minute).Add(time.Hour)
+	if resp.Details["reset_at"] != wantReset.Format(time.RFC3339) || resp.Details["cost"] != 6.0 || resp.Details["remaining"] != 5.75 {
+		t.Errorf("details %v, want reset_at %s, cost 6, remaining 5.75", resp.Details, wantReset.Format(time.RFC3339))
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3000" {
+		t.Errorf("Retry-After = %q, want 3000", got)
+	}
+	if resp.Details["scope"] != apierror.ScopeKey || resp.Details["retry_after_ms"] != 3000000.0 || resp.Details["limit"] != 10.0 {
+		t.Errorf("throttle details %v, want scope key, 3000000ms, limit 10", resp.Details)
+	}
+	if got := metricValue(t, h.metrics.Throttles.WithLabelValues(apierror.ScopeKey)); got != 1 {
+		t.Errorf("throttled{key} = %g, want 1", got)
+	}
+	if got := rec.Header().Get(costRemainingHeader); got != "5.75" {
+		t.Errorf("%s on rejection = %q, want 5.75", costRemainingHeader, got)
+	}
+	if got := metricValue(t, h.metrics.CostRejections); got != 1 {
+		t.Errorf("rejections = %g, want 1", got)
+	}
+	if got := metricValue(t, h.metrics.CostSpent.WithLabelValues(costIdentity(ownerHash("key-a")))); got != 4.25 {
+		t.Errorf("cost_spent = %g, want 4.25", got)
+	}
+
+	// Other callers have their own budget; exempt ones have none.
+	if rec := run("key-b", "python", "60s"); rec.Code != http.StatusOK || rec.Header().Get(costRemainingHeader) != "4" {
+		t.Errorf("key-b: status %d, remaining %q, want 200 and 4", rec.Code, rec.Header().Get(costRemainingHeader))
+	}
+	for range 3 {
+		if rec := run("admin-key", "python", "60s"); rec.Code != http.StatusOK || rec.Header().Get(costRemainingHeader) != "" {
+			t.Fatalf("exempt key: status %d, remaining %q, want 200 and no header", rec.Code, rec.Header().Get(costRemainingHeader))
+		}
+	}
+
+	clock.advance(50 * time.Minute)
+	if rec := run("key-a", "python", "60s"); rec.Code != http.StatusOK || rec.Header().Get(costRemainingHeader) != "4" {
+		t.Errorf("after the window slid: status %d, remaining %q, want 200 and 4", rec.Code, rec.Header().Get(costRemainingHeader))
+	}
+}
+
+func TestHandleExecute_CostRefundedWhenTurnedAway(t *testing.T) {
+	backend := &mockBackend{err: &sandbox.SaturationError{Pool: "python", RetryAfter: time.Second}}
+	h := newTestHandlers(backend)
+	h.costs, _ = newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10})
+
+	body := map[string]any{"language": "python", "code": "x"}
+	if rec := costRequest(t, h.HandleExecute, "key", body); rec.Code != http.StatusTooManyRequests || rec.Header().Get(costRemainingHeader) != "9" {
+		t.Fatalf("saturated: status %d, remaining %q", rec.Code, rec.Header().Get(costRemainingHeader))
+	}
+	backend.err = nil
+	backend.result = &sandbox.ExecutionResult{ID: "x"}
+	if rec := costRequest(t, h.HandleExecute, "key", body); rec.Header().Get(costRemainingHeader) != "9" {
+		t.Errorf("remaining %q after a refunded execution, want 9", rec.Header().Get(costRemainingHeader))
+	}
+
+	// A timeout without a result ran a container and stays charged.
+	backend.result, backend.err = nil, sandbox.ErrTimeout
+	costRequest(t, h.HandleExecute, "key", body)
+	backend.result, backend.err = &sandbox.ExecutionResult{ID: "x"}, nil
+	if rec := costRequest(t, h.HandleExecute, "key", body); rec.Header().Get(costRemainingHeader) != "7" {
+		t.Errorf("remaining %q after a timeout, want 7", rec.Header().Get(costRemainingHeader))
+	}
+}
+
+func TestHandleExecuteStream_CostBudget(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x"}})
+	h.costs, _ = newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 10, LanguageCosts: map[string]float64{"claude": 8}})
+
+	body := map[string]any{"language": "claude", "code": "x", "limits": map[string]any{"memory_mb": 256}}
+	rec := costRequest(t, h.HandleExecuteStream, "key", body)
+	if rec.Code != http.StatusOK || rec.Header().Get(costRemainingHeader) != "2" {
+		t.Fatalf("status %d, remaining %q, want 200 and 2", rec.Code, rec.Header().Get(costRemainingHeader))
+	}
+	rec = costRequest(t, h.HandleExecuteStream, "key", body)
+	if rec.Code != http.StatusTooManyRequests || strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+		t.Errorf("over budget: status %d, content type %q, want a plain 429", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+const contextKeyWriteDeadline contextKey = "write_deadline"
+
+// errIdleOutput is the cause of a claude stream's context being canceled by
+// its idle watchdog.
+var errIdleOutput = errors.New("no output within sandbox.claude_idle_output_timeout")
+
+// writeDeadline extends the write deadline of one connection.
+type writeDeadline struct {
+	rc      *http.ResponseController
+	timeout time.Duration // 0 clears the deadline
+}
+
+// ClaudeWriteDeadlineMiddleware wraps the routes that may run claude. The
+// server's write_timeout is sized for everything else, and the language is
+// only known once the handler has decoded the body, so the handler calls
+// extendClaudeWriteDeadline then.
+func ClaudeWriteDeadlineMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wd := &writeDeadline{rc: http.NewResponseController(w), timeout: timeout}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyWriteDeadline, wd)))
+		})
+	}
+}
+
+// extendClaudeWriteDeadline gives a claude request server.claude_write_timeout
+// from now to write its response. Routes outside
+// ClaudeWriteDeadlineMiddleware keep server.write_timeout.
+func extendClaudeWriteDeadline(r *http.Request) {
+	wd, ok := r.Context().Value(contextKeyWriteDeadline).(*writeDeadline)
+	if !ok {
+		return
+	}
+	var deadline time.Time
+	if wd.timeout > 0 {
+		deadline = time.Now().Add(wd.timeout)
+	}
+	if err := wd.rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Warn().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("could not extend the write deadline for claude")
+	}
+}
+
+// executionTimeout resolves the request's timeout or deadline into the
+// timeout the backend enforces and the deadline that comes to, both on the
+// server's clock. A deadline that has passed or is beyond the language's
+// maximum timeout is rejected with the server's time in the details, so a
+// client can tell its clock is off.
+func (h *Handlers) executionTimeout(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (time.Duration, time.Time, bool) {
+	now := h.now()
+	if req.Deadline == nil {
+		timeout := sandbox.DefaultTimeout(req.Language)
+		if req.Timeout.Duration > 0 {
+			timeout = req.Timeout.Duration
+		}
+		return timeout, now.Add(timeout), true
+	}
+	if req.Timeout.Duration != 0 {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "timeout and deadline are mutually exclusive"))
+		return 0, time.Time{}, false
+	}
+
+	deadline := *req.Deadline
+	timeout := deadline.Sub(now)
+	maxTimeout := sandbox.MaxTimeout(req.Language)
+	var msg string
+	switch {
+	case timeout <= 0:
+		msg = fmt.Sprintf("deadline %s has passed: server time is %s", deadline.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano))
+	case timeout > maxTimeout:
+		msg = fmt.Sprintf("deadline %s is %s after server time %s, beyond the %s maximum timeout for %s",
+			deadline.Format(time.RFC3339Nano), timeout.Round(time.Millisecond), now.Format(time.RFC3339Nano), maxTimeout, req.Language)
+	default:
+		return timeout, deadline, true
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidDeadline, msg).WithDetails(map[string]any{
+		"deadline":    deadline.Format(time.RFC3339Nano),
+		"server_time": now.Format(time.RFC3339Nano),
+		"max_timeout": maxTimeout.String(),
+	}))
+	return 0, time.Time{}, false
+}
+
+// idleOutputTimeout checks the request's idle_output_timeout against
+// sandbox.max_idle_output_timeout. 0, the default, leaves the execution to
+// run to its timeout however quiet it is.
+func (h *Handlers) idleOutputTimeout(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (time.Duration, bool) {
+	idle := req.IdleOutputTimeout.Duration
+	switch {
+	case idle == 0:
+		return 0, true
+	case idle < 0:
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "idle_output_timeout must be positive"))
+	case idle > h.maxIdleTimeout:
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "idle_output_timeout %s is beyond the %s maximum", idle, h.maxIdleTimeout).
+			WithDetails(map[string]any{"max_idle_output_timeout": h.maxIdleTimeout.String()}))
+	default:
+		return idle, true
+	}
+	return 0, false
+}
+
+// idleWarning is the warning event sent when a stream has been silent for
+// half its idle_output_timeout, left before it is stopped.
+func idleWarning(timeout, left time.Duration) []byte {
+	left = left.Round(time.Millisecond)
+	data, _ := json.Marshal(stream.Warning{
+		Code:    "idle_output",
+		Message: fmt.Sprintf("no output for %s; the execution will be stopped in %s unless it writes something", (timeout - left).Round(time.Millisecond), left),
+		AbortIn: left.String(),
+	})
+	return data
+}
+
+// idleWatchdog cancels a claude stream's execution once its container has
+// written nothing for the timeout: no stdout, no stderr and, for the docker
+// backend, no stream-json events into the progress tracker. A session stuck
+// on a hung tool otherwise holds its slot until the full claude timeout.
+type idleWatchdog struct {
+	timeout  time.Duration
+	progress *sandbox.ProgressTracker // nil when the backend doesn't feed one
+	last     atomic.Int64             // unix nanos of the latest output write
+	cancel   context.CancelCauseFunc
+	stop     chan struct{}
+	fired    atomic.Bool
+}
+
+// startIdleWatchdog returns the context to run the execution under. The
+// clock starts now, so it also covers waiting for a slot and pulling the
+// image. Stop must be called once the execution has returned.
+func startIdleWatchdog(ctx context.Context, timeout time.Duration, progress *sandbox.ProgressTracker) (context.Context, *idleWatchdog) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	d := &idleWatchdog{timeout: timeout, progress: progress, cancel: cancel, stop: make(chan struct{})}
+	d.last.Store(time.Now().UnixNano())
+	go d.run()
+	return ctx, d
+}
+
+func (d *idleWatchdog) run() {
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-timer.C:
+			idle := time.Since(d.lastOutput())
+			if idle >= d.timeout {
+				d.fired.Store(true)
+				d.cancel(errIdleOutput)
+				return
+			}
+			timer.Reset(d.timeout - idle)
+		}
+	}
+}
+
+func (d *idleWatchdog) lastOutput() time.Time {
+	last := time.Unix(0, d.last.Load())
+	if d.progress != nil {
+		if p := d.progress.LastWrite(); p.After(last) {
+			return p
+		}
+	}
+	return last
+}
+
+// Output wraps an output writer so that writes to it hold the watchdog off.
+func (d *idleWatchdog) Output(w io.Writer) io.Writer {
+	return idleOutput{d: d, w: w}
+}
+
+type idleOutput struct {
+	d *idleWatchdog
+	w io.Writer
+}
+
+func (o idleOutput) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.d.last.Store(time.Now().UnixNano())
+	}
+	return o.w.Write(p)
+}
+
+// Stop ends the watchdog and releases its context. It reports whether the
+// watchdog had aborted the execution.
+func (d *idleWatchdog) Stop() bool {
+	close(d.stop)
+	d.cancel(nil)
+	return d.fired.Load()
+}
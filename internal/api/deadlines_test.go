@@ -0,0 +1,404 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// pacedBackend writes chunks output writes delay apart, then returns. It
+// stops early, like a killed container, when the context is canceled.
+type pacedBackend struct {
+	delay    time.Duration
+	chunks   int
+	progress bool // write into the claude progress tracker instead of stdout
+}
+
+func (b *pacedBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (b *pacedBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	out := stdout
+	if b.progress {
+		out = req.Progress
+	}
+	for range b.chunks {
+		select {
+		case <-ctx.Done():
+			return &sandbox.ExecutionResult{ID: req.ID, ExitCode: -1, ExitClass: sandbox.ExitManualKill},
+				&sandbox.ExecutionError{ExecID: req.ID, Op: "docker_run", Err: ctx.Err()}
+		case <-time.After(b.delay):
+			out.Write([]byte("tick\n"))
+		}
+	}
+	return &sandbox.ExecutionResult{ID: req.ID, ExitClass: sandbox.ExitUser}, nil
+}
+
+func (b *pacedBackend) Close() error { return nil }
+func (b *pacedBackend) Name() string { return "docker" }
+
+// startDeadlineServer serves NewServer over HTTP with a 100ms write_timeout,
+// as Start would.
+func startDeadlineServer(t *testing.T, backend sandbox.Backend) *httptest.Server {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowUnauthenticated = true
+	cfg.Server.WriteTimeout = 100 * time.Millisecond
+	cfg.Sandbox.ClaudeIdleOutputTimeout = 0
+	s := NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.Config.WriteTimeout = s.httpServer.WriteTimeout
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func post(t *testing.T, url, body string) (*http.Response, error) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(strings.NewReader(string(data)))
+	return resp, err
+}
+
+func TestWriteTimeout_NonClaudeCut(t *testing.T) {
+	ts := startDeadlineServer(t, &pacedBackend{delay: 100 * time.Millisecond, chunks: 3})
+
+	if resp, err := post(t, ts.URL+"/execute", `{"language":"python","code":"1"}`); err == nil {
+		t.Fatalf("python response past write_timeout got through: %d", resp.StatusCode)
+	}
+}
+
+func TestWriteTimeout_ClaudeOutlivesIt(t *testing.T) {
+	ts := startDeadlineServer(t, &pacedBackend{delay: 100 * time.Millisecond, chunks: 3})
+
+	resp, err := post(t, ts.URL+"/execute", `{"language":"claude","code":"hi"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("claude /execute status = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = post(t, ts.URL+"/execute/stream", `{"language":"claude","code":"hi"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if done := doneEvent(t, string(body)); done.ExitClass != string(sandbox.ExitUser) {
+		t.Errorf("done = %+v", done)
+	}
+	if n := stdoutBytes(t, string(body)); n != 3*len("tick\n") {
+		t.Errorf("streamed %d stdout bytes, want all of them", n)
+	}
+}
+
+func streamClaude(h *Handlers) string {
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"language":"claude","code":"hi"}`)
+	h.HandleExecuteStream(w, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+	return w.Body.String()
+}
+
+func TestHandleExecuteStream_IdleOutputAborts(t *testing.T) {
+	h := newTestHandlers(&pacedBackend{delay: time.Hour, chunks: 1})
+	h.claudeIdleTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	events := readEvents(t, streamClaude(h))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("abort took %s", elapsed)
+	}
+	if len(events) == 0 || events[len(events)-1].Type != stream.EventError {
+		t.Fatalf("events = %+v, want an error event last", events)
+	}
+	var got stream.Error
+	if err := events[len(events)-1].Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != string(apierror.CodeIdleOutputTimeout) {
+		t.Errorf("error code = %s, want %s", got.Code, apierror.CodeIdleOutputTimeout)
+	}
+	for _, e := range events {
+		if e.Type == stream.EventDone {
+			t.Error("aborted stream sent a done event")
+		}
+	}
+	if recent := h.recent.snapshot(); len(recent) != 1 || recent[0].Status != state.Timeout {
+		t.Errorf("audit = %+v, want one idle_timeout execution", recent)
+	}
+}
+
+func TestHandleExecuteStream_OutputHoldsIdleOff(t *testing.T) {
+	for name, backend := range map[string]*pacedBackend{
+		"stdout":      {delay: 20 * time.Millisecond, chunks: 10},
+		"stream-json": {delay: 20 * time.Millisecond, chunks: 10, progress: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandlers(backend)
+			h.claudeIdleTimeout = 100 * time.Millisecond
+
+			if done := doneEvent(t, streamClaude(h)); done.ExitClass != string(sandbox.ExitUser) {
+				t.Errorf("done = %+v, want a success", done)
+			}
+		})
+	}
+}
+
+func TestHandleExecuteStream_IdleOnlyForClaude(t *testing.T) {
+	h := newTestHandlers(&pacedBackend{delay: 100 * time.Millisecond, chunks: 1})
+	h.claudeIdleTimeout = 20 * time.Millisecond
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"language":"python","code":"1"}`)
+	h.HandleExecuteStream(w, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+	if done := doneEvent(t, w.Body.String()); done.ExitClass != string(sandbox.ExitUser) {
+		t.Errorf("done = %+v, want a success", done)
+	}
+}
+
+func TestExecutionDeadline(t *testing.T) {
+	serverTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return serverTime.Add(d).Format(time.RFC3339Nano) }
+	tests := []struct {
+		name        string
+		body        map[string]any
+		wantTimeout time.Duration
+		wantCode    apierror.Code
+		wantMsg     string
+	}{
+		{"default", map[string]any{}, 10 * time.Second, "", ""},
+		{"timeout", map[string]any{"timeout": "30s"}, 30 * time.Second, "", ""},
+		{"deadline", map[string]any{"deadline": at(45 * time.Second)}, 45 * time.Second, "", ""},
+		{"deadline in another zone", map[string]any{"deadline": "2026-03-01T13:00:20+01:00"}, 20 * time.Second, "", ""},
+		{"fractional deadline", map[string]any{"deadline": at(1500 * time.Millisecond)}, 1500 * time.Millisecond, "", ""},
+		{"claude allows longer", map[string]any{"language": "claude", "deadline": at(20 * time.Minute)}, 20 * time.Minute, "", ""},
+		{"both", map[string]any{"timeout": "5s", "deadline": at(5 * time.Second)}, 0, apierror.CodeInvalidRequest, "timeout and deadline are mutually exclusive"},
+		{"past", map[string]any{"deadline": at(-time.Second)}, 0, apierror.CodeInvalidDeadline,
+			"deadline 2026-03-01T11:59:59Z has passed: server time is 2026-03-01T12:00:00Z"},
+		{"now", map[string]any{"deadline": at(0)}, 0, apierror.CodeInvalidDeadline, "has passed"},
+		{"beyond max", map[string]any{"deadline": at(90 * time.Second)}, 0, apierror.CodeInvalidDeadline,
+			"deadline 2026-03-01T12:01:30Z is 1m30s after server time 2026-03-01T12:00:00Z, beyond the 1m0s maximum timeout for python"},
+		{"beyond claude max", map[string]any{"language": "claude", "deadline": at(31 * time.Minute)}, 0, apierror.CodeInvalidDeadline, "beyond the 30m0s maximum timeout for claude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, endpoint := range []string{"execute", "stream"} {
+				backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+				h := newTestHandlers(backend)
+				h.now = func() time.Time { return serverTime }
+				body := map[string]any{"language": "python", "code": "print(1)"}
+				for k, v := range tt.body {
+					body[k] = v
+				}
+				handler := h.HandleExecute
+				if endpoint == "stream" {
+					handler = h.HandleExecuteStream
+				}
+				w := postJSON(t, handler, body)
+
+				if tt.wantCode != "" {
+					var resp apierror.Response
+					if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+						t.Fatal(err)
+					}
+					if w.Code != tt.wantCode.Status() || resp.Code != tt.wantCode || !strings.Contains(resp.Error, tt.wantMsg) {
+						t.Fatalf("%s: %d %s %q, want %s containing %q", endpoint, w.Code, resp.Code, resp.Error, tt.wantCode, tt.wantMsg)
+					}
+					if tt.wantCode == apierror.CodeInvalidDeadline && resp.Details["server_time"] != "2026-03-01T12:00:00Z" {
+						t.Errorf("%s: details %v, want the server time", endpoint, resp.Details)
+					}
+					if backend.req.Code != "" {
+						t.Errorf("%s: backend ran a rejected request", endpoint)
+					}
+					continue
+				}
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: %d %s", endpoint, w.Code, w.Body)
+				}
+				if backend.req.Timeout != tt.wantTimeout {
+					t.Errorf("%s: backend timeout %s, want %s", endpoint, backend.req.Timeout, tt.wantTimeout)
+				}
+				var timeout string
+				var deadline time.Time
+				if endpoint == "stream" {
+					done := doneEvent(t, w.Body.String())
+					timeout, deadline = done.Timeout, done.Deadline
+				} else {
+					var resp ExecutionResponse
+					if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+						t.Fatal(err)
+					}
+					timeout, deadline = resp.Timeout, resp.Deadline
+				}
+				if timeout != tt.wantTimeout.String() || !deadline.Equal(serverTime.Add(tt.wantTimeout)) {
+					t.Errorf("%s: reported timeout %s, deadline %s; want %s, %s", endpoint, timeout, deadline, tt.wantTimeout, serverTime.Add(tt.wantTimeout))
+				}
+			}
+		})
+	}
+}
+
+func TestExecutionDeadline_Malformed(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	w := postJSON(t, h.HandleExecute, map[string]any{"language": "python", "code": "print(1)", "deadline": "in 5 minutes"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400 for a deadline that isn't RFC3339", w.Code)
+	}
+}
+
+// quietBackend plays a program that prints once and then hangs, under a
+// runner whose idle watchdog warns at half the request's
+// idle_output_timeout and stops it at the threshold. Without one it runs
+// to its timeout.
+type quietBackend struct{}
+
+func (b quietBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (quietBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	start := time.Now()
+	stdout.Write([]byte("started\n"))
+	if req.IdleOutputTimeout == 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(req.Timeout):
+		}
+		return &sandbox.ExecutionResult{ID: req.ID, Output: "started\n", ExitCode: -1, ExitClass: sandbox.ExitTimeoutKill}, sandbox.ErrTimeout
+	}
+	time.Sleep(req.IdleOutputTimeout / 2)
+	if req.IdleWarning != nil {
+		req.IdleWarning(req.IdleOutputTimeout / 2)
+	}
+	time.Sleep(req.IdleOutputTimeout / 2)
+	return &sandbox.ExecutionResult{
+		ID:        req.ID,
+		Output:    "started\n",
+		ExitCode:  -1,
+		ExitClass: sandbox.ExitIdleTimeout,
+		Duration:  time.Since(start),
+		Idle:      &sandbox.IdleStop{Timeout: req.IdleOutputTimeout, LastOutput: start},
+	}, sandbox.ErrIdleTimeout
+}
+
+func (quietBackend) Close() error { return nil }
+func (quietBackend) Name() string { return "docker" }
+
+func TestHandleExecute_IdleOutputTimeout(t *testing.T) {
+	h := newTestHandlers(quietBackend{})
+	h.maxIdleTimeout = time.Minute
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"language":"python","code":"1","timeout":"30s","idle_output_timeout":"100ms"}`)
+	h.HandleExecute(w, httptest.NewRequest(http.MethodPost, "/execute", body))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("took %s, want stopped at the idle threshold, not the 30s timeout", elapsed)
+	}
+
+	var resp ExecutionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status %d body %s: %v", w.Code, w.Body, err)
+	}
+	if w.Code != http.StatusOK || resp.ExitClass != string(sandbox.ExitIdleTimeout) {
+		t.Fatalf("status %d exit class %q, want 200 idle_timeout", w.Code, resp.ExitClass)
+	}
+	if resp.IdleTimeout == nil || resp.IdleTimeout.Timeout != "100ms" || resp.IdleTimeout.LastOutput.IsZero() || resp.IdleTimeout.OutputAt != "0s" {
+		t.Errorf("idle_timeout = %+v", resp.IdleTimeout)
+	}
+	if got := metricValue(t, h.metrics.ExecutionsTotal.WithLabelValues("docker", "python", string(state.Timeout), "false")); got != 1 {
+		t.Errorf("executions_total{status=idle_timeout} = %g, want 1", got)
+	}
+	if recent := h.recent.snapshot(); len(recent) != 1 || recent[0].Status != state.Timeout {
+		t.Errorf("audit = %+v, want one idle_timeout execution", recent)
+	}
+}
+
+func TestHandleExecuteStream_IdleOutputWarns(t *testing.T) {
+	h := newTestHandlers(quietBackend{})
+	h.maxIdleTimeout = time.Minute
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"language":"python","code":"1","timeout":"30s","idle_output_timeout":"100ms"}`)
+	h.HandleExecuteStream(w, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+
+	var warned bool
+	for _, e := range readEvents(t, w.Body.String()) {
+		switch e.Type {
+		case stream.EventWarning:
+			var warning stream.Warning
+			if err := e.Decode(&warning); err != nil {
+				t.Fatal(err)
+			}
+			if warning.Code != "idle_output" || warning.AbortIn != "50ms" {
+				t.Errorf("warning = %+v", warning)
+			}
+			warned = true
+		case stream.EventDone:
+			if !warned {
+				t.Error("done arrived before any warning")
+			}
+			var done stream.Done
+			if err := e.Decode(&done); err != nil {
+				t.Fatal(err)
+			}
+			if done.ExitClass != string(sandbox.ExitIdleTimeout) || done.IdleTimeout == nil || done.IdleTimeout.Timeout != "100ms" {
+				t.Errorf("done = %+v", done)
+			}
+		}
+	}
+	if !warned {
+		t.Fatal("no warning event")
+	}
+	if recent := h.recent.snapshot(); len(recent) != 1 || recent[0].Status != state.Timeout {
+		t.Errorf("audit = %+v, want one idle_timeout execution", recent)
+	}
+}
+
+func TestIdleOutputTimeout_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    string
+		max     time.Duration
+		wantMsg string
+	}{
+		{"negative", "-1s", time.Minute, "idle_output_timeout must be positive"},
+		{"disabled", "1s", 0, "idle_output_timeout is disabled on this server"},
+		{"beyond max", "2m", time.Minute, "idle_output_timeout 2m0s is beyond the 1m0s maximum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandlers(quietBackend{})
+			h.maxIdleTimeout = tt.max
+			w := httptest.NewRecorder()
+			body := strings.NewReader(`{"language":"python","code":"1","idle_output_timeout":"` + tt.idle + `"}`)
+			h.HandleExecute(w, httptest.NewRequest(http.MethodPost, "/execute", body))
+			var resp apierror.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || resp.Code != apierror.CodeInvalidRequest || resp.Error != tt.wantMsg {
+				t.Errorf("status %d %s %q, want 400 %s %q", w.Code, resp.Code, resp.Error, apierror.CodeInvalidRequest, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// newDebugTracers is the ownerHash of each key in sandbox.debug_trace.keys,
+// the callers that may ask for a debug trace. It returns nil when debug
+// traces are off.
+func newDebugTracers(cfg config.DebugTraceConfig) map[string]bool {
+	if cfg.Strace == "" {
+		return nil
+	}
+	tracers := make(map[string]bool, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if key != "" {
+			tracers[ownerHash(key)] = true
+		}
+	}
+	return tracers
+}
+
+// checkDebugTrace refuses debug_trace for claude and from a caller not in
+// sandbox.debug_trace.keys: a trace slows the code down and shows what it
+// touched. checkCapabilities has refused it already when traces are off.
+// It writes the error response and returns false when req is refused.
+func (h *Handlers) checkDebugTrace(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) bool {
+	switch {
+	case !req.DebugTrace:
+		return true
+	case req.Language == "claude":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "debug_trace is not supported for claude"))
+		return false
+	case !h.debugTracers[workspaceOwner(r)]:
+		apierror.WriteError(w, r, apierror.New(apierror.CodeDebugTraceDenied, "this caller may not ask for debug traces"))
+		return false
+	}
+	return true
+}
+
+// newDebugTrace is a backend trace in the API's terms.
+func newDebugTrace(t *sandbox.DebugTrace) *DebugTrace {
+	if t == nil {
+		return nil
+	}
+	out := &DebugTrace{
+		Trace:     t.Trace,
+		Truncated: t.Truncated,
+		Summary: stream.TraceSummary{
+			Calls:       t.Summary.Calls,
+			Failed:      t.Summary.Failed,
+			DeniedPaths: t.Summary.DeniedPaths,
+		},
+	}
+	for _, f := range t.Summary.Failures {
+		out.Summary.Failures = append(out.Summary.Failures, stream.TraceFailure(f))
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+func TestNewDebugTracers(t *testing.T) {
+	if newDebugTracers(config.DebugTraceConfig{Keys: []string{"k"}}) != nil {
+		t.Error("tracers without sandbox.debug_trace.strace")
+	}
+	tracers := newDebugTracers(config.DebugTraceConfig{Strace: "/opt/strace", Keys: []string{"k", ""}})
+	if !reflect.DeepEqual(tracers, map[string]bool{ownerHash("k"): true}) {
+		t.Errorf("tracers = %v", tracers)
+	}
+}
+
+func TestHandleExecute_DebugTrace(t *testing.T) {
+	trace := &sandbox.DebugTrace{
+		Trace:     "7 openat(AT_FDCWD, \"/etc/app.conf\", O_RDONLY) = -1 EACCES (Permission denied)\n",
+		Truncated: true,
+		Summary: sandbox.TraceSummary{Calls: 1, Failed: 1,
+			Failures:    []sandbox.TraceFailure{{Syscall: "openat", Errno: "EACCES", Count: 1, Path: "/etc/app.conf"}},
+			DeniedPaths: []string{"/etc/app.conf"}},
+	}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser, DebugTrace: trace}}
+	h := newTestHandlers(backend)
+	h.debugTracers = newDebugTracers(config.DebugTraceConfig{Strace: "/opt/strace", Keys: []string{"tracer-key"}})
+
+	tests := []struct {
+		name, caller string
+		req          ExecutionRequest
+		status       int
+		code         apierror.Code
+	}{
+		{"allowed", "tracer-key", ExecutionRequest{Language: "python", Code: "1", DebugTrace: true}, http.StatusOK, ""},
+		{"other key", "user-key", ExecutionRequest{Language: "python", Code: "1", DebugTrace: true}, http.StatusForbidden, apierror.CodeDebugTraceDenied},
+		{"anonymous", "", ExecutionRequest{Language: "python", Code: "1", DebugTrace: true}, http.StatusForbidden, apierror.CodeDebugTraceDenied},
+		{"claude", "tracer-key", ExecutionRequest{Language: "claude", Code: "hi", DebugTrace: true}, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"not asked", "user-key", ExecutionRequest{Language: "python", Code: "1"}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.req = sandbox.ExecutionRequest{}
+			rec := postAs(t, h.HandleExecute, tt.caller, tt.req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.code != "" {
+				if resp := decodeError(t, rec); resp.Code != tt.code {
+					t.Errorf("code %s, want %s", resp.Code, tt.code)
+				}
+				if backend.req.Language != "" {
+					t.Error("refused request reached the backend")
+				}
+				return
+			}
+			if backend.req.DebugTrace != tt.req.DebugTrace {
+				t.Errorf("backend DebugTrace %v, want %v", backend.req.DebugTrace, tt.req.DebugTrace)
+			}
+			var resp ExecutionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.DebugTrace == nil || resp.DebugTrace.Summary.DeniedPaths[0] != "/etc/app.conf" {
+				t.Errorf("response debug_trace = %+v", resp.DebugTrace)
+			}
+		})
+	}
+}
+
+func TestNewDebugTrace(t *testing.T) {
+	if newDebugTrace(nil) != nil {
+		t.Error("nil trace converted")
+	}
+	got := newDebugTrace(&sandbox.DebugTrace{
+		Trace: "t",
+		Summary: sandbox.TraceSummary{Calls: 3, Failed: 1,
+			Failures:    []sandbox.TraceFailure{{Syscall: "mkdir", Errno: "EROFS", Count: 1, Path: "/out"}},
+			DeniedPaths: []string{"/out"}},
+	})
+	want := &stream.DebugTrace{
+		Trace: "t",
+		Summary: stream.TraceSummary{Calls: 3, Failed: 1,
+			Failures:    []stream.TraceFailure{{Syscall: "mkdir", Errno: "EROFS", Count: 1, Path: "/out"}},
+			DeniedPaths: []string{"/out"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newDebugTrace = %+v, want %+v", got, want)
+	}
+	// The two types must marshal alike, or the stream and the response
+	// would disagree.
+	a, _ := json.Marshal(got)
+	b, _ := json.Marshal(&sandbox.DebugTrace{Trace: "t", Summary: sandbox.TraceSummary{Calls: 3, Failed: 1,
+		Failures: []sandbox.TraceFailure{{Syscall: "mkdir", Errno: "EROFS", Count: 1, Path: "/out"}}, DeniedPaths: []string{"/out"}}})
+	if string(a) != string(b) {
+		t.Errorf("api %s != sandbox %s", a, b)
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// dedupTable tracks the executions identical submissions may attach to
+// (sandbox.dedup). An execution is in it while it runs; its result is only
+// handed to the submissions waiting when it finishes, not kept for later
+// ones. A nil *dedupTable deduplicates nothing.
+type dedupTable struct {
+	window     time.Duration
+	maxWaiters int
+	keys       map[string]bool // ownerHash of each key in sandbox.dedup.keys
+	now        func() time.Time
+
+	mu   sync.Mutex
+	runs map[string]*dedupRun
+}
+
+// dedupRun is one execution and the submissions attached to it.
+type dedupRun struct {
+	key     string
+	started time.Time
+	waiters int
+	done    chan struct{}
+	shared  *sharedResult // set before done is closed; nil if the execution ended without a result
+}
+
+// sharedResult is what an execution hands the submissions attached to it.
+type sharedResult struct {
+	result   *sandbox.ExecutionResult
+	timeout  time.Duration
+	deadline time.Time
+}
+
+func newDedupTable(cfg config.DedupConfig) *dedupTable {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	t := &dedupTable{
+		window:     cfg.Window,
+		maxWaiters: cfg.MaxWaiters,
+		keys:       make(map[string]bool, len(cfg.Keys)),
+		now:        time.Now,
+		runs:       make(map[string]*dedupRun),
+	}
+	for _, key := range cfg.Keys {
+		t.keys[ownerHash(key)] = true
+	}
+	return t
+}
+
+// dedupKey identifies what a submission runs for whom: its caller and
+// everything in the request that can change the result, with the code by
+// its hash and the timeout and limits as they will be enforced. It returns
+// "" for a submission that isn't deduplicated, which an asynchronous one
+// never is: it answers before it runs, with nothing to share or wait for.
+// codeHash is an upload's SHA-256, or "" to hash req.Code.
+func (t *dedupTable) dedupKey(r *http.Request, req ExecutionRequest, execReq sandbox.ExecutionRequest, codeHash string) string {
+	if t == nil || execReq.Chaos != nil || req.Async {
+		return ""
+	}
+	owner := workspaceOwner(r)
+	enabled := t.keys[owner]
+	if req.AllowDedup != nil {
+		enabled = *req.AllowDedup
+	}
+	if !enabled {
+		return ""
+	}
+	if codeHash == "" {
+		sum := sha256.Sum256([]byte(req.Code))
+		codeHash = hex.EncodeToString(sum[:])
+	}
+	req.Code, req.Deadline, req.Timeout, req.AllowDedup = "", nil, Duration{}, nil
+	settings, err := json.Marshal(struct {
+		Request ExecutionRequest       `json:"request"`
+		Timeout time.Duration          `json:"timeout"`
+		Limits  sandbox.ResourceLimits `json:"limits"`
+	}{req, execReq.Timeout, execReq.Limits})
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range []string{owner, req.Language, codeHash} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(settings)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// attach looks key up. A run to wait for comes back with wait set. Otherwise
+// the caller executes: with a run to finish when it is the execution others
+// attach to, or with none when key is "" or the run it matched already has
+// max_waiters. A run older than the window is replaced, so later
+// submissions wait for the newer execution.
+func (t *dedupTable) attach(key string) (run *dedupRun, wait bool) {
+	if t == nil || key == "" {
+		return nil, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if run, ok := t.runs[key]; ok && now.Sub(run.started) < t.window {
+		if run.waiters >= t.maxWaiters {
+			return nil, false
+		}
+		run.waiters++
+		return run, true
+	}
+	run = &dedupRun{key: key, started: now, done: make(chan struct{})}
+	t.runs[key] = run
+	return run, false
+}
+
+// finish hands shared, which may be nil, to the submissions waiting for
+// run. The caller that got run from attach without wait must call it.
+func (t *dedupTable) finish(run *dedupRun, shared *sharedResult) {
+	if run == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.runs[run.key] == run {
+		delete(t.runs, run.key)
+	}
+	t.mu.Unlock()
+	run.shared = shared
+	close(run.done)
+}
+
+// waitShared waits for run's execution. It returns nil if the request went
+// away first, or if the execution ended without a result, in which case the
+// caller executes on its own.
+func waitShared(r *http.Request, run *dedupRun) *sharedResult {
+	select {
+	case <-run.done:
+		return run.shared
+	case <-r.Context().Done():
+		return nil
+	}
+}
+
+// writeShared answers a POST /execute or /execute/upload that waited for an
+// identical execution with that execution's result.
+func (h *Handlers) writeShared(w http.ResponseWriter, req ExecutionRequest, sub submission, shared *sharedResult) {
+	h.metrics.DedupAttached.Inc()
+	resp := NewExecutionResponse(shared.result, req.SharedMounts)
+	resp.Timeout, resp.Deadline = shared.timeout.String(), shared.deadline
+	resp.CodeScan = sub.scan
+	resp.PartialAnalysis = sub.partial
+	resp.Analysis = analysisOf(req.Language)
+	resp.Deduplicated = true
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// streamShared answers a POST /execute/stream that waited for an identical
+// execution: its output, then its done event. There is no buffer of a
+// running execution's output to attach to, so it comes all at once.
+func (h *Handlers) streamShared(sse *sseStream, req ExecutionRequest, partial bool, shared *sharedResult) {
+	h.metrics.DedupAttached.Inc()
+	result := shared.result
+	for i, e := range result.OutputEvents {
+		end := len(result.Output)
+		if i+1 < len(result.OutputEvents) {
+			end = result.OutputEvents[i+1].Offset
+		}
+		sse.Output(e.Stream).Write([]byte(result.Output[e.Offset:end]))
+	}
+	if result.Output != "" && result.OutputEvents == nil {
+		sse.Output("stdout").Write([]byte(result.Output))
+	}
+	if result.Stderr != "" {
+		sse.Output("stderr").Write([]byte(result.Stderr))
+	}
+	done := newDoneV2(result, sandbox.StateOf(result, nil), stream.Done{
+		ID:              result.ID,
+		ExitCode:        result.ExitCode,
+		ExitClass:       string(result.ExitClass),
+		Duration:        result.Duration.String(),
+		Timeout:         shared.timeout.String(),
+		Deadline:        shared.deadline,
+		Chaos:           result.Chaos,
+		SharedMounts:    attachedMounts(result, req.SharedMounts),
+		Environment:     newEnvironment(result),
+		DroppedBytes:    sse.Dropped(),
+		IdleTimeout:     newIdleTimeout(result.Idle),
+		PartialAnalysis: partial,
+		Analysis:        analysisOf(req.Language),
+		Deduplicated:    true,
+
+		NetworkConnections: newNetworkAudit(result.NetworkConnections),
+		Recommendation:     newRecommendation(result.Recommendation),
+	})
+	sse.Finish(stream.EventDone, done)
+	h.recordStreamDrops(sse)
+}
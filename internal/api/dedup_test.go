@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// slowBackend holds every execution until release is closed, and names
+// each result after the order it was called in.
+type slowBackend struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (b *slowBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	n := b.calls.Add(1)
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &sandbox.ExecutionResult{
+		ID:       fmt.Sprintf("exec-%d", n),
+		Output:   "hello\n",
+		Stderr:   "warn\n",
+		ExitCode: 3,
+		Duration: time.Second,
+	}, nil
+}
+
+func (b *slowBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, _, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	return b.Execute(ctx, req)
+}
+
+func (b *slowBackend) Close() error { return nil }
+
+func (b *slowBackend) Name() string { return "docker" }
+
+func newDedupHandlers(cfg config.DedupConfig) (*Handlers, *slowBackend) {
+	backend := &slowBackend{release: make(chan struct{})}
+	h := newTestHandlers(backend)
+	h.dedup = newDedupTable(cfg)
+	return h, backend
+}
+
+// dedupSubmission is one request of a burst: its caller, body and route.
+type dedupSubmission struct {
+	caller string
+	body   ExecutionRequest
+	stream bool
+}
+
+// submitAll sends subs concurrently and waits until attached of them are
+// waiting for another's execution, then releases the backend.
+func submitAll(t *testing.T, h *Handlers, backend *slowBackend, attached int, subs []dedupSubmission) []*httptest.ResponseRecorder {
+	t.Helper()
+	recs := make([]*httptest.ResponseRecorder, len(subs))
+	var wg sync.WaitGroup
+	for i, s := range subs {
+		b, _ := json.Marshal(s.body)
+		req := httptest.NewRequest(http.MethodPost, "/execute", bytes.NewReader(b))
+		if s.caller != "" {
+			req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, s.caller))
+		}
+		recs[i] = httptest.NewRecorder()
+		handler := h.HandleExecute
+		if s.stream {
+			handler = h.HandleExecuteStream
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler(recs[i], req)
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for waiting(h.dedup) < attached || int(backend.calls.Load()) < len(subs)-attached {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiting and %d executing, want %d and %d", waiting(h.dedup), backend.calls.Load(), attached, len(subs)-attached)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	wg.Wait()
+	return recs
+}
+
+func waiting(t *dedupTable) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, run := range t.runs {
+		n += run.waiters
+	}
+	return n
+}
+
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) ExecutionResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp ExecutionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestDedup_SharesExecution(t *testing.T) {
+	h, backend := newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 16})
+	yes := true
+	body := ExecutionRequest{Language: "python", Code: "print('hello')", AllowDedup: &yes}
+	subs := make([]dedupSubmission, 5)
+	for i := range subs {
+		subs[i] = dedupSubmission{caller: "key-a", body: body}
+	}
+
+	recs := submitAll(t, h, backend, 4, subs)
+	if n := backend.calls.Load(); n != 1 {
+		t.Fatalf("backend ran %d executions, want 1", n)
+	}
+	deduplicated := 0
+	for _, rec := range recs {
+		resp := decodeResponse(t, rec)
+		if resp.ID != "exec-1" || resp.Output != "hello\n" || resp.Stderr != "warn\n" || resp.ExitCode != 3 || resp.Timeout == "" {
+			t.Errorf("response %+v, want exec-1's result", resp)
+		}
+		if resp.Deduplicated {
+			deduplicated++
+		}
+	}
+	if deduplicated != 4 {
+		t.Errorf("%d responses marked deduplicated, want all but the one that ran", deduplicated)
+	}
+	if got := metricValue(t, h.metrics.DedupAttached); got != 4 {
+		t.Errorf("sandbox_dedup_attached_total = %g, want 4", got)
+	}
+	if waiting(h.dedup) != 0 || len(h.dedup.runs) != 0 {
+		t.Errorf("finished execution left in the table: %v", h.dedup.runs)
+	}
+}
+
+func TestDedup_Isolation(t *testing.T) {
+	yes, no := true, false
+	code := "print('hello')"
+	tests := []struct {
+		name     string
+		keys     []string
+		subs     []dedupSubmission
+		attached int
+	}{
+		{"other callers", nil, []dedupSubmission{
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes}},
+			{caller: "key-b", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes}},
+			{caller: "key-b", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes}},
+		}, 1},
+		{"other settings", nil, []dedupSubmission{
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes, Timeout: Duration{5 * time.Second}}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes, Limits: ResourceLimits{MemoryMB: 128}}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &yes, Args: []string{"x"}}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code + "\n", AllowDedup: &yes}},
+		}, 0},
+		{"not opted in", nil, []dedupSubmission{
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code}},
+		}, 0},
+		{"in by default for keys", []string{"key-a"}, []dedupSubmission{
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code}},
+			{caller: "key-a", body: ExecutionRequest{Language: "python", Code: code, AllowDedup: &no}},
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, backend := newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 16, Keys: tt.keys})
+			recs := submitAll(t, h, backend, tt.attached, tt.subs)
+			if n := int(backend.calls.Load()); n != len(tt.subs)-tt.attached {
+				t.Errorf("backend ran %d executions, want %d", n, len(tt.subs)-tt.attached)
+			}
+			deduplicated := 0
+			for _, rec := range recs {
+				if decodeResponse(t, rec).Deduplicated {
+					deduplicated++
+				}
+			}
+			if deduplicated != tt.attached {
+				t.Errorf("%d responses deduplicated, want %d", deduplicated, tt.attached)
+			}
+		})
+	}
+}
+
+func TestDedup_Limits(t *testing.T) {
+	yes := true
+	body := ExecutionRequest{Language: "python", Code: "print('hello')", AllowDedup: &yes}
+
+	// Past max_waiters a submission runs on its own.
+	h, backend := newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 1})
+	submitAll(t, h, backend, 1, []dedupSubmission{{body: body}, {body: body}, {body: body}})
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("max_waiters 1: backend ran %d executions, want 2", n)
+	}
+
+	// Past the window a submission starts a new execution, which the
+	// next one attaches to.
+	h, _ = newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 16})
+	now := time.Unix(1000, 0)
+	h.dedup.now = func() time.Time { return now }
+	key := h.dedup.dedupKey(httptest.NewRequest(http.MethodPost, "/execute", nil), body, sandbox.ExecutionRequest{}, "")
+	first, wait := h.dedup.attach(key)
+	if wait {
+		t.Fatal("the first submission waited")
+	}
+	now = now.Add(time.Minute)
+	second, wait := h.dedup.attach(key)
+	if wait || second == first {
+		t.Fatal("a submission past the window attached to the old execution")
+	}
+	if third, wait := h.dedup.attach(key); !wait || third != second {
+		t.Error("a submission within the new execution's window didn't attach to it")
+	}
+	h.dedup.finish(first, nil)
+	if h.dedup.runs[key] != second {
+		t.Error("the old execution finishing removed the new one")
+	}
+}
+
+func TestDedup_NoResultRunsAgain(t *testing.T) {
+	h, _ := newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 16})
+	run, _ := h.dedup.attach("key")
+	follower, wait := h.dedup.attach("key")
+	if !wait || follower != run {
+		t.Fatal("second submission didn't attach")
+	}
+	h.dedup.finish(run, nil)
+	if shared := waitShared(httptest.NewRequest(http.MethodPost, "/execute", nil), follower); shared != nil {
+		t.Errorf("shared %+v from an execution without a result", shared)
+	}
+}
+
+func TestDedup_StreamingAttaches(t *testing.T) {
+	h, backend := newDedupHandlers(config.DedupConfig{Window: time.Minute, MaxWaiters: 16})
+	yes := true
+	body := ExecutionRequest{Language: "python", Code: "print('hello')", AllowDedup: &yes}
+
+	// The POST /execute runs; the stream that follows attaches to it.
+	ran := make(chan *httptest.ResponseRecorder)
+	go func() { ran <- postJSON(t, h.HandleExecute, body) }()
+	for backend.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b, _ := json.Marshal(body)
+	streamed := httptest.NewRecorder()
+	attached := make(chan struct{})
+	go func() {
+		defer close(attached)
+		h.HandleExecuteStream(streamed, httptest.NewRequest(http.MethodPost, "/execute/stream", bytes.NewReader(b)))
+	}()
+	for waiting(h.dedup) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	<-attached
+
+	if resp := decodeResponse(t, <-ran); resp.Deduplicated || backend.calls.Load() != 1 {
+		t.Fatalf("%d executions, response %+v", backend.calls.Load(), resp)
+	}
+	for _, want := range []string{"event: stdout\ndata: hello", "event: stderr\ndata: warn", `"id":"exec-1"`, `"exit_code":3`, `"deduplicated":true`} {
+		if !strings.Contains(streamed.Body.String(), want) {
+			t.Errorf("attached stream has no %q:\n%s", want, streamed.Body)
+		}
+	}
+}
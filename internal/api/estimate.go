@@ -0,0 +1,294 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+const (
+	// estimateCharsPerToken is the heuristic prompts are tokenized with.
+	estimateCharsPerToken = 4
+	// estimateBaseTokens stands for claude's system prompt and tool
+	// definitions, sent with every turn.
+	estimateBaseTokens = 15000
+	// estimateContextTokens caps what a workspace adds to a turn's input:
+	// claude can't hold more of it than its context window.
+	estimateContextTokens = 200000
+	// estimateOutputPerTurn is the output tokens a turn is taken to write.
+	estimateOutputPerTurn = 1000
+	// estimateOpenTurns stands for max_turns when nothing bounds it.
+	estimateOpenTurns = 50
+
+	// estimateHistoryWindow, estimateHistoryLimit: how far back and how many
+	// of the model's executions are looked at.
+	estimateHistoryWindow = 30 * 24 * time.Hour
+	estimateHistoryLimit  = 500
+	// estimateHistoryMin similar executions are needed before history counts
+	// at all; at estimateHistoryFull it replaces the heuristic.
+	estimateHistoryMin  = 5
+	estimateHistoryFull = 20
+
+	// defaultPricing prices the models security.claude.pricing doesn't list.
+	defaultPricing = "default"
+)
+
+// Estimate confidences.
+const (
+	confidenceLow    = "low"    // the heuristic alone
+	confidenceMedium = "medium" // blended with a few similar executions
+	confidenceHigh   = "high"   // drawn from enough similar executions
+)
+
+// EstimateResponse is POST /estimate's estimate of what a claude request
+// would cost. It is never binding: nothing is charged or reserved.
+type EstimateResponse struct {
+	Model                string               `json:"model"`                  // as priced; empty is claude's default
+	EstimatedInputTokens int64                `json:"estimated_input_tokens"` // the first turn's: the prompt and claude's system prompt
+	EstimatedTurnsRange  TurnsRange           `json:"estimated_turns_range"`
+	EstimatedCostRange   CostRange            `json:"estimated_cost_range"`
+	Confidence           string               `json:"confidence"` // low, medium or high
+	HistorySamples       int                  `json:"history_samples"`
+	Workspace            *sandbox.WorkdirSize `json:"workspace,omitempty"` // the work_dir or workspace_id, as measured
+	Binding              bool                 `json:"binding"`             // always false
+}
+
+// TurnsRange is a range of turns, both ends included.
+type TurnsRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// CostRange is a range of costs in USD.
+type CostRange struct {
+	MinUSD float64 `json:"min_usd"`
+	MaxUSD float64 `json:"max_usd"`
+}
+
+// claudeEstimator holds what estimates are made against: the claude
+// ceilings and defaults, and the pricing table, which a config reload
+// replaces.
+type claudeEstimator struct {
+	cfg     config.ClaudeSecurityConfig
+	pricing atomic.Pointer[map[string]config.ClaudePrice]
+}
+
+func newClaudeEstimator(cfg config.ClaudeSecurityConfig) *claudeEstimator {
+	e := &claudeEstimator{cfg: cfg}
+	e.reload(cfg.Pricing)
+	return e
+}
+
+// reload replaces the pricing table.
+func (e *claudeEstimator) reload(pricing map[string]config.ClaudePrice) {
+	e.pricing.Store(&pricing)
+}
+
+// price is model's price, or the default's.
+func (e *claudeEstimator) price(model string) (config.ClaudePrice, bool) {
+	pricing := *e.pricing.Load()
+	if p, ok := pricing[model]; ok && model != "" {
+		return p, true
+	}
+	p, ok := pricing[defaultPricing]
+	return p, ok
+}
+
+// resolve is the model and max_turns opts would run with, as the backend
+// resolves them against security.claude.
+func (e *claudeEstimator) resolve(opts *ClaudeOptions) (model string, maxTurns int, err error) {
+	if opts != nil {
+		model, maxTurns = opts.Model, opts.MaxTurns
+	}
+	if maxTurns < 0 {
+		return "", 0, fmt.Errorf("max_turns must be >= 0")
+	}
+	if e.cfg.MaxTurns > 0 && maxTurns > e.cfg.MaxTurns {
+		return "", 0, fmt.Errorf("max_turns %d exceeds the %d maximum", maxTurns, e.cfg.MaxTurns)
+	}
+	if model == "" {
+		model = e.cfg.DefaultModel
+	}
+	if model != "" && len(e.cfg.AllowedModels) > 0 && !slices.Contains(e.cfg.AllowedModels, model) {
+		return "", 0, fmt.Errorf("model %q is not allowed", model)
+	}
+	for _, turns := range []int{e.cfg.DefaultMaxTurns, e.cfg.MaxTurns, estimateOpenTurns} {
+		if maxTurns == 0 {
+			maxTurns = turns
+		}
+	}
+	return model, maxTurns, nil
+}
+
+// estimateTokens is the chars/4 heuristic.
+func estimateTokens(chars int64) int64 {
+	return (chars + estimateCharsPerToken - 1) / estimateCharsPerToken
+}
+
+// tokenCost is the price of input and output tokens at p.
+func tokenCost(p config.ClaudePrice, input, output int64) float64 {
+	return (float64(input)*p.InputPerMTok + float64(output)*p.OutputPerMTok) / 1e6
+}
+
+// estimate prices a prompt of promptBytes over one to maxTurns turns with
+// the heuristic, then blends in history: the usage of the model's earlier
+// executions with prompts of similar size.
+func estimate(price config.ClaudePrice, promptBytes, workspaceBytes int64, maxTurns int, history []storage.ClaudeOptions) EstimateResponse {
+	firstTurn := estimateBaseTokens + estimateTokens(promptBytes)
+	fullTurn := firstTurn + min(estimateTokens(workspaceBytes), estimateContextTokens)
+	resp := EstimateResponse{
+		EstimatedInputTokens: firstTurn,
+		EstimatedTurnsRange:  TurnsRange{Min: 1, Max: maxTurns},
+		EstimatedCostRange: CostRange{
+			MinUSD: tokenCost(price, firstTurn, estimateOutputPerTurn),
+			MaxUSD: float64(maxTurns) * tokenCost(price, fullTurn, estimateOutputPerTurn),
+		},
+		Confidence: confidenceLow,
+	}
+
+	var turns []int
+	var costs []float64
+	for _, h := range history {
+		if h.PromptBytes == 0 || int64(h.PromptBytes) > 2*promptBytes || 2*int64(h.PromptBytes) < promptBytes {
+			continue
+		}
+		turns = append(turns, min(h.Turns, maxTurns))
+		costs = append(costs, tokenCost(price, h.InputTokens, h.OutputTokens))
+	}
+	resp.HistorySamples = len(turns)
+	if len(turns) >= estimateHistoryMin {
+		slices.Sort(turns)
+		slices.Sort(costs)
+		w := min(1, float64(len(turns))/estimateHistoryFull)
+		blend := func(heuristic, observed float64) float64 { return (1-w)*heuristic + w*observed }
+		lo, hi := len(turns)/10, len(turns)-1-len(turns)/10 // the 10th and 90th percentiles
+		resp.EstimatedTurnsRange = TurnsRange{
+			Min: int(math.Round(blend(float64(resp.EstimatedTurnsRange.Min), float64(turns[lo])))),
+			Max: int(math.Round(blend(float64(resp.EstimatedTurnsRange.Max), float64(turns[hi])))),
+		}
+		resp.EstimatedCostRange = CostRange{
+			MinUSD: blend(resp.EstimatedCostRange.MinUSD, costs[lo]),
+			MaxUSD: blend(resp.EstimatedCostRange.MaxUSD, costs[hi]),
+		}
+		resp.Confidence = confidenceMedium
+		if w == 1 {
+			resp.Confidence = confidenceHigh
+		}
+	}
+	resp.EstimatedCostRange.MinUSD = roundUSD(resp.EstimatedCostRange.MinUSD)
+	resp.EstimatedCostRange.MaxUSD = roundUSD(resp.EstimatedCostRange.MaxUSD)
+	return resp
+}
+
+// roundUSD rounds to a hundredth of a cent.
+func roundUSD(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// HandleEstimate serves POST /estimate: what the claude request in the body
+// would cost, estimated from its prompt, its work_dir or workspace_id, its
+// max_turns and the model's price in security.claude.pricing, blended with
+// the usage of the model's executions with prompts of similar size. Nothing
+// runs.
+func (h *Handlers) HandleEstimate(w http.ResponseWriter, r *http.Request) {
+	var req ExecutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	if canonicalLanguage(req.Language) != "claude" {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "estimates are for claude requests only"))
+		return
+	}
+	if req.Code == "" {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "code is required"))
+		return
+	}
+	model, maxTurns, err := h.estimates.resolve(req.Claude)
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+		return
+	}
+	price, ok := h.estimates.price(model)
+	if !ok {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, fmt.Sprintf("security.claude.pricing has no price for model %q", model)))
+		return
+	}
+	workspace, ok := h.estimateWorkspace(w, r, &req)
+	if !ok {
+		return
+	}
+
+	var history []storage.ClaudeOptions
+	since := time.Now().Add(-estimateHistoryWindow)
+	if h.db != nil {
+		if history, err = h.db.RecentClaudeUsage(r.Context(), model, since, estimateHistoryLimit); err != nil {
+			log.Warn().Err(err).Msg("claude usage history unavailable; estimating without it")
+		}
+	} else {
+		history = storage.FilterClaudeUsage(h.recent.snapshot(), model, since, estimateHistoryLimit)
+	}
+
+	var workspaceBytes int64
+	if workspace != nil {
+		workspaceBytes = workspace.Bytes
+	}
+	resp := estimate(price, int64(len(req.Code)), workspaceBytes, maxTurns, history)
+	resp.Model, resp.Workspace = model, workspace
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// estimateWorkspace measures the request's work_dir with the backend's
+// bounded walk, or sums its workspace's files. It writes the error response
+// and returns false when the request must be rejected.
+func (h *Handlers) estimateWorkspace(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (*sandbox.WorkdirSize, bool) {
+	switch {
+	case req.WorkspaceID != "" && req.WorkDir != "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "work_dir and workspace_id are mutually exclusive"))
+		return nil, false
+	case req.WorkspaceID != "":
+		if h.workspaces == nil {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+			return nil, false
+		}
+		files, err := h.workspaces.List(req.WorkspaceID, workspaceOwner(r))
+		if err != nil {
+			writeWorkspaceError(w, err, r)
+			return nil, false
+		}
+		size := &sandbox.WorkdirSize{Files: int64(len(files))}
+		for _, f := range files {
+			size.Bytes += f.Size
+		}
+		return size, true
+	case req.WorkDir != "":
+		measurer, ok := h.backend.(sandbox.WorkdirMeasurer)
+		if !ok {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "work_dir mounts are not supported by this backend"))
+			return nil, false
+		}
+		size, err := measurer.MeasureWorkdir(req.WorkDir)
+		if errors.Is(err, sandbox.ErrInvalidRequest) {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+			return nil, false
+		} else if err != nil {
+			log.Error().Err(err).Msg("measuring work_dir for an estimate failed")
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "measuring work_dir failed"))
+			return nil, false
+		}
+		return &size, true
+	}
+	return nil, true
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/storage"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	for chars, want := range map[int64]int64{0: 0, 1: 1, 4: 1, 5: 2, 4000: 1000} {
+		if got := estimateTokens(chars); got != want {
+			t.Errorf("estimateTokens(%d) = %d, want %d", chars, got, want)
+		}
+	}
+}
+
+func TestClaudeEstimator_Pricing(t *testing.T) {
+	e := newClaudeEstimator(config.ClaudeSecurityConfig{Pricing: map[string]config.ClaudePrice{
+		"claude-haiku-4-5": {InputPerMTok: 1, OutputPerMTok: 5},
+		defaultPricing:     {InputPerMTok: 3, OutputPerMTok: 15},
+	}})
+	tests := []struct {
+		model string
+		want  float64 // a million tokens in, 100k out
+	}{
+		{"claude-haiku-4-5", 1 + 0.5},
+		{"claude-sonnet-4-5", 3 + 1.5}, // not listed: the default
+		{"", 3 + 1.5},                  // claude's own default
+	}
+	for _, tt := range tests {
+		p, ok := e.price(tt.model)
+		if got := tokenCost(p, 1_000_000, 100_000); !ok || got != tt.want {
+			t.Errorf("%q: cost = %g, %v; want %g", tt.model, got, ok, tt.want)
+		}
+	}
+
+	e.reload(map[string]config.ClaudePrice{"claude-haiku-4-5": {InputPerMTok: 2}})
+	if p, _ := e.price("claude-haiku-4-5"); p.InputPerMTok != 2 {
+		t.Errorf("after reload: %+v", p)
+	}
+	if _, ok := e.price("claude-sonnet-4-5"); ok {
+		t.Error("priced a model with no default after reload")
+	}
+}
+
+func TestEstimate_Heuristic(t *testing.T) {
+	price := config.ClaudePrice{InputPerMTok: 3, OutputPerMTok: 15}
+	got := estimate(price, 4000, 400_000, 10, nil)
+	if got.EstimatedInputTokens != estimateBaseTokens+1000 || got.EstimatedTurnsRange != (TurnsRange{1, 10}) ||
+		got.Confidence != confidenceLow || got.HistorySamples != 0 {
+		t.Errorf("estimate = %+v", got)
+	}
+	// One turn of 16k in and 1k out; ten of 116k in, the workspace's 100k
+	// tokens included, and 1k out.
+	want := CostRange{MinUSD: 0.063, MaxUSD: 10 * (0.348 + 0.015)}
+	if got.EstimatedCostRange != want {
+		t.Errorf("cost range = %+v, want %+v", got.EstimatedCostRange, want)
+	}
+}
+
+func TestEstimate_History(t *testing.T) {
+	price := config.ClaudePrice{InputPerMTok: 3, OutputPerMTok: 15}
+	heuristic := estimate(price, 4000, 0, 30, nil)
+
+	sample := storage.ClaudeOptions{PromptBytes: 3000, Turns: 4, InputTokens: 100_000, OutputTokens: 4_000} // $0.36
+	var history []storage.ClaudeOptions
+	for range estimateHistoryFull {
+		history = append(history, sample)
+	}
+	// Prompts of a different size aren't similar.
+	history = append(history, storage.ClaudeOptions{PromptBytes: 100, Turns: 30, InputTokens: 1e7})
+
+	got := estimate(price, 4000, 0, 30, history)
+	if got.HistorySamples != estimateHistoryFull || got.Confidence != confidenceHigh ||
+		got.EstimatedTurnsRange != (TurnsRange{4, 4}) || got.EstimatedCostRange != (CostRange{0.36, 0.36}) {
+		t.Errorf("full history: %+v", got)
+	}
+
+	// Half the samples at full weight: halfway between the two.
+	got = estimate(price, 4000, 0, 30, history[:estimateHistoryFull/2])
+	if got.Confidence != confidenceMedium || got.EstimatedTurnsRange.Max != 17 ||
+		got.EstimatedCostRange.MaxUSD != roundUSD((heuristic.EstimatedCostRange.MaxUSD+0.36)/2) {
+		t.Errorf("half history: %+v", got)
+	}
+
+	got = estimate(price, 4000, 0, 30, history[:estimateHistoryMin-1])
+	if got.Confidence != confidenceLow || got.EstimatedCostRange != heuristic.EstimatedCostRange {
+		t.Errorf("too little history: %+v", got)
+	}
+}
+
+func TestHandleEstimate(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	for _, body := range []ExecutionRequest{
+		{Language: "python", Code: "print(1)"},
+		{Language: "claude"},
+		{Language: "claude", Code: "fix it", Claude: &ClaudeOptions{MaxTurns: -1}},
+		{Language: "claude", Code: "fix it", WorkDir: "/tmp"}, // the mock backend mounts no work_dirs
+	} {
+		if rec := postJSON(t, h.HandleEstimate, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status = %d, want 400", body, rec.Code)
+		}
+	}
+
+	at := time.Now().Add(-time.Hour)
+	for range estimateHistoryFull {
+		h.recent.add(&storage.Execution{Language: "claude", CreatedAt: at, ClaudeOptions: &storage.ClaudeOptions{
+			PromptBytes: len("fix the failing test"), Turns: 3, InputTokens: 50_000, OutputTokens: 2_000,
+		}})
+	}
+	rec := postJSON(t, h.HandleEstimate, ExecutionRequest{Language: "claude", Code: "fix the failing test"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"binding":false`) {
+		t.Errorf("response doesn't say it isn't binding: %s", rec.Body)
+	}
+	var resp EstimateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Confidence != confidenceHigh || resp.EstimatedTurnsRange != (TurnsRange{3, 3}) || resp.EstimatedCostRange.MaxUSD != 0.18 {
+		t.Errorf("estimate = %+v", resp)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// In-flight policies: what happens to a language's running executions when
+// it is disabled.
+const (
+	inFlightFinish = "finish"
+	inFlightKill   = "kill"
+)
+
+// featureFlags is the kill switches' state. One is never changed once
+// stored; an update stores a new one.
+type featureFlags struct {
+	Languages []string  `json:"disabled_languages"`
+	Features  []string  `json:"disabled_features"`
+	Message   string    `json:"message,omitempty"`
+	InFlight  string    `json:"in_flight"`
+	Source    string    `json:"source"` // config, reload or admin
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (f *featureFlags) languageDisabled(language string) bool {
+	return slices.Contains(f.Languages, language)
+}
+
+func (f *featureFlags) featureDisabled(feature string) bool {
+	return slices.Contains(f.Features, feature)
+}
+
+// killSwitch holds the feature flags. Requests read them with one atomic
+// load, so an update applies to every request that starts after it.
+type killSwitch struct {
+	flags    atomic.Pointer[featureFlags]
+	mu       sync.Mutex // serializes updates
+	inFlight string     // security.disabled_in_flight, for updates that don't say
+	metrics  *monitor.Metrics
+	// kill cancels the running executions of a language, returning how many.
+	kill func(language string) int
+}
+
+func newKillSwitch(sec config.SecurityConfig, metrics *monitor.Metrics, kill func(string) int) *killSwitch {
+	k := &killSwitch{metrics: metrics, kill: kill}
+	k.reload(sec, "config")
+	return k
+}
+
+// reload replaces the flags with the config's.
+func (k *killSwitch) reload(sec config.SecurityConfig, source string) {
+	f := flagsFromConfig(sec, source)
+	k.mu.Lock()
+	k.inFlight = f.InFlight
+	k.mu.Unlock()
+	k.set(f)
+}
+
+func flagsFromConfig(sec config.SecurityConfig, source string) *featureFlags {
+	inFlight := sec.DisabledInFlight
+	if inFlight == "" {
+		inFlight = inFlightFinish
+	}
+	return &featureFlags{
+		Languages: nonNil(sec.DisabledLanguages),
+		Features:  nonNil(sec.DisabledFeatures),
+		Message:   sec.DisabledMessage,
+		InFlight:  inFlight,
+		Source:    source,
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return slices.Clone(s)
+}
+
+func (k *killSwitch) load() *featureFlags {
+	return k.flags.Load()
+}
+
+// set stores f. Languages it newly disables have their running executions
+// killed when f's in-flight policy says so.
+func (k *killSwitch) set(f *featureFlags) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	old := k.flags.Swap(f)
+	if k.metrics != nil {
+		k.metrics.SetFeatureFlags(f.Languages, f.Features)
+	}
+	for _, lang := range f.Languages {
+		if old != nil && old.languageDisabled(lang) {
+			continue
+		}
+		killed := 0
+		if f.InFlight == inFlightKill && k.kill != nil {
+			killed = k.kill(lang)
+		}
+		log.Warn().Str("language", lang).Str("source", f.Source).Str("in_flight", f.InFlight).Int("killed", killed).Msg("language disabled")
+	}
+	for _, feature := range f.Features {
+		if old == nil || !old.featureDisabled(feature) {
+			log.Warn().Str("feature", feature).Str("source", f.Source).Msg("feature disabled")
+		}
+	}
+}
+
+// checkFeature refuses the request with FEATURE_DISABLED when feature is
+// disabled.
+func (h *Handlers) checkFeature(w http.ResponseWriter, r *http.Request, feature string) bool {
+	if h.flags == nil {
+		return true
+	}
+	f := h.flags.load()
+	if !f.featureDisabled(feature) {
+		return true
+	}
+	h.writeFeatureDisabled(w, r, f, "feature", feature)
+	return false
+}
+
+// checkFlags refuses req with FEATURE_DISABLED when its language is
+// disabled. The features it uses are checked with the other capabilities,
+// by checkCapabilities.
+func (h *Handlers) checkFlags(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) bool {
+	if h.flags == nil {
+		return true
+	}
+	f := h.flags.load()
+	if f.languageDisabled(req.Language) {
+		h.writeFeatureDisabled(w, r, f, "language", req.Language)
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) writeFeatureDisabled(w http.ResponseWriter, r *http.Request, f *featureFlags, kind, flag string) {
+	h.metrics.RecordFeatureRejection(kind, flag)
+	msg := fmt.Sprintf("%s %s is disabled on this server", kind, flag)
+	details := map[string]any{"kind": kind, "flag": flag}
+	if f.Message != "" {
+		msg += ": " + f.Message
+		details["message"] = f.Message
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeFeatureDisabled, msg).WithDetails(details))
+}
+
+// flagsRequest is the body of POST /admin/flags. It replaces the flags
+// whole; in_flight defaults to security.disabled_in_flight.
+type flagsRequest struct {
+	DisabledLanguages []string `json:"disabled_languages"`
+	DisabledFeatures  []string `json:"disabled_features"`
+	Message           string   `json:"message"`
+	InFlight          string   `json:"in_flight"`
+}
+
+// HandleFlags serves GET /admin/flags, the kill switches in force, and
+// POST /admin/flags, which replaces them at once. The next SIGHUP puts back
+// what the config file says.
+func (h *Handlers) HandleFlags(w http.ResponseWriter, r *http.Request) {
+	if h.flags == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "feature flags are not set up on this server"))
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, h.flags.load())
+		return
+	}
+
+	var req flagsRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+	var unknown []string
+	known := runtime.NewRegistry().Languages()
+	for _, lang := range req.DisabledLanguages {
+		if !slices.Contains(known, lang) {
+			unknown = append(unknown, "language:"+lang)
+		}
+	}
+	for _, feature := range req.DisabledFeatures {
+		if !slices.Contains(config.Features, feature) {
+			unknown = append(unknown, "feature:"+feature)
+		}
+	}
+	if len(unknown) > 0 {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "unknown flags: %v", unknown).
+			WithDetails(map[string]any{"unknown": unknown, "languages": known, "features": config.Features}))
+		return
+	}
+	switch req.InFlight {
+	case "":
+		h.flags.mu.Lock()
+		req.InFlight = h.flags.inFlight
+		h.flags.mu.Unlock()
+	case inFlightFinish, inFlightKill:
+	default:
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest, "in_flight must be %s or %s", inFlightFinish, inFlightKill))
+		return
+	}
+
+	f := &featureFlags{
+		Languages: nonNil(req.DisabledLanguages),
+		Features:  nonNil(req.DisabledFeatures),
+		Message:   req.Message,
+		InFlight:  req.InFlight,
+		Source:    "admin",
+		UpdatedAt: time.Now().UTC(),
+	}
+	h.flags.set(f)
+	log.Info().Str("caller", workspaceOwner(r)).Strs("languages", f.Languages).Strs("features", f.Features).Msg("feature flags replaced through /admin/flags")
+	writeJSON(w, http.StatusOK, f)
+}
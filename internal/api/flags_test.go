@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+func newFlagHandlers(backend sandbox.Backend, sec config.SecurityConfig) *Handlers {
+	h := newTestHandlers(backend)
+	h.flags = newKillSwitch(sec, h.metrics, h.executions.killLanguage)
+	return h
+}
+
+func postFlags(h *Handlers, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.HandleFlags(rec, httptest.NewRequest(http.MethodPost, "/admin/flags", strings.NewReader(body)))
+	return rec
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apierror.Response {
+	t.Helper()
+	var resp apierror.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestFeatureDisabled(t *testing.T) {
+	sec := config.DefaultConfig().Security
+	sec.DisabledLanguages = []string{"node"}
+	sec.DisabledFeatures = []string{"streaming", "claude_workdir"}
+	sec.DisabledMessage = "pending CVE-2025-1234 mitigation"
+	h := newFlagHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, sec)
+
+	for _, tc := range []struct {
+		name       string
+		handler    http.HandlerFunc
+		req        ExecutionRequest
+		kind, flag string
+	}{
+		{"language", h.HandleExecute, ExecutionRequest{Language: "node", Code: "1"}, "language", "node"},
+		{"streaming", h.HandleExecuteStream, ExecutionRequest{Language: "python", Code: "1"}, "feature", "streaming"},
+		{"claude work_dir", h.HandleExecute, ExecutionRequest{Language: "claude", Code: "List the files.", WorkDir: "/srv/app"}, "feature", "claude_workdir"},
+	} {
+		rec := postJSON(t, tc.handler, tc.req)
+		resp := decodeError(t, rec)
+		if rec.Code != http.StatusForbidden || resp.Code != "FEATURE_DISABLED" || !strings.Contains(resp.Error, "CVE-2025-1234") {
+			t.Errorf("%s: status %d, %+v", tc.name, rec.Code, resp)
+		}
+		if resp.Details["kind"] != tc.kind || resp.Details["flag"] != tc.flag || resp.Details["message"] != sec.DisabledMessage {
+			t.Errorf("%s: details = %v", tc.name, resp.Details)
+		}
+	}
+
+	// What isn't disabled still runs: claude without a work_dir, python.
+	for _, req := range []ExecutionRequest{{Language: "claude", Code: "Say hi."}, {Language: "python", Code: "1"}} {
+		if rec := postJSON(t, h.HandleExecute, req); rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d %s", req.Language, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// TestFeatureFlags_Instant flips a language off while requests for it are
+// being sent: every request sent after the flip returns is refused.
+func TestFeatureFlags_Instant(t *testing.T) {
+	h := newFlagHandlers(&concurrentBackend{}, config.DefaultConfig().Security)
+
+	var flipped atomic.Bool
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan string, 100)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				after := flipped.Load()
+				rec := httptest.NewRecorder()
+				body := strings.NewReader(`{"language":"node","code":"1"}`)
+				h.HandleExecute(rec, httptest.NewRequest(http.MethodPost, "/execute", body))
+				if after && rec.Code != http.StatusForbidden {
+					select {
+					case errs <- rec.Body.String():
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if rec := postFlags(h, `{"disabled_languages":["node"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("disable node: status %d %s", rec.Code, rec.Body.String())
+	}
+	flipped.Store(true)
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for body := range errs {
+		t.Errorf("request after the flip ran: %s", body)
+	}
+}
+
+// concurrentBackend succeeds at once, without recording the request.
+type concurrentBackend struct{ mockBackend }
+
+func (concurrentBackend) Execute(context.Context, sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}, nil
+}
+
+// killableBackend runs until its execution is cancelled or released.
+type killableBackend struct {
+	mockBackend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *killableBackend) Execute(ctx context.Context, _ sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	b.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitManualKill, ExitCode: 137}, nil
+	case <-b.release:
+		return &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}, nil
+	}
+}
+
+func TestFeatureFlags_InFlight(t *testing.T) {
+	for _, policy := range []string{inFlightFinish, inFlightKill} {
+		t.Run(policy, func(t *testing.T) {
+			backend := &killableBackend{started: make(chan struct{}, 1), release: make(chan struct{})}
+			sec := config.DefaultConfig().Security
+			sec.DisabledInFlight = policy
+			h := newFlagHandlers(backend, sec)
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- postJSON(t, h.HandleExecute, ExecutionRequest{Language: "node", Code: "1"}) }()
+			<-backend.started
+
+			if rec := postFlags(h, `{"disabled_languages":["node"],"disabled_features":["uploads"]}`); rec.Code != http.StatusOK {
+				t.Fatalf("disable node: status %d %s", rec.Code, rec.Body.String())
+			}
+			select {
+			case rec := <-done:
+				if policy != inFlightKill || !strings.Contains(rec.Body.String(), `"exit_class":"manual_kill"`) {
+					t.Errorf("execution ended on disable: %s", rec.Body.String())
+				}
+			case <-time.After(200 * time.Millisecond):
+				if policy == inFlightKill {
+					t.Fatal("running execution not killed")
+				}
+				close(backend.release)
+				if rec := <-done; rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"exit_class":"user_exit"`) {
+					t.Errorf("execution allowed to finish: status %d %s", rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandleFlags(t *testing.T) {
+	sec := config.DefaultConfig().Security
+	sec.DisabledInFlight = inFlightKill
+	h := newFlagHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, sec)
+
+	for body, unknown := range map[string]string{
+		`{"disabled_languages":["cobol"]}`:                              "language:cobol",
+		`{"disabled_features":["artifacts"]}`:                           "feature:artifacts",
+		`{"disabled_languages":["node"],"disabled_features":["strem"]}`: "feature:strem",
+	} {
+		rec := postFlags(h, body)
+		resp := decodeError(t, rec)
+		got, _ := resp.Details["unknown"].([]any)
+		if rec.Code != http.StatusBadRequest || len(got) != 1 || got[0] != unknown {
+			t.Errorf("%s: status %d, %+v", body, rec.Code, resp)
+		}
+	}
+	for _, body := range []string{`{"in_flight":"drain"}`, `{"disabled_language":["node"]}`, `not json`} {
+		if rec := postFlags(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if f := h.flags.load(); len(f.Languages) != 0 || len(f.Features) != 0 {
+		t.Fatalf("refused updates changed the flags: %+v", f)
+	}
+
+	rec := postFlags(h, `{"disabled_languages":["node","bun"],"disabled_features":["network_enabled"],"message":"incident 42"}`)
+	var set featureFlags
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(set.Languages) != 2 || set.InFlight != inFlightKill || set.Source != "admin" {
+		t.Errorf("POST: status %d, %+v", rec.Code, set)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleFlags(rec, httptest.NewRequest(http.MethodGet, "/admin/flags", nil))
+	var got featureFlags
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Message != "incident 42" || len(got.Features) != 1 || got.Features[0] != "network_enabled" {
+		t.Errorf("GET = %+v", got)
+	}
+
+	// Clearing the lists turns everything back on.
+	if rec := postFlags(h, `{}`); rec.Code != http.StatusOK {
+		t.Errorf("clear: status %d", rec.Code)
+	}
+	if rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "node", Code: "1"}); rec.Code == http.StatusForbidden {
+		t.Errorf("node still refused after clearing: %s", rec.Body.String())
+	}
+}
+
+// TestFeatureFlags_Server checks the admin route, /health and a reload
+// through a whole server.
+func TestFeatureFlags_Server(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"user-key", "admin-key"}
+	cfg.Security.AdminKeys = []string{"admin-key"}
+	cfg.Security.DisabledFeatures = []string{"streaming"}
+	s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, nil, nil, monitor.NewMetrics())
+	handler := s.httpServer.Handler
+
+	health := func() HealthResponse {
+		t.Helper()
+		var resp HealthResponse
+		if err := json.NewDecoder(doRequest(handler, http.MethodGet, "/health", "", nil).Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if d := health().Disabled; d == nil || len(d.Features) != 1 || d.Features[0] != "streaming" {
+		t.Errorf("health disabled = %+v", d)
+	}
+
+	body := `{"disabled_languages":["python"],"message":"advisory"}`
+	if rec := doRequest(handler, http.MethodPost, "/admin/flags", "user-key", strings.NewReader(body)); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin POST /admin/flags: status %d", rec.Code)
+	}
+	if rec := doRequest(handler, http.MethodPost, "/admin/flags", "admin-key", strings.NewReader(body)); rec.Code != http.StatusOK {
+		t.Fatalf("admin POST /admin/flags: status %d %s", rec.Code, rec.Body.String())
+	}
+	rec := doRequest(handler, http.MethodPost, "/execute", "user-key", strings.NewReader(`{"language":"python","code":"print(1)"}`))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "advisory") {
+		t.Errorf("python after disabling: status %d %s", rec.Code, rec.Body.String())
+	}
+	if d := health().Disabled; d == nil || len(d.Languages) != 1 || len(d.Features) != 0 || d.Message != "advisory" {
+		t.Errorf("health disabled = %+v", d)
+	}
+
+	s.ReloadFlags(config.DefaultConfig().Security)
+	if d := health().Disabled; d != nil {
+		t.Errorf("after reload: health disabled = %+v", d)
+	}
+	if rec := doRequest(handler, http.MethodPost, "/execute", "user-key", strings.NewReader(`{"language":"python","code":"print(1)"}`)); rec.Code != http.StatusOK {
+		t.Errorf("python after reload: status %d %s", rec.Code, rec.Body.String())
+	}
+}
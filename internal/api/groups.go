@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+// groupKey names a cancellation group. A group is its caller's own: another
+// caller using the same name has a group of its own.
+type groupKey struct{ owner, name string }
+
+// checkCancellationGroup writes the error and returns false for a
+// cancellation_group that isn't empty or in the validTaskID format.
+func checkCancellationGroup(w http.ResponseWriter, r *http.Request, name string) bool {
+	if name == "" || validTaskID.MatchString(name) {
+		return true
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "cancellation_group must be 1-128 letters, digits, '.', '_', ':' or '-', starting with a letter or digit"))
+	return false
+}
+
+// leaveGroup takes id out of a's cancellation group, which goes once it has
+// no live executions left. Callers hold e.mu.
+func (e *executionRegistry) leaveGroup(id string, a *activeExecution) {
+	if a.group == "" {
+		return
+	}
+	key := groupKey{a.owner, a.group}
+	delete(e.groups[key], id)
+	if len(e.groups[key]) == 0 {
+		delete(e.groups, key)
+	}
+}
+
+// cancelGroup kills owner's live executions in the group and returns them,
+// by ID, each in the state it was in. Executions an earlier cancellation
+// already killed, still on their way out, aren't counted again. Their
+// handlers learn why they ended from groupCancelled.
+func (e *executionRegistry) cancelGroup(owner, name string) []CancelledExecution {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cancelled := []CancelledExecution{}
+	for id := range e.groups[groupKey{owner, name}] {
+		a := e.active[id]
+		if a.cancelled {
+			continue
+		}
+		a.cancelled = true
+		if a.cancel != nil {
+			a.cancel()
+		}
+		cancelled = append(cancelled, CancelledExecution{ID: id, State: string(a.state)})
+	}
+	sort.Slice(cancelled, func(i, j int) bool { return cancelled[i].ID < cancelled[j].ID })
+	return cancelled
+}
+
+// groupCancelled reports whether id was killed by a cancellation of its
+// group. The handler asks before finish.
+func (e *executionRegistry) groupCancelled(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.active[id]
+	return ok && a.cancelled
+}
+
+// groupOf returns the cancellation group of a live or recently finished
+// execution, for its audit record.
+func (e *executionRegistry) groupOf(id string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.active[id]; ok {
+		return a.group
+	}
+	return e.finished[id].group
+}
+
+// HandleCancelGroup serves DELETE /cancellation-groups/{name}: it kills
+// every live execution the caller started under the cancellation_group.
+// Queued ones give up their place; running ones have their containers
+// removed, as the server does for executions it gives up on at shutdown,
+// rather than waiting for their runners to notice. Streams end with a
+// cancelled event. A group with nothing live left, or one never used,
+// cancels nothing.
+func (h *Handlers) HandleCancelGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validTaskID.MatchString(name) {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid cancellation group"))
+		return
+	}
+
+	cancelled := h.executions.cancelGroup(workspaceOwner(r), name)
+	remover, _ := h.backend.(sandbox.ExecutionRemover)
+	for _, c := range cancelled {
+		if remover == nil || c.State != string(state.Running) {
+			continue
+		}
+		if err := remover.RemoveExecution(r.Context(), c.ID); err != nil {
+			log.Warn().Err(err).Str("exec_id", c.ID).Msg("failed to remove cancelled execution's container")
+		}
+	}
+
+	log.Info().Str("cancellation_group", name).Int("cancelled", len(cancelled)).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("cancellation group cancelled")
+	writeJSON(w, http.StatusOK, CancelGroupResponse{CancellationGroup: name, Cancelled: cancelled})
+}
+
+// executionCancelled is the error a POST /execute caller gets for an
+// execution its group's cancellation killed.
+func executionCancelled(id, group string) *apierror.Error {
+	return apierror.New(apierror.CodeExecutionCancelled, "execution cancelled with its cancellation group").
+		WithDetails(map[string]any{"exec_id": id, "cancellation_group": group})
+}
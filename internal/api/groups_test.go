@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// groupBackend runs code "sleep" until it is killed and leaves code "wait"
+// queued, never given a slot, until it is; anything else finishes at once.
+type groupBackend struct {
+	started chan string
+
+	mu      sync.Mutex
+	removed []string
+}
+
+func (b *groupBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (b *groupBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	switch req.Code {
+	case "wait":
+		b.started <- req.ID
+		<-ctx.Done()
+		return nil, &sandbox.ExecutionError{ExecID: req.ID, Op: "acquire_slot", Err: ctx.Err()}
+	case "hang": // killed with no result to show for it
+		req.Admitted()
+		io.WriteString(stdout, "working\n")
+		b.started <- req.ID
+		<-ctx.Done()
+		return nil, &sandbox.ExecutionError{ExecID: req.ID, Op: "docker_run", Err: ctx.Err()}
+	case "sleep":
+		req.Admitted()
+		io.WriteString(stdout, "working\n")
+		b.started <- req.ID
+		<-ctx.Done()
+		return &sandbox.ExecutionResult{ID: req.ID, Output: "working\n", ExitCode: -1, ExitClass: sandbox.ExitManualKill},
+			&sandbox.ExecutionError{ExecID: req.ID, Op: "docker_run", Err: ctx.Err()}
+	}
+	req.Admitted()
+	return &sandbox.ExecutionResult{ID: req.ID, ExitClass: sandbox.ExitUser}, nil
+}
+
+func (b *groupBackend) RemoveExecution(_ context.Context, execID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removed = append(b.removed, execID)
+	return nil
+}
+
+func (b *groupBackend) Close() error { return nil }
+
+func (b *groupBackend) Name() string { return "docker" }
+
+func cancelGroup(t *testing.T, h *Handlers, caller, name string) (int, CancelGroupResponse) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /cancellation-groups/{name}", h.HandleCancelGroup)
+	req := httptest.NewRequest(http.MethodDelete, "/cancellation-groups/"+name, nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, caller))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var resp CancelGroupResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestCancelGroup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := storage.NewFileSink(storage.FileSinkOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &groupBackend{started: make(chan string, 2)}
+	h := newTestHandlers(backend)
+	h.executions.maxGroup = 2
+	h.auditWriter = storage.NewAuditWriter(16)
+	h.auditWriter.AddSink("file", sink)
+	h.auditWriter.Start()
+
+	in := func(code string) ExecutionRequest {
+		return ExecutionRequest{Language: "python", Code: code, CancellationGroup: "run-7"}
+	}
+	if rec := postAs(t, h.HandleExecute, "alice", in("print(1)")); rec.Code != http.StatusOK {
+		t.Fatalf("completed execution: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	streamed := make(chan *httptest.ResponseRecorder)
+	go func() { streamed <- postAs(t, h.HandleExecuteStream, "alice", in("sleep")) }()
+	running := <-backend.started
+	queuedDone := make(chan *httptest.ResponseRecorder)
+	go func() { queuedDone <- postAs(t, h.HandleExecute, "alice", in("wait")) }()
+	queued := <-backend.started
+
+	// The group holds its two live executions; the completed one has left.
+	rec := postAs(t, h.HandleExecute, "alice", in("print(2)"))
+	if resp := decodeError(t, rec); rec.Code != http.StatusTooManyRequests || resp.Code != "CANCELLATION_GROUP_FULL" || resp.Details["cancellation_group"] != "run-7" {
+		t.Errorf("third execution: status %d %+v", rec.Code, resp)
+	}
+
+	// Another caller's group of the same name is its own.
+	if code, resp := cancelGroup(t, h, "bob", "run-7"); code != http.StatusOK || len(resp.Cancelled) != 0 {
+		t.Fatalf("bob's cancellation: status %d %+v", code, resp)
+	}
+	if !h.executions.running(running) || !h.executions.running(queued) {
+		t.Fatal("bob cancelled alice's executions")
+	}
+
+	code, resp := cancelGroup(t, h, "alice", "run-7")
+	if code != http.StatusOK || resp.CancellationGroup != "run-7" || len(resp.Cancelled) != 2 {
+		t.Fatalf("cancellation: status %d %+v", code, resp)
+	}
+	states := map[string]string{}
+	for _, c := range resp.Cancelled {
+		states[c.ID] = c.State
+	}
+	if states[running] != "running" || states[queued] != "queued" {
+		t.Errorf("cancelled %+v, want %s running and %s queued", resp.Cancelled, running, queued)
+	}
+
+	// The stream ends with a cancelled event after the output it had.
+	events := readEvents(t, (<-streamed).Body.String())
+	last := events[len(events)-1]
+	var cancelled stream.Cancelled
+	if !last.Terminal() || last.Type != stream.EventCancelled || last.Decode(&cancelled) != nil || cancelled.ID != running || cancelled.CancellationGroup != "run-7" {
+		t.Errorf("stream ended with %+v", last)
+	}
+	if events[0].Type != stream.EventStdout || events[0].Data != "working\n" {
+		t.Errorf("first event %+v", events[0])
+	}
+	rec = <-queuedDone
+	if resp := decodeError(t, rec); rec.Code != http.StatusConflict || resp.Code != "EXECUTION_CANCELLED" || resp.Details["exec_id"] != queued {
+		t.Errorf("queued execution: status %d %+v", rec.Code, resp)
+	}
+
+	backend.mu.Lock()
+	if len(backend.removed) != 1 || backend.removed[0] != running {
+		t.Errorf("removed containers of %v, want only %s's", backend.removed, running)
+	}
+	backend.mu.Unlock()
+	owner := ownerHash("alice")
+	for id, want := range map[string]string{running: "cancelled", queued: "cancelled"} {
+		if p, ok := h.executions.get(id, owner); !ok || p.State != want {
+			t.Errorf("%s: progress %+v, %v; want %s", id, p, ok, want)
+		}
+	}
+	if n := len(h.executions.groups); n != 0 {
+		t.Errorf("%d groups left once empty", n)
+	}
+	if code, resp := cancelGroup(t, h, "alice", "run-7"); code != http.StatusOK || len(resp.Cancelled) != 0 {
+		t.Errorf("second cancellation: status %d %+v", code, resp)
+	}
+
+	// Each execution that ran has its group on its record; the queued one
+	// never ran and has none.
+	h.auditWriter.Flush(5 * time.Second)
+	sink.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]storage.Execution{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var exec storage.Execution
+		if err := json.Unmarshal([]byte(line), &exec); err != nil {
+			t.Fatal(err)
+		}
+		records[exec.ID] = exec
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want the completed and the running execution's", len(records))
+	}
+	for id, exec := range records {
+		if exec.CancellationGroup != "run-7" {
+			t.Errorf("%s: recorded group %q", id, exec.CancellationGroup)
+		}
+	}
+	if records[running].Status != state.Cancelled {
+		t.Errorf("running execution recorded as %s", records[running].Status)
+	}
+}
+
+func TestCancelGroup_InvalidName(t *testing.T) {
+	h := newTestHandlers(&groupBackend{})
+	if code, _ := cancelGroup(t, h, "alice", "-run"); code != http.StatusBadRequest {
+		t.Errorf("DELETE of an invalid name: status %d", code)
+	}
+	rec := postAs(t, h.HandleExecute, "alice", ExecutionRequest{Language: "python", Code: "print(1)", CancellationGroup: "run 7"})
+	if resp := decodeError(t, rec); resp.Code != "INVALID_REQUEST" {
+		t.Errorf("execution with an invalid group: %+v", resp)
+	}
+}
@@ -1,17 +1,29 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/codebundle"
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/runtime"
 	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
 	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/internal/version"
+	"safe-agent-sandbox/internal/workspace"
+	"safe-agent-sandbox/pkg/stream"
 )
 
 var validUUID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
@@ -22,117 +34,260 @@ type Handlers struct {
 	auditWriter  *storage.AuditWriter
 	metrics      *monitor.Metrics
 	detector     *monitor.EscapeDetector
+	prompts      *promptScreen       // claude's pre-flight in place of detector
+	estimates    *claudeEstimator    // POST /estimate's ceilings, defaults and pricing
+	chaosEnabled bool                // accept chaos requests (sandbox.chaos.enabled)
+	workspaces   *workspace.Store    // nil when sandbox.workspaces.root is unset
+	bundles      *codebundle.Store   // nil when sandbox.bundles.store is unset
+	costs        *costLimiter        // nil when security.cost_budget.hourly is 0
+	audit        *auditGate          // nil unless database.required
+	claudeTokens *claudeTokens       // nil when security.claude_tokens is disabled
+	execSecrets  TokenBroker         // registers a secret for each claude execution (auth_proxy.per_execution_secrets); nil otherwise
+	privacy      *privacyPolicy      // database.store_code and security.privacy_mode; nil stores no code
+	getExecution executionLookup     // the database's, retained's, or both; see retainExecutions
+	listExecs    executionLister     // as getExecution, or the file sink's with database.file_sink.serve_executions
+	slo          *monitor.SLOTracker // nil when metrics.slo has no objectives
+	tasks        *taskLimiter        // nil when security.max_task_executions is 0
+	keys         *keyLimits          // security.key_limits; nil in tests
+	anomalies    *anomalyFlagger     // nil when security.anomaly is disabled
+	dedup        *dedupTable         // nil when sandbox.dedup.window is 0
+	shareWeights map[string]int      // ownerHash of each key in sandbox.fair_share.weights to its weight
+	debugTracers map[string]bool     // ownerHash of each key in sandbox.debug_trace.keys; nil when debug traces are off
+	network      networkPolicy       // security.network_policy; the zero value allows every request its network
+	flags        *killSwitch         // security.disabled_languages and disabled_features; nil checks nothing
+	now          func() time.Time    // the server clock that deadlines are converted against
+	urls         publicURLs          // server.base_path and trust_proxy_headers, for links in responses
+	jobs         *jobRunner          // the config's jobs; nil until Server.StartJobs
+	async        *asyncExecutions    // server.async_job_ttl; nil refuses asynchronous executions
+
+	executions         *executionRegistry
+	progressInterval   time.Duration
+	streamBufferBytes  int           // output queued per streaming client before chunks are dropped
+	streamWriteTimeout time.Duration // per write to a streaming client
+	claudeIdleTimeout  time.Duration // sandbox.claude_idle_output_timeout; 0 = none
+	maxIdleTimeout     time.Duration // sandbox.max_idle_output_timeout; 0 refuses idle_output_timeout
+	maxUploadBytes     int64         // sandbox.max_upload_code_bytes; 0 turns off POST /execute/upload
+	maxStdinBytes      int64         // sandbox.max_stdin_bytes; 0 refuses stdin
+	binaryMaxBytes     int64         // sandbox.binary.max_bytes
+	sharedMounts       []string      // names in sandbox.shared_mounts
+	claudeCaches       bool          // sandbox.claude_caches has volumes
+	requireApproval    bool          // sandbox.bundles.require_approval
+
+	output     sandbox.OutputLimits // sandbox.output; zero fields take the defaults
+	maxUlimits sandbox.Ulimits      // sandbox.max_ulimits, for GET /capabilities
+	runtimes   *runtime.Registry    // the default limits of each language, with sandbox.runtime_limits
+
+	usageCache  *usageCache
+	stats       *statsCollector     // GET /stats; set by NewServer
+	recent      *recentExecutions   // usage report fallback when db is nil
+	retained    *retainedExecutions // database.retained_executions; nil keeps none
+	retrievable bool                // GET /executions/{id} finds recorded executions: retained, or in Postgres
+	images      *imageDigests
 }
 
+// chaosHeader requests failure injection as "<mode>[;delay=<duration>]".
+// It takes precedence over the request body's chaos field.
+const chaosHeader = "X-Sandbox-Chaos"
+
 func NewHandlers(backend sandbox.Backend, db *storage.DB, auditWriter *storage.AuditWriter, metrics *monitor.Metrics) *Handlers {
-	return &Handlers{
+	h := &Handlers{
 		backend:     backend,
 		db:          db,
 		auditWriter: auditWriter,
 		metrics:     metrics,
 		detector:    monitor.NewEscapeDetector(),
+		prompts:     newPromptScreen(config.DefaultConfig().Security.Claude),
+		estimates:   newClaudeEstimator(config.DefaultConfig().Security.Claude),
+		now:         time.Now,
+
+		async:              newAsyncExecutions(defaultAsyncJobTTL),
+		executions:         newExecutionRegistry(metrics),
+		progressInterval:   defaultProgressInterval,
+		streamBufferBytes:  defaultStreamBufferBytes,
+		streamWriteTimeout: defaultStreamWriteTimeout,
+
+		usageCache: newUsageCache(usageCacheTTL),
+		recent:     newRecentExecutions(usageRecentSize),
+		images:     newImageDigests(metrics),
+		maxUlimits: sandbox.MaxUlimits(),
+		runtimes:   knownLanguages,
+
+		binaryMaxBytes: sandbox.DefaultBinaryMaxBytes,
 	}
+	h.retainExecutions(defaultRetainedExecutions)
+	return h
 }
 
 func (h *Handlers) HandleExecute(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeError(w, "method not allowed", "METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeMethodNotAllowed, "method not allowed"))
 		return
 	}
 
 	var req ExecutionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, "invalid request body", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	if req.Async || asyncQuery(r) {
+		h.executeAsync(w, r, req)
+		return
+	}
+	h.executeRequest(w, r, req)
+}
+
+// executeRequest checks and screens a POST /execute request and runs it.
+func (h *Handlers) executeRequest(w http.ResponseWriter, r *http.Request, req ExecutionRequest) {
+	req.Language = canonicalLanguage(req.Language)
+	if !h.resolveBundle(w, r, &req) {
 		return
 	}
 
 	if req.Language == "" {
-		writeError(w, "language is required", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "language is required"))
 		return
 	}
 	if req.Code == "" {
-		writeError(w, "code is required", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "code is required"))
 		return
 	}
+	if req.Language == "claude" {
+		extendClaudeWriteDeadline(r)
+	}
 
 	h.metrics.CodeSizeBytes.Observe(float64(len(req.Code)))
 
-	detections := h.detector.AnalyzeCode(req.Code)
-	for _, d := range detections {
-		h.metrics.RecordSecurityEvent(d.Pattern)
-		if d.Severity == monitor.SeverityCritical.String() {
-			writeError(w, "request blocked by security policy", "SECURITY_BLOCKED", http.StatusForbidden, r)
-			return
-		}
+	source, analysis, ok := h.screen(w, r, &req)
+	if !ok {
+		return
 	}
+	stdin, ok := h.screenStdin(w, r, &req)
+	if !ok {
+		return
+	}
+	h.execute(w, r, req, submission{source: source, detections: analysis.Detections, stdin: stdin, partial: analysis.Partial})
+}
+
+// submission is an execution's code once screened: where its detections
+// came from and, for an upload, the file it was streamed to.
+type submission struct {
+	source     string
+	detections []monitor.Detection
+	stdin      []monitor.Detection // the request's stdin's, see screenStdin
+	codeFile   string              // POST /execute/upload; req.Code is empty
+	codeHash   string              // the upload's SHA-256, hashed while it was written
+	scan       *CodeScan           // how much of an upload the detector read
+	partial    bool                // the detector ran out of budget and sampled the code
+}
 
-	timeout := 10 * time.Second
-	if req.Timeout.Duration > 0 {
-		timeout = req.Timeout.Duration
+// execute runs a screened request through the backend and writes the
+// response, for POST /execute and POST /execute/upload.
+func (h *Handlers) execute(w http.ResponseWriter, r *http.Request, req ExecutionRequest, sub submission) {
+	source, detections := sub.source, sub.detections
+
+	prep, ok := h.prepare(w, r, &req)
+	if !ok {
+		return
 	}
+	defer prep.revoke()
+	execReq, deadline := prep.req, prep.deadline
+	timeout, limits, chaos := execReq.Timeout, execReq.Limits, execReq.Chaos
+	execReq.CodeFile, execReq.CodeHash = sub.codeFile, sub.codeHash
+	execReq.SuspectedMiner = matchedMiner(detections)
 
-	limits := sandbox.DefaultLimits()
-	if req.Limits.MemoryMB > 0 {
-		limits = sandbox.ResourceLimits{
-			CPUShares: req.Limits.CPUShares,
-			MemoryMB:  req.Limits.MemoryMB,
-			PidsLimit: req.Limits.PidsLimit,
-			DiskMB:    req.Limits.DiskMB,
+	if h.backend == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeRunnerUnavailable, "sandbox backend unavailable"))
+		return
+	}
+
+	run, wait := h.dedup.attach(h.dedup.dedupKey(r, req, execReq, sub.codeHash))
+	if wait {
+		if shared := waitShared(r, run); shared != nil {
+			h.writeShared(w, req, sub, shared)
+			return
+		} else if r.Context().Err() != nil {
+			return
 		}
+		run = nil
 	}
+	var shared *sharedResult
+	defer func() { h.dedup.finish(run, shared) }()
 
-	networkEnabled := req.Perms.Network.Enabled
-	if req.Language == "claude" {
-		networkEnabled = true
+	release, refundKey, ok := h.admitKey(w, r)
+	if !ok {
+		return
 	}
+	defer release()
 
-	execReq := sandbox.ExecutionRequest{
-		Code:           req.Code,
-		Language:       req.Language,
-		Timeout:        timeout,
-		Limits:         limits,
-		NetworkEnabled: networkEnabled,
-		WorkDir:        req.WorkDir,
+	if !h.admitTask(w, r, req.TaskID) {
+		refundKey()
+		return
 	}
 
-	if h.backend == nil {
-		writeError(w, "sandbox backend unavailable", "RUNNER_UNAVAILABLE", http.StatusServiceUnavailable, r)
+	var cost float64
+	if chaos == nil { // chaos results are synthesized without a container
+		if cost, ok = h.chargeExecution(w, r, req.Language, timeout, limits.MemoryMB); !ok {
+			refundKey()
+			return
+		}
+	}
+
+	ctx, kill := context.WithCancel(r.Context())
+	defer kill()
+	execReq.ID, execReq.Progress, execReq.Partial, ok = h.startExecution(w, r, req.Language, req.CancellationGroup, timeout, kill)
+	if !ok {
+		h.refundExecution(r, cost)
+		refundKey()
 		return
 	}
+	execReq.Admitted = func() { h.executions.admit(execReq.ID) }
+	h.auditOnInterrupt(execReq.ID, r, req, prep.network, cost)
 
 	h.metrics.ActiveExecutions.Inc()
 	defer h.metrics.ActiveExecutions.Dec()
 
 	start := time.Now()
 
-	result, err := h.backend.Execute(r.Context(), execReq)
+	result, err := h.backend.Execute(ctx, execReq)
 	duration := time.Since(start)
+	if result != nil {
+		result.Network = prep.network
+	}
+	st := sandbox.StateOf(result, err)
+	cancelled := h.executions.groupCancelled(execReq.ID)
+	killed, ran := h.executions.killed(execReq.ID), h.executions.ran(execReq.ID)
+	switch {
+	case cancelled:
+		st = state.Cancelled
+	case killed && ran:
+		st = state.Killed
+	}
+	h.executions.finish(execReq.ID, st, result)
+	h.recordTimeout(result, err)
 
-	status := "success"
-	if err != nil {
-		switch {
-		case errors.Is(err, sandbox.ErrTimeout):
-			status = "timeout"
-		case errors.Is(err, sandbox.ErrOOM):
-			status = "oom"
-		case errors.Is(err, sandbox.ErrSecurityViolation):
-			status = "security"
-		case errors.Is(err, sandbox.ErrInvalidRequest), errors.Is(err, sandbox.ErrUnsupportedLang):
-			status = "validation"
-			writeError(w, err.Error(), "VALIDATION_ERROR", http.StatusBadRequest, r)
-			h.metrics.RecordExecution(req.Language, status, duration.Seconds())
-			return
-		default:
-			status = "error"
+	h.metrics.RecordExecution(r.Context(), h.backend.Name(), req.Language, st, duration.Seconds(), chaos != nil, execReq.ID)
+
+	if cancelled {
+		if result != nil {
+			h.logAudit(result, req.Language, req.Code, req.TaskID, st, start, r, attachedMounts(result, req.SharedMounts), cost)
 		}
+		apierror.WriteError(w, r, executionCancelled(execReq.ID, req.CancellationGroup))
+		return
 	}
 
-	h.metrics.RecordExecution(req.Language, status, duration.Seconds())
-
 	if result == nil && err != nil {
-		h.metrics.RecordError("internal")
-		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("execution failed")
-		writeError(w, "execution failed", "EXECUTION_FAILED", http.StatusInternalServerError, r)
+		if turnedAway(err) {
+			h.refundExecution(r, cost)
+			refundKey()
+		}
+		if killed {
+			if ran {
+				h.logWithoutResult(execReq, req, st, start, r, prep.network, cost)
+			}
+			apierror.WriteError(w, r, executionKilled(execReq.ID))
+			return
+		}
+		h.writeExecutionError(w, r, err)
 		return
 	}
 
@@ -140,188 +295,396 @@ func (h *Handlers) HandleExecute(w http.ResponseWriter, r *http.Request) {
 		outputDetections := h.detector.AnalyzeOutput(result.Output)
 		for _, d := range outputDetections {
 			h.metrics.RecordSecurityEvent(d.Pattern)
-			result.SecurityEvents = append(result.SecurityEvents, sandbox.SecurityEvent{
-				Type:   d.Pattern,
-				Detail: d.Detail,
-			})
 		}
+		events := append(detectionEvents(source, detections), detectionEvents(sandbox.SourceStdin, sub.stdin)...)
+		result.SecurityEvents = append(events, result.SecurityEvents...)
+		result.SecurityEvents = append(result.SecurityEvents, detectionEvents(sandbox.SourceOutput, outputDetections)...)
+		h.flagAnomalies(&execReq, workspaceOwner(r), result)
+		shared = &sharedResult{result: result, timeout: timeout, deadline: deadline}
 	}
 
-	apiSecEvents := make([]SecurityEvent, 0, len(result.SecurityEvents))
-	for _, e := range result.SecurityEvents {
-		apiSecEvents = append(apiSecEvents, SecurityEvent{
-			Type:    e.Type,
-			Syscall: e.Syscall,
-			Detail:  e.Detail,
-		})
-	}
-
-	resp := ExecutionResponse{
-		ID:       result.ID,
-		Output:   result.Output,
-		Stderr:   result.Stderr,
-		ExitCode: result.ExitCode,
-		Duration: result.Duration.String(),
-		ResourceUsage: ResourceUsage{
-			CPUTimeMS:    result.ResourceUsage.CPUTimeMS,
-			MemoryPeakMB: result.ResourceUsage.MemoryPeakMB,
-			PidsUsed:     result.ResourceUsage.PidsUsed,
-		},
-		SecurityEvents: apiSecEvents,
-	}
+	resp := NewExecutionResponse(result, req.SharedMounts)
+	resp.Timeout, resp.Deadline = timeout.String(), deadline
+	resp.CodeScan = sub.scan
+	resp.PartialAnalysis = sub.partial
+	resp.Analysis = analysisOf(req.Language)
 
 	h.metrics.OutputSizeBytes.Observe(float64(len(result.Output) + len(result.Stderr)))
 
-	h.logAudit(result, req.Language, status, start, r)
+	h.logAudit(result, req.Language, req.Code, req.TaskID, st, start, r, resp.SharedMounts, cost)
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *Handlers) HandleExecuteStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeError(w, "method not allowed", "METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeMethodNotAllowed, "method not allowed"))
+		return
+	}
+	if !h.requireCapability(w, r, "streaming") {
+		return
+	}
+	version, err := stream.ParseVersion(r.Header.Get(stream.AcceptVersionHeader))
+	if err != nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeUnknownStreamVersion, "%s %q is not a stream version this server speaks", stream.AcceptVersionHeader, r.Header.Get(stream.AcceptVersionHeader)).
+			WithDetails(map[string]any{"supported": stream.Versions}))
 		return
 	}
 
 	var req ExecutionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, "invalid request body", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	if req.Async || asyncQuery(r) {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "a stream can't be asynchronous; send async executions to POST /execute/async"))
+		return
+	}
+	req.Language = canonicalLanguage(req.Language)
+	if !h.resolveBundle(w, r, &req) {
 		return
 	}
 
 	if req.Language == "" || req.Code == "" {
-		writeError(w, "language and code are required", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "language and code are required"))
 		return
 	}
+	if req.Language == "claude" {
+		extendClaudeWriteDeadline(r)
+	}
 
-	detections := h.detector.AnalyzeCode(req.Code)
-	for _, d := range detections {
-		h.metrics.RecordSecurityEvent(d.Pattern)
-		if d.Severity == monitor.SeverityCritical.String() {
-			writeError(w, "request blocked by security policy", "SECURITY_BLOCKED", http.StatusForbidden, r)
+	source, analysis, ok := h.screen(w, r, &req)
+	if !ok {
+		return
+	}
+	detections := analysis.Detections
+	stdinDetections, ok := h.screenStdin(w, r, &req)
+	if !ok {
+		return
+	}
+
+	prep, ok := h.prepare(w, r, &req)
+	if !ok {
+		return
+	}
+	defer prep.revoke()
+	execReq, deadline := prep.req, prep.deadline
+	timeout, limits, chaos, idleTimeout := execReq.Timeout, execReq.Limits, execReq.Chaos, execReq.IdleOutputTimeout
+	execReq.SuspectedMiner = matchedMiner(detections)
+
+	if h.backend == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeRunnerUnavailable, "sandbox backend unavailable"))
+		return
+	}
+
+	run, wait := h.dedup.attach(h.dedup.dedupKey(r, req, execReq, ""))
+	var shared *sharedResult
+	if wait {
+		if shared = waitShared(r, run); shared == nil && r.Context().Err() != nil {
 			return
 		}
+		run = nil
 	}
+	defer func() { h.dedup.finish(run, shared) }()
 
-	if h.backend == nil {
-		writeError(w, "sandbox backend unavailable", "RUNNER_UNAVAILABLE", http.StatusServiceUnavailable, r)
+	refundKey := func() {}
+	if shared == nil {
+		var release func()
+		if release, refundKey, ok = h.admitKey(w, r); !ok {
+			return
+		}
+		defer release()
+	}
+	if shared == nil && !h.admitTask(w, r, req.TaskID) {
+		refundKey()
 		return
 	}
 
+	var cost float64
+	if chaos == nil && shared == nil {
+		if cost, ok = h.chargeExecution(w, r, req.Language, timeout, limits.MemoryMB); !ok {
+			refundKey()
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
+	// Nothing between here and the client may cache, buffer or rewrite
+	// the events: X-Accel-Buffering turns off nginx's proxy buffering.
+	w.Header().Set("Cache-Control", "no-store, no-transform")
+	w.Header().Set("X-Accel-Buffering", "no")
 	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set(stream.VersionHeader, strconv.Itoa(version))
 
-	stdoutWriter := NewSSEWriter(w, "stdout")
-	stderrWriter := NewSSEWriter(w, "stderr")
-	if stdoutWriter == nil || stderrWriter == nil {
-		writeError(w, "streaming not supported", "STREAMING_UNSUPPORTED", http.StatusInternalServerError, r)
+	sse := newSSEStream(w, version, h.streamBufferBytes, h.streamWriteTimeout)
+	if sse == nil {
+		h.refundExecution(r, cost)
+		refundKey()
+		apierror.WriteError(w, r, apierror.New(apierror.CodeStreamingUnsupported, "streaming not supported"))
 		return
 	}
-
-	timeout := 10 * time.Second
-	if req.Timeout.Duration > 0 {
-		timeout = req.Timeout.Duration
+	if prep.network == sandbox.NetworkDeniedByPolicy {
+		data, _ := json.Marshal(networkDenied)
+		sse.Event(stream.EventWarning, data)
 	}
-
-	limits := sandbox.DefaultLimits()
-	if req.Limits.MemoryMB > 0 {
-		limits = sandbox.ResourceLimits{
-			CPUShares: req.Limits.CPUShares,
-			MemoryMB:  req.Limits.MemoryMB,
-			PidsLimit: req.Limits.PidsLimit,
-			DiskMB:    req.Limits.DiskMB,
-		}
+	if shared != nil {
+		h.streamShared(sse, req, analysis.Partial, shared)
+		return
 	}
 
-	streamNetworkEnabled := req.Perms.Network.Enabled
-	if req.Language == "claude" {
-		streamNetworkEnabled = true
+	ctx, kill := context.WithCancel(r.Context())
+	defer kill()
+	execReq.ID, execReq.Progress, execReq.Partial, ok = h.startExecution(w, r, req.Language, req.CancellationGroup, timeout, kill)
+	if !ok {
+		h.refundExecution(r, cost)
+		refundKey()
+		return
 	}
-
-	execReq := sandbox.ExecutionRequest{
-		Code:           req.Code,
-		Language:       req.Language,
-		Timeout:        timeout,
-		Limits:         limits,
-		NetworkEnabled: streamNetworkEnabled,
-		WorkDir:        req.WorkDir,
+	execReq.Admitted = func() { h.executions.admit(execReq.ID) }
+	h.auditOnInterrupt(execReq.ID, r, req, prep.network, cost)
+	if idleTimeout > 0 {
+		execReq.IdleWarning = func(left time.Duration) { sse.Event(stream.EventWarning, idleWarning(idleTimeout, left)) }
+	}
+	execReq.DirtyWorkdir = func(git sandbox.WorkdirGit) {
+		data, _ := json.Marshal(workdirDirtyWarning(git))
+		sse.Event(stream.EventWarning, data)
+	}
+	execReq.LimitsNotEnforced = func(gaps []string) {
+		data, _ := json.Marshal(limitWarning(gaps))
+		sse.Event(stream.EventWarning, data)
 	}
+	execReq.ImagePulling = func(p sandbox.PullProgress) { sse.Event(stream.EventStatus, pullingStatus(p)) }
 
 	h.metrics.ActiveExecutions.Inc()
 	defer h.metrics.ActiveExecutions.Dec()
 
+	var progressStopped <-chan struct{}
+	stopProgress := make(chan struct{})
+	if req.Language == "claude" {
+		progressStopped = h.streamProgress(sse, execReq.ID, req.Language, execReq.Progress, stopProgress)
+	}
+
+	stdout, stderr := sse.Output("stdout"), sse.Output("stderr")
+	var idle *idleWatchdog
+	if req.Language == "claude" && h.claudeIdleTimeout > 0 {
+		ctx, idle = startIdleWatchdog(ctx, h.claudeIdleTimeout, execReq.Progress)
+		stdout, stderr = idle.Output(stdout), idle.Output(stderr)
+	}
+
 	start := time.Now()
-	result, err := h.backend.ExecuteStreaming(r.Context(), execReq, stdoutWriter, stderrWriter)
+	result, err := h.backend.ExecuteStreaming(ctx, execReq, stdout, stderr)
+	close(stopProgress)
+	if result != nil {
+		result.Network = prep.network
+	}
+	if progressStopped != nil {
+		<-progressStopped
+	}
+	st := sandbox.StateOf(result, err)
+	idled := idle != nil && idle.Stop()
+	cancelled := h.executions.groupCancelled(execReq.ID)
+	killed, ran := h.executions.killed(execReq.ID), h.executions.ran(execReq.ID)
+	switch {
+	case cancelled:
+		st = state.Cancelled
+	case killed && ran:
+		st = state.Killed
+	case idled:
+		st = state.Timeout
+	}
+	h.executions.finish(execReq.ID, st, result)
+	h.recordTimeout(result, err)
+
+	if cancelled {
+		sse.Finish(stream.EventCancelled, &stream.Cancelled{
+			ID:                execReq.ID,
+			CancellationGroup: req.CancellationGroup,
+			Message:           "execution cancelled with its cancellation group",
+			RequestID:         RequestIDFromContext(r.Context()),
+		})
+		h.recordStreamDrops(sse)
+		if result != nil {
+			h.logAudit(result, req.Language, req.Code, req.TaskID, st, start, r, attachedMounts(result, req.SharedMounts), cost)
+		}
+		return
+	}
+
+	if idled {
+		log.Warn().Str("request_id", RequestIDFromContext(r.Context())).Dur("idle_timeout", h.claudeIdleTimeout).Msg("claude stream produced no output, aborted")
+		var retrievable bool
+		var path string
+		if result != nil {
+			h.logAudit(result, req.Language, req.Code, req.TaskID, st, start, r, attachedMounts(result, req.SharedMounts), cost)
+			retrievable, path = h.retrieval(r, execReq.ID)
+		}
+		sse.Finish(stream.EventError, &stream.ErrorV2{
+			Error: stream.Error{
+				Message:       fmt.Sprintf("no output for %s; execution aborted", h.claudeIdleTimeout),
+				Code:          string(apierror.CodeIdleOutputTimeout),
+				RequestID:     RequestIDFromContext(r.Context()),
+				Retrievable:   retrievable,
+				RetrievalPath: path,
+			},
+			Status:       string(st),
+			RetrievalURL: h.retrievalURL(r, retrievable, execReq.ID),
+		})
+		h.recordStreamDrops(sse)
+		return
+	}
 
 	if err != nil && result == nil {
+		if turnedAway(err) {
+			h.refundExecution(r, cost)
+			refundKey()
+		}
+		if sse.StopIfUnused() {
+			// Nothing has been streamed yet, so answer with a normal error response.
+			w.Header().Del("Content-Type")
+			w.Header().Del("Connection")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Del(stream.VersionHeader)
+			if killed {
+				if ran {
+					h.logWithoutResult(execReq, req, st, start, r, prep.network, cost)
+				}
+				apierror.WriteError(w, r, executionKilled(execReq.ID))
+				return
+			}
+			h.writeExecutionError(w, r, err)
+			return
+		}
 		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("streaming execution failed")
-		sendSSEError(w, "execution failed")
+		// The client has seen output, so the execution is recorded with
+		// what it wrote, as a shutdown records one it gives up on.
+		h.logWithoutResult(execReq, req, st, start, r, prep.network, cost)
+		apiErr := apierror.FromSandbox(err)
+		if killed {
+			apiErr = executionKilled(execReq.ID)
+		}
+		retrievable, path := h.retrieval(r, execReq.ID)
+		sse.Finish(stream.EventError, &stream.ErrorV2{
+			Error: stream.Error{
+				Message:       apiErr.Message,
+				Code:          string(apiErr.Code),
+				RequestID:     RequestIDFromContext(r.Context()),
+				Retrievable:   retrievable,
+				RetrievalPath: path,
+			},
+			Status:       string(st),
+			RetrievalURL: h.retrievalURL(r, retrievable, execReq.ID),
+		})
+		h.recordStreamDrops(sse)
 		return
 	}
 
 	if result != nil {
-		doneData, _ := json.Marshal(map[string]any{
-			"id":        result.ID,
-			"exit_code": result.ExitCode,
-			"duration":  result.Duration.String(),
-		})
-		sendSSEDone(w, string(doneData))
+		events := append(detectionEvents(source, detections), detectionEvents(sandbox.SourceStdin, stdinDetections)...)
+		result.SecurityEvents = append(events, result.SecurityEvents...)
+		h.flagAnomalies(&execReq, workspaceOwner(r), result)
+		shared = &sharedResult{result: result, timeout: timeout, deadline: deadline}
+		done := newDoneV2(result, st, stream.Done{
+			ID:              result.ID,
+			ExitCode:        result.ExitCode,
+			ExitClass:       string(result.ExitClass),
+			Duration:        result.Duration.String(),
+			Timeout:         timeout.String(),
+			Deadline:        deadline,
+			Chaos:           result.Chaos,
+			SharedMounts:    attachedMounts(result, req.SharedMounts),
+			Environment:     newEnvironment(result),
+			// Output still queued can be dropped while the done event waits
+			// behind it, but then the client isn't there to read the event.
+			DroppedBytes:    sse.Dropped(),
+			IdleTimeout:     newIdleTimeout(result.Idle),
+			PartialAnalysis: analysis.Partial,
+			Analysis:        analysisOf(req.Language),
 
-		status := "success"
-		if err != nil {
-			status = "error"
-		}
-		h.logAudit(result, req.Language, status, start, r)
+			NetworkConnections: newNetworkAudit(result.NetworkConnections),
+			Recommendation:     newRecommendation(result.Recommendation),
+		})
+		// Recorded first, so the record is there for a client that fetches
+		// it as soon as it reads the done event.
+		h.logAudit(result, req.Language, req.Code, req.TaskID, st, start, r, attachedMounts(result, req.SharedMounts), cost)
+		done.Retrievable, done.RetrievalPath = h.retrieval(r, result.ID)
+		done.RetrievalURL = h.retrievalURL(r, done.Retrievable, result.ID)
+		sse.Finish(stream.EventDone, done)
+		h.recordStreamDrops(sse)
 	}
 }
 
 func (h *Handlers) HandleGetExecution(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", "METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeMethodNotAllowed, "method not allowed"))
 		return
 	}
 
 	id := r.PathValue("id")
 	if id == "" || !validUUID.MatchString(id) {
-		writeError(w, "valid execution ID required", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "valid execution ID required"))
+		return
+	}
+	withCode, apiErr := parseInclude(r)
+	if apiErr != nil {
+		apierror.WriteError(w, r, apiErr)
 		return
 	}
 
-	if h.db == nil {
-		writeError(w, "database not configured", "DB_UNAVAILABLE", http.StatusServiceUnavailable, r)
+	if h.getExecution == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeDBUnavailable, "database not configured"))
 		return
 	}
 
-	exec, err := h.db.GetExecution(r.Context(), id)
+	// A caller whose policy shows no code is refused before the code is read.
+	owner := workspaceOwner(r)
+	policy := h.privacy.resolve(owner)
+	if withCode && (policy.Private || !policy.ShowCode) {
+		apierror.WriteError(w, r, executionCode(policy, owner, nil))
+		return
+	}
+
+	exec, err := h.getExecution(r.Context(), id, withCode)
 	if err != nil {
-		writeError(w, "execution not found", "NOT_FOUND", http.StatusNotFound, r)
+		h.writeUnrecorded(w, r, id)
 		return
 	}
+	if withCode {
+		if apiErr := executionCode(policy, owner, exec); apiErr != nil {
+			apierror.WriteError(w, r, apiErr)
+			return
+		}
+	}
 
 	writeJSON(w, http.StatusOK, exec)
 }
 
+// executionLister lists stored executions, newest first. *storage.DB's and
+// *storage.FileSink's ListExecutions implement it.
+type executionLister func(ctx context.Context, filter storage.ExecutionFilter) ([]storage.Execution, error)
+
 func (h *Handlers) HandleListExecutions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		writeError(w, "method not allowed", "METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeMethodNotAllowed, "method not allowed"))
 		return
 	}
 
-	if h.db == nil {
-		writeError(w, "database not configured", "DB_UNAVAILABLE", http.StatusServiceUnavailable, r)
+	if h.listExecs == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeDBUnavailable, "database not configured"))
 		return
 	}
 
+	// A status from before the states, such as success, finds its state.
+	status, _ := state.Parse(r.URL.Query().Get("status"))
 	filter := storage.ExecutionFilter{
-		Language: r.URL.Query().Get("language"),
-		Status:   r.URL.Query().Get("status"),
-		Limit:    100,
+		Language:      canonicalLanguage(r.URL.Query().Get("language")),
+		Status:        status,
+		TaskID:        r.URL.Query().Get("task_id"),
+		ServerVersion: r.URL.Query().Get("server_version"),
+		Limit:         100,
 	}
 
-	execs, err := h.db.ListExecutions(r.Context(), filter)
+	execs, err := h.listExecs(r.Context(), filter)
 	if err != nil {
-		writeError(w, "query failed", "INTERNAL", http.StatusInternalServerError, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "query failed"))
 		return
 	}
 
@@ -330,28 +693,537 @@ func (h *Handlers) HandleListExecutions(w http.ResponseWriter, r *http.Request)
 
 func (h *Handlers) HandleKillExecution(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		writeError(w, "method not allowed", "METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeMethodNotAllowed, "method not allowed"))
 		return
 	}
 
 	id := r.PathValue("id")
 	if id == "" || !validUUID.MatchString(id) {
-		writeError(w, "valid execution ID required", "INVALID_REQUEST", http.StatusBadRequest, r)
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "valid execution ID required"))
+		return
+	}
+
+	prior, ok := h.executions.kill(id, workspaceOwner(r))
+	if !ok {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "execution not running"))
 		return
 	}
+	// A running execution's container is force-removed rather than left
+	// for its runner to notice the cancellation.
+	if remover, _ := h.backend.(sandbox.ExecutionRemover); remover != nil && prior == state.Running {
+		if err := remover.RemoveExecution(r.Context(), id); err != nil {
+			log.Warn().Err(err).Str("exec_id", id).Msg("failed to remove killed execution's container")
+		}
+	}
+
+	log.Info().Str("exec_id", id).Str("state", string(prior)).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("execution killed")
+	writeJSON(w, http.StatusAccepted, KillExecutionResponse{ID: id, Status: "kill_requested", State: string(prior)})
+}
 
-	// In a full implementation, we'd track active tasks by ID and kill them
-	log.Info().Str("exec_id", id).Msg("kill requested for execution")
-	writeJSON(w, http.StatusAccepted, map[string]string{"status": "kill_requested", "id": id})
+// executionKilled is the error a caller gets for an execution
+// DELETE /executions/{id} killed before its container started.
+func executionKilled(id string) *apierror.Error {
+	return apierror.New(apierror.CodeExecutionKilled, "execution killed before it started").
+		WithDetails(map[string]any{"exec_id": id})
 }
 
-func (h *Handlers) logAudit(result *sandbox.ExecutionResult, language, status string, start time.Time, r *http.Request) {
-	if h.auditWriter == nil {
+// writeExecutionError answers for a backend error that came without a result.
+func (h *Handlers) writeExecutionError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := apierror.FromSandbox(err)
+	switch apiErr.Code {
+	case apierror.CodeRateLimited:
+		// Only the chaos backend fails this way, standing in for the
+		// per-IP limiter, whose state it can't know.
+		writeRateLimited(w, r, h.metrics, apierror.Throttle{Scope: apierror.ScopeIP, RetryAfter: time.Second})
 		return
+	case apierror.CodeLanguageSaturated:
+		t := apierror.Throttle{Scope: apierror.ScopeLanguage}
+		var sat *sandbox.SaturationError
+		if errors.As(err, &sat) {
+			t.RetryAfter, t.Limit = sat.RetryAfter, float64(sat.Size)
+		}
+		writeThrottled(w, r, h.metrics, apiErr, t)
+		return
+	case apierror.CodeExecutionFailed:
+		h.metrics.RecordError(h.backend.Name(), "internal")
+		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("execution failed")
+	}
+	apierror.WriteError(w, r, apiErr)
+}
+
+// recordTimeout counts an execution that ran out of time by the phase it
+// ran out in: setup, pulling the image or creating the container, is the
+// sandbox's problem, run the program's.
+func (h *Handlers) recordTimeout(result *sandbox.ExecutionResult, err error) {
+	if phase, ok := sandbox.TimeoutPhase(result, err); ok {
+		h.metrics.RecordTimeout(h.backend.Name(), string(phase))
+	}
+}
+
+// HandleErrorCatalog lists every API error code for client generators.
+func (h *Handlers) HandleErrorCatalog(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apierror.Catalog())
+}
+
+// preparedExecution is a request resolved for the backend.
+type preparedExecution struct {
+	req      sandbox.ExecutionRequest
+	deadline time.Time
+	network  string // why req has a network or not, one of the sandbox.Network dispositions
+	revoke   func() // ends the request's claude proxy secret and removes its unpacked bundle
+}
+
+// prepare resolves what POST /execute, /execute/upload and /execute/stream
+// share, so that one body runs the same sandbox request on each: the audit
+// gate, the kill switches and capabilities, the task ID, chaos, timeouts, workspace and claude token checks, the bundle, then the mapping
+// itself. The checks after checkCapabilities may assume the capabilities
+// req uses are enabled. It writes the error response and returns false when req is
+// refused. The caller defers revoke.
+func (h *Handlers) prepare(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (preparedExecution, bool) {
+	if !h.checkAudit(w, r) || !h.checkFlags(w, r, req) || !h.checkCapabilities(w, r, req) {
+		return preparedExecution{}, false
+	}
+	if !checkTaskID(w, r, req.TaskID) || !checkCancellationGroup(w, r, req.CancellationGroup) || !h.checkDebugTrace(w, r, req) {
+		return preparedExecution{}, false
+	}
+	chaos, ok := h.chaosSpec(w, r, req.Chaos)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	timeout, deadline, ok := h.executionTimeout(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	idleTimeout, ok := h.idleOutputTimeout(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	code, ok := h.executable(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	networkEnabled, network, ok := h.resolveNetwork(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	workspaceDir, ok := h.workspaceDir(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	proxySecret, revoke, ok := h.claudeProxySecret(w, r, req)
+	if !ok {
+		return preparedExecution{}, false
+	}
+	if req.bundle != nil {
+		dir, ok := h.unpackBundle(w, r, req.bundle)
+		if !ok {
+			revoke()
+			return preparedExecution{}, false
+		}
+		workspaceDir = dir
+		release := revoke
+		revoke = func() {
+			release()
+			removeBundleDir(dir)
+		}
+	}
+
+	return preparedExecution{
+		req: sandbox.ExecutionRequest{
+			Code:           code,
+			Language:       req.Language,
+			Timeout:        timeout,
+			Limits:         sandboxLimits(h.defaultLimits(req.Language), req.Limits),
+			NetworkEnabled: networkEnabled,
+			EnvVars:        req.Perms.Environment,
+			WorkDir:        req.WorkDir,
+			Workspace:      workspaceDir,
+			SharedMounts:   req.SharedMounts,
+			UseCaches:      req.UseCaches,
+			ReadOnly:       req.Perms.Filesystem.ReadOnly,
+			Tenant:         workspaceOwner(r),
+			ShareWeight:    h.shareWeights[workspaceOwner(r)],
+			Args:           req.Args,
+			Cwd:            req.Cwd,
+			WritableDirs:   req.Perms.Filesystem.WritableDirs,
+			Claude:         sandboxClaudeOptions(req.Claude),
+			Chaos:          chaos,
+			ProxySecret:    proxySecret,
+			Output:         h.output,
+			MergeOutput:    req.MergeOutput,
+			Stdin:          req.Stdin,
+			DebugTrace:     req.DebugTrace,
+			Timezone:       req.Timezone,
+			Locale:         req.Locale,
+
+			IdleOutputTimeout: idleTimeout,
+			InternetOnly:      req.Perms.Network.InternetOnly,
+		},
+		deadline: deadline,
+		network:  network,
+		revoke:   revoke,
+	}, true
+}
+
+// sandboxLimits fills the limits a request leaves out with its language's
+// defaults, so asking for more memory doesn't also drop the CPU and pids
+// limits.
+func sandboxLimits(defaults sandbox.ResourceLimits, l ResourceLimits) sandbox.ResourceLimits {
+	limits := defaults
+	if l.CPUShares > 0 {
+		limits.CPUShares = l.CPUShares
+	}
+	if l.MemoryMB > 0 {
+		limits.MemoryMB = l.MemoryMB
+	}
+	if l.PidsLimit > 0 {
+		limits.PidsLimit = l.PidsLimit
+	}
+	if l.DiskMB > 0 {
+		limits.DiskMB = l.DiskMB
+	}
+	if u := l.Ulimits; u != nil {
+		limits.Ulimits = &sandbox.Ulimits{NoFile: u.NoFile, NProc: u.NProc, FSize: u.FSize, Core: u.Core}
+	}
+	return limits
+}
+
+// chaosSpec resolves a chaos request from the header or body. It writes the
+// error response and returns false when the request must be rejected.
+func (h *Handlers) chaosSpec(w http.ResponseWriter, r *http.Request, body *ChaosRequest) (*sandbox.ChaosSpec, bool) {
+	raw := r.Header.Get(chaosHeader)
+	if raw == "" && body == nil {
+		return nil, true
+	}
+	var spec *sandbox.ChaosSpec
+	var err error
+	if raw != "" {
+		spec, err = parseChaosHeader(raw)
+	} else {
+		var mode sandbox.ChaosMode
+		mode, err = sandbox.ParseChaosMode(body.Mode)
+		spec = &sandbox.ChaosSpec{Mode: mode, Delay: body.Delay.Duration}
+	}
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+		return nil, false
+	}
+	if spec.Delay < 0 || spec.Delay > time.Minute {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "chaos delay must be between 0 and 1m"))
+		return nil, false
+	}
+	return spec, true
+}
+
+// parseChaosHeader parses "<mode>[;delay=<duration>]".
+func parseChaosHeader(raw string) (*sandbox.ChaosSpec, error) {
+	parts := strings.Split(raw, ";")
+	mode, err := sandbox.ParseChaosMode(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, err
+	}
+	spec := &sandbox.ChaosSpec{Mode: mode}
+	for _, p := range parts[1:] {
+		key, val, found := strings.Cut(strings.TrimSpace(p), "=")
+		if !found || key != "delay" {
+			return nil, fmt.Errorf("invalid %s parameter %q", chaosHeader, p)
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s delay: %w", chaosHeader, err)
+		}
+		spec.Delay = d
+	}
+	return spec, nil
+}
+
+// NewExecutionResponse converts a backend result into the POST /execute
+// response body. sharedMounts are the mounts the request asked for.
+func NewExecutionResponse(result *sandbox.ExecutionResult, sharedMounts []string) ExecutionResponse {
+	return ExecutionResponse{
+		ID:        result.ID,
+		Output:    result.Output,
+		Stderr:    result.Stderr,
+		ExitCode:  result.ExitCode,
+		ExitClass: string(result.ExitClass),
+		Duration:  result.Duration.String(),
+		ResourceUsage: ResourceUsage{
+			CPUTimeMS:    result.ResourceUsage.CPUTimeMS,
+			MemoryPeakMB: result.ResourceUsage.MemoryPeakMB,
+			PidsUsed:     result.ResourceUsage.PidsUsed,
+		},
+		SecurityEvents: newSecurityEvents(result.SecurityEvents),
+		Chaos:          result.Chaos,
+		SharedMounts:   attachedMounts(result, sharedMounts),
+		Environment:    newEnvironment(result),
+		IdleTimeout:    newIdleTimeout(result.Idle),
+
+		NetworkConnections: newNetworkAudit(result.NetworkConnections),
+		OutputEvents:       result.OutputEvents,
+		Warnings:           executionWarnings(result),
+		Recommendation:     newRecommendation(result.Recommendation),
+		DebugTrace:         newDebugTrace(result.DebugTrace),
+	}
+}
+
+func newNetworkAudit(audit *sandbox.NetworkAudit) *NetworkAudit {
+	if audit == nil {
+		return nil
+	}
+	out := &NetworkAudit{Connections: make([]NetworkConnection, 0, len(audit.Connections)), Dropped: audit.Dropped, Unavailable: audit.Unavailable}
+	for _, c := range audit.Connections {
+		out.Connections = append(out.Connections, NetworkConnection(c))
+	}
+	return out
+}
+
+func newIdleTimeout(stop *sandbox.IdleStop) *IdleTimeout {
+	if stop == nil {
+		return nil
+	}
+	idle := &IdleTimeout{Timeout: stop.Timeout.String(), LastOutput: stop.LastOutput}
+	if !stop.LastOutput.IsZero() {
+		idle.OutputAt = stop.OutputAt.Round(time.Millisecond).String()
+	}
+	return idle
+}
+
+// newRecommendation converts the memory an OOM-killed execution should ask
+// for next time into the API's terms.
+func newRecommendation(r *sandbox.Recommendation) *Recommendation {
+	if r == nil {
+		return nil
+	}
+	out := Recommendation(*r)
+	return &out
+}
+
+// newEnvironment reports the argv and cwd a result ran with, and the server
+// build and image digest that ran it. Chaos results ran no process and have
+// none.
+func newEnvironment(result *sandbox.ExecutionResult) *Environment {
+	if len(result.Argv) == 0 {
+		return nil
+	}
+	env := &Environment{Argv: result.Argv, Cwd: result.Cwd, Claude: newClaudeOptions(result.Claude), Warmups: result.Warmups, Env: result.Env, IP: result.IP,
+		Network: result.Network, ServerVersion: version.Get().String(), ImageDigest: result.ImageDigest,
+		Timezone: result.Timezone, Locale: result.Locale, Passwd: result.Passwd, CompileCache: result.CompileCache}
+	if result.Seccomp != nil {
+		env.Seccomp = &Seccomp{Mode: result.Seccomp.Mode, Filters: result.Seccomp.Filters}
+	}
+	if result.Ulimits != nil {
+		env.Ulimits = newUlimits(*result.Ulimits)
+	}
+	if result.Workdir != nil {
+		size := WorkdirSize(*result.Workdir)
+		env.Workdir = &size
+	}
+	env.WorkdirGit = newWorkdirGit(result.WorkdirGit)
+	env.ImagePull = newImagePull(result.ImagePull)
+	if l := result.Limits; l != nil {
+		env.Limits = &LimitReport{Requested: LimitValues(l.Requested), Enforced: LimitValues(l.Enforced), NotEnforced: l.NotEnforced}
+	}
+	return env
+}
+
+// knownLanguages resolves the language names and aliases requests may use.
+var knownLanguages = runtime.NewRegistry()
+
+// runtimeLimits is knownLanguages with sandbox.runtime_limits over their
+// default limits. The backend has refused overrides that don't apply when
+// it started, so failing here only leaves the runtimes' own.
+func runtimeLimits(overrides map[string]config.DefaultLimits) *runtime.Registry {
+	if len(overrides) == 0 {
+		return knownLanguages
+	}
+	runtimes := runtime.NewRegistry()
+	if err := sandbox.SetRuntimeLimits(runtimes, overrides); err != nil {
+		log.Warn().Err(err).Msg("sandbox.runtime_limits not applied")
+		return knownLanguages
+	}
+	return runtimes
+}
+
+// defaultLimits are the limits language's executions get for the ones
+// their request leaves out.
+func (h *Handlers) defaultLimits(language string) sandbox.ResourceLimits {
+	runtimes := h.runtimes
+	if runtimes == nil {
+		runtimes = knownLanguages
+	}
+	return sandbox.RuntimeLimits(runtimes, language)
+}
+
+// canonicalLanguage maps an alias such as py or nodejs to its runtime's
+// name, so metrics, audit records, policies and responses see one spelling.
+// An unknown language is returned as sent, for the backend to reject with
+// the languages it supports.
+func canonicalLanguage(lang string) string {
+	if name, ok := knownLanguages.Canonical(lang); ok {
+		return name
+	}
+	return lang
+}
+
+func newUlimits(u sandbox.Ulimits) *Ulimits {
+	return &Ulimits{NoFile: u.NoFile, NProc: u.NProc, FSize: u.FSize, Core: u.Core}
+}
+
+func newClaudeOptions(opts *runtime.ClaudeOptions) *ClaudeOptions {
+	if opts == nil {
+		return nil
+	}
+	return &ClaudeOptions{
+		AllowedTools:    opts.AllowedTools,
+		DisallowedTools: opts.DisallowedTools,
+		MaxTurns:        opts.MaxTurns,
+		Model:           opts.Model,
+	}
+}
+
+// sandboxClaudeOptions converts the request's claude options; nil when
+// omitted so the backend applies the config defaults.
+func sandboxClaudeOptions(o *ClaudeOptions) *runtime.ClaudeOptions {
+	if o == nil {
+		return nil
+	}
+	return &runtime.ClaudeOptions{
+		AllowedTools:    o.AllowedTools,
+		DisallowedTools: o.DisallowedTools,
+		MaxTurns:        o.MaxTurns,
+		Model:           o.Model,
+	}
+}
+
+func newSecurityEvents(events []sandbox.SecurityEvent) []SecurityEvent {
+	out := make([]SecurityEvent, 0, len(events))
+	for _, e := range events {
+		out = append(out, SecurityEvent{
+			Type:     e.Type,
+			Source:   e.Source,
+			Severity: e.Severity,
+			Syscall:  e.Syscall,
+			Detail:   e.Detail,
+			Line:     e.Line,
+			Count:    e.Count,
+		})
+	}
+	return out
+}
+
+// matchedMiner reports whether the code matched the crypto_miner pattern,
+// which lets sandbox.cpu_abuse kill it without waiting for it to go quiet.
+func matchedMiner(detections []monitor.Detection) bool {
+	for _, d := range detections {
+		if d.Pattern == "crypto_miner" {
+			return true
+		}
+	}
+	return false
+}
+
+// detectionEvents turns detector findings into result events, one per
+// pattern however many lines it matched on.
+func detectionEvents(source string, detections []monitor.Detection) []sandbox.SecurityEvent {
+	var events []sandbox.SecurityEvent
+	for _, d := range monitor.Dedupe(detections) {
+		e := sandbox.SecurityEvent{
+			Type:     d.Pattern,
+			Source:   source,
+			Severity: d.Severity,
+			Detail:   d.Detail,
+			Line:     d.Line,
+		}
+		if source != sandbox.SourceOutput {
+			e.Count = d.Count
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// attachedMounts reports the shared mounts a result ran with: the requested
+// ones, since the backend rejects the execution if any can't be attached.
+// Chaos results ran no container and have none.
+func attachedMounts(result *sandbox.ExecutionResult, requested []string) []string {
+	if result.Chaos {
+		return nil
+	}
+	return requested
+}
+
+// logWithoutResult records an execution that ran but ended without a
+// result, killed or failed mid-stream, with the output it wrote until then.
+func (h *Handlers) logWithoutResult(execReq sandbox.ExecutionRequest, req ExecutionRequest, st state.State, start time.Time, r *http.Request, network string, cost float64) {
+	stdout, stderr := execReq.Partial.Output()
+	h.logAudit(&sandbox.ExecutionResult{ID: execReq.ID, Output: stdout, Stderr: stderr, ExitCode: -1, Duration: time.Since(start), Network: network},
+		req.Language, req.Code, req.TaskID, st, start, r, req.SharedMounts, cost)
+}
+
+func (h *Handlers) logAudit(result *sandbox.ExecutionResult, language, code, taskID string, st state.State, start time.Time, r *http.Request, sharedMounts []string, cost float64) {
+	if h.executions.interrupted(result.ID) {
+		return // its record was written when the server gave up on it
+	}
+	h.writeAudit(result, language, code, taskID, st, start, r, sharedMounts, cost)
+}
+
+// writeAudit records a finished execution in the audit log.
+func (h *Handlers) writeAudit(result *sandbox.ExecutionResult, language, code, taskID string, st state.State, start time.Time, r *http.Request, sharedMounts []string, cost float64) {
+	h.images.record(result)
+	if h.auditWriter == nil && h.db != nil {
+		return
+	}
+
+	h.storeAudit(h.auditRecord(result, language, code, taskID, st, start, r, sharedMounts, cost))
+}
+
+// storeAudit hands an audit record to the audit writer and to the records
+// kept in memory, and without a database to the usage reports'.
+func (h *Handlers) storeAudit(exec *storage.Execution) {
+	if h.db == nil {
+		h.recent.add(exec)
+	}
+	h.retained.add(exec)
+	if h.auditWriter != nil {
+		h.auditWriter.Log(exec)
+	}
+}
+
+// auditRecord is the audit log's record of a finished execution, keeping of
+// its code and output what the caller's dataPolicy allows.
+func (h *Handlers) auditRecord(result *sandbox.ExecutionResult, language, code, taskID string, st state.State, start time.Time, r *http.Request, sharedMounts []string, cost float64) *storage.Execution {
+	events := make([]storage.SecurityEventRecord, 0, len(result.SecurityEvents))
+	for _, e := range result.SecurityEvents {
+		events = append(events, storage.SecurityEventRecord{
+			ExecutionID: result.ID,
+			Type:        e.Type,
+			Source:      e.Source,
+			Severity:    e.Severity,
+			Detail:      e.Detail,
+			Syscall:     e.Syscall,
+			Line:        e.Line,
+			Count:       max(e.Count, 1),
+		})
+	}
+
+	var connections []storage.NetworkConnectionRecord
+	if audit := result.NetworkConnections; audit != nil {
+		for _, c := range audit.Connections {
+			connections = append(connections, storage.NetworkConnectionRecord{
+				ExecutionID:   result.ID,
+				DstIP:         c.DstIP,
+				DstPort:       c.DstPort,
+				Protocol:      c.Protocol,
+				Connections:   c.Connections,
+				BytesEstimate: c.BytesEstimate,
+				FirstSeen:     c.FirstSeen,
+			})
+		}
 	}
 
 	completedAt := time.Now()
-	h.auditWriter.Log(&storage.Execution{
+	exec := &storage.Execution{
 		ID:             result.ID,
 		Language:       language,
 		CodeHash:       result.CodeHash,
@@ -359,12 +1231,53 @@ func (h *Handlers) logAudit(result *sandbox.ExecutionResult, language, status st
 		Output:         result.Output,
 		Stderr:         result.Stderr,
 		DurationMS:     result.Duration.Milliseconds(),
+		CPUTimeMS:      result.ResourceUsage.CPUTimeMS,
+		MemoryPeakMB:   result.ResourceUsage.MemoryPeakMB,
 		SecurityEvents: len(result.SecurityEvents),
-		Status:         status,
+		Status:         st,
 		RequestIP:      r.RemoteAddr,
+		APIKeyHash:     workspaceOwner(r),
+		Chaos:          result.Chaos,
+		SharedMounts:   sharedMounts,
+		ClaudeOptions:  claudeOptionsRecord(result.Claude, result.ClaudeUsage, code),
+		Cost:           cost,
+		TaskID:         taskID,
+		CancellationGroup: h.executions.groupOf(result.ID),
+		ServerVersion:  version.Get().String(),
+		ImageDigest:    result.ImageDigest,
+		Network:        result.Network,
+		WorkdirGit:     workdirGitRecord(result.WorkdirGit),
+		Events:         events,
+		Connections:    connections,
 		CreatedAt:      start,
 		CompletedAt:    &completedAt,
-	})
+	}
+	h.privacy.resolve(exec.APIKeyHash).apply(exec, code)
+	return exec
+}
+
+func claudeOptionsRecord(opts *runtime.ClaudeOptions, usage *sandbox.ClaudeUsage, prompt string) *storage.ClaudeOptions {
+	if opts == nil {
+		return nil
+	}
+	record := &storage.ClaudeOptions{
+		AllowedTools:    opts.AllowedTools,
+		DisallowedTools: opts.DisallowedTools,
+		MaxTurns:        opts.MaxTurns,
+		Model:           opts.Model,
+		PromptBytes:     len(prompt),
+	}
+	if usage != nil {
+		record.Turns, record.InputTokens, record.OutputTokens = usage.Turns, usage.InputTokens, usage.OutputTokens
+	}
+	return record
+}
+
+// recordStreamDrops counts the output a slow streaming client missed.
+func (h *Handlers) recordStreamDrops(sse *sseStream) {
+	for stream, n := range sse.Dropped() {
+		h.metrics.RecordStreamDrop(stream, n)
+	}
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -375,11 +1288,3 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	}
 }
 
-func writeError(w http.ResponseWriter, msg, code string, status int, r *http.Request) {
-	resp := ErrorResponse{
-		Error:     msg,
-		Code:      code,
-		RequestID: RequestIDFromContext(r.Context()),
-	}
-	writeJSON(w, status, resp)
-}
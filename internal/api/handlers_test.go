@@ -3,38 +3,75 @@ package api
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/runtime"
 	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/internal/version"
+	"safe-agent-sandbox/internal/workspace"
 )
 
 // mockBackend implements sandbox.Backend for handler tests.
 type mockBackend struct {
 	result *sandbox.ExecutionResult
 	err    error
+	req    sandbox.ExecutionRequest // last request received
 }
 
-func (m *mockBackend) Execute(_ context.Context, _ sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+func (m *mockBackend) Execute(_ context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	m.req = req
 	return m.result, m.err
 }
 
-func (m *mockBackend) ExecuteStreaming(_ context.Context, _ sandbox.ExecutionRequest, _, _ io.Writer) (*sandbox.ExecutionResult, error) {
+func (m *mockBackend) ExecuteStreaming(_ context.Context, req sandbox.ExecutionRequest, _, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	m.req = req
 	return m.result, m.err
 }
 
 func (m *mockBackend) Close() error { return nil }
 
+func (m *mockBackend) Name() string { return "docker" }
+
 func newTestHandlers(backend sandbox.Backend) *Handlers {
 	return &Handlers{
 		backend:  backend,
 		metrics:  monitor.NewMetrics(),
 		detector: monitor.NewEscapeDetector(),
+		prompts:  newPromptScreen(config.DefaultConfig().Security.Claude),
+		now:      time.Now,
+
+		estimates: newClaudeEstimator(config.DefaultConfig().Security.Claude),
+
+		executions:         newExecutionRegistry(nil),
+		progressInterval:   defaultProgressInterval,
+		streamBufferBytes:  defaultStreamBufferBytes,
+		streamWriteTimeout: defaultStreamWriteTimeout,
+
+		usageCache: newUsageCache(usageCacheTTL),
+		recent:     newRecentExecutions(usageRecentSize),
+		images:     newImageDigests(nil),
+
+		binaryMaxBytes: sandbox.DefaultBinaryMaxBytes,
 	}
 }
 
@@ -123,6 +160,511 @@ func TestHandleExecute_Success(t *testing.T) {
 	}
 }
 
+// TestHandleExecute_LanguageAlias checks an alias reaches the backend,
+// metrics and audit log as the runtime's name.
+func TestHandleExecute_LanguageAlias(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "test-id"}}
+	h := newTestHandlers(backend)
+	for _, lang := range []string{"py", "Python3"} {
+		if rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: lang, Code: "print(1)"}); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", lang, rec.Code, rec.Body)
+		}
+		if backend.req.Language != "python" {
+			t.Errorf("%s: backend got language %q, want python", lang, backend.req.Language)
+		}
+	}
+	if got := metricValue(t, h.metrics.ExecutionsTotal.WithLabelValues("docker", "python", string(state.Completed), "false")); got != 2 {
+		t.Errorf("python executions = %v, want both aliases counted", got)
+	}
+	if got := metricValue(t, h.metrics.ExecutionsTotal.WithLabelValues("docker", "py", string(state.Completed), "false")); got != 0 {
+		t.Errorf("py executions = %v, want none under the alias", got)
+	}
+}
+
+func TestHandleExecute_SharedMounts(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}})
+	h.sharedMounts = []string{"datasets"}
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1", SharedMounts: []string{"datasets"}})
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.SharedMounts) != 1 || resp.SharedMounts[0] != "datasets" {
+		t.Errorf("shared_mounts = %v, want [datasets]", resp.SharedMounts)
+	}
+
+	h = newTestHandlers(&mockBackend{err: fmt.Errorf("%w: unknown shared mount \"x\" (available to python: datasets)", sandbox.ErrInvalidRequest)})
+	h.sharedMounts = []string{"datasets"}
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1", SharedMounts: []string{"x"}})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "available to python: datasets") {
+		t.Errorf("unknown mount: status %d body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleExecute_MergeOutput(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{
+		ID:           "x",
+		Output:       "1\nTraceback\n",
+		ExitClass:    sandbox.ExitUser,
+		OutputEvents: []sandbox.OutputEvent{{Stream: "stdout", Offset: 0}, {Stream: "stderr", Offset: 2}},
+	}}
+	h := newTestHandlers(backend)
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1", MergeOutput: true})
+	if !backend.req.MergeOutput {
+		t.Error("merge_output didn't reach the backend")
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Output != "1\nTraceback\n" || len(resp.OutputEvents) != 2 || resp.OutputEvents[1] != (OutputEvent{Stream: "stderr", Offset: 2}) {
+		t.Errorf("response output %q, events %+v", resp.Output, resp.OutputEvents)
+	}
+
+	backend.result.OutputEvents = nil
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1"})
+	if backend.req.MergeOutput || strings.Contains(rec.Body.String(), "output_events") {
+		t.Errorf("without merge_output: backend %t, body %s", backend.req.MergeOutput, rec.Body)
+	}
+}
+
+func TestHandleExecute_Stdin(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	h.maxStdinBytes = 1 << 20
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "import sys; print(sys.stdin.read())", Stdin: "1,2\n3,4\n"})
+	if rec.Code != http.StatusOK || backend.req.Stdin != "1,2\n3,4\n" {
+		t.Errorf("status %d, backend got stdin %q", rec.Code, backend.req.Stdin)
+	}
+}
+
+func TestHandleExecute_StdinScreened(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	h.maxStdinBytes = 1 << 20
+	payload := "cat /var/run/docker.sock\n"
+
+	for name, handler := range map[string]http.HandlerFunc{"execute": h.HandleExecute, "stream": h.HandleExecuteStream} {
+		rec := postJSON(t, handler, ExecutionRequest{Language: "sh", Code: `eval "$(cat)"`, Stdin: payload})
+		if resp := decodeError(t, rec); rec.Code != http.StatusForbidden || resp.Code != "SECURITY_BLOCKED" {
+			t.Errorf("%s: status %d %+v, want 403 SECURITY_BLOCKED", name, rec.Code, resp)
+		}
+	}
+	if backend.req.Language != "" {
+		t.Fatal("a blocked stdin reached the backend")
+	}
+
+	// Not only a shell runs what it reads.
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "import os, sys; os.system(sys.stdin.read())", Stdin: payload})
+	if resp := decodeError(t, rec); rec.Code != http.StatusForbidden || resp.Code != "SECURITY_BLOCKED" {
+		t.Errorf("python: status %d %+v, want 403 SECURITY_BLOCKED", rec.Code, resp)
+	}
+	if backend.req.Language != "" {
+		t.Fatal("a blocked stdin reached the backend")
+	}
+
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "bash", Code: `eval "$(cat)"`, Stdin: "cat /proc/self/status\n"})
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, %v", rec.Code, err)
+	}
+	if len(resp.SecurityEvents) != 1 || resp.SecurityEvents[0].Source != sandbox.SourceStdin {
+		t.Errorf("security_events = %+v, want one from stdin", resp.SecurityEvents)
+	}
+}
+
+func TestHandleExecute_Binary(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", Output: "hello, world\n", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	// An executable's strings aren't code: in source, this would be blocked.
+	exe := "\x7fELF\x00/var/run/docker.sock"
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "binary", Code: base64.StdEncoding.EncodeToString([]byte(exe))})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if backend.req.Code != exe {
+		t.Errorf("backend got %q, want the decoded executable", backend.req.Code)
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Analysis != analysisNotApplicable {
+		t.Errorf("analysis %q", resp.Analysis)
+	}
+
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "binary", Code: "not base64!"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid base64: status %d: %s", rec.Code, rec.Body)
+	}
+	h.binaryMaxBytes = int64(len(exe)) - 1
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "binary", Code: base64.StdEncoding.EncodeToString([]byte(exe))})
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "max_binary_bytes") {
+		t.Errorf("over the cap: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)"})
+	if strings.Contains(rec.Body.String(), `"analysis"`) {
+		t.Errorf("python response says analysis: %s", rec.Body)
+	}
+}
+
+func TestHandleExecute_FairShareWeight(t *testing.T) {
+	cfg := canaryConfig()
+	cfg.Sandbox.FairShare.Enabled = true
+	cfg.Sandbox.FairShare.Weights = []config.FairShareWeight{{Keys: []string{canaryAdminKey}, Weight: 4}}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x"}}
+	handler := NewServer(cfg, backend, nil, nil, monitor.NewMetrics()).Handler()
+
+	for key, want := range map[string]int{canaryAdminKey: 4, canaryUserKey: 0} {
+		rec := doRequest(handler, http.MethodPost, "/execute", key, strings.NewReader(`{"language":"python","code":"print(1)"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if backend.req.ShareWeight != want || backend.req.Tenant != ownerHash(key) {
+			t.Errorf("share weight %d for tenant %s, want %d for %s", backend.req.ShareWeight, backend.req.Tenant, want, ownerHash(key))
+		}
+	}
+}
+
+func TestHandleExecute_CodeSecurityEvents(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{
+		ID:        "x",
+		Output:    "Linux version 6.8.0\n",
+		ExitClass: sandbox.ExitUser,
+		SecurityEvents: []sandbox.SecurityEvent{
+			{Type: "oom_kill", Source: sandbox.SourceRuntime, Detail: "process killed by OOM killer"},
+		},
+	}})
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{
+		Language: "python",
+		Code:     "import os\nprint(open('/proc/self/status').read())",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: a high severity detection is reported, not blocked", rec.Code)
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SecurityEvent{
+		{Type: "proc_self_access", Source: "code", Severity: "high", Detail: "Accessing /proc/self for process info", Line: 2, Count: 1},
+		{Type: "oom_kill", Source: "runtime", Detail: "process killed by OOM killer"},
+		{Type: "kernel_leak", Source: "output", Severity: "high", Detail: "suspicious content in output: kernel_leak"},
+	}
+	if !reflect.DeepEqual(resp.SecurityEvents, want) {
+		t.Errorf("security_events = %+v, want %+v", resp.SecurityEvents, want)
+	}
+}
+
+func TestHandleExecute_CodeSecurityEventsDeduplicated(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}})
+	code := strings.Repeat("print(open('/proc/self/status').read())\n", 500) + "# xmrig\n"
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: code})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.SecurityEvents) != 2 {
+		t.Fatalf("got %d security events, want one per pattern: %+v", len(resp.SecurityEvents), resp.SecurityEvents)
+	}
+	proc, miner := resp.SecurityEvents[0], resp.SecurityEvents[1]
+	if proc.Type != "proc_self_access" || proc.Line != 1 || proc.Count != 500 {
+		t.Errorf("repeated pattern = %+v, want first line 1 and count 500", proc)
+	}
+	if miner.Type != "crypto_miner" || miner.Line != 501 || miner.Count != 1 || miner.Severity != "medium" {
+		t.Errorf("single match = %+v", miner)
+	}
+}
+
+func TestHandleExecuteStream_CodeSecurityEvents(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}})
+	b, _ := json.Marshal(ExecutionRequest{Language: "bash", Code: "cat /proc/self/status\ncat /proc/self/maps"})
+	rec := httptest.NewRecorder()
+	h.HandleExecuteStream(rec, httptest.NewRequest(http.MethodPost, "/execute/stream", bytes.NewReader(b)))
+
+	done := doneEvent(t, rec.Body.String())
+	if len(done.SecurityEvents) != 1 {
+		t.Fatalf("done security_events = %+v, want one", done.SecurityEvents)
+	}
+	if e := done.SecurityEvents[0]; e.Source != "code" || e.Type != "proc_self_access" || e.Line != 1 || e.Count != 2 {
+		t.Errorf("done security event = %+v", e)
+	}
+}
+
+func TestHandleExecute_Environment(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{
+		ID:        "x",
+		ExitClass: sandbox.ExitUser,
+		Argv:      []string{"python3", "-u", "-B", "/workspace/code.py", "in.csv"},
+		Cwd:       "/tmp",
+	}})
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1", Args: []string{"in.csv"}, Cwd: "/tmp"})
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := &Environment{Argv: []string{"python3", "-u", "-B", "/workspace/code.py", "in.csv"}, Cwd: "/tmp", Network: sandbox.NetworkNotRequested, ServerVersion: version.Get().String()}
+	if !reflect.DeepEqual(resp.Environment, want) {
+		t.Errorf("environment = %+v, want %+v", resp.Environment, want)
+	}
+
+	h = newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", Chaos: true}})
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "1"})
+	if strings.Contains(rec.Body.String(), `"environment"`) {
+		t.Errorf("chaos result has an environment: %s", rec.Body.String())
+	}
+}
+
+// TestExecuteRequestMapping checks POST /execute and POST /execute/stream
+// hand the backend the same request for the same body.
+func TestExecuteRequestMapping(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTokenHandlers(backend, &fakeBroker{})
+	h.chaosEnabled = true
+	h.maxIdleTimeout = time.Minute
+	h.sharedMounts = []string{"datasets"}
+	h.claudeCaches = true
+	store, err := workspace.NewStore(t.TempDir(), time.Hour, 1<<20, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	h.workspaces = store
+	ws, err := store.Create(ownerHash("team-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	common := ExecutionRequest{
+		Timeout: Duration{90 * time.Second},
+		Limits:  ResourceLimits{CPUShares: 512, MemoryMB: 1024, PidsLimit: 128, DiskMB: 200},
+		Perms: Permissions{
+			Network:     NetworkPermissions{Enabled: true},
+			Filesystem:  FilesystemPermissions{WritableDirs: []string{"/workspace/out"}},
+			Environment: []string{"DEBUG=1", "LANG=C.UTF-8"},
+		},
+		Chaos:             &ChaosRequest{Mode: "slow_stream", Delay: Duration{time.Millisecond}},
+		SharedMounts:      []string{"datasets"},
+		TaskID:            "fix-tests",
+		IdleOutputTimeout: Duration{30 * time.Second},
+	}
+	claude := common
+	claude.Language, claude.Code = "claude", "Fix the failing tests."
+	claude.WorkDir = "/srv/projects/app"
+	claude.UseCaches = true
+	claude.Claude = &ClaudeOptions{Model: "claude-sonnet-4-5", MaxTurns: 5, AllowedTools: []string{"Read"}, DisallowedTools: []string{"Bash"}}
+	claude.ClaudeCredential = "team"
+	python := common
+	python.Language, python.Code = "python", "print(1)"
+	python.WorkspaceID = ws.ID
+	python.Args = []string{"in.csv"}
+	python.Cwd = "/workspace/out"
+	python.Perms.Filesystem.ReadOnly = true
+
+	for _, body := range []ExecutionRequest{claude, python} {
+		var got []sandbox.ExecutionRequest
+		for _, handler := range []http.HandlerFunc{h.HandleExecute, h.HandleExecuteStream} {
+			backend.req = sandbox.ExecutionRequest{}
+			if rec := postAs(t, handler, "team-key", body); rec.Code != http.StatusOK {
+				t.Fatalf("%s: status %d %s", body.Language, rec.Code, rec.Body.String())
+			}
+			req := backend.req
+			if req.ID == "" || req.Progress == nil || (body.Language == "claude") != (req.ProxySecret != "") {
+				t.Errorf("%s: id %q, progress %v, proxy secret %q", body.Language, req.ID, req.Progress != nil, req.ProxySecret)
+			}
+			// Each execution gets its own ID, progress, admission and proxy
+			// secret, and only the stream has a client to warn.
+			req.ID, req.Progress, req.ProxySecret, req.IdleWarning, req.DirtyWorkdir, req.ImagePulling, req.Admitted = "", nil, "", nil, nil, nil, nil
+			req.LimitsNotEnforced = nil
+			got = append(got, req)
+		}
+		if !reflect.DeepEqual(got[0], got[1]) {
+			t.Errorf("%s:\nexecute %+v\nstream  %+v", body.Language, got[0], got[1])
+		}
+		want := sandbox.ResourceLimits{CPUShares: 512, MemoryMB: 1024, PidsLimit: 128, DiskMB: 200}
+		if r := got[0]; r.Limits != want || !r.NetworkEnabled || len(r.EnvVars) != 2 || r.Chaos == nil || r.Timeout != 90*time.Second {
+			t.Errorf("%s: mapped %+v", body.Language, r)
+		}
+	}
+}
+
+func TestExecuteRequestMapping_PartialLimits(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	defaults := sandbox.DefaultLimits()
+	for _, tc := range []struct {
+		limits ResourceLimits
+		want   func(*sandbox.ResourceLimits)
+	}{
+		{ResourceLimits{}, func(*sandbox.ResourceLimits) {}},
+		{ResourceLimits{MemoryMB: 1024}, func(l *sandbox.ResourceLimits) { l.MemoryMB = 1024 }},
+		{ResourceLimits{CPUShares: 2048}, func(l *sandbox.ResourceLimits) { l.CPUShares = 2048 }},
+		{ResourceLimits{PidsLimit: 32, DiskMB: 50}, func(l *sandbox.ResourceLimits) { l.PidsLimit, l.DiskMB = 32, 50 }},
+	} {
+		want := defaults
+		tc.want(&want)
+		for _, handler := range []http.HandlerFunc{h.HandleExecute, h.HandleExecuteStream} {
+			postJSON(t, handler, ExecutionRequest{Language: "python", Code: "1", Limits: tc.limits})
+			if backend.req.Limits != want {
+				t.Errorf("limits %+v ran with %+v, want %+v", tc.limits, backend.req.Limits, want)
+			}
+		}
+	}
+}
+
+func TestExecuteRequestMapping_RuntimeLimits(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	h.runtimes = runtimeLimits(map[string]config.DefaultLimits{"node": {MemoryMB: 768}})
+	for _, tc := range []struct {
+		language string
+		limits   ResourceLimits
+		want     sandbox.ResourceLimits
+	}{
+		// The request's limits, then sandbox.runtime_limits, then the runtime's.
+		{"node", ResourceLimits{MemoryMB: 2048}, sandbox.ResourceLimits{CPUShares: 512, MemoryMB: 2048, PidsLimit: 50, DiskMB: 100}},
+		{"nodejs", ResourceLimits{}, sandbox.ResourceLimits{CPUShares: 512, MemoryMB: 768, PidsLimit: 50, DiskMB: 100}},
+		{"bash", ResourceLimits{PidsLimit: 10}, sandbox.ResourceLimits{CPUShares: 512, MemoryMB: 128, PidsLimit: 10, DiskMB: 100}},
+		{"claude", ResourceLimits{}, sandbox.DevLimits()},
+		// Limits set in full run as sent, whatever the language.
+		{"bash", ResourceLimits{CPUShares: 512, MemoryMB: 256, PidsLimit: 50, DiskMB: 100}, sandbox.DefaultLimits()},
+	} {
+		postJSON(t, h.HandleExecute, ExecutionRequest{Language: tc.language, Code: "1", Limits: tc.limits})
+		if backend.req.Limits != tc.want {
+			t.Errorf("%s %+v ran with %+v, want %+v", tc.language, tc.limits, backend.req.Limits, tc.want)
+		}
+	}
+}
+
+func TestHandleExecute_OOMRecommendation(t *testing.T) {
+	rec := &sandbox.Recommendation{MemoryMB: 1024, Message: "node ran out of its 512MB of memory; try limits.memory_mb 1024"}
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitCode: 137, ExitClass: sandbox.ExitOOMKill, Recommendation: rec}})
+
+	resp := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "node", Code: "1"})
+	var got ExecutionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Recommendation == nil || *got.Recommendation != Recommendation(*rec) {
+		t.Errorf("recommendation = %+v, want %+v", got.Recommendation, rec)
+	}
+
+	resp = postJSON(t, h.HandleExecuteStream, ExecutionRequest{Language: "node", Code: "1"})
+	if !strings.Contains(resp.Body.String(), `"recommendation":{"memory_mb":1024,"message":"node ran out of its 512MB`) {
+		t.Errorf("stream done event has no recommendation: %s", resp.Body.String())
+	}
+}
+
+func TestHandleExecute_LimitsNotEnforced(t *testing.T) {
+	limits := &sandbox.LimitReport{
+		Requested:   sandbox.LimitValues{CPUShares: 512, MemoryMB: 256, MemorySwapMB: 256, PidsLimit: 50},
+		Enforced:    sandbox.LimitValues{CPUShares: 512, MemoryMB: 256, PidsLimit: 50},
+		NotEnforced: []string{sandbox.LimitMemorySwap},
+	}
+	backend := &limitGapBackend{mockBackend{result: &sandbox.ExecutionResult{ID: "x", Argv: []string{"python3"}, ExitClass: sandbox.ExitUser, Limits: limits}}}
+	h := newTestHandlers(backend)
+
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)"})
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Warnings, []Warning{limitWarning(limits.NotEnforced)}) {
+		t.Errorf("warnings = %+v, want limit_not_enforced", resp.Warnings)
+	}
+	want := &LimitReport{Requested: LimitValues(limits.Requested), Enforced: LimitValues(limits.Enforced), NotEnforced: limits.NotEnforced}
+	if resp.Environment == nil || !reflect.DeepEqual(resp.Environment.Limits, want) {
+		t.Errorf("environment = %+v, want limits %+v", resp.Environment, limits)
+	}
+
+	// A stream hears about it before the output.
+	body := postJSON(t, h.HandleExecuteStream, ExecutionRequest{Language: "python", Code: "print(1)"}).Body.String()
+	warning := strings.Index(body, "event: warning\ndata: {\"code\":\"limit_not_enforced\"")
+	if warning < 0 || warning > strings.Index(body, "event: done") {
+		t.Errorf("no limit_not_enforced warning ahead of done: %s", body)
+	}
+}
+
+// limitGapBackend calls a streaming request's LimitsNotEnforced as a
+// permissive runner on a host without swap accounting would.
+type limitGapBackend struct{ mockBackend }
+
+func (b *limitGapBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, stdout, stderr io.Writer) (*sandbox.ExecutionResult, error) {
+	if req.LimitsNotEnforced != nil {
+		req.LimitsNotEnforced(b.result.Limits.NotEnforced)
+	}
+	return b.mockBackend.ExecuteStreaming(ctx, req, stdout, stderr)
+}
+
+func TestHandleExecuteStream_WorkDir(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	h := newTestHandlers(backend)
+	rec := postJSON(t, h.HandleExecuteStream, ExecutionRequest{Language: "claude", Code: "List the files.", WorkDir: "/srv/projects/app"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d %s", rec.Code, rec.Body.String())
+	}
+	if backend.req.WorkDir != "/srv/projects/app" || !backend.req.NetworkEnabled {
+		t.Errorf("backend got work_dir %q, network %v", backend.req.WorkDir, backend.req.NetworkEnabled)
+	}
+}
+
+func TestHandleExecute_ClaudeOptions(t *testing.T) {
+	resolved := &runtime.ClaudeOptions{
+		AllowedTools:    []string{"Read"},
+		DisallowedTools: []string{"WebSearch"},
+		MaxTurns:        10,
+		Model:           "claude-haiku-4-5",
+	}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{
+		ID:        "x",
+		ExitClass: sandbox.ExitUser,
+		Argv:      []string{"sh", "-c", "..."},
+		Claude:    resolved,
+	}}
+	h := newTestHandlers(backend)
+
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "claude", Code: "hi", Claude: &ClaudeOptions{AllowedTools: []string{"Read"}}})
+	if want := (&runtime.ClaudeOptions{AllowedTools: []string{"Read"}}); !reflect.DeepEqual(backend.req.Claude, want) {
+		t.Errorf("backend got %+v, want %+v", backend.req.Claude, want)
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := &ClaudeOptions{AllowedTools: []string{"Read"}, DisallowedTools: []string{"WebSearch"}, MaxTurns: 10, Model: "claude-haiku-4-5"}
+	if resp.Environment == nil || !reflect.DeepEqual(resp.Environment.Claude, want) {
+		t.Errorf("environment = %+v, want claude options %+v", resp.Environment, want)
+	}
+
+	// Omitted options reach the backend as nil so it applies the config defaults.
+	postJSON(t, h.HandleExecute, ExecutionRequest{Language: "claude", Code: "hi"})
+	if backend.req.Claude != nil {
+		t.Errorf("backend got %+v for a request without options", backend.req.Claude)
+	}
+
+	usage := &sandbox.ClaudeUsage{Turns: 4, InputTokens: 9000, OutputTokens: 700}
+	if got := claudeOptionsRecord(resolved, usage, "hi"); !reflect.DeepEqual(got, &storage.ClaudeOptions{
+		AllowedTools: []string{"Read"}, DisallowedTools: []string{"WebSearch"}, MaxTurns: 10, Model: "claude-haiku-4-5",
+		PromptBytes: 2, Turns: 4, InputTokens: 9000, OutputTokens: 700,
+	}) {
+		t.Errorf("audit record = %+v", got)
+	}
+}
+
+func TestHandleExecute_ClaudeOptionsRejected(t *testing.T) {
+	h := newTestHandlers(&mockBackend{err: fmt.Errorf("%w: model \"x\" is not allowed", sandbox.ErrInvalidRequest)})
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "claude", Code: "hi", Claude: &ClaudeOptions{Model: "x"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+}
+
 func TestHandleExecute_ValidationErrors(t *testing.T) {
 	h := newTestHandlers(&mockBackend{})
 
@@ -165,3 +707,422 @@ func TestHandleExecute_BackendUnavailable(t *testing.T) {
 		t.Errorf("got code %q, want RUNNER_UNAVAILABLE", resp.Code)
 	}
 }
+
+func TestHandleExecute_ExitClass(t *testing.T) {
+	h := newTestHandlers(&mockBackend{
+		result: &sandbox.ExecutionResult{
+			ID:        "oom-id",
+			ExitCode:  137,
+			ExitClass: sandbox.ExitOOMKill,
+			Duration:  time.Second,
+		},
+	})
+
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{
+		Language: "python",
+		Code:     "x = ' ' * 10**10",
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ExitClass != "oom_kill" {
+		t.Errorf("ExitClass = %q, want oom_kill", resp.ExitClass)
+	}
+}
+
+func postChaos(t *testing.T, handler http.HandlerFunc, header string) *httptest.ResponseRecorder {
+	t.Helper()
+	b, _ := json.Marshal(ExecutionRequest{Language: "python", Code: "print(1)"})
+	req := httptest.NewRequest(http.MethodPost, "/execute", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(chaosHeader, header)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleExecute_ChaosDisabled(t *testing.T) {
+	h := newTestHandlers(sandbox.NewChaosBackend(&mockBackend{}))
+
+	rec := postChaos(t, h.HandleExecute, "oom")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", rec.Code)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "CHAOS_DISABLED" {
+		t.Errorf("got code %q, want CHAOS_DISABLED", resp.Code)
+	}
+}
+
+// TestHandleExecute_ChaosMatchesReal checks that each synthesized failure
+// produces the same response as the genuine backend result it imitates.
+func TestHandleExecute_ChaosMatchesReal(t *testing.T) {
+	tests := []struct {
+		header string
+		real   *mockBackend
+	}{
+		{"timeout", &mockBackend{
+			result: &sandbox.ExecutionResult{ExitCode: -1, ExitClass: sandbox.ExitTimeoutKill},
+			err:    sandbox.ErrTimeout,
+		}},
+		{"oom", &mockBackend{
+			result: &sandbox.ExecutionResult{ExitCode: 137, ExitClass: sandbox.ExitOOMKill},
+		}},
+		{"infra_error", &mockBackend{
+			result: &sandbox.ExecutionResult{ExitCode: 125, ExitClass: sandbox.ExitInfraError},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			realRec := postJSON(t, newTestHandlers(tt.real).HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)"})
+
+			h := newTestHandlers(sandbox.NewChaosBackend(&mockBackend{}))
+			h.chaosEnabled = true
+			chaosRec := postChaos(t, h.HandleExecute, tt.header)
+
+			if chaosRec.Code != realRec.Code {
+				t.Fatalf("chaos status %d, real status %d", chaosRec.Code, realRec.Code)
+			}
+			var realResp, chaosResp ExecutionResponse
+			if err := json.NewDecoder(realRec.Body).Decode(&realResp); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.NewDecoder(chaosRec.Body).Decode(&chaosResp); err != nil {
+				t.Fatal(err)
+			}
+			if chaosResp.ExitCode != realResp.ExitCode || chaosResp.ExitClass != realResp.ExitClass {
+				t.Errorf("chaos = (%d, %q), real = (%d, %q)",
+					chaosResp.ExitCode, chaosResp.ExitClass, realResp.ExitCode, realResp.ExitClass)
+			}
+		})
+	}
+}
+
+func TestHandleExecute_ChaosRateLimitedMatchesLimiter(t *testing.T) {
+	// An empty bucket that refills once a second, which chaos stands in for.
+	limited := RateLimitMiddleware(1, 0, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	limRec := httptest.NewRecorder()
+	limited.ServeHTTP(limRec, httptest.NewRequest(http.MethodPost, "/execute", nil))
+
+	h := newTestHandlers(sandbox.NewChaosBackend(&mockBackend{}))
+	h.chaosEnabled = true
+	chaosRec := postChaos(t, h.HandleExecute, "rate_limited")
+
+	if chaosRec.Code != http.StatusTooManyRequests || limRec.Code != http.StatusTooManyRequests {
+		t.Fatalf("chaos status %d, limiter status %d, want 429", chaosRec.Code, limRec.Code)
+	}
+	if chaosRec.Body.String() != limRec.Body.String() {
+		t.Errorf("chaos body %q, limiter body %q", chaosRec.Body.String(), limRec.Body.String())
+	}
+	if chaosRec.Header().Get("Retry-After") != limRec.Header().Get("Retry-After") {
+		t.Errorf("Retry-After mismatch: %q vs %q", chaosRec.Header().Get("Retry-After"), limRec.Header().Get("Retry-After"))
+	}
+}
+
+func TestHandleExecute_LanguageSaturated(t *testing.T) {
+	saturated := &sandbox.ExecutionError{ExecID: "x", Op: "acquire_slot",
+		Err: &sandbox.SaturationError{Pool: "claude", RetryAfter: 95 * time.Second}}
+	h := newTestHandlers(&mockBackend{err: saturated})
+
+	for _, handler := range []http.HandlerFunc{h.HandleExecute, h.HandleExecuteStream} {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"language":"claude","code":"fix the bug"}`)
+		handler(rec, httptest.NewRequest(http.MethodPost, "/execute", body))
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want 429", rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "95" {
+			t.Errorf("Retry-After = %q, want 95", got)
+		}
+		var resp apierror.Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != apierror.CodeLanguageSaturated || resp.Details["pool"] != "claude" {
+			t.Errorf("resp = %+v", resp)
+		}
+	}
+}
+
+func TestParseChaosHeader(t *testing.T) {
+	spec, err := parseChaosHeader("slow_stream; delay=250ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Mode != sandbox.ChaosSlowStream || spec.Delay != 250*time.Millisecond {
+		t.Errorf("got %+v", spec)
+	}
+
+	for _, bad := range []string{"meteor", "oom;delay=soon", "oom;speed=1"} {
+		if _, err := parseChaosHeader(bad); err == nil {
+			t.Errorf("parseChaosHeader(%q) expected error", bad)
+		}
+	}
+}
+
+func TestMetricsEndpoint_OpenMetricsExemplars(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowUnauthenticated = true
+	metrics := monitor.NewMetrics()
+	s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, nil, nil, metrics)
+	h := s.Handler()
+
+	body := strings.NewReader(`{"language":"python","code":"print(1)"}`)
+	req := httptest.NewRequest(http.MethodPost, "/execute", body)
+	req.Header.Set("X-Request-ID", "0b6e7c1e-3a4d-4e2f-9c1b-5d6e7f8a9b0c")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Content-Type = %q, want OpenMetrics", ct)
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, `sandbox_executions_total{backend="docker",chaos="false",language="python",status="completed"} 1`) {
+		t.Errorf("missing backend-labelled counter:\n%s", out)
+	}
+	if !strings.Contains(out, `# {exec_id="0b6e7c1e-3a4d-4e2f-9c1b-5d6e7f8a9b0c"}`) {
+		t.Errorf("missing exec_id exemplar:\n%s", out)
+	}
+
+	// Plain Prometheus text format still works and carries no exemplars.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "# {exec_id=") {
+		t.Error("exemplars leaked into the text format")
+	}
+}
+
+func TestExecutionVersion(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"key"}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{
+		ID:          "x",
+		ExitClass:   sandbox.ExitUser,
+		Argv:        []string{"python3", "/workspace/code.py"},
+		Image:       "sandbox-python:latest",
+		ImageDigest: "sha256:0123",
+	}}
+	s := NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	handler := s.httpServer.Handler
+
+	rec := doRequest(handler, http.MethodPost, "/execute", "key", strings.NewReader(`{"language":"python","code":"print(1)"}`))
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if env := resp.Environment; env == nil || env.ServerVersion != version.Get().String() || env.ImageDigest != "sha256:0123" {
+		t.Errorf("environment = %+v", env)
+	}
+
+	record := s.handlers.auditRecord(backend.result, "python", "", "", state.Completed, time.Now(), httptest.NewRequest(http.MethodPost, "/execute", nil), nil, 0)
+	if record.ServerVersion != version.Get().String() || record.ImageDigest != "sha256:0123" {
+		t.Errorf("audit record server_version %q, image_digest %q", record.ServerVersion, record.ImageDigest)
+	}
+
+	var health HealthResponse
+	if err := json.NewDecoder(doRequest(handler, http.MethodGet, "/health", "", nil).Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health.ServerVersion != version.Get().String() || health.ImageDigests["sandbox-python:latest"] != "sha256:0123" {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+// TestNoRawStatusStrings fails if the handlers name an execution's status
+// with a string again instead of a state from package state.
+func TestNoRawStatusStrings(t *testing.T) {
+	fset := token.NewFileSet()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorders := map[string]bool{"logAudit": true, "auditRecord": true, "RecordExecution": true, "finish": true}
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// statusLit reports a string literal naming a state or an old status.
+		statusLit := func(e ast.Expr) bool {
+			lit, ok := e.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return false
+			}
+			s, err := strconv.Unquote(lit.Value)
+			_, known := state.Parse(s)
+			return err == nil && known
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if !ok || !recorders[sel.Sel.Name] {
+					break
+				}
+				for _, arg := range n.Args {
+					if statusLit(arg) {
+						t.Errorf("%s: %s is passed a raw status string", fset.Position(arg.Pos()), sel.Sel.Name)
+					}
+				}
+			case *ast.AssignStmt:
+				for i, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && id.Name == "status" && i < len(n.Rhs) && statusLit(n.Rhs[i]) {
+						t.Errorf("%s: status is set to a raw string", fset.Position(n.Pos()))
+					}
+				}
+			case *ast.KeyValueExpr:
+				if id, ok := n.Key.(*ast.Ident); ok && id.Name == "Status" && statusLit(n.Value) {
+					t.Errorf("%s: Status is set to a raw string", fset.Position(n.Pos()))
+				}
+			}
+			return true
+		})
+	}
+}
+
+func killExecution(t *testing.T, h *Handlers, caller, id string) (int, KillExecutionResponse) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /executions/{id}", h.HandleKillExecution)
+	req := httptest.NewRequest(http.MethodDelete, "/executions/"+id, nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, caller))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var resp KillExecutionResponse
+	if rec.Code == http.StatusAccepted {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestHandleKillExecution(t *testing.T) {
+	backend := &groupBackend{started: make(chan string, 2)}
+	h := newTestHandlers(backend)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := storage.NewFileSink(storage.FileSinkOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.auditWriter = storage.NewAuditWriter(16)
+	h.auditWriter.AddSink("file", sink)
+	h.auditWriter.Start()
+
+	runningDone := make(chan *httptest.ResponseRecorder)
+	go func() {
+		runningDone <- postAs(t, h.HandleExecute, "alice", ExecutionRequest{Language: "python", Code: "sleep"})
+	}()
+	running := <-backend.started
+	queuedDone := make(chan *httptest.ResponseRecorder)
+	go func() {
+		queuedDone <- postAs(t, h.HandleExecute, "alice", ExecutionRequest{Language: "python", Code: "wait"})
+	}()
+	queued := <-backend.started
+
+	if code, _ := killExecution(t, h, "bob", running); code != http.StatusNotFound {
+		t.Errorf("bob killing alice's execution: status %d", code)
+	}
+	if !h.executions.running(running) {
+		t.Fatal("bob killed alice's execution")
+	}
+
+	code, resp := killExecution(t, h, "alice", running)
+	if code != http.StatusAccepted || resp.ID != running || resp.Status != "kill_requested" || resp.State != "running" {
+		t.Fatalf("kill: status %d %+v", code, resp)
+	}
+	rec := <-runningDone
+	var result ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || result.ExitClass != string(sandbox.ExitManualKill) {
+		t.Errorf("killed execution: status %d %+v (%v)", rec.Code, result, err)
+	}
+
+	if code, resp := killExecution(t, h, "alice", queued); code != http.StatusAccepted || resp.State != "queued" {
+		t.Fatalf("kill of the queued execution: status %d %+v", code, resp)
+	}
+	rec = <-queuedDone
+	if resp := decodeError(t, rec); rec.Code != http.StatusConflict || resp.Code != "EXECUTION_KILLED" || resp.Details["exec_id"] != queued {
+		t.Errorf("queued execution: status %d %+v", rec.Code, resp)
+	}
+
+	backend.mu.Lock()
+	if len(backend.removed) != 1 || backend.removed[0] != running {
+		t.Errorf("removed containers of %v, want only %s's", backend.removed, running)
+	}
+	backend.mu.Unlock()
+	if code, _ := killExecution(t, h, "alice", running); code != http.StatusNotFound {
+		t.Errorf("kill of a finished execution: status %d", code)
+	}
+	if code, _ := killExecution(t, h, "alice", "not-an-id"); code != http.StatusBadRequest {
+		t.Errorf("kill of an invalid ID: status %d", code)
+	}
+
+	// The queued execution never ran and has no record.
+	h.auditWriter.Flush(5 * time.Second)
+	sink.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exec storage.Execution
+	if err := json.Unmarshal(bytes.TrimSpace(data), &exec); err != nil || exec.ID != running || exec.Status != state.Killed {
+		t.Errorf("audited %s (%v), want %s as killed", data, err, running)
+	}
+}
+
+func TestHandleKillExecution_NoResult(t *testing.T) {
+	backend := &groupBackend{started: make(chan string, 1)}
+	h := newTestHandlers(backend)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := storage.NewFileSink(storage.FileSinkOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.auditWriter = storage.NewAuditWriter(16)
+	h.auditWriter.AddSink("file", sink)
+	h.auditWriter.Start()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postAs(t, h.HandleExecute, "alice", ExecutionRequest{Language: "python", Code: "hang"})
+	}()
+	id := <-backend.started
+	if code, _ := killExecution(t, h, "alice", id); code != http.StatusAccepted {
+		t.Fatalf("kill: status %d", code)
+	}
+	rec := <-done
+	if resp := decodeError(t, rec); rec.Code != http.StatusConflict || resp.Code != "EXECUTION_KILLED" {
+		t.Errorf("status %d %+v", rec.Code, resp)
+	}
+	if p, ok := h.executions.get(id, ownerHash("alice")); !ok || p.State != string(state.Killed) {
+		t.Errorf("progress %+v", p)
+	}
+
+	h.auditWriter.Flush(5 * time.Second)
+	sink.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exec storage.Execution
+	if err := json.Unmarshal(bytes.TrimSpace(data), &exec); err != nil || exec.ID != id || exec.Status != state.Killed {
+		t.Errorf("audited %s (%v), want %s as killed", data, err, id)
+	}
+}
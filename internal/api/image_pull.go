@@ -0,0 +1,21 @@
+package api
+
+import (
+	"encoding/json"
+
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// pullingStatus is the payload of the status event a stream carries while
+// its execution waits on a pull of its image.
+func pullingStatus(p sandbox.PullProgress) []byte {
+	data, _ := json.Marshal(Status{Phase: "pulling", Image: p.Image, BytesDone: p.BytesDone, BytesTotal: p.BytesTotal})
+	return data
+}
+
+func newImagePull(pull *sandbox.ImagePull) *ImagePull {
+	if pull == nil {
+		return nil
+	}
+	return &ImagePull{Image: pull.Image, Duration: pull.Duration.String(), Bytes: pull.Bytes}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// pullingBackend plays a first execution on a fresh host: its image is
+// pulled in three steps before the program prints.
+type pullingBackend struct{}
+
+func (b pullingBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (pullingBackend) ExecuteStreaming(_ context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	const image = "python:3.12-slim"
+	for _, done := range []int64{0, 4 << 20, 10 << 20} {
+		if req.ImagePulling != nil {
+			req.ImagePulling(sandbox.PullProgress{Image: image, BytesDone: done, BytesTotal: 10 << 20})
+		}
+	}
+	stdout.Write([]byte("1\n"))
+	return &sandbox.ExecutionResult{
+		ID:        req.ID,
+		Output:    "1\n",
+		ExitClass: sandbox.ExitUser,
+		Duration:  20 * time.Millisecond,
+		Argv:      []string{"python3", "/workspace/code.py"},
+		Image:     image,
+		ImagePull: &sandbox.ImagePull{Image: image, Duration: 42 * time.Second, Bytes: 10 << 20},
+	}, nil
+}
+
+func (pullingBackend) Close() error { return nil }
+func (pullingBackend) Name() string { return "containerd" }
+
+func TestHandleExecuteStream_PullStatus(t *testing.T) {
+	h := newTestHandlers(pullingBackend{})
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"language":"python","code":"print(1)"}`)
+	h.HandleExecuteStream(w, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+
+	var statuses []stream.Status
+	var stdout bool
+	for _, e := range readEvents(t, w.Body.String()) {
+		switch e.Type {
+		case stream.EventStatus:
+			if stdout {
+				t.Error("status event after stdout")
+			}
+			var st stream.Status
+			if err := e.Decode(&st); err != nil {
+				t.Fatal(err)
+			}
+			statuses = append(statuses, st)
+		case stream.EventStdout:
+			stdout = true
+		case stream.EventDone:
+			var done stream.Done
+			if err := e.Decode(&done); err != nil {
+				t.Fatal(err)
+			}
+			if done.Duration != "20ms" || done.Environment == nil || done.Environment.ImagePull == nil || done.Environment.ImagePull.Duration != "42s" {
+				t.Errorf("done = %+v, want the run's 20ms and the pull's 42s apart", done)
+			}
+		}
+	}
+	if !stdout {
+		t.Fatal("no stdout event")
+	}
+	want := []stream.Status{
+		{Phase: "pulling", Image: "python:3.12-slim", BytesTotal: 10 << 20},
+		{Phase: "pulling", Image: "python:3.12-slim", BytesDone: 4 << 20, BytesTotal: 10 << 20},
+		{Phase: "pulling", Image: "python:3.12-slim", BytesDone: 10 << 20, BytesTotal: 10 << 20},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("status events %+v, want %+v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestHandleExecute_ImagePull(t *testing.T) {
+	h := newTestHandlers(pullingBackend{})
+	w := postJSON(t, h.HandleExecute, map[string]any{"language": "python", "code": "print(1)"})
+	var resp ExecutionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status %d body %s: %v", w.Code, w.Body, err)
+	}
+	want := ImagePull{Image: "python:3.12-slim", Duration: "42s", Bytes: 10 << 20}
+	if resp.Environment == nil || resp.Environment.ImagePull == nil || *resp.Environment.ImagePull != want {
+		t.Fatalf("environment = %+v, want image_pull %+v", resp.Environment, want)
+	}
+	if resp.Duration != "20ms" {
+		t.Errorf("duration = %q, want the run's alone", resp.Duration)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"maps"
+	"sync"
+
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// imageDigests tracks the digest each runtime image last ran as, for
+// GET /health and sandbox_image_info. It is what executions ran, not what
+// the image store holds now: a rebuilt image shows once something runs it.
+type imageDigests struct {
+	mu      sync.Mutex
+	digests map[string]string
+	metrics *monitor.Metrics
+}
+
+func newImageDigests(metrics *monitor.Metrics) *imageDigests {
+	return &imageDigests{digests: make(map[string]string), metrics: metrics}
+}
+
+// record notes the image result ran in. Results without a digest, from
+// executions turned away before a container started, change nothing.
+func (d *imageDigests) record(result *sandbox.ExecutionResult) {
+	if result == nil || result.Image == "" || result.ImageDigest == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev := d.digests[result.Image]
+	if prev == result.ImageDigest {
+		return
+	}
+	d.digests[result.Image] = result.ImageDigest
+	if d.metrics != nil {
+		d.metrics.SetImageDigest(result.Image, prev, result.ImageDigest)
+	}
+}
+
+// snapshot returns the digests by image, nil before anything has run.
+func (d *imageDigests) snapshot() map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.digests) == 0 {
+		return nil
+	}
+	return maps.Clone(d.digests)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+// interruptCleanupTimeout bounds recording and removing the executions still
+// running once the shutdown drain has timed out.
+const interruptCleanupTimeout = 10 * time.Second
+
+// auditOnInterrupt has the execution's audit record written, as interrupted,
+// if the server shuts down with it still running.
+func (h *Handlers) auditOnInterrupt(id string, r *http.Request, req ExecutionRequest, network string, cost float64) {
+	h.executions.onAbandon(id, func(result *sandbox.ExecutionResult, started time.Time) {
+		result.Network = network
+		h.writeAudit(result, req.Language, req.Code, req.TaskID, state.Interrupted, started, r, req.SharedMounts, cost)
+	})
+}
+
+// interruptExecutions gives up on every execution still running when the
+// server's drain has timed out. Each gets its audit record now, as
+// interrupted, with the output it had written and a server_shutdown event
+// giving reason, since its handler may never get to write one; then it is
+// killed and its container removed. Clients find these records with
+// GET /executions?status=interrupted once the server is back, and can
+// submit them again. It returns how many it interrupted.
+func (h *Handlers) interruptExecutions(ctx context.Context, reason string) int {
+	interrupted := h.executions.interrupt()
+	remover, _ := h.backend.(sandbox.ExecutionRemover)
+	for _, ex := range interrupted {
+		stdout, stderr := ex.partial.Output()
+		result := &sandbox.ExecutionResult{
+			ID:       ex.id,
+			Output:   stdout,
+			Stderr:   stderr,
+			ExitCode: -1,
+			Duration: time.Since(ex.started),
+			SecurityEvents: []sandbox.SecurityEvent{{
+				Type:     "server_shutdown",
+				Source:   sandbox.SourceRuntime,
+				Severity: "medium",
+				Detail:   reason,
+			}},
+		}
+		if ex.abandon != nil {
+			ex.abandon(result, ex.started)
+		}
+		if ex.cancel != nil {
+			ex.cancel()
+		}
+		if remover != nil {
+			if err := remover.RemoveExecution(ctx, ex.id); err != nil {
+				log.Warn().Err(err).Str("exec_id", ex.id).Msg("failed to remove interrupted execution's container")
+			}
+		}
+	}
+	return len(interrupted)
+}
+
+// interruptedExecution is a running execution the server gave up on.
+type interruptedExecution struct {
+	id string
+	*activeExecution
+}
+
+// onAbandon sets what interrupt's caller runs to record id if the server
+// gives up on it.
+func (e *executionRegistry) onAbandon(id string, abandon func(*sandbox.ExecutionResult, time.Time)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.active[id]; ok {
+		a.abandon = abandon
+	}
+}
+
+// interrupt moves every queued and running execution to interrupted and returns them.
+// Their handlers' later finish is a no-op, and interrupted tells them not
+// to write a record of their own.
+func (e *executionRegistry) interrupt() []interruptedExecution {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]interruptedExecution, 0, len(e.active))
+	for id, a := range e.active {
+		delete(e.active, id)
+		e.transition(id, a, state.Interrupted)
+		e.leaveGroup(id, a)
+		a.progress.Close()
+		snapshot := a.progress.Snapshot()
+		e.record(lifecycleEvent{ExecID: id, Event: "interrupted", Language: a.language, State: string(a.state), Elapsed: snapshot.Elapsed.Round(time.Millisecond).String()})
+		e.finished[id] = finishedExecution{owner: a.owner, group: a.group, progress: progressResponse(id, a.language, a.state, snapshot)}
+		e.order = append(e.order, id)
+		e.abandoned[id] = true
+		out = append(out, interruptedExecution{id: id, activeExecution: a})
+	}
+	if len(e.order) > maxFinishedExecutions {
+		for _, id := range e.order[:len(e.order)-maxFinishedExecutions] {
+			delete(e.finished, id)
+		}
+		e.order = e.order[len(e.order)-maxFinishedExecutions:]
+	}
+	return out
+}
+
+// interrupted reports whether the server gave up on id, whose record is
+// then already written. It forgets id, as its handler asks only once.
+func (e *executionRegistry) interrupted(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	abandoned := e.abandoned[id]
+	delete(e.abandoned, id)
+	return abandoned
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// hangingBackend writes a line of output, then runs until it is killed.
+type hangingBackend struct {
+	started chan string
+	mu      sync.Mutex
+	removed []string
+}
+
+func (b *hangingBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	output := sandbox.NewOutputBudget(sandbox.DefaultOutputLimits(), io.Discard, io.Discard)
+	req.Partial.Attach(output)
+	output.Stdout().Write([]byte("step 1\n"))
+	output.Stderr().Write([]byte("working\n"))
+	b.started <- req.ID
+	<-ctx.Done()
+	stdout, stderr := output.Output()
+	return &sandbox.ExecutionResult{ID: req.ID, Output: stdout, Stderr: stderr, ExitCode: -1, ExitClass: sandbox.ExitManualKill}, nil
+}
+
+func (b *hangingBackend) ExecuteStreaming(ctx context.Context, req sandbox.ExecutionRequest, _, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	return b.Execute(ctx, req)
+}
+
+func (b *hangingBackend) RemoveExecution(_ context.Context, execID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removed = append(b.removed, execID)
+	return nil
+}
+
+func (b *hangingBackend) Close() error { return nil }
+
+func (b *hangingBackend) Name() string { return "docker" }
+
+func TestInterruptExecutions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := storage.NewFileSink(storage.FileSinkOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &hangingBackend{started: make(chan string, 1)}
+	h := newTestHandlers(backend)
+	h.auditWriter = storage.NewAuditWriter(16)
+	h.auditWriter.AddSink("file", sink)
+	h.auditWriter.Start()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print('step 1')"})
+	}()
+	id := <-backend.started
+
+	if n := h.interruptExecutions(context.Background(), "drain timed out"); n != 1 {
+		t.Fatalf("interrupted %d executions, want 1", n)
+	}
+	<-done // the handler, killed, finishes after its record was written
+	h.auditWriter.Flush(5 * time.Second)
+	sink.Close()
+
+	if len(backend.removed) != 1 || backend.removed[0] != id {
+		t.Errorf("removed containers of %v, want %s", backend.removed, id)
+	}
+	if p, ok := h.executions.get(id, workspaceOwner(httptest.NewRequest(http.MethodGet, "/", nil))); !ok || p.State != "interrupted" {
+		t.Errorf("progress %+v, %v; want interrupted", p, ok)
+	}
+
+	// The record, with what the execution had written when it was killed.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("%d records, want only the interrupted one:\n%s", len(lines), data)
+	}
+	var rec storage.Execution
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ID != id || rec.Status != state.Interrupted || rec.Output != "step 1\n" || rec.Stderr != "working\n" || rec.SecurityEvents != 1 {
+		t.Errorf("record %+v", rec)
+	}
+
+	// A new server, over the same file, lists it for clients to resubmit.
+	restarted, err := storage.NewFileSink(storage.FileSinkOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	h2 := newTestHandlers(backend)
+	h2.listExecs = restarted.ListExecutions
+	resp := httptest.NewRecorder()
+	h2.HandleListExecutions(resp, httptest.NewRequest(http.MethodGet, "/executions?status=interrupted", nil))
+	var listed []storage.Execution
+	if err := json.Unmarshal(resp.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("status %d: %v", resp.Code, err)
+	}
+	if len(listed) != 1 || listed[0].ID != id {
+		t.Errorf("status=interrupted listed %+v, want %s", listed, id)
+	}
+	resp = httptest.NewRecorder()
+	h2.HandleListExecutions(resp, httptest.NewRequest(http.MethodGet, "/executions?status=completed", nil))
+	if body := resp.Body.String(); body != "[]\n" && body != "null\n" {
+		t.Errorf("status=completed listed %s", body)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// job is one of the config's jobs, as loaded at startup: its code read
+// from its code_file and its limits checked against the privileged
+// ceilings.
+type job struct {
+	name     string
+	language string
+	code     string
+	timeout  time.Duration
+	limits   *sandbox.PrivilegedLimits
+	network  bool
+	schedule time.Duration // 0 runs only on request
+}
+
+// loadJobs loads cfg's jobs. Any job that can't run is an error, so the
+// server doesn't start with it missing.
+func loadJobs(cfg *config.Config, runtimes *runtime.Registry) (map[string]*job, error) {
+	jobs := make(map[string]*job, len(cfg.Jobs))
+	for _, jc := range cfg.Jobs {
+		language, ok := knownLanguages.Canonical(jc.Language)
+		if !ok || language == "claude" {
+			return nil, fmt.Errorf("jobs[%s].language: %q can't run jobs", jc.Name, jc.Language)
+		}
+		code, err := os.ReadFile(filepath.Clean(jc.CodeFile)) // #nosec G304 -- the path is the operator's config
+		if err != nil {
+			return nil, fmt.Errorf("jobs[%s].code_file: %w", jc.Name, err)
+		}
+		if len(code) == 0 {
+			return nil, fmt.Errorf("jobs[%s].code_file: %s is empty", jc.Name, jc.CodeFile)
+		}
+		limits, err := sandboxLimits(sandbox.RuntimeLimits(runtimes, language), ResourceLimits{
+			CPUShares: jc.Limits.CPUShares,
+			MemoryMB:  jc.Limits.MemoryMB,
+			PidsLimit: jc.Limits.PidsLimit,
+			DiskMB:    jc.Limits.DiskMB,
+		}).ValidatePrivileged()
+		if err != nil {
+			return nil, fmt.Errorf("jobs[%s].limits: %w", jc.Name, err)
+		}
+		timeout := jc.Timeout
+		if timeout == 0 {
+			timeout = cfg.Sandbox.DefaultTimeout
+		}
+		jobs[jc.Name] = &job{
+			name:     jc.Name,
+			language: language,
+			code:     string(code),
+			timeout:  timeout,
+			limits:   limits,
+			network:  jc.NetworkEnabled,
+			schedule: jc.Schedule,
+		}
+	}
+	return jobs, nil
+}
+
+// jobRunner runs the config's jobs through the backend, one run of each
+// at a time, and records every run in the audit log like an execution.
+type jobRunner struct {
+	h    *Handlers
+	jobs map[string]*job
+
+	mu      sync.Mutex
+	running map[string]string // job name -> the execution ID of its run
+
+	ctx    context.Context // cancelled at shutdown, killing the runs
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobRunner(h *Handlers, jobs map[string]*job) *jobRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobRunner{h: h, jobs: jobs, running: make(map[string]string), ctx: ctx, cancel: cancel}
+}
+
+// get returns the job called name, or nil. A nil *jobRunner has no jobs.
+func (jr *jobRunner) get(name string) *job {
+	if jr == nil {
+		return nil
+	}
+	return jr.jobs[name]
+}
+
+// start runs j in the background for the caller of r, or for the schedule
+// when r is nil, and returns the run's execution ID. With a run of j
+// already going, it returns that run's ID and false.
+func (jr *jobRunner) start(j *job, r *http.Request) (string, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if id, ok := jr.running[j.name]; ok {
+		return id, false
+	}
+	if r == nil {
+		r = &http.Request{}
+	} else {
+		r = r.WithContext(context.WithoutCancel(r.Context()))
+	}
+	id := uuid.New().String()
+	jr.running[j.name] = id
+	jr.wg.Add(1)
+	go func() {
+		defer jr.wg.Done()
+		jr.run(j, id, r)
+		jr.mu.Lock()
+		delete(jr.running, j.name)
+		jr.mu.Unlock()
+	}()
+	return id, true
+}
+
+// run runs j as execution id and records it. Its metrics are the job
+// metrics, not the executions', so maintenance doesn't count against the
+// SLOs.
+func (jr *jobRunner) run(j *job, id string, r *http.Request) {
+	h := jr.h
+	start := time.Now()
+	result, err := h.backend.Execute(jr.ctx, sandbox.ExecutionRequest{
+		ID:             id,
+		Code:           j.code,
+		Language:       j.language,
+		Timeout:        j.timeout,
+		Privileged:     j.limits,
+		NetworkEnabled: j.network,
+		Output:         h.output,
+	})
+	st := sandbox.StateOf(result, err)
+	h.metrics.RecordJobRun(j.name, st, time.Since(start).Seconds())
+	if err != nil {
+		log.Warn().Err(err).Str("job", j.name).Str("exec_id", id).Msg("job run failed")
+	}
+	if result == nil {
+		result = &sandbox.ExecutionResult{ID: id, ExitCode: -1, Duration: time.Since(start)}
+	}
+	// Jobs are the operator's own and have the network their config gives
+	// them; security.network_policy governs callers' requests.
+	result.Network = sandbox.NetworkNotRequested
+	if j.network {
+		result.Network = sandbox.NetworkRequestedAndAllowed
+	}
+
+	h.images.record(result)
+	if h.auditWriter == nil && h.db != nil {
+		return
+	}
+	code := j.code
+	if j.language == binaryLanguage {
+		code = base64.StdEncoding.EncodeToString([]byte(code)) // as a request sends it
+	}
+	exec := h.auditRecord(result, j.language, code, "", st, start, r, nil, 0)
+	exec.Job = j.name
+	h.storeAudit(exec)
+}
+
+// schedule starts a run of j every j.schedule until shutdown. A tick that
+// finds the last run still going is skipped.
+func (jr *jobRunner) schedule(j *job) {
+	jr.wg.Add(1)
+	go func() {
+		defer jr.wg.Done()
+		ticker := time.NewTicker(j.schedule)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jr.ctx.Done():
+				return
+			case <-ticker.C:
+				if reason := jr.h.audit.refusal(); reason != "" {
+					jr.h.metrics.AuditRefused(reason)
+					log.Warn().Str("job", j.name).Str("reason", reason).Msg("scheduled job run skipped: the audit trail is unavailable (database.required)")
+					continue
+				}
+				if id, ok := jr.start(j, nil); !ok {
+					log.Warn().Str("job", j.name).Str("exec_id", id).Msg("scheduled job run skipped: the last run is still going")
+				}
+			}
+		}
+	}()
+}
+
+// close kills the runs in progress and waits, until ctx is done, for them
+// to be recorded.
+func (jr *jobRunner) close(ctx context.Context) {
+	jr.cancel()
+	done := make(chan struct{})
+	go func() {
+		jr.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn().Msg("job runs not recorded before shutdown deadline")
+	}
+}
+
+// StartJobs loads the config's jobs and starts the scheduled ones. It is an
+// error for any job not to load: its code file unreadable, its language
+// unknown or its limits past sandbox.PrivilegedMaxLimits.
+func (s *Server) StartJobs() error {
+	jobs, err := loadJobs(s.cfg, s.handlers.runtimes)
+	if err != nil {
+		return err
+	}
+	runner := newJobRunner(s.handlers, jobs)
+	for _, j := range jobs {
+		if j.schedule > 0 {
+			runner.schedule(j)
+		}
+	}
+	s.handlers.jobs = runner
+	return nil
+}
+
+// HandleRunJob serves POST /admin/jobs/{name}/run, which starts a run of
+// one of the config's jobs and answers 202 with its execution ID. The code
+// run is always the job's own: nothing in the request changes it.
+func (h *Handlers) HandleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	j := h.jobs.get(name)
+	if j == nil {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeJobNotFound, "no job named %q", name))
+		return
+	}
+	if !h.checkAudit(w, r) {
+		return
+	}
+	id, ok := h.jobs.start(j, r)
+	if !ok {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeJobRunning, "job %s is already running", name).
+			WithDetails(map[string]any{"exec_id": id}))
+		return
+	}
+
+	log.Info().Str("job", name).Str("exec_id", id).
+		Str("request_id", RequestIDFromContext(r.Context())).Msg("job run started")
+	writeJSON(w, http.StatusAccepted, JobRunResponse{ID: id, Job: name})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+// gatedBackend holds each execution until release is closed, then returns
+// its result under the request's ID, as a runner does.
+type gatedBackend struct {
+	mockBackend
+	release chan struct{}
+}
+
+func (g *gatedBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	<-g.release
+	result, err := g.mockBackend.Execute(ctx, req)
+	withID := *result
+	withID.ID = req.ID
+	return &withID, err
+}
+
+func jobConfig(t *testing.T) *config.Config {
+	t.Helper()
+	codeFile := filepath.Join(t.TempDir(), "vacuum.py")
+	if err := os.WriteFile(codeFile, []byte("print('vacuumed')"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := canaryConfig()
+	cfg.Jobs = []config.JobConfig{{
+		Name:     "vacuum",
+		Language: "python",
+		CodeFile: codeFile,
+		Limits:   config.DefaultLimits{MemoryMB: 32768},
+	}}
+	return cfg
+}
+
+func TestStartJobs_LoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*config.JobConfig)
+		wantErr string
+	}{
+		{"missing code file", func(j *config.JobConfig) { j.CodeFile = "/nonexistent/vacuum.py" }, "jobs[vacuum].code_file"},
+		{"past privileged limits", func(j *config.JobConfig) { j.Limits.MemoryMB = sandbox.PrivilegedMaxLimits().MemoryMB + 1 }, "jobs[vacuum].limits"},
+		{"claude", func(j *config.JobConfig) { j.Language = "claude" }, "jobs[vacuum].language"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := jobConfig(t)
+			tt.change(&cfg.Jobs[0])
+			s := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics())
+			if err := s.StartJobs(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("StartJobs() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	backend := &gatedBackend{
+		mockBackend: mockBackend{result: &sandbox.ExecutionResult{Output: "vacuumed\n", ExitClass: sandbox.ExitUser}},
+		release:     make(chan struct{}),
+	}
+	s := NewServer(jobConfig(t), backend, nil, nil, monitor.NewMetrics())
+	if err := s.StartJobs(); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	rec := doRequest(h, http.MethodPost, "/admin/jobs/vacuum/run", canaryUserKey, nil)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(apierror.CodeAdminRequired)) {
+		t.Errorf("user key: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = doRequest(h, http.MethodPost, "/admin/jobs/reindex/run", canaryAdminKey, nil)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), string(apierror.CodeJobNotFound)) {
+		t.Errorf("unknown job: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doRequest(h, http.MethodPost, "/admin/jobs/vacuum/run", canaryAdminKey, strings.NewReader(`{"code":"import os"}`))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("admin key: status %d: %s", rec.Code, rec.Body)
+	}
+	var run JobRunResponse
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Job != "vacuum" || run.ID == "" {
+		t.Errorf("response %+v", run)
+	}
+	rec = doRequest(h, http.MethodPost, "/admin/jobs/vacuum/run", canaryAdminKey, nil)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), run.ID) {
+		t.Errorf("second run while the first is going: status %d: %s", rec.Code, rec.Body)
+	}
+
+	close(backend.release)
+	s.handlers.jobs.wg.Wait()
+
+	// The job's own code ran, with its privileged limits.
+	req := backend.req
+	if req.Code != "print('vacuumed')" || req.ID != run.ID {
+		t.Errorf("backend ran %q as %s", req.Code, req.ID)
+	}
+	if req.Privileged == nil || req.Privileged.Limits().MemoryMB != 32768 {
+		t.Errorf("backend request Privileged = %+v", req.Privileged)
+	}
+
+	exec, err := s.handlers.retained.get(context.Background(), run.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Job != "vacuum" || exec.Status != state.Completed || exec.Language != "python" {
+		t.Errorf("audit record: job %q, status %s, language %s", exec.Job, exec.Status, exec.Language)
+	}
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+)
+
+// quotaRemainingHeader carries the executions the caller has left of its
+// daily quota after this one.
+const quotaRemainingHeader = "X-Sandbox-Quota-Remaining"
+
+// keyLimits gives each caller its security.key_limits entry, or
+// default_key_limit, and counts the executions it has running and has run
+// this UTC day against their max_concurrent and daily_quota. Callers are
+// identified as workspaceOwner does. Counts are kept in memory only.
+type keyLimits struct {
+	byKey    bool                             // security.rate_limit_by: key
+	limits   map[string]config.KeyLimitConfig // by owner hash, defaults filled in
+	fallback config.KeyLimitConfig
+
+	// Who AuthMiddleware will take a request to be, for the limits kept
+	// before it runs.
+	keys       map[string]bool
+	open       bool // no keys, and unauthenticated callers allowed
+	precedence string
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	running map[string]int
+	day     string // the UTC date today counts for
+	today   map[string]int
+}
+
+func newKeyLimits(cfg config.SecurityConfig) *keyLimits {
+	fallback := cfg.DefaultKeyLimit
+	if fallback.RPS == 0 {
+		fallback.RPS = cfg.RateLimitRPS
+	}
+	if fallback.Burst == 0 {
+		fallback.Burst = cfg.RateLimitBurst
+	}
+	k := &keyLimits{
+		byKey:      cfg.RateLimitBy == "key",
+		limits:     make(map[string]config.KeyLimitConfig, len(cfg.KeyLimits)),
+		fallback:   fallback,
+		keys:       make(map[string]bool, len(cfg.AllowedKeys)),
+		precedence: cfg.AuthPrecedence,
+		now:        time.Now,
+		running:    make(map[string]int),
+		today:      make(map[string]int),
+	}
+	for key, l := range cfg.KeyLimits {
+		if l.RPS == 0 {
+			l.RPS = fallback.RPS
+		}
+		if l.Burst == 0 {
+			l.Burst = fallback.Burst
+		}
+		if l.MaxConcurrent == 0 {
+			l.MaxConcurrent = fallback.MaxConcurrent
+		}
+		if l.DailyQuota == 0 {
+			l.DailyQuota = fallback.DailyQuota
+		}
+		k.limits[ownerHash(key)] = l
+	}
+	for _, key := range cfg.AllowedKeys {
+		if key != "" {
+			k.keys[key] = true
+		}
+	}
+	k.open = len(k.keys) == 0 && cfg.AllowUnauthenticated
+	return k
+}
+
+// limit is owner's limits.
+func (k *keyLimits) limit(owner string) config.KeyLimitConfig {
+	if l, ok := k.limits[owner]; ok {
+		return l
+	}
+	return k.fallback
+}
+
+// caller is the identity AuthMiddleware will accept r as: its client
+// certificate or API key, or "" for a request it lets through anonymously
+// or refuses.
+func (k *keyLimits) caller(r *http.Request) string {
+	key := requestAPIKey(r)
+	if cert := clientCertFromContext(r.Context()); cert != "" && (key == "" || k.precedence != PreferAPIKey) {
+		return cert
+	}
+	if k.keys[key] {
+		return key
+	}
+	return ""
+}
+
+// keyRefusal is an execution its caller's limits turned away.
+type keyRefusal struct {
+	scope   string
+	limit   int
+	resetAt time.Time // the next UTC midnight, for the daily quota
+}
+
+// admit counts an execution of owner's, which is to call release when it
+// has ended. It returns what is left of owner's daily quota after this
+// one, -1 without a quota, and the day it counts against, or the limit
+// that refused it.
+func (k *keyLimits) admit(owner string) (int, string, *keyRefusal) {
+	l := k.limit(owner)
+	now := k.now().UTC()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if day := now.Format(time.DateOnly); day != k.day {
+		k.day, k.today = day, make(map[string]int)
+	}
+
+	if l.MaxConcurrent > 0 && k.running[owner] >= l.MaxConcurrent {
+		return 0, "", &keyRefusal{scope: apierror.ScopeKeyConcurrency, limit: l.MaxConcurrent}
+	}
+	if l.DailyQuota > 0 && k.today[owner] >= l.DailyQuota {
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return 0, "", &keyRefusal{scope: apierror.ScopeKeyQuota, limit: l.DailyQuota, resetAt: midnight}
+	}
+	k.running[owner]++
+	k.today[owner]++
+	if l.DailyQuota == 0 {
+		return -1, k.day, nil
+	}
+	return l.DailyQuota - k.today[owner], k.day, nil
+}
+
+// release ends an execution admit counted.
+func (k *keyLimits) release(owner string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.running[owner]--; k.running[owner] <= 0 {
+		delete(k.running, owner)
+	}
+}
+
+// refund takes back an execution admit counted against owner's quota on
+// day, if that is still today.
+func (k *keyLimits) refund(owner, day string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.day == day && k.today[owner] > 0 {
+		k.today[owner]--
+	}
+}
+
+// admitKey counts the execution against its caller's max_concurrent and
+// daily_quota, and sets the quota remaining header. It writes the 429 and
+// returns false when either is reached; otherwise the execution's handler
+// calls release once it has ended, and refund as well when it is refused
+// or turned away without starting a container, as with refundExecution.
+func (h *Handlers) admitKey(w http.ResponseWriter, r *http.Request) (release, refund func(), ok bool) {
+	if h.keys == nil {
+		return func() {}, func() {}, true
+	}
+	owner := workspaceOwner(r)
+	remaining, day, refused := h.keys.admit(owner)
+	if refused == nil {
+		if remaining >= 0 {
+			w.Header().Set(quotaRemainingHeader, strconv.Itoa(remaining))
+		}
+		return func() { h.keys.release(owner) }, func() { h.keys.refund(owner, day) }, true
+	}
+
+	t := apierror.Throttle{Scope: refused.scope, Limit: float64(refused.limit)}
+	var e *apierror.Error
+	if refused.scope == apierror.ScopeKeyQuota {
+		w.Header().Set(quotaRemainingHeader, "0")
+		t.RetryAfter = refused.resetAt.Sub(h.keys.now())
+		e = apierror.New(apierror.CodeDailyQuotaExceeded, fmt.Sprintf("daily quota of %d executions used up", refused.limit)).
+			WithDetails(map[string]any{"reset_at": refused.resetAt.Format(time.RFC3339)})
+	} else {
+		// Nothing says when one of them will end; a second is a guess.
+		t.RetryAfter = time.Second
+		e = apierror.New(apierror.CodeKeyConcurrencyLimit, fmt.Sprintf("%d executions already running", refused.limit))
+	}
+	writeThrottled(w, r, h.metrics, e, t)
+	return nil, nil, false
+}
+
+// keyRateLimit is the rate limiter with security.rate_limit_by: key: a
+// bucket per caller, filled at the rps of its limits. Requests from no
+// caller, anonymous or about to be refused by AuthMiddleware, get a bucket
+// per IP at rate_limit_rps, or default_key_limit's rps when it sets one.
+func keyRateLimit(k *keyLimits, metrics *monitor.Metrics, window *rateWindow) func(http.Handler) http.Handler {
+	return limitRate(func(r *http.Request) (string, string, float64, int) {
+		if caller := k.caller(r); caller != "" {
+			owner := ownerHash(caller)
+			l := k.limit(owner)
+			return "key:" + owner, apierror.ScopeKeyRate, l.RPS, l.Burst
+		}
+		return clientIP(r), apierror.ScopeIP, k.fallback.RPS, k.fallback.Burst
+	}, metrics, window)
+}
+
+// countKeyRequests counts each request from a caller AuthMiddleware will
+// accept in sandbox_key_requests_total, those then refused included. It
+// runs before the rate limiter. metrics may be nil.
+func countKeyRequests(k *keyLimits, metrics *monitor.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if metrics == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if caller := k.caller(r); caller != "" {
+				metrics.KeyRequest(costIdentity(ownerHash(caller)))
+			} else if k.open {
+				metrics.KeyRequest(costIdentity(""))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+)
+
+func keyLimitsConfig(limits map[string]config.KeyLimitConfig, fallback config.KeyLimitConfig) config.SecurityConfig {
+	sec := config.DefaultConfig().Security
+	sec.AllowedKeys = []string{"key-a", "key-b"}
+	sec.KeyLimits, sec.DefaultKeyLimit = limits, fallback
+	return sec
+}
+
+func TestKeyLimits_DailyQuota(t *testing.T) {
+	now := time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)
+	h := newTestHandlers(&groupBackend{})
+	h.keys = newKeyLimits(keyLimitsConfig(map[string]config.KeyLimitConfig{"key-a": {DailyQuota: 2}}, config.KeyLimitConfig{}))
+	h.keys.now = func() time.Time { return now }
+	run := func(handler http.HandlerFunc, caller string) *httptest.ResponseRecorder {
+		return postAs(t, handler, caller, ExecutionRequest{Language: "python", Code: "print(1)"})
+	}
+
+	for _, want := range []string{"1", "0"} {
+		if rec := run(h.HandleExecute, "key-a"); rec.Code != http.StatusOK || rec.Header().Get(quotaRemainingHeader) != want {
+			t.Fatalf("status %d, %s %q; want %s", rec.Code, quotaRemainingHeader, rec.Header().Get(quotaRemainingHeader), want)
+		}
+	}
+	for name, handler := range map[string]http.HandlerFunc{"execute": h.HandleExecute, "stream": h.HandleExecuteStream} {
+		rec := run(handler, "key-a")
+		resp := decodeError(t, rec)
+		if rec.Code != http.StatusTooManyRequests || resp.Code != apierror.CodeDailyQuotaExceeded || rec.Header().Get("Retry-After") != "7200" {
+			t.Fatalf("%s past the quota: status %d, Retry-After %q, %+v", name, rec.Code, rec.Header().Get("Retry-After"), resp)
+		}
+		if d := resp.Details; d["scope"] != apierror.ScopeKeyQuota || d["limit"] != float64(2) || d["reset_at"] != "2026-03-02T00:00:00Z" {
+			t.Errorf("%s: details = %v", name, d)
+		}
+	}
+
+	// A key without a quota has no header.
+	if rec := run(h.HandleExecute, "key-b"); rec.Code != http.StatusOK || rec.Header().Get(quotaRemainingHeader) != "" {
+		t.Errorf("key-b: status %d, %s %q", rec.Code, quotaRemainingHeader, rec.Header().Get(quotaRemainingHeader))
+	}
+	now = now.Add(2 * time.Hour)
+	if rec := run(h.HandleExecute, "key-a"); rec.Code != http.StatusOK {
+		t.Errorf("the next day: status %d", rec.Code)
+	}
+}
+
+func TestKeyLimits_QuotaRefundedWhenRefused(t *testing.T) {
+	h := newTestHandlers(&groupBackend{})
+	h.keys = newKeyLimits(keyLimitsConfig(map[string]config.KeyLimitConfig{"key-a": {DailyQuota: 2}}, config.KeyLimitConfig{}))
+	h.tasks = newTaskLimiter(1)
+	var clock *testClock
+	h.costs, clock = newTestCostLimiter(t, config.CostBudgetConfig{Hourly: 1})
+	run := func(handler http.HandlerFunc, taskID string) *httptest.ResponseRecorder {
+		return postAs(t, handler, "key-a", ExecutionRequest{Language: "python", Code: "print(1)", TaskID: taskID})
+	}
+
+	if rec := run(h.HandleExecute, "t1"); rec.Code != http.StatusOK {
+		t.Fatalf("first: status %d", rec.Code)
+	}
+	// Refused by the task ceiling, then by the spent cost budget.
+	if rec := run(h.HandleExecute, "t1"); decodeError(t, rec).Code != apierror.CodeTaskLimitReached {
+		t.Fatalf("task ceiling: status %d", rec.Code)
+	}
+	for name, handler := range map[string]http.HandlerFunc{"execute": h.HandleExecute, "stream": h.HandleExecuteStream} {
+		if rec := run(handler, ""); decodeError(t, rec).Code != apierror.CodeCostBudgetExceeded {
+			t.Fatalf("%s over budget: status %d", name, rec.Code)
+		}
+	}
+
+	clock.advance(time.Hour)
+	if rec := run(h.HandleExecute, ""); rec.Code != http.StatusOK || rec.Header().Get(quotaRemainingHeader) != "0" {
+		t.Errorf("after the refusals: status %d, %s %q; want 200 and 0", rec.Code, quotaRemainingHeader, rec.Header().Get(quotaRemainingHeader))
+	}
+}
+
+func TestKeyLimits_MaxConcurrent(t *testing.T) {
+	backend := &groupBackend{started: make(chan string, 1)}
+	h := newTestHandlers(backend)
+	h.keys = newKeyLimits(keyLimitsConfig(nil, config.KeyLimitConfig{MaxConcurrent: 1}))
+
+	ctx, kill := context.WithCancel(context.WithValue(context.Background(), contextKeyCaller, "key-a"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"language":"python","code":"sleep"}`))
+		h.HandleExecute(httptest.NewRecorder(), req.WithContext(ctx))
+	}()
+	<-backend.started
+
+	rec := postAs(t, h.HandleExecute, "key-a", ExecutionRequest{Language: "python", Code: "print(1)"})
+	if resp := decodeError(t, rec); rec.Code != http.StatusTooManyRequests || resp.Code != apierror.CodeKeyConcurrencyLimit || resp.Details["scope"] != apierror.ScopeKeyConcurrency {
+		t.Fatalf("while one runs: status %d %+v", rec.Code, resp)
+	}
+	if rec := postAs(t, h.HandleExecute, "key-b", ExecutionRequest{Language: "python", Code: "print(1)"}); rec.Code != http.StatusOK {
+		t.Errorf("another key: status %d", rec.Code)
+	}
+	kill()
+	<-done
+	if rec := postAs(t, h.HandleExecute, "key-a", ExecutionRequest{Language: "python", Code: "print(1)"}); rec.Code != http.StatusOK {
+		t.Errorf("once it has ended: status %d", rec.Code)
+	}
+}
+
+func TestKeyRateLimit(t *testing.T) {
+	metrics := monitor.NewMetrics()
+	keys := newKeyLimits(keyLimitsConfig(map[string]config.KeyLimitConfig{"key-a": {RPS: 0.001, Burst: 1}}, config.KeyLimitConfig{RPS: 0.001, Burst: 2}))
+	handler := countKeyRequests(keys, metrics)(keyRateLimit(keys, metrics, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/executions", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("key-a"); rec.Code != http.StatusOK {
+		t.Fatalf("key-a: status %d", rec.Code)
+	}
+	rec := send("key-a")
+	if resp := decodeError(t, rec); rec.Code != http.StatusTooManyRequests || resp.Details["scope"] != apierror.ScopeKeyRate || resp.Details["limit"] != float64(1) {
+		t.Errorf("key-a past its burst: status %d %+v", rec.Code, resp)
+	}
+	// key-b has the default burst, and keys the server doesn't accept
+	// share their IP's bucket.
+	for i, key := range []string{"key-b", "key-b", "wrong", "guess"} {
+		if rec := send(key); rec.Code != http.StatusOK {
+			t.Errorf("request %d with %s: status %d", i+1, key, rec.Code)
+		}
+	}
+	rec = send("another")
+	if resp := decodeError(t, rec); rec.Code != http.StatusTooManyRequests || resp.Details["scope"] != apierror.ScopeIP {
+		t.Errorf("third unaccepted key from the IP: status %d %+v", rec.Code, resp)
+	}
+
+	if got := metricValue(t, metrics.KeyRequests.WithLabelValues(costIdentity(ownerHash("key-a")))); got != 2 {
+		t.Errorf("key_requests_total for key-a = %g, want 2", got)
+	}
+	if got := metricValue(t, metrics.KeyRequests.WithLabelValues("anonymous")); got != 0 {
+		t.Errorf("key_requests_total counted refused keys: %g", got)
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"net"
@@ -10,12 +11,12 @@ import (
 	"regexp"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 
+	"safe-agent-sandbox/internal/api/apierror"
 	"safe-agent-sandbox/internal/monitor"
 )
 
@@ -25,15 +26,19 @@ var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9\-]{1,64}$`)
 type contextKey string
 
 const (
-	contextKeyRequestID contextKey = "request_id"
-	contextKeyAPIKey    contextKey = "api_key"
+	contextKeyCaller     contextKey = "caller"      // authenticated identity: the API key or the client certificate's
+	contextKeyClientCert contextKey = "client_cert" // identity of a verified client certificate
+)
+
+// Which identity authenticates a request that has both a verified client
+// certificate and an API key (security.auth_precedence).
+const (
+	PreferClientCert = "client_cert"
+	PreferAPIKey     = "api_key"
 )
 
 func RequestIDFromContext(ctx context.Context) string {
-	if id, ok := ctx.Value(contextKeyRequestID).(string); ok {
-		return id
-	}
-	return ""
+	return apierror.RequestIDFromContext(ctx)
 }
 
 func RequestIDMiddleware(next http.Handler) http.Handler {
@@ -43,7 +48,7 @@ func RequestIDMiddleware(next http.Handler) http.Handler {
 			id = uuid.New().String()
 		}
 		w.Header().Set("X-Request-ID", id)
-		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		ctx := apierror.ContextWithRequestID(r.Context(), id)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -87,10 +92,60 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
-// AuthMiddleware validates API keys from X-API-Key header or Bearer token.
-// Keys are compared in O(1) via a map. Empty keySet + allowUnauthenticated=true
-// lets all requests through (development mode).
-func AuthMiddleware(allowedKeys []string, allowUnauthenticated bool) func(http.Handler) http.Handler {
+// Flush and Unwrap keep streaming and http.ResponseController working through
+// the recorder.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// ClientCertMiddleware puts the identity of a verified client certificate in
+// the request context, ahead of rate limiting and auth. Certificates the TLS
+// layer did not verify are ignored.
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := clientCertIdentity(r.TLS); id != "" {
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyClientCert, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCertIdentity names the verified leaf certificate by its SPIFFE ID,
+// else its first URI SAN, else its subject CN.
+func clientCertIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := state.VerifiedChains[0][0]
+	for _, u := range leaf.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	if len(leaf.URIs) > 0 {
+		return leaf.URIs[0].String()
+	}
+	return leaf.Subject.CommonName
+}
+
+func clientCertFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyClientCert).(string)
+	return id
+}
+
+// AuthMiddleware validates API keys from X-API-Key header or Bearer token,
+// and accepts a verified client certificate in their place. Keys are
+// compared in O(1) via a map. Empty keySet + allowUnauthenticated=true lets
+// all requests through (development mode). precedence (PreferClientCert or
+// PreferAPIKey) picks the identity when a request has both; with
+// PreferClientCert the key isn't checked.
+func AuthMiddleware(allowedKeys []string, allowUnauthenticated bool, precedence string) func(http.Handler) http.Handler {
 	keySet := make(map[string]struct{}, len(allowedKeys))
 	for _, k := range allowedKeys {
 		if k == "" {
@@ -101,49 +156,99 @@ func AuthMiddleware(allowedKeys []string, allowUnauthenticated bool) func(http.H
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestAPIKey(r)
+
+			if cert := clientCertFromContext(r.Context()); cert != "" && (key == "" || precedence != PreferAPIKey) {
+				ctx := context.WithValue(r.Context(), contextKeyCaller, cert)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			if len(keySet) == 0 {
 				if allowUnauthenticated {
 					next.ServeHTTP(w, r)
 					return
 				}
-				http.Error(w, `{"error":"unauthorized","code":"AUTH_REQUIRED"}`, http.StatusUnauthorized)
+				apierror.WriteError(w, r, apierror.New(apierror.CodeAuthRequired, "unauthorized"))
 				return
 			}
 
-			key := r.Header.Get("X-API-Key")
-			if key == "" {
-				key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-			}
-
 			if key == "" {
-				http.Error(w, `{"error":"unauthorized","code":"AUTH_REQUIRED"}`, http.StatusUnauthorized)
+				apierror.WriteError(w, r, apierror.New(apierror.CodeAuthRequired, "unauthorized"))
 				return
 			}
 
 			if _, ok := keySet[key]; !ok {
-				http.Error(w, `{"error":"unauthorized","code":"AUTH_REQUIRED"}`, http.StatusUnauthorized)
+				apierror.WriteError(w, r, apierror.New(apierror.CodeAuthRequired, "unauthorized"))
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), contextKeyAPIKey, key)
+			ctx := context.WithValue(r.Context(), contextKeyCaller, key)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// requestAPIKey is the API key r presents, in X-API-Key or as a bearer
+// token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// AdminMiddleware admits only callers in adminKeys, API keys or client
+// certificate identities, to the /admin routes. It runs inside
+// AuthMiddleware, which establishes the caller; with no admin keys every
+// request is refused.
+func AdminMiddleware(adminKeys []string) func(http.Handler) http.Handler {
+	admins := make(map[string]bool, len(adminKeys))
+	for _, k := range adminKeys {
+		if k != "" {
+			admins[ownerHash(k)] = true
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !admins[workspaceOwner(r)] {
+				apierror.WriteError(w, r, apierror.New(apierror.CodeAdminRequired, "admin access required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 const maxRateLimitVisitors = 10000
 
-// RateLimitMiddleware implements a per-IP token bucket rate limiter.
+// RateLimitMiddleware implements a per-IP token bucket rate limiter. Requests
+// with a verified client certificate get a bucket per certificate identity
+// instead, so services behind one egress IP don't share a limit.
 // Stale entries are evicted every minute; the visitor map is capped at 10k entries
-// to prevent memory exhaustion from many unique IPs.
-func RateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler {
-	type visitor struct {
-		tokens    float64
-		lastCheck time.Time
-	}
+// to prevent memory exhaustion from many unique IPs. metrics may be nil.
+func RateLimitMiddleware(rps float64, burst int, metrics *monitor.Metrics) func(http.Handler) http.Handler {
+	return rateLimit(rps, burst, metrics, nil)
+}
+
+// rateLimit is RateLimitMiddleware, counting what it decides in window,
+// which may be nil.
+func rateLimit(rps float64, burst int, metrics *monitor.Metrics, window *rateWindow) func(http.Handler) http.Handler {
+	return limitRate(func(r *http.Request) (string, string, float64, int) {
+		if cert := clientCertFromContext(r.Context()); cert != "" {
+			return "cert:" + cert, apierror.ScopeIP, rps, burst
+		}
+		return clientIP(r), apierror.ScopeIP, rps, burst
+	}, metrics, window)
+}
 
+// limitRate is a token bucket rate limiter with a bucket for each name
+// bucketOf gives a request, filled at its rps up to its burst. A refusal
+// names scope as the limit hit.
+func limitRate(bucketOf func(*http.Request) (name, scope string, rps float64, burst int), metrics *monitor.Metrics, window *rateWindow) func(http.Handler) http.Handler {
 	var mu sync.Mutex
-	visitors := make(map[string]*visitor)
+	visitors := make(map[string]*rateBucket)
 
 	// Use a context so the cleanup goroutine can be stopped (e.g. in tests).
 	ctx, cancel := context.WithCancel(context.Background())
@@ -170,14 +275,10 @@ func RateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Strip port from RemoteAddr so each IP gets one bucket, not each TCP connection.
-			ip := r.RemoteAddr
-			if host, _, err := net.SplitHostPort(ip); err == nil {
-				ip = host
-			}
+			name, scope, rps, burst := bucketOf(r)
 
 			mu.Lock()
-			v, ok := visitors[ip]
+			v, ok := visitors[name]
 			if !ok {
 				// Cap the visitors map to prevent memory exhaustion from many unique IPs.
 				if len(visitors) >= maxRateLimitVisitors {
@@ -192,43 +293,58 @@ func RateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler
 					}
 					delete(visitors, oldestIP)
 				}
-				v = &visitor{tokens: float64(burst), lastCheck: time.Now()}
-				visitors[ip] = v
-			}
-
-			now := time.Now()
-			elapsed := now.Sub(v.lastCheck).Seconds()
-			v.lastCheck = now
-			v.tokens += elapsed * rps
-			if v.tokens > float64(burst) {
-				v.tokens = float64(burst)
+				v = &rateBucket{tokens: float64(burst), lastCheck: time.Now()}
+				visitors[name] = v
 			}
 
+			v.refill(time.Now(), rps, burst)
 			if v.tokens < 1 {
+				retryAfter := v.nextToken(rps)
 				mu.Unlock()
-				w.Header().Set("Retry-After", "1")
-				http.Error(w, `{"error":"rate limit exceeded","code":"RATE_LIMITED"}`, http.StatusTooManyRequests)
+				window.record(true)
+				writeRateLimited(w, r, metrics, apierror.Throttle{
+					Scope: scope, RetryAfter: retryAfter, Limit: float64(burst),
+				})
 				return
 			}
 
 			v.tokens--
 			mu.Unlock()
+			window.record(false)
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// clientIP is r's remote address without its port, so each IP gets one
+// bucket, not each TCP connection.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// writeRateLimited sends the 429 used by the per-IP and per-key limiters.
+func writeRateLimited(w http.ResponseWriter, r *http.Request, metrics *monitor.Metrics, t apierror.Throttle) {
+	writeThrottled(w, r, metrics, apierror.New(apierror.CodeRateLimited, "rate limit exceeded"), t)
+}
+
 // ConcurrentClaudeMiddleware tracks concurrent claude executions and rejects
 // new ones when the limit is reached. It inspects the JSON body for
-// "language":"claude" without consuming it.
-func ConcurrentClaudeMiddleware(maxConcurrent int) func(http.Handler) http.Handler {
-	var active atomic.Int64
+// "language":"claude" without consuming it. metrics may be nil.
+func ConcurrentClaudeMiddleware(maxConcurrent int, metrics *monitor.Metrics) func(http.Handler) http.Handler {
+	return concurrentClaude(newClaudeGate(maxConcurrent), metrics)
+}
 
+// concurrentClaude is ConcurrentClaudeMiddleware with a gate the caller
+// keeps, for GET /stats.
+func concurrentClaude(gate *claudeGate, metrics *monitor.Metrics) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Only applies to execution endpoints.
-			if r.URL.Path != "/execute" && r.URL.Path != "/execute/stream" {
+			if r.URL.Path != pathExecute && r.URL.Path != pathExecuteStream && r.URL.Path != pathExecuteAsync {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -237,7 +353,7 @@ func ConcurrentClaudeMiddleware(maxConcurrent int) func(http.Handler) http.Handl
 			body, err := io.ReadAll(r.Body)
 			r.Body.Close() // #nosec G104 -- http request body Close error is not actionable
 			if err != nil {
-				http.Error(w, `{"error":"failed to read body","code":"INVALID_REQUEST"}`, http.StatusBadRequest)
+				apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "failed to read body"))
 				return
 			}
 			// Restore the body for downstream handlers.
@@ -247,19 +363,17 @@ func ConcurrentClaudeMiddleware(maxConcurrent int) func(http.Handler) http.Handl
 			var partial struct {
 				Language string `json:"language"`
 			}
-			if json.Unmarshal(body, &partial) == nil && partial.Language == "claude" {
-				// CAS loop to avoid TOCTOU between Load and Add.
-				for {
-					cur := active.Load()
-					if cur >= int64(maxConcurrent) {
-						http.Error(w, `{"error":"too many concurrent claude sessions","code":"CLAUDE_LIMIT_REACHED"}`, http.StatusTooManyRequests)
-						return
-					}
-					if active.CompareAndSwap(cur, cur+1) {
-						break
-					}
+			if json.Unmarshal(body, &partial) == nil && canonicalLanguage(partial.Language) == "claude" {
+				leave, retryAfter, ok := gate.enter()
+				if !ok {
+					writeThrottled(w, r, metrics, apierror.New(apierror.CodeClaudeLimitReached, "too many concurrent claude sessions"),
+						apierror.Throttle{Scope: apierror.ScopeClaude, RetryAfter: retryAfter, Limit: float64(gate.max)})
+					return
 				}
-				defer active.Add(-1)
+				// An asynchronous execution takes the slot with it.
+				slot := &claudeSlot{leave: leave}
+				defer slot.release()
+				r = r.WithContext(context.WithValue(r.Context(), contextKeyClaudeSlot, slot))
 			}
 
 			next.ServeHTTP(w, r)
@@ -287,7 +401,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 					Str("path", r.URL.Path).
 					Str("request_id", RequestIDFromContext(r.Context())).
 					Msg("panic recovered")
-				http.Error(w, `{"error":"internal server error","code":"INTERNAL"}`, http.StatusInternalServerError)
+				apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "internal server error"))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -295,9 +409,15 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 }
 
 // MaxBodyMiddleware caps request body size to prevent memory exhaustion from large uploads.
+// Workspace file uploads and POST /execute/upload are streamed to disk and
+// capped by the workspace store and sandbox.max_upload_code_bytes instead.
 func MaxBodyMiddleware(maxBytes int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWorkspaceUpload(r) || isExecuteUpload(r) || isBundleUpload(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 			next.ServeHTTP(w, r)
 		})
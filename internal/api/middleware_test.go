@@ -3,13 +3,16 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
 )
 
 func TestAuthMiddleware_EmptyKeysRejectsRequests(t *testing.T) {
-	handler := AuthMiddleware(nil, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(nil, false, PreferClientCert)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -23,7 +26,7 @@ func TestAuthMiddleware_EmptyKeysRejectsRequests(t *testing.T) {
 }
 
 func TestAuthMiddleware_ExplicitAllowUnauthenticated(t *testing.T) {
-	handler := AuthMiddleware(nil, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(nil, true, PreferClientCert)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -37,7 +40,7 @@ func TestAuthMiddleware_ExplicitAllowUnauthenticated(t *testing.T) {
 }
 
 func TestAuthMiddleware_ValidKey(t *testing.T) {
-	handler := AuthMiddleware([]string{"good-key"}, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware([]string{"good-key"}, false, PreferClientCert)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -52,7 +55,7 @@ func TestAuthMiddleware_ValidKey(t *testing.T) {
 }
 
 func TestAuthMiddleware_InvalidKey(t *testing.T) {
-	handler := AuthMiddleware([]string{"good-key"}, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware([]string{"good-key"}, false, PreferClientCert)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -68,7 +71,7 @@ func TestAuthMiddleware_InvalidKey(t *testing.T) {
 
 func TestConcurrentClaudeMiddleware_RejectsOverLimit(t *testing.T) {
 	// Middleware with max 1 concurrent claude session.
-	mw := ConcurrentClaudeMiddleware(1)
+	mw := ConcurrentClaudeMiddleware(1, nil)
 
 	blocked := make(chan struct{})
 	unblock := make(chan struct{})
@@ -109,7 +112,7 @@ func TestConcurrentClaudeMiddleware_RejectsOverLimit(t *testing.T) {
 }
 
 func TestConcurrentClaudeMiddleware_AllowsPython(t *testing.T) {
-	mw := ConcurrentClaudeMiddleware(0) // 0 means all claude blocked
+	mw := ConcurrentClaudeMiddleware(0, nil) // 0 means all claude blocked
 
 	inner := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -124,3 +127,110 @@ func TestConcurrentClaudeMiddleware_AllowsPython(t *testing.T) {
 		t.Errorf("got status %d, want 200 (python should not be limited)", rec.Code)
 	}
 }
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+// TestMiddlewareErrors_UseCatalog checks that every middleware rejection is a
+// cataloged ErrorResponse carrying the request ID.
+func TestMiddlewareErrors_UseCatalog(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	claudeBody := func() io.Reader {
+		b, _ := json.Marshal(map[string]string{"language": "claude", "code": "hi"})
+		return bytes.NewReader(b)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		req     func() *http.Request
+		want    apierror.Code
+	}{
+		{
+			"auth no keys configured",
+			AuthMiddleware(nil, false, PreferClientCert)(ok),
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/execute", nil) },
+			apierror.CodeAuthRequired,
+		},
+		{
+			"auth missing key",
+			AuthMiddleware([]string{"good-key"}, false, PreferClientCert)(ok),
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/execute", nil) },
+			apierror.CodeAuthRequired,
+		},
+		{
+			"auth bad key",
+			AuthMiddleware([]string{"good-key"}, false, PreferClientCert)(ok),
+			func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/execute", nil)
+				req.Header.Set("X-API-Key", "bad-key")
+				return req
+			},
+			apierror.CodeAuthRequired,
+		},
+		{
+			"rate limited",
+			RateLimitMiddleware(0, 0, nil)(ok),
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/execute", nil) },
+			apierror.CodeRateLimited,
+		},
+		{
+			"claude limit",
+			ConcurrentClaudeMiddleware(0, nil)(ok),
+			func() *http.Request { return httptest.NewRequest(http.MethodPost, "/execute", claudeBody()) },
+			apierror.CodeClaudeLimitReached,
+		},
+		{
+			"claude body unreadable",
+			ConcurrentClaudeMiddleware(1, nil)(ok),
+			func() *http.Request { return httptest.NewRequest(http.MethodPost, "/execute", failingReader{}) },
+			apierror.CodeInvalidRequest,
+		},
+		{
+			"panic recovered",
+			RecoveryMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })),
+			func() *http.Request { return httptest.NewRequest(http.MethodGet, "/execute", nil) },
+			apierror.CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req()
+			req.Header.Set("X-Request-ID", "mw-test-1")
+			rec := httptest.NewRecorder()
+			RequestIDMiddleware(tt.handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want.Status() {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want.Status())
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("body is not an ErrorResponse: %v", err)
+			}
+			if resp.Code != tt.want || !resp.Code.Known() {
+				t.Errorf("code = %q, want %q", resp.Code, tt.want)
+			}
+			if resp.RequestID != "mw-test-1" {
+				t.Errorf("request_id = %q, want mw-test-1", resp.RequestID)
+			}
+		})
+	}
+}
+
+func TestConcurrentClaudeMiddleware_UnderBasePath(t *testing.T) {
+	// The limiter matches the routes it guards after the base path is
+	// taken off, whether or not the proxy in front already took it off.
+	h := BasePathMiddleware("/sandbox")(ConcurrentClaudeMiddleware(0, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	for _, path := range []string{"/sandbox/execute", "/sandbox/execute/stream", "/execute"} {
+		body, _ := json.Marshal(map[string]string{"language": "claude", "code": "hi"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: status %d, want 429", path, rec.Code)
+		}
+	}
+}
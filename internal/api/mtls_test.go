@@ -0,0 +1,385 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// testCA issues certificates for mTLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a leaf certificate and key as PEM. tmpl supplies the names.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) clientCert(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	t.Helper()
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certPEM, keyPEM := ca.issue(t, tmpl)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func spiffeCert(id string) *x509.Certificate {
+	u, _ := url.Parse(id)
+	return &x509.Certificate{Subject: pkix.Name{CommonName: "ignored-cn"}, URIs: []*url.URL{u}}
+}
+
+// mtlsConfig writes a server certificate and the client CA bundle to disk
+// and returns a config verifying client certificates in mode.
+func mtlsConfig(t *testing.T, serverCA, clientCA *testCA, mode string) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := serverCA.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "sandbox"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.TLS = config.TLSConfig{
+		Enabled:        true,
+		CertFile:       write("server.pem", certPEM),
+		KeyFile:        write("server-key.pem", keyPEM),
+		ClientCAFile:   write("client-ca.pem", clientCA.pem),
+		ClientAuthMode: mode,
+	}
+	cfg.Security.AllowedKeys = []string{"good-key"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// startMTLS serves s over TLS with the config Start would use.
+func startMTLS(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	tlsConfig, err := ServerTLSConfig(s.cfg.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// mtlsClient trusts serverCA and presents the first of certs, if any, even
+// when the server's CA list doesn't name its issuer.
+func mtlsClient(serverCA *testCA, certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	tlsConfig := &tls.Config{
+		RootCAs: roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if len(certs) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &certs[0], nil
+		},
+	}
+	return &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+func executeOver(client *http.Client, url, key string) (int, error) {
+	req, _ := http.NewRequest(http.MethodPost, url+"/execute", strings.NewReader(`{"language":"python","code":"print(1)"}`))
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMTLS_VerifiedClientCertIdentity(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server-ca"), newTestCA(t, "client-ca")
+	cfg := mtlsConfig(t, serverCA, clientCA, "require_and_verify")
+	s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, nil, nil, monitor.NewMetrics())
+	ts := startMTLS(t, s)
+
+	const id = "spiffe://example.org/ns/ci/sa/runner"
+	client := mtlsClient(serverCA, clientCA.clientCert(t, spiffeCert(id)))
+	status, err := executeOver(client, ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200 with only a client certificate", status)
+	}
+
+	recent := s.handlers.recent.snapshot()
+	if len(recent) != 1 {
+		t.Fatalf("recorded %d executions, want 1", len(recent))
+	}
+	if got, want := recent[0].APIKeyHash, sha256Hex(id); got != want {
+		t.Errorf("audit api_key_hash = %s, want sha256 of the SPIFFE ID %s", got, want)
+	}
+}
+
+func TestMTLS_WrongCARejectedAtTLS(t *testing.T) {
+	serverCA, clientCA, otherCA := newTestCA(t, "server-ca"), newTestCA(t, "client-ca"), newTestCA(t, "other-ca")
+	cfg := mtlsConfig(t, serverCA, clientCA, "require_and_verify")
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x"}}
+	ts := startMTLS(t, NewServer(cfg, backend, nil, nil, monitor.NewMetrics()))
+
+	for name, client := range map[string]*http.Client{
+		"wrong CA":    mtlsClient(serverCA, otherCA.clientCert(t, spiffeCert("spiffe://example.org/intruder"))),
+		"no cert":     mtlsClient(serverCA),
+		"key instead": mtlsClient(serverCA),
+	} {
+		key := ""
+		if name == "key instead" {
+			key = "good-key"
+		}
+		if status, err := executeOver(client, ts.URL, key); err == nil {
+			t.Errorf("%s: got HTTP %d, want a TLS handshake failure", name, status)
+		}
+	}
+	if backend.req.Language != "" {
+		t.Error("a rejected client reached the backend")
+	}
+}
+
+func TestMTLS_AuthPrecedence(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server-ca"), newTestCA(t, "client-ca")
+	cert := clientCA.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}})
+
+	tests := []struct {
+		precedence string
+		certs      []tls.Certificate
+		key        string
+		want       int
+		wantCaller string
+	}{
+		{PreferClientCert, []tls.Certificate{cert}, "bad-key", http.StatusOK, "billing-service"},
+		{PreferAPIKey, []tls.Certificate{cert}, "bad-key", http.StatusUnauthorized, ""},
+		{PreferAPIKey, []tls.Certificate{cert}, "good-key", http.StatusOK, "good-key"},
+		{PreferAPIKey, []tls.Certificate{cert}, "", http.StatusOK, "billing-service"},
+		// "request" mode: keys still work without a certificate.
+		{PreferClientCert, nil, "good-key", http.StatusOK, "good-key"},
+		{PreferClientCert, nil, "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/cert=%v/key=%q", tt.precedence, tt.certs != nil, tt.key)
+		t.Run(name, func(t *testing.T) {
+			cfg := mtlsConfig(t, serverCA, clientCA, "request")
+			cfg.Security.AuthPrecedence = tt.precedence
+			s := NewServer(cfg, &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}, nil, nil, monitor.NewMetrics())
+			ts := startMTLS(t, s)
+
+			status, err := executeOver(mtlsClient(serverCA, tt.certs...), ts.URL, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d", status, tt.want)
+			}
+			if tt.wantCaller == "" {
+				return
+			}
+			if recent := s.handlers.recent.snapshot(); len(recent) != 1 || recent[0].APIKeyHash != sha256Hex(tt.wantCaller) {
+				t.Errorf("audit records %+v, want caller %s", recent, tt.wantCaller)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_PerClientCert(t *testing.T) {
+	handler := RateLimitMiddleware(0, 1, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(cert string) int {
+		req := httptest.NewRequest(http.MethodGet, "/execute", nil)
+		if cert != "" {
+			req = req.WithContext(context.WithValue(req.Context(), contextKeyClientCert, cert))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// Same IP throughout: each identity gets its own burst.
+	if send("spiffe://example.org/a") != http.StatusOK || send("spiffe://example.org/b") != http.StatusOK {
+		t.Fatal("distinct certificate identities share a bucket")
+	}
+	if send("spiffe://example.org/a") != http.StatusTooManyRequests {
+		t.Error("second request from the same identity was not limited")
+	}
+	if send("") != http.StatusOK {
+		t.Error("requests without a certificate share a bucket with certificate identities")
+	}
+}
+
+func TestPlaintextHealthListener(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server-ca"), newTestCA(t, "client-ca")
+	cfg := mtlsConfig(t, serverCA, clientCA, "require_and_verify")
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = freePort(t)
+	cfg.Server.PlaintextHealthPort = freePort(t)
+	s := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics())
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Start() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", cfg.Server.PlaintextHealthPort)
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, err = http.Get(base + "/health"); err == nil {
+			break
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("Start: %v", err)
+		default:
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("plaintext /health = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Post(base+"/execute", "application/json", strings.NewReader(`{"language":"python","code":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("plaintext /execute = %d, want it not served", resp.StatusCode)
+	}
+
+	// The main port still demands a client certificate.
+	mainURL := fmt.Sprintf("https://127.0.0.1:%d", cfg.Server.Port)
+	if _, err := mtlsClient(serverCA).Get(mainURL + "/health"); err == nil {
+		t.Error("main listener accepted a client without a certificate")
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	leaf := func(cn string, uris ...string) *tls.ConnectionState {
+		c := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		for _, u := range uris {
+			parsed, _ := url.Parse(u)
+			c.URIs = append(c.URIs, parsed)
+		}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+	tests := []struct {
+		state *tls.ConnectionState
+		want  string
+	}{
+		{nil, ""},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "unverified"}}}}, ""},
+		{leaf("svc"), "svc"},
+		{leaf("svc", "https://example.org/svc", "spiffe://example.org/svc"), "spiffe://example.org/svc"},
+		{leaf("svc", "https://example.org/svc"), "https://example.org/svc"},
+	}
+	for _, tt := range tests {
+		if got := clientCertIdentity(tt.state); got != tt.want {
+			t.Errorf("clientCertIdentity = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// networkPolicy is security.network_policy: which languages may have the
+// network a request asks for, and what becomes of claude, which needs one,
+// when it may not.
+type networkPolicy struct {
+	mode         string          // allow, deny or allowlist_languages
+	languages    map[string]bool // allowlist_languages's
+	refuseClaude bool
+}
+
+func newNetworkPolicy(cfg config.NetworkPolicyConfig) networkPolicy {
+	p := networkPolicy{mode: cfg.Mode, refuseClaude: cfg.Claude == "refuse"}
+	if p.mode == "" {
+		p.mode = "allow"
+	}
+	if p.mode == "allowlist_languages" {
+		p.languages = make(map[string]bool, len(cfg.Languages))
+		for _, lang := range cfg.Languages {
+			p.languages[canonicalLanguage(lang)] = true
+		}
+	}
+	return p
+}
+
+// allows reports whether the policy lets language have a network.
+func (p networkPolicy) allows(language string) bool {
+	switch p.mode {
+	case "deny":
+		return false
+	case "allowlist_languages":
+		return p.languages[language]
+	}
+	return true
+}
+
+// decide resolves whether an execution of language gets a network when
+// its request asked for one or not, and which of the sandbox's network
+// dispositions says why. ok is false when the policy refuses claude.
+func (p networkPolicy) decide(language string, requested bool) (enabled bool, disposition string, ok bool) {
+	switch {
+	case language == "claude":
+		if p.refuseClaude && !p.allows(language) {
+			return false, "", false
+		}
+		return true, sandbox.NetworkForcedForClaude, true
+	case !requested:
+		return false, sandbox.NetworkNotRequested, true
+	case p.allows(language):
+		return true, sandbox.NetworkRequestedAndAllowed, true
+	}
+	return false, sandbox.NetworkDeniedByPolicy, true
+}
+
+// resolveNetwork applies the network policy to req, counting the decision.
+// It writes the error response and returns false when the policy refuses
+// the execution.
+func (h *Handlers) resolveNetwork(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (enabled bool, disposition string, ok bool) {
+	enabled, disposition, ok = h.network.decide(req.Language, req.Perms.Network.Enabled)
+	if !ok {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeNetworkDenied,
+			"security.network_policy denies claude the network it needs to run (mode %s, claude: refuse)", h.network.mode))
+		return false, "", false
+	}
+	h.metrics.RecordNetworkDecision(disposition)
+	return enabled, disposition, true
+}
+
+// networkDenied is the warning an execution that asked for a network and
+// ran without one carries: in its response's warnings, or as a warning
+// event before a stream's output.
+var networkDenied = Warning{
+	Code:    "network_denied",
+	Message: "permissions.network.enabled was set but security.network_policy denies this execution a network; it ran isolated",
+}
+
+// networkWarnings is a response's warnings for its execution's network
+// disposition.
+func networkWarnings(disposition string) []Warning {
+	if disposition != sandbox.NetworkDeniedByPolicy {
+		return nil
+	}
+	return []Warning{networkDenied}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+func TestNetworkPolicy_Decide(t *testing.T) {
+	allowlist := config.NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{"py", "claude"}}
+	tests := []struct {
+		name      string
+		policy    config.NetworkPolicyConfig
+		language  string
+		requested bool
+		want      string // disposition; "" when refused
+		enabled   bool
+	}{
+		{"allow, requested", config.NetworkPolicyConfig{}, "python", true, sandbox.NetworkRequestedAndAllowed, true},
+		{"allow, not requested", config.NetworkPolicyConfig{Mode: "allow"}, "python", false, sandbox.NetworkNotRequested, false},
+		{"allow, claude", config.NetworkPolicyConfig{}, "claude", false, sandbox.NetworkForcedForClaude, true},
+		{"deny, requested", config.NetworkPolicyConfig{Mode: "deny"}, "python", true, sandbox.NetworkDeniedByPolicy, false},
+		{"deny, not requested", config.NetworkPolicyConfig{Mode: "deny"}, "python", false, sandbox.NetworkNotRequested, false},
+		{"deny, claude forced", config.NetworkPolicyConfig{Mode: "deny", Claude: "force"}, "claude", true, sandbox.NetworkForcedForClaude, true},
+		{"deny, claude refused", config.NetworkPolicyConfig{Mode: "deny", Claude: "refuse"}, "claude", true, "", false},
+		{"allowlist, listed by alias", allowlist, "python", true, sandbox.NetworkRequestedAndAllowed, true},
+		{"allowlist, not listed", allowlist, "node", true, sandbox.NetworkDeniedByPolicy, false},
+		{"allowlist, not listed or requested", allowlist, "node", false, sandbox.NetworkNotRequested, false},
+		{"allowlist, claude listed under refuse", config.NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{"claude"}, Claude: "refuse"}, "claude", false, sandbox.NetworkForcedForClaude, true},
+		{"allowlist, claude not listed under refuse", config.NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{"python"}, Claude: "refuse"}, "claude", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, disposition, ok := newNetworkPolicy(tt.policy).decide(tt.language, tt.requested)
+			if ok != (tt.want != "") || disposition != tt.want || enabled != tt.enabled {
+				t.Errorf("decide = %t, %q, %t; want %t, %q, %t", enabled, disposition, ok, tt.enabled, tt.want, tt.want != "")
+			}
+		})
+	}
+}
+
+func TestHandleExecute_NetworkPolicy(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", Argv: []string{"python3"}}}
+	h := newTestHandlers(backend)
+	h.retainExecutions(1000)
+	h.network = newNetworkPolicy(config.NetworkPolicyConfig{Mode: "deny"})
+
+	req := ExecutionRequest{Language: "python", Code: "print(1)"}
+	req.Perms.Network.Enabled = true
+	rec := postJSON(t, h.HandleExecute, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if backend.req.NetworkEnabled {
+		t.Error("backend was asked for a network the policy denies")
+	}
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Warnings, []Warning{networkDenied}) {
+		t.Errorf("warnings = %+v, want network_denied", resp.Warnings)
+	}
+	if resp.Environment == nil || resp.Environment.Network != sandbox.NetworkDeniedByPolicy {
+		t.Errorf("environment = %+v, want network %s", resp.Environment, sandbox.NetworkDeniedByPolicy)
+	}
+	if exec, err := h.retained.get(context.Background(), resp.ID, false); err != nil || exec.Network != sandbox.NetworkDeniedByPolicy {
+		t.Errorf("audit record = %+v, %v; want network %s", exec, err, sandbox.NetworkDeniedByPolicy)
+	}
+	if got := metricValue(t, h.metrics.NetworkDecisions.WithLabelValues(sandbox.NetworkDeniedByPolicy)); got != 1 {
+		t.Errorf("denied decisions = %v, want 1", got)
+	}
+
+	// Not asking for a network isn't warned about.
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)"})
+	resp = ExecutionResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Warnings != nil || resp.Environment.Network != sandbox.NetworkNotRequested {
+		t.Errorf("not requested: warnings %+v, network %q", resp.Warnings, resp.Environment.Network)
+	}
+}
+
+func TestHandleExecute_NetworkPolicyClaude(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", Argv: []string{"claude"}}}
+	h := newTestHandlers(backend)
+
+	// force, the default: claude keeps its network under deny.
+	h.network = newNetworkPolicy(config.NetworkPolicyConfig{Mode: "deny"})
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "claude", Code: "hello"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if !backend.req.NetworkEnabled {
+		t.Error("claude was sent to the backend without a network")
+	}
+	if backend.result.Network != sandbox.NetworkForcedForClaude {
+		t.Errorf("network = %q, want %s", backend.result.Network, sandbox.NetworkForcedForClaude)
+	}
+
+	backend.req = sandbox.ExecutionRequest{}
+	h.network = newNetworkPolicy(config.NetworkPolicyConfig{Mode: "deny", Claude: "refuse"})
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "claude", Code: "hello"})
+	if rec.Code != http.StatusForbidden || !bytes.Contains(rec.Body.Bytes(), []byte(apierror.CodeNetworkDenied)) {
+		t.Errorf("refuse: status %d: %s", rec.Code, rec.Body)
+	}
+	if backend.req.Language != "" {
+		t.Error("a refused claude execution reached the backend")
+	}
+}
+
+func TestHandleExecuteStream_NetworkDeniedWarning(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "x", Argv: []string{"python3"}}})
+	h.network = newNetworkPolicy(config.NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{"bash"}})
+
+	req := ExecutionRequest{Language: "python", Code: "print(1)"}
+	req.Perms.Network.Enabled = true
+	b, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	h.HandleExecuteStream(rec, httptest.NewRequest(http.MethodPost, "/execute/stream", bytes.NewReader(b)))
+
+	events := readEvents(t, rec.Body.String())
+	if len(events) == 0 || events[0].Type != stream.EventWarning {
+		t.Fatalf("first event isn't a warning:\n%s", rec.Body)
+	}
+	var warning stream.Warning
+	if err := events[0].Decode(&warning); err != nil {
+		t.Fatal(err)
+	}
+	if warning != networkDenied {
+		t.Errorf("warning = %+v, want network_denied", warning)
+	}
+	if done := doneEvent(t, rec.Body.String()); done.Environment == nil || done.Environment.Network != sandbox.NetworkDeniedByPolicy {
+		t.Errorf("done environment = %+v", done.Environment)
+	}
+}
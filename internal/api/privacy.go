@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// privateCodeHashLen is how much of the code hash the audit log keeps for a
+// private caller: the 16 characters the runners log. A full SHA-256 of a
+// short program can be matched against guesses.
+const privateCodeHashLen = 16
+
+// dataPolicy is what the server keeps, and shows back, of one caller's
+// executions.
+type dataPolicy struct {
+	Private     bool // security.privacy_mode covers the caller
+	StoreOutput bool // output and stderr go to the audit log
+	StoreCode   bool // the code goes to the audit log
+	ShowCode    bool // GET /executions/{id}?include=code answers for the caller's own executions
+	CodeHashLen int  // hex characters of the code hash the audit log keeps
+}
+
+// privacyPolicy resolves each caller's dataPolicy from database.store_code
+// and security.privacy_mode. Privacy mode always wins: a private caller's
+// code and output are never stored and include=code is refused, whatever
+// store_code says. A nil *privacyPolicy stores output but no code.
+type privacyPolicy struct {
+	storeCode  bool
+	allPrivate bool
+	private    map[string]bool // ownerHash of each key in privacy_mode.keys
+}
+
+// newPrivacyPolicy returns the policy for cfg. storeCode is
+// database.store_code, and only means something with a database to store
+// code in.
+func newPrivacyPolicy(storeCode bool, cfg config.PrivacyModeConfig) *privacyPolicy {
+	p := &privacyPolicy{
+		storeCode:  storeCode,
+		allPrivate: cfg.Enabled,
+		private:    make(map[string]bool, len(cfg.Keys)),
+	}
+	for _, key := range cfg.Keys {
+		p.private[ownerHash(key)] = true
+	}
+	return p
+}
+
+// resolve returns owner's policy. owner is workspaceOwner's hash; the
+// anonymous owner is private only when every caller is.
+func (p *privacyPolicy) resolve(owner string) dataPolicy {
+	if p != nil && (p.allPrivate || p.private[owner]) {
+		return dataPolicy{Private: true, CodeHashLen: privateCodeHashLen}
+	}
+	storeCode := p != nil && p.storeCode
+	return dataPolicy{StoreOutput: true, StoreCode: storeCode, ShowCode: storeCode, CodeHashLen: sha256.Size * 2}
+}
+
+// apply strips from exec, before it is logged, whatever the policy doesn't
+// keep. code is the execution's code.
+func (d dataPolicy) apply(exec *storage.Execution, code string) {
+	if len(exec.CodeHash) > d.CodeHashLen {
+		exec.CodeHash = exec.CodeHash[:d.CodeHashLen]
+	}
+	if !d.StoreOutput {
+		exec.Output, exec.Stderr = "", ""
+	}
+	if d.StoreCode {
+		exec.Code = code
+	}
+}
+
+// parseInclude reads GET /executions/{id}'s include parameter, a comma
+// separated list of which code is the only entry.
+func parseInclude(r *http.Request) (withCode bool, err *apierror.Error) {
+	raw := r.URL.Query().Get("include")
+	if raw == "" {
+		return false, nil
+	}
+	for _, field := range strings.Split(raw, ",") {
+		if field != "code" {
+			return false, apierror.Newf(apierror.CodeInvalidRequest, "include: unknown field %q (supported: code)", field)
+		}
+	}
+	return true, nil
+}
+
+// executionCode checks that the caller may see exec's code: the execution
+// is theirs, their policy shows code, and code was stored for it.
+func executionCode(policy dataPolicy, owner string, exec *storage.Execution) *apierror.Error {
+	switch {
+	case policy.Private:
+		return apierror.New(apierror.CodeCodeUnavailable, "code is never shown to callers in privacy mode")
+	case !policy.ShowCode:
+		return apierror.New(apierror.CodeCodeUnavailable, "this server does not store code (database.store_code)")
+	case exec.APIKeyHash != owner:
+		return apierror.New(apierror.CodeCodeUnavailable, "only the caller that ran an execution can see its code")
+	case exec.Code == "":
+		return apierror.New(apierror.CodeCodeUnavailable, "no code was stored for this execution")
+	}
+	return nil
+}
+
+// executionLookup reads a stored execution, with its code when withCode.
+// *storage.DB's GetExecution implements it.
+type executionLookup func(ctx context.Context, id string, withCode bool) (*storage.Execution, error)
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+)
+
+const fullCodeHash = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPrivacyPolicy_Resolve(t *testing.T) {
+	public := dataPolicy{StoreOutput: true, CodeHashLen: 64}
+	stored := dataPolicy{StoreOutput: true, StoreCode: true, ShowCode: true, CodeHashLen: 64}
+	private := dataPolicy{Private: true, CodeHashLen: 16}
+
+	tests := []struct {
+		name      string
+		nilPolicy bool
+		storeCode bool
+		privacy   config.PrivacyModeConfig
+		caller    string
+		want      dataPolicy
+	}{
+		{"no policy", true, false, config.PrivacyModeConfig{}, "key-a", public},
+		{"defaults", false, false, config.PrivacyModeConfig{}, "key-a", public},
+		{"store code", false, true, config.PrivacyModeConfig{}, "key-a", stored},
+		{"store code, anonymous", false, true, config.PrivacyModeConfig{}, "", stored},
+		{"private key", false, false, config.PrivacyModeConfig{Keys: []string{"key-a"}}, "key-a", private},
+		{"private key wins over store code", false, true, config.PrivacyModeConfig{Keys: []string{"key-a"}}, "key-a", private},
+		{"another key is private", false, true, config.PrivacyModeConfig{Keys: []string{"key-b"}}, "key-a", stored},
+		{"another key is private, anonymous", false, true, config.PrivacyModeConfig{Keys: []string{"key-b"}}, "", stored},
+		{"everyone private", false, false, config.PrivacyModeConfig{Enabled: true}, "key-a", private},
+		{"everyone private wins over store code", false, true, config.PrivacyModeConfig{Enabled: true}, "key-a", private},
+		{"everyone private, anonymous", false, true, config.PrivacyModeConfig{Enabled: true}, "", private},
+		{"everyone private and listed", false, true, config.PrivacyModeConfig{Enabled: true, Keys: []string{"key-a"}}, "key-a", private},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p *privacyPolicy
+			if !tt.nilPolicy {
+				p = newPrivacyPolicy(tt.storeCode, tt.privacy)
+			}
+			owner := ""
+			if tt.caller != "" {
+				owner = ownerHash(tt.caller)
+			}
+			if got := p.resolve(owner); got != tt.want {
+				t.Errorf("resolve = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataPolicy_Apply(t *testing.T) {
+	tests := []struct {
+		name                    string
+		policy                  dataPolicy
+		wantHash, wantOut, code string
+	}{
+		{"public", newPrivacyPolicy(false, config.PrivacyModeConfig{}).resolve(""), fullCodeHash, "out", ""},
+		{"stored", newPrivacyPolicy(true, config.PrivacyModeConfig{}).resolve(""), fullCodeHash, "out", "print(1)"},
+		{"private", newPrivacyPolicy(true, config.PrivacyModeConfig{Enabled: true}).resolve(""), fullCodeHash[:16], "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &storage.Execution{CodeHash: fullCodeHash, Output: "out", Stderr: "err"}
+			tt.policy.apply(exec, "print(1)")
+			if exec.CodeHash != tt.wantHash || exec.Output != tt.wantOut || exec.Code != tt.code {
+				t.Errorf("exec = %+v", exec)
+			}
+			if (exec.Stderr == "") != (tt.wantOut == "") {
+				t.Errorf("stderr %q kept with output %q", exec.Stderr, exec.Output)
+			}
+		})
+	}
+}
+
+// TestAuditRecord_PrivacyMode checks what the audit log gets of a private
+// caller's execution, and of everyone else's, on the same server.
+func TestAuditRecord_PrivacyMode(t *testing.T) {
+	h := newTestHandlers(nil)
+	h.privacy = newPrivacyPolicy(true, config.PrivacyModeConfig{Keys: []string{"private-key"}})
+	result := &sandbox.ExecutionResult{ID: "exec-1", Output: "secret output", Stderr: "secret stderr", CodeHash: fullCodeHash}
+
+	record := func(caller string) *storage.Execution {
+		req := httptest.NewRequest(http.MethodPost, "/execute", nil)
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, caller))
+		return h.auditRecord(result, "python", "print('secret code')", "", state.Completed, time.Now(), req, nil, 1)
+	}
+
+	private := record("private-key")
+	if private.Code != "" || private.Output != "" || private.Stderr != "" || private.CodeHash != fullCodeHash[:16] {
+		t.Errorf("private execution recorded as %+v", private)
+	}
+	if private.APIKeyHash != ownerHash("private-key") || private.Cost != 1 {
+		t.Error("private execution lost its owner or cost, which cost budgets need")
+	}
+	public := record("public-key")
+	if public.Code != "print('secret code')" || public.Output != "secret output" || public.Stderr != "secret stderr" || public.CodeHash != fullCodeHash {
+		t.Errorf("public execution recorded as %+v", public)
+	}
+	if result.Output != "secret output" || result.CodeHash != fullCodeHash {
+		t.Error("the result, which the caller gets back, was stripped")
+	}
+}
+
+// fakeExecutions is a database of stored executions for GET /executions/{id}.
+type fakeExecutions struct {
+	execs    map[string]storage.Execution
+	withCode []bool // each lookup's withCode
+}
+
+func (f *fakeExecutions) get(_ context.Context, id string, withCode bool) (*storage.Execution, error) {
+	f.withCode = append(f.withCode, withCode)
+	exec, ok := f.execs[id]
+	if !ok {
+		return nil, errors.New("no rows in result set")
+	}
+	if !withCode {
+		exec.Code = ""
+	}
+	return &exec, nil
+}
+
+func TestHandleGetExecution_IncludeCode(t *testing.T) {
+	const (
+		ownID      = "11111111-1111-1111-1111-111111111111"
+		othersID   = "22222222-2222-2222-2222-222222222222"
+		noCodeID   = "33333333-3333-3333-3333-333333333333"
+		anonID     = "44444444-4444-4444-4444-444444444444"
+		missingID  = "55555555-5555-5555-5555-555555555555"
+		storedCode = "print('audited')"
+	)
+	now := time.Now()
+	db := &fakeExecutions{execs: map[string]storage.Execution{
+		ownID:    {ID: ownID, APIKeyHash: ownerHash("auditor-key"), Code: storedCode, CreatedAt: now},
+		othersID: {ID: othersID, APIKeyHash: ownerHash("other-key"), Code: storedCode, CreatedAt: now},
+		noCodeID: {ID: noCodeID, APIKeyHash: ownerHash("auditor-key"), CreatedAt: now},
+		anonID:   {ID: anonID, Code: storedCode, CreatedAt: now},
+	}}
+
+	tests := []struct {
+		name      string
+		storeCode bool
+		privacy   config.PrivacyModeConfig
+		caller    string
+		path      string
+		wantCode  apierror.Code // "" = 200
+		wantBody  string        // code in the response
+		wantRead  bool          // the code was read from the database
+	}{
+		{"without include", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + ownID, "", "", false},
+		{"own execution", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + ownID + "?include=code", "", storedCode, true},
+		{"another caller's", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + othersID + "?include=code", apierror.CodeCodeUnavailable, "", true},
+		{"another caller's, anonymous", true, config.PrivacyModeConfig{}, "", "/executions/" + othersID + "?include=code", apierror.CodeCodeUnavailable, "", true},
+		{"anonymous own", true, config.PrivacyModeConfig{}, "", "/executions/" + anonID + "?include=code", "", storedCode, true},
+		{"code not stored", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + noCodeID + "?include=code", apierror.CodeCodeUnavailable, "", true},
+		{"store code off", false, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + ownID + "?include=code", apierror.CodeCodeUnavailable, "", false},
+		{"private caller", true, config.PrivacyModeConfig{Keys: []string{"auditor-key"}}, "auditor-key", "/executions/" + ownID + "?include=code", apierror.CodeCodeUnavailable, "", false},
+		{"private caller without include", true, config.PrivacyModeConfig{Keys: []string{"auditor-key"}}, "auditor-key", "/executions/" + ownID, "", "", false},
+		{"everyone private", true, config.PrivacyModeConfig{Enabled: true}, "auditor-key", "/executions/" + ownID + "?include=code", apierror.CodeCodeUnavailable, "", false},
+		{"unknown include", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + ownID + "?include=code,output", apierror.CodeInvalidRequest, "", false},
+		{"missing execution", true, config.PrivacyModeConfig{}, "auditor-key", "/executions/" + missingID + "?include=code", apierror.CodeNotFound, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.withCode = nil
+			h := newTestHandlers(nil)
+			h.getExecution = db.get
+			h.privacy = newPrivacyPolicy(tt.storeCode, tt.privacy)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /executions/{id}", h.HandleGetExecution)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.caller != "" {
+				req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, tt.caller))
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if tt.wantCode != "" {
+				var resp apierror.Response
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != tt.wantCode.Status() || resp.Code != tt.wantCode {
+					t.Fatalf("status %d body %s, want %s", w.Code, w.Body, tt.wantCode)
+				}
+				if strings.Contains(w.Body.String(), storedCode) {
+					t.Errorf("error leaked the code: %s", w.Body)
+				}
+			} else {
+				var exec storage.Execution
+				if err := json.Unmarshal(w.Body.Bytes(), &exec); err != nil || w.Code != http.StatusOK {
+					t.Fatalf("status %d body %s", w.Code, w.Body)
+				}
+				if exec.Code != tt.wantBody {
+					t.Errorf("code = %q, want %q", exec.Code, tt.wantBody)
+				}
+			}
+			read := false
+			for _, withCode := range db.withCode {
+				read = read || withCode
+			}
+			if read != tt.wantRead {
+				t.Errorf("code read from the database = %v, want %v", read, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestHandleGetExecution_NoDatabase(t *testing.T) {
+	h := newTestHandlers(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /executions/{id}", h.HandleGetExecution)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/executions/11111111-1111-1111-1111-111111111111?include=code", nil))
+	if w.Code != apierror.CodeDBUnavailable.Status() {
+		t.Errorf("status %d, want %d", w.Code, apierror.CodeDBUnavailable.Status())
+	}
+}
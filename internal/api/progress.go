@@ -0,0 +1,353 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+const (
+	defaultProgressInterval = 5 * time.Second // between "progress" SSE events for claude streams
+	maxFinishedExecutions   = 256             // terminal states kept for late progress polls
+	maxLifecycleEvents      = 512             // starts and finishes kept for support bundles
+)
+
+// activeExecution is a running execution in the registry.
+type activeExecution struct {
+	owner    string
+	language string
+	state    state.State
+	progress *sandbox.ProgressTracker
+	partial  *sandbox.PartialOutput // its output so far, for an interrupted record
+	started  time.Time
+	cancel   context.CancelFunc                        // kills the execution; nil when it can't be
+	abandon  func(*sandbox.ExecutionResult, time.Time) // writes its audit record if the server gives up on it; nil writes none
+
+	group     string // its cancellation_group; "" for none
+	cancelled bool   // killed by a cancellation of its group
+	killed    bool   // killed by DELETE /executions/{id}
+}
+
+// executionRegistry tracks in-flight executions so their progress can be
+// polled without the database. A bounded set of recently finished executions
+// keeps their terminal state for clients that poll just after completion.
+type executionRegistry struct {
+	mu        sync.Mutex
+	active    map[string]*activeExecution
+	finished  map[string]finishedExecution
+	order     []string         // finished IDs, oldest first
+	abandoned map[string]bool  // IDs interrupted at shutdown, whose handlers write no record
+	events    []lifecycleEvent // oldest first
+	metrics   *monitor.Metrics // counts refused transitions; may be nil
+
+	groups   map[groupKey]map[string]bool // live execution IDs by cancellation group; a group goes once empty
+	maxGroup int                          // live executions a caller may have in one group; 0 = no limit
+}
+
+// lifecycleEvent is an execution starting or finishing, as written to
+// support bundles. It carries nothing about the caller, the code or the
+// output.
+type lifecycleEvent struct {
+	At        time.Time `json:"at"`
+	ExecID    string    `json:"exec_id"`
+	Event     string    `json:"event"` // started or finished
+	Language  string    `json:"language"`
+	State     string    `json:"state"`
+	ExitClass string    `json:"exit_class,omitempty"`
+	Elapsed   string    `json:"elapsed,omitempty"`
+}
+
+type finishedExecution struct {
+	owner    string
+	group    string
+	progress ExecutionProgress
+}
+
+func newExecutionRegistry(metrics *monitor.Metrics) *executionRegistry {
+	return &executionRegistry{
+		active:    make(map[string]*activeExecution),
+		finished:  make(map[string]finishedExecution),
+		abandoned: make(map[string]bool),
+		metrics:   metrics,
+		groups:    make(map[groupKey]map[string]bool),
+	}
+}
+
+var (
+	errExecutionRunning = errors.New("execution ID is already running")
+	errGroupFull        = errors.New("cancellation group is full")
+)
+
+// start registers an execution, queued until admit, in the caller's
+// cancellation group if it names one, and returns its progress tracker and
+// the holder for its output so far. It returns errExecutionRunning if id is
+// already running and errGroupFull if the group has maxGroup live
+// executions. cancel, when set, kills the execution.
+func (e *executionRegistry) start(id, owner, language, group string, timeout time.Duration, cancel context.CancelFunc) (*sandbox.ProgressTracker, *sandbox.PartialOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.active[id]; ok {
+		return nil, nil, errExecutionRunning
+	}
+	key := groupKey{owner, group}
+	if group != "" && e.maxGroup > 0 && len(e.groups[key]) >= e.maxGroup {
+		return nil, nil, errGroupFull
+	}
+	a := &activeExecution{
+		owner:    owner,
+		language: language,
+		state:    state.Queued,
+		progress: sandbox.NewProgressTracker(timeout),
+		partial:  &sandbox.PartialOutput{},
+		started:  time.Now(),
+		cancel:   cancel,
+		group:    group,
+	}
+	e.active[id] = a
+	if group != "" {
+		if e.groups[key] == nil {
+			e.groups[key] = make(map[string]bool)
+		}
+		e.groups[key][id] = true
+	}
+	e.record(lifecycleEvent{ExecID: id, Event: "started", Language: language, State: string(a.state)})
+	return a.progress, a.partial, nil
+}
+
+// admit moves id from queued to running, once the backend has given it a
+// slot.
+func (e *executionRegistry) admit(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.active[id]; ok && a.state == state.Queued {
+		e.transition(id, a, state.Running)
+	}
+}
+
+// finish stops tracking id and records st, the terminal state it ended in.
+// A state the execution can't move to from where it is is logged and
+// counted, and the execution is kept as finished in the state it was in.
+func (e *executionRegistry) finish(id string, st state.State, result *sandbox.ExecutionResult) {
+	e.mu.Lock()
+	a, ok := e.active[id]
+	delete(e.active, id)
+	if ok {
+		if a.state == state.Queued && !state.CanTransition(a.state, st) {
+			// A backend that never said it had a slot had one all along.
+			e.transition(id, a, state.Running)
+		}
+		e.transition(id, a, st)
+		e.leaveGroup(id, a)
+	}
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	a.progress.Close()
+	snapshot := a.progress.Snapshot()
+	progress := progressResponse(id, a.language, a.state, snapshot)
+	if result != nil {
+		progress.ExitClass = string(result.ExitClass)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record(lifecycleEvent{ExecID: id, Event: "finished", Language: a.language, State: string(a.state), ExitClass: progress.ExitClass, Elapsed: snapshot.Elapsed.Round(time.Millisecond).String()})
+	e.finished[id] = finishedExecution{owner: a.owner, group: a.group, progress: progress}
+	e.order = append(e.order, id)
+	if len(e.order) > maxFinishedExecutions {
+		delete(e.finished, e.order[0])
+		e.order = e.order[1:]
+	}
+}
+
+// get returns the progress of an execution owned by owner.
+func (e *executionRegistry) get(id, owner string) (ExecutionProgress, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.active[id]; ok && a.owner == owner {
+		return progressResponse(id, a.language, a.state, a.progress.Snapshot()), true
+	}
+	if f, ok := e.finished[id]; ok && f.owner == owner {
+		return f.progress, true
+	}
+	return ExecutionProgress{}, false
+}
+
+// transition moves a to the state to, unless the transition table forbids
+// it: then it logs and counts the attempt and leaves a where it was rather
+// than fail the request. Callers hold e.mu.
+func (e *executionRegistry) transition(id string, a *activeExecution, to state.State) {
+	if !state.CanTransition(a.state, to) {
+		log.Error().Str("exec_id", id).Str("from", string(a.state)).Str("to", string(to)).Msg("invalid execution state transition")
+		if e.metrics != nil {
+			e.metrics.RecordInvalidTransition(a.state, to)
+		}
+		return
+	}
+	a.state = to
+}
+
+// lifecycle returns the recorded lifecycle events, oldest first.
+func (e *executionRegistry) lifecycle() []lifecycleEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]lifecycleEvent(nil), e.events...)
+}
+
+// record appends ev, stamped now, dropping the oldest past
+// maxLifecycleEvents. Callers hold e.mu.
+func (e *executionRegistry) record(ev lifecycleEvent) {
+	ev.At = time.Now().UTC()
+	e.events = append(e.events, ev)
+	if len(e.events) > maxLifecycleEvents {
+		e.events = e.events[len(e.events)-maxLifecycleEvents:]
+	}
+}
+
+// killLanguage cancels the running executions of language and returns how
+// many it cancelled.
+func (e *executionRegistry) killLanguage(language string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, a := range e.active {
+		if a.language == language && a.cancel != nil {
+			a.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// kill cancels owner's live execution id and returns the state it was in:
+// queued or running. It reports false when owner has no such execution.
+// Its handler learns why it ended from killed.
+func (e *executionRegistry) kill(id, owner string) (state.State, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.active[id]
+	if !ok || a.owner != owner || a.cancel == nil {
+		return "", false
+	}
+	a.killed = true
+	a.cancel()
+	return a.state, true
+}
+
+// killed reports whether id was killed by DELETE /executions/{id}. The
+// handler asks before finish.
+func (e *executionRegistry) killed(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.active[id]
+	return ok && a.killed
+}
+
+// ran reports whether id, still tracked, got past the queue. One killed
+// while queued never ran: it is neither killed nor recorded.
+func (e *executionRegistry) ran(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.active[id]
+	return ok && a.state != state.Queued
+}
+
+func (e *executionRegistry) running(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.active[id]
+	return ok
+}
+
+func progressResponse(id, language string, st state.State, p sandbox.Progress) ExecutionProgress {
+	return ExecutionProgress{
+		ID:          id,
+		Language:    language,
+		State:       string(st),
+		Turn:        p.Turn,
+		LastTool:    p.LastTool,
+		FilesEdited: p.FilesEdited,
+		Elapsed:     p.Elapsed.Round(time.Second).String(),
+		Timeout:     p.Timeout.String(),
+	}
+}
+
+// startExecution registers an execution before it runs, in its
+// cancellation group if it has one. A client that sends a UUID as
+// X-Request-ID gets it as the execution ID, so it can poll progress for a
+// request it is still waiting on. cancel kills the execution when its
+// language is disabled with security.disabled_in_flight: kill, when its
+// group is cancelled, or when the server gives up on it at shutdown. It
+// writes the 429 and returns false when the group is full.
+func (h *Handlers) startExecution(w http.ResponseWriter, r *http.Request, language, group string, timeout time.Duration, cancel context.CancelFunc) (string, *sandbox.ProgressTracker, *sandbox.PartialOutput, bool) {
+	id := RequestIDFromContext(r.Context())
+	if len(id) != 36 || !validUUID.MatchString(id) || h.executions.running(id) {
+		id = uuid.New().String()
+	}
+	p, partial, err := h.executions.start(id, workspaceOwner(r), language, group, timeout, cancel)
+	if errors.Is(err, errExecutionRunning) { // lost a race for the client's ID
+		id = uuid.New().String()
+		p, partial, err = h.executions.start(id, workspaceOwner(r), language, group, timeout, cancel)
+	}
+	if err != nil {
+		writeThrottled(w, r, h.metrics, apierror.Newf(apierror.CodeCancellationGroupFull,
+			"cancellation group %s has %d executions running", group, h.executions.maxGroup).
+			WithDetails(map[string]any{"cancellation_group": group}), apierror.Throttle{
+			Scope: apierror.ScopeCancellationGroup, Limit: float64(h.executions.maxGroup),
+		})
+		return "", nil, nil, false
+	}
+	asyncStarted(r, id)
+	return id, p, partial, true
+}
+
+// HandleExecutionProgress returns the progress of a running execution, or the
+// terminal state of one that finished recently.
+func (h *Handlers) HandleExecutionProgress(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validUUID.MatchString(id) {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "valid execution ID required"))
+		return
+	}
+
+	progress, ok := h.executions.get(id, workspaceOwner(r))
+	if !ok {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "execution not running or no longer tracked"))
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// streamProgress sends a "progress" event every interval until stop is
+// closed. The returned channel is closed once it has stopped writing.
+func (h *Handlers) streamProgress(sse *sseStream, id, language string, p *sandbox.ProgressTracker, stop <-chan struct{}) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(h.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				data, _ := json.Marshal(progressResponse(id, language, state.Running, p.Snapshot()))
+				sse.Event("progress", data)
+			}
+		}
+	}()
+	return stopped
+}
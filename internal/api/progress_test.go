@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+const editEvent = `{"type":"assistant","message":{"content":[{"type":"tool_use","name":"Edit","input":{"file_path":"/workspace/app.py"}}]}}` + "\n"
+
+// blockingBackend feeds a claude event to the progress tracker, then waits
+// for release before returning, so tests can poll a running execution.
+type blockingBackend struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingBackend() *blockingBackend {
+	return &blockingBackend{started: make(chan string, 1), release: make(chan struct{})}
+}
+
+func (b *blockingBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (b *blockingBackend) ExecuteStreaming(_ context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	req.Admitted()
+	if req.Progress != nil {
+		req.Progress.Write([]byte(editEvent))
+	}
+	b.started <- req.ID
+	<-b.release
+	io.WriteString(stdout, "done\n")
+	return &sandbox.ExecutionResult{ID: req.ID, Output: "done\n", ExitClass: sandbox.ExitUser}, nil
+}
+
+func (b *blockingBackend) Close() error { return nil }
+
+func (b *blockingBackend) Name() string { return "docker" }
+
+func progressMux(h *Handlers) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /execute", h.HandleExecute)
+	mux.HandleFunc("POST /execute/stream", h.HandleExecuteStream)
+	mux.HandleFunc("GET /executions/{id}/progress", h.HandleExecutionProgress)
+	return RequestIDMiddleware(mux)
+}
+
+func getProgress(t *testing.T, h http.Handler, id string) (int, ExecutionProgress) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/executions/"+id+"/progress", nil))
+	var p ExecutionProgress
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, p
+}
+
+func TestExecutionProgress_RunningThenFinished(t *testing.T) {
+	backend := newBlockingBackend()
+	h := newTestHandlers(backend)
+	mux := progressMux(h)
+	id := uuid.New().String()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		body := strings.NewReader(`{"language":"claude","code":"fix it","timeout":"5m"}`)
+		req := httptest.NewRequest(http.MethodPost, "/execute", body)
+		req.Header.Set("X-Request-ID", id)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		done <- rec
+	}()
+
+	if got := <-backend.started; got != id {
+		t.Fatalf("execution ID = %s, want the client's request ID %s", got, id)
+	}
+
+	// The parser runs asynchronously; wait for it to see the event.
+	var p ExecutionProgress
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var code int
+		if code, p = getProgress(t, mux, id); code != http.StatusOK {
+			t.Fatalf("progress while running: status %d", code)
+		}
+		if p.Turn == 1 {
+			break
+		}
+	}
+	if p.State != "running" || p.Turn != 1 || p.LastTool != "Edit" || p.FilesEdited != 1 || p.Timeout != "5m0s" {
+		t.Errorf("running progress = %+v", p)
+	}
+
+	close(backend.release)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Fatalf("execute: status %d", rec.Code)
+	}
+
+	code, p := getProgress(t, mux, id)
+	if code != http.StatusOK || p.State != "completed" || p.ExitClass != "user_exit" || p.Turn != 1 {
+		t.Errorf("finished progress: status %d, %+v", code, p)
+	}
+
+	if code, _ := getProgress(t, mux, uuid.New().String()); code != http.StatusNotFound {
+		t.Errorf("unknown execution: status %d, want 404", code)
+	}
+	if code, _ := getProgress(t, mux, "not-a-uuid"); code != http.StatusBadRequest {
+		t.Errorf("invalid ID: status %d, want 400", code)
+	}
+}
+
+func TestExecutionRegistry_OwnerAndRetention(t *testing.T) {
+	reg := newExecutionRegistry(nil)
+	if p, _, err := reg.start("a", "owner-1", "claude", "", time.Minute, nil); p == nil || err != nil {
+		t.Fatalf("start refused a new ID: %v", err)
+	}
+	if _, _, err := reg.start("a", "owner-1", "claude", "", time.Minute, nil); err != errExecutionRunning {
+		t.Errorf("start of an ID that is already running: %v", err)
+	}
+	if _, ok := reg.get("a", "owner-2"); ok {
+		t.Error("another owner saw a running execution")
+	}
+	reg.finish("a", state.Completed, nil)
+	if p, ok := reg.get("a", "owner-1"); !ok || p.State != "completed" {
+		t.Errorf("finished execution = %+v, %v", p, ok)
+	}
+	if _, ok := reg.get("a", "owner-2"); ok {
+		t.Error("another owner saw a finished execution")
+	}
+
+	for i := 0; i < maxFinishedExecutions; i++ {
+		id := uuid.New().String()
+		reg.start(id, "owner-1", "python", "", time.Second, nil)
+		reg.finish(id, state.Failed, nil)
+	}
+	if _, ok := reg.get("a", "owner-1"); ok {
+		t.Error("oldest finished execution was not evicted")
+	}
+	if len(reg.finished) != maxFinishedExecutions || len(reg.order) != maxFinishedExecutions {
+		t.Errorf("retained %d finished executions, max %d", len(reg.finished), maxFinishedExecutions)
+	}
+}
+
+func TestExecutionRegistry_Transitions(t *testing.T) {
+	metrics := monitor.NewMetrics()
+	reg := newExecutionRegistry(metrics)
+	reg.start("a", "owner", "python", "", time.Minute, nil)
+	if p, _ := reg.get("a", "owner"); p.State != "queued" {
+		t.Errorf("started execution is %q, want queued", p.State)
+	}
+	reg.admit("a")
+	if p, _ := reg.get("a", "owner"); p.State != "running" {
+		t.Errorf("admitted execution is %q, want running", p.State)
+	}
+
+	// An execution can't finish in a state it can't reach from running: the
+	// move is refused, logged and counted, and the request carries on.
+	reg.finish("a", state.Queued, nil)
+	if p, ok := reg.get("a", "owner"); !ok || p.State != "running" {
+		t.Errorf("after a refused transition: %+v, %v", p, ok)
+	}
+	if got := metricValue(t, metrics.BadTransitions.WithLabelValues("running", "queued")); got != 1 {
+		t.Errorf("invalid transitions = %v, want 1", got)
+	}
+
+	// A backend that never admits it is taken to have run it.
+	reg.start("b", "owner", "python", "", time.Minute, nil)
+	reg.finish("b", state.OOM, nil)
+	if p, _ := reg.get("b", "owner"); p.State != "oom" {
+		t.Errorf("finished execution is %q, want oom", p.State)
+	}
+	if got := metricValue(t, metrics.BadTransitions.WithLabelValues("running", "oom")); got != 0 {
+		t.Errorf("a valid transition was counted as invalid")
+	}
+	events := reg.lifecycle()
+	if last := events[len(events)-1]; last.ExecID != "b" || last.State != "oom" {
+		t.Errorf("last lifecycle event = %+v", last)
+	}
+}
+
+func TestHandleExecuteStream_ProgressEvents(t *testing.T) {
+	backend := newBlockingBackend()
+	h := newTestHandlers(backend)
+	h.progressInterval = 10 * time.Millisecond
+	mux := progressMux(h)
+
+	rec := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		body := strings.NewReader(`{"language":"claude","code":"fix it"}`)
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+		close(finished)
+	}()
+	<-backend.started
+	time.Sleep(100 * time.Millisecond)
+	close(backend.release)
+	<-finished
+
+	var progress, done int
+	for _, ev := range readEvents(t, rec.Body.String()) {
+		switch ev.Type {
+		case stream.EventProgress:
+			progress++
+			var p ExecutionProgress
+			if err := ev.Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.State != "running" || p.Language != "claude" {
+				t.Errorf("progress event = %+v", p)
+			}
+		case stream.EventDone:
+			done++
+			if progress == 0 {
+				t.Error("done event before any progress event")
+			}
+		}
+	}
+	if progress == 0 || done != 1 {
+		t.Errorf("got %d progress and %d done events:\n%s", progress, done, rec.Body.String())
+	}
+}
+
+func TestHandleExecuteStream_NoProgressForOtherLanguages(t *testing.T) {
+	backend := newBlockingBackend()
+	h := newTestHandlers(backend)
+	h.progressInterval = time.Millisecond
+	mux := progressMux(h)
+
+	rec := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		body := strings.NewReader(`{"language":"python","code":"print(1)"}`)
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+		close(finished)
+	}()
+	<-backend.started
+	time.Sleep(20 * time.Millisecond)
+	close(backend.release)
+	<-finished
+
+	if strings.Contains(rec.Body.String(), "event: progress") {
+		t.Errorf("python stream has progress events:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// maxCodeBytes is the runtimes' own limit on code, and so on a prompt.
+const maxCodeBytes = 1 << 20
+
+// promptScreen is the pre-flight for claude prompts (security.claude): a
+// size cap of its own, UTF-8, and the prompt detector in place of the code
+// one, with its own severity at which a request is refused.
+type promptScreen struct {
+	detector *monitor.PromptDetector
+	maxBytes int
+	blockAt  monitor.Severity
+	block    bool // false records detections without refusing any
+}
+
+func newPromptScreen(cfg config.ClaudeSecurityConfig) *promptScreen {
+	p := &promptScreen{
+		detector: monitor.NewPromptDetector(),
+		maxBytes: cfg.MaxPromptBytes,
+		blockAt:  monitor.SeverityCritical,
+		block:    cfg.PromptBlockSeverity != "none",
+	}
+	if p.maxBytes <= 0 {
+		p.maxBytes = maxCodeBytes
+	}
+	if sev, ok := monitor.ParseSeverity(cfg.PromptBlockSeverity); ok {
+		p.blockAt = sev
+	}
+	return p
+}
+
+// newEscapeDetector is the code detector with security.detector's bounds
+// and patterns, which config validation has checked compile.
+func newEscapeDetector(cfg config.DetectorConfig) *monitor.EscapeDetector {
+	opts := monitor.DetectorOptions{Budget: cfg.AnalysisBudget, MaxLineLength: cfg.MaxLineLength}
+	for _, p := range cfg.Patterns {
+		sev, _ := monitor.ParseSeverity(p.Severity)
+		opts.Patterns = append(opts.Patterns, monitor.DetectionPattern{
+			Name:        p.Name,
+			Description: p.Description,
+			Regex:       regexp.MustCompile(p.Regex),
+			Severity:    sev,
+			Anchors:     p.Anchors,
+		})
+	}
+	d, err := monitor.NewEscapeDetectorWithOptions(opts)
+	if err != nil {
+		panic(err) // checked by config validation
+	}
+	return d
+}
+
+// blocks reports whether d refuses the request.
+func (p *promptScreen) blocks(d monitor.Detection) bool {
+	sev, ok := monitor.ParseSeverity(d.Severity)
+	return p.block && ok && sev >= p.blockAt
+}
+
+// screen runs the static analysis for req before anything else looks at it:
+// the prompt screen for claude, the escape detector for the languages that
+// run code. It writes the error and returns false when the request is
+// refused; otherwise it returns the analysis and the security event source
+// to report its detections under.
+func (h *Handlers) screen(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (string, monitor.Analysis, bool) {
+	if req.Language == binaryLanguage {
+		// Machine code has none of the patterns the detector looks for;
+		// the response says analysis not_applicable instead.
+		return sandbox.SourceCode, monitor.Analysis{}, true
+	}
+	if req.Language != "claude" {
+		analysis := h.detector.Analyze(req.Code)
+		for _, d := range analysis.Detections {
+			h.metrics.RecordSecurityEvent(d.Pattern)
+			if d.Severity == monitor.SeverityCritical.String() {
+				apierror.WriteError(w, r, apierror.New(apierror.CodeSecurityBlocked, "request blocked by security policy"))
+				return "", monitor.Analysis{}, false
+			}
+		}
+		return sandbox.SourceCode, analysis, true
+	}
+
+	if len(req.Code) > h.prompts.maxBytes {
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeInvalidRequest,
+			"prompt is %d bytes, over the %d byte limit", len(req.Code), h.prompts.maxBytes).
+			WithDetails(map[string]any{"max_prompt_bytes": h.prompts.maxBytes}))
+		return "", monitor.Analysis{}, false
+	}
+	// encoding/json decodes invalid UTF-8 to U+FFFD, so that is what a
+	// prompt that wasn't UTF-8 arrives with.
+	if !utf8.ValidString(req.Code) || strings.ContainsRune(req.Code, utf8.RuneError) {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "prompt is not valid UTF-8"))
+		return "", monitor.Analysis{}, false
+	}
+	detections := h.prompts.detector.AnalyzePrompt(req.Code)
+	for _, d := range detections {
+		h.metrics.RecordSecurityEvent(d.Pattern)
+		if h.prompts.blocks(d) {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeSecurityBlocked, "prompt blocked by security policy"))
+			return "", monitor.Analysis{}, false
+		}
+	}
+	return sandbox.SourcePrompt, monitor.Analysis{Detections: detections}, true
+}
+
+// screenStdin runs the escape detector over stdin as screen does over
+// code: any runtime can run what it reads, bash -s or eval "$(cat)" as
+// much as python's exec(sys.stdin.read()). Claude takes none. It writes
+// the 403 and returns false for a critical detection.
+func (h *Handlers) screenStdin(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) ([]monitor.Detection, bool) {
+	if req.Language == "claude" || req.Stdin == "" {
+		return nil, true
+	}
+	detections := h.detector.AnalyzeCode(req.Stdin)
+	for _, d := range detections {
+		h.metrics.RecordSecurityEvent(d.Pattern)
+		if d.Severity == monitor.SeverityCritical.String() {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeSecurityBlocked, "request blocked by security policy"))
+			return nil, false
+		}
+	}
+	return detections, true
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/storage"
+)
+
+const (
+	// usageCacheTTL is how long a usage report is served before it is
+	// recomputed, so dashboards polling the endpoint don't hammer the DB.
+	usageCacheTTL = time.Minute
+	// usageCacheSize bounds the number of distinct queries cached.
+	usageCacheSize = 64
+	// usageRecentSize is how many executions are kept in memory for reports
+	// when there is no database.
+	usageRecentSize = 10000
+	// defaultUsageRange is the report range when from is omitted.
+	defaultUsageRange = 7 * 24 * time.Hour
+)
+
+// HandleSLO serves GET /slo: each objective's attainment, error budget and
+// burn rate over metrics.slo.window.
+func (h *Handlers) HandleSLO(w http.ResponseWriter, r *http.Request) {
+	if h.slo == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "no SLOs are configured (metrics.slo.objectives)"))
+		return
+	}
+	writeJSON(w, http.StatusOK, h.slo.Report())
+}
+
+// HandleUsageReport serves GET /reports/usage?from=&to=&group_by=. from and
+// to are RFC 3339 timestamps or UTC dates; a date-only to includes that day.
+func (h *Handlers) HandleUsageReport(w http.ResponseWriter, r *http.Request) {
+	q, err := parseUsageQuery(r, time.Now())
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	if report, ok := h.usageCache.get(q); ok {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	var report *storage.UsageReport
+	if h.db != nil {
+		report, err = h.db.UsageReport(r.Context(), q)
+		if err != nil {
+			log.Error().Err(err).Msg("usage report query failed")
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "query failed"))
+			return
+		}
+	} else {
+		report, _ = storage.AggregateUsage(h.recent.snapshot(), q)
+		report.Partial = true
+	}
+	h.usageCache.put(q, report)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// parseUsageQuery reads the report parameters. to defaults to the end of the
+// current minute, so repeated requests share a cache entry; from to a week
+// before to; group_by to day.
+func parseUsageQuery(r *http.Request, now time.Time) (storage.UsageQuery, error) {
+	params := r.URL.Query()
+	q := storage.UsageQuery{
+		To:      now.UTC().Truncate(time.Minute).Add(time.Minute),
+		GroupBy: params.Get("group_by"),
+	}
+	if q.GroupBy == "" {
+		q.GroupBy = storage.GroupByDay
+	}
+	if v := params.Get("to"); v != "" {
+		t, dateOnly, err := parseReportTime(v)
+		if err != nil {
+			return q, errInvalidParam("to", v)
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		q.To = t
+	}
+	q.From = q.To.Add(-defaultUsageRange)
+	if v := params.Get("from"); v != "" {
+		t, _, err := parseReportTime(v)
+		if err != nil {
+			return q, errInvalidParam("from", v)
+		}
+		q.From = t
+	}
+	return q, q.Validate()
+}
+
+// parseReportTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date, which
+// is midnight UTC.
+func parseReportTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t.UTC(), false, err
+}
+
+type invalidParamError struct{ name, value string }
+
+func (e *invalidParamError) Error() string {
+	return e.name + " must be an RFC 3339 timestamp or YYYY-MM-DD date, got " + e.value
+}
+
+func errInvalidParam(name, value string) error { return &invalidParamError{name, value} }
+
+// usageCache holds recent reports by query.
+type usageCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[storage.UsageQuery]usageCacheEntry
+}
+
+type usageCacheEntry struct {
+	report  *storage.UsageReport
+	expires time.Time
+}
+
+func newUsageCache(ttl time.Duration) *usageCache {
+	return &usageCache{ttl: ttl, entries: make(map[storage.UsageQuery]usageCacheEntry)}
+}
+
+func (c *usageCache) get(q storage.UsageQuery) (*storage.UsageReport, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[q]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.report, true
+}
+
+func (c *usageCache) put(q storage.UsageQuery, report *storage.UsageReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= usageCacheSize {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries: drop one arbitrarily.
+		for k := range c.entries {
+			if len(c.entries) < usageCacheSize {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[q] = usageCacheEntry{report: report, expires: now.Add(c.ttl)}
+}
+
+// recentExecutions keeps the last executions in memory, without output, for
+// usage reports, task summaries, security event timelines and estimates
+// when there is no database.
+type recentExecutions struct {
+	mu    sync.Mutex
+	size  int
+	next  int
+	execs []storage.Execution
+}
+
+func newRecentExecutions(size int) *recentExecutions {
+	return &recentExecutions{size: size}
+}
+
+func (r *recentExecutions) add(e *storage.Execution) {
+	trimmed := storage.Execution{
+		ID:             e.ID,
+		Language:       e.Language,
+		CodeHash:       e.CodeHash,
+		DurationMS:     e.DurationMS,
+		SecurityEvents: e.SecurityEvents,
+		Status:         e.Status,
+		APIKeyHash:     e.APIKeyHash,
+		Chaos:          e.Chaos,
+		Cost:           e.Cost,
+		TaskID:         e.TaskID,
+		ClaudeOptions:  e.ClaudeOptions,
+		CreatedAt:      e.CreatedAt,
+		CompletedAt:    e.CompletedAt,
+		Events:         slices.Clone(e.Events), // the audit writer fills in their IDs and times
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.execs) < r.size {
+		r.execs = append(r.execs, trimmed)
+		return
+	}
+	r.execs[r.next] = trimmed
+	r.next = (r.next + 1) % r.size
+}
+
+func (r *recentExecutions) snapshot() []storage.Execution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]storage.Execution(nil), r.execs...)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+func getUsage(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/reports/usage"+query, nil)
+	rec := httptest.NewRecorder()
+	h.HandleUsageReport(rec, req)
+	return rec
+}
+
+func TestHandleUsageReport_Validation(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"bad group_by", "?group_by=hour", "group_by"},
+		{"bad from", "?from=yesterday", "from must be"},
+		{"bad to", "?to=2026-13-01", "to must be"},
+		{"from after to", "?from=2026-03-02&to=2026-03-01", "before"},
+		{"empty range", "?from=2026-03-01T00:00:00Z&to=2026-03-01T00:00:00Z", "before"},
+		{"too long", "?from=2026-01-01&to=2026-06-01", "92 days"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getUsage(t, h, tt.query)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != "INVALID_REQUEST" || !strings.Contains(resp.Error, tt.want) {
+				t.Errorf("got %s %q, want INVALID_REQUEST mentioning %q", resp.Code, resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUsageQuery(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		query    string
+		from, to time.Time
+		groupBy  string
+	}{
+		{"", time.Date(2026, 3, 3, 15, 5, 0, 0, time.UTC), time.Date(2026, 3, 10, 15, 5, 0, 0, time.UTC), "day"},
+		// A date-only to includes the whole day.
+		{"?from=2026-03-01&to=2026-03-01&group_by=language",
+			time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), "language"},
+		// Offsets are normalized to UTC.
+		{"?from=2026-03-01T19:00:00-05:00&to=2026-03-03T00:00:00Z&group_by=api_key",
+			time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), "api_key"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/reports/usage"+tt.query, nil)
+		q, err := parseUsageQuery(r, now)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if !q.From.Equal(tt.from) || !q.To.Equal(tt.to) || q.GroupBy != tt.groupBy {
+			t.Errorf("%q: got [%s, %s) by %s, want [%s, %s) by %s", tt.query, q.From, q.To, q.GroupBy, tt.from, tt.to, tt.groupBy)
+		}
+	}
+}
+
+func TestHandleUsageReport_PartialWithoutDB(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "a", Duration: 200 * time.Millisecond}}
+	h := newTestHandlers(backend)
+	for _, lang := range []string{"python", "python", "bash"} {
+		rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: lang, Code: "true"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("execute status = %d", rec.Code)
+		}
+	}
+
+	rec := getUsage(t, h, "?group_by=language")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var report storage.UsageReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Partial {
+		t.Error("partial = false, want true without a database")
+	}
+	if report.Totals.Executions != 3 || report.Totals.Succeeded != 3 {
+		t.Errorf("totals = %+v, want 3 successful executions", report.Totals)
+	}
+	if len(report.Rows) != 2 || report.Rows[0].Key != "bash" || report.Rows[1].Key != "python" || report.Rows[1].Executions != 2 {
+		t.Errorf("rows = %+v, want bash=1, python=2", report.Rows)
+	}
+}
+
+func TestHandleUsageReport_Cached(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "a"}})
+	query := "?from=2026-03-01&to=2026-03-07"
+
+	first := getUsage(t, h, query)
+	h.recent.add(&storage.Execution{Language: "python", Status: "success", CreatedAt: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)})
+	second := getUsage(t, h, query)
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("second report differs within the cache TTL:\n%s\n%s", first.Body, second.Body)
+	}
+
+	h.usageCache = newUsageCache(usageCacheTTL)
+	var report storage.UsageReport
+	if err := json.NewDecoder(getUsage(t, h, query).Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Totals.Executions != 1 {
+		t.Errorf("executions after cache reset = %d, want 1", report.Totals.Executions)
+	}
+}
+
+func TestHandleUsageReport_GroupByAPIKey(t *testing.T) {
+	h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: "a"}})
+	for _, key := range []string{"key-a", "key-b", "key-a"} {
+		req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"language":"python","code":"1"}`))
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyCaller, key))
+		h.HandleExecute(httptest.NewRecorder(), req)
+	}
+
+	var report storage.UsageReport
+	if err := json.NewDecoder(getUsage(t, h, "?group_by=api_key").Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 2 {
+		t.Fatalf("rows = %+v, want one per key", report.Rows)
+	}
+	for _, row := range report.Rows {
+		if len(row.Key) != 64 || strings.Contains(row.Key, "key-") {
+			t.Errorf("key %q is not a hash of the API key", row.Key)
+		}
+	}
+}
+
+func TestHandleSLO(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowUnauthenticated = true
+	cfg.Metrics.SLO.Objectives = map[string]config.SLOObjective{
+		"python": {P95: 2 * time.Second, SuccessRate: 0.99},
+		"claude": {SuccessRate: 0.95},
+	}
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}
+	handler := NewServer(cfg, backend, nil, nil, monitor.NewMetrics()).Handler()
+
+	for range 3 {
+		rec := postJSON(t, handler.ServeHTTP, ExecutionRequest{Language: "python", Code: "print(1)"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("execute: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /slo = %d %s", rec.Code, rec.Body)
+	}
+	var report struct {
+		Window     string    `json:"window"`
+		Bucket     string    `json:"bucket"`
+		LastReset  time.Time `json:"last_reset"`
+		Objectives []struct {
+			Language             string   `json:"language"`
+			Objective            string   `json:"objective"`
+			Target               float64  `json:"target"`
+			Threshold            string   `json:"threshold"`
+			P95                  string   `json:"p95"`
+			Executions           int64    `json:"executions"`
+			Attainment           float64  `json:"attainment"`
+			Met                  bool     `json:"met"`
+			ErrorBudgetRemaining *float64 `json:"error_budget_remaining"`
+			BurnRate             *float64 `json:"burn_rate"`
+		} `json:"objectives"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Window != "1h0m0s" || report.Bucket != "1m0s" || report.LastReset.IsZero() {
+		t.Errorf("report = %+v", report)
+	}
+	var got []string
+	for _, o := range report.Objectives {
+		got = append(got, o.Language+" "+o.Objective)
+		if o.ErrorBudgetRemaining == nil || o.BurnRate == nil || !o.Met {
+			t.Errorf("%s %s = %+v", o.Language, o.Objective, o)
+		}
+		if o.Language == "python" && o.Executions != 3 {
+			t.Errorf("python %s counted %d executions, want 3", o.Objective, o.Executions)
+		}
+		if o.Objective == "latency" && (o.Threshold != "2s" || o.P95 == "" || o.Target != 0.95) {
+			t.Errorf("latency objective = %+v", o)
+		}
+	}
+	if want := []string{"claude success", "python latency", "python success"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("objectives = %v, want %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	newTestHandlers(backend).HandleSLO(rec, httptest.NewRequest(http.MethodGet, "/slo", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without objectives: %d, want 404", rec.Code)
+	}
+}
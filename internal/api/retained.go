@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/storage"
+)
+
+// retainedBytes caps the output and stderr the records kept in memory hold
+// between them; the oldest are dropped first to stay under it.
+const retainedBytes = 64 << 20
+
+// defaultRetainedExecutions is database.retained_executions' default, which
+// NewHandlers keeps until NewServer applies the config.
+const defaultRetainedExecutions = 1000
+
+// retainedExecutions keeps the last executions' audit records, with the
+// output the caller's privacy policy let them keep, so GET /executions and
+// GET /executions/{id} answer without a database, or when it can't. A
+// record holds what the database would: no security events or connections,
+// and its code only for include=code. What it serves is marked with source
+// memory.
+type retainedExecutions struct {
+	mu       sync.Mutex
+	maxCount int
+	maxBytes int
+	bytes    int
+	order    []string // IDs, oldest first
+	byID     map[string]*storage.Execution
+}
+
+func newRetainedExecutions(maxCount, maxBytes int) *retainedExecutions {
+	return &retainedExecutions{maxCount: maxCount, maxBytes: maxBytes, byID: make(map[string]*storage.Execution)}
+}
+
+// add keeps e, dropping the oldest records past the limits. A nil
+// *retainedExecutions keeps nothing.
+func (r *retainedExecutions) add(e *storage.Execution) {
+	if r == nil {
+		return
+	}
+	kept := *e
+	kept.Events, kept.Connections = nil, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.byID[kept.ID]; ok {
+		r.bytes -= retainedSizeOf(old)
+		for i, id := range r.order {
+			if id == kept.ID {
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				break
+			}
+		}
+	}
+	r.byID[kept.ID] = &kept
+	r.order = append(r.order, kept.ID)
+	r.bytes += retainedSizeOf(&kept)
+	for len(r.order) > 1 && (len(r.order) > r.maxCount || r.bytes > r.maxBytes) {
+		oldest := r.order[0]
+		r.order = r.order[1:]
+		r.bytes -= retainedSizeOf(r.byID[oldest])
+		delete(r.byID, oldest)
+	}
+}
+
+// get is an executionLookup over the retained records.
+func (r *retainedExecutions) get(_ context.Context, id string, withCode bool) (*storage.Execution, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("execution %s is not retained", id)
+	}
+	found := *e
+	if !withCode {
+		found.Code = ""
+	}
+	found.Source = "memory"
+	return &found, nil
+}
+
+// list is an executionLister over the retained records, newest first.
+func (r *retainedExecutions) list(_ context.Context, filter storage.ExecutionFilter) ([]storage.Execution, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	execs := []storage.Execution{}
+	skipped := 0
+	for i := len(r.order) - 1; i >= 0 && len(execs) < limit; i-- {
+		e := r.byID[r.order[i]]
+		if !filter.Matches(e) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		listed := storage.Listed(*e)
+		listed.Source = "memory"
+		execs = append(execs, listed)
+	}
+	return execs, nil
+}
+
+// retainExecutions keeps the last n executions' records in memory, none
+// for 0, and points GET /executions and GET /executions/{id} at them: on
+// their own without a database, and behind it with one.
+func (h *Handlers) retainExecutions(n int) {
+	h.retained = nil
+	if n > 0 {
+		h.retained = newRetainedExecutions(n, retainedBytes)
+	}
+	switch {
+	case h.db != nil && h.retained != nil:
+		h.getExecution = lookupFallback(h.db.GetExecution, h.retained.get)
+		h.listExecs = listFallback(h.db.ListExecutions, h.retained.list)
+	case h.db != nil:
+		h.getExecution, h.listExecs = h.db.GetExecution, h.db.ListExecutions
+	case h.retained != nil:
+		h.getExecution, h.listExecs = h.retained.get, h.retained.list
+		h.retrievable = true
+	default:
+		h.getExecution, h.listExecs = nil, nil
+		h.retrievable = false
+	}
+}
+
+// lookupFallback looks an execution up in the database, and in memory when
+// the database doesn't have it: one still in the audit writer's buffer, or
+// any while the database is down.
+func lookupFallback(db, memory executionLookup) executionLookup {
+	return func(ctx context.Context, id string, withCode bool) (*storage.Execution, error) {
+		exec, err := db(ctx, id, withCode)
+		if err == nil {
+			return exec, nil
+		}
+		if kept, memErr := memory(ctx, id, withCode); memErr == nil {
+			return kept, nil
+		}
+		return nil, err
+	}
+}
+
+// listFallback lists executions from the database, and from memory when
+// the query fails.
+func listFallback(db, memory executionLister) executionLister {
+	return func(ctx context.Context, filter storage.ExecutionFilter) ([]storage.Execution, error) {
+		execs, err := db(ctx, filter)
+		if err == nil {
+			return execs, nil
+		}
+		log.Warn().Err(err).Msg("listing executions from the database failed; serving the ones kept in memory")
+		return memory(ctx, filter)
+	}
+}
+
+func retainedSizeOf(e *storage.Execution) int {
+	return len(e.Output) + len(e.Stderr) + len(e.Code)
+}
+
+// retrieval is what a stream's terminal event says about fetching the
+// execution afterwards: whether GET /executions/{id} will return its
+// output once it is recorded, and the path to ask.
+func (h *Handlers) retrieval(r *http.Request, id string) (bool, string) {
+	if !h.retrievable || !h.privacy.resolve(workspaceOwner(r)).StoreOutput {
+		return false, ""
+	}
+	return true, h.urls.path(pathExecutions + "/" + id)
+}
+
+// retrievalURL is the absolute URL of a retrievable execution, which
+// version 2 terminal events carry.
+func (h *Handlers) retrievalURL(r *http.Request, retrievable bool, id string) string {
+	if !retrievable {
+		return ""
+	}
+	return h.urls.url(r, pathExecutions+"/"+id)
+}
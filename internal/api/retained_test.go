@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// budgetBackend streams its output through an OutputBudget, as the runners
+// do, past small caps. Code "timeout" ends as the runner's timeout does and
+// "fail" as a runner failure after output has started.
+type budgetBackend struct{ mockBackend }
+
+var budgetLimits = sandbox.OutputLimits{Stdout: 64, Stderr: 32, Total: 80}
+
+const truncatedMarker = "\n... [output truncated]"
+
+func (b *budgetBackend) ExecuteStreaming(_ context.Context, req sandbox.ExecutionRequest, stdout, stderr io.Writer) (*sandbox.ExecutionResult, error) {
+	req.Admitted()
+	output := sandbox.NewOutputBudget(budgetLimits, stdout, stderr)
+	req.Partial.Attach(output)
+	for i := range 6 {
+		fmt.Fprintf(output.Stdout(), "line %d: héllo\n", i)
+		if i%2 == 0 {
+			fmt.Fprintf(output.Stderr(), "warn %d\n", i)
+		}
+	}
+	if req.Code == "fail" {
+		return nil, &sandbox.ExecutionError{ExecID: req.ID, Op: "docker_run", Err: io.ErrUnexpectedEOF}
+	}
+	out, errOut := output.Output()
+	result := &sandbox.ExecutionResult{ID: req.ID, Output: out, Stderr: errOut, ExitClass: sandbox.ExitUser, Duration: time.Second}
+	if req.Code == "timeout" {
+		result.ExitCode, result.ExitClass = -1, sandbox.ExitTimeoutKill
+		return result, sandbox.ErrTimeout
+	}
+	return result, nil
+}
+
+// streamed splits an SSE body into its stdout, its stderr and its terminal
+// event.
+func streamed(t *testing.T, body string) (stdout, stderr string, last stream.Event) {
+	t.Helper()
+	var out, errOut strings.Builder
+	events := readEvents(t, body)
+	for _, e := range events {
+		switch e.Type {
+		case stream.EventStdout:
+			out.WriteString(e.Data)
+		case stream.EventStderr:
+			errOut.WriteString(e.Data)
+		}
+	}
+	return out.String(), errOut.String(), events[len(events)-1]
+}
+
+func getExecution(t *testing.T, h *Handlers, path string) *storage.Execution {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /executions/{id}", h.HandleGetExecution)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, rec.Code, rec.Body)
+	}
+	var exec storage.Execution
+	if err := json.NewDecoder(rec.Body).Decode(&exec); err != nil {
+		t.Fatal(err)
+	}
+	return &exec
+}
+
+func TestStreamedOutputRetrievable(t *testing.T) {
+	tests := []struct {
+		code      string
+		terminal  string
+		wantState state.State
+	}{
+		{"print(1)", stream.EventDone, state.Completed},
+		{"timeout", stream.EventDone, state.Timeout},
+		{"fail", stream.EventError, state.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			h := newTestHandlers(&budgetBackend{})
+			h.retainExecutions(1000)
+			rec := postAs(t, h.HandleExecuteStream, "alice", ExecutionRequest{Language: "python", Code: tt.code})
+			stdout, stderr, last := streamed(t, rec.Body.String())
+			if last.Type != tt.terminal {
+				t.Fatalf("stream ended with %+v", last)
+			}
+			if len(stdout) != budgetLimits.Stdout {
+				t.Fatalf("streamed stdout %q isn't cut at its cap", stdout)
+			}
+
+			var retrievable bool
+			var path string
+			if tt.terminal == stream.EventDone {
+				var done stream.Done
+				if err := last.Decode(&done); err != nil {
+					t.Fatal(err)
+				}
+				retrievable, path = done.Retrievable, done.RetrievalPath
+			} else {
+				var failed stream.Error
+				if err := last.Decode(&failed); err != nil {
+					t.Fatal(err)
+				}
+				retrievable, path = failed.Retrievable, failed.RetrievalPath
+			}
+			if !retrievable || !strings.HasPrefix(path, "/executions/") {
+				t.Fatalf("terminal event: retrievable %t at %q", retrievable, path)
+			}
+
+			// The record has the streamed bytes, marked where they were
+			// cut as a buffered execution's are.
+			exec := getExecution(t, h, path)
+			if exec.Output != stdout+truncatedMarker || exec.Stderr != stderr+truncatedMarker {
+				t.Errorf("stored output\n%q\n%q\ndiffers from the streamed\n%q\n%q", exec.Output, exec.Stderr, stdout, stderr)
+			}
+			if exec.Status != tt.wantState || "/executions/"+exec.ID != path {
+				t.Errorf("stored %s as %s, want %s", exec.ID, exec.Status, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestStreamedOutputRetrievable_PrivacyMode(t *testing.T) {
+	cfg := canaryConfig()
+	cfg.Security.PrivacyMode.Enabled = true
+	s := NewServer(cfg, &budgetBackend{}, nil, nil, monitor.NewMetrics())
+	rec := postAs(t, s.handlers.HandleExecuteStream, "alice", ExecutionRequest{Language: "python", Code: "print(1)"})
+	_, _, last := streamed(t, rec.Body.String())
+	var done stream.Done
+	if err := last.Decode(&done); err != nil {
+		t.Fatal(err)
+	}
+	if done.Retrievable || done.RetrievalPath != "" {
+		t.Errorf("private caller's output offered at %q", done.RetrievalPath)
+	}
+}
+
+func TestRetainedExecutions(t *testing.T) {
+	r := newRetainedExecutions(3, 10)
+	for i, out := range []string{"aaaa", "bbbb", "cc"} {
+		r.add(&storage.Execution{ID: fmt.Sprint(i), Output: out, Code: "x", Events: []storage.SecurityEventRecord{{Type: "t"}}})
+	}
+	// Past 10 bytes, the oldest goes.
+	if _, err := r.get(context.Background(), "0", false); err == nil {
+		t.Error("oldest record kept past the byte cap")
+	}
+	got, err := r.get(context.Background(), "1", false)
+	if err != nil || got.Output != "bbbb" || got.Code != "" || got.Events != nil {
+		t.Errorf("record 1: %+v, %v", got, err)
+	}
+	if got, _ := r.get(context.Background(), "1", true); got.Code != "x" {
+		t.Errorf("include=code: %q", got.Code)
+	}
+
+	for i := 3; i < 6; i++ {
+		r.add(&storage.Execution{ID: fmt.Sprint(i)})
+	}
+	if len(r.order) != 3 || r.order[0] != "3" {
+		t.Errorf("kept %v, want the last 3", r.order)
+	}
+	var nilStore *retainedExecutions
+	nilStore.add(&storage.Execution{ID: "x"})
+}
+
+func TestRetainedExecutions_List(t *testing.T) {
+	r := newRetainedExecutions(10, 1<<20)
+	for i, lang := range []string{"python", "bash", "python", "python"} {
+		r.add(&storage.Execution{ID: fmt.Sprint(i), Language: lang, Output: "out", Status: state.Completed})
+	}
+	r.add(&storage.Execution{ID: "4", Language: "python", Status: "success"}) // a legacy status finds its state
+
+	execs, err := r.list(context.Background(), storage.ExecutionFilter{Language: "python", Status: state.Completed, Offset: 1, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range execs {
+		ids = append(ids, e.ID)
+		if e.Source != "memory" || e.Output != "" {
+			t.Errorf("%s: source %q, output %q", e.ID, e.Source, e.Output)
+		}
+	}
+	if strings.Join(ids, ",") != "3,2" {
+		t.Errorf("listed %v, want 3,2: newest first, past the offset, up to the limit", ids)
+	}
+	if got, _ := r.get(context.Background(), "1", false); got.Source != "memory" {
+		t.Errorf("get: source %q", got.Source)
+	}
+}
+
+func TestRetainExecutions(t *testing.T) {
+	t.Run("without a database", func(t *testing.T) {
+		id := "6f1c1c4e-8d3a-4b1e-9a51-2f1f6a0c7e11"
+		h := newTestHandlers(&mockBackend{result: &sandbox.ExecutionResult{ID: id, ExitClass: sandbox.ExitUser}})
+		h.retainExecutions(1000)
+		if rec := postAs(t, h.HandleExecute, "alice", ExecutionRequest{Language: "python", Code: "print(1)"}); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleListExecutions(rec, httptest.NewRequest(http.MethodGet, "/executions", nil))
+		var execs []storage.Execution
+		if err := json.NewDecoder(rec.Body).Decode(&execs); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("list: status %d, %v", rec.Code, err)
+		}
+		if len(execs) != 1 || execs[0].ID != id || execs[0].Source != "memory" {
+			t.Errorf("listed %+v", execs)
+		}
+		if exec := getExecution(t, h, "/executions/"+id); exec.Source != "memory" {
+			t.Errorf("get: source %q", exec.Source)
+		}
+
+		h.retainExecutions(0)
+		rec = httptest.NewRecorder()
+		h.HandleListExecutions(rec, httptest.NewRequest(http.MethodGet, "/executions", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("keeping none: list status %d", rec.Code)
+		}
+	})
+
+	t.Run("behind a database", func(t *testing.T) {
+		memory := newRetainedExecutions(10, 1<<20)
+		memory.add(&storage.Execution{ID: "buffered"})
+		var dbDown bool
+		stored := &storage.Execution{ID: "stored"}
+		get := lookupFallback(func(_ context.Context, id string, _ bool) (*storage.Execution, error) {
+			if dbDown || id != stored.ID {
+				return nil, fmt.Errorf("execution %s not found", id)
+			}
+			return stored, nil
+		}, memory.get)
+		list := listFallback(func(context.Context, storage.ExecutionFilter) ([]storage.Execution, error) {
+			if dbDown {
+				return nil, fmt.Errorf("database down")
+			}
+			return []storage.Execution{*stored}, nil
+		}, memory.list)
+
+		if got, err := get(context.Background(), "stored", false); err != nil || got.Source != "" {
+			t.Errorf("stored: %+v, %v", got, err)
+		}
+		if got, err := get(context.Background(), "buffered", false); err != nil || got.Source != "memory" {
+			t.Errorf("not yet in the database: %+v, %v", got, err)
+		}
+		if _, err := get(context.Background(), "unknown", false); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("unknown: %v, want the database's error", err)
+		}
+		if execs, err := list(context.Background(), storage.ExecutionFilter{}); err != nil || len(execs) != 1 || execs[0].ID != "stored" {
+			t.Errorf("list: %+v, %v", execs, err)
+		}
+		dbDown = true
+		if execs, err := list(context.Background(), storage.ExecutionFilter{}); err != nil || len(execs) != 1 || execs[0].Source != "memory" {
+			t.Errorf("list with the database down: %+v, %v", execs, err)
+		}
+	})
+}
+
+func TestNewServer_RetrievableFromMemory(t *testing.T) {
+	if got := config.DefaultConfig().Database.RetainedExecutions; got != defaultRetainedExecutions {
+		t.Errorf("database.retained_executions defaults to %d, NewHandlers to %d", got, defaultRetainedExecutions)
+	}
+	for _, tt := range []struct {
+		retained int
+		want     bool
+	}{{1000, true}, {0, false}} {
+		// A database the audit writer doesn't write to has no records;
+		// memory has them.
+		cfg := config.DefaultConfig()
+		cfg.Database.Sinks = []string{"file"}
+		cfg.Database.RetainedExecutions = tt.retained
+		cfg.Security.Anomaly.Enabled = false // it would load baselines from the database
+		s := NewServer(cfg, &mockBackend{}, &storage.DB{}, nil, monitor.NewMetrics())
+		if s.handlers.retrievable != tt.want {
+			t.Errorf("retained_executions %d: retrievable %t, want %t", tt.retained, s.handlers.retrievable, tt.want)
+		}
+	}
+}
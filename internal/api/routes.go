@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The API's paths, relative to server.base_path. Routes are registered and
+// requests matched against these, after BasePathMiddleware has taken the
+// base path off.
+const (
+	pathExecute             = "/execute"
+	pathExecuteStream       = "/execute/stream"
+	pathExecuteUpload       = "/execute/upload"
+	pathExecuteAsync        = "/execute/async"
+	pathExecutions          = "/executions"
+	pathExecution           = pathExecutions + "/{id}"
+	pathExecutionProgress   = pathExecution + "/progress"
+	pathEstimate            = "/estimate"
+	pathUsageReport         = "/reports/usage"
+	pathSecurityEvents      = "/security-events"
+	pathCapabilities        = "/capabilities"
+	pathStats               = "/stats"
+	pathTask                = "/tasks/{id}"
+	pathCancellationGroup   = "/cancellation-groups/{name}"
+	pathWorkspaces          = "/workspaces"
+	pathWorkspace           = pathWorkspaces + "/{id}"
+	pathWorkspaceFiles      = pathWorkspace + "/files"
+	pathWorkspaceFile       = pathWorkspaceFiles + "/{path...}"
+	pathBundles             = "/bundles"
+	pathAdminConfig         = "/admin/config"
+	pathAdminSupportBundle  = "/admin/support-bundle"
+	pathAdminClaudeContract = "/admin/claude-contract"
+	pathAdminFlags          = "/admin/flags"
+	pathAdminBundle         = "/admin/bundles/{digest}"
+	pathAdminJobRun         = "/admin/jobs/{name}/run"
+	pathHealth              = "/health"
+	pathErrors              = "/errors"
+	pathSLO                 = "/slo"
+	pathMetrics             = "/metrics"
+	pathUI                  = "/ui"
+)
+
+// BasePathMiddleware takes basePath (server.base_path) off the front of
+// request paths, so the routes and the middleware inside it see the paths
+// they were written for. A path outside basePath is passed on unchanged,
+// for a reverse proxy that strips the prefix itself.
+func BasePathMiddleware(basePath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if basePath == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := trimBasePath(r.URL.Path, basePath)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = p
+			r2.URL.RawPath = ""
+			if r.URL.RawPath != "" {
+				if raw, ok := trimBasePath(r.URL.RawPath, basePath); ok {
+					r2.URL.RawPath = raw
+				}
+			}
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+// trimBasePath is p relative to basePath, and whether p was under it.
+func trimBasePath(p, basePath string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, basePath)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return p, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// publicURLs builds the links responses carry as clients see them: under
+// server.base_path and, with server.trust_proxy_headers, at the scheme and
+// host the reverse proxy was reached on.
+type publicURLs struct {
+	basePath   string
+	trustProxy bool
+}
+
+// path is the root-relative path of the API path p.
+func (u publicURLs) path(p string) string {
+	return u.basePath + p
+}
+
+// url is the absolute URL of the API path p, for a response to r.
+func (u publicURLs) url(r *http.Request, p string) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if u.trustProxy {
+		if proto := firstForwarded(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwd := firstForwarded(r.Header.Get("X-Forwarded-Host")); fwd != "" && !strings.ContainsAny(fwd, "/\\@") {
+			host = fwd
+		}
+	}
+	return scheme + "://" + host + u.path(p)
+}
+
+// firstForwarded is the value the outermost proxy set in a header that
+// each proxy on the way appends to.
+func firstForwarded(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
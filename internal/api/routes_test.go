@@ -0,0 +1,196 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+func TestTrimBasePath(t *testing.T) {
+	for _, tt := range []struct {
+		path, want string
+		ok         bool
+	}{
+		{"/sandbox/execute", "/execute", true},
+		{"/sandbox", "/", true},
+		{"/sandbox/", "/", true},
+		{"/sandboxes/execute", "/sandboxes/execute", false},
+		{"/execute", "/execute", false},
+	} {
+		if got, ok := trimBasePath(tt.path, "/sandbox"); got != tt.want || ok != tt.ok {
+			t.Errorf("trimBasePath(%q) = %q, %t; want %q, %t", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPublicURLs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/sandbox/executions", nil)
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	r.Header.Set("X-Forwarded-Host", "sandbox.example.com")
+
+	u := publicURLs{basePath: "/sandbox"}
+	if got := u.url(r, "/executions/1"); got != "http://example.com/sandbox/executions/1" {
+		t.Errorf("untrusted headers: %s", got)
+	}
+	u.trustProxy = true
+	if got := u.url(r, "/executions/1"); got != "https://sandbox.example.com/sandbox/executions/1" {
+		t.Errorf("trusted headers: %s", got)
+	}
+	r.Header.Set("X-Forwarded-Proto", "javascript")
+	r.Header.Set("X-Forwarded-Host", "evil.example/x")
+	if got := u.url(r, "/executions/1"); got != "http://example.com/sandbox/executions/1" {
+		t.Errorf("malformed headers: %s", got)
+	}
+}
+
+// prefixProxy is a reverse proxy serving the API under /sandbox/. It
+// forwards paths as they are or, with strip, without the prefix.
+func prefixProxy(t *testing.T, upstream string, strip bool) *httptest.Server {
+	t.Helper()
+	target, _ := url.Parse(upstream)
+	proxy := &httputil.ReverseProxy{Rewrite: func(pr *httputil.ProxyRequest) {
+		pr.SetURL(target)
+		pr.SetXForwarded()
+		if strip {
+			pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, "/sandbox")
+			pr.Out.URL.RawPath = ""
+		}
+	}}
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBasePath_BehindReverseProxy(t *testing.T) {
+	cfg := canaryConfig()
+	cfg.Server.BasePath = "/sandbox"
+	cfg.Server.TrustProxyHeaders = true
+	cfg.Server.EnableUI = true
+	cfg.Sandbox.Workspaces.Root = t.TempDir()
+	cfg.Security.RateLimitRPS, cfg.Security.RateLimitBurst = 1000, 1000
+	// Its streamed results carry their IDs, as a runner's do.
+	backend := &budgetBackend{mockBackend{result: &sandbox.ExecutionResult{ExitClass: sandbox.ExitUser}}}
+	upstream := httptest.NewServer(NewServer(cfg, backend, nil, nil, monitor.NewMetrics()).Handler())
+	defer upstream.Close()
+
+	const id = "6f1c2a8e-8d3b-4a51-9c1e-2f0a7b9d4e63"
+	execute := `{"language":"python","code":"print(1)"}`
+	endpoints := []struct {
+		method, path, key, body string
+	}{
+		{"GET", "/health", "", ""},
+		{"GET", "/errors", "", ""},
+		{"GET", "/slo", "", ""},
+		{"GET", "/metrics", "", ""},
+		{"GET", "/ui", "", ""},
+		{"GET", "/ui/app.js", "", ""},
+		{"GET", "/ui/runtimes.json", "", ""},
+		{"POST", "/execute", canaryUserKey, execute},
+		{"POST", "/execute/stream", canaryUserKey, execute},
+		{"POST", "/execute/upload", canaryUserKey, ""},
+		{"GET", "/executions", canaryUserKey, ""},
+		{"GET", "/executions/" + id, canaryUserKey, ""},
+		{"GET", "/executions/" + id + "/progress", canaryUserKey, ""},
+		{"DELETE", "/executions/" + id, canaryUserKey, ""},
+		{"GET", "/reports/usage", canaryUserKey, ""},
+		{"GET", "/capabilities", canaryUserKey, ""},
+		{"GET", "/stats", canaryUserKey, ""},
+		{"GET", "/tasks/t1", canaryUserKey, ""},
+		{"DELETE", "/cancellation-groups/g1", canaryUserKey, ""},
+		{"POST", "/workspaces", canaryUserKey, ""},
+		{"DELETE", "/workspaces/w1", canaryUserKey, ""},
+		{"GET", "/workspaces/w1/files", canaryUserKey, ""},
+		{"GET", "/workspaces/w1/files/a.txt", canaryUserKey, ""},
+		{"PUT", "/workspaces/w1/files/a.txt", canaryUserKey, "a"},
+		{"POST", "/bundles", canaryUserKey, ""},
+		{"GET", "/admin/config", canaryAdminKey, ""},
+		{"GET", "/admin/support-bundle", canaryAdminKey, ""},
+		{"GET", "/admin/claude-contract", canaryAdminKey, ""},
+		{"POST", "/admin/claude-contract", canaryAdminKey, ""},
+		{"GET", "/admin/flags", canaryAdminKey, ""},
+		{"POST", "/admin/flags", canaryAdminKey, ""},
+		{"POST", "/admin/bundles/sha256:00", canaryAdminKey, ""},
+		{"POST", "/admin/jobs/j1/run", canaryAdminKey, ""},
+	}
+
+	do := func(t *testing.T, method, url, key, body string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	for _, strip := range []bool{false, true} {
+		name := "preserving"
+		if strip {
+			name = "stripping"
+		}
+		t.Run(name, func(t *testing.T) {
+			proxy := prefixProxy(t, upstream.URL, strip)
+			for _, e := range endpoints {
+				resp, body := do(t, e.method, proxy.URL+"/sandbox"+e.path, e.key, e.body)
+				// A route the muxes don't know gets their plain-text 404 or
+				// 405; anything the handlers answer is JSON or an asset.
+				if resp.StatusCode == http.StatusMethodNotAllowed || body == "404 page not found\n" {
+					t.Errorf("%s %s: not routed (%d %q)", e.method, e.path, resp.StatusCode, body)
+				}
+			}
+
+			resp, body := do(t, "POST", proxy.URL+"/sandbox/execute/stream", canaryUserKey, execute)
+			if got := resp.Header.Get("X-Accel-Buffering"); got != "no" {
+				t.Errorf("stream X-Accel-Buffering %q", got)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != "no-store, no-transform" {
+				t.Errorf("stream Cache-Control %q", got)
+			}
+			events := readEvents(t, body)
+			var done stream.Done
+			if err := events[len(events)-1].Decode(&done); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(done.RetrievalPath, "/sandbox/executions/") {
+				t.Errorf("retrieval_path %q", done.RetrievalPath)
+			}
+			if resp, body := do(t, "GET", proxy.URL+done.RetrievalPath, canaryUserKey, ""); resp.StatusCode != http.StatusOK {
+				t.Errorf("retrieval_path: status %d: %s", resp.StatusCode, body)
+			}
+
+			if _, body := do(t, "GET", proxy.URL+"/sandbox/ui", "", ""); !strings.Contains(body, `src="/sandbox/ui/app.js"`) ||
+				!strings.Contains(body, `<meta name="base-path" content="/sandbox">`) {
+				t.Errorf("UI page isn't under the base path:\n%s", body)
+			}
+
+			resp, body = do(t, "POST", proxy.URL+"/sandbox/workspaces", canaryUserKey, "")
+			wsID := between(body, `"id":"`, `"`)
+			resp, _ = do(t, "PUT", proxy.URL+"/sandbox/workspaces/"+wsID+"/files/dir/a b.txt", canaryUserKey, "a")
+			if want := proxy.URL + "/sandbox/workspaces/" + wsID + "/files/dir/a%20b.txt"; resp.Header.Get("Location") != want {
+				t.Errorf("Location %q, want %q", resp.Header.Get("Location"), want)
+			}
+		})
+	}
+}
+
+func between(s, from, to string) string {
+	_, rest, _ := strings.Cut(s, from)
+	v, _, _ := strings.Cut(rest, to)
+	return v
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/storage"
+)
+
+const (
+	// defaultSecurityEventRange is the range when from is omitted.
+	defaultSecurityEventRange = 24 * time.Hour
+	// defaultSecurityEventTop is how many code hashes and languages a
+	// timeline names when top is omitted.
+	defaultSecurityEventTop = 10
+)
+
+// HandleSecurityEvents serves GET /security-events: the security events of
+// every caller, or of the one whose key hashes to identity, filtered by
+// severity and up and type. With bucket=1h or 1d it returns them counted per
+// bucket and severity instead of listed. Admin only: it shows what every
+// caller's code did.
+func (h *Handlers) HandleSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSecurityEventQuery(r, time.Now())
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	if q.Bucket == "" {
+		var list *storage.SecurityEventList
+		if h.db != nil {
+			if list, err = h.db.ListSecurityEvents(r.Context(), q); err != nil {
+				log.Error().Err(err).Msg("security event query failed")
+				apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "query failed"))
+				return
+			}
+		} else {
+			list, _ = storage.ListRecentSecurityEvents(h.recent.snapshot(), q)
+			list.Partial = true
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	var timeline *storage.SecurityEventTimeline
+	if h.db != nil {
+		if timeline, err = h.db.SecurityEventTimeline(r.Context(), q); err != nil {
+			log.Error().Err(err).Msg("security event timeline query failed")
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "query failed"))
+			return
+		}
+	} else {
+		timeline, _ = storage.AggregateSecurityEvents(h.recent.snapshot(), q)
+		timeline.Partial = true
+	}
+	writeJSON(w, http.StatusOK, timeline)
+}
+
+// parseSecurityEventQuery reads the query parameters. from and to are as
+// for usage reports, but default to the day up to the end of the current
+// minute; top defaults to defaultSecurityEventTop.
+func parseSecurityEventQuery(r *http.Request, now time.Time) (storage.SecurityEventQuery, error) {
+	params := r.URL.Query()
+	q := storage.SecurityEventQuery{
+		To:          now.UTC().Truncate(time.Minute).Add(time.Minute),
+		APIKeyHash:  params.Get("identity"),
+		MinSeverity: params.Get("severity"),
+		Type:        params.Get("type"),
+		Bucket:      params.Get("bucket"),
+		TopN:        defaultSecurityEventTop,
+	}
+	if v := params.Get("to"); v != "" {
+		t, dateOnly, err := parseReportTime(v)
+		if err != nil {
+			return q, errInvalidParam("to", v)
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		q.To = t
+	}
+	q.From = q.To.Add(-defaultSecurityEventRange)
+	if v := params.Get("from"); v != "" {
+		t, _, err := parseReportTime(v)
+		if err != nil {
+			return q, errInvalidParam("from", v)
+		}
+		q.From = t
+	}
+	for name, into := range map[string]*int{"top": &q.TopN, "limit": &q.Limit} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("%s must be a non-negative integer, got %s", name, v)
+		}
+		*into = n
+	}
+	if q.APIKeyHash != "" && !isIdentityHash(q.APIKeyHash) {
+		return q, fmt.Errorf("identity must be the SHA-256 of an API key or client certificate identity, in hex")
+	}
+	return q, q.Validate()
+}
+
+// isIdentityHash reports whether s is an ownerHash.
+func isIdentityHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/storage"
+)
+
+func getSecurityEvents(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/security-events"+query, nil)
+	rec := httptest.NewRecorder()
+	h.HandleSecurityEvents(rec, req)
+	return rec
+}
+
+func TestHandleSecurityEvents_Validation(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"bad severity", "?severity=severe", "severity must be"},
+		{"bad bucket", "?bucket=15m", "bucket must be"},
+		{"bad identity", "?identity=my-api-key", "identity must be"},
+		{"bad top", "?bucket=1h&top=many", "top must be"},
+		{"top too high", "?bucket=1h&top=1000", "top must be"},
+		{"negative limit", "?limit=-1", "limit must be"},
+		{"bad from", "?from=yesterday", "from must be"},
+		{"too long", "?from=2026-01-01&to=2026-03-01", "31 days"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getSecurityEvents(t, h, tt.query)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != "INVALID_REQUEST" || !strings.Contains(resp.Error, tt.want) {
+				t.Errorf("got %s %q, want INVALID_REQUEST mentioning %q", resp.Code, resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSecurityEventQuery(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 4, 5, 0, time.UTC)
+	r := httptest.NewRequest(http.MethodGet, "/security-events", nil)
+	q, err := parseSecurityEventQuery(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := time.Date(2026, 3, 10, 15, 5, 0, 0, time.UTC)
+	if !q.To.Equal(to) || !q.From.Equal(to.Add(-24*time.Hour)) || q.TopN != defaultSecurityEventTop || q.Bucket != "" {
+		t.Errorf("defaults: %+v", q)
+	}
+
+	identity := ownerHash("key-a")
+	r = httptest.NewRequest(http.MethodGet, "/security-events?identity="+identity+"&severity=high&type=secret_access&bucket=1h&top=3&from=2026-03-09", nil)
+	if q, err = parseSecurityEventQuery(r, now); err != nil {
+		t.Fatal(err)
+	}
+	if q.APIKeyHash != identity || q.MinSeverity != "high" || q.Type != "secret_access" || q.Bucket != "1h" || q.TopN != 3 ||
+		!q.From.Equal(time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("filters: %+v", q)
+	}
+}
+
+func TestHandleSecurityEvents_PartialWithoutDB(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+	at := time.Now().UTC().Add(-time.Hour)
+	for _, e := range []struct {
+		key, severity string
+	}{{"key-a", "critical"}, {"key-a", "low"}, {"key-b", "high"}} {
+		h.recent.add(&storage.Execution{
+			Language: "python", CodeHash: "c0de", APIKeyHash: ownerHash(e.key), CreatedAt: at, CompletedAt: &at,
+			Events: []storage.SecurityEventRecord{{Type: "secret_access", Severity: e.severity}},
+		})
+	}
+
+	rec := getSecurityEvents(t, h, "?identity="+ownerHash("key-a"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status = %d: %s", rec.Code, rec.Body)
+	}
+	var list storage.SecurityEventList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if !list.Partial || len(list.Events) != 2 || list.Events[0].APIKeyHash != ownerHash("key-a") {
+		t.Errorf("list: %+v", list)
+	}
+
+	rec = getSecurityEvents(t, h, "?bucket=1h&severity=high")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("timeline: status = %d: %s", rec.Code, rec.Body)
+	}
+	var timeline storage.SecurityEventTimeline
+	if err := json.NewDecoder(rec.Body).Decode(&timeline); err != nil {
+		t.Fatal(err)
+	}
+	if !timeline.Partial || len(timeline.Buckets) < 24 {
+		t.Errorf("timeline: partial %v, %d buckets", timeline.Partial, len(timeline.Buckets))
+	}
+	want := []storage.SeverityCount{{Severity: "critical", Events: 1}, {Severity: "high", Events: 1}}
+	if len(timeline.Totals) != 2 || timeline.Totals[0] != want[0] || timeline.Totals[1] != want[1] {
+		t.Errorf("totals = %+v, want %+v", timeline.Totals, want)
+	}
+	if len(timeline.TopCodeHashes) != 1 || timeline.TopCodeHashes[0] != (storage.SecurityEventOffender{Key: "c0de", Events: 2}) {
+		t.Errorf("top code hashes = %+v", timeline.TopCodeHashes)
+	}
+}
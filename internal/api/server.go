@@ -3,7 +3,15 @@ package api
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -11,62 +19,254 @@ import (
 
 	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/redact"
+	"safe-agent-sandbox/internal/runtime"
 	"safe-agent-sandbox/internal/sandbox"
 	"safe-agent-sandbox/internal/storage"
+	"safe-agent-sandbox/internal/version"
+	"safe-agent-sandbox/internal/workspace"
 )
 
 // Server is the main HTTP server for the sandbox API.
 type Server struct {
-	httpServer *http.Server
-	handlers   *Handlers
-	cfg        *config.Config
-	startTime  time.Time
+	httpServer   *http.Server
+	healthServer *http.Server // plain HTTP /health, nil unless server.plaintext_health_port is set
+	ln           net.Listener // httpServer's, once Listen has run
+	healthLn     net.Listener // healthServer's
+	handlers     *Handlers
+	cfg          *config.Config
+	masker       *redact.Masker // secrets of cfg, for the /admin routes
+	bundle       *supportBundle
+	startTime    time.Time
+	// configWarnings are the startup config report's warnings, for /health
+	// and /admin/config.
+	configWarnings []string
+	// stopBaselines ends the anomaly baselines' saving; baselinesSaved is
+	// closed once the last save is done. Both are nil without a database.
+	stopBaselines  context.CancelFunc
+	baselinesSaved chan struct{}
 }
 
 // NewServer creates and configures the HTTP server with all routes and middleware.
 func NewServer(cfg *config.Config, backend sandbox.Backend, db *storage.DB, auditWriter *storage.AuditWriter, metrics *monitor.Metrics) *Server {
 	handlers := NewHandlers(backend, db, auditWriter, metrics)
+	handlers.chaosEnabled = cfg.Sandbox.Chaos.Enabled
+	handlers.claudeIdleTimeout = cfg.Sandbox.ClaudeIdleOutputTimeout
+	handlers.maxIdleTimeout = cfg.Sandbox.MaxIdleOutputTimeout
+	handlers.maxUploadBytes = cfg.Sandbox.MaxUploadCodeBytes
+	handlers.maxStdinBytes = cfg.Sandbox.MaxStdinBytes
+	handlers.async = newAsyncExecutions(cfg.Server.AsyncJobTTL)
+	handlers.binaryMaxBytes = cfg.Sandbox.Binary.MaxBytes
+	handlers.network = newNetworkPolicy(cfg.Security.NetworkPolicy)
+	if fs := cfg.Sandbox.FairShare; fs.Enabled {
+		handlers.shareWeights = make(map[string]int)
+		for _, tier := range fs.Weights {
+			for _, key := range tier.Keys {
+				handlers.shareWeights[ownerHash(key)] = tier.Weight
+			}
+		}
+	}
+	handlers.debugTracers = newDebugTracers(cfg.Sandbox.DebugTrace)
+	handlers.maxUlimits = sandbox.Ulimits(cfg.Sandbox.MaxUlimits)
+	handlers.runtimes = runtimeLimits(cfg.Sandbox.RuntimeLimits)
+	handlers.urls = publicURLs{basePath: cfg.Server.BasePath, trustProxy: cfg.Server.TrustProxyHeaders}
+	handlers.output = sandbox.OutputLimits{
+		Stdout: cfg.Sandbox.Output.MaxStdoutBytes,
+		Stderr: cfg.Sandbox.Output.MaxStderrBytes,
+		Total:  cfg.Sandbox.Output.MaxTotalBytes,
+	}
+	for _, m := range cfg.Sandbox.SharedMounts {
+		handlers.sharedMounts = append(handlers.sharedMounts, m.Name)
+	}
+	handlers.claudeCaches = len(cfg.Sandbox.ClaudeCaches.Volumes) > 0
+	if cfg.Security.MaxTaskExecutions > 0 {
+		handlers.tasks = newTaskLimiter(cfg.Security.MaxTaskExecutions)
+	}
+	handlers.keys = newKeyLimits(cfg.Security)
+	handlers.executions.maxGroup = cfg.Security.MaxCancellationGroupSize
+	handlers.prompts = newPromptScreen(cfg.Security.Claude)
+	handlers.estimates = newClaudeEstimator(cfg.Security.Claude)
+	handlers.detector = newEscapeDetector(cfg.Security.Detector)
+	handlers.costs = newCostLimiter(cfg.Security.CostBudget, metrics)
+	handlers.claudeTokens = newClaudeTokens(cfg.Security.ClaudeTokens)
+	handlers.privacy = newPrivacyPolicy(cfg.Database.StoreCode && db != nil, cfg.Security.PrivacyMode)
+	handlers.retainExecutions(cfg.Database.RetainedExecutions)
+	// Records reach Postgres only through the audit writer's sink.
+	inPostgres := db != nil && auditWriter != nil && slices.Contains(cfg.Database.Sinks, "postgres")
+	handlers.retrievable = inPostgres || handlers.retained != nil
+	if cfg.Database.Required {
+		var ping func(context.Context) bool
+		var fill func() float64
+		if inPostgres {
+			ping = db.Healthy
+		}
+		if auditWriter != nil {
+			fill = auditWriter.Fill
+		}
+		handlers.audit = newAuditGate(ping, fill)
+	}
+	handlers.slo = newSLOTracker(cfg.Metrics.SLO, metrics)
+	claudeGate, rateWindow := newClaudeGate(cfg.Security.MaxConcurrentClaude), newRateWindow()
+	handlers.stats = newStatsCollector(cfg.Metrics.Stats, backend, handlers.executions, claudeGate, rateWindow)
+	if handlers.costs != nil && db != nil {
+		// Executions are logged as they finish, so the last hour's spend
+		// comes back short by whatever was running or still buffered.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		records, err := db.RecentCosts(ctx, time.Now().Add(-costWindow))
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Msg("could not restore cost budgets, starting every caller at zero")
+		} else {
+			handlers.costs.seed(records)
+		}
+	}
+	handlers.flags = newKillSwitch(cfg.Security, metrics, handlers.executions.killLanguage)
+	handlers.anomalies = newAnomalyFlagger(cfg.Security.Anomaly)
+	handlers.dedup = newDedupTable(cfg.Sandbox.Dedup)
+	if handlers.anomalies != nil && db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		saved, err := db.LoadBaselines(ctx, time.Now().Add(-baselineIdleTTL))
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Msg("could not restore anomaly baselines, every caller starts cold")
+		} else {
+			handlers.anomalies.restore(saved)
+		}
+	}
+
+	if ws := cfg.Sandbox.Workspaces; ws.Root != "" {
+		store, err := workspace.NewStore(ws.Root, ws.TTL, ws.MaxFileBytes, ws.MaxTotalBytes, ws.MaxWorkspaces)
+		if err != nil {
+			log.Warn().Err(err).Msg("workspace store unavailable, workspaces disabled")
+		} else {
+			handlers.workspaces = store
+		}
+	}
+	if b := cfg.Sandbox.Bundles; b.Store != "" {
+		if bundles, err := newBundleStore(b, db); err != nil {
+			log.Warn().Err(err).Msg("bundle store unavailable, bundles disabled")
+		} else if handlers.workspaces == nil {
+			log.Warn().Msg("bundles need sandbox.workspaces.root to unpack into, bundles disabled")
+			bundles.Close()
+		} else {
+			handlers.bundles = bundles
+			handlers.requireApproval = b.RequireApproval
+		}
+	}
 
 	s := &Server{
 		handlers:  handlers,
 		cfg:       cfg,
+		masker:    redact.NewMasker(cfg),
 		startTime: time.Now(),
 	}
+	if handlers.anomalies != nil && db != nil {
+		var ctx context.Context
+		ctx, s.stopBaselines = context.WithCancel(context.Background())
+		s.baselinesSaved = make(chan struct{})
+		go func() {
+			defer close(s.baselinesSaved)
+			handlers.anomalies.persist(ctx, db)
+		}()
+	}
+	s.bundle = &supportBundle{
+		cfg:      cfg,
+		masker:   s.masker,
+		backend:  backend,
+		gatherer: metrics.Registry,
+		events:   handlers.executions.lifecycle,
+		profile:  writeProfile,
+		started:  s.startTime,
+		maxBytes: maxSupportBundleBytes,
+	}
 
+	clientCerts := cfg.TLS.ClientAuthMode == "request" || cfg.TLS.ClientAuthMode == "require_and_verify"
 	if len(cfg.Security.AllowedKeys) == 0 {
-		if cfg.Security.AllowUnauthenticated {
+		if clientCerts {
+			log.Info().Msg("no API keys configured — only verified client certificates are accepted")
+		} else if cfg.Security.AllowUnauthenticated {
 			log.Warn().Msg("no API keys configured — allow_unauthenticated is true, all requests will be accepted")
 		} else {
 			log.Warn().Msg("no API keys configured and allow_unauthenticated is false — all requests will be rejected")
 		}
 	}
 
-	// Execution API — wrapped with auth
+	// Execution API — wrapped with auth. The routes that can run claude may
+	// outlive server.write_timeout; see ClaudeWriteDeadlineMiddleware.
+	claudeRoute := ClaudeWriteDeadlineMiddleware(cfg.Server.ClaudeWriteTimeout)
 	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("POST /execute", handlers.HandleExecute)
-	apiMux.HandleFunc("POST /execute/stream", handlers.HandleExecuteStream)
-	apiMux.HandleFunc("GET /executions", handlers.HandleListExecutions)
-	apiMux.HandleFunc("GET /executions/{id}", handlers.HandleGetExecution)
-	apiMux.HandleFunc("DELETE /executions/{id}", handlers.HandleKillExecution)
+	apiMux.Handle("POST "+pathExecute, claudeRoute(http.HandlerFunc(handlers.HandleExecute)))
+	apiMux.Handle("POST "+pathExecuteStream, claudeRoute(http.HandlerFunc(handlers.HandleExecuteStream)))
+	apiMux.HandleFunc("POST "+pathExecuteUpload, handlers.HandleExecuteUpload)
+	apiMux.HandleFunc("POST "+pathExecuteAsync, handlers.HandleExecuteAsync)
+	apiMux.HandleFunc("GET "+pathExecutions, handlers.HandleListExecutions)
+	apiMux.HandleFunc("GET "+pathExecution, handlers.HandleGetExecution)
+	apiMux.HandleFunc("GET "+pathExecutionProgress, handlers.HandleExecutionProgress)
+	apiMux.HandleFunc("DELETE "+pathExecution, handlers.HandleKillExecution)
+	apiMux.HandleFunc("POST "+pathEstimate, handlers.HandleEstimate)
+	apiMux.HandleFunc("GET "+pathUsageReport, handlers.HandleUsageReport)
+	apiMux.HandleFunc("GET "+pathCapabilities, handlers.HandleCapabilities)
+	if !cfg.Metrics.Stats.Public {
+		apiMux.HandleFunc("GET "+pathStats, handlers.HandleStats)
+	}
+	apiMux.HandleFunc("GET "+pathTask, handlers.HandleGetTask)
+	apiMux.HandleFunc("DELETE "+pathCancellationGroup, handlers.HandleCancelGroup)
+	apiMux.HandleFunc("POST "+pathWorkspaces, handlers.HandleCreateWorkspace)
+	apiMux.HandleFunc("DELETE "+pathWorkspace, handlers.HandleDeleteWorkspace)
+	apiMux.HandleFunc("GET "+pathWorkspaceFiles, handlers.HandleListWorkspaceFiles)
+	apiMux.HandleFunc("GET "+pathWorkspaceFile, handlers.HandleGetWorkspaceFile)
+	apiMux.HandleFunc("PUT "+pathWorkspaceFile, handlers.HandlePutWorkspaceFile)
+	apiMux.HandleFunc("POST "+pathBundles, handlers.HandleCreateBundle)
 
-	authedAPI := AuthMiddleware(cfg.Security.AllowedKeys, cfg.Security.AllowUnauthenticated)(apiMux)
+	// Admin API — callers listed in security.admin_keys only.
+	admin := AdminMiddleware(cfg.Security.AdminKeys)
+	apiMux.Handle("GET "+pathAdminConfig, admin(http.HandlerFunc(s.handleAdminConfig)))
+	apiMux.Handle("GET "+pathAdminSupportBundle, admin(http.HandlerFunc(s.handleSupportBundle)))
+	apiMux.Handle("GET "+pathAdminClaudeContract, admin(http.HandlerFunc(handlers.HandleClaudeContract)))
+	apiMux.Handle("POST "+pathAdminClaudeContract, admin(http.HandlerFunc(handlers.HandleClaudeContract)))
+	apiMux.Handle("GET "+pathAdminFlags, admin(http.HandlerFunc(handlers.HandleFlags)))
+	apiMux.Handle("POST "+pathAdminFlags, admin(http.HandlerFunc(handlers.HandleFlags)))
+	apiMux.Handle("POST "+pathAdminBundle, admin(http.HandlerFunc(handlers.HandleBundleFlags)))
+	apiMux.Handle("POST "+pathAdminJobRun, admin(http.HandlerFunc(handlers.HandleRunJob)))
+	apiMux.Handle("GET "+pathSecurityEvents, admin(http.HandlerFunc(handlers.HandleSecurityEvents)))
+
+	precedence := cfg.Security.AuthPrecedence
+	if precedence == "" {
+		precedence = PreferClientCert
+	}
+	authedAPI := AuthMiddleware(cfg.Security.AllowedKeys, cfg.Security.AllowUnauthenticated, precedence)(apiMux)
 
 	// Top-level mux: health/metrics bypass auth, everything else goes through auth
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", s.handleHealth(db))
-	mux.Handle("GET /metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET "+pathHealth, s.handleHealth(db))
+	mux.HandleFunc("GET "+pathErrors, handlers.HandleErrorCatalog)
+	mux.HandleFunc("GET "+pathSLO, handlers.HandleSLO)
+	if cfg.Metrics.Stats.Public {
+		mux.HandleFunc("GET "+pathStats, handlers.HandleStats)
+	}
+	mux.Handle("GET "+pathMetrics, promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	if cfg.Server.EnableUI {
+		newUIHandler(runtime.NewRegistry().Languages(), cfg.Server.BasePath).register(mux)
+	}
 	mux.Handle("/", authedAPI)
 
 	// Apply middleware chain (outermost first)
 	var handler http.Handler = mux
-	handler = ConcurrentClaudeMiddleware(cfg.Security.MaxConcurrentClaude)(handler)
+	handler = concurrentClaude(claudeGate, metrics)(handler)
 	handler = MetricsMiddleware(metrics)(handler)
-	handler = RateLimitMiddleware(cfg.Security.RateLimitRPS, cfg.Security.RateLimitBurst)(handler)
+	if handlers.keys.byKey {
+		handler = keyRateLimit(handlers.keys, metrics, rateWindow)(handler)
+	} else {
+		handler = rateLimit(cfg.Security.RateLimitRPS, cfg.Security.RateLimitBurst, metrics, rateWindow)(handler)
+	}
+	handler = countKeyRequests(handlers.keys, metrics)(handler)
+	handler = ClientCertMiddleware(handler) // identity for rate limiting and auth
 	handler = MaxBodyMiddleware(cfg.Server.MaxRequestBody)(handler)
 	handler = SecurityHeadersMiddleware(handler)
 	handler = LoggingMiddleware(handler)
+	handler = RecoveryMiddleware(handler) // inside RequestID so panics report the request ID
 	handler = RequestIDMiddleware(handler)
-	handler = RecoveryMiddleware(handler)
+	handler = BasePathMiddleware(cfg.Server.BasePath)(handler) // every path below is relative to it
 
 	s.httpServer = &http.Server{
 		Addr:         cfg.Address(),
@@ -76,34 +276,235 @@ func NewServer(cfg *config.Config, backend sandbox.Backend, db *storage.DB, audi
 		IdleTimeout:  120 * time.Second,
 	}
 
+	if port := cfg.Server.PlaintextHealthPort; port > 0 {
+		healthMux := http.NewServeMux()
+		healthMux.HandleFunc("GET "+pathHealth, s.handleHealth(db))
+		s.healthServer = &http.Server{
+			Addr:              net.JoinHostPort(cfg.Server.Host, strconv.Itoa(port)),
+			Handler:           BasePathMiddleware(cfg.Server.BasePath)(SecurityHeadersMiddleware(healthMux)),
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      10 * time.Second,
+		}
+	}
+
 	return s
 }
 
-// Start begins listening for requests. Uses TLS if configured.
+// SetTokenBroker attaches the auth proxy that callers' claude tokens are
+// registered with (security.claude_tokens), as are the secrets of every
+// claude execution with auth_proxy.per_execution_secrets. Call it before
+// Start.
+func (s *Server) SetTokenBroker(b TokenBroker) {
+	if s.handlers.claudeTokens != nil {
+		s.handlers.claudeTokens.broker = b
+	}
+	if s.cfg.AuthProxy.PerExecutionSecrets {
+		s.handlers.execSecrets = b
+	}
+}
+
+// SetExecutionLister answers GET /executions from l, for a server without a
+// database that keeps its audit log elsewhere. Call it before Start.
+func (s *Server) SetExecutionLister(l func(ctx context.Context, filter storage.ExecutionFilter) ([]storage.Execution, error)) {
+	s.handlers.listExecs = l
+}
+
+// SetConfigWarnings sets the config warnings that GET /health and GET
+// /admin/config list. Call it before Start.
+func (s *Server) SetConfigWarnings(warnings []string) {
+	s.configWarnings = warnings
+}
+
+// ReloadFlags replaces the kill switches with those of sec, for a config
+// reload. It undoes any POST /admin/flags.
+func (s *Server) ReloadFlags(sec config.SecurityConfig) {
+	s.handlers.flags.reload(sec, "reload")
+}
+
+// ReloadPricing replaces the claude pricing POST /estimate uses with
+// sec's, for a config reload.
+func (s *Server) ReloadPricing(sec config.SecurityConfig) {
+	s.handlers.estimates.reload(sec.Claude.Pricing)
+}
+
+// The names of the server's listeners, as Listen takes and Listeners
+// returns them.
+const (
+	ListenerAPI    = "api"
+	ListenerHealth = "health"
+)
+
+// Listen opens the server's listeners, or takes those of inherited, by
+// name, that an upgrade handed over, and loads its TLS certificate. Start
+// calls it if it hasn't been.
+func (s *Server) Listen(inherited map[string]net.Listener) error {
+	listen := func(name, addr string) (net.Listener, error) {
+		if ln := inherited[name]; ln != nil {
+			return ln, nil
+		}
+		return net.Listen("tcp", addr)
+	}
+	if s.cfg.TLS.Enabled {
+		tlsConfig, err := ServerTLSConfig(s.cfg.TLS)
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
+	ln, err := listen(ListenerAPI, s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	if s.healthServer != nil {
+		if s.healthLn, err = listen(ListenerHealth, s.healthServer.Addr); err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("plaintext health listener: %w", err)
+		}
+	}
+	s.ln = ln
+	return nil
+}
+
+// Listeners returns the server's listeners by name, to hand to an upgrade.
+func (s *Server) Listeners() map[string]net.Listener {
+	listeners := map[string]net.Listener{ListenerAPI: s.ln}
+	if s.healthLn != nil {
+		listeners[ListenerHealth] = s.healthLn
+	}
+	return listeners
+}
+
+// Start begins serving requests, listening first if Listen hasn't been
+// called. Uses TLS if configured.
 func (s *Server) Start() error {
+	if s.ln == nil {
+		if err := s.Listen(nil); err != nil {
+			return err
+		}
+	}
+	if s.healthServer != nil {
+		log.Info().Str("addr", s.healthServer.Addr).Msg("serving /health over plain HTTP")
+		go func() {
+			if err := s.healthServer.Serve(s.healthLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Msg("plaintext health listener failed")
+			}
+		}()
+	}
+
 	if s.cfg.TLS.Enabled {
 		log.Info().
 			Str("addr", s.httpServer.Addr).
 			Str("cert", s.cfg.TLS.CertFile).
+			Str("client_auth", s.cfg.TLS.ClientAuthMode).
 			Msg("starting HTTPS server with TLS")
-
-		s.httpServer.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		}
-		return s.httpServer.ListenAndServeTLS(s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+		return s.httpServer.ServeTLS(s.ln, "", "")
 	}
 
 	log.Warn().Msg("TLS not enabled — running plain HTTP (not recommended for production)")
 	log.Info().
 		Str("addr", s.httpServer.Addr).
 		Msg("starting HTTP server")
-	return s.httpServer.ListenAndServe()
+	return s.httpServer.Serve(s.ln)
+}
+
+// newSLOTracker returns the tracker for cfg, feeding it from metrics, or nil
+// when no language has objectives.
+func newSLOTracker(cfg config.SLOConfig, metrics *monitor.Metrics) *monitor.SLOTracker {
+	if len(cfg.Objectives) == 0 {
+		return nil
+	}
+	objectives := make([]monitor.SLOObjective, 0, len(cfg.Objectives))
+	for lang, o := range cfg.Objectives {
+		objectives = append(objectives, monitor.SLOObjective{Language: lang, P95: o.P95, SuccessRate: o.SuccessRate})
+	}
+	t := monitor.NewSLOTracker(cfg.Window, cfg.Bucket, objectives)
+	metrics.TrackSLOs(t)
+	return t
+}
+
+// ServerTLSConfig loads the server certificate and, when client_auth_mode
+// asks for it, the CAs client certificates are verified against. "request"
+// verifies a certificate only if the client sends one; unverified
+// certificates are never accepted.
+func ServerTLSConfig(t config.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	switch t.ClientAuthMode {
+	case "", "none":
+		return tlsConfig, nil
+	case "request":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require_and_verify":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown tls.client_auth_mode %q", t.ClientAuthMode)
+	}
+	pem, err := os.ReadFile(filepath.Clean(t.ClientCAFile)) // #nosec G304 -- path comes from the config file
+	if err != nil {
+		return nil, fmt.Errorf("reading tls.client_ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls.client_ca_file %s has no PEM certificates", t.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
+}
+
+// Handler returns the fully wrapped HTTP handler, for tests that want the
+// real route table and middleware without a listener.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
 }
 
 // Shutdown gracefully stops the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Info().Msg("shutting down HTTP server")
-	return s.httpServer.Shutdown(ctx)
+	if s.handlers.workspaces != nil {
+		s.handlers.workspaces.Close()
+	}
+	if s.handlers.bundles != nil {
+		s.handlers.bundles.Close()
+	}
+	if s.handlers.jobs != nil {
+		s.handlers.jobs.close(ctx)
+	}
+	if s.stopBaselines != nil {
+		s.stopBaselines()
+		select {
+		case <-s.baselinesSaved:
+		case <-ctx.Done():
+			log.Warn().Msg("anomaly baselines not saved before shutdown deadline")
+		}
+	}
+	if s.healthServer != nil {
+		if err := s.healthServer.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("plaintext health listener shutdown error")
+		}
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if err == nil {
+		// Asynchronous executions outlive their requests; the drain waits
+		// for them too.
+		err = s.handlers.async.wait(ctx)
+	}
+	if err != nil && ctx.Err() != nil {
+		// The drain timed out: record and remove what is still running
+		// rather than leave it to die with the process, unrecorded.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), interruptCleanupTimeout)
+		defer cancel()
+		if n := s.handlers.interruptExecutions(cleanupCtx, "server shut down after its drain timed out; execution interrupted"); n > 0 {
+			log.Warn().Int("executions", n).Msg("interrupted executions still running at shutdown")
+		}
+	}
+	return err
 }
 
 func (s *Server) handleHealth(db *storage.DB) http.HandlerFunc {
@@ -111,13 +512,32 @@ func (s *Server) handleHealth(db *storage.DB) http.HandlerFunc {
 		dbOK := db == nil || db.Healthy(r.Context())
 
 		resp := HealthResponse{
-			Status:     "ok",
-			Database:   dbOK,
-			Containerd: true, // Would check runner.client.Healthy() in practice
-			Uptime:     time.Since(s.startTime).Round(time.Second).String(),
+			Status:         "ok",
+			Database:       dbOK,
+			Containerd:     true, // Would check runner.client.Healthy() in practice
+			Uptime:         time.Since(s.startTime).Round(time.Second).String(),
+			ServerVersion:  version.Get().String(),
+			ConfigWarnings: s.configWarnings,
+			ImageDigests:   s.handlers.images.snapshot(),
+		}
+		if dr, ok := s.handlers.backend.(sandbox.DiskReporter); ok {
+			if disk, ok := dr.DiskPressure(); ok {
+				resp.Disk = &disk
+			}
+		}
+		if f := s.handlers.flags.load(); len(f.Languages) > 0 || len(f.Features) > 0 {
+			resp.Disabled = &DisabledFlags{Languages: f.Languages, Features: f.Features, Message: f.Message}
+		}
+
+		if s.handlers.audit != nil {
+			reason := s.handlers.audit.refusal()
+			resp.Audit = &AuditHealth{Refusing: reason != "", Reason: reason}
 		}
 
-		if !dbOK {
+		switch {
+		case resp.Audit != nil && resp.Audit.Refusing:
+			resp.Status = "refusing"
+		case !dbOK:
 			resp.Status = "degraded"
 		}
 
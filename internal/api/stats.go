@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+)
+
+// statsCollector assembles GET /stats from the backend's slots, the
+// execution registry, the claude gate and the rate limiter's window. A
+// document is computed at most once per cache_ttl, however often it is
+// scraped.
+type statsCollector struct {
+	backend    sandbox.Backend
+	executions *executionRegistry
+	claude     *claudeGate
+	rate       *rateWindow
+	cfg        config.StatsConfig
+	now        func() time.Time
+
+	mu      sync.Mutex
+	cached  *StatsResponse
+	expires time.Time
+}
+
+func newStatsCollector(cfg config.StatsConfig, backend sandbox.Backend, executions *executionRegistry, claude *claudeGate, rate *rateWindow) *statsCollector {
+	return &statsCollector{
+		backend:    backend,
+		executions: executions,
+		claude:     claude,
+		rate:       rate,
+		cfg:        cfg,
+		now:        time.Now,
+	}
+}
+
+// get returns the cached document, or computes one once it has expired.
+// Scrapes that arrive while it is computed wait for it rather than
+// computing their own.
+func (c *statsCollector) get() *StatsResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.cached != nil && now.Before(c.expires) {
+		return c.cached
+	}
+	c.cached = c.compute(now)
+	c.expires = now.Add(c.cfg.CacheTTL)
+	return c.cached
+}
+
+func (c *statsCollector) compute(now time.Time) *StatsResponse {
+	resp := &StatsResponse{GeneratedAt: now, SlotPools: []sandbox.PoolStats{}}
+	if sr, ok := c.backend.(sandbox.StatsReporter); ok {
+		if slots, ok := sr.Stats(); ok {
+			resp.SlotCapacity, resp.SlotsInUse, resp.SlotPools = slots.Capacity, slots.InUse, slots.Pools
+		}
+	}
+	var oldest time.Duration
+	resp.RunningByLanguage, resp.QueueDepth, oldest = c.executions.queueStats(now)
+	for _, n := range resp.RunningByLanguage {
+		resp.Running += n
+	}
+	resp.QueueOldestWaitSeconds = oldest.Seconds()
+	resp.ClaudeInUse, resp.ClaudeCapacity = c.claude.occupancy()
+	allowed, rejected := c.rate.lastMinute()
+	resp.RateLimitRejectedPerMinute = rejected
+
+	resp.Saturation = SaturationParts{
+		Slots:     fraction(resp.SlotsInUse, resp.SlotCapacity),
+		Queue:     min(fraction(resp.QueueDepth, resp.SlotCapacity), 1),
+		Claude:    fraction(resp.ClaudeInUse, resp.ClaudeCapacity),
+		RateLimit: fraction(rejected, allowed+rejected),
+	}
+	resp.SaturationScore = saturationScore(c.cfg.Weights, resp.Saturation)
+	return resp
+}
+
+// fraction is n of total, capped at 1. Of a total of 0 it is 0, or 1 for a
+// queue waiting on slots a backend doesn't report.
+func fraction(n, total int) float64 {
+	switch {
+	case n <= 0:
+		return 0
+	case total <= 0 || n >= total:
+		return 1
+	}
+	return float64(n) / float64(total)
+}
+
+// saturationScore is the weighted mean of parts, between 0 and 1.
+func saturationScore(w config.SaturationWeights, parts SaturationParts) float64 {
+	total := w.Slots + w.Queue + w.Claude + w.RateLimit
+	if total <= 0 {
+		return 0
+	}
+	sum := w.Slots*parts.Slots + w.Queue*parts.Queue + w.Claude*parts.Claude + w.RateLimit*parts.RateLimit
+	return min(max(sum/total, 0), 1)
+}
+
+// queueStats counts the live executions: the running ones by language, and
+// the ones queued for a slot with how long the oldest has waited.
+func (e *executionRegistry) queueStats(now time.Time) (running map[string]int, queued int, oldestWait time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	running = make(map[string]int)
+	for _, a := range e.active {
+		switch a.state {
+		case state.Running:
+			running[a.language]++
+		case state.Queued:
+			queued++
+			oldestWait = max(oldestWait, now.Sub(a.started))
+		}
+	}
+	return running, queued, oldestWait
+}
+
+// HandleStats serves GET /stats: the server's saturation as one flat
+// document, with saturation_score for an autoscaler to target.
+func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.stats.get())
+}
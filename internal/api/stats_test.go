@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// statsBackend reports fixed slots and counts how often it is asked.
+type statsBackend struct {
+	mockBackend
+	slots sandbox.SlotStats
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *statsBackend) Stats() (sandbox.SlotStats, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	return b.slots, true
+}
+
+func newTestStats(t *testing.T, backend sandbox.Backend, clock *time.Time) (*statsCollector, *executionRegistry, *claudeGate, *rateWindow) {
+	t.Helper()
+	now := func() time.Time { return *clock }
+	executions := newExecutionRegistry(nil)
+	gate := newClaudeGate(4)
+	gate.now = now
+	window := newRateWindow()
+	window.now = now
+	c := newStatsCollector(config.DefaultConfig().Metrics.Stats, backend, executions, gate, window)
+	c.now = now
+	return c, executions, gate, window
+}
+
+func TestStats_Aggregates(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	backend := &statsBackend{slots: sandbox.SlotStats{Capacity: 10, InUse: 3, Pools: []sandbox.PoolStats{{Name: "claude", Capacity: 4, InUse: 1}, {Name: "default", Capacity: 10, InUse: 2}}}}
+	c, executions, gate, window := newTestStats(t, backend, &clock)
+
+	for id, lang := range map[string]string{"py-1": "python", "py-2": "python", "claude-1": "claude"} {
+		if _, _, err := executions.start(id, "alice", lang, "", time.Minute, nil); err != nil {
+			t.Fatal(err)
+		}
+		executions.admit(id)
+	}
+	executions.start("queued-old", "alice", "node", "", time.Minute, nil)
+	executions.active["queued-old"].started = clock.Add(-5 * time.Second)
+	executions.start("queued-new", "bob", "node", "", time.Minute, nil)
+	executions.active["queued-new"].started = clock.Add(-time.Second)
+
+	gate.enter()
+	gate.enter()
+	for range 6 {
+		window.record(false)
+	}
+	window.record(true)
+	window.record(true)
+	// A rejection from over a minute ago has left the window.
+	clock = clock.Add(-90 * time.Second)
+	window.record(true)
+	clock = clock.Add(90 * time.Second)
+
+	rec := httptest.NewRecorder()
+	h := newTestHandlers(backend)
+	h.stats = c
+	h.HandleStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got StatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Running != 3 || got.RunningByLanguage["python"] != 2 || got.RunningByLanguage["claude"] != 1 {
+		t.Errorf("running %d by language %v", got.Running, got.RunningByLanguage)
+	}
+	if got.SlotCapacity != 10 || got.SlotsInUse != 3 || len(got.SlotPools) != 2 || got.SlotPools[0].Name != "claude" {
+		t.Errorf("slots %d of %d, pools %+v", got.SlotsInUse, got.SlotCapacity, got.SlotPools)
+	}
+	if got.QueueDepth != 2 || got.QueueOldestWaitSeconds != 5 {
+		t.Errorf("queue depth %d, oldest wait %gs; want 2 and 5s", got.QueueDepth, got.QueueOldestWaitSeconds)
+	}
+	if got.ClaudeCapacity != 4 || got.ClaudeInUse != 2 {
+		t.Errorf("claude %d of %d", got.ClaudeInUse, got.ClaudeCapacity)
+	}
+	if got.RateLimitRejectedPerMinute != 2 {
+		t.Errorf("rejected per minute %d, want 2", got.RateLimitRejectedPerMinute)
+	}
+	want := SaturationParts{Slots: 0.3, Queue: 0.2, Claude: 0.5, RateLimit: 0.25}
+	if got.Saturation != want {
+		t.Errorf("saturation %+v, want %+v", got.Saturation, want)
+	}
+	// 0.5*0.3 + 0.2*0.2 + 0.2*0.5 + 0.1*0.25 under the default weights.
+	if math.Abs(got.SaturationScore-0.315) > 1e-9 {
+		t.Errorf("saturation_score %g, want 0.315", got.SaturationScore)
+	}
+}
+
+func TestStats_NoSlotsReported(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	c, executions, _, _ := newTestStats(t, &mockBackend{}, &clock)
+	if got := c.get(); got.SlotCapacity != 0 || got.SlotPools == nil || got.Saturation.Queue != 0 {
+		t.Errorf("idle without slot stats: %+v", got)
+	}
+	// A queue with no known capacity counts as saturated.
+	executions.start("queued", "alice", "python", "", time.Minute, nil)
+	clock = clock.Add(time.Minute)
+	if got := c.get(); got.Saturation.Queue != 1 {
+		t.Errorf("queued without slot stats: %+v", got.Saturation)
+	}
+}
+
+func TestSaturationScore(t *testing.T) {
+	parts := SaturationParts{Slots: 1, Queue: 0.5, Claude: 0, RateLimit: 0}
+	tests := []struct {
+		name    string
+		weights config.SaturationWeights
+		want    float64
+	}{
+		{"slots only", config.SaturationWeights{Slots: 1}, 1},
+		{"queue only", config.SaturationWeights{Queue: 3}, 0.5},
+		{"claude only", config.SaturationWeights{Claude: 1}, 0},
+		{"even", config.SaturationWeights{Slots: 1, Queue: 1, Claude: 1, RateLimit: 1}, 0.375},
+		{"weights needn't add up to 1", config.SaturationWeights{Slots: 2, Claude: 6}, 0.25},
+		{"none", config.SaturationWeights{}, 0},
+	}
+	for _, tt := range tests {
+		if got := saturationScore(tt.weights, parts); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStats_Cached(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	backend := &statsBackend{slots: sandbox.SlotStats{Capacity: 10}}
+	c, executions, _, _ := newTestStats(t, backend, &clock)
+
+	var wg sync.WaitGroup
+	docs := make([]*StatsResponse, 50)
+	for i := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			docs[i] = c.get()
+		}()
+	}
+	wg.Wait()
+	for _, d := range docs {
+		if d != docs[0] {
+			t.Fatal("rapid scrapes got different documents")
+		}
+	}
+	if backend.calls != 1 {
+		t.Errorf("backend asked %d times for 50 scrapes within cache_ttl", backend.calls)
+	}
+
+	// Within the TTL a new execution isn't seen; past it, it is.
+	executions.start("py", "alice", "python", "", time.Minute, nil)
+	executions.admit("py")
+	clock = clock.Add(c.cfg.CacheTTL - time.Millisecond)
+	if got := c.get(); got.Running != 0 {
+		t.Errorf("within cache_ttl: running %d, want the cached 0", got.Running)
+	}
+	clock = clock.Add(time.Millisecond)
+	if got := c.get(); got.Running != 1 || !got.GeneratedAt.Equal(clock) || backend.calls != 2 {
+		t.Errorf("past cache_ttl: running %d generated %s after %d backend calls", got.Running, got.GeneratedAt, backend.calls)
+	}
+}
+
+func TestStats_Auth(t *testing.T) {
+	for _, public := range []bool{true, false} {
+		cfg := canaryConfig()
+		cfg.Metrics.Stats.Public = public
+		handler := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics()).Handler()
+		for key, want := range map[string]int{"": http.StatusUnauthorized, canaryUserKey: http.StatusOK} {
+			if public {
+				want = http.StatusOK
+			}
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != want {
+				t.Errorf("public %t, key %q: status %d, want %d", public, key, w.Code, want)
+			}
+		}
+	}
+}
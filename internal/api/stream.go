@@ -1,26 +1,35 @@
 package api
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/pkg/stream"
 )
 
 const (
-	maxSSEStdoutBytes = 1 << 20  // 1MB
-	maxSSEStderrBytes = 256 * 1024 // 256KB
+	// defaultStreamBufferBytes bounds the output queued for one client. Output
+	// beyond it is dropped rather than stalling the container's pipes.
+	defaultStreamBufferBytes = 1 << 20
+	// defaultStreamWriteTimeout is how long one write to the client may block
+	// before the client is treated as gone.
+	defaultStreamWriteTimeout = 10 * time.Second
 )
 
-// SSEWriter implements io.Writer and flushes each write as a Server-Sent Event.
+// SSEWriter implements io.Writer and flushes each write as a Server-Sent Event,
+// framed by pkg/stream. It has no cap of its own: it is written what the
+// runner's sandbox.OutputBudget admitted, the same bytes the result keeps.
 type SSEWriter struct {
-	w       http.ResponseWriter
+	sw      *stream.Writer
 	flusher http.Flusher
 	event   string // SSE event type (e.g. "stdout", "stderr")
 	mu      sync.Mutex
-	written atomic.Int64
-	limit   int64
 }
 
 // NewSSEWriter creates an SSE writer for the given event type.
@@ -30,15 +39,10 @@ func NewSSEWriter(w http.ResponseWriter, event string) *SSEWriter {
 	if !ok {
 		return nil
 	}
-	limit := int64(maxSSEStdoutBytes)
-	if event == "stderr" {
-		limit = int64(maxSSEStderrBytes)
-	}
 	return &SSEWriter{
-		w:       w,
+		sw:      stream.NewWriter(w),
 		flusher: flusher,
 		event:   event,
-		limit:   limit,
 	}
 }
 
@@ -51,51 +55,235 @@ func (s *SSEWriter) Write(p []byte) (int, error) {
 		return 0, nil
 	}
 
-	// Enforce output limit (matches non-streaming caps).
-	if s.written.Load() >= s.limit {
-		return len(p), nil // silently drop
-	}
-	remaining := s.limit - s.written.Load()
-	data := p
-	if int64(len(data)) > remaining {
-		data = data[:remaining]
-	}
-	s.written.Add(int64(len(data)))
-
-	// SSE requires each line of a multi-line payload to have its own "data:" prefix.
-	// Without this, a newline in user output breaks the event boundary and could
-	// inject fake SSE events.
-	lines := strings.Split(string(data), "\n")
-	fmt.Fprintf(s.w, "event: %s\n", s.event)
-	for _, line := range lines {
-		fmt.Fprintf(s.w, "data: %s\n", line)
-	}
-	if _, err := fmt.Fprint(s.w, "\n"); err != nil {
+	if err := s.sw.WriteEvent(stream.Event{Type: s.event, Data: string(p)}); err != nil {
 		return 0, err
 	}
 	s.flusher.Flush()
 	return len(p), nil
 }
 
-// sanitizeSSEData replaces newlines in data to prevent SSE event injection.
-func sanitizeSSEData(s string) string {
-	s = strings.ReplaceAll(s, "\n", " ")
-	s = strings.ReplaceAll(s, "\r", " ")
+// sseChunk is one queued event. Output chunks count against the buffer.
+type sseChunk struct {
+	event  string
+	data   []byte
+	output bool
+}
+
+// sseStream decouples execution output from delivery to a client. The
+// runner writes into a bounded in-memory queue that never blocks, and a
+// sender goroutine drains it to the client, each write bounded by a
+// deadline. A client that can't keep up loses output chunks, counted by
+// stream and reported in the terminal event, instead of stalling the
+// container and pinning its slot; the runner's own capture is unaffected.
+type sseStream struct {
+	sw           *stream.Writer
+	rc           *http.ResponseController
+	out          map[string]*SSEWriter // stdout and stderr
+	writeTimeout time.Duration
+	limit        int
+	version      int // the wire format version the terminal event is sent in
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []sseChunk
+	queued   int  // output bytes in queue
+	used     bool // anything was queued or dropped
+	closed   bool
+	gone     bool // a write failed; everything after is dropped
+	dropped  map[string]int64
+	finished chan struct{}
+}
+
+// newSSEStream starts the sender for w, speaking version of the wire
+// format. Returns nil if w does not support flushing. bufferBytes and
+// writeTimeout fall back to the defaults when zero.
+func newSSEStream(w http.ResponseWriter, version, bufferBytes int, writeTimeout time.Duration) *sseStream {
+	stdout, stderr := NewSSEWriter(w, stream.EventStdout), NewSSEWriter(w, stream.EventStderr)
+	if stdout == nil || stderr == nil {
+		return nil
+	}
+	if bufferBytes <= 0 {
+		bufferBytes = defaultStreamBufferBytes
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = defaultStreamWriteTimeout
+	}
+	s := &sseStream{
+		sw:           stream.NewWriter(w),
+		rc:           http.NewResponseController(w),
+		out:          map[string]*SSEWriter{stream.EventStdout: stdout, stream.EventStderr: stderr},
+		writeTimeout: writeTimeout,
+		limit:        bufferBytes,
+		version:      version,
+		dropped:      make(map[string]int64),
+		finished:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
 	return s
 }
 
-// sendSSEDone sends a completion event with the final result as JSON.
-func sendSSEDone(w http.ResponseWriter, data string) {
-	if flusher, ok := w.(http.Flusher); ok {
-		fmt.Fprintf(w, "event: done\ndata: %s\n\n", sanitizeSSEData(data))
-		flusher.Flush()
+// Output returns the writer for an output event ("stdout" or "stderr").
+// Its writes never block and never fail, so an io.MultiWriter feeding the
+// runner's capture keeps going whatever the client does.
+func (s *sseStream) Output(event string) io.Writer {
+	return streamOutput{s: s, event: event}
+}
+
+type streamOutput struct {
+	s     *sseStream
+	event string
+}
+
+func (o streamOutput) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.s.enqueue(sseChunk{event: o.event, data: append([]byte(nil), p...), output: true})
 	}
+	return len(p), nil
 }
 
-// sendSSEError sends an error event.
-func sendSSEError(w http.ResponseWriter, errMsg string) {
-	if flusher, ok := w.(http.Flusher); ok {
-		fmt.Fprintf(w, "event: error\ndata: %s\n\n", sanitizeSSEData(errMsg))
-		flusher.Flush()
+// Event queues an event of another type, such as progress. It is dropped,
+// uncounted, if the client has gone or the buffer is full.
+func (s *sseStream) Event(event string, data []byte) {
+	s.enqueue(sseChunk{event: event, data: data})
+}
+
+func (s *sseStream) enqueue(c sseChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used = true
+	if s.closed {
+		return
+	}
+	if s.gone || s.queued+len(c.data) > s.limit {
+		if c.output {
+			s.dropped[c.event] += int64(len(c.data))
+		}
+		return
+	}
+	s.queue = append(s.queue, c)
+	if c.output {
+		s.queued += len(c.data)
+	}
+	s.cond.Signal()
+}
+
+// Dropped returns the output bytes dropped so far, by event.
+func (s *sseStream) Dropped() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := make(map[string]int64, len(s.dropped))
+	for event, n := range s.dropped {
+		if n > 0 {
+			dropped[event] = n
+		}
+	}
+	return dropped
+}
+
+// Finish queues the terminal event with v as its JSON data, in the
+// stream's version, skipping the buffer limit, and waits for the sender to
+// deliver everything queued or give up on the client. Nothing is written
+// to w once Finish returns.
+func (s *sseStream) Finish(event string, v any) {
+	data, _ := json.Marshal(stream.Payload(s.version, v))
+	s.mu.Lock()
+	s.used = true
+	if !s.closed && !s.gone {
+		s.queue = append(s.queue, sseChunk{event: event, data: data})
+	}
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-s.finished
+}
+
+// StopIfUnused stops the sender and reports true if nothing was ever
+// queued, so the caller can still answer with a plain error response.
+// Otherwise it does nothing and reports false.
+func (s *sseStream) StopIfUnused() bool {
+	s.mu.Lock()
+	if s.used {
+		s.mu.Unlock()
+		return false
+	}
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-s.finished
+	return true
+}
+
+func (s *sseStream) run() {
+	defer close(s.finished)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 { // closed and drained
+			s.mu.Unlock()
+			return
+		}
+		batch := s.queue
+		s.queue, s.queued = nil, 0
+		gone := s.gone
+		s.mu.Unlock()
+
+		for i, c := range batch {
+			if !gone {
+				if err := s.send(c); err == nil {
+					continue
+				}
+				gone = true
+			}
+			s.mu.Lock()
+			s.gone = true
+			for _, rest := range batch[i:] {
+				if rest.output {
+					s.dropped[rest.event] += int64(len(rest.data))
+				}
+			}
+			s.mu.Unlock()
+			break
+		}
+	}
+}
+
+// send writes one chunk under the write deadline. Writers that can't take
+// a deadline (a test recorder) just write.
+func (s *sseStream) send(c sseChunk) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if sw, ok := s.out[c.event]; ok && c.output {
+		if _, err := sw.Write(c.data); err != nil {
+			return err
+		}
+	} else if err := s.sw.WriteEvent(stream.Event{Type: c.event, Data: string(c.data)}); err != nil {
+		return err
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// newDoneV2 is the done event of result, which ended in st: done with its
+// security events and what version 2 adds.
+func newDoneV2(result *sandbox.ExecutionResult, st state.State, done stream.Done) *stream.DoneV2 {
+	if len(result.SecurityEvents) > 0 {
+		done.SecurityEvents = newSecurityEvents(result.SecurityEvents)
+	}
+	return &stream.DoneV2{
+		Done:     done,
+		Status:   string(st),
+		Security: stream.Summarize(done.SecurityEvents),
+		Usage: stream.Usage{
+			CPUTimeMS:    result.ResourceUsage.CPUTimeMS,
+			MemoryPeakMB: result.ResourceUsage.MemoryPeakMB,
+			PidsUsed:     result.ResourceUsage.PidsUsed,
+		},
+		DebugTrace: newDebugTrace(result.DebugTrace),
 	}
 }
@@ -0,0 +1,339 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/pkg/stream"
+)
+
+// slowWriter is a ResponseWriter whose client reads nothing until release
+// is closed. With honorDeadline, a write blocked past the deadline fails the
+// way a net.Conn write does.
+type slowWriter struct {
+	header        http.Header
+	release       chan struct{}
+	honorDeadline bool
+
+	mu       sync.Mutex
+	deadline time.Time
+	buf      bytes.Buffer
+}
+
+func newSlowWriter(honorDeadline bool) *slowWriter {
+	return &slowWriter{header: make(http.Header), release: make(chan struct{}), honorDeadline: honorDeadline}
+}
+
+func (w *slowWriter) Header() http.Header { return w.header }
+func (w *slowWriter) WriteHeader(int)     {}
+func (w *slowWriter) Flush()              {}
+
+func (w *slowWriter) SetWriteDeadline(t time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deadline = t
+	return nil
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	deadline := w.deadline
+	w.mu.Unlock()
+
+	var expired <-chan time.Time
+	if w.honorDeadline && !deadline.IsZero() {
+		expired = time.After(time.Until(deadline))
+	}
+	select {
+	case <-w.release:
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *slowWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// floodBackend writes total bytes of stdout in chunks and records how long
+// its writes took. With err set it then fails without a result.
+type floodBackend struct {
+	total, chunk int
+	returned     chan struct{}
+	elapsed      time.Duration
+	err          error
+}
+
+func (b *floodBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	return b.ExecuteStreaming(ctx, req, io.Discard, io.Discard)
+}
+
+func (b *floodBackend) ExecuteStreaming(_ context.Context, req sandbox.ExecutionRequest, stdout, _ io.Writer) (*sandbox.ExecutionResult, error) {
+	defer close(b.returned)
+	chunk := bytes.Repeat([]byte("x"), b.chunk)
+	start := time.Now()
+	for written := 0; written < b.total; written += b.chunk {
+		if _, err := stdout.Write(chunk); err != nil {
+			return nil, err
+		}
+	}
+	b.elapsed = time.Since(start)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &sandbox.ExecutionResult{ID: req.ID, ExitClass: sandbox.ExitUser}, nil
+}
+
+func (b *floodBackend) Close() error { return nil }
+func (b *floodBackend) Name() string { return "docker" }
+
+func streamTo(h *Handlers, w http.ResponseWriter) <-chan struct{} {
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		body := strings.NewReader(`{"language":"python","code":"print('x' * 10**6)"}`)
+		h.HandleExecuteStream(w, httptest.NewRequest(http.MethodPost, "/execute/stream", body))
+	}()
+	return finished
+}
+
+// readEvents parses a complete SSE body.
+func readEvents(t *testing.T, body string) []stream.Event {
+	t.Helper()
+	r := stream.NewReader(strings.NewReader(body))
+	var events []stream.Event
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("reading events: %v\n%.500s", err, body)
+		}
+		events = append(events, e)
+	}
+}
+
+// doneEvent returns the done event's payload from an SSE body.
+func doneEvent(t *testing.T, body string) stream.Done {
+	t.Helper()
+	for _, e := range readEvents(t, body) {
+		if e.Type == stream.EventDone {
+			var done stream.Done
+			if err := e.Decode(&done); err != nil {
+				t.Fatal(err)
+			}
+			return done
+		}
+	}
+	t.Fatalf("no done event:\n%.500s", body)
+	return stream.Done{}
+}
+
+// stdoutBytes counts the stdout payload delivered in an SSE body.
+func stdoutBytes(t *testing.T, body string) int {
+	n := 0
+	for _, e := range readEvents(t, body) {
+		if e.Type == stream.EventStdout {
+			n += len(e.Data)
+		}
+	}
+	return n
+}
+
+func TestHandleExecuteStream_SlowClientDoesNotBlockOutput(t *testing.T) {
+	backend := &floodBackend{total: 512 << 10, chunk: 4 << 10, returned: make(chan struct{})}
+	h := newTestHandlers(backend)
+	h.streamBufferBytes = 64 << 10
+	w := newSlowWriter(false)
+
+	finished := streamTo(h, w)
+	select {
+	case <-backend.returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("output writes blocked on a client that isn't reading")
+	}
+	close(w.release)
+	<-finished
+
+	body := w.String()
+	done := doneEvent(t, body)
+	n := done.DroppedBytes["stdout"]
+	if n == 0 {
+		t.Fatalf("done event reports no dropped bytes: %+v", done)
+	}
+	if delivered := stdoutBytes(t, body); delivered+int(n) != backend.total {
+		t.Errorf("delivered %d + dropped %d != written %d", delivered, n, backend.total)
+	}
+	if _, ok := done.DroppedBytes["stderr"]; ok {
+		t.Errorf("stderr drops reported with no stderr output: %v", done.DroppedBytes)
+	}
+}
+
+func TestHandleExecuteStream_StalledClientTimesOut(t *testing.T) {
+	backend := &floodBackend{total: 256 << 10, chunk: 4 << 10, returned: make(chan struct{})}
+	h := newTestHandlers(backend)
+	h.streamBufferBytes = 16 << 10
+	h.streamWriteTimeout = 50 * time.Millisecond
+	w := newSlowWriter(true) // never released
+
+	select {
+	case <-streamTo(h, w):
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still waiting on a client past the write deadline")
+	}
+	if backend.elapsed > time.Second {
+		t.Errorf("output writes took %s", backend.elapsed)
+	}
+}
+
+func TestHandleExecuteStream_FastClientGetsEverything(t *testing.T) {
+	backend := &floodBackend{total: 128 << 10, chunk: 1 << 10, returned: make(chan struct{})}
+	h := newTestHandlers(backend)
+	rec := httptest.NewRecorder()
+	<-streamTo(h, rec)
+
+	body := rec.Body.String()
+	if done := doneEvent(t, body); done.DroppedBytes != nil {
+		t.Errorf("done event reports drops for a recorder: %.300s", body)
+	}
+	if got := stdoutBytes(t, body); got != backend.total {
+		t.Errorf("delivered %d bytes, want %d", got, backend.total)
+	}
+}
+
+func TestHandleExecuteStream_ErrorEvent(t *testing.T) {
+	backend := &floodBackend{total: 1 << 10, chunk: 1 << 10, returned: make(chan struct{}), err: sandbox.ErrTimeout}
+	h := newTestHandlers(backend)
+	rec := httptest.NewRecorder()
+	<-streamTo(h, rec)
+
+	events := readEvents(t, rec.Body.String())
+	if len(events) == 0 || events[len(events)-1].Type != stream.EventError {
+		t.Fatalf("stream doesn't end with an error event: %q", events)
+	}
+	var got stream.Error
+	if err := events[len(events)-1].Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "EXECUTION_TIMEOUT" || got.Message != "execution timed out" {
+		t.Errorf("error event = %+v", got)
+	}
+}
+
+func TestSSEStream_OutputNeverFailsAfterClientGone(t *testing.T) {
+	w := newSlowWriter(true)
+	s := newSSEStream(w, stream.DefaultVersion, 1<<10, 10*time.Millisecond)
+	out := s.Output("stderr")
+	for i := 0; i < 100; i++ {
+		if n, err := out.Write([]byte("0123456789")); n != 10 || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.Finish(stream.EventDone, stream.Done{})
+	if got := s.Dropped()["stderr"]; got == 0 || got > 1000 {
+		t.Errorf("dropped %d stderr bytes, want some of 1000", got)
+	}
+}
+
+func TestHandleExecuteStream_Versions(t *testing.T) {
+	backend := &mockBackend{result: &sandbox.ExecutionResult{ID: "x", Argv: []string{"python3"}, ExitClass: sandbox.ExitUser,
+		ResourceUsage:  sandbox.ResourceUsage{CPUTimeMS: 40, MemoryPeakMB: 12, PidsUsed: 1},
+		SecurityEvents: []sandbox.SecurityEvent{{Type: "network_attempt", Source: sandbox.SourceRuntime, Severity: "high", Detail: "connect"}}}}
+	h := newTestHandlers(backend)
+	h.retainExecutions(1000)
+	send := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/execute/stream", strings.NewReader(`{"language":"python","code":"print(1)"}`))
+		if accept != "" {
+			req.Header.Set(stream.AcceptVersionHeader, accept)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleExecuteStream(rec, req)
+		return rec
+	}
+
+	for _, tt := range []struct {
+		accept, version string
+	}{{"", "1"}, {"1", "1"}, {"2", "2"}} {
+		rec := send(tt.accept)
+		if got := rec.Header().Get(stream.VersionHeader); rec.Code != http.StatusOK || got != tt.version {
+			t.Fatalf("Accept-Stream-Version %q: status %d, version %q; want %s", tt.accept, rec.Code, got, tt.version)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(lastEvent(t, rec.Body.String()).Data), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["status"]; ok != (tt.version == "2") {
+			t.Errorf("Accept-Stream-Version %q: done event %s", tt.accept, rec.Body)
+		}
+	}
+
+	var done stream.DoneV2
+	if err := lastEvent(t, send("2").Body.String()).Decode(&done); err != nil {
+		t.Fatal(err)
+	}
+	want := stream.SecuritySummary{Events: 1, HighestSeverity: "high", Types: []string{"network_attempt"}}
+	if done.ID != "x" || done.Status != string(state.Completed) || !reflect.DeepEqual(done.Security, want) ||
+		done.Usage != (stream.Usage{CPUTimeMS: 40, MemoryPeakMB: 12, PidsUsed: 1}) || done.RetrievalURL != "http://example.com/executions/x" {
+		t.Errorf("v2 done = %+v", done)
+	}
+
+	backend.req = sandbox.ExecutionRequest{}
+	rec := send("3")
+	var body apierror.Response
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotAcceptable || body.Code != apierror.CodeUnknownStreamVersion || !reflect.DeepEqual(body.Details["supported"], []any{1.0, 2.0}) {
+		t.Errorf("unsupported version: status %d, %+v", rec.Code, body)
+	}
+	if rec.Header().Get(stream.VersionHeader) != "" || backend.req.Code != "" {
+		t.Error("an unsupported version started a stream")
+	}
+}
+
+func TestHandleExecuteStream_ErrorEventV2(t *testing.T) {
+	h := newTestHandlers(&floodBackend{total: 1 << 10, chunk: 1 << 10, returned: make(chan struct{}), err: sandbox.ErrTimeout})
+	req := httptest.NewRequest(http.MethodPost, "/execute/stream", strings.NewReader(`{"language":"python","code":"print(1)"}`))
+	req.Header.Set(stream.AcceptVersionHeader, "2")
+	rec := httptest.NewRecorder()
+	h.HandleExecuteStream(rec, req)
+
+	var got stream.ErrorV2
+	if err := lastEvent(t, rec.Body.String()).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "EXECUTION_TIMEOUT" || got.Status != string(state.Timeout) || got.RetrievalURL != "" {
+		t.Errorf("v2 error event = %+v", got)
+	}
+}
+
+// lastEvent returns the last event of an SSE body.
+func lastEvent(t *testing.T, body string) stream.Event {
+	t.Helper()
+	events := readEvents(t, body)
+	if len(events) == 0 {
+		t.Fatalf("no events:\n%.500s", body)
+	}
+	return events[len(events)-1]
+}
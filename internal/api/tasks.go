@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// validTaskID is the task_id format: what a client would generate (a UUID,
+// a ULID, "ticket-123:attempt-2"), nothing needing escaping in a URL.
+var validTaskID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+// taskIdleTTL is how long a task's execution count is kept after its last
+// execution; a task that comes back later starts over.
+const taskIdleTTL = 24 * time.Hour
+
+// taskLimiter counts the executions each caller runs under each task_id
+// and refuses those past security.max_task_executions, to stop an agent
+// looping on a task it can't finish. Counts are kept in memory only.
+type taskLimiter struct {
+	max int
+	now func() time.Time
+
+	mu        sync.Mutex
+	tasks     map[taskKey]*taskCount
+	lastSweep time.Time
+}
+
+type taskKey struct{ owner, id string }
+
+type taskCount struct {
+	n    int
+	last time.Time
+}
+
+func newTaskLimiter(limit int) *taskLimiter {
+	return &taskLimiter{max: limit, now: time.Now, tasks: make(map[taskKey]*taskCount)}
+}
+
+// admit counts one execution of the task, or returns false and how long
+// until its count is forgotten when the task has had its max.
+func (l *taskLimiter) admit(owner, id string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, c := range l.tasks {
+			if now.Sub(c.last) >= taskIdleTTL {
+				delete(l.tasks, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := taskKey{owner, id}
+	c, ok := l.tasks[key]
+	if !ok || now.Sub(c.last) >= taskIdleTTL {
+		c = &taskCount{}
+		l.tasks[key] = c
+	}
+	if c.n >= l.max {
+		return c.last.Add(taskIdleTTL).Sub(now), false
+	}
+	c.n++
+	c.last = now
+	return 0, true
+}
+
+// checkTaskID writes the error and returns false for a task_id that isn't
+// empty or in the validTaskID format.
+func checkTaskID(w http.ResponseWriter, r *http.Request, id string) bool {
+	if id == "" || validTaskID.MatchString(id) {
+		return true
+	}
+	apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "task_id must be 1-128 letters, digits, '.', '_', ':' or '-', starting with a letter or digit"))
+	return false
+}
+
+// admitTask counts the execution against its task's ceiling. It writes the
+// 429 and returns false when the task has run out.
+func (h *Handlers) admitTask(w http.ResponseWriter, r *http.Request, taskID string) bool {
+	if h.tasks == nil || taskID == "" {
+		return true
+	}
+	retryAfter, ok := h.tasks.admit(workspaceOwner(r), taskID)
+	if ok {
+		return true
+	}
+	writeThrottled(w, r, h.metrics, apierror.Newf(apierror.CodeTaskLimitReached,
+		"task %s has run its %d executions", taskID, h.tasks.max).
+		WithDetails(map[string]any{"task_id": taskID}), apierror.Throttle{
+		Scope: apierror.ScopeTask, RetryAfter: retryAfter, Limit: float64(h.tasks.max),
+	})
+	return false
+}
+
+// HandleGetTask serves GET /tasks/{id}: the caller's executions under the
+// task_id, aggregated.
+func (h *Handlers) HandleGetTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validTaskID.MatchString(id) {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid task ID"))
+		return
+	}
+
+	owner := workspaceOwner(r)
+	var summary *storage.TaskSummary
+	if h.db != nil {
+		var err error
+		summary, err = h.db.TaskSummary(r.Context(), id, owner)
+		if err != nil {
+			log.Error().Err(err).Msg("task summary query failed")
+			apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "query failed"))
+			return
+		}
+	} else {
+		summary = storage.AggregateTask(h.recent.snapshot(), id, owner)
+		summary.Partial = true
+	}
+	if summary.Executions == 0 {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeNotFound, "task not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
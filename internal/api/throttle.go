@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/monitor"
+)
+
+const (
+	// noRefillRetryAfter is the Retry-After of a rate limit bucket that never
+	// refills (security.rate_limit_rps of 0): there is no next token to wait
+	// for, so clients are asked to back off a long way.
+	noRefillRetryAfter = time.Minute
+	// claudeSamples is how many recent claude session durations the claude
+	// gate averages for its Retry-After.
+	claudeSamples = 32
+	// defaultClaudeRetryAfter is the claude gate's Retry-After before any
+	// session has finished.
+	defaultClaudeRetryAfter = 30 * time.Second
+)
+
+// writeThrottled writes e, a 429, with t's Retry-After and throttle details,
+// and counts it under t's scope. metrics may be nil.
+func writeThrottled(w http.ResponseWriter, r *http.Request, metrics *monitor.Metrics, e *apierror.Error, t apierror.Throttle) {
+	if metrics != nil {
+		metrics.Throttled(t.Scope)
+	}
+	apierror.WriteThrottled(w, r, e, t)
+}
+
+// rateBucket is one client's token bucket in RateLimitMiddleware.
+type rateBucket struct {
+	tokens    float64
+	lastCheck time.Time
+}
+
+// refill adds the tokens earned since the last check, up to burst.
+func (b *rateBucket) refill(now time.Time, rps float64, burst int) {
+	b.tokens += now.Sub(b.lastCheck).Seconds() * rps
+	b.lastCheck = now
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+}
+
+// nextToken is how long until the bucket holds a whole token again.
+func (b *rateBucket) nextToken(rps float64) time.Duration {
+	switch {
+	case b.tokens >= 1:
+		return 0
+	case rps <= 0:
+		return noRefillRetryAfter
+	default:
+		return time.Duration((1 - b.tokens) / rps * float64(time.Second))
+	}
+}
+
+// claudeGate caps concurrent claude sessions. It remembers when each running
+// session started and how long recent ones took, so a rejected caller is told
+// when the first running session should finish.
+type claudeGate struct {
+	max int
+	now func() time.Time
+
+	mu        sync.Mutex
+	running   map[uint64]time.Time // start time by session
+	nextID    uint64
+	durations []time.Duration // ring of recent session durations
+	next      int
+}
+
+func newClaudeGate(maxConcurrent int) *claudeGate {
+	return &claudeGate{max: maxConcurrent, now: time.Now, running: make(map[uint64]time.Time)}
+}
+
+// enter admits a session, returning the func that ends it, or reports how
+// long until a session is expected to end.
+func (g *claudeGate) enter() (func(), time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.running) >= g.max {
+		return nil, g.retryAfterLocked(), false
+	}
+	id := g.nextID
+	g.nextID++
+	g.running[id] = g.now()
+	return func() { g.leave(id) }, 0, true
+}
+
+// contextKeyClaudeSlot carries the *claudeSlot of a claude request the
+// gate admitted.
+const contextKeyClaudeSlot contextKey = "claude_slot"
+
+// claudeSlot is a request's place at the gate, given up when its handler
+// returns unless an execution that outlives the request has taken it.
+type claudeSlot struct {
+	mu    sync.Mutex
+	leave func()
+}
+
+func (s *claudeSlot) release() {
+	if leave := s.take(); leave != nil {
+		leave()
+	}
+}
+
+func (s *claudeSlot) take() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leave := s.leave
+	s.leave = nil
+	return leave
+}
+
+// takeClaudeSlot takes the slot of the request ctx is from, returning the
+// func that gives it up; a no-op for a request that holds none.
+func takeClaudeSlot(ctx context.Context) func() {
+	if slot, ok := ctx.Value(contextKeyClaudeSlot).(*claudeSlot); ok {
+		if leave := slot.take(); leave != nil {
+			return leave
+		}
+	}
+	return func() {}
+}
+
+func (g *claudeGate) leave(id uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d := g.now().Sub(g.running[id])
+	delete(g.running, id)
+	if len(g.durations) < claudeSamples {
+		g.durations = append(g.durations, d)
+		return
+	}
+	g.durations[g.next] = d
+	g.next = (g.next + 1) % claudeSamples
+}
+
+// occupancy returns the sessions running and the most that may.
+func (g *claudeGate) occupancy() (inUse, capacity int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.running), g.max
+}
+
+// retryAfterLocked is the time left, on the rolling average duration, for
+// the oldest running session. A session already past the average is due
+// any moment. With no finished sessions to average it is a flat guess.
+func (g *claudeGate) retryAfterLocked() time.Duration {
+	if len(g.durations) == 0 {
+		return defaultClaudeRetryAfter
+	}
+	var sum time.Duration
+	for _, d := range g.durations {
+		sum += d
+	}
+	avg := sum / time.Duration(len(g.durations))
+
+	now := g.now()
+	soonest := avg
+	for _, start := range g.running {
+		soonest = min(soonest, max(avg-now.Sub(start), 0))
+	}
+	return soonest
+}
+
+// rateWindow counts the rate limiter's decisions over the last minute, in
+// one-second buckets, for GET /stats. A nil window counts nothing.
+type rateWindow struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	seconds [60]rateSecond
+}
+
+type rateSecond struct {
+	at                int64 // Unix second the counts are for
+	allowed, rejected int
+}
+
+func newRateWindow() *rateWindow {
+	return &rateWindow{now: time.Now}
+}
+
+// record counts a request the limiter let through or, rejected, refused.
+func (w *rateWindow) record(rejected bool) {
+	if w == nil {
+		return
+	}
+	now := w.now().Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &w.seconds[now%int64(len(w.seconds))]
+	if s.at != now {
+		*s = rateSecond{at: now}
+	}
+	if rejected {
+		s.rejected++
+	} else {
+		s.allowed++
+	}
+}
+
+// lastMinute sums the counts of the last 60 seconds, this one included.
+func (w *rateWindow) lastMinute() (allowed, rejected int) {
+	now := w.now().Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, s := range w.seconds {
+		if now-s.at < int64(len(w.seconds)) {
+			allowed += s.allowed
+			rejected += s.rejected
+		}
+	}
+	return allowed, rejected
+}
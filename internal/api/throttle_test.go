@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// checkThrottled decodes a 429 and checks its Retry-After header says what
+// its body does, for scope.
+func checkThrottled(t *testing.T, rec *httptest.ResponseRecorder, code apierror.Code, scope string) apierror.Response {
+	t.Helper()
+	var resp apierror.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusTooManyRequests || resp.Code != code || resp.Details["scope"] != scope {
+		t.Fatalf("status %d code %s scope %v, want 429 %s %s", rec.Code, resp.Code, resp.Details["scope"], code, scope)
+	}
+	ms, ok := resp.Details["retry_after_ms"].(float64)
+	header, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if !ok || err != nil || ms < 1000 || header != int(math.Ceil(ms/1000)) {
+		t.Errorf("Retry-After %q with retry_after_ms %v", rec.Header().Get("Retry-After"), resp.Details["retry_after_ms"])
+	}
+	for _, key := range []string{"limit", "remaining"} {
+		if _, ok := resp.Details[key].(float64); !ok {
+			t.Errorf("details lack %s: %v", key, resp.Details)
+		}
+	}
+	return resp
+}
+
+func TestWriteThrottled_HeaderMatchesBody(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		wantHeader string
+		wantMS     float64
+	}{
+		{0, "1", 1000},
+		{300 * time.Millisecond, "1", 1000},
+		{time.Second, "1", 1000},
+		{1200 * time.Millisecond, "2", 1200},
+		{95 * time.Second, "95", 95000},
+		{95*time.Second + 400*time.Microsecond, "95", 95000},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		e := apierror.New(apierror.CodeLanguageSaturated, "busy").WithDetails(map[string]any{"pool": "claude"})
+		apierror.WriteThrottled(rec, httptest.NewRequest(http.MethodPost, "/execute", nil), e,
+			apierror.Throttle{Scope: apierror.ScopeLanguage, RetryAfter: tt.retryAfter, Limit: 4})
+		resp := checkThrottled(t, rec, apierror.CodeLanguageSaturated, apierror.ScopeLanguage)
+		if rec.Header().Get("Retry-After") != tt.wantHeader || resp.Details["retry_after_ms"] != tt.wantMS {
+			t.Errorf("%s: Retry-After %q, retry_after_ms %v; want %s, %g", tt.retryAfter,
+				rec.Header().Get("Retry-After"), resp.Details["retry_after_ms"], tt.wantHeader, tt.wantMS)
+		}
+		if resp.Details["pool"] != "claude" || resp.Details["limit"] != 4.0 || resp.Details["remaining"] != 0.0 {
+			t.Errorf("details %v", resp.Details)
+		}
+	}
+}
+
+func TestRateBucket_NextToken(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name   string
+		tokens float64
+		rps    float64
+		want   time.Duration
+	}{
+		{"token available", 1, 2, 0},
+		{"empty", 0, 2, 500 * time.Millisecond},
+		{"partly refilled", 0.25, 0.5, 1500 * time.Millisecond},
+		{"never refills", 0, 0, noRefillRetryAfter},
+	}
+	for _, tt := range tests {
+		b := rateBucket{tokens: tt.tokens, lastCheck: start}
+		if got := b.nextToken(tt.rps); got != tt.want {
+			t.Errorf("%s: nextToken = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Refilling for a second at 0.5 rps earns half a token, capped at burst.
+	b := rateBucket{tokens: 0, lastCheck: start}
+	b.refill(start.Add(time.Second), 0.5, 5)
+	if got := b.nextToken(0.5); got != time.Second {
+		t.Errorf("after refill: nextToken = %s, want 1s", got)
+	}
+	b.refill(start.Add(time.Hour), 0.5, 5)
+	if b.tokens != 5 {
+		t.Errorf("tokens = %g, want capped at 5", b.tokens)
+	}
+}
+
+func TestClaudeGate_RetryAfter(t *testing.T) {
+	now := time.Unix(10000, 0)
+	g := newClaudeGate(2)
+	g.now = func() time.Time { return now }
+
+	leave, _, ok := g.enter()
+	if !ok {
+		t.Fatal("first session refused")
+	}
+	if _, _, ok := g.enter(); !ok {
+		t.Fatal("second session refused")
+	}
+	// Nothing has finished yet, so there is no average to go on.
+	if _, retryAfter, ok := g.enter(); ok || retryAfter != defaultClaudeRetryAfter {
+		t.Fatalf("third session: ok %v retry after %s, want refused with %s", ok, retryAfter, defaultClaudeRetryAfter)
+	}
+
+	// Sessions of 60s and 120s average 90s. One running session started
+	// 80s ago, the other 30s ago: the first is due in 10s.
+	g.durations = []time.Duration{60 * time.Second, 120 * time.Second}
+	g.running = map[uint64]time.Time{1: now.Add(-80 * time.Second), 2: now.Add(-30 * time.Second)}
+	if _, retryAfter, _ := g.enter(); retryAfter != 10*time.Second {
+		t.Errorf("retry after %s, want 10s", retryAfter)
+	}
+	// A session past the average is due any moment.
+	g.running[1] = now.Add(-5 * time.Minute)
+	if _, retryAfter, _ := g.enter(); retryAfter != 0 {
+		t.Errorf("overdue session: retry after %s, want 0", retryAfter)
+	}
+
+	// Leaving records the session's duration.
+	g = newClaudeGate(1)
+	g.now = func() time.Time { return now }
+	leave, _, _ = g.enter()
+	now = now.Add(45 * time.Second)
+	leave()
+	if len(g.durations) != 1 || g.durations[0] != 45*time.Second || len(g.running) != 0 {
+		t.Errorf("after leave: durations %v, running %v", g.durations, g.running)
+	}
+}
+
+// TestThrottle_Scopes checks every 429 the server sends carries a
+// Retry-After matching its body, and is counted under its scope.
+func TestThrottle_Scopes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	claudeBody := func() *bytes.Reader { return bytes.NewReader([]byte(`{"language":"claude","code":"hi"}`)) }
+
+	t.Run("ip", func(t *testing.T) {
+		metrics := monitor.NewMetrics()
+		limited := RateLimitMiddleware(0.5, 1, metrics)(ok)
+		limited.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		resp := checkThrottled(t, rec, apierror.CodeRateLimited, apierror.ScopeIP)
+		if resp.Details["limit"] != 1.0 || rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("details %v, Retry-After %q; want limit 1 and a token in 2s", resp.Details, rec.Header().Get("Retry-After"))
+		}
+		if got := metricValue(t, metrics.Throttles.WithLabelValues(apierror.ScopeIP)); got != 1 {
+			t.Errorf("throttled{ip} = %g", got)
+		}
+	})
+
+	t.Run("claude", func(t *testing.T) {
+		metrics := monitor.NewMetrics()
+		rec := httptest.NewRecorder()
+		ConcurrentClaudeMiddleware(0, metrics)(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/execute", claudeBody()))
+		resp := checkThrottled(t, rec, apierror.CodeClaudeLimitReached, apierror.ScopeClaude)
+		if resp.Details["retry_after_ms"] != float64(defaultClaudeRetryAfter.Milliseconds()) {
+			t.Errorf("details %v", resp.Details)
+		}
+		if got := metricValue(t, metrics.Throttles.WithLabelValues(apierror.ScopeClaude)); got != 1 {
+			t.Errorf("throttled{claude} = %g", got)
+		}
+	})
+
+	t.Run("language", func(t *testing.T) {
+		saturated := &sandbox.ExecutionError{ExecID: "x", Op: "acquire_slot",
+			Err: &sandbox.SaturationError{Pool: "claude", Size: 3, RetryAfter: 95 * time.Second}}
+		h := newTestHandlers(&mockBackend{err: saturated})
+		rec := httptest.NewRecorder()
+		h.HandleExecute(rec, httptest.NewRequest(http.MethodPost, "/execute", claudeBody()))
+		resp := checkThrottled(t, rec, apierror.CodeLanguageSaturated, apierror.ScopeLanguage)
+		if resp.Details["limit"] != 3.0 || resp.Details["retry_after_ms"] != 95000.0 || resp.Details["pool"] != "claude" {
+			t.Errorf("details %v", resp.Details)
+		}
+		if got := metricValue(t, h.metrics.Throttles.WithLabelValues(apierror.ScopeLanguage)); got != 1 {
+			t.Errorf("throttled{language} = %g", got)
+		}
+	})
+}
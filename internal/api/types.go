@@ -1,15 +1,162 @@
 package api
 
-import "time"
+import (
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/codebundle"
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/version"
+	"safe-agent-sandbox/pkg/stream"
+)
 
 // ExecutionRequest is the API-level request to execute code in a sandbox.
 type ExecutionRequest struct {
-	Code     string         `json:"code"`
-	Language string         `json:"language"` // python, node, bash, claude
-	Timeout  Duration       `json:"timeout,omitempty"`
-	Limits   ResourceLimits `json:"limits,omitempty"`
-	Perms    Permissions    `json:"permissions,omitempty"`
-	WorkDir  string         `json:"work_dir,omitempty"` // Host directory to mount (claude runtime)
+	Code         string         `json:"code"`
+	Language     string         `json:"language"` // python, node, bash, go, ruby, deno, bun, claude
+	Timeout      Duration       `json:"timeout,omitempty"`
+	Deadline     *time.Time     `json:"deadline,omitempty"` // RFC3339; instead of timeout, which it is converted to against the server's clock
+	Limits       ResourceLimits `json:"limits,omitempty"`
+	Perms        Permissions    `json:"permissions,omitempty"`
+	WorkDir      string         `json:"work_dir,omitempty"`      // Host directory to mount (claude runtime)
+	WorkspaceID  string         `json:"workspace_id,omitempty"`  // Workspace from POST /workspaces, mounted at /workspace
+	Chaos        *ChaosRequest  `json:"chaos,omitempty"`         // Failure injection (requires sandbox.chaos.enabled)
+	SharedMounts []string       `json:"shared_mounts,omitempty"` // Names from sandbox.shared_mounts, attached read-only
+	UseCaches    bool           `json:"use_caches,omitempty"`    // Mount the persistent npm/pip caches (claude only)
+	Args         []string       `json:"args,omitempty"`          // Passed to the program after the code file (not claude)
+	Cwd          string         `json:"cwd,omitempty"`           // /workspace, /tmp or one of permissions.filesystem.writable_dirs
+	Claude       *ClaudeOptions `json:"claude,omitempty"`        // Tool, turn and model restrictions (claude only)
+	TaskID       string         `json:"task_id,omitempty"`       // Client-chosen ID grouping the executions of one agent task; see GET /tasks/{id}
+
+	// Run a bundle from POST /bundles instead of code: its manifest's
+	// entrypoint, in its language, with its files read-only at /workspace.
+	// Mutually exclusive with code, workspace_id and work_dir.
+	BundleDigest string `json:"bundle_digest,omitempty"`
+
+	// Stop the execution, as exit class idle_timeout, once it has written
+	// nothing to stdout or stderr for this long. At most
+	// sandbox.max_idle_output_timeout; omitted, it is never stopped for
+	// going quiet.
+	IdleOutputTimeout Duration `json:"idle_output_timeout,omitempty"`
+
+	// Bill a claude execution to the caller's Anthropic account instead of
+	// the server's (security.claude_tokens). At most one may be set.
+	ClaudeToken      string `json:"claude_token,omitempty"`      // An API key or OAuth token; never stored, logged or passed to the container
+	ClaudeCredential string `json:"claude_credential,omitempty"` // Name of a token in security.claude_tokens.credentials
+
+	// Share the execution of an identical submission already running, if
+	// sandbox.dedup has a window. Omitted, callers in sandbox.dedup.keys
+	// share and others don't.
+	AllowDedup *bool `json:"allow_dedup,omitempty"`
+
+	// Client-chosen name the execution can be cancelled by, with every other
+	// of the caller's live executions under it, with
+	// DELETE /cancellation-groups/{name}. Same format as task_id.
+	CancellationGroup string `json:"cancellation_group,omitempty"`
+
+	// Return stdout and stderr as one output, in the order they were
+	// written as far as the runner can tell, with output_events marking
+	// which stream each run of bytes came from. stderr is then empty. A
+	// stream's events are unchanged: they already arrive in that order.
+	MergeOutput bool `json:"merge_output,omitempty"`
+
+	// Set TZ and LANG: an IANA zone name the runtime image has, and a
+	// UTF-8 locale such as en_US.UTF-8. They replace a TZ or LANG in
+	// permissions.environment. Omitted, LANG is C.UTF-8 and the zone the
+	// image's, UTC in the stock ones.
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+
+	// Run the code under strace and return what it recorded of the file
+	// and network syscalls as debug_trace. Only for callers in
+	// sandbox.debug_trace.keys; never for claude.
+	DebugTrace bool `json:"debug_trace,omitempty"`
+
+	// Written to the program's standard input, which is then closed. At
+	// most sandbox.max_stdin_bytes; never for claude.
+	Stdin string `json:"stdin,omitempty"`
+
+	// Answer as soon as the execution is queued, with its ID, and run it
+	// on its own; GET /executions/{id} has its result once it ends. Also
+	// asked for with ?async=true or POST /execute/async.
+	Async bool `json:"async,omitempty"`
+
+	bundle *codebundle.Contents // bundle_digest's files, read and verified by resolveBundle
+}
+
+// ClaudeOptions restrict a claude session. The type lives in pkg/stream, like
+// the other types that appear in stream events.
+type ClaudeOptions = stream.ClaudeOptions
+
+// ChaosRequest asks the server to synthesize a failure instead of running code.
+// Mode is one of timeout, oom, rate_limited, infra_error, slow_stream.
+type ChaosRequest struct {
+	Mode  string   `json:"mode"`
+	Delay Duration `json:"delay,omitempty"`
+}
+
+// WorkspaceResponse describes a workspace created by POST /workspaces.
+type WorkspaceResponse struct {
+	ID            string    `json:"id"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	MaxFileBytes  int64     `json:"max_file_bytes"`
+	MaxTotalBytes int64     `json:"max_total_bytes"`
+}
+
+// BundleResponse describes a bundle in the registry, as POST /bundles
+// stores it and POST /admin/bundles/{digest} changes it.
+type BundleResponse struct {
+	Digest     string    `json:"digest"` // "sha256:" and the hex SHA-256 of the canonical archive
+	Entrypoint string    `json:"entrypoint"`
+	Language   string    `json:"language"`
+	SizeBytes  int64     `json:"size_bytes"`
+	Files      int       `json:"files"`
+	Approved   bool      `json:"approved"`
+	Pinned     bool      `json:"pinned"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// CancelGroupResponse is returned by DELETE /cancellation-groups/{name}: the
+// executions it cancelled, each in the state it was in.
+type CancelGroupResponse struct {
+	CancellationGroup string               `json:"cancellation_group"`
+	Cancelled         []CancelledExecution `json:"cancelled"`
+}
+
+// KillExecutionResponse is returned by DELETE /executions/{id}.
+type KillExecutionResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // kill_requested
+	State  string `json:"state"`  // queued or running, before the kill
+}
+
+// AsyncExecutionResponse is returned by an asynchronous POST /execute for
+// the execution it queued, and by GET /executions/{id} while that is still
+// going.
+type AsyncExecutionResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // queued or running
+}
+
+// CancelledExecution is an execution a cancellation of its group killed.
+type CancelledExecution struct {
+	ID    string `json:"id"`
+	State string `json:"state"` // queued or running, before the cancellation
+}
+
+// JobRunResponse is returned by POST /admin/jobs/{name}/run for the run it
+// started. GET /executions/{id} has its record once it finishes.
+type JobRunResponse struct {
+	ID  string `json:"id"`
+	Job string `json:"job"`
+}
+
+// WorkspaceFile is one entry in GET /workspaces/{id}/files.
+type WorkspaceFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
 }
 
 // Duration wraps time.Duration for JSON marshaling as a string like "10s".
@@ -40,8 +187,14 @@ type ResourceLimits struct {
 	MemoryMB  int64 `json:"memory_mb,omitempty"`
 	PidsLimit int64 `json:"pids_limit,omitempty"`
 	DiskMB    int64 `json:"disk_mb,omitempty"`
+
+	Ulimits *Ulimits `json:"ulimits,omitempty"` // Fields left 0 are derived from the limits above
 }
 
+// Ulimits are an execution's rlimits: asked for in limits.ulimits, reported
+// in the environment block.
+type Ulimits = stream.Ulimits
+
 // Permissions defines what the sandboxed code is allowed to access.
 type Permissions struct {
 	Network     NetworkPermissions    `json:"network,omitempty"`
@@ -52,6 +205,10 @@ type Permissions struct {
 // NetworkPermissions controls network access within the sandbox.
 type NetworkPermissions struct {
 	Enabled bool `json:"enabled"`
+	// InternetOnly says the code needs only the internet. Nothing enforces
+	// it, but with sandbox.egress_audit a connection into a private range
+	// is recorded as a private_egress security event.
+	InternetOnly bool `json:"internet_only,omitempty"`
 }
 
 // FilesystemPermissions controls filesystem access.
@@ -66,12 +223,106 @@ type ExecutionResponse struct {
 	Output         string          `json:"output"`
 	Stderr         string          `json:"stderr"`
 	ExitCode       int             `json:"exit_code"`
+	ExitClass      string          `json:"exit_class,omitempty"` // user_exit, oom_kill, timeout_kill, idle_timeout, manual_kill, resource_abuse, signal:<n>, infra_error
 	Duration       string          `json:"duration"`
+	Timeout        string          `json:"timeout,omitempty"` // the timeout enforced, from timeout or deadline
+	Deadline       time.Time       `json:"deadline,omitzero"` // server time the timeout ran out at, counted from when the request arrived
 	ResourceUsage  ResourceUsage   `json:"resource_usage"`
 	SecurityEvents []SecurityEvent `json:"security_events,omitempty"`
 	Cached         bool            `json:"cached,omitempty"`
+	Chaos          bool            `json:"chaos,omitempty"`         // result was synthesized by chaos mode
+	SharedMounts   []string        `json:"shared_mounts,omitempty"` // shared mounts attached to the container
+	Environment    *Environment    `json:"environment,omitempty"`
+	IdleTimeout    *IdleTimeout    `json:"idle_timeout,omitempty"` // set when exit_class is idle_timeout
+	CodeScan       *CodeScan       `json:"code_scan,omitempty"`    // POST /execute/upload only
+	// PartialAnalysis is set when the escape detector ran out of its
+	// analysis budget and only sampled the rest of the code.
+	PartialAnalysis bool `json:"partial_analysis,omitempty"`
+	// Analysis is not_applicable when the code is an executable (language
+	// binary), which the escape detector can't read.
+	Analysis string `json:"analysis,omitempty"`
+	// Deduplicated is set when the request shared the execution of an
+	// identical one already running (sandbox.dedup); ID is that execution's.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// NetworkConnections is what an execution with a network connected to,
+	// with sandbox.egress_audit.
+	NetworkConnections *NetworkAudit `json:"network_connections,omitempty"`
+	// OutputEvents marks, with merge_output, where each run of stdout or
+	// stderr begins in Output.
+	OutputEvents []OutputEvent `json:"output_events,omitempty"`
+	// Warnings is set when the execution ran other than the request asked:
+	// network_denied when security.network_policy ran it without the
+	// network it asked for.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Recommendation is set when exit_class is oom_kill: the memory_mb to
+	// try next, from the language's ladder.
+	Recommendation *Recommendation `json:"recommendation,omitempty"`
+	// DebugTrace is what strace recorded, with debug_trace.
+	DebugTrace *DebugTrace `json:"debug_trace,omitempty"`
 }
 
+// OutputEvent is where a run of one stream's bytes begins in a merged
+// output.
+type OutputEvent = sandbox.OutputEvent
+
+// CodeScan says how much of uploaded code the escape detector read. Past
+// its limit only the start and the end of the code are scanned, so an
+// incomplete scan can miss a pattern in the middle.
+type CodeScan struct {
+	CodeBytes    int64 `json:"code_bytes"`
+	ScannedBytes int64 `json:"scanned_bytes"`
+	Complete     bool  `json:"complete"`
+}
+
+// Environment describes how the sandboxed process was started.
+type Environment = stream.Environment
+
+// LimitReport is an execution's requested limits against the enforced ones.
+type LimitReport = stream.LimitReport
+
+// LimitValues are the resource limits of a LimitReport.
+type LimitValues = stream.LimitValues
+
+// Warning is something about an execution the caller may not expect, such
+// as network_denied: it asked for a network and ran without one.
+type Warning = stream.Warning
+
+// IdleTimeout explains an execution stopped for writing no output.
+type IdleTimeout = stream.IdleTimeout
+
+// Seccomp is the seccomp state the sandboxed process started under.
+type Seccomp = stream.Seccomp
+
+// WorkdirSize is the measured size of a claude work_dir.
+type WorkdirSize = stream.WorkdirSize
+
+// WorkdirGit is the git state of a claude work_dir.
+type WorkdirGit = stream.WorkdirGit
+
+// Recommendation is the memory an execution killed by the OOM killer
+// might ask for next time.
+type Recommendation = stream.Recommendation
+
+// DebugTrace is an execution's strace output, with a summary of the calls
+// that failed.
+type DebugTrace = stream.DebugTrace
+
+// ImagePull is an image pull an execution waited on in its setup phase.
+type ImagePull = stream.ImagePull
+
+// Status is the payload of a stream's status events.
+type Status = stream.Status
+
+// NetworkAudit lists what an execution with a network connected to.
+type NetworkAudit = stream.NetworkAudit
+
+// NetworkConnection is a destination in a NetworkAudit.
+type NetworkConnection = stream.NetworkConnection
+
+// ExecutionProgress is returned by GET /executions/{id}/progress and sent as
+// progress events.
+type ExecutionProgress = stream.Progress
+
 // ResourceUsage reports measured resource consumption.
 type ResourceUsage struct {
 	CPUTimeMS    int64 `json:"cpu_time_ms"`
@@ -79,24 +330,127 @@ type ResourceUsage struct {
 	PidsUsed     int64 `json:"pids_used"`
 }
 
-// SecurityEvent records suspicious activity during execution.
-type SecurityEvent struct {
-	Type    string `json:"type"`
-	Syscall string `json:"syscall,omitempty"`
-	Detail  string `json:"detail"`
-}
+// SecurityEvent records suspicious activity in the code, the output or during
+// execution.
+type SecurityEvent = stream.SecurityEvent
 
 // ErrorResponse is returned for API errors.
-type ErrorResponse struct {
-	Error     string `json:"error"`
-	Code      string `json:"code"`
-	RequestID string `json:"request_id"`
-}
+type ErrorResponse = apierror.Response
 
 // HealthResponse is returned by the health check endpoint.
 type HealthResponse struct {
-	Status     string `json:"status"`
-	Containerd bool   `json:"containerd"`
-	Database   bool   `json:"database"`
-	Uptime     string `json:"uptime"`
+	Status         string            `json:"status"`
+	Containerd     bool              `json:"containerd"`
+	Database       bool              `json:"database"`
+	Uptime         string            `json:"uptime"`
+	ServerVersion  string            `json:"server_version"`
+	ConfigWarnings []string          `json:"config_warnings,omitempty"` // The startup config report's warnings
+	Disabled       *DisabledFlags    `json:"disabled,omitempty"`        // Languages and features the kill switches have turned off
+	ImageDigests   map[string]string `json:"image_digests,omitempty"`   // Runtime image to the digest it last ran as
+	Disk           *DiskStatus       `json:"disk,omitempty"`            // Free space on the runtime's data root and the disk pressure stage; absent when it isn't watched
+	Audit          *AuditHealth      `json:"audit,omitempty"`           // Whether database.required has executions refused; absent without it
+}
+
+// AuditHealth is the state of the database.required gate.
+type AuditHealth struct {
+	Refusing bool   `json:"refusing"`
+	Reason   string `json:"reason,omitempty"` // database or buffer, while refusing
+}
+
+// StatsResponse is returned by GET /stats: how saturated the server is, as
+// one flat document for autoscalers that don't parse Prometheus histograms.
+// It is computed at most once per metrics.stats.cache_ttl.
+type StatsResponse struct {
+	GeneratedAt                time.Time           `json:"generated_at"`
+	SaturationScore            float64             `json:"saturation_score"`               // Weighted mean of saturation by metrics.stats.weights: 0 idle, 1 saturated
+	Saturation                 SaturationParts     `json:"saturation"`                     // The parts of the score, each 0 to 1
+	Running                    int                 `json:"running"`                        // Executions the backend has given a slot
+	RunningByLanguage          map[string]int      `json:"running_by_language"`            // Running executions per language
+	SlotCapacity               int                 `json:"slot_capacity"`                  // Executions the backend runs at once; 0 when it doesn't report its slots
+	SlotsInUse                 int                 `json:"slots_in_use"`                   // Slots taken
+	SlotPools                  []sandbox.PoolStats `json:"slot_pools"`                     // Each language pool of sandbox.concurrency, and default
+	QueueDepth                 int                 `json:"queue_depth"`                    // Executions waiting for a slot
+	QueueOldestWaitSeconds     float64             `json:"queue_oldest_wait_seconds"`      // How long the longest-waiting of them has waited
+	ClaudeCapacity             int                 `json:"claude_capacity"`                // security.max_concurrent_claude
+	ClaudeInUse                int                 `json:"claude_in_use"`                  // Claude sessions in progress
+	RateLimitRejectedPerMinute int                 `json:"rate_limit_rejected_per_minute"` // Requests the per-client rate limiter refused in the last minute
+}
+
+// SaturationParts are the parts of StatsResponse.SaturationScore.
+type SaturationParts struct {
+	Slots     float64 `json:"slots"`      // slots_in_use of slot_capacity
+	Queue     float64 `json:"queue"`      // queue_depth against slot_capacity, capped at 1
+	Claude    float64 `json:"claude"`     // claude_in_use of claude_capacity
+	RateLimit float64 `json:"rate_limit"` // Share of the last minute's requests the rate limiter refused
+}
+
+// CapabilitiesResponse is returned by GET /capabilities.
+type CapabilitiesResponse struct {
+	Build     BuildInfo             `json:"build"`
+	Backend   string                `json:"backend"` // docker or containerd
+	Languages []LanguageCapability  `json:"languages"`
+	Features  []FeatureCapability   `json:"features"`
+	Fields    []string              `json:"fields"` // top-level request fields accepted; those of features that aren't enabled are left out
+	Limits    LimitCapabilities     `json:"limits"`
+	Streaming StreamingCapabilities `json:"streaming"`
+}
+
+// BuildInfo identifies the server binary: its -ldflags version, else what
+// the Go toolchain stamped into it.
+type BuildInfo = version.Info
+
+// DiskStatus is the last free-space sample of the runtime's data root.
+type DiskStatus = sandbox.DiskStatus
+
+// LanguageCapability is a language the server runs.
+type LanguageCapability struct {
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases,omitempty"` // Other names requests may use, in any case
+	MaxTimeout string   `json:"max_timeout"`
+	Disabled   bool     `json:"disabled,omitempty"` // by a kill switch
+	// DefaultLimits are what an execution of the language gets for the
+	// limits its request leaves out.
+	DefaultLimits ResourceLimits `json:"default_limits"`
+}
+
+// FeatureCapability is an optional feature and whether it can be used.
+type FeatureCapability struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Fields      []string `json:"fields,omitempty"` // request fields that use it
+	Routes      []string `json:"routes,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	Reason      string   `json:"reason,omitempty"` // why it isn't
+}
+
+// LimitCapabilities are the resource limits a request gets and may ask for.
+type LimitCapabilities struct {
+	Default              ResourceLimits `json:"default"`
+	Max                  ResourceLimits `json:"max"`
+	MaxUploadCodeBytes   int64          `json:"max_upload_code_bytes,omitempty"`
+	MaxBinaryBytes       int64          `json:"max_binary_bytes"` // language binary's executables
+	MaxIdleOutputTimeout string         `json:"max_idle_output_timeout,omitempty"`
+	// Enforcement is which limit mechanisms the host was found to enforce,
+	// with sandbox.limit_enforcement on the docker backend.
+	Enforcement *LimitEnforcement `json:"enforcement,omitempty"`
+}
+
+// LimitEnforcement is what the startup canary found the host enforces.
+type LimitEnforcement = sandbox.LimitEnforcement
+
+// StreamingCapabilities says how output can be streamed.
+type StreamingCapabilities struct {
+	SSE       bool `json:"sse"`       // POST /execute/stream
+	WebSocket bool `json:"websocket"` // not offered by this server
+	// Versions are the stream format versions Accept-Stream-Version may
+	// ask for; without it a stream is in the first.
+	Versions []int `json:"versions,omitempty"`
+}
+
+// DisabledFlags lists what security.disabled_languages, disabled_features or
+// POST /admin/flags have turned off.
+type DisabledFlags struct {
+	Languages []string `json:"languages,omitempty"`
+	Features  []string `json:"features,omitempty"`
+	Message   string   `json:"message,omitempty"`
 }
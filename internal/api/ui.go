@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiCSP replaces SecurityHeadersMiddleware's default-src 'none' on the /ui
+// routes: the page may load its own script and stylesheet and call the API
+// on the same origin, and nothing else. Inline script stays forbidden.
+const uiCSP = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// uiAsset is one embedded file, with the ETag browsers revalidate it by.
+type uiAsset struct {
+	body        []byte
+	contentType string
+	etag        string
+}
+
+// uiHandler serves the mini UI (server.enable_ui): a page that runs code
+// through POST /execute/stream and lists GET /executions. The page itself
+// is public, since a browser can't send an API key when navigating to it;
+// it asks for the key and sends it with every API call, which AuthMiddleware
+// checks as for any other client.
+type uiHandler struct {
+	assets map[string]uiAsset // by URL path
+}
+
+// basePath is server.base_path, which the page's links and API calls are
+// made under.
+func newUIHandler(languages []string, basePath string) *uiHandler {
+	h := &uiHandler{assets: make(map[string]uiAsset)}
+	types := map[string]string{
+		".html": "text/html; charset=utf-8",
+		".js":   "text/javascript; charset=utf-8",
+		".css":  "text/css; charset=utf-8",
+	}
+	entries, _ := fs.ReadDir(uiFiles, "ui")
+	for _, e := range entries {
+		body, _ := uiFiles.ReadFile("ui/" + e.Name())
+		if e.Name() == "index.html" {
+			body = uiIndex(body, basePath)
+		}
+		h.add(pathUI+"/"+e.Name(), body, types[path.Ext(e.Name())])
+	}
+	h.assets[pathUI] = h.assets[pathUI+"/index.html"]
+
+	// The language selector's options. Languages the backend can't run
+	// fail as they would from any client.
+	body, _ := json.Marshal(map[string][]string{"languages": languages})
+	h.add(pathUI+"/runtimes.json", body, "application/json")
+	return h
+}
+
+// uiIndex is the page with its links and the base-path meta tag under
+// basePath. The base path is only path characters (see config), so it
+// needs no escaping.
+func uiIndex(page []byte, basePath string) []byte {
+	s := strings.ReplaceAll(string(page), `"/ui/`, `"`+basePath+pathUI+"/")
+	s = strings.Replace(s, `<meta name="base-path" content="">`, `<meta name="base-path" content="`+basePath+`">`, 1)
+	return []byte(s)
+}
+
+func (h *uiHandler) add(urlPath string, body []byte, contentType string) {
+	sum := sha256.Sum256(body)
+	h.assets[urlPath] = uiAsset{body: body, contentType: contentType, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+}
+
+// register routes each asset on mux, so paths under /ui that aren't assets
+// fall through to the authenticated API like any unknown path.
+func (h *uiHandler) register(mux *http.ServeMux) {
+	for urlPath := range h.assets {
+		mux.Handle("GET "+urlPath, h)
+	}
+}
+
+func (h *uiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	asset := h.assets[r.URL.Path]
+	w.Header().Set("Content-Security-Policy", uiCSP)
+	// Revalidated on every load, so a new binary's UI shows up at once.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", asset.etag)
+	if r.Header.Get("If-None-Match") == asset.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", asset.contentType)
+	_, _ = w.Write(asset.body)
+}
@@ -0,0 +1,204 @@
+// Mini UI for trying the sandbox by hand. It talks to the same API as
+// everyone else: the key entered here is kept in sessionStorage and sent as
+// X-API-Key, so it is gone when the tab closes.
+"use strict";
+
+const keyStorage = "sandbox-api-key";
+// server.base_path, filled in by the server; the API's paths are under it.
+const basePath = document.querySelector('meta[name="base-path"]').content;
+const $ = (id) => document.getElementById(id);
+let running = null; // AbortController of the execution in flight
+
+function headers() {
+  const h = { "Content-Type": "application/json" };
+  const key = sessionStorage.getItem(keyStorage);
+  if (key) {
+    h["X-API-Key"] = key;
+  }
+  return h;
+}
+
+async function apiError(resp) {
+  try {
+    const body = await resp.json();
+    return `${resp.status} ${body.code}: ${body.error}`;
+  } catch {
+    return `${resp.status} ${resp.statusText}`;
+  }
+}
+
+function setStatus(text) {
+  $("status").textContent = text;
+}
+
+function appendOutput(text, stream) {
+  const span = document.createElement("span");
+  span.className = stream;
+  span.textContent = text;
+  $("output").appendChild(span);
+  $("output").scrollTop = $("output").scrollHeight;
+}
+
+async function loadLanguages() {
+  const resp = await fetch(basePath + "/ui/runtimes.json");
+  const { languages } = await resp.json();
+  const select = $("language");
+  for (const lang of languages) {
+    const option = document.createElement("option");
+    option.value = option.textContent = lang;
+    select.appendChild(option);
+  }
+  select.value = languages.includes("python") ? "python" : languages[0];
+}
+
+function requestBody() {
+  const body = {
+    language: $("language").value,
+    code: $("code").value,
+    timeout: $("timeout").value || undefined,
+  };
+  const memory = parseInt($("memory").value, 10);
+  if (memory > 0) {
+    // The server only reads limits when memory_mb is set.
+    body.limits = { memory_mb: memory, pids_limit: parseInt($("pids").value, 10) || 50 };
+  }
+  if ($("network").checked) {
+    body.permissions = { network: { enabled: true } };
+  }
+  return body;
+}
+
+// readEvents yields the Server-Sent Events of a response body: an "event:"
+// line, one "data:" line per line of payload, and a blank line.
+async function* readEvents(body) {
+  const reader = body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  let type = "message";
+  let data = [];
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += value;
+    let nl;
+    while ((nl = buffer.indexOf("\n")) >= 0) {
+      const line = buffer.slice(0, nl);
+      buffer = buffer.slice(nl + 1);
+      if (line === "") {
+        if (data.length > 0) {
+          yield { type, data: data.join("\n") };
+        }
+        type = "message";
+        data = [];
+      } else if (line.startsWith("event:")) {
+        type = line.slice(6).trim();
+      } else if (line.startsWith("data:")) {
+        data.push(line.slice(line.startsWith("data: ") ? 6 : 5));
+      }
+    }
+  }
+}
+
+async function execute(event) {
+  event.preventDefault();
+  $("output").textContent = "";
+  $("result").textContent = "";
+  running = new AbortController();
+  $("run-button").disabled = true;
+  $("stop-button").disabled = false;
+  setStatus("running…");
+  try {
+    const resp = await fetch(basePath + "/execute/stream", {
+      method: "POST",
+      headers: headers(),
+      body: JSON.stringify(requestBody()),
+      signal: running.signal,
+    });
+    if (!resp.ok) {
+      setStatus(await apiError(resp));
+      return;
+    }
+    for await (const ev of readEvents(resp.body)) {
+      switch (ev.type) {
+        case "stdout":
+        case "stderr":
+          appendOutput(ev.data, ev.type);
+          break;
+        case "progress": {
+          const p = JSON.parse(ev.data);
+          setStatus(`running… ${p.elapsed} / ${p.timeout}`);
+          break;
+        }
+        case "done": {
+          const done = JSON.parse(ev.data);
+          setStatus(`exit ${done.exit_code} (${done.exit_class}) in ${done.duration}`);
+          $("result").textContent = JSON.stringify(done, null, 2);
+          break;
+        }
+        case "error": {
+          const err = JSON.parse(ev.data);
+          setStatus(`${err.code}: ${err.error}`);
+          break;
+        }
+      }
+    }
+  } catch (err) {
+    setStatus(err.name === "AbortError" ? "stopped" : String(err));
+  } finally {
+    running = null;
+    $("run-button").disabled = false;
+    $("stop-button").disabled = true;
+    loadRecent();
+  }
+}
+
+async function loadRecent() {
+  const rows = $("recent-rows");
+  const resp = await fetch(basePath + "/executions", { headers: headers() });
+  if (!resp.ok) {
+    $("recent-status").textContent = await apiError(resp);
+    rows.replaceChildren();
+    return;
+  }
+  $("recent-status").textContent = "";
+  const execs = (await resp.json()) || [];
+  rows.replaceChildren(
+    ...execs.slice(0, 25).map((e) => {
+      const tr = document.createElement("tr");
+      const cells = [
+        new Date(e.created_at).toLocaleString(),
+        e.language,
+        e.status,
+        e.exit_code,
+        `${e.duration_ms}ms`,
+        e.id,
+      ];
+      for (const value of cells) {
+        const td = document.createElement("td");
+        td.textContent = value;
+        tr.appendChild(td);
+      }
+      tr.lastChild.className = "id";
+      return tr;
+    }),
+  );
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("api-key").value = sessionStorage.getItem(keyStorage) || "";
+  $("auth").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(keyStorage, $("api-key").value);
+    loadRecent();
+  });
+  $("forget-key").addEventListener("click", () => {
+    sessionStorage.removeItem(keyStorage);
+    $("api-key").value = "";
+  });
+  $("execute").addEventListener("submit", execute);
+  $("stop-button").addEventListener("click", () => running && running.abort());
+  $("refresh").addEventListener("click", loadRecent);
+  loadLanguages();
+  loadRecent();
+});
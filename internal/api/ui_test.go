@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+)
+
+func uiServer(t *testing.T, enabled bool) http.Handler {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"ui-test-key"}
+	cfg.Server.EnableUI = enabled
+	return NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics()).Handler()
+}
+
+func getUI(h http.Handler, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestUI_Enabled(t *testing.T) {
+	h := uiServer(t, true)
+	for path, want := range map[string]string{
+		"/ui":               "text/html; charset=utf-8",
+		"/ui/index.html":    "text/html; charset=utf-8",
+		"/ui/app.js":        "text/javascript; charset=utf-8",
+		"/ui/app.css":       "text/css; charset=utf-8",
+		"/ui/runtimes.json": "application/json",
+	} {
+		// The page loads without a key; it asks for one.
+		w := getUI(h, path)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != want {
+			t.Errorf("%s: status %d content type %q, want 200 %q", path, w.Code, w.Header().Get("Content-Type"), want)
+		}
+		if got := w.Header().Get("Content-Security-Policy"); got != uiCSP {
+			t.Errorf("%s: CSP %q, want the UI's", path, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("%s: Cache-Control %q, want no-cache", path, got)
+		}
+		if w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: lost the other security headers: %v", path, w.Header())
+		}
+	}
+
+	var runtimes struct{ Languages []string }
+	if err := json.Unmarshal(getUI(h, "/ui/runtimes.json").Body.Bytes(), &runtimes); err != nil || !slices.Contains(runtimes.Languages, "python") {
+		t.Errorf("runtimes.json = %+v, %v", runtimes, err)
+	}
+	if body := getUI(h, "/ui").Body.String(); !strings.Contains(body, `<script src="/ui/app.js"`) {
+		t.Errorf("/ui is not the page: %.200s", body)
+	}
+}
+
+func TestUI_NotModified(t *testing.T) {
+	h := uiServer(t, true)
+	etag := getUI(h, "/ui/app.js").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if w := getUI(h, "/ui/app.js", "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("revalidation: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if w := getUI(h, "/ui/app.js", "If-None-Match", `"stale"`); w.Code != http.StatusOK {
+		t.Errorf("stale ETag: status %d", w.Code)
+	}
+}
+
+// TestUI_CSPScopedToUI checks the relaxed policy stays on the UI's routes:
+// the API, health and unknown paths under /ui keep default-src 'none'.
+func TestUI_CSPScopedToUI(t *testing.T) {
+	h := uiServer(t, true)
+	for _, path := range []string{"/health", "/errors", "/executions", "/ui/missing.js", "/uix"} {
+		if got := getUI(h, path, "X-API-Key", "ui-test-key").Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+			t.Errorf("%s: CSP %q", path, got)
+		}
+	}
+	// An unknown path under /ui is an API path, so it needs a key.
+	if w := getUI(h, "/ui/missing.js"); w.Code != apierror.CodeAuthRequired.Status() {
+		t.Errorf("/ui/missing.js without a key: status %d", w.Code)
+	}
+}
+
+func TestUI_Disabled(t *testing.T) {
+	h := uiServer(t, false)
+	for _, path := range []string{"/ui", "/ui/index.html", "/ui/app.js", "/ui/runtimes.json"} {
+		// Without the UI these are unknown API paths: refused without a
+		// key, not found with one, and never the UI's assets.
+		if w := getUI(h, path); w.Code != apierror.CodeAuthRequired.Status() {
+			t.Errorf("%s without a key: status %d", path, w.Code)
+		}
+		w := getUI(h, path, "X-API-Key", "ui-test-key")
+		if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "sessionStorage") || strings.Contains(w.Body.String(), "<html") {
+			t.Errorf("%s with a key: status %d body %.100s", path, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+			t.Errorf("%s: CSP %q", path, got)
+		}
+	}
+}
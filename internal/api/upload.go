@@ -0,0 +1,227 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+const (
+	// maxUploadMetadataBytes caps the metadata part of POST /execute/upload.
+	maxUploadMetadataBytes = 64 << 10
+	// uploadScanBytes is how much of an upload's start, and of its end, the
+	// escape detector reads.
+	uploadScanBytes = 64 << 10
+)
+
+// errCodeTooLarge is returned by receiveCode once the code part passes
+// sandbox.max_upload_code_bytes.
+var errCodeTooLarge = errors.New("uploaded code over the limit")
+
+// isExecuteUpload reports whether r is a POST /execute/upload, whose code
+// is capped by sandbox.max_upload_code_bytes rather than the global
+// request body limit.
+func isExecuteUpload(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Path == pathExecuteUpload
+}
+
+// HandleExecuteUpload runs code too large to send as JSON. The body is
+// multipart/form-data: a "metadata" part holding an ExecutionRequest
+// without code, then a "code" part that is streamed to a temp file, hashed
+// as it is written and never held in memory.
+func (h *Handlers) HandleExecuteUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.requireCapability(w, r, "uploads") {
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "body must be multipart/form-data"))
+		return
+	}
+
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != "metadata" {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "the first part must be metadata"))
+		return
+	}
+	var req ExecutionRequest
+	if err := json.NewDecoder(io.LimitReader(part, maxUploadMetadataBytes)).Decode(&req); err != nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "invalid metadata"))
+		return
+	}
+	req.Language = canonicalLanguage(req.Language)
+	switch {
+	case req.Language == "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "language is required"))
+		return
+	case req.Code != "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "metadata code and the code part are mutually exclusive"))
+		return
+	case req.BundleDigest != "":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "bundle_digest and the code part are mutually exclusive; send bundle executions to POST /execute"))
+		return
+	case req.Language == "claude":
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "claude prompts can't be uploaded; send them to POST /execute"))
+		return
+	case req.Async || asyncQuery(r):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "uploads run synchronously; send async executions to POST /execute/async"))
+		return
+	}
+
+	part, err = mr.NextPart()
+	if err != nil || part.FormName() != "code" {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "the second part must be code"))
+		return
+	}
+	dir, err := os.MkdirTemp("", "sandbox-upload-*")
+	if err != nil {
+		log.Error().Err(err).Msg("could not create upload directory")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "could not store the upload"))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	up, err := receiveCode(part, filepath.Join(dir, "code"), h.maxUploadBytes)
+	switch {
+	case errors.Is(err, errCodeTooLarge):
+		apierror.WriteError(w, r, apierror.Newf(apierror.CodeCodeTooLarge,
+			"code is over the %d byte limit", h.maxUploadBytes).
+			WithDetails(map[string]any{"max_upload_code_bytes": h.maxUploadBytes}))
+		return
+	case err != nil:
+		log.Warn().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("code upload failed")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "could not read the code part"))
+		return
+	case up.size == 0:
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "code is required"))
+		return
+	case req.Language == binaryLanguage && up.size > h.binaryMaxBytes:
+		h.writeExecutableTooLarge(w, r)
+		return
+	}
+
+	h.metrics.CodeSizeBytes.Observe(float64(up.size))
+
+	var analysis monitor.Analysis
+	var scan *CodeScan
+	if req.Language != binaryLanguage { // see screen
+		analysis, scan = up.scan(h.detector)
+	}
+	detections := analysis.Detections
+	for _, d := range detections {
+		h.metrics.RecordSecurityEvent(d.Pattern)
+		if d.Severity == monitor.SeverityCritical.String() {
+			apierror.WriteError(w, r, apierror.New(apierror.CodeSecurityBlocked, "request blocked by security policy"))
+			return
+		}
+	}
+	stdin, ok := h.screenStdin(w, r, &req)
+	if !ok {
+		return
+	}
+
+	h.execute(w, r, req, submission{
+		source:     sandbox.SourceCode,
+		detections: detections,
+		stdin:      stdin,
+		codeFile:   up.path,
+		codeHash:   up.hash,
+		scan:       scan,
+		partial:    analysis.Partial,
+	})
+}
+
+// codeUpload is a code part written to disk, with what the detector gets
+// to see of it.
+type codeUpload struct {
+	path   string
+	hash   string
+	size   int64
+	window *scanWindow
+}
+
+// receiveCode streams src to a new file at path, hashing it on the way. It
+// stops reading at limit+1 bytes and returns errCodeTooLarge, so an oversized
+// upload is neither buffered nor read to its end.
+func receiveCode(src io.Reader, path string, limit int64) (*codeUpload, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	window := &scanWindow{limit: uploadScanBytes}
+	n, err := io.Copy(io.MultiWriter(f, hash, window), io.LimitReader(src, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = errCodeTooLarge
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &codeUpload{path: path, hash: fmt.Sprintf("%x", hash.Sum(nil)), size: n, window: window}, nil
+}
+
+// scan runs the escape detector over the upload. Code that fits in the
+// window is scanned whole; past that, the first and last uploadScanBytes
+// are, and detections in the end have no line number, since the lines
+// before them weren't counted.
+func (u *codeUpload) scan(d *monitor.EscapeDetector) (monitor.Analysis, *CodeScan) {
+	head, tail := u.window.head, u.window.tail
+	if u.size <= int64(len(head)+len(tail)) {
+		code := string(head) + string(tail)
+		return d.Analyze(code), &CodeScan{CodeBytes: u.size, ScannedBytes: u.size, Complete: true}
+	}
+
+	analysis := d.Analyze(string(head))
+	// The window starts mid-line, so its first, partial line is skipped.
+	end := string(tail)
+	if i := strings.IndexByte(end, '\n'); i >= 0 {
+		end = end[i+1:]
+	}
+	endAnalysis := d.Analyze(end)
+	for _, det := range endAnalysis.Detections {
+		det.Line = 0
+		analysis.Detections = append(analysis.Detections, det)
+	}
+	analysis.Partial = analysis.Partial || endAnalysis.Partial
+	scanned := int64(len(head) + len(end))
+	return analysis, &CodeScan{CodeBytes: u.size, ScannedBytes: scanned}
+}
+
+// scanWindow is an io.Writer keeping the first limit bytes written and the
+// last limit bytes after those.
+type scanWindow struct {
+	limit int
+	head  []byte
+	tail  []byte
+}
+
+func (s *scanWindow) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := s.limit - len(s.head); room > 0 {
+		k := min(room, len(p))
+		s.head = append(s.head, p[:k]...)
+		p = p[k:]
+	}
+	s.tail = append(s.tail, p...)
+	if over := len(s.tail) - s.limit; over > 0 {
+		copy(s.tail, s.tail[over:])
+		s.tail = s.tail[:s.limit]
+	}
+	return n, nil
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/sandbox"
+)
+
+// uploadBackend reads the uploaded file while the handler still has it.
+type uploadBackend struct {
+	mockBackend
+	code string
+}
+
+func (b *uploadBackend) Execute(ctx context.Context, req sandbox.ExecutionRequest) (*sandbox.ExecutionResult, error) {
+	if data, err := os.ReadFile(req.CodeFile); err == nil {
+		b.code = string(data)
+	}
+	return b.mockBackend.Execute(ctx, req)
+}
+
+// postUpload posts a multipart body with the given name, content parts, in
+// order.
+func postUpload(t *testing.T, h *Handlers, parts ...[2]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		w, err := mw.CreateFormField(p[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, p[1])
+	}
+	mw.Close()
+	return postUploadBody(h, &buf, mw.FormDataContentType())
+}
+
+func postUploadBody(h *Handlers, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/execute/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.HandleExecuteUpload(rec, req)
+	return rec
+}
+
+func newUploadHandlers(t *testing.T) (*Handlers, *uploadBackend) {
+	t.Setenv("TMPDIR", t.TempDir())
+	backend := &uploadBackend{mockBackend: mockBackend{result: &sandbox.ExecutionResult{ID: "x", ExitClass: sandbox.ExitUser}}}
+	h := newTestHandlers(backend)
+	h.maxUploadBytes = 1 << 20
+	return h, backend
+}
+
+func TestExecuteUpload(t *testing.T) {
+	h, backend := newUploadHandlers(t)
+	code := "import sys\nprint(sys.argv)\n"
+
+	rec := postUpload(t, h, [2]string{"metadata", `{"language":"python","args":["a"]}`}, [2]string{"code", code})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if backend.code != code {
+		t.Errorf("backend read %q, want the uploaded code", backend.code)
+	}
+	req := backend.req
+	if req.Code != "" || req.Language != "python" || len(req.Args) != 1 {
+		t.Errorf("backend request = %+v", req)
+	}
+	// The hash taken while streaming is the one the inline path would record.
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte(code))); req.CodeHash != want {
+		t.Errorf("code hash %s, want %s", req.CodeHash, want)
+	}
+	if _, err := os.Stat(req.CodeFile); !os.IsNotExist(err) {
+		t.Errorf("upload %s left behind: %v", req.CodeFile, err)
+	}
+
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := (CodeScan{CodeBytes: int64(len(code)), ScannedBytes: int64(len(code)), Complete: true}); resp.CodeScan == nil || *resp.CodeScan != want {
+		t.Errorf("code_scan = %+v, want %+v", resp.CodeScan, want)
+	}
+}
+
+func TestExecuteUpload_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][2]string
+		want  string
+	}{
+		{"code in metadata", [][2]string{{"metadata", `{"language":"python","code":"print(1)"}`}, {"code", "print(2)"}}, "mutually exclusive"},
+		{"no language", [][2]string{{"metadata", `{}`}, {"code", "print(2)"}}, "language is required"},
+		{"claude", [][2]string{{"metadata", `{"language":"claude"}`}, {"code", "fix the tests"}}, "POST /execute"},
+		{"async", [][2]string{{"metadata", `{"language":"python","async":true}`}, {"code", "print(2)"}}, "POST /execute/async"},
+		{"code first", [][2]string{{"code", "print(2)"}, {"metadata", `{"language":"python"}`}}, "first part must be metadata"},
+		{"no code part", [][2]string{{"metadata", `{"language":"python"}`}}, "second part must be code"},
+		{"empty code", [][2]string{{"metadata", `{"language":"python"}`}, {"code", ""}}, "code is required"},
+		{"bad metadata", [][2]string{{"metadata", `{"language":`}, {"code", "print(2)"}}, "invalid metadata"},
+	}
+	for _, tt := range tests {
+		h, backend := newUploadHandlers(t)
+		rec := postUpload(t, h, tt.parts...)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: status %d %s, want 400 mentioning %q", tt.name, rec.Code, rec.Body.String(), tt.want)
+		}
+		if backend.req.Language != "" {
+			t.Errorf("%s: reached the backend", tt.name)
+		}
+	}
+
+	h, _ := newUploadHandlers(t)
+	rec := postUploadBody(h, strings.NewReader(`{"language":"python","code":"print(1)"}`), "application/json")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("JSON body: status %d", rec.Code)
+	}
+}
+
+// countingReader counts what the handler pulled from the body.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestExecuteUpload_TooLarge(t *testing.T) {
+	h, backend := newUploadHandlers(t)
+	h.maxUploadBytes = 4 << 10
+	tmp := os.Getenv("TMPDIR")
+
+	// 64MB of code, produced as it is read.
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		w, _ := mw.CreateFormField("metadata")
+		io.WriteString(w, `{"language":"python"}`)
+		w, _ = mw.CreateFormField("code")
+		line := []byte(strings.Repeat("x", 1023) + "\n")
+		for i := 0; i < 64<<10; i++ {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+		mw.Close()
+		pw.Close()
+	}()
+	body := &countingReader{r: pr}
+	rec := postUploadBody(h, body, mw.FormDataContentType())
+	pr.Close()
+
+	var resp apierror.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge || resp.Code != "CODE_TOO_LARGE" || resp.Details["max_upload_code_bytes"] != float64(4<<10) {
+		t.Errorf("status %d, %+v", rec.Code, resp)
+	}
+	if body.n > 1<<20 {
+		t.Errorf("read %d bytes of the body, want it abandoned soon after the cap", body.n)
+	}
+	if backend.req.Language != "" {
+		t.Error("an oversized upload reached the backend")
+	}
+	if left, _ := os.ReadDir(tmp); len(left) != 0 {
+		t.Errorf("left %d entries in the temp dir", len(left))
+	}
+}
+
+func TestExecuteUpload_Disabled(t *testing.T) {
+	h, _ := newUploadHandlers(t)
+	h.maxUploadBytes = 0
+	rec := postUpload(t, h, [2]string{"metadata", `{"language":"python"}`}, [2]string{"code", "print(1)"})
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "UPLOADS_DISABLED") {
+		t.Errorf("status %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestExecuteUpload_Scan checks a large upload is scanned at both ends and
+// says so, and that a critical pattern in either blocks it.
+func TestExecuteUpload_Scan(t *testing.T) {
+	filler := strings.Repeat("x = 1\n", 3*uploadScanBytes/6)
+	const escape = "open('/sys/fs/cgroup/release_agent', 'w').write('/cmd')\n"
+
+	h, _ := newUploadHandlers(t)
+	rec := postUpload(t, h, [2]string{"metadata", `{"language":"python"}`}, [2]string{"code", filler})
+	var resp ExecutionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if s := resp.CodeScan; s == nil || s.Complete || s.CodeBytes != int64(len(filler)) || s.ScannedBytes >= s.CodeBytes || s.ScannedBytes < 2*uploadScanBytes-64 {
+		t.Errorf("code_scan = %+v, want a partial scan of both windows", resp.CodeScan)
+	}
+
+	for name, code := range map[string]string{"start": escape + filler, "end": filler + escape} {
+		h, backend := newUploadHandlers(t)
+		rec := postUpload(t, h, [2]string{"metadata", `{"language":"python"}`}, [2]string{"code", code})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("escape at the %s: status %d, want 403", name, rec.Code)
+		}
+		if backend.req.Language != "" {
+			t.Errorf("escape at the %s reached the backend", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"safe-agent-sandbox/internal/sandbox"
+	"safe-agent-sandbox/internal/storage"
+)
+
+// workdirDirtyWarning is the warning a claude execution whose work_dir had
+// uncommitted changes carries under security.claude_workdir_git warn: in
+// its response's warnings, or as a warning event before a stream's output.
+func workdirDirtyWarning(git sandbox.WorkdirGit) Warning {
+	return Warning{
+		Code:    "workdir_dirty",
+		Message: fmt.Sprintf("work_dir had uncommitted changes when claude started (%s); claude's changes are mixed in with them", strings.Join(git.Changed, ", ")),
+	}
+}
+
+// executionWarnings is a response's warnings: its network disposition's,
+// workdir_dirty when the runner warned of the work_dir, and
+// limit_not_enforced when the host didn't enforce some of its limits.
+func executionWarnings(result *sandbox.ExecutionResult) []Warning {
+	warnings := networkWarnings(result.Network)
+	if result.WorkdirGit != nil && slices.ContainsFunc(result.SecurityEvents, func(ev sandbox.SecurityEvent) bool { return ev.Type == "workdir_dirty" }) {
+		warnings = append(warnings, workdirDirtyWarning(*result.WorkdirGit))
+	}
+	if result.Limits != nil && len(result.Limits.NotEnforced) > 0 {
+		warnings = append(warnings, limitWarning(result.Limits.NotEnforced))
+	}
+	return warnings
+}
+
+// limitWarning is the warning an execution the host didn't hold to all its
+// limits carries under sandbox.limit_enforcement permissive: in its
+// response's warnings, or as a warning event before a stream's output.
+func limitWarning(gaps []string) Warning {
+	return Warning{
+		Code:    "limit_not_enforced",
+		Message: fmt.Sprintf("the host doesn't enforce the %s limits, so the execution ran with weaker limits than requested; environment.limits has what was enforced", strings.Join(gaps, ", ")),
+	}
+}
+
+func newWorkdirGit(git *sandbox.WorkdirGit) *WorkdirGit {
+	if git == nil {
+		return nil
+	}
+	out := WorkdirGit(*git)
+	return &out
+}
+
+// workdirGitRecord is what the audit log keeps of a work_dir's git state:
+// not the changed paths, which are the caller's.
+func workdirGitRecord(git *sandbox.WorkdirGit) *storage.WorkdirGit {
+	if git == nil {
+		return nil
+	}
+	return &storage.WorkdirGit{
+		Repo:      git.Repo,
+		Head:      git.Head,
+		Branch:    git.Branch,
+		Detached:  git.Detached,
+		Dirty:     git.Dirty,
+		Unchecked: git.Unchecked,
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/api/apierror"
+	"safe-agent-sandbox/internal/workspace"
+)
+
+// workspaceOwner identifies the tenant for workspace and execution ownership:
+// a hash of the API key or client certificate identity, so raw keys never
+// sit in memory next to workspace metadata.
+// Unauthenticated servers share a single anonymous owner.
+func workspaceOwner(r *http.Request) string {
+	caller, _ := r.Context().Value(contextKeyCaller).(string)
+	if caller == "" {
+		return ""
+	}
+	return ownerHash(caller)
+}
+
+// ownerHash is the owner a caller identity maps to.
+func ownerHash(caller string) string {
+	sum := sha256.Sum256([]byte(caller))
+	return hex.EncodeToString(sum[:])
+}
+
+// isWorkspaceUpload reports whether r is a file upload, which is capped by
+// the workspace store rather than the global request body limit.
+func isWorkspaceUpload(r *http.Request) bool {
+	return r.Method == http.MethodPut &&
+		strings.HasPrefix(r.URL.Path, pathWorkspaces+"/") &&
+		strings.Contains(r.URL.Path, "/files/")
+}
+
+// workspaceDir resolves an execution's workspace_id to its host directory.
+// It writes the error response and returns false when the request must be rejected.
+func (h *Handlers) workspaceDir(w http.ResponseWriter, r *http.Request, req *ExecutionRequest) (string, bool) {
+	if req.WorkspaceID == "" {
+		return "", true
+	}
+	if req.WorkDir != "" {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidRequest, "work_dir and workspace_id are mutually exclusive"))
+		return "", false
+	}
+	dir, err := h.workspaces.Dir(req.WorkspaceID, workspaceOwner(r))
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return "", false
+	}
+	return dir, true
+}
+
+func (h *Handlers) HandleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	if h.workspaces == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+		return
+	}
+
+	ws, err := h.workspaces.Create(workspaceOwner(r))
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+
+	log.Info().Str("workspace_id", ws.ID).Time("expires_at", ws.ExpiresAt).Msg("workspace created")
+	writeJSON(w, http.StatusCreated, WorkspaceResponse{
+		ID:            ws.ID,
+		ExpiresAt:     ws.ExpiresAt,
+		MaxFileBytes:  h.workspaces.MaxFileBytes(),
+		MaxTotalBytes: h.workspaces.MaxTotalBytes(),
+	})
+}
+
+func (h *Handlers) HandleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	if h.workspaces == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+		return
+	}
+
+	if err := h.workspaces.Delete(r.PathValue("id"), workspaceOwner(r)); err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) HandleListWorkspaceFiles(w http.ResponseWriter, r *http.Request) {
+	if h.workspaces == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+		return
+	}
+
+	files, err := h.workspaces.List(r.PathValue("id"), workspaceOwner(r))
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+
+	resp := make([]WorkspaceFile, 0, len(files))
+	for _, f := range files {
+		resp = append(resp, WorkspaceFile{Path: f.Path, Size: f.Size, Modified: f.ModTime})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handlers) HandlePutWorkspaceFile(w http.ResponseWriter, r *http.Request) {
+	if h.workspaces == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+		return
+	}
+
+	// The global body cap is skipped for uploads (see MaxBodyMiddleware); the
+	// store enforces the real limits, this just stops reading early.
+	body := http.MaxBytesReader(w, r.Body, h.workspaces.MaxFileBytes()+1)
+	name := r.PathValue("path")
+	n, err := h.workspaces.Put(r.PathValue("id"), workspaceOwner(r), name, body)
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+	w.Header().Set("Location", h.urls.url(r, workspaceFilePath(r.PathValue("id"), name)))
+	writeJSON(w, http.StatusCreated, WorkspaceFile{Path: name, Size: n})
+}
+
+// workspaceFilePath is the API path of a workspace file, escaped.
+func workspaceFilePath(id, name string) string {
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return pathWorkspaces + "/" + url.PathEscape(id) + "/files/" + strings.Join(segments, "/")
+}
+
+func (h *Handlers) HandleGetWorkspaceFile(w http.ResponseWriter, r *http.Request) {
+	if h.workspaces == nil {
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspacesDisabled, "workspaces are not enabled on this server"))
+		return
+	}
+
+	f, err := h.workspaces.Open(r.PathValue("id"), workspaceOwner(r), r.PathValue("path"))
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeWorkspaceError(w, err, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, f); err != nil {
+		log.Warn().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("workspace download interrupted")
+	}
+}
+
+// writeWorkspaceError maps workspace store errors to API errors.
+func writeWorkspaceError(w http.ResponseWriter, err error, r *http.Request) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, workspace.ErrNotFound):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspaceNotFound, "workspace or file not found"))
+	case errors.Is(err, workspace.ErrInvalidPath):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInvalidPath, err.Error()))
+	case errors.Is(err, workspace.ErrTooLarge), errors.As(err, &maxBytesErr):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspaceTooLarge, "file exceeds workspace size limits"))
+	case errors.Is(err, workspace.ErrLimitReached):
+		apierror.WriteError(w, r, apierror.New(apierror.CodeWorkspaceLimit, "too many active workspaces"))
+	default:
+		log.Error().Err(err).Str("request_id", RequestIDFromContext(r.Context())).Msg("workspace operation failed")
+		apierror.WriteError(w, r, apierror.New(apierror.CodeInternal, "workspace operation failed"))
+	}
+}
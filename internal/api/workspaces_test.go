@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	"safe-agent-sandbox/internal/workspace"
+)
+
+func newWorkspaceServer(t *testing.T, keys ...string) http.Handler {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Workspaces.Root = t.TempDir()
+	cfg.Sandbox.Workspaces.MaxFileBytes = 2 << 20
+	cfg.Sandbox.Workspaces.MaxTotalBytes = 3 << 20
+	cfg.Security.AllowedKeys = keys
+	cfg.Security.AllowUnauthenticated = len(keys) == 0
+	cfg.Security.RateLimitRPS = 1000
+	cfg.Security.RateLimitBurst = 1000
+
+	s := NewServer(cfg, &mockBackend{}, nil, nil, monitor.NewMetrics())
+	t.Cleanup(s.handlers.workspaces.Close)
+	return s.httpServer.Handler
+}
+
+func doRequest(h http.Handler, method, path, key string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func createWorkspace(t *testing.T, h http.Handler, key string) string {
+	t.Helper()
+	rec := doRequest(h, http.MethodPost, "/workspaces", key, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create workspace: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp WorkspaceResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.ID
+}
+
+func TestWorkspaces_UploadListDownloadDelete(t *testing.T) {
+	h := newWorkspaceServer(t)
+	id := createWorkspace(t, h, "")
+
+	rec := doRequest(h, http.MethodPut, "/workspaces/"+id+"/files/data/input.txt", "", strings.NewReader("hello"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(h, http.MethodGet, "/workspaces/"+id+"/files", "", nil)
+	var files []WorkspaceFile
+	if err := json.NewDecoder(rec.Body).Decode(&files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "data/input.txt" || files[0].Size != 5 {
+		t.Errorf("list = %+v", files)
+	}
+
+	rec = doRequest(h, http.MethodGet, "/workspaces/"+id+"/files/data/input.txt", "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("download: status %d body %q", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(h, http.MethodDelete, "/workspaces/"+id, "", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d", rec.Code)
+	}
+	rec = doRequest(h, http.MethodGet, "/workspaces/"+id+"/files", "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("list after delete: status %d, want 404", rec.Code)
+	}
+}
+
+func TestWorkspaces_PathTraversal(t *testing.T) {
+	h := newWorkspaceServer(t)
+	id := createWorkspace(t, h, "")
+
+	// Encoded dots and slashes survive ServeMux path cleaning and reach the store.
+	for _, p := range []string{"%2e%2e/escape", "a/%2e%2e/%2e%2e/escape", "%2Fetc%2Fpasswd"} {
+		rec := doRequest(h, http.MethodPut, "/workspaces/"+id+"/files/"+p, "", strings.NewReader("x"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want 400", p, rec.Code)
+		}
+	}
+}
+
+func TestWorkspaces_UploadBypassesBodyLimitButNotFileCap(t *testing.T) {
+	h := newWorkspaceServer(t)
+	id := createWorkspace(t, h, "")
+
+	// Larger than the 1MB global cap, within the 2MB file cap.
+	rec := doRequest(h, http.MethodPut, "/workspaces/"+id+"/files/ok.bin", "", strings.NewReader(strings.Repeat("x", 3<<19)))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("1.5MB upload: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(h, http.MethodPut, "/workspaces/"+id+"/files/big.bin", "", strings.NewReader(strings.Repeat("x", 2<<20+1)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over file cap: status %d, want 413", rec.Code)
+	}
+}
+
+func TestWorkspaces_OwnedByAPIKey(t *testing.T) {
+	h := newWorkspaceServer(t, "key-a", "key-b")
+	id := createWorkspace(t, h, "key-a")
+
+	rec := doRequest(h, http.MethodPut, "/workspaces/"+id+"/files/f.txt", "key-b", strings.NewReader("x"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("upload by other key: status %d, want 404", rec.Code)
+	}
+	rec = doRequest(h, http.MethodDelete, "/workspaces/"+id, "key-b", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("delete by other key: status %d, want 404", rec.Code)
+	}
+	rec = doRequest(h, http.MethodGet, "/workspaces/"+id+"/files", "key-a", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("list by owner: status %d, want 200", rec.Code)
+	}
+}
+
+func TestHandleExecute_WorkspaceValidation(t *testing.T) {
+	h := newTestHandlers(&mockBackend{})
+
+	rec := postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)", WorkspaceID: "x"})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("workspaces disabled: status %d, want 404", rec.Code)
+	}
+
+	store, err := workspace.NewStore(t.TempDir(), time.Hour, 1<<20, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	h.workspaces = store
+	rec = postJSON(t, h.HandleExecute, ExecutionRequest{Language: "python", Code: "print(1)", WorkspaceID: "x", WorkDir: "/tmp"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("work_dir with workspace_id: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,274 @@
+package codebundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestName is the file at an archive's root that declares how to run it.
+const ManifestName = "manifest.json"
+
+// Manifest is a bundle's manifest.json.
+type Manifest struct {
+	Entrypoint string `json:"entrypoint"` // Path of the file run as the execution's code
+	Language   string `json:"language"`
+}
+
+// Contents is a bundle's files, read from an archive whose digest matched.
+type Contents struct {
+	Digest   string
+	Manifest Manifest
+	files    map[string]archiveFile
+}
+
+type archiveFile struct {
+	data       []byte
+	executable bool
+}
+
+// Entrypoint returns the source of the manifest's entrypoint.
+func (c *Contents) Entrypoint() string {
+	return string(c.files[c.Manifest.Entrypoint].data)
+}
+
+// Paths returns the bundle's file paths, sorted.
+func (c *Contents) Paths() []string {
+	paths := make([]string, 0, len(c.files))
+	for p := range c.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// limits bounds what an uploaded archive may hold.
+type limits struct {
+	maxBytes  int64    // total size of the files
+	maxFiles  int      // regular files
+	languages []string // a manifest may declare
+}
+
+// readUpload reads a tar.gz upload into its files, refusing anything but
+// regular files and directories, paths that are absolute, contain .. or
+// appear twice, and archives past the limits or without a valid manifest.
+func readUpload(r io.Reader, l limits) (map[string]archiveFile, Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, Manifest{}, fmt.Errorf("%w: not a gzip stream: %w", ErrInvalid, err)
+	}
+	defer gz.Close()
+
+	files := make(map[string]archiveFile)
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Manifest{}, fmt.Errorf("%w: reading archive: %w", ErrInvalid, err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader || hdr.Typeflag == tar.TypeDir && path.Clean(hdr.Name) == "." {
+			continue // git archive's comment, and the root of a tar of "."
+		}
+		name, err := cleanPath(hdr.Name)
+		if err != nil {
+			return nil, Manifest{}, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, Manifest{}, fmt.Errorf("%w: %s: only regular files and directories are allowed", ErrInvalid, name)
+		}
+		if _, dup := files[name]; dup {
+			return nil, Manifest{}, fmt.Errorf("%w: %s appears more than once", ErrInvalid, name)
+		}
+		if len(files) == l.maxFiles {
+			return nil, Manifest{}, fmt.Errorf("%w: more than %d files", ErrTooLarge, l.maxFiles)
+		}
+		if hdr.Size > l.maxBytes-total {
+			return nil, Manifest{}, fmt.Errorf("%w: files total more than %d bytes", ErrTooLarge, l.maxBytes)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, Manifest{}, fmt.Errorf("%w: reading %s: %w", ErrInvalid, name, err)
+		}
+		total += int64(len(data))
+		files[name] = archiveFile{data: data, executable: hdr.Mode&0o111 != 0}
+	}
+
+	m, err := readManifest(files, l.languages)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+	return files, m, nil
+}
+
+// cappedReader fails with ErrTooLarge once more than max bytes are read.
+type cappedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	if c.read > c.max {
+		return n, fmt.Errorf("%w: upload over %d bytes", ErrTooLarge, c.max)
+	}
+	return n, err
+}
+
+// readManifest decodes and checks the manifest among files.
+func readManifest(files map[string]archiveFile, languages []string) (Manifest, error) {
+	f, ok := files[ManifestName]
+	if !ok {
+		return Manifest{}, fmt.Errorf("%w: no %s at the archive's root", ErrInvalid, ManifestName)
+	}
+	var m Manifest
+	dec := json.NewDecoder(bytes.NewReader(f.data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("%w: %s: %v", ErrInvalid, ManifestName, err)
+	}
+	if m.Entrypoint == "" || m.Language == "" {
+		return Manifest{}, fmt.Errorf("%w: %s needs entrypoint and language", ErrInvalid, ManifestName)
+	}
+	entrypoint, err := cleanPath(m.Entrypoint)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if _, ok := files[entrypoint]; !ok || entrypoint == ManifestName {
+		return Manifest{}, fmt.Errorf("%w: entrypoint %s is not a file in the archive", ErrInvalid, m.Entrypoint)
+	}
+	m.Entrypoint = entrypoint
+	if languages != nil && !slices.Contains(languages, m.Language) {
+		return Manifest{}, fmt.Errorf("%w: language %q can't run a bundle (known: %s)", ErrInvalid, m.Language, strings.Join(languages, ", "))
+	}
+	return m, nil
+}
+
+// canonicalize writes files as the archive a bundle is stored and addressed
+// as: an uncompressed tar of the regular files in path order, with
+// everything but their paths, contents and whether they are executable
+// zeroed. The same files give the same bytes however they were packed.
+func canonicalize(files map[string]archiveFile) ([]byte, error) {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, p := range paths {
+		f := files[p]
+		mode := int64(0o644)
+		if f.executable {
+			mode = 0o755
+		}
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     p,
+			Mode:     mode,
+			Size:     int64(len(f.data)),
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// digestOf is the address of a canonical archive.
+func digestOf(archive []byte) string {
+	sum := sha256.Sum256(archive)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ValidDigest reports whether s is written the way digests are:
+// "sha256:" and 64 lowercase hex digits.
+func ValidDigest(s string) bool {
+	hexPart, ok := strings.CutPrefix(s, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hexPart)
+	return err == nil && strings.ToLower(hexPart) == hexPart
+}
+
+// readCanonical reads a stored archive back into its files, after checking
+// it still has digest.
+func readCanonical(digest string, archive []byte) (*Contents, error) {
+	if digestOf(archive) != digest {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, digest)
+	}
+	files := make(map[string]archiveFile)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, digest, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, digest, err)
+		}
+		files[hdr.Name] = archiveFile{data: data, executable: hdr.Mode&0o111 != 0}
+	}
+	m, err := readManifest(files, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, digest, err)
+	}
+	return &Contents{Digest: digest, Manifest: m, files: files}, nil
+}
+
+// cleanPath checks an archive member's path and returns it in clean,
+// slash-separated form. Absolute paths, .. components and the root itself
+// are refused.
+func cleanPath(name string) (string, error) {
+	name = strings.TrimPrefix(name, "./")
+	if name == "" || strings.ContainsRune(name, 0) || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("%w: invalid path %q", ErrInvalid, name)
+	}
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("%w: %q must be relative", ErrInvalid, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q must not contain ..", ErrInvalid, name)
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || !fs.ValidPath(cleaned) {
+		return "", fmt.Errorf("%w: invalid path %q", ErrInvalid, name)
+	}
+	return cleaned, nil
+}
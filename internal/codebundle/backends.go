@@ -0,0 +1,247 @@
+package codebundle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"safe-agent-sandbox/internal/storage"
+)
+
+// diskBackend keeps each bundle as <dir>/<hex>.tar, its canonical archive,
+// and <dir>/<hex>.json, its record.
+type diskBackend struct {
+	dir string
+	mu  sync.Mutex // serializes record writes
+}
+
+// NewDiskBackend stores bundles under dir, creating it if needed.
+func NewDiskBackend(dir string) (Backend, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("bundle dir %q must be an absolute path", dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating bundle dir: %w", err)
+	}
+	return &diskBackend{dir: filepath.Clean(dir)}, nil
+}
+
+func (d *diskBackend) Put(_ context.Context, b Bundle, archive []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Stat(digestFile(d.dir, b.Digest, ".json")); err == nil {
+		return nil
+	}
+	// The archive goes first: a record is only ever written for one that
+	// is in place.
+	if err := d.writeFile(digestFile(d.dir, b.Digest, ".tar"), archive); err != nil {
+		return err
+	}
+	return d.writeRecord(b)
+}
+
+func (d *diskBackend) Stat(_ context.Context, digest string) (Bundle, error) {
+	data, err := os.ReadFile(digestFile(d.dir, digest, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Bundle{}, ErrNotFound
+	}
+	if err != nil {
+		return Bundle{}, fmt.Errorf("reading bundle record: %w", err)
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Bundle{}, fmt.Errorf("reading bundle record %s: %w", digest, err)
+	}
+	return b, nil
+}
+
+func (d *diskBackend) Archive(_ context.Context, digest string) ([]byte, error) {
+	data, err := os.ReadFile(digestFile(d.dir, digest, ".tar"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle: %w", err)
+	}
+	return data, nil
+}
+
+func (d *diskBackend) SetFlags(ctx context.Context, digest string, approved, pinned bool) error {
+	return d.update(ctx, digest, func(b *Bundle) { b.Approved, b.Pinned = approved, pinned })
+}
+
+func (d *diskBackend) Touch(ctx context.Context, digest string, usedAt time.Time) error {
+	return d.update(ctx, digest, func(b *Bundle) { b.LastUsedAt = usedAt })
+}
+
+// update rewrites a bundle's record with change applied.
+func (d *diskBackend) update(ctx context.Context, digest string, change func(*Bundle)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, err := d.Stat(ctx, digest)
+	if err != nil {
+		return err
+	}
+	change(&b)
+	return d.writeRecord(b)
+}
+
+func (d *diskBackend) Delete(_ context.Context, digest string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The record goes first, so a bundle is never listed without its archive.
+	if err := os.Remove(digestFile(d.dir, digest, ".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(digestFile(d.dir, digest, ".tar")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *diskBackend) List(ctx context.Context) ([]Bundle, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing bundles: %w", err)
+	}
+	var bundles []Bundle
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !ValidDigest("sha256:"+name) {
+			continue
+		}
+		b, err := d.Stat(ctx, "sha256:"+name)
+		if err != nil {
+			continue // deleted since ReadDir
+		}
+		bundles = append(bundles, b)
+	}
+	return bundles, nil
+}
+
+func (d *diskBackend) writeRecord(b Bundle) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return d.writeFile(digestFile(d.dir, b.Digest, ".json"), data)
+}
+
+// writeFile replaces name with data by way of a temp file, so a reader
+// never sees it half written.
+func (d *diskBackend) writeFile(name string, data []byte) error {
+	tmp := filepath.Join(d.dir, ".tmp-"+uuid.New().String())
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	return nil
+}
+
+// dbBackend keeps bundles in the code_bundles table.
+type dbBackend struct {
+	db *storage.DB
+}
+
+// NewDBBackend stores bundles in Postgres (migration 011_code_bundles.sql).
+func NewDBBackend(db *storage.DB) Backend {
+	return &dbBackend{db: db}
+}
+
+func (d *dbBackend) Put(ctx context.Context, b Bundle, archive []byte) error {
+	row := toRow(b)
+	row.Archive = archive
+	return d.db.PutCodeBundle(ctx, row)
+}
+
+func (d *dbBackend) Stat(ctx context.Context, digest string) (Bundle, error) {
+	row, err := d.db.GetCodeBundle(ctx, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Bundle{}, ErrNotFound
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	return fromRow(row), nil
+}
+
+func (d *dbBackend) Archive(ctx context.Context, digest string) ([]byte, error) {
+	archive, err := d.db.GetCodeBundleArchive(ctx, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return archive, err
+}
+
+func (d *dbBackend) SetFlags(ctx context.Context, digest string, approved, pinned bool) error {
+	err := d.db.SetCodeBundleFlags(ctx, digest, approved, pinned)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (d *dbBackend) Touch(ctx context.Context, digest string, usedAt time.Time) error {
+	return d.db.TouchCodeBundle(ctx, digest, usedAt)
+}
+
+func (d *dbBackend) Delete(ctx context.Context, digest string) error {
+	return d.db.DeleteCodeBundle(ctx, digest)
+}
+
+func (d *dbBackend) List(ctx context.Context) ([]Bundle, error) {
+	rows, err := d.db.ListCodeBundles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundles := make([]Bundle, len(rows))
+	for i, row := range rows {
+		bundles[i] = fromRow(row)
+	}
+	return bundles, nil
+}
+
+func toRow(b Bundle) storage.CodeBundle {
+	return storage.CodeBundle{
+		Digest:     b.Digest,
+		Entrypoint: b.Manifest.Entrypoint,
+		Language:   b.Manifest.Language,
+		SizeBytes:  b.Size,
+		Files:      b.Files,
+		Approved:   b.Approved,
+		Pinned:     b.Pinned,
+		CreatedAt:  b.CreatedAt,
+		LastUsedAt: b.LastUsedAt,
+	}
+}
+
+func fromRow(row storage.CodeBundle) Bundle {
+	return Bundle{
+		Digest:     row.Digest,
+		Manifest:   Manifest{Entrypoint: row.Entrypoint, Language: row.Language},
+		Size:       row.SizeBytes,
+		Files:      row.Files,
+		Approved:   row.Approved,
+		Pinned:     row.Pinned,
+		CreatedAt:  row.CreatedAt,
+		LastUsedAt: row.LastUsedAt,
+	}
+}
+
+// digestFile is the file name a digest is stored under: its hex part.
+func digestFile(dir, digest, ext string) string {
+	return filepath.Join(dir, strings.TrimPrefix(digest, "sha256:")+ext)
+}
@@ -0,0 +1,262 @@
+// Package codebundle keeps the code bundles that executions run by
+// reference: tar.gz uploads of files with a manifest.json naming the
+// entrypoint and language, stored content-addressed by the SHA-256 of a
+// canonical form of the archive. The digest is checked again each time a
+// bundle is read, so what runs is byte for byte what was uploaded and
+// reviewed.
+package codebundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNotFound = errors.New("bundle not found")
+	ErrInvalid  = errors.New("invalid bundle")
+	ErrTooLarge = errors.New("bundle size limit exceeded")
+	ErrCorrupt  = errors.New("stored bundle does not match its digest")
+)
+
+// Bundle is what is kept about a bundle besides its archive.
+type Bundle struct {
+	Digest     string
+	Manifest   Manifest
+	Size       int64 // bytes in the canonical archive
+	Files      int
+	Approved   bool // may run when approval is required
+	Pinned     bool // kept however long it goes unused
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Backend keeps bundles' canonical archives and their records.
+type Backend interface {
+	// Put stores a bundle unless one with its digest exists, whose record
+	// it then leaves as it is.
+	Put(ctx context.Context, b Bundle, archive []byte) error
+	Stat(ctx context.Context, digest string) (Bundle, error)
+	// Archive returns the stored bytes, unchecked.
+	Archive(ctx context.Context, digest string) ([]byte, error)
+	SetFlags(ctx context.Context, digest string, approved, pinned bool) error
+	Touch(ctx context.Context, digest string, usedAt time.Time) error
+	Delete(ctx context.Context, digest string) error
+	List(ctx context.Context) ([]Bundle, error)
+}
+
+// Options are a store's limits.
+type Options struct {
+	MaxBytes  int64         // cap on an upload, compressed, and on its files' total size
+	MaxFiles  int           // cap on an upload's regular files
+	TTL       time.Duration // unpinned bundles unused this long are deleted; 0 keeps them
+	Languages []string      // languages a manifest may declare
+}
+
+// Store adds, reads and expires bundles in a backend.
+type Store struct {
+	backend Backend
+	opts    Options
+	now     func() time.Time
+
+	cancelCleanup context.CancelFunc
+}
+
+// NewStore starts the TTL sweeper over backend. Call Close to stop it.
+func NewStore(backend Backend, opts Options) *Store {
+	s := &Store{backend: backend, opts: opts, now: time.Now}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelCleanup = cancel
+	if opts.TTL > 0 {
+		go s.cleanupLoop(ctx)
+	}
+	return s
+}
+
+// MaxBytes is the cap on an upload.
+func (s *Store) MaxBytes() int64 { return s.opts.MaxBytes }
+
+// Close stops the TTL sweeper.
+func (s *Store) Close() {
+	s.cancelCleanup()
+}
+
+// Add validates a tar.gz upload and stores it under its digest. Adding a
+// bundle that is already stored returns the stored one.
+func (s *Store) Add(ctx context.Context, r io.Reader) (Bundle, error) {
+	files, m, err := readUpload(&cappedReader{r: r, max: s.opts.MaxBytes}, limits{
+		maxBytes:  s.opts.MaxBytes,
+		maxFiles:  s.opts.MaxFiles,
+		languages: s.opts.Languages,
+	})
+	if err != nil {
+		return Bundle{}, err
+	}
+	archive, err := canonicalize(files)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("writing canonical archive: %w", err)
+	}
+	now := s.now()
+	b := Bundle{
+		Digest:     digestOf(archive),
+		Manifest:   m,
+		Size:       int64(len(archive)),
+		Files:      len(files),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if err := s.backend.Put(ctx, b, archive); err != nil {
+		return Bundle{}, err
+	}
+	return s.backend.Stat(ctx, b.Digest)
+}
+
+// Stat returns a bundle's record.
+func (s *Store) Stat(ctx context.Context, digest string) (Bundle, error) {
+	if !ValidDigest(digest) {
+		return Bundle{}, ErrNotFound
+	}
+	return s.backend.Stat(ctx, digest)
+}
+
+// Open reads a bundle's files for an execution, refusing them with
+// ErrCorrupt unless the stored archive still has its digest, and marks the
+// bundle used.
+func (s *Store) Open(ctx context.Context, digest string) (*Contents, error) {
+	if !ValidDigest(digest) {
+		return nil, ErrNotFound
+	}
+	archive, err := s.backend.Archive(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	c, err := readCanonical(digest, archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.Touch(ctx, digest, s.now()); err != nil {
+		log.Warn().Err(err).Str("digest", digest).Msg("failed to record bundle use")
+	}
+	return c, nil
+}
+
+// SetFlags approves or pins a bundle, or takes either back; a nil flag is
+// left as it is.
+func (s *Store) SetFlags(ctx context.Context, digest string, approved, pinned *bool) (Bundle, error) {
+	b, err := s.Stat(ctx, digest)
+	if err != nil {
+		return Bundle{}, err
+	}
+	if approved != nil {
+		b.Approved = *approved
+	}
+	if pinned != nil {
+		b.Pinned = *pinned
+	}
+	if err := s.backend.SetFlags(ctx, digest, b.Approved, b.Pinned); err != nil {
+		return Bundle{}, err
+	}
+	return b, nil
+}
+
+// cleanupLoop removes expired bundles once a minute.
+func (s *Store) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.cleanupExpired(ctx, s.now())
+			if err != nil {
+				log.Warn().Err(err).Msg("bundle cleanup failed")
+			}
+			if n > 0 {
+				log.Info().Int("count", n).Msg("removed unused bundles")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupExpired deletes the unpinned bundles last used more than the TTL
+// before now.
+func (s *Store) cleanupExpired(ctx context.Context, now time.Time) (int, error) {
+	bundles, err := s.backend.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, b := range bundles {
+		if b.Pinned || now.Sub(b.LastUsedAt) <= s.opts.TTL {
+			continue
+		}
+		if err := s.backend.Delete(ctx, b.Digest); err != nil {
+			log.Warn().Err(err).Str("digest", b.Digest).Msg("failed to remove unused bundle")
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Extract writes the bundle's files under dir, which must exist: readable
+// by anyone, since sandboxed code runs as a uid that differs from ours.
+func (c *Contents) Extract(dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("opening bundle directory: %w", err)
+	}
+	defer root.Close()
+	for _, p := range c.Paths() {
+		f := c.files[p]
+		if err := mkdirAll(root, path.Dir(p)); err != nil {
+			return err
+		}
+		mode := os.FileMode(0o644)
+		if f.executable {
+			mode = 0o755
+		}
+		out, err := root.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", p, err)
+		}
+		_, err = out.Write(f.data)
+		if err == nil {
+			err = out.Chmod(mode) // past the umask
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", p, err)
+		}
+	}
+	return os.Chmod(dir, 0o755) // #nosec G302 -- see above
+}
+
+// mkdirAll creates dir and its parents inside root.
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	cur := ""
+	for _, part := range strings.Split(dir, "/") {
+		cur = path.Join(cur, part)
+		if err := root.Mkdir(cur, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("creating %s: %w", cur, err)
+		}
+		if d, err := root.Open(cur); err == nil {
+			_ = d.Chmod(0o755) // #nosec G302 -- see Extract
+			_ = d.Close()
+		}
+	}
+	return nil
+}
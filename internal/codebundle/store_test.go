@@ -0,0 +1,229 @@
+package codebundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entry is one member of a test archive.
+type entry struct {
+	name     string
+	body     string
+	typeflag byte // tar.TypeReg when 0
+	mode     int64
+	linkname string
+	modTime  time.Time
+}
+
+func pack(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: e.mode, Linkname: e.linkname, ModTime: e.modTime}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o600
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var manifest = entry{name: "manifest.json", body: `{"entrypoint": "main.py", "language": "python"}`}
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	backend, err := NewDiskBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(backend, Options{MaxBytes: 1 << 10, MaxFiles: 4, Languages: []string{"python", "node"}})
+	t.Cleanup(s.Close)
+	return s, dir
+}
+
+func TestAdd_RejectsUnsafeArchives(t *testing.T) {
+	main := entry{name: "main.py", body: "print('hi')"}
+	tests := []struct {
+		name    string
+		entries []entry
+		want    error
+		msg     string
+	}{
+		{"traversal", []entry{manifest, main, {name: "../escape.py", body: "x"}}, ErrInvalid, "must not contain .."},
+		{"nested traversal", []entry{manifest, main, {name: "lib/../../escape.py", body: "x"}}, ErrInvalid, "must not contain .."},
+		{"absolute", []entry{manifest, main, {name: "/etc/cron.d/x", body: "x"}}, ErrInvalid, "must be relative"},
+		{"symlink", []entry{manifest, main, {name: "lib", typeflag: tar.TypeSymlink, linkname: "/etc"}}, ErrInvalid, "only regular files"},
+		{"hard link", []entry{manifest, main, {name: "passwd", typeflag: tar.TypeLink, linkname: "/etc/passwd"}}, ErrInvalid, "only regular files"},
+		{"device", []entry{manifest, main, {name: "null", typeflag: tar.TypeChar}}, ErrInvalid, "only regular files"},
+		{"duplicate", []entry{manifest, main, {name: "./main.py", body: "print('other')"}}, ErrInvalid, "more than once"},
+		{"no manifest", []entry{main}, ErrInvalid, "no manifest.json"},
+		{"missing entrypoint", []entry{manifest, {name: "other.py", body: "x"}}, ErrInvalid, "not a file in the archive"},
+		{"traversing entrypoint", []entry{{name: "manifest.json", body: `{"entrypoint": "../main.py", "language": "python"}`}, main}, ErrInvalid, "must not contain .."},
+		{"unknown language", []entry{{name: "manifest.json", body: `{"entrypoint": "main.py", "language": "cobol"}`}, main}, ErrInvalid, `language "cobol"`},
+		{"too many files", []entry{manifest, main, {name: "a", body: "a"}, {name: "b", body: "b"}, {name: "c", body: "c"}}, ErrTooLarge, "more than 4 files"},
+		{"too many bytes", []entry{manifest, main, {name: "big", body: strings.Repeat("x", 1<<10)}}, ErrTooLarge, "more than 1024 bytes"},
+	}
+	s, _ := newTestStore(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Add(context.Background(), bytes.NewReader(pack(t, tt.entries...)))
+			if !errors.Is(err, tt.want) || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("Add() = %v, want %v saying %q", err, tt.want, tt.msg)
+			}
+		})
+	}
+
+	if _, err := s.Add(context.Background(), strings.NewReader("not gzip")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Add(not gzip) = %v, want ErrInvalid", err)
+	}
+}
+
+func TestAdd_DigestIsCanonical(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	a, err := s.Add(ctx, bytes.NewReader(pack(t, manifest,
+		entry{name: "main.py", body: "import lib.util"},
+		entry{name: "lib/util.py", body: "X = 1", mode: 0o755},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidDigest(a.Digest) || a.Files != 3 || a.Manifest != (Manifest{Entrypoint: "main.py", Language: "python"}) {
+		t.Errorf("added %+v", a)
+	}
+
+	// The same files, packed in another order with other metadata.
+	b, err := s.Add(ctx, bytes.NewReader(pack(t,
+		entry{name: "./lib/", typeflag: tar.TypeDir, mode: 0o700},
+		entry{name: "./lib/util.py", body: "X = 1", mode: 0o700, modTime: time.Now()},
+		entry{name: "./main.py", body: "import lib.util", mode: 0o640},
+		manifest,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Digest != a.Digest {
+		t.Errorf("digest %s for the same files, want %s", b.Digest, a.Digest)
+	}
+
+	c, err := s.Add(ctx, bytes.NewReader(pack(t, manifest, entry{name: "main.py", body: "import lib.util "}, entry{name: "lib/util.py", body: "X = 1", mode: 0o755})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Digest == a.Digest {
+		t.Error("a changed file kept the digest")
+	}
+}
+
+func TestOpen_VerifiesDigest(t *testing.T) {
+	s, dir := newTestStore(t)
+	ctx := context.Background()
+	b, err := s.Add(ctx, bytes.NewReader(pack(t, manifest, entry{name: "main.py", body: "print('reviewed')"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.Open(ctx, b.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Entrypoint() != "print('reviewed')" {
+		t.Errorf("entrypoint %q", c.Entrypoint())
+	}
+	out := t.TempDir()
+	if err := c.Extract(out); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "main.py")); err != nil || string(data) != "print('reviewed')" {
+		t.Errorf("extracted main.py: %q, %v", data, err)
+	}
+
+	// Someone with access to the store swaps the code.
+	archive := digestFile(dir, b.Digest, ".tar")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, bytes.Replace(data, []byte("reviewed"), []byte("tampered"), 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(ctx, b.Digest); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open(tampered) = %v, want ErrCorrupt", err)
+	}
+
+	if _, err := s.Open(ctx, "sha256:"+strings.Repeat("0", 64)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open(unknown) = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Open(ctx, "../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open(path) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCleanupExpired(t *testing.T) {
+	s, _ := newTestStore(t)
+	s.opts.TTL = time.Hour
+	ctx := context.Background()
+	start := time.Unix(1000, 0)
+	s.now = func() time.Time { return start }
+
+	unused, err := s.Add(ctx, bytes.NewReader(pack(t, manifest, entry{name: "main.py", body: "1"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := s.Add(ctx, bytes.NewReader(pack(t, manifest, entry{name: "main.py", body: "2"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := s.Add(ctx, bytes.NewReader(pack(t, manifest, entry{name: "main.py", body: "3"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	yes := true
+	if _, err := s.SetFlags(ctx, pinned.Digest, nil, &yes); err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return start.Add(50 * time.Minute) }
+	if _, err := s.Open(ctx, used.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.cleanupExpired(ctx, start.Add(90*time.Minute)); n != 1 || err != nil {
+		t.Fatalf("removed %d, %v; want 1", n, err)
+	}
+	if _, err := s.Stat(ctx, unused.Digest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unused bundle: %v, want removed", err)
+	}
+	for _, d := range []string{pinned.Digest, used.Digest} {
+		if _, err := s.Stat(ctx, d); err != nil {
+			t.Errorf("%s: %v, want kept", d, err)
+		}
+	}
+}
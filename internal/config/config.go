@@ -1,13 +1,19 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,14 +28,39 @@ type Config struct {
 	Pool      PoolConfig      `yaml:"pool"`
 	TLS       TLSConfig       `yaml:"tls"`
 	AuthProxy AuthProxyConfig `yaml:"auth_proxy"`
+	Jobs      []JobConfig     `yaml:"jobs"`
 }
 
 // AuthProxyConfig controls the host-side reverse proxy that injects API
 // tokens so they never enter containers.
 type AuthProxyConfig struct {
-	Port         int    `yaml:"port"`           // 0 = disabled (default), >0 = listen on this port
-	Secret       string `yaml:"-"`              // Generated at runtime, not from config file
-	MaxProxyRPM  int    `yaml:"max_proxy_rpm"`  // global requests-per-minute cap (default 300, 0 = unlimited)
+	Port        int    `yaml:"port"`          // 0 = disabled (default), >0 = listen on this port
+	Secret      string `yaml:"-"`             // Generated at runtime, not from config file
+	MaxProxyRPM int    `yaml:"max_proxy_rpm"` // global requests-per-minute cap (default 300, 0 = unlimited)
+	// Connections to the API. Idle connections are kept for
+	// security.max_concurrent_claude sessions.
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // how long the API has to start answering (default 10m, 0 = no limit)
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`       // how long an unused connection is kept (default 90s, 0 = no limit)
+	DNSCacheTTL           time.Duration `yaml:"dns_cache_ttl"`           // how long a lookup of api.anthropic.com is reused (default 30s, 0 = no cache)
+	// Who can reach the proxy. Bind is the address it listens on: "" for
+	// 127.0.0.1, "docker_gateway" for the gateway of Docker's bridge
+	// network, or an IP.
+	Bind                string             `yaml:"bind"`
+	ACL                 AuthProxyACLConfig `yaml:"acl"`
+	TLS                 bool               `yaml:"tls"`                   // serve TLS with a per-startup CA that claude containers are given
+	PerExecutionSecrets bool               `yaml:"per_execution_secrets"` // give each claude execution a secret of its own and refuse the shared one
+	ListenIP            string             `yaml:"-"`                     // Bind resolved at startup
+	CAFile              string             `yaml:"-"`                     // the per-startup CA certificate when TLS is on, written at startup
+}
+
+// AuthProxyACLConfig limits the proxy to connections from the subnets of
+// Docker's bridge network, which claude containers run in, and
+// AllowedCIDRs. The subnets are read from the daemon at startup and every
+// Refresh.
+type AuthProxyACLConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	AllowedCIDRs []string      `yaml:"allowed_cidrs"` // accepted besides the bridge's subnets
+	Refresh      time.Duration `yaml:"refresh"`       // how often the bridge's subnets are re-read (default 5m, 0 = only at startup)
 }
 
 type ServerConfig struct {
@@ -39,17 +70,452 @@ type ServerConfig struct {
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 	MaxRequestBody  int64         `yaml:"max_request_body_bytes"`
+	// PlaintextHealthPort serves GET /health alone over plain HTTP, for load
+	// balancer checks that can't present a client certificate. 0 = disabled.
+	PlaintextHealthPort int `yaml:"plaintext_health_port"`
+	// ClaudeWriteTimeout replaces WriteTimeout for POST /execute and
+	// /execute/stream once the request turns out to be for claude, so
+	// WriteTimeout only has to cover everything else. 0 = no deadline.
+	ClaudeWriteTimeout time.Duration `yaml:"claude_write_timeout"`
+	// AsyncJobTTL is how long the server remembers an asynchronous
+	// execution after it ends: to answer GET /executions/{id} with the
+	// error one without a result ended with, or "running" for one whose
+	// record hasn't been written yet.
+	AsyncJobTTL time.Duration `yaml:"async_job_ttl"`
+	// EnableUI serves a page at GET /ui for running code from a browser.
+	// The page asks for an API key and its API calls are authenticated as
+	// usual.
+	EnableUI bool `yaml:"enable_ui"`
+	// BasePath serves the API under a path prefix, e.g. /sandbox behind an
+	// ingress that forwards /sandbox/... unchanged. Paths outside it are
+	// still served, for a proxy that strips the prefix, and the links in
+	// responses include it either way.
+	BasePath string `yaml:"base_path"`
+	// TrustProxyHeaders builds the absolute URLs in responses from the
+	// X-Forwarded-Proto and X-Forwarded-Host the reverse proxy sets. Only
+	// turn it on behind a proxy that overwrites them.
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// Upgrade is the handover of the server's sockets to a new binary on
+	// SIGUSR2.
+	Upgrade UpgradeConfig `yaml:"upgrade"`
+}
+
+// UpgradeConfig tunes the handover to a new binary on SIGUSR2: the new
+// process starts serving on the old one's sockets, and only then does the
+// old one stop accepting and drain.
+type UpgradeConfig struct {
+	// Binary is the new server binary. Empty runs the path this one was
+	// started from, where a deploy puts the new one.
+	Binary string `yaml:"binary"`
+	// ReadyTimeout is how long the new process has to start serving before
+	// the handover is abandoned and the old one carries on.
+	ReadyTimeout time.Duration `yaml:"ready_timeout"`
+	// DrainTimeout is how long the old process then waits for its
+	// executions before interrupting them. 0 = as long as they take.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
 }
 
 type SandboxConfig struct {
-	ContainerdSocket    string        `yaml:"containerd_socket"`
-	Namespace           string        `yaml:"namespace"`
-	DefaultTimeout      time.Duration `yaml:"default_timeout"`
-	MaxTimeout          time.Duration `yaml:"max_timeout"`
-	MaxConcurrent       int           `yaml:"max_concurrent"`
-	DefaultLimits       DefaultLimits `yaml:"default_limits"`
-	Backend             string        `yaml:"backend"`              // "auto" (default), "containerd", or "docker"
-	AllowedWorkdirRoots []string      `yaml:"allowed_workdir_roots"` // Absolute paths that WorkDir must be under; empty blocks all WorkDir mounts
+	ContainerdSocket    string              `yaml:"containerd_socket"`
+	Namespace           string              `yaml:"namespace"`
+	DefaultTimeout      time.Duration       `yaml:"default_timeout"`
+	MaxTimeout          time.Duration       `yaml:"max_timeout"`
+	MaxConcurrent       int                 `yaml:"max_concurrent"`
+	DefaultLimits       DefaultLimits       `yaml:"default_limits"`
+	Backend             string              `yaml:"backend"`               // "auto" (default), "containerd", or "docker"
+	AllowedWorkdirRoots []string            `yaml:"allowed_workdir_roots"` // Absolute paths that WorkDir must be under; empty blocks all WorkDir mounts
+	RuntimeImages       map[string]string   `yaml:"runtime_images"`        // Per-language image overrides, e.g. deno: "docker.io/denoland/deno:alpine-2.1.4"
+	Chaos               ChaosConfig         `yaml:"chaos"`
+	Workspaces          WorkspaceConfig     `yaml:"workspaces"`
+	SharedMounts        []SharedMountConfig `yaml:"shared_mounts"`
+	Concurrency         map[string]int      `yaml:"concurrency"` // Per-language slots plus "default"; must sum to max_concurrent
+	FairShare           FairShareConfig     `yaml:"fair_share"`
+	ClaudeCaches        ClaudeCachesConfig  `yaml:"claude_caches"`
+	WorkdirLock         WorkdirLockConfig   `yaml:"workdir_lock"`
+	// RuntimeLimits overrides the limits each language's executions get
+	// when their request sets none, e.g. node: {memory_mb: 1024}. Fields
+	// left 0 keep the runtime's default.
+	RuntimeLimits map[string]DefaultLimits `yaml:"runtime_limits"`
+	// Warmup lists the startup optimizations each language may use, e.g.
+	// python: [no_site, ignore_env]. Languages left out use all of theirs
+	// that the image supports; an empty list turns warmup off.
+	Warmup map[string][]string `yaml:"warmup"`
+	// SetupTimeout caps an execution's setup: pulling its image and creating
+	// its container. None of it comes out of the request's timeout, which
+	// starts with the program; running past it fails the execution with
+	// SETUP_TIMEOUT. CleanupGrace caps killing and removing the container
+	// afterwards. server.write_timeout has to cover both on top of
+	// max_timeout.
+	SetupTimeout time.Duration `yaml:"setup_timeout"`
+	CleanupGrace time.Duration `yaml:"cleanup_grace"`
+	// VerifySeccomp starts each non-claude execution (docker backend) through
+	// a /bin/sh wrapper that checks the process is under a seccomp filter and
+	// refuses to run the code otherwise. Images without /bin/sh need it off.
+	VerifySeccomp bool `yaml:"verify_seccomp"`
+	// SeccompHashInterval is how often the docker backend re-hashes each
+	// seccomp profile file it serialized at startup. A changed stat (size,
+	// mtime, inode or mode) forces a hash before the next use anyway, and a
+	// file that fails refuses the execution with SECCOMP_PROFILE_TAMPERED.
+	// 0 hashes before every use.
+	SeccompHashInterval time.Duration `yaml:"seccomp_hash_interval"`
+	// VerifyCodeIntegrity starts each non-claude execution (docker backend)
+	// through a /bin/sh wrapper that checks the mounted code file's SHA-256
+	// against the one the server recorded, failing the execution with
+	// CODE_INTEGRITY_FAILURE instead of running code that differs. Images
+	// without /bin/sh and sha256sum need it off.
+	VerifyCodeIntegrity bool `yaml:"verify_code_integrity"`
+	// VerifyMaskedPaths checks, with a docker exec into each running
+	// container, that the masked paths are covered and the read-only ones
+	// are read-only, and records a security event for any that aren't.
+	// Docker backend only; costs an exec per execution.
+	VerifyMaskedPaths bool `yaml:"verify_masked_paths"`
+	// VerifyClaudeContract runs the claude image with --contract-check, at
+	// startup and whenever its digest changes, and refuses claude executions
+	// the manifest it prints says the image can't run. Docker backend only.
+	VerifyClaudeContract bool `yaml:"verify_claude_contract"`
+	// GeneratePasswd mounts a passwd and group naming the uid non-claude
+	// executions run as, "sandbox" with HOME /tmp, over the image's own,
+	// unless the image has an entry for it already: without one getpwuid
+	// fails, and so do expanduser, git and many other tools. Probed once
+	// per image digest. Docker backend only.
+	GeneratePasswd bool `yaml:"generate_passwd"`
+	// LimitEnforcement is what happens to an execution whose limits the
+	// host doesn't enforce, as found by a canary container run at startup
+	// that reads back its own cgroup limits: strict refuses it with
+	// LIMIT_NOT_ENFORCED, permissive runs it with a limit_not_enforced
+	// warning and security event per gap, and off skips the canary.
+	// Docker backend only.
+	LimitEnforcement string `yaml:"limit_enforcement"`
+	// ClaudeIdleOutputTimeout aborts a claude /execute/stream whose
+	// container writes nothing (output, stderr or stream-json events) for
+	// this long. 0 = no limit.
+	ClaudeIdleOutputTimeout time.Duration `yaml:"claude_idle_output_timeout"`
+	// MaxIdleOutputTimeout caps the idle_output_timeout a request may ask
+	// for. 0 refuses it, so no execution is stopped for going quiet.
+	MaxIdleOutputTimeout time.Duration `yaml:"max_idle_output_timeout"`
+	// MaxUploadCodeBytes caps the code part of a POST /execute/upload,
+	// which is streamed to a temp file rather than held in memory. 0
+	// turns the endpoint off.
+	MaxUploadCodeBytes int64 `yaml:"max_upload_code_bytes"`
+	// MaxStdinBytes caps the stdin a request passes to its program. It
+	// travels in the JSON body, so server.max_request_body_bytes caps it
+	// too. 0 refuses stdin.
+	MaxStdinBytes int64 `yaml:"max_stdin_bytes"`
+	// Output caps the stdout and stderr each execution keeps; the response,
+	// the stream and the audit log all get the same bytes up to them.
+	Output OutputConfig `yaml:"output"`
+	// CNI gives network_enabled executions on the containerd backend a
+	// network. Without it they are refused: their namespace would have no
+	// interfaces. The Docker backend uses Docker's bridge and ignores it.
+	CNI CNIConfig `yaml:"cni"`
+	// DiskPressure watches free space where the container runtime keeps
+	// its images and backs off as it runs out.
+	DiskPressure DiskPressureConfig `yaml:"disk_pressure"`
+	// MaxUlimits caps the ulimits a request may set in limits.ulimits.
+	// Ulimits a request leaves out are derived from its other limits and
+	// capped here too.
+	MaxUlimits UlimitsConfig `yaml:"max_ulimits"`
+	// WorkdirSize caps the size of a claude work_dir, measured by a
+	// bounded walk before the container starts.
+	WorkdirSize WorkdirSizeConfig `yaml:"workdir_size"`
+	// Dedup lets an identical submission arriving while an execution runs
+	// share its result instead of starting a container of its own.
+	Dedup DedupConfig `yaml:"dedup"`
+	// CPUAbuse kills an execution that keeps its CPU quota saturated for a
+	// long stretch, as a miner does, rather than letting it burn the rest
+	// of its timeout.
+	CPUAbuse CPUAbuseConfig `yaml:"cpu_abuse"`
+	// Bundles is the registry of content-addressed code bundles (POST
+	// /bundles) that executions run by bundle_digest.
+	Bundles BundlesConfig `yaml:"bundles"`
+	// EgressAudit records what network-enabled and claude executions
+	// connect to, from the host's connection tracking table.
+	EgressAudit EgressAuditConfig `yaml:"egress_audit"`
+	// Binary governs the binary runtime, which runs the executable a
+	// request sends instead of source code.
+	Binary BinaryConfig `yaml:"binary"`
+	// DefaultEnv sets environment variables in every execution whose
+	// request doesn't set them itself.
+	DefaultEnv DefaultEnvConfig `yaml:"default_env"`
+	// DebugTrace lets the callers in its keys run an execution under
+	// strace (debug_trace in the request). Docker backend only.
+	DebugTrace DebugTraceConfig `yaml:"debug_trace"`
+	// CompileCache keeps what python and node compile a program to between
+	// executions of the same code, so they skip compiling it again. Docker
+	// backend only.
+	CompileCache CompileCacheConfig `yaml:"compile_cache"`
+	// Successor is set at startup in a process an upgrade started. The
+	// containers already running are the old process's, so the startup
+	// sweep for orphaned ones leaves them be.
+	Successor bool `yaml:"-"`
+}
+
+// DefaultEnvConfig is the environment variables executions get without
+// asking, such as TZ=UTC or PYTHONDONTWRITEBYTECODE=1: Global's in every
+// language, and a language's own in Languages over them. A request's
+// permissions.environment sets a variable over both. Keys follow the rules
+// a request's do, blocklist included.
+type DefaultEnvConfig struct {
+	Global    map[string]string            `yaml:"global"`
+	Languages map[string]map[string]string `yaml:"languages"` // By language; the backend rejects unknown ones when it starts
+}
+
+// DebugTraceConfig runs the executions that ask for it under Strace, a
+// statically linked strace on the host mounted read-only into the
+// container, recording the file and network syscalls the code makes. Their
+// seccomp profile allows ptrace, which no other execution's does. The trace
+// is returned with the result, cut at MaxBytes, and never stored. Never for
+// claude.
+type DebugTraceConfig struct {
+	Strace   string   `yaml:"strace"`    // Absolute path of the strace binary; empty turns debug traces off
+	Keys     []string `yaml:"keys"`      // API keys or client certificate identities that may ask for a trace
+	MaxBytes int64    `yaml:"max_bytes"` // Cap on a trace
+}
+
+// CompileCacheConfig is the host directory where compiled code is kept,
+// an entry per API key, language, image digest and code hash. python
+// programs are compiled to bytecode by a launcher that runs them as their
+// script would; node keeps its V8 compile cache there, with node 22.1 or
+// later in the image. An entry is taken from the container after an
+// execution that exits 0 and mounted read-only into later ones with the
+// same key. Entries are dropped when their image's digest changes, and the
+// least recently used once they pass MaxBytes. The cache starts empty with
+// the server.
+type CompileCacheConfig struct {
+	Dir           string   `yaml:"dir"`             // Absolute host directory holding the entries; empty turns the cache off
+	Languages     []string `yaml:"languages"`       // python, node or both
+	MaxBytes      int64    `yaml:"max_bytes"`       // Cap on all entries together
+	MaxEntryBytes int64    `yaml:"max_entry_bytes"` // An execution's artifacts past this aren't kept
+}
+
+// BinaryConfig caps the executables the binary runtime runs. Each must be
+// an ELF executable for the server's architecture, without setuid or
+// setgid bits, and statically linked unless AllowDynamic: a dynamic one
+// needs its loader and libraries in the runtime's image.
+type BinaryConfig struct {
+	MaxBytes     int64 `yaml:"max_bytes"`     // Cap on an executable, however it is sent
+	AllowDynamic bool  `yaml:"allow_dynamic"` // Run executables that ask for an interpreter (PT_INTERP)
+}
+
+// EgressAuditConfig records the connections an execution with a network
+// makes, by reading the host's conntrack table every PollInterval, and once
+// more when it ends, for entries from the container's address. They are
+// kept per destination, at most MaxConnections of them, in the result and
+// the audit log. A connection into SuspiciousCIDRs is a security event for
+// any execution; one into PrivateCIDRs only for an execution that said it
+// needs only the internet (permissions.network.internet_only). Linux only,
+// and as root: where the table can't be read, executions run unaudited and
+// say why.
+type EgressAuditConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Conntrack       string        `yaml:"conntrack"` // The host's conntrack table
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	MaxConnections  int           `yaml:"max_connections"`
+	SuspiciousCIDRs []string      `yaml:"suspicious_cidrs"` // e.g. cloud metadata endpoints
+	PrivateCIDRs    []string      `yaml:"private_cidrs"`
+}
+
+// BundlesConfig keeps code bundles: tar.gz uploads with a manifest.json
+// naming an entrypoint and language, stored by the SHA-256 of their
+// canonical form, on disk under Dir or in the database. An execution's
+// bundle is unpacked under sandbox.workspaces.root and mounted read-only at
+// /workspace. An empty Store turns bundles off.
+type BundlesConfig struct {
+	Store           string        `yaml:"store"`            // disk or database
+	Dir             string        `yaml:"dir"`              // Absolute directory holding the bundles, for store disk
+	MaxBytes        int64         `yaml:"max_bytes"`        // Cap on an upload, and on its files' total size once unpacked
+	MaxFiles        int           `yaml:"max_files"`        // Cap on an upload's files
+	RequireApproval bool          `yaml:"require_approval"` // Only bundles approved with POST /admin/bundles/{digest} run
+	TTL             time.Duration `yaml:"ttl"`              // Unpinned bundles unused this long are deleted; 0 keeps them
+}
+
+// CPUAbuseConfig is the guardrail against sustained CPU saturation. Each
+// execution's cgroup is read every PollInterval; one using more than
+// UsageFraction of its CPU quota for Sustain is killed, as resource_abuse,
+// if its code matched the crypto_miner pattern or it wrote no output in
+// that time. Mode aggressive kills on the saturation alone. It needs the
+// host's cgroup v2 hierarchy: the containerd backend, or docker with a
+// local daemon.
+type CPUAbuseConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Mode          string        `yaml:"mode"`           // default or aggressive
+	UsageFraction float64       `yaml:"usage_fraction"` // of the quota, e.g. 0.9
+	Sustain       time.Duration `yaml:"sustain"`
+	PollInterval  time.Duration `yaml:"poll_interval"`
+}
+
+// DedupConfig shares an execution with the identical submissions that
+// follow it within Window: same caller, language, code and settings. A
+// request opts in with allow_dedup, or is in by default when its caller is
+// in Keys and it doesn't set allow_dedup: false. An execution takes at most
+// MaxWaiters; later submissions run on their own. A Window of 0 turns it
+// off.
+type DedupConfig struct {
+	Window     time.Duration `yaml:"window"`
+	MaxWaiters int           `yaml:"max_waiters"`
+	Keys       []string      `yaml:"keys"` // API keys or client certificate identities deduplicated by default
+}
+
+// FairShareConfig splits a full concurrency pool's slots between callers
+// instead of handing them out in arrival order. Each slot freed while
+// executions wait goes to the waiting caller furthest below its share of
+// the slots handed out, so one caller submitting ten times as fast as
+// another still starts about as many executions. Callers in Weights get
+// that many shares to everyone else's one. The oldest waiter goes next
+// once it has waited MaxWait, whatever its caller's share, so none starves.
+type FairShareConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	MaxWait time.Duration     `yaml:"max_wait"` // under the 1s an execution waits for its pool before it is refused
+	Weights []FairShareWeight `yaml:"weights"`
+}
+
+// FairShareWeight gives the callers in Keys Weight shares each.
+type FairShareWeight struct {
+	Keys   []string `yaml:"keys"` // API keys or client certificate identities
+	Weight int      `yaml:"weight"`
+}
+
+// WorkdirSizeConfig caps the work_dir mounted into a claude container. The
+// walk that measures it stops after max_walk or max_entries, whichever comes
+// first; a work_dir it couldn't finish is judged on what it counted. Mode
+// reject refuses a work_dir over max_bytes or max_files; warn runs it with a
+// workdir_too_large security event. A max of 0 is no limit. Exclude holds
+// glob patterns matched against each entry's name and its path relative to
+// the work_dir; matching entries aren't counted or descended into.
+type WorkdirSizeConfig struct {
+	Mode       string        `yaml:"mode"`
+	MaxBytes   int64         `yaml:"max_bytes"`
+	MaxFiles   int64         `yaml:"max_files"`
+	MaxWalk    time.Duration `yaml:"max_walk"`
+	MaxEntries int64         `yaml:"max_entries"`
+	Exclude    []string      `yaml:"exclude"`
+}
+
+// UlimitsConfig are ceilings on an execution's rlimits. fsize and core are
+// in bytes; a core of 0 keeps core dumps off.
+type UlimitsConfig struct {
+	NoFile int64 `yaml:"nofile"`
+	NProc  int64 `yaml:"nproc"`
+	FSize  int64 `yaml:"fsize"`
+	Core   int64 `yaml:"core"`
+}
+
+// OutputConfig caps an execution's output in bytes. Output past a cap is
+// dropped as the container writes it, so max_total_bytes bounds what one
+// execution holds in memory.
+type OutputConfig struct {
+	MaxStdoutBytes int `yaml:"max_stdout_bytes"`
+	MaxStderrBytes int `yaml:"max_stderr_bytes"`
+	MaxTotalBytes  int `yaml:"max_total_bytes"` // stdout and stderr together
+}
+
+// DiskPressureConfig sets the free-space thresholds, as percentages of the
+// runtime's data root filesystem, at which the server steps up its response:
+// below warn_free_percent /health reports it, below
+// suspend_pulls_free_percent executions whose image isn't already present are
+// refused with IMAGE_PULL_SUSPENDED, and below gc_free_percent unused images
+// are removed until free space is back above target_free_percent, the
+// high-water mark it must also climb back over before the responses are
+// lifted.
+type DiskPressureConfig struct {
+	Enabled                 bool          `yaml:"enabled"`
+	DataRoot                string        `yaml:"data_root"` // Filesystem to watch; empty asks docker info, or takes /var/lib/containerd
+	CheckInterval           time.Duration `yaml:"check_interval"`
+	WarnFreePercent         float64       `yaml:"warn_free_percent"`
+	SuspendPullsFreePercent float64       `yaml:"suspend_pulls_free_percent"`
+	GCFreePercent           float64       `yaml:"gc_free_percent"`
+	TargetFreePercent       float64       `yaml:"target_free_percent"`
+	GC                      ImageGCConfig `yaml:"gc"`
+}
+
+// ImageGCConfig decides which images a disk-pressure GC may remove. It
+// never removes a runtime's image, one an execution is running in, or one
+// listed in keep; of the rest it removes the least recently used first.
+type ImageGCConfig struct {
+	Candidates string        `yaml:"candidates"` // "dangling" (default): untagged images only; "unregistered": also tagged images no runtime uses
+	MinIdle    time.Duration `yaml:"min_idle"`   // An image an execution used more recently than this is kept
+	Keep       []string      `yaml:"keep"`       // Image references never removed
+}
+
+// CNIConfig attaches each network_enabled containerd container to a bridge
+// with the CNI bridge, host-local and firewall plugins, NATed out through
+// the host like Docker's default bridge.
+type CNIConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	PluginDir string `yaml:"plugin_dir"` // Directory holding the plugin binaries
+	Network   string `yaml:"network"`    // CNI network name; host-local keeps its leases under it
+	Bridge    string `yaml:"bridge"`     // Host bridge interface, created on first use
+	Subnet    string `yaml:"subnet"`     // IPv4 range containers are given addresses from
+}
+
+// WorkdirLockConfig decides what happens when a claude execution asks for a
+// work_dir another one has mounted read-write.
+type WorkdirLockConfig struct {
+	Mode string        `yaml:"mode"` // "fail" (default) answers 409 WORKDIR_BUSY at once; "wait" queues
+	Wait time.Duration `yaml:"wait"` // With mode wait, how long to queue before answering WORKDIR_BUSY
+}
+
+// ClaudeCachesConfig defines Docker volumes that keep package caches across
+// claude executions that set use_caches. Volumes are per API key when
+// security.allowed_keys is set.
+type ClaudeCachesConfig struct {
+	Volumes       []CacheVolumeConfig `yaml:"volumes"`
+	MaxBytes      int64               `yaml:"max_bytes"`      // A volume larger than this is deleted and recreated empty; 0 disables the check
+	CheckInterval time.Duration       `yaml:"check_interval"` // How often volume sizes are checked
+}
+
+// CacheVolumeConfig is one cache volume, e.g. npm-cache at /home/node/.npm.
+type CacheVolumeConfig struct {
+	Name          string `yaml:"name"`
+	ContainerPath string `yaml:"container_path"` // Must be under /home/node, claude's home
+}
+
+// SharedMountConfig is a host directory that executions attach read-only by
+// name, for large reference data that can't be uploaded per request.
+type SharedMountConfig struct {
+	Name             string   `yaml:"name"`              // Referenced by ExecutionRequest.shared_mounts
+	HostPath         string   `yaml:"host_path"`         // Absolute, existing host directory
+	ContainerPath    string   `yaml:"container_path"`    // Absolute mount point inside the container
+	AllowedLanguages []string `yaml:"allowed_languages"` // Runtimes that may attach it
+}
+
+// JobConfig is a maintenance job the server runs in the sandbox: code kept
+// on the server, run on POST /admin/jobs/{name}/run or every Schedule.
+// Its limits may go past the ceilings requests are held to, up to
+// sandbox.PrivilegedMaxLimits.
+type JobConfig struct {
+	Name           string        `yaml:"name"`      // in POST /admin/jobs/{name}/run
+	Language       string        `yaml:"language"`  // any runtime but claude
+	CodeFile       string        `yaml:"code_file"` // absolute path on the server, read at startup
+	Timeout        time.Duration `yaml:"timeout"`   // 0 = sandbox.default_timeout; at most 24h
+	Limits         DefaultLimits `yaml:"limits"`    // zero fields take the defaults requests get
+	NetworkEnabled bool          `yaml:"network_enabled"`
+	Schedule       time.Duration `yaml:"schedule"` // run every Schedule from startup; 0 = only on request
+}
+
+// maxJobTimeout caps jobs[].timeout.
+const maxJobTimeout = 24 * time.Hour
+
+// reservedContainerPaths are mount points the runners or the kernel own;
+// shared mounts may not sit on or under them.
+var reservedContainerPaths = []string{"/workspace", "/sandbox", "/tmp", "/run", "/proc", "/sys", "/dev", "/etc", "/home"}
+
+var validMountName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// WorkspaceConfig controls server-side workspaces (POST /workspaces).
+// An empty Root disables them.
+type WorkspaceConfig struct {
+	Root          string        `yaml:"root"`            // Absolute directory holding one subdirectory per workspace
+	TTL           time.Duration `yaml:"ttl"`             // Workspaces are deleted this long after creation
+	MaxFileBytes  int64         `yaml:"max_file_bytes"`  // Per-file upload cap
+	MaxTotalBytes int64         `yaml:"max_total_bytes"` // Per-workspace cap across all files
+	MaxWorkspaces int           `yaml:"max_workspaces"`  // Server-wide cap on live workspaces
+}
+
+// ChaosConfig gates failure injection for client resilience testing.
+// Refused when ENV=production.
+type ChaosConfig struct {
+	Enabled bool `yaml:"enabled"`
 }
 
 type DefaultLimits struct {
@@ -64,11 +530,85 @@ type DatabaseConfig struct {
 	MaxOpenConns    int           `yaml:"max_open_conns"`
 	MaxIdleConns    int           `yaml:"max_idle_conns"`
 	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// StoreCode keeps each execution's code in the audit log, for its owner
+	// to read back with GET /executions/{id}?include=code. Callers in
+	// security.privacy_mode are never stored.
+	StoreCode bool `yaml:"store_code"`
+	// AuditBatch groups the audit writer's execution records into
+	// multi-row INSERTs.
+	AuditBatch AuditBatchConfig `yaml:"audit_batch"`
+	// Sinks are where the audit writer sends execution records: "postgres"
+	// (the default), "file", or both, each written independently.
+	Sinks    []string       `yaml:"sinks"`
+	FileSink FileSinkConfig `yaml:"file_sink"`
+	// Required refuses executions with 503 AUDIT_UNAVAILABLE while the
+	// audit trail can't be sure to record them: the database unreachable,
+	// or an audit buffer close to full. Reads keep working.
+	Required bool `yaml:"required"`
+	// RetainedExecutions is how many of the last executions' records the
+	// server keeps in memory for GET /executions and GET /executions/{id}:
+	// all they serve without a database, and what they fall back to when
+	// it can't answer. 0 keeps none.
+	RetainedExecutions int `yaml:"retained_executions"`
+}
+
+// FileSinkConfig sets up the "file" audit sink: an append-only JSONL file
+// for a log pipeline to ship, rotated by size or age.
+type FileSinkConfig struct {
+	Path       string        `yaml:"path"`         // Absolute path of the active file; rotated files are renamed beside it
+	MaxBytes   int64         `yaml:"max_bytes"`    // Rotate before the file grows past this (default 100MB); 0 never rotates by size
+	MaxAge     time.Duration `yaml:"max_age"`      // Rotate once the file's first record is this old (default 24h); 0 never rotates by age
+	Fsync      string        `yaml:"fsync"`        // always, batch (default) or never
+	HMACKeyEnv string        `yaml:"hmac_key_env"` // Env var holding the key that chains each line's HMAC to the last; empty writes no HMAC
+	// ServeExecutions answers GET /executions from the active file when no
+	// database is configured.
+	ServeExecutions bool `yaml:"serve_executions"`
+}
+
+// AuditBatchConfig sets when the audit writer writes what it has buffered:
+// once MaxRows records are waiting, or MaxWait after the first of them.
+type AuditBatchConfig struct {
+	MaxRows int           `yaml:"max_rows"` // Records per batch (default 100); 1 writes each on its own
+	MaxWait time.Duration `yaml:"max_wait"` // How long a partial batch waits for more (default 50ms); 0 writes what is buffered at once
 }
 
 type MetricsConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	Path    string `yaml:"path"`
+	Enabled bool        `yaml:"enabled"`
+	Path    string      `yaml:"path"`
+	SLO     SLOConfig   `yaml:"slo"`
+	Stats   StatsConfig `yaml:"stats"`
+}
+
+// StatsConfig sets GET /stats, the flat saturation document for
+// autoscalers that don't parse Prometheus histograms.
+type StatsConfig struct {
+	Public   bool              `yaml:"public"`    // Serve it without auth, like /metrics; off, any API key can read it
+	CacheTTL time.Duration     `yaml:"cache_ttl"` // How long a computed document is served before the next is computed (default 1s)
+	Weights  SaturationWeights `yaml:"weights"`   // How much each part counts towards saturation_score
+}
+
+// SaturationWeights weigh the parts of saturation_score, each between 0
+// (idle) and 1 (saturated), into their weighted mean. A weight of 0 leaves
+// that part out.
+type SaturationWeights struct {
+	Slots     float64 `yaml:"slots"`      // Backend concurrency slots in use
+	Queue     float64 `yaml:"queue"`      // Executions waiting for a slot, against the slots there are
+	Claude    float64 `yaml:"claude"`     // Claude sessions against security.max_concurrent_claude
+	RateLimit float64 `yaml:"rate_limit"` // Share of the last minute's requests the rate limiter refused
+}
+
+// SLOConfig sets the objectives GET /slo and the sandbox_slo_* gauges report
+// on, measured over a rolling window the server keeps in memory.
+type SLOConfig struct {
+	Window     time.Duration           `yaml:"window"`     // Rolling window the objectives are measured over (default 1h)
+	Bucket     time.Duration           `yaml:"bucket"`     // Granularity the window slides by (default 1m)
+	Objectives map[string]SLOObjective `yaml:"objectives"` // Per language; empty disables SLO tracking
+}
+
+// SLOObjective is one language's objectives; a zero field sets none.
+type SLOObjective struct {
+	P95         time.Duration `yaml:"p95"`          // 95% of executions finish within this
+	SuccessRate float64       `yaml:"success_rate"` // Fraction of executions that must end with status success, e.g. 0.99
 }
 
 type TracingConfig struct {
@@ -80,11 +620,168 @@ type TracingConfig struct {
 type SecurityConfig struct {
 	APIKeyHeader         string   `yaml:"api_key_header"`
 	AllowedKeys          []string `yaml:"allowed_keys"`
+	AdminKeys            []string `yaml:"admin_keys"`            // API keys or client certificate identities that may use /admin/* and GET /security-events; each must also pass authentication
 	AllowUnauthenticated bool     `yaml:"allow_unauthenticated"` // must be explicitly true to bypass auth when AllowedKeys is empty
 	RateLimitRPS         float64  `yaml:"rate_limit_rps"`
 	RateLimitBurst       int      `yaml:"rate_limit_burst"`
-	MaxConcurrentClaude  int      `yaml:"max_concurrent_claude"` // max concurrent claude sessions (default 5)
-	SeccompProfile       string   `yaml:"seccomp_profile"`
+	RateLimitBy          string   `yaml:"rate_limit_by"` // "ip" (default) or "key": what each rate_limit_rps bucket is kept for
+	// KeyLimits are the limits of the API keys or client certificate
+	// identities listed; fields left 0 take DefaultKeyLimit's, and every
+	// other caller gets DefaultKeyLimit.
+	KeyLimits                map[string]KeyLimitConfig `yaml:"key_limits"`
+	DefaultKeyLimit          KeyLimitConfig            `yaml:"default_key_limit"`
+	MaxConcurrentClaude      int                       `yaml:"max_concurrent_claude"`       // max concurrent claude sessions (default 5)
+	MaxTaskExecutions        int                       `yaml:"max_task_executions"`         // executions a caller may run under one task_id; 0 = no limit
+	MaxCancellationGroupSize int                       `yaml:"max_cancellation_group_size"` // executions a caller may have live under one cancellation_group; 0 = no limit
+	SeccompProfile           string                    `yaml:"seccomp_profile"`
+	AuthPrecedence           string                    `yaml:"auth_precedence"` // "client_cert" (default) or "api_key": which identity wins when a request has both
+	// ClaudeWorkdirGit is what a claude work_dir that is a git repository
+	// with uncommitted changes gets when it is mounted read-write: "ignore",
+	// "warn" (default), a warning in the response, or "require_clean", a
+	// 409 WORKDIR_DIRTY. Its state is recorded whichever it is.
+	ClaudeWorkdirGit string               `yaml:"claude_workdir_git"`
+	Claude           ClaudeSecurityConfig `yaml:"claude"`
+	CostBudget       CostBudgetConfig     `yaml:"cost_budget"`
+	ClaudeTokens     ClaudeTokensConfig   `yaml:"claude_tokens"`
+	PrivacyMode      PrivacyModeConfig    `yaml:"privacy_mode"`
+	Anomaly          AnomalyConfig        `yaml:"anomaly"`
+	Detector         DetectorConfig       `yaml:"detector"`
+	NetworkPolicy    NetworkPolicyConfig  `yaml:"network_policy"`
+
+	// Kill switches, re-read on SIGHUP and replaceable through POST
+	// /admin/flags: requests using a disabled language or feature are
+	// refused with FEATURE_DISABLED.
+	DisabledLanguages []string `yaml:"disabled_languages"`
+	DisabledFeatures  []string `yaml:"disabled_features"`  // names from Features
+	DisabledMessage   string   `yaml:"disabled_message"`   // added to the refusal, e.g. the advisory being mitigated
+	DisabledInFlight  string   `yaml:"disabled_in_flight"` // "finish" (default) or "kill": what happens to a language's running executions when it is disabled
+}
+
+// Features are the capabilities security.disabled_features can turn off.
+var Features = []string{
+	"streaming",       // POST /execute/stream
+	"uploads",         // POST /execute/upload
+	"async",           // async, and POST /execute/async
+	"network_enabled", // permissions.network.enabled; claude's own network is the claude language's
+	"claude_workdir",  // work_dir on claude executions
+	"workspaces",      // workspace_id on executions
+	"shared_mounts",   // shared_mounts
+	"claude_caches",   // use_caches
+	"claude_tokens",   // claude_token and claude_credential
+	"bundles",         // bundle_digest, and POST /bundles
+}
+
+// NetworkPolicyConfig decides which executions get a network, over what
+// their requests ask for. Mode allow gives one to every request that asks,
+// deny to none, and allowlist_languages to requests in Languages that ask.
+// A request that asked and was denied runs isolated, with a warning in its
+// response. claude can't run without a network: when the mode doesn't allow
+// it, Claude force gives it one anyway and refuse refuses its executions
+// with NETWORK_DENIED.
+type NetworkPolicyConfig struct {
+	Mode      string   `yaml:"mode"`      // "allow" (default), "deny" or "allowlist_languages"
+	Languages []string `yaml:"languages"` // With allowlist_languages, the languages that may have a network
+	Claude    string   `yaml:"claude"`    // "force" (default) or "refuse"
+}
+
+// AnomalyConfig flags executions unlike their caller's history with
+// low-severity security events: a language the caller hasn't used, a
+// duration far past its usual, its first network-enabled execution, or
+// high-severity detections from a caller that rarely has any. The history
+// is kept in memory and, with a database, saved every PersistInterval.
+type AnomalyConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	MinExecutions      int           `yaml:"min_executions"`       // Executions a caller runs before it can be flagged (default 20)
+	DurationFactor     float64       `yaml:"duration_factor"`      // duration_outlier past this multiple of the caller's p95 for the language (default 5)
+	MinOutlierDuration time.Duration `yaml:"min_outlier_duration"` // ...and at least this long (default 10s)
+	DetectionRate      float64       `yaml:"detection_rate"`       // detection_rate_spike when at most this share of the caller's executions had high-severity detections (default 0.05)
+	PersistInterval    time.Duration `yaml:"persist_interval"`     // How often the history is saved to Postgres (default 5m)
+}
+
+// DetectorConfig bounds what the escape detector may spend on a request's
+// code and adds patterns to its defaults.
+type DetectorConfig struct {
+	AnalysisBudget time.Duration     `yaml:"analysis_budget"` // Past this, the rest of the code is only sampled and the result says partial_analysis; 0 = no budget (default 50ms)
+	MaxLineLength  int               `yaml:"max_line_length"` // Longer lines are scanned in overlapping chunks; 0 = never split (default 4096)
+	Patterns       []DetectorPattern `yaml:"patterns"`
+}
+
+// DetectorPattern is a custom escape detector pattern. Its regex is only
+// run on lines containing one of Anchors, literal substrings compared
+// ignoring ASCII case, so every match has to contain one.
+type DetectorPattern struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Regex       string   `yaml:"regex"`    // Go RE2 syntax
+	Severity    string   `yaml:"severity"` // low, medium, high or critical; critical refuses the request
+	Anchors     []string `yaml:"anchors"`
+}
+
+// PrivacyModeConfig picks the callers whose code never outlives their
+// request: it isn't stored whatever database.store_code says, nor is their
+// output, and the audit log keeps only a 16-character prefix of the code
+// hash.
+type PrivacyModeConfig struct {
+	Enabled bool     `yaml:"enabled"` // Every caller
+	Keys    []string `yaml:"keys"`    // API keys or client certificate identities, when not every caller
+}
+
+// ClaudeTokensConfig lets claude executions bill the caller's own Anthropic
+// account: a request brings its token in claude_token, or names one of
+// Credentials in claude_credential. The token is handed to the auth proxy
+// under a per-execution secret and never enters the container.
+type ClaudeTokensConfig struct {
+	Enabled     bool                        `yaml:"enabled"`     // Needs auth_proxy.port
+	Credentials map[string]ClaudeCredential `yaml:"credentials"` // Named tokens kept on the server
+}
+
+// ClaudeCredential is a token stored on the server for the callers in Keys.
+type ClaudeCredential struct {
+	TokenEnv string   `yaml:"token_env"` // Environment variable holding the token, read at startup
+	Keys     []string `yaml:"keys"`      // API keys or client certificate identities that may use it
+}
+
+// KeyLimitConfig is one caller's limits. RPS and Burst apply with
+// rate_limit_by: key, and fall back to rate_limit_rps and rate_limit_burst;
+// MaxConcurrent and DailyQuota apply either way, 0 meaning no limit.
+type KeyLimitConfig struct {
+	RPS           float64 `yaml:"rps"`
+	Burst         int     `yaml:"burst"`
+	MaxConcurrent int     `yaml:"max_concurrent"` // executions running at once
+	DailyQuota    int     `yaml:"daily_quota"`    // executions per UTC day
+}
+
+// CostBudgetConfig caps what each caller may spend on executions per hour.
+// An execution costs its language's cost at the default timeout and memory,
+// scaled linearly by the timeout and memory it asks for.
+type CostBudgetConfig struct {
+	Hourly        float64            `yaml:"hourly"`         // Budget per API key or client certificate over a sliding hour; 0 disables
+	LanguageCosts map[string]float64 `yaml:"language_costs"` // Languages left out cost 1
+	ExemptKeys    []string           `yaml:"exempt_keys"`    // API keys or client certificate identities with no budget, e.g. admin keys
+}
+
+// ClaudeSecurityConfig bounds the claude options a request may ask for and
+// supplies the ones it omits.
+type ClaudeSecurityConfig struct {
+	AllowedModels          []string `yaml:"allowed_models"`           // Models a request may pick; empty allows any
+	DefaultModel           string   `yaml:"default_model"`            // Empty leaves claude's own default
+	MaxTurns               int      `yaml:"max_turns"`                // Ceiling on max_turns; 0 = no ceiling
+	DefaultMaxTurns        int      `yaml:"default_max_turns"`        // Used when a request omits max_turns; 0 = the ceiling
+	DefaultAllowedTools    []string `yaml:"default_allowed_tools"`    // Used when a request omits allowed_tools
+	DefaultDisallowedTools []string `yaml:"default_disallowed_tools"` // Used when a request omits disallowed_tools
+	DeniedTools            []string `yaml:"denied_tools"`             // Always disallowed; requests can't allow them, e.g. WebSearch
+	MaxPromptBytes         int      `yaml:"max_prompt_bytes"`         // Cap on a prompt's size; 0 = the 1MB code limit
+	PromptBlockSeverity    string   `yaml:"prompt_block_severity"`    // Lowest prompt screening severity that refuses the request, or "none" to only record; empty = critical
+	// Pricing prices models for POST /estimate, by model name; "default"
+	// prices the models not listed and claude's own default. Reloaded on
+	// SIGHUP.
+	Pricing map[string]ClaudePrice `yaml:"pricing"`
+}
+
+// ClaudePrice is a model's price in USD per million tokens.
+type ClaudePrice struct {
+	InputPerMTok  float64 `yaml:"input_per_mtok"`
+	OutputPerMTok float64 `yaml:"output_per_mtok"`
 }
 
 // PoolConfig controls pre-warmed container pooling.
@@ -98,9 +795,11 @@ type PoolConfig struct {
 
 // TLSConfig controls HTTPS/TLS termination.
 type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	CertFile string `yaml:"cert_file"`
-	KeyFile  string `yaml:"key_file"`
+	Enabled        bool   `yaml:"enabled"`
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`   // PEM bundle of the CAs that sign client certificates
+	ClientAuthMode string `yaml:"client_auth_mode"` // "none" (default), "request" (verified if presented) or "require_and_verify"
 }
 
 // Load reads configuration from a YAML file.
@@ -122,6 +821,42 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// CheckFile checks the config file at path as Load would, but reports what
+// it finds rather than failing on it: a file that doesn't parse is an
+// error in the report, and keys no setting uses, which Load ignores, are
+// warnings. offline checks as CheckOffline does. The error is for a file
+// that can't be read.
+func CheckFile(path string, offline bool) (*Report, error) {
+	data, err := os.ReadFile(filepath.Clean(path)) // #nosec G304 -- path comes from a CLI flag
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	cfg := DefaultConfig()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		r := &Report{}
+		r.errorf("parsing config: %v", err)
+		return r, nil
+	}
+	check := cfg.Check
+	if offline {
+		check = cfg.CheckOffline
+	}
+	r := check()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var strict *yaml.TypeError
+	if err := dec.Decode(DefaultConfig()); errors.As(err, &strict) {
+		for _, e := range strict.Errors {
+			if m := unknownField.FindStringSubmatch(e); m != nil {
+				r.warnf("line %s: unknown key %q is ignored", m[1], m[2])
+			}
+		}
+	}
+	return r, nil
+}
+
+var unknownField = regexp.MustCompile(`^line (\d+): field (\S+) not found in type`)
+
 // DefaultConfig returns sensible defaults for all configuration.
 func DefaultConfig() *Config {
 	return &Config{
@@ -129,15 +864,21 @@ func DefaultConfig() *Config {
 			Host:            "0.0.0.0",
 			Port:            8080,
 			ReadTimeout:     30 * time.Second,
-			WriteTimeout:    31 * time.Minute, // > max claude timeout (30min) + overhead
+			WriteTimeout:    3 * time.Minute, // > max_timeout + setup_timeout + cleanup_grace
 			ShutdownTimeout: 30 * time.Second,
 			MaxRequestBody:  1 << 20, // 1MB
+			// > max claude timeout (30min) + overhead
+			ClaudeWriteTimeout: 32 * time.Minute,
+			AsyncJobTTL:        time.Hour,
+			Upgrade:            UpgradeConfig{ReadyTimeout: time.Minute},
 		},
 		Sandbox: SandboxConfig{
 			ContainerdSocket: "/run/containerd/containerd.sock",
 			Namespace:        "sandbox",
 			DefaultTimeout:   10 * time.Second,
 			MaxTimeout:       60 * time.Second,
+			SetupTimeout:     time.Minute,
+			CleanupGrace:     30 * time.Second,
 			MaxConcurrent:    1000,
 			Backend:          "auto",
 			DefaultLimits: DefaultLimits{
@@ -146,26 +887,168 @@ func DefaultConfig() *Config {
 				PidsLimit: 50,
 				DiskMB:    100,
 			},
+			Workspaces: WorkspaceConfig{
+				TTL:           time.Hour,
+				MaxFileBytes:  10 << 20,
+				MaxTotalBytes: 50 << 20,
+				MaxWorkspaces: 100,
+			},
+			ClaudeCaches: ClaudeCachesConfig{
+				MaxBytes:      5 << 30,
+				CheckInterval: 10 * time.Minute,
+			},
+			WorkdirLock: WorkdirLockConfig{
+				Mode: "fail",
+				Wait: time.Minute,
+			},
+			VerifySeccomp:           true,
+			SeccompHashInterval:     5 * time.Minute,
+			VerifyCodeIntegrity:     true,
+			VerifyClaudeContract:    true,
+			LimitEnforcement:        "permissive",
+			ClaudeIdleOutputTimeout: 5 * time.Minute,
+			MaxIdleOutputTimeout:    10 * time.Minute,
+			MaxUploadCodeBytes:      16 << 20,
+			MaxStdinBytes:           1 << 20,
+			Output: OutputConfig{
+				MaxStdoutBytes: 1 << 20,
+				MaxStderrBytes: 256 << 10,
+				MaxTotalBytes:  1 << 20,
+			},
+			CNI: CNIConfig{
+				PluginDir: "/opt/cni/bin",
+				Network:   "sandbox",
+				Bridge:    "sandbox0",
+				Subnet:    "10.89.0.0/22",
+			},
+			DiskPressure: DiskPressureConfig{
+				Enabled:                 true,
+				CheckInterval:           time.Minute,
+				WarnFreePercent:         15,
+				SuspendPullsFreePercent: 10,
+				GCFreePercent:           5,
+				TargetFreePercent:       20,
+				GC: ImageGCConfig{
+					Candidates: "dangling",
+					MinIdle:    time.Hour,
+				},
+			},
+			MaxUlimits: UlimitsConfig{
+				NoFile: 65536,
+				NProc:  2000,
+				FSize:  10240 << 20,
+			},
+			WorkdirSize: WorkdirSizeConfig{
+				Mode:       "reject",
+				MaxBytes:   5 << 30,
+				MaxFiles:   200000,
+				MaxWalk:    2 * time.Second,
+				MaxEntries: 1000000,
+				Exclude:    []string{".git", "node_modules"},
+			},
+			Dedup: DedupConfig{
+				MaxWaiters: 16,
+			},
+			FairShare: FairShareConfig{
+				MaxWait: 500 * time.Millisecond,
+			},
+			CPUAbuse: CPUAbuseConfig{
+				Mode:          "default",
+				UsageFraction: 0.9,
+				Sustain:       30 * time.Second,
+				PollInterval:  time.Second,
+			},
+			Bundles: BundlesConfig{
+				MaxBytes: 10 << 20,
+				MaxFiles: 1000,
+				TTL:      7 * 24 * time.Hour,
+			},
+			EgressAudit: EgressAuditConfig{
+				Conntrack:       "/proc/net/nf_conntrack",
+				PollInterval:    time.Second,
+				MaxConnections:  256,
+				SuspiciousCIDRs: []string{"169.254.0.0/16", "100.100.100.200/32", "fd00:ec2::254/128"},
+				PrivateCIDRs:    []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"},
+			},
+			Binary: BinaryConfig{
+				MaxBytes: 16 << 20,
+			},
+			DebugTrace: DebugTraceConfig{
+				MaxBytes: 1 << 20,
+			},
+			CompileCache: CompileCacheConfig{
+				Languages:     []string{"python", "node"},
+				MaxBytes:      256 << 20,
+				MaxEntryBytes: 8 << 20,
+			},
 		},
 		Database: DatabaseConfig{
 			DSN:             "",
 			MaxOpenConns:    25,
 			MaxIdleConns:    5,
 			ConnMaxLifetime: 5 * time.Minute,
+			AuditBatch: AuditBatchConfig{
+				MaxRows: 100,
+				MaxWait: 50 * time.Millisecond,
+			},
+			Sinks: []string{"postgres"},
+			FileSink: FileSinkConfig{
+				MaxBytes: 100 << 20,
+				MaxAge:   24 * time.Hour,
+				Fsync:    "batch",
+			},
+			RetainedExecutions: 1000,
 		},
 		Metrics: MetricsConfig{
 			Enabled: true,
 			Path:    "/metrics",
+			SLO: SLOConfig{
+				Window: time.Hour,
+				Bucket: time.Minute,
+			},
+			Stats: StatsConfig{
+				Public:   true,
+				CacheTTL: time.Second,
+				Weights:  SaturationWeights{Slots: 0.5, Queue: 0.2, Claude: 0.2, RateLimit: 0.1},
+			},
 		},
 		Tracing: TracingConfig{
 			Enabled: false,
 			Sample:  0.1,
 		},
 		Security: SecurityConfig{
-			APIKeyHeader:        "X-API-Key",
-			RateLimitRPS:        100,
-			RateLimitBurst:      200,
-			MaxConcurrentClaude: 5,
+			APIKeyHeader:             "X-API-Key",
+			RateLimitRPS:             100,
+			RateLimitBurst:           200,
+			RateLimitBy:              "ip",
+			MaxConcurrentClaude:      5,
+			MaxCancellationGroupSize: 256,
+			ClaudeWorkdirGit:         "warn",
+			Claude: ClaudeSecurityConfig{
+				MaxPromptBytes:      256 << 10,
+				PromptBlockSeverity: "critical",
+				Pricing:             map[string]ClaudePrice{"default": {InputPerMTok: 3, OutputPerMTok: 15}},
+			},
+			CostBudget: CostBudgetConfig{
+				LanguageCosts: map[string]float64{"claude": 20},
+			},
+			Anomaly: AnomalyConfig{
+				Enabled:            true,
+				MinExecutions:      20,
+				DurationFactor:     5,
+				MinOutlierDuration: 10 * time.Second,
+				DetectionRate:      0.05,
+				PersistInterval:    5 * time.Minute,
+			},
+			Detector: DetectorConfig{
+				AnalysisBudget: 50 * time.Millisecond,
+				MaxLineLength:  4096,
+			},
+			NetworkPolicy: NetworkPolicyConfig{
+				Mode:   "allow",
+				Claude: "force",
+			},
+			DisabledInFlight: "finish",
 		},
 		Pool: PoolConfig{
 			Enabled:     true,
@@ -178,46 +1061,1021 @@ func DefaultConfig() *Config {
 			Enabled: false,
 		},
 		AuthProxy: AuthProxyConfig{
-			MaxProxyRPM: 300,
+			MaxProxyRPM:           300,
+			ResponseHeaderTimeout: 10 * time.Minute,
+			IdleConnTimeout:       90 * time.Second,
+			DNSCacheTTL:           30 * time.Second,
+			ACL:                   AuthProxyACLConfig{Refresh: 5 * time.Minute},
 		},
 	}
 }
 
-// Validate checks that the configuration is valid.
+// Validate checks that the configuration is valid. It returns every error
+// Check finds at once; warnings don't fail it.
 func (c *Config) Validate() error {
+	return c.Check().Err()
+}
+
+// Check runs every validation rule and returns what it found, in the order
+// of the file's sections. Besides the config itself it looks at the host:
+// that directories and files the config names exist and are readable, and
+// at ENV. What depends on the sandbox backend is checked by the backend.
+func (c *Config) Check() *Report {
+	return c.check(&Report{})
+}
+
+// CheckOffline is Check without looking at the host: paths the config names
+// are checked for form but not opened, so a config can be checked on a
+// machine other than the one it is for, such as in CI.
+func (c *Config) CheckOffline() *Report {
+	return c.check(&Report{offline: true})
+}
+
+func (c *Config) check(r *Report) *Report {
+	c.checkServer(r)
+	c.checkSandbox(r)
+	c.checkTelemetry(r)
+	c.checkSecurity(r)
+	c.checkTLS(r)
+	if c.AuthProxy.Port < 0 || c.AuthProxy.Port > 65535 {
+		r.errorf("auth_proxy.port must be 0-65535, got %d", c.AuthProxy.Port)
+	}
+	if p := c.AuthProxy; p.ResponseHeaderTimeout < 0 || p.IdleConnTimeout < 0 || p.DNSCacheTTL < 0 {
+		r.errorf("auth_proxy: response_header_timeout, idle_conn_timeout and dns_cache_ttl must be >= 0")
+	}
+	checkAuthProxyBoundary(r, c.AuthProxy)
+	checkJobs(r, c.Jobs)
+	return r
+}
+
+// checkJobs checks jobs' names, code files and timings. Their limits are
+// checked against the privileged ceilings when the server loads them.
+func checkJobs(r *Report, jobs []JobConfig) {
+	names := make(map[string]bool)
+	for _, j := range jobs {
+		if !validMountName.MatchString(j.Name) {
+			r.errorf("jobs: name %q must be lowercase letters, digits, - or _", j.Name)
+			continue
+		}
+		if names[j.Name] {
+			r.errorf("jobs: duplicate name %q", j.Name)
+			continue
+		}
+		names[j.Name] = true
+
+		switch j.Language {
+		case "":
+			r.errorf("jobs[%s].language is required", j.Name)
+		case "claude":
+			r.errorf("jobs[%s].language: claude can't run jobs", j.Name)
+		}
+		if !filepath.IsAbs(j.CodeFile) {
+			r.errorf("jobs[%s].code_file: %q must be an absolute path", j.Name, j.CodeFile)
+		} else if info, err := os.Stat(j.CodeFile); !r.offline && (err != nil || !info.Mode().IsRegular()) {
+			r.errorf("jobs[%s].code_file: %q is not an existing file", j.Name, j.CodeFile)
+		}
+		if j.Timeout < 0 || j.Timeout > maxJobTimeout {
+			r.errorf("jobs[%s].timeout must be 0-%s, got %s", j.Name, maxJobTimeout, j.Timeout)
+		}
+		if j.Schedule < 0 || (j.Schedule > 0 && j.Schedule < time.Minute) {
+			r.errorf("jobs[%s].schedule must be 0 or at least 1m, got %s", j.Name, j.Schedule)
+		}
+	}
+}
+
+// checkAuthProxyBoundary checks what limits who can reach the auth proxy.
+// The bridge network it may bind to is only known at startup.
+func checkAuthProxyBoundary(r *Report, p AuthProxyConfig) {
+	if p.Bind != "" && p.Bind != "docker_gateway" {
+		ip, err := netip.ParseAddr(p.Bind)
+		switch {
+		case err != nil:
+			r.errorf("auth_proxy.bind must be empty, docker_gateway or an IP address, got %q", p.Bind)
+		case ip.IsUnspecified():
+			r.errorf("auth_proxy.bind %s would expose the proxy on every interface; name the address containers reach it on", p.Bind)
+		}
+	}
+	for _, cidr := range p.ACL.AllowedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			r.errorf("auth_proxy.acl.allowed_cidrs: %q is not a CIDR", cidr)
+		}
+	}
+	if p.ACL.Refresh < 0 {
+		r.errorf("auth_proxy.acl.refresh must be >= 0")
+	}
+	if p.Port == 0 && (p.Bind != "" || p.ACL.Enabled || p.TLS || p.PerExecutionSecrets) {
+		r.warnf("auth_proxy: bind, acl, tls and per_execution_secrets have no effect with the proxy disabled (port 0)")
+	}
+}
+
+// validBasePath is a server.base_path: segments of URL-safe characters,
+// none of them starting with a dot.
+var validBasePath = regexp.MustCompile(`^(/[A-Za-z0-9_~-][A-Za-z0-9._~-]*)+$`)
+
+func (c *Config) checkServer(r *Report) {
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("server.port must be 1-65535, got %d", c.Server.Port)
+		r.errorf("server.port must be 1-65535, got %d", c.Server.Port)
+	}
+	if c.Server.WriteTimeout < 0 || c.Server.ClaudeWriteTimeout < 0 {
+		r.errorf("server.write_timeout and server.claude_write_timeout must be >= 0")
+	} else if c.Server.WriteTimeout > 0 && c.Server.ClaudeWriteTimeout > 0 && c.Server.ClaudeWriteTimeout < c.Server.WriteTimeout {
+		r.errorf("server.claude_write_timeout (%s) must be >= write_timeout (%s)",
+			c.Server.ClaudeWriteTimeout, c.Server.WriteTimeout)
+	}
+	if c.Server.AsyncJobTTL <= 0 {
+		r.errorf("server.async_job_ttl must be > 0, got %s", c.Server.AsyncJobTTL)
+	}
+	if p := c.Server.PlaintextHealthPort; p < 0 || p > 65535 || p == c.Server.Port {
+		r.errorf("server.plaintext_health_port must be 0-65535 and differ from server.port, got %d", p)
+	}
+	if b := c.Server.BasePath; b != "" && !validBasePath.MatchString(b) {
+		r.errorf("server.base_path must be empty or a path like /sandbox, without a trailing slash, got %q", b)
+	}
+	if u := c.Server.Upgrade; u.ReadyTimeout <= 0 || u.DrainTimeout < 0 {
+		r.errorf("server.upgrade.ready_timeout must be > 0 and drain_timeout >= 0")
+	}
+}
+
+func (c *Config) checkSandbox(r *Report) {
+	switch c.Sandbox.Backend {
+	case "", "auto", "containerd", "docker":
+	default:
+		r.errorf("sandbox.backend must be auto, containerd or docker, got %q", c.Sandbox.Backend)
 	}
 	if c.Sandbox.DefaultTimeout > c.Sandbox.MaxTimeout {
-		return fmt.Errorf("sandbox.default_timeout (%s) must be <= max_timeout (%s)",
+		r.errorf("sandbox.default_timeout (%s) must be <= max_timeout (%s)",
 			c.Sandbox.DefaultTimeout, c.Sandbox.MaxTimeout)
 	}
 	if c.Sandbox.MaxConcurrent < 1 {
-		return fmt.Errorf("sandbox.max_concurrent must be >= 1")
+		r.errorf("sandbox.max_concurrent must be >= 1")
 	}
-	if c.Sandbox.DefaultLimits.MemoryMB < 16 {
-		return fmt.Errorf("sandbox.default_limits.memory_mb must be >= 16")
+	if c.Sandbox.SeccompHashInterval < 0 {
+		r.errorf("sandbox.seccomp_hash_interval must be >= 0")
 	}
-	if c.TLS.Enabled {
-		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
-			return fmt.Errorf("tls.cert_file and tls.key_file are required when TLS is enabled")
+	if c.Sandbox.SetupTimeout <= 0 || c.Sandbox.CleanupGrace <= 0 {
+		r.errorf("sandbox.setup_timeout and sandbox.cleanup_grace must be > 0")
+	} else {
+		// A response is written once its container is gone.
+		overhead := c.Sandbox.SetupTimeout + c.Sandbox.CleanupGrace
+		if wt, need := c.Server.WriteTimeout, c.Sandbox.MaxTimeout+overhead; wt > 0 && wt < need {
+			r.warnf("server.write_timeout (%s) is shorter than sandbox.max_timeout + setup_timeout + cleanup_grace (%s), so a response can be cut off after its code has run",
+				wt, need)
+		}
+		if wt, need := c.Server.ClaudeWriteTimeout, 30*time.Minute+overhead; wt > 0 && wt < need {
+			r.warnf("server.claude_write_timeout (%s) is shorter than the 30m claude timeout + sandbox.setup_timeout + cleanup_grace (%s), so a response can be cut off after its session has run",
+				wt, need)
 		}
 	}
-	if c.AuthProxy.Port < 0 || c.AuthProxy.Port > 65535 {
-		return fmt.Errorf("auth_proxy.port must be 0-65535, got %d", c.AuthProxy.Port)
+	if c.Sandbox.DefaultLimits.MemoryMB < 16 {
+		r.errorf("sandbox.default_limits.memory_mb must be >= 16")
+	}
+	if c.Sandbox.ClaudeIdleOutputTimeout < 0 {
+		r.errorf("sandbox.claude_idle_output_timeout must be >= 0")
+	}
+	if c.Sandbox.MaxIdleOutputTimeout < 0 {
+		r.errorf("sandbox.max_idle_output_timeout must be >= 0")
+	}
+	if c.Sandbox.MaxUploadCodeBytes < 0 {
+		r.errorf("sandbox.max_upload_code_bytes must be >= 0")
+	}
+	if c.Sandbox.MaxStdinBytes < 0 {
+		r.errorf("sandbox.max_stdin_bytes must be >= 0")
+	} else if body := c.Server.MaxRequestBody; body > 0 && c.Sandbox.MaxStdinBytes > body {
+		r.warnf("sandbox.max_stdin_bytes (%d) is over server.max_request_body_bytes (%d), which caps stdin first",
+			c.Sandbox.MaxStdinBytes, body)
+	}
+	if c.Sandbox.Binary.MaxBytes <= 0 {
+		r.errorf("sandbox.binary.max_bytes must be > 0")
+	}
+	checkDebugTrace(r, c.Sandbox.DebugTrace)
+	checkCompileCache(r, c.Sandbox.CompileCache)
+	if o := c.Sandbox.Output; o.MaxStdoutBytes <= 0 || o.MaxStderrBytes <= 0 || o.MaxTotalBytes <= 0 {
+		r.errorf("sandbox.output: max_stdout_bytes, max_stderr_bytes and max_total_bytes must be > 0")
+	} else if o.MaxStdoutBytes > o.MaxTotalBytes || o.MaxStderrBytes > o.MaxTotalBytes {
+		r.errorf("sandbox.output: max_stdout_bytes and max_stderr_bytes must be <= max_total_bytes (%d)", o.MaxTotalBytes)
 	}
 	for _, root := range c.Sandbox.AllowedWorkdirRoots {
 		if !filepath.IsAbs(root) {
-			return fmt.Errorf("sandbox.allowed_workdir_roots: %q must be an absolute path", root)
+			r.errorf("sandbox.allowed_workdir_roots: %q must be an absolute path", root)
+		} else if info, err := os.Stat(root); !r.offline && (err != nil || !info.IsDir()) {
+			r.warnf("sandbox.allowed_workdir_roots: %q is not an existing directory, so every work_dir under it is refused; create it or remove it from the list", root)
+		}
+	}
+	if ws := c.Sandbox.Workspaces; ws.Root != "" {
+		if !filepath.IsAbs(ws.Root) {
+			r.errorf("sandbox.workspaces.root: %q must be an absolute path", ws.Root)
+		}
+		if ws.TTL <= 0 || ws.MaxFileBytes <= 0 || ws.MaxTotalBytes <= 0 {
+			r.errorf("sandbox.workspaces: ttl, max_file_bytes and max_total_bytes must be > 0")
+		} else if ws.MaxFileBytes > ws.MaxTotalBytes {
+			r.errorf("sandbox.workspaces.max_file_bytes must be <= max_total_bytes")
+		}
+	}
+	checkSharedMounts(r, c.Sandbox.SharedMounts)
+	checkDefaultEnv(r, c.Sandbox.DefaultEnv)
+	checkRuntimeLimits(r, c.Sandbox.RuntimeLimits)
+	checkConcurrency(r, c.Sandbox.Concurrency, c.Sandbox.MaxConcurrent)
+	if c.Sandbox.FairShare.Enabled {
+		checkFairShare(r, c.Sandbox.FairShare)
+	}
+	checkClaudeCaches(r, c.Sandbox.ClaudeCaches)
+	switch lock := c.Sandbox.WorkdirLock; lock.Mode {
+	case "", "fail":
+	case "wait":
+		if lock.Wait <= 0 {
+			r.errorf("sandbox.workdir_lock.wait must be > 0 with mode wait")
+		}
+	default:
+		r.errorf("sandbox.workdir_lock.mode must be fail or wait, got %q", lock.Mode)
+	}
+	switch c.Sandbox.LimitEnforcement {
+	case "", "strict", "permissive", "off":
+	default:
+		r.errorf("sandbox.limit_enforcement must be strict, permissive or off, got %q", c.Sandbox.LimitEnforcement)
+	}
+	if c.Sandbox.Chaos.Enabled && os.Getenv("ENV") == "production" {
+		r.errorf("sandbox.chaos.enabled must not be set when ENV=production")
+	}
+	if c.Sandbox.CNI.Enabled {
+		checkCNI(r, c.Sandbox.CNI)
+	}
+	if c.Sandbox.DiskPressure.Enabled {
+		checkDiskPressure(r, c.Sandbox.DiskPressure)
+	}
+	if u := c.Sandbox.MaxUlimits; u.NoFile < 16 || u.NProc < 5 || u.FSize < 1<<20 || u.Core < 0 {
+		r.errorf("sandbox.max_ulimits: need nofile >= 16, nproc >= 5, fsize >= 1048576 and core >= 0, got %d, %d, %d, %d",
+			u.NoFile, u.NProc, u.FSize, u.Core)
+	}
+	checkWorkdirSize(r, c.Sandbox.WorkdirSize)
+	if d := c.Sandbox.Dedup; d.Window < 0 {
+		r.errorf("sandbox.dedup.window must be >= 0")
+	} else if d.Window > 0 && d.MaxWaiters < 1 {
+		r.errorf("sandbox.dedup.max_waiters must be >= 1 with a window, got %d", d.MaxWaiters)
+	} else if d.Window == 0 && len(d.Keys) > 0 {
+		r.warnf("sandbox.dedup.keys has no effect without sandbox.dedup.window; set a window or remove the keys")
+	}
+	if c.Sandbox.CPUAbuse.Enabled {
+		checkCPUAbuse(r, c.Sandbox.CPUAbuse)
+	}
+	if c.Sandbox.Bundles.Store != "" {
+		checkBundles(r, c.Sandbox.Bundles, c.Sandbox.Workspaces.Root, c.Database.DSN)
+	}
+	if c.Sandbox.EgressAudit.Enabled {
+		checkEgressAudit(r, c.Sandbox.EgressAudit)
+	}
+}
+
+// checkEgressAudit checks the audit has a table to read and that its
+// ranges parse, since a bad one would otherwise flag nothing.
+func checkEgressAudit(r *Report, c EgressAuditConfig) {
+	if !filepath.IsAbs(c.Conntrack) {
+		r.errorf("sandbox.egress_audit.conntrack: %q must be an absolute path", c.Conntrack)
+	}
+	if c.PollInterval <= 0 || c.MaxConnections <= 0 {
+		r.errorf("sandbox.egress_audit: poll_interval and max_connections must be > 0")
+	}
+	for _, cidr := range c.SuspiciousCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			r.errorf("sandbox.egress_audit.suspicious_cidrs: %v", err)
+		}
+	}
+	for _, cidr := range c.PrivateCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			r.errorf("sandbox.egress_audit.private_cidrs: %v", err)
 		}
 	}
+	if runtime.GOOS != "linux" {
+		r.warnf("sandbox.egress_audit needs a Linux host's conntrack table; executions will run unaudited")
+	}
+}
+
+// checkBundles checks the bundle store has somewhere to keep bundles and
+// executions somewhere to unpack them.
+func checkBundles(r *Report, c BundlesConfig, workspaceRoot, dsn string) {
+	switch c.Store {
+	case "disk":
+		if !filepath.IsAbs(c.Dir) {
+			r.errorf("sandbox.bundles.dir: %q must be an absolute path with store disk", c.Dir)
+		}
+	case "database":
+		if dsn == "" {
+			r.errorf("sandbox.bundles.store database needs database.dsn")
+		}
+	default:
+		r.errorf("sandbox.bundles.store must be disk or database, got %q", c.Store)
+	}
+	if workspaceRoot == "" {
+		r.errorf("sandbox.bundles needs sandbox.workspaces.root, which executions' bundles are unpacked under")
+	}
+	if c.MaxBytes <= 0 || c.MaxFiles <= 0 {
+		r.errorf("sandbox.bundles: max_bytes and max_files must be > 0")
+	}
+	if c.TTL < 0 {
+		r.errorf("sandbox.bundles.ttl must be >= 0")
+	}
+}
+
+// checkCPUAbuse checks the guardrail can tell a saturated stretch: it needs
+// at least two readings within sustain.
+func checkCPUAbuse(r *Report, c CPUAbuseConfig) {
+	switch c.Mode {
+	case "default", "aggressive":
+	default:
+		r.errorf("sandbox.cpu_abuse.mode must be default or aggressive, got %q", c.Mode)
+	}
+	if c.UsageFraction <= 0 || c.UsageFraction > 1 {
+		r.errorf("sandbox.cpu_abuse.usage_fraction must be > 0 and <= 1, got %g", c.UsageFraction)
+	}
+	if c.Sustain <= 0 || c.PollInterval <= 0 {
+		r.errorf("sandbox.cpu_abuse: sustain and poll_interval must be > 0")
+	} else if c.PollInterval > c.Sustain {
+		r.errorf("sandbox.cpu_abuse.poll_interval must be <= sustain")
+	}
+}
+
+// checkWorkdirSize checks the work_dir caps and that the exclude patterns
+// parse, since a bad one would otherwise only show as nothing excluded.
+func checkWorkdirSize(r *Report, c WorkdirSizeConfig) {
+	switch c.Mode {
+	case "reject", "warn":
+	default:
+		r.errorf("sandbox.workdir_size.mode must be reject or warn, got %q", c.Mode)
+	}
+	if c.MaxBytes < 0 || c.MaxFiles < 0 {
+		r.errorf("sandbox.workdir_size: max_bytes and max_files must be >= 0")
+	}
+	if c.MaxWalk <= 0 || c.MaxEntries <= 0 {
+		r.errorf("sandbox.workdir_size: max_walk and max_entries must be > 0")
+	}
+	for _, p := range c.Exclude {
+		if _, err := filepath.Match(p, ""); err != nil {
+			r.errorf("sandbox.workdir_size.exclude: %q: %v", p, err)
+		}
+	}
+}
+
+// checkDiskPressure checks that the thresholds are percentages that step up
+// in order: each response starts at less free space than the one before.
+func checkDiskPressure(r *Report, c DiskPressureConfig) {
+	if c.CheckInterval <= 0 {
+		r.errorf("sandbox.disk_pressure.check_interval must be > 0")
+	}
+	if c.DataRoot != "" && !filepath.IsAbs(c.DataRoot) {
+		r.errorf("sandbox.disk_pressure.data_root: %q must be an absolute path", c.DataRoot)
+	}
+	if c.GCFreePercent < 0 || c.TargetFreePercent > 100 {
+		r.errorf("sandbox.disk_pressure: free percentages must be between 0 and 100")
+	} else if !(c.GCFreePercent <= c.SuspendPullsFreePercent && c.SuspendPullsFreePercent <= c.WarnFreePercent && c.WarnFreePercent < c.TargetFreePercent) {
+		r.errorf("sandbox.disk_pressure: need gc_free_percent <= suspend_pulls_free_percent <= warn_free_percent < target_free_percent, got %g, %g, %g, %g",
+			c.GCFreePercent, c.SuspendPullsFreePercent, c.WarnFreePercent, c.TargetFreePercent)
+	}
+	switch c.GC.Candidates {
+	case "", "dangling", "unregistered":
+	default:
+		r.errorf("sandbox.disk_pressure.gc.candidates must be dangling or unregistered, got %q", c.GC.Candidates)
+	}
+	if c.GC.MinIdle < 0 {
+		r.errorf("sandbox.disk_pressure.gc.min_idle must be >= 0")
+	}
+}
+
+var validBridgeName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// checkCNI checks the settings the CNI plugins would otherwise reject on the
+// first network_enabled execution.
+func checkCNI(r *Report, c CNIConfig) {
+	if !filepath.IsAbs(c.PluginDir) {
+		r.errorf("sandbox.cni.plugin_dir: %q must be an absolute path", c.PluginDir)
+	}
+	if !validMountName.MatchString(c.Network) {
+		r.errorf("sandbox.cni.network: %q must be lowercase letters, digits, - and _", c.Network)
+	}
+	if !validBridgeName.MatchString(c.Bridge) {
+		r.errorf("sandbox.cni.bridge: %q must be an interface name of 1-15 letters, digits, '.', '-' and '_'", c.Bridge)
+	}
+	prefix, err := netip.ParsePrefix(c.Subnet)
+	switch {
+	case err != nil || !prefix.Addr().Is4():
+		r.errorf("sandbox.cni.subnet: %q must be an IPv4 CIDR such as 10.89.0.0/22", c.Subnet)
+	case prefix.Bits() > 30:
+		r.errorf("sandbox.cni.subnet: %q leaves no room for containers; use /30 or wider", c.Subnet)
+	case prefix != prefix.Masked():
+		r.errorf("sandbox.cni.subnet: %q has host bits set; did you mean %s?", c.Subnet, prefix.Masked())
+	}
+}
+
+// checkDebugTrace checks sandbox.debug_trace when it is on.
+func checkDebugTrace(r *Report, c DebugTraceConfig) {
+	if c.Strace == "" {
+		return
+	}
+	if !filepath.IsAbs(c.Strace) {
+		r.errorf("sandbox.debug_trace.strace: %q must be an absolute path", c.Strace)
+	} else if info, err := os.Stat(c.Strace); !r.offline && (err != nil || !info.Mode().IsRegular()) {
+		r.errorf("sandbox.debug_trace.strace: %q is not an existing file", c.Strace)
+	}
+	if c.MaxBytes <= 0 {
+		r.errorf("sandbox.debug_trace.max_bytes must be > 0")
+	}
+	if len(c.Keys) == 0 {
+		r.warnf("sandbox.debug_trace.keys is empty; no caller can ask for a debug trace")
+	}
+}
+
+// checkCompileCache checks sandbox.compile_cache when it is on.
+func checkCompileCache(r *Report, c CompileCacheConfig) {
+	if c.Dir == "" {
+		return
+	}
+	if !filepath.IsAbs(c.Dir) {
+		r.errorf("sandbox.compile_cache.dir: %q must be an absolute path", c.Dir)
+	}
+	if len(c.Languages) == 0 {
+		r.errorf("sandbox.compile_cache.languages is empty; list python, node or both")
+	}
+	for _, lang := range c.Languages {
+		if lang != "python" && lang != "node" {
+			r.errorf("sandbox.compile_cache.languages: %q has no compile cache (python, node)", lang)
+		}
+	}
+	if c.MaxBytes <= 0 || c.MaxEntryBytes <= 0 {
+		r.errorf("sandbox.compile_cache: max_bytes and max_entry_bytes must be > 0")
+	} else if c.MaxEntryBytes > c.MaxBytes {
+		r.errorf("sandbox.compile_cache.max_entry_bytes (%d) exceeds max_bytes (%d)", c.MaxEntryBytes, c.MaxBytes)
+	}
+}
+
+// checkTelemetry covers the database, metrics and tracing sections.
+func (c *Config) checkTelemetry(r *Report) {
 	if c.Database.DSN != "" && strings.Contains(c.Database.DSN, "sslmode=disable") {
-		log.Warn().Msg("database DSN has sslmode=disable — connections to Postgres are unencrypted")
+		r.warnf("database.dsn has sslmode=disable — connections to Postgres are unencrypted; use sslmode=require or verify-full")
+	}
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		if c.Metrics.Enabled {
+			r.warnf("tracing.enabled is set but tracing.endpoint is empty, so spans go nowhere; set the collector's endpoint or disable tracing")
+		} else {
+			r.warnf("tracing.enabled is set but tracing.endpoint is empty and metrics are disabled, so the server exports no telemetry at all; set tracing.endpoint or enable metrics")
+		}
+	}
+	if c.Database.Required && c.Database.DSN == "" && !slices.Contains(c.Database.Sinks, "file") {
+		r.errorf("database.required is set but there is no audit log to require: set database.dsn or add the file sink")
+	}
+	if c.Database.StoreCode && c.Database.DSN == "" {
+		r.warnf("database.store_code is set but database.dsn is empty, so there is no audit log to keep code in; set the DSN or drop store_code")
+	}
+	if b := c.Database.AuditBatch; b.MaxRows < 1 || b.MaxRows > 1000 {
+		r.errorf("database.audit_batch.max_rows must be 1-1000, got %d", b.MaxRows)
+	}
+	if b := c.Database.AuditBatch; b.MaxWait < 0 || b.MaxWait > time.Minute {
+		r.errorf("database.audit_batch.max_wait must be 0-1m, got %s", b.MaxWait)
+	}
+	if c.Database.RetainedExecutions < 0 {
+		r.errorf("database.retained_executions must be >= 0, got %d", c.Database.RetainedExecutions)
+	}
+	checkAuditSinks(r, c.Database)
+	checkSLO(r, c.Metrics.SLO)
+	checkStats(r, c.Metrics.Stats)
+}
+
+// checkAuditSinks checks the audit sinks named and the file sink's settings
+// when it is one of them.
+func checkAuditSinks(r *Report, c DatabaseConfig) {
+	seen := map[string]bool{}
+	for _, s := range c.Sinks {
+		switch {
+		case s != "postgres" && s != "file":
+			r.errorf("database.sinks: unknown sink %q, want postgres or file", s)
+		case seen[s]:
+			r.errorf("database.sinks: %q is listed twice", s)
+		}
+		seen[s] = true
+	}
+	f := c.FileSink
+	if f.ServeExecutions && !seen["file"] {
+		r.errorf("database.file_sink.serve_executions is set but database.sinks does not include file")
+	}
+	if !seen["file"] {
+		return
+	}
+	if !filepath.IsAbs(f.Path) {
+		r.errorf("database.file_sink.path must be an absolute path, got %q", f.Path)
+	}
+	if f.MaxBytes < 0 {
+		r.errorf("database.file_sink.max_bytes must be >= 0, got %d", f.MaxBytes)
+	}
+	if f.MaxAge < 0 {
+		r.errorf("database.file_sink.max_age must be >= 0, got %s", f.MaxAge)
+	}
+	switch f.Fsync {
+	case "always", "batch", "never":
+	default:
+		r.errorf("database.file_sink.fsync must be always, batch or never, got %q", f.Fsync)
+	}
+}
+
+// maxSLOBuckets bounds the buckets kept per language: a week of minutes.
+const maxSLOBuckets = 7 * 24 * 60
+
+// checkSLO checks that the window divides into buckets and that each
+// objective leaves an error budget to burn.
+func checkSLO(r *Report, c SLOConfig) {
+	if c.Bucket <= 0 || c.Window < c.Bucket {
+		r.errorf("metrics.slo: bucket must be > 0 and window at least one bucket, got window %s, bucket %s", c.Window, c.Bucket)
+	} else if c.Window%c.Bucket != 0 {
+		r.errorf("metrics.slo.window (%s) must be a whole number of buckets (%s)", c.Window, c.Bucket)
+	} else if n := c.Window / c.Bucket; n > maxSLOBuckets {
+		r.errorf("metrics.slo.window (%s) is %d buckets of %s; at most %d are kept", c.Window, n, c.Bucket, maxSLOBuckets)
+	}
+	for _, lang := range sortedKeys(c.Objectives) {
+		o := c.Objectives[lang]
+		if o.P95 < 0 {
+			r.errorf("metrics.slo.objectives.%s.p95 must be >= 0", lang)
+		}
+		if o.SuccessRate < 0 || o.SuccessRate >= 1 {
+			r.errorf("metrics.slo.objectives.%s.success_rate must be at least 0 and below 1, got %g", lang, o.SuccessRate)
+		}
+		if o.P95 == 0 && o.SuccessRate == 0 {
+			r.warnf("metrics.slo.objectives.%s sets neither p95 nor success_rate, so nothing is tracked for it", lang)
+		}
+	}
+}
+
+func checkStats(r *Report, c StatsConfig) {
+	if c.CacheTTL < 0 || c.CacheTTL > time.Minute {
+		r.errorf("metrics.stats.cache_ttl must be between 0 and 1m, got %s", c.CacheTTL)
+	}
+	w := c.Weights
+	if w.Slots < 0 || w.Queue < 0 || w.Claude < 0 || w.RateLimit < 0 {
+		r.errorf("metrics.stats.weights must all be >= 0, got %+v", w)
+	} else if w.Slots+w.Queue+w.Claude+w.RateLimit == 0 {
+		r.errorf("metrics.stats.weights are all 0; saturation_score needs at least one")
+	}
+}
+
+func (c *Config) checkSecurity(r *Report) {
+	switch c.Security.AuthPrecedence {
+	case "", "client_cert", "api_key":
+	default:
+		r.errorf("security.auth_precedence must be client_cert or api_key, got %q", c.Security.AuthPrecedence)
+	}
+	switch c.Security.ClaudeWorkdirGit {
+	case "", "ignore", "warn", "require_clean":
+	default:
+		r.errorf("security.claude_workdir_git must be ignore, warn or require_clean, got %q", c.Security.ClaudeWorkdirGit)
+	}
+	if s := c.Security; s.RateLimitRPS > 0 && float64(s.RateLimitBurst) < s.RateLimitRPS {
+		r.warnf("security.rate_limit_burst (%d) is below rate_limit_rps (%g): a client can never send a full second's worth of requests at once, and a burst of 0 refuses everything; raise the burst to at least the rate", s.RateLimitBurst, s.RateLimitRPS)
+	}
+	checkKeyLimits(r, c.Security)
+	if c.Security.MaxTaskExecutions < 0 {
+		r.errorf("security.max_task_executions must be >= 0")
+	}
+	if c.Security.MaxCancellationGroupSize < 0 {
+		r.errorf("security.max_cancellation_group_size must be >= 0")
 	}
 	if len(c.Security.AllowedKeys) == 0 && !c.Security.AllowUnauthenticated {
-		log.Warn().Msg("security.allowed_keys is empty and allow_unauthenticated is false — all requests will be rejected; set allowed_keys or allow_unauthenticated: true")
+		r.warnf("security.allowed_keys is empty and allow_unauthenticated is false — all requests will be rejected; set allowed_keys or allow_unauthenticated: true")
+	}
+	checkClaudeSecurity(r, c.Security.Claude)
+	checkCostBudget(r, c.Security.CostBudget)
+	checkClaudeTokens(r, c.Security.ClaudeTokens, c.AuthProxy.Port)
+	checkAnomaly(r, c.Security.Anomaly)
+	checkDetector(r, c.Security.Detector)
+	checkKillSwitches(r, c.Security)
+	checkNetworkPolicy(r, c.Security.NetworkPolicy)
+	if c.Security.PrivacyMode.Enabled && c.Database.StoreCode {
+		r.warnf("database.store_code has no effect with security.privacy_mode.enabled, which keeps every caller's code out of the audit log; drop one of them")
+	}
+}
+
+func checkKeyLimits(r *Report, s SecurityConfig) {
+	switch s.RateLimitBy {
+	case "", "ip", "key":
+	default:
+		r.errorf("security.rate_limit_by must be ip or key, got %q", s.RateLimitBy)
+	}
+	check := func(name string, l KeyLimitConfig) {
+		if l.RPS < 0 || l.Burst < 0 || l.MaxConcurrent < 0 || l.DailyQuota < 0 {
+			r.errorf("%s: rps, burst, max_concurrent and daily_quota must be >= 0", name)
+		}
+		if (l.RPS != 0 || l.Burst != 0) && s.RateLimitBy != "key" {
+			r.warnf("%s sets rps or burst, which only apply with security.rate_limit_by: key", name)
+		}
+	}
+	check("security.default_key_limit", s.DefaultKeyLimit)
+	for _, key := range sortedKeys(s.KeyLimits) {
+		if key == "" {
+			r.errorf("security.key_limits has an empty key")
+			continue
+		}
+		// The key is a secret; the report doesn't name it.
+		check("security.key_limits", s.KeyLimits[key])
+	}
+}
+
+func (c *Config) checkTLS(r *Report) {
+	if c.TLS.Enabled {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			r.errorf("tls.cert_file and tls.key_file are required when TLS is enabled")
+		}
+		for _, f := range []struct{ name, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+			if f.path != "" {
+				checkReadable(r, f.name, f.path)
+			}
+		}
+	}
+	checkClientAuth(r, c.TLS)
+}
+
+// checkReadable reports the file at path, named by setting, unless it can be
+// opened for reading.
+func checkReadable(r *Report, setting, path string) {
+	if r.offline {
+		return
+	}
+	f, err := os.Open(filepath.Clean(path)) // #nosec G304 -- path comes from the config file
+	if err != nil {
+		r.errorf("%s: %q can't be read: %v", setting, path, errors.Unwrap(err))
+		return
+	}
+	_ = f.Close()
+}
+
+// checkConcurrency checks that the per-language split of max_concurrent
+// covers every language and adds up exactly. Language names are checked by
+// the backend, which knows the runtimes.
+func checkConcurrency(r *Report, split map[string]int, maxConcurrent int) {
+	if len(split) == 0 {
+		return
+	}
+	if _, ok := split["default"]; !ok {
+		r.errorf("sandbox.concurrency: a \"default\" pool is required for languages without their own limit")
+	}
+	sum, valid := 0, true
+	for _, name := range sortedKeys(split) {
+		if split[name] < 1 {
+			r.errorf("sandbox.concurrency.%s must be >= 1, got %d", name, split[name])
+			valid = false
+		}
+		sum += split[name]
+	}
+	if valid && sum != maxConcurrent {
+		r.errorf("sandbox.concurrency: pools sum to %d, must equal sandbox.max_concurrent (%d)", sum, maxConcurrent)
+	}
+}
+
+// checkFairShare checks the weight tiers: each gives at least one share to
+// at least one caller, and no caller is in two.
+func checkFairShare(r *Report, fs FairShareConfig) {
+	if fs.MaxWait <= 0 {
+		r.errorf("sandbox.fair_share.max_wait must be > 0")
+	} else if fs.MaxWait >= time.Second {
+		r.warnf("sandbox.fair_share.max_wait is %s; executions are refused after waiting 1s, so an outranked caller's may be refused before it applies", fs.MaxWait)
+	}
+	tier := make(map[string]int)
+	for i, w := range fs.Weights {
+		if w.Weight < 1 {
+			r.errorf("sandbox.fair_share.weights[%d].weight must be >= 1, got %d", i, w.Weight)
+		}
+		if len(w.Keys) == 0 {
+			r.errorf("sandbox.fair_share.weights[%d].keys must not be empty", i)
+		}
+		for _, key := range w.Keys {
+			if j, ok := tier[key]; ok && j != i {
+				r.errorf("sandbox.fair_share.weights[%d]: a key is also in weights[%d]", i, j)
+				continue
+			}
+			tier[key] = i
+		}
+	}
+}
+
+// checkClaudeCaches checks cache volume names and mount points. Volumes
+// may only sit in claude's home directory so they can't shadow the tools or
+// the workspace.
+func checkClaudeCaches(r *Report, c ClaudeCachesConfig) {
+	if len(c.Volumes) == 0 {
+		return
+	}
+	if c.MaxBytes < 0 {
+		r.errorf("sandbox.claude_caches.max_bytes must be >= 0")
+	} else if c.MaxBytes > 0 && c.CheckInterval <= 0 {
+		r.errorf("sandbox.claude_caches.check_interval must be > 0 when max_bytes is set")
+	}
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for _, v := range c.Volumes {
+		if !validMountName.MatchString(v.Name) {
+			r.errorf("sandbox.claude_caches: name %q must be lowercase letters, digits, - or _", v.Name)
+			continue
+		}
+		if names[v.Name] {
+			r.errorf("sandbox.claude_caches: duplicate name %q", v.Name)
+			continue
+		}
+		names[v.Name] = true
+
+		p := v.ContainerPath
+		if !filepath.IsAbs(p) || filepath.Clean(p) != p || !strings.HasPrefix(p, "/home/node/") {
+			r.errorf("sandbox.claude_caches[%s].container_path: %q must be a clean path under /home/node", v.Name, p)
+			continue
+		}
+		if p == "/home/node/.claude" || strings.HasPrefix(p, "/home/node/.claude/") {
+			r.errorf("sandbox.claude_caches[%s].container_path: %q would persist claude's own state", v.Name, p)
+			continue
+		}
+		for _, other := range sortedKeys(paths) {
+			if p == other || strings.HasPrefix(p, other+"/") || strings.HasPrefix(other, p+"/") {
+				r.errorf("sandbox.claude_caches[%s].container_path: %q overlaps %q", v.Name, p, other)
+				break
+			}
+		}
+		paths[p] = true
+	}
+}
+
+// checkClientAuth checks that client certificate verification has TLS
+// and a readable CA bundle to verify against.
+func checkClientAuth(r *Report, t TLSConfig) {
+	switch t.ClientAuthMode {
+	case "", "none":
+		if t.ClientCAFile != "" {
+			r.errorf("tls.client_ca_file is set but tls.client_auth_mode is none")
+		}
+		return
+	case "request", "require_and_verify":
+	default:
+		r.errorf("tls.client_auth_mode must be none, request or require_and_verify, got %q", t.ClientAuthMode)
+		return
+	}
+	if !t.Enabled {
+		r.errorf("tls.client_auth_mode %s requires tls.enabled", t.ClientAuthMode)
+	}
+	if t.ClientCAFile == "" {
+		r.errorf("tls.client_ca_file is required when tls.client_auth_mode is %s", t.ClientAuthMode)
+	} else if t.Enabled {
+		checkReadable(r, "tls.client_ca_file", t.ClientCAFile)
+	}
+}
+
+// checkClaudeSecurity checks that the claude defaults fit the ceilings
+// they sit under. Tool specs are checked by the backend, which knows their
+// syntax.
+func checkClaudeSecurity(r *Report, c ClaudeSecurityConfig) {
+	if c.MaxTurns < 0 || c.DefaultMaxTurns < 0 {
+		r.errorf("security.claude: max_turns and default_max_turns must be >= 0")
+	} else if c.MaxTurns > 0 && c.DefaultMaxTurns > c.MaxTurns {
+		r.errorf("security.claude.default_max_turns (%d) must be <= max_turns (%d)", c.DefaultMaxTurns, c.MaxTurns)
+	}
+	if c.DefaultModel != "" && len(c.AllowedModels) > 0 && !slices.Contains(c.AllowedModels, c.DefaultModel) {
+		r.errorf("security.claude.default_model %q is not in allowed_models", c.DefaultModel)
+	}
+	if c.MaxPromptBytes < 0 || c.MaxPromptBytes > 1<<20 {
+		r.errorf("security.claude.max_prompt_bytes must be 0-%d, got %d", 1<<20, c.MaxPromptBytes)
+	}
+	switch c.PromptBlockSeverity {
+	case "", "low", "medium", "high", "critical", "none":
+	default:
+		r.errorf("security.claude.prompt_block_severity must be low, medium, high, critical or none, got %q", c.PromptBlockSeverity)
+	}
+	for _, model := range sortedKeys(c.Pricing) {
+		if p := c.Pricing[model]; p.InputPerMTok < 0 || p.OutputPerMTok < 0 {
+			r.errorf("security.claude.pricing.%s: prices must be >= 0", model)
+		}
+	}
+}
+
+// checkCostBudget checks that the budget and every language cost are
+// non-negative. Language names are not checked: a cost for a runtime the
+// server doesn't have is never charged.
+func checkCostBudget(r *Report, c CostBudgetConfig) {
+	if c.Hourly < 0 {
+		r.errorf("security.cost_budget.hourly must be >= 0")
+	}
+	for _, lang := range sortedKeys(c.LanguageCosts) {
+		if cost := c.LanguageCosts[lang]; cost < 0 {
+			r.errorf("security.cost_budget.language_costs.%s must be >= 0, got %g", lang, cost)
+		}
+	}
+}
+
+func checkAnomaly(r *Report, c AnomalyConfig) {
+	if !c.Enabled {
+		return
+	}
+	if c.MinExecutions < 1 {
+		r.errorf("security.anomaly.min_executions must be >= 1, got %d", c.MinExecutions)
+	}
+	if c.DurationFactor <= 1 {
+		r.errorf("security.anomaly.duration_factor must be above 1, got %g", c.DurationFactor)
+	}
+	if c.MinOutlierDuration < 0 {
+		r.errorf("security.anomaly.min_outlier_duration must be >= 0")
+	}
+	if c.DetectionRate < 0 || c.DetectionRate >= 1 {
+		r.errorf("security.anomaly.detection_rate must be at least 0 and below 1, got %g", c.DetectionRate)
+	}
+	if c.PersistInterval <= 0 {
+		r.errorf("security.anomaly.persist_interval must be > 0")
+	}
+}
+
+// checkDetector checks the detector's bounds and that every custom pattern
+// compiles and has anchors to pre-filter lines with.
+func checkDetector(r *Report, c DetectorConfig) {
+	if c.AnalysisBudget < 0 {
+		r.errorf("security.detector.analysis_budget must be >= 0")
+	}
+	if c.MaxLineLength != 0 && c.MaxLineLength < 1024 {
+		r.errorf("security.detector.max_line_length must be 0 or at least 1024, got %d", c.MaxLineLength)
+	}
+	names := make(map[string]bool)
+	for i, p := range c.Patterns {
+		if p.Name == "" {
+			r.errorf("security.detector.patterns[%d]: name is required", i)
+			continue
+		}
+		if names[p.Name] {
+			r.errorf("security.detector.patterns: duplicate name %q", p.Name)
+		}
+		names[p.Name] = true
+		if _, err := regexp.Compile(p.Regex); err != nil || p.Regex == "" {
+			r.errorf("security.detector.patterns.%s: invalid regex %q", p.Name, p.Regex)
+		}
+		switch p.Severity {
+		case "low", "medium", "high", "critical":
+		default:
+			r.errorf("security.detector.patterns.%s.severity must be low, medium, high or critical, got %q", p.Name, p.Severity)
+		}
+		if len(p.Anchors) == 0 || slices.Contains(p.Anchors, "") {
+			r.errorf("security.detector.patterns.%s.anchors must list literal substrings every match contains", p.Name)
+		}
+	}
+}
+
+// checkKillSwitches checks the kill switches name features that exist.
+// Languages aren't checked against the runtimes: disabling one the server
+// doesn't have is harmless.
+func checkKillSwitches(r *Report, c SecurityConfig) {
+	for _, lang := range c.DisabledLanguages {
+		if lang == "" {
+			r.errorf("security.disabled_languages has an empty entry")
+		}
+	}
+	for _, f := range c.DisabledFeatures {
+		if !slices.Contains(Features, f) {
+			r.errorf("security.disabled_features: unknown feature %q (known: %s)", f, strings.Join(Features, ", "))
+		}
+	}
+	switch c.DisabledInFlight {
+	case "", "finish", "kill":
+	default:
+		r.errorf("security.disabled_in_flight must be finish or kill, got %q", c.DisabledInFlight)
+	}
+}
+
+// checkNetworkPolicy checks the policy's mode and claude handling, and that
+// its languages are only given where they mean something.
+func checkNetworkPolicy(r *Report, c NetworkPolicyConfig) {
+	switch c.Mode {
+	case "", "allow", "deny":
+		if len(c.Languages) > 0 {
+			r.warnf("security.network_policy.languages has no effect unless mode is allowlist_languages")
+		}
+	case "allowlist_languages":
+		if len(c.Languages) == 0 {
+			r.warnf("security.network_policy.languages is empty, so allowlist_languages denies every language a network")
+		}
+	default:
+		r.errorf("security.network_policy.mode must be allow, deny or allowlist_languages, got %q", c.Mode)
+	}
+	if slices.Contains(c.Languages, "") {
+		r.errorf("security.network_policy.languages has an empty entry")
+	}
+	switch c.Claude {
+	case "", "force", "refuse":
+	default:
+		r.errorf("security.network_policy.claude must be force or refuse, got %q", c.Claude)
+	}
+}
+
+// checkClaudeTokens checks that caller tokens have the auth proxy to go
+// through and that every credential says where its token is and who may use
+// it. The tokens themselves are checked at startup, when they are read.
+func checkClaudeTokens(r *Report, c ClaudeTokensConfig, proxyPort int) {
+	if !c.Enabled {
+		return
+	}
+	if proxyPort == 0 {
+		r.errorf("security.claude_tokens.enabled needs auth_proxy.port: tokens only reach the API through the proxy")
+	}
+	for _, name := range sortedKeys(c.Credentials) {
+		cred := c.Credentials[name]
+		if !validMountName.MatchString(name) {
+			r.errorf("security.claude_tokens.credentials: name %q must be lowercase letters, digits, - or _", name)
+			continue
+		}
+		if cred.TokenEnv == "" {
+			r.errorf("security.claude_tokens.credentials.%s.token_env is required", name)
+		}
+		if len(cred.Keys) == 0 {
+			r.errorf("security.claude_tokens.credentials.%s.keys is empty, so nobody may use it", name)
+		}
+	}
+}
+
+// checkDefaultEnv checks sandbox.default_env's keys against the rules for a
+// request's.
+func checkDefaultEnv(r *Report, env DefaultEnvConfig) {
+	check := func(field string, vars map[string]string) {
+		for _, key := range sortedKeys(vars) {
+			if err := CheckEnvKey(key); err != nil {
+				r.errorf("%s: %v", field, err)
+			}
+		}
+	}
+	check("sandbox.default_env.global", env.Global)
+	for _, lang := range sortedKeys(env.Languages) {
+		if lang == "" {
+			r.errorf("sandbox.default_env.languages has an empty language")
+			continue
+		}
+		check("sandbox.default_env.languages."+lang, env.Languages[lang])
+	}
+}
+
+// checkRuntimeLimits checks sandbox.runtime_limits sets no negative limit.
+// The backend checks the languages, and the limits against the ceilings
+// requests are held to, when it starts.
+func checkRuntimeLimits(r *Report, limits map[string]DefaultLimits) {
+	for _, lang := range sortedKeys(limits) {
+		l := limits[lang]
+		if lang == "" {
+			r.errorf("sandbox.runtime_limits has an empty language")
+			continue
+		}
+		if l.CPUShares < 0 || l.MemoryMB < 0 || l.PidsLimit < 0 || l.DiskMB < 0 {
+			r.errorf("sandbox.runtime_limits.%s: limits must be >= 0 (0 keeps the runtime's default)", lang)
+		}
+	}
+}
+
+// checkSharedMounts checks the parts of sandbox.shared_mounts that don't
+// depend on the sandbox: the backend also rejects sensitive host paths and
+// unknown languages when it starts.
+func checkSharedMounts(r *Report, mounts []SharedMountConfig) {
+	names := make(map[string]bool)
+	targets := make(map[string]bool)
+	for _, m := range mounts {
+		if !validMountName.MatchString(m.Name) {
+			r.errorf("sandbox.shared_mounts: name %q must be lowercase letters, digits, - or _", m.Name)
+			continue
+		}
+		if names[m.Name] {
+			r.errorf("sandbox.shared_mounts: duplicate name %q", m.Name)
+			continue
+		}
+		names[m.Name] = true
+
+		if !filepath.IsAbs(m.HostPath) {
+			r.errorf("sandbox.shared_mounts[%s].host_path: %q must be an absolute path", m.Name, m.HostPath)
+		} else if info, err := os.Stat(m.HostPath); !r.offline && (err != nil || !info.IsDir()) {
+			r.errorf("sandbox.shared_mounts[%s].host_path: %q is not an existing directory", m.Name, m.HostPath)
+		}
+
+		if len(m.AllowedLanguages) == 0 {
+			r.errorf("sandbox.shared_mounts[%s].allowed_languages must not be empty", m.Name)
+		}
+
+		if !filepath.IsAbs(m.ContainerPath) || filepath.Clean(m.ContainerPath) != m.ContainerPath || m.ContainerPath == "/" {
+			r.errorf("sandbox.shared_mounts[%s].container_path: %q must be a clean absolute path other than /", m.Name, m.ContainerPath)
+			continue
+		}
+		if i := slices.IndexFunc(reservedContainerPaths, func(reserved string) bool {
+			return m.ContainerPath == reserved || strings.HasPrefix(m.ContainerPath, reserved+"/")
+		}); i >= 0 {
+			r.errorf("sandbox.shared_mounts[%s].container_path: %q is reserved", m.Name, reservedContainerPaths[i])
+			continue
+		}
+		for _, target := range sortedKeys(targets) {
+			if m.ContainerPath == target || strings.HasPrefix(m.ContainerPath, target+"/") || strings.HasPrefix(target, m.ContainerPath+"/") {
+				r.errorf("sandbox.shared_mounts[%s].container_path: %q overlaps %q", m.Name, m.ContainerPath, target)
+				break
+			}
+		}
+		targets[m.ContainerPath] = true
+	}
+}
+
+// sortedKeys returns m's keys in order, so problems come out the same way
+// every time.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	return nil
+	sort.Strings(keys)
+	return keys
 }
 
 // Address returns the listen address string.
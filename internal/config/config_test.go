@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -27,10 +29,26 @@ func TestDefaultConfig(t *testing.T) {
 	}
 }
 
+// touch creates empty files in a temporary directory and returns their paths.
+func touch(t *testing.T, names ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+		if err := os.WriteFile(paths[i], nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
 func TestValidate(t *testing.T) {
 	valid := func() *Config {
 		return DefaultConfig()
 	}
+	tlsFiles := touch(t, "cert.pem", "key.pem")
+	strace := touch(t, "strace")[0]
 
 	tests := []struct {
 		name    string
@@ -53,18 +71,92 @@ func TestValidate(t *testing.T) {
 		}, true},
 		{"TLS enabled with cert+key", func(c *Config) {
 			c.TLS.Enabled = true
-			c.TLS.CertFile = "/etc/ssl/cert.pem"
-			c.TLS.KeyFile = "/etc/ssl/key.pem"
+			c.TLS.CertFile = tlsFiles[0]
+			c.TLS.KeyFile = tlsFiles[1]
 		}, false},
+		{"claude_write_timeout < write_timeout", func(c *Config) { c.Server.ClaudeWriteTimeout = time.Second }, true},
+		{"claude_write_timeout 0 (no deadline)", func(c *Config) { c.Server.ClaudeWriteTimeout = 0 }, false},
+		{"audit_batch max_rows 0", func(c *Config) { c.Database.AuditBatch.MaxRows = 0 }, true},
+		{"audit_batch max_rows 1", func(c *Config) { c.Database.AuditBatch.MaxRows = 1 }, false},
+		{"audit_batch max_wait negative", func(c *Config) { c.Database.AuditBatch.MaxWait = -time.Millisecond }, true},
+		{"audit_batch max_wait 0", func(c *Config) { c.Database.AuditBatch.MaxWait = 0 }, false},
+		{"write_timeout negative", func(c *Config) { c.Server.WriteTimeout = -time.Second }, true},
+		{"setup_timeout 0", func(c *Config) { c.Sandbox.SetupTimeout = 0 }, true},
+		{"cleanup_grace negative", func(c *Config) { c.Sandbox.CleanupGrace = -time.Second }, true},
+		{"claude_idle_output_timeout negative", func(c *Config) { c.Sandbox.ClaudeIdleOutputTimeout = -time.Second }, true},
+		{"max_idle_output_timeout negative", func(c *Config) { c.Sandbox.MaxIdleOutputTimeout = -time.Second }, true},
+		{"max_upload_code_bytes negative", func(c *Config) { c.Sandbox.MaxUploadCodeBytes = -1 }, true},
+		{"max_upload_code_bytes 0 (uploads off)", func(c *Config) { c.Sandbox.MaxUploadCodeBytes = 0 }, false},
+		{"binary.max_bytes 0", func(c *Config) { c.Sandbox.Binary.MaxBytes = 0 }, true},
+		{"output cap 0", func(c *Config) { c.Sandbox.Output.MaxStderrBytes = 0 }, true},
+		{"output stream cap over total", func(c *Config) { c.Sandbox.Output.MaxStdoutBytes = 2 << 20 }, true},
+		{"output total raised", func(c *Config) { c.Sandbox.Output.MaxTotalBytes = 4 << 20 }, false},
+		{"max_task_executions negative", func(c *Config) { c.Security.MaxTaskExecutions = -1 }, true},
+		{"max_task_executions 50", func(c *Config) { c.Security.MaxTaskExecutions = 50 }, false},
+		{"max_cancellation_group_size negative", func(c *Config) { c.Security.MaxCancellationGroupSize = -1 }, true},
+		{"rate_limit_by user", func(c *Config) { c.Security.RateLimitBy = "user" }, true},
+		{"key_limits daily_quota negative", func(c *Config) {
+			c.Security.KeyLimits = map[string]KeyLimitConfig{"key-a": {DailyQuota: -1}}
+		}, true},
+		{"key_limits by key", func(c *Config) {
+			c.Security.RateLimitBy = "key"
+			c.Security.KeyLimits = map[string]KeyLimitConfig{"key-a": {RPS: 5, Burst: 10, MaxConcurrent: 2}}
+		}, false},
+		{"anomaly duration_factor 1", func(c *Config) { c.Security.Anomaly.DurationFactor = 1 }, true},
+		{"anomaly detection_rate 1", func(c *Config) { c.Security.Anomaly.DetectionRate = 1 }, true},
+		{"anomaly disabled with zero settings", func(c *Config) { c.Security.Anomaly = AnomalyConfig{} }, false},
+		{"detector without bounds", func(c *Config) { c.Security.Detector = DetectorConfig{} }, false},
+		{"detector max_line_length 100", func(c *Config) { c.Security.Detector.MaxLineLength = 100 }, true},
+		{"detector pattern", func(c *Config) {
+			c.Security.Detector.Patterns = []DetectorPattern{{Name: "nsenter", Regex: `nsenter\s+-t\s*1`, Severity: "critical", Anchors: []string{"nsenter"}}}
+		}, false},
+		{"detector pattern without anchors", func(c *Config) {
+			c.Security.Detector.Patterns = []DetectorPattern{{Name: "nsenter", Regex: `nsenter\s+-t\s*1`, Severity: "critical"}}
+		}, true},
+		{"detector pattern bad regex", func(c *Config) {
+			c.Security.Detector.Patterns = []DetectorPattern{{Name: "x", Regex: `(?<=a)b`, Severity: "low", Anchors: []string{"b"}}}
+		}, true},
+		{"disabled features known", func(c *Config) { c.Security.DisabledFeatures = []string{"streaming", "claude_workdir"} }, false},
+		{"disabled feature unknown", func(c *Config) { c.Security.DisabledFeatures = []string{"artifacts"} }, true},
+		{"disabled_in_flight kill", func(c *Config) { c.Security.DisabledInFlight = "kill" }, false},
+		{"disabled_in_flight invalid", func(c *Config) { c.Security.DisabledInFlight = "drain" }, true},
+		{"limit_enforcement strict", func(c *Config) { c.Sandbox.LimitEnforcement = "strict" }, false},
+		{"limit_enforcement invalid", func(c *Config) { c.Sandbox.LimitEnforcement = "warn" }, true},
+		{"debug_trace strace", func(c *Config) { c.Sandbox.DebugTrace.Strace, c.Sandbox.DebugTrace.Keys = strace, []string{"k"} }, false},
+		{"debug_trace relative strace", func(c *Config) { c.Sandbox.DebugTrace.Strace = "bin/strace" }, true},
+		{"debug_trace missing strace", func(c *Config) { c.Sandbox.DebugTrace.Strace = "/nonexistent/strace" }, true},
+		{"debug_trace max_bytes 0", func(c *Config) {
+			c.Sandbox.DebugTrace.Strace, c.Sandbox.DebugTrace.MaxBytes = strace, 0
+		}, true},
+		{"compile_cache dir", func(c *Config) { c.Sandbox.CompileCache.Dir = "/var/cache/sandbox-compiled" }, false},
+		{"compile_cache relative dir", func(c *Config) { c.Sandbox.CompileCache.Dir = "cache" }, true},
+		{"compile_cache unknown language", func(c *Config) {
+			c.Sandbox.CompileCache.Dir, c.Sandbox.CompileCache.Languages = "/var/cache/sandbox-compiled", []string{"ruby"}
+		}, true},
+		{"compile_cache entry over total", func(c *Config) {
+			c.Sandbox.CompileCache.Dir, c.Sandbox.CompileCache.MaxEntryBytes = "/var/cache/sandbox-compiled", 1<<30
+		}, true},
 		{"auth_proxy port -1", func(c *Config) { c.AuthProxy.Port = -1 }, true},
 		{"auth_proxy port 70000", func(c *Config) { c.AuthProxy.Port = 70000 }, true},
 		{"auth_proxy port 8081", func(c *Config) { c.AuthProxy.Port = 8081 }, false},
+		{"auth_proxy negative dns_cache_ttl", func(c *Config) { c.AuthProxy.DNSCacheTTL = -time.Second }, true},
+		{"auth_proxy no response_header_timeout", func(c *Config) { c.AuthProxy.ResponseHeaderTimeout = 0 }, false},
 		{"relative workdir root", func(c *Config) {
 			c.Sandbox.AllowedWorkdirRoots = []string{"relative/path"}
 		}, true},
 		{"absolute workdir root", func(c *Config) {
 			c.Sandbox.AllowedWorkdirRoots = []string{"/tmp/sandbox"}
 		}, false},
+		{"relative workspaces root", func(c *Config) {
+			c.Sandbox.Workspaces.Root = "workspaces"
+		}, true},
+		{"workspaces file cap over total", func(c *Config) {
+			c.Sandbox.Workspaces.Root = "/srv/workspaces"
+			c.Sandbox.Workspaces.MaxFileBytes = c.Sandbox.Workspaces.MaxTotalBytes + 1
+		}, true},
+		{"workspaces enabled", func(c *Config) {
+			c.Sandbox.Workspaces.Root = "/srv/workspaces"
+		}, false},
 	}
 
 	for _, tt := range tests {
@@ -79,6 +171,764 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidate_ChaosRefusedInProduction(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Sandbox.Chaos.Enabled = true
+
+	t.Setenv("ENV", "development")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("chaos outside production: Validate() error = %v", err)
+	}
+
+	t.Setenv("ENV", "production")
+	if err := cfg.Validate(); err == nil {
+		t.Error("chaos with ENV=production: expected Validate() error")
+	}
+}
+
+func TestValidate_SharedMounts(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.csv")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mount := func(modify func(*SharedMountConfig)) []SharedMountConfig {
+		m := SharedMountConfig{Name: "datasets", HostPath: dir, ContainerPath: "/data", AllowedLanguages: []string{"python"}}
+		modify(&m)
+		return []SharedMountConfig{m}
+	}
+
+	tests := []struct {
+		name    string
+		mounts  []SharedMountConfig
+		wantErr bool
+	}{
+		{"valid", mount(func(m *SharedMountConfig) {}), false},
+		{"uppercase name", mount(func(m *SharedMountConfig) { m.Name = "Data" }), true},
+		{"empty name", mount(func(m *SharedMountConfig) { m.Name = "" }), true},
+		{"relative host path", mount(func(m *SharedMountConfig) { m.HostPath = "data" }), true},
+		{"missing host path", mount(func(m *SharedMountConfig) { m.HostPath = filepath.Join(dir, "missing") }), true},
+		{"host path is a file", mount(func(m *SharedMountConfig) { m.HostPath = file }), true},
+		{"relative container path", mount(func(m *SharedMountConfig) { m.ContainerPath = "data" }), true},
+		{"unclean container path", mount(func(m *SharedMountConfig) { m.ContainerPath = "/data/../etc" }), true},
+		{"container path /", mount(func(m *SharedMountConfig) { m.ContainerPath = "/" }), true},
+		{"container path under /workspace", mount(func(m *SharedMountConfig) { m.ContainerPath = "/workspace/data" }), true},
+		{"container path /tmp", mount(func(m *SharedMountConfig) { m.ContainerPath = "/tmp" }), true},
+		{"no languages", mount(func(m *SharedMountConfig) { m.AllowedLanguages = nil }), true},
+		{"duplicate name", []SharedMountConfig{
+			{Name: "a", HostPath: dir, ContainerPath: "/a", AllowedLanguages: []string{"python"}},
+			{Name: "a", HostPath: dir, ContainerPath: "/b", AllowedLanguages: []string{"python"}},
+		}, true},
+		{"nested container paths", []SharedMountConfig{
+			{Name: "a", HostPath: dir, ContainerPath: "/data", AllowedLanguages: []string{"python"}},
+			{Name: "b", HostPath: dir, ContainerPath: "/data/b", AllowedLanguages: []string{"python"}},
+		}, true},
+		{"two mounts", []SharedMountConfig{
+			{Name: "a", HostPath: dir, ContainerPath: "/data/a", AllowedLanguages: []string{"python"}},
+			{Name: "b", HostPath: dir, ContainerPath: "/data/b", AllowedLanguages: []string{"node"}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Sandbox.SharedMounts = tt.mounts
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_ClaudeCaches(t *testing.T) {
+	npm := CacheVolumeConfig{Name: "npm", ContainerPath: "/home/node/.npm"}
+	pip := CacheVolumeConfig{Name: "pip", ContainerPath: "/home/node/.cache/pip"}
+	tests := []struct {
+		name    string
+		volumes []CacheVolumeConfig
+		modify  func(*ClaudeCachesConfig)
+		wantErr bool
+	}{
+		{"unset", nil, nil, false},
+		{"npm and pip", []CacheVolumeConfig{npm, pip}, nil, false},
+		{"uppercase name", []CacheVolumeConfig{{Name: "NPM", ContainerPath: "/home/node/.npm"}}, nil, true},
+		{"duplicate name", []CacheVolumeConfig{npm, {Name: "npm", ContainerPath: "/home/node/.yarn"}}, nil, true},
+		{"outside home", []CacheVolumeConfig{{Name: "npm", ContainerPath: "/usr/lib/node_modules"}}, nil, true},
+		{"unclean path", []CacheVolumeConfig{{Name: "npm", ContainerPath: "/home/node/../../etc"}}, nil, true},
+		{"claude state", []CacheVolumeConfig{{Name: "state", ContainerPath: "/home/node/.claude"}}, nil, true},
+		{"overlapping paths", []CacheVolumeConfig{{Name: "all", ContainerPath: "/home/node/.cache"}, pip}, nil, true},
+		{"negative max_bytes", []CacheVolumeConfig{npm}, func(c *ClaudeCachesConfig) { c.MaxBytes = -1 }, true},
+		{"no check interval", []CacheVolumeConfig{npm}, func(c *ClaudeCachesConfig) { c.CheckInterval = 0 }, true},
+		{"uncapped", []CacheVolumeConfig{npm}, func(c *ClaudeCachesConfig) { c.MaxBytes, c.CheckInterval = 0, 0 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Sandbox.ClaudeCaches.Volumes = tt.volumes
+			if tt.modify != nil {
+				tt.modify(&cfg.Sandbox.ClaudeCaches)
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_ClaudeSecurity(t *testing.T) {
+	tests := []struct {
+		name    string
+		claude  ClaudeSecurityConfig
+		wantErr bool
+	}{
+		{"unset", ClaudeSecurityConfig{}, false},
+		{"defaults within ceilings", ClaudeSecurityConfig{AllowedModels: []string{"a", "b"}, DefaultModel: "b", MaxTurns: 20, DefaultMaxTurns: 10}, false},
+		{"default model without allow list", ClaudeSecurityConfig{DefaultModel: "a"}, false},
+		{"default model not allowed", ClaudeSecurityConfig{AllowedModels: []string{"a"}, DefaultModel: "b"}, true},
+		{"default turns over ceiling", ClaudeSecurityConfig{MaxTurns: 5, DefaultMaxTurns: 6}, true},
+		{"negative ceiling", ClaudeSecurityConfig{MaxTurns: -1}, true},
+		{"prompt limits", ClaudeSecurityConfig{MaxPromptBytes: 4096, PromptBlockSeverity: "high"}, false},
+		{"prompt screening records only", ClaudeSecurityConfig{PromptBlockSeverity: "none"}, false},
+		{"prompt cap over the code limit", ClaudeSecurityConfig{MaxPromptBytes: 2 << 20}, true},
+		{"negative prompt cap", ClaudeSecurityConfig{MaxPromptBytes: -1}, true},
+		{"unknown block severity", ClaudeSecurityConfig{PromptBlockSeverity: "severe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Security.Claude = tt.claude
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_ClaudeWorkdirGit(t *testing.T) {
+	for mode, wantErr := range map[string]bool{"": false, "ignore": false, "warn": false, "require_clean": false, "strict": true} {
+		cfg := DefaultConfig()
+		cfg.Security.ClaudeWorkdirGit = mode
+		if err := cfg.Validate(); (err != nil) != wantErr {
+			t.Errorf("claude_workdir_git %q: Validate() error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestValidate_SLO(t *testing.T) {
+	tests := []struct {
+		name    string
+		slo     SLOConfig
+		wantErr bool
+	}{
+		{"default", DefaultConfig().Metrics.SLO, false},
+		{"objectives", SLOConfig{Window: time.Hour, Bucket: time.Minute, Objectives: map[string]SLOObjective{"python": {P95: 2 * time.Second, SuccessRate: 0.99}, "claude": {SuccessRate: 0.95}}}, false},
+		{"no bucket", SLOConfig{Window: time.Hour}, true},
+		{"window shorter than a bucket", SLOConfig{Window: time.Second, Bucket: time.Minute}, true},
+		{"partial bucket", SLOConfig{Window: 90 * time.Second, Bucket: time.Minute}, true},
+		{"too many buckets", SLOConfig{Window: 30 * 24 * time.Hour, Bucket: time.Minute}, true},
+		{"no error budget", SLOConfig{Window: time.Hour, Bucket: time.Minute, Objectives: map[string]SLOObjective{"python": {SuccessRate: 1}}}, true},
+		{"negative p95", SLOConfig{Window: time.Hour, Bucket: time.Minute, Objectives: map[string]SLOObjective{"python": {P95: -time.Second}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Metrics.SLO = tt.slo
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_CostBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  CostBudgetConfig
+		wantErr bool
+	}{
+		{"default", DefaultConfig().Security.CostBudget, false},
+		{"budget", CostBudgetConfig{Hourly: 500, LanguageCosts: map[string]float64{"claude": 50, "bash": 0.5}}, false},
+		{"free language", CostBudgetConfig{Hourly: 500, LanguageCosts: map[string]float64{"bash": 0}}, false},
+		{"negative budget", CostBudgetConfig{Hourly: -1}, true},
+		{"negative cost", CostBudgetConfig{Hourly: 500, LanguageCosts: map[string]float64{"python": -2}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Security.CostBudget = tt.budget
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_ClaudeTokens(t *testing.T) {
+	cred := ClaudeCredential{TokenEnv: "TEAM_CLAUDE_TOKEN", Keys: []string{"team-key"}}
+	tests := []struct {
+		name      string
+		tokens    ClaudeTokensConfig
+		proxyPort int
+		wantErr   bool
+	}{
+		{"default", DefaultConfig().Security.ClaudeTokens, 0, false},
+		{"disabled credentials unchecked", ClaudeTokensConfig{Credentials: map[string]ClaudeCredential{"team": {}}}, 0, false},
+		{"enabled", ClaudeTokensConfig{Enabled: true}, 8081, false},
+		{"credential", ClaudeTokensConfig{Enabled: true, Credentials: map[string]ClaudeCredential{"team": cred}}, 8081, false},
+		{"no proxy", ClaudeTokensConfig{Enabled: true}, 0, true},
+		{"bad name", ClaudeTokensConfig{Enabled: true, Credentials: map[string]ClaudeCredential{"Team A": cred}}, 8081, true},
+		{"no token_env", ClaudeTokensConfig{Enabled: true, Credentials: map[string]ClaudeCredential{"team": {Keys: cred.Keys}}}, 8081, true},
+		{"no keys", ClaudeTokensConfig{Enabled: true, Credentials: map[string]ClaudeCredential{"team": {TokenEnv: cred.TokenEnv}}}, 8081, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Security.ClaudeTokens = tt.tokens
+			cfg.AuthProxy.Port = tt.proxyPort
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_WorkdirLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    WorkdirLockConfig
+		wantErr bool
+	}{
+		{"default", DefaultConfig().Sandbox.WorkdirLock, false},
+		{"unset mode", WorkdirLockConfig{}, false},
+		{"wait", WorkdirLockConfig{Mode: "wait", Wait: 30 * time.Second}, false},
+		{"wait without duration", WorkdirLockConfig{Mode: "wait"}, true},
+		{"unknown mode", WorkdirLockConfig{Mode: "steal"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Sandbox.WorkdirLock = tt.lock
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_CNI(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*CNIConfig)
+		wantErr string
+	}{
+		{"defaults", func(c *CNIConfig) {}, ""},
+		{"disabled ignores settings", func(c *CNIConfig) { c.Enabled, c.Subnet = false, "nonsense" }, ""},
+		{"relative plugin dir", func(c *CNIConfig) { c.PluginDir = "cni/bin" }, "sandbox.cni.plugin_dir"},
+		{"network name", func(c *CNIConfig) { c.Network = "Sandbox Net" }, "sandbox.cni.network"},
+		{"bridge too long", func(c *CNIConfig) { c.Bridge = "sandbox-bridge-0" }, "sandbox.cni.bridge"},
+		{"bridge with slash", func(c *CNIConfig) { c.Bridge = "br/0" }, "sandbox.cni.bridge"},
+		{"ipv6 subnet", func(c *CNIConfig) { c.Subnet = "fd00::/64" }, "must be an IPv4 CIDR"},
+		{"not a cidr", func(c *CNIConfig) { c.Subnet = "10.89.0.0" }, "must be an IPv4 CIDR"},
+		{"too narrow", func(c *CNIConfig) { c.Subnet = "10.89.0.0/31" }, "leaves no room"},
+		{"host bits", func(c *CNIConfig) { c.Subnet = "10.89.0.1/22" }, "did you mean 10.89.0.0/22?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Sandbox.CNI.Enabled = true
+			tt.modify(&cfg.Sandbox.CNI)
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_DiskPressure(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*DiskPressureConfig)
+		wantErr string
+	}{
+		{"defaults", func(c *DiskPressureConfig) {}, ""},
+		{"disabled ignores settings", func(c *DiskPressureConfig) { c.Enabled, c.GCFreePercent = false, 50 }, ""},
+		{"equal thresholds", func(c *DiskPressureConfig) { c.GCFreePercent, c.SuspendPullsFreePercent = 10, 10 }, ""},
+		{"out of order", func(c *DiskPressureConfig) { c.GCFreePercent = 12 }, "need gc_free_percent <= suspend_pulls_free_percent"},
+		{"target not above warn", func(c *DiskPressureConfig) { c.TargetFreePercent = 15 }, "< target_free_percent"},
+		{"over 100", func(c *DiskPressureConfig) { c.TargetFreePercent = 120 }, "between 0 and 100"},
+		{"no interval", func(c *DiskPressureConfig) { c.CheckInterval = 0 }, "check_interval"},
+		{"relative data root", func(c *DiskPressureConfig) { c.DataRoot = "var/lib/docker" }, "sandbox.disk_pressure.data_root"},
+		{"unknown candidates", func(c *DiskPressureConfig) { c.GC.Candidates = "all" }, "sandbox.disk_pressure.gc.candidates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.DiskPressure)
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_MaxUlimits(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	cfg.Sandbox.MaxUlimits.Core = 1 << 20
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("core dumps allowed: %v", err)
+	}
+	cfg.Sandbox.MaxUlimits.NoFile = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "sandbox.max_ulimits") {
+		t.Errorf("nofile 0: Validate() = %v", err)
+	}
+}
+
+func TestValidate_WorkdirSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*WorkdirSizeConfig)
+		wantErr string
+	}{
+		{"defaults", func(c *WorkdirSizeConfig) {}, ""},
+		{"warn, no limits", func(c *WorkdirSizeConfig) { c.Mode, c.MaxBytes, c.MaxFiles = "warn", 0, 0 }, ""},
+		{"unknown mode", func(c *WorkdirSizeConfig) { c.Mode = "truncate" }, "sandbox.workdir_size.mode"},
+		{"negative max", func(c *WorkdirSizeConfig) { c.MaxFiles = -1 }, "max_files must be >= 0"},
+		{"no walk", func(c *WorkdirSizeConfig) { c.MaxWalk = 0 }, "max_walk"},
+		{"bad pattern", func(c *WorkdirSizeConfig) { c.Exclude = []string{"build/["} }, "sandbox.workdir_size.exclude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.WorkdirSize)
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_NetworkPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  NetworkPolicyConfig
+		wantErr string
+		warning string
+	}{
+		{"default", DefaultConfig().Security.NetworkPolicy, "", ""},
+		{"deny, refusing claude", NetworkPolicyConfig{Mode: "deny", Claude: "refuse"}, "", ""},
+		{"allowlist", NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{"python"}}, "", ""},
+		{"unknown mode", NetworkPolicyConfig{Mode: "block"}, "security.network_policy.mode", ""},
+		{"unknown claude", NetworkPolicyConfig{Claude: "allow"}, "security.network_policy.claude", ""},
+		{"empty language", NetworkPolicyConfig{Mode: "allowlist_languages", Languages: []string{""}}, "languages has an empty entry", ""},
+		{"empty allowlist", NetworkPolicyConfig{Mode: "allowlist_languages"}, "", "allowlist_languages denies every language"},
+		{"languages without the allowlist", NetworkPolicyConfig{Mode: "deny", Languages: []string{"python"}}, "", "languages has no effect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Security.NetworkPolicy = tt.policy
+			r := cfg.Check()
+			err := r.Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+			if warnings := strings.Join(r.Warnings, "\n"); tt.warning == "" && strings.Contains(warnings, "network_policy") || !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", r.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestCheck_FairShare(t *testing.T) {
+	tier := func(weight int, keys ...string) FairShareWeight { return FairShareWeight{Keys: keys, Weight: weight} }
+	tests := []struct {
+		name    string
+		modify  func(*FairShareConfig)
+		wantErr string
+	}{
+		{"off", func(c *FairShareConfig) {}, ""},
+		{"on", func(c *FairShareConfig) { c.Enabled = true }, ""},
+		{"weights", func(c *FairShareConfig) {
+			c.Enabled, c.Weights = true, []FairShareWeight{tier(4, "a", "b"), tier(2, "c")}
+		}, ""},
+		{"no max wait", func(c *FairShareConfig) { c.Enabled, c.MaxWait = true, 0 }, "sandbox.fair_share.max_wait"},
+		{"zero weight", func(c *FairShareConfig) { c.Enabled, c.Weights = true, []FairShareWeight{tier(0, "a")} }, "weights[0].weight must be >= 1"},
+		{"no keys", func(c *FairShareConfig) { c.Enabled, c.Weights = true, []FairShareWeight{tier(2)} }, "weights[0].keys must not be empty"},
+		{"key in two tiers", func(c *FairShareConfig) {
+			c.Enabled, c.Weights = true, []FairShareWeight{tier(4, "a"), tier(2, "b", "a")}
+		}, "weights[1]: a key is also in weights[0]"},
+		{"unchecked when off", func(c *FairShareConfig) { c.MaxWait, c.Weights = 0, []FairShareWeight{tier(0)} }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.FairShare)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.Sandbox.FairShare.Enabled, cfg.Sandbox.FairShare.MaxWait = true, 2*time.Second
+	if warnings := strings.Join(cfg.Check().Warnings, "\n"); !strings.Contains(warnings, "sandbox.fair_share.max_wait is 2s") {
+		t.Errorf("max_wait past the slot wait: warnings %q", warnings)
+	}
+}
+
+func TestCheck_Dedup(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*DedupConfig)
+		wantErr string
+		warning string
+	}{
+		{"off", func(c *DedupConfig) {}, "", ""},
+		{"on", func(c *DedupConfig) { c.Window = time.Second }, "", ""},
+		{"negative window", func(c *DedupConfig) { c.Window = -time.Second }, "sandbox.dedup.window", ""},
+		{"no waiters", func(c *DedupConfig) { c.Window, c.MaxWaiters = time.Second, 0 }, "sandbox.dedup.max_waiters", ""},
+		{"keys without a window", func(c *DedupConfig) { c.Keys = []string{"key"} }, "", "sandbox.dedup.keys has no effect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.Dedup)
+			r := cfg.Check()
+			err := r.Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+			if warnings := strings.Join(r.Warnings, "\n"); tt.warning == "" && strings.Contains(warnings, "sandbox.dedup") || !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", r.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestCheck_WriteTimeoutCoversPhases(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		warning string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"write_timeout only covers max_timeout", func(c *Config) { c.Server.WriteTimeout = 70 * time.Second }, "server.write_timeout (1m10s) is shorter than sandbox.max_timeout + setup_timeout + cleanup_grace (2m30s)"},
+		{"slow setup allowed", func(c *Config) { c.Sandbox.SetupTimeout = 5 * time.Minute }, "server.write_timeout (3m0s)"},
+		{"claude_write_timeout", func(c *Config) { c.Server.ClaudeWriteTimeout = 30 * time.Minute }, "server.claude_write_timeout (30m0s)"},
+		{"no write deadlines", func(c *Config) { c.Server.WriteTimeout, c.Server.ClaudeWriteTimeout = 0, 0 }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			r := cfg.Check()
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if warnings := strings.Join(r.Warnings, "\n"); tt.warning == "" && strings.Contains(warnings, "write_timeout") || !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", r.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestCheck_Bundles(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{"off", func(c *Config) { c.Sandbox.Bundles.Dir = "relative" }, ""},
+		{"disk", func(c *Config) {
+			c.Sandbox.Bundles.Store, c.Sandbox.Bundles.Dir = "disk", "/var/lib/sandbox/bundles"
+			c.Sandbox.Workspaces.Root = "/var/lib/sandbox/workspaces"
+		}, ""},
+		{"relative dir", func(c *Config) {
+			c.Sandbox.Bundles.Store, c.Sandbox.Bundles.Dir = "disk", "bundles"
+			c.Sandbox.Workspaces.Root = "/var/lib/sandbox/workspaces"
+		}, "sandbox.bundles.dir"},
+		{"database without a dsn", func(c *Config) {
+			c.Sandbox.Bundles.Store = "database"
+			c.Sandbox.Workspaces.Root = "/var/lib/sandbox/workspaces"
+		}, "database.dsn"},
+		{"no workspaces", func(c *Config) {
+			c.Sandbox.Bundles.Store, c.Sandbox.Bundles.Dir = "disk", "/var/lib/sandbox/bundles"
+		}, "sandbox.workspaces.root"},
+		{"bad store", func(c *Config) {
+			c.Sandbox.Bundles.Store = "s3"
+			c.Sandbox.Workspaces.Root = "/var/lib/sandbox/workspaces"
+		}, "sandbox.bundles.store"},
+		{"no cap", func(c *Config) {
+			c.Sandbox.Bundles.Store, c.Sandbox.Bundles.Dir, c.Sandbox.Bundles.MaxFiles = "disk", "/var/lib/sandbox/bundles", 0
+			c.Sandbox.Workspaces.Root = "/var/lib/sandbox/workspaces"
+		}, "max_files must be > 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_CPUAbuse(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*CPUAbuseConfig)
+		wantErr string
+	}{
+		{"off", func(c *CPUAbuseConfig) { c.Mode = "bogus" }, ""},
+		{"defaults", func(c *CPUAbuseConfig) { c.Enabled = true }, ""},
+		{"aggressive", func(c *CPUAbuseConfig) { c.Enabled, c.Mode = true, "aggressive" }, ""},
+		{"bad mode", func(c *CPUAbuseConfig) { c.Enabled, c.Mode = true, "strict" }, "sandbox.cpu_abuse.mode"},
+		{"fraction over 1", func(c *CPUAbuseConfig) { c.Enabled, c.UsageFraction = true, 1.5 }, "sandbox.cpu_abuse.usage_fraction"},
+		{"zero fraction", func(c *CPUAbuseConfig) { c.Enabled, c.UsageFraction = true, 0 }, "sandbox.cpu_abuse.usage_fraction"},
+		{"no sustain", func(c *CPUAbuseConfig) { c.Enabled, c.Sustain = true, 0 }, "sustain and poll_interval must be > 0"},
+		{"poll past sustain", func(c *CPUAbuseConfig) { c.Enabled, c.PollInterval = true, time.Minute }, "sandbox.cpu_abuse.poll_interval must be <= sustain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.CPUAbuse)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_EgressAudit(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*EgressAuditConfig)
+		wantErr string
+	}{
+		{"off", func(c *EgressAuditConfig) { c.Conntrack = "" }, ""},
+		{"defaults", func(c *EgressAuditConfig) { c.Enabled = true }, ""},
+		{"relative table", func(c *EgressAuditConfig) { c.Enabled, c.Conntrack = true, "nf_conntrack" }, "sandbox.egress_audit.conntrack"},
+		{"no cap", func(c *EgressAuditConfig) { c.Enabled, c.MaxConnections = true, 0 }, "poll_interval and max_connections must be > 0"},
+		{"bad suspicious range", func(c *EgressAuditConfig) {
+			c.Enabled, c.SuspiciousCIDRs = true, []string{"169.254.169.254"}
+		}, "sandbox.egress_audit.suspicious_cidrs"},
+		{"bad private range", func(c *EgressAuditConfig) {
+			c.Enabled, c.PrivateCIDRs = true, []string{"10.0.0.0/33"}
+		}, "sandbox.egress_audit.private_cidrs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Sandbox.EgressAudit)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_Stats(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*StatsConfig)
+		wantErr string
+	}{
+		{"defaults", func(c *StatsConfig) {}, ""},
+		{"uncached, slots only", func(c *StatsConfig) { c.CacheTTL, c.Weights = 0, SaturationWeights{Slots: 1} }, ""},
+		{"long cache", func(c *StatsConfig) { c.CacheTTL = time.Hour }, "metrics.stats.cache_ttl"},
+		{"negative weight", func(c *StatsConfig) { c.Weights.Queue = -0.2 }, "must all be >= 0"},
+		{"no weights", func(c *StatsConfig) { c.Weights = SaturationWeights{} }, "all 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Metrics.Stats)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_Upgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*UpgradeConfig)
+		wantErr string
+	}{
+		{"defaults", func(c *UpgradeConfig) {}, ""},
+		{"bounded drain", func(c *UpgradeConfig) { c.DrainTimeout = 10 * time.Minute }, ""},
+		{"no ready timeout", func(c *UpgradeConfig) { c.ReadyTimeout = 0 }, "server.upgrade.ready_timeout"},
+		{"negative drain", func(c *UpgradeConfig) { c.DrainTimeout = -time.Second }, "drain_timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg.Server.Upgrade)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_AuthProxyBoundary(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*AuthProxyConfig)
+		wantErr string
+	}{
+		{"defaults", func(p *AuthProxyConfig) {}, ""},
+		{"everything on", func(p *AuthProxyConfig) {
+			p.Bind, p.TLS, p.PerExecutionSecrets = "docker_gateway", true, true
+			p.ACL = AuthProxyACLConfig{Enabled: true, AllowedCIDRs: []string{"127.0.0.1/32", "fd00::/8"}}
+		}, ""},
+		{"bind IP", func(p *AuthProxyConfig) { p.Bind = "172.17.0.1" }, ""},
+		{"bind host name", func(p *AuthProxyConfig) { p.Bind = "localhost" }, "auth_proxy.bind"},
+		{"bind everywhere", func(p *AuthProxyConfig) { p.Bind = "0.0.0.0" }, "every interface"},
+		{"bind everywhere, IPv6", func(p *AuthProxyConfig) { p.Bind = "::" }, "every interface"},
+		{"bad CIDR", func(p *AuthProxyConfig) { p.ACL.AllowedCIDRs = []string{"172.17.0.1"} }, "allowed_cidrs"},
+		{"negative refresh", func(p *AuthProxyConfig) { p.ACL.Refresh = -time.Second }, "acl.refresh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AuthProxy.Port = 8081
+			tt.modify(&cfg.AuthProxy)
+			err := cfg.Check().Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.AuthProxy.TLS = true
+	if r := cfg.Check(); !slices.ContainsFunc(r.Warnings, func(w string) bool { return strings.Contains(w, "no effect") }) {
+		t.Errorf("no warning for tls with the proxy disabled: %v", r.Warnings)
+	}
+}
+
+func TestValidate_AuditSinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinks   []string
+		file    FileSinkConfig
+		wantErr string
+	}{
+		{"default", []string{"postgres"}, FileSinkConfig{}, ""},
+		{"none", nil, FileSinkConfig{}, ""},
+		{"both", []string{"postgres", "file"}, FileSinkConfig{Path: "/var/log/sandbox/audit.jsonl", Fsync: "always"}, ""},
+		{"unknown sink", []string{"kafka"}, FileSinkConfig{}, "unknown sink"},
+		{"listed twice", []string{"file", "file"}, FileSinkConfig{Path: "/a.jsonl", Fsync: "batch"}, "listed twice"},
+		{"relative path", []string{"file"}, FileSinkConfig{Path: "audit.jsonl", Fsync: "batch"}, "absolute path"},
+		{"bad fsync", []string{"file"}, FileSinkConfig{Path: "/a.jsonl", Fsync: "sometimes"}, "fsync"},
+		{"negative size", []string{"file"}, FileSinkConfig{Path: "/a.jsonl", Fsync: "never", MaxBytes: -1}, "max_bytes"},
+		{"serve without the sink", []string{"postgres"}, FileSinkConfig{ServeExecutions: true}, "serve_executions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Database.Sinks, cfg.Database.FileSink = tt.sinks, tt.file
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_ClientAuth(t *testing.T) {
+	files := touch(t, "server.pem", "server-key.pem", "ca.pem")
+	tlsOn := TLSConfig{Enabled: true, CertFile: files[0], KeyFile: files[1]}
+	withCA := func(mode string) TLSConfig {
+		t := tlsOn
+		t.ClientAuthMode, t.ClientCAFile = mode, files[2]
+		return t
+	}
+	tests := []struct {
+		name       string
+		tls        TLSConfig
+		precedence string
+		healthPort int
+		wantErr    bool
+	}{
+		{"default", DefaultConfig().TLS, "", 0, false},
+		{"require and verify", withCA("require_and_verify"), "", 0, false},
+		{"request", withCA("request"), "api_key", 0, false},
+		{"explicit none", TLSConfig{ClientAuthMode: "none"}, "client_cert", 0, false},
+		{"unknown mode", withCA("optional"), "", 0, true},
+		{"verification without CA", TLSConfig{Enabled: true, CertFile: "s", KeyFile: "k", ClientAuthMode: "request"}, "", 0, true},
+		{"verification without TLS", TLSConfig{ClientAuthMode: "require_and_verify", ClientCAFile: "ca.pem"}, "", 0, true},
+		{"CA without mode", TLSConfig{Enabled: true, CertFile: "s", KeyFile: "k", ClientCAFile: "ca.pem"}, "", 0, true},
+		{"unknown precedence", tlsOn, "header", 0, true},
+		{"health port", withCA("require_and_verify"), "", 8081, false},
+		{"health port clashes", tlsOn, "", 8080, true},
+		{"health port out of range", tlsOn, "", 70000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.TLS = tt.tls
+			cfg.Security.AuthPrecedence = tt.precedence
+			cfg.Server.PlaintextHealthPort = tt.healthPort
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Concurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		split   map[string]int
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"sums to max_concurrent", map[string]int{"python": 400, "node": 300, "bash": 200, "claude": 10, "default": 90}, false},
+		{"default only", map[string]int{"default": 1000}, false},
+		{"sum too low", map[string]int{"python": 400, "default": 100}, true},
+		{"sum too high", map[string]int{"python": 1000, "default": 1}, true},
+		{"no default pool", map[string]int{"python": 600, "node": 400}, true},
+		{"zero slots", map[string]int{"python": 0, "default": 1000}, true},
+		{"negative slots", map[string]int{"python": -10, "default": 1010}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig() // max_concurrent: 1000
+			cfg.Sandbox.Concurrency = tt.split
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestLoad(t *testing.T) {
 	yamlContent := `
 server:
@@ -136,6 +986,147 @@ func TestLoad_FileNotFound(t *testing.T) {
 	}
 }
 
+func TestLoad_DefaultEnv(t *testing.T) {
+	load := func(env string) (*Config, error) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("sandbox:\n  default_env:\n"+env), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load("    global: {TZ: UTC}\n    languages:\n      python: {PYTHONDONTWRITEBYTECODE: \"1\"}\n")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Sandbox.DefaultEnv.Global["TZ"] != "UTC" || cfg.Sandbox.DefaultEnv.Languages["python"]["PYTHONDONTWRITEBYTECODE"] != "1" {
+		t.Errorf("DefaultEnv = %+v", cfg.Sandbox.DefaultEnv)
+	}
+
+	tests := []struct {
+		env, wantErr string
+	}{
+		{"    global: {PATH: /opt/bin}\n", `sandbox.default_env.global: env var "PATH" is blocked`},
+		{"    languages:\n      node: {NODE_OPTIONS: --inspect}\n", `sandbox.default_env.languages.node: env var "NODE_OPTIONS" is blocked`},
+		{"    languages:\n      python: {ANTHROPIC_BASE_URL: x}\n", `env var "ANTHROPIC_BASE_URL" is blocked`},
+		{"    global: {\"BAD-KEY\": x}\n", `sandbox.default_env.global: env var key "BAD-KEY" contains invalid characters`},
+	}
+	for _, tt := range tests {
+		if _, err := load(tt.env); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: Load() error = %v, want %q", tt.env, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidate_DatabaseRequired(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Database.Required = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "database.required is set but there is no audit log") {
+		t.Errorf("required without a sink: Validate() = %v", err)
+	}
+	cfg.Database.DSN = "postgres://sandbox@db/sandbox?sslmode=require"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("required with a DSN: Validate() = %v", err)
+	}
+}
+
+func TestLoad_RuntimeLimits(t *testing.T) {
+	load := func(limits string) (*Config, error) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("sandbox:\n  runtime_limits:\n"+limits), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load("    node: {memory_mb: 1024}\n")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Sandbox.RuntimeLimits["node"]; got != (DefaultLimits{MemoryMB: 1024}) {
+		t.Errorf("RuntimeLimits[node] = %+v", got)
+	}
+	if _, err := load("    bash: {pids_limit: -1}\n"); err == nil || !strings.Contains(err.Error(), "sandbox.runtime_limits.bash: limits must be >= 0") {
+		t.Errorf("negative limit: Load() error = %v", err)
+	}
+}
+
+func TestLoad_Jobs(t *testing.T) {
+	dir := t.TempDir()
+	codeFile := filepath.Join(dir, "vacuum.py")
+	if err := os.WriteFile(codeFile, []byte("print(1)"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	load := func(codeFile string) (*Config, error) {
+		path := filepath.Join(dir, "config.yaml")
+		yamlContent := `
+jobs:
+  - name: vacuum
+    language: python
+    code_file: ` + codeFile + `
+    timeout: 30m
+    limits:
+      memory_mb: 32768
+    schedule: 6h
+`
+		if err := os.WriteFile(path, []byte(yamlContent), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(codeFile)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := JobConfig{Name: "vacuum", Language: "python", CodeFile: codeFile, Timeout: 30 * time.Minute,
+		Limits: DefaultLimits{MemoryMB: 32768}, Schedule: 6 * time.Hour}
+	if len(cfg.Jobs) != 1 || cfg.Jobs[0] != want {
+		t.Errorf("Jobs = %+v, want [%+v]", cfg.Jobs, want)
+	}
+
+	_, err = load(filepath.Join(dir, "missing.py"))
+	if err == nil || !strings.Contains(err.Error(), "jobs[vacuum].code_file") {
+		t.Errorf("missing code_file: Load() error = %v", err)
+	}
+}
+
+func TestValidate_Jobs(t *testing.T) {
+	codeFile := filepath.Join(t.TempDir(), "job.sh")
+	if err := os.WriteFile(codeFile, []byte("true"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	job := func(modify func(*JobConfig)) []JobConfig {
+		j := JobConfig{Name: "cleanup", Language: "bash", CodeFile: codeFile}
+		modify(&j)
+		return []JobConfig{j}
+	}
+
+	tests := []struct {
+		name    string
+		jobs    []JobConfig
+		wantErr bool
+	}{
+		{"valid", job(func(j *JobConfig) {}), false},
+		{"uppercase name", job(func(j *JobConfig) { j.Name = "Cleanup" }), true},
+		{"no language", job(func(j *JobConfig) { j.Language = "" }), true},
+		{"claude", job(func(j *JobConfig) { j.Language = "claude" }), true},
+		{"relative code file", job(func(j *JobConfig) { j.CodeFile = "job.sh" }), true},
+		{"timeout past 24h", job(func(j *JobConfig) { j.Timeout = 25 * time.Hour }), true},
+		{"schedule under a minute", job(func(j *JobConfig) { j.Schedule = time.Second }), true},
+		{"duplicate name", append(job(func(j *JobConfig) {}), job(func(j *JobConfig) {})...), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Jobs = tt.jobs
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestAddress(t *testing.T) {
 	cfg := DefaultConfig()
 	want := "0.0.0.0:8080"
@@ -150,3 +1141,24 @@ func TestAddress(t *testing.T) {
 		t.Errorf("Address() = %q, want %q", got, want)
 	}
 }
+
+func TestValidate_BasePath(t *testing.T) {
+	for path, wantErr := range map[string]bool{
+		"":                false,
+		"/sandbox":        false,
+		"/api/sandbox-v1": false,
+		"/sandbox/":       true,
+		"sandbox":         true,
+		"/":               true,
+		"/a//b":           true,
+		"/a/../b":         true,
+		"/a?b":            true,
+		"/a b":            true,
+	} {
+		cfg := DefaultConfig()
+		cfg.Server.BasePath = path
+		if err := cfg.Validate(); (err != nil) != wantErr {
+			t.Errorf("base_path %q: Validate() error = %v, wantErr %v", path, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// envBlocklist contains env var keys that must never be passed into a container.
+var envBlocklist = map[string]bool{
+	"LD_PRELOAD":      true,
+	"LD_LIBRARY_PATH": true,
+	"HTTP_PROXY":      true,
+	"HTTPS_PROXY":     true,
+	"NODE_OPTIONS":    true,
+	"PYTHONPATH":      true,
+	"PATH":            true,
+	"HOME":            true,
+	"USER":            true,
+}
+
+// envBlockedPrefixes are env var key prefixes that must never be passed into
+// a container: a caller's ANTHROPIC_BASE_URL would come after the server's
+// and send claude's token wherever it points.
+var envBlockedPrefixes = []string{"ANTHROPIC_", "CLAUDE_"}
+
+// CheckEnvKey reports whether key may name an environment variable set in
+// a container, by a request's permissions.environment or by
+// sandbox.default_env alike: letters, digits and underscores, and not one
+// the blocklist holds back.
+func CheckEnvKey(key string) error {
+	if key == "" {
+		return errors.New("env var key is empty")
+	}
+	for _, c := range key {
+		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_') {
+			return fmt.Errorf("env var key %q contains invalid characters", key)
+		}
+	}
+	upper := strings.ToUpper(key)
+	if envBlocklist[upper] || slices.ContainsFunc(envBlockedPrefixes, func(p string) bool { return strings.HasPrefix(upper, p) }) {
+		return fmt.Errorf("env var %q is blocked for security reasons", key)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Report is what Check found wrong with a config. Errors keep the server
+// from starting. Warnings are settings that start fine but probably don't do
+// what was meant; the server logs them and lists them in GET /health. Each
+// entry names the setting it is about and, where there is one, the fix.
+type Report struct {
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+
+	offline bool // skip checks that look at the host; see CheckOffline
+}
+
+func (r *Report) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *Report) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Warn adds warnings found outside this package, such as by the sandbox
+// backend once it knows what it runs on.
+func (r *Report) Warn(warnings ...string) {
+	r.Warnings = append(r.Warnings, warnings...)
+}
+
+// Err returns the report's errors as one *ValidationError, or nil when it
+// has none.
+func (r *Report) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Report: r}
+}
+
+// String lists every problem, errors first, one per line.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, %s", plural(len(r.Errors), "error"), plural(len(r.Warnings), "warning"))
+	for _, e := range r.Errors {
+		b.WriteString("\n  error:   " + e)
+	}
+	for _, w := range r.Warnings {
+		b.WriteString("\n  warning: " + w)
+	}
+	return b.String()
+}
+
+// Log writes the whole report as one log entry: an error with errors, a
+// warning with only warnings, and an info line when the config is clean.
+func (r *Report) Log() {
+	switch {
+	case len(r.Errors) > 0:
+		log.Error().Msg("config report: " + r.String())
+	case len(r.Warnings) > 0:
+		log.Warn().Msg("config report: " + r.String())
+	default:
+		log.Info().Msg("config report: no problems found")
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// ValidationError is the error of a config that fails Check. Its Report
+// also holds the warnings.
+type ValidationError struct {
+	Report *Report
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Report.Errors) == 1 {
+		return e.Report.Errors[0]
+	}
+	return fmt.Sprintf("%d errors: %s", len(e.Report.Errors), strings.Join(e.Report.Errors, "; "))
+}
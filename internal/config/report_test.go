@@ -0,0 +1,309 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// quiet is a default config that Check has nothing to say about.
+func quiet() *Config {
+	cfg := DefaultConfig()
+	cfg.Security.AllowedKeys = []string{"key"}
+	return cfg
+}
+
+func TestCheck_Warnings(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // substring of the only warning; "" = none
+	}{
+		{"clean", func(c *Config) {}, ""},
+		{"workdir root exists", func(c *Config) { c.Sandbox.AllowedWorkdirRoots = []string{dir} }, ""},
+		{"workdir root missing", func(c *Config) {
+			c.Sandbox.AllowedWorkdirRoots = []string{dir, filepath.Join(dir, "missing")}
+		}, `sandbox.allowed_workdir_roots: "` + filepath.Join(dir, "missing") + `" is not an existing directory`},
+		{"workdir root is a file", func(c *Config) {
+			c.Sandbox.AllowedWorkdirRoots = touch(t, "file")
+		}, "is not an existing directory"},
+		{"unencrypted database", func(c *Config) { c.Database.DSN = "postgres://db/sandbox?sslmode=disable" }, "database.dsn has sslmode=disable"},
+		{"tracing nowhere", func(c *Config) { c.Tracing.Enabled = true }, "tracing.endpoint is empty, so spans go nowhere"},
+		{"no telemetry", func(c *Config) {
+			c.Tracing.Enabled = true
+			c.Metrics.Enabled = false
+		}, "metrics are disabled, so the server exports no telemetry"},
+		{"tracing with endpoint", func(c *Config) {
+			c.Tracing.Enabled = true
+			c.Tracing.Endpoint = "http://collector:4318"
+			c.Metrics.Enabled = false
+		}, ""},
+		{"burst below rate", func(c *Config) { c.Security.RateLimitBurst = 50 }, "security.rate_limit_burst (50) is below rate_limit_rps (100)"},
+		{"zero burst", func(c *Config) { c.Security.RateLimitBurst = 0 }, "a burst of 0 refuses everything"},
+		{"burst equals rate", func(c *Config) { c.Security.RateLimitBurst = 100 }, ""},
+		{"no rate limit", func(c *Config) {
+			c.Security.RateLimitRPS = 0
+			c.Security.RateLimitBurst = 0
+		}, ""},
+		{"no keys", func(c *Config) { c.Security.AllowedKeys = nil }, "all requests will be rejected"},
+		{"no keys, unauthenticated", func(c *Config) {
+			c.Security.AllowedKeys = nil
+			c.Security.AllowUnauthenticated = true
+		}, ""},
+		{"store code without a database", func(c *Config) {
+			c.Database.DSN = ""
+			c.Database.StoreCode = true
+		}, "database.store_code is set but database.dsn is empty"},
+		{"store code for nobody", func(c *Config) {
+			c.Database.DSN = "postgres://db/sandbox"
+			c.Database.StoreCode = true
+			c.Security.PrivacyMode.Enabled = true
+		}, "database.store_code has no effect with security.privacy_mode.enabled"},
+		{"store code but some private", func(c *Config) {
+			c.Database.DSN = "postgres://db/sandbox"
+			c.Database.StoreCode = true
+			c.Security.PrivacyMode.Keys = []string{"k"}
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := quiet()
+			tt.modify(cfg)
+			r := cfg.Check()
+			if len(r.Errors) > 0 {
+				t.Fatalf("errors %q", r.Errors)
+			}
+			switch {
+			case tt.want == "" && len(r.Warnings) > 0:
+				t.Errorf("warnings %q, want none", r.Warnings)
+			case tt.want != "" && (len(r.Warnings) != 1 || !strings.Contains(r.Warnings[0], tt.want)):
+				t.Errorf("warnings %q, want one containing %q", r.Warnings, tt.want)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Validate() = %v; warnings must not fail it", err)
+			}
+		})
+	}
+}
+
+func TestCheck_TLSFiles(t *testing.T) {
+	files := touch(t, "cert.pem", "key.pem", "ca.pem")
+	unreadable := touch(t, "unreadable.pem")[0]
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name string
+		tls  TLSConfig
+		want []string // substrings of the errors, in order
+	}{
+		{"readable", TLSConfig{Enabled: true, CertFile: files[0], KeyFile: files[1]}, nil},
+		{"with CA", TLSConfig{Enabled: true, CertFile: files[0], KeyFile: files[1], ClientAuthMode: "request", ClientCAFile: files[2]}, nil},
+		{"missing key", TLSConfig{Enabled: true, CertFile: files[0], KeyFile: missing}, []string{`tls.key_file: "` + missing + `" can't be read: no such file`}},
+		{"missing CA", TLSConfig{Enabled: true, CertFile: files[0], KeyFile: files[1], ClientAuthMode: "request", ClientCAFile: missing}, []string{"tls.client_ca_file"}},
+		{"both missing", TLSConfig{Enabled: true, CertFile: missing, KeyFile: missing}, []string{"tls.cert_file", "tls.key_file"}},
+		{"disabled", TLSConfig{CertFile: missing, KeyFile: missing}, nil},
+	}
+	if os.Getuid() != 0 { // root reads anything
+		tests = append(tests, struct {
+			name string
+			tls  TLSConfig
+			want []string
+		}{"unreadable cert", TLSConfig{Enabled: true, CertFile: unreadable, KeyFile: files[1]}, []string{`tls.cert_file: "` + unreadable + `" can't be read: permission denied`}})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := quiet()
+			cfg.TLS = tt.tls
+			r := cfg.Check()
+			if len(r.Errors) != len(tt.want) {
+				t.Fatalf("errors %q, want %d", r.Errors, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if e := r.Errors[i]; !strings.Contains(e, want) {
+					t.Errorf("error %q, want it to contain %q", e, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheck_ReportsEveryErrorInFileOrder(t *testing.T) {
+	cfg := quiet()
+	// Set out of order on purpose: the report follows the file's sections.
+	cfg.AuthProxy.Port = -1
+	cfg.TLS.ClientAuthMode = "optional"
+	cfg.Security.CostBudget.LanguageCosts = map[string]float64{"python": -1, "bash": -2}
+	cfg.Security.AuthPrecedence = "header"
+	cfg.Sandbox.WorkdirLock.Mode = "steal"
+	cfg.Sandbox.MaxConcurrent = 0
+	cfg.Server.Port = 0
+	cfg.Security.RateLimitBurst = 1
+	cfg.Database.DSN = "postgres://db/x?sslmode=disable"
+
+	r := cfg.Check()
+	want := []string{
+		"server.port",
+		"server.plaintext_health_port",
+		"sandbox.max_concurrent",
+		"sandbox.workdir_lock.mode",
+		"security.auth_precedence",
+		"security.cost_budget.language_costs.bash",
+		"security.cost_budget.language_costs.python",
+		"tls.client_auth_mode",
+		"auth_proxy.port",
+	}
+	if len(r.Errors) != len(want) {
+		t.Fatalf("errors %q, want %d", r.Errors, len(want))
+	}
+	for i, field := range want {
+		if !strings.HasPrefix(r.Errors[i], field) {
+			t.Errorf("error %d = %q, want it to be about %s", i, r.Errors[i], field)
+		}
+	}
+	if len(r.Warnings) != 2 || !strings.HasPrefix(r.Warnings[0], "database.dsn") || !strings.HasPrefix(r.Warnings[1], "security.rate_limit_burst") {
+		t.Errorf("warnings %q", r.Warnings)
+	}
+
+	// The same report every time, though some of it comes from maps.
+	for range 5 {
+		if again := cfg.Check(); !slices.Equal(again.Errors, r.Errors) {
+			t.Fatalf("errors reordered: %q", again.Errors)
+		}
+	}
+
+	err := cfg.Validate()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.Report.Warnings == nil {
+		t.Fatalf("Validate() = %v, want a *ValidationError with the warnings", err)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "9 errors: server.port") || strings.Count(msg, "; ") != 8 {
+		t.Errorf("error message %q", msg)
+	}
+}
+
+func TestCheck_SectionsReportEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	cfg := quiet()
+	cfg.Sandbox.SharedMounts = []SharedMountConfig{
+		{Name: "Bad Name", HostPath: dir, ContainerPath: "/data", AllowedLanguages: []string{"python"}},
+		{Name: "ref", HostPath: "relative", ContainerPath: "/ref"},
+		{Name: "tmp", HostPath: dir, ContainerPath: "/tmp/x", AllowedLanguages: []string{"python"}},
+	}
+	cfg.Sandbox.Concurrency = map[string]int{"python": 0, "node": -1}
+
+	r := cfg.Check()
+	want := []string{
+		`sandbox.shared_mounts: name "Bad Name"`,
+		"sandbox.shared_mounts[ref].host_path",
+		"sandbox.shared_mounts[ref].allowed_languages",
+		"sandbox.shared_mounts[tmp].container_path",
+		`sandbox.concurrency: a "default" pool is required`,
+		"sandbox.concurrency.node",
+		"sandbox.concurrency.python",
+	}
+	if len(r.Errors) != len(want) {
+		t.Fatalf("errors %q, want %d", r.Errors, len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(r.Errors[i], prefix) {
+			t.Errorf("error %d = %q, want prefix %q", i, r.Errors[i], prefix)
+		}
+	}
+}
+
+func TestReport_String(t *testing.T) {
+	r := &Report{Errors: []string{"server.port must be 1-65535, got 0"}, Warnings: []string{"a", "b"}}
+	want := "1 error, 2 warnings\n  error:   server.port must be 1-65535, got 0\n  warning: a\n  warning: b"
+	if got := r.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if err := r.Err(); err == nil || err.Error() != "server.port must be 1-65535, got 0" {
+		t.Errorf("Err() = %v", err)
+	}
+	r.Warn("from the backend")
+	if len(r.Warnings) != 3 {
+		t.Errorf("Warn didn't add: %q", r.Warnings)
+	}
+	if (&Report{Warnings: []string{"a"}}).Err() != nil {
+		t.Error("warnings alone are an error")
+	}
+}
+
+func TestLoad_ReportsEveryError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "server:\n  port: 0\nsandbox:\n  max_concurrent: 0\n  default_timeout: 2m\n  max_timeout: 1m\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load(path)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Load() = %v, want a *ValidationError", err)
+	}
+	if n := len(invalid.Report.Errors); n != 4 {
+		t.Errorf("errors %q, want 4", invalid.Report.Errors)
+	}
+}
+
+func TestCheckOffline_SkipsHost(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	cfg := quiet()
+	cfg.Sandbox.AllowedWorkdirRoots = []string{missing}
+	cfg.Sandbox.SharedMounts = []SharedMountConfig{{Name: "data", HostPath: missing, ContainerPath: "/data", AllowedLanguages: []string{"python"}}}
+	cfg.TLS = TLSConfig{Enabled: true, CertFile: missing, KeyFile: missing}
+	if r := cfg.Check(); len(r.Errors) != 3 || len(r.Warnings) != 1 {
+		t.Errorf("Check() = %s, want the host's problems", r)
+	}
+	if r := cfg.CheckOffline(); len(r.Errors) != 0 || len(r.Warnings) != 0 {
+		t.Errorf("CheckOffline() = %s", r)
+	}
+	cfg.Sandbox.AllowedWorkdirRoots = []string{"relative"}
+	if r := cfg.CheckOffline(); len(r.Errors) != 1 {
+		t.Errorf("CheckOffline() = %s, want a relative root still refused", r)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(yaml string) string {
+		path := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name     string
+		yaml     string
+		errors   []string
+		warnings []string
+	}{
+		{"clean", "security:\n  allowed_keys: [key]\n", nil, nil},
+		{"unknown keys", "security:\n  allowed_keys: [key]\n  alowed_keys: [other]\nsandbox:\n  backnd: docker\n",
+			nil, []string{`line 3: unknown key "alowed_keys" is ignored`, `line 5: unknown key "backnd" is ignored`}},
+		{"invalid", "security:\n  allowed_keys: [key]\nsandbox:\n  backend: podman\n",
+			[]string{`sandbox.backend must be auto, containerd or docker, got "podman"`}, nil},
+		{"unparsable", "server:\n  port: eighty\n", []string{"parsing config: yaml: unmarshal errors:\n  line 2: cannot unmarshal !!str `eighty` into int"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := CheckFile(write(tt.yaml), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(r.Errors, tt.errors) || !slices.Equal(r.Warnings, tt.warnings) {
+				t.Errorf("CheckFile() = %q, %q; want %q, %q", r.Errors, r.Warnings, tt.errors, tt.warnings)
+			}
+		})
+	}
+	if _, err := CheckFile(filepath.Join(dir, "missing.yaml"), true); err == nil {
+		t.Error("a missing file was checked")
+	}
+}
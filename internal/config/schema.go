@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaEnums lists the values of settings that take one of a few, by
+// their path in the file; [] stands for a list's items. The validation in
+// Check has the final word: keep these in step with it. An empty value is
+// listed where Check takes it as the default.
+var schemaEnums = map[string][]string{
+	"sandbox.backend":                       {"", "auto", "containerd", "docker"},
+	"sandbox.workdir_lock.mode":             {"", "fail", "wait"},
+	"sandbox.workdir_size.mode":             {"reject", "warn"},
+	"sandbox.limit_enforcement":             {"", "strict", "permissive", "off"},
+	"sandbox.cpu_abuse.mode":                {"default", "aggressive"},
+	"sandbox.bundles.store":                 {"", "disk", "database"},
+	"sandbox.disk_pressure.gc.candidates":   {"", "dangling", "unregistered"},
+	"sandbox.compile_cache.languages[]":     {"python", "node"},
+	"database.sinks[]":                      {"postgres", "file"},
+	"database.file_sink.fsync":              {"always", "batch", "never"},
+	"security.auth_precedence":              {"", "client_cert", "api_key"},
+	"security.claude_workdir_git":           {"", "ignore", "warn", "require_clean"},
+	"security.claude.prompt_block_severity": {"", "low", "medium", "high", "critical", "none"},
+	"security.detector.patterns[].severity": {"low", "medium", "high", "critical"},
+	"security.disabled_features[]":          Features,
+	"security.disabled_in_flight":           {"", "finish", "kill"},
+	"tls.client_auth_mode":                  {"", "none", "request", "require_and_verify"},
+}
+
+// durationPattern matches what time.ParseDuration takes, such as 30s or
+// 1h30m.
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema returns a JSON Schema for the config file, generated from the yaml
+// tags of Config. Durations are strings such as "30s", settings in
+// schemaEnums list their values, and defaults are DefaultConfig's. Keys no
+// setting uses are refused, where the server would ignore them.
+func Schema() ([]byte, error) {
+	s := schemaFor(reflect.TypeOf(Config{}), reflect.ValueOf(*DefaultConfig()), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "safe-agent-sandbox server config"
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// schemaFor describes values of type t at path. v is the default, or the
+// zero Value where there is none, as for the items of a list.
+func schemaFor(t reflect.Type, v reflect.Value, path string) map[string]any {
+	var s map[string]any
+	switch {
+	case t == durationType:
+		s = map[string]any{"type": "string", "pattern": durationPattern}
+		if v.IsValid() && !v.IsZero() {
+			s["default"] = time.Duration(v.Int()).String()
+		}
+		return s
+	case t.Kind() == reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" || !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name) // yaml.v3's default
+			}
+			var fv reflect.Value
+			if v.IsValid() {
+				fv = v.Field(i)
+			}
+			props[name] = schemaFor(f.Type, fv, strings.TrimPrefix(path+"."+name, "."))
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	case t.Kind() == reflect.Pointer:
+		var ev reflect.Value
+		if v.IsValid() && !v.IsNil() {
+			ev = v.Elem()
+		}
+		return schemaFor(t.Elem(), ev, path)
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), reflect.Value{}, path+".*")}
+	case t.Kind() == reflect.Slice:
+		s = map[string]any{"type": "array", "items": schemaFor(t.Elem(), reflect.Value{}, path+"[]")}
+	case t.Kind() == reflect.String:
+		s = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		s = map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		s = map[string]any{"type": "integer"}
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		s = map[string]any{"type": "integer", "minimum": 0}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s = map[string]any{"type": "number"}
+	default:
+		panic(fmt.Sprintf("config: no schema for %s (%s)", path, t))
+	}
+	if enum, ok := schemaEnums[path]; ok {
+		s["enum"] = enum
+	}
+	// Lists of sections would marshal with Go's field names; they have
+	// no default anyway.
+	if v.IsValid() && !v.IsZero() && (t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Struct) {
+		s["default"] = v.Interface()
+	}
+	return s
+}
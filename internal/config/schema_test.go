@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestSchema_Golden catches a Config change without its schema: the
+// published configs/config.schema.json must be what Schema generates.
+func TestSchema_Golden(t *testing.T) {
+	got, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../configs/config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("configs/config.schema.json is out of date; run make config-schema")
+	}
+}
+
+func TestSchema_Shapes(t *testing.T) {
+	schema := generatedSchema(t)
+	tests := []struct {
+		path []string
+		want map[string]any
+	}{
+		{[]string{"server", "read_timeout"}, map[string]any{"type": "string", "pattern": durationPattern, "default": "30s"}},
+		{[]string{"server", "port"}, map[string]any{"type": "integer", "default": 8080.0}},
+		{[]string{"sandbox", "backend"}, map[string]any{"type": "string", "enum": []any{"", "auto", "containerd", "docker"}, "default": "auto"}},
+		{[]string{"sandbox", "workdir_size", "exclude"}, map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "default": []any{".git", "node_modules"}}},
+		{[]string{"sandbox", "runtime_images"}, map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}}},
+	}
+	for _, tt := range tests {
+		got := schema
+		for _, name := range tt.path {
+			got = got["properties"].(map[string]any)[name].(map[string]any)
+		}
+		if !jsonEqual(got, tt.want) {
+			t.Errorf("%v: %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if _, ok := schema["properties"].(map[string]any)["auth_proxy"].(map[string]any)["properties"].(map[string]any)["secret"]; ok {
+		t.Error("auth_proxy.secret, which isn't read from the file, is in the schema")
+	}
+}
+
+// TestSchema_ShippedConfig checks configs/config.yaml against the schema,
+// and that the schema refuses a mistyped key and an unknown backend.
+func TestSchema_ShippedConfig(t *testing.T) {
+	schema := generatedSchema(t)
+	data, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := conforms(schema, jsonValue(t, doc), ""); err != nil {
+		t.Errorf("configs/config.yaml: %v", err)
+	}
+
+	for _, bad := range []string{"sandbox:\n  backnd: docker\n", "sandbox:\n  backend: podman\n", "server:\n  read_timeout: 30\n"} {
+		var doc any
+		yaml.Unmarshal([]byte(bad), &doc)
+		if err := conforms(schema, jsonValue(t, doc), ""); err == nil {
+			t.Errorf("schema accepted %q", bad)
+		}
+	}
+}
+
+func generatedSchema(t *testing.T) map[string]any {
+	t.Helper()
+	data, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// jsonValue round-trips v through JSON, as a YAML file would be seen by a
+// JSON Schema validator.
+func jsonValue(t *testing.T, v any) any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	json.Unmarshal(data, &out)
+	return out
+}
+
+func jsonEqual(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// conforms checks v against the parts of JSON Schema that Schema uses.
+func conforms(schema map[string]any, v any, path string) error {
+	if v == nil {
+		return nil // an empty key in YAML, which leaves the default
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: want an object, got %v", path, v)
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for k, item := range obj {
+			sub, ok := props[k].(map[string]any)
+			if !ok {
+				if sub, ok = schema["additionalProperties"].(map[string]any); !ok {
+					return fmt.Errorf("%s: unknown key %q", path, k)
+				}
+			}
+			if err := conforms(sub, item, path+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: want an array, got %v", path, v)
+		}
+		for i, item := range items {
+			if err := conforms(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: want a string, got %v", path, v)
+		}
+		if p, ok := schema["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(s) {
+			return fmt.Errorf("%s: %q doesn't match %s", path, s, p)
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			return fmt.Errorf("%s: %q is not one of %v", path, s, enum)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: want an integer, got %v", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: want a number, got %v", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: want a boolean, got %v", path, v)
+		}
+	}
+	return nil
+}
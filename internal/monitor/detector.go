@@ -1,8 +1,10 @@
 package monitor
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -11,6 +13,10 @@ import (
 // This provides an additional layer of detection beyond seccomp/capabilities.
 type EscapeDetector struct {
 	patterns []DetectionPattern
+	anchors  *anchorSet
+	budget   time.Duration
+	maxLine  int
+	now      func() time.Time
 }
 
 // DetectionPattern defines a suspicious pattern to match.
@@ -19,8 +25,40 @@ type DetectionPattern struct {
 	Description string
 	Regex       *regexp.Regexp
 	Severity    Severity
+	// Anchors are literal substrings one of which is in anything Regex
+	// matches, compared ignoring ASCII case. Regex is only run on lines
+	// containing one, so an anchor list missing a way Regex can match
+	// hides those matches. A pattern without anchors is run on every line.
+	Anchors []string
 }
 
+// DetectorOptions bound the cost of AnalyzeCode on large or adversarial
+// code.
+type DetectorOptions struct {
+	// Budget is how long AnalyzeCode scans every line; past it, it only
+	// samples the rest and the analysis is partial. 0 = no budget.
+	Budget time.Duration
+	// MaxLineLength splits longer lines into chunks for the regexes, which
+	// overlap by chunkOverlap bytes so a match across a boundary is still
+	// found if it is no longer than that. 0 = lines are never split.
+	MaxLineLength int
+	// Patterns are added to the default ones and must have anchors.
+	Patterns []DetectionPattern
+}
+
+const (
+	// DefaultAnalysisBudget is NewEscapeDetector's Budget.
+	DefaultAnalysisBudget = 50 * time.Millisecond
+	// DefaultMaxLineLength is NewEscapeDetector's MaxLineLength.
+	DefaultMaxLineLength = 4096
+
+	chunkOverlap = 256
+	// Once over budget, one chunk in sampleEvery is scanned.
+	sampleEvery = 16
+	// The clock is read once every clockEvery chunks.
+	clockEvery = 32
+)
+
 // Severity levels for detected threats.
 type Severity int
 
@@ -48,34 +86,102 @@ func (s Severity) String() string {
 
 // Detection represents a detected suspicious pattern.
 type Detection struct {
-	Pattern  string   `json:"pattern"`
-	Severity string   `json:"severity"`
-	Detail   string   `json:"detail"`
-	Line     int      `json:"line,omitempty"`
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+	Detail   string `json:"detail"`
+	Line     int    `json:"line,omitempty"`
+	Count    int    `json:"count,omitempty"` // matches collapsed into this one by Dedupe
 }
 
 // NewEscapeDetector creates a detector with default patterns.
 func NewEscapeDetector() *EscapeDetector {
-	return &EscapeDetector{
-		patterns: defaultPatterns(),
+	d, _ := NewEscapeDetectorWithOptions(DetectorOptions{
+		Budget:        DefaultAnalysisBudget,
+		MaxLineLength: DefaultMaxLineLength,
+	})
+	return d
+}
+
+// NewEscapeDetectorWithOptions creates a detector with the default patterns
+// and those in opts.
+func NewEscapeDetectorWithOptions(opts DetectorOptions) (*EscapeDetector, error) {
+	if opts.MaxLineLength != 0 && opts.MaxLineLength <= chunkOverlap {
+		return nil, fmt.Errorf("max line length must be over %d, got %d", chunkOverlap, opts.MaxLineLength)
+	}
+	patterns := defaultPatterns()
+	for _, p := range opts.Patterns {
+		if len(p.Anchors) == 0 {
+			return nil, fmt.Errorf("pattern %s has no anchors", p.Name)
+		}
+		for _, a := range p.Anchors {
+			if a == "" {
+				return nil, fmt.Errorf("pattern %s has an empty anchor", p.Name)
+			}
+		}
+		patterns = append(patterns, p)
 	}
+	return &EscapeDetector{
+		patterns: patterns,
+		anchors:  newAnchorSet(patterns),
+		budget:   opts.Budget,
+		maxLine:  opts.MaxLineLength,
+		now:      time.Now,
+	}, nil
+}
+
+// Analysis is what AnalyzeCode found.
+type Analysis struct {
+	Detections []Detection
+	// Partial is set when the budget ran out and only a sample of the
+	// lines after that point were scanned.
+	Partial bool
 }
 
 // AnalyzeCode checks submitted code for suspicious patterns before execution.
 func (d *EscapeDetector) AnalyzeCode(code string) []Detection {
-	var detections []Detection
+	return d.Analyze(code).Detections
+}
+
+// Analyze is AnalyzeCode, saying whether the analysis was partial.
+func (d *EscapeDetector) Analyze(code string) Analysis {
+	var a Analysis
+	start := d.now()
+	hits := make([]bool, len(d.patterns))
+	found := make([]bool, len(d.patterns)) // on the current line
+	chunks := 0
+
+	for i, line := range strings.Split(code, "\n") {
+		clear(found)
+		for len(line) > 0 {
+			chunk := line
+			if d.maxLine > 0 && len(chunk) > d.maxLine {
+				chunk = chunk[:d.maxLine]
+				line = line[d.maxLine-chunkOverlap:]
+			} else {
+				line = ""
+			}
+
+			chunks++
+			if d.budget > 0 && !a.Partial && chunks%clockEvery == 0 && d.now().Sub(start) > d.budget {
+				a.Partial = true
+				log.Warn().Dur("budget", d.budget).Int("line", i+1).Msg("code analysis over budget, sampling the rest")
+			}
+			if a.Partial && chunks%sampleEvery != 0 {
+				continue
+			}
 
-	lines := strings.Split(code, "\n")
-	for i, line := range lines {
-		for _, p := range d.patterns {
-			if p.Regex.MatchString(line) {
-				det := Detection{
+			d.anchors.candidates(chunk, hits)
+			for j, p := range d.patterns {
+				if !hits[j] || found[j] || !p.Regex.MatchString(chunk) {
+					continue
+				}
+				found[j] = true
+				a.Detections = append(a.Detections, Detection{
 					Pattern:  p.Name,
 					Severity: p.Severity.String(),
 					Detail:   p.Description,
 					Line:     i + 1,
-				}
-				detections = append(detections, det)
+				})
 
 				log.Warn().
 					Str("pattern", p.Name).
@@ -86,7 +192,25 @@ func (d *EscapeDetector) AnalyzeCode(code string) []Detection {
 		}
 	}
 
-	return detections
+	return a
+}
+
+// Dedupe collapses detections of the same pattern into the first one, with
+// Count set to how many there were, so a pattern repeated on every line of a
+// large file is reported once.
+func Dedupe(detections []Detection) []Detection {
+	var out []Detection
+	index := make(map[string]int)
+	for _, d := range detections {
+		if i, ok := index[d.Pattern]; ok {
+			out[i].Count++
+			continue
+		}
+		index[d.Pattern] = len(out)
+		d.Count = 1
+		out = append(out, d)
+	}
+	return out
 }
 
 // AnalyzeOutput checks execution output for signs of successful escape.
@@ -125,60 +249,70 @@ func defaultPatterns() []DetectionPattern {
 			Description: "Accessing /proc/self for process info",
 			Regex:       regexp.MustCompile(`/proc/self/(root|exe|fd|ns|maps|status)`),
 			Severity:    SeverityHigh,
+			Anchors:     []string{"/proc/self/"},
 		},
 		{
 			Name:        "container_breakout",
 			Description: "Attempting container breakout via cgroup",
 			Regex:       regexp.MustCompile(`/sys/fs/cgroup|notify_on_release|release_agent`),
 			Severity:    SeverityCritical,
+			Anchors:     []string{"/sys/fs/cgroup", "notify_on_release", "release_agent"},
 		},
 		{
 			Name:        "host_mount_access",
 			Description: "Attempting to access host mounts",
 			Regex:       regexp.MustCompile(`/var/run/docker|/var/run/containerd`),
 			Severity:    SeverityCritical,
+			Anchors:     []string{"/var/run/docker", "/var/run/containerd"},
 		},
 		{
 			Name:        "kernel_exploit",
 			Description: "Potential kernel exploitation attempt",
 			Regex:       regexp.MustCompile(`(?i)(dirty.?cow|dirty.?pipe|over(lay|l)fs|userfaultfd)`),
 			Severity:    SeverityCritical,
+			Anchors:     []string{"dirty", "overlayfs", "overlfs", "userfaultfd"},
 		},
 		{
 			Name:        "metadata_service",
 			Description: "Attempting to reach cloud metadata service",
 			Regex:       regexp.MustCompile(`169\.254\.169\.254|metadata\.google|metadata\.aws`),
 			Severity:    SeverityHigh,
+			Anchors:     []string{"169.254.169.254", "metadata.google", "metadata.aws"},
 		},
 		{
 			Name:        "reverse_shell",
 			Description: "Potential reverse shell command",
 			Regex:       regexp.MustCompile(`(?i)(nc|ncat|netcat|socat)\s+.*-[elp]|/dev/tcp/|bash\s+-i\s+>&`),
 			Severity:    SeverityCritical,
+			Anchors:     []string{"nc ", "nc\t", "nc\f", "nc\r", "ncat", "netcat", "socat", "/dev/tcp/", "bash"},
 		},
 		{
 			Name:        "capability_abuse",
 			Description: "Attempting to manipulate capabilities",
 			Regex:       regexp.MustCompile(`(?i)(cap_sys_admin|cap_net_raw|setcap|getcap|capsh)`),
 			Severity:    SeverityHigh,
+			Anchors:     []string{"cap_sys_admin", "cap_net_raw", "setcap", "getcap", "capsh"},
 		},
 		{
 			Name:        "ptrace_attempt",
 			Description: "Attempting to use ptrace for debugging/injection",
 			Regex:       regexp.MustCompile(`(?i)(ptrace|process_vm_readv|process_vm_writev|PTRACE_ATTACH)`),
 			Severity:    SeverityCritical,
+			Anchors:     []string{"ptrace", "process_vm_readv", "process_vm_writev"},
 		},
 		{
 			Name:        "symlink_race",
 			Description: "Potential symlink race attack",
 			Regex:       regexp.MustCompile(`ln\s+-sf?\s+/proc|ln\s+-sf?\s+/sys|ln\s+-sf?\s+/dev`),
 			Severity:    SeverityHigh,
+			Anchors:     []string{"/proc", "/sys", "/dev"},
 		},
 		{
 			Name:        "crypto_miner",
 			Description: "Potential cryptocurrency mining",
 			Regex:       regexp.MustCompile(`(?i)(stratum\+tcp|xmrig|minerd|cryptonight|hashrate)`),
 			Severity:    SeverityMedium,
+			Anchors:     []string{"stratum+tcp", "xmrig", "minerd", "cryptonight", "hashrate"},
 		},
 	}
 }
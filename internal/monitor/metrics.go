@@ -1,23 +1,73 @@
 package monitor
 
 import (
+	"context"
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"safe-agent-sandbox/internal/state"
+	"safe-agent-sandbox/internal/version"
 )
 
 // Metrics holds all Prometheus metrics for the sandbox system.
 type Metrics struct {
 	Registry *prometheus.Registry
 
-	ExecutionsTotal    *prometheus.CounterVec
-	ExecutionDuration  *prometheus.HistogramVec
-	ExecutionErrors    *prometheus.CounterVec
-	ActiveExecutions   prometheus.Gauge
-	SecurityEvents     *prometheus.CounterVec
-	ContainerPoolSize  *prometheus.GaugeVec
-	ContainerdLatency  *prometheus.HistogramVec
-	RequestsInFlight   prometheus.Gauge
-	CodeSizeBytes      prometheus.Histogram
-	OutputSizeBytes    prometheus.Histogram
+	ExecutionsTotal   *prometheus.CounterVec
+	ExecutionDuration *prometheus.HistogramVec
+	ExecutionErrors   *prometheus.CounterVec
+	ExecutionTimeouts *prometheus.CounterVec
+	ActiveExecutions  prometheus.Gauge
+	SlotWait          *prometheus.HistogramVec
+	SlotsInUse        *prometheus.GaugeVec
+	FairShareWait     *prometheus.HistogramVec
+	WorkdirLockWait   prometheus.Histogram
+	WorkdirConflicts  prometheus.Counter
+	WorkdirSize       prometheus.Histogram
+	DedupAttached     prometheus.Counter
+	CachePrunes       *prometheus.CounterVec
+	SeccompChecks     *prometheus.CounterVec
+	SeccompTampered   *prometheus.CounterVec
+	CPUAbuseKills     *prometheus.CounterVec
+	ImagePulls        *prometheus.HistogramVec
+	SecurityEvents    *prometheus.CounterVec
+	ContainerPoolSize *prometheus.GaugeVec
+	ContainerdLatency *prometheus.HistogramVec
+	RequestsInFlight  prometheus.Gauge
+	CodeSizeBytes     prometheus.Histogram
+	OutputSizeBytes   prometheus.Histogram
+	StreamDropped     *prometheus.CounterVec
+	CostSpent         *prometheus.GaugeVec
+	CostRejections    prometheus.Counter
+	Throttles         *prometheus.CounterVec
+	KeyRequests       *prometheus.CounterVec
+	ProxyConns        *prometheus.CounterVec
+	ProxyHandshake    prometheus.Histogram
+	ProxyDialErrors   prometheus.Counter
+	AuditBatchRows    *prometheus.HistogramVec
+	AuditFlush        *prometheus.HistogramVec
+	AuditFallbacks    prometheus.Counter
+	AuditLost         *prometheus.CounterVec
+	AuditRefusals     *prometheus.CounterVec
+	AnomalyFlags      *prometheus.CounterVec
+	FeatureDisabled   *prometheus.GaugeVec
+	FeatureRejections *prometheus.CounterVec
+	BuildInfo         prometheus.Gauge
+	ImageInfo         *prometheus.GaugeVec
+	BadTransitions    *prometheus.CounterVec
+	DiskFreeBytes     *prometheus.GaugeVec
+	DiskPressure      prometheus.Gauge
+	ImagesRemoved     prometheus.Counter
+	ImageGCFreedBytes prometheus.Counter
+	JobRuns           *prometheus.CounterVec
+	NetworkDecisions  *prometheus.CounterVec
+	JobDuration       *prometheus.HistogramVec
+	Handovers         *prometheus.CounterVec
+
+	slo *SLOTracker // nil unless TrackSLOs was called
 }
 
 // NewMetrics creates and registers all Prometheus metrics using a dedicated registry.
@@ -31,28 +81,37 @@ func NewMetrics() *Metrics {
 			prometheus.CounterOpts{
 				Namespace: "sandbox",
 				Name:      "executions_total",
-				Help:      "Total number of sandbox executions by language and status.",
+				Help:      "Total number of sandbox executions by backend, language and status, the state each ended in. chaos=\"true\" marks synthesized failures.",
 			},
-			[]string{"language", "status"},
+			[]string{"backend", "language", "status", "chaos"},
 		),
 
 		ExecutionDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: "sandbox",
 				Name:      "execution_duration_seconds",
-				Help:      "Duration of sandbox executions in seconds.",
+				Help:      "Duration of sandbox executions in seconds. Exemplars carry exec_id and trace_id.",
 				Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
 			},
-			[]string{"language"},
+			[]string{"backend", "language", "chaos"},
 		),
 
 		ExecutionErrors: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: "sandbox",
 				Name:      "execution_errors_total",
-				Help:      "Total sandbox execution errors by type.",
+				Help:      "Total sandbox execution errors by backend and type.",
 			},
-			[]string{"type"},
+			[]string{"backend", "type"},
+		),
+
+		ExecutionTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "execution_timeouts_total",
+				Help:      "Executions that ran out of time, by backend and phase: setup (image pull, container creation; never the code's fault) or run (the code's timeout).",
+			},
+			[]string{"backend", "phase"},
 		),
 
 		ActiveExecutions: prometheus.NewGauge(
@@ -63,6 +122,115 @@ func NewMetrics() *Metrics {
 			},
 		),
 
+		SlotWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "slot_wait_seconds",
+				Help:      "Time executions waited for a concurrency slot, by language. Saturated waits are included.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+			},
+			[]string{"language"},
+		),
+
+		SlotsInUse: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "slots_in_use",
+				Help:      "Concurrency slots in use per pool: a language with its own limit, or default.",
+			},
+			[]string{"pool"},
+		),
+
+		FairShareWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "fair_share_wait_seconds",
+				Help:      "Time executions waited for a concurrency slot under sandbox.fair_share, by caller: the first 12 hex characters of its API key hash, or anonymous.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+			},
+			[]string{"identity"},
+		),
+
+		WorkdirLockWait: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "workdir_lock_wait_seconds",
+				Help:      "Time claude executions waited for a work_dir another execution had mounted read-write. Uncontended locks are not observed.",
+				Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300},
+			},
+		),
+
+		WorkdirConflicts: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "workdir_lock_conflicts_total",
+				Help:      "Executions rejected with WORKDIR_BUSY.",
+			},
+		),
+
+		WorkdirSize: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "workdir_size_bytes",
+				Help:      "Size of claude work_dirs, measured before they are mounted. A walk cut short by max_walk or max_entries observes what it counted.",
+				Buckets:   prometheus.ExponentialBuckets(1<<20, 4, 10), // 1MB to 256GB
+			},
+		),
+
+		DedupAttached: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "dedup_attached_total",
+				Help:      "Submissions answered with the result of an identical execution already running (sandbox.dedup), without running their own.",
+			},
+		),
+
+		CachePrunes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "cache_prunes_total",
+				Help:      "Claude cache volumes deleted for exceeding sandbox.claude_caches.max_bytes.",
+			},
+			[]string{"cache"},
+		),
+
+		SeccompChecks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "seccomp_verifications_total",
+				Help:      "Docker executions by the seccomp state sandbox.verify_seccomp found: applied, not_applied (code not run) or unreported.",
+			},
+			[]string{"outcome"},
+		),
+
+		SeccompTampered: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "seccomp_profile_tampered_total",
+				Help:      "Seccomp profile files found changed on disk before an execution used them, by profile. Each was rewritten and the execution refused.",
+			},
+			[]string{"profile"},
+		),
+
+		CPUAbuseKills: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "cpu_abuse_kills_total",
+				Help:      "Executions killed for saturating their CPU quota (sandbox.cpu_abuse), by language and trigger: crypto_miner, silent or aggressive.",
+			},
+			[]string{"language", "trigger"},
+		),
+
+		ImagePulls: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "image_pull_duration_seconds",
+				Help:      "Time executions waited on a pull of their runtime image, in their setup phase, by image.",
+				Buckets:   []float64{1, 5, 10, 30, 60, 120, 300},
+			},
+			[]string{"image"},
+		),
+
 		SecurityEvents: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: "sandbox",
@@ -117,37 +285,546 @@ func NewMetrics() *Metrics {
 				Buckets:   prometheus.ExponentialBuckets(10, 4, 8),
 			},
 		),
+
+		StreamDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "stream_dropped_bytes_total",
+				Help:      "Output bytes not delivered to streaming clients that could not keep up.",
+			},
+			[]string{"stream"},
+		),
+
+		CostSpent: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "cost_spent",
+				Help:      "Cost charged to each caller over the last hour, against security.cost_budget.hourly. identity is a prefix of the API key hash.",
+			},
+			[]string{"identity"},
+		),
+
+		CostRejections: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "cost_budget_rejections_total",
+				Help:      "Executions rejected with COST_BUDGET_EXCEEDED.",
+			},
+		),
+
+		Throttles: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "throttled_total",
+				Help:      "Requests answered 429, by the limit they hit: ip, key, claude, language or proxy.",
+			},
+			[]string{"scope"},
+		),
+
+		KeyRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "key_requests_total",
+				Help:      "Authenticated API requests by caller, those refused included. identity is a prefix of the API key hash, or anonymous.",
+			},
+			[]string{"identity"},
+		),
+
+		ProxyConns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "proxy_upstream_conns_total",
+				Help:      "Requests the auth proxy forwarded, by whether they reused a pooled connection to the API.",
+			},
+			[]string{"reused"},
+		),
+
+		ProxyHandshake: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "proxy_tls_handshake_seconds",
+				Help:      "TLS handshakes of the auth proxy's new connections to the API. A resumed session is quicker.",
+				Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+			},
+		),
+
+		ProxyDialErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "proxy_dial_errors_total",
+				Help:      "Connections to the API the auth proxy failed to open, lookup failures included.",
+			},
+		),
+
+		AuditBatchRows: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "audit_batch_rows",
+				Help:      "Execution records per audit log batch, by sink: postgres or file.",
+				Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+			},
+			[]string{"sink"},
+		),
+
+		AuditFlush: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "audit_flush_duration_seconds",
+				Help:      "Time to write an audit log batch, including retries and per-record fallback, by sink.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+			},
+			[]string{"sink"},
+		),
+
+		AuditFallbacks: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "audit_row_fallbacks_total",
+				Help:      "Audit records written on their own after Postgres refused their batch.",
+			},
+		),
+
+		AuditLost: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "audit_records_lost_total",
+				Help:      "Audit records a sink never wrote, because its buffer was full or every retry failed, by sink.",
+			},
+			[]string{"sink"},
+		),
+
+		AuditRefusals: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "audit_refusals_total",
+				Help:      "Executions refused with AUDIT_UNAVAILABLE under database.required, by why the audit trail couldn't take them: database or buffer.",
+			},
+			[]string{"reason"},
+		),
+
+		AnomalyFlags: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "anomaly_flags_total",
+				Help:      "Executions flagged as unlike their caller's history, by flag: new_language_for_key, duration_outlier, first_network_use or detection_rate_spike.",
+			},
+			[]string{"flag"},
+		),
+
+		FeatureDisabled: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "feature_disabled",
+				Help:      "1 for each language and feature turned off by security.disabled_languages, security.disabled_features or POST /admin/flags.",
+			},
+			[]string{"kind", "flag"},
+		),
+
+		FeatureRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "feature_disabled_rejections_total",
+				Help:      "Requests refused with FEATURE_DISABLED, by the language or feature that was off.",
+			},
+			[]string{"kind", "flag"},
+		),
+
+		BuildInfo: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   "sandbox",
+				Name:        "build_info",
+				Help:        "Always 1, labelled with the server's version, VCS revision and Go version.",
+				ConstLabels: buildLabels(version.Get()),
+			},
+		),
+
+		ImageInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "image_info",
+				Help:      "1 for each runtime image with the digest it last ran as.",
+			},
+			[]string{"image", "digest"},
+		),
+
+		BadTransitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "state_transitions_invalid_total",
+				Help:      "Execution state changes refused as not allowed from the state the execution was in.",
+			},
+			[]string{"from", "to"},
+		),
+
+		DiskFreeBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "disk_free_bytes",
+				Help:      "Free bytes on the container runtime's data root at the last sample.",
+			},
+			[]string{"path"},
+		),
+
+		DiskPressure: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "sandbox",
+				Name:      "disk_pressure_stage",
+				Help:      "Disk pressure response in force: 0 none, 1 warn, 2 image pulls suspended, 3 image GC.",
+			},
+		),
+
+		ImagesRemoved: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "image_gc_removed_total",
+				Help:      "Images removed by disk-pressure GC.",
+			},
+		),
+
+		ImageGCFreedBytes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "image_gc_removed_bytes_total",
+				Help:      "Size of the images removed by disk-pressure GC, as the runtime reported it. Layers shared with images kept are not freed.",
+			},
+		),
+
+		JobRuns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "job_runs_total",
+				Help:      "Runs of the server's maintenance jobs (config jobs) by job and the state they ended in. They are left out of sandbox_executions_total and the SLOs.",
+			},
+			[]string{"job", "status"},
+		),
+
+		JobDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sandbox",
+				Name:      "job_duration_seconds",
+				Help:      "Duration of maintenance job runs in seconds.",
+				Buckets:   []float64{1, 10, 30, 60, 300, 900, 1800, 3600, 7200, 21600},
+			},
+			[]string{"job"},
+		),
+
+		NetworkDecisions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "network_decisions_total",
+				Help:      "Execution requests by whether security.network_policy gave them a network and why: requested_and_allowed, requested_but_denied_by_policy, forced_for_claude or not_requested.",
+			},
+			[]string{"disposition"},
+		),
+
+		Handovers: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sandbox",
+				Name:      "handovers_total",
+				Help:      "Upgrades that handed the listening sockets to a new binary by outcome: handed_over and aborted in the old process, inherited in the new one.",
+			},
+			[]string{"outcome"},
+		),
 	}
+	m.BuildInfo.Set(1)
 
 	// Register all collectors
 	reg.MustRegister(
 		m.ExecutionsTotal,
 		m.ExecutionDuration,
 		m.ExecutionErrors,
+		m.ExecutionTimeouts,
 		m.ActiveExecutions,
+		m.SlotWait,
+		m.SlotsInUse,
+		m.FairShareWait,
+		m.WorkdirLockWait,
+		m.WorkdirConflicts,
+		m.WorkdirSize,
+		m.DedupAttached,
+		m.CachePrunes,
+		m.SeccompChecks,
+		m.SeccompTampered,
+		m.CPUAbuseKills,
+		m.ImagePulls,
 		m.SecurityEvents,
 		m.ContainerPoolSize,
 		m.ContainerdLatency,
 		m.RequestsInFlight,
 		m.CodeSizeBytes,
 		m.OutputSizeBytes,
+		m.StreamDropped,
+		m.CostSpent,
+		m.CostRejections,
+		m.Throttles,
+		m.KeyRequests,
+		m.ProxyConns,
+		m.ProxyHandshake,
+		m.ProxyDialErrors,
+		m.AuditBatchRows,
+		m.AuditFlush,
+		m.AuditFallbacks,
+		m.AuditLost,
+		m.AuditRefusals,
+		m.AnomalyFlags,
+		m.FeatureDisabled,
+		m.FeatureRejections,
+		m.BuildInfo,
+		m.ImageInfo,
+		m.BadTransitions,
+		m.DiskFreeBytes,
+		m.DiskPressure,
+		m.ImagesRemoved,
+		m.ImageGCFreedBytes,
+		m.JobRuns,
+		m.JobDuration,
+		m.NetworkDecisions,
+		m.Handovers,
 	)
 
 	return m
 }
 
-// RecordExecution records metrics for a completed execution.
-func (m *Metrics) RecordExecution(language, status string, durationSec float64) {
-	m.ExecutionsTotal.WithLabelValues(language, status).Inc()
-	m.ExecutionDuration.WithLabelValues(language).Observe(durationSec)
+// RecordExecution records metrics for a completed execution. chaos marks
+// executions synthesized by failure injection so dashboards can exclude them.
+// The duration observation carries an exemplar with execID, plus the trace ID
+// when ctx holds a sampled span, so a latency spike links to the execution.
+func (m *Metrics) RecordExecution(ctx context.Context, backend, language string, st state.State, durationSec float64, chaos bool, execID string) {
+	chaosLabel := strconv.FormatBool(chaos)
+	m.ExecutionsTotal.WithLabelValues(backend, language, string(st), chaosLabel).Inc()
+	if m.slo != nil && !chaos && st != state.Rejected {
+		m.slo.Record(language, st == state.Completed, time.Duration(durationSec*float64(time.Second)))
+	}
+
+	obs := m.ExecutionDuration.WithLabelValues(backend, language, chaosLabel)
+	eo, ok := obs.(prometheus.ExemplarObserver)
+	if !ok || execID == "" {
+		obs.Observe(durationSec)
+		return
+	}
+	exemplar := prometheus.Labels{"exec_id": execID}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && sc.IsSampled() {
+		exemplar["trace_id"] = sc.TraceID().String()
+	}
+	eo.ObserveWithExemplar(durationSec, exemplar)
+}
+
+// RecordJobRun records a finished run of a maintenance job. Job runs are
+// counted here rather than by RecordExecution, so they don't move the
+// public execution metrics or SLOs.
+func (m *Metrics) RecordJobRun(job string, st state.State, durationSec float64) {
+	m.JobRuns.WithLabelValues(job, string(st)).Inc()
+	m.JobDuration.WithLabelValues(job).Observe(durationSec)
+}
+
+// RecordNetworkDecision counts an execution request's network disposition.
+func (m *Metrics) RecordNetworkDecision(disposition string) {
+	m.NetworkDecisions.WithLabelValues(disposition).Inc()
+}
+
+// RecordHandover counts an upgrade's outcome.
+func (m *Metrics) RecordHandover(outcome string) {
+	m.Handovers.WithLabelValues(outcome).Inc()
+}
+
+// TrackSLOs feeds t the executions RecordExecution sees and registers its
+// gauges. Chaos runs and requests rejected as invalid are left out.
+func (m *Metrics) TrackSLOs(t *SLOTracker) {
+	m.slo = t
+	m.Registry.MustRegister(t)
+}
+
+// RecordError records an execution error by backend and type.
+func (m *Metrics) RecordError(backend, errType string) {
+	m.ExecutionErrors.WithLabelValues(backend, errType).Inc()
+}
+
+// RecordTimeout records an execution that ran out of time in phase.
+func (m *Metrics) RecordTimeout(backend, phase string) {
+	m.ExecutionTimeouts.WithLabelValues(backend, phase).Inc()
+}
+
+// ObserveSlotWait records how long an execution waited for a slot.
+func (m *Metrics) ObserveSlotWait(language string, wait time.Duration) {
+	m.SlotWait.WithLabelValues(language).Observe(wait.Seconds())
+}
+
+// SetSlotsInUse records the slots in use in a concurrency pool.
+func (m *Metrics) SetSlotsInUse(pool string, inUse int) {
+	m.SlotsInUse.WithLabelValues(pool).Set(float64(inUse))
+}
+
+// ObserveFairShareWait records how long a caller's execution waited for a
+// slot while sandbox.fair_share split the slots between callers.
+func (m *Metrics) ObserveFairShareWait(identity string, wait time.Duration) {
+	m.FairShareWait.WithLabelValues(identity).Observe(wait.Seconds())
+}
+
+// ObserveWorkdirLockWait records how long an execution queued for a work_dir.
+func (m *Metrics) ObserveWorkdirLockWait(wait time.Duration) {
+	m.WorkdirLockWait.Observe(wait.Seconds())
+}
+
+// WorkdirLockConflict records an execution rejected with WORKDIR_BUSY.
+func (m *Metrics) WorkdirLockConflict() {
+	m.WorkdirConflicts.Inc()
+}
+
+// ObserveWorkdirSize records the measured size of a claude work_dir.
+func (m *Metrics) ObserveWorkdirSize(bytes int64) {
+	m.WorkdirSize.Observe(float64(bytes))
+}
+
+// CachePruned records a cache volume deleted for exceeding its size cap.
+func (m *Metrics) CachePruned(cache string) {
+	m.CachePrunes.WithLabelValues(cache).Inc()
+}
+
+// SeccompVerified records the outcome of checking an execution's seccomp
+// filter.
+func (m *Metrics) SeccompVerified(outcome string) {
+	m.SeccompChecks.WithLabelValues(outcome).Inc()
+}
+
+// SeccompProfileTampered records a seccomp profile file found changed on
+// disk.
+func (m *Metrics) SeccompProfileTampered(profile string) {
+	m.SeccompTampered.WithLabelValues(profile).Inc()
+}
+
+// CPUAbuseKilled records an execution the CPU guardrail killed.
+func (m *Metrics) CPUAbuseKilled(language, trigger string) {
+	m.CPUAbuseKills.WithLabelValues(language, trigger).Inc()
 }
 
-// RecordError records an execution error by type.
-func (m *Metrics) RecordError(errType string) {
-	m.ExecutionErrors.WithLabelValues(errType).Inc()
+// ImagePulled records an image pull an execution waited on.
+func (m *Metrics) ImagePulled(image string, took time.Duration) {
+	m.ImagePulls.WithLabelValues(image).Observe(took.Seconds())
+}
+
+// AuditBatchFlushed records an audit log batch a sink wrote and how long it
+// took.
+func (m *Metrics) AuditBatchFlushed(sink string, rows int, took time.Duration) {
+	m.AuditBatchRows.WithLabelValues(sink).Observe(float64(rows))
+	m.AuditFlush.WithLabelValues(sink).Observe(took.Seconds())
+}
+
+// AuditRowFallback records an audit record written on its own after its
+// batch was refused.
+func (m *Metrics) AuditRowFallback() {
+	m.AuditFallbacks.Inc()
+}
+
+// AuditRecordsLost records audit records a sink dropped.
+func (m *Metrics) AuditRecordsLost(sink string, n int) {
+	m.AuditLost.WithLabelValues(sink).Add(float64(n))
+}
+
+// AuditRefused records an execution refused with AUDIT_UNAVAILABLE.
+func (m *Metrics) AuditRefused(reason string) {
+	m.AuditRefusals.WithLabelValues(reason).Inc()
+}
+
+// RecordAnomaly records an execution flagged by security.anomaly.
+func (m *Metrics) RecordAnomaly(flag string) {
+	m.AnomalyFlags.WithLabelValues(flag).Inc()
+}
+
+// SetFeatureFlags sets the disabled languages and features, replacing the
+// last set.
+func (m *Metrics) SetFeatureFlags(languages, features []string) {
+	m.FeatureDisabled.Reset()
+	for _, l := range languages {
+		m.FeatureDisabled.WithLabelValues("language", l).Set(1)
+	}
+	for _, f := range features {
+		m.FeatureDisabled.WithLabelValues("feature", f).Set(1)
+	}
+}
+
+// SetImageDigest records that image ran as digest, dropping the series for
+// the digest it ran as before, prev, when that differs.
+func (m *Metrics) SetImageDigest(image, prev, digest string) {
+	if prev != "" && prev != digest {
+		m.ImageInfo.DeleteLabelValues(image, prev)
+	}
+	m.ImageInfo.WithLabelValues(image, digest).Set(1)
+}
+
+// DiskSampled records a free-space sample of the runtime's data root and
+// the disk pressure stage it left the server in.
+func (m *Metrics) DiskSampled(path string, freeBytes uint64, stage int) {
+	m.DiskFreeBytes.WithLabelValues(path).Set(float64(freeBytes))
+	m.DiskPressure.Set(float64(stage))
+}
+
+// ImageRemoved records an image removed by disk-pressure GC.
+func (m *Metrics) ImageRemoved(sizeBytes int64) {
+	m.ImagesRemoved.Inc()
+	m.ImageGCFreedBytes.Add(float64(sizeBytes))
+}
+
+// RecordInvalidTransition records a refused move from one execution state
+// to another.
+func (m *Metrics) RecordInvalidTransition(from, to state.State) {
+	m.BadTransitions.WithLabelValues(string(from), string(to)).Inc()
+}
+
+// RecordFeatureRejection records a request refused with FEATURE_DISABLED.
+func (m *Metrics) RecordFeatureRejection(kind, flag string) {
+	m.FeatureRejections.WithLabelValues(kind, flag).Inc()
+}
+
+// RecordStreamDrop records output dropped for a slow streaming client.
+func (m *Metrics) RecordStreamDrop(stream string, bytes int64) {
+	m.StreamDropped.WithLabelValues(stream).Add(float64(bytes))
+}
+
+// SetCostSpent records a caller's spend over the last hour.
+func (m *Metrics) SetCostSpent(identity string, spent float64) {
+	m.CostSpent.WithLabelValues(identity).Set(spent)
+}
+
+// CostBudgetRejected records an execution rejected with COST_BUDGET_EXCEEDED.
+func (m *Metrics) CostBudgetRejected() {
+	m.CostRejections.Inc()
+}
+
+// Throttled records a 429 for the limit scope.
+func (m *Metrics) Throttled(scope string) {
+	m.Throttles.WithLabelValues(scope).Inc()
+}
+
+// KeyRequest records an authenticated request from the caller identity.
+func (m *Metrics) KeyRequest(identity string) {
+	m.KeyRequests.WithLabelValues(identity).Inc()
+}
+
+// UpstreamConn records an auth proxy request and whether its connection to
+// the API was reused.
+func (m *Metrics) UpstreamConn(reused bool) {
+	m.ProxyConns.WithLabelValues(strconv.FormatBool(reused)).Inc()
+}
+
+// UpstreamHandshake records a TLS handshake to the API.
+func (m *Metrics) UpstreamHandshake(d time.Duration) {
+	m.ProxyHandshake.Observe(d.Seconds())
+}
+
+// UpstreamDialError records a connection to the API that failed to open.
+func (m *Metrics) UpstreamDialError() {
+	m.ProxyDialErrors.Inc()
 }
 
 // RecordSecurityEvent records a security event.
 func (m *Metrics) RecordSecurityEvent(eventType string) {
 	m.SecurityEvents.WithLabelValues(eventType).Inc()
 }
+
+func buildLabels(i version.Info) prometheus.Labels {
+	return prometheus.Labels{
+		"version":    i.String(),
+		"revision":   i.Revision,
+		"go_version": i.GoVersion,
+	}
+}
@@ -0,0 +1,174 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+	"safe-agent-sandbox/internal/state"
+)
+
+// gatherFamily scrapes the registry and returns the named metric family.
+func gatherFamily(t *testing.T, m *Metrics, name string) *dto.MetricFamily {
+	t.Helper()
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return nil
+}
+
+func labels(m *dto.Metric) map[string]string {
+	out := make(map[string]string)
+	for _, l := range m.GetLabel() {
+		out[l.GetName()] = l.GetValue()
+	}
+	return out
+}
+
+func exemplarLabels(m *dto.Metric) map[string]string {
+	for _, b := range m.GetHistogram().GetBucket() {
+		if e := b.GetExemplar(); e != nil {
+			out := make(map[string]string)
+			for _, l := range e.GetLabel() {
+				out[l.GetName()] = l.GetValue()
+			}
+			return out
+		}
+	}
+	return nil
+}
+
+func TestRecordExecution_BackendLabel(t *testing.T) {
+	m := NewMetrics()
+	m.RecordExecution(context.Background(), "containerd", "python", state.Completed, 0.2, false, "exec-1")
+	m.RecordExecution(context.Background(), "docker", "python", state.Timeout, 10, false, "exec-2")
+	m.RecordError("docker", "internal")
+
+	for _, name := range []string{"sandbox_executions_total", "sandbox_execution_duration_seconds", "sandbox_execution_errors_total"} {
+		backends := make(map[string]bool)
+		for _, metric := range gatherFamily(t, m, name).GetMetric() {
+			backends[labels(metric)["backend"]] = true
+		}
+		if name == "sandbox_execution_errors_total" {
+			if !backends["docker"] || len(backends) != 1 {
+				t.Errorf("%s backends = %v, want docker", name, backends)
+			}
+			continue
+		}
+		if !backends["docker"] || !backends["containerd"] || len(backends) != 2 {
+			t.Errorf("%s backends = %v, want docker and containerd", name, backends)
+		}
+	}
+}
+
+func TestRecordExecution_Exemplar(t *testing.T) {
+	m := NewMetrics()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	m.RecordExecution(ctx, "docker", "python", state.Completed, 0.3, false, "exec-traced")
+	m.RecordExecution(context.Background(), "docker", "node", state.Completed, 0.3, false, "exec-untraced")
+
+	for _, metric := range gatherFamily(t, m, "sandbox_execution_duration_seconds").GetMetric() {
+		got := exemplarLabels(metric)
+		switch labels(metric)["language"] {
+		case "python":
+			if got["exec_id"] != "exec-traced" || got["trace_id"] != traceID.String() {
+				t.Errorf("python exemplar = %v", got)
+			}
+		case "node":
+			if _, ok := got["trace_id"]; got["exec_id"] != "exec-untraced" || ok {
+				t.Errorf("node exemplar = %v, want exec_id only", got)
+			}
+		}
+	}
+}
+
+func TestSlotMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveSlotWait("python", 2*time.Millisecond)
+	m.ObserveSlotWait("python", 3*time.Millisecond)
+	m.ObserveSlotWait("claude", time.Second)
+	m.SetSlotsInUse("claude", 10)
+	m.SetSlotsInUse("default", 4)
+	m.SetSlotsInUse("default", 3)
+
+	waits := make(map[string]uint64)
+	for _, metric := range gatherFamily(t, m, "sandbox_slot_wait_seconds").GetMetric() {
+		waits[labels(metric)["language"]] = metric.GetHistogram().GetSampleCount()
+	}
+	if waits["python"] != 2 || waits["claude"] != 1 {
+		t.Errorf("slot wait samples = %v", waits)
+	}
+
+	inUse := make(map[string]float64)
+	for _, metric := range gatherFamily(t, m, "sandbox_slots_in_use").GetMetric() {
+		inUse[labels(metric)["pool"]] = metric.GetGauge().GetValue()
+	}
+	if inUse["claude"] != 10 || inUse["default"] != 3 {
+		t.Errorf("slots in use = %v", inUse)
+	}
+
+	m.ObserveFairShareWait("0123456789ab", 20*time.Millisecond)
+	m.ObserveFairShareWait("anonymous", time.Millisecond)
+	m.ObserveFairShareWait("anonymous", time.Millisecond)
+	shares := make(map[string]uint64)
+	for _, metric := range gatherFamily(t, m, "sandbox_fair_share_wait_seconds").GetMetric() {
+		shares[labels(metric)["identity"]] = metric.GetHistogram().GetSampleCount()
+	}
+	if shares["0123456789ab"] != 1 || shares["anonymous"] != 2 {
+		t.Errorf("fair share wait samples = %v", shares)
+	}
+}
+
+func TestCachePruned(t *testing.T) {
+	m := NewMetrics()
+	m.CachePruned("npm")
+	m.CachePruned("npm")
+	m.CachePruned("pip")
+
+	pruned := make(map[string]float64)
+	for _, metric := range gatherFamily(t, m, "sandbox_cache_prunes_total").GetMetric() {
+		pruned[labels(metric)["cache"]] = metric.GetCounter().GetValue()
+	}
+	if pruned["npm"] != 2 || pruned["pip"] != 1 {
+		t.Errorf("cache prunes = %v", pruned)
+	}
+}
+
+func TestBuildAndImageInfo(t *testing.T) {
+	m := NewMetrics()
+	build := gatherFamily(t, m, "sandbox_build_info").GetMetric()
+	if len(build) != 1 || build[0].GetGauge().GetValue() != 1 || labels(build[0])["version"] == "" || labels(build[0])["go_version"] == "" {
+		t.Errorf("sandbox_build_info = %v", build)
+	}
+
+	m.SetImageDigest("sandbox-python:latest", "", "sha256:aaa")
+	m.SetImageDigest("sandbox-node:latest", "", "sha256:bbb")
+	m.SetImageDigest("sandbox-python:latest", "sha256:aaa", "sha256:ccc")
+	got := make(map[string]string)
+	for _, metric := range gatherFamily(t, m, "sandbox_image_info").GetMetric() {
+		l := labels(metric)
+		if _, dup := got[l["image"]]; dup {
+			t.Errorf("%s has more than one digest series", l["image"])
+		}
+		got[l["image"]] = l["digest"]
+	}
+	if got["sandbox-python:latest"] != "sha256:ccc" || got["sandbox-node:latest"] != "sha256:bbb" {
+		t.Errorf("sandbox_image_info = %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package monitor
+
+// anchorSet finds which patterns could match a line from the literal
+// anchors they declare, in one pass over the line whatever the number of
+// patterns: an Aho-Corasick automaton over every anchor, with ASCII letters
+// folded to lower case. A pattern whose anchors are all absent from a line
+// can't match it, so its regex isn't run.
+type anchorSet struct {
+	class [256]int32 // byte to input class; 0 is every byte in no anchor
+	delta [][]int32  // state to next state by class
+	out   [][]int    // patterns with an anchor ending at each state
+	// always are the patterns with no anchors, run on every line.
+	always []int
+}
+
+func newAnchorSet(patterns []DetectionPattern) *anchorSet {
+	s := &anchorSet{}
+	nclass := int32(1)
+	for _, p := range patterns {
+		for _, a := range p.Anchors {
+			for i := 0; i < len(a); i++ {
+				b := lower(a[i])
+				if s.class[b] == 0 {
+					s.class[b] = nclass
+					if b >= 'a' && b <= 'z' {
+						s.class[b-'a'+'A'] = nclass
+					}
+					nclass++
+				}
+			}
+		}
+	}
+
+	newState := func() int32 {
+		row := make([]int32, nclass)
+		for i := range row {
+			row[i] = -1
+		}
+		s.delta = append(s.delta, row)
+		s.out = append(s.out, nil)
+		return int32(len(s.delta) - 1)
+	}
+	newState() // the root
+	for i, p := range patterns {
+		if len(p.Anchors) == 0 {
+			s.always = append(s.always, i)
+			continue
+		}
+		for _, a := range p.Anchors {
+			state := int32(0)
+			for j := 0; j < len(a); j++ {
+				c := s.class[lower(a[j])]
+				if s.delta[state][c] < 0 {
+					next := newState()
+					s.delta[state][c] = next
+				}
+				state = s.delta[state][c]
+			}
+			s.out[state] = append(s.out[state], i)
+		}
+	}
+
+	// Fill in the failure transitions breadth first, so every state's
+	// failure state is complete before it is used.
+	fail := make([]int32, len(s.delta))
+	var queue []int32
+	for c, next := range s.delta[0] {
+		if next < 0 {
+			s.delta[0][c] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		s.out[state] = append(s.out[state], s.out[fail[state]]...)
+		for c, next := range s.delta[state] {
+			if next < 0 {
+				s.delta[state][c] = s.delta[fail[state]][c]
+				continue
+			}
+			if state != 0 {
+				fail[next] = s.delta[fail[state]][c]
+			}
+			queue = append(queue, next)
+		}
+	}
+	return s
+}
+
+// candidates marks in hits the patterns that could match text.
+func (s *anchorSet) candidates(text string, hits []bool) {
+	clear(hits)
+	for _, i := range s.always {
+		hits[i] = true
+	}
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = s.delta[state][s.class[text[i]]]
+		for _, p := range s.out[state] {
+			hits[p] = true
+		}
+	}
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
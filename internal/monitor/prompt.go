@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PromptDetector screens claude prompts for instructions that would turn the
+// agent against its own sandbox. Prompts are natural language, so the code
+// patterns don't apply: "explain what ptrace does" is a fine prompt. Instead
+// each pattern pairs an intent with a target, and both have to appear in the
+// same paragraph.
+type PromptDetector struct {
+	patterns []PromptPattern
+}
+
+// PromptPattern matches when every one of its expressions matches within a
+// single paragraph of the prompt.
+type PromptPattern struct {
+	Name        string
+	Description string
+	All         []*regexp.Regexp
+	Severity    Severity
+}
+
+// NewPromptDetector creates a detector with the default prompt patterns.
+func NewPromptDetector() *PromptDetector {
+	return &PromptDetector{
+		patterns: defaultPromptPatterns(),
+	}
+}
+
+// AnalyzePrompt checks a claude prompt before it is run. A detection's Line
+// is the first line of the paragraph that matched.
+func (d *PromptDetector) AnalyzePrompt(prompt string) []Detection {
+	var detections []Detection
+
+	lines := strings.Split(prompt, "\n")
+	start := 0
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			continue
+		}
+		if i > start {
+			detections = append(detections, d.analyzeParagraph(strings.Join(lines[start:i], "\n"), start+1)...)
+		}
+		start = i + 1
+	}
+
+	return detections
+}
+
+func (d *PromptDetector) analyzeParagraph(text string, line int) []Detection {
+	var detections []Detection
+	for _, p := range d.patterns {
+		if !matchesAll(p.All, text) {
+			continue
+		}
+		detections = append(detections, Detection{
+			Pattern:  p.Name,
+			Severity: p.Severity.String(),
+			Detail:   p.Description,
+			Line:     line,
+		})
+
+		log.Warn().
+			Str("pattern", p.Name).
+			Str("severity", p.Severity.String()).
+			Int("line", line).
+			Msg("agent abuse detected in prompt")
+	}
+	return detections
+}
+
+func matchesAll(res []*regexp.Regexp, text string) bool {
+	for _, re := range res {
+		if !re.MatchString(text) {
+			return false
+		}
+	}
+	return len(res) > 0
+}
+
+// ParseSeverity is the inverse of Severity.String.
+func ParseSeverity(s string) (Severity, bool) {
+	for sev := SeverityLow; sev <= SeverityCritical; sev++ {
+		if sev.String() == s {
+			return sev, true
+		}
+	}
+	return 0, false
+}
+
+func defaultPromptPatterns() []PromptPattern {
+	var (
+		exfilVerb = regexp.MustCompile(`(?i)\b(upload|exfiltrate|send|post|transmit|leak|e-?mail|forward|curl|wget|scp|rsync|netcat|nc)\b`)
+		readVerb  = regexp.MustCompile(`(?i)\b(cat|read|print|dump|reveal|copy|output|base64)\b`)
+		// Files and variables holding credentials, as opposed to the words
+		// "api key" or "password", which turn up in ordinary coding tasks.
+		secret = regexp.MustCompile(`(?i)(~|\$HOME|/root|/home/[\w.-]+)/\.(ssh|aws|gnupg|kube|docker)\b|\bid_(rsa|dsa|ecdsa|ed25519)\b|\.aws/credentials|\.netrc\b|\.git-credentials|/etc/shadow|(^|[\s'"/])\.env\b|ANTHROPIC_API_KEY|CLAUDE_CODE_OAUTH_TOKEN|\b(all|every) (the )?(environment|env) var`)
+		// Anywhere off the machine; a secret going there is exfiltration.
+		destination = regexp.MustCompile(`(?i)https?://|\b\d{1,3}(\.\d{1,3}){3}\b|[\w.+-]+@[\w-]+\.[a-z]{2,}|\b(remote|external|attacker'?s?|my) (server|host|machine|endpoint)\b|pastebin|webhook`)
+		// Destinations with no business receiving a whole repository.
+		dropSite  = regexp.MustCompile(`(?i)\b\d{1,3}(\.\d{1,3}){3}\b|[\w.+-]+@[\w-]+\.[a-z]{2,}|\battacker|pastebin|transfer\.sh|ngrok|webhook\.site|requestbin|pipedream|burpcollaborator|interact\.sh|oast\.`)
+		workspace = regexp.MustCompile(`(?i)\b(workspace|repo(sitory)?|codebase|source code|all (the )?files|every file|entire (project|directory|folder))\b|/workspace\b`)
+		override  = regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,40}\b(previous|prior|above|earlier|all|your|system)\b.{0,20}\b(instructions|rules|prompts?|guidelines|directions|guardrails)\b`)
+		destroy   = regexp.MustCompile(`(?i)\brm\s+-[a-z]*r[a-z]*\s+(--no-preserve-root\s+)?(/|~|\$HOME|\*)|\bmkfs\b|\bdd\s+if=\S+\s+of=/dev/|:\(\)\s*\{\s*:\|:&\s*\};:|\bchmod\s+-R\s+0?777\s+/|>\s*/dev/sd[a-z]|\bkill\s+-9\s+-1\b|\bgit\s+push\s+(-f|--force)\b|\bdrop\s+(table|database)\b`)
+		tamper    = regexp.MustCompile(`(?i)\b(disable|bypass|turn off|circumvent|escape|break out of|get around|remove)\b`)
+		guard     = regexp.MustCompile(`(?i)\b(sandbox|seccomp|apparmor|your (own )?(restrictions|guardrails|permissions)|permission (checks|prompts)|allowed ?tools|network (restrictions|policy|isolation))\b|dangerously-skip-permissions`)
+	)
+	return []PromptPattern{
+		{
+			Name:        "secret_exfiltration",
+			Description: "Instructs the agent to send credentials off the machine",
+			All:         []*regexp.Regexp{exfilVerb, secret, destination},
+			Severity:    SeverityCritical,
+		},
+		{
+			Name:        "workspace_exfiltration",
+			Description: "Instructs the agent to ship the workspace to an outside drop",
+			All:         []*regexp.Regexp{exfilVerb, workspace, dropSite},
+			Severity:    SeverityCritical,
+		},
+		{
+			Name:        "destructive_override",
+			Description: "Overrides the agent's instructions to run a destructive command",
+			All:         []*regexp.Regexp{override, destroy},
+			Severity:    SeverityCritical,
+		},
+		{
+			Name:        "secret_access",
+			Description: "Instructs the agent to read credential files",
+			All:         []*regexp.Regexp{readVerb, secret},
+			Severity:    SeverityHigh,
+		},
+		{
+			Name:        "sandbox_tampering",
+			Description: "Instructs the agent to disable its own sandbox or permissions",
+			All:         []*regexp.Regexp{tamper, guard},
+			Severity:    SeverityHigh,
+		},
+		{
+			Name:        "instruction_override",
+			Description: "Tells the agent to ignore its instructions",
+			All:         []*regexp.Regexp{override},
+			Severity:    SeverityMedium,
+		},
+	}
+}
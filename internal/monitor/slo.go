@@ -0,0 +1,263 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SLO objective kinds, as reported in SLOStatus.Objective and the gauges'
+// objective label.
+const (
+	ObjectiveLatency = "latency" // the language's p95 stays within P95
+	ObjectiveSuccess = "success" // SuccessRate of executions end in state completed
+)
+
+// sloLatencyQuantile is the share of executions a latency objective bounds.
+const sloLatencyQuantile = 0.95
+
+// sloBounds are the upper bounds, in seconds, of the duration histogram each
+// bucket keeps: execution_duration_seconds' buckets, extended to claude's
+// timeouts.
+var sloBounds = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// SLOObjective is one language's objectives; a zero field sets none.
+type SLOObjective struct {
+	Language    string
+	P95         time.Duration
+	SuccessRate float64
+}
+
+// SLOTracker measures executions against per-language objectives over a
+// rolling window. Each language keeps a ring of buckets, one per bucket
+// interval; a bucket is cleared when the ring comes back round to it, so
+// the window slides without a sweeper. Nothing is persisted: a restart
+// starts every window empty, which the report's LastReset says.
+type SLOTracker struct {
+	window     time.Duration
+	bucket     time.Duration
+	objectives []SLOObjective // sorted by language
+	now        func() time.Time
+	reset      time.Time
+
+	mu    sync.Mutex
+	rings map[string][]sloBucket
+
+	attainment *prometheus.Desc
+	burnRate   *prometheus.Desc
+}
+
+type sloBucket struct {
+	start     time.Time // zero until first used
+	total     int64
+	successes int64
+	slow      int64   // longer than the language's P95 objective
+	counts    []int64 // per sloBounds, then one for longer
+}
+
+// NewSLOTracker tracks objectives over window in steps of bucket. window
+// should be a whole number of buckets.
+func NewSLOTracker(window, bucket time.Duration, objectives []SLOObjective) *SLOTracker {
+	t := &SLOTracker{
+		window:     window,
+		bucket:     bucket,
+		objectives: append([]SLOObjective(nil), objectives...),
+		now:        time.Now,
+		rings:      make(map[string][]sloBucket, len(objectives)),
+		attainment: prometheus.NewDesc("sandbox_slo_attainment",
+			"Fraction of executions in the SLO window meeting the objective.",
+			[]string{"language", "objective"}, nil),
+		burnRate: prometheus.NewDesc("sandbox_slo_burn_rate",
+			"How fast the objective's error budget is being spent over the SLO window; above 1 it runs out before the window does.",
+			[]string{"language", "objective"}, nil),
+	}
+	sort.Slice(t.objectives, func(i, j int) bool { return t.objectives[i].Language < t.objectives[j].Language })
+	n := int((window + bucket - 1) / bucket)
+	for _, o := range t.objectives {
+		t.rings[o.Language] = make([]sloBucket, n)
+	}
+	t.reset = t.now()
+	return t
+}
+
+// Record counts one execution of language. Languages without objectives are
+// ignored.
+func (t *SLOTracker) Record(language string, success bool, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ring, ok := t.rings[language]
+	if !ok {
+		return
+	}
+	b := t.slot(ring, t.now())
+	b.total++
+	if success {
+		b.successes++
+	}
+	if p95 := t.objective(language).P95; p95 > 0 && duration > p95 {
+		b.slow++
+	}
+	b.counts[sort.SearchFloat64s(sloBounds, duration.Seconds())]++
+}
+
+// slot returns ring's bucket for now, cleared if it last held an older
+// interval.
+func (t *SLOTracker) slot(ring []sloBucket, now time.Time) *sloBucket {
+	start := now.Truncate(t.bucket)
+	b := &ring[int(start.UnixNano()/int64(t.bucket))%len(ring)]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start, counts: make([]int64, len(sloBounds)+1)}
+	}
+	return b
+}
+
+// sum adds up ring's buckets that are still inside the window at now.
+func (t *SLOTracker) sum(ring []sloBucket, now time.Time) sloBucket {
+	oldest := now.Truncate(t.bucket).Add(-time.Duration(len(ring)-1) * t.bucket)
+	total := sloBucket{counts: make([]int64, len(sloBounds)+1)}
+	for _, b := range ring {
+		if b.start.IsZero() || b.start.Before(oldest) {
+			continue
+		}
+		total.total += b.total
+		total.successes += b.successes
+		total.slow += b.slow
+		for i, c := range b.counts {
+			total.counts[i] += c
+		}
+	}
+	return total
+}
+
+func (t *SLOTracker) objective(language string) SLOObjective {
+	for _, o := range t.objectives {
+		if o.Language == language {
+			return o
+		}
+	}
+	return SLOObjective{}
+}
+
+// SLOReport is the state of every objective, as GET /slo returns it.
+type SLOReport struct {
+	Window     string      `json:"window"`
+	Bucket     string      `json:"bucket"`
+	LastReset  time.Time   `json:"last_reset"` // tracking started; until a window has passed since, it covers less
+	Objectives []SLOStatus `json:"objectives"`
+}
+
+// SLOStatus is one objective over the window. With no executions it is met,
+// with its whole budget left.
+type SLOStatus struct {
+	Language             string  `json:"language"`
+	Objective            string  `json:"objective"`
+	Target               float64 `json:"target"`              // fraction of executions that must meet it
+	Threshold            string  `json:"threshold,omitempty"` // latency: the p95 bound
+	P95                  string  `json:"p95,omitempty"`       // latency: estimated from the duration histogram
+	Executions           int64   `json:"executions"`
+	Attainment           float64 `json:"attainment"` // fraction that met it
+	Met                  bool    `json:"met"`
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"` // 1 - burn_rate; negative once overspent
+	BurnRate             float64 `json:"burn_rate"`              // failure rate over the rate the target allows
+}
+
+// Report returns every objective's current state.
+func (t *SLOTracker) Report() SLOReport {
+	return SLOReport{
+		Window:     t.window.String(),
+		Bucket:     t.bucket.String(),
+		LastReset:  t.reset,
+		Objectives: t.statuses(),
+	}
+}
+
+func (t *SLOTracker) statuses() []SLOStatus {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]SLOStatus, 0, 2*len(t.objectives))
+	for _, o := range t.objectives {
+		sum := t.sum(t.rings[o.Language], now)
+		if o.P95 > 0 {
+			s := newSLOStatus(o.Language, ObjectiveLatency, sloLatencyQuantile, sum.total, sum.total-sum.slow)
+			s.Threshold = o.P95.String()
+			if sum.total > 0 {
+				p95 := estimateQuantile(sum.counts, sloLatencyQuantile)
+				s.P95 = time.Duration(p95 * float64(time.Second)).Round(time.Millisecond).String()
+			}
+			statuses = append(statuses, s)
+		}
+		if o.SuccessRate > 0 {
+			statuses = append(statuses, newSLOStatus(o.Language, ObjectiveSuccess, o.SuccessRate, sum.total, sum.successes))
+		}
+	}
+	return statuses
+}
+
+// newSLOStatus works out attainment and burn for good of total executions
+// against target.
+func newSLOStatus(language, objective string, target float64, total, good int64) SLOStatus {
+	s := SLOStatus{
+		Language:             language,
+		Objective:            objective,
+		Target:               target,
+		Executions:           total,
+		Attainment:           1,
+		ErrorBudgetRemaining: 1,
+	}
+	if total > 0 {
+		s.Attainment = float64(good) / float64(total)
+		s.BurnRate = (1 - s.Attainment) / (1 - target)
+		s.ErrorBudgetRemaining = 1 - s.BurnRate
+	}
+	s.Met = s.Attainment >= target
+	return s
+}
+
+// estimateQuantile interpolates the q quantile, in seconds, from histogram
+// counts over sloBounds the way Prometheus' histogram_quantile does: linearly
+// within the bucket the rank falls in. Past the last bound it answers the
+// last bound.
+func estimateQuantile(counts []int64, q float64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var below int64
+	for i, c := range counts {
+		if c > 0 && float64(below+c) >= rank {
+			if i == len(sloBounds) {
+				break
+			}
+			lower := 0.0
+			if i > 0 {
+				lower = sloBounds[i-1]
+			}
+			return lower + (sloBounds[i]-lower)*(rank-float64(below))/float64(c)
+		}
+		below += c
+	}
+	return sloBounds[len(sloBounds)-1]
+}
+
+// Describe implements prometheus.Collector.
+func (t *SLOTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.attainment
+	ch <- t.burnRate
+}
+
+// Collect implements prometheus.Collector, working the gauges out at scrape
+// time so they follow the window even when nothing runs.
+func (t *SLOTracker) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range t.statuses() {
+		ch <- prometheus.MustNewConstMetric(t.attainment, prometheus.GaugeValue, s.Attainment, s.Language, s.Objective)
+		ch <- prometheus.MustNewConstMetric(t.burnRate, prometheus.GaugeValue, s.BurnRate, s.Language, s.Objective)
+	}
+}
@@ -0,0 +1,161 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// newTestSLOTracker returns a tracker on a clock the test moves.
+func newTestSLOTracker(window, bucket time.Duration, objectives ...SLOObjective) (*SLOTracker, *time.Time) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	t := NewSLOTracker(window, bucket, objectives)
+	t.now = func() time.Time { return now }
+	t.reset = now
+	return t, &now
+}
+
+func statusOf(t *testing.T, tr *SLOTracker, language, objective string) SLOStatus {
+	t.Helper()
+	for _, s := range tr.Report().Objectives {
+		if s.Language == language && s.Objective == objective {
+			return s
+		}
+	}
+	t.Fatalf("no %s %s objective in %+v", language, objective, tr.Report())
+	return SLOStatus{}
+}
+
+func TestEstimateQuantile(t *testing.T) {
+	counts := func(byBound map[float64]int64) []int64 {
+		c := make([]int64, len(sloBounds)+1)
+		for bound, n := range byBound {
+			i := len(sloBounds)
+			if bound > 0 {
+				i = indexOf(sloBounds, bound)
+			}
+			c[i] = n
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		counts []int64
+		want   float64
+	}{
+		{"empty", counts(nil), 0},
+		{"one bucket", counts(map[float64]int64{1: 100}), 0.5 + 0.5*0.95},
+		{"rank in the upper bucket", counts(map[float64]int64{0.1: 90, 2.5: 10}), 1 + 1.5*0.5},
+		{"rank on a bucket edge", counts(map[float64]int64{0.5: 95, 5: 5}), 0.5},
+		{"first bucket from zero", counts(map[float64]int64{0.01: 20}), 0.0095},
+		{"past the last bound", counts(map[float64]int64{1: 1, -1: 99}), 1800},
+	}
+	for _, tt := range tests {
+		if got := estimateQuantile(tt.counts, 0.95); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: p95 = %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func indexOf(bounds []float64, v float64) int {
+	for i, b := range bounds {
+		if b == v {
+			return i
+		}
+	}
+	panic("not a bound")
+}
+
+func TestSLOTracker_BurnRate(t *testing.T) {
+	tr, _ := newTestSLOTracker(time.Hour, time.Minute,
+		SLOObjective{Language: "python", P95: 2 * time.Second, SuccessRate: 0.99},
+		SLOObjective{Language: "claude", SuccessRate: 0.95})
+
+	for i := 0; i < 100; i++ {
+		d := 300 * time.Millisecond
+		if i < 10 {
+			d = 3 * time.Second
+		}
+		tr.Record("python", i%50 != 0, d) // 2 failures
+	}
+	tr.Record("bash", false, time.Minute) // no objectives
+
+	success := statusOf(t, tr, "python", ObjectiveSuccess)
+	if success.Executions != 100 || success.Attainment != 0.98 || success.Met {
+		t.Errorf("success = %+v", success)
+	}
+	if math.Abs(success.BurnRate-2) > 1e-9 || math.Abs(success.ErrorBudgetRemaining+1) > 1e-9 {
+		t.Errorf("burn rate %g, budget left %g; want 2 and -1", success.BurnRate, success.ErrorBudgetRemaining)
+	}
+
+	latency := statusOf(t, tr, "python", ObjectiveLatency)
+	if latency.Attainment != 0.9 || latency.Met || latency.Threshold != "2s" || math.Abs(latency.BurnRate-2) > 1e-9 {
+		t.Errorf("latency = %+v", latency)
+	}
+	// 90 in (0.25,0.5], 10 in (2.5,5]: rank 95 is halfway into the top bucket.
+	if latency.P95 != "3.75s" {
+		t.Errorf("p95 = %s, want 3.75s", latency.P95)
+	}
+
+	idle := statusOf(t, tr, "claude", ObjectiveSuccess)
+	if idle.Executions != 0 || !idle.Met || idle.BurnRate != 0 || idle.ErrorBudgetRemaining != 1 {
+		t.Errorf("claude with no executions = %+v", idle)
+	}
+	if len(tr.Report().Objectives) != 3 {
+		t.Errorf("objectives = %+v, want python latency and success, claude success", tr.Report().Objectives)
+	}
+}
+
+func TestSLOTracker_WindowEviction(t *testing.T) {
+	tr, now := newTestSLOTracker(5*time.Minute, time.Minute, SLOObjective{Language: "python", SuccessRate: 0.9})
+
+	tr.Record("python", false, time.Second)
+	*now = now.Add(2 * time.Minute)
+	tr.Record("python", true, time.Second)
+	tr.Record("python", true, time.Second)
+	if s := statusOf(t, tr, "python", ObjectiveSuccess); s.Executions != 3 {
+		t.Fatalf("executions = %d, want 3", s.Executions)
+	}
+
+	// The failure's bucket is the oldest in the window until it has been
+	// five buckets ago.
+	*now = now.Add(2*time.Minute + 59*time.Second)
+	if s := statusOf(t, tr, "python", ObjectiveSuccess); s.Executions != 3 {
+		t.Errorf("at 4m59s: executions = %d, want 3", s.Executions)
+	}
+	*now = now.Add(time.Second)
+	if s := statusOf(t, tr, "python", ObjectiveSuccess); s.Executions != 2 || s.Attainment != 1 {
+		t.Errorf("at 5m: %+v, want the failure evicted", s)
+	}
+
+	// A record landing on a slot the ring has come back round to clears it.
+	*now = now.Add(3 * time.Minute)
+	tr.Record("python", false, time.Second)
+	if s := statusOf(t, tr, "python", ObjectiveSuccess); s.Executions != 1 || s.Attainment != 0 {
+		t.Errorf("after wrapping: %+v", s)
+	}
+}
+
+func TestSLOTracker_Gauges(t *testing.T) {
+	m := NewMetrics()
+	tr, _ := newTestSLOTracker(time.Hour, time.Minute, SLOObjective{Language: "python", SuccessRate: 0.9})
+	m.TrackSLOs(tr)
+
+	m.RecordExecution(t.Context(), "docker", "python", state.Completed, 0.2, false, "")
+	m.RecordExecution(t.Context(), "docker", "python", state.Failed, 0.2, false, "")
+	m.RecordExecution(t.Context(), "docker", "python", state.Failed, 0.2, true, "")    // chaos
+	m.RecordExecution(t.Context(), "docker", "python", state.Rejected, 0.2, false, "") // caller's mistake
+
+	for name, want := range map[string]float64{"sandbox_slo_attainment": 0.5, "sandbox_slo_burn_rate": 5} {
+		metrics := gatherFamily(t, m, name).GetMetric()
+		if len(metrics) != 1 {
+			t.Fatalf("%s: %d series, want 1", name, len(metrics))
+		}
+		got := metrics[0].GetGauge().GetValue()
+		if l := labels(metrics[0]); l["language"] != "python" || l["objective"] != ObjectiveSuccess || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s%v = %g, want %g", name, l, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// SourceACL is the set of addresses the proxy accepts connections from: the
+// prefixes it was made with, and the ones its load func returns, which can
+// change while the proxy runs.
+type SourceACL struct {
+	static []netip.Prefix
+	load   func(ctx context.Context) ([]netip.Prefix, error)
+
+	mu     sync.RWMutex
+	loaded []netip.Prefix
+}
+
+// NewSourceACL returns an ACL of static and whatever load returns; load may
+// be nil. Nothing is loaded until Reload.
+func NewSourceACL(static []netip.Prefix, load func(ctx context.Context) ([]netip.Prefix, error)) *SourceACL {
+	return &SourceACL{static: static, load: load}
+}
+
+// Reload replaces the loaded prefixes with load's. When load fails the ones
+// it returned last are kept.
+func (a *SourceACL) Reload(ctx context.Context) error {
+	if a.load == nil {
+		return nil
+	}
+	prefixes, err := a.load(ctx)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loaded = prefixes
+	return nil
+}
+
+// Run reloads the ACL every interval until ctx is done, passing failures to
+// onError.
+func (a *SourceACL) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	if a.load == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Reload(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// allows reports whether remoteAddr, a request's "ip:port", is in the ACL.
+// An IPv4 address that arrives mapped into IPv6 is matched as IPv4, and an
+// IPv6 zone is ignored.
+func (a *SourceACL) allows(remoteAddr string) bool {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap().WithZone("")
+	for _, p := range a.static {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, p := range a.loaded {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
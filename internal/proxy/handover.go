@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+)
+
+// State is what a proxy hands to the one replacing it in an upgrade, so
+// that the containers started against it are served the same by its
+// successor: the shared secret they present, the certificate they trust
+// and the per-execution secrets still registered.
+type State struct {
+	Secret string            `json:"secret"`
+	CA     []byte            `json:"ca,omitempty"`   // PEM, when serving TLS
+	Cert   []byte            `json:"cert,omitempty"` // DER
+	Key    []byte            `json:"key,omitempty"`  // PKCS #8
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+// Registration is a per-execution secret registered, or revoked, after
+// State was handed over.
+type Registration struct {
+	Secret  string `json:"secret"`
+	Token   string `json:"token,omitempty"`
+	Revoked bool   `json:"revoked,omitempty"`
+}
+
+// SetListener makes Start serve on ln, a socket inherited in an upgrade,
+// rather than listen itself. Call it before Start.
+func (ap *AuthProxy) SetListener(ln net.Listener) {
+	ap.listener = ln
+}
+
+// Listener returns the socket Start is serving on, to hand to an upgrade.
+func (ap *AuthProxy) Listener() net.Listener {
+	return ap.listener
+}
+
+// StopAccepting closes the proxy's listener once an upgrade's new process
+// is serving on it too. The connections already accepted are served until
+// Close.
+func (ap *AuthProxy) StopAccepting() error {
+	return ap.listener.Close()
+}
+
+// HandOver sends the proxy's State to send, then a Registration for every
+// secret registered or revoked from then on, in order, until stop is
+// called. It stops if a send fails: the new process isn't reading, so is
+// gone or going.
+func (ap *AuthProxy) HandOver(send func(v any) error) (stop func(), err error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	st := State{Secret: ap.secret, CA: ap.caPEM, Tokens: maps.Clone(ap.tokens)}
+	if ap.cert != nil {
+		st.Cert = ap.cert.Certificate[0]
+		if st.Key, err = x509.MarshalPKCS8PrivateKey(ap.cert.PrivateKey); err != nil {
+			return nil, fmt.Errorf("handing over the proxy's key: %w", err)
+		}
+	}
+	if err := send(st); err != nil {
+		return nil, err
+	}
+	ap.follower = func(r Registration) {
+		if err := send(r); err != nil {
+			ap.follower = nil
+		}
+	}
+	return func() {
+		ap.mu.Lock()
+		defer ap.mu.Unlock()
+		ap.follower = nil
+	}, nil
+}
+
+// Inherit takes on what the proxy being replaced handed over: its
+// per-execution secrets, and its certificate, which UseTLS then serves
+// instead of making one. The shared secret is the caller's to pass to New.
+// Call it before Start.
+func (ap *AuthProxy) Inherit(st State) error {
+	if st.Cert != nil {
+		key, err := x509.ParsePKCS8PrivateKey(st.Key)
+		if err != nil {
+			return fmt.Errorf("inherited proxy key: %w", err)
+		}
+		ap.caPEM, ap.cert = st.CA, &tls.Certificate{Certificate: [][]byte{st.Cert}, PrivateKey: key}
+	}
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	if ap.tokens == nil {
+		ap.tokens = make(map[string]string)
+	}
+	ap.inherited = make(map[string]bool, len(st.Tokens))
+	for secret, token := range st.Tokens {
+		ap.tokens[secret] = token
+		ap.inherited[secret] = true
+	}
+	return nil
+}
+
+// Follow applies the Registrations receive returns, from the proxy this one
+// inherited from, until it returns an error: io.EOF once that proxy's
+// process has exited. The inherited secrets still registered then are
+// revoked, as nothing is left to revoke them. It returns receive's error
+// unless that is io.EOF. Call it after Inherit.
+func (ap *AuthProxy) Follow(receive func(v any) error) error {
+	for {
+		var r Registration
+		if err := receive(&r); err != nil {
+			ap.mu.Lock()
+			for secret := range ap.inherited {
+				delete(ap.tokens, secret)
+			}
+			ap.inherited = nil
+			ap.mu.Unlock()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		ap.mu.Lock()
+		if r.Revoked {
+			delete(ap.tokens, r.Secret)
+			delete(ap.inherited, r.Secret)
+		} else {
+			ap.tokens[r.Secret] = r.Token
+			ap.inherited[r.Secret] = true
+		}
+		ap.mu.Unlock()
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"testing"
+)
+
+// pipe carries messages between HandOver and Inherit or Follow as JSON, as
+// the handover pipe does; closing it is the old process exiting.
+type pipe chan []byte
+
+func (p pipe) send(v any) error {
+	b, err := json.Marshal(v)
+	p <- b
+	return err
+}
+
+func (p pipe) receive(v any) error {
+	b, ok := <-p
+	if !ok {
+		return io.EOF
+	}
+	return json.Unmarshal(b, v)
+}
+
+func TestAuthProxy_HandOver(t *testing.T) {
+	old := New(0, "server-token", "server-secret", TransportConfig{})
+	caPEM, err := old.UseTLS(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := old.Register("alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make(pipe, 16)
+	stop, err := old.HandOver(p.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State
+	if err := p.receive(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Secret != "server-secret" {
+		t.Errorf("handed over secret %q", st.Secret)
+	}
+	successor := New(0, "server-token", st.Secret, TransportConfig{})
+	if err := successor.Inherit(st); err != nil {
+		t.Fatal(err)
+	}
+	// Containers trusting the old CA trust the successor's certificate.
+	inheritedCA, err := successor.UseTLS(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inheritedCA, caPEM) || !bytes.Equal(successor.cert.Certificate[0], old.cert.Certificate[0]) {
+		t.Error("successor serves a certificate of its own")
+	}
+
+	// Registered before, during and after: the successor follows them all.
+	started, err := old.Register("bob-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Revoke(running)
+	stop()
+	unseen, err := old.Register("carol-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(p)
+	err = successor.Follow(func(v any) error {
+		err := p.receive(v)
+		if err == io.EOF {
+			// Everything the old process sent has been applied.
+			for secret, want := range map[string]bool{running: false, started: true, unseen: false} {
+				if _, ok := successor.registered(secret); ok != want {
+					t.Errorf("before the old process exited: secret %s registered %t, want %t", secret[:8], ok, want)
+				}
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := successor.registered(started); ok {
+		t.Error("inherited secret still registered once the old process exited")
+	}
+	if _, ok := successor.registered("server-secret"); ok {
+		t.Error("shared secret registered")
+	}
+}
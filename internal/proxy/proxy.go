@@ -2,14 +2,21 @@ package proxy
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
 )
 
 const anthropicHost = "api.anthropic.com"
@@ -25,30 +32,103 @@ type AuthProxy struct {
 	maxRPM      int           // global requests-per-minute cap (0 = unlimited)
 	windowCount atomic.Int64  // requests in current window
 	windowStart atomic.Int64  // unix seconds of current window start
+	throttles   ThrottleObserver
+	upstream    *url.URL
+	transport   http.RoundTripper // nil means http.DefaultTransport
+	pool        *http.Transport
+	acl         *SourceACL // nil accepts every source
+	execSecrets bool       // refuse secret; only secrets from Register are accepted
+
+	listener net.Listener     // what Start serves on; set before it when inherited in an upgrade
+	cert     *tls.Certificate // what UseTLS serves; set before it when inherited
+	caPEM    []byte           // the CA that signed cert
+
+	mu     sync.RWMutex
+	tokens map[string]string // per-execution secret -> token forwarded for it
+
+	// inherited are the secrets in tokens the proxy this one replaced handed
+	// over; follower is told of each Register and Revoke while this one
+	// hands over to another. Both are guarded by mu.
+	inherited map[string]bool
+	follower  func(Registration)
+}
+
+// ThrottleObserver counts the requests the proxy answers 429.
+type ThrottleObserver interface {
+	Throttled(scope string)
+}
+
+// SetThrottleObserver reports the proxy's 429s to o. Call it before Start.
+func (ap *AuthProxy) SetThrottleObserver(o ThrottleObserver) {
+	ap.throttles = o
 }
 
+// SetBind makes the proxy listen on ip rather than 127.0.0.1. Call it
+// before Start.
+func (ap *AuthProxy) SetBind(ip netip.Addr) {
+	_, port, _ := net.SplitHostPort(ap.addr)
+	ap.addr = net.JoinHostPort(ip.String(), port)
+	ap.server.Addr = ap.addr
+}
+
+// SetSourceACL refuses requests from addresses outside acl. Call it before
+// Start.
+func (ap *AuthProxy) SetSourceACL(acl *SourceACL) {
+	ap.acl = acl
+}
+
+// RequireExecutionSecrets refuses the shared secret: each execution must
+// present one of its own from Register, so a secret seen by one container
+// is no use once that execution ends. Call it before Start.
+func (ap *AuthProxy) RequireExecutionSecrets() {
+	ap.execSecrets = true
+}
+
+// tokenKey carries the token handleProxy picked to the Director.
+type tokenKey struct{}
+
 // New creates an AuthProxy that will listen on the given port and inject
 // the provided token as an x-api-key header on every forwarded request.
 // If secret is non-empty, incoming requests must present it as the x-api-key
 // header value (this is what Claude Code sends when ANTHROPIC_API_KEY is set
-// to the proxy secret inside the container).
-func New(port int, token, secret string) *AuthProxy {
-	return NewWithRPM(port, token, secret, 0)
+// to the proxy secret inside the container). tc tunes its connections to
+// the API and names the observer they are reported to.
+func New(port int, token, secret string, tc TransportConfig) *AuthProxy {
+	return NewWithRPM(port, token, secret, 0, tc)
 }
 
 // NewWithRPM creates an AuthProxy with a global requests-per-minute cap.
 // maxRPM of 0 means unlimited.
-func NewWithRPM(port int, token, secret string, maxRPM int) *AuthProxy {
+func NewWithRPM(port int, token, secret string, maxRPM int, tc TransportConfig) *AuthProxy {
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	pool := newTransport(tc, newDNSCache(tc.DNSCacheTTL))
 	ap := &AuthProxy{
-		token:  token,
-		secret: secret,
-		addr:   addr,
-		maxRPM: maxRPM,
+		token:     token,
+		secret:    secret,
+		addr:      addr,
+		maxRPM:    maxRPM,
+		upstream:  &url.URL{Scheme: "https", Host: anthropicHost},
+		transport: &tracedTransport{base: pool, observer: tc.Observer},
+		pool:      pool,
 	}
 
-	target := &url.URL{Scheme: "https", Host: anthropicHost}
+	rp := ap.reverseProxy(ap.upstream)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ap.handleProxy(rp))
+
+	ap.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return ap
+}
+
+// reverseProxy forwards to target with the token handleProxy picked in place
+// of whatever auth headers the caller sent.
+func (ap *AuthProxy) reverseProxy(target *url.URL) *httputil.ReverseProxy {
 	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Transport = ap.transport
 
 	// Customise the Director to set auth headers.
 	origDirector := rp.Director
@@ -58,39 +138,90 @@ func NewWithRPM(port int, token, secret string, maxRPM int) *AuthProxy {
 		r.Header.Del("x-api-key")
 		r.Header.Del("Authorization")
 		// Inject the real token.
-		r.Header.Set("x-api-key", ap.token)
+		token, ok := r.Context().Value(tokenKey{}).(string)
+		if !ok || token == "" {
+			token = ap.token
+		}
+		r.Header.Set("x-api-key", token)
 		r.Host = anthropicHost
 	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", ap.handleProxy(rp))
-
-	ap.server = &http.Server{
-		Addr:              addr,
-		Handler:           mux,
-		ReadHeaderTimeout: 10 * time.Second,
-	}
-	return ap
+	return rp
 }
 
-// handleProxy validates the shared secret and RPM limit before forwarding.
+// handleProxy validates the source, the shared secret and RPM limit before
+// forwarding. A secret from Register is accepted too, and forwards with its
+// token.
 func (ap *AuthProxy) handleProxy(rp *httputil.ReverseProxy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if ap.secret != "" {
-			presented := r.Header.Get("x-api-key")
-			if subtle.ConstantTimeCompare([]byte(presented), []byte(ap.secret)) != 1 {
-				http.Error(w, "forbidden", http.StatusForbidden)
-				return
-			}
+		if ap.acl != nil && !ap.acl.allows(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		presented := r.Header.Get("x-api-key")
+		token, registered := ap.registered(presented)
+		if !registered && (ap.execSecrets || ap.secret != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(ap.secret)) != 1) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
 		}
 		if ap.maxRPM > 0 && !ap.allowRequest() {
-			http.Error(w, `{"error":"proxy rate limit exceeded","code":"PROXY_RATE_LIMITED"}`, http.StatusTooManyRequests)
+			if ap.throttles != nil {
+				ap.throttles.Throttled(apierror.ScopeProxy)
+			}
+			apierror.WriteThrottled(w, r, apierror.New(apierror.CodeProxyRateLimited, "proxy rate limit exceeded"), apierror.Throttle{
+				Scope: apierror.ScopeProxy, RetryAfter: ap.windowReset(time.Now()), Limit: float64(ap.maxRPM),
+			})
 			return
 		}
+		if registered {
+			r = r.WithContext(context.WithValue(r.Context(), tokenKey{}, token))
+		}
 		rp.ServeHTTP(w, r)
 	}
 }
 
+// Register mints a per-execution secret that forwards with token instead of
+// the proxy's own, until Revoke; an empty token forwards with the proxy's
+// own. A container given the secret can bill the token's account through
+// the proxy but never sees the token.
+func (ap *AuthProxy) Register(token string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating execution secret: %w", err)
+	}
+	secret := hex.EncodeToString(b)
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	if ap.tokens == nil {
+		ap.tokens = make(map[string]string)
+	}
+	ap.tokens[secret] = token
+	if ap.follower != nil {
+		ap.follower(Registration{Secret: secret, Token: token})
+	}
+	return secret, nil
+}
+
+// Revoke forgets a secret from Register; requests presenting it are refused
+// from then on, unless the proxy requires no secret at all.
+func (ap *AuthProxy) Revoke(secret string) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	delete(ap.tokens, secret)
+	if ap.follower != nil {
+		ap.follower(Registration{Secret: secret, Revoked: true})
+	}
+}
+
+func (ap *AuthProxy) registered(secret string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	token, ok := ap.tokens[secret]
+	return token, ok
+}
+
 // allowRequest implements a sliding-window RPM counter. Returns true if the
 // request should be allowed.
 func (ap *AuthProxy) allowRequest() bool {
@@ -106,20 +237,45 @@ func (ap *AuthProxy) allowRequest() bool {
 	return count <= int64(ap.maxRPM)
 }
 
+// windowReset is how long until the current RPM window ends.
+func (ap *AuthProxy) windowReset(now time.Time) time.Duration {
+	return max(time.Unix(ap.windowStart.Load()+60, 0).Sub(now), 0)
+}
+
 // Start begins listening. It returns an error if the bind fails.
-// The server runs in a background goroutine.
+// The server runs in a background goroutine, and a connection to the API
+// is opened in another so the first execution doesn't wait for it; one
+// that can't be opened is left to the first request.
 func (ap *AuthProxy) Start() error {
-	ln, err := net.Listen("tcp", ap.addr)
-	if err != nil {
-		return fmt.Errorf("auth proxy listen: %w", err)
+	if ap.listener == nil {
+		l, err := net.Listen("tcp", ap.addr)
+		if err != nil {
+			return fmt.Errorf("auth proxy listen: %w", err)
+		}
+		ap.listener = l
+	}
+	ln := ap.listener
+	if ap.server.TLSConfig != nil {
+		ln = tls.NewListener(ln, ap.server.TLSConfig)
 	}
 	go func() {
 		_ = ap.server.Serve(ln) // returns on Close/Shutdown
 	}()
+	if ap.pool != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), warmTimeout)
+			defer cancel()
+			_ = ap.warm(ctx)
+		}()
+	}
 	return nil
 }
 
 // Close gracefully shuts down the proxy.
 func (ap *AuthProxy) Close(ctx context.Context) error {
-	return ap.server.Shutdown(ctx)
+	err := ap.server.Shutdown(ctx)
+	if ap.pool != nil {
+		ap.pool.CloseIdleConnections()
+	}
+	return err
 }
@@ -2,13 +2,20 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/api/apierror"
 )
 
 func TestAuthProxy_SecretValidation(t *testing.T) {
@@ -131,6 +138,51 @@ func TestAuthProxy_RateLimitExceeded(t *testing.T) {
 	if rec.Code != http.StatusTooManyRequests {
 		t.Errorf("got status %d, want 429 after exceeding RPM limit", rec.Code)
 	}
+	var resp apierror.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	ms, _ := resp.Details["retry_after_ms"].(float64)
+	header, _ := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if resp.Code != apierror.CodeProxyRateLimited || resp.Details["scope"] != apierror.ScopeProxy || resp.Details["limit"] != 3.0 {
+		t.Errorf("body %s", rec.Body)
+	}
+	if ms < 1000 || ms > 60000 || header != int(math.Ceil(ms/1000)) {
+		t.Errorf("Retry-After %q with retry_after_ms %g", rec.Header().Get("Retry-After"), ms)
+	}
+}
+
+type countingObserver map[string]int
+
+func (c countingObserver) Throttled(scope string) { c[scope]++ }
+
+func TestAuthProxy_ThrottleHint(t *testing.T) {
+	ap := &AuthProxy{maxRPM: 1}
+	start := time.Unix(1000, 0)
+	ap.windowStart.Store(start.Unix())
+	for _, tt := range []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{start, time.Minute},
+		{start.Add(45 * time.Second), 15 * time.Second},
+		{start.Add(2 * time.Minute), 0},
+	} {
+		if got := ap.windowReset(tt.now); got != tt.want {
+			t.Errorf("windowReset at +%s = %s, want %s", tt.now.Sub(start), got, tt.want)
+		}
+	}
+
+	observer := countingObserver{}
+	ap = &AuthProxy{maxRPM: 1}
+	ap.SetThrottleObserver(observer)
+	handler := ap.handleProxy(httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "127.0.0.1:1"}))
+	ap.windowStart.Store(time.Now().Unix())
+	ap.windowCount.Store(1)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/messages", nil))
+	if observer[apierror.ScopeProxy] != 1 {
+		t.Errorf("throttles observed = %v", observer)
+	}
 }
 
 func TestAuthProxy_StartAndClose(t *testing.T) {
@@ -142,7 +194,7 @@ func TestAuthProxy_StartAndClose(t *testing.T) {
 	port := ln.Addr().(*net.TCPAddr).Port
 	ln.Close()
 
-	ap := New(port, "tok", "sec")
+	ap := New(port, "tok", "sec", TransportConfig{})
 	if err := ap.Start(); err != nil {
 		t.Fatalf("Start: %v", err)
 	}
@@ -169,3 +221,186 @@ func TestAuthProxy_StartAndClose(t *testing.T) {
 		t.Error("expected connection error after Close, got nil")
 	}
 }
+
+func TestAuthProxy_RegisteredTokens(t *testing.T) {
+	var gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	ap := &AuthProxy{token: "server-token", secret: "server-secret"}
+	handler := ap.handleProxy(ap.reverseProxy(target))
+	send := func(presented string) int {
+		gotKey = ""
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", presented)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	alice, err := ap.Register("alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ap.Register("bob-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice == bob || alice == "server-secret" || len(alice) != 64 {
+		t.Fatalf("secrets %q and %q", alice, bob)
+	}
+
+	for _, tt := range []struct {
+		presented, wantKey string
+	}{
+		{alice, "alice-token"},
+		{bob, "bob-token"},
+		{"server-secret", "server-token"},
+	} {
+		if code := send(tt.presented); code != http.StatusOK || gotKey != tt.wantKey {
+			t.Errorf("presenting %q: status %d, upstream key %q, want %q", tt.presented, code, gotKey, tt.wantKey)
+		}
+	}
+
+	ap.Revoke(alice)
+	if code := send(alice); code != http.StatusForbidden || gotKey != "" {
+		t.Errorf("revoked secret: status %d, upstream key %q", code, gotKey)
+	}
+	if code := send(bob); code != http.StatusOK || gotKey != "bob-token" {
+		t.Errorf("after revoking another secret: status %d, upstream key %q", code, gotKey)
+	}
+	ap.Revoke(bob)
+	ap.Revoke(bob) // twice is harmless
+	if code := send(""); code != http.StatusForbidden {
+		t.Errorf("no key: status %d", code)
+	}
+}
+
+func TestAuthProxy_ExecutionSecretsOnly(t *testing.T) {
+	var gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	ap := &AuthProxy{token: "server-token", secret: "server-secret"}
+	ap.RequireExecutionSecrets()
+	handler := ap.handleProxy(ap.reverseProxy(target))
+	send := func(presented string) int {
+		gotKey = ""
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", presented)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := send("server-secret"); code != http.StatusForbidden {
+		t.Errorf("shared secret: status %d, want 403", code)
+	}
+	// An execution registered without a token of its own forwards with the
+	// server's.
+	secret, err := ap.Register("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := send(secret); code != http.StatusOK || gotKey != "server-token" {
+		t.Errorf("execution secret: status %d, upstream key %q", code, gotKey)
+	}
+	ap.Revoke(secret)
+	if code := send(secret); code != http.StatusForbidden {
+		t.Errorf("revoked execution secret: status %d", code)
+	}
+}
+
+func TestSourceACL(t *testing.T) {
+	bridge := []netip.Prefix{netip.MustParsePrefix("172.17.0.0/16")}
+	loadErr := error(nil)
+	acl := NewSourceACL([]netip.Prefix{netip.MustParsePrefix("10.1.2.0/24"), netip.MustParsePrefix("fd00::/8")},
+		func(context.Context) ([]netip.Prefix, error) { return bridge, loadErr })
+
+	tests := []struct {
+		remote       string
+		beforeReload bool
+		after        bool
+	}{
+		{"10.1.2.3:5000", true, true},
+		{"10.1.3.3:5000", false, false},
+		{"172.17.0.2:41000", false, true},
+		{"[::ffff:172.17.0.2]:41000", false, true}, // IPv4 arriving mapped into IPv6
+		{"[fd00::5%eth0]:80", true, true},
+		{"127.0.0.1:41000", false, false},
+		{"[::1]:41000", false, false},
+		{"not an address", false, false},
+		{"172.17.0.2", false, false}, // no port: not a RemoteAddr
+	}
+	for _, tt := range tests {
+		if got := acl.allows(tt.remote); got != tt.beforeReload {
+			t.Errorf("before reload: allows(%q) = %t", tt.remote, got)
+		}
+	}
+	if err := acl.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := acl.allows(tt.remote); got != tt.after {
+			t.Errorf("after reload: allows(%q) = %t", tt.remote, got)
+		}
+	}
+
+	// A failed reload keeps the subnets it read last; a good one replaces them.
+	loadErr = fmt.Errorf("daemon unreachable")
+	if err := acl.Reload(context.Background()); err == nil {
+		t.Fatal("reload didn't report the failure")
+	}
+	if !acl.allows("172.17.0.2:41000") {
+		t.Error("failed reload dropped the last subnets")
+	}
+	loadErr, bridge = nil, []netip.Prefix{netip.MustParsePrefix("172.18.0.0/16")}
+	if err := acl.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if acl.allows("172.17.0.2:41000") || !acl.allows("172.18.0.9:41000") {
+		t.Error("reload didn't replace the subnets")
+	}
+}
+
+func TestAuthProxy_SourceACL(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	ap := &AuthProxy{token: "server-token", secret: "server-secret"}
+	ap.SetSourceACL(NewSourceACL([]netip.Prefix{netip.MustParsePrefix("172.17.0.0/16")}, nil))
+	handler := ap.handleProxy(ap.reverseProxy(target))
+	for remote, want := range map[string]int{"172.17.0.4:40000": http.StatusOK, "192.168.1.20:40000": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("x-api-key", "server-secret") // the right secret from the wrong place
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != want {
+			t.Errorf("from %s: status %d, want %d", remote, rec.Code, want)
+		}
+	}
+}
+
+func TestAuthProxy_SetBind(t *testing.T) {
+	ap := New(8081, "tok", "sec", TransportConfig{})
+	ap.SetBind(netip.MustParseAddr("172.17.0.1"))
+	if ap.addr != "172.17.0.1:8081" || ap.server.Addr != ap.addr {
+		t.Errorf("addr %q, server addr %q", ap.addr, ap.server.Addr)
+	}
+	ap.SetBind(netip.MustParseAddr("fd00::1"))
+	if ap.addr != "[fd00::1]:8081" {
+		t.Errorf("IPv6 addr %q", ap.addr)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// ContainerHost is the name claude containers reach the proxy by.
+const ContainerHost = "host.docker.internal"
+
+// certValidity is how long the per-startup certificates are valid for. They
+// are thrown away when the server stops, long before.
+const certValidity = 365 * 24 * time.Hour
+
+// UseTLS makes Start serve TLS, with a certificate for ContainerHost,
+// localhost and ips signed by a CA generated for this proxy alone. It
+// returns the CA certificate in PEM for clients to trust; its key never
+// leaves memory. A proxy that inherited a certificate in an upgrade serves
+// that one instead, which its containers already trust. Call it before
+// Start.
+func (ap *AuthProxy) UseTLS(ips ...netip.Addr) ([]byte, error) {
+	if ap.cert == nil {
+		caPEM, leaf, err := newCertificates([]string{ContainerHost, "localhost"}, ips, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		ap.caPEM, ap.cert = caPEM, &leaf
+	}
+	ap.server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{*ap.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return ap.caPEM, nil
+}
+
+// newCertificates generates a CA and a server certificate it signs for hosts
+// and ips, returning the CA in PEM and the server certificate with its key.
+func newCertificates(hosts []string, ips []netip.Addr, now time.Time) ([]byte, tls.Certificate, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("generating proxy CA key: %w", err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "safe-agent-sandbox auth proxy CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("creating proxy CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("generating proxy key: %w", err)
+	}
+	cert := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, ip := range ips {
+		cert.IPAddresses = append(cert.IPAddresses, net.IP(ip.AsSlice()))
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("creating proxy certificate: %w", err)
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return caPEM, tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, nil
+}
+
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		// Since Go 1.24 crypto/rand doesn't return errors.
+		panic(err)
+	}
+	return n
+}
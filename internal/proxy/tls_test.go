@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAuthProxy_TLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	ap := New(port, "tok", "sec", TransportConfig{})
+	caPEM, err := ap.UseTLS(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer ap.Close(context.Background())
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("CA isn't PEM: %q", caPEM)
+	}
+	client := func(roots *x509.CertPool, serverName string) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: serverName}}}
+	}
+	url := fmt.Sprintf("https://127.0.0.1:%d/", port)
+
+	// Trusting the CA, the proxy is reached by its IP or by the name
+	// containers use; without the secret it still refuses.
+	for _, name := range []string{"", ContainerHost, "localhost"} {
+		resp, err := client(roots, name).Get(url)
+		if err != nil {
+			t.Fatalf("server name %q: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("server name %q: status %d, want 403", name, resp.StatusCode)
+		}
+	}
+
+	if _, err := client(roots, "api.anthropic.com").Get(url); err == nil {
+		t.Error("certificate accepted for a name it wasn't issued for")
+	}
+	if _, err := client(x509.NewCertPool(), "").Get(url); err == nil {
+		t.Error("handshake succeeded without trusting the CA")
+	}
+	if resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port)); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusForbidden {
+			t.Error("plain HTTP reached the handler")
+		}
+	}
+}
+
+func TestNewCertificates_FreshCA(t *testing.T) {
+	a, _, err := newCertificates([]string{ContainerHost}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := newCertificates([]string{ContainerHost}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) == string(b) {
+		t.Error("two startups share a CA")
+	}
+}
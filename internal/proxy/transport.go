@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// TransportConfig tunes the proxy's connections to the API. Zero durations
+// mean no limit, as in http.Transport, and a zero DNSCacheTTL turns the
+// cache off.
+type TransportConfig struct {
+	MaxIdleConns          int           // idle connections kept to the API; the claude concurrency
+	ResponseHeaderTimeout time.Duration // how long the API has to start answering
+	IdleConnTimeout       time.Duration // how long an unused connection is kept
+	DNSCacheTTL           time.Duration // how long a lookup of the API's host is reused
+	Observer              TransportObserver
+}
+
+// TransportObserver records how the proxy reaches the API: whether each
+// request got a pooled connection, how long new connections took to
+// handshake, and dials that failed.
+type TransportObserver interface {
+	UpstreamConn(reused bool)
+	UpstreamHandshake(d time.Duration)
+	UpstreamDialError()
+}
+
+// warmTimeout bounds the connection Start opens ahead of the first request.
+const warmTimeout = 10 * time.Second
+
+// newTransport returns the transport the proxy forwards with. Connections
+// are pooled and TLS sessions resumed, so an execution's first request
+// usually skips the handshake, and lookups go through a dnsCache.
+func newTransport(tc TransportConfig, cache *dnsCache) *http.Transport {
+	d := &upstreamDialer{
+		dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		cache:    cache,
+		observer: tc.Observer,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		ForceAttemptHTTP2:     true, // a custom DialContext turns it off otherwise
+		MaxIdleConns:          max(tc.MaxIdleConns, 1),
+		MaxIdleConnsPerHost:   max(tc.MaxIdleConns, 1),
+		IdleConnTimeout:       tc.IdleConnTimeout,
+		ResponseHeaderTimeout: tc.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ClientSessionCache: tls.NewLRUClientSessionCache(max(tc.MaxIdleConns, 1) * 4),
+		},
+	}
+}
+
+// tracedTransport reports each round trip's connection to observer.
+type tracedTransport struct {
+	base     http.RoundTripper
+	observer TransportObserver
+}
+
+func (t *tracedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.observer == nil {
+		return t.base.RoundTrip(r)
+	}
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.observer.UpstreamConn(info.Reused)
+		},
+		TLSHandshakeStart: func() {
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil && !handshakeStart.IsZero() {
+				t.observer.UpstreamHandshake(time.Since(handshakeStart))
+			}
+		},
+	}
+	return t.base.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+}
+
+// upstreamDialer dials through a dnsCache, trying each address in turn.
+type upstreamDialer struct {
+	dialer   *net.Dialer
+	cache    *dnsCache
+	observer TransportObserver
+}
+
+func (d *upstreamDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil && d.observer != nil {
+		d.observer.UpstreamDialError()
+	}
+	return conn, err
+}
+
+func (d *upstreamDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := d.cache.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	// None of the addresses answered; they may have moved.
+	d.cache.forget(host)
+	return nil, errors.Join(errs...)
+}
+
+// dnsCache keeps successful lookups for ttl. Failures aren't cached, and
+// the resolver doesn't report a record's TTL, so ttl should stay below
+// it. A ttl of 0 looks up every time.
+type dnsCache struct {
+	ttl    time.Duration
+	lookup func(ctx context.Context, host string) ([]string, error)
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		ttl:     ttl,
+		lookup:  net.DefaultResolver.LookupHost,
+		now:     time.Now,
+		entries: make(map[string]dnsEntry),
+	}
+}
+
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	if c.ttl > 0 {
+		c.mu.Lock()
+		e, ok := c.entries[host]
+		c.mu.Unlock()
+		if ok && c.now().Before(e.expires) {
+			return e.addrs, nil
+		}
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if c.ttl > 0 && len(addrs) > 0 {
+		c.mu.Lock()
+		c.entries[host] = dnsEntry{addrs: addrs, expires: c.now().Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+func (c *dnsCache) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host)
+}
+
+// warm looks up the API's host and opens a connection to it, left in the
+// pool for the first execution's request. The HEAD request carries no key.
+func (ap *AuthProxy) warm(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ap.upstream.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ap.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
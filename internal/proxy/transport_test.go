@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+type transportRecorder struct {
+	mu         sync.Mutex
+	conns      []bool // reused, per request
+	handshakes int
+	dialErrors int
+}
+
+func (r *transportRecorder) UpstreamConn(reused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns = append(r.conns, reused)
+}
+
+func (r *transportRecorder) UpstreamHandshake(time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handshakes++
+}
+
+func (r *transportRecorder) UpstreamDialError() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dialErrors++
+}
+
+// fakeResolver answers every host with addrs and counts the lookups.
+type fakeResolver struct {
+	mu      sync.Mutex
+	addrs   []string
+	err     error
+	lookups int
+}
+
+func (f *fakeResolver) lookup(_ context.Context, host string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	return f.addrs, f.err
+}
+
+func TestAuthProxy_WarmConnectionIsReused(t *testing.T) {
+	var gotKey string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+
+	// The test server's certificate is for example.com, which the fake
+	// resolver sends to it.
+	resolver := &fakeResolver{addrs: []string{"127.0.0.1"}}
+	cache := newDNSCache(time.Minute)
+	cache.lookup = resolver.lookup
+	obs := &transportRecorder{}
+	pool := newTransport(TransportConfig{MaxIdleConns: 2, DNSCacheTTL: time.Minute, Observer: obs}, cache)
+	pool.TLSClientConfig.RootCAs = upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	defer pool.CloseIdleConnections()
+	ap := &AuthProxy{
+		token:     "real-token",
+		upstream:  &url.URL{Scheme: "https", Host: net.JoinHostPort("example.com", port)},
+		transport: &tracedTransport{base: pool, observer: obs},
+		pool:      pool,
+	}
+
+	if err := ap.warm(context.Background()); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+
+	// The first forwarded request finds the warm connection waiting.
+	var reused []bool
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) }}
+	handler := ap.handleProxy(ap.reverseProxy(ap.upstream))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK || gotKey != "real-token" {
+			t.Fatalf("request %d: status %d, upstream key %q", i, rec.Code, gotKey)
+		}
+	}
+	if len(reused) != 2 || !reused[0] || !reused[1] {
+		t.Errorf("forwarded requests reused = %v, want both on the warm connection", reused)
+	}
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if len(obs.conns) != 3 || obs.conns[0] || !obs.conns[1] || !obs.conns[2] {
+		t.Errorf("observed conns %v, want one new for warm, then reused", obs.conns)
+	}
+	if obs.handshakes != 1 || obs.dialErrors != 0 {
+		t.Errorf("observed %d handshakes, %d dial errors; want 1 and 0", obs.handshakes, obs.dialErrors)
+	}
+	if resolver.lookups != 1 {
+		t.Errorf("resolver asked %d times, want once", resolver.lookups)
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	resolver := &fakeResolver{addrs: []string{"192.0.2.1"}}
+	now := time.Unix(1000, 0)
+	c := newDNSCache(30 * time.Second)
+	c.lookup = resolver.lookup
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if addrs, err := c.resolve(ctx, "api.example"); err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Fatalf("resolve: %v, %v", addrs, err)
+		}
+	}
+	if resolver.lookups != 1 {
+		t.Errorf("second lookup within the TTL reached the resolver: %d lookups", resolver.lookups)
+	}
+
+	now = now.Add(30 * time.Second)
+	c.resolve(ctx, "api.example")
+	if resolver.lookups != 2 {
+		t.Errorf("lookup after the TTL: %d lookups, want 2", resolver.lookups)
+	}
+
+	c.forget("api.example")
+	resolver.err = errors.New("no such host")
+	for i := 0; i < 2; i++ {
+		if _, err := c.resolve(ctx, "api.example"); err == nil {
+			t.Error("a failed lookup was answered")
+		}
+	}
+	if resolver.lookups != 4 {
+		t.Errorf("failed lookups: %d lookups, want each one asked; failures aren't cached", resolver.lookups)
+	}
+
+	resolver.err = nil
+	c.ttl = 0
+	c.resolve(ctx, "api.example")
+	c.resolve(ctx, "api.example")
+	if resolver.lookups != 6 {
+		t.Errorf("ttl 0: %d lookups, want every one asked", resolver.lookups)
+	}
+}
+
+func TestUpstreamDialer_Failure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	resolver := &fakeResolver{addrs: []string{"127.0.0.1"}}
+	cache := newDNSCache(time.Minute)
+	cache.lookup = resolver.lookup
+	obs := &transportRecorder{}
+	d := &upstreamDialer{dialer: &net.Dialer{Timeout: time.Second}, cache: cache, observer: obs}
+
+	if _, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example", port)); err == nil {
+		t.Fatal("dialled a closed port")
+	}
+	if obs.dialErrors != 1 {
+		t.Errorf("observed %d dial errors, want 1", obs.dialErrors)
+	}
+	// The addresses that didn't answer are looked up again next time.
+	d.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example", port))
+	if resolver.lookups != 2 {
+		t.Errorf("%d lookups, want the failed answer forgotten", resolver.lookups)
+	}
+}
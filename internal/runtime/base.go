@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // Runtime defines how to execute code for a specific language.
@@ -22,49 +24,181 @@ type Runtime interface {
 	// Validate checks if the code is syntactically acceptable before execution.
 	// This is a best-effort pre-check, not a full parser.
 	Validate(code string) error
+
+	// DefaultLimits returns the limits an execution gets when its request
+	// sets none.
+	DefaultLimits() Limits
+}
+
+// NetworkAware is implemented by runtimes whose command line depends on
+// whether the sandbox grants network access (e.g. Deno's --allow-net).
+type NetworkAware interface {
+	NetworkCommand(codePath string, networkEnabled bool) []string
+}
+
+// Environ is implemented by runtimes whose programs need env vars of their
+// own, such as where a compiler may keep its cache on a read-only rootfs.
+// They come before the request's, which may override them.
+type Environ interface {
+	Env(networkEnabled bool) []string
+}
+
+// Aliased is implemented by runtimes that accept other names, such as py
+// for python. Aliases, like names, are lowercase; requests may use any case.
+type Aliased interface {
+	Aliases() []string
+}
+
+// CommandFor returns the command for rt, consulting NetworkAware when implemented.
+func CommandFor(rt Runtime, codePath string, networkEnabled bool) []string {
+	if na, ok := rt.(NetworkAware); ok {
+		return na.NetworkCommand(codePath, networkEnabled)
+	}
+	return rt.Command(codePath)
+}
+
+// EnvFor returns the env vars of rt's programs, none unless it implements
+// Environ.
+func EnvFor(rt Runtime, networkEnabled bool) []string {
+	if e, ok := rt.(Environ); ok {
+		return e.Env(networkEnabled)
+	}
+	return nil
 }
 
 // Registry maps language names to their Runtime implementations.
 type Registry struct {
 	runtimes map[string]Runtime
+	aliases  map[string]string   // alias to the name it stands for
+	warmup   map[string][]string // enabled warmup options by language; see SetWarmup
+	limits   map[string]Limits   // overridden default limits by language; see SetLimits
 }
 
 // NewRegistry creates a registry with all supported runtimes.
 func NewRegistry() *Registry {
 	r := &Registry{
 		runtimes: make(map[string]Runtime),
+		aliases:  make(map[string]string),
 	}
 	r.Register(&PythonRuntime{})
 	r.Register(&NodeRuntime{})
 	r.Register(&BashRuntime{})
 	r.Register(&GoRuntime{})
+	r.Register(&RubyRuntime{})
+	r.Register(&DenoRuntime{})
+	r.Register(&BunRuntime{})
+	r.Register(&BinaryRuntime{})
 	r.Register(&ClaudeRuntime{})
 	return r
 }
 
-// Register adds a runtime to the registry.
+// Register adds a runtime to the registry. A name or alias already taken
+// by another runtime panics: the registry is built at startup, so a
+// collision is a bug to catch there rather than a request to misroute.
 func (r *Registry) Register(rt Runtime) {
-	r.runtimes[rt.Name()] = rt
+	name := rt.Name()
+	names := []string{name}
+	if a, ok := rt.(Aliased); ok {
+		names = append(names, a.Aliases()...)
+	}
+	for _, n := range names {
+		if owner, ok := r.lookup(n); ok {
+			panic(fmt.Sprintf("runtime: %s: %q is already taken by %s", name, n, owner))
+		}
+	}
+	r.runtimes[name] = rt
+	for _, alias := range names[1:] {
+		r.aliases[alias] = name
+	}
+}
+
+// lookup resolves an exact name or alias.
+func (r *Registry) lookup(language string) (string, bool) {
+	if _, ok := r.runtimes[language]; ok {
+		return language, true
+	}
+	name, ok := r.aliases[language]
+	return name, ok
+}
+
+// Canonical resolves a language name or alias to the runtime's name,
+// ignoring case and surrounding space: "Py" and "python3" are both python.
+func (r *Registry) Canonical(language string) (string, bool) {
+	return r.lookup(strings.ToLower(strings.TrimSpace(language)))
 }
 
-// Get returns the runtime for the given language.
+// Get returns the runtime for the given language name or alias.
 func (r *Registry) Get(language string) (Runtime, error) {
-	rt, ok := r.runtimes[language]
+	name, ok := r.Canonical(language)
 	if !ok {
-		return nil, fmt.Errorf("unsupported language: %q (supported: python, node, bash, go, claude)", language)
+		return nil, fmt.Errorf("unsupported language: %q (supported: %s)", language, r.Supported())
 	}
-	return rt, nil
+	return r.runtimes[name], nil
 }
 
-// Languages returns all registered language names.
+// AliasesOf returns the aliases registered for a language name, sorted.
+func (r *Registry) AliasesOf(language string) []string {
+	var aliases []string
+	for alias, name := range r.aliases {
+		if name == language {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// Supported lists the languages with their aliases for error messages,
+// e.g. "bash (sh, shell), bun, ...".
+func (r *Registry) Supported() string {
+	langs := r.Languages()
+	for i, lang := range langs {
+		if aliases := r.AliasesOf(lang); len(aliases) > 0 {
+			langs[i] = fmt.Sprintf("%s (%s)", lang, strings.Join(aliases, ", "))
+		}
+	}
+	return strings.Join(langs, ", ")
+}
+
+// Languages returns all registered language names, sorted.
 func (r *Registry) Languages() []string {
 	langs := make([]string, 0, len(r.runtimes))
 	for name := range r.runtimes {
 		langs = append(langs, name)
 	}
+	sort.Strings(langs)
+	return langs
+}
+
+// LanguagesForExtension returns the sorted languages whose code files use ext.
+// More than one result means the extension alone is ambiguous (.ts: deno, bun).
+func (r *Registry) LanguagesForExtension(ext string) []string {
+	var langs []string
+	for name, rt := range r.runtimes {
+		if rt.FileExtension() == ext {
+			langs = append(langs, name)
+		}
+	}
+	sort.Strings(langs)
 	return langs
 }
 
+// OverrideImages replaces the container image for the given languages.
+// Unknown languages are an error so config typos don't go unnoticed.
+func (r *Registry) OverrideImages(images map[string]string) error {
+	for lang, image := range images {
+		rt, ok := r.runtimes[lang]
+		if !ok {
+			return fmt.Errorf("runtime image override for unknown language %q", lang)
+		}
+		if image == "" {
+			return fmt.Errorf("runtime image override for %q is empty", lang)
+		}
+		r.runtimes[lang] = &imageOverride{Runtime: rt, image: image}
+	}
+	return nil
+}
+
 // Images returns all container images needed by registered runtimes.
 func (r *Registry) Images() []string {
 	images := make([]string, 0, len(r.runtimes))
@@ -73,3 +207,19 @@ func (r *Registry) Images() []string {
 	}
 	return images
 }
+
+// imageOverride wraps a Runtime to substitute a configured image.
+type imageOverride struct {
+	Runtime
+	image string
+}
+
+func (o *imageOverride) Image() string { return o.image }
+
+func (o *imageOverride) NetworkCommand(codePath string, networkEnabled bool) []string {
+	return CommandFor(o.Runtime, codePath, networkEnabled)
+}
+
+func (o *imageOverride) Env(networkEnabled bool) []string {
+	return EnvFor(o.Runtime, networkEnabled)
+}
@@ -0,0 +1,146 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_GetErrorListsLanguages(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.Get("rust")
+	if err == nil {
+		t.Fatal("Get(rust) should fail")
+	}
+	for _, lang := range r.Languages() {
+		if !strings.Contains(err.Error(), lang) {
+			t.Errorf("error %q does not list %q", err, lang)
+		}
+	}
+	for _, want := range []string{"bash (sh, shell)", "node (javascript, js, nodejs)", "python (py, python3)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not list %q", err, want)
+		}
+	}
+}
+
+func TestRegistry_Aliases(t *testing.T) {
+	r := NewRegistry()
+	tests := map[string]string{
+		"python":     "python",
+		"py":         "python",
+		"Python3":    "python",
+		" PY ":       "python",
+		"js":         "node",
+		"JavaScript": "node",
+		"nodejs":     "node",
+		"sh":         "bash",
+		"SHELL":      "bash",
+		"deno":       "deno",
+		"Claude":     "claude",
+		"typescript": "",
+		"python3.12": "",
+		"":           "",
+	}
+	for lang, want := range tests {
+		rt, err := r.Get(lang)
+		switch {
+		case want == "" && err == nil:
+			t.Errorf("Get(%q) = %s, want an error", lang, rt.Name())
+		case want != "" && (err != nil || rt.Name() != want):
+			t.Errorf("Get(%q) = %v, %v; want %s", lang, rt, err, want)
+		}
+	}
+	if got := r.AliasesOf("node"); strings.Join(got, ",") != "javascript,js,nodejs" {
+		t.Errorf("AliasesOf(node) = %v", got)
+	}
+	if got := r.Languages(); strings.Join(got, ",") != "bash,binary,bun,claude,deno,go,node,python,ruby" {
+		t.Errorf("Languages() = %v, want names only", got)
+	}
+}
+
+type aliasedRuntime struct {
+	BashRuntime
+	name    string
+	aliases []string
+}
+
+func (a *aliasedRuntime) Name() string      { return a.name }
+func (a *aliasedRuntime) Aliases() []string { return a.aliases }
+
+func TestRegistry_AliasCollision(t *testing.T) {
+	tests := []struct {
+		rt   Runtime
+		want string
+	}{
+		{&aliasedRuntime{name: "zsh", aliases: []string{"sh"}}, `zsh: "sh" is already taken by bash`},
+		{&aliasedRuntime{name: "pypy", aliases: []string{"python"}}, `pypy: "python" is already taken by python`},
+		{&aliasedRuntime{name: "py", aliases: nil}, `py: "py" is already taken by python`},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if msg, _ := recover().(string); !strings.Contains(msg, tt.want) {
+					t.Errorf("Register(%s) panicked with %q, want %q", tt.rt.Name(), msg, tt.want)
+				}
+			}()
+			NewRegistry().Register(tt.rt)
+		}()
+	}
+
+	r := NewRegistry()
+	r.Register(&aliasedRuntime{name: "zsh", aliases: []string{"z"}})
+	if rt, err := r.Get("Z"); err != nil || rt.Name() != "zsh" {
+		t.Errorf("Get(Z) = %v, %v", rt, err)
+	}
+}
+
+func TestRegistry_LanguagesForExtension(t *testing.T) {
+	r := NewRegistry()
+
+	if got := r.LanguagesForExtension(".ts"); strings.Join(got, ",") != "bun,deno" {
+		t.Errorf("LanguagesForExtension(.ts) = %v, want [bun deno]", got)
+	}
+	if got := r.LanguagesForExtension(".py"); strings.Join(got, ",") != "python" {
+		t.Errorf("LanguagesForExtension(.py) = %v, want [python]", got)
+	}
+	if got := r.LanguagesForExtension(".rs"); len(got) != 0 {
+		t.Errorf("LanguagesForExtension(.rs) = %v, want none", got)
+	}
+}
+
+func TestRegistry_OverrideImages(t *testing.T) {
+	r := NewRegistry()
+	if err := r.OverrideImages(map[string]string{"deno": "registry.local/deno:pinned"}); err != nil {
+		t.Fatalf("OverrideImages() = %v", err)
+	}
+
+	rt, err := r.Get("deno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.Image() != "registry.local/deno:pinned" {
+		t.Errorf("Image() = %q, want override", rt.Image())
+	}
+	if rt.Name() != "deno" {
+		t.Errorf("Name() = %q, want deno", rt.Name())
+	}
+	// The wrapper must keep network-aware command selection.
+	if cmd := CommandFor(rt, "/workspace/code.ts", true); !contains(cmd, "--allow-net") {
+		t.Errorf("CommandFor(override, network) = %v, want --allow-net", cmd)
+	}
+
+	if err := r.OverrideImages(map[string]string{"cobol": "x"}); err == nil {
+		t.Error("override for unknown language should fail")
+	}
+	if err := r.OverrideImages(map[string]string{"bun": ""}); err == nil {
+		t.Error("empty override image should fail")
+	}
+}
+
+func TestCommandFor_PlainRuntime(t *testing.T) {
+	b := &BashRuntime{}
+	got := CommandFor(b, "/workspace/code.sh", true)
+	if strings.Join(got, " ") != strings.Join(b.Command("/workspace/code.sh"), " ") {
+		t.Errorf("CommandFor(bash) = %v, want Command() output", got)
+	}
+}
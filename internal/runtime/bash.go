@@ -7,6 +7,8 @@ type BashRuntime struct{}
 
 func (b *BashRuntime) Name() string { return "bash" }
 
+func (b *BashRuntime) Aliases() []string { return []string{"sh", "shell"} }
+
 func (b *BashRuntime) Image() string { return "docker.io/library/alpine:3.19" }
 
 func (b *BashRuntime) Command(codePath string) []string {
@@ -20,6 +22,14 @@ func (b *BashRuntime) Command(codePath string) []string {
 
 func (b *BashRuntime) FileExtension() string { return ".sh" }
 
+// DefaultLimits gives shell scripts half the base memory: they mostly run
+// small tools, and the rest of the reservation would go unused.
+func (b *BashRuntime) DefaultLimits() Limits {
+	l := baseLimits()
+	l.MemoryMB = 128
+	return l
+}
+
 func (b *BashRuntime) Validate(code string) error {
 	if len(code) == 0 {
 		return fmt.Errorf("empty code")
@@ -0,0 +1,31 @@
+package runtime
+
+import "fmt"
+
+// BinaryRuntime runs a pre-compiled executable directly, with no
+// interpreter or toolchain in its image. The code is the executable
+// itself; the sandbox checks it is one before mounting it.
+type BinaryRuntime struct{}
+
+func (b *BinaryRuntime) Name() string { return "binary" }
+
+// Image has /bin/sh and sha256sum for the seccomp and code integrity
+// wrappers. A scratch or distroless image can replace it through
+// sandbox.runtime_images, with both wrappers turned off.
+func (b *BinaryRuntime) Image() string { return "docker.io/library/busybox:1.37-musl" }
+
+func (b *BinaryRuntime) Command(codePath string) []string {
+	return []string{codePath}
+}
+
+// FileExtension is empty: executables have none.
+func (b *BinaryRuntime) FileExtension() string { return "" }
+
+func (b *BinaryRuntime) DefaultLimits() Limits { return baseLimits() }
+
+func (b *BinaryRuntime) Validate(code string) error {
+	if len(code) == 0 {
+		return fmt.Errorf("empty executable")
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package runtime
+
+import "testing"
+
+func TestBinaryRuntime_Command(t *testing.T) {
+	b := &BinaryRuntime{}
+	cmd := b.Command("/workspace/code")
+	if len(cmd) != 1 || cmd[0] != "/workspace/code" {
+		t.Errorf("Command() = %v, want [/workspace/code]", cmd)
+	}
+}
+
+func TestBinaryRuntime_Validate(t *testing.T) {
+	b := &BinaryRuntime{}
+	if err := b.Validate("\x7fELF"); err != nil {
+		t.Errorf("Validate(executable) = %v, want nil", err)
+	}
+	if err := b.Validate(""); err == nil {
+		t.Error("Validate(empty) should return error")
+	}
+}
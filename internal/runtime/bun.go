@@ -0,0 +1,28 @@
+package runtime
+
+import "fmt"
+
+// BunRuntime configures execution of TypeScript/JavaScript under Bun.
+type BunRuntime struct{}
+
+func (b *BunRuntime) Name() string { return "bun" }
+
+func (b *BunRuntime) Image() string { return "docker.io/oven/bun:1.1-alpine" }
+
+func (b *BunRuntime) Command(codePath string) []string {
+	return []string{"bun", "run", codePath}
+}
+
+func (b *BunRuntime) FileExtension() string { return ".ts" }
+
+func (b *BunRuntime) DefaultLimits() Limits { return baseLimits() }
+
+func (b *BunRuntime) Validate(code string) error {
+	if len(code) == 0 {
+		return fmt.Errorf("empty code")
+	}
+	if len(code) > 1<<20 {
+		return fmt.Errorf("code too large: %d bytes (max 1MB)", len(code))
+	}
+	return nil
+}
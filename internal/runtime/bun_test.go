@@ -0,0 +1,28 @@
+package runtime
+
+import "testing"
+
+func TestBunRuntime_Command(t *testing.T) {
+	b := &BunRuntime{}
+	cmd := b.Command("/workspace/code.ts")
+	if len(cmd) != 3 || cmd[0] != "bun" || cmd[1] != "run" || cmd[2] != "/workspace/code.ts" {
+		t.Errorf("Command() = %v, want [bun run /workspace/code.ts]", cmd)
+	}
+}
+
+func TestBunRuntime_FileExtension(t *testing.T) {
+	b := &BunRuntime{}
+	if b.FileExtension() != ".ts" {
+		t.Errorf("FileExtension() = %q, want %q", b.FileExtension(), ".ts")
+	}
+}
+
+func TestBunRuntime_Validate(t *testing.T) {
+	b := &BunRuntime{}
+	if err := b.Validate(`console.log("hi")`); err != nil {
+		t.Errorf("Validate(valid code) = %v, want nil", err)
+	}
+	if err := b.Validate(""); err == nil {
+		t.Error("Validate(empty) should return error")
+	}
+}
@@ -1,25 +1,77 @@
 package runtime
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ClaudeRuntime struct{}
 
+// ClaudeOptions restrict a claude session. Each set field becomes the
+// matching Claude Code CLI flag; zero values leave claude's own defaults.
+type ClaudeOptions struct {
+	AllowedTools    []string `json:"allowed_tools,omitempty"`
+	DisallowedTools []string `json:"disallowed_tools,omitempty"`
+	MaxTurns        int      `json:"max_turns,omitempty"`
+	Model           string   `json:"model,omitempty"`
+}
+
 func (c *ClaudeRuntime) Name() string { return "claude" }
 
 func (c *ClaudeRuntime) Image() string { return "sandbox-claude:latest" }
 
 func (c *ClaudeRuntime) Command(codePath string) []string {
-	// Use positional params ($1) instead of string interpolation for defense in depth.
-	// codePath is our temp file so low risk, but this prevents any shell metacharacter issues.
-	return []string{
+	return c.CommandWithOptions(codePath, ClaudeOptions{})
+}
+
+// CommandWithOptions is Command with the flags for opts appended to the
+// claude invocation.
+func (c *ClaudeRuntime) CommandWithOptions(codePath string, opts ClaudeOptions) []string {
+	// Use positional params instead of string interpolation for defense in depth.
+	// codePath is our temp file so low risk, but this prevents any shell metacharacter
+	// issues, and the flags reach claude as separate words however they're spelled.
+	return append([]string{
 		"sh", "-c",
-		`cat "$1" | claude -p --dangerously-skip-permissions --output-format text`,
+		`f="$1"; shift; cat "$f" | claude -p --dangerously-skip-permissions --output-format stream-json --verbose "$@"`,
 		"_", codePath,
+	}, opts.Flags()...)
+}
+
+// Flags returns the Claude Code CLI flags for o.
+func (o ClaudeOptions) Flags() []string {
+	var flags []string
+	if o.Model != "" {
+		flags = append(flags, "--model", o.Model)
+	}
+	if o.MaxTurns > 0 {
+		flags = append(flags, "--max-turns", fmt.Sprint(o.MaxTurns))
 	}
+	// Both tool flags take a list; each tool is its own word so specs with
+	// spaces, like "Bash(git log:*)", stay intact.
+	if len(o.AllowedTools) > 0 {
+		flags = append(append(flags, "--allowedTools"), o.AllowedTools...)
+	}
+	if len(o.DisallowedTools) > 0 {
+		flags = append(append(flags, "--disallowedTools"), o.DisallowedTools...)
+	}
+	return flags
+}
+
+// ClaudeToolName is the tool a Claude Code tool spec refers to: "Bash" for
+// "Bash(git log:*)".
+func ClaudeToolName(spec string) string {
+	name, _, _ := strings.Cut(spec, "(")
+	return strings.TrimSpace(name)
 }
 
 func (c *ClaudeRuntime) FileExtension() string { return ".txt" }
 
+// DefaultLimits is the dev tier, sandbox.DevLimits: claude runs toolchains
+// and test suites, not a snippet.
+func (c *ClaudeRuntime) DefaultLimits() Limits {
+	return Limits{CPUShares: 4096, MemoryMB: 4096, PidsLimit: 500, DiskMB: 2048}
+}
+
 func (c *ClaudeRuntime) Validate(code string) error {
 	if len(code) == 0 {
 		return fmt.Errorf("empty prompt")
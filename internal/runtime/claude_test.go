@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClaudeCommandWithOptions(t *testing.T) {
+	c := &ClaudeRuntime{}
+	base := c.Command("/sandbox/prompt.txt")
+	if !reflect.DeepEqual(c.CommandWithOptions("/sandbox/prompt.txt", ClaudeOptions{}), base) {
+		t.Errorf("empty options should give the plain command %v", base)
+	}
+	if got := base[len(base)-1]; got != "/sandbox/prompt.txt" {
+		t.Errorf("code path must be the last word without options, got %q", got)
+	}
+
+	argv := c.CommandWithOptions("/sandbox/prompt.txt", ClaudeOptions{
+		AllowedTools:    []string{"Read", "Bash(git log:*)"},
+		DisallowedTools: []string{"WebSearch"},
+		MaxTurns:        8,
+		Model:           "claude-sonnet-4-5",
+	})
+	want := append(append([]string{}, base...),
+		"--model", "claude-sonnet-4-5",
+		"--max-turns", "8",
+		"--allowedTools", "Read", "Bash(git log:*)",
+		"--disallowedTools", "WebSearch",
+	)
+	if !reflect.DeepEqual(argv, want) {
+		t.Errorf("argv = %q\nwant   %q", argv, want)
+	}
+}
+
+func TestClaudeToolName(t *testing.T) {
+	for spec, want := range map[string]string{
+		"Bash":                         "Bash",
+		"Bash(git log:*)":              "Bash",
+		"WebFetch(domain:example.com)": "WebFetch",
+	} {
+		if got := ClaudeToolName(spec); got != want {
+			t.Errorf("ClaudeToolName(%q) = %q, want %q", spec, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+)
+
+// CompileCachePath is where sandbox.compile_cache mounts an execution's
+// entry: read-only when it holds the code's compiled form already, and
+// otherwise an empty directory the runtime writes it to.
+const CompileCachePath = "/run/sandbox/compiled"
+
+// CompileCacher is implemented by runtimes whose compiled form of the code
+// can be kept between executions of the same code.
+type CompileCacher interface {
+	// CompileCacheUsable reports whether an image, as its ImageProbe found
+	// it, can use the cache.
+	CompileCacheUsable(probe ImageProbe) bool
+
+	// CompileCacheCommand is argv, the runtime's command for codePath,
+	// reading the compiled code from CompileCachePath when it is there and
+	// writing it there when it isn't, plus the env vars that needs.
+	CompileCacheCommand(argv []string, codePath string) (args, env []string)
+}
+
+// AsCompileCacher returns rt as a CompileCacher, looking through image
+// overrides.
+func AsCompileCacher(rt Runtime) (CompileCacher, bool) {
+	if o, ok := rt.(*imageOverride); ok {
+		rt = o.Runtime
+	}
+	c, ok := rt.(CompileCacher)
+	return c, ok
+}
+
+// pythonCompiledFile is the bytecode the launcher keeps in
+// CompileCachePath.
+const pythonCompiledFile = CompileCachePath + "/code.pyc"
+
+// pythonLauncher runs the script in argv[1] as python would, from the
+// bytecode in pythonCompiledFile when it was compiled by this interpreter
+// and otherwise compiling it and trying to write that. Python only caches
+// the modules a program imports, never the script itself. The launcher's
+// names are gone from __main__ before the code runs; a traceback has one
+// frame of it at the top.
+var pythonLauncher = fmt.Sprintf(`import sys
+def _sandbox_load(path, pyc):
+    import marshal
+    from importlib.util import MAGIC_NUMBER
+    try:
+        with open(pyc, "rb") as f:
+            data = f.read()
+        if data.startswith(MAGIC_NUMBER):
+            return marshal.loads(data[len(MAGIC_NUMBER):])
+    except (OSError, EOFError, ValueError, TypeError):
+        pass
+    with open(path, "rb") as f:
+        code = compile(f.read(), path, "exec", dont_inherit=True)
+    try:
+        with open(pyc, "wb") as f:
+            f.write(MAGIC_NUMBER + marshal.dumps(code))
+    except OSError:
+        pass
+    return code
+_sandbox_code = _sandbox_load(sys.argv[1], %q)
+del sys.argv[0], _sandbox_load
+sys.path[0] = sys.argv[0].rpartition("/")[0] or "."
+__file__ = sys.argv[0]
+del sys
+exec(globals().pop("_sandbox_code"))`, pythonCompiledFile)
+
+// CompileCacheUsable holds for any python: the launcher needs nothing of
+// the image.
+func (p *PythonRuntime) CompileCacheUsable(ImageProbe) bool { return true }
+
+// CompileCacheCommand runs the script through pythonLauncher, keeping the
+// interpreter's flags and the script's args.
+func (p *PythonRuntime) CompileCacheCommand(argv []string, codePath string) ([]string, []string) {
+	i := slices.Index(argv, codePath)
+	if i < 0 {
+		return argv, nil
+	}
+	return slices.Insert(slices.Clone(argv), i, "-c", pythonLauncher), nil
+}
+
+// CompileCacheUsable needs node's own compile cache, which arrived in 22.1.
+func (n *NodeRuntime) CompileCacheUsable(probe ImageProbe) bool {
+	return nodeAtLeast(probe.Version, 22, 1)
+}
+
+// CompileCacheCommand points NODE_COMPILE_CACHE at the mount: node reads
+// the code's V8 cache from there, and writes it on exit when it is missing.
+func (n *NodeRuntime) CompileCacheCommand(argv []string, _ string) ([]string, []string) {
+	return argv, []string{"NODE_COMPILE_CACHE=" + CompileCachePath}
+}
@@ -0,0 +1,148 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileCacheCommand(t *testing.T) {
+	p := &PythonRuntime{}
+	argv := []string{"python3", "-u", "-B", "-S", "/workspace/code.py", "one", "/workspace/code.py"}
+	got, env := p.CompileCacheCommand(argv, "/workspace/code.py")
+	want := []string{"python3", "-u", "-B", "-S", "-c", pythonLauncher, "/workspace/code.py", "one", "/workspace/code.py"}
+	if !reflect.DeepEqual(got, want) || env != nil {
+		t.Errorf("python: %q %v", got, env)
+	}
+	if argv[4] != "/workspace/code.py" {
+		t.Error("python: argv modified in place")
+	}
+
+	n := &NodeRuntime{}
+	argv = n.Command("/workspace/code.js")
+	got, env = n.CompileCacheCommand(argv, "/workspace/code.js")
+	if !reflect.DeepEqual(got, argv) || !reflect.DeepEqual(env, []string{"NODE_COMPILE_CACHE=" + CompileCachePath}) {
+		t.Errorf("node: %q %v", got, env)
+	}
+}
+
+func TestCompileCacheUsable(t *testing.T) {
+	n := &NodeRuntime{}
+	for version, want := range map[string]bool{"v20.11.1": false, "v22.0.0": false, "v22.1.0": true, "v23.3.0": true, "": false} {
+		if got := n.CompileCacheUsable(ImageProbe{Version: version}); got != want {
+			t.Errorf("node %q: %v, want %v", version, got, want)
+		}
+	}
+	if !(&PythonRuntime{}).CompileCacheUsable(ImageProbe{}) {
+		t.Error("python without a probe: not usable")
+	}
+	r := NewRegistry()
+	for lang, want := range map[string]bool{"python": true, "node": true, "bash": false, "claude": false} {
+		rt, _ := r.Get(lang)
+		if _, ok := AsCompileCacher(rt); ok != want {
+			t.Errorf("%s: CompileCacher %v, want %v", lang, ok, want)
+		}
+	}
+}
+
+// launcherAt is pythonLauncher keeping its bytecode at pyc.
+func launcherAt(t testing.TB, pyc string) string {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	return strings.Replace(pythonLauncher, fmt.Sprintf("%q", pythonCompiledFile), fmt.Sprintf("%q", pyc), 1)
+}
+
+func TestPythonLauncher(t *testing.T) {
+	dir := t.TempDir()
+	pyc := filepath.Join(dir, "code.pyc")
+	launcher := launcherAt(t, pyc)
+	script := filepath.Join(dir, "code.py")
+	write := func(body string) {
+		if err := os.WriteFile(script, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(argv ...string) string {
+		out, err := exec.Command("python3", argv...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		return string(out)
+	}
+	write(`import sys
+print(__name__, __file__, sys.argv, sys.path[0] == __file__.rpartition("/")[0])
+print(sorted(n for n in globals() if not n.startswith("__")))
+print("first")
+`)
+
+	direct := run("-B", script, "a", "b")
+	if got := run("-B", "-c", launcher, script, "a", "b"); got != direct {
+		t.Errorf("launched:\n%s\nrun directly:\n%s", got, direct)
+	}
+	if _, err := os.Stat(pyc); err != nil {
+		t.Fatalf("no bytecode written: %v", err)
+	}
+
+	// The bytecode is what runs now, whatever the file says.
+	write(`print("second")`)
+	if got := run("-B", "-c", launcher, script); !strings.Contains(got, "first") {
+		t.Errorf("cached bytecode not used: %s", got)
+	}
+
+	// Bytecode of another interpreter is compiled again.
+	if err := os.WriteFile(pyc, []byte("\x00\x00\x0d\x0agarbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := run("-B", "-c", launcher, script); got != "second\n" {
+		t.Errorf("foreign bytecode: %s", got)
+	}
+
+	// A read-only cache without the bytecode still runs the code.
+	if err := os.Remove(pyc); err != nil {
+		t.Fatal(err)
+	}
+	noCache := strings.Replace(launcher, fmt.Sprintf("%q", pyc), fmt.Sprintf("%q", filepath.Join(dir, "missing", "code.pyc")), 1)
+	if got := run("-B", "-c", noCache, script); got != "second\n" {
+		t.Errorf("unwritable cache: %s", got)
+	}
+}
+
+// BenchmarkPythonCompileCache runs a 2000-line generated program with and
+// without its bytecode cached.
+func BenchmarkPythonCompileCache(b *testing.B) {
+	dir := b.TempDir()
+	pyc := filepath.Join(dir, "code.pyc")
+	launcher := launcherAt(b, pyc)
+	var src strings.Builder
+	for i := range 2000 {
+		fmt.Fprintf(&src, "def f%d(x):\n    return [y * %d for y in range(x) if y %% 3 == %d]\n", i, i, i%3)
+	}
+	src.WriteString("print(len(f1999(10)))\n")
+	script := filepath.Join(dir, "code.py")
+	if err := os.WriteFile(script, []byte(src.String()), 0600); err != nil {
+		b.Fatal(err)
+	}
+	run := func(b *testing.B, argv ...string) {
+		if out, err := exec.Command("python3", argv...).CombinedOutput(); err != nil {
+			b.Fatalf("%v: %s", err, out)
+		}
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			run(b, "-B", script)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		run(b, "-B", "-c", launcher, script)
+		for b.Loop() {
+			run(b, "-B", "-c", launcher, script)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package runtime
+
+import "fmt"
+
+// DenoRuntime configures execution of TypeScript/JavaScript under Deno.
+// Deno's permission flags give a second sandboxing layer inside the container:
+// network is denied unless the execution has network enabled, reads are
+// limited to /workspace and writes to /tmp.
+type DenoRuntime struct{}
+
+func (d *DenoRuntime) Name() string { return "deno" }
+
+func (d *DenoRuntime) Image() string { return "docker.io/denoland/deno:alpine-2.1.4" }
+
+func (d *DenoRuntime) Command(codePath string) []string {
+	return d.NetworkCommand(codePath, false)
+}
+
+// NetworkCommand swaps --deny-net for --allow-net when the sandbox grants network access.
+func (d *DenoRuntime) NetworkCommand(codePath string, networkEnabled bool) []string {
+	netFlag := "--deny-net"
+	if networkEnabled {
+		netFlag = "--allow-net"
+	}
+	return []string{
+		"deno", "run",
+		"--no-prompt", // Fail instead of asking for permissions
+		netFlag,
+		"--allow-read=/workspace",
+		"--allow-write=/tmp",
+		codePath,
+	}
+}
+
+func (d *DenoRuntime) FileExtension() string { return ".ts" }
+
+func (d *DenoRuntime) DefaultLimits() Limits { return baseLimits() }
+
+func (d *DenoRuntime) Validate(code string) error {
+	if len(code) == 0 {
+		return fmt.Errorf("empty code")
+	}
+	if len(code) > 1<<20 {
+		return fmt.Errorf("code too large: %d bytes (max 1MB)", len(code))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDenoRuntime_Command(t *testing.T) {
+	d := &DenoRuntime{}
+	cmd := strings.Join(d.Command("/workspace/code.ts"), " ")
+	want := "deno run --no-prompt --deny-net --allow-read=/workspace --allow-write=/tmp /workspace/code.ts"
+	if cmd != want {
+		t.Errorf("Command() = %q, want %q", cmd, want)
+	}
+}
+
+func TestDenoRuntime_NetworkCommand(t *testing.T) {
+	d := &DenoRuntime{}
+
+	denied := d.NetworkCommand("/workspace/code.ts", false)
+	if !contains(denied, "--deny-net") || contains(denied, "--allow-net") {
+		t.Errorf("NetworkCommand(false) = %v, want --deny-net only", denied)
+	}
+
+	allowed := d.NetworkCommand("/workspace/code.ts", true)
+	if !contains(allowed, "--allow-net") || contains(allowed, "--deny-net") {
+		t.Errorf("NetworkCommand(true) = %v, want --allow-net only", allowed)
+	}
+}
+
+func TestDenoRuntime_FileExtension(t *testing.T) {
+	d := &DenoRuntime{}
+	if d.FileExtension() != ".ts" {
+		t.Errorf("FileExtension() = %q, want %q", d.FileExtension(), ".ts")
+	}
+}
+
+func TestDenoRuntime_Validate(t *testing.T) {
+	d := &DenoRuntime{}
+	if err := d.Validate(`console.log("hi")`); err != nil {
+		t.Errorf("Validate(valid code) = %v, want nil", err)
+	}
+	if err := d.Validate(""); err == nil {
+		t.Error("Validate(empty) should return error")
+	}
+}
+
+func contains(args []string, needle string) bool {
+	for _, a := range args {
+		if a == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,13 @@ package runtime
 
 import "fmt"
 
-// GoRuntime configures execution of Go code.
+// goPath is the PATH of go programs: the image's, which the containerd
+// backend doesn't keep, with the toolchain first.
+const goPath = "PATH=/usr/local/go/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// GoRuntime configures execution of Go code. go run compiles the program
+// first, so the build cache and module cache are put on /tmp, the one
+// writable filesystem under --read-only.
 type GoRuntime struct{}
 
 func (g *GoRuntime) Name() string { return "go" }
@@ -13,8 +19,30 @@ func (g *GoRuntime) Command(codePath string) []string {
 	return []string{"go", "run", codePath}
 }
 
+// Env keeps the toolchain from fetching anything without network: no
+// module proxy, and only the image's own toolchain whatever a go.mod asks
+// for.
+func (g *GoRuntime) Env(networkEnabled bool) []string {
+	env := []string{goPath, "GOCACHE=/tmp/.cache/go-build", "GOPATH=/tmp/go", "GOTELEMETRY=off", "GOTOOLCHAIN=local"}
+	if !networkEnabled {
+		env = append(env, "GOPROXY=off")
+	}
+	return env
+}
+
 func (g *GoRuntime) FileExtension() string { return ".go" }
 
+// DefaultLimits gives the compiler room: it runs several processes with a
+// thread each, and the build cache of the standard library alone takes
+// tens of megabytes of /tmp.
+func (g *GoRuntime) DefaultLimits() Limits {
+	l := baseLimits()
+	l.MemoryMB = 512
+	l.PidsLimit = 200
+	l.DiskMB = 512
+	return l
+}
+
 func (g *GoRuntime) Validate(code string) error {
 	if len(code) == 0 {
 		return fmt.Errorf("empty code")
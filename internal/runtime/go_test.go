@@ -61,3 +61,29 @@ func TestGoRuntime_RegisteredInRegistry(t *testing.T) {
 		t.Errorf("registered runtime name = %q, want %q", rt.Name(), "go")
 	}
 }
+
+func TestGoRuntime_Env(t *testing.T) {
+	g := &GoRuntime{}
+	offline := g.Env(false)
+	for _, want := range []string{"GOCACHE=/tmp/.cache/go-build", "GOPATH=/tmp/go", "GOPROXY=off", "GOTOOLCHAIN=local"} {
+		if !contains(offline, want) {
+			t.Errorf("Env(false) = %v, want %s", offline, want)
+		}
+	}
+	if online := g.Env(true); contains(online, "GOPROXY=off") {
+		t.Errorf("Env(true) = %v, should leave GOPROXY alone", online)
+	}
+
+	// The image override must keep the environment.
+	r := NewRegistry()
+	if err := r.OverrideImages(map[string]string{"go": "registry.local/go:pinned"}); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := r.Get("go")
+	if env := EnvFor(rt, false); !contains(env, "GOPROXY=off") {
+		t.Errorf("EnvFor(override) = %v, want GOPROXY=off", env)
+	}
+	if env := EnvFor(&PythonRuntime{}, false); env != nil {
+		t.Errorf("EnvFor(python) = %v, want none", env)
+	}
+}
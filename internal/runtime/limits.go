@@ -0,0 +1,76 @@
+package runtime
+
+import "fmt"
+
+// Limits are the resources an execution gets when its request leaves them
+// out: the sandbox's ResourceLimits, without ulimits.
+type Limits struct {
+	CPUShares int64 // 1024 = 1 CPU core
+	MemoryMB  int64
+	PidsLimit int64
+	DiskMB    int64 // tmpfs size for /tmp and /workspace
+}
+
+// baseLimits are the default limits of a runtime with no needs of its own:
+// half a CPU, 256MB, 50 processes and 100MB of tmpfs.
+func baseLimits() Limits {
+	return Limits{CPUShares: 512, MemoryMB: 256, PidsLimit: 50, DiskMB: 100}
+}
+
+// SetLimits overrides the default limits of the given languages field by
+// field: a zero field keeps the runtime's own. Unknown languages are an
+// error so config typos don't go unnoticed.
+func (r *Registry) SetLimits(overrides map[string]Limits) error {
+	limits := make(map[string]Limits, len(overrides))
+	for lang, o := range overrides {
+		rt, ok := r.runtimes[lang]
+		if !ok {
+			return fmt.Errorf("limits for unknown language %q", lang)
+		}
+		l := rt.DefaultLimits()
+		if o.CPUShares != 0 {
+			l.CPUShares = o.CPUShares
+		}
+		if o.MemoryMB != 0 {
+			l.MemoryMB = o.MemoryMB
+		}
+		if o.PidsLimit != 0 {
+			l.PidsLimit = o.PidsLimit
+		}
+		if o.DiskMB != 0 {
+			l.DiskMB = o.DiskMB
+		}
+		limits[lang] = l
+	}
+	r.limits = limits
+	return nil
+}
+
+// Limits returns the limits language's executions get by default: its
+// runtime's, with what SetLimits overrode. An unknown language gets the
+// base limits.
+func (r *Registry) Limits(language string) Limits {
+	name, ok := r.Canonical(language)
+	if !ok {
+		return baseLimits()
+	}
+	if l, ok := r.limits[name]; ok {
+		return l
+	}
+	return r.runtimes[name].DefaultLimits()
+}
+
+// RecommendMemory returns the memory_mb to suggest to an execution of
+// language that ran out of memoryMB: the next rung above it on the
+// runtime's ladder, which starts at its default memory and doubles up to
+// maxMB. It is 0 when memoryMB is maxMB already.
+func (r *Registry) RecommendMemory(language string, memoryMB, maxMB int64) int64 {
+	if memoryMB >= maxMB {
+		return 0
+	}
+	rung := max(r.Limits(language).MemoryMB, 1)
+	for rung <= memoryMB {
+		rung *= 2
+	}
+	return min(rung, maxMB)
+}
@@ -0,0 +1,66 @@
+package runtime
+
+import "testing"
+
+func TestRegistry_Limits(t *testing.T) {
+	r := NewRegistry()
+	tests := []struct {
+		language string
+		memoryMB int64
+	}{
+		{"python", 256},
+		{"node", 512},
+		{"nodejs", 512},
+		{"bash", 128},
+		{"claude", 4096},
+		{"cobol", 256},
+	}
+	for _, tt := range tests {
+		if got := r.Limits(tt.language).MemoryMB; got != tt.memoryMB {
+			t.Errorf("Limits(%s).MemoryMB = %d, want %d", tt.language, got, tt.memoryMB)
+		}
+	}
+
+	if err := r.SetLimits(map[string]Limits{"node": {MemoryMB: 1024}, "bash": {PidsLimit: 20}}); err != nil {
+		t.Fatalf("SetLimits() = %v", err)
+	}
+	if got, want := r.Limits("nodejs"), (Limits{CPUShares: 512, MemoryMB: 1024, PidsLimit: 50, DiskMB: 100}); got != want {
+		t.Errorf("Limits(nodejs) = %+v, want %+v", got, want)
+	}
+	if got, want := r.Limits("bash"), (Limits{CPUShares: 512, MemoryMB: 128, PidsLimit: 20, DiskMB: 100}); got != want {
+		t.Errorf("Limits(bash) = %+v, want %+v", got, want)
+	}
+	if got := r.Limits("python"); got != baseLimits() {
+		t.Errorf("Limits(python) = %+v, want the base limits", got)
+	}
+
+	if err := r.SetLimits(map[string]Limits{"cobol": {MemoryMB: 1}}); err == nil {
+		t.Error("limits for an unknown language should fail")
+	}
+	if err := r.SetLimits(map[string]Limits{"py": {MemoryMB: 1}}); err == nil {
+		t.Error("limits for an alias should fail")
+	}
+}
+
+func TestRegistry_RecommendMemory(t *testing.T) {
+	r := NewRegistry()
+	tests := []struct {
+		language        string
+		memoryMB, maxMB int64
+		want            int64
+	}{
+		{"python", 256, 4096, 512},
+		{"node", 512, 4096, 1024},
+		{"bash", 128, 4096, 256},
+		// A request's own memory is placed on the ladder.
+		{"python", 300, 4096, 512},
+		{"node", 100, 4096, 512},
+		{"python", 3000, 4096, 4096},
+		{"python", 4096, 4096, 0},
+	}
+	for _, tt := range tests {
+		if got := r.RecommendMemory(tt.language, tt.memoryMB, tt.maxMB); got != tt.want {
+			t.Errorf("RecommendMemory(%s, %d, %d) = %d, want %d", tt.language, tt.memoryMB, tt.maxMB, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,8 @@ type NodeRuntime struct{}
 
 func (n *NodeRuntime) Name() string { return "node" }
 
+func (n *NodeRuntime) Aliases() []string { return []string{"js", "javascript", "nodejs"} }
+
 func (n *NodeRuntime) Image() string { return "docker.io/library/node:20-slim" }
 
 func (n *NodeRuntime) Command(codePath string) []string {
@@ -20,6 +22,14 @@ func (n *NodeRuntime) Command(codePath string) []string {
 
 func (n *NodeRuntime) FileExtension() string { return ".js" }
 
+// DefaultLimits gives node twice the base memory: V8's own footprint
+// takes much of 256MB before the program has any data.
+func (n *NodeRuntime) DefaultLimits() Limits {
+	l := baseLimits()
+	l.MemoryMB = 512
+	return l
+}
+
 func (n *NodeRuntime) Validate(code string) error {
 	if len(code) == 0 {
 		return fmt.Errorf("empty code")
@@ -10,6 +10,8 @@ type PythonRuntime struct{}
 
 func (p *PythonRuntime) Name() string { return "python" }
 
+func (p *PythonRuntime) Aliases() []string { return []string{"py", "python3"} }
+
 func (p *PythonRuntime) Image() string { return "docker.io/library/python:3.12-slim" }
 
 func (p *PythonRuntime) Command(codePath string) []string {
@@ -22,6 +24,8 @@ func (p *PythonRuntime) Command(codePath string) []string {
 
 func (p *PythonRuntime) FileExtension() string { return ".py" }
 
+func (p *PythonRuntime) DefaultLimits() Limits { return baseLimits() }
+
 func (p *PythonRuntime) Validate(code string) error {
 	if len(code) == 0 {
 		return fmt.Errorf("empty code")
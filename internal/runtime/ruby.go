@@ -0,0 +1,38 @@
+package runtime
+
+import "fmt"
+
+// RubyRuntime configures execution of Ruby code. Gems go to /tmp, the one
+// writable filesystem under --read-only, instead of the image's
+// /usr/local/bundle.
+type RubyRuntime struct{}
+
+func (r *RubyRuntime) Name() string { return "ruby" }
+
+func (r *RubyRuntime) Aliases() []string { return []string{"rb"} }
+
+func (r *RubyRuntime) Image() string { return "docker.io/library/ruby:3.3-alpine" }
+
+func (r *RubyRuntime) Command(codePath string) []string {
+	return []string{"ruby", codePath}
+}
+
+// Env points gem installs at /tmp. Without network gem install fails on
+// its own, so nothing depends on networkEnabled.
+func (r *RubyRuntime) Env(bool) []string {
+	return []string{"GEM_HOME=/tmp/gems"}
+}
+
+func (r *RubyRuntime) FileExtension() string { return ".rb" }
+
+func (r *RubyRuntime) DefaultLimits() Limits { return baseLimits() }
+
+func (r *RubyRuntime) Validate(code string) error {
+	if len(code) == 0 {
+		return fmt.Errorf("empty code")
+	}
+	if len(code) > 1<<20 {
+		return fmt.Errorf("code too large: %d bytes (max 1MB)", len(code))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRubyRuntime_Command(t *testing.T) {
+	r := &RubyRuntime{}
+	if cmd := r.Command("/workspace/code.rb"); strings.Join(cmd, " ") != "ruby /workspace/code.rb" {
+		t.Errorf("Command() = %v, want [ruby /workspace/code.rb]", cmd)
+	}
+	if r.FileExtension() != ".rb" {
+		t.Errorf("FileExtension() = %q, want .rb", r.FileExtension())
+	}
+}
+
+func TestRubyRuntime_Validate(t *testing.T) {
+	r := &RubyRuntime{}
+	if err := r.Validate(`puts "hi"`); err != nil {
+		t.Errorf("Validate(valid code) = %v, want nil", err)
+	}
+	if err := r.Validate(""); err == nil {
+		t.Error("Validate(empty) should return error")
+	}
+	if err := r.Validate(strings.Repeat("x", 1<<20+1)); err == nil {
+		t.Error("Validate(>1MB) should return error")
+	}
+}
+
+func TestRubyRuntime_RegisteredInRegistry(t *testing.T) {
+	reg := NewRegistry()
+	for _, lang := range []string{"ruby", "rb"} {
+		rt, err := reg.Get(lang)
+		if err != nil || rt.Name() != "ruby" {
+			t.Fatalf("Get(%s) = %v, %v; want ruby", lang, rt, err)
+		}
+	}
+	if got := reg.LanguagesForExtension(".rb"); strings.Join(got, ",") != "ruby" {
+		t.Errorf("LanguagesForExtension(.rb) = %v, want [ruby]", got)
+	}
+	// The image override must keep the environment.
+	if err := reg.OverrideImages(map[string]string{"ruby": "registry.local/ruby:pinned"}); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := reg.Get("ruby")
+	if env := EnvFor(rt, false); !contains(env, "GEM_HOME=/tmp/gems") {
+		t.Errorf("EnvFor(override) = %v, want GEM_HOME=/tmp/gems", env)
+	}
+}
@@ -0,0 +1,216 @@
+package runtime
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Warmup options trim interpreter startup. One is applied only when the
+// runtime's image supports it (see ImageProbe) and sandbox.warmup leaves it
+// enabled for the language.
+const (
+	WarmupNoSite       = "no_site"       // python -S: skip the site module and its site-packages scan
+	WarmupIgnoreEnv    = "ignore_env"    // python -E: ignore PYTHON* variables
+	WarmupSnapshot     = "snapshot"      // node --snapshot-blob: start from a heap snapshot baked into the image
+	WarmupCompileCache = "compile_cache" // node: NODE_COMPILE_CACHE pointing at a cache baked into the image
+
+	// WarmupPrecompiledStdlib is reported rather than chosen: the image ships
+	// stdlib bytecode, which Python reads even under -B.
+	WarmupPrecompiledStdlib = "precompiled_stdlib"
+)
+
+// Paths the sandbox images bake node warmup artifacts into
+// (deployments/docker/Dockerfile.sandbox-node).
+const (
+	NodeSnapshotBlob = "/opt/sandbox/node-startup.blob"
+	NodeCompileCache = "/opt/sandbox/node-compile-cache"
+)
+
+// ImageProbe is what a runtime image supports, as printed in JSON by the
+// runtime's ProbeCommand.
+type ImageProbe struct {
+	Version           string   `json:"version"`                      // Interpreter version, e.g. 3.12.4 or v20.11.1
+	PrecompiledStdlib bool     `json:"precompiled_stdlib,omitempty"` // python: stdlib bytecode is present
+	SitePackages      []string `json:"site_packages,omitempty"`      // python: what site would load (distributions, .pth files, sitecustomize)
+	SnapshotBlob      bool     `json:"snapshot_blob,omitempty"`      // node: NodeSnapshotBlob exists
+	CompileCache      bool     `json:"compile_cache,omitempty"`      // node: NodeCompileCache exists
+}
+
+// Warmable is implemented by runtimes whose startup can be trimmed when
+// their image allows it.
+type Warmable interface {
+	// WarmupOptions lists the options the runtime offers, all enabled by default.
+	WarmupOptions() []string
+
+	// ProbeCommand prints an ImageProbe of the runtime's image.
+	ProbeCommand() []string
+
+	// Warmups returns the enabled options that the probe and the code allow.
+	Warmups(probe ImageProbe, enabled []string, code string) []string
+
+	// WarmCommand is Command with warmups applied, plus the env vars they need.
+	WarmCommand(codePath string, warmups []string) (argv, env []string)
+}
+
+// AsWarmable returns rt as a Warmable, looking through image overrides.
+func AsWarmable(rt Runtime) (Warmable, bool) {
+	if o, ok := rt.(*imageOverride); ok {
+		rt = o.Runtime
+	}
+	w, ok := rt.(Warmable)
+	return w, ok
+}
+
+// SetWarmup restricts the warmup options each language may use. Languages
+// left out keep all of theirs; an empty list turns warmup off. Unknown
+// languages and options are an error so config typos don't go unnoticed.
+func (r *Registry) SetWarmup(enabled map[string][]string) error {
+	for lang, options := range enabled {
+		rt, ok := r.runtimes[lang]
+		if !ok {
+			return fmt.Errorf("warmup for unknown language %q", lang)
+		}
+		w, ok := AsWarmable(rt)
+		if !ok {
+			return fmt.Errorf("%s has no warmup options", lang)
+		}
+		for _, opt := range options {
+			if !slices.Contains(w.WarmupOptions(), opt) {
+				return fmt.Errorf("unknown %s warmup option %q (available: %s)", lang, opt, strings.Join(w.WarmupOptions(), ", "))
+			}
+		}
+	}
+	r.warmup = enabled
+	return nil
+}
+
+// Warmup returns the warmup options enabled for language.
+func (r *Registry) Warmup(language string) []string {
+	if options, ok := r.warmup[language]; ok {
+		return options
+	}
+	if w, ok := AsWarmable(r.runtimes[language]); ok {
+		return w.WarmupOptions()
+	}
+	return nil
+}
+
+// pythonProbe reports the interpreter version, whether os.py has bytecode
+// next to it, and whatever in site-packages the site module would act on.
+const pythonProbe = `import importlib.util, json, os, sys, sysconfig
+d = sysconfig.get_paths()["purelib"]
+try:
+    site = sorted(n for n in os.listdir(d) if n.endswith((".dist-info", ".egg-info", ".egg-link", ".pth")) or n in ("sitecustomize.py", "usercustomize.py"))
+except OSError:
+    site = []
+print(json.dumps({"version": sys.version.split()[0], "precompiled_stdlib": os.path.exists(importlib.util.cache_from_source(os.__file__)), "site_packages": site}))`
+
+// bundledSitePackages are installed in the stock python images; nothing
+// imports them implicitly, so they don't make -S unsafe.
+var bundledSitePackages = []string{"pip-", "setuptools-", "wheel-", "distutils-precedence.pth"}
+
+// siteBuiltinCall matches calls to the builtins that only the site module
+// defines, such as exit() and quit(); sys.exit() is fine.
+var siteBuiltinCall = regexp.MustCompile(`(?:^|[^.\w])(?:exit|quit|help|copyright|credits|license)\s*\(`)
+
+func (p *PythonRuntime) WarmupOptions() []string {
+	return []string{WarmupNoSite, WarmupIgnoreEnv}
+}
+
+func (p *PythonRuntime) ProbeCommand() []string {
+	return []string{"python3", "-S", "-E", "-c", pythonProbe}
+}
+
+// Warmups skips the site module only when the image has nothing in
+// site-packages that code could expect to import and the code doesn't call
+// a builtin site defines.
+func (p *PythonRuntime) Warmups(probe ImageProbe, enabled []string, code string) []string {
+	var warmups []string
+	if probe.PrecompiledStdlib {
+		warmups = append(warmups, WarmupPrecompiledStdlib)
+	}
+	if slices.Contains(enabled, WarmupNoSite) && !needsSite(probe.SitePackages) && !siteBuiltinCall.MatchString(code) {
+		warmups = append(warmups, WarmupNoSite)
+	}
+	if slices.Contains(enabled, WarmupIgnoreEnv) {
+		warmups = append(warmups, WarmupIgnoreEnv)
+	}
+	return warmups
+}
+
+func needsSite(entries []string) bool {
+	for _, e := range entries {
+		if !slices.ContainsFunc(bundledSitePackages, func(prefix string) bool { return strings.HasPrefix(e, prefix) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PythonRuntime) WarmCommand(codePath string, warmups []string) ([]string, []string) {
+	argv := p.Command(codePath)
+	flags := make([]string, 0, 2)
+	if slices.Contains(warmups, WarmupNoSite) {
+		flags = append(flags, "-S")
+	}
+	if slices.Contains(warmups, WarmupIgnoreEnv) {
+		flags = append(flags, "-E")
+	}
+	return slices.Insert(argv, len(argv)-1, flags...), nil
+}
+
+// nodeProbe reports the node version and which baked artifacts exist.
+var nodeProbe = fmt.Sprintf(`const fs = require("fs");
+console.log(JSON.stringify({version: process.version, snapshot_blob: fs.existsSync(%q), compile_cache: fs.existsSync(%q)}))`,
+	NodeSnapshotBlob, NodeCompileCache)
+
+func (n *NodeRuntime) WarmupOptions() []string {
+	return []string{WarmupSnapshot, WarmupCompileCache}
+}
+
+func (n *NodeRuntime) ProbeCommand() []string {
+	return []string{"node", "-e", nodeProbe}
+}
+
+// Warmups needs the artifact in the image and a node that can use it:
+// running a script from a userland snapshot arrived in 20, the compile cache
+// in 22.1.
+func (n *NodeRuntime) Warmups(probe ImageProbe, enabled []string, _ string) []string {
+	var warmups []string
+	if slices.Contains(enabled, WarmupSnapshot) && probe.SnapshotBlob && nodeAtLeast(probe.Version, 20, 0) {
+		warmups = append(warmups, WarmupSnapshot)
+	}
+	if slices.Contains(enabled, WarmupCompileCache) && probe.CompileCache && nodeAtLeast(probe.Version, 22, 1) {
+		warmups = append(warmups, WarmupCompileCache)
+	}
+	return warmups
+}
+
+func (n *NodeRuntime) WarmCommand(codePath string, warmups []string) ([]string, []string) {
+	argv := n.Command(codePath)
+	var env []string
+	if slices.Contains(warmups, WarmupSnapshot) {
+		argv = slices.Insert(argv, len(argv)-1, "--snapshot-blob", NodeSnapshotBlob)
+	}
+	if slices.Contains(warmups, WarmupCompileCache) {
+		env = append(env, "NODE_COMPILE_CACHE="+NodeCompileCache)
+	}
+	return argv, env
+}
+
+// nodeAtLeast reports whether a version like v22.3.0 is at least major.minor.
+func nodeAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err1 := strconv.Atoi(parts[0])
+	gotMinor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
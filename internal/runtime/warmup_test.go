@@ -0,0 +1,145 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPythonRuntime_Warmups(t *testing.T) {
+	p := &PythonRuntime{}
+	all := p.WarmupOptions()
+	stock := ImageProbe{Version: "3.12.4", SitePackages: []string{"pip-24.0.dist-info"}}
+
+	tests := []struct {
+		name    string
+		probe   ImageProbe
+		enabled []string
+		code    string
+		want    []string
+	}{
+		{"stock image", stock, all, "print(1)", []string{WarmupNoSite, WarmupIgnoreEnv}},
+		{"precompiled stdlib", ImageProbe{Version: "3.12.4", PrecompiledStdlib: true}, all, "print(1)",
+			[]string{WarmupPrecompiledStdlib, WarmupNoSite, WarmupIgnoreEnv}},
+		{"bundled packages only", ImageProbe{SitePackages: []string{"distutils-precedence.pth", "pip-24.0.dist-info", "setuptools-69.0.dist-info", "wheel-0.42.dist-info"}}, all, "print(1)",
+			[]string{WarmupNoSite, WarmupIgnoreEnv}},
+		{"third-party package", ImageProbe{SitePackages: []string{"numpy-1.26.4.dist-info", "pip-24.0.dist-info"}}, all, "import numpy",
+			[]string{WarmupIgnoreEnv}},
+		{"path file", ImageProbe{SitePackages: []string{"extra.pth"}}, all, "print(1)", []string{WarmupIgnoreEnv}},
+		{"sitecustomize", ImageProbe{SitePackages: []string{"sitecustomize.py"}}, all, "print(1)", []string{WarmupIgnoreEnv}},
+		{"calls exit", stock, all, "print(1)\nexit(2)", []string{WarmupIgnoreEnv}},
+		{"calls quit", stock, all, "if x: quit()", []string{WarmupIgnoreEnv}},
+		{"sys.exit is fine", stock, all, "import sys\nsys.exit(2)", []string{WarmupNoSite, WarmupIgnoreEnv}},
+		{"exit in a name is fine", stock, all, "on_exit(1)", []string{WarmupNoSite, WarmupIgnoreEnv}},
+		{"no_site disabled", stock, []string{WarmupIgnoreEnv}, "print(1)", []string{WarmupIgnoreEnv}},
+		{"all disabled", stock, []string{}, "print(1)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Warmups(tt.probe, tt.enabled, tt.code); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Warmups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPythonRuntime_WarmCommand(t *testing.T) {
+	p := &PythonRuntime{}
+	argv, env := p.WarmCommand("/workspace/code.py", []string{WarmupPrecompiledStdlib, WarmupNoSite, WarmupIgnoreEnv})
+	if want := []string{"python3", "-u", "-B", "-S", "-E", "/workspace/code.py"}; !reflect.DeepEqual(argv, want) {
+		t.Errorf("argv = %v, want %v", argv, want)
+	}
+	if env != nil {
+		t.Errorf("env = %v, want none", env)
+	}
+	if argv, _ := p.WarmCommand("/workspace/code.py", nil); !reflect.DeepEqual(argv, p.Command("/workspace/code.py")) {
+		t.Errorf("no warmups: argv = %v, want the plain command", argv)
+	}
+}
+
+func TestNodeRuntime_Warmups(t *testing.T) {
+	n := &NodeRuntime{}
+	all := n.WarmupOptions()
+	baked := ImageProbe{SnapshotBlob: true, CompileCache: true}
+
+	tests := []struct {
+		name    string
+		version string
+		probe   ImageProbe
+		enabled []string
+		want    []string
+	}{
+		{"stock node 20", "v20.11.1", ImageProbe{}, all, nil},
+		{"baked node 22.3", "v22.3.0", baked, all, []string{WarmupSnapshot, WarmupCompileCache}},
+		{"baked node 22.0 has no compile cache", "v22.0.0", baked, all, []string{WarmupSnapshot}},
+		{"baked node 20", "v20.11.1", baked, all, []string{WarmupSnapshot}},
+		{"baked node 18", "v18.19.0", baked, all, nil},
+		{"unparseable version", "nightly", baked, all, nil},
+		{"snapshot disabled", "v22.3.0", baked, []string{WarmupCompileCache}, []string{WarmupCompileCache}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := tt.probe
+			probe.Version = tt.version
+			if got := n.Warmups(probe, tt.enabled, ""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Warmups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeRuntime_WarmCommand(t *testing.T) {
+	n := &NodeRuntime{}
+	argv, env := n.WarmCommand("/workspace/code.js", []string{WarmupSnapshot, WarmupCompileCache})
+	want := []string{"node", "--max-old-space-size=256", "--disallow-code-generation-from-strings", "--snapshot-blob", NodeSnapshotBlob, "/workspace/code.js"}
+	if !reflect.DeepEqual(argv, want) {
+		t.Errorf("argv = %v, want %v", argv, want)
+	}
+	if want := []string{"NODE_COMPILE_CACHE=" + NodeCompileCache}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+func TestRegistry_SetWarmup(t *testing.T) {
+	r := NewRegistry()
+	if got := r.Warmup("python"); !reflect.DeepEqual(got, []string{WarmupNoSite, WarmupIgnoreEnv}) {
+		t.Errorf("default python warmup = %v", got)
+	}
+	if got := r.Warmup("bash"); got != nil {
+		t.Errorf("bash warmup = %v, want none", got)
+	}
+
+	if err := r.SetWarmup(map[string][]string{"python": {WarmupIgnoreEnv}, "node": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Warmup("python"); !reflect.DeepEqual(got, []string{WarmupIgnoreEnv}) {
+		t.Errorf("python warmup = %v", got)
+	}
+	if got := r.Warmup("node"); len(got) != 0 {
+		t.Errorf("node warmup = %v, want none", got)
+	}
+
+	for name, cfg := range map[string]map[string][]string{
+		"unknown language":   {"cobol": {}},
+		"no options":         {"bash": {WarmupNoSite}},
+		"unknown option":     {"python": {"turbo"}},
+		"other runtime's op": {"python": {WarmupSnapshot}},
+	} {
+		if err := r.SetWarmup(cfg); err == nil {
+			t.Errorf("%s: SetWarmup(%v) should fail", name, cfg)
+		}
+	}
+}
+
+func TestAsWarmable_ImageOverride(t *testing.T) {
+	r := NewRegistry()
+	if err := r.OverrideImages(map[string]string{"python": "sandbox-python:latest"}); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := r.Get("python")
+	if _, ok := AsWarmable(rt); !ok {
+		t.Error("an image override hides Warmable")
+	}
+	if got := r.Warmup("python"); len(got) == 0 {
+		t.Error("an image override loses the default warmups")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os/exec"
 	"runtime"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -16,10 +17,67 @@ type Backend interface {
 	Execute(ctx context.Context, req ExecutionRequest) (*ExecutionResult, error)
 	ExecuteStreaming(ctx context.Context, req ExecutionRequest, stdout, stderr io.Writer) (*ExecutionResult, error)
 	Close() error
+	// Name identifies the backend type in metrics: docker, containerd or remote.
+	Name() string
+}
+
+// MaxTimeout is the longest timeout a backend accepts for language: 30
+// minutes for a claude session, a minute for everything else.
+func MaxTimeout(language string) time.Duration {
+	if language == "claude" {
+		return 30 * time.Minute
+	}
+	return 60 * time.Second
+}
+
+// DefaultTimeout is the timeout of an execution of language whose request
+// sets none. Go's covers compiling the program, which it does every run.
+func DefaultTimeout(language string) time.Duration {
+	if language == "go" {
+		return 30 * time.Second
+	}
+	return 10 * time.Second
+}
+
+// PrivilegedMaxTimeout is the longest timeout a request with Privileged
+// limits, a server job's, may ask for.
+const PrivilegedMaxTimeout = 24 * time.Hour
+
+// maxTimeoutOf is the longest timeout req may ask for.
+func maxTimeoutOf(req ExecutionRequest) time.Duration {
+	if req.Privileged != nil {
+		return PrivilegedMaxTimeout
+	}
+	return MaxTimeout(req.Language)
+}
+
+// Observer receives a backend's internal metrics. *monitor.Metrics implements it.
+type Observer interface {
+	SlotObserver
+	CacheObserver
+	WorkdirObserver
+	SeccompObserver
+	DiskObserver
+	CPUAbuseObserver
+	ImagePullObserver
 }
 
 // NewBackend picks the best available backend: containerd on Linux, Docker elsewhere.
-func NewBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
+// With sandbox.chaos.enabled the result is wrapped in a ChaosBackend. obs, if
+// not nil, receives the backend's slot and cache metrics.
+func NewBackend(ctx context.Context, cfg *config.Config, obs Observer) (Backend, error) {
+	backend, err := newBackend(ctx, cfg, obs)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Sandbox.Chaos.Enabled {
+		log.Warn().Msg("chaos mode enabled: requests may ask for synthesized failures")
+		return NewChaosBackend(backend), nil
+	}
+	return backend, nil
+}
+
+func newBackend(ctx context.Context, cfg *config.Config, obs Observer) (Backend, error) {
 	preference := cfg.Sandbox.Backend
 	if preference == "" {
 		preference = "auto"
@@ -27,12 +85,12 @@ func NewBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
 
 	switch preference {
 	case "containerd":
-		return newContainerdBackend(ctx, cfg)
+		return newContainerdBackend(ctx, cfg, obs)
 	case "docker":
-		return newDockerBackend(cfg)
+		return newDockerBackend(cfg, obs)
 	case "auto":
 		if runtime.GOOS == "linux" {
-			backend, err := newContainerdBackend(ctx, cfg)
+			backend, err := newContainerdBackend(ctx, cfg, obs)
 			if err == nil {
 				log.Info().Msg("using containerd backend")
 				return backend, nil
@@ -40,7 +98,7 @@ func NewBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
 			log.Warn().Err(err).Msg("containerd unavailable, trying Docker")
 		}
 
-		backend, err := newDockerBackend(cfg)
+		backend, err := newDockerBackend(cfg, obs)
 		if err == nil {
 			log.Info().Msg("using Docker backend")
 			return backend, nil
@@ -52,7 +110,7 @@ func NewBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
 	}
 }
 
-func newContainerdBackend(ctx context.Context, cfg *config.Config) (Backend, error) {
+func newContainerdBackend(ctx context.Context, cfg *config.Config, obs Observer) (Backend, error) {
 	client, err := NewClient(ctx, cfg.Sandbox.ContainerdSocket, cfg.Sandbox.Namespace)
 	if err != nil {
 		return nil, err
@@ -63,25 +121,219 @@ func newContainerdBackend(ctx context.Context, cfg *config.Config) (Backend, err
 		_ = client.Close()
 		return nil, err
 	}
+	if err := runner.runtimes.OverrideImages(cfg.Sandbox.RuntimeImages); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("sandbox.runtime_images: %w", err)
+	}
+	// The containerd backend runs without warmups, but a typo should still fail.
+	if err := runner.runtimes.SetWarmup(cfg.Sandbox.Warmup); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("sandbox.warmup: %w", err)
+	}
+	if err := SetRuntimeLimits(runner.runtimes, cfg.Sandbox.RuntimeLimits); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("sandbox.runtime_limits: %w", err)
+	}
+	runner.workspaceRoot = cfg.Sandbox.Workspaces.Root
+	runner.maxUlimits = Ulimits(cfg.Sandbox.MaxUlimits)
+	runner.cpuAbuse = cfg.Sandbox.CPUAbuse
+	runner.binary = cfg.Sandbox.Binary
+	runner.maxStdin = cfg.Sandbox.MaxStdinBytes
+	runner.setupTimeout, runner.cleanupGrace = cfg.Sandbox.SetupTimeout, cfg.Sandbox.CleanupGrace
+	runner.egress = newEgressAuditor(cfg.Sandbox.EgressAudit)
+	if cfg.Sandbox.CNI.Enabled {
+		runner.network = newCNINetwork(cfg.Sandbox.CNI)
+	}
+	if runner.sharedMounts, err = newSharedMounts(cfg.Sandbox.SharedMounts, runner.runtimes); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("sandbox.shared_mounts: %w", err)
+	}
+	if runner.defaultEnv, err = newDefaultEnv(cfg.Sandbox.DefaultEnv, runner.runtimes); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("sandbox.default_env: %w", err)
+	}
+	if len(cfg.Sandbox.Concurrency) > 0 {
+		if runner.slots, err = newConfiguredSlotLimiter(cfg.Sandbox.MaxConcurrent, cfg.Sandbox.Concurrency, runner.runtimes); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("sandbox.concurrency: %w", err)
+		}
+	}
+	if cfg.Sandbox.FairShare.Enabled {
+		runner.slots.shareFairly(cfg.Sandbox.FairShare.MaxWait)
+	}
+	runner.slots.observer = obs
+	runner.cpuAbuseObs = obs
+	runner.imagePullObs = obs
+	if cfg.Sandbox.DiskPressure.Enabled {
+		root := cfg.Sandbox.DiskPressure.DataRoot
+		if root == "" {
+			root = containerdDataRoot("/etc/containerd/config.toml")
+		}
+		runner.disk, runner.cancelDisk = startDiskMonitor(cfg.Sandbox.DiskPressure, root, containerdImages{client}, runner.runtimes.Images, obs)
+	}
 
-	cleaned, err := runner.CleanupOrphaned(ctx)
-	if err != nil {
-		log.Warn().Err(err).Msg("failed to cleanup orphaned containers")
-	} else if cleaned > 0 {
-		log.Info().Int("count", cleaned).Msg("cleaned orphaned containers on startup")
+	// The containers of the process a successor replaces are still in use.
+	if !cfg.Sandbox.Successor {
+		cleaned, err := runner.CleanupOrphaned(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to cleanup orphaned containers")
+		} else if cleaned > 0 {
+			log.Info().Int("count", cleaned).Msg("cleaned orphaned containers on startup")
+		}
 	}
 
 	return runner, nil
 }
 
-func newDockerBackend(cfg *config.Config) (Backend, error) {
+func newDockerBackend(cfg *config.Config, obs Observer) (Backend, error) {
+	if err := checkDocker(); err != nil {
+		return nil, err
+	}
+
+	runner := newDockerRunner(cfg.Sandbox.MaxConcurrent, cfg.Sandbox.AllowedWorkdirRoots, cfg.AuthProxy.Port, cfg.AuthProxy.Secret, cfg.Security.MaxConcurrentClaude)
+	if err := configureDockerRunner(runner, cfg); err != nil {
+		return nil, err
+	}
+	// A successor's first sweep leaves the containers of the process it
+	// replaces, still draining, alone.
+	runner.startOrphanCleanup(!cfg.Sandbox.Successor)
+	sweepCtx, cancel := context.WithTimeout(context.Background(), startupSweepTimeout)
+	if err := runner.awaitStartupSweep(sweepCtx); err != nil {
+		log.Warn().Dur("waited", startupSweepTimeout).Msg("startup orphan sweep still running; executions wait for it")
+	}
+	cancel()
+	runner.proxyHost, runner.proxyCA = proxyHostGateway(cfg.AuthProxy.ListenIP), cfg.AuthProxy.CAFile
+	runner.slots.observer = obs
+	runner.workdirs.observer = obs
+	runner.workdirSize.observer = obs
+	runner.seccompObs = obs
+	runner.cpuAbuseObs = obs
+	runner.imagePullObs = obs
+	// Each seccomp profile is serialized once, here, rather than per
+	// execution.
+	if _, err := runner.seccompProfiles(); err != nil {
+		return nil, err
+	}
+	if runner.contract != nil {
+		// Checked now so a rebuilt image is reported at startup rather than
+		// by the first claude execution.
+		go runner.ClaudeContract(context.Background(), false)
+	}
+	if runner.limitProbe != nil {
+		go runner.LimitEnforcement(context.Background())
+	}
+	if runner.caches != nil {
+		runner.caches.observer = obs
+		cacheCtx, cancel := context.WithCancel(context.Background())
+		runner.cancelCaches = cancel
+		go runner.caches.monitorLoop(cacheCtx)
+	}
+	if cfg.Sandbox.DiskPressure.Enabled {
+		root := cfg.Sandbox.DiskPressure.DataRoot
+		if root == "" {
+			root = dockerDataRoot(runner.dockerOutput)
+		}
+		runner.disk, runner.cancelDisk = startDiskMonitor(cfg.Sandbox.DiskPressure, root, dockerImages{runner.dockerOutput}, runner.runtimes.Images, obs)
+	}
+	return runner, nil
+}
+
+// NewLocalDockerRunner builds a DockerRunner from cfg for running executions
+// in-process, without a server. It has no auth proxy and no orphan cleanup
+// loop, which would kill the containers of a server running on the same host.
+func NewLocalDockerRunner(cfg *config.Config) (*DockerRunner, error) {
+	if err := checkDocker(); err != nil {
+		return nil, err
+	}
+
+	runner := newDockerRunner(cfg.Sandbox.MaxConcurrent, cfg.Sandbox.AllowedWorkdirRoots, 0, "", cfg.Security.MaxConcurrentClaude)
+	if err := configureDockerRunner(runner, cfg); err != nil {
+		return nil, err
+	}
+	return runner, nil
+}
+
+func checkDocker() error {
 	if _, err := exec.LookPath("docker"); err != nil {
-		return nil, fmt.Errorf("docker not found in PATH: %w", err)
+		return fmt.Errorf("docker not found in PATH: %w", err)
 	}
 
 	if err := exec.Command("docker", "info").Run(); err != nil {
-		return nil, fmt.Errorf("docker daemon not reachable: %w", err)
+		return fmt.Errorf("docker daemon not reachable: %w", err)
 	}
+	return nil
+}
 
-	return NewDockerRunner(cfg.Sandbox.MaxConcurrent, cfg.Sandbox.AllowedWorkdirRoots, cfg.AuthProxy.Port, cfg.AuthProxy.Secret, cfg.Security.MaxConcurrentClaude), nil
+// configureDockerRunner applies the sandbox config that NewDockerRunner does
+// not take as parameters.
+func configureDockerRunner(runner *DockerRunner, cfg *config.Config) error {
+	if err := runner.runtimes.OverrideImages(cfg.Sandbox.RuntimeImages); err != nil {
+		return fmt.Errorf("sandbox.runtime_images: %w", err)
+	}
+	if err := runner.runtimes.SetWarmup(cfg.Sandbox.Warmup); err != nil {
+		return fmt.Errorf("sandbox.warmup: %w", err)
+	}
+	if err := SetRuntimeLimits(runner.runtimes, cfg.Sandbox.RuntimeLimits); err != nil {
+		return fmt.Errorf("sandbox.runtime_limits: %w", err)
+	}
+	runner.workspaceRoot = cfg.Sandbox.Workspaces.Root
+	runner.verifySeccomp = cfg.Sandbox.VerifySeccomp
+	runner.verifyCode = cfg.Sandbox.VerifyCodeIntegrity
+	runner.verifyPaths = cfg.Sandbox.VerifyMaskedPaths
+	runner.genPasswd = cfg.Sandbox.GeneratePasswd
+	runner.maxUlimits = Ulimits(cfg.Sandbox.MaxUlimits)
+	runner.cpuAbuse = cfg.Sandbox.CPUAbuse
+	runner.binary = cfg.Sandbox.Binary
+	runner.maxStdin = cfg.Sandbox.MaxStdinBytes
+	runner.debugTrace = cfg.Sandbox.DebugTrace
+	runner.setupTimeout, runner.cleanupGrace = cfg.Sandbox.SetupTimeout, cfg.Sandbox.CleanupGrace
+	runner.seccompHash = cfg.Sandbox.SeccompHashInterval
+	if runner.cpuAbuse.Enabled && !runner.procMounts {
+		log.Warn().Msg("sandbox.cpu_abuse needs a local Linux docker daemon to read container cgroups; not enforced")
+		runner.cpuAbuse.Enabled = false
+	}
+	runner.egress = newEgressAuditor(cfg.Sandbox.EgressAudit)
+	if runner.egress != nil && runner.egress.unavailable == "" && !runner.procMounts {
+		log.Warn().Msg("sandbox.egress_audit needs a local Linux docker daemon to read its conntrack table; executions run unaudited")
+		runner.egress.unavailable = "the docker daemon isn't local, so its host's conntrack table can't be read"
+	}
+	if !cfg.Sandbox.VerifyClaudeContract {
+		runner.contract = nil
+	}
+	if runner.limitMode = cfg.Sandbox.LimitEnforcement; runner.limitMode != LimitEnforcementOff {
+		if rt, err := runner.runtimes.Get("bash"); err == nil {
+			runner.limitProbe = newLimitProber(runner.dockerOutput, rt.Image())
+		}
+	}
+	compiled, err := newCompileCache(cfg.Sandbox.CompileCache)
+	if err != nil {
+		return fmt.Errorf("sandbox.compile_cache: %w", err)
+	}
+	runner.compiled = compiled
+	mounts, err := newSharedMounts(cfg.Sandbox.SharedMounts, runner.runtimes)
+	if err != nil {
+		return fmt.Errorf("sandbox.shared_mounts: %w", err)
+	}
+	runner.sharedMounts = mounts
+	if runner.defaultEnv, err = newDefaultEnv(cfg.Sandbox.DefaultEnv, runner.runtimes); err != nil {
+		return fmt.Errorf("sandbox.default_env: %w", err)
+	}
+	runner.caches = newClaudeCaches(cfg.Sandbox.ClaudeCaches, runner.dockerOutput)
+	if runner.claude, err = newClaudePolicy(cfg.Security.Claude); err != nil {
+		return fmt.Errorf("security.claude: %w", err)
+	}
+	runner.workdirSize = newWorkdirSizer(cfg.Sandbox.WorkdirSize)
+	runner.workdirGit = cfg.Security.ClaudeWorkdirGit
+	if cfg.Sandbox.WorkdirLock.Mode == "wait" {
+		runner.workdirs.wait = cfg.Sandbox.WorkdirLock.Wait
+	}
+	if len(cfg.Sandbox.Concurrency) > 0 {
+		if runner.slots, err = newConfiguredSlotLimiter(cfg.Sandbox.MaxConcurrent, cfg.Sandbox.Concurrency, runner.runtimes); err != nil {
+			return fmt.Errorf("sandbox.concurrency: %w", err)
+		}
+	}
+	if cfg.Sandbox.FairShare.Enabled {
+		runner.slots.shareFairly(cfg.Sandbox.FairShare.MaxWait)
+	}
+	return nil
 }
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	goruntime "runtime"
+	"strings"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// binaryLanguage is the runtime whose code is an executable, run directly
+// rather than handed to an interpreter.
+const binaryLanguage = "binary"
+
+// DefaultBinaryMaxBytes caps an executable when sandbox.binary.max_bytes
+// is unset.
+const DefaultBinaryMaxBytes = 16 << 20
+
+// elfMachines is the ELF machine each GOARCH the server builds for runs.
+// Containers run on the server's architecture, so an executable for any
+// other fails to start, or runs under emulation where binfmt is set up.
+var elfMachines = map[string]elf.Machine{
+	"amd64":   elf.EM_X86_64,
+	"arm64":   elf.EM_AARCH64,
+	"386":     elf.EM_386,
+	"arm":     elf.EM_ARM,
+	"riscv64": elf.EM_RISCV,
+	"ppc64le": elf.EM_PPC64,
+	"s390x":   elf.EM_S390,
+}
+
+// validateBinary checks a binary execution's code is an executable the
+// sandbox will run: no larger than cfg.MaxBytes, an ELF executable for
+// machine, statically linked unless cfg.AllowDynamic, and, sent as a
+// file, without setuid or setgid bits. The container's no-new-privileges
+// would ignore the bits, but nothing that asks for them is run.
+func validateBinary(req ExecutionRequest, cfg config.BinaryConfig, machine elf.Machine) error {
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultBinaryMaxBytes
+	}
+
+	var code io.ReaderAt = strings.NewReader(req.Code)
+	size := int64(len(req.Code))
+	if req.CodeFile != "" {
+		f, err := os.Open(req.CodeFile)
+		if err != nil {
+			return fmt.Errorf("%w: code_file: %v", ErrInvalidRequest, err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("%w: code_file: %v", ErrInvalidRequest, err)
+		}
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return fmt.Errorf("%w: executable has its setuid or setgid bit set", ErrInvalidRequest)
+		}
+		code, size = f, info.Size()
+	}
+	if size > maxBytes {
+		return fmt.Errorf("%w: executable is %d bytes, over the %d byte limit", ErrInvalidRequest, size, maxBytes)
+	}
+	return checkELF(code, machine, cfg.AllowDynamic)
+}
+
+// checkELF checks r holds an ELF executable for machine that, unless
+// allowDynamic, asks for no interpreter: a PT_INTERP program header names
+// the dynamic loader, which a static executable, PIE or not, doesn't have.
+func checkELF(r io.ReaderAt, machine elf.Machine, allowDynamic bool) error {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("%w: not an ELF executable: %v", ErrInvalidRequest, err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("%w: ELF file is %s, not an executable", ErrInvalidRequest, f.Type)
+	}
+	if f.Machine != machine {
+		return fmt.Errorf("%w: executable is built for %s; the sandbox runs %s", ErrInvalidRequest, f.Machine, machine)
+	}
+	if allowDynamic {
+		return nil
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			return fmt.Errorf("%w: executable is dynamically linked; only static executables run unless sandbox.binary.allow_dynamic is set", ErrInvalidRequest)
+		}
+	}
+	return nil
+}
+
+// hostMachine is the ELF machine of the server's architecture, which
+// executables have to be built for.
+func hostMachine() elf.Machine {
+	return elfMachines[goruntime.GOARCH]
+}
@@ -0,0 +1,111 @@
+package sandbox
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// The testdata executables are hand-assembled ELF files that write "hello,
+// world" and exit: hello_amd64 and hello_arm64 static, and
+// hello_amd64_dynamic the same with a PT_INTERP naming glibc's loader.
+
+func TestCheckELF(t *testing.T) {
+	tests := []struct {
+		fixture      string
+		allowDynamic bool
+		wantErr      string
+	}{
+		{"hello_amd64", false, ""},
+		{"hello_arm64", false, "built for EM_AARCH64"},
+		{"hello_amd64_dynamic", false, "dynamically linked"},
+		{"hello_amd64_dynamic", true, ""},
+		{"claude_stream.jsonl", false, "not an ELF executable"},
+	}
+	for _, tt := range tests {
+		err := checkELF(strings.NewReader(string(readFixture(t, tt.fixture))), elf.EM_X86_64, tt.allowDynamic)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s (allow_dynamic %t): %v", tt.fixture, tt.allowDynamic, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, ErrInvalidRequest)):
+			t.Errorf("%s (allow_dynamic %t): %v, want an invalid request error about %q", tt.fixture, tt.allowDynamic, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBinary(t *testing.T) {
+	hello := string(readFixture(t, "hello_amd64"))
+	file := filepath.Join(t.TempDir(), "hello")
+	if err := os.WriteFile(file, []byte(hello), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []ExecutionRequest{{Code: hello}, {CodeFile: file}} {
+		if err := validateBinary(req, config.BinaryConfig{}, elf.EM_X86_64); err != nil {
+			t.Errorf("under the default cap: %v", err)
+		}
+		err := validateBinary(req, config.BinaryConfig{MaxBytes: 100}, elf.EM_X86_64)
+		if err == nil || !strings.Contains(err.Error(), "over the 100 byte limit") {
+			t.Errorf("%d bytes against a 100 byte cap: %v", len(hello), err)
+		}
+	}
+
+	if err := os.Chmod(file, 0o755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	err := validateBinary(ExecutionRequest{CodeFile: file}, config.BinaryConfig{}, elf.EM_X86_64)
+	if err == nil || !strings.Contains(err.Error(), "setuid") {
+		t.Errorf("setuid executable: %v", err)
+	}
+}
+
+func TestValidateRequest_BinaryOverInlineCap(t *testing.T) {
+	// An executable isn't held to the 1MB cap on source code, only to
+	// sandbox.binary.max_bytes; past it, it is refused before any ELF check.
+	d := newTestRunner(0, "", nil)
+	req := ExecutionRequest{Language: "binary", Code: strings.Repeat("\x00", maxInlineCode+1)}
+	if err := d.validateRequest(&req); err == nil || !strings.Contains(err.Error(), "not an ELF executable") {
+		t.Errorf("default cap: %v", err)
+	}
+	d.binary.MaxBytes = maxInlineCode
+	if err := d.validateRequest(&req); err == nil || !strings.Contains(err.Error(), "byte limit") {
+		t.Errorf("1MB cap: %v", err)
+	}
+}
+
+func TestDockerRunner_Binary(t *testing.T) {
+	if goruntime.GOOS != "linux" || goruntime.GOARCH != "amd64" {
+		t.Skip("the fixture is a linux/amd64 executable")
+	}
+	// The fake docker runs the executable it was asked to mount, as the
+	// container would, after checking it was mounted read-only and 0555.
+	d := scriptedDockerRunner(t, `for a; do case "$a" in *:/workspace/code:ro) exe=${a%%:*} ;; esac; done
+[ "$(stat -c %a "$exe")" = 555 ] || { echo "mode $(stat -c %a "$exe")" >&2; exit 99; }
+"$exe"`)
+	req := ExecutionRequest{Language: "binary", Code: string(readFixture(t, "hello_amd64")), Timeout: 5 * time.Second}
+
+	result, err := d.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 0 || result.Output != "hello, world\n" {
+		t.Errorf("exit %d, output %q, stderr %q", result.ExitCode, result.Output, result.Stderr)
+	}
+
+	req.Code = string(readFixture(t, "hello_amd64_dynamic"))
+	if _, err := d.Execute(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("dynamic executable: %v", err)
+	}
+	d.binary.AllowDynamic = true
+	if _, err := d.Execute(context.Background(), req); errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("dynamic executable with allow_dynamic: %v", err)
+	}
+}
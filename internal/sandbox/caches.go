@@ -0,0 +1,247 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+const (
+	cacheVolumePrefix = "sandbox-cache-"
+	cacheVolumeLabel  = "safe-agent-sandbox.cache" // value is the cache name
+	tenantSuffixLen   = 16                         // hex chars of the tenant hash in volume names
+)
+
+// CacheObserver receives cache volume metrics from the Docker backend.
+type CacheObserver interface {
+	CachePruned(cache string)
+}
+
+type cacheVolume struct {
+	name          string
+	containerPath string
+}
+
+// claudeCaches manages the Docker volumes that keep npm/pip caches between
+// claude executions. Volumes are created on first use and deleted once they
+// grow past maxBytes; the next execution that needs one starts it empty.
+type claudeCaches struct {
+	volumes  []cacheVolume
+	maxBytes int64
+	interval time.Duration
+	observer CacheObserver
+	docker   func(ctx context.Context, args ...string) ([]byte, error)
+
+	mu      sync.Mutex
+	created map[string]bool // volume names known to exist
+}
+
+func newClaudeCaches(cfg config.ClaudeCachesConfig, docker func(ctx context.Context, args ...string) ([]byte, error)) *claudeCaches {
+	if len(cfg.Volumes) == 0 {
+		return nil
+	}
+	c := &claudeCaches{
+		maxBytes: cfg.MaxBytes,
+		interval: cfg.CheckInterval,
+		docker:   docker,
+		created:  make(map[string]bool),
+	}
+	for _, v := range cfg.Volumes {
+		c.volumes = append(c.volumes, cacheVolume{name: v.Name, containerPath: v.ContainerPath})
+	}
+	return c
+}
+
+// cachesAllowed reports whether an execution may mount cache volumes. Caches
+// are writable and outlive the container, so they are only ever given to
+// claude, and never to a request that asked for a read-only filesystem.
+func cachesAllowed(isClaude bool, req ExecutionRequest) bool {
+	return isClaude && req.UseCaches && !req.ReadOnly
+}
+
+// cacheVolumeName namespaces a cache per tenant, so one API key can't plant
+// packages that another key's sessions will install. An empty tenant (no
+// API keys configured) shares one volume per cache.
+func cacheVolumeName(cache, tenant string) string {
+	if tenant == "" {
+		return cacheVolumePrefix + cache
+	}
+	sum := sha256.Sum256([]byte(tenant))
+	return cacheVolumePrefix + cache + "-" + hex.EncodeToString(sum[:])[:tenantSuffixLen]
+}
+
+// cacheOf returns the configured cache a volume belongs to.
+func (c *claudeCaches) cacheOf(volume string) (string, bool) {
+	for _, v := range c.volumes {
+		base := cacheVolumePrefix + v.name
+		if volume == base {
+			return v.name, true
+		}
+		suffix, ok := strings.CutPrefix(volume, base+"-")
+		if ok && len(suffix) == tenantSuffixLen && isHex(suffix) {
+			return v.name, true
+		}
+	}
+	return "", false
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// mountArgs returns the docker run flags mounting the tenant's caches.
+func (c *claudeCaches) mountArgs(tenant string) []string {
+	var args []string
+	for _, v := range c.volumes {
+		args = append(args, "-v", fmt.Sprintf("%s:%s:rw", cacheVolumeName(v.name, tenant), v.containerPath))
+	}
+	return args
+}
+
+// ensure creates the tenant's cache volumes that don't exist yet. Creating
+// them explicitly, rather than letting docker run do it, labels them.
+func (c *claudeCaches) ensure(ctx context.Context, tenant string) error {
+	for _, v := range c.volumes {
+		name := cacheVolumeName(v.name, tenant)
+		c.mu.Lock()
+		known := c.created[name]
+		c.mu.Unlock()
+		if known {
+			continue
+		}
+		// docker volume create is a no-op for an existing volume.
+		if _, err := c.docker(ctx, "volume", "create", "--label", cacheVolumeLabel+"="+v.name, name); err != nil {
+			return fmt.Errorf("creating cache volume %s: %w", name, err)
+		}
+		c.mu.Lock()
+		c.created[name] = true
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// parseVolumeSizes reads `docker system df -v` output formatted as one
+// "name<TAB>size" line per volume, with sizes as docker prints them (1.5GB).
+func parseVolumeSizes(out []byte) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		name, size, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("unexpected df line %q", line)
+		}
+		n, err := parseDockerSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %w", name, err)
+		}
+		sizes[name] = n
+	}
+	return sizes, scanner.Err()
+}
+
+// dockerSizeUnits are the decimal units docker uses for human-readable sizes.
+var dockerSizeUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"PB", 1e15}, {"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"B", 1},
+}
+
+func parseDockerSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	for _, u := range dockerSizeUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil || f < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return int64(f * u.scale), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q", s)
+}
+
+// oversized returns the cache volumes larger than maxBytes, largest first.
+// Volumes that aren't ours are never returned.
+func (c *claudeCaches) oversized(sizes map[string]int64) []string {
+	var over []string
+	for name, size := range sizes {
+		if _, ok := c.cacheOf(name); ok && size > c.maxBytes {
+			over = append(over, name)
+		}
+	}
+	sort.Slice(over, func(i, j int) bool {
+		if sizes[over[i]] != sizes[over[j]] {
+			return sizes[over[i]] > sizes[over[j]]
+		}
+		return over[i] < over[j]
+	})
+	return over
+}
+
+// check deletes cache volumes over the cap. A volume in use by a running
+// container can't be removed; it is retried on the next check.
+func (c *claudeCaches) check(ctx context.Context) {
+	out, err := c.docker(ctx, "system", "df", "-v", "--format", "{{range .Volumes}}{{.Name}}\t{{.Size}}\n{{end}}")
+	if err != nil {
+		log.Warn().Err(err).Msg("cache volume size check failed")
+		return
+	}
+	sizes, err := parseVolumeSizes(out)
+	if err != nil {
+		log.Warn().Err(err).Msg("cache volume size check failed")
+		return
+	}
+
+	for _, name := range c.oversized(sizes) {
+		if _, err := c.docker(ctx, "volume", "rm", name); err != nil {
+			log.Warn().Err(err).Str("volume", name).Msg("cache volume over cap but not removable, will retry")
+			continue
+		}
+		c.mu.Lock()
+		delete(c.created, name)
+		c.mu.Unlock()
+
+		cache, _ := c.cacheOf(name)
+		log.Info().Str("volume", name).Int64("size_bytes", sizes[name]).Int64("max_bytes", c.maxBytes).Msg("pruned cache volume")
+		if c.observer != nil {
+			c.observer.CachePruned(cache)
+		}
+	}
+}
+
+// monitorLoop checks volume sizes every interval until ctx is cancelled.
+func (c *claudeCaches) monitorLoop(ctx context.Context) {
+	if c.maxBytes <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			c.check(checkCtx)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// fakeDocker records docker invocations and answers them from canned output.
+type fakeDocker struct {
+	mu     sync.Mutex
+	calls  [][]string
+	output map[string]string // keyed by the first two args
+	fail   map[string]bool   // full command lines that fail
+}
+
+func (f *fakeDocker) run(_ context.Context, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, args)
+	if f.fail[strings.Join(args, " ")] {
+		return nil, errors.New("volume is in use")
+	}
+	return []byte(f.output[strings.Join(args[:2], " ")]), nil
+}
+
+func (f *fakeDocker) commands(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, c := range f.calls {
+		if line := strings.Join(c, " "); strings.HasPrefix(line, prefix) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+type pruneCounter struct {
+	mu     sync.Mutex
+	pruned map[string]int
+}
+
+func (p *pruneCounter) CachePruned(cache string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruned[cache]++
+}
+
+func testCaches(docker *fakeDocker, maxBytes int64) *claudeCaches {
+	return newClaudeCaches(config.ClaudeCachesConfig{
+		Volumes: []config.CacheVolumeConfig{
+			{Name: "npm", ContainerPath: "/home/node/.npm"},
+			{Name: "pip", ContainerPath: "/home/node/.cache/pip"},
+		},
+		MaxBytes: maxBytes,
+	}, docker.run)
+}
+
+func TestNewClaudeCaches_NoneConfigured(t *testing.T) {
+	if c := newClaudeCaches(config.ClaudeCachesConfig{MaxBytes: 1}, nil); c != nil {
+		t.Errorf("newClaudeCaches with no volumes = %+v, want nil", c)
+	}
+}
+
+func TestCacheVolumeName_TenantNamespacing(t *testing.T) {
+	a := cacheVolumeName("npm", "tenant-a")
+	b := cacheVolumeName("npm", "tenant-b")
+	if a == b {
+		t.Errorf("tenants share volume %s", a)
+	}
+	if a != cacheVolumeName("npm", "tenant-a") {
+		t.Error("volume name is not stable for a tenant")
+	}
+	if got := cacheVolumeName("npm", ""); got != "sandbox-cache-npm" {
+		t.Errorf("anonymous volume = %s, want sandbox-cache-npm", got)
+	}
+
+	c := testCaches(&fakeDocker{}, 0)
+	for _, name := range []string{a, b, "sandbox-cache-npm"} {
+		if cache, ok := c.cacheOf(name); !ok || cache != "npm" {
+			t.Errorf("cacheOf(%s) = %q, %v", name, cache, ok)
+		}
+	}
+	for _, name := range []string{"sandbox-cache-cargo", "sandbox-cache-npm-x", "postgres-data", "sandbox-cache-npm-" + strings.Repeat("z", tenantSuffixLen)} {
+		if _, ok := c.cacheOf(name); ok {
+			t.Errorf("cacheOf(%s) claimed a volume that isn't ours", name)
+		}
+	}
+}
+
+func TestClaudeCaches_MountArgsAndEnsure(t *testing.T) {
+	docker := &fakeDocker{}
+	c := testCaches(docker, 0)
+
+	want := []string{
+		"-v", cacheVolumeName("npm", "t1") + ":/home/node/.npm:rw",
+		"-v", cacheVolumeName("pip", "t1") + ":/home/node/.cache/pip:rw",
+	}
+	if got := c.mountArgs("t1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("mountArgs = %v, want %v", got, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := c.ensure(context.Background(), "t1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if creates := docker.commands("volume create"); len(creates) != 2 {
+		t.Errorf("volume create calls = %v, want one per cache", creates)
+	}
+	if err := c.ensure(context.Background(), "t2"); err != nil {
+		t.Fatal(err)
+	}
+	if creates := docker.commands("volume create"); len(creates) != 4 {
+		t.Errorf("volume create calls after a second tenant = %d, want 4", len(creates))
+	}
+
+	docker.fail = map[string]bool{"volume create --label " + cacheVolumeLabel + "=npm " + cacheVolumeName("npm", "t3"): true}
+	if err := c.ensure(context.Background(), "t3"); err == nil {
+		t.Error("ensure succeeded when volume create failed")
+	}
+}
+
+func TestParseVolumeSizes(t *testing.T) {
+	out := "sandbox-cache-npm\t6.2GB\nsandbox-cache-pip\t512.5MB\n\npostgres-data\t0B\nsmall\t12kB\n"
+	sizes, err := parseVolumeSizes([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		"sandbox-cache-npm": 6_200_000_000,
+		"sandbox-cache-pip": 512_500_000,
+		"postgres-data":     0,
+		"small":             12_000,
+	}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sizes = %v, want %v", sizes, want)
+	}
+
+	for _, bad := range []string{"no-tab 1GB", "vol\t1XB", "vol\t-1GB", "vol\tGB"} {
+		if _, err := parseVolumeSizes([]byte(bad)); err == nil {
+			t.Errorf("parseVolumeSizes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestClaudeCaches_CheckPrunesOversized(t *testing.T) {
+	npmA := cacheVolumeName("npm", "a")
+	npmB := cacheVolumeName("npm", "b")
+	pipA := cacheVolumeName("pip", "a")
+	docker := &fakeDocker{
+		output: map[string]string{"system df": strings.Join([]string{
+			npmA + "\t7GB",
+			npmB + "\t1GB",
+			pipA + "\t5.5GB",
+			"postgres-data\t90GB",
+		}, "\n")},
+		fail: map[string]bool{"volume rm " + pipA: true},
+	}
+	counter := &pruneCounter{pruned: make(map[string]int)}
+	c := testCaches(docker, 5_000_000_000)
+	c.observer = counter
+	if err := c.ensure(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.check(context.Background())
+
+	// Largest first; the foreign volume and the one under the cap are left alone.
+	wantRm := []string{"volume rm " + npmA, "volume rm " + pipA}
+	if got := docker.commands("volume rm"); !reflect.DeepEqual(got, wantRm) {
+		t.Errorf("removals = %v, want %v", got, wantRm)
+	}
+	if !reflect.DeepEqual(counter.pruned, map[string]int{"npm": 1}) {
+		t.Errorf("pruned = %v, want only the removed npm volume", counter.pruned)
+	}
+	if c.created[npmA] || !c.created[pipA] {
+		t.Errorf("created = %v, want the removed volume forgotten and the busy one kept", c.created)
+	}
+
+	// The busy volume is retried on the next check.
+	docker.fail = nil
+	c.check(context.Background())
+	if got := docker.commands("volume rm " + pipA); len(got) != 2 {
+		t.Errorf("busy volume removals = %d, want a retry", len(got))
+	}
+	if counter.pruned["pip"] != 1 {
+		t.Errorf("pruned = %v after retry", counter.pruned)
+	}
+}
@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitCgroupEmpty blocks until the cgroup v2 directory dir has no process
+// left, woken by inotify as cgroup.events changes, or ctx is done.
+func waitCgroupEmpty(ctx context.Context, dir string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	events := os.NewFile(uintptr(fd), "inotify")
+	defer events.Close()
+	if _, err := unix.InotifyAddWatch(fd, filepath.Join(dir, "cgroup.events"), unix.IN_MODIFY); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = events.SetReadDeadline(time.Now()) })
+	defer stop()
+	buf := make([]byte, 4096)
+	for {
+		if empty, err := cgroupEmpty(dir); err != nil || empty {
+			return err
+		}
+		if _, err := events.Read(buf); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+)
+
+// waitCgroupEmpty needs inotify, which only Linux has; without it there is
+// no reading at exit.
+func waitCgroupEmpty(context.Context, string) error {
+	return errors.New("cgroup events need inotify")
+}
@@ -0,0 +1,193 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ChaosMode selects the failure a chaos execution synthesizes.
+type ChaosMode string
+
+const (
+	ChaosTimeout     ChaosMode = "timeout"
+	ChaosOOM         ChaosMode = "oom"
+	ChaosRateLimited ChaosMode = "rate_limited"
+	ChaosInfraError  ChaosMode = "infra_error"
+	ChaosSlowStream  ChaosMode = "slow_stream"
+)
+
+// ParseChaosMode validates a chaos mode name.
+func ParseChaosMode(s string) (ChaosMode, error) {
+	switch m := ChaosMode(s); m {
+	case ChaosTimeout, ChaosOOM, ChaosRateLimited, ChaosInfraError, ChaosSlowStream:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: unknown chaos mode %q (timeout, oom, rate_limited, infra_error, slow_stream)", ErrInvalidRequest, s)
+	}
+}
+
+// ChaosSpec asks the chaos backend to fake a failure instead of running code.
+type ChaosSpec struct {
+	Mode  ChaosMode
+	Delay time.Duration // wait before producing the result (slow_stream: between chunks)
+}
+
+// slowStreamChunks is how many stdout chunks slow_stream emits.
+const slowStreamChunks = 5
+
+// ChaosBackend wraps a Backend and synthesizes failures for requests that
+// carry a ChaosSpec, without launching a container. Requests without one are
+// passed through untouched. Only installed when sandbox.chaos.enabled is set.
+type ChaosBackend struct {
+	inner Backend
+}
+
+// NewChaosBackend wraps inner with failure injection.
+func NewChaosBackend(inner Backend) *ChaosBackend {
+	return &ChaosBackend{inner: inner}
+}
+
+func (c *ChaosBackend) Execute(ctx context.Context, req ExecutionRequest) (*ExecutionResult, error) {
+	if req.Chaos == nil {
+		return c.inner.Execute(ctx, req)
+	}
+	return c.synthesize(ctx, req, io.Discard)
+}
+
+func (c *ChaosBackend) ExecuteStreaming(ctx context.Context, req ExecutionRequest, stdout, stderr io.Writer) (*ExecutionResult, error) {
+	if req.Chaos == nil {
+		return c.inner.ExecuteStreaming(ctx, req, stdout, stderr)
+	}
+	return c.synthesize(ctx, req, stdout)
+}
+
+// Name reports the wrapped backend, so chaos runs are labelled like real ones.
+func (c *ChaosBackend) Name() string { return c.inner.Name() }
+
+func (c *ChaosBackend) Close() error {
+	return c.inner.Close()
+}
+
+// synthesize builds the same result/error pair the real runners return for
+// the chosen condition, so handler mapping, metrics and audit run unchanged.
+func (c *ChaosBackend) synthesize(ctx context.Context, req ExecutionRequest, stdout io.Writer) (*ExecutionResult, error) {
+	execID := executionID(req)
+	codeHash := requestCodeHash(req)
+	spec := req.Chaos
+	start := time.Now()
+
+	log.Warn().
+		Str("exec_id", execID).
+		Str("chaos_mode", string(spec.Mode)).
+		Dur("delay", spec.Delay).
+		Msg("chaos execution: synthesizing failure")
+	if req.Admitted != nil {
+		req.Admitted() // no slot to wait for
+	}
+
+	result := &ExecutionResult{
+		ID:       execID,
+		CodeHash: codeHash,
+		Chaos:    true,
+	}
+
+	if spec.Mode != ChaosSlowStream {
+		if err := chaosSleep(ctx, spec.Delay); err != nil {
+			result.ExitCode = -1
+			result.ExitClass = ExitManualKill
+			result.Duration = time.Since(start)
+			return result, &ExecutionError{ExecID: execID, Op: "chaos", Err: err}
+		}
+	}
+
+	switch spec.Mode {
+	case ChaosTimeout:
+		timeout := req.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		result.ExitCode = -1
+		result.ExitClass = ExitTimeoutKill
+		result.SecurityEvents = []SecurityEvent{{
+			Type:   "timeout",
+			Source: SourceRuntime,
+			Detail: fmt.Sprintf("execution exceeded %s timeout", timeout),
+		}}
+		result.Duration = time.Since(start)
+		return result, ErrTimeout
+
+	case ChaosOOM:
+		result.ExitCode = exitCodeSIGKILL
+		result.ExitClass = ExitOOMKill
+		result.SecurityEvents = []SecurityEvent{{
+			Type:   "oom_kill",
+			Source: SourceRuntime,
+			Detail: "process killed by OOM killer",
+		}}
+
+	case ChaosRateLimited:
+		return nil, &ExecutionError{ExecID: execID, Op: "chaos", Err: ErrRateLimited}
+
+	case ChaosInfraError:
+		result.ExitCode = 125
+		result.Stderr = "docker: Error response from daemon: chaos: injected infrastructure error.\n"
+		result.ExitClass = classifyExit(exitInfo{code: result.ExitCode, stderr: result.Stderr})
+
+	case ChaosSlowStream:
+		var out []byte
+		for i := 1; i <= slowStreamChunks; i++ {
+			if err := chaosSleep(ctx, spec.Delay); err != nil {
+				result.Output = string(out)
+				result.ExitCode = -1
+				result.ExitClass = ExitManualKill
+				result.Duration = time.Since(start)
+				return result, &ExecutionError{ExecID: execID, Op: "chaos", Err: err}
+			}
+			chunk := fmt.Sprintf("chaos: chunk %d/%d\n", i, slowStreamChunks)
+			out = append(out, chunk...)
+			_, _ = io.WriteString(stdout, chunk)
+		}
+		result.Output = string(out)
+		result.ExitClass = ExitUser
+
+	default:
+		return nil, &ExecutionError{ExecID: execID, Op: "chaos", Err: fmt.Errorf("%w: unknown chaos mode %q", ErrInvalidRequest, spec.Mode)}
+	}
+
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+func chaosSleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats reports the wrapped backend's.
+func (c *ChaosBackend) Stats() (SlotStats, bool) {
+	if sr, ok := c.inner.(StatsReporter); ok {
+		return sr.Stats()
+	}
+	return SlotStats{}, false
+}
+
+// DiskPressure reports the wrapped backend's.
+func (c *ChaosBackend) DiskPressure() (DiskStatus, bool) {
+	if dr, ok := c.inner.(DiskReporter); ok {
+		return dr.DiskPressure()
+	}
+	return DiskStatus{}, false
+}
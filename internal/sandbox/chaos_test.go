@@ -0,0 +1,145 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passthroughBackend records whether the chaos wrapper delegated to it.
+type passthroughBackend struct {
+	called bool
+}
+
+func (p *passthroughBackend) Execute(_ context.Context, _ ExecutionRequest) (*ExecutionResult, error) {
+	p.called = true
+	return &ExecutionResult{ID: "real", ExitClass: ExitUser}, nil
+}
+
+func (p *passthroughBackend) ExecuteStreaming(_ context.Context, _ ExecutionRequest, _, _ io.Writer) (*ExecutionResult, error) {
+	p.called = true
+	return &ExecutionResult{ID: "real", ExitClass: ExitUser}, nil
+}
+
+func (p *passthroughBackend) Close() error { return nil }
+
+func (p *passthroughBackend) Name() string { return "passthrough" }
+
+func TestParseChaosMode(t *testing.T) {
+	for _, m := range []string{"timeout", "oom", "rate_limited", "infra_error", "slow_stream"} {
+		if _, err := ParseChaosMode(m); err != nil {
+			t.Errorf("ParseChaosMode(%q) error = %v", m, err)
+		}
+	}
+	if _, err := ParseChaosMode("meteor"); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("ParseChaosMode(meteor) error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestChaosBackend_PassThrough(t *testing.T) {
+	inner := &passthroughBackend{}
+	c := NewChaosBackend(inner)
+
+	result, err := c.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "print(1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inner.called || result.ID != "real" || result.Chaos {
+		t.Errorf("request without chaos spec was not passed through: %+v", result)
+	}
+}
+
+func TestChaosBackend_Modes(t *testing.T) {
+	tests := []struct {
+		mode      ChaosMode
+		wantErr   error
+		wantNil   bool
+		wantCode  int
+		wantClass ExitClass
+	}{
+		{ChaosTimeout, ErrTimeout, false, -1, ExitTimeoutKill},
+		{ChaosOOM, nil, false, 137, ExitOOMKill},
+		{ChaosRateLimited, ErrRateLimited, true, 0, ""},
+		{ChaosInfraError, nil, false, 125, ExitInfraError},
+		{ChaosSlowStream, nil, false, 0, ExitUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			inner := &passthroughBackend{}
+			c := NewChaosBackend(inner)
+			result, err := c.Execute(context.Background(), ExecutionRequest{
+				Language: "python",
+				Code:     "print(1)",
+				Chaos:    &ChaosSpec{Mode: tt.mode},
+			})
+
+			if inner.called {
+				t.Fatal("chaos request reached the real backend")
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantNil {
+				if result != nil {
+					t.Errorf("result = %+v, want nil", result)
+				}
+				return
+			}
+			if !result.Chaos {
+				t.Error("result not marked as chaos")
+			}
+			if result.ExitCode != tt.wantCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantCode)
+			}
+			if result.ExitClass != tt.wantClass {
+				t.Errorf("ExitClass = %q, want %q", result.ExitClass, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestChaosBackend_SlowStream(t *testing.T) {
+	c := NewChaosBackend(&passthroughBackend{})
+	var stdout bytes.Buffer
+
+	start := time.Now()
+	result, err := c.ExecuteStreaming(context.Background(), ExecutionRequest{
+		Chaos: &ChaosSpec{Mode: ChaosSlowStream, Delay: 10 * time.Millisecond},
+	}, &stdout, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < slowStreamChunks*10*time.Millisecond {
+		t.Errorf("slow_stream finished in %s, expected at least %s", elapsed, slowStreamChunks*10*time.Millisecond)
+	}
+	if got := strings.Count(stdout.String(), "\n"); got != slowStreamChunks {
+		t.Errorf("streamed %d chunks, want %d", got, slowStreamChunks)
+	}
+	if result.Output != stdout.String() {
+		t.Errorf("Output = %q, want streamed %q", result.Output, stdout.String())
+	}
+}
+
+func TestChaosBackend_DelayHonorsCancel(t *testing.T) {
+	c := NewChaosBackend(&passthroughBackend{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	result, err := c.Execute(ctx, ExecutionRequest{
+		Chaos: &ChaosSpec{Mode: ChaosOOM, Delay: time.Minute},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context deadline", err)
+	}
+	if result.ExitClass != ExitManualKill {
+		t.Errorf("ExitClass = %q, want manual_kill", result.ExitClass)
+	}
+}
@@ -0,0 +1,296 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// claudeContractVersion is the manifest format this server understands. The
+// image's entrypoint prints it for --contract-check; see
+// deployments/docker/Dockerfile.claude.
+const claudeContractVersion = 1
+
+const (
+	claudePromptPath = "/tmp/prompt.txt"
+	claudeSecretFile = "/run/secrets/auth_token"
+	// claudeContractTimeout bounds the inspect and the check container
+	// together; claude --help starts node, so it gets longer than a probe.
+	claudeContractTimeout = 30 * time.Second
+)
+
+// ErrClaudeImageIncompatible is returned for a claude execution whose image
+// failed the contract check: it would start, but the server couldn't drive
+// claude in it.
+var ErrClaudeImageIncompatible = errors.New("claude image incompatible")
+
+// ClaudeContractError lists what a claude execution needs that the image's
+// manifest doesn't declare.
+type ClaudeContractError struct {
+	Image   string
+	Missing []string // e.g. "flag:--max-turns", "env:ANTHROPIC_BASE_URL"
+}
+
+func (e *ClaudeContractError) Error() string {
+	return fmt.Sprintf("%s: %s lacks %s", ErrClaudeImageIncompatible, e.Image, strings.Join(e.Missing, ", "))
+}
+
+func (e *ClaudeContractError) Unwrap() error { return ErrClaudeImageIncompatible }
+
+// ClaudeManifest is what the claude image's entrypoint prints when run with
+// --contract-check: the claude CLI it has and what the entrypoint honours.
+type ClaudeManifest struct {
+	Contract      int      `json:"contract"`
+	ClaudeVersion string   `json:"claude_version"`
+	PromptPath    string   `json:"prompt_path"`  // where the image expects the prompt
+	Env           []string `json:"env"`          // environment variables passed on to claude
+	SecretFiles   []string `json:"secret_files"` // files the entrypoint reads credentials from
+	Flags         []string `json:"flags"`        // claude CLI flags found in claude --help
+}
+
+// ParseClaudeManifest parses the output of --contract-check. The manifest is
+// its last non-empty line, so whatever claude logs before it is ignored.
+func ParseClaudeManifest(out []byte) (ClaudeManifest, error) {
+	var m ClaudeManifest
+	lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+	last := bytes.TrimSpace(lines[len(lines)-1])
+	if len(last) == 0 {
+		return m, errors.New("no manifest in --contract-check output")
+	}
+	if err := json.Unmarshal(last, &m); err != nil {
+		return m, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if m.Contract != claudeContractVersion {
+		return m, fmt.Errorf("manifest contract version %d, want %d", m.Contract, claudeContractVersion)
+	}
+	return m, nil
+}
+
+// claudeRequirements lists what a claude execution needs of its image: the
+// flags every session is run with and those for opts, and how the token
+// reaches claude, which is ANTHROPIC_BASE_URL pointing at the auth proxy when
+// proxy is set and the secret file otherwise.
+func claudeRequirements(proxy bool, opts *runtime.ClaudeOptions) []string {
+	reqs := []string{
+		"prompt_path:" + claudePromptPath,
+		"flag:--print",
+		"flag:--dangerously-skip-permissions",
+		"flag:--output-format",
+		"flag:--verbose",
+	}
+	if proxy {
+		reqs = append(reqs, "env:ANTHROPIC_BASE_URL", "env:ANTHROPIC_API_KEY")
+	} else {
+		reqs = append(reqs, "secret_file:"+claudeSecretFile)
+	}
+	if opts != nil {
+		if opts.Model != "" {
+			reqs = append(reqs, "flag:--model")
+		}
+		if opts.MaxTurns > 0 {
+			reqs = append(reqs, "flag:--max-turns")
+		}
+		if len(opts.AllowedTools) > 0 {
+			reqs = append(reqs, "flag:--allowedTools")
+		}
+		if len(opts.DisallowedTools) > 0 {
+			reqs = append(reqs, "flag:--disallowedTools")
+		}
+	}
+	return reqs
+}
+
+// missing returns the requirements m doesn't meet, in order.
+func (m ClaudeManifest) missing(reqs []string) []string {
+	have := map[string]bool{"prompt_path:" + m.PromptPath: true}
+	for _, e := range m.Env {
+		have["env:"+e] = true
+	}
+	for _, f := range m.SecretFiles {
+		have["secret_file:"+f] = true
+	}
+	for _, f := range m.Flags {
+		have["flag:"+f] = true
+	}
+	var out []string
+	for _, r := range reqs {
+		if !have[r] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ClaudeContractChecker is implemented by backends that check the claude
+// image's contract, for GET and POST /admin/claude-contract.
+type ClaudeContractChecker interface {
+	// ClaudeContract returns the contract check of the claude image, running
+	// it when the image's digest is new or when recheck is set.
+	ClaudeContract(ctx context.Context, recheck bool) ClaudeContractStatus
+}
+
+// ClaudeContractStatus is the result of checking the claude image. Missing
+// is what the server's own configuration needs that the image lacks; options
+// a request adds are checked per execution. Error is set when there is no
+// manifest: the image isn't pulled, or its entrypoint has no contract check.
+type ClaudeContractStatus struct {
+	Image     string          `json:"image"`
+	Digest    string          `json:"digest,omitempty"`
+	Manifest  *ClaudeManifest `json:"manifest,omitempty"`
+	Missing   []string        `json:"missing,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CheckedAt time.Time       `json:"checked_at"`
+}
+
+// Compatible reports whether claude executions without options can run.
+func (s ClaudeContractStatus) Compatible() bool {
+	return s.Manifest != nil && len(s.Missing) == 0
+}
+
+// claudeContract runs the claude image's contract check once per image
+// digest, the way warmupProbes probes runtime images.
+type claudeContract struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+	now    func() time.Time
+	proxy  bool // the auth proxy is on; see claudeRequirements
+
+	mu     sync.Mutex
+	images map[string]claudeCheck // by image reference
+}
+
+type claudeCheck struct {
+	status  ClaudeContractStatus
+	pulled  bool // the image was found; a missing one is looked up every time
+	checked time.Time
+}
+
+func newClaudeContract(docker func(ctx context.Context, args ...string) ([]byte, error), proxy bool) *claudeContract {
+	return &claudeContract{docker: docker, now: time.Now, proxy: proxy, images: make(map[string]claudeCheck)}
+}
+
+// get returns the contract check of image, running it when the image's
+// digest has changed since the last one, or when recheck is set.
+func (c *claudeContract) get(ctx context.Context, image string, recheck bool) (ClaudeContractStatus, bool) {
+	now := c.now()
+	c.mu.Lock()
+	cached, found := c.images[image]
+	c.mu.Unlock()
+	if !recheck && found && cached.pulled && now.Sub(cached.checked) < warmupRecheck {
+		return cached.status, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, claudeContractTimeout)
+	defer cancel()
+	entry := claudeCheck{checked: now, status: ClaudeContractStatus{Image: image, CheckedAt: now}}
+	out, err := c.docker(ctx, "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		entry.status.Error = fmt.Sprintf("image not found: %v", err)
+		c.store(image, entry)
+		return entry.status, false
+	}
+	entry.pulled = true
+	entry.status.Digest = strings.TrimSpace(string(out))
+	if !recheck && found && cached.pulled && cached.status.Digest == entry.status.Digest {
+		entry.status = cached.status
+		c.store(image, entry)
+		return entry.status, true
+	}
+
+	out, err = c.docker(ctx,
+		"run", "--rm",
+		"--network", "none",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+		"--tmpfs", "/tmp:size=16m",
+		"--env", "HOME=/tmp",
+		"--user", "65534:65534",
+		"--memory", "256m",
+		"--pids-limit", "64",
+		image, "--contract-check")
+	var manifest ClaudeManifest
+	if err == nil {
+		manifest, err = ParseClaudeManifest(out)
+	}
+	if err != nil {
+		entry.status.Error = fmt.Sprintf("contract check failed (is the image built from deployments/docker/Dockerfile.claude?): %v", err)
+		log.Error().Err(err).Str("image", image).Str("digest", entry.status.Digest).Msg("claude image contract check failed; claude executions will be refused")
+	} else {
+		entry.status.Manifest = &manifest
+		entry.status.Missing = manifest.missing(claudeRequirements(c.proxy, nil))
+		if len(entry.status.Missing) > 0 {
+			log.Error().Str("image", image).Str("claude_version", manifest.ClaudeVersion).Strs("missing", entry.status.Missing).
+				Msg("claude image does not meet the contract; claude executions will be refused")
+		} else {
+			log.Info().Str("image", image).Str("digest", entry.status.Digest).Str("claude_version", manifest.ClaudeVersion).
+				Msg("claude image meets the contract")
+		}
+	}
+	c.store(image, entry)
+	return entry.status, true
+}
+
+func (c *claudeContract) store(image string, entry claudeCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images[image] = entry
+}
+
+// verify returns a ClaudeContractError when image can't run req. An image
+// that isn't pulled passes: docker run reports that better.
+func (c *claudeContract) verify(ctx context.Context, image string, req ExecutionRequest) error {
+	status, pulled := c.get(ctx, image, false)
+	if !pulled {
+		return nil
+	}
+	if status.Manifest == nil {
+		return &ClaudeContractError{Image: image, Missing: []string{"contract_check"}}
+	}
+	if missing := status.Manifest.missing(claudeRequirements(c.proxy, req.Claude)); len(missing) > 0 {
+		return &ClaudeContractError{Image: image, Missing: missing}
+	}
+	return nil
+}
+
+// ClaudeContract checks the claude runtime's image. Without
+// sandbox.verify_claude_contract it reports an error saying so.
+func (d *DockerRunner) ClaudeContract(ctx context.Context, recheck bool) ClaudeContractStatus {
+	rt, err := d.runtimes.Get("claude")
+	if err != nil {
+		return ClaudeContractStatus{Error: "no claude runtime is registered", CheckedAt: time.Now()}
+	}
+	if d.contract == nil {
+		return ClaudeContractStatus{Image: rt.Image(), Error: "sandbox.verify_claude_contract is off", CheckedAt: time.Now()}
+	}
+	status, _ := d.contract.get(ctx, rt.Image(), recheck)
+	return status
+}
+
+// verifyClaudeContract refuses a claude execution its image can't run.
+func (d *DockerRunner) verifyClaudeContract(ctx context.Context, req ExecutionRequest) error {
+	if req.Language != "claude" || d.contract == nil {
+		return nil
+	}
+	rt, err := d.runtimes.Get("claude")
+	if err != nil {
+		return nil
+	}
+	return d.contract.verify(ctx, rt.Image(), req)
+}
+
+// ClaudeContract reports the wrapped backend's.
+func (c *ChaosBackend) ClaudeContract(ctx context.Context, recheck bool) ClaudeContractStatus {
+	if cc, ok := c.inner.(ClaudeContractChecker); ok {
+		return cc.ClaudeContract(ctx, recheck)
+	}
+	return ClaudeContractStatus{Error: c.inner.Name() + " backend does not run claude", CheckedAt: time.Now()}
+}
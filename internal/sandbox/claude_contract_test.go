@@ -0,0 +1,156 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	claudeImage = "sandbox-claude:latest"
+	// fullManifest is what the entrypoint prints for a current claude CLI.
+	fullManifest = `{"contract":1,"claude_version":"1.0.30","prompt_path":"/tmp/prompt.txt",` +
+		`"env":["ANTHROPIC_BASE_URL","ANTHROPIC_API_KEY"],"secret_files":["/run/secrets/auth_token"],` +
+		`"flags":["--print","--dangerously-skip-permissions","--output-format","--verbose","--model","--max-turns","--allowedTools","--disallowedTools"]}`
+)
+
+func TestParseClaudeManifest(t *testing.T) {
+	m, err := ParseClaudeManifest([]byte("npm notice a new version is available\n" + fullManifest + "\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ClaudeVersion != "1.0.30" || m.PromptPath != "/tmp/prompt.txt" || len(m.Flags) != 8 {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	for name, out := range map[string]string{
+		"empty":         "  \n",
+		"not json":      "exec: --contract-check: not found",
+		"newer version": `{"contract":2,"claude_version":"2.0.0"}`,
+		"no version":    `{"claude_version":"1.0.30"}`,
+	} {
+		if _, err := ParseClaudeManifest([]byte(out)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestClaudeRequirements(t *testing.T) {
+	m, err := ParseClaudeManifest([]byte(fullManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &runtime.ClaudeOptions{Model: "claude-sonnet-4-5", MaxTurns: 5, AllowedTools: []string{"Read"}, DisallowedTools: []string{"Bash"}}
+	for _, proxy := range []bool{false, true} {
+		if missing := m.missing(claudeRequirements(proxy, opts)); len(missing) != 0 {
+			t.Errorf("proxy %v: full manifest missing %v", proxy, missing)
+		}
+	}
+
+	// An older CLI without --max-turns and an entrypoint that only reads
+	// the secret file: fine until a request or the proxy needs more.
+	old := ClaudeManifest{
+		Contract: 1, PromptPath: "/tmp/prompt.txt", SecretFiles: []string{"/run/secrets/auth_token"},
+		Flags: []string{"--print", "--dangerously-skip-permissions", "--output-format", "--verbose", "--model"},
+	}
+	tests := []struct {
+		name  string
+		proxy bool
+		opts  *runtime.ClaudeOptions
+		want  []string
+	}{
+		{"no options", false, nil, nil},
+		{"model", false, &runtime.ClaudeOptions{Model: "claude-sonnet-4-5"}, nil},
+		{"max turns", false, &runtime.ClaudeOptions{MaxTurns: 3}, []string{"flag:--max-turns"}},
+		{"tools", false, &runtime.ClaudeOptions{AllowedTools: []string{"Read"}, DisallowedTools: []string{"Bash"}}, []string{"flag:--allowedTools", "flag:--disallowedTools"}},
+		{"proxy", true, nil, []string{"env:ANTHROPIC_BASE_URL", "env:ANTHROPIC_API_KEY"}},
+	}
+	for _, tt := range tests {
+		if got := old.missing(claudeRequirements(tt.proxy, tt.opts)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: missing %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	moved := old
+	moved.PromptPath = "/workspace/prompt.txt"
+	if got := moved.missing(claudeRequirements(false, nil)); !reflect.DeepEqual(got, []string{"prompt_path:/tmp/prompt.txt"}) {
+		t.Errorf("moved prompt: missing %v", got)
+	}
+}
+
+func testClaudeContract(docker *fakeDocker, proxy bool) (*claudeContract, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newClaudeContract(docker.run, proxy)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestClaudeContract_CachedByDigest(t *testing.T) {
+	docker := &fakeDocker{output: map[string]string{"image inspect": "sha256:aaa\n", "run --rm": fullManifest + "\n"}}
+	c, now := testClaudeContract(docker, true)
+	ctx := context.Background()
+
+	status, pulled := c.get(ctx, claudeImage, false)
+	if !pulled || !status.Compatible() || status.Digest != "sha256:aaa" {
+		t.Fatalf("get() = %+v, %v", status, pulled)
+	}
+	runs := docker.commands("run --rm")
+	if len(runs) != 1 || !strings.Contains(runs[0], "--network none") || !strings.HasSuffix(runs[0], claudeImage+" --contract-check") {
+		t.Errorf("check runs = %v", runs)
+	}
+
+	// The same digest isn't checked again, past the recheck interval or not.
+	c.get(ctx, claudeImage, false)
+	*now = now.Add(warmupRecheck)
+	c.get(ctx, claudeImage, false)
+	if n := len(docker.commands("run --rm")); n != 1 {
+		t.Errorf("checked %d times for one digest, want 1", n)
+	}
+
+	// A rebuilt image is, and so is any image when asked to recheck.
+	docker.output["image inspect"] = "sha256:bbb\n"
+	*now = now.Add(warmupRecheck)
+	c.get(ctx, claudeImage, false)
+	c.get(ctx, claudeImage, true)
+	if n := len(docker.commands("run --rm")); n != 3 {
+		t.Errorf("checked %d times, want 3", n)
+	}
+}
+
+func TestClaudeContract_Verify(t *testing.T) {
+	ctx := context.Background()
+	maxTurns := ExecutionRequest{Language: "claude", Code: "hi", Claude: &runtime.ClaudeOptions{MaxTurns: 3}}
+
+	// An image without --max-turns runs requests that don't ask for it.
+	noTurns := strings.Replace(fullManifest, `"--max-turns",`, "", 1)
+	c, _ := testClaudeContract(&fakeDocker{output: map[string]string{"image inspect": "sha256:aaa", "run --rm": noTurns}}, false)
+	if err := c.verify(ctx, claudeImage, ExecutionRequest{Language: "claude", Code: "hi"}); err != nil {
+		t.Errorf("request without options: %v", err)
+	}
+	err := c.verify(ctx, claudeImage, maxTurns)
+	var ce *ClaudeContractError
+	if !errors.Is(err, ErrClaudeImageIncompatible) || !errors.As(err, &ce) || !reflect.DeepEqual(ce.Missing, []string{"flag:--max-turns"}) {
+		t.Errorf("max_turns: %v", err)
+	}
+
+	// An image built before the contract check existed runs nothing.
+	c, _ = testClaudeContract(&fakeDocker{output: map[string]string{"image inspect": "sha256:aaa"}}, false)
+	if err := c.verify(ctx, claudeImage, ExecutionRequest{Language: "claude", Code: "hi"}); !errors.As(err, &ce) || ce.Missing[0] != "contract_check" {
+		t.Errorf("no manifest: %v", err)
+	}
+
+	// An image that isn't pulled is left for docker run to report.
+	docker := &fakeDocker{output: map[string]string{}, fail: map[string]bool{"image inspect --format {{.Id}} " + claudeImage: true}}
+	c, _ = testClaudeContract(docker, false)
+	if err := c.verify(ctx, claudeImage, maxTurns); err != nil {
+		t.Errorf("unpulled image: %v", err)
+	}
+	if n := len(docker.commands("run --rm")); n != 0 {
+		t.Errorf("ran the check %d times on an unpulled image", n)
+	}
+}
@@ -0,0 +1,146 @@
+package sandbox
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const maxClaudeTools = 64
+
+var (
+	// validClaudeTool matches a Claude Code tool spec: a tool name with an
+	// optional parenthesized rule, such as Read or Bash(git log:*).
+	validClaudeTool  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}(\([^()\x00-\x1f]{1,256}\))?$`)
+	validClaudeModel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:\[\]-]{0,127}$`)
+)
+
+// claudePolicy is security.claude: the ceilings on claude options and the
+// defaults for the ones a request omits.
+type claudePolicy struct {
+	cfg config.ClaudeSecurityConfig
+}
+
+// newClaudePolicy checks the tool specs in cfg, which config.Validate
+// leaves to the backend.
+func newClaudePolicy(cfg config.ClaudeSecurityConfig) (*claudePolicy, error) {
+	p := &claudePolicy{cfg: cfg}
+	for _, tools := range [][]string{cfg.DefaultAllowedTools, cfg.DefaultDisallowedTools, cfg.DeniedTools} {
+		if err := validateClaudeTools(tools); err != nil {
+			return nil, err
+		}
+	}
+	for _, tool := range cfg.DefaultAllowedTools {
+		if p.denied(tool) {
+			return nil, fmt.Errorf("default_allowed_tools: %q is in denied_tools", tool)
+		}
+	}
+	if cfg.DefaultModel != "" && !validClaudeModel.MatchString(cfg.DefaultModel) {
+		return nil, fmt.Errorf("default_model: invalid model name %q", cfg.DefaultModel)
+	}
+	return p, nil
+}
+
+// resolve checks the options req asked for against the policy and replaces
+// them with the ones claude will run with: omitted fields take the config
+// defaults, max_turns is capped at the ceiling and denied tools are always
+// disallowed. Non-claude requests must not set options. A nil policy has no
+// ceilings or defaults.
+func (p *claudePolicy) resolve(req *ExecutionRequest) error {
+	if p == nil {
+		p = &claudePolicy{}
+	}
+	if req.Language != "claude" {
+		if req.Claude != nil {
+			return fmt.Errorf("%w: claude options are only supported for claude", ErrInvalidRequest)
+		}
+		return nil
+	}
+
+	var asked runtime.ClaudeOptions
+	if req.Claude != nil {
+		asked = *req.Claude
+	}
+	for _, tools := range [][]string{asked.AllowedTools, asked.DisallowedTools} {
+		if err := validateClaudeTools(tools); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	}
+	for _, tool := range asked.AllowedTools {
+		if p.denied(tool) {
+			return fmt.Errorf("%w: tool %q is disabled on this server", ErrInvalidRequest, tool)
+		}
+	}
+	if asked.Model != "" {
+		if !validClaudeModel.MatchString(asked.Model) {
+			return fmt.Errorf("%w: invalid model name %q", ErrInvalidRequest, asked.Model)
+		}
+		if len(p.cfg.AllowedModels) > 0 && !slices.Contains(p.cfg.AllowedModels, asked.Model) {
+			return fmt.Errorf("%w: model %q is not allowed (allowed: %v)", ErrInvalidRequest, asked.Model, p.cfg.AllowedModels)
+		}
+	}
+	if asked.MaxTurns < 0 {
+		return fmt.Errorf("%w: max_turns must be >= 0", ErrInvalidRequest)
+	}
+	if p.cfg.MaxTurns > 0 && asked.MaxTurns > p.cfg.MaxTurns {
+		return fmt.Errorf("%w: max_turns %d exceeds the %d maximum", ErrInvalidRequest, asked.MaxTurns, p.cfg.MaxTurns)
+	}
+
+	opts := runtime.ClaudeOptions{
+		AllowedTools:    asked.AllowedTools,
+		DisallowedTools: asked.DisallowedTools,
+		MaxTurns:        asked.MaxTurns,
+		Model:           asked.Model,
+	}
+	if opts.AllowedTools == nil {
+		opts.AllowedTools = p.cfg.DefaultAllowedTools
+	}
+	if opts.DisallowedTools == nil {
+		opts.DisallowedTools = p.cfg.DefaultDisallowedTools
+	}
+	if opts.MaxTurns == 0 {
+		opts.MaxTurns = p.cfg.DefaultMaxTurns
+	}
+	if opts.MaxTurns == 0 {
+		opts.MaxTurns = p.cfg.MaxTurns
+	}
+	if opts.Model == "" {
+		opts.Model = p.cfg.DefaultModel
+	}
+	disallowed := slices.Clone(opts.DisallowedTools)
+	for _, tool := range p.cfg.DeniedTools {
+		if !slices.Contains(disallowed, tool) {
+			disallowed = append(disallowed, tool)
+		}
+	}
+	opts.DisallowedTools = disallowed
+
+	req.Claude = &opts
+	return nil
+}
+
+// denied reports whether tool, or the tool a rule like Bash(curl:*) is for,
+// is in denied_tools.
+func (p *claudePolicy) denied(tool string) bool {
+	for _, d := range p.cfg.DeniedTools {
+		if tool == d || runtime.ClaudeToolName(tool) == d {
+			return true
+		}
+	}
+	return false
+}
+
+func validateClaudeTools(tools []string) error {
+	if len(tools) > maxClaudeTools {
+		return fmt.Errorf("at most %d tools allowed, got %d", maxClaudeTools, len(tools))
+	}
+	for _, tool := range tools {
+		if !validClaudeTool.MatchString(tool) {
+			return fmt.Errorf("invalid tool spec %q", tool)
+		}
+	}
+	return nil
+}
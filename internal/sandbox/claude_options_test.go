@@ -0,0 +1,136 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func testClaudePolicy(t *testing.T) *claudePolicy {
+	t.Helper()
+	p, err := newClaudePolicy(config.ClaudeSecurityConfig{
+		AllowedModels:          []string{"claude-sonnet-4-5", "claude-haiku-4-5"},
+		DefaultModel:           "claude-haiku-4-5",
+		MaxTurns:               20,
+		DefaultMaxTurns:        10,
+		DefaultAllowedTools:    []string{"Read", "Edit"},
+		DefaultDisallowedTools: []string{"Bash(curl:*)"},
+		DeniedTools:            []string{"WebSearch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestClaudePolicy_Defaults(t *testing.T) {
+	p := testClaudePolicy(t)
+	req := ExecutionRequest{Language: "claude", Code: "hi"}
+	if err := p.resolve(&req); err != nil {
+		t.Fatal(err)
+	}
+	want := &runtime.ClaudeOptions{
+		AllowedTools:    []string{"Read", "Edit"},
+		DisallowedTools: []string{"Bash(curl:*)", "WebSearch"},
+		MaxTurns:        10,
+		Model:           "claude-haiku-4-5",
+	}
+	if !reflect.DeepEqual(req.Claude, want) {
+		t.Errorf("resolved = %+v, want %+v", req.Claude, want)
+	}
+
+	// Fields the request sets replace the defaults; the rest still apply,
+	// and denied tools are disallowed whatever the request says.
+	req = ExecutionRequest{Language: "claude", Code: "hi", Claude: &runtime.ClaudeOptions{
+		DisallowedTools: []string{},
+		Model:           "claude-sonnet-4-5",
+	}}
+	if err := p.resolve(&req); err != nil {
+		t.Fatal(err)
+	}
+	want = &runtime.ClaudeOptions{
+		AllowedTools:    []string{"Read", "Edit"},
+		DisallowedTools: []string{"WebSearch"},
+		MaxTurns:        10,
+		Model:           "claude-sonnet-4-5",
+	}
+	if !reflect.DeepEqual(req.Claude, want) {
+		t.Errorf("resolved = %+v, want %+v", req.Claude, want)
+	}
+}
+
+func TestClaudePolicy_MaxTurnsCeiling(t *testing.T) {
+	p, err := newClaudePolicy(config.ClaudeSecurityConfig{MaxTurns: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ExecutionRequest{Language: "claude", Code: "hi"}
+	if err := p.resolve(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Claude.MaxTurns != 5 {
+		t.Errorf("max turns = %d, want the ceiling without a default", req.Claude.MaxTurns)
+	}
+}
+
+func TestClaudePolicy_Rejects(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ExecutionRequest
+	}{
+		{"disallowed model", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{Model: "claude-opus-4-1"}}},
+		{"malformed model", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{Model: "--dangerously-skip-permissions"}}},
+		{"turns over ceiling", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{MaxTurns: 21}}},
+		{"negative turns", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{MaxTurns: -1}}},
+		{"denied tool", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{AllowedTools: []string{"WebSearch"}}}},
+		{"denied tool rule", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{AllowedTools: []string{"WebSearch(query:*)"}}}},
+		{"flag as tool", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{DisallowedTools: []string{"--model"}}}},
+		{"newline in rule", ExecutionRequest{Language: "claude", Claude: &runtime.ClaudeOptions{AllowedTools: []string{"Bash(ls\n)"}}}},
+		{"not claude", ExecutionRequest{Language: "python", Claude: &runtime.ClaudeOptions{}}},
+	}
+	p := testClaudePolicy(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.resolve(&tt.req); !errors.Is(err, ErrInvalidRequest) {
+				t.Errorf("resolve() error = %v, want ErrInvalidRequest", err)
+			}
+		})
+	}
+}
+
+func TestNewClaudePolicy_Invalid(t *testing.T) {
+	for name, cfg := range map[string]config.ClaudeSecurityConfig{
+		"default allows denied tool": {DefaultAllowedTools: []string{"Bash(ls:*)"}, DeniedTools: []string{"Bash"}},
+		"bad denied tool":            {DeniedTools: []string{"Web Search"}},
+		"bad default model":          {DefaultModel: "-m"},
+	} {
+		if _, err := newClaudePolicy(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBuildDockerArgs_ClaudeOptions(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.claude = testClaudePolicy(t)
+	req := ExecutionRequest{Language: "claude", Code: "fix the tests", Claude: &runtime.ClaudeOptions{AllowedTools: []string{"Bash(go test:*)"}}}
+	if err := d.validateRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := d.runtimes.Get("claude")
+	args := d.buildDockerArgs("exec-c", rt, "/tmp/p.txt", "/sandbox/p.txt", "/tmp/sandbox-exec-c", "/tmp/seccomp.json", req)
+
+	want := []string{
+		"_", "/sandbox/p.txt",
+		"--model", "claude-haiku-4-5",
+		"--max-turns", "10",
+		"--allowedTools", "Bash(go test:*)",
+		"--disallowedTools", "Bash(curl:*)", "WebSearch",
+	}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command tail = %q, want %q", got, want)
+	}
+}
@@ -48,6 +48,12 @@ func (r *Runner) cleanupContainer(ctx context.Context, container containerd.Cont
 		}
 	}
 
+	// The task is gone, so nothing in the container can use the network
+	// while it is released; the container record still holds what to release.
+	if err := r.detachNetwork(cleanupCtx, container); err != nil {
+		logger.Warn().Err(err).Msg("failed to detach network")
+	}
+
 	if err := container.Delete(cleanupCtx, containerd.WithSnapshotCleanup); err != nil {
 		if !errdefs.IsNotFound(err) {
 			logger.Error().Err(err).Msg("failed to delete container")
@@ -92,6 +98,33 @@ func (r *Runner) CleanupOrphaned(ctx context.Context) (int, error) {
 	return cleaned, nil
 }
 
+// ExecutionRemover is implemented by backends that can force-remove an
+// execution's container by the execution's ID, for a server giving up on
+// the executions still running when it shuts down.
+type ExecutionRemover interface {
+	RemoveExecution(ctx context.Context, execID string) error
+}
+
+// RemoveExecution kills and deletes the container of execID, if it still
+// exists.
+func (r *Runner) RemoveExecution(ctx context.Context, execID string) error {
+	container, err := r.client.Raw().LoadContainer(r.client.WithNamespace(ctx), "sandbox-"+execID)
+	if errdefs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return r.cleanupContainer(ctx, container)
+}
+
+// RemoveExecution force-removes the container of execID, if it still
+// exists.
+func (d *DockerRunner) RemoveExecution(ctx context.Context, execID string) error {
+	ctx, end := newDeadlines(0, 0, d.cleanupGrace).phase(ctx, PhaseCleanup)
+	defer end()
+	return d.removeContainer(ctx, d.containerName(execID))
+}
+
 func (r *Runner) GarbageCollect(ctx context.Context) error {
 	nsCtx := r.client.WithNamespace(ctx)
 
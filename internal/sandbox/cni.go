@@ -0,0 +1,262 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+const (
+	cniVersion = "1.0.0"
+	cniIfName  = "eth0"
+	// cniTimeout bounds each plugin run. The bridge plugin creates the
+	// bridge and iptables chains on first use, which can take a while.
+	cniTimeout = 30 * time.Second
+	// cniLabel marks containers attached to the CNI network, so that
+	// cleanupContainer, orphans included, knows to detach them.
+	// cniResultLabel keeps the ADD result, which DEL is given back as its
+	// prevResult, the way libcni caches it on disk.
+	cniLabel       = "sandbox.network"
+	cniResultLabel = "sandbox.network.result"
+)
+
+// cniNetwork attaches containers to a CNI network by running its plugins the
+// way the CNI spec describes: each is an executable that reads its config on
+// stdin, takes the command and container from CNI_* variables and prints a
+// result or an error as JSON.
+type cniNetwork struct {
+	pluginDir string
+	name      string
+	plugins   []map[string]any
+	// run executes one plugin; a variable so tests can fake the plugins.
+	run func(ctx context.Context, path string, env []string, stdin []byte) ([]byte, error)
+}
+
+// cniAttachment is what CNI ADD gave the container.
+type cniAttachment struct {
+	IP      string // without the prefix length
+	Gateway string
+	Result  json.RawMessage // the last plugin's result, for teardown
+}
+
+func newCNINetwork(cfg config.CNIConfig) *cniNetwork {
+	return &cniNetwork{
+		pluginDir: cfg.PluginDir,
+		name:      cfg.Network,
+		plugins: []map[string]any{
+			{
+				"type":      "bridge",
+				"bridge":    cfg.Bridge,
+				"isGateway": true,
+				"ipMasq":    true,
+				"ipam": map[string]any{
+					"type":   "host-local",
+					"ranges": [][]map[string]string{{{"subnet": cfg.Subnet}}},
+					"routes": []map[string]string{{"dst": "0.0.0.0/0"}},
+				},
+			},
+			// Docker sets the FORWARD policy to DROP; the firewall plugin
+			// lets the container's traffic through anyway.
+			{"type": "firewall"},
+		},
+		run: runCNIPlugin,
+	}
+}
+
+// binaries returns the plugin executables the network needs, IPAM included.
+func (n *cniNetwork) binaries() []string {
+	var names []string
+	for _, p := range n.plugins {
+		names = append(names, p["type"].(string))
+		if ipam, ok := p["ipam"].(map[string]any); ok {
+			names = append(names, ipam["type"].(string))
+		}
+	}
+	return names
+}
+
+// pluginConfig is the stdin of plugin i: its own settings plus the
+// network's name and version and, after the first ADD, the result so far.
+func (n *cniNetwork) pluginConfig(i int, prevResult json.RawMessage) ([]byte, error) {
+	conf := map[string]any{"cniVersion": cniVersion, "name": n.name}
+	for k, v := range n.plugins[i] {
+		conf[k] = v
+	}
+	if prevResult != nil {
+		conf["prevResult"] = prevResult
+	}
+	return json.Marshal(conf)
+}
+
+func (n *cniNetwork) exec(ctx context.Context, command string, i int, containerID, netns string, prevResult json.RawMessage) ([]byte, error) {
+	stdin, err := n.pluginConfig(i, prevResult)
+	if err != nil {
+		return nil, err
+	}
+	plugin := n.plugins[i]["type"].(string)
+	env := append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+containerID,
+		"CNI_NETNS="+netns,
+		"CNI_IFNAME="+cniIfName,
+		"CNI_PATH="+n.pluginDir,
+	)
+	ctx, cancel := context.WithTimeout(ctx, cniTimeout)
+	defer cancel()
+	out, err := n.run(ctx, filepath.Join(n.pluginDir, plugin), env, stdin)
+	if err != nil {
+		return nil, fmt.Errorf("cni %s %s: %w", plugin, command, err)
+	}
+	return out, nil
+}
+
+// setup is CNI ADD: it plugs an interface into the network namespace at
+// netns and returns the address it got. A failed setup is torn down again.
+func (n *cniNetwork) setup(ctx context.Context, containerID, netns string) (cniAttachment, error) {
+	var attachment cniAttachment
+	var result json.RawMessage
+	for i := range n.plugins {
+		out, err := n.exec(ctx, "ADD", i, containerID, netns, result)
+		if err == nil {
+			attachment, err = parseCNIResult(out)
+		}
+		if err != nil {
+			if delErr := n.teardown(context.Background(), containerID, netns, result); delErr != nil {
+				err = errors.Join(err, delErr)
+			}
+			return cniAttachment{}, err
+		}
+		result = out
+	}
+	return attachment, nil
+}
+
+// teardown is CNI DEL, plugins in reverse order, given setup's result.
+// netns may be empty once the container has exited; the plugins still
+// release its address and rules. Detaching what was never attached is not an
+// error, so teardown is safe to repeat.
+func (n *cniNetwork) teardown(ctx context.Context, containerID, netns string, result json.RawMessage) error {
+	var errs []error
+	for i := len(n.plugins) - 1; i >= 0; i-- {
+		if _, err := n.exec(ctx, "DEL", i, containerID, netns, result); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseCNIResult picks the container's IPv4 address out of an ADD result.
+func parseCNIResult(data []byte) (cniAttachment, error) {
+	var result struct {
+		IPs []struct {
+			Address string `json:"address"`
+			Gateway string `json:"gateway"`
+		} `json:"ips"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return cniAttachment{}, fmt.Errorf("decoding cni result: %w", err)
+	}
+	for _, ip := range result.IPs {
+		if prefix, err := netip.ParsePrefix(ip.Address); err == nil && prefix.Addr().Is4() {
+			return cniAttachment{IP: prefix.Addr().String(), Gateway: ip.Gateway, Result: data}, nil
+		}
+	}
+	return cniAttachment{}, fmt.Errorf("cni result has no IPv4 address: %s", data)
+}
+
+// runCNIPlugin executes a plugin. A plugin that fails prints a JSON error
+// ({"code": ..., "msg": ..., "details": ...}) on stdout, which becomes the
+// error.
+func runCNIPlugin(ctx context.Context, path string, env []string, stdin []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path) // #nosec G204 -- path is sandbox.cni.plugin_dir joined with a fixed plugin name
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var failure struct {
+			Code    int    `json:"code"`
+			Msg     string `json:"msg"`
+			Details string `json:"details"`
+		}
+		if json.Unmarshal(stdout.Bytes(), &failure) == nil && failure.Msg != "" {
+			msg := failure.Msg
+			if failure.Details != "" {
+				msg += ": " + failure.Details
+			}
+			return nil, fmt.Errorf("%s (code %d)", msg, failure.Code)
+		}
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, fmt.Errorf("%w: %s", err, s)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// attachNetwork plugs a created task's network namespace into the CNI
+// network and records the result on the container for cleanupContainer.
+func (r *Runner) attachNetwork(ctx context.Context, container containerd.Container, task containerd.Task) (string, error) {
+	ctx = r.client.WithNamespace(ctx)
+	attachment, err := r.network.setup(ctx, container.ID(), fmt.Sprintf("/proc/%d/ns/net", task.Pid()))
+	if err != nil {
+		return "", err
+	}
+	if _, err := container.SetLabels(ctx, map[string]string{cniResultLabel: string(attachment.Result)}); err != nil {
+		// cleanupContainer can still detach it, without the result.
+		log.Warn().Err(err).Str("container_id", container.ID()).Msg("could not record the cni result")
+	}
+	return attachment.IP, nil
+}
+
+// detachNetwork releases the address and rules of a container attached by
+// attachNetwork. It runs once the task has exited, so the interface went
+// with the namespace and the plugins are given none.
+func (r *Runner) detachNetwork(ctx context.Context, container containerd.Container) error {
+	if r.network == nil {
+		return nil
+	}
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return fmt.Errorf("reading labels: %w", err)
+	}
+	if _, ok := labels[cniLabel]; !ok {
+		return nil
+	}
+	var result json.RawMessage
+	if s := labels[cniResultLabel]; json.Valid([]byte(s)) {
+		result = json.RawMessage(s)
+	}
+	return r.network.teardown(ctx, container.ID(), "", result)
+}
+
+// resolvConfMount gives a networked container the host's resolvers. Under
+// systemd-resolved /etc/resolv.conf names a stub on the host's loopback,
+// which the container can't reach, so the upstream list is used instead.
+func resolvConfMount() specs.Mount {
+	source := "/etc/resolv.conf"
+	if _, err := os.Stat("/run/systemd/resolve/resolv.conf"); err == nil {
+		source = "/run/systemd/resolve/resolv.conf"
+	}
+	return specs.Mount{
+		Destination: "/etc/resolv.conf",
+		Type:        "bind",
+		Source:      source,
+		Options:     []string{"rbind", "ro"},
+	}
+}
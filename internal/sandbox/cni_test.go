@@ -0,0 +1,269 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// pluginCall is one fake plugin run.
+type pluginCall struct {
+	command, plugin, netns string
+	conf                   map[string]any
+}
+
+// fakePlugins makes n record its plugin runs and answer ADD with an address,
+// failing the plugins in fail.
+func fakePlugins(n *cniNetwork, fail ...string) *[]pluginCall {
+	var calls []pluginCall
+	n.run = func(_ context.Context, path string, env []string, stdin []byte) ([]byte, error) {
+		call := pluginCall{plugin: filepath.Base(path)}
+		for _, kv := range env {
+			if v, ok := strings.CutPrefix(kv, "CNI_COMMAND="); ok {
+				call.command = v
+			}
+			if v, ok := strings.CutPrefix(kv, "CNI_NETNS="); ok {
+				call.netns = v
+			}
+		}
+		if err := json.Unmarshal(stdin, &call.conf); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+		if slices.Contains(fail, call.plugin) {
+			return nil, errors.New("iptables: not found")
+		}
+		if call.command == "DEL" {
+			return nil, nil
+		}
+		return []byte(`{"cniVersion":"1.0.0","interfaces":[{"name":"eth0","sandbox":"/proc/42/ns/net"}],` +
+			`"ips":[{"address":"fd00::5/64","interface":0},{"address":"10.89.0.5/22","gateway":"10.89.0.1","interface":0}]}`), nil
+	}
+	return &calls
+}
+
+func summarize(calls []pluginCall) []string {
+	var out []string
+	for _, c := range calls {
+		s := c.command + " " + c.plugin
+		if c.conf["prevResult"] != nil {
+			s += " +prev"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestCNIPluginConfig(t *testing.T) {
+	n := newCNINetwork(config.DefaultConfig().Sandbox.CNI)
+	data, err := n.pluginConfig(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bridge":"sandbox0","cniVersion":"1.0.0","ipMasq":true,"ipam":{"ranges":[[{"subnet":"10.89.0.0/22"}]],` +
+		`"routes":[{"dst":"0.0.0.0/0"}],"type":"host-local"},"isGateway":true,"name":"sandbox","type":"bridge"}`
+	if string(data) != want {
+		t.Errorf("bridge config = %s\nwant %s", data, want)
+	}
+
+	data, err = n.pluginConfig(1, json.RawMessage(`{"ips":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"cniVersion":"1.0.0","name":"sandbox","prevResult":{"ips":[]},"type":"firewall"}`; string(data) != want {
+		t.Errorf("firewall config = %s, want %s", data, want)
+	}
+
+	if got, want := n.binaries(), []string{"bridge", "host-local", "firewall"}; !slices.Equal(got, want) {
+		t.Errorf("binaries = %q, want %q", got, want)
+	}
+}
+
+func TestCNISetup(t *testing.T) {
+	n := newCNINetwork(config.DefaultConfig().Sandbox.CNI)
+	calls := fakePlugins(n)
+	attachment, err := n.setup(context.Background(), "sandbox-x", "/proc/42/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.IP != "10.89.0.5" || attachment.Gateway != "10.89.0.1" || len(attachment.Result) == 0 {
+		t.Errorf("attachment = %+v, want the IPv4 address", attachment)
+	}
+	if got, want := summarize(*calls), []string{"ADD bridge", "ADD firewall +prev"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	for _, c := range *calls {
+		if c.netns != "/proc/42/ns/net" {
+			t.Errorf("%s got netns %q", c.plugin, c.netns)
+		}
+	}
+}
+
+func TestCNISetup_FailureTearsDown(t *testing.T) {
+	n := newCNINetwork(config.DefaultConfig().Sandbox.CNI)
+	calls := fakePlugins(n, "firewall")
+	_, err := n.setup(context.Background(), "sandbox-x", "/proc/42/ns/net")
+	if err == nil || !strings.Contains(err.Error(), "cni firewall ADD: iptables: not found") {
+		t.Fatalf("err = %v", err)
+	}
+	// Everything comes down again, last plugin first, given what the
+	// bridge had set up.
+	want := []string{"ADD bridge", "ADD firewall +prev", "DEL firewall +prev", "DEL bridge +prev"}
+	if got := summarize(*calls); !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestCNITeardown(t *testing.T) {
+	n := newCNINetwork(config.DefaultConfig().Sandbox.CNI)
+	calls := fakePlugins(n, "firewall")
+	err := n.teardown(context.Background(), "sandbox-x", "", nil)
+	if err == nil {
+		t.Error("a failed DEL wasn't reported")
+	}
+	// A failing plugin doesn't stop the rest from releasing theirs.
+	if got, want := summarize(*calls), []string{"DEL firewall", "DEL bridge"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestParseCNIResult(t *testing.T) {
+	if _, err := parseCNIResult([]byte(`{"ips":[{"address":"fd00::5/64"}]}`)); err == nil {
+		t.Error("a result without IPv4 accepted")
+	}
+	if _, err := parseCNIResult([]byte(`not json`)); err == nil {
+		t.Error("a malformed result accepted")
+	}
+}
+
+func TestRunCNIPlugin(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ctx := context.Background()
+
+	ok := write("ok", `read conf; echo "{\"command\":\"$CNI_COMMAND\",\"conf\":$conf}"`)
+	out, err := runCNIPlugin(ctx, ok, []string{"CNI_COMMAND=ADD"}, []byte(`{"name":"sandbox"}`+"\n"))
+	if err != nil || strings.TrimSpace(string(out)) != `{"command":"ADD","conf":{"name":"sandbox"}}` {
+		t.Errorf("out = %s, err = %v", out, err)
+	}
+
+	failed := write("failed", `echo '{"code":11,"msg":"failed to allocate","details":"range is full"}'; exit 1`)
+	if _, err := runCNIPlugin(ctx, failed, nil, nil); err == nil || err.Error() != "failed to allocate: range is full (code 11)" {
+		t.Errorf("err = %v, want the plugin's error", err)
+	}
+
+	crashed := write("crashed", `echo panic >&2; exit 2`)
+	if _, err := runCNIPlugin(ctx, crashed, nil, nil); err == nil || !strings.Contains(err.Error(), "exit status 2: panic") {
+		t.Errorf("err = %v, want the exit status and stderr", err)
+	}
+}
+
+// exitedContainer is a containerd container for cleanupContainer. Its task
+// is already gone, as the execution deleted it.
+type exitedContainer struct {
+	containerd.Container
+	labels map[string]string
+	steps  *[]string
+}
+
+func (c *exitedContainer) ID() string { return "sandbox-x" }
+func (c *exitedContainer) Task(context.Context, cio.Attach) (containerd.Task, error) {
+	*c.steps = append(*c.steps, "task")
+	return nil, errdefs.ErrNotFound
+}
+func (c *exitedContainer) Labels(context.Context) (map[string]string, error) { return c.labels, nil }
+func (c *exitedContainer) Delete(context.Context, ...containerd.DeleteOpts) error {
+	*c.steps = append(*c.steps, "delete container")
+	return nil
+}
+
+func TestCleanupContainer_DetachesNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{"networked", map[string]string{cniLabel: "sandbox", cniResultLabel: `{"ips":[]}`},
+			[]string{"task", "DEL firewall +prev", "DEL bridge +prev", "delete container"}},
+		{"result not recorded", map[string]string{cniLabel: "sandbox"},
+			[]string{"task", "DEL firewall", "DEL bridge", "delete container"}},
+		{"not networked", nil, []string{"task", "delete container"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Runner{client: &Client{namespace: "sandbox"}, network: newCNINetwork(config.DefaultConfig().Sandbox.CNI)}
+			calls := fakePlugins(r.network)
+			var steps []string
+			run := r.network.run
+			r.network.run = func(ctx context.Context, path string, env []string, stdin []byte) ([]byte, error) {
+				out, err := run(ctx, path, env, stdin)
+				steps = append(steps, summarize((*calls)[len(*calls)-1:])[0])
+				return out, err
+			}
+			if err := r.cleanupContainer(context.Background(), &exitedContainer{labels: tt.labels, steps: &steps}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(steps, tt.want) {
+				t.Errorf("steps = %q, want %q", steps, tt.want)
+			}
+			for _, c := range *calls {
+				if c.netns != "" {
+					t.Errorf("%s given netns %q after the task exited", c.plugin, c.netns)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRequest_NetworkNeedsCNI(t *testing.T) {
+	r := &Runner{runtimes: runtime.NewRegistry()}
+	req := ExecutionRequest{Language: "python", Code: "print(1)", NetworkEnabled: true}
+	if err := r.validateRequest(&req); !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), "sandbox.cni") {
+		t.Errorf("err = %v, want network refused without sandbox.cni", err)
+	}
+	r.network = newCNINetwork(config.DefaultConfig().Sandbox.CNI)
+	if err := r.validateRequest(&req); err != nil {
+		t.Errorf("err = %v with sandbox.cni", err)
+	}
+	req.NetworkEnabled = false
+	r.network = nil
+	if err := r.validateRequest(&req); err != nil {
+		t.Errorf("err = %v without network", err)
+	}
+}
+
+func TestContainerdConfigWarnings_CNIPlugins(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.CNI.Enabled = true
+	cfg.Sandbox.CNI.PluginDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(cfg.Sandbox.CNI.PluginDir, "bridge"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{network: newCNINetwork(cfg.Sandbox.CNI)}
+	got := r.ConfigWarnings(context.Background(), cfg)
+	if len(got) != 2 || !strings.Contains(got[0], "no host-local plugin") || !strings.Contains(got[1], "no firewall plugin") {
+		t.Errorf("warnings = %q", got)
+	}
+	if !reflect.DeepEqual(dockerConfigWarnings(context.Background(), nil, runtime.NewRegistry(), &config.Config{Sandbox: cfg.Sandbox}),
+		[]string{"sandbox.cni has no effect with the docker backend, which networks containers through Docker's bridge; set sandbox.cni.enabled: false"}) {
+		t.Error("docker backend doesn't warn about sandbox.cni")
+	}
+}
@@ -0,0 +1,41 @@
+package sandbox
+
+import "strings"
+
+const (
+	// codeHashEnv carries the SHA-256 the server recorded for the code into
+	// the container, for the wrapper to check the mounted file against.
+	codeHashEnv = "SANDBOX_CODE_SHA256"
+	// exitCodeIntegrity is the wrapper's exit code when the file differs.
+	exitCodeIntegrity = 96
+	// codeIntegrityMessage is all the wrapper writes, to stderr, when it
+	// refuses to start the command.
+	codeIntegrityMessage = "sandbox: code file does not match its sha256; not run"
+)
+
+// codeIntegrityWrapper runs under /bin/sh ahead of the runtime's command. It
+// hashes the code file, its first argument, and starts the command only if
+// the digest is the one in codeHashEnv, which the command doesn't inherit.
+// A file that can't be read, or an image without sha256sum, fails the check
+// like a file that differs.
+const codeIntegrityWrapper = `sum=$(sha256sum 2>/dev/null <"$1") && [ "${sum%% *}" = "$` + codeHashEnv + `" ] || {
+	echo '` + codeIntegrityMessage + `' >&2
+	exit 96
+}
+unset ` + codeHashEnv + `
+shift
+exec "$@"`
+
+// codeIntegrityCommand prefixes argv with the integrity wrapper, checking
+// the code file mounted at codePath.
+func codeIntegrityCommand(codePath string, argv []string) []string {
+	return append([]string{"/bin/sh", "-c", codeIntegrityWrapper, "sandbox-code", codePath}, argv...)
+}
+
+// codeIntegrityRefused reports whether a run that exited with exitCode and
+// wrote stderr was the wrapper refusing to start it. Code that exits 96 has
+// written its own stderr, or nothing; faking the message only fails its own
+// execution.
+func codeIntegrityRefused(exitCode int, stderr string) bool {
+	return exitCode == exitCodeIntegrity && strings.TrimSpace(stderr) == codeIntegrityMessage
+}
@@ -0,0 +1,134 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runCodeIntegrityWrapper runs the wrapper over file, expecting hash, with
+// a command that prints whether the hash was passed on to it.
+func runCodeIntegrityWrapper(t *testing.T, file, hash string) (int, string, string) {
+	t.Helper()
+	for _, tool := range []string{"sh", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s in PATH", tool)
+		}
+	}
+	cmd := exec.Command("sh", "-c", codeIntegrityWrapper, "sandbox-code", file, "sh", "-c", "echo ran ${"+codeHashEnv+":-unset}")
+	cmd.Env = append(os.Environ(), codeHashEnv+"="+hash)
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), stdout.String(), stderr.String()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+func TestCodeIntegrityWrapper(t *testing.T) {
+	const code = "print('hi')\n"
+	file := filepath.Join(t.TempDir(), "code.py")
+	if err := os.WriteFile(file, []byte(code), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+
+	tests := []struct {
+		name, file, hash string
+		refused          bool
+	}{
+		{"matching", file, hash, false},
+		{"different", file, fmt.Sprintf("%x", sha256.Sum256([]byte("print('bye')\n"))), true},
+		{"no hash", file, "", true},
+		{"missing file", file + ".gone", hash, true},
+	}
+	for _, tt := range tests {
+		exitCode, out, stderr := runCodeIntegrityWrapper(t, tt.file, tt.hash)
+		if got := codeIntegrityRefused(exitCode, stderr); got != tt.refused {
+			t.Errorf("%s: exit code %d, stderr %q: refused = %t, want %t", tt.name, exitCode, stderr, got, tt.refused)
+		}
+		if !tt.refused && out != "ran unset\n" {
+			t.Errorf("%s: command output %q, want it run without %s", tt.name, out, codeHashEnv)
+		}
+		if tt.refused && out != "" {
+			t.Errorf("%s: command ran: %q", tt.name, out)
+		}
+	}
+}
+
+func TestCodeIntegrityRefused(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		stderr   string
+		want     bool
+	}{
+		{exitCodeIntegrity, codeIntegrityMessage + "\n", true},
+		{exitCodeIntegrity, "", false},                 // code that exits 96
+		{exitCodeIntegrity, "Traceback: ...\n", false}, // code that failed with 96
+		{1, codeIntegrityMessage + "\n", false},        // code that printed the message
+		{exitSeccompNotApplied, codeIntegrityMessage + "\n", false},
+	}
+	for _, tt := range tests {
+		if got := codeIntegrityRefused(tt.exitCode, tt.stderr); got != tt.want {
+			t.Errorf("exit code %d, stderr %q: refused = %t, want %t", tt.exitCode, tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDockerArgs_VerifyCode(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.verifyCode = true
+	req := ExecutionRequest{Code: "1", Args: []string{"arg"}}
+	hash := requestCodeHash(req)
+
+	for _, name := range d.runtimes.Languages() {
+		rt, _ := d.runtimes.Get(name)
+		codePath := "/workspace/code" + rt.FileExtension()
+		req.Language = name
+		args := d.buildDockerArgs("exec-c", rt,
+			"/tmp/code"+rt.FileExtension(), codePath,
+			"/tmp/sandbox-exec-c", "/tmp/seccomp.json",
+			req,
+		)
+		if name == "claude" {
+			if argsContain(args, codeIntegrityWrapper) || argsContainPrefix(args, codeHashEnv+"=") {
+				t.Errorf("claude args are wrapped: %v", args)
+			}
+			continue
+		}
+		if !argsContainPair(args, "-e", codeHashEnv+"="+hash) {
+			t.Errorf("%s: no %s in %v", name, codeHashEnv, args)
+		}
+		want := append([]string{rt.Image(), "/bin/sh", "-c", codeIntegrityWrapper, "sandbox-code", codePath}, commandArgv(rt, codePath, req)...)
+		if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: command = %v, want %v", name, got, want)
+		}
+	}
+
+	// Under the seccomp wrapper, the file is checked once filtering is.
+	d.verifySeccomp = true
+	py, _ := d.runtimes.Get("python")
+	req.Language = "python"
+	args := d.buildDockerArgs("exec-c", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-c", "/tmp/seccomp.json",
+		req,
+	)
+	want := []string{py.Image(), "/bin/sh", "-c", seccompWrapper, "sandbox-seccomp",
+		"/bin/sh", "-c", codeIntegrityWrapper, "sandbox-code", "/workspace/code.py",
+		"python3", "-u", "-B", "/workspace/code.py", "arg"}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+}
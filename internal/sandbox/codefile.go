@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxInlineCode is the limit on ExecutionRequest.Code. Uploads in CodeFile
+// are capped by whoever wrote the file, and executables by validateBinary.
+const maxInlineCode = 1 << 20
+
+// validateCode checks req carries its code one way or the other.
+func validateCode(req ExecutionRequest) error {
+	switch {
+	case req.Code != "" && req.CodeFile != "":
+		return fmt.Errorf("%w: code and code_file are mutually exclusive", ErrInvalidRequest)
+	case req.CodeFile != "":
+		info, err := os.Stat(req.CodeFile)
+		if err != nil {
+			return fmt.Errorf("%w: code_file: %v", ErrInvalidRequest, err)
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return fmt.Errorf("%w: code_file is empty or not a regular file", ErrInvalidRequest)
+		}
+	case req.Code == "":
+		return fmt.Errorf("%w: code is empty", ErrInvalidRequest)
+	case len(req.Code) > maxInlineCode && req.Language != binaryLanguage:
+		return fmt.Errorf("%w: code exceeds 1MB limit", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// codeMode is the mode a code file is mounted with: world-readable, since
+// the container runs as nobody, and executable for the binary runtime,
+// which runs the file itself.
+func codeMode(language string) os.FileMode {
+	if language == binaryLanguage {
+		return 0555
+	}
+	return 0444
+}
+
+// requestCodeHash returns the hex SHA-256 of req's code, reading CodeFile
+// only if the caller didn't hash it already. A file that can't be read
+// hashes as empty code; validation reports it.
+func requestCodeHash(req ExecutionRequest) string {
+	if req.CodeFile == "" {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(req.Code)))
+	}
+	if req.CodeHash != "" {
+		return req.CodeHash
+	}
+	h := sha256.New()
+	if f, err := os.Open(req.CodeFile); err == nil {
+		_, _ = io.Copy(h, f)
+		f.Close()
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// writeCode puts req's code at path, mode 0600, refusing a file already
+// there. CodeFile is hard-linked where it can be, so a large upload isn't
+// copied a second time; across filesystems it is copied. Before the file is
+// mounted, it must have no name beyond path and, linked, the upload's: one
+// more is a way to the code someone else holds.
+func writeCode(path string, req ExecutionRequest) error {
+	links := uint64(1)
+	if req.CodeFile == "" {
+		if err := createCode(path, strings.NewReader(req.Code)); err != nil {
+			return err
+		}
+		return checkLinks(path, links)
+	}
+	err := os.Link(req.CodeFile, path)
+	switch {
+	case errors.Is(err, os.ErrExist):
+		return err
+	case err != nil:
+		err = copyCodeFile(path, req.CodeFile)
+	default:
+		links = 2
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	return checkLinks(path, links)
+}
+
+func copyCodeFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return createCode(dst, in)
+}
+
+// createCode writes r to a new file at path, failing with os.ErrExist
+// rather than writing through whatever is there already.
+func createCode(path string, r io.Reader) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// checkLinks fails with ErrCodeIntegrity unless the regular file at path has
+// want names. Platforms without link counts aren't checked.
+func checkLinks(path string, want uint64) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", ErrCodeIntegrity, path)
+	}
+	if n, ok := linkCount(info); ok && n != want {
+		return fmt.Errorf("%w: %s has %d links, want %d", ErrCodeIntegrity, path, n, want)
+	}
+	return nil
+}
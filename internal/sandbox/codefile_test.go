@@ -0,0 +1,124 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "code")
+	if err := os.WriteFile(file, []byte("print(1)\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     ExecutionRequest
+		wantErr string
+	}{
+		{"inline", ExecutionRequest{Code: "print(1)"}, ""},
+		{"file", ExecutionRequest{CodeFile: file}, ""},
+		{"both", ExecutionRequest{Code: "print(1)", CodeFile: file}, "mutually exclusive"},
+		{"neither", ExecutionRequest{}, "code is empty"},
+		{"inline over 1MB", ExecutionRequest{Code: strings.Repeat("a", maxInlineCode+1)}, "1MB"},
+		{"empty file", ExecutionRequest{CodeFile: empty}, "empty"},
+		{"missing file", ExecutionRequest{CodeFile: file + ".gone"}, "code_file"},
+		{"directory", ExecutionRequest{CodeFile: t.TempDir()}, "not a regular file"},
+	}
+	for _, tt := range tests {
+		err := validateCode(tt.req)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest mentioning %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// TestWriteCode_File checks an uploaded file lands in the container's code
+// path with the same bytes, and hashes as the same code sent inline would.
+func TestWriteCode_File(t *testing.T) {
+	const code = "import os\nprint(os.getpid())\n"
+	upload := filepath.Join(t.TempDir(), "code")
+	if err := os.WriteFile(upload, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inline := ExecutionRequest{Code: code}
+	file := ExecutionRequest{CodeFile: upload}
+
+	if got, want := requestCodeHash(file), requestCodeHash(inline); got != want {
+		t.Errorf("file hash %s, inline hash %s", got, want)
+	}
+	if got := requestCodeHash(ExecutionRequest{CodeFile: upload, CodeHash: "precomputed"}); got != "precomputed" {
+		t.Errorf("hash with CodeHash set = %s, want it used as is", got)
+	}
+
+	for name, req := range map[string]ExecutionRequest{"inline": inline, "file": file} {
+		dst := filepath.Join(t.TempDir(), "main.py")
+		if err := writeCode(dst, req); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		info, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %v, want 0600", name, info.Mode().Perm())
+		}
+		if got, _ := os.ReadFile(dst); string(got) != code {
+			t.Errorf("%s: wrote %q", name, got)
+		}
+	}
+
+	// An existing file at the destination is an error, not overwritten,
+	// whichever way the code comes.
+	for name, req := range map[string]ExecutionRequest{"inline": inline, "file": file} {
+		dst := filepath.Join(t.TempDir(), "main.py")
+		if err := os.WriteFile(dst, []byte("other"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeCode(dst, req); !errors.Is(err, os.ErrExist) {
+			t.Errorf("%s over an existing file: err = %v, want ErrExist", name, err)
+		}
+		if got, _ := os.ReadFile(dst); string(got) != "other" {
+			t.Errorf("%s over an existing file: wrote %q", name, got)
+		}
+	}
+}
+
+// TestCheckLinks checks a code file with a name beyond the ones writeCode
+// gave it is refused.
+func TestCheckLinks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "code.py")
+	if err := writeCode(path, ExecutionRequest{Code: "print(1)"}); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Lstat(path)
+	if _, ok := linkCount(info); !ok {
+		t.Skip("no link counts on this platform")
+	}
+	if err := os.Link(path, filepath.Join(dir, "other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLinks(path, 1); !errors.Is(err, ErrCodeIntegrity) || !strings.Contains(err.Error(), "2 links") {
+		t.Errorf("with a second name: err = %v, want ErrCodeIntegrity", err)
+	}
+	if err := checkLinks(path, 2); err != nil {
+		t.Errorf("a linked upload's two names: %v", err)
+	}
+	if err := checkLinks(dir, 1); !errors.Is(err, ErrCodeIntegrity) {
+		t.Errorf("a directory: err = %v, want ErrCodeIntegrity", err)
+	}
+}
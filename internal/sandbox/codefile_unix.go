@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package sandbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns how many names the file info describes has.
+func linkCount(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
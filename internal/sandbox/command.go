@@ -0,0 +1,96 @@
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	maxArgs      = 64
+	maxArgLength = 4096
+)
+
+// validateCommand checks the argv and working directory a request asked
+// for. Claude builds its own command line, so it takes neither.
+func validateCommand(req ExecutionRequest) error {
+	if req.Language == "claude" && (len(req.Args) > 0 || req.Cwd != "") {
+		return fmt.Errorf("%w: args and cwd are not supported for claude", ErrInvalidRequest)
+	}
+	if len(req.Args) > maxArgs {
+		return fmt.Errorf("%w: at most %d args allowed, got %d", ErrInvalidRequest, maxArgs, len(req.Args))
+	}
+	for i, arg := range req.Args {
+		if len(arg) > maxArgLength {
+			return fmt.Errorf("%w: args[%d] exceeds %d bytes", ErrInvalidRequest, i, maxArgLength)
+		}
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("%w: args[%d] contains a NUL byte", ErrInvalidRequest, i)
+		}
+	}
+	if req.Cwd != "" && !allowedCwd(req.Cwd, req.WritableDirs) {
+		allowed := append([]string{"/workspace", "/tmp"}, req.WritableDirs...)
+		return fmt.Errorf("%w: cwd %q must be one of %s", ErrInvalidRequest, req.Cwd, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+func allowedCwd(cwd string, writableDirs []string) bool {
+	if path.Clean(cwd) != cwd || !path.IsAbs(cwd) {
+		return false
+	}
+	return cwd == "/workspace" || cwd == "/tmp" || slices.Contains(writableDirs, cwd)
+}
+
+// commandArgv is the full argv of the sandboxed process: the runtime's
+// command for the code file, with the request's warmups applied, followed by
+// the request's args. Claude takes its resolved options instead of args.
+func commandArgv(rt runtime.Runtime, codePath string, req ExecutionRequest) []string {
+	if c, ok := rt.(*runtime.ClaudeRuntime); ok && req.Claude != nil {
+		return c.CommandWithOptions(codePath, *req.Claude)
+	}
+	argv := runtime.CommandFor(rt, codePath, req.NetworkEnabled)
+	if w, ok := runtime.AsWarmable(rt); ok && len(req.Warmups) > 0 {
+		argv, _ = w.WarmCommand(codePath, req.Warmups)
+	}
+	return append(argv, req.Args...)
+}
+
+// warmupEnv returns the env vars the request's warmups need.
+func warmupEnv(rt runtime.Runtime, codePath string, req ExecutionRequest) []string {
+	w, ok := runtime.AsWarmable(rt)
+	if !ok || len(req.Warmups) == 0 {
+		return nil
+	}
+	_, env := w.WarmCommand(codePath, req.Warmups)
+	return env
+}
+
+// setProcess points a containerd spec at argv, and at cwd when one is set;
+// otherwise the image's (or the workspace's) working directory stays.
+func setProcess(s *specs.Spec, argv []string, cwd string) {
+	if s.Process == nil {
+		s.Process = &specs.Process{}
+	}
+	s.Process.Args = argv
+	if cwd != "" {
+		s.Process.Cwd = cwd
+	}
+}
+
+// effectiveCwd is the working directory reported on the result; empty means
+// the image's default.
+func effectiveCwd(req ExecutionRequest) string {
+	if req.Cwd != "" {
+		return req.Cwd
+	}
+	if req.Workspace != "" {
+		return "/workspace"
+	}
+	return ""
+}
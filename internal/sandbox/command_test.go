@@ -0,0 +1,69 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ExecutionRequest
+		wantErr bool
+	}{
+		{"no args or cwd", ExecutionRequest{Language: "python"}, false},
+		{"args", ExecutionRequest{Language: "python", Args: []string{"--verbose", "input.csv", ""}}, false},
+		{"cwd /workspace", ExecutionRequest{Language: "python", Cwd: "/workspace"}, false},
+		{"cwd /tmp", ExecutionRequest{Language: "node", Cwd: "/tmp"}, false},
+		{"cwd declared writable dir", ExecutionRequest{Language: "bash", Cwd: "/data/out", WritableDirs: []string{"/data/out"}}, false},
+		{"cwd undeclared", ExecutionRequest{Language: "python", Cwd: "/etc"}, true},
+		{"cwd under /tmp", ExecutionRequest{Language: "python", Cwd: "/tmp/x"}, true},
+		{"cwd relative", ExecutionRequest{Language: "python", Cwd: "workspace"}, true},
+		{"cwd unclean", ExecutionRequest{Language: "python", Cwd: "/workspace/../etc", WritableDirs: []string{"/workspace/../etc"}}, true},
+		{"too many args", ExecutionRequest{Language: "python", Args: make([]string, maxArgs+1)}, true},
+		{"arg too long", ExecutionRequest{Language: "python", Args: []string{strings.Repeat("a", maxArgLength+1)}}, true},
+		{"NUL in arg", ExecutionRequest{Language: "python", Args: []string{"a\x00b"}}, true},
+		{"claude args", ExecutionRequest{Language: "claude", Args: []string{"x"}}, true},
+		{"claude cwd", ExecutionRequest{Language: "claude", Cwd: "/tmp"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCommand(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRequest) {
+				t.Errorf("error = %v, want ErrInvalidRequest", err)
+			}
+		})
+	}
+}
+
+func TestSetProcess(t *testing.T) {
+	py, _ := runtime.NewRegistry().Get("python")
+	req := ExecutionRequest{Language: "python", Args: []string{"a", "b c"}, Cwd: "/tmp"}
+	argv := commandArgv(py, "/workspace/code.py", req)
+
+	want := []string{"python3", "-u", "-B", "/workspace/code.py", "a", "b c"}
+	if !reflect.DeepEqual(argv, want) {
+		t.Fatalf("argv = %v, want %v", argv, want)
+	}
+
+	s := &specs.Spec{Process: &specs.Process{Args: []string{"python3"}, Cwd: "/"}}
+	setProcess(s, argv, req.Cwd)
+	if !reflect.DeepEqual(s.Process.Args, want) || s.Process.Cwd != "/tmp" {
+		t.Errorf("process = %+v", s.Process)
+	}
+
+	s = &specs.Spec{Process: &specs.Process{Cwd: "/workspace"}}
+	setProcess(s, argv, "")
+	if s.Process.Cwd != "/workspace" {
+		t.Errorf("cwd = %q, want the existing cwd kept when none is requested", s.Process.Cwd)
+	}
+}
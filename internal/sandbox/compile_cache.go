@@ -0,0 +1,342 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	// compileCacheDir is the directory, in the execution's temp dir, that a
+	// miss mounts read-write for the runtime to leave its artifacts in.
+	compileCacheDir = "compiled"
+	// maxCompiledFiles caps the files one entry keeps.
+	maxCompiledFiles = 256
+	// compileHarvestBudget is how long keeping a miss's artifacts may hold
+	// up its result; an entry not copied by then is abandoned.
+	compileHarvestBudget = 200 * time.Millisecond
+)
+
+// Compile cache outcomes, in ExecutionResult.CompileCache.
+const (
+	CompileCacheHit  = "hit"  // the code ran from its compiled form in the cache
+	CompileCacheMiss = "miss" // it was compiled, and kept if it exited 0
+)
+
+// compileCache keeps what runtimes compile code to between executions of
+// the same code (sandbox.compile_cache). An entry is a directory under dir,
+// named by compileCacheKey. A miss mounts an empty directory of its own
+// read-write, which harvest copies into the cache after a clean exit; later
+// executions with the key mount the entry read-only. Entries are per tenant:
+// the code that compiled one could have written anything into it. A nil
+// *compileCache caches nothing.
+type compileCache struct {
+	dir           string
+	languages     []string
+	maxBytes      int64
+	maxEntryBytes int64
+	now           func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*compiledEntry // by key
+	digests map[string]string         // image to the digest of its entries
+	bytes   int64
+}
+
+type compiledEntry struct {
+	image   string
+	bytes   int64
+	used    time.Time
+	users   int  // executions with it mounted, which keep it on disk
+	dropped bool // out of entries; removed when its last user is done
+}
+
+// compiledMount is an execution's use of the cache.
+type compiledMount struct {
+	key     string
+	image   string
+	digest  string
+	hostDir string // mounted at runtime.CompileCachePath
+	hit     bool
+}
+
+// newCompileCache empties cfg.Dir, creating it if need be: entries from an
+// earlier run don't say what image they were compiled against. It returns
+// nil when the cache is off.
+func newCompileCache(cfg config.CompileCacheConfig) (*compileCache, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	old, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range old {
+		if err := os.RemoveAll(filepath.Join(cfg.Dir, e.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return &compileCache{
+		dir:           cfg.Dir,
+		languages:     cfg.Languages,
+		maxBytes:      cfg.MaxBytes,
+		maxEntryBytes: cfg.MaxEntryBytes,
+		now:           time.Now,
+		entries:       make(map[string]*compiledEntry),
+		digests:       make(map[string]string),
+	}, nil
+}
+
+// compileCacheKey names the entry of code, by its SHA-256, that tenant ran
+// in language on the image with digest.
+func compileCacheKey(tenant, language, digest, codeHash string) string {
+	h := sha256.New()
+	for _, part := range []string{tenant, language, digest, codeHash} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// enabled reports whether language's executions use the cache.
+func (c *compileCache) enabled(language string) bool {
+	return c != nil && slices.Contains(c.languages, language)
+}
+
+// lookup finds key's entry, dropping every entry of image first when its
+// digest is no longer digest. On a hit the entry's directory is returned,
+// and kept on disk until release; on a miss, "".
+func (c *compileCache) lookup(key, image, digest string) (dir string, release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate(image, digest)
+	e, ok := c.entries[key]
+	if !ok {
+		return "", func() {}
+	}
+	e.used = c.now()
+	e.users++
+	return filepath.Join(c.dir, key), func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		e.users--
+		if e.dropped && e.users == 0 {
+			_ = os.RemoveAll(filepath.Join(c.dir, key))
+		}
+	}
+}
+
+// invalidate drops image's entries when they were compiled against a
+// digest other than digest. Called with mu held.
+func (c *compileCache) invalidate(image, digest string) {
+	if old, ok := c.digests[image]; ok && old == digest {
+		return
+	}
+	c.digests[image] = digest
+	for key, e := range c.entries {
+		if e.image == image {
+			c.drop(key, e)
+		}
+	}
+}
+
+// drop takes key's entry out of the cache, removing it from disk unless an
+// execution has it mounted. Called with mu held.
+func (c *compileCache) drop(key string, e *compiledEntry) {
+	delete(c.entries, key)
+	c.bytes -= e.bytes
+	e.dropped = true
+	if e.users == 0 {
+		_ = os.RemoveAll(filepath.Join(c.dir, key))
+	}
+}
+
+// harvest keeps what a miss left in from as m's entry, unless it is empty,
+// over maxEntryBytes or maxCompiledFiles, or not copied within
+// compileHarvestBudget. Only regular files are kept: from was writable by
+// the code, which may have left links to host files in it. Eviction then
+// brings the cache back under maxBytes, least recently used first.
+func (c *compileCache) harvest(m *compiledMount) error {
+	deadline := c.now().Add(compileHarvestBudget)
+	var files []string
+	var size int64
+	err := filepath.WalkDir(m.hostDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		files = append(files, path)
+		if size > c.maxEntryBytes || len(files) > maxCompiledFiles {
+			return fmt.Errorf("artifacts over %d bytes or %d files", c.maxEntryBytes, maxCompiledFiles)
+		}
+		return nil
+	})
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(c.dir, ".harvest-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var copied int64
+	for _, path := range files {
+		if c.now().After(deadline) {
+			return errors.New("harvest ran out of time")
+		}
+		rel, err := filepath.Rel(m.hostDir, path)
+		if err != nil {
+			return err
+		}
+		n, err := copyCompiled(path, filepath.Join(tmp, rel), c.maxEntryBytes-copied)
+		if err != nil {
+			return err
+		}
+		copied += n
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.digests[m.image] != m.digest {
+		return nil // the image changed meanwhile
+	}
+	if _, ok := c.entries[m.key]; ok {
+		return nil // another miss of the key got there first
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, m.key)); err != nil {
+		return err
+	}
+	c.entries[m.key] = &compiledEntry{image: m.image, bytes: copied, used: c.now()}
+	c.bytes += copied
+	c.evict()
+	return nil
+}
+
+// evict drops the least recently used entries not mounted anywhere until
+// the cache is within maxBytes. Called with mu held.
+func (c *compileCache) evict() {
+	for c.bytes > c.maxBytes {
+		var oldestKey string
+		var oldest *compiledEntry
+		for key, e := range c.entries {
+			if e.users == 0 && (oldest == nil || e.used.Before(oldest.used)) {
+				oldestKey, oldest = key, e
+			}
+		}
+		if oldest == nil {
+			return // everything left is in use; over until it isn't
+		}
+		c.drop(oldestKey, oldest)
+	}
+}
+
+// copyCompiled copies the regular file from to to, no more than limit
+// bytes of it, refusing anything that isn't a regular file by then.
+func copyCompiled(from, to string, limit int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, err
+	}
+	src, err := os.Open(from) // #nosec G304 -- a regular file under the execution's temp dir, checked below
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	if info, err := src.Stat(); err != nil || !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("%s is not a regular file", from)
+	}
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644) // #nosec G302 -- read by the unprivileged container user
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%s grew past the entry's limit", from)
+	}
+	return n, err
+}
+
+// compileCacheFor sets up req's use of the cache: a hit when the entry for
+// its code is there, a miss with a fresh directory under hostDir otherwise.
+// It returns nil when the cache doesn't apply: the language isn't cached or
+// its image can't use it, the image's digest is unknown, the code is an
+// upload or is traced, the caller asked for a read-only filesystem, or a
+// baked node compile cache is in use. release is called when the execution
+// is done with the mount.
+func (d *DockerRunner) compileCacheFor(ctx context.Context, req ExecutionRequest, rt runtime.Runtime, hostDir string) (m *compiledMount, release func(), err error) {
+	none := func() {}
+	cacher, ok := runtime.AsCompileCacher(rt)
+	if !d.compiled.enabled(rt.Name()) || !ok || req.CodeFile != "" || req.DebugTrace || req.ReadOnly ||
+		slices.Contains(req.Warmups, runtime.WarmupCompileCache) {
+		return nil, none, nil
+	}
+	var probe runtime.ImageProbe
+	if w, ok := runtime.AsWarmable(rt); ok {
+		probe, _ = d.warmups.get(ctx, rt.Image(), w.ProbeCommand())
+	}
+	if !cacher.CompileCacheUsable(probe) {
+		return nil, none, nil
+	}
+	digest := d.digests.get(rt.Image())
+	if digest == "" {
+		return nil, none, nil
+	}
+	m = &compiledMount{key: compileCacheKey(req.Tenant, rt.Name(), digest, requestCodeHash(req)), image: rt.Image(), digest: digest}
+	dir, release := d.compiled.lookup(m.key, m.image, m.digest)
+	if dir != "" {
+		m.hostDir, m.hit = dir, true
+		return m, release, nil
+	}
+	m.hostDir = filepath.Join(hostDir, compileCacheDir)
+	if err := os.Mkdir(m.hostDir, 0700); err != nil {
+		return nil, none, err
+	}
+	if err := os.Chmod(m.hostDir, 0777); err != nil { // #nosec G302 -- written by the unprivileged container user
+		return nil, none, err
+	}
+	return m, none, nil
+}
+
+// compileCacheArgs mounts req's cache directory and applies the runtime's
+// command for it to argv.
+func compileCacheArgs(rt runtime.Runtime, containerCodePath string, req ExecutionRequest, argv []string) (args, newArgv []string) {
+	cacher, ok := runtime.AsCompileCacher(rt)
+	if req.compiled == nil || !ok {
+		return nil, argv
+	}
+	mode := "rw"
+	if req.compiled.hit {
+		mode = "ro"
+	}
+	args = []string{"-v", fmt.Sprintf("%s:%s:%s", req.compiled.hostDir, runtime.CompileCachePath, mode)}
+	argv, env := cacher.CompileCacheCommand(argv, containerCodePath)
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	return args, argv
+}
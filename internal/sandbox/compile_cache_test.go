@@ -0,0 +1,248 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func newTestCompileCache(t *testing.T, maxBytes, maxEntryBytes int64) *compileCache {
+	t.Helper()
+	c, err := newCompileCache(config.CompileCacheConfig{
+		Dir: t.TempDir(), Languages: []string{"python"}, MaxBytes: maxBytes, MaxEntryBytes: maxEntryBytes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1700000000, 0)
+	c.now = func() time.Time { clock = clock.Add(time.Millisecond); return clock }
+	return c
+}
+
+// compiledMiss is a miss of key whose execution left files in its dir.
+func compiledMiss(t *testing.T, key, image, digest string, files map[string]string) *compiledMount {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &compiledMount{key: key, image: image, digest: digest, hostDir: dir}
+}
+
+func TestCompileCacheKey(t *testing.T) {
+	base := compileCacheKey("tenant", "python", "sha256:aa", "c0de")
+	for _, other := range []string{
+		compileCacheKey("other", "python", "sha256:aa", "c0de"),
+		compileCacheKey("tenant", "node", "sha256:aa", "c0de"),
+		compileCacheKey("tenant", "python", "sha256:bb", "c0de"),
+		compileCacheKey("tenant", "python", "sha256:aa", "c0df"),
+		compileCacheKey("tenantpython", "", "sha256:aa", "c0de"),
+	} {
+		if other == base {
+			t.Errorf("key collides with %s", base)
+		}
+	}
+}
+
+func TestNewCompileCache_EmptiesDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stale"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCompileCache(config.CompileCacheConfig{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("left over from an earlier run: %v", left)
+	}
+	if c, err := newCompileCache(config.CompileCacheConfig{}); c != nil || err != nil {
+		t.Errorf("without a dir: %v, %v", c, err)
+	}
+}
+
+func TestCompileCache_HarvestAndLookup(t *testing.T) {
+	c := newTestCompileCache(t, 1<<20, 1<<10)
+	if dir, _ := c.lookup("k", "py", "sha256:aa"); dir != "" {
+		t.Fatalf("empty cache hit: %s", dir)
+	}
+	if err := c.harvest(compiledMiss(t, "k", "py", "sha256:aa", map[string]string{"code.pyc": "bytecode", "sub/x": "y"})); err != nil {
+		t.Fatal(err)
+	}
+	dir, release := c.lookup("k", "py", "sha256:aa")
+	if dir == "" {
+		t.Fatal("harvested entry not found")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "code.pyc")); string(got) != "bytecode" {
+		t.Errorf("code.pyc = %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "sub", "x")); string(got) != "y" {
+		t.Errorf("sub/x = %q", got)
+	}
+
+	// A new digest drops the image's entries, but not from under the
+	// execution that has one mounted.
+	if dir, _ := c.lookup("k2", "py", "sha256:bb"); dir != "" {
+		t.Fatalf("hit after the digest changed: %s", dir)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("mounted entry removed: %v", err)
+	}
+	release()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("dropped entry still on disk after release: %v", err)
+	}
+	if c.bytes != 0 {
+		t.Errorf("bytes = %d after invalidation", c.bytes)
+	}
+
+	// A harvest against the old digest is too late to keep.
+	if err := c.harvest(compiledMiss(t, "k", "py", "sha256:aa", map[string]string{"code.pyc": "old"})); err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := c.lookup("k", "py", "sha256:bb"); dir != "" {
+		t.Errorf("entry of a replaced image kept")
+	}
+}
+
+func TestCompileCache_HarvestRefuses(t *testing.T) {
+	c := newTestCompileCache(t, 1<<20, 16)
+	c.lookup("big", "py", "sha256:aa")
+
+	if err := c.harvest(compiledMiss(t, "big", "py", "sha256:aa", map[string]string{"a": "0123456789", "b": "0123456789"})); err == nil {
+		t.Error("entry over max_entry_bytes kept")
+	}
+
+	m := compiledMiss(t, "link", "py", "sha256:aa", map[string]string{"code.pyc": "ok"})
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("host file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(m.hostDir, "leak")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.harvest(m); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := c.lookup("link", "py", "sha256:aa")
+	if _, err := os.Lstat(filepath.Join(dir, "leak")); !os.IsNotExist(err) {
+		t.Errorf("symlink copied into the cache: %v", err)
+	}
+
+	if err := c.harvest(compiledMiss(t, "empty", "py", "sha256:aa", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if dir, _ := c.lookup("empty", "py", "sha256:aa"); dir != "" {
+		t.Error("empty entry kept")
+	}
+}
+
+func TestCompileCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCompileCache(t, 20, 10)
+	c.lookup("a", "py", "sha256:aa") // harvests follow a lookup, which records the digest
+	for _, key := range []string{"a", "b"} {
+		if err := c.harvest(compiledMiss(t, key, "py", "sha256:aa", map[string]string{"code.pyc": "0123456789"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, releaseA := c.lookup("a", "py", "sha256:aa") // a is now the more recent, and mounted
+	if err := c.harvest(compiledMiss(t, "c", "py", "sha256:aa", map[string]string{"code.pyc": "0123456789"})); err != nil {
+		t.Fatal(err)
+	}
+	releaseA()
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if dir, _ := c.lookup(key, "py", "sha256:aa"); (dir != "") != want {
+			t.Errorf("%s cached: %v, want %v", key, dir != "", want)
+		}
+	}
+	if c.bytes != 20 {
+		t.Errorf("bytes = %d, want 20", c.bytes)
+	}
+}
+
+func TestCompileCacheFor(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	fake := func(ctx context.Context, args ...string) ([]byte, error) {
+		if args[0] == "image" {
+			return []byte("sha256:aa\n"), nil
+		}
+		return nil, errors.New("no probe")
+	}
+	d.digests, d.warmups = newDigestCache(fake), newWarmupProbes(fake)
+	d.compiled = newTestCompileCache(t, 1<<20, 1<<10)
+	py, _ := d.runtimes.Get("python")
+	node, _ := d.runtimes.Get("node")
+	req := ExecutionRequest{Language: "python", Code: "print(1)", Tenant: "t"}
+
+	m, release, err := d.compileCacheFor(context.Background(), req, py, t.TempDir())
+	if err != nil || m == nil || m.hit {
+		t.Fatalf("first run: %+v, %v", m, err)
+	}
+	release()
+	if info, err := os.Stat(m.hostDir); err != nil || info.Mode().Perm() != 0777 {
+		t.Errorf("miss dir not writable by the container user: %v %v", info, err)
+	}
+	if err := os.WriteFile(filepath.Join(m.hostDir, "code.pyc"), []byte("bytecode"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.compiled.harvest(m); err != nil {
+		t.Fatal(err)
+	}
+	hit, release, err := d.compileCacheFor(context.Background(), req, py, t.TempDir())
+	if err != nil || hit == nil || !hit.hit {
+		t.Fatalf("second run: %+v, %v", hit, err)
+	}
+	release()
+
+	other := req
+	other.Tenant = "u"
+	if m, _, _ := d.compileCacheFor(context.Background(), other, py, t.TempDir()); m == nil || m.hit {
+		t.Errorf("another tenant's entry used: %+v", m)
+	}
+	for name, r := range map[string]ExecutionRequest{
+		"traced":    {Language: "python", Code: "1", DebugTrace: true},
+		"read-only": {Language: "python", Code: "1", ReadOnly: true},
+		"upload":    {Language: "python", CodeFile: "/tmp/code.py"},
+	} {
+		if m, _, _ := d.compileCacheFor(context.Background(), r, py, t.TempDir()); m != nil {
+			t.Errorf("%s: %+v", name, m)
+		}
+	}
+	// node isn't in the languages, and its image couldn't be probed anyway.
+	if m, _, _ := d.compileCacheFor(context.Background(), ExecutionRequest{Language: "node", Code: "1"}, node, t.TempDir()); m != nil {
+		t.Errorf("node: %+v", m)
+	}
+}
+
+func TestBuildDockerArgs_CompileCache(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	py, _ := d.runtimes.Get("python")
+	req := ExecutionRequest{Language: "python", Code: "1", compiled: &compiledMount{hostDir: "/var/cache/compiled/k", hit: true}}
+
+	args := d.buildDockerArgs("exec-c", py, "/tmp/code.py", "/workspace/code.py", "/tmp/sandbox-exec-c", "/tmp/seccomp.json", req)
+	if !argsContainPair(args, "-v", "/var/cache/compiled/k:"+runtime.CompileCachePath+":ro") {
+		t.Errorf("hit not mounted read-only in %v", args)
+	}
+	want, _ := (&runtime.PythonRuntime{}).CompileCacheCommand(commandArgv(py, "/workspace/code.py", req), "/workspace/code.py")
+	want = append([]string{py.Image()}, want...)
+	if got := args[max(len(args)-len(want), 0):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %q\nwant %q", got, want)
+	}
+
+	req.compiled.hit = false
+	args = d.buildDockerArgs("exec-c", py, "/tmp/code.py", "/workspace/code.py", "/tmp/sandbox-exec-c", "/tmp/seccomp.json", req)
+	if !argsContainPair(args, "-v", "/var/cache/compiled/k:"+runtime.CompileCachePath+":rw") {
+		t.Errorf("miss not mounted read-write in %v", args)
+	}
+}
@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// ConfigChecker is implemented by backends that can find configuration
+// problems config.Check can't, because they depend on the backend or on what
+// it has pulled.
+type ConfigChecker interface {
+	ConfigWarnings(ctx context.Context, cfg *config.Config) []string
+}
+
+// ConfigWarnings warns about a container pool and CNI network the Docker
+// backend doesn't use and an auth proxy for a claude image that isn't pulled.
+func (d *DockerRunner) ConfigWarnings(ctx context.Context, cfg *config.Config) []string {
+	return dockerConfigWarnings(ctx, d.dockerOutput, d.runtimes, cfg)
+}
+
+func dockerConfigWarnings(ctx context.Context, docker func(ctx context.Context, args ...string) ([]byte, error), runtimes *runtime.Registry, cfg *config.Config) []string {
+	var warnings []string
+	if cfg.Pool.Enabled {
+		warnings = append(warnings, "pool.enabled has no effect with the docker backend, which starts a fresh container for every execution; set pool.enabled: false")
+	}
+	if cfg.Sandbox.CNI.Enabled {
+		warnings = append(warnings, "sandbox.cni has no effect with the docker backend, which networks containers through Docker's bridge; set sandbox.cni.enabled: false")
+	}
+	if cfg.AuthProxy.Port > 0 {
+		rt, err := runtimes.Get("claude")
+		if err != nil {
+			return append(warnings, "auth_proxy.port is set but no claude runtime is registered")
+		}
+		ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+		defer cancel()
+		if _, err := docker(ctx, "image", "inspect", "--format", "{{.Id}}", rt.Image()); err != nil {
+			warnings = append(warnings, fmt.Sprintf("auth_proxy.port is set but the claude image %s isn't present (%v), so claude executions will fail; build it with make claude-image", rt.Image(), err))
+		}
+	}
+	return warnings
+}
+
+// ConfigWarnings warns about an auth proxy the containerd backend has no use
+// for, since it can't run claude, and CNI plugins that aren't installed.
+func (r *Runner) ConfigWarnings(_ context.Context, cfg *config.Config) []string {
+	var warnings []string
+	if cfg.AuthProxy.Port > 0 {
+		warnings = append(warnings, "auth_proxy.port is set but the containerd backend can't run claude; set sandbox.backend: docker or auth_proxy.port: 0")
+	}
+	if r.network != nil {
+		for _, plugin := range r.network.binaries() {
+			if _, err := os.Stat(filepath.Join(r.network.pluginDir, plugin)); err != nil {
+				warnings = append(warnings, fmt.Sprintf("sandbox.cni.plugin_dir: no %s plugin in %s, so network_enabled executions will fail; install the CNI reference plugins there", plugin, r.network.pluginDir))
+			}
+		}
+	}
+	return warnings
+}
+
+// ConfigWarnings reports the wrapped backend's.
+func (c *ChaosBackend) ConfigWarnings(ctx context.Context, cfg *config.Config) []string {
+	if checker, ok := c.inner.(ConfigChecker); ok {
+		return checker.ConfigWarnings(ctx, cfg)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func TestDockerConfigWarnings(t *testing.T) {
+	pulled := func(_ context.Context, args ...string) ([]byte, error) { return []byte("sha256:abc\n"), nil }
+	missing := func(_ context.Context, args ...string) ([]byte, error) {
+		if args[0] != "image" || args[len(args)-1] != "sandbox-claude:latest" {
+			t.Errorf("docker %q", args)
+		}
+		return nil, errors.New("exit status 1: No such image")
+	}
+	tests := []struct {
+		name      string
+		pool      bool
+		proxyPort int
+		docker    func(context.Context, ...string) ([]byte, error)
+		want      []string
+	}{
+		{"nothing to say", false, 0, missing, nil},
+		{"pool", true, 0, pulled, []string{"pool.enabled has no effect with the docker backend"}},
+		{"claude image pulled", false, 8081, pulled, nil},
+		{"claude image missing", false, 8081, missing, []string{"the claude image sandbox-claude:latest isn't present (exit status 1: No such image)"}},
+		{"both", true, 8081, missing, []string{"pool.enabled", "auth_proxy.port"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Pool.Enabled = tt.pool
+			cfg.AuthProxy.Port = tt.proxyPort
+			got := dockerConfigWarnings(context.Background(), tt.docker, runtime.NewRegistry(), cfg)
+			if len(got) != len(tt.want) {
+				t.Fatalf("warnings %q, want %d", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("warning %q, want it to contain %q", got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerdConfigWarnings(t *testing.T) {
+	cfg := config.DefaultConfig()
+	var r Runner
+	if got := r.ConfigWarnings(context.Background(), cfg); len(got) != 0 {
+		t.Errorf("warnings %q without an auth proxy", got)
+	}
+	cfg.AuthProxy.Port = 8081
+	if got := r.ConfigWarnings(context.Background(), cfg); len(got) != 1 || !strings.Contains(got[0], "can't run claude") {
+		t.Errorf("warnings %q", got)
+	}
+	if got := NewChaosBackend(&r).ConfigWarnings(context.Background(), cfg); len(got) != 1 {
+		t.Errorf("chaos backend warnings %q, want the wrapped backend's", got)
+	}
+}
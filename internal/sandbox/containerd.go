@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/rs/zerolog/log"
 )
@@ -114,26 +115,90 @@ func (c *Client) Close() error {
 	return nil
 }
 
-// PullImage pulls a container image if it's not already available.
-func (c *Client) PullImage(ctx context.Context, ref string) (containerd.Image, error) {
+// PullImage pulls a container image if it's not already available. While
+// it pulls, progress, if not nil, is called with how far it has got every
+// pullProgressInterval; the ImagePull returned is nil when there was
+// nothing to pull.
+func (c *Client) PullImage(ctx context.Context, ref string, progress func(PullProgress)) (containerd.Image, *ImagePull, error) {
 	ctx = c.WithNamespace(ctx)
 
 	// Check if image already exists
 	image, err := c.inner.GetImage(ctx, ref)
 	if err == nil {
-		return image, nil
+		return image, nil, nil
 	}
 
 	// Pull the image
 	log.Info().Str("ref", ref).Msg("pulling image")
 
+	start := time.Now()
+	tracker := newPullTracker()
+	if progress != nil {
+		stop := reportPull(pullProgressInterval, func() PullProgress {
+			// A failed poll reports what the handlers alone know.
+			active, _ := c.inner.ContentStore().ListStatuses(ctx)
+			return tracker.progress(ref, active)
+		}, progress)
+		defer stop()
+	}
 	image, err = c.inner.Pull(ctx, ref,
 		containerd.WithPullUnpack,
+		containerd.WithImageHandlerWrapper(tracker.wrap),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("pulling image %s: %w", ref, err)
+		return nil, nil, fmt.Errorf("pulling image %s: %w", ref, err)
 	}
 
-	log.Info().Str("ref", ref).Msg("image pulled successfully")
-	return image, nil
+	pull := &ImagePull{Image: ref, Duration: time.Since(start), Bytes: tracker.fetchedBytes()}
+	log.Info().Str("ref", ref).Dur("took", pull.Duration).Int64("bytes", pull.Bytes).Msg("image pulled successfully")
+	return image, pull, nil
+}
+
+// containerdImages is the namespace's image store, for the disk monitor.
+// containerd has no dangling images: every image is a name.
+type containerdImages struct {
+	client *Client
+}
+
+func (c containerdImages) list(ctx context.Context) ([]storedImage, error) {
+	ctx = c.client.WithNamespace(ctx)
+	raw := c.client.Raw()
+	list, err := raw.ImageService().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []storedImage
+	byID := make(map[string]int)
+	for _, img := range list {
+		id := img.Target.Digest.String()
+		i, ok := byID[id]
+		if !ok {
+			size, err := containerd.NewImage(raw, img).Size(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("image %s: %w", img.Name, err)
+			}
+			i = len(out)
+			byID[id] = i
+			out = append(out, storedImage{ID: id, Size: size})
+		}
+		out[i].Refs = append(out[i].Refs, img.Name)
+	}
+	return out, nil
+}
+
+// remove deletes img's names; the garbage collector frees its content and
+// snapshots once nothing else refers to them.
+func (c containerdImages) remove(ctx context.Context, img storedImage) error {
+	ctx = c.client.WithNamespace(ctx)
+	for _, ref := range img.Refs {
+		if err := c.client.Raw().ImageService().Delete(ctx, ref, images.SynchronousDelete()); err != nil {
+			return fmt.Errorf("deleting %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+func (c containerdImages) present(ctx context.Context, ref string) bool {
+	_, err := c.client.Raw().GetImage(c.client.WithNamespace(ctx), ref)
+	return err == nil
 }
@@ -0,0 +1,326 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// CPUAbuseObserver counts the executions the CPU guardrail kills, by
+// language and by what let it: crypto_miner, silent or aggressive.
+type CPUAbuseObserver interface {
+	CPUAbuseKilled(language, trigger string)
+}
+
+// cpuStat is one reading of a cgroup's CPU accounting: the cumulative usage
+// and throttled time from cpu.stat, and the quota from cpu.max.
+type cpuStat struct {
+	at        time.Time
+	usage     time.Duration
+	throttled time.Duration
+	quota     float64 // CPUs; 0 when the cgroup has no quota
+}
+
+// cpuStatSource reads an execution's cgroup. It fails before the container
+// is running and after it has gone; the guard skips those readings.
+type cpuStatSource func(ctx context.Context) (cpuStat, error)
+
+// utilization is the share of the quota used between two readings.
+func utilization(from, to cpuStat) float64 {
+	elapsed := to.at.Sub(from.at)
+	if elapsed <= 0 || to.quota <= 0 {
+		return 0
+	}
+	return float64(to.usage-from.usage) / (float64(elapsed) * to.quota)
+}
+
+// cpuGuard kills an execution that keeps its CPU quota saturated
+// (sandbox.cpu_abuse): a miner otherwise burns a core for its whole timeout.
+// Saturation alone isn't enough outside aggressive mode, since honest code
+// can be busy too; the code must also have matched the crypto_miner pattern,
+// or have written no output for the whole stretch. A nil guard, for
+// executions it doesn't watch, passes output through and never fires.
+type cpuGuard struct {
+	cfg     config.CPUAbuseConfig
+	flagged bool // the code matched crypto_miner
+	source  cpuStatSource
+	now     func() time.Time
+	last    atomic.Int64 // unix nanos of the latest output write, 0 before any
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	done    chan struct{}
+	fired   atomic.Bool
+
+	// Owned by run until done is closed.
+	prev cpuStat // the latest reading
+	from cpuStat // the reading the saturated stretch started at; zero outside one
+}
+
+// watchCPU returns the context to run the program under, canceled when the
+// guard fires. Stop must be called once the program has exited.
+func watchCPU(ctx context.Context, cfg config.CPUAbuseConfig, req ExecutionRequest, source cpuStatSource) (context.Context, *cpuGuard) {
+	if !cfg.Enabled || source == nil {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	g := newCPUGuard(cfg, req.SuspectedMiner, source)
+	g.cancel = cancel
+	go g.run(ctx)
+	return ctx, g
+}
+
+func newCPUGuard(cfg config.CPUAbuseConfig, flagged bool, source cpuStatSource) *cpuGuard {
+	return &cpuGuard{
+		cfg:     cfg,
+		flagged: flagged,
+		source:  source,
+		now:     time.Now,
+		cancel:  func() {},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (g *cpuGuard) run(ctx context.Context) {
+	defer close(g.done)
+	ticker := time.NewTicker(g.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s, err := g.source(ctx)
+		if err != nil {
+			continue
+		}
+		if g.observe(s) {
+			g.fired.Store(true)
+			g.cancel()
+			return
+		}
+	}
+}
+
+// observe takes a reading and reports whether the execution has now been
+// saturated, with whatever else its trigger needs, for cfg.Sustain. A
+// reading under the fraction ends the stretch; in silent mode, output
+// during it starts the stretch again from this reading.
+func (g *cpuGuard) observe(cur cpuStat) bool {
+	prev := g.prev
+	g.prev = cur
+	if prev.at.IsZero() || utilization(prev, cur) < g.cfg.UsageFraction {
+		g.from = cpuStat{}
+		return false
+	}
+	if g.from.at.IsZero() {
+		g.from = prev
+	}
+	if g.trigger() == "silent" && g.last.Load() > g.from.at.UnixNano() {
+		g.from = cur
+		return false
+	}
+	return cur.at.Sub(g.from.at) >= g.cfg.Sustain
+}
+
+// trigger names what, besides saturation, lets the guard kill.
+func (g *cpuGuard) trigger() string {
+	switch {
+	case g.cfg.Mode == "aggressive":
+		return "aggressive"
+	case g.flagged:
+		return "crypto_miner"
+	default:
+		return "silent"
+	}
+}
+
+// Output wraps an output writer so that writes to it count as output.
+func (g *cpuGuard) Output(w io.Writer) io.Writer {
+	if g == nil {
+		return w
+	}
+	return cpuGuardOutput{g: g, w: w}
+}
+
+type cpuGuardOutput struct {
+	g *cpuGuard
+	w io.Writer
+}
+
+func (o cpuGuardOutput) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.g.last.Store(o.g.now().UnixNano())
+	}
+	return o.w.Write(p)
+}
+
+// Fired reports whether the guard killed the execution.
+func (g *cpuGuard) Fired() bool {
+	return g != nil && g.fired.Load()
+}
+
+// Stop ends the guard, waiting for a reading in progress, and releases its
+// context.
+func (g *cpuGuard) Stop() {
+	if g == nil {
+		return
+	}
+	close(g.stop)
+	<-g.done
+	g.cancel()
+}
+
+// report describes the kill as a runtime security event, with what was
+// measured over the stretch. Only valid once it has fired.
+func (g *cpuGuard) report() SecurityEvent {
+	from, to := g.from, g.prev
+	why := map[string]string{
+		"aggressive":   "aggressive mode",
+		"crypto_miner": "code matched crypto_miner",
+		"silent":       "no output meanwhile",
+	}[g.trigger()]
+	detail := fmt.Sprintf("cpu at %.0f%% of its %g-cpu quota for %s, throttled %s of it; %s; killed",
+		utilization(from, to)*100, to.quota, to.at.Sub(from.at).Round(time.Millisecond),
+		(to.throttled - from.throttled).Round(time.Millisecond), why)
+	return SecurityEvent{Type: "resource_abuse", Source: SourceRuntime, Severity: "high", Detail: detail}
+}
+
+// cgroupCPUSource reads the cgroup v2 directory dir.
+func cgroupCPUSource(dir string) cpuStatSource {
+	return func(context.Context) (cpuStat, error) {
+		return readCPUStat(dir)
+	}
+}
+
+// dockerCPUSource reads a docker container's cgroup. The path is the
+// host's, so there is none for a remote daemon, and no source.
+func dockerCPUSource(cgroup *containerCgroup) cpuStatSource {
+	if cgroup == nil {
+		return nil
+	}
+	return func(context.Context) (cpuStat, error) {
+		dir, err := cgroup.Dir()
+		if err != nil {
+			return cpuStat{}, err
+		}
+		return readCPUStat(dir)
+	}
+}
+
+// dockerCgroupDir finds container's cgroup v2 directory as it starts: one
+// docker events stream, from since, before it was created, waits for the
+// start, and one inspect then gives its init process. A container whose
+// program has already exited by then has none.
+func (d *DockerRunner) dockerCgroupDir(container string, since time.Time) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		eventsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		cmd := d.dockerCommand(eventsCtx, "events", "--since", strconv.FormatInt(since.Unix(), 10),
+			"--filter", "container="+container, "--filter", "event=start", "--format", "{{.ID}}")
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return "", err
+		}
+		if err := cmd.Start(); err != nil {
+			return "", err
+		}
+		id, readErr := bufio.NewReader(out).ReadString('\n')
+		cancel()
+		_ = cmd.Wait()
+		if strings.TrimSpace(id) == "" {
+			return "", fmt.Errorf("docker events ended before %s started: %v", container, readErr)
+		}
+		pid, err := d.dockerOutput(ctx, "inspect", "--format", "{{.State.Pid}}", container)
+		if err != nil {
+			return "", err
+		}
+		if p := strings.TrimSpace(string(pid)); p != "" && p != "0" {
+			return procCgroupDir(p)
+		}
+		return "", errors.New("container not running")
+	}
+}
+
+// procCgroupDir returns the cgroup v2 directory of process pid.
+func procCgroupDir(pid string) (string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", pid, "cgroup")) // #nosec G304 -- pid from docker inspect
+	if err != nil {
+		return "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if path, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", fmt.Errorf("process %s has no cgroup v2 entry", pid)
+}
+
+// readCPUStat reads cpu.stat and cpu.max in the cgroup v2 directory dir.
+func readCPUStat(dir string) (cpuStat, error) {
+	at := time.Now()
+	stat, err := os.ReadFile(filepath.Join(dir, "cpu.stat")) // #nosec G304 -- cgroup of our own container
+	if err != nil {
+		return cpuStat{}, err
+	}
+	limit, err := os.ReadFile(filepath.Join(dir, "cpu.max")) // #nosec G304 -- cgroup of our own container
+	if err != nil {
+		return cpuStat{}, err
+	}
+	s := parseCPUStat(stat)
+	s.at, s.quota = at, parseCPUMax(limit)
+	return s, nil
+}
+
+// parseCPUStat extracts usage_usec and throttled_usec from cpu.stat.
+func parseCPUStat(data []byte) cpuStat {
+	var s cpuStat
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "usage_usec":
+			s.usage = time.Duration(n) * time.Microsecond
+		case "throttled_usec":
+			s.throttled = time.Duration(n) * time.Microsecond
+		}
+	}
+	return s
+}
+
+// parseCPUMax returns the quota in cpu.max, "<quota> <period>", in CPUs: 0
+// for "max", which has none.
+func parseCPUMax(data []byte) float64 {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0
+	}
+	quota, err1 := strconv.ParseFloat(fields[0], 64)
+	period, err2 := strconv.ParseFloat(fields[1], 64)
+	if err1 != nil || err2 != nil || period <= 0 {
+		return 0
+	}
+	return quota / period
+}
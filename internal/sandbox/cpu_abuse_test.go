@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// readings stands in for a cgroup polled once a second: each reading used
+// the given share of a 1-cpu quota since the one before.
+func readings(start time.Time, quota float64, shares ...float64) []cpuStat {
+	out := []cpuStat{{at: start, quota: quota}}
+	for _, share := range shares {
+		prev := out[len(out)-1]
+		used := time.Duration(share * quota * float64(time.Second))
+		out = append(out, cpuStat{
+			at:        prev.at.Add(time.Second),
+			usage:     prev.usage + used,
+			throttled: prev.throttled + time.Second/2,
+			quota:     quota,
+		})
+	}
+	return out
+}
+
+func repeat(share float64, n int) []float64 {
+	shares := make([]float64, n)
+	for i := range shares {
+		shares[i] = share
+	}
+	return shares
+}
+
+func TestCPUGuard_Observe(t *testing.T) {
+	bursty := []float64{1, 1, 1, 0.2, 1, 1, 1, 0.2, 1, 1, 1, 1, 0.2}
+	tests := []struct {
+		name    string
+		mode    string
+		flagged bool
+		quota   float64
+		shares  []float64
+		output  int // output is written just before this reading; 0 for none
+		fireAt  int // the reading the guard fires on; 0 for never
+	}{
+		{"sustained and silent", "default", false, 1, repeat(1, 10), 0, 5},
+		{"half a cpu quota", "default", false, 0.5, repeat(0.95, 10), 0, 5},
+		{"bursty", "default", false, 1, bursty, 0, 0},
+		{"under the fraction", "default", false, 1, repeat(0.85, 10), 0, 0},
+		{"no quota", "default", false, 0, repeat(1, 10), 0, 0},
+		{"output restarts the clock", "default", false, 1, repeat(1, 12), 4, 9},
+		{"output before saturation", "default", false, 1, append([]float64{0.1}, repeat(1, 8)...), 1, 6},
+		{"flagged miner ignores output", "default", true, 1, repeat(1, 12), 4, 5},
+		{"aggressive ignores output", "aggressive", false, 1, repeat(1, 12), 4, 5},
+		{"aggressive bursty", "aggressive", false, 1, bursty, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.CPUAbuseConfig{Enabled: true, Mode: tt.mode, UsageFraction: 0.9, Sustain: 5 * time.Second, PollInterval: time.Second}
+			g := newCPUGuard(cfg, tt.flagged, nil)
+			rs := readings(time.Unix(1000, 0), tt.quota, tt.shares...)
+			fired := 0
+			for i, r := range rs {
+				if i == tt.output && i > 0 {
+					g.now = func() time.Time { return r.at.Add(-time.Second / 2) }
+					g.Output(io.Discard).Write([]byte("tick\n"))
+				}
+				if g.observe(r) {
+					fired = i
+					break
+				}
+			}
+			if fired != tt.fireAt {
+				t.Errorf("fired on reading %d, want %d", fired, tt.fireAt)
+			}
+		})
+	}
+}
+
+func TestCPUGuard_KillsAndReports(t *testing.T) {
+	var n atomic.Int64
+	start := time.Unix(1000, 0)
+	// Every poll reads another 100ms of a half-cpu quota used in full.
+	source := func(context.Context) (cpuStat, error) {
+		i := n.Add(1)
+		if i == 1 {
+			return cpuStat{}, errors.New("container not running")
+		}
+		return cpuStat{
+			at:        start.Add(time.Duration(i) * 100 * time.Millisecond),
+			usage:     time.Duration(i) * 50 * time.Millisecond,
+			throttled: time.Duration(i) * 40 * time.Millisecond,
+			quota:     0.5,
+		}, nil
+	}
+	cfg := config.CPUAbuseConfig{Enabled: true, Mode: "default", UsageFraction: 0.9, Sustain: time.Second, PollInterval: time.Millisecond}
+	execCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx, g := watchCPU(execCtx, cfg, ExecutionRequest{SuspectedMiner: true}, source)
+	defer g.Stop()
+
+	<-ctx.Done()
+	if !g.Fired() || execCtx.Err() != nil {
+		t.Fatalf("fired %v, exec ctx %v: want the guard's kill, not the timeout", g.Fired(), execCtx.Err())
+	}
+	if g.trigger() != "crypto_miner" {
+		t.Errorf("trigger %q, want crypto_miner", g.trigger())
+	}
+	event := g.report()
+	if event.Type != "resource_abuse" || event.Source != SourceRuntime {
+		t.Errorf("event = %+v", event)
+	}
+	for _, want := range []string{"100% of its 0.5-cpu quota for 1s", "throttled 400ms", "code matched crypto_miner"} {
+		if !strings.Contains(event.Detail, want) {
+			t.Errorf("detail %q doesn't say %q", event.Detail, want)
+		}
+	}
+}
+
+func TestCPUGuard_Off(t *testing.T) {
+	ctx := context.Background()
+	got, g := watchCPU(ctx, config.CPUAbuseConfig{PollInterval: time.Second}, ExecutionRequest{}, cgroupCPUSource(t.TempDir()))
+	if got != ctx || g != nil {
+		t.Fatal("a disabled guard watched the execution")
+	}
+	if g.Output(io.Discard) != io.Discard || g.Fired() {
+		t.Error("a nil guard wrapped output or fired")
+	}
+	g.Stop()
+}
+
+func TestReadCPUStat(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := readCPUStat(dir); err == nil {
+		t.Fatal("read a cgroup without cpu.stat")
+	}
+	write("cpu.stat", "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\nnr_periods 30\nnr_throttled 20\nthrottled_usec 1200000\n")
+	write("cpu.max", "50000 100000\n")
+	s, err := readCPUStat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.usage != 2500*time.Millisecond || s.throttled != 1200*time.Millisecond || s.quota != 0.5 || s.at.IsZero() {
+		t.Errorf("read %+v", s)
+	}
+
+	write("cpu.max", "max 100000\n")
+	if s, _ := readCPUStat(dir); s.quota != 0 {
+		t.Errorf("quota %g for cpu.max \"max\", want none", s.quota)
+	}
+}
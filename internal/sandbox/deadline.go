@@ -0,0 +1,138 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Phase is a stretch of an execution with a time budget of its own.
+type Phase string
+
+const (
+	PhaseSetup   Phase = "setup"   // pulling the image and creating the container
+	PhaseRun     Phase = "run"     // the program, from the moment it starts
+	PhaseCleanup Phase = "cleanup" // killing and removing the container
+)
+
+const (
+	// DefaultSetupTimeout caps the setup phase when sandbox.setup_timeout
+	// is unset.
+	DefaultSetupTimeout = time.Minute
+	// DefaultCleanupGrace caps the cleanup phase when sandbox.cleanup_grace
+	// is unset.
+	DefaultCleanupGrace = 30 * time.Second
+)
+
+// phaseTimeout is the cause a phase's context is canceled with when its
+// budget runs out.
+type phaseTimeout struct {
+	phase  Phase
+	budget time.Duration
+}
+
+func (e *phaseTimeout) Error() string {
+	return fmt.Sprintf("%s phase ran past its %s budget", e.phase, e.budget)
+}
+
+// deadlines splits an execution's time into phases, each with a budget and
+// a context that ends with it:
+//
+//   - setup, for the image pull and the container's creation, capped by
+//     sandbox.setup_timeout. None of it comes out of the request's timeout,
+//     so a slow registry is an infrastructure failure, not the program's.
+//   - run, the request's timeout, counted from when the program starts.
+//   - cleanup, sandbox.cleanup_grace for killing and removing the
+//     container. It runs even once the caller has gone.
+//
+// Budgets don't carry over: a setup that finishes early doesn't lengthen
+// the run, and a slow one doesn't shorten it. Setup and run also end with
+// the caller's context, which expired tells apart from running out of
+// budget.
+type deadlines struct {
+	setup, run, cleanup time.Duration
+
+	// afterFunc is time.AfterFunc, returning its Stop; tests replace it
+	// with a fake clock.
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+}
+
+// newDeadlines takes the request's timeout as the run budget. A setup or
+// cleanup budget of 0 takes the default.
+func newDeadlines(setup, run, cleanup time.Duration) *deadlines {
+	if setup <= 0 {
+		setup = DefaultSetupTimeout
+	}
+	if cleanup <= 0 {
+		cleanup = DefaultCleanupGrace
+	}
+	return &deadlines{
+		setup:   setup,
+		run:     run,
+		cleanup: cleanup,
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		},
+	}
+}
+
+func (d *deadlines) budget(p Phase) time.Duration {
+	switch p {
+	case PhaseSetup:
+		return d.setup
+	case PhaseCleanup:
+		return d.cleanup
+	default:
+		return d.run
+	}
+}
+
+// phase starts phase p: its context is canceled with a *phaseTimeout cause
+// once the phase's budget has passed from now, or when end is called. A
+// cleanup context isn't canceled with parent, only bounded by its grace.
+func (d *deadlines) phase(parent context.Context, p Phase) (ctx context.Context, end func()) {
+	if p == PhaseCleanup {
+		parent = context.WithoutCancel(parent)
+	}
+	budget := d.budget(p)
+	ctx, cancel := context.WithCancelCause(parent)
+	stop := d.afterFunc(budget, func() { cancel(&phaseTimeout{phase: p, budget: budget}) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// expired reports the phase whose budget ended ctx, when that, and not the
+// caller, is what ended it.
+func expired(ctx context.Context) (Phase, bool) {
+	var pt *phaseTimeout
+	if ctx.Err() != nil && errors.As(context.Cause(ctx), &pt) {
+		return pt.phase, true
+	}
+	return "", false
+}
+
+// setupError wraps err, from an operation of the setup phase, as
+// ErrSetupTimeout when the phase's budget is what stopped it.
+func setupError(ctx context.Context, execID, op string, err error) error {
+	if p, ok := expired(ctx); ok && p == PhaseSetup {
+		err = fmt.Errorf("%w: %w", ErrSetupTimeout, context.Cause(ctx))
+	}
+	return &ExecutionError{ExecID: execID, Op: op, Err: err}
+}
+
+// TimeoutPhase reports which phase's budget an execution ran out of, from
+// what the backend returned for it: PhaseSetup when pulling the image or
+// creating the container took too long, an infrastructure problem that may
+// pass on a retry, or PhaseRun when the program used up its timeout.
+func TimeoutPhase(result *ExecutionResult, err error) (Phase, bool) {
+	switch {
+	case errors.Is(err, ErrSetupTimeout):
+		return PhaseSetup, true
+	case result != nil && result.ExitClass == ExitTimeoutKill, errors.Is(err, ErrTimeout):
+		return PhaseRun, true
+	}
+	return "", false
+}
@@ -0,0 +1,283 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTimers is a clock for deadlines whose timers fire only when advance
+// moves past them.
+type fakeTimers struct {
+	mu     sync.Mutex
+	now    time.Duration
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Duration
+	f    func()
+	done bool // fired or stopped
+}
+
+func (c *fakeTimers) afterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{at: c.now + d, f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		pending := !t.done
+		t.done = true
+		return pending
+	}
+}
+
+func (c *fakeTimers) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	var due []func()
+	for _, t := range c.timers {
+		if !t.done && t.at <= c.now {
+			t.done = true
+			due = append(due, t.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+func testDeadlines(setup, run, cleanup time.Duration) (*deadlines, *fakeTimers) {
+	clock := &fakeTimers{}
+	d := newDeadlines(setup, run, cleanup)
+	d.afterFunc = clock.afterFunc
+	return d, clock
+}
+
+func TestDeadlines_PhaseExpiry(t *testing.T) {
+	for _, p := range []Phase{PhaseSetup, PhaseRun, PhaseCleanup} {
+		t.Run(string(p), func(t *testing.T) {
+			d, clock := testDeadlines(time.Minute, 10*time.Second, 30*time.Second)
+			ctx, end := d.phase(context.Background(), p)
+			defer end()
+
+			clock.advance(d.budget(p) - time.Nanosecond)
+			if ctx.Err() != nil {
+				t.Fatalf("%s ended before its %s budget: %v", p, d.budget(p), context.Cause(ctx))
+			}
+			clock.advance(time.Nanosecond)
+			if got, ok := expired(ctx); !ok || got != p {
+				t.Errorf("expired() = %q, %v; want %q", got, ok, p)
+			}
+		})
+	}
+}
+
+func TestDeadlines_BudgetsDontCarryOver(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup time.Duration // how long setup takes
+	}{
+		{"fast setup", time.Second},
+		{"slow setup", 59 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, clock := testDeadlines(time.Minute, 10*time.Second, 30*time.Second)
+			setupCtx, endSetup := d.phase(context.Background(), PhaseSetup)
+			clock.advance(tt.setup)
+			endSetup()
+			if _, ok := expired(setupCtx); ok {
+				t.Fatal("a finished setup reported as expired")
+			}
+
+			// The run gets exactly its timeout from when it starts.
+			runCtx, endRun := d.phase(context.Background(), PhaseRun)
+			defer endRun()
+			clock.advance(10*time.Second - time.Millisecond)
+			if runCtx.Err() != nil {
+				t.Fatalf("run ended %s in: %v", 10*time.Second-time.Millisecond, context.Cause(runCtx))
+			}
+			clock.advance(time.Millisecond)
+			if p, ok := expired(runCtx); !ok || p != PhaseRun {
+				t.Errorf("expired() = %q, %v; want run", p, ok)
+			}
+		})
+	}
+
+	d, clock := testDeadlines(time.Minute, 10*time.Second, 30*time.Second)
+	setupCtx, endSetup := d.phase(context.Background(), PhaseSetup)
+	defer endSetup()
+	clock.advance(time.Minute)
+	if p, ok := expired(setupCtx); !ok || p != PhaseSetup {
+		t.Errorf("setup past its budget: expired() = %q, %v; want setup", p, ok)
+	}
+}
+
+func TestDeadlines_CallerCancel(t *testing.T) {
+	d, clock := testDeadlines(time.Minute, 10*time.Second, 30*time.Second)
+	parent, cancel := context.WithCancel(context.Background())
+	runCtx, endRun := d.phase(parent, PhaseRun)
+	defer endRun()
+	cancel()
+
+	if runCtx.Err() == nil {
+		t.Fatal("run outlived its caller")
+	}
+	if p, ok := expired(runCtx); ok {
+		t.Errorf("a canceled caller reported as %s running out", p)
+	}
+
+	// Cleanup runs for a caller that has gone, within its grace.
+	cleanupCtx, endCleanup := d.phase(parent, PhaseCleanup)
+	defer endCleanup()
+	if cleanupCtx.Err() != nil {
+		t.Fatal("cleanup canceled with its caller")
+	}
+	clock.advance(30 * time.Second)
+	if p, ok := expired(cleanupCtx); !ok || p != PhaseCleanup {
+		t.Errorf("expired() = %q, %v; want cleanup", p, ok)
+	}
+}
+
+func TestDeadlines_Defaults(t *testing.T) {
+	d := newDeadlines(0, 5*time.Second, 0)
+	if d.setup != DefaultSetupTimeout || d.run != 5*time.Second || d.cleanup != DefaultCleanupGrace {
+		t.Errorf("budgets %s/%s/%s", d.setup, d.run, d.cleanup)
+	}
+}
+
+func TestTimeoutPhase(t *testing.T) {
+	d, clock := testDeadlines(time.Minute, 10*time.Second, 30*time.Second)
+	setupCtx, end := d.phase(context.Background(), PhaseSetup)
+	defer end()
+	clock.advance(time.Minute)
+	setupErr := setupError(setupCtx, "x", "pull_image", setupCtx.Err())
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		result *ExecutionResult
+		err    error
+		want   Phase
+	}{
+		{"setup ran out", nil, setupErr, PhaseSetup},
+		{"program ran out", &ExecutionResult{ExitClass: ExitTimeoutKill}, ErrTimeout, PhaseRun},
+		{"idle", &ExecutionResult{ExitClass: ExitIdleTimeout}, ErrIdleTimeout, ""},
+		{"setup canceled by the caller", nil, setupError(canceled, "x", "pull_image", canceled.Err()), ""},
+		{"completed", &ExecutionResult{ExitClass: ExitUser}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := TimeoutPhase(tt.result, tt.err); got != tt.want {
+				t.Errorf("TimeoutPhase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if !errors.Is(setupErr, ErrSetupTimeout) || StateOf(nil, setupErr) == StateOf(nil, ErrTimeout) {
+		t.Errorf("setup timeout %v, state %s: want ErrSetupTimeout, not a timeout state", setupErr, StateOf(nil, setupErr))
+	}
+}
+
+// fakeDockerRunner is a DockerRunner whose docker CLI is a script: images
+// are missing until pulled, a pull takes $PULL_SECONDS and a run
+// $RUN_SECONDS, both in a child the CLI leaves holding its output when it
+// is killed.
+func fakeDockerRunner(t *testing.T, pull, run string) *DockerRunner {
+	t.Helper()
+	t.Setenv("PULL_SECONDS", pull)
+	t.Setenv("RUN_SECONDS", run)
+	return scriptedDockerRunner(t, `sleep "$RUN_SECONDS"; echo ran`)
+}
+
+// scriptedDockerRunner is a DockerRunner whose docker CLI is a script
+// running run, in sh, for docker run.
+func scriptedDockerRunner(t *testing.T, run string) *DockerRunner {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+image) [ -f "$STATE/pulled" ] && echo sha256:fake && exit 0; echo "Error: No such image" >&2; exit 1 ;;
+pull) sleep "${PULL_SECONDS:-0}"; touch "$STATE/pulled" ;;
+run) ` + run + ` ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("STATE", dir)
+
+	d := newDockerRunner(10, nil, 0, "", 1)
+	d.dockerHost = ""
+	d.verifySeccomp = false
+	d.warmups = nil
+	t.Cleanup(func() {
+		if d.seccomp != nil {
+			d.seccomp.remove()
+		}
+	})
+	return d
+}
+
+func TestDockerRunner_PhaseTimeouts(t *testing.T) {
+	req := ExecutionRequest{Language: "python", Code: "print('ran')", Timeout: 500 * time.Millisecond}
+
+	t.Run("pull stall keeps the run budget", func(t *testing.T) {
+		d := fakeDockerRunner(t, "1", "0.1")
+		d.setupTimeout = 5 * time.Second
+		result, err := d.Execute(context.Background(), req)
+		if err != nil {
+			t.Fatalf("a 1s pull with a 500ms timeout: %v", err)
+		}
+		if result.ExitClass != ExitUser || result.Output != "ran\n" || result.Duration >= req.Timeout {
+			t.Errorf("result %s %q in %s", result.ExitClass, result.Output, result.Duration)
+		}
+	})
+
+	t.Run("setup past its cap", func(t *testing.T) {
+		d := fakeDockerRunner(t, "5", "0")
+		d.setupTimeout = 300 * time.Millisecond
+		start := time.Now()
+		result, err := d.Execute(context.Background(), req)
+		if result != nil || !errors.Is(err, ErrSetupTimeout) {
+			t.Fatalf("Execute() = %v, %v; want ErrSetupTimeout", result, err)
+		}
+		if p, _ := TimeoutPhase(result, err); p != PhaseSetup {
+			t.Errorf("TimeoutPhase() = %q", p)
+		}
+		if took := time.Since(start); took > 300*time.Millisecond+dockerKillWait+time.Second {
+			t.Errorf("returned after %s", took)
+		}
+	})
+
+	t.Run("program past its timeout", func(t *testing.T) {
+		d := fakeDockerRunner(t, "0", "5")
+		start := time.Now()
+		result, err := d.Execute(context.Background(), req)
+		if !errors.Is(err, ErrTimeout) || result == nil || result.ExitClass != ExitTimeoutKill {
+			t.Fatalf("Execute() = %+v, %v; want a timeout_kill", result, err)
+		}
+		if p, _ := TimeoutPhase(result, err); p != PhaseRun {
+			t.Errorf("TimeoutPhase() = %q", p)
+		}
+		// The killed CLI's sleep still holds its pipes.
+		if took := time.Since(start); took > req.Timeout+dockerKillWait+time.Second {
+			t.Errorf("returned after %s", took)
+		}
+		if want := fmt.Sprintf("execution exceeded %s timeout", req.Timeout); len(result.SecurityEvents) == 0 || result.SecurityEvents[len(result.SecurityEvents)-1].Detail != want {
+			t.Errorf("events %+v, want %q", result.SecurityEvents, want)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package sandbox
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"safe-agent-sandbox/pkg/seccomp"
+)
+
+const (
+	// debugTraceFile is the host file, in the execution's temp dir, that
+	// strace writes its trace to.
+	debugTraceFile = "trace"
+	// debugTracePath is where that file is mounted in the container, and
+	// debugTraceStrace where sandbox.debug_trace.strace is.
+	debugTracePath   = "/run/sandbox/trace"
+	debugTraceStrace = "/run/sandbox/strace"
+	// maxTraceFailures and maxTraceDeniedPaths cap a TraceSummary's lists.
+	maxTraceFailures    = 20
+	maxTraceDeniedPaths = 20
+)
+
+// DebugTrace is what strace recorded of an execution's file and network
+// syscalls, with debug_trace. Trace is cut at sandbox.debug_trace.max_bytes,
+// and Truncated says so.
+type DebugTrace struct {
+	Trace     string       `json:"trace"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Summary   TraceSummary `json:"summary"`
+}
+
+// TraceSummary is what a trace says at a glance: the calls that failed, by
+// syscall and errno, and the paths refused for want of permission.
+type TraceSummary struct {
+	Calls       int            `json:"calls"`
+	Failed      int            `json:"failed"`
+	Failures    []TraceFailure `json:"failures,omitempty"`     // most frequent first
+	DeniedPaths []string       `json:"denied_paths,omitempty"` // EACCES, EPERM or EROFS, in the order first seen
+}
+
+// TraceFailure counts the calls of one syscall that failed with one errno.
+type TraceFailure struct {
+	Syscall string `json:"syscall"`
+	Errno   string `json:"errno"`
+	Count   int    `json:"count"`
+	Path    string `json:"path,omitempty"` // the first call's, when it named one
+}
+
+// traceCommand runs argv under strace, following its children and
+// recording their file and network syscalls. strace writes through a pipe
+// that keeps the first maxBytes+1 bytes, the one over telling a full trace
+// from a cut one, and drains the rest so the traced code isn't held up.
+func traceCommand(argv []string, maxBytes int64) []string {
+	sink := fmt.Sprintf("|head -c %d >%s; cat >/dev/null", maxBytes+1, debugTracePath)
+	return append([]string{debugTraceStrace, "-f", "-qq", "-s", "256", "-e", "trace=%file,%network", "-o", sink, "--"}, argv...)
+}
+
+// readTrace reads the trace an execution left at path.
+func readTrace(path string, maxBytes int64) *DebugTrace {
+	data, err := os.ReadFile(path) // #nosec G304 -- path built internally under hostDir
+	if err != nil {
+		return nil
+	}
+	trace := &DebugTrace{Trace: string(data)}
+	if int64(len(data)) > maxBytes {
+		trace.Trace, trace.Truncated = string(data[:maxBytes]), true
+	}
+	trace.Summary = summarizeTrace(trace.Trace)
+	return trace
+}
+
+var (
+	// traceCall matches a call strace finished on one line, or the start
+	// of one it finished later: the result follows the last ") = ".
+	traceCall    = regexp.MustCompile(`^(\w+)\((.*)(?:\) += (.*)| <unfinished \.\.\.>)$`)
+	traceResumed = regexp.MustCompile(`^<\.\.\. (\w+) resumed>(.*?)\) += (.*)$`)
+	traceQuoted  = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	tracePID     = regexp.MustCompile(`^(?:\[pid +(\d+)\]|(\d+)) +`)
+)
+
+// summarizeTrace sums up strace -f output. A call strace split around
+// another process's is counted once, when it resumes.
+func summarizeTrace(trace string) TraceSummary {
+	var s TraceSummary
+	type key struct{ syscall, errno string }
+	failures := make(map[key]*TraceFailure)
+	denied := make(map[string]bool)
+	unfinished := make(map[string]string) // pid and syscall to the call's arguments
+	for _, line := range strings.Split(trace, "\n") {
+		pid := ""
+		if m := tracePID.FindStringSubmatch(line); m != nil {
+			pid = m[1] + m[2]
+			line = line[len(m[0]):]
+		}
+		var syscall, args, ret string
+		if m := traceResumed.FindStringSubmatch(line); m != nil {
+			syscall, ret = m[1], m[3]
+			args = unfinished[pid+" "+syscall] + m[2]
+			delete(unfinished, pid+" "+syscall)
+		} else if m := traceCall.FindStringSubmatch(line); m != nil {
+			syscall, args, ret = m[1], m[2], m[3]
+			if strings.HasSuffix(line, "<unfinished ...>") {
+				unfinished[pid+" "+syscall] = args
+				continue
+			}
+		} else {
+			continue // signals, exits and lines cut short
+		}
+		s.Calls++
+		errno, failed := traceErrno(ret)
+		if !failed {
+			continue
+		}
+		s.Failed++
+		path := ""
+		if m := traceQuoted.FindStringSubmatch(args); m != nil {
+			path = m[1]
+		}
+		k := key{syscall, errno}
+		if f, ok := failures[k]; ok {
+			f.Count++
+		} else {
+			failures[k] = &TraceFailure{Syscall: syscall, Errno: errno, Count: 1, Path: path}
+		}
+		switch errno {
+		case "EACCES", "EPERM", "EROFS":
+			if path != "" && !denied[path] && len(s.DeniedPaths) < maxTraceDeniedPaths {
+				denied[path] = true
+				s.DeniedPaths = append(s.DeniedPaths, path)
+			}
+		}
+	}
+	for _, f := range failures {
+		s.Failures = append(s.Failures, *f)
+	}
+	slices.SortFunc(s.Failures, func(a, b TraceFailure) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Syscall, b.Syscall), cmp.Compare(a.Errno, b.Errno))
+	})
+	if len(s.Failures) > maxTraceFailures {
+		s.Failures = s.Failures[:maxTraceFailures]
+	}
+	return s
+}
+
+// traceErrno reads a call's result: "-1 ENOENT (No such file or directory)"
+// failed with ENOENT.
+func traceErrno(ret string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(ret), "-1 ")
+	if !ok {
+		return "", false
+	}
+	errno, _, _ := strings.Cut(rest, " ")
+	return errno, errno != ""
+}
+
+// seccompProfileJSON is the Docker seccomp profile req runs under: the
+// default one, the network one with a network, and the trace one, which
+// allows ptrace, only with DebugTrace.
+func seccompProfileJSON(req ExecutionRequest) ([]byte, error) {
+	switch {
+	case req.DebugTrace:
+		return seccomp.DockerTraceProfileJSON(req.NetworkEnabled)
+	case req.NetworkEnabled:
+		return seccomp.DockerNetworkProfileJSON()
+	default:
+		return seccomp.DockerProfileJSON()
+	}
+}
+
+// validateDebugTrace refuses a debug_trace the backend can't run: never
+// for claude, and only with sandbox.debug_trace.strace.
+func validateDebugTrace(req ExecutionRequest, strace string) error {
+	switch {
+	case !req.DebugTrace:
+		return nil
+	case req.Language == "claude":
+		return fmt.Errorf("%w: debug_trace is not supported for claude", ErrInvalidRequest)
+	case strace == "":
+		return fmt.Errorf("%w: debug traces need sandbox.debug_trace.strace", ErrInvalidRequest)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+func TestTraceCommand(t *testing.T) {
+	got := traceCommand([]string{"python3", "-u", "/workspace/code.py"}, 1024)
+	want := []string{debugTraceStrace, "-f", "-qq", "-s", "256", "-e", "trace=%file,%network",
+		"-o", "|head -c 1025 >" + debugTracePath + "; cat >/dev/null", "--", "python3", "-u", "/workspace/code.py"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("traceCommand = %q\nwant %q", got, want)
+	}
+}
+
+func TestBuildDockerArgs_DebugTrace(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.debugTrace = config.DebugTraceConfig{Strace: "/opt/strace/strace", MaxBytes: 4096}
+	py, _ := d.runtimes.Get("python")
+
+	args := d.buildDockerArgs("exec-t", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-t", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1", DebugTrace: true},
+	)
+	if !argsContainPair(args, "-v", "/opt/strace/strace:"+debugTraceStrace+":ro") {
+		t.Errorf("strace not mounted read-only in %v", args)
+	}
+	if !argsContainPair(args, "-v", filepath.Join("/tmp/sandbox-exec-t", debugTraceFile)+":"+debugTracePath+":rw") {
+		t.Errorf("trace file not mounted in %v", args)
+	}
+	want := append([]string{py.Image()}, traceCommand([]string{"python3", "-u", "-B", "/workspace/code.py"}, 4096)...)
+	if got := args[max(len(args)-len(want), 0):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %q\nwant %q", got, want)
+	}
+
+	args = d.buildDockerArgs("exec-u", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-u", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1"},
+	)
+	if argsContain(args, debugTraceStrace) || argsContainPrefix(args, "/opt/strace/") {
+		t.Errorf("untraced execution runs strace: %v", args)
+	}
+}
+
+func TestSeccompProfileJSON(t *testing.T) {
+	ptrace := func(req ExecutionRequest) (action string, socket bool) {
+		t.Helper()
+		data, err := seccompProfileJSON(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var profile struct {
+			Syscalls []struct {
+				Names  []string `json:"names"`
+				Action string   `json:"action"`
+			} `json:"syscalls"`
+		}
+		if err := json.Unmarshal(data, &profile); err != nil {
+			t.Fatal(err)
+		}
+		for _, rule := range profile.Syscalls {
+			for _, name := range rule.Names {
+				switch name {
+				case "ptrace":
+					action = rule.Action
+				case "socket":
+					socket = true
+				}
+			}
+		}
+		return action, socket
+	}
+	tests := []struct {
+		name       string
+		req        ExecutionRequest
+		wantPtrace string
+		wantSocket bool
+	}{
+		{"default", ExecutionRequest{}, "SCMP_ACT_TRAP", false},
+		{"network", ExecutionRequest{NetworkEnabled: true}, "SCMP_ACT_TRAP", true},
+		{"trace", ExecutionRequest{DebugTrace: true}, "SCMP_ACT_ALLOW", false},
+		{"trace with network", ExecutionRequest{DebugTrace: true, NetworkEnabled: true}, "SCMP_ACT_ALLOW", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if action, socket := ptrace(tt.req); action != tt.wantPtrace || socket != tt.wantSocket {
+				t.Errorf("ptrace %s, socket %v; want %s, %v", action, socket, tt.wantPtrace, tt.wantSocket)
+			}
+		})
+	}
+}
+
+func TestSummarizeTrace(t *testing.T) {
+	data, err := os.ReadFile("testdata/strace_denied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarizeTrace(string(data))
+	want := TraceSummary{
+		Calls:  12,
+		Failed: 8,
+		Failures: []TraceFailure{
+			{Syscall: "openat", Errno: "EACCES", Count: 2, Path: "/etc/app.conf"},
+			{Syscall: "access", Errno: "ENOENT", Count: 1, Path: "/etc/ld.so.preload"},
+			{Syscall: "mkdir", Errno: "EROFS", Count: 1, Path: "/workspace/out"},
+			{Syscall: "newfstatat", Errno: "ENOENT", Count: 1, Path: "/home/sandbox/.config"},
+			{Syscall: "openat", Errno: "EROFS", Count: 1, Path: "/workspace/out/result.csv"},
+			{Syscall: "socket", Errno: "EAFNOSUPPORT", Count: 1},
+			{Syscall: "unlink", Errno: "ENOENT", Count: 1, Path: "/tmp/lock"},
+		},
+		DeniedPaths: []string{"/etc/app.conf", "/workspace/out", "/workspace/out/result.csv"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeTrace =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := summarizeTrace(""); !reflect.DeepEqual(got, TraceSummary{}) {
+		t.Errorf("empty trace: %+v", got)
+	}
+}
+
+func TestSummarizeTrace_Caps(t *testing.T) {
+	var b strings.Builder
+	for i := range 50 {
+		b.WriteString("7 openat(AT_FDCWD, \"/denied/" + strings.Repeat("x", i+1) + "\", O_RDONLY) = -1 EACCES (Permission denied)\n")
+		b.WriteString("7 syscall_" + strings.Repeat("y", i+1) + "(\"/a\") = -1 ENOENT (No such file or directory)\n")
+	}
+	s := summarizeTrace(b.String())
+	if s.Calls != 100 || s.Failed != 100 {
+		t.Errorf("calls %d, failed %d; want 100, 100", s.Calls, s.Failed)
+	}
+	if len(s.Failures) != maxTraceFailures || s.Failures[0].Count != 50 {
+		t.Errorf("failures %d, first %+v", len(s.Failures), s.Failures[0])
+	}
+	if len(s.DeniedPaths) != maxTraceDeniedPaths || s.DeniedPaths[0] != "/denied/x" {
+		t.Errorf("denied paths %d, first %q", len(s.DeniedPaths), s.DeniedPaths[0])
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), debugTraceFile)
+	line := "7 openat(AT_FDCWD, \"/etc/app.conf\", O_RDONLY) = -1 EACCES (Permission denied)\n"
+	if err := os.WriteFile(path, []byte(line+line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	full := readTrace(path, int64(2*len(line)))
+	if full.Truncated || full.Trace != line+line || full.Summary.Failed != 2 {
+		t.Errorf("full trace: %+v", full)
+	}
+	cut := readTrace(path, int64(len(line)+10))
+	if !cut.Truncated || len(cut.Trace) != len(line)+10 || cut.Summary.Calls != 1 {
+		t.Errorf("cut trace: truncated %v, %d bytes, %d calls", cut.Truncated, len(cut.Trace), cut.Summary.Calls)
+	}
+	if readTrace(filepath.Join(t.TempDir(), "missing"), 10) != nil {
+		t.Error("missing trace read as one")
+	}
+}
+
+func TestValidateDebugTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ExecutionRequest
+		strace  string
+		wantErr bool
+	}{
+		{"not asked", ExecutionRequest{Language: "python"}, "", false},
+		{"python", ExecutionRequest{Language: "python", DebugTrace: true}, "/opt/strace", false},
+		{"claude", ExecutionRequest{Language: "claude", DebugTrace: true}, "/opt/strace", true},
+		{"no strace", ExecutionRequest{Language: "python", DebugTrace: true}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDebugTrace(tt.req, tt.strace)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidRequest)) {
+				t.Errorf("validateDebugTrace = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
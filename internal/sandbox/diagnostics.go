@@ -0,0 +1,113 @@
+package sandbox
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// diagnosticsTimeout bounds each command or call Diagnostics makes.
+const diagnosticsTimeout = 10 * time.Second
+
+// Diagnoser is implemented by backends that can describe their container
+// runtime for a support bundle.
+type Diagnoser interface {
+	Diagnostics(ctx context.Context) Diagnostics
+}
+
+// Diagnostics describes a backend's container runtime. Whatever could not be
+// found out is listed in Errors; the rest is still filled in.
+type Diagnostics struct {
+	Backend string        `json:"backend"`
+	Version string        `json:"version,omitempty"` // docker version or the containerd version and revision
+	Info    string        `json:"info,omitempty"`    // docker info
+	Images  []ImageDigest `json:"images,omitempty"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// ImageDigest is the image a language runs in and what it resolves to
+// locally. Digest is empty, with Error set, for an image that isn't pulled.
+type ImageDigest struct {
+	Language string `json:"language"`
+	Image    string `json:"image"`
+	Digest   string `json:"digest,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Diagnostics runs docker version and docker info and looks up each runtime
+// image's ID and repo digests.
+func (d *DockerRunner) Diagnostics(ctx context.Context) Diagnostics {
+	return dockerDiagnostics(ctx, d.dockerOutput, d.runtimes)
+}
+
+func dockerDiagnostics(ctx context.Context, docker func(ctx context.Context, args ...string) ([]byte, error), runtimes *runtime.Registry) Diagnostics {
+	diag := Diagnostics{Backend: "docker"}
+	run := func(args ...string) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+		defer cancel()
+		out, err := docker(ctx, args...)
+		return strings.TrimSpace(string(out)), err
+	}
+
+	var err error
+	if diag.Version, err = run("version"); err != nil {
+		diag.Errors = append(diag.Errors, "docker version: "+err.Error())
+	}
+	if diag.Info, err = run("info"); err != nil {
+		diag.Errors = append(diag.Errors, "docker info: "+err.Error())
+	}
+	diag.Images = imageDigests(runtimes, func(image string) (string, error) {
+		return run("image", "inspect", "--format", "{{.Id}} {{join .RepoDigests \",\"}}", image)
+	})
+	return diag
+}
+
+// Diagnostics reports the containerd version and each runtime image's
+// target digest in the configured namespace.
+func (r *Runner) Diagnostics(ctx context.Context) Diagnostics {
+	diag := Diagnostics{Backend: "containerd"}
+	ctx, cancel := context.WithTimeout(r.client.WithNamespace(ctx), diagnosticsTimeout)
+	defer cancel()
+
+	if v, err := r.client.Raw().Version(ctx); err != nil {
+		diag.Errors = append(diag.Errors, "containerd version: "+err.Error())
+	} else {
+		diag.Version = strings.TrimSpace(v.Version + " " + v.Revision)
+	}
+	diag.Images = imageDigests(r.runtimes, func(image string) (string, error) {
+		img, err := r.client.Raw().GetImage(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		return img.Target().Digest.String(), nil
+	})
+	return diag
+}
+
+// Diagnostics reports the wrapped backend's.
+func (c *ChaosBackend) Diagnostics(ctx context.Context) Diagnostics {
+	if d, ok := c.inner.(Diagnoser); ok {
+		return d.Diagnostics(ctx)
+	}
+	return Diagnostics{Backend: c.inner.Name()}
+}
+
+// imageDigests resolves the image of every language with lookup, in
+// language order.
+func imageDigests(runtimes *runtime.Registry, lookup func(image string) (string, error)) []ImageDigest {
+	languages := runtimes.Languages()
+	images := make([]ImageDigest, 0, len(languages))
+	for _, lang := range languages {
+		rt, _ := runtimes.Get(lang)
+		entry := ImageDigest{Language: lang, Image: rt.Image()}
+		if digest, err := lookup(entry.Image); err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Digest = digest
+		}
+		images = append(images, entry)
+	}
+	return images
+}
@@ -0,0 +1,59 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func TestDockerDiagnostics(t *testing.T) {
+	runtimes := runtime.NewRegistry()
+	if err := runtimes.OverrideImages(map[string]string{"bun": "registry.local/bun:unpulled"}); err != nil {
+		t.Fatal(err)
+	}
+	docker := func(_ context.Context, args ...string) ([]byte, error) {
+		switch {
+		case args[0] == "version":
+			return []byte("Client: 27.1.1\nServer: 27.1.1\n"), nil
+		case args[0] == "info":
+			return nil, errors.New("exit status 1: permission denied")
+		case args[len(args)-1] == "registry.local/bun:unpulled":
+			return nil, errors.New("exit status 1: No such image")
+		}
+		return []byte("sha256:abc " + args[len(args)-1] + "@sha256:def\n"), nil
+	}
+
+	diag := dockerDiagnostics(context.Background(), docker, runtimes)
+	if diag.Backend != "docker" || diag.Version != "Client: 27.1.1\nServer: 27.1.1" {
+		t.Errorf("diag = %+v", diag)
+	}
+	if diag.Info != "" || len(diag.Errors) != 1 || !strings.HasPrefix(diag.Errors[0], "docker info: ") {
+		t.Errorf("info = %q, errors = %v, want the info failure listed", diag.Info, diag.Errors)
+	}
+
+	if len(diag.Images) != len(runtimes.Languages()) {
+		t.Fatalf("images = %+v, want one per language", diag.Images)
+	}
+	for _, img := range diag.Images {
+		switch img.Language {
+		case "bun":
+			if img.Digest != "" || !strings.Contains(img.Error, "No such image") {
+				t.Errorf("unpulled image = %+v", img)
+			}
+		case "python":
+			if want := "sha256:abc " + img.Image + "@sha256:def"; img.Digest != want {
+				t.Errorf("python digest = %q, want %q", img.Digest, want)
+			}
+		}
+	}
+}
+
+func TestChaosBackendDiagnostics(t *testing.T) {
+	diag := NewChaosBackend(&passthroughBackend{}).Diagnostics(context.Background())
+	if diag.Backend != "passthrough" {
+		t.Errorf("backend = %q, want the wrapped backend's name", diag.Backend)
+	}
+}
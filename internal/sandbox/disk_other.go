@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package sandbox
+
+import "errors"
+
+// statFS isn't implemented here, so disk pressure isn't watched.
+func statFS(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("free space sampling is not supported on this platform")
+}
@@ -0,0 +1,470 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// ErrImagePullSuspended is returned for an execution whose image would have
+// to be pulled while the runtime's disk is short of space.
+var ErrImagePullSuspended = errors.New("image pulls suspended: runtime disk is low on space")
+
+// DiskStage is how hard the server is backing off for lack of space on the
+// runtime's data root. Each stage keeps the responses of the ones before it.
+type DiskStage int
+
+const (
+	DiskOK             DiskStage = iota
+	DiskWarn                     // reported in /health
+	DiskPullsSuspended           // executions whose image isn't present are refused
+	DiskGC                       // unused images are being removed
+)
+
+func (s DiskStage) String() string {
+	switch s {
+	case DiskWarn:
+		return "warn"
+	case DiskPullsSuspended:
+		return "pulls_suspended"
+	case DiskGC:
+		return "gc"
+	default:
+		return "ok"
+	}
+}
+
+// DiskObserver receives disk-pressure metrics from a backend.
+type DiskObserver interface {
+	DiskSampled(path string, freeBytes uint64, stage int)
+	ImageRemoved(sizeBytes int64)
+}
+
+// DiskReporter is implemented by backends that watch their data root, for
+// GET /health. ok is false when no monitor is running.
+type DiskReporter interface {
+	DiskPressure() (status DiskStatus, ok bool)
+}
+
+// DiskStatus is the last free-space sample of the runtime's data root.
+type DiskStatus struct {
+	Path        string    `json:"path"`
+	FreeBytes   uint64    `json:"free_bytes"`
+	TotalBytes  uint64    `json:"total_bytes"`
+	FreePercent float64   `json:"free_percent"`
+	Stage       string    `json:"stage"` // ok, warn, pulls_suspended or gc
+	CheckedAt   time.Time `json:"checked_at"`
+	Error       string    `json:"error,omitempty"` // why the last sample failed; the stage is the one before it
+}
+
+// storedImage is an image in the runtime's store.
+type storedImage struct {
+	ID   string   // docker image ID, or containerd target digest
+	Refs []string // the references naming it; none for a dangling image
+	Size int64
+}
+
+// imageStore is the runtime's image store, as the disk monitor needs it.
+type imageStore interface {
+	list(ctx context.Context) ([]storedImage, error)
+	remove(ctx context.Context, img storedImage) error
+	present(ctx context.Context, ref string) bool
+}
+
+// diskMonitor samples free space on the runtime's data root and steps
+// through the DiskStages as it falls: see config.DiskPressureConfig. A
+// stage is entered as soon as a sample calls for it, but left only once
+// free space is back above the target, so a host hovering at a threshold
+// doesn't flap. A nil monitor admits every image.
+type diskMonitor struct {
+	cfg      config.DiskPressureConfig
+	path     string
+	statfs   func(path string) (free, total uint64, err error)
+	store    imageStore
+	pinned   func() []string // the runtimes' images, never removed
+	observer DiskObserver
+	now      func() time.Time
+
+	mu      sync.Mutex
+	stage   DiskStage
+	status  DiskStatus
+	lastUse map[string]time.Time // by image ID or reference: when an execution last started in it
+	inUse   map[string]int       // by image ID or reference: executions running in it
+}
+
+func newDiskMonitor(cfg config.DiskPressureConfig, path string, store imageStore, pinned func() []string) *diskMonitor {
+	return &diskMonitor{
+		cfg:     cfg,
+		path:    path,
+		statfs:  statFS,
+		store:   store,
+		pinned:  pinned,
+		now:     time.Now,
+		status:  DiskStatus{Path: path, Stage: DiskOK.String()},
+		lastUse: make(map[string]time.Time),
+		inUse:   make(map[string]int),
+	}
+}
+
+// admit refuses an execution in ref with ErrImagePullSuspended if pulls are
+// suspended and the image isn't already in the store.
+func (m *diskMonitor) admit(ctx context.Context, ref string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	stage, status := m.stage, m.status
+	m.mu.Unlock()
+	if stage < DiskPullsSuspended || m.store.present(ctx, ref) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is not present and %s has %.1f%% free", ErrImagePullSuspended, ref, status.Path, status.FreePercent)
+}
+
+// use marks the image, by whichever of its ID and references the caller
+// knows, as in use by an execution until the returned func is called. GC
+// skips it meanwhile, and ranks it by when this was last called.
+func (m *diskMonitor) use(keys ...string) (release func()) {
+	if m == nil {
+		return func() {}
+	}
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		if k != "" {
+			m.inUse[k]++
+			m.lastUse[k] = now
+		}
+	}
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, k := range keys {
+			if k == "" {
+				continue
+			}
+			if m.inUse[k]--; m.inUse[k] <= 0 {
+				delete(m.inUse, k)
+			}
+		}
+	}
+}
+
+// Status returns the last sample.
+func (m *diskMonitor) Status() DiskStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// check samples free space and, at DiskGC, removes images until it is back
+// above the target or nothing more may go.
+func (m *diskMonitor) check(ctx context.Context) {
+	if m.sample() == DiskGC {
+		m.collect(ctx)
+		m.sample()
+	}
+}
+
+// sample reads free space and moves to the stage it calls for.
+func (m *diskMonitor) sample() DiskStage {
+	free, total, err := m.statfs(m.path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil || total == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s reports a size of 0", m.path)
+		}
+		log.Warn().Err(err).Str("path", m.path).Msg("disk pressure sample failed")
+		m.status.Error = err.Error()
+		return m.stage
+	}
+
+	pct := 100 * float64(free) / float64(total)
+	next := m.stageFor(pct)
+	if next < m.stage && pct < m.cfg.TargetFreePercent {
+		next = m.stage
+	}
+	if next != m.stage {
+		ev := log.Warn()
+		if next < m.stage {
+			ev = log.Info()
+		}
+		ev.Str("path", m.path).Float64("free_percent", pct).Str("from", m.stage.String()).Str("to", next.String()).Msg("disk pressure stage changed")
+	}
+	m.stage = next
+	m.status = DiskStatus{Path: m.path, FreeBytes: free, TotalBytes: total, FreePercent: pct, Stage: next.String(), CheckedAt: m.now()}
+	if m.observer != nil {
+		m.observer.DiskSampled(m.path, free, int(next))
+	}
+	return next
+}
+
+func (m *diskMonitor) stageFor(freePercent float64) DiskStage {
+	switch {
+	case freePercent < m.cfg.GCFreePercent:
+		return DiskGC
+	case freePercent < m.cfg.SuspendPullsFreePercent:
+		return DiskPullsSuspended
+	case freePercent < m.cfg.WarnFreePercent:
+		return DiskWarn
+	default:
+		return DiskOK
+	}
+}
+
+// collect removes candidates, least recently used first, checking free
+// space after each until it reaches the target. The runtime refuses to
+// remove an image a container still uses; that one is skipped.
+func (m *diskMonitor) collect(ctx context.Context) {
+	images, err := m.store.list(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("image GC: listing images failed")
+		return
+	}
+	removed := 0
+	for _, img := range m.candidates(images) {
+		if free, total, err := m.statfs(m.path); err == nil && total > 0 && 100*float64(free)/float64(total) >= m.cfg.TargetFreePercent {
+			break
+		}
+		if m.busy(img) { // an execution started in it since the list
+			continue
+		}
+		if err := m.store.remove(ctx, img); err != nil {
+			log.Warn().Err(err).Str("image", img.ID).Strs("refs", img.Refs).Msg("image GC: removal failed, skipping")
+			continue
+		}
+		removed++
+		log.Info().Str("image", img.ID).Strs("refs", img.Refs).Int64("size_bytes", img.Size).Msg("image GC: removed image")
+		if m.observer != nil {
+			m.observer.ImageRemoved(img.Size)
+		}
+	}
+	log.Info().Int("removed", removed).Str("path", m.path).Msg("image GC finished")
+}
+
+// candidates returns the images GC may remove, in the order to remove them:
+// least recently used first, never used before anything used, larger first
+// among equals. Images a runtime uses, listed in gc.keep, in use by a
+// running execution or used within gc.min_idle are left out, as are tagged
+// images unless gc.candidates is unregistered.
+func (m *diskMonitor) candidates(images []storedImage) []storedImage {
+	protected := make(map[string]bool)
+	for _, ref := range append(m.pinned(), m.cfg.GC.Keep...) {
+		protected[normalizeRef(ref)] = true
+	}
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type candidate struct {
+		storedImage
+		lastUse time.Time
+	}
+	var out []candidate
+	for _, img := range images {
+		if len(img.Refs) > 0 && m.cfg.GC.Candidates != "unregistered" {
+			continue
+		}
+		if slices.ContainsFunc(img.Refs, func(r string) bool { return protected[normalizeRef(r)] }) || m.busyLocked(img) {
+			continue
+		}
+		var last time.Time
+		for _, k := range append([]string{img.ID}, img.Refs...) {
+			if t := m.lastUse[k]; t.After(last) {
+				last = t
+			}
+		}
+		if !last.IsZero() && now.Sub(last) < m.cfg.GC.MinIdle {
+			continue
+		}
+		out = append(out, candidate{img, last})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if !a.lastUse.Equal(b.lastUse) {
+			return a.lastUse.Before(b.lastUse)
+		}
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.ID < b.ID
+	})
+	images = make([]storedImage, len(out))
+	for i, c := range out {
+		images[i] = c.storedImage
+	}
+	return images
+}
+
+// busy reports whether an execution is running in img.
+func (m *diskMonitor) busy(img storedImage) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.busyLocked(img)
+}
+
+func (m *diskMonitor) busyLocked(img storedImage) bool {
+	return m.inUse[img.ID] > 0 || slices.ContainsFunc(img.Refs, func(r string) bool { return m.inUse[r] > 0 })
+}
+
+// normalizeRef drops the parts of an image reference that Docker Hub
+// images are written with or without, so python:3.12-slim and
+// docker.io/library/python:3.12-slim compare equal.
+func normalizeRef(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	return strings.TrimPrefix(ref, "library/")
+}
+
+// monitorLoop checks free space every check_interval until ctx is cancelled.
+func (m *diskMonitor) monitorLoop(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		m.check(checkCtx)
+		cancel()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DiskPressure reports the last sample of the Docker data root.
+func (d *DockerRunner) DiskPressure() (DiskStatus, bool) {
+	if d.disk == nil {
+		return DiskStatus{}, false
+	}
+	return d.disk.Status(), true
+}
+
+// DiskPressure reports the last sample of containerd's root.
+func (r *Runner) DiskPressure() (DiskStatus, bool) {
+	if r.disk == nil {
+		return DiskStatus{}, false
+	}
+	return r.disk.Status(), true
+}
+
+// startDiskMonitor starts watching path, or returns nil, having logged why,
+// when path can't be sampled.
+func startDiskMonitor(cfg config.DiskPressureConfig, path string, store imageStore, pinned func() []string, obs DiskObserver) (*diskMonitor, context.CancelFunc) {
+	if path == "" {
+		log.Warn().Msg("disk pressure: runtime data root unknown, set sandbox.disk_pressure.data_root; not watching")
+		return nil, nil
+	}
+	if _, _, err := statFS(path); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("disk pressure: can't sample the runtime data root; not watching")
+		return nil, nil
+	}
+	m := newDiskMonitor(cfg, path, store, pinned)
+	m.observer = obs
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.monitorLoop(ctx)
+	log.Info().Str("path", path).Msg("watching runtime data root for disk pressure")
+	return m, cancel
+}
+
+// dockerImages is the Docker daemon's image store, through the CLI.
+type dockerImages struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+func (d dockerImages) list(ctx context.Context) ([]storedImage, error) {
+	out, err := d.docker(ctx, "image", "ls", "--no-trunc", "--format", "{{.ID}}\t{{.Repository}}:{{.Tag}}\t{{.Size}}")
+	if err != nil {
+		return nil, err
+	}
+	return parseImageList(out)
+}
+
+// remove removes img by its references, which untags and then deletes it,
+// or by ID if it is dangling. Without --force Docker refuses an image a
+// container uses.
+func (d dockerImages) remove(ctx context.Context, img storedImage) error {
+	targets := img.Refs
+	if len(targets) == 0 {
+		targets = []string{img.ID}
+	}
+	_, err := d.docker(ctx, append([]string{"image", "rm"}, targets...)...)
+	return err
+}
+
+func (d dockerImages) present(ctx context.Context, ref string) bool {
+	_, err := d.docker(ctx, "image", "inspect", "--format", "{{.Id}}", ref)
+	return err == nil
+}
+
+// parseImageList reads `docker image ls` lines of "id<TAB>repo:tag<TAB>size",
+// one per tag, into one storedImage per ID.
+func parseImageList(out []byte) ([]storedImage, error) {
+	var images []storedImage
+	byID := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected image line %q", line)
+		}
+		size, err := parseDockerSize(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("image %s: %w", fields[0], err)
+		}
+		i, ok := byID[fields[0]]
+		if !ok {
+			i = len(images)
+			byID[fields[0]] = i
+			images = append(images, storedImage{ID: fields[0], Size: size})
+		}
+		if ref := fields[1]; !strings.Contains(ref, "<none>") {
+			images[i].Refs = append(images[i].Refs, ref)
+		}
+	}
+	return images, scanner.Err()
+}
+
+// dockerDataRoot asks the daemon where it keeps its images.
+func dockerDataRoot(docker func(ctx context.Context, args ...string) ([]byte, error)) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := docker(ctx, "info", "--format", "{{.DockerRootDir}}")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// containerdDataRoot reads root from containerd's config file, which
+// defaults to /var/lib/containerd.
+func containerdDataRoot(configPath string) string {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "/var/lib/containerd"
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && strings.TrimSpace(key) == "root" {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return "/var/lib/containerd"
+}
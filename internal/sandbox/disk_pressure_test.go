@@ -0,0 +1,219 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// fakeImages is an image store whose removals free their size on a fake
+// filesystem.
+type fakeImages struct {
+	images  []storedImage
+	pulled  map[string]bool
+	refuse  map[string]bool // IDs the runtime won't remove
+	removed []string
+	disk    *fakeDisk
+}
+
+func (f *fakeImages) list(context.Context) ([]storedImage, error) { return f.images, nil }
+
+func (f *fakeImages) remove(_ context.Context, img storedImage) error {
+	if f.refuse[img.ID] {
+		return errors.New("image is being used by a stopped container")
+	}
+	f.removed = append(f.removed, img.ID)
+	f.disk.free += uint64(img.Size)
+	return nil
+}
+
+func (f *fakeImages) present(_ context.Context, ref string) bool { return f.pulled[ref] }
+
+type fakeDisk struct {
+	free, total uint64
+	err         error
+}
+
+func (d *fakeDisk) statfs(string) (uint64, uint64, error) { return d.free, d.total, d.err }
+
+func testDiskMonitor(disk *fakeDisk, store *fakeImages) *diskMonitor {
+	cfg := config.DefaultConfig().Sandbox.DiskPressure // warn 15, suspend 10, gc 5, target 20
+	m := newDiskMonitor(cfg, "/var/lib/docker", store, func() []string { return []string{"python:3.12-slim", "sandbox-claude:latest"} })
+	m.statfs = disk.statfs
+	return m
+}
+
+func TestDiskMonitor_Stages(t *testing.T) {
+	disk := &fakeDisk{total: 1000}
+	m := testDiskMonitor(disk, &fakeImages{disk: disk})
+
+	// Free space per sample, as a percentage of 1000 bytes, and the stage
+	// it leaves the monitor in. Stages are entered at once but only left
+	// above the 20% target.
+	steps := []struct {
+		free uint64
+		want DiskStage
+	}{
+		{500, DiskOK},
+		{150, DiskOK}, // at the warn threshold, not below it
+		{149, DiskWarn},
+		{190, DiskWarn}, // above warn, below target
+		{99, DiskPullsSuspended},
+		{140, DiskPullsSuspended},
+		{200, DiskOK},
+		{60, DiskPullsSuspended}, // straight past warn
+		{120, DiskPullsSuspended},
+		{210, DiskOK},
+	}
+	for i, s := range steps {
+		disk.free = s.free
+		if got := m.sample(); got != s.want {
+			t.Fatalf("step %d: %d bytes free: stage %s, want %s", i, s.free, got, s.want)
+		}
+		if st := m.Status(); st.Stage != s.want.String() || st.FreeBytes != s.free || st.FreePercent != float64(s.free)/10 {
+			t.Fatalf("step %d: status %+v", i, st)
+		}
+	}
+
+	// A failed sample keeps the stage and reports why.
+	disk.free = 10
+	m.sample()
+	disk.err = errors.New("no such file or directory")
+	if got := m.sample(); got != DiskGC || m.Status().Error == "" || m.Status().FreeBytes != 10 {
+		t.Errorf("failed sample: stage %s, status %+v", got, m.Status())
+	}
+}
+
+func TestDiskMonitor_AdmitAndGC(t *testing.T) {
+	disk := &fakeDisk{free: 40, total: 1000}
+	store := &fakeImages{
+		disk: disk,
+		images: []storedImage{
+			{ID: "sha256:old", Size: 100},
+			{ID: "sha256:older", Size: 30},
+			{ID: "sha256:python", Refs: []string{"docker.io/library/python:3.12-slim"}, Size: 500},
+		},
+		pulled: map[string]bool{"python:3.12-slim": true},
+		refuse: map[string]bool{"sha256:older": true},
+	}
+	m := testDiskMonitor(disk, store)
+	ctx := context.Background()
+
+	m.sample()
+	if err := m.admit(ctx, "python:3.12-slim"); err != nil {
+		t.Errorf("present image refused: %v", err)
+	}
+	if err := m.admit(ctx, "node:22-slim"); !errors.Is(err, ErrImagePullSuspended) {
+		t.Errorf("missing image admitted under pressure: %v", err)
+	}
+
+	// GC skips the runtime's image and the one the runtime won't remove,
+	// and stops once the target is reached.
+	m.check(ctx)
+	if !reflect.DeepEqual(store.removed, []string{"sha256:old"}) {
+		t.Errorf("removed %v", store.removed)
+	}
+	if m.stage != DiskGC || m.admit(ctx, "node:22-slim") == nil {
+		t.Errorf("14%% free after GC: stage %s, want it kept until the target", m.stage)
+	}
+
+	disk.free = 300
+	m.check(ctx)
+	if m.stage != DiskOK || m.admit(ctx, "node:22-slim") != nil {
+		t.Errorf("30%% free: stage %s", m.stage)
+	}
+	var nilMonitor *diskMonitor
+	if nilMonitor.admit(ctx, "node:22-slim") != nil {
+		t.Error("a nil monitor refused an image")
+	}
+	nilMonitor.use("node:22-slim")()
+}
+
+func TestDiskMonitor_Candidates(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	images := []storedImage{
+		{ID: "sha256:a", Size: 10},                                          // never used
+		{ID: "sha256:b", Size: 50},                                          // never used, larger
+		{ID: "sha256:c", Size: 10},                                          // used 3h ago
+		{ID: "sha256:d", Size: 10},                                          // used 10m ago, within min_idle
+		{ID: "sha256:e", Size: 10},                                          // running
+		{ID: "sha256:f", Refs: []string{"custom/tool:1"}, Size: 10},         // tagged, used 2h ago
+		{ID: "sha256:g", Refs: []string{"sandbox-claude:latest"}, Size: 10}, // a runtime's image
+		{ID: "sha256:h", Refs: []string{"kept/base:2"}, Size: 10},           // in gc.keep
+		{ID: "sha256:i", Refs: []string{"custom/old:1"}, Size: 10},          // tagged, used 5h ago by reference
+	}
+	disk := &fakeDisk{}
+	m := testDiskMonitor(disk, &fakeImages{disk: disk})
+	m.cfg.GC.Keep = []string{"kept/base:2"}
+	at := func(ago time.Duration, keys ...string) {
+		m.now = func() time.Time { return now.Add(-ago) }
+		m.use(keys...)()
+	}
+	at(3*time.Hour, "sha256:c")
+	at(10*time.Minute, "sha256:d")
+	at(2*time.Hour, "sha256:f")
+	at(5*time.Hour, "custom/old:1")
+	m.now = func() time.Time { return now }
+	release := m.use("sha256:e")
+	defer release()
+
+	ids := func(images []storedImage) []string {
+		var out []string
+		for _, img := range images {
+			out = append(out, img.ID)
+		}
+		return out
+	}
+	if got, want := ids(m.candidates(images)), []string{"sha256:b", "sha256:a", "sha256:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dangling candidates = %v, want %v", got, want)
+	}
+	m.cfg.GC.Candidates = "unregistered"
+	if got, want := ids(m.candidates(images)), []string{"sha256:b", "sha256:a", "sha256:i", "sha256:c", "sha256:f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unregistered candidates = %v, want %v", got, want)
+	}
+	release()
+	m.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if got, want := ids(m.candidates(images)), []string{"sha256:b", "sha256:a", "sha256:i", "sha256:c", "sha256:f", "sha256:d", "sha256:e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("2h later, candidates = %v, want %v", got, want)
+	}
+}
+
+func TestParseImageList(t *testing.T) {
+	out := "sha256:1\tpython:3.12-slim\t131MB\n" +
+		"sha256:2\tsandbox-claude:latest\t1.2GB\n" +
+		"sha256:2\tsandbox-claude:v3\t1.2GB\n" +
+		"sha256:3\t<none>:<none>\t1.1GB\n"
+	got, err := parseImageList([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []storedImage{
+		{ID: "sha256:1", Refs: []string{"python:3.12-slim"}, Size: 131e6},
+		{ID: "sha256:2", Refs: []string{"sandbox-claude:latest", "sandbox-claude:v3"}, Size: 1.2e9},
+		{ID: "sha256:3", Size: 1.1e9},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseImageList = %+v, want %+v", got, want)
+	}
+	if _, err := parseImageList([]byte("sha256:1 python 1MB\n")); err == nil {
+		t.Error("malformed line accepted")
+	}
+}
+
+func TestContainerdDataRoot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if got := containerdDataRoot(path); got != "/var/lib/containerd" {
+		t.Errorf("no config: %q", got)
+	}
+	os.WriteFile(path, []byte("version = 2\nroot = \"/data/containerd\"\nstate = \"/run/containerd\"\n"), 0o600)
+	if got := containerdDataRoot(path); got != "/data/containerd" {
+		t.Errorf("configured root: %q", got)
+	}
+}
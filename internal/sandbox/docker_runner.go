@@ -3,12 +3,14 @@ package sandbox
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,22 +19,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/runtime"
-	"safe-agent-sandbox/pkg/seccomp"
 )
 
-// envBlocklist contains env var keys that must never be passed into a container.
-var envBlocklist = map[string]bool{
-	"LD_PRELOAD":      true,
-	"LD_LIBRARY_PATH": true,
-	"HTTP_PROXY":      true,
-	"HTTPS_PROXY":     true,
-	"NODE_OPTIONS":    true,
-	"PYTHONPATH":      true,
-	"PATH":            true,
-	"HOME":            true,
-	"USER":            true,
-}
+// dockerKillWait is how long docker run's output pipes are waited on once
+// its CLI has been killed, before it returns anyway.
+const dockerKillWait = 2 * time.Second
 
 // sensitivePathPrefixes are directories that must never be mounted as WorkDir.
 var sensitivePathPrefixes = []string{"/etc", "/var", "/root"}
@@ -43,78 +36,204 @@ var sensitiveHomeDirs = []string{".ssh", ".aws", ".gnupg", ".claude"}
 // DockerRunner is the Docker-based sandbox backend (macOS, or Linux without containerd).
 type DockerRunner struct {
 	runtimes      *runtime.Registry
-	sem           chan struct{}
-	claudeSem     chan struct{} // separate concurrency limit for claude sessions
+	slots         *slotLimiter // per-language concurrency pools
 	active        atomic.Int64
 	wg            sync.WaitGroup
 	mu            sync.Mutex
 	closed        bool
-	dockerHost    string   // resolved DOCKER_HOST (e.g. from Docker context)
-	allowedRoots  []string // WorkDir must be under one of these
-	proxyPort     int      // >0 means auth proxy is active; skip token-via-file
-	proxySecret   string   // shared secret containers present to the auth proxy
+	running       map[string]bool        // container names of executions in flight; guarded by mu
+	swept         chan struct{}          // closed once the startup orphan sweep is done; nil without one
+	instance      string                 // this server's sandbox.instance label
+	dockerHost    string                 // resolved DOCKER_HOST (e.g. from Docker context)
+	allowedRoots  []string               // WorkDir must be under one of these
+	workspaceRoot string                 // Workspace must be under this; empty disables workspaces
+	procMounts    bool                   // bind work_dir and workspace by their pinned /proc/<pid>/fd links
+	sharedMounts  map[string]SharedMount // sandbox.shared_mounts by name
+	proxyPort     int                    // >0 means auth proxy is active; skip token-via-file
+	proxySecret   string                 // shared secret containers present to the auth proxy
+	proxyHost     string                 // what host.docker.internal maps to; "" is host-gateway
+	proxyCA       string                 // host path of the auth proxy's CA when it serves TLS; "" is plain HTTP
+	caches        *claudeCaches          // sandbox.claude_caches; nil when none are configured
+	claude        *claudePolicy          // security.claude; nil applies no ceilings or defaults
+	workdirs      *workdirLocks          // work_dirs mounted read-write by running executions
+	workdirSize   *workdirSizer          // sandbox.workdir_size; nil mounts claude work_dirs unmeasured
+	workdirGit    string                 // security.claude_workdir_git: ignore, warn or require_clean; "" is ignore
+	defaultEnv    *defaultEnv            // sandbox.default_env; nil sets none
+	warmups       *warmupProbes          // what each runtime image supports; see runtime.Warmable
+	digests       *digestCache           // the ID each runtime image resolves to, for ExecutionResult.ImageDigest
+	identities    *identityProbes        // whether each runtime image has the sandbox user, and its zones
+	genPasswd     bool                   // sandbox.generate_passwd; mount a passwd with the sandbox user into images without one
+	contract      *claudeContract        // sandbox.verify_claude_contract; nil runs claude images unchecked
+	limitProbe    *limitProber           // sandbox.limit_enforcement; nil when off
+	limitMode     string                 // sandbox.limit_enforcement: strict or permissive
+	verifySeccomp bool                   // sandbox.verify_seccomp; start non-claude code through seccompWrapper
+	verifyCode    bool                   // sandbox.verify_code_integrity; start non-claude code through codeIntegrityWrapper
+	verifyPaths   bool                   // sandbox.verify_masked_paths; check them with a pathProbe
+	maxUlimits    Ulimits                // sandbox.max_ulimits; ceilings on the ulimits a request sets
+	seccompObs    SeccompObserver
+	seccompHash   time.Duration // sandbox.seccomp_hash_interval
+	seccompMu     sync.Mutex
+	seccomp       *seccompProfiles // written at backend startup, or by the first execution
+	imagePullObs  ImagePullObserver
+	cpuAbuse      config.CPUAbuseConfig // sandbox.cpu_abuse; off without a local daemon
+	cpuAbuseObs   CPUAbuseObserver
+	binary        config.BinaryConfig // sandbox.binary; a zero MaxBytes takes DefaultBinaryMaxBytes
+	maxStdin      int64               // sandbox.max_stdin_bytes; 0 refuses stdin
+	disk          *diskMonitor        // sandbox.disk_pressure; nil when it isn't watched
+	setupTimeout  time.Duration       // sandbox.setup_timeout; 0 takes DefaultSetupTimeout
+	cleanupGrace  time.Duration       // sandbox.cleanup_grace; 0 takes DefaultCleanupGrace
+	egress        *egressAuditor      // sandbox.egress_audit; nil when off
 	cancelCleanup context.CancelFunc
+	cancelCaches  context.CancelFunc
+	cancelDisk    context.CancelFunc
+	debugTrace    config.DebugTraceConfig // sandbox.debug_trace; an empty Strace refuses debug_trace
+	compiled      *compileCache           // sandbox.compile_cache; nil caches nothing
 }
 
 func NewDockerRunner(maxConcurrent int, allowedRoots []string, proxyPort int, proxySecret string, maxConcurrentClaude int) *DockerRunner {
+	d := newDockerRunner(maxConcurrent, allowedRoots, proxyPort, proxySecret, maxConcurrentClaude)
+	d.startOrphanCleanup(true)
+	return d
+}
+
+// startOrphanCleanup starts orphanCleanupLoop, whose first sweep removes
+// every other server's containers too if sweepAll is set.
+// Executions wait for that first sweep before creating their containers.
+func (d *DockerRunner) startOrphanCleanup(sweepAll bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancelCleanup = cancel
+	d.swept = make(chan struct{})
+	go d.orphanCleanupLoop(ctx, sweepAll)
+}
+
+// awaitStartupSweep waits for the startup orphan sweep, if the runner has
+// one, or for ctx to be done.
+func (d *DockerRunner) awaitStartupSweep(ctx context.Context) error {
+	if d.swept == nil {
+		return nil
+	}
+	select {
+	case <-d.swept:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newDockerRunner(maxConcurrent int, allowedRoots []string, proxyPort int, proxySecret string, maxConcurrentClaude int) *DockerRunner {
 	if maxConcurrent < 1 {
 		maxConcurrent = 100
 	}
 	if maxConcurrentClaude < 1 {
 		maxConcurrentClaude = 5
 	}
+	// Claude sessions get a separate, tighter pool unless sandbox.concurrency splits the cap.
 	d := &DockerRunner{
 		runtimes:     runtime.NewRegistry(),
-		sem:          make(chan struct{}, maxConcurrent),
-		claudeSem:    make(chan struct{}, maxConcurrentClaude),
+		slots:        newSlotLimiter(maxConcurrent, map[string]int{"claude": maxConcurrentClaude, defaultPool: maxConcurrent}),
 		dockerHost:   resolveDockerHost(),
 		allowedRoots: allowedRoots,
 		proxyPort:    proxyPort,
 		proxySecret:  proxySecret,
+		workdirs:     newWorkdirLocks(0),
+		running:      make(map[string]bool),
+		instance:     uuid.NewString(),
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	d.cancelCleanup = cancel
-	go d.orphanCleanupLoop(ctx)
-
+	d.warmups = newWarmupProbes(d.dockerOutput)
+	d.digests = newDigestCache(d.dockerOutput)
+	d.identities = newIdentityProbes(d.dockerOutput)
+	d.contract = newClaudeContract(d.dockerOutput, proxyPort > 0)
+	d.verifySeccomp = true
+	d.verifyCode = true
+	d.seccompHash = DefaultSeccompHashInterval
+	d.maxStdin = DefaultMaxStdinBytes
+	d.maxUlimits = MaxUlimits()
+	d.procMounts = procMountable(d.dockerHost)
 	return d
 }
 
 // orphanCleanupLoop periodically kills orphaned sandbox containers that survived server crashes.
-func (d *DockerRunner) orphanCleanupLoop(ctx context.Context) {
+func (d *DockerRunner) orphanCleanupLoop(ctx context.Context, sweepAll bool) {
 	// Run once on startup
-	d.cleanupOrphans()
+	d.cleanupOrphans(sweepAll)
+	close(d.swept)
 
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			d.cleanupOrphans()
+			d.cleanupOrphans(false)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (d *DockerRunner) cleanupOrphans() {
-	cmd := exec.Command("docker", "ps", "--filter", "name=sandbox-", "-q") // #nosec G204 -- no user input
-	if d.dockerHost != "" {
-		cmd.Env = append(os.Environ(), "DOCKER_HOST="+d.dockerHost)
-	}
-	out, err := cmd.Output()
+const (
+	// instanceLabel carries the ID of the server that started a container,
+	// so servers sharing a Docker daemon only sweep their own.
+	instanceLabel = "sandbox.instance"
+	// orphanGrace is how old a container must be before a periodic sweep
+	// removes it, whatever the registry of running executions says.
+	orphanGrace = 2 * time.Minute
+	// startupSweepTimeout is how long NewBackend waits on the startup sweep
+	// before handing the runner over; executions wait out the rest.
+	startupSweepTimeout = 30 * time.Second
+	// orphanListFormat is the docker ps format orphanContainers reads.
+	orphanListFormat = "{{.ID}}\t{{.Names}}\t{{.CreatedAt}}\t{{.Label \"" + instanceLabel + "\"}}"
+	// dockerCreatedAt is how docker ps prints CreatedAt.
+	dockerCreatedAt = "2006-01-02 15:04:05 -0700 MST"
+)
+
+func (d *DockerRunner) cleanupOrphans(startup bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := d.dockerOutput(ctx, "ps", "-a", "--filter", "name=sandbox-", "--format", orphanListFormat)
 	if err != nil {
+		log.Warn().Err(err).Msg("listing sandbox containers for orphan cleanup failed")
 		return
 	}
-	ids := strings.Fields(strings.TrimSpace(string(out)))
-	for _, id := range ids {
+	// Read after the listing: an execution registers before its container
+	// exists, so every listed container of a live execution is in it.
+	d.mu.Lock()
+	running := maps.Clone(d.running)
+	d.mu.Unlock()
+
+	for _, id := range orphanContainers(out, d.instance, startup, running, time.Now()) {
 		log.Warn().Str("container_id", id).Msg("killing orphaned sandbox container")
-		kill := exec.Command("docker", "rm", "-f", id) // #nosec G204 -- id from docker ps
-		if d.dockerHost != "" {
-			kill.Env = append(os.Environ(), "DOCKER_HOST="+d.dockerHost)
+		_ = d.dockerCommand(ctx, "rm", "-f", id).Run()
+	}
+}
+
+// orphanContainers returns the IDs of the containers in out, docker ps
+// output in orphanListFormat, that a sweep removes. Containers of running
+// executions never are. At startup every other sandbox container is an
+// orphan, whichever server started it, as a crashed server's containers
+// carry its old instance ID. After that a container must carry instance and
+// be older than orphanGrace.
+func orphanContainers(out []byte, instance string, startup bool, running map[string]bool, now time.Time) []string {
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			continue
 		}
-		_ = kill.Run()
+		id, names, created, label := fields[0], strings.Split(fields[1], ","), fields[2], fields[3]
+		// The name filter matches anywhere in the name.
+		if !slices.ContainsFunc(names, func(n string) bool { return strings.HasPrefix(n, "sandbox-") }) ||
+			slices.ContainsFunc(names, func(n string) bool { return running[n] }) {
+			continue
+		}
+		if !startup {
+			createdAt, err := time.Parse(dockerCreatedAt, created)
+			if label != instance || err != nil || now.Sub(createdAt) < orphanGrace {
+				continue
+			}
+		}
+		ids = append(ids, id)
 	}
+	return ids
 }
 
 // resolveDockerHost figures out the Docker socket. On macOS, Docker Desktop uses
@@ -137,8 +256,7 @@ func resolveDockerHost() string {
 }
 
 func (d *DockerRunner) Execute(ctx context.Context, req ExecutionRequest) (*ExecutionResult, error) {
-	var stdout, stderr bytes.Buffer
-	return d.executeInternal(ctx, req, &stdout, &stderr)
+	return d.executeInternal(ctx, req, io.Discard, io.Discard)
 }
 
 func (d *DockerRunner) ExecuteStreaming(ctx context.Context, req ExecutionRequest, stdout, stderr io.Writer) (*ExecutionResult, error) {
@@ -146,8 +264,8 @@ func (d *DockerRunner) ExecuteStreaming(ctx context.Context, req ExecutionReques
 }
 
 func (d *DockerRunner) executeInternal(ctx context.Context, req ExecutionRequest, stdout, stderr io.Writer) (*ExecutionResult, error) {
-	execID := uuid.New().String()
-	codeHash := fmt.Sprintf("%x", sha256.Sum256([]byte(req.Code)))
+	execID := executionID(req)
+	codeHash := requestCodeHash(req)
 
 	logger := log.With().
 		Str("exec_id", execID).
@@ -160,22 +278,48 @@ func (d *DockerRunner) executeInternal(ctx context.Context, req ExecutionRequest
 	if err := d.validateRequest(&req); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "validate", Err: err}
 	}
+	if err := d.verifyClaudeContract(ctx, req); err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "claude_contract", Err: err}
+	}
+	limitReport, limitEvents, err := d.checkLimits(ctx, executionLimits(d.runtimes, req.Language, req.Limits), req)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "check_limits", Err: err}
+	}
+	// Held until the container has exited, so the directories validated are
+	// the ones mounted.
+	pins, err := d.pinMounts(req)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "validate", Err: err}
+	}
+	defer pins.close()
 
-	select {
-	case d.sem <- struct{}{}:
-		defer func() { <-d.sem }()
-	case <-ctx.Done():
-		return nil, &ExecutionError{ExecID: execID, Op: "acquire_slot", Err: ctx.Err()}
+	workdir, workdirEvents, err := d.measureWorkdir(req)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "measure_work_dir", Err: err}
 	}
 
-	// Claude sessions have a separate, tighter concurrency limit.
-	if req.Language == "claude" {
-		select {
-		case d.claudeSem <- struct{}{}:
-			defer func() { <-d.claudeSem }()
-		case <-ctx.Done():
-			return nil, &ExecutionError{ExecID: execID, Op: "acquire_claude_slot", Err: ctx.Err()}
-		}
+	// Taken before the slot so a queued request doesn't hold one idle, and
+	// released last, after the container is removed.
+	unlock, err := d.lockWorkdir(ctx, req, execID)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "lock_work_dir", Err: err}
+	}
+	defer unlock()
+
+	// Read under the lock, so it is the state this execution starts from.
+	workdirGit, gitEvents, err := d.checkWorkdirGit(req)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "check_work_dir_git", Err: err}
+	}
+	workdirEvents = append(workdirEvents, gitEvents...)
+
+	release, err := d.slots.acquire(ctx, req.Language, callerOf(req))
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "acquire_slot", Err: err}
+	}
+	defer release()
+	if req.Admitted != nil {
+		req.Admitted()
 	}
 
 	d.wg.Add(1)
@@ -188,16 +332,27 @@ func (d *DockerRunner) executeInternal(ctx context.Context, req ExecutionRequest
 		if req.Language == "claude" {
 			timeout = 30 * time.Minute
 		} else {
-			timeout = 10 * time.Second
+			timeout = DefaultTimeout(req.Language)
 		}
 	}
-	execCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+	phases := newDeadlines(d.setupTimeout, timeout, d.cleanupGrace)
+	setupCtx, endSetup := phases.phase(ctx, PhaseSetup)
+	defer endSetup()
 
 	rt, err := d.runtimes.Get(req.Language)
 	if err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "get_runtime", Err: err}
 	}
+	if d.disk != nil {
+		if err := d.disk.admit(setupCtx, rt.Image()); err != nil {
+			return nil, setupError(setupCtx, execID, "admit_image", err)
+		}
+		defer d.disk.use(rt.Image(), d.digests.get(rt.Image()))()
+	}
+	pull, err := d.pullImage(setupCtx, rt.Image(), req.ImagePulling)
+	if err != nil {
+		return nil, setupError(setupCtx, execID, "pull_image", err)
+	}
 
 	hostDir, err := os.MkdirTemp("", "sandbox-"+execID+"-*")
 	if err != nil {
@@ -206,14 +361,17 @@ func (d *DockerRunner) executeInternal(ctx context.Context, req ExecutionRequest
 	defer os.RemoveAll(hostDir)
 
 	codeFile := filepath.Join(hostDir, "code"+rt.FileExtension())
-	if err := os.WriteFile(codeFile, []byte(req.Code), 0600); err != nil {
+	if err := writeCode(codeFile, req); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "write_code", Err: err}
 	}
-	if err := os.Chmod(codeFile, 0444); err != nil { // world-readable: container runs as nobody
+	if err := os.Chmod(codeFile, codeMode(req.Language)); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "chmod_code", Err: err}
 	}
 
 	containerCodePath := "/workspace/code" + rt.FileExtension()
+	if req.Workspace != "" {
+		containerCodePath = codeMountDir + "/code" + rt.FileExtension()
+	}
 	if rt.Name() == "claude" {
 		containerCodePath = "/tmp/prompt" + rt.FileExtension()
 	}
@@ -233,92 +391,450 @@ func (d *DockerRunner) executeInternal(ctx context.Context, req ExecutionRequest
 		}
 	}
 
-	// Write seccomp profile to temp file for Docker's --security-opt.
-	var seccompPath string
-	{
-		var profileJSON []byte
-		var profileErr error
-		if isClaude || req.NetworkEnabled {
-			profileJSON, profileErr = seccomp.DockerNetworkProfileJSON()
-		} else {
-			profileJSON, profileErr = seccomp.DockerProfileJSON()
+	if cachesAllowed(isClaude, req) && d.caches != nil {
+		if err := d.caches.ensure(setupCtx, req.Tenant); err != nil {
+			return nil, setupError(setupCtx, execID, "create_cache_volumes", err)
 		}
-		if profileErr != nil {
-			return nil, &ExecutionError{ExecID: execID, Op: "seccomp_profile", Err: profileErr}
+	}
+
+	// Docker's --security-opt reads the profile from the file written at
+	// startup, verified unchanged.
+	profiles, err := d.seccompProfiles()
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "seccomp_profile", Err: err}
+	}
+	seccompPath, err := profiles.path(req)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "seccomp_profile", Err: err}
+	}
+
+	// The wrapper writes the status file as the container user.
+	var seccompStatus string
+	if d.verifySeccomp && !isClaude {
+		seccompStatus = filepath.Join(hostDir, seccompStatusFile)
+		if err := os.WriteFile(seccompStatus, nil, 0600); err != nil {
+			return nil, &ExecutionError{ExecID: execID, Op: "write_seccomp_status", Err: err}
 		}
-		seccompFile := filepath.Join(hostDir, "seccomp.json")
-		if err := os.WriteFile(seccompFile, profileJSON, 0600); err != nil {
-			return nil, &ExecutionError{ExecID: execID, Op: "write_seccomp", Err: err}
+		if err := os.Chmod(seccompStatus, 0666); err != nil { // #nosec G302 -- written by the unprivileged container user
+			return nil, &ExecutionError{ExecID: execID, Op: "write_seccomp_status", Err: err}
+		}
+	}
+	var tracePath string
+	if req.DebugTrace {
+		tracePath = filepath.Join(hostDir, debugTraceFile)
+		if err := os.WriteFile(tracePath, nil, 0600); err != nil {
+			return nil, &ExecutionError{ExecID: execID, Op: "write_debug_trace", Err: err}
+		}
+		if err := os.Chmod(tracePath, 0666); err != nil { // #nosec G302 -- written by the unprivileged container user
+			return nil, &ExecutionError{ExecID: execID, Op: "write_debug_trace", Err: err}
 		}
-		seccompPath = seccompFile
 	}
 
+	// Probed before the clock starts: the first execution per image digest
+	// pays for it, outside its own duration.
+	if err := shapeIdentity(setupCtx, d.identities, d.genPasswd, rt.Image(), &req); err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "validate", Err: err}
+	}
+	if req.Passwd == PasswdGenerated {
+		if err := writePasswd(hostDir); err != nil {
+			return nil, &ExecutionError{ExecID: execID, Op: "write_passwd", Err: err}
+		}
+	}
+	if req.CodeFile == "" { // an upload isn't read back to see what warmups it would break
+		req.Warmups = chooseWarmups(setupCtx, d.warmups, d.runtimes, rt, req.Code, req.EnvVars)
+	}
+	compiled, releaseCompiled, err := d.compileCacheFor(setupCtx, req, rt, hostDir)
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "compile_cache", Err: err}
+	}
+	defer releaseCompiled()
+	req.compiled = compiled
+	if err := d.awaitStartupSweep(setupCtx); err != nil {
+		return nil, setupError(setupCtx, execID, "orphan_sweep", err)
+	}
+	if _, ok := expired(setupCtx); ok {
+		return nil, setupError(setupCtx, execID, "setup", setupCtx.Err())
+	}
+	endSetup()
+
+	if err := pins.apply(&req, d.procMounts); err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "validate", Err: err}
+	}
 	args := d.buildDockerArgs(execID, rt, codeFile, containerCodePath, hostDir, seccompPath, req)
+	ulimits := executionLimits(d.runtimes, req.Language, req.Limits).EffectiveUlimits(d.maxUlimits)
 
 	start := time.Now()
 
-	cmd := exec.CommandContext(execCtx, "docker", args...) // #nosec G204 -- args built internally by buildDockerArgs, not from raw user input
+	containerName := d.containerName(execID)
+	// Registered before the container exists, and until it's gone, so
+	// orphan sweeps leave it alone.
+	d.mu.Lock()
+	d.running[containerName] = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.running, containerName)
+		d.mu.Unlock()
+	}()
+	// The container is not started with --rm so we can inspect its final state
+	// (OOMKilled) before it goes away. Remove it ourselves on every path; this
+	// also stops a container whose docker CLI we killed on timeout. A name
+	// still taken by someone else's container when docker run gave up is
+	// left to its owner.
+	var nameTaken bool
+	defer func() {
+		if nameTaken {
+			return
+		}
+		cleanupCtx, endCleanup := phases.phase(ctx, PhaseCleanup)
+		defer endCleanup()
+		if rmErr := d.removeContainer(cleanupCtx, containerName); rmErr != nil {
+			logger.Error().Err(rmErr).Msg("container removal failed")
+		}
+	}()
+
+	// The timeout starts here, with the container: docker run only creates
+	// it, the image being present already.
+	execCtx, endRun := phases.phase(ctx, PhaseRun)
+	defer endRun()
+	runCtx, idle := watchIdle(execCtx, req)
+	defer idle.Stop()
+	// A local daemon's container cgroup is found once, as it starts, for
+	// both watchers, and read a last time as the program exits, before the
+	// deferred removal.
+	var cgroup *containerCgroup
+	if d.procMounts {
+		cgroup = watchCgroup(execCtx, d.dockerCgroupDir(containerName, start))
+		defer cgroup.Stop()
+	}
+	runCtx, cpu := watchCPU(runCtx, d.cpuAbuse, req, dockerCPUSource(cgroup))
+	defer cpu.Stop()
+	readUsage, lastUsage, usageInterval := dockerUsageSource(d.dockerOutput, containerName, cgroup)
+	usage := watchUsage(execCtx, readUsage, lastUsage, usageInterval)
+	defer usage.Stop()
+	// Killing the CLI leaves anything it started holding our pipes;
+	// dockerCommand stops waiting on them soon after. The deferred removal
+	// stops the container.
+	cmd := d.dockerCommand(runCtx, args...)
 
-	if d.dockerHost != "" {
-		cmd.Env = append(os.Environ(), "DOCKER_HOST="+d.dockerHost)
+	output := NewOutputBudget(req.Output, stdout, stderr)
+	if req.MergeOutput {
+		output.Merge()
 	}
+	req.Partial.Attach(output)
+	conflicts := newConflictGuard(output.Stderr())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinReader(req), output.Stdout(), conflicts
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = io.MultiWriter(&stdoutBuf, stdout)
-	cmd.Stderr = io.MultiWriter(&stderrBuf, stderr)
+	// Claude emits stream-json: callers get only the result text, while the
+	// raw events feed the progress tracker.
+	var claudeOut *claudeResultWriter
+	if isClaude {
+		claudeOut = newClaudeResultWriter(cmd.Stdout)
+		cmd.Stdout = claudeOut
+		if req.Progress != nil {
+			cmd.Stdout = io.MultiWriter(req.Progress, claudeOut)
+		}
+	}
+	// Outermost, so claude's stream-json events count as output too.
+	cmd.Stdout, cmd.Stderr = cpu.Output(idle.Output(cmd.Stdout)), cpu.Output(idle.Output(cmd.Stderr))
 
 	logger.Info().Strs("args", args[:5]).Msg("starting docker container")
 
+	var probe *pathProbe
+	if d.verifyPaths {
+		probe = d.startPathProbe(execCtx, containerName, DefaultSecurityProfile())
+	}
+	var egress *egressWatch
+	if req.NetworkEnabled {
+		egress = d.egress.watch(req.InternetOnly)
+		go egress.resolve(execCtx, dockerContainerIP(d.dockerOutput, containerName))
+	}
 	err = cmd.Run()
+	// A name held by a container another server left behind is taken
+	// back, once.
+	if holder, ok := conflicts.conflict(err); ok && d.clearNameConflict(execCtx, containerName, holder) {
+		retry := d.dockerCommand(runCtx, args...)
+		retry.Stdin, retry.Stdout, retry.Stderr = stdinReader(req), cmd.Stdout, cmd.Stderr
+		conflicts.reset()
+		err = retry.Run()
+	}
+	_, nameTaken = conflicts.conflict(err)
+	resourceUsage := usage.Stop()
+	if flushErr := conflicts.release(); flushErr != nil {
+		logger.Debug().Err(flushErr).Msg("passing on docker run's stderr failed")
+	}
+	network, egressEvents := egress.Stop()
 	duration := time.Since(start)
+	if claudeOut != nil {
+		if flushErr := claudeOut.Flush(); flushErr != nil {
+			logger.Warn().Err(flushErr).Msg("flushing claude output failed")
+		}
+	}
+	stdoutText, stderrText := output.Output()
 
 	var exitCode int
-	var securityEvents []SecurityEvent
+	securityEvents := append(limitEvents, workdirEvents...)
+	if probe != nil {
+		pathEvents, probeErr := probe.wait()
+		if probeErr != nil {
+			logger.Debug().Err(probeErr).Msg("masked and read-only paths went unchecked")
+		}
+		for _, ev := range pathEvents {
+			logger.Error().Str("event", ev.Type).Msg(ev.Detail)
+		}
+		securityEvents = append(securityEvents, pathEvents...)
+	}
+	securityEvents = append(securityEvents, egressEvents...)
 
 	if err != nil {
-		if execCtx.Err() == context.DeadlineExceeded {
+		if ctxErr := runCtx.Err(); ctxErr != nil {
+			reason := killTimeout
+			switch _, timedOut := expired(execCtx); {
+			case idle.Fired():
+				reason = killIdle
+			case cpu.Fired():
+				reason = killCPUAbuse
+			case !timedOut:
+				reason = killManual
+			}
+			result := &ExecutionResult{
+				ID:        execID,
+				Output:    stdoutText,
+				Stderr:    stderrText,
+				ExitCode:  -1,
+				ExitClass: classifyExit(exitInfo{code: -1, killed: reason}),
+				Duration:  duration,
+				CodeHash:  codeHash,
+				Argv:      commandArgv(rt, containerCodePath, req),
+				Cwd:       effectiveCwd(req),
+				Claude:    req.Claude,
+				Warmups:   req.Warmups,
+				Env:       envKeys(req.EnvVars),
+				Passwd:    req.Passwd,
+				Ulimits:   &ulimits,
+				Image:     rt.Image(),
+				ImagePull: pull,
+				Limits:    limitReport,
+				Workdir:   workdir,
+
+				ResourceUsage:      resourceUsage,
+				WorkdirGit:         workdirGit,
+				NetworkConnections: network,
+			}
+			result.Timezone, result.Locale = effectiveLocale(req)
+			output.mergeInto(result)
+			result.ImageDigest = d.digests.get(rt.Image())
+			if seccompStatus != "" {
+				result.Seccomp, _ = d.checkSeccomp(seccompStatus, -1)
+			}
+			if tracePath != "" {
+				result.DebugTrace = readTrace(tracePath, d.debugTrace.MaxBytes)
+			}
+			if claudeOut != nil {
+				result.ClaudeUsage = claudeOut.usage
+			}
+			switch reason {
+			case killManual:
+				result.SecurityEvents = securityEvents
+				return result, &ExecutionError{ExecID: execID, Op: "docker_run", Err: ctxErr}
+			case killIdle:
+				var event SecurityEvent
+				result.Idle, event = idle.report()
+				result.SecurityEvents = append(securityEvents, event)
+				return result, ErrIdleTimeout
+			case killCPUAbuse:
+				result.SecurityEvents = append(securityEvents, cpu.report())
+				if d.cpuAbuseObs != nil {
+					d.cpuAbuseObs.CPUAbuseKilled(req.Language, cpu.trigger())
+				}
+				return result, ErrCPUAbuse
+			}
 			securityEvents = append(securityEvents, SecurityEvent{
 				Type:   "timeout",
+				Source: SourceRuntime,
 				Detail: fmt.Sprintf("execution exceeded %s timeout", timeout),
 			})
-			return &ExecutionResult{
-				ID:             execID,
-				Output:         truncateOutput(stdoutBuf.String(), 1<<20),
-				Stderr:         truncateOutput(stderrBuf.String(), 256*1024),
-				ExitCode:       -1,
-				Duration:       duration,
-				SecurityEvents: securityEvents,
-				CodeHash:       codeHash,
-			}, ErrTimeout
+			result.SecurityEvents = securityEvents
+			return result, ErrTimeout
 		}
 
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
-			if exitCode == 137 {
-				securityEvents = append(securityEvents, SecurityEvent{
-					Type:   "oom_kill",
-					Detail: "process killed (OOM or resource limit)",
-				})
-			}
 		} else {
 			return nil, &ExecutionError{ExecID: execID, Op: "docker_run", Err: err}
 		}
 	}
 
+	var seccompResult *SeccompStatus
+	if seccompStatus != "" {
+		var outcome string
+		seccompResult, outcome = d.checkSeccomp(seccompStatus, exitCode)
+		if outcome == SeccompNotApplied {
+			mode := "unknown"
+			if seccompResult != nil {
+				mode = seccompResult.Mode
+			}
+			logger.Error().Str("seccomp_mode", mode).Msg("container started without a seccomp filter; code not run")
+			return nil, &ExecutionError{ExecID: execID, Op: "verify_seccomp", Err: ErrSeccompNotApplied}
+		}
+	}
+	if d.verifyCode && !isClaude && codeIntegrityRefused(exitCode, stderrText) {
+		logger.Error().Str("code_hash", codeHash).Msg("mounted code file doesn't match its hash; code not run")
+		return nil, &ExecutionError{ExecID: execID, Op: "verify_code", Err: ErrCodeIntegrity}
+	}
+
+	info := exitInfo{code: exitCode, stderr: stderrText}
+	if exitCode == exitCodeSIGKILL {
+		info.oomKilled = d.inspectOOMKilled(containerName)
+	}
+	exitClass := classifyExit(info)
+	if exitClass == ExitOOMKill {
+		securityEvents = append(securityEvents, SecurityEvent{
+			Type:   "oom_kill",
+			Source: SourceRuntime,
+			Detail: "process killed by OOM killer",
+		})
+	}
+
 	logger.Info().
 		Int("exit_code", exitCode).
+		Str("exit_class", string(exitClass)).
 		Dur("duration", duration).
 		Msg("docker execution completed")
 
-	return &ExecutionResult{
+	result := &ExecutionResult{
 		ID:             execID,
-		Output:         truncateOutput(stdoutBuf.String(), 1<<20),
-		Stderr:         truncateOutput(stderrBuf.String(), 256*1024),
+		Output:         stdoutText,
+		Stderr:         stderrText,
 		ExitCode:       exitCode,
+		ExitClass:      exitClass,
 		Duration:       duration,
 		SecurityEvents: securityEvents,
+		ResourceUsage:  resourceUsage,
 		CodeHash:       codeHash,
-	}, nil
+		Argv:           commandArgv(rt, containerCodePath, req),
+		Cwd:            effectiveCwd(req),
+		Claude:         req.Claude,
+		Warmups:        req.Warmups,
+		Env:            envKeys(req.EnvVars),
+		Passwd:         req.Passwd,
+		Seccomp:        seccompResult,
+		Ulimits:        &ulimits,
+		Image:          rt.Image(),
+		ImageDigest:    d.digests.get(rt.Image()),
+		ImagePull:      pull,
+		Limits:         limitReport,
+		Workdir:        workdir,
+		WorkdirGit:     workdirGit,
+
+		NetworkConnections: network,
+	}
+	if exitClass == ExitOOMKill {
+		result.Recommendation = recommendMemory(d.runtimes, req.Language, req.Limits)
+	}
+	if tracePath != "" {
+		result.DebugTrace = readTrace(tracePath, d.debugTrace.MaxBytes)
+	}
+	if claudeOut != nil {
+		result.ClaudeUsage = claudeOut.usage
+	}
+	if compiled != nil {
+		result.CompileCache = CompileCacheMiss
+		if compiled.hit {
+			result.CompileCache = CompileCacheHit
+		} else if exitCode == 0 {
+			if err := d.compiled.harvest(compiled); err != nil {
+				logger.Warn().Err(err).Msg("compiled code not cached")
+			}
+		}
+	}
+	result.Timezone, result.Locale = effectiveLocale(req)
+	output.mergeInto(result)
+	return result, nil
+}
+
+// checkSeccomp reads the seccomp wrapper's status file after a run that
+// exited with exitCode (-1 when it was killed) and reports the outcome.
+func (d *DockerRunner) checkSeccomp(statusFile string, exitCode int) (*SeccompStatus, string) {
+	data, err := os.ReadFile(statusFile) // #nosec G304 -- path built internally under hostDir
+	status, outcome := verifySeccomp(data, err, exitCode)
+	if d.seccompObs != nil {
+		d.seccompObs.SeccompVerified(outcome)
+	}
+	return status, outcome
+}
+
+// dockerCommand builds a docker CLI invocation against the resolved Docker host.
+func (d *DockerRunner) dockerCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "docker", args...) // #nosec G204 -- args built internally
+	cmd.WaitDelay = dockerKillWait
+	if d.dockerHost != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_HOST="+d.dockerHost)
+	}
+	return cmd
+}
+
+// dockerOutput runs a docker CLI command and returns its stdout. Errors
+// include what docker printed to stderr.
+func (d *DockerRunner) dockerOutput(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := d.dockerCommand(ctx, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return out, err
+}
+
+// inspectOOMKilled asks the daemon whether the kernel OOM killer stopped the
+// container. Returns false if the container is gone or inspect fails.
+func (d *DockerRunner) inspectOOMKilled(name string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := d.dockerCommand(ctx, "inspect", "--format", "{{.State.OOMKilled}}", name).Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(out)) == "true"
+}
+
+// pullImage pulls image if it isn't present, so that an execution's setup
+// phase pays for the pull rather than docker run, inside the program's
+// timeout. docker pull --quiet tells nothing of how far it has got, so
+// progress, if not nil, is called once as the pull starts. The ImagePull
+// is nil when there was nothing to pull.
+func (d *DockerRunner) pullImage(ctx context.Context, image string, progress func(PullProgress)) (*ImagePull, error) {
+	if d.digests.get(image) != "" {
+		return nil, nil
+	}
+	log.Info().Str("image", image).Msg("pulling image")
+	if progress != nil {
+		progress(PullProgress{Image: image})
+	}
+	start := time.Now()
+	if _, err := d.dockerOutput(ctx, "pull", "--quiet", image); err != nil {
+		return nil, fmt.Errorf("pulling image %s: %w", image, err)
+	}
+	pull := &ImagePull{Image: image, Duration: time.Since(start)}
+	log.Info().Str("image", image).Dur("took", pull.Duration).Msg("image pulled successfully")
+	if d.imagePullObs != nil {
+		d.imagePullObs.ImagePulled(image, pull.Duration)
+	}
+	return pull, nil
+}
+
+// removeContainer force-removes the named container, killing it if still
+// running, within ctx.
+func (d *DockerRunner) removeContainer(ctx context.Context, name string) error {
+	var stderr bytes.Buffer
+	cmd := d.dockerCommand(ctx, "rm", "-f", name)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "No such container") {
+			return nil
+		}
+		return fmt.Errorf("docker rm %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
 }
 
 func (d *DockerRunner) buildDockerArgs(
@@ -329,31 +845,25 @@ func (d *DockerRunner) buildDockerArgs(
 	req ExecutionRequest,
 ) []string {
 	isClaude := rt.Name() == "claude"
-
-	limits := req.Limits
-	if limits == (ResourceLimits{}) {
-		if isClaude {
-			limits = DevLimits()
-		} else {
-			limits = DefaultLimits()
-		}
-	}
+	limits := executionLimits(d.runtimes, rt.Name(), req.Limits)
+	ulimits := limits.EffectiveUlimits(d.maxUlimits)
 
 	network := "none"
-	if req.NetworkEnabled || isClaude {
+	if req.NetworkEnabled {
 		network = "bridge"
 	}
 
-	user := "65534:65534"
-	home := "/tmp"
+	user := sandboxUID + ":" + sandboxUID
+	home := sandboxHome
 	if isClaude {
 		user = "1000:1000"
 		home = "/home/node"
 	}
 
 	args := []string{
-		"run", "--rm",
-		"--name", "sandbox-" + execID,
+		"run",
+		"--name", d.containerName(execID),
+		"--label", instanceLabel + "=" + d.instance,
 		"--network", network,
 		"--cap-drop", "ALL",
 		"--security-opt", "no-new-privileges",
@@ -363,12 +873,27 @@ func (d *DockerRunner) buildDockerArgs(
 		"--pids-limit", fmt.Sprintf("%d", limits.PidsLimit),
 		"--cpus", fmt.Sprintf("%.1f", float64(limits.CPUShares)/1024.0),
 		"--tmpfs", fmt.Sprintf("/tmp:rw,nosuid,nodev,size=%dm", limits.DiskMB),
+		"--ulimit", fmt.Sprintf("nofile=%d:%d", ulimits.NoFile, ulimits.NoFile),
+		"--ulimit", fmt.Sprintf("nproc=%d:%d", ulimits.NProc, ulimits.NProc),
+		"--ulimit", fmt.Sprintf("fsize=%d:%d", ulimits.FSize, ulimits.FSize),
+		"--ulimit", fmt.Sprintf("core=%d:%d", ulimits.Core, ulimits.Core),
 		"-v", fmt.Sprintf("%s:%s:ro", hostCodeFile, containerCodePath),
 		"--user", user,
 		"-e", "HOME=" + home,
-		"-e", "LANG=C.UTF-8",
-		"-e", "SANDBOX=true",
 	}
+	for _, env := range baseLocaleEnv(req) {
+		args = append(args, "-e", env)
+	}
+	args = append(args, "-e", "SANDBOX=true")
+	if req.Passwd == PasswdGenerated {
+		args = append(args,
+			"-v", filepath.Join(hostDir, "passwd")+":/etc/passwd:ro",
+			"-v", filepath.Join(hostDir, "group")+":/etc/group:ro",
+		)
+	}
+
+	// The same masked and read-only paths as the containerd backend's spec.
+	args = append(args, dockerPathArgs(DefaultSecurityProfile(), os.Stat)...)
 
 	// Claude needs a writable rootfs (Node.js/npm write to global cache dirs at startup).
 	// Other runtimes get a read-only rootfs for tighter isolation.
@@ -376,10 +901,41 @@ func (d *DockerRunner) buildDockerArgs(
 		args = append(args, "--read-only")
 	}
 
+	if req.Workspace != "" {
+		mode := "ro"
+		if isClaude {
+			mode = "rw"
+		}
+		args = append(args,
+			"-v", fmt.Sprintf("%s:/workspace:%s", req.Workspace, mode),
+		)
+	}
+	if cwd := effectiveCwd(req); cwd != "" {
+		args = append(args, "--workdir", cwd)
+	}
+	// Without it docker run never reads its own stdin.
+	if req.Stdin != "" {
+		args = append(args, "--interactive")
+	}
+
+	for _, name := range req.SharedMounts { // validated by validateRequest
+		if m, ok := d.sharedMounts[name]; ok {
+			args = append(args, "-v", fmt.Sprintf("%s:%s:ro", m.HostPath, m.ContainerPath))
+		}
+	}
+
+	if cachesAllowed(isClaude, req) && d.caches != nil {
+		args = append(args, d.caches.mountArgs(req.Tenant)...)
+	}
+
 	if isClaude {
 		if req.WorkDir != "" {
+			mode := "ro"
+			if workdirWritable(req) {
+				mode = "rw"
+			}
 			args = append(args,
-				"-v", fmt.Sprintf("%s:/workspace:rw", req.WorkDir),
+				"-v", fmt.Sprintf("%s:/workspace:%s", req.WorkDir, mode),
 			)
 		}
 
@@ -387,11 +943,30 @@ func (d *DockerRunner) buildDockerArgs(
 			// Auth proxy mode: route API traffic through the host proxy.
 			// The container gets a proxy secret as its "API key" — the proxy
 			// validates it before forwarding with the real token. The secret
-			// is worthless against api.anthropic.com directly.
+			// is worthless against api.anthropic.com directly. A caller's own
+			// token is registered under a secret of its own.
+			secret := d.proxySecret
+			if req.ProxySecret != "" {
+				secret = req.ProxySecret
+			}
+			host, scheme := d.proxyHost, "http"
+			if host == "" {
+				host = "host-gateway"
+			}
+			if d.proxyCA != "" {
+				// Claude Code trusts the proxy's per-startup CA through
+				// NODE_EXTRA_CA_CERTS, so the secret never crosses the
+				// bridge in the clear.
+				scheme = "https"
+				args = append(args,
+					"-v", d.proxyCA+":"+proxyCAPath+":ro",
+					"-e", "NODE_EXTRA_CA_CERTS="+proxyCAPath,
+				)
+			}
 			args = append(args,
-				"--add-host", "host.docker.internal:host-gateway",
-				"-e", fmt.Sprintf("ANTHROPIC_BASE_URL=http://host.docker.internal:%d", d.proxyPort),
-				"-e", "ANTHROPIC_API_KEY="+d.proxySecret,
+				"--add-host", "host.docker.internal:"+host,
+				"-e", fmt.Sprintf("ANTHROPIC_BASE_URL=%s://host.docker.internal:%d", scheme, d.proxyPort),
+				"-e", "ANTHROPIC_API_KEY="+secret,
 			)
 		} else {
 			// Legacy mode: mount auth token as a secret file.
@@ -404,100 +979,162 @@ func (d *DockerRunner) buildDockerArgs(
 		}
 	}
 
+	for _, env := range runtime.EnvFor(rt, req.NetworkEnabled) {
+		args = append(args, "-e", env)
+	}
 	for _, env := range req.EnvVars {
 		args = append(args, "-e", env)
 	}
+	for _, env := range warmupEnv(rt, containerCodePath, req) {
+		args = append(args, "-e", env)
+	}
 
+	argv := commandArgv(rt, containerCodePath, req)
+	cacheArgs, argv := compileCacheArgs(rt, containerCodePath, req, argv)
+	args = append(args, cacheArgs...)
+	if req.DebugTrace {
+		args = append(args,
+			"-v", fmt.Sprintf("%s:%s:ro", d.debugTrace.Strace, debugTraceStrace),
+			"-v", fmt.Sprintf("%s:%s:rw", filepath.Join(hostDir, debugTraceFile), debugTracePath),
+		)
+		argv = traceCommand(argv, d.debugTrace.MaxBytes)
+	}
+	if d.verifyCode && !isClaude {
+		args = append(args, "-e", codeHashEnv+"="+requestCodeHash(req))
+		argv = codeIntegrityCommand(containerCodePath, argv)
+	}
+	if d.verifySeccomp && !isClaude {
+		args = append(args, "-v", fmt.Sprintf("%s:%s:rw", filepath.Join(hostDir, seccompStatusFile), seccompStatusPath))
+		argv = seccompCommand(argv)
+	}
 	args = append(args, rt.Image())
-	args = append(args, rt.Command(containerCodePath)...)
+	args = append(args, argv...)
 
 	return args
 }
 
+// workdirWritable reports whether req mounts its work_dir read-write: claude
+// gets it rw unless the caller asked for a read-only filesystem. Other
+// runtimes don't mount it.
+func workdirWritable(req ExecutionRequest) bool {
+	return req.Language == "claude" && req.WorkDir != "" && !req.ReadOnly
+}
+
+// lockWorkdir takes the lock on req's work_dir when it is mounted read-write;
+// read-only mounts of the same directory don't need one.
+func (d *DockerRunner) lockWorkdir(ctx context.Context, req ExecutionRequest, execID string) (func(), error) {
+	if !workdirWritable(req) {
+		return func() {}, nil
+	}
+	return d.workdirs.acquire(ctx, req.WorkDir, execID)
+}
+
 func (d *DockerRunner) validateRequest(req *ExecutionRequest) error {
-	if req.Code == "" {
-		return fmt.Errorf("%w: code is empty", ErrInvalidRequest)
+	if name, ok := d.runtimes.Canonical(req.Language); ok {
+		req.Language = name
 	}
-	if len(req.Code) > 1<<20 {
-		return fmt.Errorf("%w: code exceeds 1MB limit", ErrInvalidRequest)
+	if err := validateCode(*req); err != nil {
+		return err
 	}
 	if _, err := d.runtimes.Get(req.Language); err != nil {
-		return fmt.Errorf("%w: %s", ErrUnsupportedLang, req.Language)
+		return fmt.Errorf("%w: %q (supported: %s)", ErrUnsupportedLang, req.Language, d.runtimes.Supported())
 	}
-	maxTimeout := 60 * time.Second
-	if req.Language == "claude" {
-		maxTimeout = 30 * time.Minute
+	if req.Language == binaryLanguage {
+		if err := validateBinary(*req, d.binary, hostMachine()); err != nil {
+			return err
+		}
 	}
-	if req.Timeout > maxTimeout {
+	if maxTimeout := maxTimeoutOf(*req); req.Timeout > maxTimeout {
 		return fmt.Errorf("%w: timeout exceeds %s maximum", ErrInvalidRequest, maxTimeout)
 	}
 	if req.WorkDir != "" {
-		// Resolve symlinks to prevent TOCTOU race — store the real path back into req.
-		realPath, err := filepath.EvalSymlinks(req.WorkDir)
-		if err != nil {
-			return fmt.Errorf("%w: work_dir is not valid", ErrInvalidRequest)
+		if len(d.allowedRoots) == 0 {
+			return fmt.Errorf("%w: no allowed_workdir_roots configured; WorkDir mounts are disabled", ErrInvalidRequest)
 		}
-		info, err := os.Stat(realPath)
-		if err != nil || !info.IsDir() {
-			return fmt.Errorf("%w: work_dir is not a valid directory", ErrInvalidRequest)
+		// Store the resolved path back into req so the mount uses what we checked.
+		realPath, err := resolveMountDir(req.WorkDir, d.allowedRoots, "work_dir")
+		if err != nil {
+			return err
 		}
 		req.WorkDir = realPath
-
-		// Block known sensitive prefixes
-		for _, prefix := range sensitivePathPrefixes {
-			if strings.HasPrefix(realPath, prefix+"/") || realPath == prefix {
-				return fmt.Errorf("%w: work_dir %q is under a sensitive path", ErrInvalidRequest, prefix)
-			}
-		}
-		// Block home directories containing sensitive subdirs
-		for _, dir := range sensitiveHomeDirs {
-			if strings.Contains(realPath, "/"+dir+"/") || strings.HasSuffix(realPath, "/"+dir) {
-				return fmt.Errorf("%w: work_dir contains sensitive directory %q", ErrInvalidRequest, dir)
-			}
+	}
+	if err := validateCommand(*req); err != nil {
+		return err
+	}
+	if err := validateStdin(*req, d.maxStdin); err != nil {
+		return err
+	}
+	if err := validateDebugTrace(*req, d.debugTrace.Strace); err != nil {
+		return err
+	}
+	if err := d.claude.resolve(req); err != nil {
+		return err
+	}
+	if req.UseCaches {
+		switch {
+		case req.Language != "claude":
+			return fmt.Errorf("%w: use_caches is only supported for claude", ErrInvalidRequest)
+		case req.ReadOnly:
+			return fmt.Errorf("%w: use_caches cannot be combined with a read-only filesystem", ErrInvalidRequest)
+		case d.caches == nil:
+			return fmt.Errorf("%w: no sandbox.claude_caches configured", ErrInvalidRequest)
 		}
-
-		// Check WorkDir is under an allowed root
-		if len(d.allowedRoots) > 0 {
-			allowed := false
-			for _, root := range d.allowedRoots {
-				if strings.HasPrefix(realPath, root+"/") || realPath == root {
-					allowed = true
-					break
-				}
-			}
-			if !allowed {
-				return fmt.Errorf("%w: work_dir is not under an allowed root", ErrInvalidRequest)
-			}
-		} else {
-			return fmt.Errorf("%w: no allowed_workdir_roots configured; WorkDir mounts are disabled", ErrInvalidRequest)
+	}
+	if req.ProxySecret != "" {
+		// Without the proxy the container would get the server's token.
+		switch {
+		case req.Language != "claude":
+			return fmt.Errorf("%w: a proxy secret is only supported for claude", ErrInvalidRequest)
+		case d.proxyPort == 0:
+			return fmt.Errorf("%w: a caller's claude token needs the auth proxy (auth_proxy.port)", ErrInvalidRequest)
 		}
 	}
-	for _, env := range req.EnvVars {
-		if !strings.Contains(env, "=") {
-			return fmt.Errorf("%w: env var must be KEY=VALUE format", ErrInvalidRequest)
+	if req.Workspace != "" {
+		if req.WorkDir != "" {
+			return fmt.Errorf("%w: work_dir and workspace are mutually exclusive", ErrInvalidRequest)
 		}
-		key := env[:strings.Index(env, "=")]
-		for _, c := range key {
-			if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_') {
-				return fmt.Errorf("%w: env var key contains invalid characters", ErrInvalidRequest)
-			}
+		if d.workspaceRoot == "" {
+			return fmt.Errorf("%w: workspaces are not enabled", ErrInvalidRequest)
 		}
-		if envBlocklist[strings.ToUpper(key)] {
-			return fmt.Errorf("%w: env var %q is blocked for security reasons", ErrInvalidRequest, key)
+		realPath, err := resolveMountDir(req.Workspace, []string{d.workspaceRoot}, "workspace")
+		if err != nil {
+			return err
 		}
+		req.Workspace = realPath
+	}
+	if _, err := resolveSharedMounts(req.SharedMounts, req.Language, d.sharedMounts); err != nil {
+		return err
+	}
+	if err := validateEnvVars(req.EnvVars); err != nil {
+		return err
+	}
+	req.EnvVars = d.defaultEnv.apply(req.Language, req.EnvVars)
+	if err := validateLocale(req); err != nil {
+		return err
 	}
-	if req.Limits != (ResourceLimits{}) {
+	req.EnvVars = localeEnv(*req, req.EnvVars)
+	if req.Privileged != nil {
+		req.Limits = req.Privileged.Limits()
+	} else if req.Limits != (ResourceLimits{}) {
 		if err := req.Limits.Validate(); err != nil {
 			return err
 		}
 	}
-	return nil
+	return req.Limits.ValidateUlimits(d.maxUlimits)
 }
 
 func (d *DockerRunner) ActiveCount() int64 {
 	return d.active.Load()
 }
 
+// Stats reports the occupancy of the concurrency slots.
+func (d *DockerRunner) Stats() (SlotStats, bool) {
+	return d.slots.stats(), true
+}
+
+// Name returns "docker".
+func (d *DockerRunner) Name() string { return "docker" }
+
 func (d *DockerRunner) Close() error {
 	d.mu.Lock()
 	d.closed = true
@@ -506,6 +1143,12 @@ func (d *DockerRunner) Close() error {
 	if d.cancelCleanup != nil {
 		d.cancelCleanup()
 	}
+	if d.cancelCaches != nil {
+		d.cancelCaches()
+	}
+	if d.cancelDisk != nil {
+		d.cancelDisk()
+	}
 
 	// Wait up to 30s for active executions to drain.
 	done := make(chan struct{})
@@ -519,5 +1162,10 @@ func (d *DockerRunner) Close() error {
 	case <-time.After(30 * time.Second):
 		log.Warn().Int64("active", d.active.Load()).Msg("timed out waiting for docker executions to drain")
 	}
+	d.seccompMu.Lock()
+	if d.seccomp != nil {
+		d.seccomp.remove()
+	}
+	d.seccompMu.Unlock()
 	return nil
 }
@@ -1,6 +1,10 @@
 package sandbox
 
 import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -13,8 +17,7 @@ import (
 func newTestRunner(proxyPort int, proxySecret string, allowedRoots []string) *DockerRunner {
 	return &DockerRunner{
 		runtimes:     runtime.NewRegistry(),
-		sem:          make(chan struct{}, 10),
-		claudeSem:    make(chan struct{}, 5),
+		slots:        newSlotLimiter(10, map[string]int{"claude": 5, defaultPool: 10}),
 		proxyPort:    proxyPort,
 		proxySecret:  proxySecret,
 		allowedRoots: allowedRoots,
@@ -41,6 +44,16 @@ func argsContainPrefix(args []string, prefix string) bool {
 	return false
 }
 
+// argsContainPair returns true if flag is immediately followed by value.
+func argsContainPair(args []string, flag, value string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
 func TestBuildDockerArgs_StandardRuntime(t *testing.T) {
 	d := newTestRunner(0, "", nil)
 	rt, _ := d.runtimes.Get("python")
@@ -75,7 +88,7 @@ func TestBuildDockerArgs_ClaudeWithProxy(t *testing.T) {
 	args := d.buildDockerArgs("exec-2", rt,
 		"/tmp/prompt.txt", "/tmp/prompt.txt",
 		"/tmp/sandbox-exec-2", "/tmp/seccomp.json",
-		ExecutionRequest{Language: "claude", Code: "hello"},
+		ExecutionRequest{Language: "claude", Code: "hello", NetworkEnabled: true},
 	)
 
 	if !argsContain(args, "host.docker.internal:host-gateway") {
@@ -98,6 +111,44 @@ func TestBuildDockerArgs_ClaudeWithProxy(t *testing.T) {
 	}
 }
 
+func TestBuildDockerArgs_ClaudeCallerToken(t *testing.T) {
+	d := newTestRunner(8081, "secret123", nil)
+	rt, _ := d.runtimes.Get("claude")
+
+	args := d.buildDockerArgs("exec-4", rt,
+		"/tmp/prompt.txt", "/tmp/prompt.txt",
+		"/tmp/sandbox-exec-4", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "claude", Code: "hello", ProxySecret: "per-exec-secret"},
+	)
+	if !argsContain(args, "ANTHROPIC_API_KEY=per-exec-secret") {
+		t.Error("expected the per-execution secret as ANTHROPIC_API_KEY")
+	}
+	if argsContain(args, "ANTHROPIC_API_KEY=secret123") {
+		t.Error("server proxy secret passed alongside the caller's")
+	}
+}
+
+func TestValidateRequest_ProxySecret(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		proxyPort int
+		language  string
+		wantErr   bool
+	}{
+		{"claude with proxy", 8081, "claude", false},
+		{"claude without proxy", 0, "claude", true},
+		{"not claude", 8081, "python", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestRunner(tt.proxyPort, "secret123", nil)
+			req := ExecutionRequest{Language: tt.language, Code: "hello", ProxySecret: "per-exec-secret"}
+			if err := d.validateRequest(&req); (err != nil) != tt.wantErr {
+				t.Errorf("validateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestBuildDockerArgs_ClaudeWithoutProxy(t *testing.T) {
 	d := newTestRunner(0, "", nil)
 	rt, _ := d.runtimes.Get("claude")
@@ -105,7 +156,7 @@ func TestBuildDockerArgs_ClaudeWithoutProxy(t *testing.T) {
 	args := d.buildDockerArgs("exec-3", rt,
 		"/tmp/prompt.txt", "/tmp/prompt.txt",
 		"/tmp/sandbox-exec-3", "/tmp/seccomp.json",
-		ExecutionRequest{Language: "claude", Code: "hello"},
+		ExecutionRequest{Language: "claude", Code: "hello", NetworkEnabled: true},
 	)
 
 	// Without proxy, no ANTHROPIC_BASE_URL.
@@ -115,6 +166,17 @@ func TestBuildDockerArgs_ClaudeWithoutProxy(t *testing.T) {
 	if !argsContain(args, "bridge") {
 		t.Error("expected --network bridge for claude runtime")
 	}
+
+	// The network is the API's decision: the runner doesn't give claude
+	// one the request doesn't carry.
+	args = d.buildDockerArgs("exec-3", rt,
+		"/tmp/prompt.txt", "/tmp/prompt.txt",
+		"/tmp/sandbox-exec-3", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "claude", Code: "hello"},
+	)
+	if argsContain(args, "bridge") || !argsContain(args, "none") {
+		t.Error("expected --network none for claude without network_enabled")
+	}
 	// Claude should not have --read-only.
 	if argsContain(args, "--read-only") {
 		t.Error("claude runtime should NOT have --read-only")
@@ -136,6 +198,217 @@ func TestBuildDockerArgs_ClaudeWorkDir(t *testing.T) {
 	}
 }
 
+func TestBuildDockerArgs_Workspace(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+
+	py, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-5", py,
+		"/tmp/code.py", codeMountDir+"/code.py",
+		"/tmp/sandbox-exec-5", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "print(1)", Workspace: "/srv/ws/abc"},
+	)
+	if !argsContain(args, "/srv/ws/abc:/workspace:ro") {
+		t.Error("expected read-only workspace mount for python")
+	}
+	if !argsContain(args, "/tmp/code.py:/sandbox/code.py:ro") {
+		t.Error("expected code file outside /workspace when a workspace is mounted")
+	}
+
+	claude, _ := d.runtimes.Get("claude")
+	args = d.buildDockerArgs("exec-6", claude,
+		"/tmp/prompt.txt", "/tmp/prompt.txt",
+		"/tmp/sandbox-exec-6", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "claude", Code: "hello", Workspace: "/srv/ws/abc"},
+	)
+	if !argsContain(args, "/srv/ws/abc:/workspace:rw") {
+		t.Error("expected read-write workspace mount for claude")
+	}
+}
+
+func TestBuildDockerArgs_ArgsAndCwd(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	py, _ := d.runtimes.Get("python")
+
+	args := d.buildDockerArgs("exec-9", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-9", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1", Args: []string{"one", "two words"}, Cwd: "/tmp"},
+	)
+	want := []string{py.Image(), "python3", "-u", "-B", "/workspace/code.py", "one", "two words"}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+	if !argsContainPair(args, "--workdir", "/tmp") {
+		t.Errorf("expected --workdir /tmp in %v", args)
+	}
+
+	// A requested cwd replaces the workspace default rather than adding to it.
+	args = d.buildDockerArgs("exec-10", py,
+		"/tmp/code.py", codeMountDir+"/code.py",
+		"/tmp/sandbox-exec-10", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1", Workspace: "/srv/ws/abc", Cwd: "/tmp"},
+	)
+	var workdirs int
+	for _, a := range args {
+		if a == "--workdir" {
+			workdirs++
+		}
+	}
+	if workdirs != 1 || !argsContainPair(args, "--workdir", "/tmp") {
+		t.Errorf("expected a single --workdir /tmp in %v", args)
+	}
+
+	args = d.buildDockerArgs("exec-11", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-11", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1"},
+	)
+	if argsContain(args, "--workdir") {
+		t.Errorf("expected the image's workdir without cwd or workspace, got %v", args)
+	}
+}
+
+func TestBuildDockerArgs_SharedMounts(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.sharedMounts = testSharedMounts(t)
+	host := d.sharedMounts["datasets"].HostPath
+
+	req := ExecutionRequest{Language: "python", Code: "1", SharedMounts: []string{"datasets"}}
+	if err := d.validateRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	python, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-7", python,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-7", "/tmp/seccomp.json", req,
+	)
+	if !argsContain(args, host+":/data/datasets:ro") {
+		t.Errorf("expected read-only shared mount in %v", args)
+	}
+	if argsContain(args, d.sharedMounts["models"].HostPath+":/data/models:ro") {
+		t.Error("unrequested shared mount was attached")
+	}
+
+	req = ExecutionRequest{Language: "node", Code: "1", SharedMounts: []string{"datasets"}}
+	if err := d.validateRequest(&req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("node with python-only mount: error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestValidateRequest_PrivilegedLimits(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	elevated := ResourceLimits{CPUShares: 512, MemoryMB: 32768, PidsLimit: 50, DiskMB: 100}
+
+	// A request's own limits are held to MaxLimits, however large.
+	req := ExecutionRequest{Language: "python", Code: "1", Limits: elevated}
+	if err := d.validateRequest(&req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("elevated limits without Privileged: error = %v, want ErrInvalidRequest", err)
+	}
+	req = ExecutionRequest{Language: "python", Code: "1", Timeout: time.Hour}
+	if err := d.validateRequest(&req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("hour timeout without Privileged: error = %v, want ErrInvalidRequest", err)
+	}
+
+	// Privileged limits replace whatever Limits the request has.
+	p, err := elevated.ValidatePrivileged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = ExecutionRequest{Language: "python", Code: "1", Timeout: time.Hour, Privileged: p, Limits: DefaultLimits()}
+	if err := d.validateRequest(&req); err != nil {
+		t.Fatalf("privileged request: %v", err)
+	}
+	if req.Limits != elevated {
+		t.Errorf("Limits = %+v, want the privileged %+v", req.Limits, elevated)
+	}
+}
+
+func TestBuildDockerArgs_ClaudeCaches(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.caches = testCaches(&fakeDocker{}, 0)
+	claude, _ := d.runtimes.Get("claude")
+	npm := cacheVolumeName("npm", "tenant") + ":/home/node/.npm:rw"
+
+	build := func(req ExecutionRequest) []string {
+		return d.buildDockerArgs("exec-8", claude,
+			"/tmp/prompt.txt", "/workspace/prompt.txt",
+			"/tmp/sandbox-exec-8", "/tmp/seccomp.json", req,
+		)
+	}
+	if args := build(ExecutionRequest{Language: "claude", Code: "hi", UseCaches: true, Tenant: "tenant"}); !argsContain(args, npm) {
+		t.Errorf("expected cache mount in %v", args)
+	}
+	if args := build(ExecutionRequest{Language: "claude", Code: "hi", Tenant: "tenant"}); argsContainPrefix(args, cacheVolumePrefix) {
+		t.Errorf("caches mounted without use_caches: %v", args)
+	}
+	if args := build(ExecutionRequest{Language: "claude", Code: "hi", UseCaches: true, ReadOnly: true, Tenant: "tenant"}); argsContainPrefix(args, cacheVolumePrefix) {
+		t.Errorf("caches mounted for a read-only request: %v", args)
+	}
+
+	tests := []struct {
+		name    string
+		req     ExecutionRequest
+		wantErr bool
+	}{
+		{"claude with caches", ExecutionRequest{Language: "claude", Code: "hi", UseCaches: true}, false},
+		{"python with caches", ExecutionRequest{Language: "python", Code: "1", UseCaches: true}, true},
+		{"read-only with caches", ExecutionRequest{Language: "claude", Code: "hi", UseCaches: true, ReadOnly: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := d.validateRequest(&req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	d.caches = nil
+	req := ExecutionRequest{Language: "claude", Code: "hi", UseCaches: true}
+	if err := d.validateRequest(&req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("use_caches without configured caches: error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestValidateRequest_Workspace(t *testing.T) {
+	root := t.TempDir()
+	ws := root + "/0b6e7c1e"
+	if err := os.Mkdir(ws, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	d := newTestRunner(0, "", []string{outside})
+	d.workspaceRoot = root
+
+	tests := []struct {
+		name    string
+		req     ExecutionRequest
+		wantErr bool
+	}{
+		{"workspace under root", ExecutionRequest{Language: "python", Code: "1", Workspace: ws}, false},
+		{"workspace outside root", ExecutionRequest{Language: "python", Code: "1", Workspace: outside}, true},
+		{"workspace with work_dir", ExecutionRequest{Language: "claude", Code: "1", Workspace: ws, WorkDir: outside}, true},
+		{"workspace root is not an allowed work_dir", ExecutionRequest{Language: "claude", Code: "1", WorkDir: ws}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := d.validateRequest(&req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	d.workspaceRoot = ""
+	req := ExecutionRequest{Language: "python", Code: "1", Workspace: ws}
+	if err := d.validateRequest(&req); err == nil {
+		t.Error("expected error when workspaces are not enabled")
+	}
+}
+
 func TestValidateRequest(t *testing.T) {
 	d := newTestRunner(0, "", []string{"/tmp"})
 
@@ -184,6 +457,11 @@ func TestValidateRequest(t *testing.T) {
 			ExecutionRequest{Language: "python", Code: "1", EnvVars: []string{"LD_PRELOAD=/lib/evil.so"}},
 			true,
 		},
+		{
+			"blocked env var prefix",
+			ExecutionRequest{Language: "claude", Code: "hello", EnvVars: []string{"anthropic_base_url=http://evil.example"}},
+			true,
+		},
 		{
 			"env var key with special chars",
 			ExecutionRequest{Language: "python", Code: "1", EnvVars: []string{"BAD;KEY=val"}},
@@ -234,40 +512,153 @@ func TestBuildDockerArgs_ClaudeDevLimits(t *testing.T) {
 
 func TestDockerRunner_ClaudeConcurrencyLimit(t *testing.T) {
 	d := &DockerRunner{
-		runtimes:  runtime.NewRegistry(),
-		sem:       make(chan struct{}, 100),
-		claudeSem: make(chan struct{}, 2),
+		runtimes: runtime.NewRegistry(),
+		slots:    newSlotLimiter(100, map[string]int{"claude": 2, defaultPool: 100}),
 	}
+	d.slots.wait = 10 * time.Millisecond
+	ctx := context.Background()
 
-	// Fill claude semaphore
-	d.claudeSem <- struct{}{}
-	d.claudeSem <- struct{}{}
+	// Fill the claude pool
+	release1, err := d.slots.acquire(ctx, "claude", slotCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.slots.acquire(ctx, "claude", slotCaller{}); err != nil {
+		t.Fatal(err)
+	}
 
-	// Verify main sem still has capacity
-	select {
-	case d.sem <- struct{}{}:
-		<-d.sem // release
-	default:
-		t.Error("main semaphore should have capacity")
+	// Verify other languages still have capacity
+	release, err := d.slots.acquire(ctx, "python", slotCaller{})
+	if err != nil {
+		t.Errorf("python should have capacity: %v", err)
+	} else {
+		release()
 	}
 
-	// Verify claude sem is full
-	select {
-	case d.claudeSem <- struct{}{}:
-		<-d.claudeSem
-		t.Error("claude semaphore should be full")
-	default:
-		// expected
+	// Verify the claude pool is full
+	if _, err := d.slots.acquire(ctx, "claude", slotCaller{}); !errors.Is(err, ErrLanguageSaturated) {
+		t.Errorf("claude pool should be full, got %v", err)
 	}
 
 	// Release one slot
-	<-d.claudeSem
+	release1()
 
 	// Now should have capacity
-	select {
-	case d.claudeSem <- struct{}{}:
-		<-d.claudeSem
-	default:
-		t.Error("claude semaphore should have capacity after release")
+	if _, err := d.slots.acquire(ctx, "claude", slotCaller{}); err != nil {
+		t.Errorf("claude pool should have capacity after release: %v", err)
+	}
+}
+
+func TestBuildDockerArgs_DenoNetworkFlag(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	rt, _ := d.runtimes.Get("deno")
+
+	args := d.buildDockerArgs("exec-deno", rt,
+		"/tmp/code.ts", "/workspace/code.ts",
+		"/tmp/sandbox-exec-deno", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "deno", Code: "1"},
+	)
+	if !argsContain(args, "--deny-net") || argsContain(args, "--allow-net") {
+		t.Error("expected --deny-net without network enabled")
+	}
+
+	args = d.buildDockerArgs("exec-deno", rt,
+		"/tmp/code.ts", "/workspace/code.ts",
+		"/tmp/sandbox-exec-deno", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "deno", Code: "1", NetworkEnabled: true},
+	)
+	if !argsContain(args, "--allow-net") {
+		t.Error("expected --allow-net with network enabled")
+	}
+}
+
+func TestBuildDockerArgs_InstanceLabel(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.instance = "instance-a"
+	rt, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-l", rt, "/tmp/code.py", "/workspace/code.py", "/tmp/sandbox-exec-l", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "print(1)"})
+	if !argsContainPair(args, "--label", "sandbox.instance=instance-a") {
+		t.Errorf("args %v lack the instance label", args)
+	}
+}
+
+func TestOrphanContainers(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(age time.Duration) string { return now.Add(-age).Format(dockerCreatedAt) }
+	// docker ps output in orphanListFormat.
+	ps := strings.Join([]string{
+		"c1\tsandbox-old\t" + at(time.Hour) + "\tinstance-a",
+		"c2\tsandbox-running\t" + at(time.Hour) + "\tinstance-a",
+		"c3\tsandbox-young\t" + at(30*time.Second) + "\tinstance-a",
+		"c4\tsandbox-other\t" + at(time.Hour) + "\tinstance-b",
+		"c5\tsandbox-legacy\t" + at(time.Hour) + "\t",
+		"c6\tnot-sandbox-x\t" + at(time.Hour) + "\tinstance-a",
+		"c7\tsandbox-bad-date\tyesterday\tinstance-a",
+	}, "\n") + "\n"
+	running := map[string]bool{"sandbox-running": true}
+
+	tests := []struct {
+		name    string
+		startup bool
+		want    []string
+	}{
+		// Only this server's containers, not running and past the grace period.
+		{"periodic", false, []string{"c1"}},
+		// Everything but a running execution's container.
+		{"startup", true, []string{"c1", "c3", "c4", "c5", "c7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := orphanContainers([]byte(ps), "instance-a", tt.startup, running, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orphans = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := orphanContainers(nil, "instance-a", true, nil, now); len(got) != 0 {
+		t.Errorf("no containers: orphans = %v", got)
+	}
+}
+
+// TestOrphanContainers_LongExecution is the sweep that tore down a live
+// claude session: its container is hours old and carries this server's
+// label, but its execution is still running.
+func TestOrphanContainers_LongExecution(t *testing.T) {
+	now := time.Now()
+	ps := "c1\tsandbox-claude\t" + now.Add(-3*time.Hour).Format(dockerCreatedAt) + "\tinstance-a\n"
+	if got := orphanContainers([]byte(ps), "instance-a", false, map[string]bool{"sandbox-claude": true}, now); len(got) != 0 {
+		t.Errorf("removed a running execution's container: %v", got)
+	}
+	if got := orphanContainers([]byte(ps), "instance-a", false, nil, now); !reflect.DeepEqual(got, []string{"c1"}) {
+		t.Errorf("finished execution's leftover: orphans = %v", got)
+	}
+}
+
+func TestBuildDockerArgs_Ulimits(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.maxUlimits = MaxUlimits()
+	py, _ := d.runtimes.Get("python")
+	build := func(limits ResourceLimits) []string {
+		return d.buildDockerArgs("exec-u", py,
+			"/tmp/code.py", "/workspace/code.py",
+			"/tmp/sandbox-exec-u", "/tmp/seccomp.json",
+			ExecutionRequest{Language: "python", Code: "print(1)", Limits: limits},
+		)
+	}
+
+	args := build(ResourceLimits{})
+	for _, want := range []string{"nofile=256:256", "nproc=50:50", "fsize=104857600:104857600", "core=0:0"} {
+		if !argsContainPair(args, "--ulimit", want) {
+			t.Errorf("default limits: missing --ulimit %s in %v", want, args)
+		}
+	}
+
+	limits := DefaultLimits()
+	limits.Ulimits = &Ulimits{NoFile: 4096}
+	args = build(limits)
+	if !argsContainPair(args, "--ulimit", "nofile=4096:4096") || !argsContainPair(args, "--ulimit", "nproc=50:50") {
+		t.Errorf("nofile override: %v", args)
 	}
 }
@@ -0,0 +1,424 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// NetworkConnection is a destination an execution connected to, as the
+// egress audit saw it in the host's conntrack table.
+type NetworkConnection struct {
+	DstIP       string `json:"dst_ip"`
+	DstPort     int    `json:"dst_port,omitempty"` // 0 for protocols without ports, such as icmp
+	Protocol    string `json:"protocol"`
+	Connections int    `json:"connections"` // distinct flows to it
+	// BytesEstimate adds up both directions of its flows as of the last
+	// read of the table: 0 where the kernel doesn't count bytes
+	// (nf_conntrack_acct), and short of the truth for a flow that ended
+	// between reads.
+	BytesEstimate int64     `json:"bytes_estimate"`
+	FirstSeen     time.Time `json:"first_seen"`
+}
+
+// NetworkAudit is what the egress audit recorded for an execution with a
+// network (sandbox.egress_audit), in the order the destinations were first
+// seen. A connection that opened and closed between two reads of the table
+// may be missed.
+type NetworkAudit struct {
+	Connections []NetworkConnection `json:"connections"`
+	Dropped     int                 `json:"dropped,omitempty"`     // flows to destinations past max_connections, not listed
+	Unavailable string              `json:"unavailable,omitempty"` // why nothing could be recorded
+}
+
+// egressWatchResolve is how often a watch looks for a docker container's
+// address until it has one.
+const egressWatchResolve = 50 * time.Millisecond
+
+// flow is a conntrack entry's original direction: the connection as the
+// container opened it.
+type flow struct {
+	proto        string
+	src, dst     netip.Addr
+	sport, dport uint16
+}
+
+// conntrackEntry is a line of the conntrack table.
+type conntrackEntry struct {
+	flow
+	bytes int64 // both directions; 0 without nf_conntrack_acct
+}
+
+// parseConntrack reads the entries of /proc/net/nf_conntrack, skipping
+// lines it can't make sense of:
+//
+//	ipv4 2 tcp 6 117 TIME_WAIT src=172.17.0.2 dst=93.184.216.34 sport=40334 dport=443 packets=12 bytes=1630 src=93.184.216.34 dst=10.0.0.5 sport=443 dport=40334 packets=10 bytes=5694 [ASSURED] mark=0 use=1
+//
+// The first src, dst, sport and dport are the original direction's; the
+// legacy ip_conntrack format, without the two leading columns, is read too.
+func parseConntrack(data []byte) []conntrackEntry {
+	var entries []conntrackEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 2 && (fields[0] == "ipv4" || fields[0] == "ipv6") {
+			fields = fields[2:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		e := conntrackEntry{flow: flow{proto: fields[0]}}
+		orig := make(map[string]string, 4)
+		for _, f := range fields[1:] {
+			key, val, ok := strings.Cut(f, "=")
+			switch {
+			case !ok:
+			case key == "bytes":
+				if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+					e.bytes += n
+				}
+			case key == "src" || key == "dst" || key == "sport" || key == "dport":
+				if _, dup := orig[key]; !dup {
+					orig[key] = val
+				}
+			}
+		}
+		src, err1 := netip.ParseAddr(orig["src"])
+		dst, err2 := netip.ParseAddr(orig["dst"])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		e.src, e.dst = src.Unmap(), dst.Unmap()
+		if sport, err := strconv.ParseUint(orig["sport"], 10, 16); err == nil {
+			e.sport = uint16(sport)
+		}
+		if dport, err := strconv.ParseUint(orig["dport"], 10, 16); err == nil {
+			e.dport = uint16(dport)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// egressAuditor reads the host's conntrack table for the executions it
+// watches: once when each watch starts, every poll interval while any run,
+// and once when each stops. A flow is only put down to a watch if it first
+// appeared after the watch started, so one left in the table by an earlier
+// container with the same address isn't. A nil auditor watches nothing.
+type egressAuditor struct {
+	cfg         config.EgressAuditConfig
+	suspicious  []netip.Prefix
+	private     []netip.Prefix
+	read        func() ([]byte, error)
+	now         func() time.Time
+	unavailable string // set when the table can't be read here at all
+
+	mu      sync.Mutex
+	reads   int          // reads of the table so far
+	seen    map[flow]int // the read each flow in the table first appeared in
+	watches map[*egressWatch]bool
+	stop    chan struct{} // ends the poll loop; nil while it isn't running
+}
+
+// newEgressAuditor returns nil when the audit is off. Ranges that don't
+// parse were refused by config.Check.
+func newEgressAuditor(cfg config.EgressAuditConfig) *egressAuditor {
+	if !cfg.Enabled {
+		return nil
+	}
+	a := &egressAuditor{
+		cfg:     cfg,
+		read:    func() ([]byte, error) { return os.ReadFile(cfg.Conntrack) },
+		now:     time.Now,
+		watches: make(map[*egressWatch]bool),
+	}
+	for _, cidr := range cfg.SuspiciousCIDRs {
+		if p, err := netip.ParsePrefix(cidr); err == nil {
+			a.suspicious = append(a.suspicious, p.Masked())
+		}
+	}
+	for _, cidr := range cfg.PrivateCIDRs {
+		if p, err := netip.ParsePrefix(cidr); err == nil {
+			a.private = append(a.private, p.Masked())
+		}
+	}
+	if goruntime.GOOS != "linux" {
+		a.unavailable = "connection tracking is only read on Linux hosts"
+	}
+	return a
+}
+
+// watch starts auditing an execution. Its container's address is set with
+// setIP or resolve once it has one. internetOnly flags its connections into
+// the private ranges too.
+func (a *egressAuditor) watch(internetOnly bool) *egressWatch {
+	if a == nil {
+		return nil
+	}
+	w := &egressWatch{a: a, internetOnly: internetOnly, flows: make(map[flow]int64), dests: make(map[flow]*NetworkConnection)}
+	if a.unavailable != "" {
+		w.unavailable = a.unavailable
+		return w
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The read that sets the watch's baseline: what is in the table now
+	// isn't the execution's.
+	if err := a.sample(); err != nil {
+		w.unavailable = fmt.Sprintf("reading the conntrack table: %v", err)
+		return w
+	}
+	w.since = a.reads
+	a.watches[w] = true
+	if a.stop == nil {
+		a.stop = make(chan struct{})
+		go a.poll(a.stop)
+	}
+	return w
+}
+
+// poll reads the table every poll interval until stop is closed.
+func (a *egressAuditor) poll(stop chan struct{}) {
+	ticker := time.NewTicker(a.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		if err := a.sample(); err != nil {
+			log.Debug().Err(err).Msg("egress audit: reading the conntrack table failed")
+		}
+		a.mu.Unlock()
+	}
+}
+
+// sample reads the table and passes each watch with an address the flows
+// from it that are new since it started. Callers hold a.mu.
+func (a *egressAuditor) sample() error {
+	data, err := a.read()
+	if err != nil {
+		return err
+	}
+	entries := parseConntrack(data)
+	now := a.now()
+	a.reads++
+	// Kept only for flows still in the table, which bounds it by the
+	// table's size.
+	seen := make(map[flow]int, len(entries))
+	for _, e := range entries {
+		if read, ok := a.seen[e.flow]; ok {
+			seen[e.flow] = read
+		} else {
+			seen[e.flow] = a.reads
+		}
+	}
+	a.seen = seen
+
+	for w := range a.watches {
+		if !w.ip.IsValid() {
+			continue
+		}
+		var mine []conntrackEntry
+		for _, e := range entries {
+			if e.src == w.ip && seen[e.flow] > w.since {
+				mine = append(mine, e)
+			}
+		}
+		w.observe(mine, now)
+	}
+	return nil
+}
+
+// egressWatch collects the connections of one execution. A nil watch, for
+// an execution that isn't audited, records nothing.
+type egressWatch struct {
+	a            *egressAuditor
+	internetOnly bool
+	unavailable  string
+
+	// Guarded by a.mu.
+	since   int        // the auditor's read that set the baseline
+	ip      netip.Addr // the container's; invalid until known
+	stopped bool
+	flows   map[flow]int64              // bytes of each flow at its last read
+	dests   map[flow]*NetworkConnection // by protocol, address and port, the ports and source unset
+	order   []flow                      // dests' keys by first seen
+	dropped int
+}
+
+// setIP gives the watch its container's address.
+func (w *egressWatch) setIP(ip netip.Addr) {
+	if w == nil || w.unavailable != "" {
+		return
+	}
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	if !w.stopped {
+		w.ip = ip.Unmap()
+	}
+}
+
+// resolve looks the container's address up until it has one, the watch has
+// stopped or ctx is done: a docker container only has one once it is
+// running. Flows from before then aren't missed, being still in the table.
+func (w *egressWatch) resolve(ctx context.Context, lookup func(ctx context.Context) (netip.Addr, error)) {
+	if w == nil || w.unavailable != "" {
+		return
+	}
+	ticker := time.NewTicker(egressWatchResolve)
+	defer ticker.Stop()
+	for {
+		if ip, err := lookup(ctx); err == nil && ip.IsValid() {
+			w.setIP(ip)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.a.mu.Lock()
+		stopped := w.stopped
+		w.a.mu.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+// observe adds a read's flows from the container, all new since the
+// watch's baseline. Callers hold a.mu.
+func (w *egressWatch) observe(entries []conntrackEntry, now time.Time) {
+	flows := make(map[flow]int64, len(entries))
+	for _, e := range entries {
+		prev, known := w.flows[e.flow]
+		flows[e.flow] = max(prev, e.bytes)
+		key := flow{proto: e.proto, dst: e.dst, dport: e.dport}
+		c := w.dests[key]
+		if c == nil {
+			if len(w.dests) >= w.a.cfg.MaxConnections {
+				if !known {
+					w.dropped++
+				}
+				continue
+			}
+			c = &NetworkConnection{DstIP: e.dst.String(), DstPort: int(e.dport), Protocol: e.proto, FirstSeen: now}
+			w.dests[key] = c
+			w.order = append(w.order, key)
+		}
+		if !known {
+			c.Connections++
+		}
+		if e.bytes > prev {
+			c.BytesEstimate += e.bytes - prev
+		}
+	}
+	// A flow gone from the table is forgotten, its bytes already counted.
+	w.flows = flows
+}
+
+// Stop reads the table a last time and ends the watch. It returns what was
+// recorded, and a security event for each destination in a flagged range.
+func (w *egressWatch) Stop() (*NetworkAudit, []SecurityEvent) {
+	if w == nil {
+		return nil, nil
+	}
+	if w.unavailable != "" {
+		return &NetworkAudit{Connections: []NetworkConnection{}, Unavailable: w.unavailable}, nil
+	}
+
+	a := w.a
+	a.mu.Lock()
+	if w.ip.IsValid() {
+		if err := a.sample(); err != nil {
+			log.Debug().Err(err).Msg("egress audit: reading the conntrack table failed")
+		}
+	}
+	w.stopped = true
+	delete(a.watches, w)
+	if len(a.watches) == 0 && a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+		a.seen = nil
+	}
+	a.mu.Unlock()
+
+	audit := &NetworkAudit{Connections: make([]NetworkConnection, 0, len(w.order)), Dropped: w.dropped}
+	if !w.ip.IsValid() {
+		audit.Unavailable = "the container's address was never found"
+		return audit, nil
+	}
+	var events []SecurityEvent
+	for _, key := range w.order {
+		c := *w.dests[key]
+		audit.Connections = append(audit.Connections, c)
+		if event, ok := a.flag(key, c, w.internetOnly); ok {
+			events = append(events, event)
+		}
+	}
+	return audit, events
+}
+
+// flag returns the security event for a connection into a suspicious range,
+// or, for an execution that said it needs only the internet, a private one.
+// DNS is let through to private ranges: the container's resolver is often
+// at a private address.
+func (a *egressAuditor) flag(key flow, c NetworkConnection, internetOnly bool) (SecurityEvent, bool) {
+	dst := netip.AddrPortFrom(key.dst, key.dport).String()
+	if c.DstPort == 0 {
+		dst = c.DstIP
+	}
+	if i := slices.IndexFunc(a.suspicious, func(p netip.Prefix) bool { return p.Contains(key.dst) }); i >= 0 {
+		return SecurityEvent{
+			Type:     "suspicious_egress",
+			Source:   SourceRuntime,
+			Severity: "high",
+			Detail:   fmt.Sprintf("%s connection to %s, in suspicious range %s", c.Protocol, dst, a.suspicious[i]),
+		}, true
+	}
+	if !internetOnly || c.DstPort == 53 {
+		return SecurityEvent{}, false
+	}
+	if i := slices.IndexFunc(a.private, func(p netip.Prefix) bool { return p.Contains(key.dst) }); i >= 0 {
+		return SecurityEvent{
+			Type:     "private_egress",
+			Source:   SourceRuntime,
+			Severity: "medium",
+			Detail:   fmt.Sprintf("%s connection to %s, in private range %s, from an internet_only execution", c.Protocol, dst, a.private[i]),
+		}, true
+	}
+	return SecurityEvent{}, false
+}
+
+// dockerContainerIP looks up container's address on its first network, once
+// it is running.
+func dockerContainerIP(docker func(ctx context.Context, args ...string) ([]byte, error), container string) func(ctx context.Context) (netip.Addr, error) {
+	return func(ctx context.Context) (netip.Addr, error) {
+		out, err := docker(ctx, "inspect", "--format", "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}", container)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return netip.Addr{}, fmt.Errorf("container %s has no address yet", container)
+		}
+		return netip.ParseAddr(fields[0])
+	}
+}
@@ -0,0 +1,180 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// conntrackLine is an nf_conntrack entry for a tcp flow, with accounting.
+func conntrackLine(src, dst string, sport, dport, bytes int) string {
+	return fmt.Sprintf("ipv4     2 tcp      6 117 ESTABLISHED src=%s dst=%s sport=%d dport=%d packets=3 bytes=%d "+
+		"src=%s dst=10.0.0.5 sport=%d dport=%d packets=2 bytes=0 [ASSURED] mark=0 zone=0 use=2\n",
+		src, dst, sport, dport, bytes, dst, dport, sport)
+}
+
+// fakeConntrack is a conntrack table the test rewrites between reads.
+type fakeConntrack struct {
+	table string
+	err   error
+}
+
+func (f *fakeConntrack) read() ([]byte, error) { return []byte(f.table), f.err }
+
+func newTestAuditor(t *testing.T, table *fakeConntrack, max int) *egressAuditor {
+	t.Helper()
+	cfg := config.DefaultConfig().Sandbox.EgressAudit
+	cfg.Enabled = true
+	cfg.MaxConnections = max
+	cfg.PollInterval = time.Hour // the test reads the table itself
+	a := newEgressAuditor(cfg)
+	a.unavailable = ""
+	a.read = table.read
+	clock := time.Unix(1000, 0)
+	a.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+	return a
+}
+
+func (a *egressAuditor) tick() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sample()
+}
+
+func TestParseConntrack(t *testing.T) {
+	data := `ipv4     2 tcp      6 117 TIME_WAIT src=172.17.0.2 dst=93.184.216.34 sport=40334 dport=443 packets=12 bytes=1630 src=93.184.216.34 dst=10.0.0.5 sport=443 dport=40334 packets=10 bytes=5694 [ASSURED] mark=0 use=1
+ipv4     2 udp      17 29 src=172.17.0.2 dst=8.8.8.8 sport=5353 dport=53 [UNREPLIED] src=8.8.8.8 dst=10.0.0.5 sport=53 dport=5353 mark=0 use=2
+ipv4     2 icmp     1 29 src=172.17.0.2 dst=1.1.1.1 type=8 code=0 id=7 src=1.1.1.1 dst=10.0.0.5 type=0 code=0 id=7 mark=0 use=2
+ipv6     10 tcp      6 60 SYN_SENT src=fd00::2 dst=2606:4700::1111 sport=50000 dport=80 [UNREPLIED] src=2606:4700::1111 dst=fd00::2 sport=80 dport=50000 mark=0 use=1
+tcp      6 30 SYN_SENT src=172.17.0.3 dst=10.1.2.3 sport=1 dport=22 src=10.1.2.3 dst=172.17.0.3 sport=22 dport=1
+garbage
+ipv4 2
+`
+	entries := parseConntrack([]byte(data))
+	if len(entries) != 5 {
+		t.Fatalf("parsed %d entries, want 5: %+v", len(entries), entries)
+	}
+	first := entries[0]
+	want := flow{proto: "tcp", src: netip.MustParseAddr("172.17.0.2"), dst: netip.MustParseAddr("93.184.216.34"), sport: 40334, dport: 443}
+	if first.flow != want || first.bytes != 1630+5694 {
+		t.Errorf("tcp entry %+v, want %+v with 7324 bytes", first, want)
+	}
+	if udp := entries[1]; udp.proto != "udp" || udp.dport != 53 || udp.bytes != 0 {
+		t.Errorf("udp entry %+v", udp)
+	}
+	if icmp := entries[2]; icmp.proto != "icmp" || icmp.dport != 0 || icmp.dst.String() != "1.1.1.1" {
+		t.Errorf("icmp entry %+v", icmp)
+	}
+	if v6 := entries[3]; v6.dst.String() != "2606:4700::1111" || v6.dport != 80 {
+		t.Errorf("ipv6 entry %+v", v6)
+	}
+	if legacy := entries[4]; legacy.src.String() != "172.17.0.3" || legacy.dport != 22 {
+		t.Errorf("ip_conntrack entry %+v", legacy)
+	}
+}
+
+func TestEgressWatch_Aggregates(t *testing.T) {
+	const ip = "172.17.0.2"
+	leftover := conntrackLine(ip, "203.0.113.9", 1, 443, 100) // an earlier container's, at the same address
+	table := &fakeConntrack{table: leftover}
+	a := newTestAuditor(t, table, 2)
+
+	w := a.watch(false)
+	w.setIP(netip.MustParseAddr(ip))
+	table.table = leftover +
+		conntrackLine(ip, "93.184.216.34", 2, 443, 500) +
+		conntrackLine(ip, "93.184.216.34", 3, 443, 200) +
+		conntrackLine("172.17.0.9", "93.184.216.34", 4, 443, 999) // another container's
+	a.tick()
+	// The first flow has grown and the second has gone; a third destination
+	// is past max_connections.
+	table.table = conntrackLine(ip, "93.184.216.34", 2, 443, 800) +
+		conntrackLine(ip, "198.51.100.7", 5, 80, 40) +
+		conntrackLine(ip, "198.51.100.8", 6, 80, 40)
+	a.tick()
+
+	audit, events := w.Stop()
+	if audit.Unavailable != "" || len(events) != 0 {
+		t.Fatalf("audit %+v, events %+v", audit, events)
+	}
+	if len(audit.Connections) != 2 || audit.Dropped != 1 {
+		t.Fatalf("connections %+v, dropped %d; want 2 listed and 1 dropped", audit.Connections, audit.Dropped)
+	}
+	web := audit.Connections[0]
+	if web.DstIP != "93.184.216.34" || web.DstPort != 443 || web.Protocol != "tcp" || web.Connections != 2 || web.BytesEstimate != 1000 {
+		t.Errorf("first destination %+v, want 2 connections and 1000 bytes to 93.184.216.34:443", web)
+	}
+	if next := audit.Connections[1]; next.DstIP != "198.51.100.7" || !next.FirstSeen.After(web.FirstSeen) {
+		t.Errorf("second destination %+v, first seen after %s", next, web.FirstSeen)
+	}
+	if len(a.watches) != 0 || a.stop != nil {
+		t.Error("the poll loop is still running with no watches")
+	}
+}
+
+func TestEgressWatch_Flags(t *testing.T) {
+	const ip = "172.17.0.2"
+	table := &fakeConntrack{}
+	a := newTestAuditor(t, table, 10)
+	flows := conntrackLine(ip, "169.254.169.254", 1, 80, 0) +
+		conntrackLine(ip, "10.0.0.8", 2, 5432, 0) +
+		conntrackLine(ip, "192.168.1.1", 3, 53, 0) +
+		conntrackLine(ip, "93.184.216.34", 4, 443, 0)
+
+	for _, tt := range []struct {
+		internetOnly bool
+		want         []string
+	}{
+		{false, []string{"suspicious_egress"}},
+		{true, []string{"suspicious_egress", "private_egress"}},
+	} {
+		table.table = ""
+		w := a.watch(tt.internetOnly)
+		w.setIP(netip.MustParseAddr(ip))
+		table.table = flows
+		audit, events := w.Stop()
+		if len(audit.Connections) != 4 {
+			t.Fatalf("internet_only %t: connections %+v", tt.internetOnly, audit.Connections)
+		}
+		var got []string
+		for _, ev := range events {
+			got = append(got, ev.Type)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("internet_only %t: events %+v, want %v", tt.internetOnly, events, tt.want)
+		}
+		if !strings.Contains(events[0].Detail, "tcp connection to 169.254.169.254:80, in suspicious range 169.254.0.0/16") || events[0].Severity != "high" {
+			t.Errorf("metadata event %+v", events[0])
+		}
+	}
+}
+
+func TestEgressWatch_Unavailable(t *testing.T) {
+	var nilAuditor *egressAuditor
+	if audit, events := nilAuditor.watch(false).Stop(); audit != nil || events != nil {
+		t.Errorf("audit off: %+v, %+v", audit, events)
+	}
+
+	table := &fakeConntrack{err: errors.New("permission denied")}
+	a := newTestAuditor(t, table, 10)
+	audit, _ := a.watch(false).Stop()
+	if audit == nil || audit.Connections == nil || !strings.Contains(audit.Unavailable, "permission denied") {
+		t.Errorf("unreadable table: %+v", audit)
+	}
+
+	table.err = nil
+	audit, _ = a.watch(false).Stop()
+	if !strings.Contains(audit.Unavailable, "address was never found") {
+		t.Errorf("no address: %+v", audit)
+	}
+
+	a.unavailable = "the docker daemon isn't local"
+	if audit, _ := a.watch(false).Stop(); audit.Unavailable != a.unavailable {
+		t.Errorf("remote daemon: %+v", audit)
+	}
+}
@@ -0,0 +1,90 @@
+package sandbox
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// validateEnvVars checks a request's env vars: KEY=VALUE, with keys
+// config.CheckEnvKey allows.
+func validateEnvVars(envs []string) error {
+	for _, env := range envs {
+		key, _, ok := strings.Cut(env, "=")
+		if !ok {
+			return fmt.Errorf("%w: env var must be KEY=VALUE format", ErrInvalidRequest)
+		}
+		if err := config.CheckEnvKey(key); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	}
+	return nil
+}
+
+// defaultEnv is sandbox.default_env with its languages made canonical.
+type defaultEnv struct {
+	global    map[string]string
+	languages map[string]map[string]string
+}
+
+// newDefaultEnv resolves sandbox.default_env at startup: every language
+// must be a registered runtime, named once whatever its alias.
+func newDefaultEnv(cfg config.DefaultEnvConfig, runtimes *runtime.Registry) (*defaultEnv, error) {
+	if len(cfg.Global) == 0 && len(cfg.Languages) == 0 {
+		return nil, nil
+	}
+	env := &defaultEnv{global: cfg.Global, languages: make(map[string]map[string]string, len(cfg.Languages))}
+	for lang, vars := range cfg.Languages {
+		name, ok := runtimes.Canonical(lang)
+		if !ok {
+			return nil, fmt.Errorf("unknown language %q", lang)
+		}
+		if _, dup := env.languages[name]; dup {
+			return nil, fmt.Errorf("language %q is given more than once, under its aliases", name)
+		}
+		env.languages[name] = vars
+	}
+	return env, nil
+}
+
+// apply returns the env of an execution of language: the configured
+// defaults its request doesn't set, sorted, then the request's own.
+func (e *defaultEnv) apply(language string, request []string) []string {
+	if e == nil {
+		return request
+	}
+	vars := maps.Clone(e.global)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	maps.Copy(vars, e.languages[language])
+	for _, env := range request {
+		key, _, _ := strings.Cut(env, "=")
+		delete(vars, key)
+	}
+	out := make([]string, 0, len(vars)+len(request))
+	for _, key := range slices.Sorted(maps.Keys(vars)) {
+		out = append(out, key+"="+vars[key])
+	}
+	return append(out, request...)
+}
+
+// envKeys returns the names of env, for the environment report: the
+// values may be secrets.
+func envKeys(env []string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(env))
+	for _, v := range env {
+		key, _, _ := strings.Cut(v, "=")
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func testDefaultEnv(t *testing.T) *defaultEnv {
+	t.Helper()
+	env, err := newDefaultEnv(config.DefaultEnvConfig{
+		Global: map[string]string{"TZ": "UTC", "MPLBACKEND": "Agg"},
+		Languages: map[string]map[string]string{
+			"py":   {"PYTHONDONTWRITEBYTECODE": "1", "MPLBACKEND": "svg"},
+			"node": {"NODE_ENV": "sandbox"},
+		},
+	}, runtime.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestDefaultEnv_Apply(t *testing.T) {
+	env := testDefaultEnv(t)
+	tests := []struct {
+		language string
+		request  []string
+		want     []string
+	}{
+		{"bash", nil, []string{"MPLBACKEND=Agg", "TZ=UTC"}},
+		{"python", nil, []string{"MPLBACKEND=svg", "PYTHONDONTWRITEBYTECODE=1", "TZ=UTC"}},
+		{"python", []string{"TZ=Europe/Paris", "DEBUG=1"}, []string{"MPLBACKEND=svg", "PYTHONDONTWRITEBYTECODE=1", "TZ=Europe/Paris", "DEBUG=1"}},
+		{"node", []string{"NODE_ENV=production"}, []string{"MPLBACKEND=Agg", "TZ=UTC", "NODE_ENV=production"}},
+		// Keys are case-sensitive, as the container's environment is.
+		{"bash", []string{"tz=x"}, []string{"MPLBACKEND=Agg", "TZ=UTC", "tz=x"}},
+	}
+	for _, tt := range tests {
+		if got := env.apply(tt.language, tt.request); !slices.Equal(got, tt.want) {
+			t.Errorf("%s %q: env %q, want %q", tt.language, tt.request, got, tt.want)
+		}
+	}
+
+	var none *defaultEnv
+	if got := none.apply("python", []string{"A=1"}); !slices.Equal(got, []string{"A=1"}) {
+		t.Errorf("without default_env: env %q", got)
+	}
+	if got := envKeys([]string{"TZ=UTC", "A=1=2", "TZ=x"}); !slices.Equal(got, []string{"TZ", "A"}) {
+		t.Errorf("envKeys = %q", got)
+	}
+}
+
+func TestNewDefaultEnv_Languages(t *testing.T) {
+	if env, err := newDefaultEnv(config.DefaultEnvConfig{}, runtime.NewRegistry()); env != nil || err != nil {
+		t.Errorf("unset: %v, %v; want nil", env, err)
+	}
+	tests := []struct {
+		languages map[string]map[string]string
+		wantErr   string
+	}{
+		{map[string]map[string]string{"cobol": {"A": "1"}}, `unknown language "cobol"`},
+		{map[string]map[string]string{"python": {"A": "1"}, "py": {"B": "2"}}, `language "python" is given more than once`},
+	}
+	for _, tt := range tests {
+		_, err := newDefaultEnv(config.DefaultEnvConfig{Languages: tt.languages}, runtime.NewRegistry())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%v: error = %v, want %q", tt.languages, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDefaultEnv_Backends(t *testing.T) {
+	req := func() *ExecutionRequest {
+		return &ExecutionRequest{Language: "python", Code: "print(1)", EnvVars: []string{"TZ=Asia/Tokyo"}}
+	}
+	want := []string{"MPLBACKEND=svg", "PYTHONDONTWRITEBYTECODE=1", "TZ=Asia/Tokyo"}
+
+	d := newTestRunner(0, "", nil)
+	d.defaultEnv = testDefaultEnv(t)
+	dreq := req()
+	if err := d.validateRequest(dreq); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-1", rt, "/tmp/code.py", "/workspace/code.py", "/tmp/sandbox-exec-1", "/tmp/seccomp.json", *dreq)
+	var env []string
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "-e" {
+			env = append(env, args[i+1])
+		}
+	}
+	if len(env) < len(want) || !slices.Equal(env[len(env)-len(want):], want) {
+		t.Errorf("docker: env %q, want it to end %q", env, want)
+	}
+
+	r := &Runner{runtimes: runtime.NewRegistry(), defaultEnv: testDefaultEnv(t)}
+	creq := req()
+	if err := r.validateRequest(creq); err != nil {
+		t.Fatal(err)
+	}
+	if env := containerdEnv(*creq, nil); !slices.Equal(env[len(env)-len(want):], want) || !slices.Contains(env, "HOME=/tmp") {
+		t.Errorf("containerd: env %q, want the base then %q", env, want)
+	}
+}
+
+func TestValidateEnvVars(t *testing.T) {
+	r := &Runner{runtimes: runtime.NewRegistry()}
+	for _, env := range []string{"LD_PRELOAD=/lib/evil.so", "claude_code_x=1", "NOEQUALS", "BAD-KEY=1", "=1"} {
+		if err := r.validateRequest(&ExecutionRequest{Language: "bash", Code: "env", EnvVars: []string{env}}); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("containerd %q: error = %v, want ErrInvalidRequest", env, err)
+		}
+	}
+	if err := validateEnvVars([]string{"DEBUG=1", "EMPTY=", "EQ=a=b"}); err != nil {
+		t.Errorf("valid env: %v", err)
+	}
+}
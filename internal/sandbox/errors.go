@@ -7,14 +7,25 @@ import (
 
 // Sentinel errors for typed error checking.
 var (
-	ErrTimeout          = errors.New("execution timed out")
-	ErrOOM              = errors.New("out of memory")
-	ErrPidLimit         = errors.New("pid limit exceeded")
-	ErrSecurityViolation = errors.New("security violation detected")
-	ErrContainerdDown   = errors.New("containerd unavailable")
-	ErrPoolExhausted    = errors.New("container pool exhausted")
-	ErrInvalidRequest   = errors.New("invalid execution request")
-	ErrUnsupportedLang  = errors.New("unsupported language")
+	ErrTimeout                = errors.New("execution timed out")
+	ErrSetupTimeout           = errors.New("execution setup timed out before the code started")
+	ErrIdleTimeout            = errors.New("no output within idle_output_timeout")
+	ErrCPUAbuse               = errors.New("cpu quota saturated past sandbox.cpu_abuse")
+	ErrOOM                    = errors.New("out of memory")
+	ErrPidLimit               = errors.New("pid limit exceeded")
+	ErrSecurityViolation      = errors.New("security violation detected")
+	ErrContainerdDown         = errors.New("containerd unavailable")
+	ErrPoolExhausted          = errors.New("container pool exhausted")
+	ErrInvalidRequest         = errors.New("invalid execution request")
+	ErrUnsupportedLang        = errors.New("unsupported language")
+	ErrRateLimited            = errors.New("rate limited")
+	ErrLanguageSaturated      = errors.New("language concurrency pool saturated")
+	ErrWorkdirBusy            = errors.New("work_dir in use by another execution")
+	ErrWorkdirTooLarge        = errors.New("work_dir too large")
+	ErrWorkdirDirty           = errors.New("work_dir has uncommitted changes")
+	ErrSeccompNotApplied      = errors.New("seccomp filter not applied")
+	ErrCodeIntegrity          = errors.New("code file failed its integrity check")
+	ErrSeccompProfileTampered = errors.New("seccomp profile file failed verification")
 )
 
 // ExecutionError wraps errors with execution context.
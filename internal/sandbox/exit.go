@@ -0,0 +1,145 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExitClass explains why an execution stopped, separating exits chosen by the
+// user program from ones caused by the sandbox or the container runtime.
+type ExitClass string
+
+const (
+	ExitUser        ExitClass = "user_exit"      // program exited on its own
+	ExitOOMKill     ExitClass = "oom_kill"       // kernel OOM killer fired inside the cgroup
+	ExitTimeoutKill ExitClass = "timeout_kill"   // we killed it after the timeout elapsed
+	ExitIdleTimeout ExitClass = "idle_timeout"   // we killed it after idle_output_timeout passed with no output
+	ExitManualKill  ExitClass = "manual_kill"    // killed on request (cancel, kill API, docker kill)
+	ExitCPUAbuse    ExitClass = "resource_abuse" // we killed it for saturating its CPU quota (sandbox.cpu_abuse)
+	ExitInfraError  ExitClass = "infra_error"    // container could not start or command not invocable
+)
+
+// ExitSignal returns the class for a process terminated by signal n.
+func ExitSignal(n int) ExitClass {
+	return ExitClass(fmt.Sprintf("signal:%d", n))
+}
+
+// IsSignal reports whether the class is a signal:<n> class.
+func (c ExitClass) IsSignal() bool {
+	return strings.HasPrefix(string(c), "signal:")
+}
+
+// killReason records which kill path the runner itself took, if any.
+type killReason int
+
+const (
+	killNone killReason = iota
+	killTimeout
+	killIdle
+	killManual
+	killCPUAbuse
+)
+
+// exitInfo is everything a backend knows about how a container process ended.
+type exitInfo struct {
+	code      int
+	killed    killReason
+	oomKilled bool   // from cgroup OOM counters or docker inspect
+	stderr    string // docker CLI stderr (daemon errors are printed here)
+}
+
+// Exit code 128+9: SIGKILL, the code runc reports for OOM kills and docker kill alike.
+const exitCodeSIGKILL = 137
+
+// dockerInfraMarkers are prefixes the docker CLI and runc use for their own
+// errors, as opposed to anything the user program wrote to stderr.
+var dockerInfraMarkers = []string{
+	"docker: Error response from daemon",
+	"Error response from daemon",
+	"OCI runtime create failed",
+	"OCI runtime exec failed",
+	"failed to create task for container",
+}
+
+// classifyExit derives an ExitClass. Kills we sent take precedence over
+// everything else, so a timeout is never reported as an OOM. A bare 137 without
+// an OOM signal from the cgroup is treated as an external kill, not an OOM.
+func classifyExit(info exitInfo) ExitClass {
+	switch info.killed {
+	case killTimeout:
+		return ExitTimeoutKill
+	case killIdle:
+		return ExitIdleTimeout
+	case killManual:
+		return ExitManualKill
+	case killCPUAbuse:
+		return ExitCPUAbuse
+	}
+	if info.oomKilled {
+		return ExitOOMKill
+	}
+	if info.code >= 125 && info.code <= 127 && isDockerInfraError(info.stderr) {
+		return ExitInfraError
+	}
+	if info.code == exitCodeSIGKILL {
+		return ExitManualKill
+	}
+	// Shells and runc report death-by-signal as 128+n. A program can also exit
+	// with such a code deliberately; we can't tell the two apart.
+	if info.code > 128 && info.code < 128+32 {
+		return ExitSignal(info.code - 128)
+	}
+	return ExitUser
+}
+
+func isDockerInfraError(stderr string) bool {
+	for _, m := range dockerInfraMarkers {
+		if strings.Contains(stderr, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupRoot is where the host cgroup filesystem is mounted. Overridden in tests.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupOOMKilled reports whether the kernel OOM killer fired in the cgroup
+// containerd created for namespace/id. It checks cgroup v2 memory.events first,
+// then the v1 memory controller. Returns false when neither is readable.
+func cgroupOOMKilled(namespace, id string) bool {
+	candidates := []string{
+		filepath.Join(cgroupRoot, namespace, id, "memory.events"),
+		filepath.Join(cgroupRoot, "memory", namespace, id, "memory.oom_control"),
+	}
+	for _, path := range candidates {
+		data, err := os.ReadFile(filepath.Clean(path)) // #nosec G304 -- path built from our namespace and exec ID
+		if err != nil {
+			continue
+		}
+		return parseOOMKillCount(data) > 0
+	}
+	return false
+}
+
+// parseOOMKillCount extracts the "oom_kill N" counter present in both
+// memory.events (v2) and memory.oom_control (v1).
+func parseOOMKillCount(data []byte) int64 {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
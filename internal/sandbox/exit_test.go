@@ -0,0 +1,122 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyExit(t *testing.T) {
+	tests := []struct {
+		name string
+		info exitInfo
+		want ExitClass
+	}{
+		{"clean exit", exitInfo{code: 0}, ExitUser},
+		{"user error exit", exitInfo{code: 1}, ExitUser},
+		{"user exit 127 from script", exitInfo{code: 127, stderr: "sh: foo: not found"}, ExitUser},
+		{"timeout kill", exitInfo{code: -1, killed: killTimeout}, ExitTimeoutKill},
+		{"timeout kill wins over 137", exitInfo{code: 137, killed: killTimeout}, ExitTimeoutKill},
+		{"timeout kill wins over oom flag", exitInfo{code: 137, killed: killTimeout, oomKilled: true}, ExitTimeoutKill},
+		{"idle kill", exitInfo{code: -1, killed: killIdle}, ExitIdleTimeout},
+		{"idle kill wins over oom flag", exitInfo{code: 137, killed: killIdle, oomKilled: true}, ExitIdleTimeout},
+		{"manual kill", exitInfo{code: -1, killed: killManual}, ExitManualKill},
+		{"cpu abuse kill", exitInfo{code: -1, killed: killCPUAbuse}, ExitCPUAbuse},
+		{"oom from cgroup", exitInfo{code: 137, oomKilled: true}, ExitOOMKill},
+		{"137 without oom is external kill", exitInfo{code: 137}, ExitManualKill},
+		{
+			"docker daemon error",
+			exitInfo{code: 125, stderr: "docker: Error response from daemon: No such image: foo."},
+			ExitInfraError,
+		},
+		{
+			"command not invocable",
+			exitInfo{code: 126, stderr: "OCI runtime create failed: permission denied: unknown"},
+			ExitInfraError,
+		},
+		{
+			"command not found in image",
+			exitInfo{code: 127, stderr: "docker: Error response from daemon: failed to create task for container: exec: \"python3\": executable file not found in $PATH"},
+			ExitInfraError,
+		},
+		{"sigterm", exitInfo{code: 143}, ExitSignal(15)},
+		{"sigsegv", exitInfo{code: 139}, ExitSignal(11)},
+		{"above signal range", exitInfo{code: 200}, ExitUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyExit(tt.info); got != tt.want {
+				t.Errorf("classifyExit(%+v) = %q, want %q", tt.info, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitClass_IsSignal(t *testing.T) {
+	if !ExitSignal(9).IsSignal() {
+		t.Error("signal:9 should be a signal class")
+	}
+	if ExitOOMKill.IsSignal() {
+		t.Error("oom_kill should not be a signal class")
+	}
+	if got := ExitSignal(15); got != "signal:15" {
+		t.Errorf("ExitSignal(15) = %q, want signal:15", got)
+	}
+}
+
+func TestParseOOMKillCount(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int64
+	}{
+		{"v2 memory.events with kill", "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n", 1},
+		{"v2 memory.events clean", "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n", 0},
+		{"v1 oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 3\n", 3},
+		{"missing counter", "oom_kill_disable 0\n", 0},
+		{"garbage", "oom_kill lots\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseOOMKillCount([]byte(tt.data)); got != tt.want {
+				t.Errorf("parseOOMKillCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCgroupOOMKilled(t *testing.T) {
+	root := t.TempDir()
+	orig := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = orig })
+
+	// v2 layout
+	v2 := filepath.Join(root, "sandbox", "sandbox-a")
+	if err := os.MkdirAll(v2, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(v2, "memory.events"), []byte("oom 1\noom_kill 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// v1 layout
+	v1 := filepath.Join(root, "memory", "sandbox", "sandbox-b")
+	if err := os.MkdirAll(v1, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(v1, "memory.oom_control"), []byte("under_oom 0\noom_kill 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cgroupOOMKilled("sandbox", "sandbox-a") {
+		t.Error("expected OOM kill from v2 memory.events")
+	}
+	if cgroupOOMKilled("sandbox", "sandbox-b") {
+		t.Error("expected no OOM kill from v1 oom_control")
+	}
+	if cgroupOOMKilled("sandbox", "sandbox-missing") {
+		t.Error("missing cgroup should report no OOM kill")
+	}
+}
@@ -0,0 +1,248 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // zone names validate the same whatever the host has installed
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sandboxUID  = "65534" // what non-claude executions run as, group included
+	sandboxHome = "/tmp"  // their HOME
+	// defaultLocale is LANG when a request doesn't set a locale.
+	defaultLocale = "C.UTF-8"
+	zoneinfoDir   = "/usr/share/zoneinfo"
+)
+
+// Where an execution's passwd entry for the sandbox user came from, as
+// ExecutionResult.Passwd reports it.
+const (
+	PasswdGenerated = "generated" // the runner's, mounted over /etc/passwd and /etc/group
+	PasswdImage     = "image"     // the image had one
+)
+
+// localePattern allows the UTF-8 locales: C, a language or a language and
+// territory, and an optional modifier, such as de_DE.UTF-8 or
+// sr_RS.UTF-8@latin.
+var localePattern = regexp.MustCompile(`^(C|[a-z]{2,3}(_[A-Z]{2})?)\.(UTF-8|utf8)(@[a-z]+)?$`)
+
+// validateLocale checks a request's timezone, an IANA zone name, and its
+// locale, a UTF-8 one. Whether the image has the zone is the runner's to
+// check.
+func validateLocale(req *ExecutionRequest) error {
+	if req.Timezone != "" {
+		if req.Timezone == "Local" {
+			return fmt.Errorf("%w: timezone %q is not a zone name", ErrInvalidRequest, req.Timezone)
+		}
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidRequest, req.Timezone)
+		}
+	}
+	if req.Locale != "" && !localePattern.MatchString(req.Locale) {
+		return fmt.Errorf("%w: locale %q is not a UTF-8 locale such as C.UTF-8 or en_US.UTF-8", ErrInvalidRequest, req.Locale)
+	}
+	return nil
+}
+
+// localeEnv returns env less TZ and LANG when the request sets them with
+// timezone and locale, which take their place.
+func localeEnv(req ExecutionRequest, env []string) []string {
+	if req.Timezone == "" && req.Locale == "" {
+		return env
+	}
+	out := make([]string, 0, len(env))
+	for _, v := range env {
+		key, _, _ := strings.Cut(v, "=")
+		if (key == "TZ" && req.Timezone != "") || (key == "LANG" && req.Locale != "") {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// baseLocaleEnv is the LANG and TZ every execution starts with: C.UTF-8
+// and the image's zone unless the request, or its env, says otherwise.
+func baseLocaleEnv(req ExecutionRequest) []string {
+	var env []string
+	switch {
+	case req.Locale != "":
+		env = append(env, "LANG="+req.Locale)
+	case !hasEnv(req.EnvVars, "LANG"):
+		env = append(env, "LANG="+defaultLocale)
+	}
+	if req.Timezone != "" {
+		env = append(env, "TZ="+req.Timezone)
+	}
+	return env
+}
+
+// effectiveLocale returns the TZ and LANG an execution ran with, for its
+// environment report: the request's, else its env's, else the defaults.
+// An empty timezone is the image's, UTC as good as always.
+func effectiveLocale(req ExecutionRequest) (timezone, locale string) {
+	timezone, locale = req.Timezone, req.Locale
+	for _, v := range req.EnvVars {
+		switch key, value, _ := strings.Cut(v, "="); {
+		case key == "TZ" && timezone == "":
+			timezone = value
+		case key == "LANG" && locale == "":
+			locale = value
+		}
+	}
+	if locale == "" {
+		locale = defaultLocale
+	}
+	return timezone, locale
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, v := range env {
+		if k, _, _ := strings.Cut(v, "="); k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// writePasswd writes the passwd and group files mounted over an
+// execution's, into dir: root, and the sandbox user with its home.
+func writePasswd(dir string) error {
+	users := "root:x:0:0:root:/root:/sbin/nologin\n" +
+		"sandbox:x:" + sandboxUID + ":" + sandboxUID + ":sandbox:" + sandboxHome + ":/sbin/nologin\n"
+	groups := "root:x:0:\n" +
+		"sandbox:x:" + sandboxUID + ":\n"
+	// Readable by the container user; the files are mounted read-only.
+	if err := os.WriteFile(filepath.Join(dir, "passwd"), []byte(users), 0644); err != nil { // #nosec G306 -- world-readable like /etc/passwd
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "group"), []byte(groups), 0644) // #nosec G306 -- world-readable like /etc/group
+}
+
+// identityProbe is what an image has of the sandbox user's identity and
+// of the zones a request may ask for.
+type identityProbe struct {
+	hasUser bool            // /etc/passwd has an entry for the sandbox uid
+	zones   map[string]bool // names under /usr/share/zoneinfo
+}
+
+// identityScript prints the image's /etc/passwd, a separator, then its
+// zoneinfo files. An image missing either prints nothing for it.
+const identityScript = "cat /etc/passwd 2>/dev/null; echo ---; find " + zoneinfoDir + " ! -type d 2>/dev/null; exit 0"
+
+// parseIdentityProbe reads identityScript's output.
+func parseIdentityProbe(out string) identityProbe {
+	p := identityProbe{zones: make(map[string]bool)}
+	passwd, zones, _ := strings.Cut(out, "---\n")
+	for _, line := range strings.Split(passwd, "\n") {
+		if fields := strings.Split(line, ":"); len(fields) >= 3 && fields[2] == sandboxUID {
+			p.hasUser = true
+		}
+	}
+	for _, line := range strings.Split(zones, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), zoneinfoDir+"/"); ok && name != "" {
+			p.zones[name] = true
+		}
+	}
+	return p
+}
+
+// identityProbes finds out, once per image digest like warmupProbes, whether
+// runtime images have a passwd entry for the sandbox user and which zones
+// they carry.
+type identityProbes struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+	now    func() time.Time
+
+	mu     sync.Mutex
+	images map[string]cachedIdentity // by image reference
+}
+
+type cachedIdentity struct {
+	digest  string
+	probe   identityProbe
+	ok      bool // the probe ran
+	checked time.Time
+}
+
+func newIdentityProbes(docker func(ctx context.Context, args ...string) ([]byte, error)) *identityProbes {
+	return &identityProbes{docker: docker, now: time.Now, images: make(map[string]cachedIdentity)}
+}
+
+// get returns the probe of image, running identityScript in it when the
+// image's digest is new. False means the probe couldn't run, for one
+// because the image has no /bin/sh; it is retried after warmupRecheck.
+func (p *identityProbes) get(ctx context.Context, image string) (identityProbe, bool) {
+	now := p.now()
+	p.mu.Lock()
+	cached, found := p.images[image]
+	p.mu.Unlock()
+	if found && now.Sub(cached.checked) < warmupRecheck {
+		return cached.probe, cached.ok
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmupProbeTimeout)
+	defer cancel()
+	entry := cachedIdentity{checked: now}
+	out, err := p.docker(ctx, "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return entry.probe, false
+	}
+	entry.digest = strings.TrimSpace(string(out))
+	if found && cached.ok && cached.digest == entry.digest {
+		entry.probe, entry.ok = cached.probe, true
+		p.store(image, entry)
+		return entry.probe, true
+	}
+
+	out, err = p.docker(ctx, probeRunArgs(image, "/bin/sh", "-c", identityScript)...)
+	entry.ok = err == nil
+	if err != nil {
+		log.Warn().Err(err).Str("image", image).Msg("identity probe failed; generating passwd and trusting timezones")
+	} else {
+		entry.probe = parseIdentityProbe(string(out))
+		log.Info().Str("image", image).Str("digest", entry.digest).Bool("has_user", entry.probe.hasUser).
+			Int("zones", len(entry.probe.zones)).Msg("probed runtime image for the sandbox user and zones")
+	}
+	p.store(image, entry)
+	return entry.probe, entry.ok
+}
+
+func (p *identityProbes) store(image string, entry cachedIdentity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.images[image] = entry
+}
+
+// shapeIdentity settles a non-claude execution's identity against its
+// image: with generatePasswd it gets a generated passwd unless the image
+// has the sandbox user, and a timezone the image turns out not to have is
+// refused, as its TZ would silently fall back to UTC. UTC is always
+// allowed. Without a probe both are given the benefit of the doubt. Claude
+// runs as the image's own node user, and its image isn't probed.
+func shapeIdentity(ctx context.Context, probes *identityProbes, generatePasswd bool, image string, req *ExecutionRequest) error {
+	passwd := generatePasswd
+	zone := req.Timezone != "" && req.Timezone != "UTC"
+	if probes == nil || req.Language == "claude" || (!passwd && !zone) {
+		return nil
+	}
+	probe, ok := probes.get(ctx, image)
+	if zone && ok && !probe.zones[req.Timezone] {
+		return fmt.Errorf("%w: timezone %q is not in the %s image's zoneinfo", ErrInvalidRequest, req.Timezone, req.Language)
+	}
+	if passwd {
+		req.Passwd = PasswdGenerated
+		if ok && probe.hasUser {
+			req.Passwd = PasswdImage
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// A slim Debian image: nobody is uid 65534.
+	debianIdentity = "root:x:0:0:root:/root:/bin/bash\nnobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n---\n" +
+		"/usr/share/zoneinfo/UTC\n/usr/share/zoneinfo/Asia/Tokyo\n/usr/share/zoneinfo/Europe/Berlin\n"
+	// A minimal image: root only, and no zoneinfo.
+	bareIdentity = "root:x:0:0:root:/root:/bin/sh\n---\n"
+)
+
+func testIdentityProbes(docker *fakeDocker) (*identityProbes, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newIdentityProbes(docker.run)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func TestWritePasswd(t *testing.T) {
+	dir := t.TempDir()
+	if err := writePasswd(dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"passwd": "root:x:0:0:root:/root:/sbin/nologin\nsandbox:x:65534:65534:sandbox:/tmp:/sbin/nologin\n",
+		"group":  "root:x:0:\nsandbox:x:65534:\n",
+	} {
+		path := filepath.Join(dir, name)
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+			t.Errorf("%s mode %v, want it readable by the container user", name, info.Mode().Perm())
+		}
+	}
+}
+
+func TestParseIdentityProbe(t *testing.T) {
+	p := parseIdentityProbe(debianIdentity)
+	if !p.hasUser {
+		t.Error("debian: nobody not taken for the sandbox user")
+	}
+	for _, zone := range []string{"UTC", "Asia/Tokyo", "Europe/Berlin"} {
+		if !p.zones[zone] {
+			t.Errorf("debian: zone %s missing from %v", zone, p.zones)
+		}
+	}
+
+	p = parseIdentityProbe(bareIdentity)
+	if p.hasUser || len(p.zones) != 0 {
+		t.Errorf("bare image: %+v", p)
+	}
+	// A gid of 65534 isn't the uid.
+	if p := parseIdentityProbe("app:x:1000:65534::/app:/bin/sh\n---\n"); p.hasUser {
+		t.Error("uid 1000 in group 65534 taken for the sandbox user")
+	}
+}
+
+func TestIdentityProbes_CachedByDigest(t *testing.T) {
+	docker := &fakeDocker{output: map[string]string{"image inspect": "sha256:aaa\n", "run --rm": debianIdentity}}
+	p, now := testIdentityProbes(docker)
+
+	if probe, ok := p.get(context.Background(), pythonImage); !ok || !probe.hasUser {
+		t.Fatalf("get() = %+v, %v", probe, ok)
+	}
+	p.get(context.Background(), pythonImage)
+	*now = now.Add(warmupRecheck)
+	p.get(context.Background(), pythonImage)
+	if n := len(docker.commands("run --rm")); n != 1 {
+		t.Errorf("probed %d times for one digest, want 1", n)
+	}
+	want := strings.Join(probeRunArgs(pythonImage, "/bin/sh", "-c", identityScript), " ")
+	if runs := docker.commands("run --rm"); runs[0] != want {
+		t.Errorf("probe = %q, want %q", runs[0], want)
+	}
+
+	docker.output["image inspect"] = "sha256:bbb\n"
+	docker.output["run --rm"] = bareIdentity
+	*now = now.Add(warmupRecheck)
+	if probe, _ := p.get(context.Background(), pythonImage); probe.hasUser {
+		t.Errorf("after retag: %+v", probe)
+	}
+}
+
+func TestShapeIdentity(t *testing.T) {
+	failed := func() *fakeDocker {
+		return &fakeDocker{output: map[string]string{"image inspect": "sha256:ccc"},
+			fail: map[string]bool{strings.Join(probeRunArgs(pythonImage, "/bin/sh", "-c", identityScript), " "): true}}
+	}
+	tests := []struct {
+		name     string
+		probe    *fakeDocker
+		generate bool
+		language string
+		timezone string
+		want     string // req.Passwd
+		wantErr  bool
+		probes   int
+	}{
+		{"image has the user", &fakeDocker{output: map[string]string{"image inspect": "sha256:a", "run --rm": debianIdentity}}, true, "python", "", PasswdImage, false, 1},
+		{"image lacks it", &fakeDocker{output: map[string]string{"image inspect": "sha256:a", "run --rm": bareIdentity}}, true, "python", "", PasswdGenerated, false, 1},
+		{"probe failed", failed(), true, "python", "", PasswdGenerated, false, 1},
+		{"off", &fakeDocker{}, false, "python", "", "", false, 0},
+		{"claude", &fakeDocker{}, true, "claude", "Asia/Tokyo", "", false, 0},
+		{"zone in the image", &fakeDocker{output: map[string]string{"image inspect": "sha256:a", "run --rm": debianIdentity}}, false, "python", "Asia/Tokyo", "", false, 1},
+		{"zone not in the image", &fakeDocker{output: map[string]string{"image inspect": "sha256:a", "run --rm": bareIdentity}}, false, "python", "Asia/Tokyo", "", true, 1},
+		{"UTC needs no zoneinfo", &fakeDocker{output: map[string]string{"image inspect": "sha256:a", "run --rm": bareIdentity}}, false, "python", "UTC", "", false, 0},
+		{"zone unprobed", failed(), false, "python", "Asia/Tokyo", "", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes, _ := testIdentityProbes(tt.probe)
+			req := &ExecutionRequest{Language: tt.language, Timezone: tt.timezone}
+			err := shapeIdentity(context.Background(), probes, tt.generate, pythonImage, req)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidRequest)) {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if req.Passwd != tt.want {
+				t.Errorf("Passwd = %q, want %q", req.Passwd, tt.want)
+			}
+			if n := len(tt.probe.commands("run --rm")); n != tt.probes {
+				t.Errorf("%d probes, want %d", n, tt.probes)
+			}
+		})
+	}
+}
+
+func TestValidateLocale(t *testing.T) {
+	for _, tt := range []struct {
+		timezone, locale string
+		ok               bool
+	}{
+		{"", "", true},
+		{"Asia/Tokyo", "en_US.UTF-8", true},
+		{"UTC", "C.UTF-8", true},
+		{"America/Argentina/Buenos_Aires", "sr_RS.UTF-8@latin", true},
+		{"", "de_DE.utf8", true},
+		{"Mars/Olympus_Mons", "", false},
+		{"Asia/Tokyo ", "", false},
+		{"../../etc/passwd", "", false},
+		{"/etc/localtime", "", false},
+		{"Local", "", false},
+		{"", "C", false},
+		{"", "POSIX", false},
+		{"", "en_US.ISO-8859-1", false},
+		{"", "en_US", false},
+		{"", "en_US.UTF-8;id", false},
+	} {
+		err := validateLocale(&ExecutionRequest{Timezone: tt.timezone, Locale: tt.locale})
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrInvalidRequest)) {
+			t.Errorf("timezone %q, locale %q: err = %v, want ok %v", tt.timezone, tt.locale, err, tt.ok)
+		}
+	}
+}
+
+func TestBuildDockerArgs_Identity(t *testing.T) {
+	envOf := func(args []string) []string {
+		var env []string
+		for i := 0; i+1 < len(args); i++ {
+			if args[i] == "-e" {
+				env = append(env, args[i+1])
+			}
+		}
+		return env
+	}
+	d := newTestRunner(0, "", nil)
+	rt, _ := d.runtimes.Get("python")
+	build := func(req *ExecutionRequest) []string {
+		t.Helper()
+		if err := d.validateRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		return d.buildDockerArgs("exec-1", rt, "/tmp/code.py", "/workspace/code.py", "/tmp/sandbox-exec-1", "/tmp/seccomp.json", *req)
+	}
+
+	args := build(&ExecutionRequest{Language: "python", Code: "print(1)"})
+	if env := envOf(args); !slices.Contains(env, "LANG=C.UTF-8") || slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "TZ=") }) {
+		t.Errorf("defaults: env %q", env)
+	}
+	if argsContain(args, "/tmp/sandbox-exec-1/passwd:/etc/passwd:ro") {
+		t.Error("passwd mounted without generate_passwd")
+	}
+
+	// The request's timezone and locale replace its env's, once each.
+	req := &ExecutionRequest{Language: "python", Code: "print(1)", Timezone: "Asia/Tokyo", Locale: "ja_JP.UTF-8",
+		EnvVars: []string{"TZ=UTC", "LANG=en_US.UTF-8", "DEBUG=1"}, Passwd: PasswdGenerated}
+	args = build(req)
+	env := envOf(args)
+	for key, want := range map[string]string{"TZ": "TZ=Asia/Tokyo", "LANG": "LANG=ja_JP.UTF-8"} {
+		if got := slices.DeleteFunc(slices.Clone(env), func(v string) bool { return !strings.HasPrefix(v, key+"=") }); !slices.Equal(got, []string{want}) {
+			t.Errorf("%s: %q, want just %q", key, got, want)
+		}
+	}
+	if !slices.Contains(env, "DEBUG=1") {
+		t.Errorf("env %q lost DEBUG", env)
+	}
+	if !argsContain(args, "/tmp/sandbox-exec-1/passwd:/etc/passwd:ro") || !argsContain(args, "/tmp/sandbox-exec-1/group:/etc/group:ro") {
+		t.Errorf("generated passwd not mounted: %q", args)
+	}
+	if tz, locale := effectiveLocale(*req); tz != "Asia/Tokyo" || locale != "ja_JP.UTF-8" {
+		t.Errorf("reported %q, %q", tz, locale)
+	}
+
+	// A LANG in the env alone is the only one.
+	req = &ExecutionRequest{Language: "python", Code: "print(1)", EnvVars: []string{"LANG=de_DE.UTF-8"}}
+	if env := envOf(build(req)); slices.Contains(env, "LANG=C.UTF-8") {
+		t.Errorf("env LANG: %q", env)
+	}
+	if _, locale := effectiveLocale(*req); locale != "de_DE.UTF-8" {
+		t.Errorf("reported locale %q", locale)
+	}
+
+	if err := d.validateRequest(&ExecutionRequest{Language: "python", Code: "print(1)", Timezone: "Nowhere/Special"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("bogus timezone: err = %v", err)
+	}
+}
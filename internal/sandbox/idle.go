@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// IdleStop describes an execution the idle output watchdog stopped.
+type IdleStop struct {
+	Timeout    time.Duration `json:"timeout"`              // the request's IdleOutputTimeout
+	LastOutput time.Time     `json:"last_output,omitzero"` // latest write to stdout or stderr; zero if there was none
+	OutputAt   time.Duration `json:"output_at,omitempty"`  // how far into the run LastOutput was
+}
+
+// idleWatchdog stops an execution whose program has written nothing to
+// stdout or stderr for the request's IdleOutputTimeout: a program hung on a
+// lock or a network read otherwise holds its slot until the full timeout.
+// A nil watchdog, for requests without one, passes output through and never
+// fires.
+type idleWatchdog struct {
+	timeout time.Duration
+	warn    func(left time.Duration)
+	start   time.Time
+	last    atomic.Int64 // unix nanos of the latest output write, 0 before any
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	fired   atomic.Bool
+}
+
+// watchIdle returns the context to run the program under, canceled when
+// the watchdog fires. The clock starts now, so runners call it just before
+// the program starts. Stop must be called once the program has exited.
+func watchIdle(ctx context.Context, req ExecutionRequest) (context.Context, *idleWatchdog) {
+	if req.IdleOutputTimeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	d := &idleWatchdog{
+		timeout: req.IdleOutputTimeout,
+		warn:    req.IdleWarning,
+		start:   time.Now(),
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+	}
+	go d.run()
+	return ctx, d
+}
+
+func (d *idleWatchdog) run() {
+	// Wake at half the timeout to warn, then at the timeout to fire; any
+	// output in between pushes both back.
+	warned := int64(-1) // the silence, by its last write, already warned about
+	timer := time.NewTimer(d.timeout / 2)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-timer.C:
+		}
+		last := d.last.Load()
+		since := d.start
+		if last != 0 {
+			since = time.Unix(0, last)
+		}
+		idle := time.Since(since)
+		switch {
+		case idle >= d.timeout:
+			d.fired.Store(true)
+			d.cancel()
+			return
+		case idle >= d.timeout/2:
+			if warned != last && d.warn != nil {
+				d.warn(d.timeout - idle)
+			}
+			warned = last
+			timer.Reset(d.timeout - idle)
+		default:
+			timer.Reset(d.timeout/2 - idle)
+		}
+	}
+}
+
+// Output wraps an output writer so that writes to it hold the watchdog off.
+func (d *idleWatchdog) Output(w io.Writer) io.Writer {
+	if d == nil {
+		return w
+	}
+	return idleOutput{d: d, w: w}
+}
+
+type idleOutput struct {
+	d *idleWatchdog
+	w io.Writer
+}
+
+func (o idleOutput) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.d.last.Store(time.Now().UnixNano())
+	}
+	return o.w.Write(p)
+}
+
+// Fired reports whether the watchdog stopped the execution.
+func (d *idleWatchdog) Fired() bool {
+	return d != nil && d.fired.Load()
+}
+
+// Stop ends the watchdog and releases its context.
+func (d *idleWatchdog) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	d.cancel()
+}
+
+// report describes the stop for the result, and the runtime security event
+// recorded with it.
+func (d *idleWatchdog) report() (*IdleStop, SecurityEvent) {
+	stop := &IdleStop{Timeout: d.timeout}
+	detail := fmt.Sprintf("no output within %s of the start; stopped", d.timeout)
+	if last := d.last.Load(); last != 0 {
+		stop.LastOutput = time.Unix(0, last)
+		stop.OutputAt = stop.LastOutput.Sub(d.start)
+		detail = fmt.Sprintf("no output for %s since output at %s; stopped", d.timeout, stop.OutputAt.Round(time.Millisecond))
+	}
+	return stop, SecurityEvent{Type: "idle_timeout", Source: SourceRuntime, Detail: detail}
+}
@@ -0,0 +1,117 @@
+package sandbox
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quietProgram stands in for a program that prints once and then hangs: it
+// writes to out and waits for ctx, returning how long it ran.
+func quietProgram(ctx context.Context, out io.Writer) time.Duration {
+	start := time.Now()
+	out.Write([]byte("started\n"))
+	<-ctx.Done()
+	return time.Since(start)
+}
+
+func TestIdleWatchdog_StopsQuietProgram(t *testing.T) {
+	var mu sync.Mutex
+	var warnings []time.Duration
+	req := ExecutionRequest{
+		IdleOutputTimeout: 100 * time.Millisecond,
+		IdleWarning: func(left time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, left)
+		},
+	}
+	execCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx, idle := watchIdle(execCtx, req)
+	defer idle.Stop()
+
+	ran := quietProgram(ctx, idle.Output(io.Discard))
+	if ran < 100*time.Millisecond || ran > 5*time.Second {
+		t.Fatalf("ran %s, want stopped at the 100ms idle threshold", ran)
+	}
+	if !idle.Fired() || execCtx.Err() != nil {
+		t.Fatalf("fired %v, exec ctx %v: want the idle stop, not the timeout", idle.Fired(), execCtx.Err())
+	}
+
+	mu.Lock()
+	if len(warnings) != 1 || warnings[0] <= 0 || warnings[0] > 50*time.Millisecond {
+		t.Errorf("warnings = %v, want one at half the threshold", warnings)
+	}
+	mu.Unlock()
+
+	stop, event := idle.report()
+	if stop.Timeout != 100*time.Millisecond || stop.LastOutput.IsZero() || stop.OutputAt > 50*time.Millisecond {
+		t.Errorf("report = %+v", stop)
+	}
+	if event.Type != "idle_timeout" || event.Source != SourceRuntime {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestIdleWatchdog_OutputHoldsOff(t *testing.T) {
+	warned := make(chan time.Duration, 10)
+	ctx, idle := watchIdle(context.Background(), ExecutionRequest{
+		IdleOutputTimeout: 100 * time.Millisecond,
+		IdleWarning:       func(left time.Duration) { warned <- left },
+	})
+	out := idle.Output(io.Discard)
+	for range 10 {
+		time.Sleep(20 * time.Millisecond)
+		out.Write([]byte("tick\n"))
+	}
+	idle.Stop()
+	if idle.Fired() || len(warned) != 0 {
+		t.Errorf("fired %v with %d warnings for a program writing every 20ms", idle.Fired(), len(warned))
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("ctx after Stop: %v", ctx.Err())
+	}
+}
+
+// TestIdleWatchdog_WarnsPerSilence checks output after a warning rearms it.
+func TestIdleWatchdog_WarnsPerSilence(t *testing.T) {
+	warned := make(chan time.Duration, 10)
+	_, idle := watchIdle(context.Background(), ExecutionRequest{
+		IdleOutputTimeout: 200 * time.Millisecond,
+		IdleWarning:       func(left time.Duration) { warned <- left },
+	})
+	defer idle.Stop()
+	out := idle.Output(io.Discard)
+
+	<-warned
+	out.Write([]byte("back\n"))
+	select {
+	case <-warned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no warning for the second silence")
+	}
+}
+
+func TestIdleWatchdog_NeverWrote(t *testing.T) {
+	ctx, idle := watchIdle(context.Background(), ExecutionRequest{IdleOutputTimeout: 20 * time.Millisecond})
+	defer idle.Stop()
+	<-ctx.Done()
+	if stop, _ := idle.report(); !stop.LastOutput.IsZero() || stop.OutputAt != 0 {
+		t.Errorf("report = %+v, want no last output", stop)
+	}
+}
+
+func TestIdleWatchdog_Off(t *testing.T) {
+	ctx := context.Background()
+	got, idle := watchIdle(ctx, ExecutionRequest{})
+	if idle != nil || got != ctx {
+		t.Fatal("watchdog started without an idle_output_timeout")
+	}
+	if idle.Output(io.Discard) != io.Discard || idle.Fired() {
+		t.Error("nil watchdog should pass output through and never fire")
+	}
+	idle.Stop()
+}
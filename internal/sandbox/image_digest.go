@@ -0,0 +1,57 @@
+package sandbox
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageDigestTimeout bounds the docker image inspect behind a digest.
+const imageDigestTimeout = 5 * time.Second
+
+// digestCache remembers the ID of each image the docker backend runs, so
+// executions can record what they actually ran. An image is looked up once
+// and again after warmupRecheck, which notices a re-tagged image; a failed
+// lookup is retried by the next execution.
+type digestCache struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+	now    func() time.Time
+
+	mu     sync.Mutex
+	images map[string]cachedDigest // by image reference
+}
+
+type cachedDigest struct {
+	digest  string
+	checked time.Time
+}
+
+func newDigestCache(docker func(ctx context.Context, args ...string) ([]byte, error)) *digestCache {
+	return &digestCache{docker: docker, now: time.Now, images: make(map[string]cachedDigest)}
+}
+
+// get returns image's ID, sha256:..., or "" if it can't be found out.
+// Executions call it once their container has run, when the image is
+// certain to be there.
+func (c *digestCache) get(image string) string {
+	now := c.now()
+	c.mu.Lock()
+	cached, found := c.images[image]
+	c.mu.Unlock()
+	if found && now.Sub(cached.checked) < warmupRecheck {
+		return cached.digest
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageDigestTimeout)
+	defer cancel()
+	out, err := c.docker(ctx, "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return cached.digest // whatever it was last time, if anything
+	}
+	digest := strings.TrimSpace(string(out))
+	c.mu.Lock()
+	c.images[image] = cachedDigest{digest: digest, checked: now}
+	c.mu.Unlock()
+	return digest
+}
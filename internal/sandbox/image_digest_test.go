@@ -0,0 +1,50 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDigestCache(t *testing.T) {
+	var calls int
+	digest, fail := "sha256:aaa", false
+	c := newDigestCache(func(_ context.Context, args ...string) ([]byte, error) {
+		calls++
+		if fail {
+			return nil, errors.New("docker: connection refused")
+		}
+		if args[len(args)-1] != "sandbox-python:latest" {
+			t.Errorf("inspected %v", args)
+		}
+		return []byte(digest + "\n"), nil
+	})
+	clock := time.Unix(0, 0)
+	c.now = func() time.Time { return clock }
+
+	if got := c.get("sandbox-python:latest"); got != "sha256:aaa" || calls != 1 {
+		t.Fatalf("first lookup: %q after %d calls", got, calls)
+	}
+	// Cached until the recheck, even if the image has been re-tagged.
+	digest = "sha256:bbb"
+	clock = clock.Add(warmupRecheck - time.Second)
+	if got := c.get("sandbox-python:latest"); got != "sha256:aaa" || calls != 1 {
+		t.Errorf("within the recheck: %q after %d calls", got, calls)
+	}
+	clock = clock.Add(time.Second)
+	if got := c.get("sandbox-python:latest"); got != "sha256:bbb" || calls != 2 {
+		t.Errorf("after the recheck: %q after %d calls", got, calls)
+	}
+
+	// A failed lookup keeps the last digest and is retried next time.
+	fail = true
+	clock = clock.Add(warmupRecheck)
+	if got := c.get("sandbox-python:latest"); got != "sha256:bbb" {
+		t.Errorf("failed lookup: %q", got)
+	}
+	fail = false
+	if c.get("sandbox-python:latest"); calls != 4 {
+		t.Errorf("failed lookup not retried: %d calls", calls)
+	}
+}
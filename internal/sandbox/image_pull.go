@@ -0,0 +1,134 @@
+package sandbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pullProgressInterval is how often a pull in progress is reported.
+const pullProgressInterval = time.Second
+
+// PullProgress is how far the pull of an execution's image has got.
+// BytesTotal counts the blobs found so far: it grows as the pull reads
+// manifests. The docker backend reports once, with no bytes.
+type PullProgress struct {
+	Image      string
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// ImagePull is an image pull an execution waited on in its setup phase,
+// which the run's Duration doesn't include. Bytes is 0 when the backend
+// can't tell.
+type ImagePull struct {
+	Image    string        `json:"image"`
+	Duration time.Duration `json:"duration"`
+	Bytes    int64         `json:"bytes,omitempty"`
+}
+
+// ImagePullObserver times the image pulls executions wait on, by image.
+type ImagePullObserver interface {
+	ImagePulled(image string, took time.Duration)
+}
+
+// pullTracker follows a containerd pull through its image handlers: the
+// descriptors it has found, for the total, and the ones it has fetched.
+// The bytes of blobs still being fetched are in the content store's
+// ingest statuses.
+type pullTracker struct {
+	mu      sync.Mutex
+	sizes   map[digest.Digest]int64
+	fetched map[digest.Digest]bool
+}
+
+func newPullTracker() *pullTracker {
+	return &pullTracker{sizes: make(map[digest.Digest]int64), fetched: make(map[digest.Digest]bool)}
+}
+
+// wrap is a containerd.WithImageHandlerWrapper: it counts each descriptor
+// the pull dispatches, and its children, and marks it fetched once the
+// handlers below are done with it.
+func (t *pullTracker) wrap(h images.Handler) images.Handler {
+	return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		t.mu.Lock()
+		t.sizes[desc.Digest] = desc.Size
+		t.mu.Unlock()
+		children, err := h.Handle(ctx, desc)
+		if err != nil {
+			return children, err
+		}
+		t.mu.Lock()
+		t.fetched[desc.Digest] = true
+		for _, c := range children {
+			if _, ok := t.sizes[c.Digest]; !ok {
+				t.sizes[c.Digest] = c.Size
+			}
+		}
+		t.mu.Unlock()
+		return children, nil
+	})
+}
+
+// progress is the pull of image given the ingests under way in the
+// content store. Only those of blobs the pull has found count: another
+// pull may be writing to the store at the same time.
+func (t *pullTracker) progress(image string, active []content.Status) PullProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := PullProgress{Image: image}
+	for d, size := range t.sizes {
+		p.BytesTotal += size
+		if t.fetched[d] {
+			p.BytesDone += size
+		}
+	}
+	for _, st := range active {
+		if size, ok := t.sizes[st.Expected]; ok && !t.fetched[st.Expected] {
+			p.BytesDone += min(st.Offset, size)
+		}
+	}
+	return p
+}
+
+// fetchedBytes is what the pull fetched in all, once it is done.
+func (t *pullTracker) fetchedBytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n int64
+	for d := range t.fetched {
+		n += t.sizes[d]
+	}
+	return n
+}
+
+// reportPull calls report with poll's reading at once and then every
+// interval, until the returned stop is called. stop waits for a report
+// under way, so none comes after it.
+func reportPull(interval time.Duration, poll func() PullProgress, report func(PullProgress)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		report(poll())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report(poll())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func blob(name string, size int64) ocispec.Descriptor {
+	return ocispec.Descriptor{Digest: digest.FromString(name), Size: size}
+}
+
+func TestPullTracker(t *testing.T) {
+	manifest, config := blob("manifest", 1000), blob("config", 500)
+	base, app := blob("base", 30000), blob("app", 8500)
+	children := map[digest.Digest][]ocispec.Descriptor{
+		manifest.Digest: {config, base, app},
+	}
+	tracker := newPullTracker()
+	var during PullProgress
+	fetch := images.HandlerFunc(func(_ context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if desc.Digest == app.Digest {
+			// Part way through the last layer, with another pull's ingest
+			// in the store too.
+			during = tracker.progress("python", []content.Status{
+				{Ref: "app", Expected: app.Digest, Offset: 4000, Total: app.Size},
+				{Ref: "other", Expected: digest.FromString("other"), Offset: 99999},
+			})
+		}
+		return children[desc.Digest], nil
+	})
+	// One handler at a time, so the last layer comes last.
+	if err := images.Walk(context.Background(), tracker.wrap(fetch), manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (PullProgress{Image: "python", BytesDone: 1000 + 500 + 30000 + 4000, BytesTotal: 40000}); during != want {
+		t.Errorf("during the pull: %+v, want %+v", during, want)
+	}
+	if got, want := tracker.progress("python", nil), (PullProgress{Image: "python", BytesDone: 40000, BytesTotal: 40000}); got != want {
+		t.Errorf("after the pull: %+v, want %+v", got, want)
+	}
+	if got := tracker.fetchedBytes(); got != 40000 {
+		t.Errorf("fetched %d bytes, want 40000", got)
+	}
+}
+
+func TestReportPull(t *testing.T) {
+	var mu sync.Mutex
+	var reports []PullProgress
+	polls := 0
+	stop := reportPull(10*time.Millisecond, func() PullProgress {
+		polls++
+		return PullProgress{Image: "node", BytesDone: int64(polls)}
+	}, func(p PullProgress) {
+		mu.Lock()
+		reports = append(reports, p)
+		mu.Unlock()
+	})
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	mu.Lock()
+	n := len(reports)
+	mu.Unlock()
+	if n < 2 {
+		t.Fatalf("%d reports in 55ms at 10ms, want the first at once and more on the ticks", n)
+	}
+	for i, p := range reports {
+		if p.BytesDone != int64(i+1) {
+			t.Errorf("report %d = %+v, want poll %d", i, p, i+1)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != n {
+		t.Errorf("%d reports after stop, want none", len(reports)-n)
+	}
+}
+
+type pullCounter struct {
+	mu    sync.Mutex
+	pulls map[string]int
+}
+
+func (c *pullCounter) ImagePulled(image string, took time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pulls[image]++
+}
+
+func TestDockerRunner_ImagePull(t *testing.T) {
+	d := fakeDockerRunner(t, "0.2", "0")
+	obs := &pullCounter{pulls: make(map[string]int)}
+	d.imagePullObs = obs
+	var events []PullProgress
+	req := ExecutionRequest{Language: "python", Code: "print('ran')", Timeout: time.Second,
+		ImagePulling: func(p PullProgress) { events = append(events, p) }}
+
+	result, err := d.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := result.Image
+	if len(events) != 1 || events[0] != (PullProgress{Image: image}) {
+		t.Errorf("pulling events %+v, want one for %s", events, image)
+	}
+	if result.ImagePull == nil || result.ImagePull.Image != image || result.ImagePull.Duration < 200*time.Millisecond {
+		t.Errorf("image pull %+v, want the 200ms pull of %s", result.ImagePull, image)
+	}
+	if result.Duration >= result.ImagePull.Duration {
+		t.Errorf("duration %s counts the %s pull", result.Duration, result.ImagePull.Duration)
+	}
+
+	// The image is there now.
+	events = nil
+	if result, err = d.Execute(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || result.ImagePull != nil {
+		t.Errorf("second execution: events %+v, image pull %+v; want no pull", events, result.ImagePull)
+	}
+	if obs.pulls[image] != 1 {
+		t.Errorf("observed pulls %v, want one of %s", obs.pulls, image)
+	}
+}
@@ -0,0 +1,294 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The limit mechanisms the startup canary checks. Every docker execution
+// uses all four: --memory-swap is set to --memory so it can't swap.
+const (
+	LimitMemory     = "memory"
+	LimitMemorySwap = "memory_swap"
+	LimitPids       = "pids"
+	LimitCPU        = "cpu"
+)
+
+// limitMechanisms are the mechanisms in the order they are reported.
+var limitMechanisms = []string{LimitMemory, LimitMemorySwap, LimitPids, LimitCPU}
+
+// sandbox.limit_enforcement modes.
+const (
+	LimitEnforcementStrict     = "strict"     // refuse executions the host can't hold to their limits
+	LimitEnforcementPermissive = "permissive" // run them, with a limit_not_enforced event per gap
+	LimitEnforcementOff        = "off"        // don't check
+)
+
+const (
+	// limitProbeTimeout bounds the canary, which may have to pull its image.
+	limitProbeTimeout = 2 * time.Minute
+	// The canary's limits, and what its cgroup files read when they hold.
+	canaryMemoryMB = 64
+	canaryPids     = 32
+	canaryCPUs     = "0.5"
+)
+
+// ErrLimitNotEnforced is returned, under sandbox.limit_enforcement strict,
+// for an execution whose limits the host wasn't verified to enforce.
+var ErrLimitNotEnforced = errors.New("resource limits not enforced on this host")
+
+// LimitNotEnforcedError names the mechanisms an execution needed that the
+// host wasn't verified to enforce.
+type LimitNotEnforcedError struct {
+	Gaps []string
+}
+
+func (e *LimitNotEnforcedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrLimitNotEnforced, strings.Join(e.Gaps, ", "))
+}
+
+func (e *LimitNotEnforcedError) Unwrap() error { return ErrLimitNotEnforced }
+
+// LimitEnforcement is what the startup canary found the host enforces, by
+// mechanism. Error is set when the canary couldn't run, and then nothing
+// counts as enforced.
+type LimitEnforcement struct {
+	Cgroup    string          `json:"cgroup,omitempty"` // v1 or v2
+	Enforced  map[string]bool `json:"enforced"`
+	Error     string          `json:"error,omitempty"`
+	CheckedAt time.Time       `json:"checked_at"`
+}
+
+// gaps returns the mechanisms not verified to be enforced.
+func (e LimitEnforcement) gaps() []string {
+	var gaps []string
+	for _, m := range limitMechanisms {
+		if !e.Enforced[m] {
+			gaps = append(gaps, m)
+		}
+	}
+	return gaps
+}
+
+// LimitEnforcementChecker is implemented by backends that verify the
+// limits they set take effect.
+type LimitEnforcementChecker interface {
+	LimitEnforcement(ctx context.Context) (LimitEnforcement, bool)
+}
+
+// LimitValues are an execution's limits in the units of ResourceLimits.
+type LimitValues struct {
+	CPUShares    int64 `json:"cpu_shares,omitempty"`
+	MemoryMB     int64 `json:"memory_mb,omitempty"`
+	MemorySwapMB int64 `json:"memory_swap_mb,omitempty"` // memory and swap together
+	PidsLimit    int64 `json:"pids_limit,omitempty"`
+}
+
+// LimitReport is what an execution asked for against what the host was
+// verified to enforce of it: a limit that isn't is left out of Enforced
+// and named in NotEnforced.
+type LimitReport struct {
+	Requested   LimitValues `json:"requested"`
+	Enforced    LimitValues `json:"enforced"`
+	NotEnforced []string    `json:"not_enforced,omitempty"`
+}
+
+// newLimitReport reports limits against enforcement.
+func newLimitReport(limits ResourceLimits, enforcement LimitEnforcement) *LimitReport {
+	requested := LimitValues{CPUShares: limits.CPUShares, MemoryMB: limits.MemoryMB, MemorySwapMB: limits.MemoryMB, PidsLimit: limits.PidsLimit}
+	report := &LimitReport{Requested: requested, Enforced: requested, NotEnforced: enforcement.gaps()}
+	for _, gap := range report.NotEnforced {
+		switch gap {
+		case LimitMemory:
+			report.Enforced.MemoryMB = 0
+		case LimitMemorySwap:
+			report.Enforced.MemorySwapMB = 0
+		case LimitPids:
+			report.Enforced.PidsLimit = 0
+		case LimitCPU:
+			report.Enforced.CPUShares = 0
+		}
+	}
+	return report
+}
+
+// limitEvents are the limit_not_enforced events of report, one per gap.
+func limitEvents(report *LimitReport) []SecurityEvent {
+	var events []SecurityEvent
+	for _, gap := range report.NotEnforced {
+		events = append(events, SecurityEvent{
+			Type:     "limit_not_enforced",
+			Source:   SourceRuntime,
+			Severity: "medium",
+			Detail:   fmt.Sprintf("%s: requested %s but the host was not verified to enforce it", gap, report.Requested.describe(gap)),
+		})
+	}
+	return events
+}
+
+func (v LimitValues) describe(mechanism string) string {
+	switch mechanism {
+	case LimitMemory:
+		return fmt.Sprintf("%dMB", v.MemoryMB)
+	case LimitMemorySwap:
+		return fmt.Sprintf("%dMB of memory and swap", v.MemorySwapMB)
+	case LimitPids:
+		return fmt.Sprintf("%d processes", v.PidsLimit)
+	case LimitCPU:
+		return fmt.Sprintf("%d CPU shares", v.CPUShares)
+	}
+	return mechanism
+}
+
+// limitScript prints the canary's cgroup version and its limits as the
+// cgroup files read them, a missing file as an empty value.
+const limitScript = `if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+echo cgroup=v2
+echo memory=$(cat /sys/fs/cgroup/memory.max 2>/dev/null)
+echo memory_swap=$(cat /sys/fs/cgroup/memory.swap.max 2>/dev/null)
+echo pids=$(cat /sys/fs/cgroup/pids.max 2>/dev/null)
+echo cpu=$(cat /sys/fs/cgroup/cpu.max 2>/dev/null)
+else
+echo cgroup=v1
+echo memory=$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes 2>/dev/null)
+echo memory_swap=$(cat /sys/fs/cgroup/memory/memory.memsw.limit_in_bytes 2>/dev/null)
+echo pids=$(cat /sys/fs/cgroup/pids/pids.max 2>/dev/null)
+echo cpu=$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us 2>/dev/null)
+fi`
+
+// canaryArgs run limitScript in image under the canary's limits, set the
+// way buildDockerArgs sets an execution's.
+func canaryArgs(image string) []string {
+	return []string{
+		"run", "--rm",
+		"--network", "none",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+		"--user", sandboxUID + ":" + sandboxUID,
+		"--memory", fmt.Sprintf("%dm", canaryMemoryMB),
+		"--memory-swap", fmt.Sprintf("%dm", canaryMemoryMB),
+		"--pids-limit", fmt.Sprint(canaryPids),
+		"--cpus", canaryCPUs,
+		image, "/bin/sh", "-c", limitScript,
+	}
+}
+
+// parseLimitProbe reads limitScript's output: a mechanism is enforced when
+// its cgroup file holds the canary's limit.
+func parseLimitProbe(out string) (cgroup string, enforced map[string]bool) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+	memory := fmt.Sprint(canaryMemoryMB << 20)
+	want := map[string]string{
+		LimitMemory:     memory,
+		LimitMemorySwap: "0", // on v2, swap.max is the swap on top of memory
+		LimitPids:       fmt.Sprint(canaryPids),
+		LimitCPU:        "50000 100000",
+	}
+	if values["cgroup"] == "v1" {
+		want[LimitMemorySwap] = memory
+		want[LimitCPU] = "50000"
+	}
+	enforced = make(map[string]bool, len(want))
+	for _, m := range limitMechanisms {
+		enforced[m] = values[m] == want[m]
+	}
+	return values["cgroup"], enforced
+}
+
+// limitProber runs the canary, at startup and whenever the last run failed
+// warmupRecheck ago. A run that read the cgroup files stands for the
+// server's lifetime: the host doesn't change under it.
+type limitProber struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+	image  string
+	now    func() time.Time
+
+	mu     sync.Mutex
+	result LimitEnforcement
+	ran    bool
+}
+
+func newLimitProber(docker func(ctx context.Context, args ...string) ([]byte, error), image string) *limitProber {
+	return &limitProber{docker: docker, image: image, now: time.Now}
+}
+
+// get returns what the canary found, running it if it hasn't yet or
+// failed and is due again. Executions wait for a run under way, which
+// outlives the one that started it: its result stands for all of them.
+func (p *limitProber) get(ctx context.Context) LimitEnforcement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if p.ran && (p.result.Error == "" || now.Sub(p.result.CheckedAt) < warmupRecheck) {
+		return p.result
+	}
+	p.ran = true
+	p.result = LimitEnforcement{CheckedAt: now}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), limitProbeTimeout)
+	defer cancel()
+	out, err := p.docker(ctx, canaryArgs(p.image)...)
+	if err != nil {
+		p.result.Error = err.Error()
+		log.Error().Err(err).Str("image", p.image).Msg("limit enforcement canary failed; no limit counts as enforced")
+		return p.result
+	}
+	p.result.Cgroup, p.result.Enforced = parseLimitProbe(string(out))
+	if gaps := p.result.gaps(); len(gaps) > 0 {
+		log.Warn().Str("cgroup", p.result.Cgroup).Strs("not_enforced", gaps).Msg("the host doesn't enforce some resource limits")
+	} else {
+		log.Info().Str("cgroup", p.result.Cgroup).Msg("verified resource limits take effect")
+	}
+	return p.result
+}
+
+// LimitEnforcement reports what the canary found; false when
+// sandbox.limit_enforcement is off.
+func (d *DockerRunner) LimitEnforcement(ctx context.Context) (LimitEnforcement, bool) {
+	if d.limitProbe == nil {
+		return LimitEnforcement{}, false
+	}
+	return d.limitProbe.get(ctx), true
+}
+
+// LimitEnforcement reports the wrapped backend's.
+func (c *ChaosBackend) LimitEnforcement(ctx context.Context) (LimitEnforcement, bool) {
+	if checker, ok := c.inner.(LimitEnforcementChecker); ok {
+		return checker.LimitEnforcement(ctx)
+	}
+	return LimitEnforcement{}, false
+}
+
+// checkLimits compares an execution's limits with what the host enforces:
+// under strict, a gap refuses it with a LimitNotEnforcedError; under
+// permissive it runs with a limit_not_enforced event per gap, and the
+// request's LimitsNotEnforced is called. The report is nil when
+// sandbox.limit_enforcement is off.
+func (d *DockerRunner) checkLimits(ctx context.Context, limits ResourceLimits, req ExecutionRequest) (*LimitReport, []SecurityEvent, error) {
+	if d.limitProbe == nil {
+		return nil, nil, nil
+	}
+	report := newLimitReport(limits, d.limitProbe.get(ctx))
+	if len(report.NotEnforced) == 0 {
+		return report, nil, nil
+	}
+	if d.limitMode == LimitEnforcementStrict {
+		return nil, nil, &LimitNotEnforcedError{Gaps: slices.Clone(report.NotEnforced)}
+	}
+	if req.LimitsNotEnforced != nil {
+		req.LimitsNotEnforced(report.NotEnforced)
+	}
+	return report, limitEvents(report), nil
+}
@@ -0,0 +1,125 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// What limitScript prints where every limit holds.
+	enforcedV2 = "cgroup=v2\nmemory=67108864\nmemory_swap=0\npids=32\ncpu=50000 100000\n"
+	enforcedV1 = "cgroup=v1\nmemory=67108864\nmemory_swap=67108864\npids=32\ncpu=50000\n"
+	// A cgroup v1 kernel booted without swap accounting has no memsw file.
+	noSwapV1 = "cgroup=v1\nmemory=67108864\nmemory_swap=\npids=32\ncpu=50000\n"
+)
+
+func TestParseLimitProbe(t *testing.T) {
+	all := map[string]bool{LimitMemory: true, LimitMemorySwap: true, LimitPids: true, LimitCPU: true}
+	tests := []struct {
+		name   string
+		out    string
+		cgroup string
+		gaps   []string
+	}{
+		{"v2", enforcedV2, "v2", nil},
+		{"v1", enforcedV1, "v1", nil},
+		{"v1 without swap accounting", noSwapV1, "v1", []string{LimitMemorySwap}},
+		{"v2 without swap accounting", "cgroup=v2\nmemory=67108864\nmemory_swap=\npids=32\ncpu=50000 100000\n", "v2", []string{LimitMemorySwap}},
+		{"pids limit ignored", "cgroup=v2\nmemory=67108864\nmemory_swap=0\npids=max\ncpu=50000 100000\n", "v2", []string{LimitPids}},
+		{"no cpu quota", "cgroup=v2\nmemory=67108864\nmemory_swap=0\npids=32\ncpu=max 100000\n", "v2", []string{LimitCPU}},
+		{"nothing readable", "cgroup=v2\nmemory=\nmemory_swap=\npids=\ncpu=\n", "v2", []string{LimitMemory, LimitMemorySwap, LimitPids, LimitCPU}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgroup, enforced := parseLimitProbe(tt.out)
+			if cgroup != tt.cgroup {
+				t.Errorf("cgroup %q, want %q", cgroup, tt.cgroup)
+			}
+			if gaps := (LimitEnforcement{Enforced: enforced}).gaps(); !slices.Equal(gaps, tt.gaps) {
+				t.Errorf("gaps %v, want %v", gaps, tt.gaps)
+			}
+			if tt.gaps == nil && !maps.Equal(enforced, all) {
+				t.Errorf("enforced %v", enforced)
+			}
+		})
+	}
+}
+
+func TestLimitProber_RetriesFailures(t *testing.T) {
+	canary := strings.Join(canaryArgs(pythonImage), " ")
+	docker := &fakeDocker{output: map[string]string{"run --rm": enforcedV2}, fail: map[string]bool{canary: true}}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newLimitProber(docker.run, pythonImage)
+	p.now = func() time.Time { return now }
+
+	// A canary that couldn't run verifies nothing.
+	if e := p.get(context.Background()); e.Error == "" || len(e.gaps()) != len(limitMechanisms) {
+		t.Fatalf("failed canary: %+v", e)
+	}
+	docker.fail = nil
+	now = now.Add(warmupRecheck / 2)
+	if e := p.get(context.Background()); e.Error == "" {
+		t.Errorf("rerun within warmupRecheck: %+v", e)
+	}
+	now = now.Add(warmupRecheck)
+	if e := p.get(context.Background()); e.Error != "" || len(e.gaps()) != 0 || e.Cgroup != "v2" {
+		t.Errorf("after retry: %+v", e)
+	}
+
+	// One that ran stands.
+	now = now.Add(time.Hour)
+	p.get(context.Background())
+	if n := len(docker.commands("run --rm")); n != 2 {
+		t.Errorf("canary ran %d times, want 2", n)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	limits := ResourceLimits{CPUShares: 512, MemoryMB: 256, PidsLimit: 50, DiskMB: 100}
+	runner := func(mode, probe string) *DockerRunner {
+		d := newTestRunner(0, "", nil)
+		d.limitMode = mode
+		d.limitProbe = newLimitProber((&fakeDocker{output: map[string]string{"run --rm": probe}}).run, pythonImage)
+		return d
+	}
+
+	// Every limit held: nothing to say but the report.
+	report, events, err := runner(LimitEnforcementStrict, enforcedV1).checkLimits(context.Background(), limits, ExecutionRequest{})
+	if err != nil || len(events) != 0 || report == nil || report.Enforced != report.Requested {
+		t.Errorf("enforced: report %+v, events %v, err %v", report, events, err)
+	}
+
+	_, _, err = runner(LimitEnforcementStrict, noSwapV1).checkLimits(context.Background(), limits, ExecutionRequest{})
+	var gaps *LimitNotEnforcedError
+	if !errors.As(err, &gaps) || !errors.Is(err, ErrLimitNotEnforced) || !slices.Equal(gaps.Gaps, []string{LimitMemorySwap}) {
+		t.Errorf("strict: err = %v", err)
+	}
+
+	var warned []string
+	report, events, err = runner(LimitEnforcementPermissive, noSwapV1).checkLimits(context.Background(), limits,
+		ExecutionRequest{LimitsNotEnforced: func(g []string) { warned = g }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LimitValues{CPUShares: 512, MemoryMB: 256, PidsLimit: 50}
+	if report.Requested.MemorySwapMB != 256 || report.Enforced != want || !slices.Equal(report.NotEnforced, []string{LimitMemorySwap}) {
+		t.Errorf("permissive: report %+v", report)
+	}
+	if len(events) != 1 || events[0].Type != "limit_not_enforced" || !strings.HasPrefix(events[0].Detail, "memory_swap: requested 256MB") {
+		t.Errorf("permissive: events %+v", events)
+	}
+	if !slices.Equal(warned, []string{LimitMemorySwap}) {
+		t.Errorf("permissive: LimitsNotEnforced got %v", warned)
+	}
+
+	// Off checks nothing.
+	d := newTestRunner(0, "", nil)
+	if report, events, err := d.checkLimits(context.Background(), limits, ExecutionRequest{}); report != nil || events != nil || err != nil {
+		t.Errorf("off: %+v, %v, %v", report, events, err)
+	}
+}
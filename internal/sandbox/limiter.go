@@ -0,0 +1,235 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	defaultPool     = "default"   // pool for languages without their own sub-limit
+	poolSlotWait    = time.Second // how long an execution waits for its pool before it is rejected
+	durationSamples = 64          // recent durations per pool used for Retry-After
+	minRetryAfter   = time.Second
+	identityLabel   = 12 // hex characters of a caller's identity in the fair share wait metric
+)
+
+// SlotObserver receives concurrency slot metrics from a backend. The wait is
+// reported per request language; slots in use are reported per pool, which
+// is a language name or "default". With sandbox.fair_share the wait is also
+// reported per caller, named by the start of its identity or "anonymous".
+type SlotObserver interface {
+	ObserveSlotWait(language string, wait time.Duration)
+	SetSlotsInUse(pool string, inUse int)
+	ObserveFairShareWait(identity string, wait time.Duration)
+}
+
+// SaturationError is returned when a language's pool stays full for longer
+// than an execution is willing to wait. RetryAfter is the pool's recent
+// median execution time.
+type SaturationError struct {
+	Pool       string
+	Size       int // the pool's slots
+	RetryAfter time.Duration
+}
+
+func (e *SaturationError) Error() string {
+	return fmt.Sprintf("%s pool saturated, retry after %s", e.Pool, e.RetryAfter)
+}
+
+func (e *SaturationError) Unwrap() error { return ErrLanguageSaturated }
+
+// slotPool is one language's share of the concurrency cap.
+type slotPool struct {
+	name  string
+	slots *slotScheduler
+
+	mu        sync.Mutex
+	durations []time.Duration // ring of recent execution times
+	next      int
+}
+
+func newSlotPool(name string, size int) *slotPool {
+	return &slotPool{name: name, slots: newSlotScheduler(size)}
+}
+
+func (p *slotPool) record(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.durations) < durationSamples {
+		p.durations = append(p.durations, d)
+		return
+	}
+	p.durations[p.next] = d
+	p.next = (p.next + 1) % durationSamples
+}
+
+// retryAfter is the median of recent execution times, rounded up to whole
+// seconds since that is all Retry-After can express.
+func (p *slotPool) retryAfter() time.Duration {
+	p.mu.Lock()
+	sorted := slices.Clone(p.durations)
+	p.mu.Unlock()
+	if len(sorted) == 0 {
+		return minRetryAfter
+	}
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2] + time.Second - 1).Truncate(time.Second)
+	return max(median, minRetryAfter)
+}
+
+// slotLimiter caps concurrent executions per language, so a burst of slow
+// sessions in one language can't starve the others. Each language with a
+// sub-limit has its own pool; the rest share the "default" pool. A global cap
+// bounds the total when the pools add up to more than it.
+type slotLimiter struct {
+	pools    map[string]*slotPool
+	total    chan struct{}
+	wait     time.Duration
+	fair     bool
+	observer SlotObserver
+}
+
+// newSlotLimiter builds a limiter from per-pool sizes, which must include
+// "default".
+func newSlotLimiter(maxConcurrent int, limits map[string]int) *slotLimiter {
+	l := &slotLimiter{
+		pools: make(map[string]*slotPool, len(limits)),
+		total: make(chan struct{}, maxConcurrent),
+		wait:  poolSlotWait,
+	}
+	for name, size := range limits {
+		l.pools[name] = newSlotPool(name, size)
+	}
+	return l
+}
+
+// newConfiguredSlotLimiter builds a limiter from sandbox.concurrency, which
+// config validation has already checked adds up to maxConcurrent.
+func newConfiguredSlotLimiter(maxConcurrent int, limits map[string]int, runtimes *runtime.Registry) (*slotLimiter, error) {
+	for name := range limits {
+		if name == defaultPool {
+			continue
+		}
+		if _, err := runtimes.Get(name); err != nil {
+			return nil, fmt.Errorf("unknown language %q (available: %s)", name, strings.Join(runtimes.Languages(), ", "))
+		}
+	}
+	return newSlotLimiter(maxConcurrent, limits), nil
+}
+
+// shareFairly makes every pool split its slots between callers when full,
+// as sandbox.fair_share describes, rather than serve waiters in arrival
+// order. A waiter goes next regardless once it has waited maxWait.
+func (l *slotLimiter) shareFairly(maxWait time.Duration) {
+	l.fair = true
+	for _, p := range l.pools {
+		p.slots.fair, p.slots.maxWait = true, maxWait
+	}
+}
+
+func (l *slotLimiter) pool(language string) *slotPool {
+	if p, ok := l.pools[language]; ok {
+		return p
+	}
+	return l.pools[defaultPool]
+}
+
+// acquire takes a slot for language on behalf of caller, waiting up to
+// l.wait for its pool. The returned release must be called once the
+// execution has finished.
+func (l *slotLimiter) acquire(ctx context.Context, language string, caller slotCaller) (func(), error) {
+	p := l.pool(language)
+	start := time.Now()
+	if w, ok := p.slots.enter(caller); !ok {
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		select {
+		case <-w.granted:
+		case <-timer.C:
+			if p.slots.leave(w) {
+				l.observeWait(language, caller, time.Since(start))
+				return nil, &SaturationError{Pool: p.name, Size: p.slots.size, RetryAfter: p.retryAfter()}
+			}
+		case <-ctx.Done():
+			if p.slots.leave(w) {
+				return nil, ctx.Err()
+			}
+			p.slots.release()
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case l.total <- struct{}{}:
+	case <-ctx.Done():
+		p.slots.release()
+		return nil, ctx.Err()
+	}
+	l.observeWait(language, caller, time.Since(start))
+	l.observeInUse(p)
+
+	running := time.Now()
+	return func() {
+		p.record(time.Since(running))
+		<-l.total
+		p.slots.release()
+		l.observeInUse(p)
+	}, nil
+}
+
+func (l *slotLimiter) observeWait(language string, caller slotCaller, wait time.Duration) {
+	if l.observer == nil {
+		return
+	}
+	l.observer.ObserveSlotWait(language, wait)
+	if l.fair {
+		identity := caller.identity[:min(identityLabel, len(caller.identity))]
+		if identity == "" {
+			identity = "anonymous"
+		}
+		l.observer.ObserveFairShareWait(identity, wait)
+	}
+}
+
+func (l *slotLimiter) observeInUse(p *slotPool) {
+	if l.observer != nil {
+		l.observer.SetSlotsInUse(p.name, p.slots.used())
+	}
+}
+
+// StatsReporter is implemented by backends that run executions under a
+// slotLimiter, for GET /stats. ok is false when the wrapped backend has none.
+type StatsReporter interface {
+	Stats() (stats SlotStats, ok bool)
+}
+
+// SlotStats is a snapshot of a backend's concurrency slots.
+type SlotStats struct {
+	Capacity int         `json:"capacity"` // executions the backend runs at once
+	InUse    int         `json:"in_use"`
+	Pools    []PoolStats `json:"pools"` // by name
+}
+
+// PoolStats is one pool's share of the slots: a language's, or "default".
+type PoolStats struct {
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+	InUse    int    `json:"in_use"`
+}
+
+// stats reads the limiter's occupancy. Slots taken between the reads can
+// make the pools add up to more than InUse.
+func (l *slotLimiter) stats() SlotStats {
+	s := SlotStats{Capacity: cap(l.total), InUse: len(l.total)}
+	for _, p := range l.pools {
+		s.Pools = append(s.Pools, PoolStats{Name: p.name, Capacity: p.slots.size, InUse: p.slots.used()})
+	}
+	slices.SortFunc(s.Pools, func(a, b PoolStats) int { return strings.Compare(a.Name, b.Name) })
+	return s
+}
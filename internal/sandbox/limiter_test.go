@@ -0,0 +1,221 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	waits  map[string][]time.Duration
+	inUse  map[string]int
+	shares map[string][]time.Duration // fair share waits by identity
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{waits: make(map[string][]time.Duration), inUse: make(map[string]int), shares: make(map[string][]time.Duration)}
+}
+
+func (o *recordingObserver) ObserveSlotWait(language string, wait time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.waits[language] = append(o.waits[language], wait)
+}
+
+func (o *recordingObserver) ObserveFairShareWait(identity string, wait time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.shares[identity] = append(o.shares[identity], wait)
+}
+
+func (o *recordingObserver) SetSlotsInUse(pool string, inUse int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inUse[pool] = inUse
+}
+
+// slowJob holds a slot like a long-running execution until release is closed.
+func slowJob(t *testing.T, l *slotLimiter, language string, release <-chan struct{}, wg *sync.WaitGroup) {
+	t.Helper()
+	done, err := l.acquire(context.Background(), language, slotCaller{})
+	if err != nil {
+		t.Fatalf("acquire %s: %v", language, err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-release
+		done()
+	}()
+}
+
+func TestSlotLimiter_SaturatedLanguageDoesNotStarveOthers(t *testing.T) {
+	l := newSlotLimiter(12, map[string]int{"claude": 2, "python": 8, defaultPool: 2})
+	l.wait = 50 * time.Millisecond
+	obs := newRecordingObserver()
+	l.observer = obs
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	slowJob(t, l, "claude", release, &wg)
+	slowJob(t, l, "claude", release, &wg)
+
+	// Python snippets keep running while claude is saturated.
+	for i := 0; i < 20; i++ {
+		done, err := l.acquire(context.Background(), "python", slotCaller{})
+		if err != nil {
+			t.Fatalf("python acquire %d: %v", i, err)
+		}
+		done()
+	}
+
+	start := time.Now()
+	_, err := l.acquire(context.Background(), "claude", slotCaller{})
+	var sat *SaturationError
+	if !errors.As(err, &sat) || !errors.Is(err, ErrLanguageSaturated) {
+		t.Fatalf("claude acquire: got %v, want a SaturationError", err)
+	}
+	if sat.Pool != "claude" || sat.RetryAfter != minRetryAfter {
+		t.Errorf("saturation = %+v, want pool claude with the minimum Retry-After", sat)
+	}
+	if waited := time.Since(start); waited < l.wait {
+		t.Errorf("rejected after %s, before the %s wait", waited, l.wait)
+	}
+
+	obs.mu.Lock()
+	if got := obs.inUse["claude"]; got != 2 {
+		t.Errorf("claude slots in use = %d, want 2", got)
+	}
+	if got := len(obs.waits["python"]); got != 20 {
+		t.Errorf("recorded %d python waits, want 20", got)
+	}
+	if w := obs.waits["claude"]; len(w) != 3 || w[2] < l.wait {
+		t.Errorf("claude waits = %v, want 3 with the last at least %s", w, l.wait)
+	}
+	obs.mu.Unlock()
+
+	close(release)
+	wg.Wait()
+	obs.mu.Lock()
+	if got := obs.inUse["claude"]; got != 0 {
+		t.Errorf("claude slots in use after release = %d, want 0", got)
+	}
+	obs.mu.Unlock()
+}
+
+func TestSlotLimiter_DefaultPool(t *testing.T) {
+	l := newSlotLimiter(3, map[string]int{"claude": 2, defaultPool: 1})
+	l.wait = 10 * time.Millisecond
+
+	done, err := l.acquire(context.Background(), "node", slotCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bash shares the default pool with node.
+	if _, err := l.acquire(context.Background(), "bash", slotCaller{}); !errors.Is(err, ErrLanguageSaturated) {
+		t.Errorf("bash acquire: got %v, want saturated default pool", err)
+	}
+	done()
+	if done, err = l.acquire(context.Background(), "bash", slotCaller{}); err != nil {
+		t.Errorf("bash acquire after release: %v", err)
+	} else {
+		done()
+	}
+}
+
+func TestSlotLimiter_Stats(t *testing.T) {
+	l := newSlotLimiter(3, map[string]int{"claude": 2, defaultPool: 2})
+	done, err := l.acquire(context.Background(), "claude", slotCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SlotStats{Capacity: 3, InUse: 1, Pools: []PoolStats{{"claude", 2, 1}, {defaultPool, 2, 0}}}
+	if got := l.stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	done()
+	if got := l.stats(); got.InUse != 0 || got.Pools[0].InUse != 0 {
+		t.Errorf("stats after release = %+v", got)
+	}
+}
+
+func TestSlotLimiter_WaitsForSlot(t *testing.T) {
+	l := newSlotLimiter(1, map[string]int{defaultPool: 1})
+	obs := newRecordingObserver()
+	l.observer = obs
+
+	done, err := l.acquire(context.Background(), "python", slotCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		done()
+	}()
+
+	// A slot that frees up within the wait is taken rather than rejected.
+	done2, err := l.acquire(context.Background(), "python", slotCaller{})
+	if err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	done2()
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if w := obs.waits["python"]; len(w) != 2 || w[1] < 100*time.Millisecond {
+		t.Errorf("python waits = %v, want the second to be at least 100ms", w)
+	}
+}
+
+func TestSlotLimiter_ContextCancelled(t *testing.T) {
+	l := newSlotLimiter(1, map[string]int{defaultPool: 1})
+	done, err := l.acquire(context.Background(), "python", slotCaller{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx, "python", slotCaller{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestSlotPool_RetryAfterIsRecentMedian(t *testing.T) {
+	p := newSlotPool("claude", 1)
+	if got := p.retryAfter(); got != minRetryAfter {
+		t.Errorf("no history: retryAfter = %s, want %s", got, minRetryAfter)
+	}
+
+	for _, d := range []time.Duration{90 * time.Second, 2 * time.Minute, 30 * time.Second, 3 * time.Minute, 100 * time.Second} {
+		p.record(d)
+	}
+	if got := p.retryAfter(); got != 100*time.Second {
+		t.Errorf("retryAfter = %s, want the 100s median", got)
+	}
+
+	// Old samples age out of the ring; sub-second medians round up.
+	for i := 0; i < durationSamples; i++ {
+		p.record(1500 * time.Millisecond)
+	}
+	if got := p.retryAfter(); got != 2*time.Second {
+		t.Errorf("retryAfter = %s, want 2s", got)
+	}
+}
+
+func TestNewConfiguredSlotLimiter_UnknownLanguage(t *testing.T) {
+	reg := runtime.NewRegistry()
+	if _, err := newConfiguredSlotLimiter(10, map[string]int{"python": 5, defaultPool: 5}, reg); err != nil {
+		t.Errorf("valid split: %v", err)
+	}
+	if _, err := newConfiguredSlotLimiter(10, map[string]int{"cobol": 5, defaultPool: 5}, reg); err == nil {
+		t.Error("unknown language accepted")
+	}
+}
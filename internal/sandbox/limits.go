@@ -11,8 +11,24 @@ type ResourceLimits struct {
 	MemoryMB  int64 `json:"memory_mb"`  // Hard memory limit
 	PidsLimit int64 `json:"pids_limit"` // Max processes (fork bomb protection)
 	DiskMB    int64 `json:"disk_mb"`    // Tmpfs size for /tmp and /workspace
+
+	// Ulimits overrides the rlimits derived from the limits above; fields
+	// left 0 keep theirs. See EffectiveUlimits.
+	Ulimits *Ulimits `json:"ulimits,omitempty"`
+}
+
+// Ulimits are the rlimits an execution's processes run under, soft and hard
+// alike.
+type Ulimits struct {
+	NoFile int64 `json:"nofile"` // Open file descriptors per process
+	NProc  int64 `json:"nproc"`  // Processes of the sandbox user
+	FSize  int64 `json:"fsize"`  // Largest file a process may write, in bytes
+	Core   int64 `json:"core"`   // Largest core dump, in bytes
 }
 
+// defaultNoFile is the nofile an execution gets with 256MB of memory or less.
+const defaultNoFile = 256
+
 func DefaultLimits() ResourceLimits {
 	return ResourceLimits{
 		CPUShares: 512,  // 0.5 CPU
@@ -33,23 +49,153 @@ func DevLimits() ResourceLimits {
 	}
 }
 
+// MaxLimits returns the largest limits Validate accepts.
+func MaxLimits() ResourceLimits {
+	return ResourceLimits{CPUShares: 8192, MemoryMB: 16384, PidsLimit: 2000, DiskMB: 10240}
+}
+
+// MaxUlimits returns the ceilings on requested ulimits when
+// sandbox.max_ulimits doesn't set them. Core dumps stay off.
+func MaxUlimits() Ulimits {
+	return Ulimits{NoFile: 65536, NProc: 2000, FSize: 10240 << 20}
+}
+
 func (rl ResourceLimits) Validate() error {
-	if rl.CPUShares < 2 || rl.CPUShares > 8192 {
-		return fmt.Errorf("%w: cpu_shares must be 2-8192, got %d", ErrInvalidRequest, rl.CPUShares)
+	max := MaxLimits()
+	if rl.CPUShares < 2 || rl.CPUShares > max.CPUShares {
+		return fmt.Errorf("%w: cpu_shares must be 2-%d, got %d", ErrInvalidRequest, max.CPUShares, rl.CPUShares)
+	}
+	if rl.MemoryMB < 16 || rl.MemoryMB > max.MemoryMB {
+		return fmt.Errorf("%w: memory_mb must be 16-%d, got %d", ErrInvalidRequest, max.MemoryMB, rl.MemoryMB)
+	}
+	if rl.PidsLimit < 5 || rl.PidsLimit > max.PidsLimit {
+		return fmt.Errorf("%w: pids_limit must be 5-%d, got %d", ErrInvalidRequest, max.PidsLimit, rl.PidsLimit)
+	}
+	if rl.DiskMB < 1 || rl.DiskMB > max.DiskMB {
+		return fmt.Errorf("%w: disk_mb must be 1-%d, got %d", ErrInvalidRequest, max.DiskMB, rl.DiskMB)
+	}
+	return nil
+}
+
+// PrivilegedMaxLimits returns the largest limits ValidatePrivileged
+// accepts, for the server's own maintenance jobs. Nothing a caller sends is
+// checked against them.
+func PrivilegedMaxLimits() ResourceLimits {
+	return ResourceLimits{CPUShares: 32768, MemoryMB: 65536, PidsLimit: 10000, DiskMB: 102400}
+}
+
+// PrivilegedLimits are limits ValidatePrivileged accepted, which may exceed
+// MaxLimits. They can only be made by ValidatePrivileged, so a request can't
+// carry them by copying a caller's limits.
+type PrivilegedLimits struct {
+	limits ResourceLimits
+}
+
+// Limits returns the limits p was made from.
+func (p *PrivilegedLimits) Limits() ResourceLimits {
+	return p.limits
+}
+
+// ValidatePrivileged checks rl against PrivilegedMaxLimits instead of
+// MaxLimits. It is for limits the operator configured, never for a
+// request's: the API checks those with Validate.
+func (rl ResourceLimits) ValidatePrivileged() (*PrivilegedLimits, error) {
+	max := PrivilegedMaxLimits()
+	if rl.CPUShares < 2 || rl.CPUShares > max.CPUShares {
+		return nil, fmt.Errorf("%w: cpu_shares must be 2-%d, got %d", ErrInvalidRequest, max.CPUShares, rl.CPUShares)
 	}
-	if rl.MemoryMB < 16 || rl.MemoryMB > 16384 {
-		return fmt.Errorf("%w: memory_mb must be 16-16384, got %d", ErrInvalidRequest, rl.MemoryMB)
+	if rl.MemoryMB < 16 || rl.MemoryMB > max.MemoryMB {
+		return nil, fmt.Errorf("%w: memory_mb must be 16-%d, got %d", ErrInvalidRequest, max.MemoryMB, rl.MemoryMB)
 	}
-	if rl.PidsLimit < 5 || rl.PidsLimit > 2000 {
-		return fmt.Errorf("%w: pids_limit must be 5-2000, got %d", ErrInvalidRequest, rl.PidsLimit)
+	if rl.PidsLimit < 5 || rl.PidsLimit > max.PidsLimit {
+		return nil, fmt.Errorf("%w: pids_limit must be 5-%d, got %d", ErrInvalidRequest, max.PidsLimit, rl.PidsLimit)
 	}
-	if rl.DiskMB < 1 || rl.DiskMB > 10240 {
-		return fmt.Errorf("%w: disk_mb must be 1-10240, got %d", ErrInvalidRequest, rl.DiskMB)
+	if rl.DiskMB < 1 || rl.DiskMB > max.DiskMB {
+		return nil, fmt.Errorf("%w: disk_mb must be 1-%d, got %d", ErrInvalidRequest, max.DiskMB, rl.DiskMB)
+	}
+	return &PrivilegedLimits{limits: rl}, nil
+}
+
+// ValidateUlimits checks the ulimits rl asks for against the ceilings in
+// max. Fields left 0 aren't checked: they take their defaults.
+func (rl ResourceLimits) ValidateUlimits(max Ulimits) error {
+	u := rl.Ulimits
+	if u == nil {
+		return nil
+	}
+	max = max.withDefaults()
+	checks := []struct {
+		name           string
+		v, least, most int64
+	}{
+		{"nofile", u.NoFile, 16, max.NoFile},
+		{"nproc", u.NProc, 5, max.NProc},
+		{"fsize", u.FSize, 1 << 20, max.FSize},
+		{"core", u.Core, 0, max.Core},
+	}
+	for _, c := range checks {
+		if c.v == 0 {
+			continue
+		}
+		if c.v > c.most {
+			return fmt.Errorf("%w: ulimits.%s must be at most %d (sandbox.max_ulimits.%s), got %d", ErrInvalidRequest, c.name, c.most, c.name, c.v)
+		}
+		if c.v < c.least {
+			return fmt.Errorf("%w: ulimits.%s must be at least %d, got %d", ErrInvalidRequest, c.name, c.least, c.v)
+		}
 	}
 	return nil
 }
 
-func ApplyResourceLimits(spec *specs.Spec, limits ResourceLimits) {
+// EffectiveUlimits returns the ulimits an execution with these limits runs
+// under. Fields rl.Ulimits leaves 0 are derived from the other limits:
+// nofile is 256, or one per MB of memory_mb above that; nproc is
+// pids_limit; fsize is disk_mb, the size of /tmp; core is 0. Derived values
+// are capped at max.
+func (rl ResourceLimits) EffectiveUlimits(max Ulimits) Ulimits {
+	max = max.withDefaults()
+	u := Ulimits{
+		NoFile: min(defaultNoFile, max.NoFile),
+		NProc:  min(rl.PidsLimit, max.NProc),
+		FSize:  min(rl.DiskMB<<20, max.FSize),
+	}
+	if rl.MemoryMB > defaultNoFile {
+		u.NoFile = min(rl.MemoryMB, max.NoFile)
+	}
+	if o := rl.Ulimits; o != nil {
+		if o.NoFile > 0 {
+			u.NoFile = o.NoFile
+		}
+		if o.NProc > 0 {
+			u.NProc = o.NProc
+		}
+		if o.FSize > 0 {
+			u.FSize = o.FSize
+		}
+		u.Core = o.Core
+	}
+	return u
+}
+
+// withDefaults fills the ceilings u leaves 0 from MaxUlimits. A core
+// ceiling of 0 is kept: it turns core dumps off.
+func (u Ulimits) withDefaults() Ulimits {
+	def := MaxUlimits()
+	if u.NoFile <= 0 {
+		u.NoFile = def.NoFile
+	}
+	if u.NProc <= 0 {
+		u.NProc = def.NProc
+	}
+	if u.FSize <= 0 {
+		u.FSize = def.FSize
+	}
+	return u
+}
+
+// ApplyResourceLimits sets limits on spec, with ulimits as the process's
+// rlimits; see ResourceLimits.EffectiveUlimits.
+func ApplyResourceLimits(spec *specs.Spec, limits ResourceLimits, ulimits Ulimits) {
 	if spec.Linux == nil {
 		spec.Linux = &specs.Linux{}
 	}
@@ -93,10 +239,10 @@ func ApplyResourceLimits(spec *specs.Spec, limits ResourceLimits) {
 	})
 
 	spec.Process.Rlimits = []specs.POSIXRlimit{
-		{Type: "RLIMIT_NOFILE", Hard: 256, Soft: 256},
-		{Type: "RLIMIT_NPROC", Hard: safeUint64(limits.PidsLimit), Soft: safeUint64(limits.PidsLimit)},
-		{Type: "RLIMIT_FSIZE", Hard: safeUint64(tmpfsBytes), Soft: safeUint64(tmpfsBytes)},
-		{Type: "RLIMIT_CORE", Hard: 0, Soft: 0},
+		{Type: "RLIMIT_NOFILE", Hard: safeUint64(ulimits.NoFile), Soft: safeUint64(ulimits.NoFile)},
+		{Type: "RLIMIT_NPROC", Hard: safeUint64(ulimits.NProc), Soft: safeUint64(ulimits.NProc)},
+		{Type: "RLIMIT_FSIZE", Hard: safeUint64(ulimits.FSize), Soft: safeUint64(ulimits.FSize)},
+		{Type: "RLIMIT_CORE", Hard: safeUint64(ulimits.Core), Soft: safeUint64(ulimits.Core)},
 		{Type: "RLIMIT_STACK", Hard: 8388608, Soft: 8388608},
 	}
 }
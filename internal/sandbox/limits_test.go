@@ -1,6 +1,13 @@
 package sandbox
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
 
 func TestDevLimits(t *testing.T) {
 	l := DevLimits()
@@ -49,6 +56,33 @@ func TestValidate_DevTierCeilings(t *testing.T) {
 	}
 }
 
+func TestValidatePrivileged(t *testing.T) {
+	elevated := ResourceLimits{CPUShares: 16384, MemoryMB: 32768, PidsLimit: 4000, DiskMB: 51200}
+	if err := elevated.Validate(); err == nil {
+		t.Error("Validate accepted limits past MaxLimits")
+	}
+	p, err := elevated.ValidatePrivileged()
+	if err != nil {
+		t.Fatalf("ValidatePrivileged() = %v", err)
+	}
+	if p.Limits() != elevated {
+		t.Errorf("Limits() = %+v, want %+v", p.Limits(), elevated)
+	}
+
+	max := PrivilegedMaxLimits()
+	for _, over := range []ResourceLimits{
+		{CPUShares: max.CPUShares + 1, MemoryMB: 256, PidsLimit: 50, DiskMB: 100},
+		{CPUShares: 512, MemoryMB: max.MemoryMB + 1, PidsLimit: 50, DiskMB: 100},
+		{CPUShares: 512, MemoryMB: 256, PidsLimit: max.PidsLimit + 1, DiskMB: 100},
+		{CPUShares: 512, MemoryMB: 256, PidsLimit: 50, DiskMB: max.DiskMB + 1},
+		{CPUShares: 512, MemoryMB: 8, PidsLimit: 50, DiskMB: 100},
+	} {
+		if _, err := over.ValidatePrivileged(); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("ValidatePrivileged(%+v) = %v, want ErrInvalidRequest", over, err)
+		}
+	}
+}
+
 func TestDefaultLimits(t *testing.T) {
 	l := DefaultLimits()
 	if l.CPUShares != 512 {
@@ -64,3 +98,84 @@ func TestDefaultLimits(t *testing.T) {
 		t.Errorf("DiskMB = %d, want 100", l.DiskMB)
 	}
 }
+
+func TestEffectiveUlimits(t *testing.T) {
+	max := MaxUlimits()
+	tests := []struct {
+		name   string
+		limits ResourceLimits
+		max    Ulimits
+		want   Ulimits
+	}{
+		{"default tier", DefaultLimits(), max, Ulimits{NoFile: 256, NProc: 50, FSize: 100 << 20}},
+		{"dev tier", DevLimits(), max, Ulimits{NoFile: 4096, NProc: 500, FSize: 2048 << 20}},
+		{"derived values are capped", DevLimits(), Ulimits{NoFile: 1024, NProc: 100, FSize: 1 << 30}, Ulimits{NoFile: 1024, NProc: 100, FSize: 1 << 30}},
+		{"zero ceilings take MaxUlimits", DevLimits(), Ulimits{}, Ulimits{NoFile: 4096, NProc: 500, FSize: 2048 << 20}},
+		{
+			"overrides",
+			ResourceLimits{CPUShares: 512, MemoryMB: 256, PidsLimit: 50, DiskMB: 100, Ulimits: &Ulimits{NoFile: 4096, Core: 1 << 20}},
+			Ulimits{NoFile: 65536, Core: 1 << 20},
+			Ulimits{NoFile: 4096, NProc: 50, FSize: 100 << 20, Core: 1 << 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.EffectiveUlimits(tt.max); got != tt.want {
+				t.Errorf("EffectiveUlimits = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUlimits(t *testing.T) {
+	limits := func(u Ulimits) ResourceLimits {
+		l := DefaultLimits()
+		l.Ulimits = &u
+		return l
+	}
+	max := Ulimits{NoFile: 4096, NProc: 1000, FSize: 1 << 30}
+	tests := []struct {
+		name    string
+		limits  ResourceLimits
+		wantErr string
+	}{
+		{"none", DefaultLimits(), ""},
+		{"zeros take defaults", limits(Ulimits{}), ""},
+		{"at the ceilings", limits(Ulimits{NoFile: 4096, NProc: 1000, FSize: 1 << 30}), ""},
+		{"nofile over", limits(Ulimits{NoFile: 4097}), "ulimits.nofile must be at most 4096 (sandbox.max_ulimits.nofile), got 4097"},
+		{"nproc over", limits(Ulimits{NProc: 1001}), "ulimits.nproc must be at most 1000 (sandbox.max_ulimits.nproc)"},
+		{"fsize over", limits(Ulimits{FSize: 1<<30 + 1}), "ulimits.fsize must be at most 1073741824 (sandbox.max_ulimits.fsize)"},
+		{"core dumps off", limits(Ulimits{Core: 1}), "ulimits.core must be at most 0 (sandbox.max_ulimits.core)"},
+		{"nofile too low", limits(Ulimits{NoFile: 3}), "ulimits.nofile must be at least 16, got 3"},
+		{"negative fsize", limits(Ulimits{FSize: -1}), "ulimits.fsize must be at least 1048576"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.ValidateUlimits(max)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateUlimits = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateUlimits = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyResourceLimits_Rlimits(t *testing.T) {
+	spec := &specs.Spec{Process: &specs.Process{}}
+	ApplyResourceLimits(spec, DefaultLimits(), Ulimits{NoFile: 1024, NProc: 50, FSize: 100 << 20})
+	want := []specs.POSIXRlimit{
+		{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024},
+		{Type: "RLIMIT_NPROC", Hard: 50, Soft: 50},
+		{Type: "RLIMIT_FSIZE", Hard: 100 << 20, Soft: 100 << 20},
+		{Type: "RLIMIT_CORE", Hard: 0, Soft: 0},
+		{Type: "RLIMIT_STACK", Hard: 8388608, Soft: 8388608},
+	}
+	if !reflect.DeepEqual(spec.Process.Rlimits, want) {
+		t.Errorf("Rlimits = %+v, want %+v", spec.Process.Rlimits, want)
+	}
+}
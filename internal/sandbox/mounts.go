@@ -0,0 +1,146 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// codeMountDir holds the code file when /workspace is taken by a workspace mount.
+const codeMountDir = "/sandbox"
+
+// resolveMountDir resolves symlinks in dir (so the checked path is the one
+// mounted) and verifies it is a directory under one of roots and clear of
+// sensitive host paths. field names the request field in error messages.
+// Requests' directories are then pinned with pinMountDir, which closes the
+// window before the mount.
+func resolveMountDir(dir string, roots []string, field string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not valid", ErrInvalidRequest, field)
+	}
+	info, err := os.Stat(realPath)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: %s is not a valid directory", ErrInvalidRequest, field)
+	}
+
+	// Block known sensitive prefixes
+	for _, prefix := range sensitivePathPrefixes {
+		if strings.HasPrefix(realPath, prefix+"/") || realPath == prefix {
+			return "", fmt.Errorf("%w: %s %q is under a sensitive path", ErrInvalidRequest, field, prefix)
+		}
+	}
+	// Block home directories containing sensitive subdirs
+	for _, dir := range sensitiveHomeDirs {
+		if strings.Contains(realPath, "/"+dir+"/") || strings.HasSuffix(realPath, "/"+dir) {
+			return "", fmt.Errorf("%w: %s contains sensitive directory %q", ErrInvalidRequest, field, dir)
+		}
+	}
+
+	// Check the path is under an allowed root. Roots are resolved too, so a
+	// root configured through a symlink still matches.
+	for _, root := range roots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if strings.HasPrefix(realPath, root+"/") || realPath == root {
+			return realPath, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not under an allowed root", ErrInvalidRequest, field)
+}
+
+// SharedMount is a validated sandbox.shared_mounts entry.
+type SharedMount struct {
+	Name          string
+	HostPath      string // symlinks resolved
+	ContainerPath string
+	languages     map[string]bool
+}
+
+// newSharedMounts resolves the configured shared mounts at startup. Host paths
+// get the same sensitive-path checks as work_dir, and every allowed language
+// must be a registered runtime.
+func newSharedMounts(cfgs []config.SharedMountConfig, runtimes *runtime.Registry) (map[string]SharedMount, error) {
+	mounts := make(map[string]SharedMount, len(cfgs))
+	for _, c := range cfgs {
+		field := "shared mount " + c.Name
+		hostPath, err := resolveMountDir(c.HostPath, []string{c.HostPath}, field)
+		if err != nil {
+			return nil, err
+		}
+		m := SharedMount{
+			Name:          c.Name,
+			HostPath:      hostPath,
+			ContainerPath: c.ContainerPath,
+			languages:     make(map[string]bool, len(c.AllowedLanguages)),
+		}
+		for _, lang := range c.AllowedLanguages {
+			if _, err := runtimes.Get(lang); err != nil {
+				return nil, fmt.Errorf("%s: unknown language %q", field, lang)
+			}
+			m.languages[lang] = true
+		}
+		mounts[c.Name] = m
+	}
+	return mounts, nil
+}
+
+// resolveSharedMounts checks a request's shared_mounts against the configured
+// mounts and returns them in request order. Errors list the names available
+// to the language.
+func resolveSharedMounts(names []string, language string, mounts map[string]SharedMount) ([]SharedMount, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var available []string
+	for name, m := range mounts {
+		if m.languages[language] {
+			available = append(available, name)
+		}
+	}
+	sort.Strings(available)
+	availableMsg := "none"
+	if len(available) > 0 {
+		availableMsg = strings.Join(available, ", ")
+	}
+
+	resolved := make([]SharedMount, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		m, ok := mounts[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: unknown shared mount %q (available to %s: %s)", ErrInvalidRequest, name, language, availableMsg)
+		case !m.languages[language]:
+			return nil, fmt.Errorf("%w: shared mount %q is not available to %s (available: %s)", ErrInvalidRequest, name, language, availableMsg)
+		case seen[name]:
+			return nil, fmt.Errorf("%w: shared mount %q listed twice", ErrInvalidRequest, name)
+		}
+		seen[name] = true
+		resolved = append(resolved, m)
+	}
+	return resolved, nil
+}
+
+// sharedMountSpecs returns the read-only OCI bind mounts for a containerd spec.
+func sharedMountSpecs(mounts []SharedMount) []specs.Mount {
+	out := make([]specs.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		out = append(out, specs.Mount{
+			Destination: m.ContainerPath,
+			Type:        "bind",
+			Source:      m.HostPath,
+			Options:     []string{"rbind", "ro"},
+		})
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func testSharedMounts(t *testing.T) map[string]SharedMount {
+	t.Helper()
+	dir := t.TempDir()
+	mounts, err := newSharedMounts([]config.SharedMountConfig{
+		{Name: "datasets", HostPath: dir, ContainerPath: "/data/datasets", AllowedLanguages: []string{"python", "bash"}},
+		{Name: "models", HostPath: dir, ContainerPath: "/data/models", AllowedLanguages: []string{"python"}},
+	}, runtime.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mounts
+}
+
+func TestNewSharedMounts_Validation(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := newSharedMounts([]config.SharedMountConfig{
+		{Name: "data", HostPath: link, ContainerPath: "/data", AllowedLanguages: []string{"python"}},
+	}, runtime.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.EvalSymlinks(dir); mounts["data"].HostPath != want {
+		t.Errorf("host path = %s, want symlink resolved to %s", mounts["data"].HostPath, want)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.SharedMountConfig
+	}{
+		{"sensitive prefix", config.SharedMountConfig{Name: "etc", HostPath: "/etc", ContainerPath: "/data", AllowedLanguages: []string{"python"}}},
+		{"missing host path", config.SharedMountConfig{Name: "gone", HostPath: filepath.Join(dir, "gone"), ContainerPath: "/data", AllowedLanguages: []string{"python"}}},
+		{"unknown language", config.SharedMountConfig{Name: "data", HostPath: dir, ContainerPath: "/data", AllowedLanguages: []string{"cobol"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSharedMounts([]config.SharedMountConfig{tt.cfg}, runtime.NewRegistry()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestResolveSharedMounts_LanguageGating(t *testing.T) {
+	mounts := testSharedMounts(t)
+
+	got, err := resolveSharedMounts([]string{"models", "datasets"}, "python", mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "models" || got[1].Name != "datasets" {
+		t.Errorf("resolved = %+v, want request order", got)
+	}
+
+	tests := []struct {
+		name     string
+		names    []string
+		language string
+		wantMsg  string
+	}{
+		{"unknown", []string{"corpus"}, "python", `unknown shared mount "corpus" (available to python: datasets, models)`},
+		{"disallowed", []string{"models"}, "bash", `shared mount "models" is not available to bash (available: datasets)`},
+		{"none for language", []string{"datasets"}, "node", "(available: none)"},
+		{"duplicate", []string{"datasets", "datasets"}, "python", "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveSharedMounts(tt.names, tt.language, mounts)
+			if !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("error = %v, want ErrInvalidRequest", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+
+	if got, err := resolveSharedMounts(nil, "node", mounts); got != nil || err != nil {
+		t.Errorf("no mounts requested = %v, %v", got, err)
+	}
+}
+
+func TestSharedMountSpecs(t *testing.T) {
+	mounts := testSharedMounts(t)
+	resolved, err := resolveSharedMounts([]string{"datasets"}, "python", mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs := sharedMountSpecs(resolved)
+	if len(specs) != 1 {
+		t.Fatalf("got %d mounts, want 1", len(specs))
+	}
+	m := specs[0]
+	if m.Destination != "/data/datasets" || m.Source != mounts["datasets"].HostPath || m.Type != "bind" {
+		t.Errorf("mount = %+v", m)
+	}
+	if strings.Join(m.Options, ",") != "rbind,ro" {
+		t.Errorf("options = %v, want rbind,ro", m.Options)
+	}
+}
+
+func TestRunnerValidateRequest_SharedMounts(t *testing.T) {
+	r := &Runner{runtimes: runtime.NewRegistry(), sharedMounts: testSharedMounts(t)}
+
+	if err := r.validateRequest(&ExecutionRequest{Language: "bash", Code: "ls /data/datasets", SharedMounts: []string{"datasets"}}); err != nil {
+		t.Errorf("allowed mount: %v", err)
+	}
+	if err := r.validateRequest(&ExecutionRequest{Language: "bash", Code: "ls", SharedMounts: []string{"models"}}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("disallowed mount: error = %v, want ErrInvalidRequest", err)
+	}
+}
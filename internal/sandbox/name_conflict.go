@@ -0,0 +1,142 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// dockerNameConflict begins the docker CLI's error when the name of the
+	// container it was to create is taken.
+	dockerNameConflict = "docker: Error response from daemon: Conflict."
+	// maxConflictHeld caps the stderr a conflictGuard holds back once it
+	// begins like a name conflict. docker's message is well under it.
+	maxConflictHeld = 4 << 10
+)
+
+// nameConflictHolder finds the container holding the name in docker's
+// conflict error.
+var nameConflictHolder = regexp.MustCompile(`already in use by container "([0-9a-f]{12,64})"`)
+
+// containerName is the name of execID's container: the server's instance
+// ID is in it, so no container a crashed server left behind holds a name
+// this one picks.
+func (d *DockerRunner) containerName(execID string) string {
+	return "sandbox-" + d.instance + "-" + execID
+}
+
+// nameConflictRemovable reports whether the container holding a name the
+// runner picked, labeled instanceLabel=label, may be force-removed: only if
+// another server started it. Without the label it isn't a sandbox
+// container, and with this server's it is a live execution's.
+func nameConflictRemovable(label, instance string) bool {
+	return label != "" && label != instance
+}
+
+// clearNameConflict force-removes holder, the container holding name, if
+// nameConflictRemovable allows it, and reports whether it did.
+func (d *DockerRunner) clearNameConflict(ctx context.Context, name, holder string) bool {
+	if holder == "" {
+		holder = name
+	}
+	logger := log.With().Str("container", name).Str("holder", holder).Logger()
+	out, err := d.dockerOutput(ctx, "inspect", "--type", "container", "--format", `{{index .Config.Labels "`+instanceLabel+`"}}`, holder)
+	if err != nil {
+		logger.Warn().Err(err).Msg("inspecting the container holding an execution's name failed")
+		return false
+	}
+	label := strings.TrimSpace(string(out))
+	if !nameConflictRemovable(label, d.instance) {
+		logger.Error().Str("instance", label).Msg("container name taken by a container this server may not remove")
+		return false
+	}
+	logger.Warn().Str("instance", label).Msg("removing another server's container holding an execution's name")
+	if err := d.removeContainer(ctx, holder); err != nil {
+		logger.Warn().Err(err).Msg("removing the container holding an execution's name failed")
+		return false
+	}
+	return true
+}
+
+// conflictGuard holds back the start of docker run's stderr while it reads
+// like a name conflict, so a conflict the runner resolves and retries never
+// reaches the caller. Anything else is passed on as soon as it differs.
+type conflictGuard struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	held    []byte
+	passing bool
+}
+
+func newConflictGuard(w io.Writer) *conflictGuard {
+	return &conflictGuard{w: w}
+}
+
+func (g *conflictGuard) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.passing {
+		return g.w.Write(p)
+	}
+	g.held = append(g.held, p...)
+	n := min(len(g.held), len(dockerNameConflict))
+	if string(g.held[:n]) == dockerNameConflict[:n] && len(g.held) <= maxConflictHeld {
+		return len(p), nil
+	}
+	if err := g.flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// conflict reports whether docker run, ending in err, failed on a name
+// conflict, and the ID of the container holding the name if docker said.
+func (g *conflictGuard) conflict(err error) (holder string, ok bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 125 {
+		return "", false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.passing || !strings.HasPrefix(string(g.held), dockerNameConflict) {
+		return "", false
+	}
+	if m := nameConflictHolder.FindSubmatch(g.held); m != nil {
+		holder = string(m[1])
+	}
+	return holder, true
+}
+
+// reset drops what is held, for a retry.
+func (g *conflictGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held, g.passing = nil, false
+}
+
+// release passes on what is held, for a run that won't be retried.
+func (g *conflictGuard) release() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.flush()
+}
+
+// flush passes on what is held and everything after it. Called with mu
+// held.
+func (g *conflictGuard) flush() error {
+	g.passing = true
+	if len(g.held) == 0 {
+		return nil
+	}
+	_, err := g.w.Write(g.held)
+	g.held = nil
+	return err
+}
@@ -0,0 +1,216 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const conflictHolderID = "0123456789abcdef0123"
+
+// conflictDockerRunner is a fake docker whose run fails on a name conflict
+// while $STATE/taken exists. rm of the holder frees the name unless
+// $STATE/sticky exists; inspect prints $STATE/label as the holder's
+// instance label. Every rm is logged to $STATE/rm and every run to
+// $STATE/runs.
+func conflictDockerRunner(t *testing.T, label string) (*DockerRunner, string) {
+	t.Helper()
+	d := scriptedDockerRunner(t, `echo run >> "$STATE/runs"
+if [ -f "$STATE/taken" ]; then
+  echo 'docker: Error response from daemon: Conflict. The container name "/x" is already in use by container "`+conflictHolderID+`". You have to remove (or rename) that container to be able to reuse that name.' >&2
+  exit 125
+fi
+echo ran`)
+	dir := os.Getenv("STATE")
+	script := `#!/bin/sh
+case "$1" in
+inspect) case "$*" in *` + instanceLabel + `*) cat "$STATE/label"; exit 0 ;; esac ;;
+rm) echo "$3" >> "$STATE/rm"; [ "$3" = ` + conflictHolderID + ` ] && [ ! -f "$STATE/sticky" ] && rm -f "$STATE/taken"; exit 0 ;;
+esac
+exec "$STATE/docker-base" "$@"
+`
+	if err := os.Rename(filepath.Join(dir, "docker"), filepath.Join(dir, "docker-base")); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"docker": script, "label": label + "\n", "taken": ""} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d, dir
+}
+
+func stateLines(t *testing.T, dir, name string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(b))
+}
+
+func TestNameConflictRemovable(t *testing.T) {
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"crashed-server", true},
+		{"this-server", false}, // a live execution of ours
+		{"", false},            // not a sandbox container
+	}
+	for _, tt := range tests {
+		if got := nameConflictRemovable(tt.label, "this-server"); got != tt.want {
+			t.Errorf("nameConflictRemovable(%q) = %v, want %v", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestConflictGuard(t *testing.T) {
+	exit125 := exec.Command("sh", "-c", "exit 125").Run()
+	conflictMsg := dockerNameConflict + ` The container name "/x" is already in use by container "` + conflictHolderID + `".` + "\n"
+
+	var out bytes.Buffer
+	g := newConflictGuard(&out)
+	g.Write([]byte("Traceback"))
+	g.Write([]byte(" (most recent call last)\n"))
+	if out.String() != "Traceback (most recent call last)\n" {
+		t.Errorf("other stderr passed on as %q", out.String())
+	}
+	if _, ok := g.conflict(exit125); ok {
+		t.Error("stderr that isn't docker's conflict read as one")
+	}
+
+	out.Reset()
+	g = newConflictGuard(&out)
+	g.Write([]byte(conflictMsg[:10]))
+	g.Write([]byte(conflictMsg[10:]))
+	if out.Len() != 0 {
+		t.Errorf("conflict passed on before release: %q", out.String())
+	}
+	if holder, ok := g.conflict(exit125); !ok || holder != conflictHolderID {
+		t.Errorf("conflict() = %q, %v; want %s", holder, ok, conflictHolderID)
+	}
+	if _, ok := g.conflict(errors.New("signal: killed")); ok {
+		t.Error("conflict read from a run that didn't exit 125")
+	}
+	g.reset()
+	g.Write([]byte("retried\n"))
+	if out.String() != "retried\n" {
+		t.Errorf("after reset: %q", out.String())
+	}
+
+	out.Reset()
+	g = newConflictGuard(&out)
+	g.Write([]byte(conflictMsg))
+	if err := g.release(); err != nil || out.String() != conflictMsg {
+		t.Errorf("release() = %v, passed on %q", err, out.String())
+	}
+}
+
+func TestDockerRunner_NameConflict(t *testing.T) {
+	req := ExecutionRequest{Language: "python", Code: "print('ran')", Timeout: 5 * time.Second}
+
+	t.Run("another server's container is removed and the run retried", func(t *testing.T) {
+		d, dir := conflictDockerRunner(t, "crashed-server")
+		var stderr bytes.Buffer
+		result, err := d.ExecuteStreaming(context.Background(), req, &bytes.Buffer{}, &stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitClass != ExitUser || result.Output != "ran\n" || strings.Contains(result.Stderr+stderr.String(), "Conflict") {
+			t.Errorf("result %s %q, stderr %q", result.ExitClass, result.Output, result.Stderr+stderr.String())
+		}
+		if runs := stateLines(t, dir, "runs"); len(runs) != 2 {
+			t.Errorf("%d runs, want 2", len(runs))
+		}
+		if rm := stateLines(t, dir, "rm"); len(rm) == 0 || rm[0] != conflictHolderID {
+			t.Errorf("removed %q, want the holder first", rm)
+		}
+	})
+
+	for _, label := range []string{"", "self"} {
+		t.Run("holder labeled "+label+" is left alone", func(t *testing.T) {
+			d, dir := conflictDockerRunner(t, label)
+			if label == "self" {
+				os.WriteFile(filepath.Join(dir, "label"), []byte(d.instance+"\n"), 0o644)
+			}
+			result, err := d.Execute(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitClass != ExitInfraError {
+				t.Errorf("conflict ended as %s", result.ExitClass)
+			}
+			if runs := stateLines(t, dir, "runs"); len(runs) != 1 {
+				t.Errorf("%d runs, want 1", len(runs))
+			}
+			if rm := stateLines(t, dir, "rm"); len(rm) != 0 {
+				t.Errorf("removed %q, want nothing", rm)
+			}
+		})
+	}
+
+	t.Run("retried only once", func(t *testing.T) {
+		d, dir := conflictDockerRunner(t, "crashed-server")
+		os.WriteFile(filepath.Join(dir, "sticky"), nil, 0o644)
+		var stderr bytes.Buffer
+		result, err := d.ExecuteStreaming(context.Background(), req, &bytes.Buffer{}, &stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitClass != ExitInfraError {
+			t.Errorf("conflict ended as %s", result.ExitClass)
+		}
+		if runs := stateLines(t, dir, "runs"); len(runs) != 2 {
+			t.Errorf("%d runs, want 2", len(runs))
+		}
+		// The holder once, and never the name: it's still someone else's.
+		if rm := stateLines(t, dir, "rm"); len(rm) != 1 || rm[0] != conflictHolderID {
+			t.Errorf("removed %q, want only the holder", rm)
+		}
+		if !strings.Contains(stderr.String(), "Conflict") {
+			t.Errorf("the unresolved conflict wasn't passed on: %q", result.Stderr+stderr.String())
+		}
+	})
+}
+
+func TestDockerRunner_WaitsForStartupSweep(t *testing.T) {
+	d := scriptedDockerRunner(t, `[ -f "$STATE/swept" ] && echo ran || echo early`)
+	dir := os.Getenv("STATE")
+	script := `#!/bin/sh
+[ "$1" = ps ] && sleep 0.3 && touch "$STATE/swept" && exit 0
+exec "$STATE/docker-base" "$@"
+`
+	if err := os.Rename(filepath.Join(dir, "docker"), filepath.Join(dir, "docker-base")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	d.startOrphanCleanup(true)
+	defer d.cancelCleanup()
+
+	result, err := d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "print('ran')", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != "ran\n" {
+		t.Errorf("output %q: the container was created before the startup sweep finished", result.Output)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	d.swept = make(chan struct{})
+	if err := d.awaitStartupSweep(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("awaitStartupSweep() = %v, want a deadline error", err)
+	}
+}
@@ -0,0 +1,270 @@
+package sandbox
+
+import (
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// truncatedMarker ends captured output that was cut at its cap.
+const truncatedMarker = "\n... [output truncated]"
+
+// OutputLimits caps, in bytes, the output an execution keeps and streams:
+// each of stdout and stderr, and the two together. A zero field takes its
+// default.
+type OutputLimits struct {
+	Stdout int
+	Stderr int
+	Total  int
+}
+
+// DefaultOutputLimits returns the caps used unless sandbox.output sets
+// others: 1MB of stdout and 256KB of stderr, 1MB between them.
+func DefaultOutputLimits() OutputLimits {
+	return OutputLimits{Stdout: 1 << 20, Stderr: 256 * 1024, Total: 1 << 20}
+}
+
+func (l OutputLimits) withDefaults() OutputLimits {
+	d := DefaultOutputLimits()
+	if l.Stdout <= 0 {
+		l.Stdout = d.Stdout
+	}
+	if l.Stderr <= 0 {
+		l.Stderr = d.Stderr
+	}
+	if l.Total <= 0 {
+		l.Total = d.Total
+	}
+	return l
+}
+
+// OutputBudget admits an execution's stdout and stderr against its
+// OutputLimits, with one account for the pair. What it admits is both
+// captured for the result and passed on to the writers it was made with,
+// so a streaming client, the result and the audit record see the same
+// bytes up to their caps. A cap cuts on a UTF-8 rune boundary, and an
+// incomplete rune at the end of a write is held back until the next write
+// completes it, so no write passed on ends mid-rune. Output past a cap is
+// dropped, not buffered.
+type OutputBudget struct {
+	mu     sync.Mutex
+	limits OutputLimits
+	used   int // admitted from both streams
+	stdout outputStream
+	stderr outputStream
+	merged *mergedOutput // nil unless Merge was called
+}
+
+type outputStream struct {
+	b         *OutputBudget
+	name      string // stdout or stderr, for OutputEvent
+	limit     int
+	buf       []byte // admitted
+	held      []byte // an incomplete rune the last write ended with
+	dst       io.Writer
+	truncated bool
+}
+
+// NewOutputBudget returns a budget for one execution's output, passing what
+// it admits on to stdout and stderr.
+func NewOutputBudget(limits OutputLimits, stdout, stderr io.Writer) *OutputBudget {
+	limits = limits.withDefaults()
+	b := &OutputBudget{limits: limits}
+	b.stdout = outputStream{b: b, name: "stdout", limit: limits.Stdout, dst: stdout}
+	b.stderr = outputStream{b: b, name: "stderr", limit: limits.Stderr, dst: stderr}
+	return b
+}
+
+// OutputEvent marks where, in merged output, a run of one stream's bytes
+// begins. The run ends where the next event's does, or at the end of the
+// output.
+type OutputEvent struct {
+	Stream string `json:"stream"` // stdout or stderr
+	Offset int    `json:"offset"` // in bytes from the start of the output
+}
+
+// mergedOutput is what both streams admitted, in the order it was.
+type mergedOutput struct {
+	buf    []byte
+	events []OutputEvent
+}
+
+// Merge has b also keep the two streams as one capture, for a request with
+// MergeOutput. It is called before the container writes anything.
+//
+// The order is the order in which the runner's reads of the two streams
+// reached the budget, which is exact for writes the program made
+// unbuffered and further apart than the runner takes to read a pipe.
+// Beyond that nothing can be promised: docker and containerd hand the
+// streams over as two pipes, so writes close together may be read in
+// either order, and a write larger than a pipe's buffer may be read in
+// pieces interleaved with the other stream's. What the program buffers
+// itself (C stdio on a pipe, say) reaches the pipe only when flushed. The
+// writers b passes output on to see it in this same order, since they are
+// written to under b's lock.
+func (b *OutputBudget) Merge() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.merged = &mergedOutput{}
+}
+
+// mergeInto replaces result's Output and Stderr with the merged capture and
+// its events, when b merges. Output must have been called first, so the
+// bytes held back are in it.
+func (b *OutputBudget) mergeInto(result *ExecutionResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.merged == nil {
+		return
+	}
+	result.Output = string(b.merged.buf)
+	if b.stdout.truncated || b.stderr.truncated {
+		result.Output += truncatedMarker
+	}
+	result.Stderr = ""
+	result.OutputEvents = append([]OutputEvent{}, b.merged.events...)
+}
+
+// Stdout returns the writer for the container's stdout.
+func (b *OutputBudget) Stdout() io.Writer { return &b.stdout }
+
+// Stderr returns the writer for the container's stderr.
+func (b *OutputBudget) Stderr() io.Writer { return &b.stderr }
+
+// Output returns the captured stdout and stderr, each ending with a marker
+// if it was cut. It is called once the container has exited: the bytes
+// still held back, which no write will now complete, are admitted first.
+func (b *OutputBudget) Output() (stdout, stderr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range []*outputStream{&b.stdout, &b.stderr} {
+		if len(s.held) == 0 {
+			continue
+		}
+		data := s.held
+		s.held = nil
+		if room := s.room(); len(data) > room {
+			data, s.truncated = data[:room], true
+		}
+		// A client that can't take them now would have failed an earlier
+		// write; the result has them either way.
+		_ = s.admit(data)
+	}
+	return b.stdout.String(), b.stderr.String()
+}
+
+// Captured returns the stdout and stderr captured so far, for a caller
+// reading them while the container still runs; a cut stream ends with the
+// marker.
+func (b *OutputBudget) Captured() (stdout, stderr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stdout.String(), b.stderr.String()
+}
+
+// PartialOutput lets a caller read an execution's output while it runs.
+// The backend attaches the execution's OutputBudget to it once the output
+// has somewhere to go. A nil *PartialOutput is ignored.
+type PartialOutput struct {
+	mu     sync.Mutex
+	budget *OutputBudget
+}
+
+// Attach makes b the budget Output reads.
+func (p *PartialOutput) Attach(b *OutputBudget) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.budget = b
+}
+
+// Output returns what the execution has written so far: nothing before
+// the runner has attached its budget.
+func (p *PartialOutput) Output() (stdout, stderr string) {
+	if p == nil {
+		return "", ""
+	}
+	p.mu.Lock()
+	b := p.budget
+	p.mu.Unlock()
+	if b == nil {
+		return "", ""
+	}
+	return b.Captured()
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	b := s.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.truncated || len(p) == 0 {
+		return len(p), nil
+	}
+
+	data := p
+	if len(s.held) > 0 {
+		data = append(s.held, p...)
+		s.held = nil
+	}
+	if room := s.room(); len(data) > room {
+		data, s.truncated = data[:room], true
+		data = data[:len(data)-incompleteRune(data)]
+	} else if n := incompleteRune(data); n > 0 {
+		s.held = append([]byte(nil), data[len(data)-n:]...)
+		data = data[:len(data)-n]
+	}
+	if err := s.admit(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// room is what the stream may still admit, under its own cap and the
+// pair's. The budget's lock must be held.
+func (s *outputStream) room() int {
+	return max(0, min(s.limit-len(s.buf), s.b.limits.Total-s.b.used))
+}
+
+func (s *outputStream) admit(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s.buf = append(s.buf, data...)
+	s.b.used += len(data)
+	if m := s.b.merged; m != nil {
+		if len(m.events) == 0 || m.events[len(m.events)-1].Stream != s.name {
+			m.events = append(m.events, OutputEvent{Stream: s.name, Offset: len(m.buf)})
+		}
+		m.buf = append(m.buf, data...)
+	}
+	if s.dst == nil {
+		return nil
+	}
+	_, err := s.dst.Write(data)
+	return err
+}
+
+func (s *outputStream) String() string {
+	if s.truncated {
+		return string(s.buf) + truncatedMarker
+	}
+	return string(s.buf)
+}
+
+// incompleteRune returns the length of the incomplete UTF-8 sequence p ends
+// with: a multi-byte lead byte and fewer continuation bytes than it needs.
+// Invalid bytes are not held for a rune they can never complete.
+func incompleteRune(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if p[i] >= 0xC0 && !utf8.FullRune(p[i:]) {
+			return len(p) - i
+		}
+		return 0
+	}
+	return 0
+}
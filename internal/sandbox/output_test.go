@@ -0,0 +1,281 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"safe-agent-sandbox/pkg/stream"
+)
+
+func TestOutputBudget_RuneBoundary(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		limit  int
+		want   string
+		trunc  bool
+	}{
+		{"fits", []string{"héllo"}, 16, "héllo", false},
+		{"exact", []string{"héllo"}, 6, "héllo", false},
+		{"cap inside a rune", []string{"aé"}, 2, "a", true},
+		{"cap inside a 4-byte rune", []string{"ab😀"}, 5, "ab", true},
+		{"rune split across writes", []string{"a\xc3", "\xa9b"}, 16, "aéb", false},
+		{"split rune past the cap", []string{"a\xc3", "\xa9b"}, 2, "a", true},
+		{"ends mid-rune", []string{"a\xe2\x82"}, 16, "a\xe2\x82", false},
+		{"invalid bytes kept", []string{"\xff\xfe", "ok"}, 16, "\xff\xfeok", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var streamed bytes.Buffer
+			b := NewOutputBudget(OutputLimits{Stdout: tt.limit, Total: tt.limit}, &streamed, nil)
+			for _, w := range tt.writes {
+				if n, err := b.Stdout().Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			got, _ := b.Output()
+			want := tt.want
+			if tt.trunc {
+				want += truncatedMarker
+			}
+			if got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+			if streamed.String() != tt.want {
+				t.Errorf("streamed %q, want %q", streamed.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputBudget_SharedTotal(t *testing.T) {
+	var out, errOut bytes.Buffer
+	b := NewOutputBudget(OutputLimits{Stdout: 8, Stderr: 8, Total: 10}, &out, &errOut)
+	io.WriteString(b.Stdout(), "123456")
+	io.WriteString(b.Stderr(), "abcdef")
+	io.WriteString(b.Stdout(), "78")
+
+	stdout, stderr := b.Output()
+	if stdout != "123456"+truncatedMarker || stderr != "abcd"+truncatedMarker {
+		t.Errorf("output = %q, %q", stdout, stderr)
+	}
+	if out.String() != "123456" || errOut.String() != "abcd" {
+		t.Errorf("streamed %q, %q", out.String(), errOut.String())
+	}
+
+	// Each stream's own cap still applies under the total.
+	b = NewOutputBudget(OutputLimits{Stdout: 4, Stderr: 4, Total: 100}, nil, nil)
+	io.WriteString(b.Stdout(), "123456")
+	io.WriteString(b.Stderr(), "ab")
+	if stdout, stderr := b.Output(); stdout != "1234"+truncatedMarker || stderr != "ab" {
+		t.Errorf("output = %q, %q", stdout, stderr)
+	}
+
+	if l := (OutputLimits{Stderr: 7}).withDefaults(); l.Stdout != 1<<20 || l.Stderr != 7 || l.Total != 1<<20 {
+		t.Errorf("withDefaults = %+v", l)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("client gone") }
+
+func TestOutputBudget_DownstreamError(t *testing.T) {
+	b := NewOutputBudget(OutputLimits{}, failingWriter{}, nil)
+	if _, err := io.WriteString(b.Stdout(), "lost"); err == nil {
+		t.Error("downstream error not returned")
+	}
+	if stdout, _ := b.Output(); stdout != "lost" {
+		t.Errorf("output = %q, want it captured anyway", stdout)
+	}
+}
+
+// TestOutputBudget_StreamedEqualsStored writes a multi-byte corpus through
+// the budget in random chunks, streaming it as SSE events, and checks the
+// client reads exactly what the result keeps, cut on a rune boundary.
+func TestOutputBudget_StreamedEqualsStored(t *testing.T) {
+	alphabet := []rune("aZ9 \né€😀ßж中\t")
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		corpus := map[string]string{}
+		for _, ev := range []string{stream.EventStdout, stream.EventStderr} {
+			var b strings.Builder
+			for i := rng.Intn(400); i > 0; i-- {
+				b.WriteRune(alphabet[rng.Intn(len(alphabet))])
+			}
+			corpus[ev] = b.String()
+		}
+		limits := OutputLimits{Stdout: 1 + rng.Intn(300), Stderr: 1 + rng.Intn(300), Total: 1 + rng.Intn(500)}
+
+		var wire bytes.Buffer
+		sw := stream.NewWriter(&wire)
+		budget := NewOutputBudget(limits, eventWriter{sw, stream.EventStdout}, eventWriter{sw, stream.EventStderr})
+		writers := map[string]io.Writer{stream.EventStdout: budget.Stdout(), stream.EventStderr: budget.Stderr()}
+		rest := maps.Clone(corpus)
+		for len(rest[stream.EventStdout])+len(rest[stream.EventStderr]) > 0 {
+			// Interleave the streams in chunks that split runes.
+			ev := stream.EventStdout
+			if rest[ev] == "" || rest[stream.EventStderr] != "" && rng.Intn(3) == 0 {
+				ev = stream.EventStderr
+			}
+			n := min(len(rest[ev]), 1+rng.Intn(9))
+			writers[ev].Write([]byte(rest[ev][:n]))
+			rest[ev] = rest[ev][n:]
+		}
+		stdout, stderr := budget.Output()
+
+		streamed := map[string]string{}
+		r := stream.NewReader(&wire)
+		for {
+			e, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.ValidString(e.Data) {
+				t.Fatalf("trial %d: event ends mid-rune: %q", trial, e.Data)
+			}
+			streamed[e.Type] += e.Data
+		}
+
+		for _, c := range []struct{ event, stored string }{{stream.EventStdout, stdout}, {stream.EventStderr, stderr}} {
+			kept := strings.TrimSuffix(c.stored, truncatedMarker)
+			if streamed[c.event] != kept {
+				t.Fatalf("trial %d %s: streamed %q, stored %q", trial, c.event, streamed[c.event], kept)
+			}
+			if !strings.HasPrefix(corpus[c.event], kept) || !utf8.ValidString(kept) {
+				t.Fatalf("trial %d %s: stored %q is not a valid prefix of %q", trial, c.event, kept, corpus[c.event])
+			}
+			if (kept != corpus[c.event]) != strings.HasSuffix(c.stored, truncatedMarker) {
+				t.Fatalf("trial %d %s: cut without the marker or marked without a cut", trial, c.event)
+			}
+		}
+		if len(strings.TrimSuffix(stdout, truncatedMarker))+len(strings.TrimSuffix(stderr, truncatedMarker)) > limits.Total {
+			t.Fatalf("trial %d: kept more than the total cap %d", trial, limits.Total)
+		}
+	}
+}
+
+// eventWriter is what the API's SSE writers do with a write: one event.
+type eventWriter struct {
+	sw    *stream.Writer
+	event string
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	return len(p), w.sw.WriteEvent(stream.Event{Type: w.event, Data: string(p)})
+}
+
+func TestOutputBudget_Merge(t *testing.T) {
+	var wire bytes.Buffer
+	sw := stream.NewWriter(&wire)
+	budget := NewOutputBudget(OutputLimits{Stdout: 10, Stderr: 100, Total: 100},
+		eventWriter{sw, stream.EventStdout}, eventWriter{sw, stream.EventStderr})
+	budget.Merge()
+	io.WriteString(budget.Stdout(), "a1\n")
+	io.WriteString(budget.Stdout(), "a2\n")
+	io.WriteString(budget.Stderr(), "Traceback\n")
+	io.WriteString(budget.Stdout(), "a3\n€") // cut at stdout's cap, on a rune boundary
+	io.WriteString(budget.Stderr(), "Error\n")
+
+	stdout, stderr := budget.Output()
+	if stdout != "a1\na2\na3\n"+truncatedMarker || stderr != "Traceback\nError\n" {
+		t.Errorf("separate capture %q, %q changed by merging", stdout, stderr)
+	}
+	result := &ExecutionResult{Output: stdout, Stderr: stderr}
+	budget.mergeInto(result)
+	if want := "a1\na2\nTraceback\na3\nError\n" + truncatedMarker; result.Output != want || result.Stderr != "" {
+		t.Errorf("merged output %q, stderr %q; want %q", result.Output, result.Stderr, want)
+	}
+	wantEvents := []OutputEvent{{"stdout", 0}, {"stderr", 6}, {"stdout", 16}, {"stderr", 19}}
+	if !slices.Equal(result.OutputEvents, wantEvents) {
+		t.Errorf("events %+v, want %+v", result.OutputEvents, wantEvents)
+	}
+
+	// The events on the wire come in the same order, with their types.
+	var streamed []string
+	r := stream.NewReader(&wire)
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed = append(streamed, e.Type+":"+e.Data)
+	}
+	if want := []string{"stdout:a1\n", "stdout:a2\n", "stderr:Traceback\n", "stdout:a3\n", "stderr:Error\n"}; !slices.Equal(streamed, want) {
+		t.Errorf("streamed %q, want %q", streamed, want)
+	}
+}
+
+func TestOutputBudget_MergeIntoWithoutMerge(t *testing.T) {
+	budget := NewOutputBudget(OutputLimits{}, nil, nil)
+	io.WriteString(budget.Stdout(), "out\n")
+	io.WriteString(budget.Stderr(), "err\n")
+	stdout, stderr := budget.Output()
+	result := &ExecutionResult{Output: stdout, Stderr: stderr}
+	budget.mergeInto(result)
+	if result.Output != "out\n" || result.Stderr != "err\n" || result.OutputEvents != nil {
+		t.Errorf("result %q, %q, %+v changed without Merge", result.Output, result.Stderr, result.OutputEvents)
+	}
+}
+
+// TestDockerRunner_MergeOutput runs a program that alternates prints to
+// stdout and stderr, far enough apart that the runner reads them in order.
+func TestDockerRunner_MergeOutput(t *testing.T) {
+	d := scriptedDockerRunner(t, `for i in 1 2 3; do echo "out $i"; sleep 0.05; echo "err $i" >&2; sleep 0.05; done`)
+	req := ExecutionRequest{Language: "python", Code: "print(1)", Timeout: 5 * time.Second}
+
+	result, err := d.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != "out 1\nout 2\nout 3\n" || result.Stderr != "err 1\nerr 2\nerr 3\n" || result.OutputEvents != nil {
+		t.Errorf("without merge_output: %q, %q, %+v", result.Output, result.Stderr, result.OutputEvents)
+	}
+
+	req.MergeOutput = true
+	var order []string
+	record := func(name string) io.Writer {
+		return writerFunc(func(p []byte) (int, error) {
+			order = append(order, name+":"+string(p))
+			return len(p), nil
+		})
+	}
+	result, err = d.ExecuteStreaming(context.Background(), req, record("stdout"), record("stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "out 1\nerr 1\nout 2\nerr 2\nout 3\nerr 3\n"; result.Output != want || result.Stderr != "" {
+		t.Errorf("merged output %q, stderr %q; want %q", result.Output, result.Stderr, want)
+	}
+	for i, e := range result.OutputEvents {
+		want := OutputEvent{Stream: []string{"stdout", "stderr"}[i%2], Offset: 6 * i}
+		if e != want {
+			t.Errorf("event %d: %+v, want %+v", i, e, want)
+		}
+	}
+	if len(result.OutputEvents) != 6 {
+		t.Errorf("%d events, want 6", len(result.OutputEvents))
+	}
+	if want := []string{"stdout:out 1\n", "stderr:err 1\n", "stdout:out 2\n", "stderr:err 2\n", "stdout:out 3\n", "stderr:err 3\n"}; !slices.Equal(order, want) {
+		t.Errorf("streamed %q, want %q", order, want)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How the Docker backend enforces each of a SecurityProfile's paths. The
+// docker CLI has no flag for masked or read-only paths, so dockerPathArgs
+// picks the closest option per path.
+const (
+	// pathDaemon: left to the daemon's own masked and read-only paths.
+	// runc refuses any mount under /proc whose source isn't procfs, so a
+	// masked /proc path can't be over-mounted from here.
+	pathDaemon = "daemon"
+	// pathTmpfs: a masked directory gets an empty read-only tmpfs.
+	pathTmpfs = "tmpfs"
+	// pathDevNull: a masked file outside /proc gets /dev/null bound over it.
+	pathDevNull = "devnull"
+	// pathBindRO: a /proc path the daemon leaves writable is bound read-only
+	// over itself, which runc accepts because the source is procfs.
+	pathBindRO = "bind_ro"
+	// pathSysfs: sysfs is read-only in containers that aren't privileged.
+	pathSysfs = "sysfs"
+	// pathRootfs: covered by --read-only, which claude runs without.
+	pathRootfs = "rootfs"
+	// pathAbsent: the host has no such path, so there is nothing to hide;
+	// runc would fail creating a mount point on read-only sysfs.
+	pathAbsent = "absent"
+)
+
+// dockerDaemonReadonly are the /proc paths a default Docker daemon makes
+// read-only, plus /proc/asound, which it masks. Its masked paths outside
+// /proc vary by version and daemon configuration, so those are never left
+// to it.
+var dockerDaemonReadonly = []string{"/proc/asound", "/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger"}
+
+// dockerPathMode decides how path, masked or read-only, is enforced. stat
+// looks the path up on the host, whose /sys containers see.
+func dockerPathMode(p string, masked bool, stat func(string) (fs.FileInfo, error)) string {
+	inProc := p == "/proc" || strings.HasPrefix(p, "/proc/")
+	inSys := p == "/sys" || strings.HasPrefix(p, "/sys/")
+	switch {
+	case masked && inProc:
+		return pathDaemon
+	case masked:
+		info, err := stat(p)
+		switch {
+		case err != nil:
+			return pathAbsent
+		case info.IsDir():
+			return pathTmpfs
+		default:
+			return pathDevNull
+		}
+	case inProc && slices.Contains(dockerDaemonReadonly, p):
+		return pathDaemon
+	case inProc:
+		return pathBindRO
+	case inSys:
+		return pathSysfs
+	default:
+		return pathRootfs
+	}
+}
+
+// dockerPathArgs returns the docker run options that apply profile's masked
+// and read-only paths.
+func dockerPathArgs(profile SecurityProfile, stat func(string) (fs.FileInfo, error)) []string {
+	var args []string
+	for _, p := range profile.MaskedPaths {
+		switch dockerPathMode(p, true, stat) {
+		case pathTmpfs:
+			args = append(args, "--tmpfs", p+":ro,nosuid,nodev,noexec,size=4k,mode=000")
+		case pathDevNull:
+			args = append(args, "-v", "/dev/null:"+p+":ro")
+		}
+	}
+	for _, p := range profile.ReadonlyPaths {
+		if dockerPathMode(p, false, stat) == pathBindRO {
+			args = append(args, "--mount", "type=bind,source="+p+",target="+p+",readonly")
+		}
+	}
+	return args
+}
+
+const (
+	// pathProbeTimeout bounds how long the path probe keeps trying to exec
+	// into a container that is still starting.
+	pathProbeTimeout = 5 * time.Second
+	pathProbeRetry   = 50 * time.Millisecond
+)
+
+// pathProbeScript prints the container's mount table, a separator, then
+// which of its arguments exist.
+const pathProbeScript = `cat /proc/self/mountinfo
+echo ---
+for p; do [ -e "$p" ] && echo "$p"; done
+exit 0`
+
+// pathProbeCommand is the docker exec that pathProbeScript runs as. It runs
+// as root so that permissions can't hide a path the mount table exposes.
+func pathProbeCommand(container string, profile SecurityProfile) []string {
+	args := []string{"exec", "--user", "0", container, "/bin/sh", "-c", pathProbeScript, "sandbox-paths"}
+	args = append(args, profile.MaskedPaths...)
+	return append(args, profile.ReadonlyPaths...)
+}
+
+// mountPoint is one line of /proc/self/mountinfo.
+type mountPoint struct {
+	target   string
+	readOnly bool
+}
+
+// checkPaths reads pathProbeScript's output and returns a security event for
+// every masked path that exists without a mount over it and every read-only
+// path whose mount is writable.
+func checkPaths(output []byte, profile SecurityProfile) ([]SecurityEvent, error) {
+	mounts, existing, ok := bytes.Cut(output, []byte("\n---\n"))
+	if !ok {
+		return nil, fmt.Errorf("path probe printed no separator")
+	}
+	var table []mountPoint
+	scanner := bufio.NewScanner(bytes.NewReader(mounts))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		// id parent major:minor root target options ... - fstype source super
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		table = append(table, mountPoint{
+			target:   unescapeMountPath(fields[4]),
+			readOnly: slices.Contains(strings.Split(fields[5], ","), "ro"),
+		})
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		if line != "" {
+			present[line] = true
+		}
+	}
+
+	var events []SecurityEvent
+	for _, p := range profile.MaskedPaths {
+		if present[p] && !slices.ContainsFunc(table, func(m mountPoint) bool { return m.target == p }) {
+			events = append(events, SecurityEvent{
+				Type:     "unmasked_path",
+				Source:   SourceRuntime,
+				Severity: "critical",
+				Detail:   p + " is readable in the container: nothing is mounted over it",
+			})
+		}
+	}
+	for _, p := range profile.ReadonlyPaths {
+		if m, ok := coveringMount(table, p); present[p] && ok && !m.readOnly {
+			events = append(events, SecurityEvent{
+				Type:     "writable_path",
+				Source:   SourceRuntime,
+				Severity: "critical",
+				Detail:   p + " is on a writable mount (" + m.target + ") in the container",
+			})
+		}
+	}
+	return events, nil
+}
+
+// coveringMount returns the mount p is on: the last-mounted one whose target
+// is p or a parent of it.
+func coveringMount(table []mountPoint, p string) (mountPoint, bool) {
+	for i := len(table) - 1; i >= 0; i-- {
+		t := table[i].target
+		if t == p || t == "/" || strings.HasPrefix(p, strings.TrimSuffix(t, "/")+"/") {
+			return table[i], true
+		}
+	}
+	return mountPoint{}, false
+}
+
+// unescapeMountPath undoes mountinfo's octal escapes of space, tab, newline
+// and backslash.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return path.Clean(s)
+	}
+	r := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return path.Clean(r.Replace(s))
+}
+
+// pathProbe checks a running container's masked and read-only paths with
+// docker exec. The container may not have started yet, or may already be
+// gone: the probe retries until it gets in, the timeout passes or wait is
+// called.
+type pathProbe struct {
+	done   chan struct{}
+	stop   chan struct{}
+	events []SecurityEvent
+	err    error
+}
+
+func (d *DockerRunner) startPathProbe(ctx context.Context, container string, profile SecurityProfile) *pathProbe {
+	p := &pathProbe{done: make(chan struct{}), stop: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		ctx, cancel := context.WithTimeout(ctx, pathProbeTimeout)
+		defer cancel()
+		args := pathProbeCommand(container, profile)
+		for {
+			out, err := d.dockerOutput(ctx, args...)
+			if err == nil {
+				p.events, p.err = checkPaths(out, profile)
+				return
+			}
+			p.err = err
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.stop:
+				return
+			case <-time.After(pathProbeRetry):
+			}
+		}
+	}()
+	return p
+}
+
+// wait stops the probe once the container has exited and returns what it
+// found. An error means the paths went unchecked.
+func (p *pathProbe) wait() ([]SecurityEvent, error) {
+	close(p.stop)
+	<-p.done
+	return p.events, p.err
+}
@@ -0,0 +1,181 @@
+package sandbox
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// hostStat stats paths in a fake host filesystem.
+func hostStat(files fstest.MapFS) func(string) (fs.FileInfo, error) {
+	return func(p string) (fs.FileInfo, error) {
+		return fs.Stat(files, strings.TrimPrefix(p, "/"))
+	}
+}
+
+var linuxHost = hostStat(fstest.MapFS{
+	"sys/firmware":                 &fstest.MapFile{Mode: fs.ModeDir},
+	"sys/devices/virtual/powercap": &fstest.MapFile{Mode: fs.ModeDir},
+	"sys/kernel/notes":             &fstest.MapFile{},
+})
+
+func TestDockerPathMode(t *testing.T) {
+	tests := []struct {
+		path   string
+		masked bool
+		want   string
+	}{
+		{"/proc/kcore", true, pathDaemon},
+		{"/proc/acpi", true, pathDaemon},
+		{"/sys/firmware", true, pathTmpfs},
+		{"/sys/devices/virtual/powercap", true, pathTmpfs},
+		{"/sys/kernel/notes", true, pathDevNull},
+		{"/sys/devices/virtual/thermal", true, pathAbsent},
+		{"/proc/sys", false, pathDaemon},
+		{"/proc/sysrq-trigger", false, pathDaemon},
+		{"/proc/asound", false, pathDaemon}, // masked by the daemon, hence unwritable
+		{"/proc/driver", false, pathBindRO},
+		{"/sys/kernel", false, pathSysfs},
+		{"/etc/hosts", false, pathRootfs},
+	}
+	for _, tt := range tests {
+		if got := dockerPathMode(tt.path, tt.masked, linuxHost); got != tt.want {
+			t.Errorf("dockerPathMode(%q, masked=%v) = %s, want %s", tt.path, tt.masked, got, tt.want)
+		}
+	}
+}
+
+func TestDockerPathArgs(t *testing.T) {
+	got := dockerPathArgs(DefaultSecurityProfile(), linuxHost)
+	want := []string{
+		"--tmpfs", "/sys/firmware:ro,nosuid,nodev,noexec,size=4k,mode=000",
+		"--tmpfs", "/sys/devices/virtual/powercap:ro,nosuid,nodev,noexec,size=4k,mode=000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("default profile args = %q, want %q", got, want)
+	}
+
+	// A host without powercap gets no mount point runc can't create.
+	noPowercap := hostStat(fstest.MapFS{"sys/firmware": &fstest.MapFile{Mode: fs.ModeDir}})
+	if got := dockerPathArgs(DefaultSecurityProfile(), noPowercap); slices.Contains(got, "/sys/devices/virtual/powercap:ro,nosuid,nodev,noexec,size=4k,mode=000") {
+		t.Errorf("args = %q mask a path the host doesn't have", got)
+	}
+
+	profile := SecurityProfile{MaskedPaths: []string{"/sys/kernel/notes"}, ReadonlyPaths: []string{"/proc/driver", "/sys/kernel"}}
+	got = dockerPathArgs(profile, linuxHost)
+	want = []string{
+		"-v", "/dev/null:/sys/kernel/notes:ro",
+		"--mount", "type=bind,source=/proc/driver,target=/proc/driver,readonly",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("file and /proc args = %q, want %q", got, want)
+	}
+}
+
+func TestDockerPathArgs_InBuildDockerArgs(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	for _, lang := range []string{"python", "claude"} {
+		rt, _ := d.runtimes.Get(lang)
+		args := d.buildDockerArgs("test-id", rt, "/tmp/code", "/workspace/code", "/tmp", "/tmp/seccomp.json", ExecutionRequest{Language: lang})
+		for _, want := range dockerPathArgs(DefaultSecurityProfile(), os.Stat) {
+			if !argsContain(args, want) {
+				t.Errorf("%s args missing %q", lang, want)
+			}
+		}
+	}
+}
+
+func TestSharedPathLists(t *testing.T) {
+	profile := DefaultSecurityProfile()
+	if !slices.Equal(profile.MaskedPaths, maskedPaths) || !slices.Equal(profile.ReadonlyPaths, readonlyPaths) {
+		t.Fatal("DefaultSecurityProfile doesn't use the shared path lists")
+	}
+	profile.MaskedPaths[0] = "/changed"
+	if maskedPaths[0] == "/changed" {
+		t.Error("a profile's lists alias the shared ones")
+	}
+
+	// Every default path maps to something Docker enforces or something
+	// verify_masked_paths can check, never silently to nothing.
+	for _, p := range maskedPaths {
+		if mode := dockerPathMode(p, true, linuxHost); mode != pathDaemon && mode != pathTmpfs {
+			t.Errorf("masked %s: %s", p, mode)
+		}
+	}
+	for _, p := range readonlyPaths {
+		if mode := dockerPathMode(p, false, linuxHost); mode != pathDaemon {
+			t.Errorf("read-only %s: %s", p, mode)
+		}
+	}
+}
+
+func TestCheckPaths(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string // Type: path
+	}{
+		{"mountinfo_masked", nil},
+		{"mountinfo_unmasked", []string{
+			"unmasked_path: /proc/keys",
+			"unmasked_path: /sys/devices/virtual/powercap",
+			"writable_path: /proc/sys",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			events, err := checkPaths(data, DefaultSecurityProfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ev := range events {
+				if ev.Source != SourceRuntime || ev.Severity != "critical" {
+					t.Errorf("event %+v", ev)
+				}
+				got = append(got, ev.Type+": "+strings.Fields(ev.Detail)[0])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := checkPaths([]byte("exec failed"), DefaultSecurityProfile()); err == nil {
+		t.Error("output without a separator accepted")
+	}
+}
+
+func TestCoveringMount(t *testing.T) {
+	table := []mountPoint{{target: "/"}, {target: "/proc"}, {target: "/proc/sys", readOnly: true}, {target: "/procfs"}}
+	for p, want := range map[string]string{
+		"/proc/sys":       "/proc/sys",
+		"/proc/sys/fs":    "/proc/sys",
+		"/proc/sysrq":     "/proc",
+		"/proc":           "/proc",
+		"/etc/hosts":      "/",
+		"/procfs/a":       "/procfs",
+		"/proc/sys/net/x": "/proc/sys",
+	} {
+		if m, ok := coveringMount(table, p); !ok || m.target != want {
+			t.Errorf("coveringMount(%q) = %q, want %q", p, m.target, want)
+		}
+	}
+}
+
+func TestPathProbeCommand(t *testing.T) {
+	profile := SecurityProfile{MaskedPaths: []string{"/proc/kcore"}, ReadonlyPaths: []string{"/proc/sys"}}
+	got := pathProbeCommand("sandbox-x", profile)
+	want := []string{"exec", "--user", "0", "sandbox-x", "/bin/sh", "-c", pathProbeScript, "sandbox-paths", "/proc/kcore", "/proc/sys"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %q", got)
+	}
+}
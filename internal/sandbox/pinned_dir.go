@@ -0,0 +1,165 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errNoOpenat2 is openBeneath's answer on kernels (and platforms) without
+// openat2; pinMountDir falls back to openNoFollow.
+var errNoOpenat2 = errors.New("openat2 is not supported")
+
+// pinnedDir is a mount source that validateRequest checked, held open from
+// then until the container has started. resolveMountDir's checks are on a
+// path, and a symlink swapped into that path before docker run would bind
+// whatever it points to instead; the held directory can't be swapped.
+type pinnedDir struct {
+	path string      // resolved by resolveMountDir; contains no symlinks
+	file *os.File    // O_PATH where the platform has it
+	info fs.FileInfo // of file: the device and inode path must still name
+}
+
+// pinMountDir opens realPath, a path resolveMountDir returned, beneath the
+// allowed root it is under. With openat2 the root is opened without
+// following symlinks and realPath beneath it the same way, so no component
+// can have been swapped since it was resolved. Without it, only the last
+// component is opened without following; verify then catches a swap of any
+// other.
+func pinMountDir(realPath string, roots []string, field string) (*pinnedDir, error) {
+	root, ok := mountRoot(realPath, roots)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not under an allowed root", ErrInvalidRequest, field)
+	}
+	rel, err := filepath.Rel(root, realPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not under an allowed root", ErrInvalidRequest, field)
+	}
+	file, err := openBeneath(root, rel)
+	if errors.Is(err, errNoOpenat2) {
+		file, err = openNoFollow(realPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s changed while it was validated", ErrInvalidRequest, field)
+	}
+	info, err := file.Stat()
+	if err != nil || !info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s changed while it was validated", ErrInvalidRequest, field)
+	}
+	p := &pinnedDir{path: realPath, file: file, info: info}
+	if err := p.verify(); err != nil {
+		p.close()
+		return nil, fmt.Errorf("%w: %s %v", ErrInvalidRequest, field, err)
+	}
+	return p, nil
+}
+
+// mountRoot returns the root, resolved as resolveMountDir resolves it, that
+// realPath is under.
+func mountRoot(realPath string, roots []string) (string, bool) {
+	for _, root := range roots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if strings.HasPrefix(realPath, root+"/") || realPath == root {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// verify re-resolves the path and checks it still names the held directory,
+// by device and inode.
+func (p *pinnedDir) verify() error {
+	resolved, err := filepath.EvalSymlinks(p.path)
+	if err != nil || resolved != p.path {
+		return errors.New("changed while it was validated")
+	}
+	info, err := os.Stat(p.path)
+	if err != nil || !os.SameFile(info, p.info) {
+		return errors.New("changed while it was validated")
+	}
+	return nil
+}
+
+// mountSource verifies the path one last time and returns the bind source
+// for the held directory. viaProc mounts /proc/<pid>/fd/<n> instead of the
+// path: the daemon opens that magic link, which is the held directory
+// whatever its path names by then. It needs a daemon that shares the
+// server's /proc; see procMountable.
+func (p *pinnedDir) mountSource(viaProc bool) (string, error) {
+	if err := p.verify(); err != nil {
+		return "", err
+	}
+	if viaProc {
+		return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), p.file.Fd()), nil
+	}
+	return p.path, nil
+}
+
+func (p *pinnedDir) close() {
+	if p != nil {
+		p.file.Close()
+	}
+}
+
+// procMountable reports whether the docker daemon at host can bind the
+// server's /proc/<pid>/fd links: a local Linux daemon. Docker Desktop runs
+// its daemon in a VM, with a /proc of its own.
+func procMountable(host string) bool {
+	if !procFDs() {
+		return false
+	}
+	return host == "" || strings.HasPrefix(host, "unix://") && !strings.Contains(host, "/.docker/desktop/")
+}
+
+// pinnedMounts are an execution's pinned work_dir and workspace; either may
+// be nil.
+type pinnedMounts struct {
+	workDir   *pinnedDir
+	workspace *pinnedDir
+}
+
+// pinMounts pins req's work_dir and workspace, which validateRequest has
+// resolved.
+func (d *DockerRunner) pinMounts(req ExecutionRequest) (pinnedMounts, error) {
+	var m pinnedMounts
+	var err error
+	if req.WorkDir != "" {
+		if m.workDir, err = pinMountDir(req.WorkDir, d.allowedRoots, "work_dir"); err != nil {
+			return m, err
+		}
+	}
+	if req.Workspace != "" {
+		if m.workspace, err = pinMountDir(req.Workspace, []string{d.workspaceRoot}, "workspace"); err != nil {
+			m.close()
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// apply replaces req's work_dir and workspace with their mount sources.
+func (m pinnedMounts) apply(req *ExecutionRequest, viaProc bool) error {
+	var err error
+	if m.workDir != nil {
+		if req.WorkDir, err = m.workDir.mountSource(viaProc); err != nil {
+			return fmt.Errorf("%w: work_dir %v", ErrInvalidRequest, err)
+		}
+	}
+	if m.workspace != nil {
+		if req.Workspace, err = m.workspace.mountSource(viaProc); err != nil {
+			return fmt.Errorf("%w: workspace %v", ErrInvalidRequest, err)
+		}
+	}
+	return nil
+}
+
+func (m pinnedMounts) close() {
+	m.workDir.close()
+	m.workspace.close()
+}
@@ -0,0 +1,51 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBeneath opens root, then rel beneath it, as O_PATH directories with
+// openat2: neither walk follows a symlink, and rel's can't leave root.
+// errNoOpenat2 means the kernel predates openat2 (5.6).
+func openBeneath(root, rel string) (*os.File, error) {
+	rootFD, err := unix.Openat2(unix.AT_FDCWD, root, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_NO_SYMLINKS,
+	})
+	if errors.Is(err, unix.ENOSYS) {
+		return nil, errNoOpenat2
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: root, Err: err}
+	}
+	defer unix.Close(rootFD)
+
+	fd, err := unix.Openat2(rootFD, rel, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: filepath.Join(root, rel), Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, rel)), nil
+}
+
+// openNoFollow opens path as an O_PATH directory, refusing a symlink as its
+// last component.
+func openNoFollow(path string) (*os.File, error) {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// procFDs reports whether /proc/<pid>/fd links are available to mount by.
+func procFDs() bool {
+	_, err := os.Stat("/proc/self/fd")
+	return err == nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sandbox
+
+import "os"
+
+// openBeneath needs openat2, which only Linux has.
+func openBeneath(root, rel string) (*os.File, error) {
+	return nil, errNoOpenat2
+}
+
+// openNoFollow opens path. Without O_NOFOLLOW here, a swapped-in symlink is
+// left to pinnedDir.verify.
+func openNoFollow(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// procFDs is false: the Docker daemon is in a VM.
+func procFDs() bool { return false }
@@ -0,0 +1,180 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// pinTree is an allowed root holding root/p/work, and an outside tree with a
+// work directory of its own that a swap can point root/p at.
+type pinTree struct {
+	root, work, outside string
+	workInfo            os.FileInfo
+}
+
+func newPinTree(t *testing.T) pinTree {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	work := filepath.Join(root, "p", "work")
+	for _, dir := range []string{work, filepath.Join(outside, "work")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pinTree{root: root, work: work, outside: outside, workInfo: info}
+}
+
+// swap replaces root/p with a symlink to the outside tree.
+func (tr pinTree) swap(t *testing.T) {
+	t.Helper()
+	p := filepath.Join(tr.root, "p")
+	if err := os.Rename(p, p+".orig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(tr.outside, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinMountDir_BindsValidatedDir(t *testing.T) {
+	tr := newPinTree(t)
+	realPath, err := resolveMountDir(tr.work, []string{tr.root}, "work_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pinMountDir(realPath, []string{tr.root}, "work_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	if src, err := p.mountSource(false); err != nil || src != tr.work {
+		t.Errorf("mountSource(false) = %q, %v; want %s", src, err, tr.work)
+	}
+	if !procFDs() {
+		t.Skip("no /proc/<pid>/fd")
+	}
+	src, err := p.mountSource(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(src); err != nil || !os.SameFile(info, tr.workInfo) {
+		t.Errorf("%s is not the validated directory: %v", src, err)
+	}
+}
+
+func TestPinMountDir_SwapBeforePin(t *testing.T) {
+	tr := newPinTree(t)
+	realPath, err := resolveMountDir(tr.work, []string{tr.root}, "work_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.swap(t)
+	if p, err := pinMountDir(realPath, []string{tr.root}, "work_dir"); !errors.Is(err, ErrInvalidRequest) {
+		p.close()
+		t.Fatalf("pinned a path swapped after validation: %v", err)
+	}
+}
+
+func TestPinMountDir_SwapAfterPin(t *testing.T) {
+	for _, viaProc := range []bool{false, true} {
+		tr := newPinTree(t)
+		p, err := pinMountDir(tr.work, []string{tr.root}, "work_dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.swap(t)
+		if src, err := p.mountSource(viaProc); err == nil {
+			t.Errorf("viaProc=%v: mounted %s after a swap", viaProc, src)
+		}
+		// The held directory is still the original, now at p.orig.
+		if info, err := p.file.Stat(); err != nil || !os.SameFile(info, tr.workInfo) {
+			t.Errorf("viaProc=%v: held directory changed: %v", viaProc, err)
+		}
+		p.close()
+	}
+}
+
+// TestPinMountDir_RootSwap swaps the allowed root itself for a symlink to a
+// tree with the same layout.
+func TestPinMountDir_RootSwap(t *testing.T) {
+	tr := newPinTree(t)
+	if err := os.MkdirAll(filepath.Join(tr.outside, "p", "work"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tr.root, tr.root+".orig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(tr.outside, tr.root); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := pinMountDir(tr.work, []string{tr.root}, "work_dir"); !errors.Is(err, ErrInvalidRequest) {
+		p.close()
+		t.Fatalf("pinned beneath a swapped root: %v", err)
+	}
+}
+
+// TestPinMountDir_Race swaps root/p back and forth while requests validate
+// and pin it: each must be rejected or bind the original directory, never
+// the outside one. A mounted path can't be checked after the fact, so this
+// needs the /proc links.
+func TestPinMountDir_Race(t *testing.T) {
+	if !procFDs() {
+		t.Skip("no /proc/<pid>/fd")
+	}
+	tr := newPinTree(t)
+	p := filepath.Join(tr.root, "p")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = os.Rename(p, p+".orig")
+			_ = os.Symlink(tr.outside, p)
+			_ = os.Remove(p)
+			_ = os.Rename(p+".orig", p)
+		}
+	}()
+
+	bound := 0
+	for i := 0; i < 2000; i++ {
+		realPath, err := resolveMountDir(tr.work, []string{tr.root}, "work_dir")
+		if err != nil {
+			continue
+		}
+		pin, err := pinMountDir(realPath, []string{tr.root}, "work_dir")
+		if err != nil {
+			continue
+		}
+		src, err := pin.mountSource(true)
+		if err == nil {
+			info, statErr := os.Stat(src)
+			if statErr == nil && !os.SameFile(info, tr.workInfo) {
+				t.Fatalf("iteration %d: %s is not the validated directory", i, src)
+			}
+			bound++
+		}
+		pin.close()
+	}
+	close(stop)
+	wg.Wait()
+	t.Logf("%d of 2000 executions bound the original directory; the rest were rejected", bound)
+}
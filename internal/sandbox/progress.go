@@ -0,0 +1,307 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressQueueChunks = 32        // stdout chunks buffered for the parser before writes are dropped
+	progressMaxChunk    = 64 << 10  // bytes copied per stdout write
+	progressMaxLine     = 256 << 10 // longer stream-json lines are skipped, not parsed
+	claudeMaxLine       = 2 << 20   // longer lines are dropped by the result filter
+)
+
+// Progress is a coarse snapshot of a claude session, derived from the
+// tool-use events in its stream-json output. Turn counts assistant messages.
+type Progress struct {
+	Turn        int           `json:"turn"`
+	LastTool    string        `json:"last_tool,omitempty"`
+	FilesEdited int           `json:"files_edited"`
+	Elapsed     time.Duration `json:"elapsed"`
+	Timeout     time.Duration `json:"timeout"`
+	Done        bool          `json:"done"`
+}
+
+// ProgressTracker parses claude stream-json output into a Progress. Write
+// never blocks: chunks are handed to a parser goroutine through a bounded
+// queue and dropped when it falls behind, so the output pipeline is never
+// slowed by progress tracking. The parser resynchronises at the next newline.
+type ProgressTracker struct {
+	queue   chan []byte
+	done    chan struct{}
+	start   time.Time
+	timeout time.Duration
+	dropped atomic.Int64
+	written atomic.Int64 // unix nanos of the latest Write; 0 before the first
+
+	closeMu sync.RWMutex // guards queue against sends after close
+	closed  bool
+
+	mu    sync.Mutex
+	state Progress
+	files map[string]struct{}
+
+	// Owned by the parser goroutine.
+	line []byte
+	skip bool // discarding the rest of an oversized line
+}
+
+// NewProgressTracker starts a tracker for an execution with the given timeout.
+// Close must be called once the execution has finished.
+func NewProgressTracker(timeout time.Duration) *ProgressTracker {
+	p := newProgressTracker(timeout)
+	go p.run()
+	return p
+}
+
+func newProgressTracker(timeout time.Duration) *ProgressTracker {
+	return &ProgressTracker{
+		queue:   make(chan []byte, progressQueueChunks),
+		done:    make(chan struct{}),
+		start:   time.Now(),
+		timeout: timeout,
+		files:   make(map[string]struct{}),
+	}
+}
+
+// Write copies p for the parser without blocking. It always reports success.
+// Writes after Close are dropped.
+func (p *ProgressTracker) Write(b []byte) (int, error) {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return len(b), nil
+	}
+	p.written.Store(time.Now().UnixNano())
+	chunk := b
+	if len(chunk) > progressMaxChunk {
+		chunk = chunk[:progressMaxChunk]
+	}
+	select {
+	case p.queue <- bytes.Clone(chunk):
+	default:
+		p.dropped.Add(1)
+	}
+	return len(b), nil
+}
+
+// Close drains queued output, stops the parser and marks the progress done.
+func (p *ProgressTracker) Close() {
+	p.closeMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.closeMu.Unlock()
+	<-p.done
+}
+
+// LastWrite returns when output last arrived, dropped or not. It is zero
+// before the first write.
+func (p *ProgressTracker) LastWrite() time.Time {
+	if n := p.written.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// Snapshot returns the current progress.
+func (p *ProgressTracker) Snapshot() Progress {
+	p.mu.Lock()
+	s := p.state
+	p.mu.Unlock()
+	s.Timeout = p.timeout
+	if !s.Done {
+		s.Elapsed = time.Since(p.start)
+	}
+	return s
+}
+
+func (p *ProgressTracker) run() {
+	for chunk := range p.queue {
+		p.feed(chunk)
+	}
+	if len(p.line) > 0 && !p.skip {
+		p.parseLine(p.line)
+	}
+	p.line = nil
+
+	p.mu.Lock()
+	p.state.Done = true
+	p.state.Elapsed = time.Since(p.start)
+	p.mu.Unlock()
+	close(p.done)
+}
+
+// feed splits chunk into lines, keeping at most progressMaxLine bytes of an
+// unfinished line between chunks.
+func (p *ProgressTracker) feed(chunk []byte) {
+	for len(chunk) > 0 {
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			if !p.skip {
+				if len(p.line)+len(chunk) > progressMaxLine {
+					p.line, p.skip = p.line[:0], true
+				} else {
+					p.line = append(p.line, chunk...)
+				}
+			}
+			return
+		}
+		if !p.skip && len(p.line)+i <= progressMaxLine {
+			p.parseLine(append(p.line, chunk[:i]...))
+		}
+		p.line, p.skip = p.line[:0], false
+		chunk = chunk[i+1:]
+	}
+}
+
+// streamEvent is the subset of a claude stream-json event the tracker reads.
+type streamEvent struct {
+	Type     string `json:"type"`
+	Result   string `json:"result"`
+	NumTurns int    `json:"num_turns"`
+	Usage    struct {
+		InputTokens              int64 `json:"input_tokens"`
+		CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+		OutputTokens             int64 `json:"output_tokens"`
+	} `json:"usage"`
+	Message struct {
+		Content []struct {
+			Type  string `json:"type"`
+			Name  string `json:"name"`
+			Input struct {
+				FilePath     string `json:"file_path"`
+				NotebookPath string `json:"notebook_path"`
+			} `json:"input"`
+		} `json:"content"`
+	} `json:"message"`
+}
+
+// editTools are the claude tools that modify a file named in their input.
+var editTools = map[string]bool{"Edit": true, "MultiEdit": true, "Write": true, "NotebookEdit": true}
+
+// parseLine applies one stream-json event. Lines that are not JSON events
+// (claude's own warnings, shell noise) are ignored.
+func (p *ProgressTracker) parseLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return
+	}
+	var ev streamEvent
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return
+	}
+
+	if ev.Type != "assistant" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.Turn++
+	for _, c := range ev.Message.Content {
+		if c.Type != "tool_use" || c.Name == "" {
+			continue
+		}
+		p.state.LastTool = c.Name
+		if !editTools[c.Name] {
+			continue
+		}
+		path := c.Input.FilePath
+		if path == "" {
+			path = c.Input.NotebookPath
+		}
+		if path != "" {
+			p.files[path] = struct{}{}
+			p.state.FilesEdited = len(p.files)
+		}
+	}
+}
+
+// ClaudeUsage is what a claude session reported using in its result event.
+type ClaudeUsage struct {
+	Turns        int   `json:"turns"`
+	InputTokens  int64 `json:"input_tokens"` // cache reads and writes included
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// claudeResultWriter turns claude's stream-json stdout back into the plain
+// text that --output-format text would print: only the final result event's
+// text is forwarded, and its usage kept. Lines that are not JSON pass
+// through unchanged so shell and CLI error messages still reach the caller.
+type claudeResultWriter struct {
+	w     io.Writer
+	line  []byte
+	skip  bool
+	usage *ClaudeUsage // read once the writer is flushed
+}
+
+func newClaudeResultWriter(w io.Writer) *claudeResultWriter {
+	return &claudeResultWriter{w: w}
+}
+
+func (c *claudeResultWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if !c.skip {
+				if len(c.line)+len(b) > claudeMaxLine {
+					c.line, c.skip = c.line[:0], true
+				} else {
+					c.line = append(c.line, b...)
+				}
+			}
+			return n, nil
+		}
+		if !c.skip && len(c.line)+i <= claudeMaxLine {
+			if err := c.emit(append(c.line, b[:i]...)); err != nil {
+				return 0, err
+			}
+		}
+		c.line, c.skip = c.line[:0], false
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// Flush emits an unterminated final line.
+func (c *claudeResultWriter) Flush() error {
+	if len(c.line) == 0 || c.skip {
+		return nil
+	}
+	err := c.emit(c.line)
+	c.line = c.line[:0]
+	return err
+}
+
+func (c *claudeResultWriter) emit(line []byte) error {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var ev streamEvent
+		if err := json.Unmarshal(trimmed, &ev); err == nil {
+			if ev.Type == "result" {
+				u := ev.Usage
+				c.usage = &ClaudeUsage{
+					Turns:        ev.NumTurns,
+					InputTokens:  u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+					OutputTokens: u.OutputTokens,
+				}
+			}
+			if ev.Type != "result" || ev.Result == "" {
+				return nil
+			}
+			_, err := io.WriteString(c.w, ev.Result+"\n")
+			return err
+		}
+	}
+	_, err := c.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,172 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestProgressTracker_FixtureTransitions(t *testing.T) {
+	p := newProgressTracker(5 * time.Minute)
+
+	// State after each line of the recording; non-event lines leave it unchanged.
+	want := []Progress{
+		{},                          // system init
+		{Turn: 1, LastTool: "Glob"}, // assistant: Glob
+		{Turn: 1, LastTool: "Glob"}, // user: tool_result
+		{Turn: 1, LastTool: "Glob"}, // npm warning (not JSON)
+		{Turn: 2, LastTool: "Read"}, // assistant: Read
+		{Turn: 2, LastTool: "Read"}, // user
+		{Turn: 3, LastTool: "Edit", FilesEdited: 1}, // assistant: Edit app.py
+		{Turn: 3, LastTool: "Edit", FilesEdited: 1}, // user
+		{Turn: 4, LastTool: "Edit", FilesEdited: 2}, // assistant: Write test_app.py, Edit app.py again
+		{Turn: 4, LastTool: "Edit", FilesEdited: 2}, // user
+		{Turn: 5, LastTool: "Bash", FilesEdited: 2}, // assistant: Bash
+		{Turn: 5, LastTool: "Bash", FilesEdited: 2}, // user
+		{Turn: 6, LastTool: "Bash", FilesEdited: 2}, // assistant: final text
+		{Turn: 6, LastTool: "Bash", FilesEdited: 2}, // result
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(readFixture(t, "claude_stream.jsonl")))
+	i := 0
+	for scanner.Scan() {
+		p.feed(append(scanner.Bytes(), '\n'))
+		if i >= len(want) {
+			t.Fatalf("fixture has more than %d lines", len(want))
+		}
+		got := p.Snapshot()
+		if got.Turn != want[i].Turn || got.LastTool != want[i].LastTool || got.FilesEdited != want[i].FilesEdited {
+			t.Errorf("after line %d: got turn=%d tool=%q files=%d, want %+v",
+				i+1, got.Turn, got.LastTool, got.FilesEdited, want[i])
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Errorf("fixture has %d lines, want %d", i, len(want))
+	}
+}
+
+func TestProgressTracker_WriteAndClose(t *testing.T) {
+	data := readFixture(t, "claude_stream.jsonl")
+	p := NewProgressTracker(time.Minute)
+	if !p.LastWrite().IsZero() {
+		t.Errorf("LastWrite before any write = %s", p.LastWrite())
+	}
+	before := time.Now()
+
+	// Odd-sized writes split events across chunks the way pipes do; few enough
+	// to fit the queue, so nothing is dropped.
+	for len(data) > 0 {
+		n := min(301, len(data))
+		p.Write(data[:n])
+		data = data[n:]
+	}
+	if p.Snapshot().Done {
+		t.Error("progress done before Close")
+	}
+	if p.LastWrite().Before(before) {
+		t.Errorf("LastWrite = %s, before the writes at %s", p.LastWrite(), before)
+	}
+	p.Close()
+	p.Close() // idempotent
+
+	got := p.Snapshot()
+	if !got.Done || got.Turn != 6 || got.LastTool != "Bash" || got.FilesEdited != 2 {
+		t.Errorf("final progress = %+v", got)
+	}
+	if got.Timeout != time.Minute || got.Elapsed <= 0 {
+		t.Errorf("elapsed = %s, timeout = %s", got.Elapsed, got.Timeout)
+	}
+	if n, err := p.Write([]byte("late\n")); n != 5 || err != nil {
+		t.Errorf("Write after Close = %d, %v", n, err)
+	}
+}
+
+func TestProgressTracker_GarbageIsBounded(t *testing.T) {
+	p := newProgressTracker(time.Minute)
+	event := `{"type":"assistant","message":{"content":[{"type":"tool_use","name":"Write","input":{"file_path":"/workspace/a"}}]}}` + "\n"
+
+	// 16MB with no newline, then a valid event: the oversized line is skipped
+	// without holding it in memory and parsing resumes after it.
+	garbage := bytes.Repeat([]byte("\x00{not json"), 4096)
+	for i := 0; i < 400; i++ {
+		p.feed(garbage)
+		if cap(p.line) > 2*progressMaxLine {
+			t.Fatalf("line buffer grew to %d bytes", cap(p.line))
+		}
+	}
+	p.feed([]byte("\n"))
+	p.feed([]byte(strings.Repeat("{{{{\n", 1000)))
+	p.feed([]byte(event))
+
+	if got := p.Snapshot(); got.Turn != 1 || got.LastTool != "Write" || got.FilesEdited != 1 {
+		t.Errorf("progress after garbage = %+v", got)
+	}
+}
+
+func TestProgressTracker_WriteNeverBlocks(t *testing.T) {
+	p := newProgressTracker(time.Minute) // no parser goroutine: the queue never drains
+
+	done := make(chan struct{})
+	go func() {
+		chunk := make([]byte, 1<<20)
+		for i := 0; i < 100; i++ {
+			p.Write(chunk)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked on a full queue")
+	}
+	if got := p.dropped.Load(); got != 100-progressQueueChunks {
+		t.Errorf("dropped = %d, want %d", got, 100-progressQueueChunks)
+	}
+	for len(p.queue) > 0 {
+		if c := <-p.queue; len(c) > progressMaxChunk {
+			t.Errorf("queued chunk of %d bytes, max %d", len(c), progressMaxChunk)
+		}
+	}
+}
+
+func TestClaudeResultWriter(t *testing.T) {
+	var out bytes.Buffer
+	c := newClaudeResultWriter(&out)
+
+	data := readFixture(t, "claude_stream.jsonl")
+	for len(data) > 0 {
+		n := min(100, len(data))
+		if _, err := c.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	c.Write([]byte("Error: unterminated"))
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "npm WARN config production Use `--omit=dev` instead.\n" +
+		"Updated the greeting and added a test.\n" +
+		"Error: unterminated\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	wantUsage := ClaudeUsage{Turns: 11, InputTokens: 24 + 5120 + 40960, OutputTokens: 1830}
+	if c.usage == nil || *c.usage != wantUsage {
+		t.Errorf("usage = %+v, want %+v", c.usage, wantUsage)
+	}
+}
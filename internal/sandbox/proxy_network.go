@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// proxyCAPath is where a claude container finds the auth proxy's CA when
+// auth_proxy.tls is on.
+const proxyCAPath = "/run/sandbox/proxy-ca.pem"
+
+// DockerBridge is the addressing of Docker's default bridge network, which
+// claude containers run in and reach the auth proxy from.
+type DockerBridge struct {
+	Subnets  []netip.Prefix
+	Gateways []netip.Addr
+}
+
+// InspectDockerBridge asks the daemon at DOCKER_HOST, or the current
+// context's, for the bridge network's subnets and gateways.
+func InspectDockerBridge(ctx context.Context) (DockerBridge, error) {
+	cmd := exec.CommandContext(ctx, "docker", "network", "inspect", "bridge", "--format",
+		`{{range .IPAM.Config}}{{.Subnet}} {{.Gateway}}{{"\n"}}{{end}}`)
+	if host := resolveDockerHost(); host != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_HOST="+host)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return DockerBridge{}, fmt.Errorf("docker network inspect bridge: %w", err)
+	}
+	return parseDockerBridge(string(out))
+}
+
+// parseDockerBridge reads InspectDockerBridge's "<subnet> <gateway>" lines.
+// A subnet may have no gateway.
+func parseDockerBridge(out string) (DockerBridge, error) {
+	var b DockerBridge
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		subnet, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return DockerBridge{}, fmt.Errorf("bridge network subnet: %w", err)
+		}
+		b.Subnets = append(b.Subnets, subnet.Masked())
+		if len(fields) > 1 {
+			gw, err := netip.ParseAddr(fields[1])
+			if err != nil {
+				return DockerBridge{}, fmt.Errorf("bridge network gateway: %w", err)
+			}
+			b.Gateways = append(b.Gateways, gw)
+		}
+	}
+	if len(b.Subnets) == 0 {
+		return DockerBridge{}, fmt.Errorf("bridge network has no subnets")
+	}
+	return b, nil
+}
+
+// AuthProxyBind resolves auth_proxy.bind to the address the proxy listens
+// on: 127.0.0.1 when it is empty, the bridge network's IPv4 gateway for
+// "docker_gateway", or else the IP it names. bridge is only asked for
+// "docker_gateway".
+func AuthProxyBind(bind string, bridge func() (DockerBridge, error)) (netip.Addr, error) {
+	switch bind {
+	case "":
+		return netip.AddrFrom4([4]byte{127, 0, 0, 1}), nil
+	case "docker_gateway":
+		b, err := bridge()
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		for _, gw := range b.Gateways {
+			if gw.Is4() {
+				return gw, nil
+			}
+		}
+		return netip.Addr{}, fmt.Errorf("bridge network has no IPv4 gateway")
+	}
+	ip, err := netip.ParseAddr(bind)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("auth_proxy.bind: %w", err)
+	}
+	return ip, nil
+}
+
+// proxyHostGateway is what claude containers map host.docker.internal to:
+// the address the proxy listens on, or Docker's host-gateway when that is
+// loopback or unknown.
+func proxyHostGateway(listenIP string) string {
+	ip, err := netip.ParseAddr(listenIP)
+	if err != nil || ip.IsLoopback() {
+		return "host-gateway"
+	}
+	return ip.String()
+}
@@ -0,0 +1,123 @@
+package sandbox
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerBridge(t *testing.T) {
+	b, err := parseDockerBridge("172.17.0.0/16 172.17.0.1\nfd00:dead:beef::/64 \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Subnets) != 2 || b.Subnets[0] != netip.MustParsePrefix("172.17.0.0/16") || b.Subnets[1] != netip.MustParsePrefix("fd00:dead:beef::/64") {
+		t.Errorf("subnets %v", b.Subnets)
+	}
+	if len(b.Gateways) != 1 || b.Gateways[0] != netip.MustParseAddr("172.17.0.1") {
+		t.Errorf("gateways %v", b.Gateways)
+	}
+
+	for _, out := range []string{"", "\n", "bogus 172.17.0.1", "172.17.0.0/16 gateway"} {
+		if _, err := parseDockerBridge(out); err == nil {
+			t.Errorf("parsed %q", out)
+		}
+	}
+}
+
+func TestAuthProxyBind(t *testing.T) {
+	bridge := DockerBridge{
+		Subnets:  []netip.Prefix{netip.MustParsePrefix("fd00::/64"), netip.MustParsePrefix("172.18.0.0/16")},
+		Gateways: []netip.Addr{netip.MustParseAddr("fd00::1"), netip.MustParseAddr("172.18.0.1")},
+	}
+	asked := 0
+	inspect := func() (DockerBridge, error) {
+		asked++
+		return bridge, nil
+	}
+
+	tests := []struct {
+		bind, want string
+	}{
+		{"", "127.0.0.1"},
+		{"docker_gateway", "172.18.0.1"}, // the IPv4 gateway
+		{"10.0.0.5", "10.0.0.5"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		got, err := AuthProxyBind(tt.bind, inspect)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("bind %q: %s, %v; want %s", tt.bind, got, err, tt.want)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("Docker asked %d times, want only for docker_gateway", asked)
+	}
+
+	if _, err := AuthProxyBind("docker_gateway", func() (DockerBridge, error) { return DockerBridge{}, errors.New("no daemon") }); err == nil || !strings.Contains(err.Error(), "no daemon") {
+		t.Errorf("unreachable daemon: %v", err)
+	}
+	bridge.Gateways = bridge.Gateways[:1]
+	if _, err := AuthProxyBind("docker_gateway", inspect); err == nil {
+		t.Error("bound to a bridge without an IPv4 gateway")
+	}
+	if _, err := AuthProxyBind("localhost", inspect); err == nil {
+		t.Error("bound to a host name")
+	}
+}
+
+func TestProxyHostGateway(t *testing.T) {
+	for listen, want := range map[string]string{
+		"":           "host-gateway",
+		"127.0.0.1":  "host-gateway",
+		"::1":        "host-gateway",
+		"172.17.0.1": "172.17.0.1",
+	} {
+		if got := proxyHostGateway(listen); got != want {
+			t.Errorf("proxyHostGateway(%q) = %q, want %q", listen, got, want)
+		}
+	}
+}
+
+func TestBuildDockerArgs_ProxyBoundary(t *testing.T) {
+	d := newTestRunner(8081, "secret123", nil)
+	d.proxyHost, d.proxyCA = "172.17.0.1", "/tmp/sandbox-proxy-ca-1/ca.pem"
+
+	claude, _ := d.runtimes.Get("claude")
+	args := d.buildDockerArgs("exec-p", claude,
+		"/tmp/prompt.txt", "/tmp/prompt.txt",
+		"/tmp/sandbox-exec-p", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "claude", Code: "hello"},
+	)
+	for _, want := range [][2]string{
+		{"--add-host", "host.docker.internal:172.17.0.1"},
+		{"-e", "ANTHROPIC_BASE_URL=https://host.docker.internal:8081"},
+		{"-e", "NODE_EXTRA_CA_CERTS=" + proxyCAPath},
+		{"-v", "/tmp/sandbox-proxy-ca-1/ca.pem:" + proxyCAPath + ":ro"},
+	} {
+		if !argsContainPair(args, want[0], want[1]) {
+			t.Errorf("claude: no %s %s in %v", want[0], want[1], args)
+		}
+	}
+
+	// Code with network access gets neither the secret nor the CA, nor a
+	// name for the proxy.
+	for _, name := range d.runtimes.Languages() {
+		if name == "claude" {
+			continue
+		}
+		rt, _ := d.runtimes.Get(name)
+		args := d.buildDockerArgs("exec-n", rt,
+			"/tmp/code"+rt.FileExtension(), "/workspace/code"+rt.FileExtension(),
+			"/tmp/sandbox-exec-n", "/tmp/seccomp.json",
+			ExecutionRequest{Language: name, Code: "1", NetworkEnabled: true},
+		)
+		for _, a := range args {
+			if strings.Contains(a, "secret123") || strings.Contains(a, "ANTHROPIC_") || strings.Contains(a, "host.docker.internal") ||
+				strings.Contains(a, "NODE_EXTRA_CA_CERTS") || strings.Contains(a, "ca.pem") {
+				t.Errorf("%s with network: %q in %v", name, a, args)
+			}
+		}
+	}
+}
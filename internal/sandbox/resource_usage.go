@@ -0,0 +1,284 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usagePollInterval is how often a docker execution's resource usage is
+// read while it runs. Its cgroup goes with the container, so what's
+// reported is the reading taken as its last process exits, or the last
+// one before it did.
+const usagePollInterval = 100 * time.Millisecond
+
+// remoteUsagePollInterval is usagePollInterval for a remote daemon, read
+// with docker stats: each reading is a CLI process that takes about a
+// second to answer.
+const remoteUsagePollInterval = 2 * time.Second
+
+// lastUsageWait bounds how long Stop waits for the reading taken as the
+// container's cgroup emptied. The cgroup empties before docker run returns,
+// so only a container still running, its CLI killed, waits it out.
+const lastUsageWait = 50 * time.Millisecond
+
+// usageSource reads an execution's resource usage so far. It fails before
+// the container is running and after it has gone; those readings are
+// skipped.
+type usageSource func(ctx context.Context) (ResourceUsage, error)
+
+// usageWatch samples an execution's resource usage until stopped, keeping
+// the highest of each reading: CPU time only grows, and where the kernel
+// has no memory.peak or pids.peak the samples are the peak's best estimate.
+type usageWatch struct {
+	source   usageSource
+	last     usageSource // read once more by Stop; may be nil
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	usage ResourceUsage // owned by run until done is closed
+}
+
+// watchUsage starts sampling source, first right away. Stop must be called
+// once the program has exited, and reads last, if not nil, for the usage
+// at exit.
+func watchUsage(ctx context.Context, source, last usageSource, interval time.Duration) *usageWatch {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &usageWatch{source: source, last: last, interval: interval, cancel: cancel, done: make(chan struct{})}
+	go w.run(ctx)
+	return w
+}
+
+func (w *usageWatch) run(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if u, err := w.source(ctx); err == nil {
+			w.usage = maxUsage(w.usage, u)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop ends the sampling, abandoning a reading in progress, takes the last
+// reading, and returns the usage seen; zero if the program exited before
+// any reading. Calls after the first return the same.
+func (w *usageWatch) Stop() ResourceUsage {
+	w.cancel()
+	<-w.done
+	if w.last != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), lastUsageWait)
+		defer cancel()
+		if u, err := w.last(ctx); err == nil {
+			w.usage = maxUsage(w.usage, u)
+		}
+		w.last = nil
+	}
+	return w.usage
+}
+
+func maxUsage(a, b ResourceUsage) ResourceUsage {
+	return ResourceUsage{
+		CPUTimeMS:    max(a.CPUTimeMS, b.CPUTimeMS),
+		MemoryPeakMB: max(a.MemoryPeakMB, b.MemoryPeakMB),
+		PidsUsed:     max(a.PidsUsed, b.PidsUsed),
+	}
+}
+
+// dockerUsageSource reads a docker container's usage from its cgroup when
+// the daemon is local, and from docker stats, which has no CPU time, when
+// it isn't and cgroup is nil. It returns the source with the one to read
+// at exit, if any, and the interval to sample at.
+func dockerUsageSource(docker func(ctx context.Context, args ...string) ([]byte, error), container string, cgroup *containerCgroup) (source, last usageSource, interval time.Duration) {
+	if cgroup == nil {
+		return func(ctx context.Context) (ResourceUsage, error) {
+			out, err := docker(ctx, "stats", "--no-stream", "--format", "{{.MemUsage}}\t{{.PIDs}}", container)
+			if err != nil {
+				return ResourceUsage{}, err
+			}
+			return parseDockerStats(out)
+		}, nil, remoteUsagePollInterval
+	}
+	return func(context.Context) (ResourceUsage, error) {
+		dir, err := cgroup.Dir()
+		if err != nil {
+			return ResourceUsage{}, err
+		}
+		return readCgroupUsage(dir)
+	}, cgroup.Last, usagePollInterval
+}
+
+// containerCgroup follows a docker container's cgroup v2 directory: found
+// once, as the container starts, and read a last time as its last process
+// exits, before the daemon removes it.
+type containerCgroup struct {
+	cancel context.CancelFunc
+	found  chan struct{} // closed once dir or err is set
+	done   chan struct{} // closed once last or lastErr is set
+
+	dir     string
+	err     error
+	last    ResourceUsage
+	lastErr error
+}
+
+// watchCgroup starts following the cgroup find returns. Stop must be
+// called once the container is gone.
+func watchCgroup(ctx context.Context, find func(ctx context.Context) (string, error)) *containerCgroup {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &containerCgroup{cancel: cancel, found: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		c.dir, c.err = find(ctx)
+		close(c.found)
+		if c.lastErr = c.err; c.err != nil {
+			return
+		}
+		if c.lastErr = waitCgroupEmpty(ctx, c.dir); c.lastErr == nil {
+			c.last, c.lastErr = readCgroupUsage(c.dir)
+		}
+	}()
+	return c
+}
+
+// Dir returns the cgroup's directory, or an error until it's found.
+func (c *containerCgroup) Dir() (string, error) {
+	select {
+	case <-c.found:
+		return c.dir, c.err
+	default:
+		return "", errors.New("container not running")
+	}
+}
+
+// Last waits, within ctx, for the usage read as the cgroup emptied.
+func (c *containerCgroup) Last(ctx context.Context) (ResourceUsage, error) {
+	select {
+	case <-c.done:
+		return c.last, c.lastErr
+	case <-ctx.Done():
+		return ResourceUsage{}, ctx.Err()
+	}
+}
+
+// Stop ends the watch.
+func (c *containerCgroup) Stop() {
+	c.cancel()
+	<-c.done
+}
+
+// cgroupEmpty reports whether the cgroup v2 directory dir has no process
+// left, as its cgroup.events says.
+func cgroupEmpty(dir string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.events")) // #nosec G304 -- cgroup of our own container
+	if err != nil {
+		return false, err
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line == "populated 0" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readCgroupUsage reads the cgroup v2 directory dir: CPU time from
+// cpu.stat, and the memory and pids peaks, or their current values on
+// kernels without memory.peak (before 5.19) or pids.peak (before 6.1).
+func readCgroupUsage(dir string) (ResourceUsage, error) {
+	stat, err := os.ReadFile(filepath.Join(dir, "cpu.stat")) // #nosec G304 -- cgroup of our own container
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	memory, err := readCgroupCounter(dir, "memory.peak", "memory.current")
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	pids, err := readCgroupCounter(dir, "pids.peak", "pids.current")
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	return ResourceUsage{
+		CPUTimeMS:    parseCPUStat(stat).usage.Milliseconds(),
+		MemoryPeakMB: ceilMB(memory),
+		PidsUsed:     pids,
+	}, nil
+}
+
+// readCgroupCounter reads the single number in the first of files dir has.
+func readCgroupCounter(dir string, files ...string) (int64, error) {
+	var err error
+	for _, name := range files {
+		var data []byte
+		data, err = os.ReadFile(filepath.Join(dir, name)) // #nosec G304 -- cgroup of our own container
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return 0, err
+}
+
+// parseDockerStats parses a "{{.MemUsage}}\t{{.PIDs}}" line of docker
+// stats, e.g. "12.5MiB / 256MiB\t3".
+func parseDockerStats(out []byte) (ResourceUsage, error) {
+	line, _, _ := bytes.Cut(bytes.TrimSpace(out), []byte("\n"))
+	mem, pids, ok := strings.Cut(string(line), "\t")
+	if !ok {
+		return ResourceUsage{}, fmt.Errorf("unexpected docker stats output %q", line)
+	}
+	used, _, _ := strings.Cut(mem, "/")
+	size, err := parseStatsSize(strings.TrimSpace(used))
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(pids), 10, 64)
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("docker stats pids %q: %w", pids, err)
+	}
+	return ResourceUsage{MemoryPeakMB: ceilMB(size), PidsUsed: n}, nil
+}
+
+// statsUnits are the suffixes docker stats prints sizes with, binary for
+// memory and decimal elsewhere.
+var statsUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+func parseStatsSize(s string) (int64, error) {
+	for _, u := range statsUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("docker stats size %q: %w", s, err)
+			}
+			return int64(f * u.bytes), nil
+		}
+	}
+	return 0, fmt.Errorf("docker stats size %q has no unit", s)
+}
+
+// ceilMB is n bytes in MB, rounded up so that any use shows.
+func ceilMB(n int64) int64 {
+	return (n + 1<<20 - 1) >> 20
+}
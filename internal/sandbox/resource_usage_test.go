@@ -0,0 +1,182 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeCgroup(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		writeCgroupFile(t, dir, name, content)
+	}
+	return dir
+}
+
+func TestReadCgroupUsage(t *testing.T) {
+	cpuStat := "usage_usec 1534000\nuser_usec 1200000\nsystem_usec 334000\nthrottled_usec 0\n"
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  ResourceUsage
+	}{
+		{"peaks", map[string]string{
+			"cpu.stat": cpuStat, "memory.peak": "104857600\n", "memory.current": "1048576\n", "pids.peak": "7\n", "pids.current": "1\n",
+		}, ResourceUsage{CPUTimeMS: 1534, MemoryPeakMB: 100, PidsUsed: 7}},
+		{"current without peaks", map[string]string{
+			"cpu.stat": cpuStat, "memory.current": "1048577\n", "pids.current": "2\n",
+		}, ResourceUsage{CPUTimeMS: 1534, MemoryPeakMB: 2, PidsUsed: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCgroupUsage(writeCgroup(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("gone", func(t *testing.T) {
+		if _, err := readCgroupUsage(filepath.Join(t.TempDir(), "gone")); err == nil {
+			t.Error("read a cgroup that isn't there")
+		}
+		if _, err := readCgroupUsage(writeCgroup(t, map[string]string{"cpu.stat": cpuStat})); err == nil {
+			t.Error("read a cgroup without memory accounting")
+		}
+	})
+}
+
+func TestParseDockerStats(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    ResourceUsage
+		wantErr bool
+	}{
+		{out: "12.5MiB / 256MiB\t3\n", want: ResourceUsage{MemoryPeakMB: 13, PidsUsed: 3}},
+		{out: "1.2GiB / 2GiB\t40", want: ResourceUsage{MemoryPeakMB: 1229, PidsUsed: 40}},
+		{out: "640KiB / 256MiB\t1", want: ResourceUsage{MemoryPeakMB: 1, PidsUsed: 1}},
+		{out: "0B / 0B\t0", want: ResourceUsage{}},
+		{out: "", wantErr: true},
+		{out: "--\t--", wantErr: true},
+		{out: "12MiB / 256MiB\tmany", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDockerStats([]byte(tt.out))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDockerStats(%q) = %+v, %v; want %+v, error %v", tt.out, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUsageWatch(t *testing.T) {
+	readings := []ResourceUsage{
+		{CPUTimeMS: 10, MemoryPeakMB: 50, PidsUsed: 4},
+		{CPUTimeMS: 30, MemoryPeakMB: 20, PidsUsed: 2}, // current values falling back
+	}
+	var n atomic.Int32
+	source := func(context.Context) (ResourceUsage, error) {
+		i := int(n.Add(1)) - 1
+		if i == 0 || i > len(readings) {
+			return ResourceUsage{}, errors.New("container not running")
+		}
+		return readings[i-1], nil
+	}
+	w := watchUsage(context.Background(), source, nil, time.Millisecond)
+	for n.Load() <= int32(len(readings)) {
+		time.Sleep(time.Millisecond)
+	}
+	want := ResourceUsage{CPUTimeMS: 30, MemoryPeakMB: 50, PidsUsed: 4}
+	if got := w.Stop(); got != want {
+		t.Errorf("Stop() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDockerUsageSource_Interval(t *testing.T) {
+	docker := func(context.Context, ...string) ([]byte, error) { return nil, errors.New("unused") }
+	if _, last, every := dockerUsageSource(docker, "c", new(containerCgroup)); every != usagePollInterval || last == nil {
+		t.Errorf("local daemon: every %s, last reading %t; want %s, true", every, last != nil, usagePollInterval)
+	}
+	// Each docker stats is a process that takes a second.
+	if _, _, every := dockerUsageSource(docker, "c", nil); every != remoteUsagePollInterval {
+		t.Errorf("remote daemon: every %s, want %s", every, remoteUsagePollInterval)
+	}
+}
+
+// A program that exits between two readings is still measured: its cgroup
+// is read as it empties, before the daemon removes it.
+func TestDockerUsageSource_ReadAtExit(t *testing.T) {
+	dir := writeCgroup(t, map[string]string{
+		"cgroup.events": "populated 1\nfrozen 0\n",
+		"cpu.stat":      "usage_usec 0\n",
+		"memory.peak":   "0\n",
+		"pids.peak":     "0\n",
+	})
+	cgroup := watchCgroup(context.Background(), func(context.Context) (string, error) { return dir, nil })
+	defer cgroup.Stop()
+	source, last, _ := dockerUsageSource(nil, "c", cgroup)
+	first := make(chan struct{})
+	var once atomic.Bool
+	w := watchUsage(context.Background(), func(ctx context.Context) (ResourceUsage, error) {
+		if once.CompareAndSwap(false, true) {
+			defer close(first)
+		}
+		return source(ctx)
+	}, last, time.Hour)
+	<-first
+
+	writeCgroupFile(t, dir, "cpu.stat", "usage_usec 42000\n")
+	writeCgroupFile(t, dir, "memory.peak", "5242880\n")
+	writeCgroupFile(t, dir, "pids.peak", "2\n")
+	writeCgroupFile(t, dir, "cgroup.events", "populated 0\nfrozen 0\n")
+	if _, err := cgroup.Last(t.Context()); err != nil {
+		t.Fatalf("no reading as the cgroup emptied: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil { // the daemon removes it
+		t.Fatal(err)
+	}
+
+	want := ResourceUsage{CPUTimeMS: 42, MemoryPeakMB: 5, PidsUsed: 2}
+	if got := w.Stop(); got != want {
+		t.Errorf("Stop() = %+v, want %+v", got, want)
+	}
+}
+
+func writeCgroupFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerRunner_ResourceUsage(t *testing.T) {
+	d := scriptedDockerRunner(t, `sleep 0.3; echo ran`)
+	d.procMounts = false // docker stats, as for a remote daemon
+	dir := os.Getenv("STATE")
+	script := `#!/bin/sh
+[ "$1" = stats ] && printf '12.5MiB / 256MiB\t3\n' && exit 0
+exec "$STATE/docker-base" "$@"
+`
+	if err := os.Rename(filepath.Join(dir, "docker"), filepath.Join(dir, "docker-base")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "print('ran')", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ResourceUsage{MemoryPeakMB: 13, PidsUsed: 3}); result.ResourceUsage != want {
+		t.Errorf("resource usage %+v, want %+v", result.ResourceUsage, want)
+	}
+}
@@ -1,17 +1,17 @@
 package sandbox
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"io"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unicode/utf8"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
@@ -21,28 +21,155 @@ import (
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/rs/zerolog/log"
 
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/runtime"
 )
 
 type ExecutionRequest struct {
-	Code           string         `json:"code"`
-	Language       string         `json:"language"`
-	Timeout        time.Duration  `json:"timeout"`
-	Limits         ResourceLimits `json:"limits"`
-	NetworkEnabled bool           `json:"network_enabled"`
-	WorkDir        string         `json:"work_dir,omitempty"` // Host directory to mount as /workspace (claude runtime)
-	EnvVars        []string       `json:"env_vars,omitempty"` // Additional env vars (e.g. CLAUDE_CODE_OAUTH_TOKEN)
+	ID             string                 `json:"-"` // Execution ID to use; generated unless a canonical UUID
+	Code           string                 `json:"code"`
+	Language       string                 `json:"language"`
+	Timeout        time.Duration          `json:"timeout"`
+	Limits         ResourceLimits         `json:"limits"`
+	NetworkEnabled bool                   `json:"network_enabled"`         // Resolved by security.network_policy; claude has no network without it either
+	WorkDir        string                 `json:"work_dir,omitempty"`      // Host directory to mount as /workspace (claude runtime)
+	Workspace      string                 `json:"-"`                       // Server-managed workspace directory, mounted at /workspace (rw for claude, ro otherwise)
+	EnvVars        []string               `json:"env_vars,omitempty"`      // Additional env vars (e.g. CLAUDE_CODE_OAUTH_TOKEN)
+	Args           []string               `json:"args,omitempty"`          // Appended to the runtime command after the code path
+	Cwd            string                 `json:"cwd,omitempty"`           // Working directory: /workspace, /tmp or one of WritableDirs
+	WritableDirs   []string               `json:"writable_dirs,omitempty"` // Declared writable dirs (permissions.filesystem.writable_dirs)
+	SharedMounts   []string               `json:"shared_mounts,omitempty"` // Names from sandbox.shared_mounts to attach read-only
+	UseCaches      bool                   `json:"use_caches,omitempty"`    // Mount sandbox.claude_caches volumes (claude, docker backend only)
+	Claude         *runtime.ClaudeOptions `json:"claude,omitempty"`        // Tool, turn and model restrictions (claude only); resolved against security.claude
+	ReadOnly       bool                   `json:"read_only,omitempty"`     // Caller asked for a read-only filesystem; caches are never mounted
+	Tenant         string                 `json:"-"`                       // Opaque API key identity; namespaces cache volumes
+	ShareWeight    int                    `json:"-"`                       // Tenant's shares of a contended pool under sandbox.fair_share; 0 = 1
+	Chaos          *ChaosSpec             `json:"-"`                       // Synthesize a failure instead of running (chaos backend only)
+	Progress       *ProgressTracker       `json:"-"`                       // Receives claude's stream-json stdout (docker backend only)
+	Warmups        []string               `json:"-"`                       // Startup optimizations the runner chose (docker backend only)
+	ProxySecret    string                 `json:"-"`                       // Auth proxy secret registered for the caller's token, presented instead of the server's (claude, docker backend only)
+	Output         OutputLimits           `json:"-"`                       // Caps on the stdout and stderr kept and streamed; zero fields take DefaultOutputLimits
+	Partial        *PartialOutput         `json:"-"`                       // Given the output captured so far, for the server to report if it shuts down mid-execution
+
+	// IdleOutputTimeout stops the execution early, as ExitIdleTimeout, once
+	// it has written nothing to stdout or stderr for this long. 0 = never.
+	IdleOutputTimeout time.Duration `json:"idle_output_timeout,omitempty"`
+	// IdleWarning, if set, is called once per silence when it reaches half
+	// of IdleOutputTimeout, with the time left before the execution is
+	// stopped.
+	IdleWarning func(left time.Duration) `json:"-"`
+	// DirtyWorkdir, if set, is called before the container starts when a
+	// claude work_dir has uncommitted changes and
+	// security.claude_workdir_git is warn (docker backend only).
+	DirtyWorkdir func(git WorkdirGit) `json:"-"`
+	// ImagePulling, if set, is called while the execution waits on a pull
+	// of its image, in the setup phase: every pullProgressInterval on
+	// containerd, once on docker.
+	ImagePulling func(PullProgress) `json:"-"`
+	// LimitsNotEnforced, if set, is called before the container starts with
+	// the limit mechanisms the host wasn't verified to enforce, under
+	// sandbox.limit_enforcement permissive (docker backend only).
+	LimitsNotEnforced func(gaps []string) `json:"-"`
+	// Admitted, if set, is called once the execution has its concurrency
+	// slot. Until then it is queued behind others of its language.
+	Admitted func() `json:"-"`
+	// SuspectedMiner is set when the code matched the crypto_miner pattern;
+	// sandbox.cpu_abuse then kills it on saturation without waiting for it
+	// to go quiet.
+	SuspectedMiner bool `json:"-"`
+	// InternetOnly is the caller's word that the execution needs only the
+	// internet; sandbox.egress_audit flags its connections into private
+	// ranges. Nothing stops them.
+	InternetOnly bool `json:"internet_only,omitempty"`
+	// Timezone and Locale set TZ and LANG, taking the place of any the env
+	// sets: an IANA zone name, which the docker backend also checks the
+	// image has, and a UTF-8 locale. LANG is C.UTF-8 otherwise.
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	// Passwd is where the sandbox user's passwd entry came from, one of
+	// PasswdGenerated or PasswdImage, with sandbox.generate_passwd (docker
+	// backend only); the runner sets it.
+	Passwd string `json:"-"`
+
+	// CodeFile is a host file holding the code, for uploads too large for
+	// Code; the two are mutually exclusive. The runner links it into the
+	// execution's directory rather than copying it, and the caller removes
+	// it afterwards. CodeHash is its SHA-256, if the caller hashed it while
+	// writing it.
+	CodeFile string `json:"-"`
+	CodeHash string `json:"-"`
+
+	// MergeOutput keeps stdout and stderr as one capture, in the order the
+	// runner read them: the result's Output holds both, Stderr is empty,
+	// and OutputEvents marks where each stream's runs begin. See
+	// OutputBudget.Merge for how far the order can be trusted.
+	MergeOutput bool `json:"merge_output,omitempty"`
+
+	// DebugTrace runs the code under strace, with sandbox.debug_trace
+	// (docker backend only, never claude), and the result's DebugTrace
+	// holds what it recorded.
+	DebugTrace bool `json:"debug_trace,omitempty"`
+
+	// Stdin is written to the program's standard input, which is closed
+	// after it; without it the program reads EOF. At most
+	// sandbox.max_stdin_bytes; never for claude.
+	Stdin string `json:"stdin,omitempty"`
+
+	// compiled is the execution's use of sandbox.compile_cache, which the
+	// docker runner sets up; nil when it has none.
+	compiled *compiledMount
+
+	// Privileged replaces Limits with limits past MaxLimits, for the
+	// server's maintenance jobs (config jobs). Only ValidatePrivileged
+	// makes them; nothing from the API sets it.
+	Privileged *PrivilegedLimits `json:"-"`
 }
 
 type ExecutionResult struct {
-	ID             string          `json:"id"`
-	Output         string          `json:"output"`
-	Stderr         string          `json:"stderr"`
-	ExitCode       int             `json:"exit_code"`
-	Duration       time.Duration   `json:"duration"`
-	ResourceUsage  ResourceUsage   `json:"resource_usage"`
-	SecurityEvents []SecurityEvent `json:"security_events,omitempty"`
-	CodeHash       string          `json:"code_hash"`
+	ID             string                 `json:"id"`
+	Output         string                 `json:"output"`
+	Stderr         string                 `json:"stderr"`
+	ExitCode       int                    `json:"exit_code"`
+	ExitClass      ExitClass              `json:"exit_class"`
+	Duration       time.Duration          `json:"duration"`
+	ResourceUsage  ResourceUsage          `json:"resource_usage"`
+	SecurityEvents []SecurityEvent        `json:"security_events,omitempty"`
+	CodeHash       string                 `json:"code_hash"`
+	Chaos          bool                   `json:"chaos,omitempty"`          // Synthesized by the chaos backend, no container ran
+	Argv           []string               `json:"argv,omitempty"`           // Command line the process ran with
+	Cwd            string                 `json:"cwd,omitempty"`            // Working directory; empty when the image default applied
+	Claude         *runtime.ClaudeOptions `json:"claude,omitempty"`         // Options claude ran with, after config defaults
+	Warmups        []string               `json:"warmups,omitempty"`        // Startup optimizations applied, see runtime.Warmable
+	Env            []string               `json:"env,omitempty"`            // Names of the env vars the request and sandbox.default_env set
+	Seccomp        *SeccompStatus         `json:"seccomp,omitempty"`        // Seccomp state the process started under (docker backend, sandbox.verify_seccomp)
+	IP             string                 `json:"ip,omitempty"`             // Address the container was given (containerd backend with sandbox.cni)
+	Ulimits        *Ulimits               `json:"ulimits,omitempty"`        // Rlimits the process ran under
+	Idle           *IdleStop              `json:"idle,omitempty"`           // Set when the idle output watchdog stopped the execution
+	Image          string                 `json:"image,omitempty"`          // Runtime image reference the container was created from
+	ImageDigest    string                 `json:"image_digest,omitempty"`   // What Image resolved to: the image ID on docker, the manifest digest on containerd
+	Workdir        *WorkdirSize           `json:"workdir,omitempty"`        // Size of the claude work_dir, measured before it was mounted (docker backend, sandbox.workdir_size)
+	WorkdirGit     *WorkdirGit            `json:"workdir_git,omitempty"`    // Git state of the claude work_dir, read before it was mounted (docker backend)
+	Recommendation *Recommendation        `json:"recommendation,omitempty"` // Set on an oom_kill: the memory to ask for next time
+	ImagePull      *ImagePull             `json:"image_pull,omitempty"`     // The pull of Image the execution waited on in setup, if it had to; not in Duration
+	Limits         *LimitReport           `json:"limits,omitempty"`         // Requested limits against those the host enforces (docker backend, sandbox.limit_enforcement)
+	Network        string                 `json:"network,omitempty"`        // Why the execution had a network or not, one of the Network dispositions; set by the API
+	Timezone       string                 `json:"timezone,omitempty"`       // TZ the request set
+	Locale         string                 `json:"locale,omitempty"`         // LANG the request set; C.UTF-8 unless the env set one
+	Passwd         string                 `json:"passwd,omitempty"`         // Where the sandbox user's passwd entry came from, with sandbox.generate_passwd
+	// NetworkConnections is what an execution with a network connected
+	// to, with sandbox.egress_audit.
+	NetworkConnections *NetworkAudit `json:"network_connections,omitempty"`
+	// OutputEvents marks the runs of each stream in Output when the
+	// request had MergeOutput; nil otherwise.
+	OutputEvents []OutputEvent `json:"output_events,omitempty"`
+	// DebugTrace is what strace recorded when the request had DebugTrace.
+	DebugTrace *DebugTrace `json:"debug_trace,omitempty"`
+	// ClaudeUsage is what a claude session reported using in its final
+	// result event; nil if it never got that far.
+	ClaudeUsage *ClaudeUsage `json:"claude_usage,omitempty"`
+	// CompileCache is CompileCacheHit or CompileCacheMiss when the
+	// execution used sandbox.compile_cache.
+	CompileCache string `json:"compile_cache,omitempty"`
 }
 
 type ResourceUsage struct {
@@ -51,20 +178,70 @@ type ResourceUsage struct {
 	PidsUsed     int64 `json:"pids_used"`
 }
 
+// Network dispositions: why an execution had a network or didn't, as
+// security.network_policy decided.
+const (
+	NetworkRequestedAndAllowed = "requested_and_allowed"
+	NetworkDeniedByPolicy      = "requested_but_denied_by_policy"
+	NetworkForcedForClaude     = "forced_for_claude" // claude always has one, asked for or not
+	NetworkNotRequested        = "not_requested"
+)
+
+// Security event sources: where an event was detected.
+const (
+	SourceCode     = "code"     // static analysis of the submitted code
+	SourceOutput   = "output"   // patterns in the execution output
+	SourceRuntime  = "runtime"  // observed by the runner, e.g. OOM kills
+	SourcePrompt   = "prompt"   // screening of a claude prompt
+	SourceBaseline = "baseline" // departure from the caller's history, security.anomaly
+	SourceStdin    = "stdin"    // static analysis of the request's stdin
+)
+
 type SecurityEvent struct {
-	Type    string `json:"type"`
-	Syscall string `json:"syscall,omitempty"`
-	Detail  string `json:"detail"`
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Severity string `json:"severity,omitempty"`
+	Syscall  string `json:"syscall,omitempty"`
+	Detail   string `json:"detail"`
+	Line     int    `json:"line,omitempty"`  // first matching line, for code events
+	Count    int    `json:"count,omitempty"` // occurrences of the pattern, for code events
+}
+
+// executionID returns req.ID when it is a canonical UUID, safe to embed in
+// container and directory names, and a fresh one otherwise.
+func executionID(req ExecutionRequest) string {
+	if len(req.ID) == 36 {
+		if _, err := uuid.Parse(req.ID); err == nil {
+			return req.ID
+		}
+	}
+	return uuid.New().String()
 }
 
 // Runner is the containerd-based sandbox backend.
 type Runner struct {
 	client   *Client
 	runtimes *runtime.Registry
-	sem      chan struct{} // Concurrency limiter
+	slots    *slotLimiter // Per-language concurrency pools
 	active   atomic.Int64 // Active execution count
 	mu       sync.Mutex   // Protects shutdown state
 	closed   bool
+
+	workspaceRoot string                 // Workspace must be under this; empty disables workspaces
+	sharedMounts  map[string]SharedMount // sandbox.shared_mounts by name
+	network       *cniNetwork            // nil unless sandbox.cni is enabled; network_enabled is refused without it
+	disk          *diskMonitor           // sandbox.disk_pressure; nil when it isn't watched
+	cancelDisk    context.CancelFunc
+	maxUlimits    Ulimits               // sandbox.max_ulimits; ceilings on the ulimits a request sets
+	cpuAbuse      config.CPUAbuseConfig // sandbox.cpu_abuse
+	cpuAbuseObs   CPUAbuseObserver
+	imagePullObs  ImagePullObserver
+	binary        config.BinaryConfig // sandbox.binary; a zero MaxBytes takes DefaultBinaryMaxBytes
+	maxStdin      int64               // sandbox.max_stdin_bytes; 0 refuses stdin
+	setupTimeout  time.Duration       // sandbox.setup_timeout; 0 takes DefaultSetupTimeout
+	cleanupGrace  time.Duration       // sandbox.cleanup_grace; 0 takes DefaultCleanupGrace
+	egress        *egressAuditor      // sandbox.egress_audit; nil when off
+	defaultEnv    *defaultEnv         // sandbox.default_env; nil sets none
 }
 
 // NewRunner creates a new sandbox runner.
@@ -74,16 +251,17 @@ func NewRunner(ctx context.Context, client *Client, maxConcurrent int) (*Runner,
 	}
 
 	return &Runner{
-		client:   client,
-		runtimes: runtime.NewRegistry(),
-		sem:      make(chan struct{}, maxConcurrent),
+		client:     client,
+		runtimes:   runtime.NewRegistry(),
+		slots:      newSlotLimiter(maxConcurrent, map[string]int{defaultPool: maxConcurrent}),
+		maxUlimits: MaxUlimits(),
+		maxStdin:   DefaultMaxStdinBytes,
 	}, nil
 }
 
 // Execute runs code in an isolated sandbox container.
 func (r *Runner) Execute(ctx context.Context, req ExecutionRequest) (*ExecutionResult, error) {
-	var stdout, stderr bytes.Buffer
-	return r.executeInternal(ctx, req, &stdout, &stderr)
+	return r.executeInternal(ctx, req, io.Discard, io.Discard)
 }
 
 // ExecuteStreaming runs code in a sandbox, streaming stdout/stderr to the provided writers.
@@ -92,8 +270,8 @@ func (r *Runner) ExecuteStreaming(ctx context.Context, req ExecutionRequest, std
 }
 
 func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdout, stderr io.Writer) (*ExecutionResult, error) {
-	execID := uuid.New().String()
-	codeHash := fmt.Sprintf("%x", sha256.Sum256([]byte(req.Code)))
+	execID := executionID(req)
+	codeHash := requestCodeHash(req)
 
 	logger := log.With().
 		Str("exec_id", execID).
@@ -103,15 +281,17 @@ func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdo
 
 	logger.Info().Msg("execution requested")
 
-	if err := r.validateRequest(req); err != nil {
+	if err := r.validateRequest(&req); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "validate", Err: err}
 	}
 
-	select {
-	case r.sem <- struct{}{}:
-		defer func() { <-r.sem }()
-	case <-ctx.Done():
-		return nil, &ExecutionError{ExecID: execID, Op: "acquire_slot", Err: ctx.Err()}
+	release, err := r.slots.acquire(ctx, req.Language, callerOf(req))
+	if err != nil {
+		return nil, &ExecutionError{ExecID: execID, Op: "acquire_slot", Err: err}
+	}
+	defer release()
+	if req.Admitted != nil {
+		req.Admitted()
 	}
 
 	r.active.Add(1)
@@ -119,12 +299,11 @@ func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdo
 
 	timeout := req.Timeout
 	if timeout == 0 {
-		timeout = 10 * time.Second
+		timeout = DefaultTimeout(req.Language)
 	}
-	execCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	start := time.Now()
+	phases := newDeadlines(r.setupTimeout, timeout, r.cleanupGrace)
+	setupCtx, endSetup := phases.phase(ctx, PhaseSetup)
+	defer endSetup()
 
 	rt, err := r.runtimes.Get(req.Language)
 	if err != nil {
@@ -139,46 +318,66 @@ func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdo
 
 	codeFileName := "code" + rt.FileExtension()
 	hostCodePath := filepath.Join(hostCodeDir, codeFileName)
-	if err := os.WriteFile(hostCodePath, []byte(req.Code), 0600); err != nil {
+	if err := writeCode(hostCodePath, req); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "write_code", Err: err}
 	}
-	if err := os.Chmod(hostCodePath, 0444); err != nil { // world-readable: container runs as nobody
+	if err := os.Chmod(hostCodePath, codeMode(req.Language)); err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "chmod_code", Err: err}
 	}
 
-	image, err := r.client.PullImage(execCtx, rt.Image())
+	if err := r.disk.admit(setupCtx, rt.Image()); err != nil {
+		return nil, setupError(setupCtx, execID, "admit_image", err)
+	}
+	image, pull, err := r.client.PullImage(setupCtx, rt.Image(), req.ImagePulling)
 	if err != nil {
-		return nil, &ExecutionError{ExecID: execID, Op: "pull_image", Err: err}
+		return nil, setupError(setupCtx, execID, "pull_image", err)
 	}
+	if pull != nil && r.imagePullObs != nil {
+		r.imagePullObs.ImagePulled(pull.Image, pull.Duration)
+	}
+	imageDigest := image.Target().Digest.String()
+	defer r.disk.use(rt.Image(), imageDigest)()
 
 	secProfile := DefaultSecurityProfile()
 	if req.NetworkEnabled {
 		secProfile = NetworkAllowedSecurityProfile()
 	}
+	ulimits := req.Limits.EffectiveUlimits(r.maxUlimits)
 
 	containerID := fmt.Sprintf("sandbox-%s", execID)
 	codePath := fmt.Sprintf("/workspace/%s", codeFileName)
+	if req.Workspace != "" {
+		codePath = fmt.Sprintf("%s/%s", codeMountDir, codeFileName)
+	}
 
-	container, err := r.createContainer(execCtx, containerID, image, rt, codePath, hostCodeDir, req, secProfile)
+	container, err := r.createContainer(setupCtx, containerID, image, rt, codePath, hostCodeDir, req, secProfile)
 	if err != nil {
-		return nil, &ExecutionError{ExecID: execID, Op: "create_container", Err: err}
+		return nil, setupError(setupCtx, execID, "create_container", err)
 	}
 	// Always cleanup, even on panic
 	defer func() {
-		if cleanErr := r.cleanupContainer(context.Background(), container); cleanErr != nil {
+		cleanupCtx, endCleanup := phases.phase(ctx, PhaseCleanup)
+		defer endCleanup()
+		if cleanErr := r.cleanupContainer(cleanupCtx, container); cleanErr != nil {
 			logger.Error().Err(cleanErr).Msg("container cleanup failed")
 		}
 	}()
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	stdoutWriter := io.MultiWriter(&stdoutBuf, stdout)
-	stderrWriter := io.MultiWriter(&stderrBuf, stderr)
+	// The task's streams are wired up at creation, in the setup phase; the
+	// watchdogs that wrap them start with the run phase. cio closes the
+	// task's stdin once the request's is copied in.
+	output := NewOutputBudget(req.Output, stdout, stderr)
+	if req.MergeOutput {
+		output.Merge()
+	}
+	req.Partial.Attach(output)
+	var stdoutWriter, stderrWriter lateWriter
 
-	task, err := container.NewTask(execCtx,
-		cio.NewCreator(cio.WithStreams(nil, stdoutWriter, stderrWriter)),
+	task, err := container.NewTask(setupCtx,
+		cio.NewCreator(cio.WithStreams(stdinReader(req), &stdoutWriter, &stderrWriter)),
 	)
 	if err != nil {
-		return nil, &ExecutionError{ExecID: execID, Op: "create_task", Err: err}
+		return nil, setupError(setupCtx, execID, "create_task", err)
 	}
 	defer func() {
 		if _, err := task.Delete(context.Background(), containerd.WithProcessKill); err != nil {
@@ -186,61 +385,164 @@ func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdo
 		}
 	}()
 
+	// The task's namespace exists from creation, so the network is in place
+	// before the code starts. cleanupContainer detaches it.
+	var ip string
+	if req.NetworkEnabled {
+		if ip, err = r.attachNetwork(setupCtx, container, task); err != nil {
+			return nil, setupError(setupCtx, execID, "setup_network", err)
+		}
+	}
+	if _, ok := expired(setupCtx); ok {
+		return nil, setupError(setupCtx, execID, "setup", setupCtx.Err())
+	}
+	endSetup()
+
+	execCtx, endRun := phases.phase(ctx, PhaseRun)
+	defer endRun()
+	start := time.Now()
+	runCtx, idle := watchIdle(execCtx, req)
+	defer idle.Stop()
+	runCtx, cpu := watchCPU(runCtx, r.cpuAbuse, req, cgroupCPUSource(filepath.Join(cgroupRoot, r.client.namespace, containerID)))
+	defer cpu.Stop()
+	stdoutWriter.set(cpu.Output(idle.Output(output.Stdout())))
+	stderrWriter.set(cpu.Output(idle.Output(output.Stderr())))
+
 	exitCh, err := task.Wait(execCtx)
 	if err != nil {
 		return nil, &ExecutionError{ExecID: execID, Op: "task_wait", Err: err}
 	}
 
+	// The address is known from the start: CNI gave it in setup.
+	var egress *egressWatch
+	if req.NetworkEnabled {
+		egress = r.egress.watch(req.InternetOnly)
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			egress.setIP(addr)
+		}
+	}
+
 	if err := task.Start(execCtx); err != nil {
+		egress.Stop()
 		return nil, &ExecutionError{ExecID: execID, Op: "task_start", Err: err}
 	}
 
 	logger.Info().Msg("task started")
 
 	var exitCode int
+	var exitClass ExitClass
 	var securityEvents []SecurityEvent
+	var network *NetworkAudit
 
 	select {
 	case status := <-exitCh:
 		exitCode = int(status.ExitCode())
-		if status.Error() != nil {
-			if isOOMKilled(status.Error()) {
-				securityEvents = append(securityEvents, SecurityEvent{
-					Type:   "oom_kill",
-					Detail: "process killed by OOM killer",
-				})
-				return &ExecutionResult{
-					ID:             execID,
-					Stderr:         "Process killed: out of memory",
-					ExitCode:       137,
-					Duration:       time.Since(start),
-					SecurityEvents: securityEvents,
-					CodeHash:       codeHash,
-				}, ErrOOM
+		// Read the OOM counter before the deferred task delete removes the cgroup.
+		exitClass = classifyExit(exitInfo{
+			code:      exitCode,
+			oomKilled: cgroupOOMKilled(r.client.namespace, containerID),
+		})
+		var egressEvents []SecurityEvent
+		network, egressEvents = egress.Stop()
+		securityEvents = append(securityEvents, egressEvents...)
+		if exitClass == ExitOOMKill {
+			securityEvents = append(securityEvents, SecurityEvent{
+				Type:   "oom_kill",
+				Source: SourceRuntime,
+				Detail: "process killed by OOM killer",
+			})
+			stdoutText, _ := output.Output()
+			result := &ExecutionResult{
+				ID:             execID,
+				Output:         stdoutText,
+				ExitCode:       exitCode,
+				ExitClass:      exitClass,
+				Duration:       time.Since(start),
+				SecurityEvents: securityEvents,
+				CodeHash:       codeHash,
+				Argv:           commandArgv(rt, codePath, req),
+				Cwd:            effectiveCwd(req),
+				Env:            envKeys(req.EnvVars),
+				IP:             ip,
+				Ulimits:        &ulimits,
+				Image:          rt.Image(),
+				ImageDigest:    imageDigest,
+				ImagePull:      pull,
+
+				NetworkConnections: network,
 			}
+			result.Recommendation = recommendMemory(r.runtimes, req.Language, req.Limits)
+			result.Timezone, result.Locale = effectiveLocale(req)
+			output.mergeInto(result)
+			// In place of what the process wrote to stderr, merged or not.
+			result.Stderr = "Process killed: out of memory"
+			return result, ErrOOM
 		}
 
-	case <-execCtx.Done():
-		logger.Warn().Msg("execution timed out, killing task")
+	case <-runCtx.Done():
+		reason := killTimeout
+		switch _, timedOut := expired(execCtx); {
+		case idle.Fired():
+			reason = killIdle
+		case cpu.Fired():
+			reason = killCPUAbuse
+		case !timedOut:
+			reason = killManual
+		}
+		logger.Warn().Err(runCtx.Err()).Bool("idle", reason == killIdle).Msg("execution interrupted, killing task")
 		if err := task.Kill(context.Background(), 9); err != nil {
-			logger.Error().Err(err).Msg("failed to kill timed out task")
+			logger.Error().Err(err).Msg("failed to kill task")
 		}
 		<-exitCh
+		var egressEvents []SecurityEvent
+		network, egressEvents = egress.Stop()
+		securityEvents = append(securityEvents, egressEvents...)
+
+		stdoutText, stderrText := output.Output()
+		result := &ExecutionResult{
+			ID:          execID,
+			Output:      stdoutText,
+			Stderr:      stderrText,
+			ExitCode:    -1,
+			ExitClass:   classifyExit(exitInfo{code: -1, killed: reason}),
+			Duration:    time.Since(start),
+			CodeHash:    codeHash,
+			Argv:        commandArgv(rt, codePath, req),
+			Cwd:         effectiveCwd(req),
+			Env:         envKeys(req.EnvVars),
+			IP:          ip,
+			Ulimits:     &ulimits,
+			Image:       rt.Image(),
+			ImageDigest: imageDigest,
+			ImagePull:   pull,
+
+			NetworkConnections: network,
+		}
+		result.Timezone, result.Locale = effectiveLocale(req)
+		output.mergeInto(result)
+		switch reason {
+		case killManual:
+			result.SecurityEvents = securityEvents
+			return result, &ExecutionError{ExecID: execID, Op: "task_wait", Err: runCtx.Err()}
+		case killIdle:
+			var event SecurityEvent
+			result.Idle, event = idle.report()
+			result.SecurityEvents = append(securityEvents, event)
+			return result, ErrIdleTimeout
+		case killCPUAbuse:
+			result.SecurityEvents = append(securityEvents, cpu.report())
+			if r.cpuAbuseObs != nil {
+				r.cpuAbuseObs.CPUAbuseKilled(req.Language, cpu.trigger())
+			}
+			return result, ErrCPUAbuse
+		}
 
-		securityEvents = append(securityEvents, SecurityEvent{
+		result.SecurityEvents = append(securityEvents, SecurityEvent{
 			Type:   "timeout",
+			Source: SourceRuntime,
 			Detail: fmt.Sprintf("execution exceeded %s timeout", timeout),
 		})
-
-		return &ExecutionResult{
-			ID:             execID,
-			Output:         truncateOutput(stdoutBuf.String(), 1<<20),
-			Stderr:         truncateOutput(stderrBuf.String(), 256*1024),
-			ExitCode:       -1,
-			Duration:       time.Since(start),
-			SecurityEvents: securityEvents,
-			CodeHash:       codeHash,
-		}, ErrTimeout
+		return result, ErrTimeout
 	}
 
 	duration := time.Since(start)
@@ -249,15 +551,30 @@ func (r *Runner) executeInternal(ctx context.Context, req ExecutionRequest, stdo
 		Dur("duration", duration).
 		Msg("execution completed")
 
-	return &ExecutionResult{
+	stdoutText, stderrText := output.Output()
+	result := &ExecutionResult{
 		ID:             execID,
-		Output:         truncateOutput(stdoutBuf.String(), 1<<20), // 1MB max
-		Stderr:         truncateOutput(stderrBuf.String(), 256*1024), // 256KB max
+		Output:         stdoutText,
+		Stderr:         stderrText,
 		ExitCode:       exitCode,
+		ExitClass:      exitClass,
 		Duration:       duration,
 		SecurityEvents: securityEvents,
 		CodeHash:       codeHash,
-	}, nil
+		Argv:           commandArgv(rt, codePath, req),
+		Cwd:            effectiveCwd(req),
+		Env:            envKeys(req.EnvVars),
+		IP:             ip,
+		Ulimits:        &ulimits,
+		Image:          rt.Image(),
+		ImageDigest:    imageDigest,
+		ImagePull:      pull,
+
+		NetworkConnections: network,
+	}
+	result.Timezone, result.Locale = effectiveLocale(req)
+	output.mergeInto(result)
+	return result, nil
 }
 
 // ActiveCount returns the number of currently running executions.
@@ -265,11 +582,22 @@ func (r *Runner) ActiveCount() int64 {
 	return r.active.Load()
 }
 
+// Stats reports the occupancy of the concurrency slots.
+func (r *Runner) Stats() (SlotStats, bool) {
+	return r.slots.stats(), true
+}
+
+// Name returns "containerd".
+func (r *Runner) Name() string { return "containerd" }
+
 // Close shuts down the runner, waiting for active executions.
 func (r *Runner) Close() error {
 	r.mu.Lock()
 	r.closed = true
 	r.mu.Unlock()
+	if r.cancelDisk != nil {
+		r.cancelDisk()
+	}
 	return nil
 }
 
@@ -285,31 +613,61 @@ func (r *Runner) createContainer(
 ) (containerd.Container, error) {
 	nsCtx := r.client.WithNamespace(ctx)
 
+	mounts, err := resolveSharedMounts(req.SharedMounts, rt.Name(), r.sharedMounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels map[string]string
+	if req.NetworkEnabled {
+		labels = map[string]string{cniLabel: r.network.name}
+	}
+
 	container, err := r.client.Raw().NewContainer(nsCtx, id,
+		containerd.WithContainerLabels(labels),
 		containerd.WithImage(image),
 		containerd.WithNewSnapshot(id+"-snapshot", image),
 		containerd.WithNewSpec(
 			oci.WithImageConfig(image),
-			oci.WithProcessArgs(rt.Command(codePath)...),
 			oci.WithHostname("sandbox"),
 			func(_ context.Context, _ oci.Client, _ *containers.Container, s *specs.Spec) error {
 				ApplySecurityProfile(s, secProfile)
-				ApplyResourceLimits(s, req.Limits)
-
-				s.Mounts = append(s.Mounts, specs.Mount{
-					Destination: "/workspace",
-					Type:        "bind",
-					Source:      hostCodeDir,
-					Options:     []string{"rbind", "ro"},
-				})
-
-				s.Process.Env = []string{
-					"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
-					"HOME=/tmp",
-					"LANG=C.UTF-8",
-					"SANDBOX=true",
+				ApplyResourceLimits(s, req.Limits, req.Limits.EffectiveUlimits(r.maxUlimits))
+
+				if req.Workspace != "" {
+					// Workspace takes /workspace; the code moves aside.
+					s.Mounts = append(s.Mounts,
+						specs.Mount{
+							Destination: codeMountDir,
+							Type:        "bind",
+							Source:      hostCodeDir,
+							Options:     []string{"rbind", "ro"},
+						},
+						specs.Mount{
+							Destination: "/workspace",
+							Type:        "bind",
+							Source:      req.Workspace,
+							Options:     []string{"rbind", "ro"},
+						},
+					)
+					s.Process.Cwd = "/workspace"
+				} else {
+					s.Mounts = append(s.Mounts, specs.Mount{
+						Destination: "/workspace",
+						Type:        "bind",
+						Source:      hostCodeDir,
+						Options:     []string{"rbind", "ro"},
+					})
 				}
 
+				s.Mounts = append(s.Mounts, sharedMountSpecs(mounts)...)
+				if req.NetworkEnabled {
+					s.Mounts = append(s.Mounts, resolvConfMount())
+				}
+				setProcess(s, commandArgv(rt, codePath, req), req.Cwd)
+
+				s.Process.Env = containerdEnv(req, runtime.EnvFor(rt, req.NetworkEnabled))
+
 				return nil
 			},
 		),
@@ -321,55 +679,115 @@ func (r *Runner) createContainer(
 	return container, nil
 }
 
-func (r *Runner) validateRequest(req ExecutionRequest) error {
-	if req.Code == "" {
-		return fmt.Errorf("%w: code is empty", ErrInvalidRequest)
+// containerdEnv is the environment of a containerd execution: a base every
+// one starts with, its runtime's env replacing the base's of the same
+// name, then sandbox.default_env's and the request's.
+func containerdEnv(req ExecutionRequest, runtimeEnv []string) []string {
+	env := append([]string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"HOME=" + sandboxHome,
+	}, baseLocaleEnv(req)...)
+	env = append(env, "SANDBOX=true")
+	for _, e := range runtimeEnv {
+		name, _, _ := strings.Cut(e, "=")
+		env = slices.DeleteFunc(env, func(base string) bool { return strings.HasPrefix(base, name+"=") })
+		env = append(env, e)
+	}
+	return append(env, req.EnvVars...)
+}
+
+func (r *Runner) validateRequest(req *ExecutionRequest) error {
+	if name, ok := r.runtimes.Canonical(req.Language); ok {
+		req.Language = name
 	}
-	if len(req.Code) > 1<<20 {
-		return fmt.Errorf("%w: code exceeds 1MB limit", ErrInvalidRequest)
+	if err := validateCode(*req); err != nil {
+		return err
 	}
 
 	if req.Language == "claude" {
 		return fmt.Errorf("%w: claude runtime requires Docker backend (not containerd)", ErrUnsupportedLang)
 	}
+	if req.NetworkEnabled && r.network == nil {
+		return fmt.Errorf("%w: network access on the containerd backend needs sandbox.cni", ErrInvalidRequest)
+	}
+	if req.UseCaches {
+		return fmt.Errorf("%w: use_caches is only supported for claude", ErrInvalidRequest)
+	}
+	if req.Claude != nil {
+		return fmt.Errorf("%w: claude options are only supported for claude", ErrInvalidRequest)
+	}
+	if req.DebugTrace {
+		return fmt.Errorf("%w: debug_trace needs the docker backend", ErrInvalidRequest)
+	}
+	if err := validateCommand(*req); err != nil {
+		return err
+	}
+	if err := validateStdin(*req, r.maxStdin); err != nil {
+		return err
+	}
 
 	if _, err := r.runtimes.Get(req.Language); err != nil {
-		return fmt.Errorf("%w: %s", ErrUnsupportedLang, req.Language)
+		return fmt.Errorf("%w: %q (supported: %s)", ErrUnsupportedLang, req.Language, r.runtimes.Supported())
+	}
+	if req.Language == binaryLanguage {
+		if err := validateBinary(*req, r.binary, hostMachine()); err != nil {
+			return err
+		}
+	}
+
+	if maxTimeout := maxTimeoutOf(*req); req.Timeout > maxTimeout {
+		return fmt.Errorf("%w: timeout exceeds %s maximum", ErrInvalidRequest, maxTimeout)
+	}
+
+	if req.Workspace != "" {
+		if r.workspaceRoot == "" {
+			return fmt.Errorf("%w: workspaces are not enabled", ErrInvalidRequest)
+		}
+		realPath, err := resolveMountDir(req.Workspace, []string{r.workspaceRoot}, "workspace")
+		if err != nil {
+			return err
+		}
+		req.Workspace = realPath
 	}
 
-	if req.Timeout > 60*time.Second {
-		return fmt.Errorf("%w: timeout exceeds 60s maximum", ErrInvalidRequest)
+	if _, err := resolveSharedMounts(req.SharedMounts, req.Language, r.sharedMounts); err != nil {
+		return err
+	}
+	if err := validateEnvVars(req.EnvVars); err != nil {
+		return err
+	}
+	req.EnvVars = r.defaultEnv.apply(req.Language, req.EnvVars)
+	if err := validateLocale(req); err != nil {
+		return err
 	}
+	req.EnvVars = localeEnv(*req, req.EnvVars)
 
-	if req.Limits != (ResourceLimits{}) {
+	if req.Privileged != nil {
+		req.Limits = req.Privileged.Limits()
+	} else if req.Limits != (ResourceLimits{}) {
 		if err := req.Limits.Validate(); err != nil {
 			return err
 		}
 	}
+	req.Limits = executionLimits(r.runtimes, req.Language, req.Limits)
 
-	return nil
+	return req.Limits.ValidateUlimits(r.maxUlimits)
 }
 
-func isOOMKilled(err error) bool {
-	if err == nil {
-		return false
-	}
-	return false // Placeholder: check cgroup OOM events in production
+// lateWriter forwards writes to a writer set after it is handed out: a
+// task's streams are wired up when it is created, in the setup phase, but
+// the watchdogs that wrap them only start with the run phase. Nothing is
+// written before the task starts, so set comes first; writes without a
+// writer are dropped all the same.
+type lateWriter struct {
+	w atomic.Pointer[io.Writer]
 }
 
-func truncateOutput(s string, maxBytes int) string {
-	if len(s) <= maxBytes {
-		return s
-	}
-	// Trim any incomplete UTF-8 rune at the boundary. DecodeLastRuneInString
-	// returns RuneError with size 1 for each invalid trailing byte.
-	t := s[:maxBytes]
-	for len(t) > 0 {
-		r, size := utf8.DecodeLastRuneInString(t)
-		if r != utf8.RuneError || size != 1 {
-			break
-		}
-		t = t[:len(t)-1]
+func (l *lateWriter) set(w io.Writer) { l.w.Store(&w) }
+
+func (l *lateWriter) Write(p []byte) (int, error) {
+	if w := l.w.Load(); w != nil {
+		return (*w).Write(p)
 	}
-	return t + "\n... [output truncated]"
+	return len(p), nil
 }
@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"fmt"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+// Recommendation is what an execution that ran out of memory might ask for
+// next time: the next rung of its runtime's memory ladder.
+type Recommendation struct {
+	MemoryMB int64  `json:"memory_mb"`
+	Message  string `json:"message"`
+}
+
+// SetRuntimeLimits applies sandbox.runtime_limits to runtimes. Each
+// language's limits are held to MaxLimits, as a request's are, so an
+// override can't leave every request that leaves them out refused.
+func SetRuntimeLimits(runtimes *runtime.Registry, overrides map[string]config.DefaultLimits) error {
+	limits := make(map[string]runtime.Limits, len(overrides))
+	for lang, l := range overrides {
+		limits[lang] = runtime.Limits(l)
+	}
+	if err := runtimes.SetLimits(limits); err != nil {
+		return err
+	}
+	for lang := range overrides {
+		if err := limitsOf(runtimes.Limits(lang)).Validate(); err != nil {
+			return fmt.Errorf("%s: %w", lang, err)
+		}
+	}
+	return nil
+}
+
+// RuntimeLimits returns the limits executions of language get by default
+// from runtimes.
+func RuntimeLimits(runtimes *runtime.Registry, language string) ResourceLimits {
+	return limitsOf(runtimes.Limits(language))
+}
+
+func limitsOf(l runtime.Limits) ResourceLimits {
+	return ResourceLimits{CPUShares: l.CPUShares, MemoryMB: l.MemoryMB, PidsLimit: l.PidsLimit, DiskMB: l.DiskMB}
+}
+
+// executionLimits returns the limits an execution runs under: the ones it
+// asked for, or its runtime's defaults when it set none.
+func executionLimits(runtimes *runtime.Registry, language string, limits ResourceLimits) ResourceLimits {
+	ulimits := limits.Ulimits
+	limits.Ulimits = nil
+	if limits == (ResourceLimits{}) {
+		limits = RuntimeLimits(runtimes, language)
+	}
+	limits.Ulimits = ulimits
+	return limits
+}
+
+// recommendMemory is the Recommendation for an execution of language
+// killed for running out of memory under limits; nil when it already had
+// all a request may ask for.
+func recommendMemory(runtimes *runtime.Registry, language string, limits ResourceLimits) *Recommendation {
+	memory := executionLimits(runtimes, language, limits).MemoryMB
+	next := runtimes.RecommendMemory(language, memory, MaxLimits().MemoryMB)
+	if next == 0 {
+		return nil
+	}
+	return &Recommendation{
+		MemoryMB: next,
+		Message:  fmt.Sprintf("%s ran out of its %dMB of memory; try limits.memory_mb %d", language, memory, next),
+	}
+}
@@ -0,0 +1,59 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/runtime"
+)
+
+func TestRuntimeLimits(t *testing.T) {
+	runtimes := runtime.NewRegistry()
+	if got := RuntimeLimits(runtimes, "claude"); got != DevLimits() {
+		t.Errorf("claude = %+v, want DevLimits", got)
+	}
+	if got := RuntimeLimits(runtimes, "python"); got != DefaultLimits() {
+		t.Errorf("python = %+v, want DefaultLimits", got)
+	}
+
+	if err := SetRuntimeLimits(runtimes, map[string]config.DefaultLimits{"node": {MemoryMB: 2048}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := RuntimeLimits(runtimes, "node").MemoryMB; got != 2048 {
+		t.Errorf("node memory = %d, want the override's 2048", got)
+	}
+	if err := SetRuntimeLimits(runtime.NewRegistry(), map[string]config.DefaultLimits{"bash": {MemoryMB: 1 << 20}}); err == nil || !strings.HasPrefix(err.Error(), "bash: ") {
+		t.Errorf("override over the ceiling: error = %v", err)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	runtimes := runtime.NewRegistry()
+	if got := executionLimits(runtimes, "node", ResourceLimits{}); got.MemoryMB != 512 {
+		t.Errorf("node without limits: %+v, want 512MB", got)
+	}
+	explicit := ResourceLimits{CPUShares: 256, MemoryMB: 64, PidsLimit: 10, DiskMB: 10}
+	if got := executionLimits(runtimes, "node", explicit); got != explicit {
+		t.Errorf("node with limits: %+v, want them as sent", got)
+	}
+	// Ulimits alone don't make the limits set.
+	withUlimits := ResourceLimits{Ulimits: &Ulimits{NoFile: 64}}
+	if got := executionLimits(runtimes, "bash", withUlimits); got.MemoryMB != 128 || got.Ulimits == nil || got.Ulimits.NoFile != 64 {
+		t.Errorf("bash with ulimits: %+v", got)
+	}
+}
+
+func TestRecommendMemory(t *testing.T) {
+	runtimes := runtime.NewRegistry()
+	rec := recommendMemory(runtimes, "node", ResourceLimits{})
+	if rec == nil || rec.MemoryMB != 1024 || rec.Message != "node ran out of its 512MB of memory; try limits.memory_mb 1024" {
+		t.Errorf("node at its default: %+v", rec)
+	}
+	if rec := recommendMemory(runtimes, "python", ResourceLimits{CPUShares: 512, MemoryMB: 700, PidsLimit: 50, DiskMB: 100}); rec == nil || rec.MemoryMB != 1024 {
+		t.Errorf("python at 700MB: %+v, want 1024", rec)
+	}
+	if rec := recommendMemory(runtimes, "claude", ResourceLimits{MemoryMB: MaxLimits().MemoryMB}); rec != nil {
+		t.Errorf("claude at the memory ceiling: %+v, want none", rec)
+	}
+}
@@ -0,0 +1,138 @@
+package sandbox
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// slotCaller is who an execution waiting for a slot runs for: the API key
+// identity from ExecutionRequest.Tenant, empty for unauthenticated
+// callers, and the shares of a contended pool that caller is entitled to.
+type slotCaller struct {
+	identity string
+	weight   int // 0 counts as 1
+}
+
+func callerOf(req ExecutionRequest) slotCaller {
+	return slotCaller{identity: req.Tenant, weight: req.ShareWeight}
+}
+
+// slotWaiter is an execution queued for a full pool. granted is closed
+// once a released slot has been handed to it.
+type slotWaiter struct {
+	identity string
+	weight   int
+	arrived  time.Time
+	granted  chan struct{}
+}
+
+// slotScheduler hands out a pool's slots. A free slot is taken at once;
+// a released one goes straight to a waiter, so a newcomer can't take it
+// from under the queue.
+//
+// Waiters are served in arrival order unless the scheduler is fair. Then
+// it runs stride scheduling over callers: each has a pass, advanced by
+// 1/weight for every slot it is given, and the waiting caller with the
+// lowest pass goes next, its earliest waiter first. A caller that starts
+// waiting is brought up to the pass of the last slot handed out, so time
+// spent idle isn't banked as credit. The oldest waiter goes next anyway
+// once it has waited maxWait.
+type slotScheduler struct {
+	size    int
+	fair    bool
+	maxWait time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	inUse   int
+	waiting []*slotWaiter      // in arrival order
+	pass    map[string]float64 // by caller identity, while anyone waits
+	vtime   float64            // pass of the last slot handed out
+}
+
+func newSlotScheduler(size int) *slotScheduler {
+	return &slotScheduler{size: size, now: time.Now, pass: make(map[string]float64)}
+}
+
+// enter takes a slot for c if one is free and ok is true; otherwise it
+// queues c and returns the waiter, whose granted is closed when a slot is
+// handed to it.
+func (s *slotScheduler) enter(c slotCaller) (w *slotWaiter, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inUse < s.size && len(s.waiting) == 0 {
+		s.inUse++
+		return nil, true
+	}
+	w = &slotWaiter{identity: c.identity, weight: max(c.weight, 1), arrived: s.now(), granted: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	if s.fair {
+		s.pass[w.identity] = max(s.pass[w.identity], s.vtime)
+	}
+	return w, false
+}
+
+// leave takes w out of the queue when it gives up waiting. It returns
+// false if a slot was handed to w first, which the caller then holds.
+func (s *slotScheduler) leave(w *slotWaiter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.Index(s.waiting, w)
+	if i < 0 {
+		return false
+	}
+	s.waiting = slices.Delete(s.waiting, i, i+1)
+	s.forget()
+	return true
+}
+
+// release frees a slot, handing it to the next waiter if there is one.
+func (s *slotScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiting) == 0 {
+		s.inUse--
+		return
+	}
+	i := s.next()
+	w := s.waiting[i]
+	s.waiting = slices.Delete(s.waiting, i, i+1)
+	if s.fair {
+		s.vtime = max(s.vtime, s.pass[w.identity])
+		s.pass[w.identity] += 1 / float64(w.weight)
+	}
+	s.forget()
+	close(w.granted)
+}
+
+// next is the index of the waiter the next slot goes to.
+func (s *slotScheduler) next() int {
+	if !s.fair || s.now().Sub(s.waiting[0].arrived) >= s.maxWait {
+		return 0
+	}
+	best := 0
+	for i, w := range s.waiting {
+		if s.pass[w.identity] < s.pass[s.waiting[best].identity] {
+			best = i
+		}
+	}
+	return best
+}
+
+// forget drops the callers' passes once nobody waits: fairness only
+// applies while the pool is contended, and a caller's share of an earlier
+// contention doesn't count against it in the next.
+func (s *slotScheduler) forget() {
+	if len(s.waiting) == 0 && len(s.pass) > 0 {
+		clear(s.pass)
+		s.vtime = 0
+	}
+}
+
+// used is the number of slots held.
+func (s *slotScheduler) used() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
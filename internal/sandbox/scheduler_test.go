@@ -0,0 +1,207 @@
+package sandbox
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeScheduler is a fair scheduler on a clock the test moves, with every
+// slot taken so each caller queues.
+func fakeScheduler(t *testing.T, size int, maxWait time.Duration) (*slotScheduler, *time.Time) {
+	t.Helper()
+	now := time.Unix(0, 0)
+	s := newSlotScheduler(size)
+	s.fair, s.maxWait, s.now = true, maxWait, func() time.Time { return now }
+	for range size {
+		if _, ok := s.enter(slotCaller{}); !ok {
+			t.Fatal("a free slot was not taken at once")
+		}
+	}
+	return s, &now
+}
+
+// queue has caller wait for a slot, adding the waiter to pending.
+func queue(t *testing.T, s *slotScheduler, c slotCaller, pending *[]*slotWaiter) *slotWaiter {
+	t.Helper()
+	w, ok := s.enter(c)
+	if ok {
+		t.Fatalf("%s took a slot with none free", c.identity)
+	}
+	*pending = append(*pending, w)
+	return w
+}
+
+// handOff releases a slot and returns the waiter it went to, taking it
+// out of pending.
+func handOff(t *testing.T, s *slotScheduler, pending *[]*slotWaiter) *slotWaiter {
+	t.Helper()
+	s.release()
+	var got []*slotWaiter
+	for _, w := range *pending {
+		select {
+		case <-w.granted:
+			got = append(got, w)
+		default:
+		}
+	}
+	if len(got) != 1 {
+		t.Fatalf("release granted %d waiters, want 1", len(got))
+	}
+	*pending = slices.DeleteFunc(*pending, func(w *slotWaiter) bool { return w == got[0] })
+	return got[0]
+}
+
+func TestSlotScheduler_SharesBetweenCallers(t *testing.T) {
+	tests := []struct {
+		name         string
+		heavy, light slotCaller
+		heavyRate    int     // waiters queued per tick
+		lightRate    int     // waiters queued per tick
+		wantRatio    float64 // slots heavy gets per slot light gets
+	}{
+		{"10:1 submissions, equal weights", slotCaller{"heavy", 1}, slotCaller{"light", 1}, 10, 1, 1},
+		{"equal submissions, weight 3", slotCaller{"heavy", 3}, slotCaller{"light", 0}, 5, 5, 3},
+		// The light caller is owed three slots for each of heavy's but
+		// only asks for one a tick: it gets all it asks for, and the heavy
+		// caller the rest.
+		{"10:1 submissions, weight 3 for the light caller", slotCaller{"heavy", 1}, slotCaller{"light", 3}, 10, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := fakeScheduler(t, 4, time.Hour)
+			var pending []*slotWaiter
+			granted := make(map[string]int)
+			for range 200 {
+				for range tt.heavyRate {
+					queue(t, s, tt.heavy, &pending)
+				}
+				for range tt.lightRate {
+					queue(t, s, tt.light, &pending)
+				}
+				for range 2 {
+					granted[handOff(t, s, &pending).identity]++
+				}
+			}
+			ratio := float64(granted["heavy"]) / float64(granted["light"])
+			if ratio < tt.wantRatio*0.95 || ratio > tt.wantRatio*1.05 {
+				t.Errorf("granted %v: heavy:light = %.2f, want %.0f", granted, ratio, tt.wantRatio)
+			}
+		})
+	}
+}
+
+func TestSlotScheduler_FIFOWhenNotFair(t *testing.T) {
+	s, _ := fakeScheduler(t, 1, time.Hour)
+	s.fair = false
+	var pending []*slotWaiter
+	var want []*slotWaiter
+	for _, id := range []string{"a", "a", "a", "b", "a", "b"} {
+		want = append(want, queue(t, s, slotCaller{identity: id}, &pending))
+	}
+	for i, w := range want {
+		if got := handOff(t, s, &pending); got != w {
+			t.Fatalf("slot %d went to a %s waiter out of arrival order", i, got.identity)
+		}
+	}
+	if len(s.pass) != 0 {
+		t.Errorf("passes kept without fair sharing: %v", s.pass)
+	}
+}
+
+func TestSlotScheduler_StarvationBound(t *testing.T) {
+	// A weight 100 caller outranks a weight 1 caller that has just had a
+	// slot for its next 100 slots. Once the weight 1 caller's waiter is
+	// the oldest and has waited max_wait, it goes next anyway.
+	const maxWait = 100 * time.Millisecond
+	s, now := fakeScheduler(t, 1, maxWait)
+	var pending []*slotWaiter
+	small, big := slotCaller{"small", 1}, slotCaller{"big", 100}
+	queue(t, s, small, &pending)
+	queue(t, s, big, &pending)
+	if w := handOff(t, s, &pending); w.identity != "small" {
+		t.Fatalf("tied callers: slot went to %s, want the earlier arrival", w.identity)
+	}
+
+	starved := queue(t, s, small, &pending)
+	arrived := *now
+	for i := 1; ; i++ {
+		queue(t, s, big, &pending)
+		*now = now.Add(10 * time.Millisecond)
+		w := handOff(t, s, &pending)
+		if w == starved {
+			if wait := now.Sub(arrived); wait != maxWait {
+				t.Errorf("starved waiter granted after %s, want %s", wait, maxWait)
+			}
+			break
+		}
+		if i > 20 {
+			t.Fatalf("starved waiter still waiting after %d slots", i)
+		}
+	}
+}
+
+func TestSlotScheduler_LeaveAfterGrant(t *testing.T) {
+	s, _ := fakeScheduler(t, 1, time.Hour)
+	var pending []*slotWaiter
+	first := queue(t, s, slotCaller{identity: "a"}, &pending)
+	second := queue(t, s, slotCaller{identity: "b"}, &pending)
+	if !s.leave(second) {
+		t.Error("leave of a waiter still queued reported it granted")
+	}
+	handOff(t, s, &pending)
+	if s.leave(first) {
+		t.Error("leave of a granted waiter reported it still queued")
+	}
+	if s.used() != 1 {
+		t.Errorf("used = %d, want the handed-off slot still held", s.used())
+	}
+	if len(s.pass) != 0 || s.vtime != 0 {
+		t.Errorf("passes kept with nobody waiting: %v at %v", s.pass, s.vtime)
+	}
+}
+
+func TestSlotLimiter_FairShareWaitMetric(t *testing.T) {
+	l := newSlotLimiter(1, map[string]int{defaultPool: 1})
+	obs := newRecordingObserver()
+	l.observer = obs
+	l.shareFairly(time.Second)
+
+	owner := strings.Repeat("ab", 32)
+	for _, c := range []slotCaller{{identity: owner}, {}} {
+		done, err := l.acquire(context.Background(), "python", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done()
+	}
+	if len(obs.shares[owner[:identityLabel]]) != 1 || len(obs.shares["anonymous"]) != 1 {
+		t.Errorf("fair share waits = %v, want one for %s and one anonymous", obs.shares, owner[:identityLabel])
+	}
+}
+
+func BenchmarkSlotLimiter_Uncontended(b *testing.B) {
+	for _, fair := range []bool{false, true} {
+		name := "fifo"
+		if fair {
+			name = "fair"
+		}
+		b.Run(name, func(b *testing.B) {
+			l := newSlotLimiter(4, map[string]int{defaultPool: 4})
+			if fair {
+				l.shareFairly(time.Second)
+			}
+			caller := slotCaller{identity: "bench", weight: 1}
+			b.ReportAllocs()
+			for b.Loop() {
+				done, err := l.acquire(context.Background(), "python", caller)
+				if err != nil {
+					b.Fatal(err)
+				}
+				done()
+			}
+		})
+	}
+}
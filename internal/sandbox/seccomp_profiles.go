@@ -0,0 +1,213 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSeccompHashInterval is how often a seccomp profile file is hashed
+// in full when sandbox.seccomp_hash_interval is unset.
+const DefaultSeccompHashInterval = 5 * time.Minute
+
+// seccompVariant is one of the seccomp profiles seccompProfileJSON picks
+// from, and the name of its file.
+type seccompVariant string
+
+const (
+	seccompDefault      seccompVariant = "default"
+	seccompNetwork      seccompVariant = "network"
+	seccompTrace        seccompVariant = "trace"
+	seccompTraceNetwork seccompVariant = "trace-network"
+)
+
+var seccompVariants = map[seccompVariant]ExecutionRequest{
+	seccompDefault:      {},
+	seccompNetwork:      {NetworkEnabled: true},
+	seccompTrace:        {DebugTrace: true},
+	seccompTraceNetwork: {DebugTrace: true, NetworkEnabled: true},
+}
+
+// seccompVariantOf is the variant req runs under.
+func seccompVariantOf(req ExecutionRequest) seccompVariant {
+	switch {
+	case req.DebugTrace && req.NetworkEnabled:
+		return seccompTraceNetwork
+	case req.DebugTrace:
+		return seccompTrace
+	case req.NetworkEnabled:
+		return seccompNetwork
+	default:
+		return seccompDefault
+	}
+}
+
+// seccompProfiles holds each seccomp profile serialized once, in a 0500
+// directory of the server's, and checks a file is what was written before
+// docker is pointed at it: its stat before every use, its SHA-256 every
+// interval or whenever the stat changed.
+type seccompProfiles struct {
+	dir      string
+	interval time.Duration
+	observer SeccompObserver
+	now      func() time.Time
+
+	mu    sync.Mutex
+	files map[seccompVariant]*seccompProfileFile
+}
+
+type seccompProfileFile struct {
+	path     string
+	data     []byte
+	digest   [sha256.Size]byte
+	info     os.FileInfo // as last verified
+	hashedAt time.Time
+}
+
+// newSeccompProfiles serializes every variant into a new directory under
+// os.TempDir. interval 0 hashes each file before every use.
+func newSeccompProfiles(interval time.Duration, observer SeccompObserver) (*seccompProfiles, error) {
+	dir, err := os.MkdirTemp("", "sandbox-seccomp-")
+	if err != nil {
+		return nil, fmt.Errorf("creating the seccomp profile directory: %w", err)
+	}
+	p := &seccompProfiles{
+		dir:      dir,
+		interval: interval,
+		observer: observer,
+		now:      time.Now,
+		files:    make(map[seccompVariant]*seccompProfileFile, len(seccompVariants)),
+	}
+	for v, req := range seccompVariants {
+		data, err := seccompProfileJSON(req)
+		if err != nil {
+			p.remove()
+			return nil, fmt.Errorf("seccomp profile %s: %w", v, err)
+		}
+		f := &seccompProfileFile{path: filepath.Join(dir, string(v)+".json"), data: data, digest: sha256.Sum256(data)}
+		p.files[v] = f
+		if err := p.write(f); err != nil {
+			p.remove()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(dir, 0o500); err != nil {
+		p.remove()
+		return nil, fmt.Errorf("protecting the seccomp profile directory: %w", err)
+	}
+	return p, nil
+}
+
+// seccompProfiles is the runner's profile files, written now if the backend
+// didn't at startup.
+func (d *DockerRunner) seccompProfiles() (*seccompProfiles, error) {
+	d.seccompMu.Lock()
+	defer d.seccompMu.Unlock()
+	if d.seccomp == nil {
+		p, err := newSeccompProfiles(d.seccompHash, d.seccompObs)
+		if err != nil {
+			return nil, err
+		}
+		d.seccomp = p
+	}
+	return d.seccomp, nil
+}
+
+// path is the file of the profile req runs under, verified. A file that
+// fails verification is rewritten and ErrSeccompProfileTampered returned:
+// the execution must not run against whatever it held.
+func (p *seccompProfiles) path(req ExecutionRequest) (string, error) {
+	v := seccompVariantOf(req)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.files[v]
+
+	now := p.now()
+	info, err := os.Lstat(f.path)
+	if err == nil && sameProfileStat(f.info, info) && now.Sub(f.hashedAt) < p.interval {
+		return f.path, nil
+	}
+	if err == nil && info.Mode().IsRegular() {
+		if data, readErr := os.ReadFile(f.path); readErr == nil && sha256.Sum256(data) == f.digest {
+			// Hashed clean. A touch alone isn't tampering, so the stat it now
+			// has is the one to expect; a file left writable, or another file
+			// moved or linked into its place, is.
+			if os.SameFile(f.info, info) && info.Mode().Perm() == 0o400 {
+				f.info, f.hashedAt = info, now
+				return f.path, nil
+			}
+		}
+	}
+
+	log.Error().Str("profile", string(v)).Str("path", f.path).Msg("seccomp profile file changed on disk; rewriting it and refusing the execution")
+	if p.observer != nil {
+		p.observer.SeccompProfileTampered(string(v))
+	}
+	if err := p.rewrite(f); err != nil {
+		log.Error().Err(err).Str("profile", string(v)).Msg("rewriting the seccomp profile file failed")
+	}
+	return "", fmt.Errorf("%w: %s", ErrSeccompProfileTampered, string(v))
+}
+
+// sameProfileStat reports whether b is the stat a was taken of, unchanged.
+func sameProfileStat(a, b os.FileInfo) bool {
+	return a != nil && os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime()) && a.Mode() == b.Mode()
+}
+
+// rewrite writes f again into the protected directory, opening it up for
+// as long as that takes.
+func (p *seccompProfiles) rewrite(f *seccompProfileFile) error {
+	if err := os.Chmod(p.dir, 0o700); err != nil {
+		return err
+	}
+	defer os.Chmod(p.dir, 0o500)
+	return p.write(f)
+}
+
+// write replaces f's file with a 0400 one holding f.data and records its
+// stat. The directory must be writable.
+func (p *seccompProfiles) write(f *seccompProfileFile) error {
+	tmp, err := os.CreateTemp(p.dir, ".profile-")
+	if err != nil {
+		return fmt.Errorf("writing seccomp profile: %w", err)
+	}
+	_, err = tmp.Write(f.data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o400)
+	}
+	if err == nil {
+		// A directory or link planted at the path would survive the rename.
+		if info, statErr := os.Lstat(f.path); statErr == nil && !info.Mode().IsRegular() {
+			err = os.RemoveAll(f.path)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing seccomp profile: %w", err)
+	}
+	info, err := os.Lstat(f.path)
+	if err != nil {
+		return fmt.Errorf("writing seccomp profile: %w", err)
+	}
+	f.info, f.hashedAt = info, p.now()
+	return nil
+}
+
+// remove deletes the directory and every profile in it.
+func (p *seccompProfiles) remove() {
+	os.Chmod(p.dir, 0o700)
+	if err := os.RemoveAll(p.dir); err != nil {
+		log.Warn().Err(err).Str("dir", p.dir).Msg("removing the seccomp profile directory failed")
+	}
+}
@@ -0,0 +1,203 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tamperCounter map[string]int
+
+func (tamperCounter) SeccompVerified(string)            {}
+func (c tamperCounter) SeccompProfileTampered(p string) { c[p]++ }
+
+func testSeccompProfiles(t *testing.T, interval time.Duration) (*seccompProfiles, tamperCounter) {
+	t.Helper()
+	obs := tamperCounter{}
+	p, err := newSeccompProfiles(interval, obs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.remove)
+	return p, obs
+}
+
+// tamper runs change on the file of req's profile with its directory
+// writable, as anything running as the server could.
+func tamper(t *testing.T, p *seccompProfiles, req ExecutionRequest, change func(path string) error) {
+	t.Helper()
+	if err := os.Chmod(p.dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(p.dir, 0o500)
+	if err := change(p.files[seccompVariantOf(req)].path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeccompProfiles_PathPerVariant(t *testing.T) {
+	p, _ := testSeccompProfiles(t, time.Minute)
+	if info, err := os.Stat(p.dir); err != nil || info.Mode().Perm() != 0o500 {
+		t.Fatalf("directory: %v, %v", info, err)
+	}
+	seen := map[string]bool{}
+	for _, req := range seccompVariants {
+		path, err := p.path(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(path) != p.dir || seen[path] {
+			t.Errorf("%+v: path %s", req, path)
+		}
+		seen[path] = true
+		if again, _ := p.path(req); again != path {
+			t.Errorf("%+v: path moved from %s to %s", req, path, again)
+		}
+		want, _ := seccompProfileJSON(req)
+		got, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%+v: file doesn't hold its profile (%v)", req, err)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o400 {
+			t.Errorf("%+v: mode %v", req, info.Mode())
+		}
+	}
+}
+
+func TestSeccompProfiles_Tampered(t *testing.T) {
+	req := ExecutionRequest{NetworkEnabled: true}
+	want, _ := seccompProfileJSON(req)
+	tests := []struct {
+		name   string
+		change func(path string) error
+	}{
+		{"rewritten", func(path string) error {
+			os.Chmod(path, 0o600)
+			if err := os.WriteFile(path, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0o600); err != nil {
+				return err
+			}
+			return os.Chmod(path, 0o400)
+		}},
+		{"replaced", func(path string) error {
+			if err := os.WriteFile(path+".new", want, 0o400); err != nil { // the same bytes, another file
+				return err
+			}
+			return os.Rename(path+".new", path)
+		}},
+		{"left writable", func(path string) error { return os.Chmod(path, 0o666) }},
+		{"removed", os.Remove},
+		{"a directory", func(path string) error {
+			os.Remove(path)
+			return os.Mkdir(path, 0o700)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, obs := testSeccompProfiles(t, time.Hour)
+			tamper(t, p, req, tt.change)
+			if _, err := p.path(req); !errors.Is(err, ErrSeccompProfileTampered) {
+				t.Fatalf("path() = %v, want ErrSeccompProfileTampered", err)
+			}
+			if obs[string(seccompNetwork)] != 1 {
+				t.Errorf("observer saw %v", obs)
+			}
+			path, err := p.path(req)
+			if err != nil {
+				t.Fatalf("after the rewrite: %v", err)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, want) {
+				t.Error("the file wasn't rewritten with its profile")
+			}
+			if info, _ := os.Stat(p.dir); info.Mode().Perm() != 0o500 {
+				t.Errorf("directory left %v", info.Mode())
+			}
+		})
+	}
+
+	t.Run("stat preserved until the full hash", func(t *testing.T) {
+		p, obs := testSeccompProfiles(t, time.Hour)
+		now := time.Now()
+		p.now = func() time.Time { return now }
+		path, _ := p.path(req)
+		info, _ := os.Stat(path)
+		forged := bytes.Replace(want, []byte("SCMP_ACT_ERRNO"), []byte("SCMP_ACT_ALLOW"), 1) // the same size
+		if bytes.Equal(forged, want) {
+			t.Fatal("the profile has no SCMP_ACT_ERRNO to forge")
+		}
+		tamper(t, p, req, func(path string) error {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			f.Write(forged)
+			f.Close()
+			return os.Chtimes(path, info.ModTime(), info.ModTime())
+		})
+		if _, err := p.path(req); err != nil {
+			t.Fatalf("the stat check hashed: %v", err)
+		}
+		now = now.Add(time.Hour)
+		if _, err := p.path(req); !errors.Is(err, ErrSeccompProfileTampered) || obs[string(seccompNetwork)] != 1 {
+			t.Fatalf("after the interval: %v, observer saw %v", err, obs)
+		}
+	})
+
+	t.Run("touched", func(t *testing.T) {
+		p, obs := testSeccompProfiles(t, time.Hour)
+		tamper(t, p, req, func(path string) error {
+			at := time.Now().Add(time.Minute)
+			return os.Chtimes(path, at, at)
+		})
+		if _, err := p.path(req); err != nil || len(obs) != 0 {
+			t.Errorf("a touch refused: %v, observer saw %v", err, obs)
+		}
+	})
+}
+
+func TestDockerRunner_SeccompProfilePath(t *testing.T) {
+	d := scriptedDockerRunner(t, `for a; do case "$a" in seccomp=*) echo "${a#seccomp=}" ;; esac; done`)
+	result, err := d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "print(1)", NetworkEnabled: true, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(result.Output), d.seccomp.files[seccompNetwork].path; got != want {
+		t.Errorf("docker run got seccomp=%s, want %s", got, want)
+	}
+}
+
+// BenchmarkSeccompProfile compares serializing and writing the profile for
+// every execution with verifying the file written at startup.
+func BenchmarkSeccompProfile(b *testing.B) {
+	req := ExecutionRequest{NetworkEnabled: true}
+	b.Run("per_execution", func(b *testing.B) {
+		dir := b.TempDir()
+		for b.Loop() {
+			data, err := seccompProfileJSON(req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "seccomp.json"), data, 0o600); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for name, interval := range map[string]time.Duration{"verified": DefaultSeccompHashInterval, "hashed": 0} {
+		b.Run(name, func(b *testing.B) {
+			p, err := newSeccompProfiles(interval, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer p.remove()
+			for b.Loop() {
+				if _, err := p.path(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
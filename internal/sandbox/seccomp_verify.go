@@ -0,0 +1,123 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Seccomp verification outcomes, as reported to SeccompObserver.
+const (
+	SeccompApplied    = "applied"     // the process started in filter mode
+	SeccompNotApplied = "not_applied" // the wrapper refused to start the process
+	SeccompUnreported = "unreported"  // the process ran but its status file was unreadable afterwards
+)
+
+const (
+	// seccompStatusFile is the host file, in the execution's temp dir, that
+	// the wrapper writes the process's seccomp fields to.
+	seccompStatusFile = "seccomp-status"
+	// seccompStatusPath is where that file is mounted in the container.
+	seccompStatusPath = "/run/sandbox/seccomp"
+	// exitSeccompNotApplied is the wrapper's exit code when filtering is off.
+	exitSeccompNotApplied = 97
+)
+
+// seccompWrapper runs under /bin/sh ahead of the runtime's command. It copies
+// the Seccomp fields of its own /proc status, which the command inherits
+// through exec, to seccompStatusPath, and starts the command only in filter
+// mode (2) with at least one filter. Kernels before 5.9 don't report
+// Seccomp_filters; there the mode alone decides.
+const seccompWrapper = `mode= filters=
+while IFS=: read -r key value; do
+	case $key in
+	Seccomp) mode=${value##*[!0-9]} ;;
+	Seccomp_filters) filters=${value##*[!0-9]} ;;
+	*) continue ;;
+	esac
+	echo "$key:$value"
+done </proc/self/status >` + seccompStatusPath + `
+[ "$mode" = 2 ] && [ "${filters:-1}" != 0 ] || exit 97
+exec "$@"`
+
+// SeccompObserver receives seccomp verification outcomes from the Docker
+// backend, and the profile files it found changed on disk.
+type SeccompObserver interface {
+	SeccompVerified(outcome string)
+	SeccompProfileTampered(profile string)
+}
+
+// SeccompStatus is the seccomp state a sandboxed process started under.
+type SeccompStatus struct {
+	Mode    string `json:"mode"`              // disabled, strict or filter
+	Filters int    `json:"filters,omitempty"` // filters attached; 0 when the kernel doesn't report them
+	// filtersReported is false on kernels without Seccomp_filters.
+	filtersReported bool
+}
+
+var seccompModes = map[int]string{0: "disabled", 1: "strict", 2: "filter"}
+
+// applied reports whether the process ran with a seccomp filter.
+func (s *SeccompStatus) applied() bool {
+	return s.Mode == "filter" && (s.Filters > 0 || !s.filtersReported)
+}
+
+// parseSeccompStatus reads the Seccomp and Seccomp_filters fields of a
+// /proc/<pid>/status, or of the wrapper's copy of them.
+func parseSeccompStatus(data []byte) (*SeccompStatus, error) {
+	var status *SeccompStatus
+	filters := -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (key != "Seccomp" && key != "Seccomp_filters") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s value %q", key, strings.TrimSpace(value))
+		}
+		if key == "Seccomp_filters" {
+			filters = n
+			continue
+		}
+		mode, known := seccompModes[n]
+		if !known {
+			return nil, fmt.Errorf("unknown seccomp mode %d", n)
+		}
+		status = &SeccompStatus{Mode: mode}
+	}
+	if status == nil {
+		return nil, fmt.Errorf("no Seccomp field")
+	}
+	if filters >= 0 {
+		status.Filters, status.filtersReported = filters, true
+	}
+	return status, nil
+}
+
+// seccompCommand prefixes argv with the verification wrapper.
+func seccompCommand(argv []string) []string {
+	return append([]string{"/bin/sh", "-c", seccompWrapper, "sandbox-seccomp"}, argv...)
+}
+
+// verifySeccomp decides what a finished run's status file says. The
+// wrapper's exit code alone can't tell a refusal from code that exits 97, so
+// a refusal needs the file to agree, or to be missing: the wrapper writes it
+// before it checks anything. Code that ran can rewrite the file, so a file
+// read after a normal exit only feeds the report.
+func verifySeccomp(data []byte, readErr error, exitCode int) (*SeccompStatus, string) {
+	var status *SeccompStatus
+	if readErr == nil {
+		status, _ = parseSeccompStatus(data)
+	}
+	if exitCode == exitSeccompNotApplied && (status == nil || !status.applied()) {
+		return status, SeccompNotApplied
+	}
+	if status == nil || !status.applied() {
+		return nil, SeccompUnreported
+	}
+	return status, SeccompApplied
+}
@@ -0,0 +1,181 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSeccompStatus(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    SeccompStatus
+		applied bool
+	}{
+		{"proc_status_filter", SeccompStatus{Mode: "filter", Filters: 1, filtersReported: true}, true},
+		{"proc_status_unconfined", SeccompStatus{Mode: "disabled", filtersReported: true}, false},
+		{"proc_status_no_filters", SeccompStatus{Mode: "filter", filtersReported: true}, false},
+		// Seccomp_filters arrived in 5.9; before that the mode alone decides.
+		{"proc_status_old_kernel", SeccompStatus{Mode: "filter"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseSeccompStatus(data)
+			if err != nil {
+				t.Fatalf("parseSeccompStatus: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("status = %+v, want %+v", *got, tt.want)
+			}
+			if got.applied() != tt.applied {
+				t.Errorf("applied() = %v, want %v", got.applied(), tt.applied)
+			}
+		})
+	}
+}
+
+func TestParseSeccompStatus_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"Name:\tpython3\nUid:\t65534\n",
+		"Seccomp:\t3\n",
+		"Seccomp:\tfilter\n",
+		"Seccomp:\t2\nSeccomp_filters:\t-1\n",
+	} {
+		if status, err := parseSeccompStatus([]byte(data)); err == nil {
+			t.Errorf("parseSeccompStatus(%q) = %+v, want an error", data, status)
+		}
+	}
+}
+
+func TestVerifySeccomp(t *testing.T) {
+	filter := "Seccomp:\t2\nSeccomp_filters:\t1\n"
+	tests := []struct {
+		name     string
+		data     string
+		readErr  error
+		exitCode int
+		want     string
+		reported bool // a status comes back
+	}{
+		{"applied", filter, nil, 0, SeccompApplied, true},
+		{"code exited 97", filter, nil, exitSeccompNotApplied, SeccompApplied, true},
+		{"refused", "Seccomp:\t0\nSeccomp_filters:\t0\n", nil, exitSeccompNotApplied, SeccompNotApplied, true},
+		{"refused before writing", "", nil, exitSeccompNotApplied, SeccompNotApplied, false},
+		{"status file gone", "", os.ErrNotExist, exitSeccompNotApplied, SeccompNotApplied, false},
+		{"code overwrote the file", "garbage", nil, 1, SeccompUnreported, false},
+		{"code disabled reporting", "Seccomp:\t0\n", nil, 0, SeccompUnreported, false},
+		{"killed", "", os.ErrNotExist, -1, SeccompUnreported, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, outcome := verifySeccomp([]byte(tt.data), tt.readErr, tt.exitCode)
+			if outcome != tt.want {
+				t.Errorf("outcome = %s, want %s", outcome, tt.want)
+			}
+			if (status != nil) != tt.reported {
+				t.Errorf("status = %+v, want one: %v", status, tt.reported)
+			}
+		})
+	}
+}
+
+// runSeccompWrapper runs the wrapper against a fixture in place of
+// /proc/self/status and returns its exit code, what it wrote and the
+// command's output.
+func runSeccompWrapper(t *testing.T, fixture string) (int, []byte, string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh in PATH")
+	}
+	statusFile := filepath.Join(t.TempDir(), seccompStatusFile)
+	script := strings.NewReplacer(
+		"/proc/self/status", filepath.Join("testdata", fixture),
+		seccompStatusPath, statusFile,
+	).Replace(seccompWrapper)
+
+	out, err := exec.Command("sh", "-c", script, "sandbox-seccomp", "echo", "ran").Output()
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(statusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exitCode, written, string(out)
+}
+
+func TestSeccompWrapper(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		exitCode int
+		outcome  string
+	}{
+		{"proc_status_filter", 0, SeccompApplied},
+		{"proc_status_old_kernel", 0, SeccompApplied},
+		{"proc_status_unconfined", exitSeccompNotApplied, SeccompNotApplied},
+		{"proc_status_no_filters", exitSeccompNotApplied, SeccompNotApplied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			exitCode, written, out := runSeccompWrapper(t, tt.fixture)
+			if exitCode != tt.exitCode {
+				t.Fatalf("exit code = %d, want %d", exitCode, tt.exitCode)
+			}
+			if ran := out == "ran\n"; ran != (tt.exitCode == 0) {
+				t.Errorf("command output %q with exit code %d", out, exitCode)
+			}
+			if _, outcome := verifySeccomp(written, nil, exitCode); outcome != tt.outcome {
+				t.Errorf("outcome = %s, want %s (status file %q)", outcome, tt.outcome, written)
+			}
+
+			// What the wrapper copies parses like the full status.
+			fixture, _ := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			want, _ := parseSeccompStatus(fixture)
+			if got, err := parseSeccompStatus(written); err != nil || *got != *want {
+				t.Errorf("status file parses to %+v, %v; want %+v", got, err, want)
+			}
+		})
+	}
+}
+
+func TestBuildDockerArgs_VerifySeccomp(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	d.verifySeccomp = true
+	py, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-s", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-s", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1", Args: []string{"arg"}},
+	)
+	if !argsContainPair(args, "-v", "/tmp/sandbox-exec-s/"+seccompStatusFile+":"+seccompStatusPath+":rw") {
+		t.Errorf("expected the seccomp status file mount in %v", args)
+	}
+	want := []string{py.Image(), "/bin/sh", "-c", seccompWrapper, "sandbox-seccomp", "python3", "-u", "-B", "/workspace/code.py", "arg"}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+
+	// Claude runs its own entrypoint unwrapped.
+	claude, _ := d.runtimes.Get("claude")
+	args = d.buildDockerArgs("exec-sc", claude,
+		"/tmp/p.txt", "/tmp/prompt.txt",
+		"/tmp/sandbox-exec-sc", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "claude", Code: "hi"},
+	)
+	if argsContain(args, seccompWrapper) || argsContainPrefix(args, "/tmp/sandbox-exec-sc/"+seccompStatusFile) {
+		t.Errorf("claude args are wrapped: %v", args)
+	}
+}
@@ -1,6 +1,8 @@
 package sandbox
 
 import (
+	"slices"
+
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	"safe-agent-sandbox/pkg/seccomp"
@@ -14,6 +16,32 @@ type SecurityProfile struct {
 	ReadonlyPaths []string
 }
 
+// maskedPaths are hidden from sandboxed code on both backends: containerd
+// gets them as the spec's MaskedPaths, Docker as dockerPathArgs translates
+// them.
+var maskedPaths = []string{
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// readonlyPaths are read-only to sandboxed code on both backends, likewise.
+var readonlyPaths = []string{
+	"/proc/asound",
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
 func DefaultSecurityProfile() SecurityProfile {
 	return SecurityProfile{
 		Seccomp:      seccomp.DefaultProfile(),
@@ -27,26 +55,8 @@ func DefaultSecurityProfile() SecurityProfile {
 			{Type: specs.UserNamespace},
 			{Type: specs.CgroupNamespace},
 		},
-		MaskedPaths: []string{
-			"/proc/acpi",
-			"/proc/kcore",
-			"/proc/keys",
-			"/proc/latency_stats",
-			"/proc/timer_list",
-			"/proc/timer_stats",
-			"/proc/sched_debug",
-			"/proc/scsi",
-			"/sys/firmware",
-			"/sys/devices/virtual/powercap",
-		},
-		ReadonlyPaths: []string{
-			"/proc/asound",
-			"/proc/bus",
-			"/proc/fs",
-			"/proc/irq",
-			"/proc/sys",
-			"/proc/sysrq-trigger",
-		},
+		MaskedPaths:   slices.Clone(maskedPaths),
+		ReadonlyPaths: slices.Clone(readonlyPaths),
 	}
 }
 
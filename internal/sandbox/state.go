@@ -0,0 +1,40 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// StateOf returns the state an execution ended in, from what the backend
+// returned for it. The result's exit class, when there is one, decides;
+// otherwise the error does.
+func StateOf(result *ExecutionResult, err error) state.State {
+	if result != nil {
+		switch c := result.ExitClass; {
+		case c == ExitOOMKill:
+			return state.OOM
+		case c == ExitTimeoutKill, c == ExitIdleTimeout:
+			return state.Timeout
+		case c == ExitManualKill, c == ExitCPUAbuse:
+			return state.Killed
+		case c == ExitInfraError, c.IsSignal():
+			return state.Failed
+		}
+	}
+	switch {
+	case err == nil:
+		return state.Completed
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrIdleTimeout):
+		return state.Timeout
+	case errors.Is(err, ErrOOM):
+		return state.OOM
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrUnsupportedLang):
+		return state.Rejected
+	case errors.Is(err, context.Canceled):
+		return state.Cancelled
+	default:
+		return state.Failed
+	}
+}
@@ -0,0 +1,53 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+func TestStateOf(t *testing.T) {
+	exited := func(c ExitClass) *ExecutionResult { return &ExecutionResult{ExitClass: c} }
+	tests := []struct {
+		name   string
+		result *ExecutionResult
+		err    error
+		want   state.State
+	}{
+		{"user exit", exited(ExitUser), nil, state.Completed},
+		{"nonzero user exit", &ExecutionResult{ExitClass: ExitUser, ExitCode: 1}, nil, state.Completed},
+		{"no class", exited(""), nil, state.Completed},
+		{"oom kill", exited(ExitOOMKill), nil, state.OOM},
+		{"timeout kill", exited(ExitTimeoutKill), ErrTimeout, state.Timeout},
+		{"idle timeout", exited(ExitIdleTimeout), nil, state.Timeout},
+		{"manual kill", exited(ExitManualKill), nil, state.Killed},
+		{"cpu abuse", exited(ExitCPUAbuse), ErrCPUAbuse, state.Killed},
+		{"infra error", exited(ExitInfraError), nil, state.Failed},
+		{"signal", exited(ExitSignal(11)), nil, state.Failed},
+		{"class decides over error", exited(ExitOOMKill), ErrTimeout, state.OOM},
+		{"timeout error", nil, fmt.Errorf("run: %w", ErrTimeout), state.Timeout},
+		{"idle timeout error", nil, ErrIdleTimeout, state.Timeout},
+		{"oom error", nil, ErrOOM, state.OOM},
+		{"invalid request", nil, ErrInvalidRequest, state.Rejected},
+		{"unsupported language", nil, ErrUnsupportedLang, state.Rejected},
+		{"caller gone", nil, context.Canceled, state.Cancelled},
+		{"rate limited", nil, ErrRateLimited, state.Failed},
+		{"saturated", nil, ErrLanguageSaturated, state.Failed},
+		{"security", nil, ErrSecurityViolation, state.Failed},
+		{"anything else", nil, errors.New("docker: boom"), state.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StateOf(tt.result, tt.err)
+			if got != tt.want {
+				t.Errorf("StateOf = %s, want %s", got, tt.want)
+			}
+			if !got.Terminal() {
+				t.Errorf("StateOf returned %s, not a terminal state", got)
+			}
+		})
+	}
+}
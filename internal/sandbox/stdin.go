@@ -0,0 +1,37 @@
+package sandbox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultMaxStdinBytes caps ExecutionRequest.Stdin when
+// sandbox.max_stdin_bytes is unset.
+const DefaultMaxStdinBytes = 1 << 20
+
+// validateStdin checks the stdin a request carries against maxBytes, the
+// runner's sandbox.max_stdin_bytes; 0 refuses any. Claude reads its prompt
+// from the code file and takes none.
+func validateStdin(req ExecutionRequest, maxBytes int64) error {
+	switch {
+	case req.Stdin == "":
+		return nil
+	case req.Language == "claude":
+		return fmt.Errorf("%w: stdin is not supported for claude", ErrInvalidRequest)
+	case maxBytes == 0:
+		return fmt.Errorf("%w: stdin is disabled (sandbox.max_stdin_bytes is 0)", ErrInvalidRequest)
+	case int64(len(req.Stdin)) > maxBytes:
+		return fmt.Errorf("%w: stdin is %d bytes, over the %d byte limit", ErrInvalidRequest, len(req.Stdin), maxBytes)
+	}
+	return nil
+}
+
+// stdinReader is the reader req's program gets as its standard input, or
+// nil for none. Each call starts from the beginning, for a retried run.
+func stdinReader(req ExecutionRequest) io.Reader {
+	if req.Stdin == "" {
+		return nil
+	}
+	return strings.NewReader(req.Stdin)
+}
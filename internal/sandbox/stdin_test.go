@@ -0,0 +1,70 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateStdin(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      ExecutionRequest
+		maxBytes int64
+		wantErr  bool
+	}{
+		{"none", ExecutionRequest{Language: "python"}, 0, false},
+		{"under the cap", ExecutionRequest{Language: "python", Stdin: "1 2 3"}, 5, false},
+		{"over the cap", ExecutionRequest{Language: "python", Stdin: "1 2 3"}, 4, true},
+		{"disabled", ExecutionRequest{Language: "python", Stdin: "x"}, 0, true},
+		{"claude", ExecutionRequest{Language: "claude", Stdin: "x"}, DefaultMaxStdinBytes, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStdin(tt.req, tt.maxBytes)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidRequest)) {
+				t.Errorf("validateStdin = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStdinReader(t *testing.T) {
+	if r := stdinReader(ExecutionRequest{}); r != nil {
+		t.Errorf("no stdin gave reader %v", r)
+	}
+	req := ExecutionRequest{Stdin: "data"}
+	for range 2 { // a retry reads it from the start
+		if b, _ := io.ReadAll(stdinReader(req)); string(b) != "data" {
+			t.Errorf("read %q", b)
+		}
+	}
+}
+
+func TestDockerRunner_Stdin(t *testing.T) {
+	d := scriptedDockerRunner(t, `case "$*" in *--interactive*) cat ;; *) echo "not interactive" ;; esac`)
+	input := "line one\nline two\n"
+	result, err := d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "import sys; print(sys.stdin.read())", Stdin: input, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != input {
+		t.Errorf("output %q, want the stdin back", result.Output)
+	}
+
+	result, err = d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "print(1)", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Output, "not interactive") {
+		t.Errorf("without stdin: output %q", result.Output)
+	}
+
+	d.maxStdin = 4
+	if _, err := d.Execute(context.Background(), ExecutionRequest{Language: "python", Code: "1", Stdin: input, Timeout: 5 * time.Second}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("over sandbox.max_stdin_bytes: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	// warmupRecheck is how long a probe is trusted before the image's digest
+	// is looked up again; a re-tagged image is probed afresh within it.
+	warmupRecheck = time.Minute
+	// warmupProbeTimeout bounds the inspect and the probe container together.
+	warmupProbeTimeout = 15 * time.Second
+)
+
+// warmupProbes finds out which warmup options runtime images support by
+// running each runtime's probe command in its image, once per image digest.
+// Executions that find no usable probe simply run without warmups.
+type warmupProbes struct {
+	docker func(ctx context.Context, args ...string) ([]byte, error)
+	now    func() time.Time
+
+	mu     sync.Mutex
+	images map[string]imageProbe // by image reference
+}
+
+type imageProbe struct {
+	digest  string
+	probe   runtime.ImageProbe
+	ok      bool // the probe ran and parsed
+	checked time.Time
+}
+
+func newWarmupProbes(docker func(ctx context.Context, args ...string) ([]byte, error)) *warmupProbes {
+	return &warmupProbes{docker: docker, now: time.Now, images: make(map[string]imageProbe)}
+}
+
+// get returns the probe of image, running argv in it when the image's digest
+// is new. False means there is no usable probe: the image isn't pulled yet,
+// which is checked again next time, or the probe failed, which is retried
+// after warmupRecheck.
+func (w *warmupProbes) get(ctx context.Context, image string, argv []string) (runtime.ImageProbe, bool) {
+	now := w.now()
+	w.mu.Lock()
+	cached, found := w.images[image]
+	w.mu.Unlock()
+	if found && now.Sub(cached.checked) < warmupRecheck {
+		return cached.probe, cached.ok
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmupProbeTimeout)
+	defer cancel()
+	entry := imageProbe{checked: now}
+	out, err := w.docker(ctx, "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return entry.probe, false
+	}
+	entry.digest = strings.TrimSpace(string(out))
+	if found && cached.ok && cached.digest == entry.digest {
+		entry.probe, entry.ok = cached.probe, true
+		w.store(image, entry)
+		return entry.probe, true
+	}
+
+	if out, err = w.docker(ctx, probeRunArgs(image, argv...)...); err == nil {
+		err = json.Unmarshal(bytes.TrimSpace(out), &entry.probe)
+	}
+	entry.ok = err == nil
+	if err != nil {
+		log.Warn().Err(err).Str("image", image).Msg("warmup probe failed, running without warmups")
+	} else {
+		log.Info().Str("image", image).Str("digest", entry.digest).Interface("probe", entry.probe).Msg("probed runtime image for warmups")
+	}
+	w.store(image, entry)
+	return entry.probe, entry.ok
+}
+
+// probeRunArgs are the docker arguments that run argv in a throwaway
+// container of image, locked down like an execution's.
+func probeRunArgs(image string, argv ...string) []string {
+	return append([]string{
+		"run", "--rm",
+		"--network", "none",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+		"--user", "65534:65534",
+		"--memory", "128m",
+		"--pids-limit", "32",
+		image,
+	}, argv...)
+}
+
+func (w *warmupProbes) store(image string, entry imageProbe) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.images[image] = entry
+}
+
+// chooseWarmups returns the warmup options an execution of rt can use: those
+// enabled for the language that its image and code allow, less ignore_env
+// when env sets a PYTHON* variable it would throw away.
+func chooseWarmups(ctx context.Context, probes *warmupProbes, runtimes *runtime.Registry, rt runtime.Runtime, code string, env []string) []string {
+	w, ok := runtime.AsWarmable(rt)
+	enabled := runtimes.Warmup(rt.Name())
+	if !ok || len(enabled) == 0 || probes == nil {
+		return nil
+	}
+	probe, ok := probes.get(ctx, rt.Image(), w.ProbeCommand())
+	if !ok {
+		return nil
+	}
+	warmups := w.Warmups(probe, enabled, code)
+	if slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "PYTHON") }) {
+		warmups = slices.DeleteFunc(warmups, func(opt string) bool { return opt == runtime.WarmupIgnoreEnv })
+	}
+	return warmups
+}
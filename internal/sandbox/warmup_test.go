@@ -0,0 +1,173 @@
+package sandbox
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/runtime"
+)
+
+const (
+	pythonImage     = "docker.io/library/python:3.12-slim"
+	stockPythonJSON = `{"version":"3.12.4","site_packages":["pip-24.0.dist-info"]}`
+)
+
+func testWarmupProbes(docker *fakeDocker) (*warmupProbes, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newWarmupProbes(docker.run)
+	w.now = func() time.Time { return now }
+	return w, &now
+}
+
+func TestWarmupProbes_CachedByDigest(t *testing.T) {
+	docker := &fakeDocker{output: map[string]string{
+		"image inspect": "sha256:aaa\n",
+		"run --rm":      stockPythonJSON + "\n",
+	}}
+	w, now := testWarmupProbes(docker)
+	argv := (&runtime.PythonRuntime{}).ProbeCommand()
+
+	probe, ok := w.get(context.Background(), pythonImage, argv)
+	if !ok || probe.Version != "3.12.4" || !reflect.DeepEqual(probe.SitePackages, []string{"pip-24.0.dist-info"}) {
+		t.Fatalf("get() = %+v, %v", probe, ok)
+	}
+	w.get(context.Background(), pythonImage, argv)
+	if n := len(docker.commands("image inspect")); n != 1 {
+		t.Errorf("inspected %d times within the recheck interval, want 1", n)
+	}
+
+	// Past the interval the digest is looked up again, but the same digest
+	// isn't probed again.
+	*now = now.Add(warmupRecheck)
+	if _, ok := w.get(context.Background(), pythonImage, argv); !ok {
+		t.Fatal("probe lost on recheck")
+	}
+	if n := len(docker.commands("image inspect")); n != 2 {
+		t.Errorf("inspected %d times, want 2", n)
+	}
+	if n := len(docker.commands("run --rm")); n != 1 {
+		t.Errorf("probed %d times for one digest, want 1", n)
+	}
+
+	// A new digest behind the tag is probed.
+	docker.output["image inspect"] = "sha256:bbb\n"
+	docker.output["run --rm"] = `{"version":"3.12.5","precompiled_stdlib":true}`
+	*now = now.Add(warmupRecheck)
+	if probe, _ := w.get(context.Background(), pythonImage, argv); probe.Version != "3.12.5" || !probe.PrecompiledStdlib {
+		t.Errorf("after retag: %+v", probe)
+	}
+	if n := len(docker.commands("run --rm")); n != 2 {
+		t.Errorf("probed %d times, want 2", n)
+	}
+}
+
+func TestWarmupProbes_ProbeContainer(t *testing.T) {
+	docker := &fakeDocker{output: map[string]string{"image inspect": "sha256:aaa", "run --rm": stockPythonJSON}}
+	w, _ := testWarmupProbes(docker)
+	w.get(context.Background(), pythonImage, []string{"python3", "-c", "print(1)"})
+
+	runs := docker.commands("run --rm")
+	if len(runs) != 1 {
+		t.Fatalf("probe runs = %v", runs)
+	}
+	want := "run --rm --network none --cap-drop ALL --security-opt no-new-privileges --read-only --user 65534:65534 --memory 128m --pids-limit 32 " +
+		pythonImage + " python3 -c print(1)"
+	if runs[0] != want {
+		t.Errorf("probe = %q, want %q", runs[0], want)
+	}
+}
+
+func TestWarmupProbes_Failures(t *testing.T) {
+	argv := []string{"python3", "-c", "x"}
+
+	// Not pulled yet: no probe, and the next execution, which follows the
+	// pull, looks again.
+	docker := &fakeDocker{fail: map[string]bool{"image inspect --format {{.Id}} " + pythonImage: true}}
+	w, now := testWarmupProbes(docker)
+	if _, ok := w.get(context.Background(), pythonImage, argv); ok {
+		t.Error("probe reported for an image that isn't there")
+	}
+	delete(docker.fail, "image inspect --format {{.Id}} "+pythonImage)
+	docker.output = map[string]string{"image inspect": "sha256:aaa", "run --rm": stockPythonJSON}
+	if _, ok := w.get(context.Background(), pythonImage, argv); !ok {
+		t.Error("image not probed once pulled")
+	}
+
+	// Output that isn't a probe counts as a failure, retried after the
+	// recheck interval.
+	docker = &fakeDocker{output: map[string]string{"image inspect": "sha256:aaa", "run --rm": "python3: not found"}}
+	w, now = testWarmupProbes(docker)
+	if _, ok := w.get(context.Background(), pythonImage, argv); ok {
+		t.Error("garbled probe accepted")
+	}
+	w.get(context.Background(), pythonImage, argv)
+	if n := len(docker.commands("run --rm")); n != 1 {
+		t.Errorf("probed %d times within the recheck interval, want 1", n)
+	}
+	docker.output["run --rm"] = stockPythonJSON
+	*now = now.Add(warmupRecheck)
+	if _, ok := w.get(context.Background(), pythonImage, argv); !ok {
+		t.Error("failed probe not retried for the same digest")
+	}
+}
+
+func TestChooseWarmups(t *testing.T) {
+	docker := &fakeDocker{output: map[string]string{"image inspect": "sha256:aaa", "run --rm": stockPythonJSON}}
+	w, _ := testWarmupProbes(docker)
+	runtimes := runtime.NewRegistry()
+	py, _ := runtimes.Get("python")
+	bash, _ := runtimes.Get("bash")
+
+	if got := chooseWarmups(context.Background(), w, runtimes, py, "print(1)", nil); !reflect.DeepEqual(got, []string{runtime.WarmupNoSite, runtime.WarmupIgnoreEnv}) {
+		t.Errorf("python = %v", got)
+	}
+	if got := chooseWarmups(context.Background(), w, runtimes, py, "print(1)", []string{"TZ=UTC", "PYTHONDONTWRITEBYTECODE=1"}); !reflect.DeepEqual(got, []string{runtime.WarmupNoSite}) {
+		t.Errorf("python with a PYTHON* variable = %v, want ignore_env left out", got)
+	}
+	if got := chooseWarmups(context.Background(), w, runtimes, bash, "echo", nil); got != nil {
+		t.Errorf("bash = %v, want none", got)
+	}
+	if got := chooseWarmups(context.Background(), nil, runtimes, py, "print(1)", nil); got != nil {
+		t.Errorf("without probes = %v, want none", got)
+	}
+
+	if err := runtimes.SetWarmup(map[string][]string{"python": {}}); err != nil {
+		t.Fatal(err)
+	}
+	before := len(docker.commands(""))
+	if got := chooseWarmups(context.Background(), w, runtimes, py, "print(1)", nil); got != nil {
+		t.Errorf("disabled = %v, want none", got)
+	}
+	if len(docker.commands("")) != before {
+		t.Error("probed a language with warmup turned off")
+	}
+}
+
+func TestBuildDockerArgs_Warmups(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	py, _ := d.runtimes.Get("python")
+	args := d.buildDockerArgs("exec-11", py,
+		"/tmp/code.py", "/workspace/code.py",
+		"/tmp/sandbox-exec-11", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "python", Code: "1", Args: []string{"arg"}, Warmups: []string{runtime.WarmupNoSite, runtime.WarmupIgnoreEnv}},
+	)
+	want := []string{py.Image(), "python3", "-u", "-B", "-S", "-E", "/workspace/code.py", "arg"}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+
+	node, _ := d.runtimes.Get("node")
+	args = d.buildDockerArgs("exec-12", node,
+		"/tmp/code.js", "/workspace/code.js",
+		"/tmp/sandbox-exec-12", "/tmp/seccomp.json",
+		ExecutionRequest{Language: "node", Code: "1", Warmups: []string{runtime.WarmupSnapshot, runtime.WarmupCompileCache}},
+	)
+	if !argsContainPair(args, "-e", "NODE_COMPILE_CACHE="+runtime.NodeCompileCache) {
+		t.Errorf("expected the compile cache env var in %v", args)
+	}
+	if !argsContainPair(args, "--snapshot-blob", runtime.NodeSnapshotBlob) {
+		t.Errorf("expected --snapshot-blob in %v", args)
+	}
+}
@@ -0,0 +1,454 @@
+package sandbox
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WorkdirGit is the git state of a claude work_dir, read before it was
+// mounted so that the changes claude makes can be told from the ones that
+// were already there. Untracked files count as changes unless the
+// repository's .gitignore files or .git/info/exclude match them; a user's
+// global excludes file isn't read.
+type WorkdirGit struct {
+	Repo      bool     `json:"repo"`
+	Head      string   `json:"head,omitempty"`      // The commit HEAD resolves to; empty before a branch's first commit
+	Branch    string   `json:"branch,omitempty"`    // Empty when HEAD is detached
+	Detached  bool     `json:"detached,omitempty"`  // HEAD names a commit, not a branch
+	Dirty     bool     `json:"dirty"`               // Changes staged, unstaged, conflicted or untracked, as git status lists them
+	Changed   []string `json:"changed,omitempty"`   // The first paths found changed, up to workdirGitChanges
+	Unchecked string   `json:"unchecked,omitempty"` // Why Dirty couldn't be determined; the policy then ignores the tree
+}
+
+// WorkdirDirtyError is returned when a claude work_dir is a repository with
+// uncommitted changes under security.claude_workdir_git require_clean.
+type WorkdirDirtyError struct {
+	Path string // resolved host path
+	Git  WorkdirGit
+}
+
+func (e *WorkdirDirtyError) Error() string {
+	return fmt.Sprintf("work_dir %s has uncommitted changes: %s", e.Path, strings.Join(e.Git.Changed, ", "))
+}
+
+func (e *WorkdirDirtyError) Unwrap() error { return ErrWorkdirDirty }
+
+const (
+	// workdirGitBudget bounds reading a work_dir's git state, as
+	// sandbox.workdir_size.max_walk does its size.
+	workdirGitBudget = 2 * time.Second
+	// workdirGitChanges is how many changed paths are kept; reading stops
+	// once it has found them.
+	workdirGitChanges = 10
+)
+
+var errGitBudget = fmt.Errorf("reading the repository took over %s", workdirGitBudget)
+
+// errStatusFull stops a gitStatus that has found workdirGitChanges paths.
+var errStatusFull = errors.New("enough changes found")
+
+// checkWorkdirGit reads the git state of a claude work_dir before it is
+// mounted, and applies security.claude_workdir_git when it has uncommitted
+// changes and is mounted read-write: a WorkdirDirtyError under
+// require_clean, or under warn a workdir_dirty event for the result and a
+// call to the request's DirtyWorkdir. A state that couldn't be read is
+// recorded with the reason and ignored.
+func (d *DockerRunner) checkWorkdirGit(req ExecutionRequest) (*WorkdirGit, []SecurityEvent, error) {
+	if req.Language != "claude" || req.WorkDir == "" {
+		return nil, nil, nil
+	}
+	git := readWorkdirGit(req.WorkDir, time.Now().Add(workdirGitBudget))
+	if !git.Dirty || req.ReadOnly {
+		return &git, nil, nil
+	}
+	switch d.workdirGit {
+	case "require_clean":
+		return nil, nil, &WorkdirDirtyError{Path: req.WorkDir, Git: git}
+	case "warn":
+		if req.DirtyWorkdir != nil {
+			req.DirtyWorkdir(git)
+		}
+		return &git, []SecurityEvent{{
+			Type:   "workdir_dirty",
+			Source: SourceRuntime,
+			Detail: (&WorkdirDirtyError{Path: req.WorkDir, Git: git}).Error(),
+		}}, nil
+	}
+	return &git, nil, nil
+}
+
+// readWorkdirGit reads the git state of the work tree at root, giving up on
+// Dirty once deadline passes.
+func readWorkdirGit(root string, deadline time.Time) WorkdirGit {
+	repo, err := openGitRepo(root)
+	if errors.Is(err, errNotRepo) {
+		return WorkdirGit{}
+	}
+	if err != nil {
+		return WorkdirGit{Repo: true, Unchecked: err.Error()}
+	}
+	defer repo.close()
+
+	state := WorkdirGit{Repo: true}
+	if state.Head, state.Branch, err = repo.head(); err != nil {
+		state.Unchecked = err.Error()
+		return state
+	}
+	state.Detached = state.Branch == ""
+	s := &gitStatus{repo: repo, deadline: deadline, seen: make(map[string]bool)}
+	err = s.run(state.Head)
+	state.Changed = s.changed
+	state.Dirty = len(s.changed) > 0
+	if err != nil && !errors.Is(err, errStatusFull) && !state.Dirty {
+		state.Unchecked = err.Error()
+	}
+	return state
+}
+
+// gitStatus finds what git status --porcelain lists: the index against
+// the work tree, HEAD against the index, and untracked files.
+type gitStatus struct {
+	repo     *gitRepo
+	deadline time.Time
+	changed  []string
+	seen     map[string]bool
+}
+
+// add records path as changed, returning errStatusFull once there are
+// enough.
+func (s *gitStatus) add(path string) error {
+	if !s.seen[path] {
+		s.seen[path] = true
+		s.changed = append(s.changed, path)
+	}
+	if len(s.changed) >= workdirGitChanges {
+		return errStatusFull
+	}
+	return nil
+}
+
+func (s *gitStatus) expired() bool {
+	return !time.Now().Before(s.deadline)
+}
+
+func (s *gitStatus) run(head string) error {
+	idx, err := s.repo.readIndex()
+	if err != nil {
+		return err
+	}
+	if err := s.unstaged(idx); err != nil {
+		return err
+	}
+	if err := s.staged(head, idx); err != nil {
+		return err
+	}
+	return s.untracked(idx)
+}
+
+// unstaged adds the files that differ between the index and the work
+// tree, and the conflicted and intent-to-add ones.
+func (s *gitStatus) unstaged(idx *gitIndex) error {
+	for _, e := range idx.entries {
+		if s.expired() {
+			return errGitBudget
+		}
+		changed := e.stage != 0 || e.added
+		if !changed && !e.ignore && e.mode != modeGitlink {
+			var err error
+			if changed, err = s.repo.modified(e, idx.mtime); err != nil {
+				return err
+			}
+		}
+		if changed {
+			if err := s.add(e.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// staged adds the files that differ between HEAD's tree and the index.
+// When the index's cache-tree is valid it is the tree the index would be
+// committed as, so a match with HEAD's says there are none without reading
+// HEAD's trees.
+func (s *gitStatus) staged(head string, idx *gitIndex) error {
+	if head == "" {
+		// Before the first commit everything in the index is staged.
+		for _, e := range idx.entries {
+			if err := s.add(e.path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tree, err := s.repo.commitTree(head)
+	if err != nil {
+		return err
+	}
+	if idx.tree == tree {
+		return nil
+	}
+	files := make(map[string]treeFile)
+	if err := s.repo.readTree(tree, "", files, s.deadline); err != nil {
+		return err
+	}
+	for _, e := range idx.entries {
+		if e.stage != 0 || e.added {
+			continue
+		}
+		if f, ok := files[e.path]; !ok || f.id != e.id || f.mode != e.mode {
+			if err := s.add(e.path); err != nil {
+				return err
+			}
+		}
+		delete(files, e.path)
+	}
+	// What's left was deleted from the index.
+	deleted := make([]string, 0, len(files))
+	for path := range files {
+		deleted = append(deleted, path)
+	}
+	slices.Sort(deleted)
+	for _, path := range deleted {
+		if err := s.add(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untracked adds the files in the work tree that the index doesn't have
+// and the repository's ignore rules don't match. A directory holding a
+// repository of its own is added as itself, as git lists it.
+func (s *gitStatus) untracked(idx *gitIndex) error {
+	tracked := make(map[string]bool, len(idx.entries))
+	for _, e := range idx.entries {
+		tracked[e.path] = true
+	}
+	ignore := (&gitIgnore{}).with(filepath.Join(s.repo.commonDir, "info", "exclude"), "")
+	return s.walk("", tracked, ignore)
+}
+
+func (s *gitStatus) walk(dir string, tracked map[string]bool, ignore *gitIgnore) error {
+	if s.expired() {
+		return errGitBudget
+	}
+	abs := filepath.Join(s.repo.root, filepath.FromSlash(dir))
+	if dir != "" {
+		if _, err := os.Lstat(filepath.Join(abs, ".git")); err == nil {
+			return s.add(dir + "/")
+		}
+	}
+	ignore = ignore.with(filepath.Join(abs, ".gitignore"), dir)
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return nil // as git does, past a warning
+	}
+	for _, ent := range entries {
+		rel := path.Join(dir, ent.Name())
+		if ent.Name() == ".git" || tracked[rel] {
+			continue
+		}
+		isDir := ent.IsDir()
+		if ignore.match(rel, isDir) {
+			continue
+		}
+		var err error
+		switch {
+		case isDir:
+			err = s.walk(rel, tracked, ignore)
+		case ent.Type().IsRegular() || ent.Type()&fs.ModeSymlink != 0:
+			err = s.add(rel)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modified reports whether e's file in the work tree differs from the
+// index: by its stat where that can tell, and otherwise by hashing it as a
+// blob. A file whose stat changed under a clean filter or end-of-line
+// conversion reads as modified.
+func (r *gitRepo) modified(e indexEntry, indexTime time.Time) (bool, error) {
+	file := filepath.Join(r.root, filepath.FromSlash(e.path))
+	info, err := os.Lstat(file)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if worktreeMode(info, e.mode, r.trackExec) != e.mode {
+		return true, nil
+	}
+	// An entry git hasn't stat'd, as read-tree leaves them, has size 0 and
+	// is compared by content, as git does.
+	if e.size != 0 && uint32(info.Size()) != e.size { // #nosec G115 -- the index truncates sizes the same way
+		return true, nil
+	}
+	// Modified in the same tick as the index was written, a file could
+	// have changed since without its stat showing it.
+	if info.ModTime().Equal(e.mtime) && info.ModTime().Before(indexTime) {
+		return false, nil
+	}
+	id, err := r.hashBlob(file, info)
+	if err != nil {
+		return false, err
+	}
+	return id != e.id, nil
+}
+
+// worktreeMode is the index mode of a file in the work tree. Without
+// core.filemode the executable bit is whatever the index says.
+func worktreeMode(info fs.FileInfo, indexMode uint32, trackExec bool) uint32 {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return 0o120000
+	case info.Mode().IsRegular():
+		if !trackExec && indexMode&0o170000 == 0o100000 {
+			return indexMode
+		}
+		if info.Mode()&0o100 != 0 {
+			return 0o100755
+		}
+		return 0o100644
+	case info.IsDir():
+		return modeTree
+	}
+	return 0
+}
+
+// hashBlob names file's content as a blob: a symlink's target, or a regular
+// file's bytes.
+func (r *gitRepo) hashBlob(file string, info fs.FileInfo) (string, error) {
+	var content io.Reader
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return "", err
+		}
+		content = strings.NewReader(target)
+	} else {
+		f, err := os.Open(file) // #nosec G304 -- a tracked file in the validated work_dir
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		content = f
+	}
+	h := r.newHash()
+	fmt.Fprintf(h, "blob %d\x00", info.Size())
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ignoreRule is a line of a .gitignore or exclude file.
+type ignoreRule struct {
+	base     string // the directory of the file it's from, relative to the work tree
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool // matched against the path from base, not only the name
+}
+
+// gitIgnore is the rules in force in a directory, the exclude file's first
+// and then each .gitignore's from the work tree's root down, so the last to
+// match a path decides.
+type gitIgnore struct {
+	rules []ignoreRule
+}
+
+// with returns g and the rules of file, whose directory is base.
+func (g *gitIgnore) with(file, base string) *gitIgnore {
+	data, err := os.ReadFile(file) // #nosec G304 -- in the validated work_dir
+	if err != nil {
+		return g
+	}
+	rules := slices.Clip(g.rules)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		r := ignoreRule{base: base}
+		if line[0] == '!' {
+			r.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		r.anchored = strings.Contains(line, "/")
+		if r.pattern = strings.TrimPrefix(line, "/"); r.pattern != "" {
+			rules = append(rules, r)
+		}
+	}
+	return &gitIgnore{rules: rules}
+}
+
+// match reports whether the path rel, relative to the work tree, is
+// ignored.
+func (g *gitIgnore) match(rel string, isDir bool) bool {
+	for _, r := range slices.Backward(g.rules) {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		var ok bool
+		if r.anchored {
+			sub := rel
+			if r.base != "" {
+				var found bool
+				if sub, found = strings.CutPrefix(rel, r.base+"/"); !found {
+					continue
+				}
+			}
+			ok = globPath(r.pattern, sub)
+		} else {
+			ok, _ = path.Match(r.pattern, path.Base(rel))
+		}
+		if ok {
+			return !r.negate
+		}
+	}
+	return false
+}
+
+// globPath matches a slash-separated pattern against a path, where a **
+// segment matches any number of segments. It takes time in proportion to
+// the segments of each, whatever the pattern.
+func globPath(pattern, name string) bool {
+	pat, segs := strings.Split(pattern, "/"), strings.Split(name, "/")
+	// match[j] is whether pat[i:] matches segs[j:], for i from the end.
+	match := make([]bool, len(segs)+1)
+	match[len(segs)] = true
+	for i := len(pat) - 1; i >= 0; i-- {
+		next := make([]bool, len(segs)+1)
+		for j := len(segs); j >= 0; j-- {
+			switch {
+			case pat[i] == "**":
+				next[j] = match[j] || j < len(segs) && next[j+1]
+			case j < len(segs):
+				ok, _ := path.Match(pat[i], segs[j])
+				next[j] = ok && match[j+1]
+			}
+		}
+		match = next
+	}
+	return match[0]
+}
@@ -0,0 +1,710 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1" // #nosec G505 -- git's object names, not a security boundary
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNotRepo is openGitRepo's error for a directory without a .git.
+var errNotRepo = errors.New("not a git repository")
+
+const (
+	// maxGitObject bounds a commit or tree read whole into memory.
+	maxGitObject = 64 << 20
+	// maxDeltaDepth bounds a chain of deltas, as git's own pack-objects
+	// does, so a crafted pack can't recurse without end.
+	maxDeltaDepth = 4095
+
+	modeGitlink = 0o160000 // a submodule's commit
+	modeTree    = 0o040000
+)
+
+// gitRepo reads a repository's refs, index and objects itself. Running the
+// git binary would read the repository's config, which whatever last wrote
+// to the work_dir could have made run commands on the host.
+type gitRepo struct {
+	root      string // the work tree
+	gitDir    string // .git, or where a worktree's .git file points
+	commonDir string // the refs and objects a linked worktree shares with its repository
+	hashSize  int
+	newHash   func() hash.Hash
+	trackExec bool // core.filemode
+
+	packs []*gitPack // opened the first time an object isn't loose
+}
+
+// openGitRepo opens the repository whose work tree is root, or returns
+// errNotRepo. Only root itself is looked at: a work_dir inside some other
+// repository's work tree isn't one.
+func openGitRepo(root string) (*gitRepo, error) {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Lstat(dotGit)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotRepo
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &gitRepo{root: root, hashSize: sha1.Size, newHash: sha1.New, trackExec: true}
+	switch {
+	case info.IsDir():
+		r.gitDir = dotGit
+	case info.Mode().IsRegular():
+		// A linked worktree or a submodule: "gitdir: <path>".
+		data, err := os.ReadFile(dotGit) // #nosec G304 -- inside the validated work_dir
+		if err != nil {
+			return nil, err
+		}
+		dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return nil, errors.New(".git is a file that doesn't name a gitdir")
+		}
+		r.gitDir = resolveFrom(root, dir)
+	default:
+		return nil, errors.New(".git is neither a directory nor a file")
+	}
+	r.commonDir = r.gitDir
+	if data, err := os.ReadFile(filepath.Join(r.gitDir, "commondir")); err == nil {
+		r.commonDir = resolveFrom(r.gitDir, strings.TrimSpace(string(data)))
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.commonDir, "config"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	cfg := parseGitConfig(data)
+	switch format := cfg["extensions.objectformat"]; format {
+	case "", "sha1":
+	case "sha256":
+		r.hashSize, r.newHash = sha256.Size, sha256.New
+	default:
+		return nil, fmt.Errorf("object format %s isn't supported", format)
+	}
+	if refs := cfg["extensions.refstorage"]; refs != "" && refs != "files" {
+		return nil, fmt.Errorf("%s ref storage isn't supported", refs)
+	}
+	switch cfg["core.filemode"] {
+	case "false", "no", "off", "0":
+		r.trackExec = false
+	}
+	return r, nil
+}
+
+func resolveFrom(base, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
+
+// parseGitConfig reads the section.key = value lines of a git config file,
+// lowercased, the last of a key winning. Includes aren't followed: only
+// core and extensions keys are read from it.
+func parseGitConfig(data []byte) map[string]string {
+	cfg := make(map[string]string)
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			section, _, _ = strings.Cut(strings.ToLower(line[1:]), "]")
+		default:
+			key, value, _ := strings.Cut(line, "=")
+			cfg[section+"."+strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cfg
+}
+
+func (r *gitRepo) close() {
+	for _, p := range r.packs {
+		_ = p.idx.Close()
+		_ = p.pack.Close()
+	}
+}
+
+// validID reports whether id is an object name in the repository's format.
+func (r *gitRepo) validID(id string) bool {
+	if len(id) != 2*r.hashSize {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// head returns the commit HEAD resolves to and the branch it names. branch
+// is empty when HEAD is detached, and commit before a branch's first
+// commit.
+func (r *gitRepo) head() (commit, branch string, err error) {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", "", fmt.Errorf("reading HEAD: %w", err)
+	}
+	content := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(content, "ref: ")
+	if !ok {
+		if !r.validID(content) {
+			return "", "", errors.New("HEAD is neither a ref nor a commit")
+		}
+		return content, "", nil
+	}
+	commit, err = r.resolveRef(ref)
+	return commit, strings.TrimPrefix(ref, "refs/heads/"), err
+}
+
+// resolveRef follows ref to a commit, loose or packed; "" if it doesn't
+// exist yet.
+func (r *gitRepo) resolveRef(ref string) (string, error) {
+	for range 5 {
+		if !strings.HasPrefix(ref, "refs/") || strings.Contains(ref, "..") {
+			return "", fmt.Errorf("HEAD names %q, which isn't a ref", ref)
+		}
+		data, err := os.ReadFile(filepath.Join(r.commonDir, filepath.FromSlash(ref)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return r.packedRef(ref)
+		}
+		if err != nil {
+			return "", err
+		}
+		content := strings.TrimSpace(string(data))
+		if next, ok := strings.CutPrefix(content, "ref: "); ok {
+			ref = next
+			continue
+		}
+		if !r.validID(content) {
+			return "", fmt.Errorf("%s doesn't hold a commit", ref)
+		}
+		return content, nil
+	}
+	return "", fmt.Errorf("%s is a chain of symbolic refs", ref)
+}
+
+func (r *gitRepo) packedRef(ref string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if id, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref && r.validID(id) {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// indexEntry is a file in the index.
+type indexEntry struct {
+	path   string
+	mode   uint32
+	size   uint32 // truncated to 32 bits, as the index keeps it
+	mtime  time.Time
+	id     string
+	stage  int  // 0, or 1 to 3 for the sides of a conflict
+	ignore bool // assume-unchanged or skip-worktree: git doesn't look at the file
+	added  bool // intent-to-add (git add -N): in the index, not yet staged
+}
+
+// gitIndex is what readIndex reads from .git/index.
+type gitIndex struct {
+	entries []indexEntry
+	// tree is the root of the cache-tree extension, the tree the index
+	// would be committed as, when it is still valid; "" otherwise.
+	tree string
+	// mtime is the index's own. An entry whose file was modified no
+	// earlier can't be trusted on its stat alone.
+	mtime time.Time
+}
+
+var errIndexTruncated = errors.New("the index is truncated")
+
+// readIndex reads index versions 2 to 4. A repository without one has an
+// empty index.
+func (r *gitRepo) readIndex() (*gitIndex, error) {
+	path := filepath.Join(r.gitDir, "index")
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &gitIndex{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- inside the validated work_dir's repository
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, errors.New("the index has no index header")
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("index version %d isn't supported", version)
+	}
+	n := binary.BigEndian.Uint32(data[8:])
+	idx := &gitIndex{mtime: info.ModTime()}
+	hs := r.hashSize
+	pos, prev := 12, ""
+	for range n {
+		start, fixed := pos, 40+hs+2
+		if pos+fixed > len(data) {
+			return nil, errIndexTruncated
+		}
+		u32 := func(at int) uint32 { return binary.BigEndian.Uint32(data[pos+at:]) }
+		e := indexEntry{
+			mtime: time.Unix(int64(u32(8)), int64(u32(12))),
+			mode:  u32(24),
+			size:  u32(36),
+			id:    hex.EncodeToString(data[pos+40 : pos+40+hs]),
+		}
+		flags := binary.BigEndian.Uint16(data[pos+40+hs:])
+		pos += fixed
+		e.stage = int(flags>>12) & 3
+		e.ignore = flags&0x8000 != 0
+		if flags&0x4000 != 0 && version >= 3 {
+			if pos+2 > len(data) {
+				return nil, errIndexTruncated
+			}
+			extended := binary.BigEndian.Uint16(data[pos:])
+			pos += 2
+			e.ignore = e.ignore || extended&0x4000 != 0
+			e.added = extended&0x2000 != 0
+		}
+		if version == 4 {
+			// The name is what to keep of the previous one, and the rest.
+			strip, k := offsetVarint(data[pos:])
+			if k == 0 || strip > int64(len(prev)) {
+				return nil, errIndexTruncated
+			}
+			pos += k
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, errIndexTruncated
+			}
+			e.path = prev[:len(prev)-int(strip)] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, errIndexTruncated
+			}
+			e.path = string(data[pos : pos+end])
+			// NUL-padded to a multiple of 8 bytes, with at least one.
+			pos = start + (pos-start+end+8)&^7
+		}
+		prev = e.path
+		idx.entries = append(idx.entries, e)
+	}
+	for end := len(data) - hs; pos+8 <= end; {
+		sig, size := string(data[pos:pos+4]), int(binary.BigEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size > end-pos {
+			return nil, errIndexTruncated
+		}
+		body := data[pos : pos+size]
+		pos += size
+		switch {
+		case sig == "TREE":
+			idx.tree = cacheTreeRoot(body, hs)
+		case sig[0] >= 'A' && sig[0] <= 'Z':
+			// Optional, and nothing here needs it.
+		default:
+			// link (a split index) or sdir (a sparse one).
+			return nil, fmt.Errorf("index extension %q isn't supported", sig)
+		}
+	}
+	return idx, nil
+}
+
+// cacheTreeRoot returns the root tree of a cache-tree extension, or "" if
+// it has been invalidated since it was written.
+func cacheTreeRoot(body []byte, hs int) string {
+	// The root's entry comes first: its empty path, then "count subtrees\n".
+	if len(body) == 0 || body[0] != 0 {
+		return ""
+	}
+	body = body[1:]
+	nl := bytes.IndexByte(body, '\n')
+	if nl < 0 {
+		return ""
+	}
+	count, _, _ := strings.Cut(string(body[:nl]), " ")
+	if n, err := strconv.Atoi(count); err != nil || n < 0 || len(body) < nl+1+hs {
+		return ""
+	}
+	return hex.EncodeToString(body[nl+1 : nl+1+hs])
+}
+
+// offsetVarint decodes the varint of index v4 names and pack offset
+// deltas, returning it and the bytes it took; 0 bytes if b ends first.
+func offsetVarint(b []byte) (int64, int) {
+	var v int64
+	for i, c := range b {
+		if i > 9 {
+			break
+		}
+		if i > 0 {
+			v++
+		}
+		v = v<<7 | int64(c&0x7f)
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sizeVarint decodes the little-endian varint sizes at the start of a
+// delta.
+func sizeVarint(b []byte) (int, int) {
+	var v int
+	for i, c := range b {
+		if i > 8 {
+			break
+		}
+		v |= int(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// commitTree returns the tree a commit records.
+func (r *gitRepo) commitTree(commit string) (string, error) {
+	typ, data, err := r.readObject(commit, 0)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	tree, ok := strings.CutPrefix(string(line), "tree ")
+	if typ != "commit" || !ok || !r.validID(tree) {
+		return "", fmt.Errorf("HEAD %s isn't a commit", commit)
+	}
+	return tree, nil
+}
+
+// treeFile is a file in a tree.
+type treeFile struct {
+	mode uint32
+	id   string
+}
+
+// readTree adds the files under tree to files by path, with prefix, and
+// gives up once deadline passes.
+func (r *gitRepo) readTree(tree, prefix string, files map[string]treeFile, deadline time.Time) error {
+	if !time.Now().Before(deadline) {
+		return errGitBudget
+	}
+	typ, data, err := r.readObject(tree, 0)
+	if err != nil {
+		return err
+	}
+	if typ != "tree" {
+		return fmt.Errorf("object %s isn't a tree", tree[:12])
+	}
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+1+r.hashSize {
+			return fmt.Errorf("tree %s is corrupt", tree[:12])
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return fmt.Errorf("tree %s is corrupt", tree[:12])
+		}
+		path := prefix + string(data[sp+1:nul])
+		id := hex.EncodeToString(data[nul+1 : nul+1+r.hashSize])
+		data = data[nul+1+r.hashSize:]
+		if mode == modeTree {
+			if err := r.readTree(id, path+"/", files, deadline); err != nil {
+				return err
+			}
+			continue
+		}
+		files[path] = treeFile{mode: uint32(mode), id: id}
+	}
+	return nil
+}
+
+// readObject returns an object's type and content, loose or packed. depth
+// counts the deltas followed to get here.
+func (r *gitRepo) readObject(id string, depth int) (string, []byte, error) {
+	if !r.validID(id) {
+		return "", nil, fmt.Errorf("%q isn't an object name", id)
+	}
+	f, err := os.Open(filepath.Join(r.commonDir, "objects", id[:2], id[2:]))
+	if err == nil {
+		defer f.Close()
+		return readLooseObject(f)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, err
+	}
+	if r.packs == nil {
+		if err := r.openPacks(); err != nil {
+			return "", nil, err
+		}
+	}
+	raw, _ := hex.DecodeString(id)
+	for _, p := range r.packs {
+		off, ok, err := p.find(raw)
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			return p.read(r, off, depth)
+		}
+	}
+	// Alternates aren't followed.
+	return "", nil, fmt.Errorf("object %s not found", id[:12])
+}
+
+func readLooseObject(f *os.File) (string, []byte, error) {
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", filepath.Base(f.Name()), err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, maxGitObject))
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", filepath.Base(f.Name()), err)
+	}
+	header, content, ok := bytes.Cut(data, []byte{0})
+	typ, _, _ := strings.Cut(string(header), " ")
+	if !ok {
+		return "", nil, fmt.Errorf("object %s has no header", filepath.Base(f.Name()))
+	}
+	return typ, content, nil
+}
+
+// gitPack is a pack file and its version 2 index.
+type gitPack struct {
+	idx, pack *os.File
+	size      int64 // of the pack
+	hashSize  int64
+	fanout    [256]uint32
+}
+
+const packIdxHeader = 8 + 256*4
+
+func (r *gitRepo) openPacks() error {
+	r.packs = []*gitPack{}
+	idxs, _ := filepath.Glob(filepath.Join(r.commonDir, "objects", "pack", "*.idx"))
+	for _, name := range idxs {
+		p := &gitPack{hashSize: int64(r.hashSize)}
+		var err error
+		if p.idx, err = os.Open(name); err != nil { // #nosec G304 -- from the repository's pack directory
+			return err
+		}
+		var header [packIdxHeader]byte
+		if _, err := p.idx.ReadAt(header[:], 0); err != nil || string(header[:4]) != "\377tOc" || binary.BigEndian.Uint32(header[4:]) != 2 {
+			_ = p.idx.Close()
+			return fmt.Errorf("pack index %s isn't version 2", filepath.Base(name))
+		}
+		for i := range p.fanout {
+			p.fanout[i] = binary.BigEndian.Uint32(header[8+4*i:])
+		}
+		if p.pack, err = os.Open(strings.TrimSuffix(name, ".idx") + ".pack"); err != nil {
+			_ = p.idx.Close()
+			return err
+		}
+		if info, err := p.pack.Stat(); err == nil {
+			p.size = info.Size()
+		}
+		r.packs = append(r.packs, p)
+	}
+	return nil
+}
+
+// find returns where in the pack the object named id starts.
+func (p *gitPack) find(id []byte) (int64, bool, error) {
+	n := int64(p.fanout[255])
+	lo := int64(0)
+	if id[0] > 0 {
+		lo = int64(p.fanout[id[0]-1])
+	}
+	hi := int64(p.fanout[id[0]])
+	name := make([]byte, p.hashSize)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err := p.idx.ReadAt(name, packIdxHeader+mid*p.hashSize); err != nil {
+			return 0, false, err
+		}
+		switch c := bytes.Compare(name, id); {
+		case c < 0:
+			lo = mid + 1
+		case c > 0:
+			hi = mid
+		default:
+			// Names, then a CRC each, then an offset each; offsets past
+			// 2GB are in a table of 8-byte ones after those.
+			var b [8]byte
+			offsets := packIdxHeader + n*p.hashSize + n*4
+			if _, err := p.idx.ReadAt(b[:4], offsets+mid*4); err != nil {
+				return 0, false, err
+			}
+			off := binary.BigEndian.Uint32(b[:4])
+			if off&0x80000000 == 0 {
+				return int64(off), true, nil
+			}
+			if _, err := p.idx.ReadAt(b[:], offsets+n*4+int64(off&0x7fffffff)*8); err != nil {
+				return 0, false, err
+			}
+			return int64(binary.BigEndian.Uint64(b[:])), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+var packTypes = [...]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+// read returns the object at off, applying its deltas.
+func (p *gitPack) read(r *gitRepo, off int64, depth int) (string, []byte, error) {
+	if depth > maxDeltaDepth {
+		return "", nil, errors.New("a pack's delta chain is too long")
+	}
+	corrupt := fmt.Errorf("pack %s is corrupt at %d", filepath.Base(p.pack.Name()), off)
+	var header [10 + 32 + 10]byte
+	n, err := p.pack.ReadAt(header[:], off)
+	if n == 0 {
+		return "", nil, fmt.Errorf("reading %s: %w", p.pack.Name(), err)
+	}
+	c := header[0]
+	typ, size, shift, i := int(c>>4)&7, int64(c&15), 4, 1
+	for c&0x80 != 0 {
+		if i >= n || shift > 56 {
+			return "", nil, corrupt
+		}
+		c = header[i]
+		i++
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	var baseOff int64
+	var baseID string
+	switch typ {
+	case 1, 2, 3, 4:
+	case 6: // a delta against the object back at a relative offset
+		rel, k := offsetVarint(header[i:n])
+		if k == 0 || rel <= 0 || rel > off {
+			return "", nil, corrupt
+		}
+		i += k
+		baseOff = off - rel
+	case 7: // a delta against an object by name
+		if i+int(p.hashSize) > n {
+			return "", nil, corrupt
+		}
+		baseID = hex.EncodeToString(header[i : i+int(p.hashSize)])
+		i += int(p.hashSize)
+	default:
+		return "", nil, corrupt
+	}
+	if size > maxGitObject {
+		return "", nil, fmt.Errorf("an object in %s is over %d bytes", filepath.Base(p.pack.Name()), maxGitObject)
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(p.pack, off+int64(i), p.size-off-int64(i))))
+	if err != nil {
+		return "", nil, corrupt
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return "", nil, corrupt
+	}
+	if typ <= 4 {
+		return packTypes[typ], data, nil
+	}
+	var baseType string
+	var base []byte
+	if baseID != "" {
+		baseType, base, err = r.readObject(baseID, depth+1)
+	} else {
+		baseType, base, err = p.read(r, baseOff, depth+1)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := applyDelta(base, data)
+	if err != nil {
+		return "", nil, corrupt
+	}
+	return baseType, out, nil
+}
+
+var errBadDelta = errors.New("bad delta")
+
+// applyDelta rebuilds an object from its base and a delta's copy and
+// insert instructions.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, k := sizeVarint(delta)
+	if k == 0 || srcSize != len(base) {
+		return nil, errBadDelta
+	}
+	delta = delta[k:]
+	dstSize, k := sizeVarint(delta)
+	if k == 0 || dstSize > maxGitObject {
+		return nil, errBadDelta
+	}
+	delta = delta[k:]
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		c := delta[0]
+		delta = delta[1:]
+		switch {
+		case c&0x80 != 0:
+			// Copy: the bits below say which offset and size bytes follow.
+			var off, size int
+			for i := range 7 {
+				if c&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errBadDelta
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size > len(base) {
+				return nil, errBadDelta
+			}
+			out = append(out, base[off:off+size]...)
+		case c != 0:
+			// Insert the next c bytes.
+			if int(c) > len(delta) {
+				return nil, errBadDelta
+			}
+			out = append(out, delta[:c]...)
+			delta = delta[c:]
+		default:
+			return nil, errBadDelta
+		}
+	}
+	if len(out) != dstSize {
+		return nil, errBadDelta
+	}
+	return out, nil
+}
@@ -0,0 +1,328 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitFixture makes a repository in a new directory with the git CLI, which
+// the reader under test never runs. It skips the test without one.
+func gitFixture(t *testing.T, args ...string) (root string, git func(args ...string) string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root = t.TempDir()
+	git = func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		cmd.Env = append(os.Environ(),
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(append([]string{"init", "-q", "-b", "main"}, args...)...)
+	return root, git
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// committedFixture is a repository with one commit of a few files, and
+// ignore rules for build output.
+func committedFixture(t *testing.T, args ...string) (string, func(args ...string) string) {
+	t.Helper()
+	root, git := gitFixture(t, args...)
+	writeFiles(t, root, map[string]string{
+		"main.go":         "package main\n",
+		"lib/util.go":     "package lib\n",
+		"lib/deep/x.txt":  "x\n",
+		".gitignore":      "/build/\n*.log\n!keep.log\n",
+		"lib/.gitignore":  "gen/\n",
+		"docs/readme.txt": "docs\n",
+	})
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	return root, git
+}
+
+func readGit(t *testing.T, root string) WorkdirGit {
+	t.Helper()
+	return readWorkdirGit(root, time.Now().Add(workdirGitBudget))
+}
+
+func TestReadWorkdirGit(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, root string, git func(...string) string)
+		changed []string
+	}{
+		{"clean", func(*testing.T, string, func(...string) string) {}, nil},
+		{"ignored files only", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"build/out.bin": "b", "run.log": "l", "lib/gen/a.go": "g"})
+		}, nil},
+		{"negated ignore", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"keep.log": "k"})
+		}, []string{"keep.log"}},
+		{"unstaged edit", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"lib/util.go": "package lib // edited\n"})
+		}, []string{"lib/util.go"}},
+		{"same size edit", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"main.go": "package mian\n"})
+		}, []string{"main.go"}},
+		{"staged edit", func(t *testing.T, root string, git func(...string) string) {
+			writeFiles(t, root, map[string]string{"docs/readme.txt": "more docs\n"})
+			git("add", "docs/readme.txt")
+		}, []string{"docs/readme.txt"}},
+		{"deleted", func(t *testing.T, root string, _ func(...string) string) {
+			if err := os.Remove(filepath.Join(root, "lib/deep/x.txt")); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"lib/deep/x.txt"}},
+		{"removed from the index", func(t *testing.T, _ string, git func(...string) string) {
+			git("rm", "-q", "--cached", "main.go")
+		}, []string{"main.go"}},
+		{"untracked", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"notes/todo.md": "t"})
+		}, []string{"notes/todo.md"}},
+		{"executable bit", func(t *testing.T, root string, _ func(...string) string) {
+			if err := os.Chmod(filepath.Join(root, "main.go"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"main.go"}},
+		{"nested repository", func(t *testing.T, root string, _ func(...string) string) {
+			writeFiles(t, root, map[string]string{"vendor/dep/.git/HEAD": "ref: refs/heads/main\n", "vendor/dep/a.go": "a"})
+		}, []string{"vendor/dep/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, git := committedFixture(t)
+			head := git("rev-parse", "HEAD")
+			tt.change(t, root, git)
+			got := readGit(t, root)
+			want := WorkdirGit{Repo: true, Head: head, Branch: "main", Dirty: tt.changed != nil, Changed: tt.changed}
+			if !equalWorkdirGit(got, want) {
+				t.Errorf("read %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func equalWorkdirGit(a, b WorkdirGit) bool {
+	return a.Repo == b.Repo && a.Head == b.Head && a.Branch == b.Branch && a.Detached == b.Detached &&
+		a.Dirty == b.Dirty && slices.Equal(a.Changed, b.Changed) && a.Unchecked == b.Unchecked
+}
+
+func TestReadWorkdirGit_Repositories(t *testing.T) {
+	if got := readGit(t, t.TempDir()); !equalWorkdirGit(got, WorkdirGit{}) {
+		t.Errorf("not a repository: read %+v", got)
+	}
+
+	t.Run("no commits", func(t *testing.T) {
+		root, git := gitFixture(t)
+		writeFiles(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+		git("add", "a.txt")
+		want := WorkdirGit{Repo: true, Branch: "main", Dirty: true, Changed: []string{"a.txt", "b.txt"}}
+		if got := readGit(t, root); !equalWorkdirGit(got, want) {
+			t.Errorf("read %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		root, git := committedFixture(t)
+		git("checkout", "-q", "--detach")
+		want := WorkdirGit{Repo: true, Head: git("rev-parse", "HEAD"), Detached: true}
+		if got := readGit(t, root); !equalWorkdirGit(got, want) {
+			t.Errorf("read %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("packed", func(t *testing.T) {
+		root, git := committedFixture(t)
+		// A second commit of a near copy, so the pack holds deltas.
+		writeFiles(t, root, map[string]string{"lib/util.go": "package lib\n" + strings.Repeat("// filler line\n", 200)})
+		git("commit", "-qam", "grow")
+		writeFiles(t, root, map[string]string{"lib/util.go": "package lib\n" + strings.Repeat("// filler line\n", 201)})
+		git("commit", "-qam", "grow again")
+		git("gc", "-q", "--aggressive", "--prune=now")
+		if err := os.Remove(filepath.Join(root, ".git", "index")); err != nil {
+			t.Fatal(err)
+		}
+		// An index without a cache-tree, so HEAD's trees are read from the pack.
+		git("read-tree", "HEAD")
+		want := WorkdirGit{Repo: true, Head: git("rev-parse", "HEAD"), Branch: "main"}
+		if got := readGit(t, root); !equalWorkdirGit(got, want) {
+			t.Errorf("read %+v, want %+v", got, want)
+		}
+		writeFiles(t, root, map[string]string{"main.go": "package main // edited\n"})
+		git("add", "main.go")
+		want = WorkdirGit{Repo: true, Head: want.Head, Branch: "main", Dirty: true, Changed: []string{"main.go"}}
+		if got := readGit(t, root); !equalWorkdirGit(got, want) {
+			t.Errorf("staged after gc: read %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("linked worktree", func(t *testing.T) {
+		root, git := committedFixture(t)
+		linked := filepath.Join(t.TempDir(), "linked")
+		git("worktree", "add", "-q", "-b", "feature", linked)
+		want := WorkdirGit{Repo: true, Head: git("rev-parse", "HEAD"), Branch: "feature"}
+		if got := readGit(t, linked); !equalWorkdirGit(got, want) {
+			t.Errorf("read %+v, want %+v", got, want)
+		}
+		writeFiles(t, linked, map[string]string{"new.txt": "n"})
+		if got := readGit(t, linked); !got.Dirty || !slices.Equal(got.Changed, []string{"new.txt"}) {
+			t.Errorf("untracked in the worktree: read %+v", got)
+		}
+		if got := readGit(t, root); got.Dirty {
+			t.Errorf("main work tree read dirty from the linked one's file: %+v", got)
+		}
+	})
+
+	t.Run("sha256", func(t *testing.T) {
+		root, git := gitFixture(t, "--object-format=sha256")
+		writeFiles(t, root, map[string]string{"a.txt": "a"})
+		git("add", "a.txt")
+		git("commit", "-qm", "initial")
+		want := WorkdirGit{Repo: true, Head: git("rev-parse", "HEAD"), Branch: "main"}
+		if got := readGit(t, root); !equalWorkdirGit(got, want) {
+			t.Errorf("read %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("many changes", func(t *testing.T) {
+		root, _ := committedFixture(t)
+		files := make(map[string]string)
+		for i := range 3 * workdirGitChanges {
+			files[filepath.Join("new", string(rune('a'+i)))] = "x"
+		}
+		writeFiles(t, root, files)
+		if got := readGit(t, root); !got.Dirty || len(got.Changed) != workdirGitChanges || got.Unchecked != "" {
+			t.Errorf("read %+v, want the first %d changes", got, workdirGitChanges)
+		}
+	})
+
+	t.Run("out of time", func(t *testing.T) {
+		root, _ := committedFixture(t)
+		if got := readWorkdirGit(root, time.Now()); got.Dirty || got.Unchecked != errGitBudget.Error() {
+			t.Errorf("read %+v, want unchecked for the budget", got)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		root, git := committedFixture(t)
+		git("config", "extensions.refstorage", "reftable")
+		writeFiles(t, root, map[string]string{"new.txt": "n"})
+		if got := readGit(t, root); !got.Repo || got.Dirty || got.Unchecked == "" {
+			t.Errorf("reftable: read %+v, want a repository left unchecked", got)
+		}
+	})
+}
+
+func TestReadWorkdirGit_RunsNoGit(t *testing.T) {
+	root, git := committedFixture(t)
+	head := git("rev-parse", "HEAD")
+	// A repository's config can run commands; reading it must not.
+	marker := filepath.Join(t.TempDir(), "ran")
+	git("config", "core.fsmonitor", "touch "+marker)
+	writeFiles(t, root, map[string]string{"main.go": "package main // edited\n"})
+	t.Setenv("PATH", "")
+
+	want := WorkdirGit{Repo: true, Head: head, Branch: "main", Dirty: true, Changed: []string{"main.go"}}
+	if got := readGit(t, root); !equalWorkdirGit(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("core.fsmonitor ran")
+	}
+}
+
+func TestCheckWorkdirGit(t *testing.T) {
+	root, _ := committedFixture(t)
+	writeFiles(t, root, map[string]string{"main.go": "package main // edited\n"})
+
+	tests := []struct {
+		policy   string
+		readOnly bool
+		wantErr  bool
+		warned   bool
+	}{
+		{"ignore", false, false, false},
+		{"warn", false, false, true},
+		{"warn", true, false, false},
+		{"require_clean", false, true, false},
+		{"require_clean", true, false, false},
+	}
+	for _, tt := range tests {
+		d := &DockerRunner{workdirGit: tt.policy}
+		var warned *WorkdirGit
+		req := ExecutionRequest{Language: "claude", WorkDir: root, ReadOnly: tt.readOnly, DirtyWorkdir: func(git WorkdirGit) { warned = &git }}
+		git, events, err := d.checkWorkdirGit(req)
+		if gotErr := errors.Is(err, ErrWorkdirDirty); gotErr != tt.wantErr {
+			t.Errorf("%s read-only %v: err = %v", tt.policy, tt.readOnly, err)
+		}
+		if tt.wantErr {
+			var dirty *WorkdirDirtyError
+			if !errors.As(err, &dirty) || !slices.Equal(dirty.Git.Changed, []string{"main.go"}) {
+				t.Errorf("%s: err = %#v, want the changed paths", tt.policy, err)
+			}
+			continue
+		}
+		if git == nil || !git.Dirty {
+			t.Errorf("%s read-only %v: git = %+v, want it recorded dirty", tt.policy, tt.readOnly, git)
+		}
+		if (warned != nil) != tt.warned || (len(events) == 1 && events[0].Type == "workdir_dirty") != tt.warned {
+			t.Errorf("%s read-only %v: warned %v with events %+v, want warned %v", tt.policy, tt.readOnly, warned != nil, events, tt.warned)
+		}
+	}
+
+	d := &DockerRunner{workdirGit: "require_clean"}
+	if git, _, err := d.checkWorkdirGit(ExecutionRequest{Language: "python", WorkDir: root}); git != nil || err != nil {
+		t.Errorf("python: %+v, %v; want no check", git, err)
+	}
+}
+
+func TestGlobPath(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"build", "build", true},
+		{"a/*.go", "a/x.go", true},
+		{"a/*.go", "a/b/x.go", false},
+		{"**/gen", "gen", true},
+		{"**/gen", "a/b/gen", true},
+		{"a/**/z", "a/z", true},
+		{"a/**/z", "a/b/c/z", true},
+		{"a/**/z", "b/z", false},
+		{"a/**", "a/b/c", true},
+		{strings.Repeat("**/", 30) + "x", strings.Repeat("a/", 30) + "y", false},
+	}
+	for _, tt := range tests {
+		if got := globPath(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("globPath(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
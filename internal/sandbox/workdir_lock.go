@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WorkdirObserver receives work_dir lock and size metrics from the Docker
+// backend.
+type WorkdirObserver interface {
+	ObserveWorkdirLockWait(wait time.Duration)
+	WorkdirLockConflict()
+	ObserveWorkdirSize(bytes int64)
+}
+
+// WorkdirBusyError is returned when another execution holds a work_dir
+// mounted read-write for longer than a request is willing to wait.
+type WorkdirBusyError struct {
+	Path   string // resolved host path
+	Holder string // execution ID of the current holder
+}
+
+func (e *WorkdirBusyError) Error() string {
+	return fmt.Sprintf("work_dir %s is mounted read-write by execution %s", e.Path, e.Holder)
+}
+
+func (e *WorkdirBusyError) Unwrap() error { return ErrWorkdirBusy }
+
+type workdirHold struct {
+	execID   string
+	released chan struct{}
+}
+
+// workdirLocks gives one execution at a time a work_dir mounted read-write,
+// so concurrent claude sessions can't race each other's edits. Paths are the
+// resolved real paths from validateRequest, so symlinks to a held directory
+// conflict with it. A zero wait fails fast.
+type workdirLocks struct {
+	wait     time.Duration
+	observer WorkdirObserver
+
+	mu   sync.Mutex
+	held map[string]*workdirHold
+}
+
+func newWorkdirLocks(wait time.Duration) *workdirLocks {
+	return &workdirLocks{wait: wait, held: make(map[string]*workdirHold)}
+}
+
+// acquire locks path for execID, waiting up to l.wait for the current
+// holder. The returned release must be called once the container is gone.
+func (l *workdirLocks) acquire(ctx context.Context, path, execID string) (func(), error) {
+	start := time.Now()
+	var deadline <-chan time.Time
+	contended := false
+	for {
+		l.mu.Lock()
+		hold, busy := l.held[path]
+		if !busy {
+			mine := &workdirHold{execID: execID, released: make(chan struct{})}
+			l.held[path] = mine
+			l.mu.Unlock()
+			if contended {
+				l.observeWait(time.Since(start))
+			}
+			return func() { l.release(path, mine) }, nil
+		}
+		l.mu.Unlock()
+
+		if !contended {
+			contended = true
+			if l.wait <= 0 {
+				return nil, l.conflict(path, hold, start)
+			}
+			timer := time.NewTimer(l.wait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-hold.released:
+		case <-deadline:
+			return nil, l.conflict(path, hold, start)
+		case <-ctx.Done():
+			l.observeWait(time.Since(start))
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *workdirLocks) release(path string, hold *workdirHold) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[path] == hold {
+		delete(l.held, path)
+		close(hold.released)
+	}
+}
+
+func (l *workdirLocks) conflict(path string, hold *workdirHold, start time.Time) error {
+	if l.observer != nil {
+		if l.wait > 0 {
+			l.observer.ObserveWorkdirLockWait(time.Since(start))
+		}
+		l.observer.WorkdirLockConflict()
+	}
+	return &WorkdirBusyError{Path: path, Holder: hold.execID}
+}
+
+func (l *workdirLocks) observeWait(wait time.Duration) {
+	if l.observer != nil {
+		l.observer.ObserveWorkdirLockWait(wait)
+	}
+}
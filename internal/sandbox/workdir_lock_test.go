@@ -0,0 +1,215 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type workdirRecorder struct {
+	mu        sync.Mutex
+	waits     []time.Duration
+	conflicts int
+	sizes     []int64
+}
+
+func (o *workdirRecorder) ObserveWorkdirLockWait(wait time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.waits = append(o.waits, wait)
+}
+
+func (o *workdirRecorder) WorkdirLockConflict() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.conflicts++
+}
+
+func (o *workdirRecorder) ObserveWorkdirSize(bytes int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sizes = append(o.sizes, bytes)
+}
+
+// workdirRunner returns a runner whose work_dir root holds project and a
+// symlink alias to it.
+func workdirRunner(t *testing.T, wait time.Duration) (d *DockerRunner, project, alias string, obs *workdirRecorder) {
+	t.Helper()
+	root := t.TempDir()
+	project = filepath.Join(root, "project")
+	if err := os.Mkdir(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	alias = filepath.Join(root, "alias")
+	if err := os.Symlink(project, alias); err != nil {
+		t.Fatal(err)
+	}
+	d = newTestRunner(0, "", []string{root})
+	d.workdirs = newWorkdirLocks(wait)
+	obs = &workdirRecorder{}
+	d.workdirs.observer = obs
+	return d, project, alias, obs
+}
+
+// startExecution validates req and takes its work_dir lock the way
+// executeInternal does.
+func startExecution(ctx context.Context, t *testing.T, d *DockerRunner, req ExecutionRequest, execID string) (func(), error) {
+	t.Helper()
+	if err := d.validateRequest(&req); err != nil {
+		t.Fatalf("validateRequest: %v", err)
+	}
+	return d.lockWorkdir(ctx, req, execID)
+}
+
+func claudeWorkdirRequest(dir string) ExecutionRequest {
+	return ExecutionRequest{Language: "claude", Code: "edit things", WorkDir: dir}
+}
+
+func TestWorkdirLock_FailFast(t *testing.T) {
+	d, project, alias, obs := workdirRunner(t, 0)
+	ctx := context.Background()
+
+	unlock, err := startExecution(ctx, t, d, claudeWorkdirRequest(project), "exec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same directory through a symlink is the same lock.
+	for _, dir := range []string{project, alias} {
+		_, err := startExecution(ctx, t, d, claudeWorkdirRequest(dir), "exec-2")
+		var busy *WorkdirBusyError
+		if !errors.As(err, &busy) || !errors.Is(err, ErrWorkdirBusy) {
+			t.Fatalf("%s: error = %v, want WorkdirBusyError", dir, err)
+		}
+		if busy.Holder != "exec-1" {
+			t.Errorf("holder = %q, want exec-1", busy.Holder)
+		}
+	}
+	if obs.conflicts != 2 || len(obs.waits) != 0 {
+		t.Errorf("conflicts %d waits %v, want 2 conflicts and no fail-fast waits", obs.conflicts, obs.waits)
+	}
+
+	// Read-only mounts don't take the lock.
+	ro := claudeWorkdirRequest(alias)
+	ro.ReadOnly = true
+	unlockRO, err := startExecution(ctx, t, d, ro, "exec-3")
+	if err != nil {
+		t.Fatalf("read-only mount: %v", err)
+	}
+	unlockRO()
+
+	unlock()
+	unlock2, err := startExecution(ctx, t, d, claudeWorkdirRequest(alias), "exec-4")
+	if err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+	unlock2()
+}
+
+func TestWorkdirLock_Wait(t *testing.T) {
+	d, project, alias, obs := workdirRunner(t, 5*time.Second)
+	ctx := context.Background()
+
+	unlock, err := startExecution(ctx, t, d, claudeWorkdirRequest(project), "exec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := make(chan time.Time, 1)
+	acquired := make(chan time.Time, 1)
+	go func() {
+		unlock2, err := startExecution(ctx, t, d, claudeWorkdirRequest(alias), "exec-2")
+		if err != nil {
+			t.Errorf("queued execution: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- time.Now()
+		unlock2()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	released <- time.Now()
+	unlock()
+
+	got, ok := <-acquired
+	if !ok {
+		return
+	}
+	if rel := <-released; got.Before(rel) {
+		t.Errorf("second execution got the lock at %v, before the first released it at %v", got, rel)
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if len(obs.waits) != 1 || obs.waits[0] < 40*time.Millisecond || obs.conflicts != 0 {
+		t.Errorf("waits %v conflicts %d, want one wait of about 50ms", obs.waits, obs.conflicts)
+	}
+}
+
+func TestWorkdirLock_WaitTimesOut(t *testing.T) {
+	d, project, _, obs := workdirRunner(t, 30*time.Millisecond)
+	ctx := context.Background()
+
+	unlock, err := startExecution(ctx, t, d, claudeWorkdirRequest(project), "exec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	start := time.Now()
+	_, err = startExecution(ctx, t, d, claudeWorkdirRequest(project), "exec-2")
+	if !errors.Is(err, ErrWorkdirBusy) {
+		t.Fatalf("error = %v, want ErrWorkdirBusy", err)
+	}
+	if waited := time.Since(start); waited < 30*time.Millisecond {
+		t.Errorf("gave up after %v, want the configured 30ms wait", waited)
+	}
+	if obs.conflicts != 1 || len(obs.waits) != 1 {
+		t.Errorf("conflicts %d waits %v, want one of each", obs.conflicts, obs.waits)
+	}
+}
+
+func TestWorkdirLock_CancelWhileWaiting(t *testing.T) {
+	d, project, _, _ := workdirRunner(t, time.Minute)
+
+	unlock, err := startExecution(context.Background(), t, d, claudeWorkdirRequest(project), "exec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that disconnects while queued gives up without taking the lock.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := startExecution(ctx, t, d, claudeWorkdirRequest(project), "exec-2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the context's", err)
+	}
+
+	unlock()
+	unlock() // a second release is harmless
+	d.workdirs.mu.Lock()
+	held := len(d.workdirs.held)
+	d.workdirs.mu.Unlock()
+	if held != 0 {
+		t.Errorf("%d locks still held after every execution ended", held)
+	}
+}
+
+func TestBuildDockerArgs_WorkdirMode(t *testing.T) {
+	d := newTestRunner(0, "", nil)
+	rt, _ := d.runtimes.Get("claude")
+	for _, readOnly := range []bool{false, true} {
+		req := ExecutionRequest{Language: "claude", Code: "x", WorkDir: "/srv/project", ReadOnly: readOnly}
+		args := d.buildDockerArgs("exec-w", rt, "/tmp/p.txt", "/tmp/prompt.txt", "/tmp/sandbox-exec-w", "/tmp/seccomp.json", req)
+		want := "/srv/project:/workspace:rw"
+		if readOnly {
+			want = "/srv/project:/workspace:ro"
+		}
+		if !argsContainPair(args, "-v", want) {
+			t.Errorf("read_only=%v: expected -v %s in %v", readOnly, want, args)
+		}
+	}
+}
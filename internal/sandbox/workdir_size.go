@@ -0,0 +1,251 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// WorkdirSize is what the walk of a claude work_dir counted before the
+// container started. Symlinks and excluded entries aren't counted.
+type WorkdirSize struct {
+	Files   int64 `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Partial bool  `json:"partial,omitempty"` // The walk stopped early; the counts are a lower bound
+}
+
+// WorkdirTooLargeError is returned when a claude work_dir is over
+// sandbox.workdir_size in reject mode.
+type WorkdirTooLargeError struct {
+	Path     string // resolved host path
+	Size     WorkdirSize
+	MaxBytes int64
+	MaxFiles int64
+}
+
+func (e *WorkdirTooLargeError) Error() string {
+	at := ""
+	if e.Size.Partial {
+		at = "at least "
+	}
+	return fmt.Sprintf("work_dir %s holds %s%d files, %d bytes; the limit is %d files, %d bytes",
+		e.Path, at, e.Size.Files, e.Size.Bytes, e.MaxFiles, e.MaxBytes)
+}
+
+func (e *WorkdirTooLargeError) Unwrap() error { return ErrWorkdirTooLarge }
+
+// workdirSizeCacheDirs bounds the directories the sizer remembers; past it
+// the cache starts again empty.
+const workdirSizeCacheDirs = 100000
+
+// workdirReadChunk is how many entries are read from a directory between
+// checks of the walk's budget.
+const workdirReadChunk = 256
+
+// dirListing is what a directory holds directly: its regular files, and the
+// subdirectories the walk goes on into.
+type dirListing struct {
+	mtime   time.Time
+	files   int64
+	bytes   int64
+	subdirs []string
+}
+
+// workdirSizer measures work_dirs with a walk bounded in time and entries.
+// A directory's listing is cached by path and mtime, which changes when an
+// entry is added, removed or renamed, so an unchanged tree is measured
+// without reading its directories again. A file rewritten in place keeps
+// its cached size until its directory changes.
+type workdirSizer struct {
+	cfg      config.WorkdirSizeConfig
+	now      func() time.Time
+	observer WorkdirObserver
+
+	mu    sync.Mutex
+	cache map[string]dirListing
+}
+
+func newWorkdirSizer(cfg config.WorkdirSizeConfig) *workdirSizer {
+	return &workdirSizer{cfg: cfg, now: time.Now, cache: make(map[string]dirListing)}
+}
+
+// exceeds reports whether size is over a configured limit.
+func (s *workdirSizer) exceeds(size WorkdirSize) bool {
+	return s.cfg.MaxBytes > 0 && size.Bytes > s.cfg.MaxBytes ||
+		s.cfg.MaxFiles > 0 && size.Files > s.cfg.MaxFiles
+}
+
+// excluded reports whether the entry at rel, relative to the root, matches
+// an exclude pattern by name or by path.
+func (s *workdirSizer) excluded(rel string) bool {
+	for _, p := range s.cfg.Exclude {
+		if ok, _ := filepath.Match(p, filepath.Base(rel)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// measure walks root without following symlinks. It stops once the counts
+// are over a limit, there being no need to know by how much, or when
+// max_walk or max_entries runs out; either way the result is Partial.
+func (s *workdirSizer) measure(root string) WorkdirSize {
+	var size WorkdirSize
+	deadline := s.now().Add(s.cfg.MaxWalk)
+	entries := int64(0)
+	stack := []string{"."}
+	for len(stack) > 0 {
+		if s.exceeds(size) || entries >= s.cfg.MaxEntries || !s.now().Before(deadline) {
+			size.Partial = true
+			break
+		}
+		rel := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		dir := filepath.Join(root, rel)
+		info, err := os.Lstat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		entries++
+		listing, ok := s.cached(dir, info.ModTime())
+		if !ok {
+			var complete bool
+			listing, complete = s.list(dir, rel, &entries, deadline)
+			if complete {
+				listing.mtime = info.ModTime()
+				s.store(dir, listing)
+			} else {
+				size.Partial = true
+			}
+		}
+		size.Files += listing.files
+		size.Bytes += listing.bytes
+		if size.Partial {
+			break
+		}
+		for _, sub := range listing.subdirs {
+			stack = append(stack, filepath.Join(rel, sub))
+		}
+	}
+	if s.observer != nil {
+		s.observer.ObserveWorkdirSize(size.Bytes)
+	}
+	return size
+}
+
+// list reads dir in chunks, counting against entries and stopping between
+// chunks once the budget is spent. complete is false if it stopped early.
+func (s *workdirSizer) list(dir, rel string, entries *int64, deadline time.Time) (listing dirListing, complete bool) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return listing, true // unreadable here means unreadable in the container
+	}
+	defer f.Close()
+	for {
+		ents, err := f.ReadDir(workdirReadChunk)
+		for _, ent := range ents {
+			*entries++
+			if s.excluded(filepath.Join(rel, ent.Name())) {
+				continue
+			}
+			switch t := ent.Type(); {
+			case t.IsDir():
+				listing.subdirs = append(listing.subdirs, ent.Name())
+			case t.IsRegular():
+				if info, err := ent.Info(); err == nil {
+					listing.files++
+					listing.bytes += info.Size()
+				}
+			}
+		}
+		if err != nil {
+			return listing, true // io.EOF, or as far as it can be read
+		}
+		if *entries >= s.cfg.MaxEntries || !s.now().Before(deadline) {
+			return listing, false
+		}
+	}
+}
+
+func (s *workdirSizer) cached(dir string, mtime time.Time) (dirListing, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listing, ok := s.cache[dir]
+	if !ok || !listing.mtime.Equal(mtime) {
+		return dirListing{}, false
+	}
+	return listing, true
+}
+
+func (s *workdirSizer) store(dir string, listing dirListing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= workdirSizeCacheDirs {
+		s.cache = make(map[string]dirListing)
+	}
+	s.cache[dir] = listing
+}
+
+// measureWorkdir sizes a claude work_dir before it is mounted. Over a limit
+// it returns a WorkdirTooLargeError, or in warn mode a workdir_too_large
+// event for the result. Other runtimes don't mount the work_dir.
+func (d *DockerRunner) measureWorkdir(req ExecutionRequest) (*WorkdirSize, []SecurityEvent, error) {
+	if d.workdirSize == nil || req.Language != "claude" || req.WorkDir == "" {
+		return nil, nil, nil
+	}
+	size := d.workdirSize.measure(req.WorkDir)
+	if !d.workdirSize.exceeds(size) {
+		return &size, nil, nil
+	}
+	tooLarge := &WorkdirTooLargeError{
+		Path:     req.WorkDir,
+		Size:     size,
+		MaxBytes: d.workdirSize.cfg.MaxBytes,
+		MaxFiles: d.workdirSize.cfg.MaxFiles,
+	}
+	if d.workdirSize.cfg.Mode == "warn" {
+		return &size, []SecurityEvent{{
+			Type:   "workdir_too_large",
+			Source: SourceRuntime,
+			Detail: tooLarge.Error(),
+		}}, nil
+	}
+	return nil, nil, tooLarge
+}
+
+// WorkdirMeasurer is implemented by backends that size a claude work_dir
+// with the walk they measure it with before mounting it.
+type WorkdirMeasurer interface {
+	MeasureWorkdir(dir string) (WorkdirSize, error)
+}
+
+// MeasureWorkdir resolves dir as a request's work_dir is resolved and
+// measures it, without mounting it.
+func (d *DockerRunner) MeasureWorkdir(dir string) (WorkdirSize, error) {
+	if len(d.allowedRoots) == 0 {
+		return WorkdirSize{}, fmt.Errorf("%w: no allowed_workdir_roots configured; WorkDir mounts are disabled", ErrInvalidRequest)
+	}
+	realPath, err := resolveMountDir(dir, d.allowedRoots, "work_dir")
+	if err != nil {
+		return WorkdirSize{}, err
+	}
+	if d.workdirSize == nil {
+		return WorkdirSize{}, fmt.Errorf("work_dir sizing is not configured")
+	}
+	return d.workdirSize.measure(realPath), nil
+}
+
+// MeasureWorkdir measures with the wrapped backend.
+func (c *ChaosBackend) MeasureWorkdir(dir string) (WorkdirSize, error) {
+	if m, ok := c.inner.(WorkdirMeasurer); ok {
+		return m.MeasureWorkdir(dir)
+	}
+	return WorkdirSize{}, fmt.Errorf("%w: the backend doesn't mount work_dirs", ErrInvalidRequest)
+}
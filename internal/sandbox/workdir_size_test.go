@@ -0,0 +1,181 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/config"
+)
+
+// sizeTree creates dirs directories under root, each holding files files of
+// 100 bytes.
+func sizeTree(t *testing.T, root string, dirs, files int) {
+	t.Helper()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", d))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", f)), make([]byte, 100), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func testWorkdirSizer(modify func(*config.WorkdirSizeConfig)) *workdirSizer {
+	cfg := config.DefaultConfig().Sandbox.WorkdirSize
+	modify(&cfg)
+	return newWorkdirSizer(cfg)
+}
+
+func TestWorkdirSizer_Thresholds(t *testing.T) {
+	root := t.TempDir()
+	sizeTree(t, root, 4, 5) // 20 files, 2000 bytes
+
+	tests := []struct {
+		name     string
+		maxFiles int64
+		maxBytes int64
+		over     bool
+	}{
+		{"under both", 21, 2001, false},
+		{"at the limits", 20, 2000, false},
+		{"one file over", 19, 0, true},
+		{"one byte over", 0, 1999, true},
+		{"no limits", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testWorkdirSizer(func(c *config.WorkdirSizeConfig) { c.MaxFiles, c.MaxBytes = tt.maxFiles, tt.maxBytes })
+			size := s.measure(root)
+			if got := s.exceeds(size); got != tt.over {
+				t.Fatalf("measured %+v: over = %v, want %v", size, got, tt.over)
+			}
+			if !tt.over && (size != WorkdirSize{Files: 20, Bytes: 2000}) {
+				t.Errorf("measured %+v, want 20 files of 2000 bytes", size)
+			}
+		})
+	}
+
+	// Over a limit, the walk stops as soon as it knows.
+	s := testWorkdirSizer(func(c *config.WorkdirSizeConfig) { c.MaxFiles = 4 })
+	if size := s.measure(root); !size.Partial || size.Files != 5 {
+		t.Errorf("max_files 4: measured %+v, want the walk stopped after a directory", size)
+	}
+}
+
+func TestWorkdirSizer_Budget(t *testing.T) {
+	root := t.TempDir()
+	sizeTree(t, root, 3, workdirReadChunk+10)
+
+	s := testWorkdirSizer(func(c *config.WorkdirSizeConfig) { c.MaxEntries = 100 })
+	if size := s.measure(root); !size.Partial || size.Files != workdirReadChunk {
+		t.Errorf("max_entries 100: measured %+v, want the first chunk of one directory", size)
+	}
+	if len(s.cache) != 1 {
+		t.Errorf("cached %d listings, want the root's only; a cut-short listing isn't kept", len(s.cache))
+	}
+
+	// The clock passes max_walk after the root and one directory are read.
+	s = testWorkdirSizer(func(c *config.WorkdirSizeConfig) {})
+	start := time.Now()
+	reads := 0
+	s.now = func() time.Time {
+		reads++
+		if reads > 6 {
+			return start.Add(time.Minute)
+		}
+		return start
+	}
+	size := s.measure(root)
+	if !size.Partial || size.Files >= 3*(workdirReadChunk+10) {
+		t.Errorf("max_walk spent: measured %+v, want a partial count", size)
+	}
+}
+
+func TestWorkdirSizer_SkipsSymlinksAndExcludes(t *testing.T) {
+	outside := t.TempDir()
+	sizeTree(t, outside, 1, 50)
+	root := t.TempDir()
+	sizeTree(t, root, 1, 2)
+	os.Symlink(outside, filepath.Join(root, "escape"))
+	os.Symlink(filepath.Join(outside, "d0", "f0"), filepath.Join(root, "d0", "link"))
+	sizeTree(t, filepath.Join(root, "node_modules"), 2, 10)
+	sizeTree(t, filepath.Join(root, "build"), 1, 10)
+	os.WriteFile(filepath.Join(root, "app.log"), make([]byte, 1000), 0o644)
+
+	s := testWorkdirSizer(func(c *config.WorkdirSizeConfig) { c.Exclude = []string{"node_modules", "build/d0", "*.log"} })
+	if size := s.measure(root); (size != WorkdirSize{Files: 2, Bytes: 200}) {
+		t.Errorf("measured %+v, want d0's 2 files only", size)
+	}
+}
+
+func TestWorkdirSizer_CacheFollowsMtime(t *testing.T) {
+	root := t.TempDir()
+	sizeTree(t, root, 2, 3)
+	s := testWorkdirSizer(func(c *config.WorkdirSizeConfig) {})
+	if size := s.measure(root); size.Files != 6 {
+		t.Fatalf("measured %+v", size)
+	}
+
+	// A listing is reused while its directory's mtime is unchanged, even if
+	// the directory's contents aren't.
+	dir := filepath.Join(root, "d1")
+	info, _ := os.Stat(dir)
+	os.WriteFile(filepath.Join(dir, "new"), make([]byte, 100), 0o644)
+	os.Chtimes(dir, info.ModTime(), info.ModTime())
+	if size := s.measure(root); size.Files != 6 {
+		t.Errorf("unchanged mtime: measured %+v, want the cached 6 files", size)
+	}
+	later := info.ModTime().Add(time.Second)
+	os.Chtimes(dir, later, later)
+	if size := s.measure(root); size.Files != 7 || size.Bytes != 700 {
+		t.Errorf("new mtime: measured %+v, want 7 files", size)
+	}
+}
+
+func TestMeasureWorkdir(t *testing.T) {
+	root := t.TempDir()
+	sizeTree(t, root, 1, 3)
+	obs := &workdirRecorder{}
+	d := newDockerRunner(1, []string{root}, 0, "", 1)
+	d.workdirSize = testWorkdirSizer(func(c *config.WorkdirSizeConfig) { c.MaxFiles = 2 })
+	d.workdirSize.observer = obs
+	req := ExecutionRequest{Language: "claude", Code: "fix the tests", WorkDir: root}
+
+	_, _, err := d.measureWorkdir(req)
+	var large *WorkdirTooLargeError
+	if !errors.As(err, &large) || !errors.Is(err, ErrWorkdirTooLarge) || large.Size.Files != 3 || large.MaxFiles != 2 {
+		t.Fatalf("reject mode: %v", err)
+	}
+
+	d.workdirSize.cfg.Mode = "warn"
+	size, events, err := d.measureWorkdir(req)
+	if err != nil || size == nil || size.Files != 3 || len(events) != 1 || events[0].Type != "workdir_too_large" || !strings.Contains(events[0].Detail, "3 files") {
+		t.Errorf("warn mode: %+v, %+v, %v", size, events, err)
+	}
+	if len(obs.sizes) != 2 || obs.sizes[0] != 300 {
+		t.Errorf("observed %v, want each measurement", obs.sizes)
+	}
+
+	req.Language = "python"
+	if size, _, err := d.measureWorkdir(req); size != nil || err != nil {
+		t.Errorf("python measured %+v, %v; its work_dir isn't mounted", size, err)
+	}
+
+	// As POST /estimate measures: resolved against the roots, never refused
+	// for its size.
+	if size, err := d.MeasureWorkdir(root); err != nil || size.Files != 3 {
+		t.Errorf("MeasureWorkdir() = %+v, %v", size, err)
+	}
+	if _, err := d.MeasureWorkdir(t.TempDir()); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("outside the allowed roots: %v", err)
+	}
+}
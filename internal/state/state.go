@@ -0,0 +1,114 @@
+// Package state is the lifecycle of an execution: the states it moves
+// through, the transitions between them, and the status strings earlier
+// builds recorded in their place.
+//
+//	queued ──> running ──> completed | failed | timeout | oom | killed | cancelled | reaped | rejected | interrupted
+//	   └─────> rejected | failed | cancelled | interrupted
+//
+// Every state but queued and running is terminal. The state is what the
+// audit log stores as an execution's status and what the metrics label
+// status carries.
+package state
+
+import "slices"
+
+// State is where an execution is in its lifecycle.
+type State string
+
+const (
+	Queued    State = "queued"    // accepted, waiting for a slot
+	Running   State = "running"   // in its container
+	Completed State = "completed" // the code ran to its own exit, whatever its exit code
+	Failed    State = "failed"    // the sandbox couldn't run it or ended it: an infrastructure error, a signal, a security refusal, no slot
+	Timeout   State = "timeout"   // stopped at its timeout or idle_output_timeout
+	OOM       State = "oom"       // killed by the OOM killer
+	Killed    State = "killed"    // stopped on request, by a kill switch or an external SIGKILL
+	Cancelled State = "cancelled" // abandoned by its caller, or killed with its cancellation group, before it finished
+	Reaped    State = "reaped"    // cleaned up after the server lost track of it
+	Rejected  State = "rejected"  // refused before it ran as an invalid request
+	// Interrupted is written by a server that shut down with the execution
+	// still queued or running once its drain timed out.
+	Interrupted State = "interrupted"
+)
+
+// All lists the states in lifecycle order.
+var All = []State{Queued, Running, Completed, Failed, Timeout, OOM, Killed, Cancelled, Reaped, Rejected, Interrupted}
+
+var transitions = map[State][]State{
+	Queued: {Running, Rejected, Failed, Cancelled, Interrupted},
+	// A backend validates what the server hands it, so an execution the
+	// server counted as running can still be rejected.
+	Running: {Completed, Failed, Timeout, OOM, Killed, Cancelled, Reaped, Rejected, Interrupted},
+}
+
+// Valid reports whether s is one of the states.
+func (s State) Valid() bool {
+	for _, v := range All {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal reports whether s is a state an execution ends in.
+func (s State) Terminal() bool {
+	return s.Valid() && s != Queued && s != Running
+}
+
+// CanTransition reports whether an execution may move from one state to
+// the other. Nothing leaves a terminal state.
+func CanTransition(from, to State) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// legacy maps the statuses earlier builds wrote to the audit log and the
+// metrics onto the states that replaced them.
+var legacy = map[string]State{
+	"success":      Completed,
+	"error":        Failed,
+	"infra_error":  Failed,
+	"signal":       Failed,
+	"security":     Failed,
+	"seccomp":      Failed,
+	"rate_limited": Failed,
+	"saturated":    Failed,
+	"workdir_busy": Failed,
+	"timeout":      Timeout,
+	"idle_timeout": Timeout,
+	"oom":          OOM,
+	"killed":       Killed,
+	"manual_kill":  Killed,
+	"validation":   Rejected,
+}
+
+// Parse returns the state a status names, either a state or a status an
+// earlier build recorded, such as "success" for completed. An unknown
+// status is returned as it is, with ok false.
+func Parse(status string) (s State, ok bool) {
+	if s := State(status); s.Valid() {
+		return s, true
+	}
+	if s, ok := legacy[status]; ok {
+		return s, true
+	}
+	return State(status), false
+}
+
+// Aliases returns s and every earlier status that Parse maps to it: what a
+// query for s has to match among rows written before the states existed.
+func Aliases(s State) []string {
+	aliases := []string{string(s)}
+	for old, to := range legacy {
+		if to == s && old != string(s) {
+			aliases = append(aliases, old)
+		}
+	}
+	slices.Sort(aliases[1:])
+	return aliases
+}
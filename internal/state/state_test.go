@@ -0,0 +1,100 @@
+package state
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTransitions(t *testing.T) {
+	allowed := map[[2]State]bool{}
+	for _, to := range []State{Running, Rejected, Failed, Cancelled, Interrupted} {
+		allowed[[2]State{Queued, to}] = true
+	}
+	for _, to := range []State{Completed, Failed, Timeout, OOM, Killed, Cancelled, Reaped, Rejected, Interrupted} {
+		allowed[[2]State{Running, to}] = true
+	}
+
+	for _, from := range All {
+		for _, to := range All {
+			if got := CanTransition(from, to); got != allowed[[2]State{from, to}] {
+				t.Errorf("CanTransition(%s, %s) = %v", from, to, got)
+			}
+		}
+		if from.Terminal() != (from != Queued && from != Running) {
+			t.Errorf("%s.Terminal() = %v", from, from.Terminal())
+		}
+		if !from.Valid() {
+			t.Errorf("%s is not Valid", from)
+		}
+	}
+
+	for _, s := range []State{"", "success", "finished"} {
+		if s.Valid() || s.Terminal() || CanTransition(Queued, s) || CanTransition(s, Running) {
+			t.Errorf("%q is treated as a state", s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		status string
+		want   State
+		ok     bool
+	}{
+		{"completed", Completed, true},
+		{"running", Running, true},
+		{"reaped", Reaped, true},
+		{"interrupted", Interrupted, true},
+		{"success", Completed, true},
+		{"error", Failed, true},
+		{"infra_error", Failed, true},
+		{"signal", Failed, true},
+		{"security", Failed, true},
+		{"seccomp", Failed, true},
+		{"rate_limited", Failed, true},
+		{"saturated", Failed, true},
+		{"workdir_busy", Failed, true},
+		{"timeout", Timeout, true},
+		{"idle_timeout", Timeout, true},
+		{"oom", OOM, true},
+		{"killed", Killed, true},
+		{"manual_kill", Killed, true},
+		{"validation", Rejected, true},
+		{"exploded", "exploded", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := Parse(tt.status); got != tt.want || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %v; want %q, %v", tt.status, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Every legacy status parses to a state and is among that state's aliases.
+	for old, s := range legacy {
+		if !s.Valid() {
+			t.Errorf("legacy %q maps to %q, not a state", old, s)
+		}
+		if !slices.Contains(Aliases(s), old) {
+			t.Errorf("Aliases(%s) = %v, missing %q", s, Aliases(s), old)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	tests := []struct {
+		s    State
+		want []string
+	}{
+		{Completed, []string{"completed", "success"}},
+		{Timeout, []string{"timeout", "idle_timeout"}},
+		{Killed, []string{"killed", "manual_kill"}},
+		{Rejected, []string{"rejected", "validation"}},
+		{Reaped, []string{"reaped"}},
+		{Failed, []string{"failed", "error", "infra_error", "rate_limited", "saturated", "seccomp", "security", "signal", "workdir_busy"}},
+	}
+	for _, tt := range tests {
+		if got := Aliases(tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("Aliases(%s) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Baseline is one caller's execution history, as the API's anomaly flagging
+// summarizes it. Stats is opaque here.
+type Baseline struct {
+	Identity  string          // api_key_hash
+	Stats     json.RawMessage // JSON
+	UpdatedAt time.Time
+}
+
+// SaveBaselines writes baselines, replacing any saved for the same
+// identities, in one transaction.
+func (db *DB) SaveBaselines(ctx context.Context, baselines []Baseline) error {
+	if len(baselines) == 0 {
+		return nil
+	}
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning baseline transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	for _, b := range baselines {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO identity_baselines (identity, stats, updated_at) VALUES ($1, $2, $3)
+			ON CONFLICT (identity) DO UPDATE SET stats = EXCLUDED.stats, updated_at = EXCLUDED.updated_at`,
+			b.Identity, b.Stats, b.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("saving baseline: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing baselines: %w", err)
+	}
+	return nil
+}
+
+// LoadBaselines returns the baselines updated since since.
+func (db *DB) LoadBaselines(ctx context.Context, since time.Time) ([]Baseline, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT identity, stats, updated_at FROM identity_baselines WHERE updated_at >= $1`, since)
+	if err != nil {
+		return nil, fmt.Errorf("querying baselines: %w", err)
+	}
+	defer rows.Close()
+
+	var baselines []Baseline
+	for rows.Next() {
+		var b Baseline
+		if err := rows.Scan(&b.Identity, &b.Stats, &b.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning baseline row: %w", err)
+		}
+		baselines = append(baselines, b)
+	}
+	return baselines, rows.Err()
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestDBBaselines saves a baseline twice to a migrated database named by
+// SANDBOX_TEST_DATABASE_URL and loads the second back.
+func TestDBBaselines(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id := "baseline-" + uuid.NewString()
+	at := time.Now().UTC().Truncate(time.Microsecond)
+	for _, stats := range []string{`{"executions":1}`, `{"executions":2}`} {
+		if err := db.SaveBaselines(ctx, []Baseline{{Identity: id, Stats: json.RawMessage(stats), UpdatedAt: at}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, err := db.LoadBaselines(ctx, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range loaded {
+		if b.Identity == id {
+			var stats struct{ Executions int }
+			if err := json.Unmarshal(b.Stats, &stats); err != nil || stats.Executions != 2 || !b.UpdatedAt.Equal(at) {
+				t.Errorf("loaded %s %v, %v", b.Stats, b.UpdatedAt, err)
+			}
+			return
+		}
+	}
+	t.Errorf("baseline %s not loaded", id)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNotFound is returned for a code bundle that isn't stored.
+var ErrNotFound = errors.New("not found")
+
+// CodeBundle is a row of code_bundles: a bundle's record and, when written
+// or asked for, its canonical archive.
+type CodeBundle struct {
+	Digest     string
+	Entrypoint string
+	Language   string
+	SizeBytes  int64
+	Files      int
+	Approved   bool
+	Pinned     bool
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	Archive    []byte
+}
+
+// PutCodeBundle stores b unless a bundle with its digest is stored already.
+func (db *DB) PutCodeBundle(ctx context.Context, b CodeBundle) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO code_bundles (digest, entrypoint, language, size_bytes, files, approved, pinned, created_at, last_used_at, archive)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (digest) DO NOTHING`,
+		b.Digest, b.Entrypoint, b.Language, b.SizeBytes, b.Files, b.Approved, b.Pinned, b.CreatedAt, b.LastUsedAt, b.Archive)
+	if err != nil {
+		return fmt.Errorf("saving code bundle: %w", err)
+	}
+	return nil
+}
+
+// GetCodeBundle returns a bundle's record, without its archive.
+func (db *DB) GetCodeBundle(ctx context.Context, digest string) (CodeBundle, error) {
+	var b CodeBundle
+	err := db.pool.QueryRow(ctx, `
+		SELECT digest, entrypoint, language, size_bytes, files, approved, pinned, created_at, last_used_at
+		FROM code_bundles WHERE digest = $1`, digest).Scan(
+		&b.Digest, &b.Entrypoint, &b.Language, &b.SizeBytes, &b.Files, &b.Approved, &b.Pinned, &b.CreatedAt, &b.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CodeBundle{}, ErrNotFound
+	}
+	if err != nil {
+		return CodeBundle{}, fmt.Errorf("querying code bundle %s: %w", digest, err)
+	}
+	return b, nil
+}
+
+// GetCodeBundleArchive returns a bundle's archive as stored.
+func (db *DB) GetCodeBundleArchive(ctx context.Context, digest string) ([]byte, error) {
+	var archive []byte
+	err := db.pool.QueryRow(ctx, `SELECT archive FROM code_bundles WHERE digest = $1`, digest).Scan(&archive)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying code bundle %s: %w", digest, err)
+	}
+	return archive, nil
+}
+
+// SetCodeBundleFlags approves or pins a bundle, or takes either back.
+func (db *DB) SetCodeBundleFlags(ctx context.Context, digest string, approved, pinned bool) error {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE code_bundles SET approved = $2, pinned = $3 WHERE digest = $1`, digest, approved, pinned)
+	if err != nil {
+		return fmt.Errorf("updating code bundle %s: %w", digest, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchCodeBundle records that a bundle was used at usedAt.
+func (db *DB) TouchCodeBundle(ctx context.Context, digest string, usedAt time.Time) error {
+	if _, err := db.pool.Exec(ctx, `
+		UPDATE code_bundles SET last_used_at = GREATEST(last_used_at, $2) WHERE digest = $1`, digest, usedAt); err != nil {
+		return fmt.Errorf("updating code bundle %s: %w", digest, err)
+	}
+	return nil
+}
+
+// DeleteCodeBundle removes a bundle.
+func (db *DB) DeleteCodeBundle(ctx context.Context, digest string) error {
+	if _, err := db.pool.Exec(ctx, `DELETE FROM code_bundles WHERE digest = $1`, digest); err != nil {
+		return fmt.Errorf("deleting code bundle %s: %w", digest, err)
+	}
+	return nil
+}
+
+// ListCodeBundles returns every bundle's record, without archives.
+func (db *DB) ListCodeBundles(ctx context.Context) ([]CodeBundle, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT digest, entrypoint, language, size_bytes, files, approved, pinned, created_at, last_used_at
+		FROM code_bundles`)
+	if err != nil {
+		return nil, fmt.Errorf("querying code bundles: %w", err)
+	}
+	defer rows.Close()
+
+	var bundles []CodeBundle
+	for rows.Next() {
+		var b CodeBundle
+		if err := rows.Scan(&b.Digest, &b.Entrypoint, &b.Language, &b.SizeBytes, &b.Files, &b.Approved, &b.Pinned, &b.CreatedAt, &b.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("scanning code bundle row: %w", err)
+		}
+		bundles = append(bundles, b)
+	}
+	return bundles, rows.Err()
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RecentClaudeUsage returns the options of up to limit claude executions
+// with model created since since that reported their usage, newest first.
+// An empty model is claude's own default.
+func (db *DB) RecentClaudeUsage(ctx context.Context, model string, since time.Time, limit int) ([]ClaudeOptions, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT claude_options
+		FROM executions
+		WHERE language = 'claude' AND created_at >= $2 AND NOT chaos
+		  AND COALESCE(claude_options->>'model', '') = $1
+		  AND (claude_options->>'turns')::int > 0
+		ORDER BY created_at DESC
+		LIMIT $3`, model, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying claude usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []ClaudeOptions
+	for rows.Next() {
+		var opts ClaudeOptions
+		if err := rows.Scan(&opts); err != nil {
+			return nil, fmt.Errorf("scanning claude usage: %w", err)
+		}
+		usage = append(usage, opts)
+	}
+	return usage, rows.Err()
+}
+
+// FilterClaudeUsage selects from execs, in any order, what
+// DB.RecentClaudeUsage would, for when there is no database.
+func FilterClaudeUsage(execs []Execution, model string, since time.Time, limit int) []ClaudeOptions {
+	var matched []Execution
+	for _, e := range execs {
+		opts := e.ClaudeOptions
+		if e.Language != "claude" || e.Chaos || e.CreatedAt.Before(since) || opts == nil || opts.Model != model || opts.Turns <= 0 {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	slices.SortFunc(matched, func(a, b Execution) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	usage := make([]ClaudeOptions, 0, min(len(matched), limit))
+	for _, e := range matched[:min(len(matched), limit)] {
+		usage = append(usage, *e.ClaudeOptions)
+	}
+	return usage
+}
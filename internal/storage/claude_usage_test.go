@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterClaudeUsage(t *testing.T) {
+	since := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	claude := func(model string, turns, day int) Execution {
+		return Execution{Language: "claude", CreatedAt: since.AddDate(0, 0, day), ClaudeOptions: &ClaudeOptions{Model: model, Turns: turns}}
+	}
+	execs := []Execution{
+		claude("haiku", 2, 1),
+		claude("haiku", 5, 3), // newest
+		claude("haiku", 3, 2),
+		claude("sonnet", 4, 2),
+		claude("haiku", 0, 2),  // no result event
+		claude("haiku", 9, -1), // too old
+		{Language: "python", CreatedAt: since},
+	}
+	chaos := claude("haiku", 1, 1)
+	chaos.Chaos = true
+	execs = append(execs, chaos)
+
+	got := FilterClaudeUsage(execs, "haiku", since, 2)
+	if len(got) != 2 || got[0].Turns != 5 || got[1].Turns != 3 {
+		t.Errorf("got %+v, want the newest two haiku sessions with usage", got)
+	}
+}
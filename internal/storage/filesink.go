@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// Fsync policies of a FileSink: when written records are synced to disk.
+const (
+	FsyncAlways = "always" // after each record
+	FsyncBatch  = "batch"  // after each batch the audit writer hands over
+	FsyncNever  = "never"  // when the OS gets to it
+)
+
+// FileSinkOptions configure a FileSink.
+type FileSinkOptions struct {
+	Path     string        // the file records are appended to
+	MaxBytes int64         // rotate before a record would take the file past this; 0 = no limit
+	MaxAge   time.Duration // rotate once the file's first record is this old; 0 = no limit
+	Fsync    string        // FsyncAlways, FsyncBatch (the default) or FsyncNever
+	HMACKey  []byte        // chains each line to the one before it; nil writes no hmac
+}
+
+// FileSink is an AuditSink that appends execution records to a file, one
+// JSON object per line, for a log pipeline to ship. A rotated file is
+// renamed beside the active one with the time it was rotated, e.g.
+// audit-20260301T120000.000000000Z.jsonl, and left for the pipeline to
+// collect.
+//
+// With an HMAC key, each line ends with "hmac": the hex HMAC-SHA256 of the
+// previous line's hmac followed by the line without its own. The chain runs
+// on across rotations and restarts, so VerifyChain finds a line that was
+// changed, removed or inserted anywhere before the last one.
+type FileSink struct {
+	opts FileSinkOptions
+	now  func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time // when the file's first record was written
+	prev   string    // hmac of the last line written; "" before the first
+}
+
+// hmacSuffixLen is the length of what a line's hmac adds to it,
+// `,"hmac":"<64 hex>"}` in place of its closing brace.
+const hmacSuffixLen = len(`,"hmac":""}`) + sha256.Size*2
+
+// fileRecord is the line written for an execution: the record, its
+// security events and its network connections, which the database keeps in
+// tables of their own.
+type fileRecord struct {
+	*Execution
+	Events      []SecurityEventRecord     `json:"events,omitempty"`
+	Connections []NetworkConnectionRecord `json:"connections,omitempty"`
+}
+
+// NewFileSink opens the file at opts.Path for appending, creating it if
+// needed. A final line left incomplete by a crash is cut off, and the hmac
+// chain picks up from the last complete line.
+func NewFileSink(opts FileSinkOptions) (*FileSink, error) {
+	if opts.Fsync == "" {
+		opts.Fsync = FsyncBatch
+	}
+	s := &FileSink{opts: opts, now: time.Now}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the active file and recovers its state: its size, the time of
+// its first record and the hmac of its last line.
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.opts.Path), 0o750); err != nil {
+		return fmt.Errorf("creating audit file directory: %w", err)
+	}
+	f, err := os.OpenFile(s.opts.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening audit file: %w", err)
+	}
+	s.f, s.size, s.opened = f, info.Size(), s.now()
+	if s.size == 0 {
+		return nil
+	}
+
+	last, end, err := lastLine(f, s.size)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("reading audit file: %w", err)
+	}
+	if end < s.size {
+		log.Warn().Str("path", s.opts.Path).Int64("bytes", s.size-end).Msg("audit file ends in an incomplete record, cutting it off")
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return fmt.Errorf("truncating audit file: %w", err)
+		}
+		s.size = end
+	}
+	if last != nil && s.opts.HMACKey != nil {
+		if _, sum, ok := splitHMAC(last); ok {
+			s.prev = sum
+		} else {
+			log.Warn().Str("path", s.opts.Path).Msg("last audit record has no hmac, starting a new chain")
+		}
+	}
+	if first, err := firstLine(s.opts.Path); err == nil {
+		var rec struct {
+			CreatedAt time.Time `json:"created_at"`
+		}
+		if json.Unmarshal(first, &rec) == nil && !rec.CreatedAt.IsZero() {
+			s.opened = rec.CreatedAt
+		}
+	}
+	return nil
+}
+
+// LogExecution appends exec.
+func (s *FileSink) LogExecution(ctx context.Context, exec *Execution) error {
+	return s.LogExecutions(ctx, []*Execution{exec})
+}
+
+// LogExecutions appends execs in order, rotating the file first when a
+// record would take it past max_bytes, or it is max_age old. On error the
+// file is cut back to where the failed write started, so no record is left
+// half written; the records before it in execs stay written.
+func (s *FileSink) LogExecutions(_ context.Context, execs []*Execution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("audit file is closed")
+	}
+
+	var pending bytes.Buffer // lines not yet written, the last of them chained as prev
+	prev := s.prev
+	for _, exec := range execs {
+		line, err := json.Marshal(fileRecord{Execution: exec, Events: exec.Events, Connections: exec.Connections})
+		if err != nil {
+			return fmt.Errorf("encoding audit record %s: %w", exec.ID, err)
+		}
+		next := prev
+		if s.opts.HMACKey != nil {
+			next = chainHMAC(s.opts.HMACKey, prev, line)
+			line = appendHMAC(line, next)
+		}
+		line = append(line, '\n')
+
+		if s.rotationDue(int64(pending.Len() + len(line))) {
+			if err := s.write(pending.Bytes(), prev); err != nil {
+				return err
+			}
+			pending.Reset()
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		pending.Write(line)
+		prev = next
+		if s.opts.Fsync == FsyncAlways {
+			if err := s.write(pending.Bytes(), prev); err != nil {
+				return err
+			}
+			pending.Reset()
+		}
+	}
+	if err := s.write(pending.Bytes(), prev); err != nil {
+		return err
+	}
+	if s.opts.Fsync == FsyncBatch {
+		if err := s.f.Sync(); err != nil {
+			return fmt.Errorf("syncing audit file: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotationDue reports whether the file must be rotated before adding n
+// bytes to it. An empty file never is: a record bigger than max_bytes gets
+// a file of its own.
+func (s *FileSink) rotationDue(n int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.opts.MaxBytes > 0 && s.size+n > s.opts.MaxBytes {
+		return true
+	}
+	return s.opts.MaxAge > 0 && s.now().Sub(s.opened) >= s.opts.MaxAge
+}
+
+// write appends lines, whose last hmac is prev, syncing them with fsync
+// always.
+func (s *FileSink) write(lines []byte, prev string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if s.size == 0 {
+		s.opened = s.now()
+	}
+	if _, err := s.f.Write(lines); err != nil {
+		if terr := s.f.Truncate(s.size); terr != nil {
+			log.Error().Err(terr).Str("path", s.opts.Path).Msg("failed to cut a partly written audit record off")
+		}
+		return fmt.Errorf("writing audit file: %w", err)
+	}
+	s.size += int64(len(lines))
+	s.prev = prev
+	if s.opts.Fsync == FsyncAlways {
+		if err := s.f.Sync(); err != nil {
+			return fmt.Errorf("syncing audit file: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotate renames the active file aside and opens a new one. The hmac chain
+// carries on into it.
+func (s *FileSink) rotate() error {
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("syncing audit file: %w", err)
+	}
+	if err := s.f.Close(); err != nil {
+		return fmt.Errorf("closing audit file: %w", err)
+	}
+	// A file that can't be renamed is reopened and keeps growing, rather
+	// than the sink taking no more records.
+	renamed := true
+	if err := os.Rename(s.opts.Path, rotatedPath(s.opts.Path, s.now())); err != nil {
+		log.Error().Err(err).Str("path", s.opts.Path).Msg("failed to rotate the audit file, appending to it")
+		renamed = false
+	}
+	f, err := os.OpenFile(s.opts.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		s.f = nil
+		return fmt.Errorf("opening audit file: %w", err)
+	}
+	s.f, s.opened = f, s.now()
+	if renamed {
+		s.size = 0
+	}
+	return nil
+}
+
+// rotatedPath is where the file at path goes when it is rotated at t:
+// beside it, with t before the extension so it still matches *.jsonl.
+func rotatedPath(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + t.UTC().Format("20060102T150405.000000000Z") + ext
+}
+
+// Close syncs and closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
+
+// ListExecutions reads the records in the active file that match filter,
+// newest first, with the fields DB.ListExecutions returns. Rotated files
+// aren't read, and a line that doesn't decode is skipped.
+func (s *FileSink) ListExecutions(ctx context.Context, filter ExecutionFilter) ([]Execution, error) {
+	f, err := os.Open(s.opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit file: %w", err)
+	}
+	defer f.Close()
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var matched []Execution
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var exec Execution
+			if json.Unmarshal(line, &exec) == nil && filter.Matches(&exec) {
+				matched = append(matched, Listed(exec))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading audit file: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	slices.Reverse(matched)
+	matched = matched[min(filter.Offset, len(matched)):]
+	return matched[:min(limit, len(matched))], nil
+}
+
+// Matches reports whether exec passes the filter, as ListExecutions' query
+// would.
+func (f ExecutionFilter) Matches(exec *Execution) bool {
+	status, _ := state.Parse(string(exec.Status))
+	return (f.Language == "" || exec.Language == f.Language) &&
+		(f.Status == "" || status == f.Status) &&
+		(f.TaskID == "" || exec.TaskID == f.TaskID) &&
+		(f.ServerVersion == "" || exec.ServerVersion == f.ServerVersion) &&
+		(f.Since == nil || !exec.CreatedAt.Before(*f.Since)) &&
+		(f.Until == nil || exec.CreatedAt.Before(*f.Until))
+}
+
+// Listed keeps the fields of exec that a listing shows.
+func Listed(exec Execution) Execution {
+	status, _ := state.Parse(string(exec.Status))
+	return Execution{
+		ID: exec.ID, Language: exec.Language, CodeHash: exec.CodeHash, ExitCode: exec.ExitCode,
+		DurationMS: exec.DurationMS, SecurityEvents: exec.SecurityEvents, Status: status,
+		CreatedAt: exec.CreatedAt, CompletedAt: exec.CompletedAt, TaskID: exec.TaskID,
+		ServerVersion: exec.ServerVersion, ImageDigest: exec.ImageDigest,
+	}
+}
+
+// ErrChainBroken is returned by VerifyChain for a line whose hmac doesn't
+// follow from the lines before it.
+var ErrChainBroken = errors.New("audit hmac chain broken")
+
+// VerifyChain checks the hmac chain of the lines read from r, a file a
+// FileSink wrote with key. prev is where the chain comes in from: "" for the
+// first file, or what VerifyChain returned for the file rotated before this
+// one. It returns the hmac of the last line and how many lines it checked.
+//
+// A line that was changed, removed or inserted breaks the chain at the first
+// line after it. Lines cut off the end of the active file are only found by
+// comparing last with an hmac kept elsewhere; from a rotated file, by
+// verifying the file after it.
+func VerifyChain(r io.Reader, key []byte, prev string) (last string, lines int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			lines++
+			if line[len(line)-1] != '\n' {
+				return prev, lines - 1, fmt.Errorf("%w: line %d is incomplete", ErrChainBroken, lines)
+			}
+			content, sum, ok := splitHMAC(line[:len(line)-1])
+			if !ok {
+				return prev, lines - 1, fmt.Errorf("%w: line %d has no hmac", ErrChainBroken, lines)
+			}
+			if want := chainHMAC(key, prev, content); !hmac.Equal([]byte(want), []byte(sum)) {
+				return prev, lines - 1, fmt.Errorf("%w: line %d doesn't follow from the line before it", ErrChainBroken, lines)
+			}
+			prev = sum
+		}
+		if err == io.EOF {
+			return prev, lines, nil
+		}
+		if err != nil {
+			return prev, lines - 1, err
+		}
+	}
+}
+
+// chainHMAC is the hmac of line, a record without its hmac, following prev.
+func chainHMAC(key []byte, prev string, line []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prev))
+	mac.Write(line)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// appendHMAC adds the field "hmac": sum to line, a JSON object.
+func appendHMAC(line []byte, sum string) []byte {
+	out := make([]byte, 0, len(line)+hmacSuffixLen-1)
+	out = append(out, line[:len(line)-1]...)
+	out = append(out, `,"hmac":"`...)
+	out = append(out, sum...)
+	return append(out, `"}`...)
+}
+
+// splitHMAC undoes appendHMAC, returning the line as it was hashed and its
+// hmac. ok is false when line doesn't end with an hmac field.
+func splitHMAC(line []byte) (content []byte, sum string, ok bool) {
+	if len(line) < hmacSuffixLen+1 {
+		return nil, "", false
+	}
+	cut := len(line) - hmacSuffixLen
+	suffix := line[cut:]
+	if !bytes.HasPrefix(suffix, []byte(`,"hmac":"`)) || !bytes.HasSuffix(suffix, []byte(`"}`)) {
+		return nil, "", false
+	}
+	sum = string(suffix[len(`,"hmac":"`) : len(suffix)-2])
+	if _, err := hex.DecodeString(sum); err != nil {
+		return nil, "", false
+	}
+	content = append(slices.Clip(line[:cut]), '}')
+	return content, sum, true
+}
+
+// lastLine finds the last complete line of the size bytes of f. It returns
+// the line without its newline, or nil when there is none, and end, the
+// offset just past its newline: less than size when the file ends in an
+// incomplete line.
+func lastLine(f *os.File, size int64) (line []byte, end int64, err error) {
+	const chunk = 64 << 10
+	var tail []byte // the bytes from the read position to size
+	newlines := func() []int {
+		var at []int
+		for i, b := range tail {
+			if b == '\n' {
+				at = append(at, i)
+			}
+		}
+		return at
+	}
+	pos := size
+	for {
+		at := newlines()
+		switch {
+		case len(at) >= 2:
+			last, before := at[len(at)-1], at[len(at)-2]
+			return tail[before+1 : last], pos + int64(last) + 1, nil
+		case len(at) == 1 && pos == 0:
+			return tail[:at[0]], int64(at[0]) + 1, nil
+		case pos == 0:
+			return nil, 0, nil // no complete line at all
+		}
+		n := min(int64(chunk), pos)
+		pos -= n
+		buf := make([]byte, n, int(n)+len(tail))
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			return nil, 0, err
+		}
+		tail = append(buf, tail...)
+	}
+}
+
+// firstLine returns the first line of the file at path.
+func firstLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return bufio.NewReader(f).ReadBytes('\n')
+}
@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+var testHMACKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testFileSink(t *testing.T, opts FileSinkOptions) (*FileSink, *time.Time) {
+	t.Helper()
+	if opts.Path == "" {
+		opts.Path = filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	}
+	s, err := NewFileSink(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func logIDs(t *testing.T, s *FileSink, ids ...string) {
+	t.Helper()
+	execs := make([]*Execution, len(ids))
+	for i, id := range ids {
+		execs[i] = &Execution{ID: id, Language: "python", Status: state.Completed, CreatedAt: s.now()}
+	}
+	if err := s.LogExecutions(context.Background(), execs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// auditFiles returns the rotated files beside path, oldest first, then the
+// active one.
+func auditFiles(t *testing.T, path string) []string {
+	t.Helper()
+	rotated, err := filepath.Glob(strings.TrimSuffix(path, ".jsonl") + "-*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rotated)
+	return append(rotated, path)
+}
+
+func lineCount(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+// verifyFiles checks the chain across files in order, returning the lines
+// checked.
+func verifyFiles(t *testing.T, files []string) (int, error) {
+	t.Helper()
+	var prev string
+	total := 0
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		prev, n, err = VerifyChain(f, testHMACKey, prev)
+		f.Close()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func TestFileSink_RotatesBySize(t *testing.T) {
+	s, now := testFileSink(t, FileSinkOptions{HMACKey: testHMACKey})
+	logIDs(t, s, "e0")
+	lineLen := s.size
+	s.opts.MaxBytes = 3 * lineLen
+
+	// Three lines fit exactly; the fourth, in the same batch, starts a file.
+	logIDs(t, s, "e1", "e2")
+	*now = now.Add(time.Second)
+	logIDs(t, s, "e3", "e4")
+	files := auditFiles(t, s.opts.Path)
+	if len(files) != 2 || lineCount(t, files[0]) != 3 || lineCount(t, files[1]) != 2 {
+		t.Fatalf("files %v, want a rotated file of 3 lines and an active one of 2", files)
+	}
+	if s.size != 2*lineLen {
+		t.Errorf("active file size %d, want %d", s.size, 2*lineLen)
+	}
+
+	// A record bigger than max_bytes is still written, in a file of its own.
+	s.opts.MaxBytes = lineLen / 2
+	*now = now.Add(time.Second)
+	logIDs(t, s, "e5")
+	if files := auditFiles(t, s.opts.Path); len(files) != 3 || lineCount(t, files[2]) != 1 {
+		t.Errorf("files %v after an oversized record", files)
+	}
+	if n, err := verifyFiles(t, auditFiles(t, s.opts.Path)); err != nil || n != 6 {
+		t.Errorf("chain across rotations: %d lines, %v", n, err)
+	}
+}
+
+func TestFileSink_RotatesByAge(t *testing.T) {
+	s, now := testFileSink(t, FileSinkOptions{MaxAge: time.Hour})
+	logIDs(t, s, "e0")
+	*now = now.Add(59 * time.Minute)
+	logIDs(t, s, "e1")
+	if files := auditFiles(t, s.opts.Path); len(files) != 1 {
+		t.Fatalf("rotated before max_age: %v", files)
+	}
+	*now = now.Add(time.Minute)
+	logIDs(t, s, "e2")
+	files := auditFiles(t, s.opts.Path)
+	if len(files) != 2 || lineCount(t, files[0]) != 2 || lineCount(t, files[1]) != 1 {
+		t.Fatalf("files %v at max_age", files)
+	}
+	want := filepath.Join(filepath.Dir(s.opts.Path), "audit-20260301T130000.000000000Z.jsonl")
+	if files[0] != want {
+		t.Errorf("rotated to %s, want %s", files[0], want)
+	}
+
+	// The age of a reopened file is its first record's.
+	s.Close()
+	reopened, err := NewFileSink(s.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reopened.opened.Equal(*now) {
+		t.Errorf("reopened file's age starts at %s, want %s", reopened.opened, *now)
+	}
+}
+
+func TestVerifyChain_DetectsTampering(t *testing.T) {
+	s, now := testFileSink(t, FileSinkOptions{HMACKey: testHMACKey, MaxAge: time.Hour})
+	logIDs(t, s, "e0", "e1", "e2")
+	*now = now.Add(time.Hour)
+	logIDs(t, s, "e3", "e4")
+	files := auditFiles(t, s.opts.Path)
+	if n, err := verifyFiles(t, files); err != nil || n != 5 {
+		t.Fatalf("untouched files: %d lines, %v", n, err)
+	}
+
+	tamper := func(t *testing.T, path string, edit func([]string) []string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		os.WriteFile(path, []byte(strings.Join(edit(lines), "")), 0o600)
+		t.Cleanup(func() { os.WriteFile(path, data, 0o600) })
+	}
+	tests := []struct {
+		name  string
+		file  int
+		edit  func([]string) []string
+		lines int // lines verified before the break
+	}{
+		{"modified", 0, func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"exit_code":0`, `"exit_code":1`, 1)
+			return l
+		}, 1},
+		{"removed", 0, func(l []string) []string { return append(l[:1:1], l[2:]...) }, 1},
+		{"reordered", 1, func(l []string) []string { return []string{l[1], l[0]} }, 3},
+		{"rotated file dropped", -1, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := files
+			if tt.edit == nil {
+				files = files[1:]
+			} else {
+				tamper(t, files[tt.file], tt.edit)
+			}
+			n, err := verifyFiles(t, files)
+			if !errors.Is(err, ErrChainBroken) || n != tt.lines {
+				t.Errorf("verified %d lines, %v; want a break after %d", n, err, tt.lines)
+			}
+		})
+	}
+
+	if _, _, err := VerifyChain(strings.NewReader(""), testHMACKey, ""); err != nil {
+		t.Errorf("empty file: %v", err)
+	}
+	f, _ := os.Open(files[0])
+	defer f.Close()
+	if _, _, err := VerifyChain(f, []byte("another key"), ""); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("wrong key: %v", err)
+	}
+}
+
+// TestFileSink_RecoversPartialLine checks a record cut off by a crash is
+// dropped on reopening and the chain carries on from the line before it.
+func TestFileSink_RecoversPartialLine(t *testing.T) {
+	s, _ := testFileSink(t, FileSinkOptions{HMACKey: testHMACKey})
+	logIDs(t, s, "e0", "e1")
+	s.Close()
+	whole, _ := os.ReadFile(s.opts.Path)
+	f, _ := os.OpenFile(s.opts.Path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"id":"e2","language":"pyth`)
+	f.Close()
+
+	reopened, err := NewFileSink(s.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got, _ := os.ReadFile(s.opts.Path); !bytes.Equal(got, whole) {
+		t.Fatalf("partial line not cut off:\n%s", got)
+	}
+	if reopened.prev != s.prev || reopened.size != int64(len(whole)) {
+		t.Errorf("reopened at prev %q, size %d; want %q, %d", reopened.prev, reopened.size, s.prev, len(whole))
+	}
+	logIDs(t, reopened, "e2")
+	if n, err := verifyFiles(t, []string{s.opts.Path}); err != nil || n != 3 {
+		t.Errorf("chain after recovery: %d lines, %v", n, err)
+	}
+}
+
+func TestFileSink_ListExecutions(t *testing.T) {
+	s, now := testFileSink(t, FileSinkOptions{HMACKey: testHMACKey})
+	start := *now
+	records := []*Execution{
+		{ID: "a", Language: "python", Status: "success", TaskID: "t1"}, // a status from before the states
+		{ID: "b", Language: "bash", Status: state.Failed, TaskID: "t1"},
+		{ID: "c", Language: "python", Status: state.Timeout, Code: "print(1)"},
+		{ID: "d", Language: "python", Status: state.Completed, TaskID: "t2"},
+	}
+	for i, r := range records {
+		r.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+	}
+	if err := s.LogExecutions(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	// A line being written as the file is read isn't listed.
+	f, _ := os.OpenFile(s.opts.Path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"id":"e","language":"python"`)
+	f.Close()
+
+	since := start.Add(time.Minute)
+	tests := []struct {
+		name   string
+		filter ExecutionFilter
+		want   []string
+	}{
+		{"all", ExecutionFilter{}, []string{"d", "c", "b", "a"}},
+		{"language", ExecutionFilter{Language: "python"}, []string{"d", "c", "a"}},
+		{"legacy status", ExecutionFilter{Status: state.Completed}, []string{"d", "a"}},
+		{"task", ExecutionFilter{TaskID: "t1"}, []string{"b", "a"}},
+		{"since", ExecutionFilter{Since: &since}, []string{"d", "c", "b"}},
+		{"page", ExecutionFilter{Limit: 2, Offset: 1}, []string{"c", "b"}},
+		{"past the end", ExecutionFilter{Offset: 10}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execs, err := s.ListExecutions(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range execs {
+				got = append(got, e.ID)
+				if e.Code != "" || !e.Status.Valid() {
+					t.Errorf("listed %+v, want the listing's fields with a state", e)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
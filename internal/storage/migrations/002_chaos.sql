@@ -0,0 +1,4 @@
+-- 002_chaos.sql
+-- Mark executions synthesized by chaos mode so reports can exclude them
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS chaos BOOLEAN NOT NULL DEFAULT false;
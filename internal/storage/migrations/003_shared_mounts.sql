@@ -0,0 +1,4 @@
+-- 003_shared_mounts.sql
+-- Record which sandbox.shared_mounts each execution had attached
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS shared_mounts TEXT[] NOT NULL DEFAULT '{}';
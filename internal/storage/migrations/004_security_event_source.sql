@@ -0,0 +1,7 @@
+-- 004_security_event_source.sql
+-- Record where each security event was detected, and collapse repeated code
+-- detections into one row with a count
+
+ALTER TABLE security_events ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'runtime';
+ALTER TABLE security_events ADD COLUMN IF NOT EXISTS line INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE security_events ADD COLUMN IF NOT EXISTS count INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- 005_claude_options.sql
+-- Record the tool, turn and model restrictions each claude execution ran with
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS claude_options JSONB;
@@ -0,0 +1,7 @@
+-- 006_execution_cost.sql
+-- Record each execution's cost score so hourly budgets survive restarts
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS cost DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Index for reading back the last hour's spend at startup
+CREATE INDEX IF NOT EXISTS idx_executions_cost_recent ON executions (created_at) WHERE cost > 0;
@@ -0,0 +1,5 @@
+-- 007_execution_code.sql
+-- Keep each execution's code when database.store_code is set, for
+-- GET /executions/{id}?include=code. NULL when it wasn't stored.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS code TEXT;
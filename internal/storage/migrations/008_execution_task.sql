@@ -0,0 +1,9 @@
+-- 008_execution_task.sql
+-- Group the executions an agent runs for one task under the request's
+-- task_id, for GET /tasks/{id} and GET /executions?task_id=. NULL when the
+-- request carried none.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS task_id TEXT;
+
+-- Index for a task's executions, scoped to the caller that ran them
+CREATE INDEX IF NOT EXISTS idx_executions_task ON executions (task_id, api_key_hash) WHERE task_id IS NOT NULL;
@@ -0,0 +1,10 @@
+-- 009_identity_baselines.sql
+-- Each caller's execution history as security.anomaly summarizes it, saved
+-- periodically so a restart doesn't put every caller back in cold start.
+-- stats is the server's own JSON; identity is the api_key_hash.
+
+CREATE TABLE IF NOT EXISTS identity_baselines (
+    identity   TEXT PRIMARY KEY,
+    stats      JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+-- 010_execution_version.sql
+-- Record which build of the server ran each execution and the digest its
+-- runtime image resolved to, so a change in behaviour can be traced to a
+-- deploy or an image rebuild. NULL for executions logged before this.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS server_version TEXT;
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS image_digest TEXT;
+
+-- Index for GET /executions?server_version=
+CREATE INDEX IF NOT EXISTS idx_executions_server_version ON executions (server_version, created_at DESC) WHERE server_version IS NOT NULL;
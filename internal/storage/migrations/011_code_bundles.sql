@@ -0,0 +1,19 @@
+-- 011_code_bundles.sql
+-- The code bundle registry (sandbox.bundles.store: database). archive is the
+-- canonical tar whose SHA-256 is the digest; it is checked again on every
+-- read, so a row edited in place is refused rather than run.
+
+CREATE TABLE IF NOT EXISTS code_bundles (
+    digest       TEXT PRIMARY KEY,
+    entrypoint   TEXT NOT NULL,
+    language     TEXT NOT NULL,
+    size_bytes   BIGINT NOT NULL,
+    files        INTEGER NOT NULL,
+    approved     BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    archive      BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_code_bundles_last_used ON code_bundles(last_used_at) WHERE NOT pinned;
@@ -0,0 +1,21 @@
+-- 012_network_connections.sql
+-- The destinations each network-enabled execution connected to, recorded by
+-- sandbox.egress_audit from the host's conntrack table, for looking into a
+-- suspected exfiltration after the fact. One row per destination.
+
+CREATE TABLE IF NOT EXISTS network_connections (
+    id             TEXT PRIMARY KEY,
+    execution_id   TEXT NOT NULL REFERENCES executions(id) ON DELETE CASCADE,
+    dst_ip         TEXT NOT NULL,
+    dst_port       INTEGER NOT NULL DEFAULT 0,
+    protocol       TEXT NOT NULL,
+    connections    INTEGER NOT NULL DEFAULT 1,
+    bytes_estimate BIGINT NOT NULL DEFAULT 0,
+    first_seen     TIMESTAMPTZ NOT NULL
+);
+
+-- Index for querying an execution's connections
+CREATE INDEX IF NOT EXISTS idx_network_connections_execution ON network_connections (execution_id);
+
+-- Index for finding the executions that reached an address
+CREATE INDEX IF NOT EXISTS idx_network_connections_dst ON network_connections (dst_ip, first_seen DESC);
@@ -0,0 +1,5 @@
+-- The cancellation_group an execution ran in, so the executions a
+-- DELETE /cancellation-groups/{name} killed can be found in the audit log.
+-- NULL when the request carried none.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS cancellation_group TEXT;
@@ -0,0 +1,5 @@
+-- The config job an execution was a run of (POST /admin/jobs/{name}/run or
+-- its schedule), so maintenance runs can be told apart from callers'
+-- executions in the audit log. NULL for every other execution.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS job TEXT;
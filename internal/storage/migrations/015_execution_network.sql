@@ -0,0 +1,6 @@
+-- Why each execution had a network or not, as security.network_policy
+-- decided: requested_and_allowed, requested_but_denied_by_policy,
+-- forced_for_claude or not_requested. NULL for executions logged before
+-- this.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS network TEXT;
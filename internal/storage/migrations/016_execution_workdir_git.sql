@@ -0,0 +1,5 @@
+-- The git state of a claude execution's work_dir before it was mounted:
+-- {repo, head, branch, detached, dirty, unchecked}. NULL for executions
+-- without a work_dir, and those logged before this.
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS workdir_git JSONB;
@@ -0,0 +1,4 @@
+-- Security event timelines filter by the identity that ran each execution
+-- over a time range.
+
+CREATE INDEX IF NOT EXISTS idx_executions_api_key_hash ON executions (api_key_hash, created_at DESC);
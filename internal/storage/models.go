@@ -1,24 +1,68 @@
 package storage
 
-import "time"
+import (
+	"time"
+
+	"safe-agent-sandbox/internal/state"
+)
 
 // Execution represents a stored execution record.
 type Execution struct {
-	ID             string    `json:"id" db:"id"`
-	Language       string    `json:"language" db:"language"`
-	CodeHash       string    `json:"code_hash" db:"code_hash"`
-	ExitCode       int       `json:"exit_code" db:"exit_code"`
-	Output         string    `json:"output" db:"output"`
-	Stderr         string    `json:"stderr" db:"stderr"`
-	DurationMS     int64     `json:"duration_ms" db:"duration_ms"`
-	CPUTimeMS      int64     `json:"cpu_time_ms" db:"cpu_time_ms"`
-	MemoryPeakMB   int64     `json:"memory_peak_mb" db:"memory_peak_mb"`
-	SecurityEvents int       `json:"security_events" db:"security_events"`
-	Status         string    `json:"status" db:"status"` // running, completed, timeout, error, killed
-	RequestIP      string    `json:"request_ip" db:"request_ip"`
-	APIKeyHash     string    `json:"api_key_hash,omitempty" db:"api_key_hash"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
-	CompletedAt    *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ID             string                `json:"id" db:"id"`
+	Language       string                `json:"language" db:"language"`
+	CodeHash       string                `json:"code_hash" db:"code_hash"`
+	ExitCode       int                   `json:"exit_code" db:"exit_code"`
+	Output         string                `json:"output" db:"output"`
+	Stderr         string                `json:"stderr" db:"stderr"`
+	DurationMS     int64                 `json:"duration_ms" db:"duration_ms"`
+	CPUTimeMS      int64                 `json:"cpu_time_ms" db:"cpu_time_ms"`
+	MemoryPeakMB   int64                 `json:"memory_peak_mb" db:"memory_peak_mb"`
+	SecurityEvents int                   `json:"security_events" db:"security_events"`
+	Status         state.State           `json:"status" db:"status"` // the state it ended in; see package state
+	RequestIP      string                `json:"request_ip" db:"request_ip"`
+	APIKeyHash     string                `json:"api_key_hash,omitempty" db:"api_key_hash"`
+	Chaos          bool                  `json:"chaos,omitempty" db:"chaos"`                   // synthesized by failure injection
+	SharedMounts   []string              `json:"shared_mounts,omitempty" db:"shared_mounts"`   // sandbox.shared_mounts attached read-only
+	ClaudeOptions  *ClaudeOptions        `json:"claude_options,omitempty" db:"claude_options"` // options a claude session ran with
+	Cost           float64               `json:"cost,omitempty" db:"cost"`                     // charged against security.cost_budget
+	Code           string                `json:"code,omitempty" db:"code"`                     // with database.store_code; read only for ?include=code
+	TaskID         string                `json:"task_id,omitempty" db:"task_id"`               // the agent task the execution is a turn of
+	CancellationGroup string             `json:"cancellation_group,omitempty" db:"cancellation_group"` // the cancellation group it ran in
+	Job            string                `json:"job,omitempty" db:"job"`                       // the config job it was a run of, on POST /admin/jobs/{name}/run or its schedule
+	ServerVersion  string                `json:"server_version,omitempty" db:"server_version"` // build of the server that ran it
+	ImageDigest    string                `json:"image_digest,omitempty" db:"image_digest"`     // what the runtime image resolved to
+	Network        string                `json:"network,omitempty" db:"network"`               // why it had a network or not, as security.network_policy decided
+	WorkdirGit     *WorkdirGit           `json:"workdir_git,omitempty" db:"workdir_git"`       // git state of a claude work_dir before it was mounted
+	Events         []SecurityEventRecord `json:"-" db:"-"`                                     // written to security_events with the execution
+	Connections    []NetworkConnectionRecord `json:"-" db:"-"`                                 // written to network_connections with the execution
+	CreatedAt      time.Time             `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time            `json:"completed_at,omitempty" db:"completed_at"`
+	Source         string                `json:"source,omitempty" db:"-"` // "memory" when served from the records a server keeps in memory, which don't outlive it
+}
+
+// ClaudeOptions records the tool, turn and model restrictions of a claude
+// execution, and the size of its prompt and what it used, as POST /estimate
+// reads them back. Stored as JSONB.
+type ClaudeOptions struct {
+	AllowedTools    []string `json:"allowed_tools,omitempty"`
+	DisallowedTools []string `json:"disallowed_tools,omitempty"`
+	MaxTurns        int      `json:"max_turns,omitempty"`
+	Model           string   `json:"model,omitempty"`
+	PromptBytes     int      `json:"prompt_bytes,omitempty"`
+	Turns           int      `json:"turns,omitempty"` // from claude's result event; 0 if it didn't get that far
+	InputTokens     int64    `json:"input_tokens,omitempty"`
+	OutputTokens    int64    `json:"output_tokens,omitempty"`
+}
+
+// WorkdirGit records the git state of a claude execution's work_dir before
+// it was mounted. Stored as JSONB.
+type WorkdirGit struct {
+	Repo      bool   `json:"repo"`
+	Head      string `json:"head,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	Detached  bool   `json:"detached,omitempty"`
+	Dirty     bool   `json:"dirty"`
+	Unchecked string `json:"unchecked,omitempty"` // why dirty couldn't be determined
 }
 
 // SecurityEventRecord stores security event details for audit.
@@ -26,18 +70,43 @@ type SecurityEventRecord struct {
 	ID          string    `json:"id" db:"id"`
 	ExecutionID string    `json:"execution_id" db:"execution_id"`
 	Type        string    `json:"type" db:"type"`
+	Source      string    `json:"source" db:"source"` // code, output or runtime
 	Severity    string    `json:"severity" db:"severity"`
 	Detail      string    `json:"detail" db:"detail"`
 	Syscall     string    `json:"syscall,omitempty" db:"syscall"`
+	Line        int       `json:"line,omitempty" db:"line"`
+	Count       int       `json:"count" db:"count"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+// NetworkConnectionRecord stores a destination a network-enabled execution
+// connected to, as sandbox.egress_audit recorded it.
+type NetworkConnectionRecord struct {
+	ID            string    `json:"id" db:"id"`
+	ExecutionID   string    `json:"execution_id" db:"execution_id"`
+	DstIP         string    `json:"dst_ip" db:"dst_ip"`
+	DstPort       int       `json:"dst_port" db:"dst_port"`
+	Protocol      string    `json:"protocol" db:"protocol"`
+	Connections   int       `json:"connections" db:"connections"`
+	BytesEstimate int64     `json:"bytes_estimate" db:"bytes_estimate"`
+	FirstSeen     time.Time `json:"first_seen" db:"first_seen"`
+}
+
+// CostRecord is one execution's charge against its caller's hourly budget.
+type CostRecord struct {
+	APIKeyHash string
+	At         time.Time
+	Cost       float64
+}
+
 // ExecutionFilter provides criteria for querying executions.
 type ExecutionFilter struct {
-	Language   string
-	Status     string
-	Since      *time.Time
-	Until      *time.Time
-	Limit      int
-	Offset     int
+	Language      string
+	Status        state.State
+	TaskID        string
+	ServerVersion string
+	Since         *time.Time
+	Until         *time.Time
+	Limit         int
+	Offset        int
 }
@@ -3,14 +3,23 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
+
+	"safe-agent-sandbox/internal/state"
 )
 
+// execer is the part of pgxpool.Pool and pgx.Tx used for inserts.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
 // DB wraps a PostgreSQL connection pool for audit logging.
 type DB struct {
 	pool *pgxpool.Pool
@@ -52,72 +61,176 @@ func (db *DB) Healthy(ctx context.Context) bool {
 	return db.pool.Ping(ctx) == nil
 }
 
+// maxQueryParams is the most parameters Postgres takes in one statement.
+const maxQueryParams = 65535
+
+const (
+	insertExecutions = `INSERT INTO executions (id, language, code_hash, exit_code, output, stderr,
+		duration_ms, cpu_time_ms, memory_peak_mb, security_events, status,
+		request_ip, api_key_hash, created_at, completed_at, chaos, shared_mounts, claude_options, cost, code, task_id,
+		server_version, image_digest, cancellation_group, job, network, workdir_git)`
+	insertSecurityEvents     = `INSERT INTO security_events (id, execution_id, type, source, severity, detail, syscall, line, count, created_at)`
+	insertNetworkConnections = `INSERT INTO network_connections (id, execution_id, dst_ip, dst_port, protocol, connections, bytes_estimate, first_seen)`
+)
+
 // LogExecution inserts an execution record into the audit log.
 func (db *DB) LogExecution(ctx context.Context, exec *Execution) error {
-	query := `
-		INSERT INTO executions (id, language, code_hash, exit_code, output, stderr,
-			duration_ms, cpu_time_ms, memory_peak_mb, security_events, status,
-			request_ip, api_key_hash, created_at, completed_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	return db.LogExecutions(ctx, []*Execution{exec})
+}
+
+// LogExecutions inserts execution records and their security events in one
+// transaction, each table with multi-row INSERTs in the order given. On
+// error none of them are written.
+func (db *DB) LogExecutions(ctx context.Context, execs []*Execution) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning audit transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := db.pool.Exec(ctx, query,
+	// The events go in the same transaction so a retried write can't leave
+	// events behind without their execution, or insert them twice.
+	rows := make([][]any, len(execs))
+	var events, connections [][]any
+	for i, exec := range execs {
+		rows[i] = executionArgs(exec)
+		for j := range exec.Events {
+			events = append(events, securityEventArgs(&exec.Events[j]))
+		}
+		for j := range exec.Connections {
+			connections = append(connections, networkConnectionArgs(&exec.Connections[j]))
+		}
+	}
+	if err := insertRows(ctx, tx, insertExecutions, rows); err != nil {
+		return fmt.Errorf("inserting executions: %w", err)
+	}
+	if err := insertRows(ctx, tx, insertSecurityEvents, events); err != nil {
+		return fmt.Errorf("inserting security events: %w", err)
+	}
+	if err := insertRows(ctx, tx, insertNetworkConnections, connections); err != nil {
+		return fmt.Errorf("inserting network connections: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing audit transaction: %w", err)
+	}
+	return nil
+}
+
+// insertRows runs prefix, an INSERT up to its VALUES, with one VALUES row
+// per entry of rows, in order. Rows go in as few statements as the
+// parameter limit allows.
+func insertRows(ctx context.Context, q execer, prefix string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	perStatement := maxQueryParams / len(rows[0])
+	for start := 0; start < len(rows); start += perStatement {
+		chunk := rows[start:min(start+perStatement, len(rows))]
+		var query strings.Builder
+		query.WriteString(prefix)
+		query.WriteString("\n\t\tVALUES ")
+		args := make([]any, 0, len(chunk)*len(chunk[0]))
+		for i, row := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteByte('(')
+			for j := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteByte(')')
+			args = append(args, row...)
+		}
+		if _, err := q.Exec(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executionArgs is exec's row of insertExecutions.
+func executionArgs(exec *Execution) []any {
+	return []any{
 		exec.ID, exec.Language, exec.CodeHash, exec.ExitCode,
 		truncateForDB(exec.Output, 65535),
 		truncateForDB(exec.Stderr, 65535),
 		exec.DurationMS, exec.CPUTimeMS, exec.MemoryPeakMB,
-		exec.SecurityEvents, exec.Status,
+		exec.SecurityEvents, string(exec.Status),
 		exec.RequestIP, exec.APIKeyHash,
-		exec.CreatedAt, exec.CompletedAt,
-	)
-	if err != nil {
-		return fmt.Errorf("inserting execution: %w", err)
+		exec.CreatedAt, exec.CompletedAt, exec.Chaos, sharedMountsColumn(exec.SharedMounts),
+		exec.ClaudeOptions, exec.Cost, nullableText(exec.Code), nullableText(exec.TaskID),
+		nullableText(exec.ServerVersion), nullableText(exec.ImageDigest), nullableText(exec.CancellationGroup),
+		nullableText(exec.Job), nullableText(exec.Network), exec.WorkdirGit,
 	}
-	return nil
 }
 
-// LogSecurityEvent inserts a security event record.
-func (db *DB) LogSecurityEvent(ctx context.Context, event *SecurityEventRecord) error {
+// securityEventArgs is event's row of insertSecurityEvents, giving it an ID
+// and time if it has none.
+func securityEventArgs(event *SecurityEventRecord) []any {
 	if event.ID == "" {
 		event.ID = uuid.New().String()
 	}
 	if event.CreatedAt.IsZero() {
 		event.CreatedAt = time.Now()
 	}
+	return []any{
+		event.ID, event.ExecutionID, event.Type, event.Source, event.Severity,
+		event.Detail, event.Syscall, event.Line, event.Count, event.CreatedAt,
+	}
+}
 
-	query := `
-		INSERT INTO security_events (id, execution_id, type, severity, detail, syscall, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+// networkConnectionArgs is conn's row of insertNetworkConnections, giving
+// it an ID if it has none.
+func networkConnectionArgs(conn *NetworkConnectionRecord) []any {
+	if conn.ID == "" {
+		conn.ID = uuid.New().String()
+	}
+	return []any{
+		conn.ID, conn.ExecutionID, conn.DstIP, conn.DstPort, conn.Protocol,
+		conn.Connections, conn.BytesEstimate, conn.FirstSeen,
+	}
+}
 
-	_, err := db.pool.Exec(ctx, query,
-		event.ID, event.ExecutionID, event.Type, event.Severity,
-		event.Detail, event.Syscall, event.CreatedAt,
-	)
-	if err != nil {
+// LogSecurityEvent inserts a security event record.
+func (db *DB) LogSecurityEvent(ctx context.Context, event *SecurityEventRecord) error {
+	if err := insertRows(ctx, db.pool, insertSecurityEvents, [][]any{securityEventArgs(event)}); err != nil {
 		return fmt.Errorf("inserting security event: %w", err)
 	}
 	return nil
 }
 
-// GetExecution retrieves a single execution by ID.
-func (db *DB) GetExecution(ctx context.Context, id string) (*Execution, error) {
+// GetExecution retrieves a single execution by ID. Its code, if stored, is
+// only read with withCode.
+func (db *DB) GetExecution(ctx context.Context, id string, withCode bool) (*Execution, error) {
 	query := `
 		SELECT id, language, code_hash, exit_code, output, stderr,
 			duration_ms, cpu_time_ms, memory_peak_mb, security_events, status,
-			request_ip, api_key_hash, created_at, completed_at
+			request_ip, api_key_hash, created_at, completed_at, chaos, shared_mounts, claude_options,
+			CASE WHEN $2 THEN COALESCE(code, '') ELSE '' END, COALESCE(task_id, ''),
+			COALESCE(server_version, ''), COALESCE(image_digest, ''), COALESCE(cancellation_group, ''),
+			COALESCE(job, ''), COALESCE(network, ''), workdir_git
 		FROM executions WHERE id = $1`
 
 	var exec Execution
-	err := db.pool.QueryRow(ctx, query, id).Scan(
+	var status string
+	err := db.pool.QueryRow(ctx, query, id, withCode).Scan(
 		&exec.ID, &exec.Language, &exec.CodeHash, &exec.ExitCode,
 		&exec.Output, &exec.Stderr,
 		&exec.DurationMS, &exec.CPUTimeMS, &exec.MemoryPeakMB,
-		&exec.SecurityEvents, &exec.Status,
+		&exec.SecurityEvents, &status,
 		&exec.RequestIP, &exec.APIKeyHash,
-		&exec.CreatedAt, &exec.CompletedAt,
+		&exec.CreatedAt, &exec.CompletedAt, &exec.Chaos, &exec.SharedMounts,
+		&exec.ClaudeOptions, &exec.Code, &exec.TaskID,
+		&exec.ServerVersion, &exec.ImageDigest, &exec.CancellationGroup,
+		&exec.Job, &exec.Network, &exec.WorkdirGit,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying execution %s: %w", id, err)
 	}
+	exec.Status, _ = state.Parse(status)
 	return &exec, nil
 }
 
@@ -125,10 +238,13 @@ func (db *DB) GetExecution(ctx context.Context, id string) (*Execution, error) {
 func (db *DB) ListExecutions(ctx context.Context, filter ExecutionFilter) ([]Execution, error) {
 	query := `
 		SELECT id, language, code_hash, exit_code, duration_ms,
-			security_events, status, created_at, completed_at
+			security_events, status, created_at, completed_at, COALESCE(task_id, ''),
+			COALESCE(server_version, ''), COALESCE(image_digest, '')
 		FROM executions
 		WHERE ($1 = '' OR language = $1)
-		  AND ($2 = '' OR status = $2)
+		  AND ($2::text[] IS NULL OR status = ANY($2))
+		  AND ($5 = '' OR task_id = $5)
+		  AND ($6 = '' OR server_version = $6)
 		ORDER BY created_at DESC
 		LIMIT $3 OFFSET $4`
 
@@ -137,8 +253,13 @@ func (db *DB) ListExecutions(ctx context.Context, filter ExecutionFilter) ([]Exe
 		limit = 100
 	}
 
+	// Rows logged before the states existed hold the status it replaced.
+	var statuses []string
+	if filter.Status != "" {
+		statuses = state.Aliases(filter.Status)
+	}
 	rows, err := db.pool.Query(ctx, query,
-		filter.Language, filter.Status, limit, filter.Offset,
+		filter.Language, statuses, limit, filter.Offset, filter.TaskID, filter.ServerVersion,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying executions: %w", err)
@@ -148,19 +269,46 @@ func (db *DB) ListExecutions(ctx context.Context, filter ExecutionFilter) ([]Exe
 	var results []Execution
 	for rows.Next() {
 		var exec Execution
+		var status string
 		if err := rows.Scan(
 			&exec.ID, &exec.Language, &exec.CodeHash, &exec.ExitCode,
-			&exec.DurationMS, &exec.SecurityEvents, &exec.Status,
-			&exec.CreatedAt, &exec.CompletedAt,
+			&exec.DurationMS, &exec.SecurityEvents, &status,
+			&exec.CreatedAt, &exec.CompletedAt, &exec.TaskID,
+			&exec.ServerVersion, &exec.ImageDigest,
 		); err != nil {
 			return nil, fmt.Errorf("scanning execution row: %w", err)
 		}
+		exec.Status, _ = state.Parse(status)
 		results = append(results, exec)
 	}
 
 	return results, rows.Err()
 }
 
+// RecentCosts returns the charges of executions created since since, oldest
+// first, to rebuild cost budgets after a restart.
+func (db *DB) RecentCosts(ctx context.Context, since time.Time) ([]CostRecord, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT api_key_hash, created_at, cost
+		FROM executions
+		WHERE created_at >= $1 AND cost > 0
+		ORDER BY created_at`, since)
+	if err != nil {
+		return nil, fmt.Errorf("querying recent costs: %w", err)
+	}
+	defer rows.Close()
+
+	var records []CostRecord
+	for rows.Next() {
+		var rec CostRecord
+		if err := rows.Scan(&rec.APIKeyHash, &rec.At, &rec.Cost); err != nil {
+			return nil, fmt.Errorf("scanning cost row: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
 func truncateForDB(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -177,3 +325,20 @@ func truncateForDB(s string, maxLen int) string {
 	}
 	return t
 }
+
+// sharedMountsColumn maps nil to an empty array for the NOT NULL column.
+func sharedMountsColumn(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
+
+// nullableText maps an empty string, code that wasn't stored or a request
+// without a task_id, to NULL.
+func nullableText(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
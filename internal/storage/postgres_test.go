@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// captureExecer records the statements it is given.
+type captureExecer struct {
+	queries []string
+	args    [][]any
+}
+
+func (c *captureExecer) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	c.queries = append(c.queries, sql)
+	c.args = append(c.args, args)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestInsertRows(t *testing.T) {
+	var q captureExecer
+	rows := [][]any{{"a", 1}, {"b", 2}, {"c", 3}}
+	if err := insertRows(context.Background(), &q, "INSERT INTO t (x, y)", rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 1 || !strings.HasSuffix(q.queries[0], "VALUES ($1, $2), ($3, $4), ($5, $6)") {
+		t.Errorf("queries = %q", q.queries)
+	}
+	if want := []any{"a", 1, "b", 2, "c", 3}; !reflect.DeepEqual(q.args[0], want) {
+		t.Errorf("args = %v, want the rows in order", q.args[0])
+	}
+
+	// Rows past the parameter limit go in a second statement.
+	q = captureExecer{}
+	rows = make([][]any, maxQueryParams/len(executionArgs(&Execution{}))+1)
+	for i := range rows {
+		rows[i] = executionArgs(&Execution{})
+	}
+	if err := insertRows(context.Background(), &q, insertExecutions, rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 2 || len(q.args[1]) != len(executionArgs(&Execution{})) {
+		t.Errorf("%d statements, the last with %d args", len(q.queries), len(q.args[len(q.args)-1]))
+	}
+	for _, args := range q.args {
+		if len(args) > maxQueryParams {
+			t.Errorf("statement with %d parameters", len(args))
+		}
+	}
+
+	q = captureExecer{}
+	if err := insertRows(context.Background(), &q, insertSecurityEvents, nil); err != nil || len(q.queries) != 0 {
+		t.Errorf("no rows: %d statements, %v", len(q.queries), err)
+	}
+}
+
+func TestExecutionArgs_MatchColumns(t *testing.T) {
+	cols := insertExecutions[strings.Index(insertExecutions, "(")+1 : strings.LastIndex(insertExecutions, ")")]
+	if n, args := strings.Count(cols, ",")+1, executionArgs(&Execution{}); len(args) != n {
+		t.Errorf("executionArgs gives %d values for %d columns", len(args), n)
+	}
+	args := executionArgs(&Execution{ServerVersion: "v1.4.0", ImageDigest: "sha256:abc", CancellationGroup: "run-7", Job: "nightly", Network: "forced_for_claude"})
+	if v, ok := args[len(args)-6].(*string); !ok || v == nil || *v != "v1.4.0" {
+		t.Errorf("server_version arg = %v", args[len(args)-6])
+	}
+	if v, ok := executionArgs(&Execution{})[len(args)-5].(*string); !ok || v != nil {
+		t.Errorf("empty image_digest arg = %v, want NULL", v)
+	}
+	if v, ok := args[len(args)-4].(*string); !ok || v == nil || *v != "run-7" {
+		t.Errorf("cancellation_group arg = %v", args[len(args)-4])
+	}
+	if v, ok := args[len(args)-3].(*string); !ok || v == nil || *v != "nightly" {
+		t.Errorf("job arg = %v", args[len(args)-3])
+	}
+	if v, ok := args[len(args)-2].(*string); !ok || v == nil || *v != "forced_for_claude" {
+		t.Errorf("network arg = %v", args[len(args)-2])
+	}
+	if v, ok := args[len(args)-1].(*WorkdirGit); !ok || v != nil {
+		t.Errorf("empty workdir_git arg = %v, want NULL", args[len(args)-1])
+	}
+}
+
+func TestDBExecutionVersion(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ver := "test-" + uuid.NewString()
+	stamped := &Execution{ID: uuid.NewString(), Language: "python", CodeHash: "seed", Status: state.Completed,
+		CreatedAt: time.Now(), ServerVersion: ver, ImageDigest: "sha256:0123"}
+	unstamped := &Execution{ID: uuid.NewString(), Language: "python", CodeHash: "seed", Status: state.Completed,
+		CreatedAt: time.Now()}
+	if err := db.LogExecutions(ctx, []*Execution{stamped, unstamped}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetExecution(ctx, stamped.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ServerVersion != ver || got.ImageDigest != "sha256:0123" {
+		t.Errorf("got server_version %q, image_digest %q", got.ServerVersion, got.ImageDigest)
+	}
+	if got, err := db.GetExecution(ctx, unstamped.ID, false); err != nil || got.ServerVersion != "" || got.ImageDigest != "" {
+		t.Errorf("unstamped execution: %+v, %v", got, err)
+	}
+
+	execs, err := db.ListExecutions(ctx, ExecutionFilter{ServerVersion: ver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execs) != 1 || execs[0].ID != stamped.ID || execs[0].ImageDigest != "sha256:0123" {
+		t.Errorf("?server_version= listed %+v, want only the stamped execution", execs)
+	}
+}
+
+// TestDBLegacyStatus reads back a row written with a status from before
+// the states, as rows already in the table were.
+func TestDBLegacyStatus(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	task := "legacy-" + uuid.NewString()
+	old := &Execution{ID: uuid.NewString(), Language: "python", CodeHash: "seed", Status: "success",
+		CreatedAt: time.Now(), TaskID: task}
+	if err := db.LogExecutions(ctx, []*Execution{old}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.GetExecution(ctx, old.ID, false); err != nil || got.Status != state.Completed {
+		t.Errorf("GetExecution: %+v, %v; want status completed", got, err)
+	}
+	execs, err := db.ListExecutions(ctx, ExecutionFilter{Status: state.Completed, TaskID: task})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execs) != 1 || execs[0].ID != old.ID || execs[0].Status != state.Completed {
+		t.Errorf("?status=completed listed %+v, want the success row", execs)
+	}
+}
+
+func TestTruncateForDB(t *testing.T) {
+	// The audit log keeps a prefix of the output the response and the
+	// stream carried, cut on a rune boundary like the output caps.
+	out := strings.Repeat("€", 10) // 3 bytes each
+	for n := 0; n <= len(out)+1; n++ {
+		got := truncateForDB(out, n)
+		if !strings.HasPrefix(out, got) || !utf8.ValidString(got) || len(got) > n || len(got) < n-2 {
+			t.Errorf("truncateForDB(%d) = %q", n, got)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxSecurityEventRange caps how far apart a security event query's from and
+// to may be.
+const MaxSecurityEventRange = 31 * 24 * time.Hour
+
+// Security event buckets: the width of each point of a timeline.
+const (
+	BucketHour = "1h"
+	BucketDay  = "1d"
+)
+
+// bucketUnits are the date_trunc field and the width of each bucket.
+var bucketUnits = map[string]struct {
+	field string
+	width time.Duration
+}{
+	BucketHour: {"hour", time.Hour},
+	BucketDay:  {"day", 24 * time.Hour},
+}
+
+// Severities are the security event severities, least severe first. Events
+// without one, such as timeouts, rank below them all.
+var Severities = []string{"low", "medium", "high", "critical"}
+
+// SeverityUnrated is how a timeline counts events without a severity.
+const SeverityUnrated = "unrated"
+
+// severityRank is s's place in Severities, or -1.
+func severityRank(s string) int {
+	return slices.Index(Severities, s)
+}
+
+// MaxSecurityEventTop caps a timeline's top offenders.
+const MaxSecurityEventTop = 100
+
+// SecurityEventQuery selects security events created in [From, To). Without
+// a Bucket it lists them, newest first, up to Limit; with one it counts
+// them per bucket and severity, with the TopN code hashes and languages
+// that raised the most.
+type SecurityEventQuery struct {
+	From        time.Time
+	To          time.Time
+	APIKeyHash  string // identity of the caller that ran the execution; "" for all
+	MinSeverity string // one of Severities; "" includes unrated events too
+	Type        string
+	Bucket      string
+	Limit       int
+	TopN        int
+}
+
+// Validate checks the filters and the range.
+func (q SecurityEventQuery) Validate() error {
+	if q.MinSeverity != "" && severityRank(q.MinSeverity) < 0 {
+		return fmt.Errorf("severity must be one of %s", strings.Join(Severities, ", "))
+	}
+	if _, ok := bucketUnits[q.Bucket]; q.Bucket != "" && !ok {
+		return fmt.Errorf("bucket must be %s or %s", BucketHour, BucketDay)
+	}
+	if q.TopN < 0 || q.TopN > MaxSecurityEventTop {
+		return fmt.Errorf("top must be between 0 and %d", MaxSecurityEventTop)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if q.To.Sub(q.From) > MaxSecurityEventRange {
+		return fmt.Errorf("range exceeds %d days", int(MaxSecurityEventRange.Hours()/24))
+	}
+	return nil
+}
+
+// severities is the severities q matches, or nil for all of them.
+func (q SecurityEventQuery) severities() []string {
+	if q.MinSeverity == "" {
+		return nil
+	}
+	return Severities[severityRank(q.MinSeverity):]
+}
+
+// matches reports whether an event of e at is selected by q, bar the
+// identity.
+func (q SecurityEventQuery) matches(e SecurityEventRecord, at time.Time) bool {
+	return !at.Before(q.From) && at.Before(q.To) &&
+		(q.Type == "" || e.Type == q.Type) &&
+		(q.MinSeverity == "" || severityRank(e.Severity) >= severityRank(q.MinSeverity))
+}
+
+// SecurityEventEntry is a security event with the execution that raised it.
+type SecurityEventEntry struct {
+	SecurityEventRecord
+	APIKeyHash string `json:"api_key_hash"`
+	Language   string `json:"language"`
+	CodeHash   string `json:"code_hash"`
+}
+
+// SecurityEventList is the result of a SecurityEventQuery without a Bucket.
+// Partial marks a list read from the in-memory record of recent executions
+// rather than the database.
+type SecurityEventList struct {
+	From        time.Time            `json:"from"`
+	To          time.Time            `json:"to"`
+	Events      []SecurityEventEntry `json:"events"`
+	Partial     bool                 `json:"partial"`
+	GeneratedAt time.Time            `json:"generated_at"`
+}
+
+// SeverityCount is the events of one severity.
+type SeverityCount struct {
+	Severity string `json:"severity"`
+	Events   int64  `json:"events"`
+}
+
+// SecurityEventBucket counts the events in [Start, Start+bucket), by
+// severity, most severe first. Severities without events are left out.
+type SecurityEventBucket struct {
+	Start      time.Time       `json:"start"`
+	Events     int64           `json:"events"`
+	BySeverity []SeverityCount `json:"by_severity"`
+}
+
+// SecurityEventOffender is a code hash or language and the events raised
+// by its executions.
+type SecurityEventOffender struct {
+	Key    string `json:"key"`
+	Events int64  `json:"events"`
+}
+
+// SecurityEventTimeline is the result of a SecurityEventQuery with a
+// Bucket. Every bucket from the one holding From to the one before To is
+// there, empty or not; buckets are cut in UTC. Partial is as for
+// SecurityEventList.
+type SecurityEventTimeline struct {
+	From          time.Time               `json:"from"`
+	To            time.Time               `json:"to"`
+	Bucket        string                  `json:"bucket"`
+	Buckets       []SecurityEventBucket   `json:"buckets"`
+	Totals        []SeverityCount         `json:"totals"`
+	TopCodeHashes []SecurityEventOffender `json:"top_code_hashes"`
+	TopLanguages  []SecurityEventOffender `json:"top_languages"`
+	Partial       bool                    `json:"partial"`
+	GeneratedAt   time.Time               `json:"generated_at"`
+}
+
+// securityEventFilter is the FROM and WHERE of the security event queries,
+// whose first five parameters are from, to, api_key_hash, type and the
+// severities.
+const securityEventFilter = `
+		FROM security_events e
+		JOIN executions x ON x.id = e.execution_id
+		WHERE e.created_at >= $1 AND e.created_at < $2
+		  AND ($3 = '' OR x.api_key_hash = $3)
+		  AND ($4 = '' OR e.type = $4)
+		  AND ($5::text[] IS NULL OR e.severity = ANY($5))`
+
+func (q SecurityEventQuery) filterArgs() []any {
+	return []any{q.From, q.To, q.APIKeyHash, q.Type, q.severities()}
+}
+
+// ListSecurityEvents reads the events q selects, newest first.
+func (db *DB) ListSecurityEvents(ctx context.Context, q SecurityEventQuery) (*SecurityEventList, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	rows, err := db.pool.Query(ctx, `
+		SELECT e.id, e.execution_id, e.type, e.source, e.severity, e.detail, e.syscall,
+			e.line, e.count, e.created_at, x.api_key_hash, x.language, x.code_hash`+
+		securityEventFilter+`
+		ORDER BY e.created_at DESC, e.id
+		LIMIT $6`, append(q.filterArgs(), eventLimit(q.Limit))...)
+	if err != nil {
+		return nil, fmt.Errorf("querying security events: %w", err)
+	}
+	defer rows.Close()
+
+	list := &SecurityEventList{From: q.From, To: q.To, Events: []SecurityEventEntry{}, GeneratedAt: time.Now().UTC()}
+	for rows.Next() {
+		var e SecurityEventEntry
+		if err := rows.Scan(&e.ID, &e.ExecutionID, &e.Type, &e.Source, &e.Severity, &e.Detail, &e.Syscall,
+			&e.Line, &e.Count, &e.CreatedAt, &e.APIKeyHash, &e.Language, &e.CodeHash); err != nil {
+			return nil, fmt.Errorf("scanning security event row: %w", err)
+		}
+		list.Events = append(list.Events, e)
+	}
+	return list, rows.Err()
+}
+
+// SecurityEventTimeline counts the events q selects per bucket and
+// severity in one grouped scan, and the top offenders in one more each.
+func (db *DB) SecurityEventTimeline(ctx context.Context, q SecurityEventQuery) (*SecurityEventTimeline, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	args := q.filterArgs()
+	rows, err := db.pool.Query(ctx, `
+		SELECT date_trunc($6, e.created_at AT TIME ZONE 'UTC') AS bucket, e.severity, COUNT(*)`+
+		securityEventFilter+`
+		GROUP BY 1, 2`, append(args, bucketUnits[q.Bucket].field)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying security event timeline: %w", err)
+	}
+	defer rows.Close()
+
+	t := newTimeline(q)
+	for rows.Next() {
+		var start time.Time
+		var severity string
+		var n int64
+		if err := rows.Scan(&start, &severity, &n); err != nil {
+			return nil, fmt.Errorf("scanning security event timeline row: %w", err)
+		}
+		t.add(start, severity, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, top := range []struct {
+		column string
+		into   *[]SecurityEventOffender
+	}{{"x.code_hash", &t.TopCodeHashes}, {"x.language", &t.TopLanguages}} {
+		if *top.into, err = db.topOffenders(ctx, top.column, args, q.TopN); err != nil {
+			return nil, err
+		}
+	}
+	return t.finish(), nil
+}
+
+// topOffenders is the n values of column whose executions raised the most
+// of the events args select, ties broken by value.
+func (db *DB) topOffenders(ctx context.Context, column string, args []any, n int) ([]SecurityEventOffender, error) {
+	top := []SecurityEventOffender{}
+	if n == 0 {
+		return top, nil
+	}
+	rows, err := db.pool.Query(ctx, `
+		SELECT `+column+`, COUNT(*)`+
+		securityEventFilter+`
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+		LIMIT $6`, append(slices.Clone(args), n)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying top offenders by %s: %w", column, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o SecurityEventOffender
+		if err := rows.Scan(&o.Key, &o.Events); err != nil {
+			return nil, fmt.Errorf("scanning top offender row: %w", err)
+		}
+		top = append(top, o)
+	}
+	return top, rows.Err()
+}
+
+// ListRecentSecurityEvents lists the events of execs that q selects, as
+// DB.ListSecurityEvents does, for when there is no database. An event
+// without a time of its own, not having been written yet, takes its
+// execution's completion.
+func ListRecentSecurityEvents(execs []Execution, q SecurityEventQuery) (*SecurityEventList, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	list := &SecurityEventList{From: q.From, To: q.To, Events: []SecurityEventEntry{}, GeneratedAt: time.Now().UTC()}
+	eachSecurityEvent(execs, q, func(x Execution, e SecurityEventRecord) {
+		list.Events = append(list.Events, SecurityEventEntry{SecurityEventRecord: e, APIKeyHash: x.APIKeyHash, Language: x.Language, CodeHash: x.CodeHash})
+	})
+	slices.SortStableFunc(list.Events, func(a, b SecurityEventEntry) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	if limit := eventLimit(q.Limit); len(list.Events) > limit {
+		list.Events = list.Events[:limit]
+	}
+	return list, nil
+}
+
+// AggregateSecurityEvents computes the same timeline as
+// DB.SecurityEventTimeline over the events of execs.
+func AggregateSecurityEvents(execs []Execution, q SecurityEventQuery) (*SecurityEventTimeline, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	t := newTimeline(q)
+	width := bucketUnits[q.Bucket].width
+	byCode, byLanguage := make(map[string]int64), make(map[string]int64)
+	eachSecurityEvent(execs, q, func(x Execution, e SecurityEventRecord) {
+		t.add(e.CreatedAt.UTC().Truncate(width), e.Severity, 1)
+		byCode[x.CodeHash]++
+		byLanguage[x.Language]++
+	})
+	t.TopCodeHashes, t.TopLanguages = topOffenders(byCode, q.TopN), topOffenders(byLanguage, q.TopN)
+	return t.finish(), nil
+}
+
+// eachSecurityEvent calls fn with each event of execs that q selects.
+func eachSecurityEvent(execs []Execution, q SecurityEventQuery, fn func(Execution, SecurityEventRecord)) {
+	for _, x := range execs {
+		if q.APIKeyHash != "" && x.APIKeyHash != q.APIKeyHash {
+			continue
+		}
+		for _, e := range x.Events {
+			if e.CreatedAt.IsZero() {
+				e.CreatedAt = x.CreatedAt
+				if x.CompletedAt != nil {
+					e.CreatedAt = *x.CompletedAt
+				}
+			}
+			if q.matches(e, e.CreatedAt) {
+				fn(x, e)
+			}
+		}
+	}
+}
+
+func topOffenders(counts map[string]int64, n int) []SecurityEventOffender {
+	top := make([]SecurityEventOffender, 0, len(counts))
+	for k, v := range counts {
+		top = append(top, SecurityEventOffender{Key: k, Events: v})
+	}
+	slices.SortFunc(top, func(a, b SecurityEventOffender) int {
+		return cmp.Or(cmp.Compare(b.Events, a.Events), strings.Compare(a.Key, b.Key))
+	})
+	return top[:min(n, len(top))]
+}
+
+// eventLimit is the events a list holds: 100 by default, 1000 at most.
+func eventLimit(limit int) int {
+	if limit <= 0 || limit > 1000 {
+		return 100
+	}
+	return limit
+}
+
+// timeline accumulates a SecurityEventTimeline's counts.
+type timeline struct {
+	*SecurityEventTimeline
+	first  time.Time
+	width  time.Duration
+	counts map[string]int64 // totals by severity
+}
+
+// newTimeline lays out q's empty buckets. Days and hours in UTC start on
+// multiples of their width since the Unix epoch, so Truncate finds them.
+func newTimeline(q SecurityEventQuery) *timeline {
+	width := bucketUnits[q.Bucket].width
+	t := &timeline{
+		SecurityEventTimeline: &SecurityEventTimeline{From: q.From, To: q.To, Bucket: q.Bucket, GeneratedAt: time.Now().UTC()},
+		first:                 q.From.UTC().Truncate(width),
+		width:                 width,
+		counts:                make(map[string]int64),
+	}
+	for start := t.first; start.Before(q.To); start = start.Add(width) {
+		t.Buckets = append(t.Buckets, SecurityEventBucket{Start: start, BySeverity: []SeverityCount{}})
+	}
+	return t
+}
+
+// add counts n events of severity in the bucket starting at start.
+func (t *timeline) add(start time.Time, severity string, n int64) {
+	i := int(start.UTC().Sub(t.first) / t.width)
+	if i < 0 || i >= len(t.Buckets) {
+		return
+	}
+	if severityRank(severity) < 0 {
+		severity = SeverityUnrated
+	}
+	b := &t.Buckets[i]
+	b.Events += n
+	b.BySeverity = addSeverity(b.BySeverity, severity, n)
+	t.counts[severity] += n
+}
+
+// finish orders each count by severity.
+func (t *timeline) finish() *SecurityEventTimeline {
+	t.Totals = []SeverityCount{}
+	for severity, n := range t.counts {
+		t.Totals = addSeverity(t.Totals, severity, n)
+	}
+	if t.TopCodeHashes == nil {
+		t.TopCodeHashes = []SecurityEventOffender{}
+	}
+	if t.TopLanguages == nil {
+		t.TopLanguages = []SecurityEventOffender{}
+	}
+	return t.SecurityEventTimeline
+}
+
+// addSeverity adds n events of severity to counts, kept most severe first.
+func addSeverity(counts []SeverityCount, severity string, n int64) []SeverityCount {
+	i, found := slices.BinarySearchFunc(counts, severity, func(c SeverityCount, s string) int {
+		return cmp.Compare(severityRank(s), severityRank(c.Severity))
+	})
+	if found {
+		counts[i].Events += n
+		return counts
+	}
+	return slices.Insert(counts, i, SeverityCount{Severity: severity, Events: n})
+}
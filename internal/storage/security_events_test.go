@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// securityEventSeed is three executions' events around the boundary of two
+// UTC hours. The -05:00 times check that buckets are cut in UTC: 05:59 local
+// is 10:59 UTC.
+func securityEventSeed() []Execution {
+	est := time.FixedZone("EST", -5*3600)
+	at := func(hour, minute int, loc *time.Location) time.Time {
+		return time.Date(2026, 3, 1, hour, minute, 0, 0, loc)
+	}
+	event := func(typ, severity string, t time.Time) SecurityEventRecord {
+		return SecurityEventRecord{Type: typ, Source: "runtime", Severity: severity, Detail: typ, Count: 1, CreatedAt: t}
+	}
+	return []Execution{
+		{Language: "python", CodeHash: "aaa", APIKeyHash: "key-a", CreatedAt: at(10, 0, time.UTC), Events: []SecurityEventRecord{
+			event("secret_access", "high", at(10, 0, time.UTC)),
+			event("root_access", "critical", at(5, 59, est)), // 10:59 UTC
+			event("timeout", "", at(10, 30, time.UTC)),
+		}},
+		{Language: "python", CodeHash: "bbb", APIKeyHash: "key-a", CreatedAt: at(11, 0, time.UTC), Events: []SecurityEventRecord{
+			event("secret_access", "high", at(11, 0, time.UTC)),
+			event("host_info_leak", "medium", at(6, 15, est)), // 11:15 UTC
+			event("secret_access", "low", at(11, 59, time.UTC)),
+		}},
+		{Language: "bash", CodeHash: "ccc", APIKeyHash: "key-b", CreatedAt: at(11, 0, time.UTC), Events: []SecurityEventRecord{
+			event("secret_access", "critical", at(11, 30, time.UTC)),
+			// Outside the range: never counted.
+			event("secret_access", "critical", at(12, 0, time.UTC)),
+			event("secret_access", "critical", at(9, 59, time.UTC)),
+		}},
+	}
+}
+
+func securityEventSeedQuery() SecurityEventQuery {
+	return SecurityEventQuery{
+		From:   time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		To:     time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Bucket: BucketHour,
+		TopN:   2,
+	}
+}
+
+func checkTimeline(t *testing.T, got *SecurityEventTimeline) {
+	t.Helper()
+	ten := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	wantBuckets := []SecurityEventBucket{
+		{Start: ten, Events: 3, BySeverity: []SeverityCount{{"critical", 1}, {"high", 1}, {SeverityUnrated, 1}}},
+		{Start: ten.Add(time.Hour), Events: 4, BySeverity: []SeverityCount{{"critical", 1}, {"high", 1}, {"medium", 1}, {"low", 1}}},
+	}
+	if len(got.Buckets) != len(wantBuckets) {
+		t.Fatalf("got %d buckets %+v, want %d", len(got.Buckets), got.Buckets, len(wantBuckets))
+	}
+	for i, want := range wantBuckets {
+		b := got.Buckets[i]
+		if !b.Start.Equal(want.Start) || b.Events != want.Events || !reflect.DeepEqual(b.BySeverity, want.BySeverity) {
+			t.Errorf("bucket %d:\n got %+v\nwant %+v", i, b, want)
+		}
+	}
+	wantTotals := []SeverityCount{{"critical", 2}, {"high", 2}, {"medium", 1}, {"low", 1}, {SeverityUnrated, 1}}
+	if !reflect.DeepEqual(got.Totals, wantTotals) {
+		t.Errorf("totals = %+v, want %+v", got.Totals, wantTotals)
+	}
+	// aaa and bbb tie on 3 events; the tie goes to the lower hash.
+	if want := []SecurityEventOffender{{"aaa", 3}, {"bbb", 3}}; !reflect.DeepEqual(got.TopCodeHashes, want) {
+		t.Errorf("top code hashes = %+v, want %+v", got.TopCodeHashes, want)
+	}
+	if want := []SecurityEventOffender{{"python", 6}, {"bash", 1}}; !reflect.DeepEqual(got.TopLanguages, want) {
+		t.Errorf("top languages = %+v, want %+v", got.TopLanguages, want)
+	}
+}
+
+func TestAggregateSecurityEvents(t *testing.T) {
+	got, err := AggregateSecurityEvents(securityEventSeed(), securityEventSeedQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTimeline(t, got)
+}
+
+func TestAggregateSecurityEvents_Filters(t *testing.T) {
+	q := securityEventSeedQuery()
+	q.APIKeyHash, q.MinSeverity = "key-a", "high"
+	got, err := AggregateSecurityEvents(securityEventSeed(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SeverityCount{{"critical", 1}, {"high", 2}}; !reflect.DeepEqual(got.Totals, want) {
+		t.Errorf("key-a, high and up: totals = %+v, want %+v", got.Totals, want)
+	}
+
+	q = securityEventSeedQuery()
+	q.Type, q.Bucket, q.TopN = "root_access", BucketDay, 0
+	got, err = AggregateSecurityEvents(securityEventSeed(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One day bucket, starting at midnight before from.
+	if len(got.Buckets) != 1 || !got.Buckets[0].Start.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || got.Buckets[0].Events != 1 {
+		t.Errorf("root_access by day: %+v", got.Buckets)
+	}
+	if len(got.TopCodeHashes) != 0 || got.TopLanguages == nil {
+		t.Errorf("top 0: %+v %+v", got.TopCodeHashes, got.TopLanguages)
+	}
+}
+
+func TestAggregateSecurityEvents_EmptyBuckets(t *testing.T) {
+	q := securityEventSeedQuery()
+	q.To = q.From.Add(6 * time.Hour)
+	got, err := AggregateSecurityEvents(nil, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Buckets) != 6 || len(got.Totals) != 0 || got.Buckets[5].BySeverity == nil {
+		t.Errorf("got %+v, want 6 empty buckets", got)
+	}
+}
+
+func TestListRecentSecurityEvents(t *testing.T) {
+	q := securityEventSeedQuery()
+	q.Bucket, q.Type, q.Limit = "", "secret_access", 3
+	got, err := ListRecentSecurityEvents(securityEventSeed(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, e := range got.Events {
+		summary = append(summary, e.CreatedAt.UTC().Format("15:04")+" "+e.Severity+" "+e.CodeHash)
+	}
+	if want := []string{"11:59 low bbb", "11:30 critical ccc", "11:00 high bbb"}; !reflect.DeepEqual(summary, want) {
+		t.Errorf("events = %q, want %q", summary, want)
+	}
+
+	// An event not written yet takes its execution's completion time.
+	done := time.Date(2026, 3, 1, 10, 45, 0, 0, time.UTC)
+	execs := []Execution{{CreatedAt: done.Add(-time.Minute), CompletedAt: &done, Events: []SecurityEventRecord{{Type: "timeout"}}}}
+	got, err = ListRecentSecurityEvents(execs, securityEventSeedQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Events) != 1 || !got.Events[0].CreatedAt.Equal(done) {
+		t.Errorf("unwritten event: %+v", got.Events)
+	}
+}
+
+func TestSecurityEventQuery_Validate(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		q       SecurityEventQuery
+		wantErr bool
+	}{
+		{SecurityEventQuery{From: from, To: from.Add(MaxSecurityEventRange), Bucket: BucketHour}, false},
+		{SecurityEventQuery{From: from, To: from.Add(MaxSecurityEventRange + time.Second)}, true},
+		{SecurityEventQuery{From: from, To: from}, true},
+		{SecurityEventQuery{From: from, To: from.Add(time.Hour), Bucket: "15m"}, true},
+		{SecurityEventQuery{From: from, To: from.Add(time.Hour), MinSeverity: "severe"}, true},
+		{SecurityEventQuery{From: from, To: from.Add(time.Hour), MinSeverity: "medium"}, false},
+		{SecurityEventQuery{From: from, To: from.Add(time.Hour), TopN: MaxSecurityEventTop + 1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.q.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, wantErr %v", tt.q, err, tt.wantErr)
+		}
+	}
+}
+
+// TestDBSecurityEventTimeline runs the SQL aggregation against a migrated
+// database named by SANDBOX_TEST_DATABASE_URL, with the session time zone
+// away from UTC to show it doesn't move the buckets.
+func TestDBSecurityEventTimeline(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := New(ctx, dsn+sep+"timezone=America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := securityEventSeedQuery()
+	if _, err := db.pool.Exec(ctx, `DELETE FROM security_events WHERE created_at >= $1 - interval '1 day' AND created_at < $2 + interval '1 day'`, q.From, q.To); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range securityEventSeed() {
+		e.ID = uuid.NewString()
+		for i := range e.Events {
+			e.Events[i].ExecutionID = e.ID
+		}
+		if err := db.LogExecution(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.SecurityEventTimeline(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTimeline(t, got)
+
+	q.Bucket, q.Limit = "", 2
+	list, err := db.ListSecurityEvents(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Events) != 2 || list.Events[0].Severity != "low" || list.Events[0].CodeHash != "bbb" {
+		t.Errorf("events = %+v", list.Events)
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// TaskSummary aggregates the executions one caller ran under a task_id: the
+// turns of one agent task. Partial marks a summary computed from the
+// in-memory record of recent executions rather than the database.
+type TaskSummary struct {
+	TaskID          string           `json:"task_id"`
+	Executions      int64            `json:"executions"`
+	Statuses        map[string]int64 `json:"statuses"` // executions by the state they ended in
+	TotalDurationMS int64            `json:"total_duration_ms"`
+	ClaudeMinutes   float64          `json:"claude_minutes"` // total duration of claude executions
+	Cost            float64          `json:"cost"`           // charged against security.cost_budget
+	SecurityEvents  []TaskEventCount `json:"security_events"`
+	FirstAt         time.Time        `json:"first_at"` // the first execution's start
+	LastAt          time.Time        `json:"last_at"`  // the last one's completion
+	Partial         bool             `json:"partial"`
+}
+
+// TaskEventCount is how many security events of one kind the task's
+// executions recorded, Count summed over the events' own counts.
+type TaskEventCount struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+	Count    int64  `json:"count"`
+}
+
+// TaskSummary aggregates the executions owner ran under taskID. A task with
+// none has Executions 0.
+func (db *DB) TaskSummary(ctx context.Context, taskID, owner string) (*TaskSummary, error) {
+	summary := &TaskSummary{TaskID: taskID, Statuses: map[string]int64{}, SecurityEvents: []TaskEventCount{}}
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT status, COUNT(*),
+			COALESCE(SUM(duration_ms), 0)::bigint,
+			COALESCE(SUM(duration_ms) FILTER (WHERE language = 'claude'), 0)::bigint,
+			COALESCE(SUM(cost), 0)::float8,
+			MIN(created_at), MAX(COALESCE(completed_at, created_at))
+		FROM executions
+		WHERE task_id = $1 AND api_key_hash = $2
+		GROUP BY status`, taskID, owner)
+	if err != nil {
+		return nil, fmt.Errorf("querying task %s: %w", taskID, err)
+	}
+	defer rows.Close()
+	var claudeMS int64
+	for rows.Next() {
+		var status string
+		var n, durationMS, claude int64
+		var cost float64
+		var first, last time.Time
+		if err := rows.Scan(&status, &n, &durationMS, &claude, &cost, &first, &last); err != nil {
+			return nil, fmt.Errorf("scanning task row: %w", err)
+		}
+		// Rows logged before the states existed group apart under the
+		// status the state replaced.
+		st, _ := state.Parse(status)
+		summary.Statuses[string(st)] += n
+		summary.Executions += n
+		summary.TotalDurationMS += durationMS
+		summary.Cost += cost
+		claudeMS += claude
+		if summary.FirstAt.IsZero() || first.Before(summary.FirstAt) {
+			summary.FirstAt = first
+		}
+		if last.After(summary.LastAt) {
+			summary.LastAt = last
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	summary.ClaudeMinutes = float64(claudeMS) / 60000
+	if summary.Executions == 0 {
+		return summary, nil
+	}
+
+	events, err := db.pool.Query(ctx, `
+		SELECT e.type, e.source, e.severity, SUM(e.count)::bigint
+		FROM security_events e JOIN executions x ON x.id = e.execution_id
+		WHERE x.task_id = $1 AND x.api_key_hash = $2
+		GROUP BY e.type, e.source, e.severity`, taskID, owner)
+	if err != nil {
+		return nil, fmt.Errorf("querying task %s security events: %w", taskID, err)
+	}
+	defer events.Close()
+	for events.Next() {
+		var c TaskEventCount
+		if err := events.Scan(&c.Type, &c.Source, &c.Severity, &c.Count); err != nil {
+			return nil, fmt.Errorf("scanning task event row: %w", err)
+		}
+		summary.SecurityEvents = append(summary.SecurityEvents, c)
+	}
+	sortEventCounts(summary.SecurityEvents)
+	return summary, events.Err()
+}
+
+// AggregateTask computes the same summary as DB.TaskSummary over execs, for
+// when there is no database.
+func AggregateTask(execs []Execution, taskID, owner string) *TaskSummary {
+	summary := &TaskSummary{TaskID: taskID, Statuses: map[string]int64{}, SecurityEvents: []TaskEventCount{}}
+	var claudeMS int64
+	events := make(map[TaskEventCount]int64)
+	for _, e := range execs {
+		if e.TaskID != taskID || e.APIKeyHash != owner {
+			continue
+		}
+		summary.Executions++
+		summary.Statuses[string(e.Status)]++
+		summary.TotalDurationMS += e.DurationMS
+		summary.Cost += e.Cost
+		if e.Language == "claude" {
+			claudeMS += e.DurationMS
+		}
+		if summary.FirstAt.IsZero() || e.CreatedAt.Before(summary.FirstAt) {
+			summary.FirstAt = e.CreatedAt
+		}
+		last := e.CreatedAt
+		if e.CompletedAt != nil {
+			last = *e.CompletedAt
+		}
+		if last.After(summary.LastAt) {
+			summary.LastAt = last
+		}
+		for _, ev := range e.Events {
+			events[TaskEventCount{Type: ev.Type, Source: ev.Source, Severity: ev.Severity}] += int64(max(ev.Count, 1))
+		}
+	}
+	summary.ClaudeMinutes = float64(claudeMS) / 60000
+	for c, n := range events {
+		c.Count = n
+		summary.SecurityEvents = append(summary.SecurityEvents, c)
+	}
+	sortEventCounts(summary.SecurityEvents)
+	return summary
+}
+
+// sortEventCounts puts the most frequent events first.
+func sortEventCounts(counts []TaskEventCount) {
+	slices.SortFunc(counts, func(a, b TaskEventCount) int {
+		return cmp.Or(
+			cmp.Compare(b.Count, a.Count),
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.Source, b.Source),
+			cmp.Compare(a.Severity, b.Severity),
+		)
+	})
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// taskSeed is three turns of task "fix-tests" by caller "a", plus rows that
+// must not count: the same task ID from another caller, and another task.
+func taskSeed(taskID string) []Execution {
+	at := func(minute int) time.Time { return time.Date(2026, 3, 1, 12, minute, 0, 0, time.UTC) }
+	done := func(minute int) *time.Time { t := at(minute); return &t }
+	return []Execution{
+		{TaskID: taskID, APIKeyHash: "a", Language: "claude", Status: state.Completed, DurationMS: 90000, Cost: 4, CreatedAt: at(0), CompletedAt: done(2),
+			Events: []SecurityEventRecord{{Type: "secret_access", Source: "prompt", Severity: "high", Count: 1}}},
+		{TaskID: taskID, APIKeyHash: "a", Language: "bash", Status: state.Failed, DurationMS: 500, Cost: 0.5, CreatedAt: at(3), CompletedAt: done(3),
+			Events: []SecurityEventRecord{
+				{Type: "proc_self_access", Source: "code", Severity: "medium", Count: 3},
+				{Type: "secret_access", Source: "prompt", Severity: "high", Count: 1},
+			}},
+		{TaskID: taskID, APIKeyHash: "a", Language: "claude", Status: state.Completed, DurationMS: 30000, Cost: 2, CreatedAt: at(5), CompletedAt: done(6)},
+		{TaskID: taskID, APIKeyHash: "b", Language: "python", Status: state.Completed, DurationMS: 1, CreatedAt: at(1), CompletedAt: done(1)},
+		{TaskID: taskID + "-other", APIKeyHash: "a", Language: "python", Status: state.Completed, DurationMS: 1, CreatedAt: at(1), CompletedAt: done(1)},
+	}
+}
+
+func wantTaskSummary(taskID string) TaskSummary {
+	return TaskSummary{
+		TaskID:          taskID,
+		Executions:      3,
+		Statuses:        map[string]int64{"completed": 2, "failed": 1},
+		TotalDurationMS: 120500,
+		ClaudeMinutes:   2,
+		Cost:            6.5,
+		SecurityEvents: []TaskEventCount{
+			{Type: "proc_self_access", Source: "code", Severity: "medium", Count: 3},
+			{Type: "secret_access", Source: "prompt", Severity: "high", Count: 2},
+		},
+		FirstAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		LastAt:  time.Date(2026, 3, 1, 12, 6, 0, 0, time.UTC),
+	}
+}
+
+func checkTaskSummary(t *testing.T, got *TaskSummary, want TaskSummary) {
+	t.Helper()
+	if math.Abs(got.ClaudeMinutes-want.ClaudeMinutes) > 1e-9 || math.Abs(got.Cost-want.Cost) > 1e-9 {
+		t.Errorf("claude_minutes %g cost %g, want %g and %g", got.ClaudeMinutes, got.Cost, want.ClaudeMinutes, want.Cost)
+	}
+	g := *got
+	g.ClaudeMinutes, g.Cost = want.ClaudeMinutes, want.Cost
+	g.FirstAt, g.LastAt = g.FirstAt.UTC(), g.LastAt.UTC()
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("\n got %+v\nwant %+v", g, want)
+	}
+}
+
+func TestAggregateTask(t *testing.T) {
+	checkTaskSummary(t, AggregateTask(taskSeed("fix-tests"), "fix-tests", "a"), wantTaskSummary("fix-tests"))
+
+	// Task IDs are the client's, so another caller's use of one is its own task.
+	other := AggregateTask(taskSeed("fix-tests"), "fix-tests", "b")
+	if other.Executions != 1 || other.Statuses["completed"] != 1 {
+		t.Errorf("caller b: %+v, want its one execution", other)
+	}
+
+	empty := AggregateTask(taskSeed("fix-tests"), "unknown", "a")
+	if empty.Executions != 0 || len(empty.Statuses) != 0 || len(empty.SecurityEvents) != 0 || !empty.FirstAt.IsZero() {
+		t.Errorf("unknown task: %+v", empty)
+	}
+}
+
+// TestDBTaskSummary runs the SQL aggregation against a migrated database
+// named by SANDBOX_TEST_DATABASE_URL.
+func TestDBTaskSummary(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	taskID := "task-" + uuid.NewString()
+	for _, e := range taskSeed(taskID) {
+		e.ID = uuid.NewString()
+		e.CodeHash = "seed"
+		for i := range e.Events {
+			e.Events[i].ExecutionID = e.ID
+		}
+		if err := db.LogExecution(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := db.TaskSummary(ctx, taskID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTaskSummary(t, summary, wantTaskSummary(taskID))
+
+	execs, err := db.ListExecutions(ctx, ExecutionFilter{TaskID: taskID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execs) != 4 {
+		t.Errorf("listed %d executions of the task, want both callers' 4", len(execs))
+	}
+}
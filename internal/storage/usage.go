@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// MaxUsageRange caps how far apart a usage report's from and to may be, so
+// a single report can't scan the whole audit log.
+const MaxUsageRange = 92 * 24 * time.Hour
+
+// Usage report dimensions.
+const (
+	GroupByDay      = "day"      // UTC calendar day, as YYYY-MM-DD
+	GroupByLanguage = "language" // runtime name
+	GroupByAPIKey   = "api_key"  // API key hash; empty for unauthenticated requests
+)
+
+// UsageQuery selects executions created in [From, To) and the dimension to
+// group them by.
+type UsageQuery struct {
+	From    time.Time
+	To      time.Time
+	GroupBy string
+}
+
+// Validate checks the dimension and the range.
+func (q UsageQuery) Validate() error {
+	switch q.GroupBy {
+	case GroupByDay, GroupByLanguage, GroupByAPIKey:
+	default:
+		return fmt.Errorf("group_by must be %s, %s or %s", GroupByDay, GroupByLanguage, GroupByAPIKey)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if q.To.Sub(q.From) > MaxUsageRange {
+		return fmt.Errorf("range exceeds %d days", int(MaxUsageRange.Hours()/24))
+	}
+	return nil
+}
+
+// UsageRow aggregates the executions sharing one key. Chaos executions are
+// left out: nothing ran. SuccessRate counts state completed only.
+type UsageRow struct {
+	Key            string  `json:"key"`
+	Executions     int64   `json:"executions"`
+	Succeeded      int64   `json:"succeeded"`
+	SuccessRate    float64 `json:"success_rate"`
+	P95DurationMS  float64 `json:"p95_duration_ms"` // interpolated, like Postgres percentile_cont
+	ClaudeMinutes  float64 `json:"claude_minutes"`  // total duration of claude executions
+	SecurityEvents int64   `json:"security_events"`
+}
+
+// UsageReport is the result of a UsageQuery. Partial marks a report computed
+// from the in-memory record of recent executions rather than the database.
+type UsageReport struct {
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	GroupBy     string     `json:"group_by"`
+	Rows        []UsageRow `json:"rows"`
+	Totals      UsageRow   `json:"totals"`
+	Partial     bool       `json:"partial"`
+	GeneratedAt time.Time  `json:"generated_at"`
+}
+
+// usageKeys are the SQL expressions for each dimension. Days are cut in UTC
+// whatever the session's time zone.
+var usageKeys = map[string]string{
+	GroupByDay:      `to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD')`,
+	GroupByLanguage: `language`,
+	GroupByAPIKey:   `api_key_hash`,
+}
+
+// UsageReport aggregates the executions table for q. The totals come from
+// the same scan through an empty grouping set.
+func (db *DB) UsageReport(ctx context.Context, q UsageQuery) (*UsageReport, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	key := usageKeys[q.GroupBy]
+	query := fmt.Sprintf(`
+		SELECT GROUPING(%[1]s) = 1 AS total,
+			COALESCE(%[1]s, '') AS key,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = ANY($3)),
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms), 0)::float8,
+			(COALESCE(SUM(duration_ms) FILTER (WHERE language = 'claude'), 0) / 60000.0)::float8,
+			COALESCE(SUM(security_events), 0)::bigint
+		FROM executions
+		WHERE created_at >= $1 AND created_at < $2 AND NOT chaos
+		GROUP BY GROUPING SETS ((%[1]s), ())
+		ORDER BY total, key`, key)
+
+	rows, err := db.pool.Query(ctx, query, q.From, q.To, state.Aliases(state.Completed))
+	if err != nil {
+		return nil, fmt.Errorf("querying usage: %w", err)
+	}
+	defer rows.Close()
+
+	report := &UsageReport{From: q.From, To: q.To, GroupBy: q.GroupBy, Rows: []UsageRow{}, GeneratedAt: time.Now().UTC()}
+	for rows.Next() {
+		var total bool
+		var row UsageRow
+		if err := rows.Scan(&total, &row.Key, &row.Executions, &row.Succeeded,
+			&row.P95DurationMS, &row.ClaudeMinutes, &row.SecurityEvents); err != nil {
+			return nil, fmt.Errorf("scanning usage row: %w", err)
+		}
+		row.SuccessRate = rate(row.Succeeded, row.Executions)
+		if total {
+			row.Key = ""
+			report.Totals = row
+			continue
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report, rows.Err()
+}
+
+// AggregateUsage computes the same report as DB.UsageReport over execs, for
+// when there is no database.
+func AggregateUsage(execs []Execution, q UsageQuery) (*UsageReport, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]Execution)
+	var all []Execution
+	for _, e := range execs {
+		if e.Chaos || e.CreatedAt.Before(q.From) || !e.CreatedAt.Before(q.To) {
+			continue
+		}
+		k := usageKey(e, q.GroupBy)
+		groups[k] = append(groups[k], e)
+		all = append(all, e)
+	}
+
+	report := &UsageReport{From: q.From, To: q.To, GroupBy: q.GroupBy, Rows: []UsageRow{}, GeneratedAt: time.Now().UTC()}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		row := aggregateRow(groups[k])
+		row.Key = k
+		report.Rows = append(report.Rows, row)
+	}
+	report.Totals = aggregateRow(all)
+	return report, nil
+}
+
+func usageKey(e Execution, groupBy string) string {
+	switch groupBy {
+	case GroupByDay:
+		return e.CreatedAt.UTC().Format(time.DateOnly)
+	case GroupByLanguage:
+		return e.Language
+	default:
+		return e.APIKeyHash
+	}
+}
+
+func aggregateRow(execs []Execution) UsageRow {
+	var row UsageRow
+	var claudeMS int64
+	durations := make([]int64, 0, len(execs))
+	for _, e := range execs {
+		row.Executions++
+		if e.Status == state.Completed {
+			row.Succeeded++
+		}
+		if e.Language == "claude" {
+			claudeMS += e.DurationMS
+		}
+		row.SecurityEvents += int64(e.SecurityEvents)
+		durations = append(durations, e.DurationMS)
+	}
+	slices.Sort(durations)
+	row.SuccessRate = rate(row.Succeeded, row.Executions)
+	row.P95DurationMS = percentileCont(durations, 0.95)
+	row.ClaudeMinutes = float64(claudeMS) / 60000
+	return row
+}
+
+// percentileCont interpolates between the two nearest ranks, as Postgres's
+// percentile_cont does.
+func percentileCont(sorted []int64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(pos)), int(math.Ceil(pos))
+	frac := pos - float64(lo)
+	return float64(sorted[lo]) + frac*float64(sorted[hi]-sorted[lo])
+}
+
+func rate(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// usageSeed spans two UTC days. The -05:00 rows check that days are cut in
+// UTC: 21:30 local on Mar 1 is 02:30 UTC on Mar 2.
+func usageSeed() []Execution {
+	est := time.FixedZone("EST", -5*3600)
+	at := func(day, hour int, loc *time.Location) time.Time {
+		return time.Date(2026, 3, day, hour, 30, 0, 0, loc)
+	}
+	return []Execution{
+		{Language: "python", Status: state.Completed, DurationMS: 100, APIKeyHash: "a", CreatedAt: at(1, 0, time.UTC)},
+		{Language: "python", Status: state.Completed, DurationMS: 200, APIKeyHash: "a", CreatedAt: at(1, 12, time.UTC)},
+		{Language: "python", Status: state.Timeout, DurationMS: 10000, APIKeyHash: "b", CreatedAt: at(1, 18, est)},                      // Mar 1 23:30 UTC
+		{Language: "claude", Status: state.Completed, DurationMS: 90000, SecurityEvents: 2, APIKeyHash: "a", CreatedAt: at(1, 21, est)}, // Mar 2 02:30 UTC
+		{Language: "claude", Status: state.Failed, DurationMS: 30000, SecurityEvents: 1, APIKeyHash: "b", CreatedAt: at(2, 23, time.UTC)},
+		// Outside the range or synthesized: never counted.
+		{Language: "python", Status: state.Completed, DurationMS: 1, CreatedAt: at(3, 0, time.UTC)},
+		{Language: "python", Status: state.Completed, DurationMS: 1, CreatedAt: time.Date(2026, 2, 28, 23, 59, 0, 0, time.UTC)},
+		{Language: "python", Status: state.Completed, DurationMS: 1, Chaos: true, CreatedAt: at(1, 1, time.UTC)},
+	}
+}
+
+func usageSeedQuery(groupBy string) UsageQuery {
+	return UsageQuery{
+		From:    time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		GroupBy: groupBy,
+	}
+}
+
+// wantUsage is the expected report over usageSeed, by dimension.
+var wantUsage = map[string][]UsageRow{
+	GroupByDay: {
+		// Durations 100, 200, 10000: p95 interpolates 90% of the way from 200 to 10000.
+		{Key: "2026-03-01", Executions: 3, Succeeded: 2, SuccessRate: 2.0 / 3, P95DurationMS: 9020},
+		{Key: "2026-03-02", Executions: 2, Succeeded: 1, SuccessRate: 0.5, P95DurationMS: 87000, ClaudeMinutes: 2, SecurityEvents: 3},
+	},
+	GroupByLanguage: {
+		{Key: "claude", Executions: 2, Succeeded: 1, SuccessRate: 0.5, P95DurationMS: 87000, ClaudeMinutes: 2, SecurityEvents: 3},
+		{Key: "python", Executions: 3, Succeeded: 2, SuccessRate: 2.0 / 3, P95DurationMS: 9020},
+	},
+	GroupByAPIKey: {
+		{Key: "a", Executions: 3, Succeeded: 3, SuccessRate: 1, P95DurationMS: 81020, ClaudeMinutes: 1.5, SecurityEvents: 2},
+		{Key: "b", Executions: 2, Succeeded: 0, SuccessRate: 0, P95DurationMS: 29000, ClaudeMinutes: 0.5, SecurityEvents: 1},
+	},
+}
+
+// Durations 100, 200, 10000, 30000, 90000: p95 is 80% of the way from 30000 to 90000.
+var wantUsageTotals = UsageRow{Executions: 5, Succeeded: 3, SuccessRate: 0.6, P95DurationMS: 78000, ClaudeMinutes: 2, SecurityEvents: 3}
+
+func checkUsage(t *testing.T, report *UsageReport, groupBy string) {
+	t.Helper()
+	want := wantUsage[groupBy]
+	if len(report.Rows) != len(want) {
+		t.Fatalf("%s: got %d rows %+v, want %d", groupBy, len(report.Rows), report.Rows, len(want))
+	}
+	for i := range want {
+		checkUsageRow(t, groupBy, report.Rows[i], want[i])
+	}
+	checkUsageRow(t, groupBy+" totals", report.Totals, wantUsageTotals)
+}
+
+func checkUsageRow(t *testing.T, name string, got, want UsageRow) {
+	t.Helper()
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if got.Key != want.Key || got.Executions != want.Executions || got.Succeeded != want.Succeeded ||
+		got.SecurityEvents != want.SecurityEvents || !near(got.SuccessRate, want.SuccessRate) ||
+		!near(got.P95DurationMS, want.P95DurationMS) || !near(got.ClaudeMinutes, want.ClaudeMinutes) {
+		t.Errorf("%s:\n got %+v\nwant %+v", name, got, want)
+	}
+}
+
+func TestAggregateUsage(t *testing.T) {
+	for groupBy := range wantUsage {
+		report, err := AggregateUsage(usageSeed(), usageSeedQuery(groupBy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkUsage(t, report, groupBy)
+	}
+}
+
+func TestAggregateUsage_Empty(t *testing.T) {
+	report, err := AggregateUsage(nil, usageSeedQuery(GroupByDay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 0 || report.Totals != (UsageRow{}) {
+		t.Errorf("got %+v, want no rows and zero totals", report)
+	}
+}
+
+func TestUsageQuery_Validate(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		q       UsageQuery
+		wantErr bool
+	}{
+		{UsageQuery{From: from, To: from.Add(MaxUsageRange), GroupBy: GroupByDay}, false},
+		{UsageQuery{From: from, To: from.Add(MaxUsageRange + time.Second), GroupBy: GroupByDay}, true},
+		{UsageQuery{From: from, To: from, GroupBy: GroupByLanguage}, true},
+		{UsageQuery{From: from, To: from.Add(time.Hour), GroupBy: "status"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.q.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, wantErr %v", tt.q, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPercentileCont(t *testing.T) {
+	tests := []struct {
+		sorted []int64
+		p      float64
+		want   float64
+	}{
+		{nil, 0.95, 0},
+		{[]int64{7}, 0.95, 7},
+		{[]int64{0, 100}, 0.5, 50},
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}, 0.95, 20},
+	}
+	for _, tt := range tests {
+		if got := percentileCont(tt.sorted, tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("percentileCont(%v, %v) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+// TestDBUsageReport runs the SQL aggregation against a migrated database
+// named by the SANDBOX_TEST_DATABASE_URL URL. The session time zone is set away from
+// UTC to show it doesn't move the day buckets.
+func TestDBUsageReport(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := New(ctx, dsn+sep+"timezone=America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := usageSeedQuery(GroupByDay)
+	if _, err := db.pool.Exec(ctx, `DELETE FROM executions WHERE created_at >= $1 - interval '1 day' AND created_at < $2 + interval '1 day'`, q.From, q.To); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range usageSeed() {
+		e.ID = uuid.NewString()
+		e.CodeHash = "seed"
+		if err := db.LogExecution(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for groupBy := range wantUsage {
+		report, err := db.UsageReport(ctx, usageSeedQuery(groupBy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkUsage(t, report, groupBy)
+	}
+}
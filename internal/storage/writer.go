@@ -2,44 +2,131 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"math"
 	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// AuditSink is somewhere the audit writer writes execution records: the
+// database, or a FileSink.
+type AuditSink interface {
+	LogExecution(ctx context.Context, exec *Execution) error
+	LogExecutions(ctx context.Context, execs []*Execution) error
+}
+
+// AuditObserver receives the audit writer's metrics, by sink name.
+type AuditObserver interface {
+	// AuditBatchFlushed records a batch written, or given up on, and how
+	// long that took including retries and fallback.
+	AuditBatchFlushed(sink string, rows int, took time.Duration)
+	// AuditRowFallback records a record written on its own after its
+	// batch was refused.
+	AuditRowFallback()
+	// AuditRecordsLost records records a sink never wrote: its buffer was
+	// full, or it failed to write them for good.
+	AuditRecordsLost(sink string, n int)
+}
+
+// AuditWriter writes execution records to its sinks off the request path.
+// Records are buffered and written in batches of up to maxRows, each a
+// multi-row INSERT on the database; a batch waits at most maxWait for more
+// records. Every sink gets every record, through a buffer and goroutine of
+// its own, so a sink that is slow or down doesn't hold up the others.
 type AuditWriter struct {
-	db   *DB
+	sinks      []*sinkWriter
+	bufferSize int
+	wg         sync.WaitGroup
+	done       chan struct{}
+	maxRows    int
+	maxWait    time.Duration
+	observer   AuditObserver
+	backoff    func(attempt int) time.Duration
+}
+
+// sinkWriter is one sink of an AuditWriter and the records buffered for it.
+type sinkWriter struct {
+	name string
+	sink AuditSink
 	ch   chan *Execution
-	wg   sync.WaitGroup
-	done chan struct{}
 }
 
-func NewAuditWriter(db *DB, bufferSize int) *AuditWriter {
+// NewAuditWriter returns a writer with no sinks; add them with AddSink.
+// Each sink buffers up to bufferSize records.
+func NewAuditWriter(bufferSize int) *AuditWriter {
 	if bufferSize < 1 {
 		bufferSize = 10000
 	}
 	return &AuditWriter{
-		db:   db,
-		ch:   make(chan *Execution, bufferSize),
-		done: make(chan struct{}),
+		bufferSize: bufferSize,
+		done:       make(chan struct{}),
+		maxRows:    100,
+		maxWait:    50 * time.Millisecond,
+		backoff: func(attempt int) time.Duration {
+			return time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+		},
 	}
 }
 
+// AddSink adds a sink, named in logs and metrics. Call it before Start.
+func (w *AuditWriter) AddSink(name string, sink AuditSink) {
+	w.sinks = append(w.sinks, &sinkWriter{name: name, sink: sink, ch: make(chan *Execution, w.bufferSize)})
+}
+
+// SetBatching sets database.audit_batch: the records per batch, and how
+// long a partial batch waits for more. Call it before Start.
+func (w *AuditWriter) SetBatching(maxRows int, maxWait time.Duration) {
+	w.maxRows = max(maxRows, 1)
+	w.maxWait = maxWait
+}
+
+// SetObserver sets what receives the writer's metrics. Call it before Start.
+func (w *AuditWriter) SetObserver(obs AuditObserver) {
+	w.observer = obs
+}
+
 func (w *AuditWriter) Start() {
-	w.wg.Add(1)
-	go w.processLoop()
+	for _, s := range w.sinks {
+		w.wg.Add(1)
+		go w.processLoop(s)
+	}
 }
 
+// Log queues exec for every sink. A sink whose buffer is full loses it;
+// the others still get it.
 func (w *AuditWriter) Log(exec *Execution) {
-	select {
-	case w.ch <- exec:
-	default:
-		log.Warn().Str("exec_id", exec.ID).Msg("audit buffer full, dropping log entry")
+	for _, s := range w.sinks {
+		select {
+		case s.ch <- exec:
+		default:
+			log.Warn().Str("exec_id", exec.ID).Str("sink", s.name).Msg("audit buffer full, dropping log entry")
+			w.lost(s, 1)
+		}
+	}
+}
+
+// Fill is how full the fullest sink's buffer is, from 0 to 1: records
+// logged but not yet written, against what the buffer holds.
+func (w *AuditWriter) Fill() float64 {
+	var fill float64
+	for _, s := range w.sinks {
+		fill = max(fill, float64(len(s.ch))/float64(cap(s.ch)))
 	}
+	return fill
 }
 
+func (w *AuditWriter) lost(s *sinkWriter, n int) {
+	if w.observer != nil {
+		w.observer.AuditRecordsLost(s.name, n)
+	}
+}
+
+// Flush stops the writer once it has written everything logged so far,
+// partial batch included, or after timeout.
 func (w *AuditWriter) Flush(timeout time.Duration) {
 	close(w.done)
 
@@ -57,53 +144,152 @@ func (w *AuditWriter) Flush(timeout time.Duration) {
 	}
 }
 
-func (w *AuditWriter) processLoop() {
+func (w *AuditWriter) processLoop(s *sinkWriter) {
 	defer w.wg.Done()
 
+	batch := make([]*Execution, 0, w.maxRows)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var deadline <-chan time.Time // set while a partial batch waits
+	flush := func() {
+		if len(batch) > 0 {
+			w.writeBatch(s, batch)
+			batch = make([]*Execution, 0, w.maxRows)
+		}
+		timer.Stop()
+		deadline = nil
+	}
+	// take adds what is already buffered to the batch, flushing each one
+	// that fills, without waiting for more.
+	take := func() {
+		for {
+			select {
+			case exec := <-s.ch:
+				batch = append(batch, exec)
+				if len(batch) >= w.maxRows {
+					flush()
+				}
+			default:
+				return
+			}
+		}
+	}
+
 	for {
 		select {
-		case exec := <-w.ch:
-			w.writeWithRetry(exec)
+		case exec := <-s.ch:
+			batch = append(batch, exec)
+			if len(batch) >= w.maxRows {
+				flush()
+			}
+			take()
+			switch {
+			case len(batch) == 0:
+			case w.maxWait <= 0:
+				flush()
+			case deadline == nil:
+				timer.Reset(w.maxWait)
+				deadline = timer.C
+			}
+		case <-deadline:
+			deadline = nil
+			flush()
 		case <-w.done:
-			// Drain remaining entries
-			for {
-				select {
-				case exec := <-w.ch:
-					w.writeWithRetry(exec)
-				default:
-					return
-				}
+			take()
+			flush()
+			return
+		}
+	}
+}
+
+// writeBatch writes batch in one transaction, retrying as writeWithRetry
+// does. When the database refuses the batch's data it falls back to writing
+// each record on its own, in order, so one bad record doesn't lose the rest.
+func (w *AuditWriter) writeBatch(s *sinkWriter, batch []*Execution) {
+	start := time.Now()
+	defer func() {
+		if w.observer != nil {
+			w.observer.AuditBatchFlushed(s.name, len(batch), time.Since(start))
+		}
+	}()
+	if len(batch) == 1 {
+		w.writeWithRetry(s, batch[0])
+		return
+	}
+
+	err := w.retry(func(ctx context.Context) error { return s.sink.LogExecutions(ctx, batch) },
+		func(e *zerolog.Event) *zerolog.Event {
+			return e.Str("sink", s.name).Int("rows", len(batch)).Str("first_exec_id", batch[0].ID)
+		})
+	switch {
+	case err == nil:
+	case retryable(err):
+		for _, exec := range batch {
+			log.Error().
+				Err(err).
+				Str("sink", s.name).
+				Str("exec_id", exec.ID).
+				Msg("audit write failed permanently after retries")
+		}
+		w.lost(s, len(batch))
+	default:
+		log.Warn().Err(err).Str("sink", s.name).Int("rows", len(batch)).Msg("audit batch refused, writing its records one at a time")
+		for _, exec := range batch {
+			if w.observer != nil {
+				w.observer.AuditRowFallback()
 			}
+			w.writeWithRetry(s, exec)
 		}
 	}
 }
 
-func (w *AuditWriter) writeWithRetry(exec *Execution) {
+func (w *AuditWriter) writeWithRetry(s *sinkWriter, exec *Execution) {
+	err := w.retry(func(ctx context.Context) error { return s.sink.LogExecution(ctx, exec) },
+		func(e *zerolog.Event) *zerolog.Event { return e.Str("sink", s.name).Str("exec_id", exec.ID) })
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("sink", s.name).
+			Str("exec_id", exec.ID).
+			Msg("audit write failed permanently after retries")
+		w.lost(s, 1)
+	}
+}
+
+// retry runs write until it succeeds, has failed maxRetries+1 times, or
+// fails in a way retrying can't fix. with adds the fields identifying what
+// is being written to each retry's log line.
+func (w *AuditWriter) retry(write func(ctx context.Context) error, with func(*zerolog.Event) *zerolog.Event) error {
 	const maxRetries = 3
 
+	var err error
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err := w.db.LogExecution(ctx, exec)
+		err = write(ctx)
 		cancel()
 
-		if err == nil {
-			return
+		if err == nil || !retryable(err) || attempt == maxRetries {
+			return err
 		}
+		backoff := w.backoff(attempt)
+		with(log.Warn()).
+			Err(err).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Msg("audit write failed, retrying")
+		time.Sleep(backoff)
+	}
+	return err
+}
 
-		if attempt < maxRetries {
-			backoff := time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
-			log.Warn().
-				Err(err).
-				Str("exec_id", exec.ID).
-				Int("attempt", attempt+1).
-				Dur("backoff", backoff).
-				Msg("audit write failed, retrying")
-			time.Sleep(backoff)
-		} else {
-			log.Error().
-				Err(err).
-				Str("exec_id", exec.ID).
-				Msg("audit write failed permanently after retries")
-		}
+// retryable reports whether err might not recur: anything but Postgres
+// refusing the data itself, with a data exception (class 22) or an
+// integrity constraint violation (class 23).
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) >= 2 {
+		class := pgErr.Code[:2]
+		return class != "22" && class != "23"
 	}
+	return true
 }
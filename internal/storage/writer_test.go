@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"safe-agent-sandbox/internal/state"
+)
+
+// fakeStore records the writes it is given. Records whose ID is in poison
+// are refused as Postgres refuses bad data, and so is any batch holding one.
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]string // IDs of each LogExecutions call, in order
+	rows    []string   // IDs of each LogExecution call, in order
+	poison  map[string]bool
+	fail    int // transient failures still to return
+}
+
+func (s *fakeStore) LogExecution(_ context.Context, exec *Execution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.poison[exec.ID] {
+		return &pgconn.PgError{Code: "22P02", Message: "invalid input syntax"}
+	}
+	s.rows = append(s.rows, exec.ID)
+	return nil
+}
+
+func (s *fakeStore) LogExecutions(_ context.Context, execs []*Execution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("connection reset by peer")
+	}
+	ids := make([]string, len(execs))
+	for i, e := range execs {
+		if s.poison[e.ID] {
+			return &pgconn.PgError{Code: "22P02", Message: "invalid input syntax"}
+		}
+		ids[i] = e.ID
+	}
+	s.batches = append(s.batches, ids)
+	return nil
+}
+
+func (s *fakeStore) snapshot() ([][]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...), append([]string(nil), s.rows...)
+}
+
+type auditCounter struct {
+	mu        sync.Mutex
+	flushed   []int
+	fallbacks int
+	lost      map[string]int
+}
+
+func (c *auditCounter) AuditBatchFlushed(_ string, rows int, _ time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushed = append(c.flushed, rows)
+}
+
+func (c *auditCounter) AuditRowFallback() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallbacks++
+}
+
+func (c *auditCounter) AuditRecordsLost(sink string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lost == nil {
+		c.lost = map[string]int{}
+	}
+	c.lost[sink] += n
+}
+
+func testAuditWriter(store *fakeStore, maxRows int, maxWait time.Duration) (*AuditWriter, *auditCounter) {
+	w := NewAuditWriter(100)
+	w.AddSink("postgres", store)
+	w.SetBatching(maxRows, maxWait)
+	obs := &auditCounter{}
+	w.SetObserver(obs)
+	w.backoff = func(int) time.Duration { return 0 }
+	return w, obs
+}
+
+func ids(prefix string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return out
+}
+
+func logAll(w *AuditWriter, ids []string) {
+	for _, id := range ids {
+		w.Log(&Execution{ID: id})
+	}
+}
+
+func TestAuditWriter_Batches(t *testing.T) {
+	store := &fakeStore{}
+	// A wait longer than the test: only max_rows and Flush can write.
+	w, obs := testAuditWriter(store, 4, time.Hour)
+	logAll(w, ids("e", 10)) // buffered before the writer starts
+	w.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if batches, _ := store.snapshot(); len(batches) == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if batches, _ := store.snapshot(); !reflect.DeepEqual(batches, [][]string{{"e0", "e1", "e2", "e3"}, {"e4", "e5", "e6", "e7"}}) {
+		t.Fatalf("full batches = %v", batches)
+	}
+
+	// The partial batch waits for more until Flush writes it.
+	w.Flush(5 * time.Second)
+	batches, rows := store.snapshot()
+	if len(batches) != 3 || !reflect.DeepEqual(batches[2], []string{"e8", "e9"}) || len(rows) != 0 {
+		t.Errorf("after Flush: batches %v, rows %v", batches, rows)
+	}
+	if !reflect.DeepEqual(obs.flushed, []int{4, 4, 2}) {
+		t.Errorf("observed batch sizes %v", obs.flushed)
+	}
+}
+
+func TestAuditWriter_MaxWait(t *testing.T) {
+	store := &fakeStore{}
+	w, _ := testAuditWriter(store, 100, 10*time.Millisecond)
+	w.Start()
+	defer w.Flush(time.Second)
+
+	logAll(w, ids("e", 3))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		batches, rows := store.snapshot()
+		var written []string
+		for _, b := range batches {
+			written = append(written, b...)
+		}
+		written = append(written, rows...)
+		if len(written) == 3 {
+			if !reflect.DeepEqual(written, ids("e", 3)) {
+				t.Errorf("written %v, want them in order", written)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("partial batch not written after max_wait: %v %v", batches, rows)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestAuditWriter_PoisonRecord checks a record Postgres refuses costs only
+// itself: the rest of its batch is written one at a time, in order.
+func TestAuditWriter_PoisonRecord(t *testing.T) {
+	store := &fakeStore{poison: map[string]bool{"e2": true}}
+	w, obs := testAuditWriter(store, 5, time.Hour)
+	logAll(w, ids("e", 5))
+	w.Start()
+	w.Flush(5 * time.Second)
+
+	batches, rows := store.snapshot()
+	if len(batches) != 0 || !reflect.DeepEqual(rows, []string{"e0", "e1", "e3", "e4"}) {
+		t.Errorf("batches %v, rows %v; want the good records written alone, in order", batches, rows)
+	}
+	if obs.fallbacks != 5 {
+		t.Errorf("fallbacks = %d, want 5", obs.fallbacks)
+	}
+}
+
+// TestAuditWriter_RetriesBatch checks a transient failure retries the batch
+// as a whole rather than falling back to single rows.
+func TestAuditWriter_RetriesBatch(t *testing.T) {
+	store := &fakeStore{fail: 2}
+	w, obs := testAuditWriter(store, 3, time.Hour)
+	logAll(w, ids("e", 3))
+	w.Start()
+	w.Flush(5 * time.Second)
+
+	batches, rows := store.snapshot()
+	if !reflect.DeepEqual(batches, [][]string{{"e0", "e1", "e2"}}) || len(rows) != 0 || obs.fallbacks != 0 {
+		t.Errorf("batches %v, rows %v, fallbacks %d", batches, rows, obs.fallbacks)
+	}
+}
+
+// blockedSink holds every write until release is closed.
+type blockedSink struct{ release chan struct{} }
+
+func (s blockedSink) LogExecution(context.Context, *Execution) error { <-s.release; return nil }
+
+func (s blockedSink) LogExecutions(context.Context, []*Execution) error { <-s.release; return nil }
+
+// TestAuditWriter_SinksIndependent checks each sink gets every record, and
+// one that is stuck or failing costs the others nothing.
+func TestAuditWriter_SinksIndependent(t *testing.T) {
+	good := &fakeStore{}
+	failing := &fakeStore{poison: map[string]bool{}}
+	for _, id := range ids("e", 5) {
+		failing.poison[id] = true
+	}
+	stuck := blockedSink{release: make(chan struct{})}
+	w := NewAuditWriter(2)
+	w.AddSink("postgres", failing)
+	w.AddSink("stuck", stuck)
+	w.AddSink("file", good)
+	w.SetBatching(1, 0)
+	obs := &auditCounter{}
+	w.SetObserver(obs)
+	w.backoff = func(int) time.Duration { return 0 }
+	w.Start()
+
+	// Logged one at a time so the good sink keeps up; the stuck one holds
+	// the first, buffers two and loses the rest.
+	for _, id := range ids("e", 5) {
+		w.Log(&Execution{ID: id})
+		deadline := time.Now().Add(5 * time.Second)
+		for _, rows := good.snapshot(); len(rows) == 0 || rows[len(rows)-1] != id; _, rows = good.snapshot() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not written to the good sink while another was stuck: %v", id, rows)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		for len(w.sinks[1].ch) > 0 && id == "e0" {
+			time.Sleep(time.Millisecond) // until the stuck sink holds e0
+		}
+	}
+	close(stuck.release)
+	w.Flush(5 * time.Second)
+
+	if _, rows := good.snapshot(); !reflect.DeepEqual(rows, ids("e", 5)) {
+		t.Errorf("good sink wrote %v", rows)
+	}
+	if want := map[string]int{"postgres": 5, "stuck": 2}; !reflect.DeepEqual(obs.lost, want) {
+		t.Errorf("lost %v, want %v", obs.lost, want)
+	}
+}
+
+// TestAuditWriter_Fill checks the fill is the fullest sink's, before the
+// writer has started draining them.
+func TestAuditWriter_Fill(t *testing.T) {
+	w := NewAuditWriter(4)
+	if got := w.Fill(); got != 0 {
+		t.Errorf("no sinks: fill %g", got)
+	}
+	w.AddSink("postgres", &fakeStore{})
+	w.AddSink("file", &fakeStore{})
+	w.sinks[1].ch <- &Execution{ID: "e0"}
+	w.Log(&Execution{ID: "e1"})
+	if got := w.Fill(); got != 0.5 {
+		t.Errorf("fill %g, want the file sink's 0.5", got)
+	}
+}
+
+// TestDBLogExecutions writes a batch to a migrated database named by
+// SANDBOX_TEST_DATABASE_URL, then one with a duplicate ID that must write
+// nothing.
+func TestDBLogExecutions(t *testing.T) {
+	dsn := os.Getenv("SANDBOX_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SANDBOX_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	taskID := "batch-" + uuid.NewString()
+	var execs []*Execution
+	for i := 0; i < 3; i++ {
+		id := uuid.NewString()
+		execs = append(execs, &Execution{ID: id, TaskID: taskID, APIKeyHash: "a", Language: "python", CodeHash: "seed", Status: state.Completed,
+			CreatedAt: time.Now(), Events: []SecurityEventRecord{{ExecutionID: id, Type: "secret_access", Source: "code", Severity: "high", Count: 1}}})
+	}
+	if err := db.LogExecutions(ctx, execs); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := db.TaskSummary(ctx, taskID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Executions != 3 || len(summary.SecurityEvents) != 1 || summary.SecurityEvents[0].Count != 3 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	fresh := &Execution{ID: uuid.NewString(), TaskID: taskID, APIKeyHash: "a", Language: "python", CodeHash: "seed", Status: state.Completed, CreatedAt: time.Now()}
+	err = db.LogExecutions(ctx, []*Execution{fresh, execs[0]})
+	if err == nil || retryable(err) {
+		t.Fatalf("duplicate ID: err %v, want a refusal that isn't retried", err)
+	}
+	if got, err := db.GetExecution(ctx, fresh.ID, false); err == nil && got != nil {
+		t.Error("a refused batch wrote its other record")
+	}
+}
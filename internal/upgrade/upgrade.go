@@ -0,0 +1,256 @@
+// Package upgrade replaces a running server with a new binary without
+// refusing a connection. The old process starts the new one with its
+// listening sockets as inherited file descriptors; the new one starts
+// serving on them and says it's ready, and only then does the old one stop
+// accepting and drain. If the new one fails first, it is killed and the old
+// one carries on serving as if nothing had happened.
+//
+// The two talk over a pair of pipes. The old process sends JSON messages of
+// its choosing down one for as long as it lives, so the new one reads
+// io.EOF once it has exited; the new one writes a line on the other when
+// it's ready.
+package upgrade
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envListeners names the sockets a process was handed, comma-separated,
+	// in the order of their file descriptors from firstListenerFD.
+	envListeners = "SANDBOX_UPGRADE_LISTENERS"
+
+	stateFD         = 3 // read end of the old process's messages
+	readyFD         = 4 // write end of the new process's ready line
+	firstListenerFD = 5
+
+	readyLine = "ready\n"
+
+	// sendTimeout bounds how long Send waits for a new process that isn't
+	// reading its messages.
+	sendTimeout = 5 * time.Second
+)
+
+// What a handover ended in, for metrics and logs.
+const (
+	OutcomeHandedOver = "handed_over" // the new process is serving; the old one drains
+	OutcomeAborted    = "aborted"     // the new process failed first; the old one keeps serving
+	OutcomeInherited  = "inherited"   // this process was started by a handover
+)
+
+// Listener is a socket handed to the new process under Name.
+type Listener struct {
+	Name string
+	net.Listener
+}
+
+// Upgrader starts the new process of a handover.
+type Upgrader struct {
+	Binary       string        // the new server binary
+	Args         []string      // its arguments, without the program name
+	ReadyTimeout time.Duration // how long it has to say it's ready
+}
+
+// Handover is a new process listeners were handed to.
+type Handover struct {
+	cmd     *exec.Cmd
+	timeout time.Duration
+	state   *os.File // write end of the messages to it
+	ready   *os.File // read end of its ready line
+	exited  chan struct{}
+	err     error // how it exited, once exited is closed
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Start starts the new process with listeners, which it serves on alongside
+// this one's until Wait returns. It can be sent messages at once.
+func (u *Upgrader) Start(listeners []Listener) (*Handover, error) {
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("handover pipe: %w", err)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		_ = stateR.Close()
+		_ = stateW.Close()
+		return nil, fmt.Errorf("handover pipe: %w", err)
+	}
+	// The new process has its own copies of these once started.
+	files := []*os.File{stateR, readyW}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	names := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		f, err := listenerFile(l.Listener)
+		if err != nil {
+			_ = stateW.Close()
+			_ = readyR.Close()
+			return nil, fmt.Errorf("handing over %s: %w", l.Name, err)
+		}
+		files = append(files, f)
+		names = append(names, l.Name)
+	}
+
+	cmd := exec.Command(u.Binary, u.Args...) // #nosec G204 -- the binary comes from the config file or is this one
+	cmd.Env = append(slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, envListeners+"=")
+	}), envListeners+"="+strings.Join(names, ","))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		_ = stateW.Close()
+		_ = readyR.Close()
+		return nil, fmt.Errorf("starting %s: %w", u.Binary, err)
+	}
+	h := &Handover{
+		cmd:     cmd,
+		timeout: u.ReadyTimeout,
+		state:   stateW,
+		ready:   readyR,
+		exited:  make(chan struct{}),
+		enc:     json.NewEncoder(stateW),
+	}
+	go func() {
+		h.err = cmd.Wait()
+		close(h.exited)
+	}()
+	return h, nil
+}
+
+// Pid is the new process's ID.
+func (h *Handover) Pid() int {
+	return h.cmd.Process.Pid
+}
+
+// Send writes v to the new process as a line of JSON. Messages arrive in
+// the order they were sent.
+func (h *Handover) Send(v any) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_ = h.state.SetWriteDeadline(time.Now().Add(sendTimeout))
+	if err := h.enc.Encode(v); err != nil {
+		return fmt.Errorf("sending to the new process: %w", err)
+	}
+	return nil
+}
+
+// Wait waits for the new process to say it's ready, after which this one
+// should stop accepting. If it exits first, or isn't ready within the
+// ReadyTimeout, Wait kills it and returns why.
+func (h *Handover) Wait() error {
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(h.ready).ReadString('\n')
+		if err == nil && line != readyLine {
+			err = fmt.Errorf("unexpected %q on the ready pipe", line)
+		}
+		ready <- err
+	}()
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-ready:
+		if err == nil {
+			_ = h.ready.Close()
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			// It has exited, or closed the pipe and is about to.
+			select {
+			case <-h.exited:
+				err = fmt.Errorf("new process exited before it was ready: %w", h.err)
+			case <-time.After(time.Second):
+				err = errors.New("new process closed its ready pipe without being ready")
+			}
+		}
+		h.Cancel()
+		return err
+	case <-timer.C:
+		h.Cancel()
+		return fmt.Errorf("new process not ready after %s", h.timeout)
+	}
+}
+
+// Cancel kills the new process, for a handover abandoned before it was
+// ready, and waits for it to exit.
+func (h *Handover) Cancel() {
+	_ = h.cmd.Process.Kill()
+	<-h.exited
+	_ = h.state.Close()
+	_ = h.ready.Close()
+}
+
+// Inherited is what a process started by a handover was handed.
+type Inherited struct {
+	listeners map[string]net.Listener
+	state     *os.File
+	dec       *json.Decoder
+	ready     *os.File
+}
+
+// Inherit returns what this process was handed by the one it is replacing,
+// or nil if it wasn't started by a handover.
+func Inherit() (*Inherited, error) {
+	names, ok := os.LookupEnv(envListeners)
+	if !ok {
+		return nil, nil
+	}
+	// Not for any process this one starts.
+	_ = os.Unsetenv(envListeners)
+
+	in := &Inherited{
+		listeners: make(map[string]net.Listener),
+		state:     os.NewFile(stateFD, "handover-state"),
+		ready:     os.NewFile(readyFD, "handover-ready"),
+	}
+	in.dec = json.NewDecoder(in.state)
+	for i, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+		f := os.NewFile(uintptr(firstListenerFD+i), name)
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", name, err)
+		}
+		in.listeners[name] = ln
+	}
+	return in, nil
+}
+
+// Listeners returns the sockets handed over, by name.
+func (in *Inherited) Listeners() map[string]net.Listener {
+	return in.listeners
+}
+
+// Receive reads the old process's next message into v. It returns io.EOF
+// once the old process has exited.
+func (in *Inherited) Receive(v any) error {
+	return in.dec.Decode(v)
+}
+
+// Ready tells the old process this one is serving, so it stops accepting.
+func (in *Inherited) Ready() error {
+	defer in.ready.Close()
+	if _, err := io.WriteString(in.ready, readyLine); err != nil {
+		return fmt.Errorf("telling the old process this one is ready: %w", err)
+	}
+	return nil
+}
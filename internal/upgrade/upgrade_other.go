@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package upgrade
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// Signal is nil here, where sockets can't be handed over: there are no
+// handovers.
+var Signal os.Signal
+
+func listenerFile(net.Listener) (*os.File, error) {
+	return nil, errors.New("sockets can't be handed over on this platform")
+}
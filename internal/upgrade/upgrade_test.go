@@ -0,0 +1,198 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// childMode makes the test binary a stub new binary: "serve" serves what it
+// inherited, "fail" exits before it's ready and "hang" never is.
+const childMode = "UPGRADE_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(childMode); mode != "" {
+		os.Exit(runChild(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runChild(mode string) int {
+	switch mode {
+	case "fail":
+		return 3
+	case "hang":
+		time.Sleep(time.Hour)
+		return 0
+	}
+	in, err := Inherit()
+	if err != nil || in == nil {
+		fmt.Fprintln(os.Stderr, "inherit:", in, err)
+		return 1
+	}
+	var greeting string
+	if err := in.Receive(&greeting); err != nil {
+		fmt.Fprintln(os.Stderr, "receive:", err)
+		return 1
+	}
+	go func() {
+		_ = http.Serve(in.Listeners()["api"], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "child %d %s", os.Getpid(), greeting)
+		}))
+	}()
+	if err := in.Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Until the old process goes.
+	for in.Receive(new(any)) == nil {
+	}
+	return 0
+}
+
+// oldServer serves "old" on a listener, holding requests for /slow until
+// release is closed. It sends on arrived as each one comes in.
+func oldServer(t *testing.T) (ln net.Listener, srv *http.Server, arrived, release chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arrived, release = make(chan struct{}, 1), make(chan struct{})
+	srv = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			arrived <- struct{}{}
+			<-release
+		}
+		_, _ = io.WriteString(w, "old")
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln, srv, arrived, release
+}
+
+// get fetches path on a connection of its own.
+func get(t *testing.T, addr, path string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func stubUpgrader(t *testing.T, mode string, readyTimeout time.Duration) *Upgrader {
+	t.Setenv(childMode, mode)
+	return &Upgrader{Binary: os.Args[0], Args: []string{"-test.run=^$"}, ReadyTimeout: readyTimeout}
+}
+
+func TestHandover(t *testing.T) {
+	ln, srv, arrived, release := oldServer(t)
+	addr := ln.Addr().String()
+
+	// A slow request is in flight on the old process throughout.
+	slow := make(chan string, 1)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		slow <- string(b)
+	}()
+	<-arrived
+
+	h, err := stubUpgrader(t, "serve", 10*time.Second).Start([]Listener{{Name: "api", Listener: ln}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Cancel)
+	if err := h.Send("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old process stops accepting, as Shutdown does first, and drains.
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- srv.Shutdown(context.Background()) }()
+
+	want := fmt.Sprintf("child %d hello", h.Pid())
+	for range 5 {
+		if got := get(t, addr, "/"); got != want {
+			t.Fatalf("new request answered %q, want %q", got, want)
+		}
+	}
+	select {
+	case got := <-slow:
+		t.Fatalf("slow request finished before it was released: %q", got)
+	case err := <-drained:
+		t.Fatalf("old process drained with a request in flight: %v", err)
+	default:
+	}
+
+	close(release)
+	if got := <-slow; got != "old" {
+		t.Errorf("in-flight request on the old process answered %q, want old", got)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("drain: %v", err)
+	}
+}
+
+func TestHandover_Aborted(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr string
+	}{
+		{"fail", "exited before it was ready: exit status 3"},
+		{"hang", "not ready after 200ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ln, _, _, _ := oldServer(t)
+			h, err := stubUpgrader(t, tt.mode, 200*time.Millisecond).Start([]Listener{{Name: "api", Listener: ln}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = h.Wait()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Wait = %v, want %q", err, tt.wantErr)
+			}
+			select {
+			case <-h.exited:
+			default:
+				t.Error("new process left running after the handover was abandoned")
+			}
+			// The old process never stopped accepting.
+			if got := get(t, ln.Addr().String(), "/"); got != "old" {
+				t.Errorf("after the abort, answered %q", got)
+			}
+		})
+	}
+}
+
+func TestInherit_NotHandedOver(t *testing.T) {
+	if in, err := Inherit(); in != nil || err != nil {
+		t.Errorf("Inherit = %v, %v; want nil without a handover", in, err)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Signal starts a handover.
+var Signal os.Signal = syscall.SIGUSR2
+
+// listenerFile returns a duplicate of ln's descriptor. Unlike ln's File
+// method it leaves the socket non-blocking: the flag is shared with ln,
+// whose Accept would otherwise tie up a thread that Close then waits on.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("a %T can't be handed over", ln)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(s uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(s)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err = errors.Join(err, dupErr); err != nil {
+		return nil, fmt.Errorf("duplicating the listener: %w", err)
+	}
+	return os.NewFile(uintptr(fd), ln.Addr().String()), nil
+}
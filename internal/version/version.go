@@ -0,0 +1,92 @@
+// Package version identifies the running build: the version it was stamped
+// with through -ldflags, or failing that what the Go toolchain recorded in
+// the binary.
+package version
+
+import (
+	goruntime "runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Set by the Makefile:
+//
+//	-X safe-agent-sandbox/internal/version.version=$(VERSION)
+//	-X safe-agent-sandbox/internal/version.buildTime=$(BUILD_TIME)
+//
+// commit can be set the same way where the build has no VCS stamp, such as
+// a source archive.
+var (
+	version   string
+	commit    string
+	buildTime string
+)
+
+// Info identifies a build.
+type Info struct {
+	Version   string `json:"version"`            // -ldflags version, else the module version; "dev" for neither
+	Revision  string `json:"revision,omitempty"` // VCS commit
+	Time      string `json:"time,omitempty"`     // -ldflags build time, else the commit time
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running binary's build.
+var Get = sync.OnceValue(func() Info {
+	info, ok := debug.ReadBuildInfo()
+	return fromBuildInfo(info, ok, version, commit, buildTime)
+})
+
+// String is the version with enough of the revision to tell builds of the
+// same version apart: v1.4.0, or dev+3f2c9e1a0b4c-dirty for a local build.
+// It is what executions record as their server_version.
+func (i Info) String() string {
+	s := i.Version
+	if rev := shortRevision(i.Revision); rev != "" && !strings.Contains(s, rev[:7]) {
+		s += "+" + rev
+	}
+	if i.Modified && !strings.HasSuffix(s, "-dirty") {
+		s += "-dirty"
+	}
+	return s
+}
+
+func shortRevision(rev string) string {
+	if len(rev) < 7 {
+		return ""
+	}
+	return rev[:min(len(rev), 12)]
+}
+
+// fromBuildInfo fills an Info from the -ldflags values, falling back to
+// info, which test binaries and go run builds have without VCS settings, and
+// which is missing altogether (ok false) from binaries built without module
+// support.
+func fromBuildInfo(info *debug.BuildInfo, ok bool, ldVersion, ldCommit, ldTime string) Info {
+	i := Info{Version: ldVersion, Revision: ldCommit, Time: ldTime, GoVersion: goruntime.Version()}
+	if ok {
+		i.GoVersion = info.GoVersion
+		if i.Version == "" && info.Main.Version != "(devel)" {
+			i.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if i.Revision == "" {
+					i.Revision = s.Value
+				}
+			case "vcs.time":
+				if i.Time == "" {
+					i.Time = s.Value
+				}
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	if i.Version == "" {
+		i.Version = "dev"
+	}
+	return i
+}
@@ -0,0 +1,60 @@
+package version
+
+import (
+	goruntime "runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestFromBuildInfo(t *testing.T) {
+	vcs := &debug.BuildInfo{
+		GoVersion: "go1.24.1",
+		Main:      debug.Module{Path: "safe-agent-sandbox", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "3f2c9e1a0b4c5d6e7f8091a2b3c4d5e6f708192a"},
+			{Key: "vcs.time", Value: "2026-03-01T09:12:44Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	tests := []struct {
+		name                      string
+		info                      *debug.BuildInfo
+		ok                        bool
+		ldVersion, ldCommit, time string
+		want                      Info
+		str                       string
+	}{
+		{"make build", vcs, true, "v1.4.0-3-g3f2c9e1-dirty", "", "2026-03-02T10:00:00Z",
+			Info{Version: "v1.4.0-3-g3f2c9e1-dirty", Revision: vcs.Settings[0].Value, Time: "2026-03-02T10:00:00Z", Modified: true, GoVersion: "go1.24.1"},
+			"v1.4.0-3-g3f2c9e1-dirty"},
+		{"go build in a checkout", vcs, true, "", "", "",
+			Info{Version: "dev", Revision: vcs.Settings[0].Value, Time: "2026-03-01T09:12:44Z", Modified: true, GoVersion: "go1.24.1"},
+			"dev+3f2c9e1a0b4c-dirty"},
+		{"go install of a release", &debug.BuildInfo{GoVersion: "go1.24.1", Main: debug.Module{Version: "v1.4.0"}}, true, "", "", "",
+			Info{Version: "v1.4.0", GoVersion: "go1.24.1"}, "v1.4.0"},
+		{"test binary, no VCS settings", &debug.BuildInfo{GoVersion: "go1.24.1", Main: debug.Module{Version: "(devel)"}}, true, "", "", "",
+			Info{Version: "dev", GoVersion: "go1.24.1"}, "dev"},
+		{"archive with a stamped commit", &debug.BuildInfo{GoVersion: "go1.24.1"}, true, "v1.4.0", "3f2c9e1a0b4c5d6e", "",
+			Info{Version: "v1.4.0", Revision: "3f2c9e1a0b4c5d6e", GoVersion: "go1.24.1"}, "v1.4.0+3f2c9e1a0b4c"},
+		{"no build info", nil, false, "", "", "",
+			Info{Version: "dev", GoVersion: goruntime.Version()}, "dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fromBuildInfo(tt.info, tt.ok, tt.ldVersion, tt.ldCommit, tt.time)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.str {
+				t.Errorf("String() = %q, want %q", got.String(), tt.str)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	// Test binaries carry build info without VCS settings.
+	if i := Get(); i.Version == "" || i.GoVersion == "" || i.String() == "" {
+		t.Errorf("Get() = %+v", i)
+	}
+}
@@ -0,0 +1,429 @@
+// Package workspace manages server-side scratch directories that API callers
+// upload files into and mount into executions at /workspace.
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNotFound     = errors.New("workspace not found")
+	ErrInvalidPath  = errors.New("invalid workspace path")
+	ErrTooLarge     = errors.New("workspace size limit exceeded")
+	ErrLimitReached = errors.New("workspace limit reached")
+)
+
+// Workspace is the metadata for one workspace. Files live on disk under
+// Store.root/<ID>.
+type Workspace struct {
+	ID        string
+	Owner     string // opaque tenant identifier, compared on every access
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	mu sync.Mutex // serializes uploads so the total cap holds
+}
+
+// FileInfo describes one regular file in a workspace.
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store creates and expires workspaces under a single root directory.
+// Metadata is kept in memory; directories left over from a previous process
+// are removed at startup since their owners can no longer be verified.
+type Store struct {
+	root          string
+	ttl           time.Duration
+	maxFileBytes  int64
+	maxTotalBytes int64
+	maxWorkspaces int
+
+	mu         sync.Mutex
+	workspaces map[string]*Workspace
+
+	cancelCleanup context.CancelFunc
+}
+
+// NewStore creates root if needed, clears stale workspaces and starts the
+// TTL sweeper. Call Close to stop it.
+func NewStore(root string, ttl time.Duration, maxFileBytes, maxTotalBytes int64, maxWorkspaces int) (*Store, error) {
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("workspace root %q must be an absolute path", root)
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("creating workspace root: %w", err)
+	}
+
+	s := &Store{
+		root:          filepath.Clean(root),
+		ttl:           ttl,
+		maxFileBytes:  maxFileBytes,
+		maxTotalBytes: maxTotalBytes,
+		maxWorkspaces: maxWorkspaces,
+		workspaces:    make(map[string]*Workspace),
+	}
+	if n := s.removeStale(); n > 0 {
+		log.Info().Int("count", n).Msg("removed stale workspaces on startup")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelCleanup = cancel
+	go s.cleanupLoop(ctx)
+
+	return s, nil
+}
+
+// Root returns the directory all workspaces live under.
+func (s *Store) Root() string { return s.root }
+
+// MaxFileBytes is the per-file upload cap.
+func (s *Store) MaxFileBytes() int64 { return s.maxFileBytes }
+
+// MaxTotalBytes is the per-workspace size cap.
+func (s *Store) MaxTotalBytes() int64 { return s.maxTotalBytes }
+
+// Close stops the TTL sweeper. Workspaces on disk are left for the next
+// startup to clear.
+func (s *Store) Close() {
+	s.cancelCleanup()
+}
+
+// Create allocates an empty workspace owned by owner.
+func (s *Store) Create(owner string) (*Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxWorkspaces > 0 && len(s.workspaces) >= s.maxWorkspaces {
+		return nil, ErrLimitReached
+	}
+
+	now := time.Now()
+	ws := &Workspace{
+		ID:        uuid.New().String(),
+		Owner:     owner,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	dir := s.dir(ws.ID)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating workspace: %w", err)
+	}
+	// Sandboxed code runs as an unprivileged uid that differs from ours; the
+	// root directory is 0700, so only the mount exposes this.
+	if err := os.Chmod(dir, 0o777); err != nil { // #nosec G302 -- see above
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("creating workspace: %w", err)
+	}
+
+	s.workspaces[ws.ID] = ws
+	return ws, nil
+}
+
+// Get returns the workspace if it exists, has not expired, and belongs to owner.
+// Any mismatch is reported as ErrNotFound so IDs can't be probed.
+func (s *Store) Get(id, owner string) (*Workspace, error) {
+	s.mu.Lock()
+	ws, ok := s.workspaces[id]
+	s.mu.Unlock()
+	if !ok || ws.Owner != owner || time.Now().After(ws.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return ws, nil
+}
+
+// Dir returns the host directory for a workspace the caller owns.
+func (s *Store) Dir(id, owner string) (string, error) {
+	ws, err := s.Get(id, owner)
+	if err != nil {
+		return "", err
+	}
+	return s.dir(ws.ID), nil
+}
+
+// Delete removes a workspace and its files.
+func (s *Store) Delete(id, owner string) error {
+	if _, err := s.Get(id, owner); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.workspaces, id)
+	s.mu.Unlock()
+	return os.RemoveAll(s.dir(id))
+}
+
+// Put stores r at name inside the workspace, creating parent directories.
+// The upload is rejected, and nothing is kept, if it exceeds the per-file cap
+// or would push the workspace past its total cap.
+func (s *Store) Put(id, owner, name string, r io.Reader) (int64, error) {
+	ws, err := s.Get(id, owner)
+	if err != nil {
+		return 0, err
+	}
+	name, err = cleanPath(name)
+	if err != nil {
+		return 0, err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	root, err := os.OpenRoot(s.dir(id))
+	if err != nil {
+		return 0, fmt.Errorf("opening workspace: %w", err)
+	}
+	defer root.Close()
+
+	used, err := usage(root)
+	if err != nil {
+		return 0, err
+	}
+	// Replacing a file frees its current size.
+	if info, err := root.Lstat(name); err == nil && info.Mode().IsRegular() {
+		used -= info.Size()
+	}
+	limit := min(s.maxFileBytes, s.maxTotalBytes-used)
+	if limit < 0 {
+		limit = 0
+	}
+
+	if err := mkdirAll(root, path.Dir(name)); err != nil {
+		return 0, err
+	}
+
+	tmp := path.Join(path.Dir(name), ".upload-"+uuid.New().String())
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPath, name)
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, limit+1))
+	if copyErr == nil {
+		copyErr = f.Chmod(0o666) // #nosec G302 -- writable by the sandbox uid, see Create
+	}
+	closeErr := f.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil && n > limit {
+		copyErr = ErrTooLarge
+	}
+	if copyErr != nil {
+		_ = root.Remove(tmp)
+		return 0, copyErr
+	}
+
+	// os.Root has no Rename before Go 1.25; both paths were validated to stay
+	// inside the workspace, and tmp is not a symlink since we created it.
+	dir := s.dir(id)
+	if err := os.Rename(filepath.Join(dir, filepath.FromSlash(tmp)), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+		_ = root.Remove(tmp)
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPath, name)
+	}
+	return n, nil
+}
+
+// Open returns a reader for a regular file in the workspace. Symlinks that
+// point outside the workspace (e.g. created by an execution) are refused.
+func (s *Store) Open(id, owner, name string) (*os.File, error) {
+	if _, err := s.Get(id, owner); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(s.dir(id))
+	if err != nil {
+		return nil, fmt.Errorf("opening workspace: %w", err)
+	}
+	defer root.Close()
+
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
+
+// List returns every regular file in the workspace, sorted by path.
+func (s *Store) List(id, owner string) ([]FileInfo, error) {
+	if _, err := s.Get(id, owner); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(s.dir(id))
+	if err != nil {
+		return nil, fmt.Errorf("opening workspace: %w", err)
+	}
+	defer root.Close()
+
+	files := []FileInfo{}
+	err = fs.WalkDir(root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, FileInfo{Path: p, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing workspace: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func (s *Store) dir(id string) string {
+	return filepath.Join(s.root, id)
+}
+
+// cleanupLoop removes expired workspaces once a minute.
+func (s *Store) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n := s.cleanupExpired(time.Now()); n > 0 {
+				log.Info().Int("count", n).Msg("removed expired workspaces")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupExpired deletes workspaces whose TTL has passed at now.
+func (s *Store) cleanupExpired(now time.Time) int {
+	s.mu.Lock()
+	var expired []string
+	for id, ws := range s.workspaces {
+		if now.After(ws.ExpiresAt) {
+			expired = append(expired, id)
+			delete(s.workspaces, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range expired {
+		if err := os.RemoveAll(s.dir(id)); err != nil {
+			log.Warn().Err(err).Str("workspace_id", id).Msg("failed to remove expired workspace")
+		}
+	}
+	return len(expired)
+}
+
+// removeStale deletes workspace directories not known to this process.
+func (s *Store) removeStale() int {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, e := range entries {
+		if _, err := uuid.Parse(e.Name()); err != nil {
+			continue // not ours
+		}
+		if err := os.RemoveAll(filepath.Join(s.root, e.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// cleanPath validates a caller-supplied relative path and returns it in
+// slash-separated clean form. Absolute paths, ".." components and the
+// workspace root itself are rejected.
+func cleanPath(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("%w: %q must be relative", ErrInvalidPath, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q must not contain ..", ErrInvalidPath, name)
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || !fs.ValidPath(cleaned) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+	return cleaned, nil
+}
+
+// usage sums the sizes of all regular files under root.
+func usage(root *os.Root) (int64, error) {
+	var total int64
+	err := fs.WalkDir(root.FS(), ".", func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("measuring workspace: %w", err)
+	}
+	return total, nil
+}
+
+// mkdirAll creates dir and its parents inside root, world-writable like the
+// workspace itself (see Store.Create).
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	cur := ""
+	for _, part := range strings.Split(dir, "/") {
+		cur = path.Join(cur, part)
+		err := root.Mkdir(cur, 0o777)
+		if errors.Is(err, fs.ErrExist) {
+			info, statErr := root.Lstat(cur)
+			if statErr != nil || !info.IsDir() {
+				return fmt.Errorf("%w: %s is not a directory", ErrInvalidPath, cur)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPath, cur)
+		}
+		if d, err := root.Open(cur); err == nil {
+			_ = d.Chmod(0o777) // #nosec G302 -- see Store.Create
+			_ = d.Close()
+		}
+	}
+	return nil
+}
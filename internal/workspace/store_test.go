@@ -0,0 +1,227 @@
+package workspace
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, maxFile, maxTotal int64) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir(), time.Hour, maxFile, maxTotal, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"data.csv", "data.csv", false},
+		{"dir/sub/file.txt", "dir/sub/file.txt", false},
+		{"./a//b", "a/b", false},
+		{"", "", true},
+		{".", "", true},
+		{"../escape", "", true},
+		{"a/../../escape", "", true},
+		{"a/..", "", true},
+		{"/etc/passwd", "", true},
+		{`..\windows`, "", true},
+		{"nul\x00byte", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanPath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cleanPath(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("cleanPath(%q) error = %v, want ErrInvalidPath", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("cleanPath(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_PutOpenList(t *testing.T) {
+	s := newTestStore(t, 1024, 4096)
+	ws, err := s.Create("tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Put(ws.ID, "tenant-a", "in/data.csv", strings.NewReader("a,b\n1,2\n")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	f, err := s.Open(ws.ID, "tenant-a", "in/data.csv")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "a,b\n1,2\n" {
+		t.Errorf("read back %q", data)
+	}
+
+	files, err := s.List(ws.ID, "tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "in/data.csv" || files[0].Size != 8 {
+		t.Errorf("List = %+v", files)
+	}
+}
+
+func TestStore_PathTraversalRejected(t *testing.T) {
+	s := newTestStore(t, 1024, 4096)
+	ws, _ := s.Create("")
+
+	for _, name := range []string{"../outside", "/etc/passwd", "a/../../outside"} {
+		if _, err := s.Put(ws.ID, "", name, strings.NewReader("x")); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Put(%q) error = %v, want ErrInvalidPath", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.Root(), "outside")); !os.IsNotExist(err) {
+		t.Error("file was written outside the workspace")
+	}
+}
+
+func TestStore_SymlinkEscapeRejected(t *testing.T) {
+	s := newTestStore(t, 1024, 4096)
+	ws, _ := s.Create("")
+	dir, _ := s.Dir(ws.ID, "")
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("host data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// An execution with a writable mount could plant these.
+	if err := os.Symlink(secret, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(dir, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Open(ws.ID, "", "link"); err == nil {
+		t.Error("Open followed a symlink out of the workspace")
+	}
+	if _, err := s.Put(ws.ID, "", "linkdir/planted", strings.NewReader("x")); err == nil {
+		t.Error("Put wrote through a symlinked directory")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(secret), "planted")); !os.IsNotExist(err) {
+		t.Error("file was written outside the workspace")
+	}
+}
+
+func TestStore_SizeCaps(t *testing.T) {
+	s := newTestStore(t, 10, 15)
+	ws, _ := s.Create("")
+
+	if _, err := s.Put(ws.ID, "", "big", strings.NewReader(strings.Repeat("x", 11))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over per-file cap: error = %v, want ErrTooLarge", err)
+	}
+	if _, err := s.Put(ws.ID, "", "a", strings.NewReader(strings.Repeat("x", 10))); err != nil {
+		t.Fatalf("at per-file cap: %v", err)
+	}
+	if _, err := s.Put(ws.ID, "", "b", strings.NewReader(strings.Repeat("x", 6))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over total cap: error = %v, want ErrTooLarge", err)
+	}
+	// Replacing a file only counts the new size.
+	if _, err := s.Put(ws.ID, "", "a", strings.NewReader(strings.Repeat("y", 10))); err != nil {
+		t.Errorf("replace within cap: %v", err)
+	}
+
+	files, _ := s.List(ws.ID, "")
+	if len(files) != 1 {
+		t.Errorf("rejected uploads left files behind: %+v", files)
+	}
+}
+
+func TestStore_Ownership(t *testing.T) {
+	s := newTestStore(t, 1024, 4096)
+	ws, _ := s.Create("tenant-a")
+
+	if _, err := s.Get(ws.ID, "tenant-b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get by other tenant: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Put(ws.ID, "tenant-b", "f", strings.NewReader("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Put by other tenant: error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ws.ID, "tenant-b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete by other tenant: error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ws.ID, "tenant-a"); err != nil {
+		t.Errorf("Delete by owner: %v", err)
+	}
+}
+
+func TestStore_TTLCleanup(t *testing.T) {
+	s := newTestStore(t, 1024, 4096)
+	ws, _ := s.Create("")
+	dir, _ := s.Dir(ws.ID, "")
+
+	if n := s.cleanupExpired(time.Now()); n != 0 {
+		t.Errorf("cleanupExpired before TTL removed %d", n)
+	}
+	if n := s.cleanupExpired(ws.ExpiresAt.Add(time.Second)); n != 1 {
+		t.Errorf("cleanupExpired after TTL removed %d, want 1", n)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expired workspace directory still exists")
+	}
+	if _, err := s.Get(ws.ID, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after expiry: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStore_Limit(t *testing.T) {
+	s, err := NewStore(t.TempDir(), time.Hour, 10, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Create(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(""); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("Create over limit: error = %v, want ErrLimitReached", err)
+	}
+}
+
+func TestNewStore_RemovesStale(t *testing.T) {
+	root := t.TempDir()
+	stale := filepath.Join(root, "0b6e7c1e-6f3a-4b8e-9a51-2d7c0f5e9a10")
+	other := filepath.Join(root, "not-a-workspace")
+	for _, d := range []string{stale, other} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := NewStore(root, time.Hour, 10, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale workspace was not removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("unrelated directory was removed")
+	}
+}
@@ -0,0 +1,311 @@
+package conformance
+
+// Catalog returns the built-in scenarios: a baseline per language that must
+// run, then the attempts that must be blocked, gathered from the e2e and
+// escape tests. Each call returns a fresh slice.
+func Catalog() []Scenario {
+	return []Scenario{
+		// Baselines: without them a "blocked" result could just be code
+		// that never ran.
+		{
+			Name:        "python_runs",
+			Description: "Benign python runs and prints",
+			Language:    "python",
+			Code:        `print("conformance ok")`,
+			Expect:      Permitted,
+			Want:        "conformance ok",
+			Severity:    SeverityHigh,
+		},
+		{
+			Name:        "bash_runs",
+			Description: "Benign bash runs and prints",
+			Language:    "bash",
+			Code:        `echo "conformance ok"`,
+			Expect:      Permitted,
+			Want:        "conformance ok",
+			Severity:    SeverityHigh,
+		},
+		{
+			Name:        "node_runs",
+			Description: "Benign node runs and prints",
+			Language:    "node",
+			Code:        `console.log("conformance ok")`,
+			Expect:      Permitted,
+			Want:        "conformance ok",
+			Severity:    SeverityHigh,
+		},
+		{
+			Name:        "tmp_writable",
+			Description: "/tmp is a writable tmpfs",
+			Language:    "bash",
+			Code:        `echo "tmpfs works" > /tmp/conformance && cat /tmp/conformance`,
+			Expect:      Permitted,
+			Want:        "tmpfs works",
+			Severity:    SeverityMedium,
+		},
+
+		// Filesystem.
+		{
+			Name:        "read_etc_shadow",
+			Description: "/etc/shadow is unreadable",
+			Language:    "python",
+			Code: `
+try:
+    data = open("/etc/shadow").read()
+    print("ESCAPE: read /etc/shadow:", data[:40])
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "write_rootfs",
+			Description: "The root filesystem is read-only",
+			Language:    "python",
+			Code: `
+try:
+    with open("/etc/conformance", "w") as f:
+        f.write("pwned")
+    print("ESCAPE: wrote /etc/conformance")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+		{
+			Name:        "docker_socket",
+			Description: "No container runtime socket is mounted",
+			Language:    "python",
+			Code: `
+import os
+for path in ("/var/run/docker.sock", "/run/docker.sock", "/run/containerd/containerd.sock"):
+    if os.path.exists(path):
+        print("ESCAPE: found", path)
+print("checked")
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "proc_sysrq",
+			Description: "/proc/sysrq-trigger is not writable",
+			Language:    "bash",
+			Code:        `if echo h > /proc/sysrq-trigger 2>/dev/null; then echo "ESCAPE: wrote /proc/sysrq-trigger"; else echo blocked; fi`,
+			Expect:      Blocked,
+			Severity:    SeverityHigh,
+		},
+
+		// Privileges and syscalls.
+		{
+			Name:        "capabilities_dropped",
+			Description: "The process has no effective capabilities",
+			Language:    "python",
+			Code: `
+for line in open("/proc/self/status"):
+    if line.startswith("CapEff:"):
+        caps = int(line.split()[1], 16)
+        print("ESCAPE: effective capabilities %x" % caps if caps else "no capabilities")
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "seccomp_filter",
+			Description: "The process runs under a seccomp filter",
+			Language:    "python",
+			Code: `
+status = dict(l.split(":", 1) for l in open("/proc/self/status") if ":" in l)
+mode = status.get("Seccomp", "").strip()
+print("seccomp mode", mode if mode == "2" else "ESCAPE: seccomp mode %r" % mode)
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "no_new_privs",
+			Description: "no_new_privs is set, so setuid binaries can't raise privileges",
+			Language:    "python",
+			Code: `
+status = dict(l.split(":", 1) for l in open("/proc/self/status") if ":" in l)
+flag = status.get("NoNewPrivs", "").strip()
+print("no_new_privs", flag if flag == "1" else "ESCAPE: NoNewPrivs %r" % flag)
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+		{
+			Name:        "setuid_root",
+			Description: "setuid(0) fails",
+			Language:    "python",
+			Code: `
+import os
+try:
+    os.setuid(0)
+    print("ESCAPE: setuid(0) succeeded")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "mount_syscall",
+			Description: "mount(2) fails",
+			Language:    "python",
+			Code: `
+import ctypes, ctypes.util, os
+libc = ctypes.CDLL(ctypes.util.find_library("c"), use_errno=True)
+if libc.mount(b"none", b"/tmp", b"tmpfs", 0, None) == 0:
+    print("ESCAPE: mount succeeded")
+else:
+    print("blocked:", os.strerror(ctypes.get_errno()))
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "ptrace",
+			Description: "ptrace(2) fails",
+			Language:    "python",
+			Code: `
+import ctypes, ctypes.util, os
+libc = ctypes.CDLL(ctypes.util.find_library("c"), use_errno=True)
+if libc.ptrace(0, 0, None, None) == 0:
+    print("ESCAPE: ptrace succeeded")
+else:
+    print("blocked:", os.strerror(ctypes.get_errno()))
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+		{
+			Name:        "chroot",
+			Description: "chroot(2) fails",
+			Language:    "python",
+			Code: `
+import os
+try:
+    os.chroot("/tmp")
+    print("ESCAPE: chroot succeeded")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+		{
+			Name:        "sethostname",
+			Description: "The hostname can't be changed",
+			Language:    "python",
+			Code: `
+import socket
+try:
+    socket.sethostname("conformance")
+    print("ESCAPE: sethostname succeeded")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityMedium,
+		},
+
+		// Network.
+		{
+			Name:        "network_egress",
+			Description: "Code without network permission can't connect out",
+			Language:    "python",
+			Code: `
+import socket
+try:
+    socket.create_connection(("1.1.1.1", 53), timeout=3).close()
+    print("ESCAPE: connected to 1.1.1.1:53")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "cloud_metadata",
+			Description: "The cloud metadata endpoint is unreachable",
+			Language:    "python",
+			Code: `
+import socket
+try:
+    socket.create_connection(("169.254.169.254", 80), timeout=3).close()
+    print("ESCAPE: connected to 169.254.169.254:80")
+except OSError as e:
+    print("blocked:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+		{
+			Name:        "network_when_enabled",
+			Description: "Code with network permission can connect out",
+			Language:    "python",
+			Code: `
+import socket
+socket.create_connection(("1.1.1.1", 53), timeout=5).close()
+print("network ok")
+`,
+			Expect:   Permitted,
+			Want:     "network ok",
+			Severity: SeverityMedium,
+			Network:  true,
+			Requires: []string{FeatureNetwork},
+		},
+
+		// Secrets.
+		{
+			Name:        "host_secrets",
+			Description: "No server credentials reach the environment",
+			Language:    "python",
+			Code: `
+import os
+for key in os.environ:
+    if any(s in key for s in ("ANTHROPIC", "CLAUDE_CODE_OAUTH", "AWS_SECRET", "SANDBOX_API_KEY", "TOKEN")):
+        print("ESCAPE: environment has", key)
+print("checked")
+`,
+			Expect:   Blocked,
+			Severity: SeverityCritical,
+		},
+
+		// Resource limits.
+		{
+			Name:        "fork_bomb",
+			Description: "The pids limit stops a fork bomb",
+			Language:    "python",
+			Code: `
+import os, time
+pids = []
+try:
+    for _ in range(1000):
+        pid = os.fork()
+        if pid == 0:
+            time.sleep(5)
+            os._exit(0)
+        pids.append(pid)
+    print("ESCAPE: forked", len(pids), "processes")
+except OSError as e:
+    print("blocked after", len(pids), "forks:", e)
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+		{
+			Name:        "memory_bomb",
+			Description: "The memory limit stops a 2GB allocation",
+			Language:    "python",
+			Code: `
+data = b"A" * (2 << 30)
+print("ESCAPE: allocated", len(data) >> 20, "MB")
+`,
+			Expect:   Blocked,
+			Severity: SeverityHigh,
+		},
+	}
+}
@@ -0,0 +1,284 @@
+// Package conformance checks that a sandbox deployment still isolates the
+// code it runs. It holds a catalog of scenarios, adversarial programs that
+// must be blocked and benign ones that must still run, and evaluates what a
+// server did with each into a pass/fail report with evidence.
+//
+// Operators changing seccomp profiles, images or network policy run it with
+// `sandbox-cli verify`; deployments can embed it in their own CI:
+//
+//	report := conformance.Run(ctx, &conformance.HTTPExecutor{URL: url, APIKey: key}, conformance.Catalog(), conformance.Options{})
+//	report.WriteText(os.Stdout)
+//	if !report.OK() {
+//		os.Exit(1)
+//	}
+//
+// A blocked scenario's code prints EscapeMarker if, and only if, its attempt
+// worked, so adding one is a matter of writing the attempt and the print.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// EscapeMarker is what a blocked scenario prints once its attempt has
+// succeeded. Its presence in stdout is an isolation regression.
+const EscapeMarker = "ESCAPE:"
+
+// Outcome is what a scenario must come to for the deployment to conform.
+type Outcome string
+
+const (
+	Blocked   Outcome = "blocked"   // the attempt must not succeed
+	Permitted Outcome = "permitted" // the code must run and print Want
+)
+
+// Severity ranks the isolation a scenario guards.
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical" // host or credential compromise
+	SeverityHigh     Severity = "high"     // a sandbox boundary or limit
+	SeverityMedium   Severity = "medium"   // defense in depth
+)
+
+// FeatureNetwork is declared by deployments whose network_enabled
+// executions reach the internet (the docker backend, or containerd with
+// sandbox.cni).
+const FeatureNetwork = "network"
+
+// Scenario is one program and what the deployment must do with it.
+type Scenario struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Language    string   `json:"language"`
+	Code        string   `json:"code"`
+	Expect      Outcome  `json:"expect"`
+	Want        string   `json:"want,omitempty"` // stdout a permitted scenario must print
+	Severity    Severity `json:"severity"`
+	Network     bool     `json:"network,omitempty"`  // run with permissions.network.enabled
+	Requires    []string `json:"requires,omitempty"` // features the deployment must declare, or the scenario is skipped
+}
+
+// validate checks a scenario loaded from data.
+func (s Scenario) validate() error {
+	switch {
+	case s.Name == "":
+		return fmt.Errorf("scenario without a name")
+	case s.Language == "" || s.Code == "":
+		return fmt.Errorf("scenario %s: language and code are required", s.Name)
+	case s.Expect != Blocked && s.Expect != Permitted:
+		return fmt.Errorf("scenario %s: expect must be %s or %s, not %q", s.Name, Blocked, Permitted, s.Expect)
+	case s.Expect == Permitted && s.Want == "":
+		return fmt.Errorf("scenario %s: a permitted scenario needs want", s.Name)
+	}
+	switch s.Severity {
+	case SeverityCritical, SeverityHigh, SeverityMedium:
+		return nil
+	default:
+		return fmt.Errorf("scenario %s: severity must be critical, high or medium, not %q", s.Name, s.Severity)
+	}
+}
+
+// LoadScenarios reads a JSON array of scenarios, for adding a deployment's
+// own to the catalog. Names must not repeat each other or the catalog's.
+func LoadScenarios(r io.Reader) ([]Scenario, error) {
+	var scenarios []Scenario
+	if err := json.NewDecoder(r).Decode(&scenarios); err != nil {
+		return nil, fmt.Errorf("decoding scenarios: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, s := range Catalog() {
+		seen[s.Name] = true
+	}
+	for _, s := range scenarios {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("scenario %s is defined twice", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return scenarios, nil
+}
+
+// Result is what the server did with a scenario's code.
+type Result struct {
+	Status    int    // HTTP status
+	ErrorCode string // API error code, for a status other than 200
+	Error     string // API error message
+	ExitCode  int
+	ExitClass string
+	Stdout    string
+	Stderr    string
+}
+
+// Executor runs a scenario's code on the deployment under test. An error
+// means the deployment could not be asked, not that the code failed.
+type Executor interface {
+	Execute(ctx context.Context, s Scenario) (Result, error)
+}
+
+// Status is a scenario's verdict.
+type Status string
+
+const (
+	StatusPass  Status = "pass"
+	StatusFail  Status = "fail"  // the deployment did not do what the scenario requires
+	StatusSkip  Status = "skip"  // the scenario doesn't apply to the deployment
+	StatusError Status = "error" // the scenario couldn't be run or its result proves nothing
+)
+
+// Verdict is one scenario's line in the report.
+type Verdict struct {
+	Scenario string
+	Severity Severity
+	Expect   Outcome
+	Language string
+	Status   Status
+	Reason   string
+	Evidence string // excerpt of the output backing the verdict
+}
+
+// excerptBytes is the most output a verdict quotes.
+const excerptBytes = 200
+
+// Evaluate decides a scenario's verdict from its result.
+func Evaluate(s Scenario, r Result) Verdict {
+	v := Verdict{Scenario: s.Name, Severity: s.Severity, Expect: s.Expect, Language: s.Language}
+	switch {
+	case r.Status == 400 && r.ErrorCode == "VALIDATION_ERROR" && strings.Contains(r.Error, "unsupported language"):
+		v.Status, v.Reason = StatusSkip, fmt.Sprintf("the server does not run %s", s.Language)
+		return v
+	case r.Status == 403 && r.ErrorCode == "SECURITY_BLOCKED":
+		v.Status, v.Reason = StatusPass, "refused before running (SECURITY_BLOCKED)"
+		if s.Expect == Permitted {
+			v.Status, v.Reason = StatusFail, "benign code refused (SECURITY_BLOCKED)"
+		}
+		return v
+	case r.Status != 200:
+		v.Status, v.Reason = StatusError, fmt.Sprintf("HTTP %d %s: %s", r.Status, r.ErrorCode, r.Error)
+		return v
+	}
+
+	ended := fmt.Sprintf("exit %d (%s)", r.ExitCode, r.ExitClass)
+	if s.Expect == Blocked {
+		if i := strings.Index(r.Stdout, EscapeMarker); i >= 0 {
+			v.Status, v.Reason, v.Evidence = StatusFail, "the attempt succeeded", excerpt(r.Stdout[i:])
+			return v
+		}
+		v.Status, v.Reason, v.Evidence = StatusPass, "stopped: "+ended, excerpt(r.Stdout+r.Stderr)
+		return v
+	}
+	if r.ExitCode == 0 && strings.Contains(r.Stdout, s.Want) {
+		v.Status, v.Reason = StatusPass, "ran"
+		return v
+	}
+	v.Status = StatusFail
+	v.Reason = fmt.Sprintf("%s, want exit 0 printing %q", ended, s.Want)
+	v.Evidence = excerpt(r.Stdout + r.Stderr)
+	return v
+}
+
+// excerpt is the start of text, on one line.
+func excerpt(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) > excerptBytes {
+		text = text[:excerptBytes] + "..."
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Options describe the deployment under test.
+type Options struct {
+	// Features the deployment has, such as FeatureNetwork. Scenarios
+	// requiring others are skipped.
+	Features []string
+}
+
+// Run executes the scenarios in order and evaluates each.
+//
+// A block only counts if the language can run at all: if a permitted,
+// network-free scenario for a language doesn't pass, that language's blocked
+// scenarios are errors rather than passes.
+func Run(ctx context.Context, exec Executor, scenarios []Scenario, opts Options) Report {
+	var report Report
+	baselineFailed := make(map[string]string) // language -> failed baseline scenario
+	for _, s := range scenarios {
+		if missing := missingFeatures(s.Requires, opts.Features); len(missing) > 0 {
+			report.Verdicts = append(report.Verdicts, Verdict{Scenario: s.Name, Severity: s.Severity, Expect: s.Expect, Language: s.Language,
+				Status: StatusSkip, Reason: "needs " + strings.Join(missing, ", ")})
+			continue
+		}
+		var v Verdict
+		r, err := exec.Execute(ctx, s)
+		if err != nil {
+			v = Verdict{Scenario: s.Name, Severity: s.Severity, Expect: s.Expect, Language: s.Language, Status: StatusError, Reason: err.Error()}
+		} else {
+			v = Evaluate(s, r)
+		}
+		if s.Expect == Permitted && !s.Network && (v.Status == StatusFail || v.Status == StatusError) {
+			baselineFailed[s.Language] = s.Name
+		}
+		report.Verdicts = append(report.Verdicts, v)
+	}
+	for i, v := range report.Verdicts {
+		if name, ok := baselineFailed[v.Language]; ok && v.Expect == Blocked && v.Status == StatusPass {
+			report.Verdicts[i].Status = StatusError
+			report.Verdicts[i].Reason = fmt.Sprintf("inconclusive: %s code does not run (%s failed)", v.Language, name)
+		}
+	}
+	return report
+}
+
+func missingFeatures(required, have []string) []string {
+	var missing []string
+	for _, f := range required {
+		if !slices.Contains(have, f) {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// Report is the verdict on every scenario run.
+type Report struct {
+	Verdicts []Verdict
+}
+
+// Count returns how many verdicts have status.
+func (r Report) Count(status Status) int {
+	n := 0
+	for _, v := range r.Verdicts {
+		if v.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether every scenario that applied passed.
+func (r Report) OK() bool {
+	return r.Count(StatusFail) == 0 && r.Count(StatusError) == 0
+}
+
+// WriteText writes the report as a table, with the evidence under each
+// failure, and a summary line.
+func (r Report) WriteText(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, v := range r.Verdicts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.ToUpper(string(v.Status)), v.Severity, v.Scenario, v.Reason)
+		if v.Status == StatusFail && v.Evidence != "" {
+			fmt.Fprintf(tw, "\t\t\toutput: %s\n", v.Evidence)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d scenarios: %d passed, %d failed, %d skipped, %d errors\n", len(r.Verdicts),
+		r.Count(StatusPass), r.Count(StatusFail), r.Count(StatusSkip), r.Count(StatusError))
+}
@@ -0,0 +1,165 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, s := range Catalog() {
+		if err := s.validate(); err != nil {
+			t.Error(err)
+		}
+		if seen[s.Name] {
+			t.Errorf("%s defined twice", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Expect == Blocked && !strings.Contains(s.Code, EscapeMarker) {
+			t.Errorf("%s: blocked scenario never prints %s", s.Name, EscapeMarker)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	blocked := Scenario{Name: "mount", Language: "python", Expect: Blocked, Severity: SeverityCritical}
+	permitted := Scenario{Name: "runs", Language: "python", Expect: Permitted, Want: "ok", Severity: SeverityHigh}
+	tests := []struct {
+		name         string
+		scenario     Scenario
+		result       Result
+		want         Status
+		wantEvidence string
+	}{
+		{"blocked attempt", blocked, Result{Status: 200, Stdout: "blocked: Operation not permitted\n", ExitClass: "user_exit"}, StatusPass, "blocked: Operation not permitted"},
+		{"killed attempt", blocked, Result{Status: 200, ExitCode: 137, ExitClass: "oom_kill"}, StatusPass, ""},
+		{"escape", blocked, Result{Status: 200, Stdout: "starting\nESCAPE: mount succeeded\n"}, StatusFail, "ESCAPE: mount succeeded"},
+		{"escape after a kill", blocked, Result{Status: 200, Stdout: "ESCAPE: forked 1000", ExitCode: -1, ExitClass: "timeout_kill"}, StatusFail, "ESCAPE: forked 1000"},
+		{"escape on stderr only", blocked, Result{Status: 200, Stderr: "ESCAPE: not a marker there"}, StatusPass, "ESCAPE: not a marker there"},
+		{"refused", blocked, Result{Status: 403, ErrorCode: "SECURITY_BLOCKED"}, StatusPass, ""},
+		{"benign refused", permitted, Result{Status: 403, ErrorCode: "SECURITY_BLOCKED"}, StatusFail, ""},
+		{"benign ran", permitted, Result{Status: 200, Stdout: "ok\n"}, StatusPass, ""},
+		{"benign failed", permitted, Result{Status: 200, Stdout: "ok\n", Stderr: "Traceback", ExitCode: 1}, StatusFail, "ok Traceback"},
+		{"benign silent", permitted, Result{Status: 200}, StatusFail, ""},
+		{"unsupported language", blocked, Result{Status: 400, ErrorCode: "VALIDATION_ERROR", Error: "execution x: validate: unsupported language: python"}, StatusSkip, ""},
+		{"throttled", blocked, Result{Status: 429, ErrorCode: "RATE_LIMITED", Error: "rate limit exceeded"}, StatusError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Evaluate(tt.scenario, tt.result)
+			if v.Status != tt.want || v.Evidence != tt.wantEvidence {
+				t.Errorf("verdict = %s (%s) evidence %q, want %s evidence %q", v.Status, v.Reason, v.Evidence, tt.want, tt.wantEvidence)
+			}
+		})
+	}
+
+	long := Evaluate(blocked, Result{Status: 200, Stdout: "ESCAPE: " + strings.Repeat("x", 1000)})
+	if len(long.Evidence) != excerptBytes+len("...") {
+		t.Errorf("evidence of %d bytes, want cut to %d", len(long.Evidence), excerptBytes)
+	}
+}
+
+// fakeExecutor returns canned results by scenario name.
+type fakeExecutor map[string]Result
+
+func (f fakeExecutor) Execute(_ context.Context, s Scenario) (Result, error) {
+	r, ok := f[s.Name]
+	if !ok {
+		return Result{}, errors.New("connection refused")
+	}
+	return r, nil
+}
+
+func TestRun(t *testing.T) {
+	scenarios := []Scenario{
+		{Name: "python_runs", Language: "python", Expect: Permitted, Want: "ok"},
+		{Name: "node_runs", Language: "node", Expect: Permitted, Want: "ok"},
+		{Name: "python_mount", Language: "python", Expect: Blocked},
+		{Name: "node_mount", Language: "node", Expect: Blocked},
+		{Name: "network", Language: "python", Expect: Permitted, Want: "ok", Network: true, Requires: []string{FeatureNetwork}},
+		{Name: "unreachable", Language: "python", Expect: Blocked},
+	}
+	exec := fakeExecutor{
+		"python_runs":  {Status: 200, Stdout: "ok"},
+		"node_runs":    {Status: 200, ExitCode: 127, Stderr: "node: not found"},
+		"python_mount": {Status: 200, Stdout: "blocked"},
+		"node_mount":   {Status: 200, ExitCode: 127},
+	}
+
+	report := Run(context.Background(), exec, scenarios, Options{})
+	want := map[string]Status{
+		"python_runs":  StatusPass,
+		"node_runs":    StatusFail,
+		"python_mount": StatusPass,
+		"node_mount":   StatusError, // node can't run anything, so its block proves nothing
+		"network":      StatusSkip,
+		"unreachable":  StatusError,
+	}
+	for _, v := range report.Verdicts {
+		if v.Status != want[v.Scenario] {
+			t.Errorf("%s: %s (%s), want %s", v.Scenario, v.Status, v.Reason, want[v.Scenario])
+		}
+	}
+	if report.OK() {
+		t.Error("report with failures is OK")
+	}
+
+	exec["network"] = Result{Status: 200, Stdout: "ok"}
+	report = Run(context.Background(), exec, scenarios[4:5], Options{Features: []string{FeatureNetwork}})
+	if !report.OK() || report.Count(StatusPass) != 1 {
+		t.Errorf("with the network feature: %+v", report.Verdicts)
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	extra, err := LoadScenarios(strings.NewReader(`[{"name":"read_vault","language":"bash","code":"cat /vault/token && echo ESCAPE: read","expect":"blocked","severity":"critical"}]`))
+	if err != nil || len(extra) != 1 || extra[0].Expect != Blocked {
+		t.Fatalf("LoadScenarios = %+v, %v", extra, err)
+	}
+	for name, data := range map[string]string{
+		"duplicate of the catalog": `[{"name":"read_etc_shadow","language":"bash","code":"x","expect":"blocked","severity":"high"}]`,
+		"bad outcome":              `[{"name":"a","language":"bash","code":"x","expect":"maybe","severity":"high"}]`,
+		"permitted without want":   `[{"name":"a","language":"bash","code":"x","expect":"permitted","severity":"high"}]`,
+		"no severity":              `[{"name":"a","language":"bash","code":"x","expect":"blocked"}]`,
+	} {
+		if _, err := LoadScenarios(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestHTTPExecutor(t *testing.T) {
+	var got map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/execute" || r.Header.Get("X-API-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"authentication required","code":"AUTH_REQUIRED"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"output":"blocked\n","stderr":"","exit_code":0,"exit_class":"user_exit"}`))
+	}))
+	defer ts.Close()
+
+	s := Scenario{Name: "net", Language: "python", Code: "x", Network: true}
+	r, err := (&HTTPExecutor{URL: ts.URL + "/", APIKey: "key"}).Execute(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != 200 || r.Stdout != "blocked\n" || r.ExitClass != "user_exit" {
+		t.Errorf("result = %+v", r)
+	}
+	if got["timeout"] != "15s" || got["permissions"] == nil {
+		t.Errorf("request = %v", got)
+	}
+
+	r, err = (&HTTPExecutor{URL: ts.URL}).Execute(context.Background(), s)
+	if err != nil || r.Status != http.StatusUnauthorized || r.ErrorCode != "AUTH_REQUIRED" {
+		t.Errorf("unauthenticated: %+v, %v", r, err)
+	}
+}
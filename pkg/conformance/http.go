@@ -0,0 +1,88 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is each scenario's execution timeout unless
+// HTTPExecutor.Timeout says otherwise.
+const DefaultTimeout = 15 * time.Second
+
+// HTTPExecutor runs scenarios through a server's POST /execute.
+type HTTPExecutor struct {
+	URL     string        // server base URL, e.g. http://localhost:8080
+	APIKey  string        // sent as X-API-Key when set
+	Timeout time.Duration // execution timeout; DefaultTimeout when 0
+	Client  *http.Client  // defaults to one allowing the timeout plus a margin
+}
+
+func (e *HTTPExecutor) Execute(ctx context.Context, s Scenario) (Result, error) {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	payload := map[string]any{
+		"language": s.Language,
+		"code":     s.Code,
+		"timeout":  timeout.String(),
+	}
+	if s.Network {
+		payload["permissions"] = map[string]any{"network": map[string]any{"enabled": true}}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.URL, "/")+"/execute", bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("X-API-Key", e.APIKey)
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: timeout + 30*time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, fmt.Errorf("reading response: %w", err)
+	}
+
+	r := Result{Status: resp.StatusCode}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil {
+			r.ErrorCode, r.Error = apiErr.Code, apiErr.Error
+		}
+		return r, nil
+	}
+	var out struct {
+		Output    string `json:"output"`
+		Stderr    string `json:"stderr"`
+		ExitCode  int    `json:"exit_code"`
+		ExitClass string `json:"exit_class"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Result{}, fmt.Errorf("decoding response: %w", err)
+	}
+	r.Stdout, r.Stderr, r.ExitCode, r.ExitClass = out.Output, out.Stderr, out.ExitCode, out.ExitClass
+	return r, nil
+}
@@ -2,6 +2,7 @@ package seccomp
 
 import (
 	"encoding/json"
+	"slices"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
@@ -89,19 +90,33 @@ func baseSyscalls(b *ProfileBuilder) *ProfileBuilder {
 		})
 }
 
-func dangerousSyscalls(b *ProfileBuilder) *ProfileBuilder {
+// trappedSyscalls raise SIGSYS rather than failing.
+var trappedSyscalls = []string{
+	"ptrace",
+	"process_vm_readv", "process_vm_writev",
+	"keyctl",
+	"add_key", "request_key",
+	"bpf",
+	"perf_event_open",
+	"userfaultfd",
+	"kexec_load", "kexec_file_load",
+	"finit_module", "init_module", "delete_module",
+}
+
+// dangerousSyscalls traps and blocks what no sandboxed code gets, except
+// allowed: a syscall can't be both allowed and trapped.
+func dangerousSyscalls(b *ProfileBuilder, allowed ...string) *ProfileBuilder {
+	trapped := make([]string, 0, len(trappedSyscalls))
+	for _, name := range trappedSyscalls {
+		if !slices.Contains(allowed, name) {
+			trapped = append(trapped, name)
+		}
+	}
+	if len(allowed) > 0 {
+		b = b.AllowSyscalls(allowed...)
+	}
 	return b.
-		TrapSyscalls(
-			"ptrace",
-			"process_vm_readv", "process_vm_writev",
-			"keyctl",
-			"add_key", "request_key",
-			"bpf",
-			"perf_event_open",
-			"userfaultfd",
-			"kexec_load", "kexec_file_load",
-			"finit_module", "init_module", "delete_module",
-		).
+		TrapSyscalls(trapped...).
 		BlockSyscalls(
 			"mount", "umount2", "pivot_root",
 			"reboot",
@@ -126,6 +141,19 @@ func DefaultProfile() *specs.LinuxSeccomp {
 	return b.Build()
 }
 
+// TraceProfile is DefaultProfile, or NetworkAllowProfile with network,
+// allowing ptrace as well: a debug trace runs the code under strace, which
+// needs it. Only executions that asked for a trace get it.
+func TraceProfile(network bool) *specs.LinuxSeccomp {
+	b := NewBuilder()
+	b = baseSyscalls(b)
+	if network {
+		b = networkSyscalls(b)
+	}
+	b = dangerousSyscalls(b, "ptrace")
+	return b.Build()
+}
+
 // dockerSeccompProfile mirrors the Docker daemon's seccomp profile JSON format.
 type dockerSeccompProfile struct {
 	DefaultAction string               `json:"defaultAction"`
@@ -156,6 +184,11 @@ func DockerNetworkProfileJSON() ([]byte, error) {
 	return profileToDockerJSON(NetworkAllowProfile())
 }
 
+// DockerTraceProfileJSON exports TraceProfile as Docker-format JSON.
+func DockerTraceProfileJSON(network bool) ([]byte, error) {
+	return profileToDockerJSON(TraceProfile(network))
+}
+
 func profileToDockerJSON(profile *specs.LinuxSeccomp) ([]byte, error) {
 	actionMap := map[specs.LinuxSeccompAction]string{
 		specs.ActAllow: "SCMP_ACT_ALLOW",
@@ -209,16 +242,17 @@ func profileToDockerJSON(profile *specs.LinuxSeccomp) ([]byte, error) {
 func NetworkAllowProfile() *specs.LinuxSeccomp {
 	b := NewBuilder()
 	b = baseSyscalls(b)
+	b = networkSyscalls(b)
+	b = dangerousSyscalls(b)
+	return b.Build()
+}
 
-	// Network syscalls
-	b.AllowSyscalls(
+func networkSyscalls(b *ProfileBuilder) *ProfileBuilder {
+	return b.AllowSyscalls(
 		"socket", "connect", "bind", "listen", "accept", "accept4",
 		"sendto", "recvfrom", "sendmsg", "recvmsg",
 		"getsockopt", "setsockopt",
 		"getsockname", "getpeername",
 		"shutdown",
 	)
-
-	b = dangerousSyscalls(b)
-	return b.Build()
 }
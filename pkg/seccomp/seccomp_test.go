@@ -113,3 +113,33 @@ func TestProfileBuilder(t *testing.T) {
 		t.Errorf("names = %v, want [read write]", rule.Names)
 	}
 }
+
+func TestTraceProfile_AllowsPtraceOnly(t *testing.T) {
+	actions := func(p *specs.LinuxSeccomp) map[string]specs.LinuxSeccompAction {
+		m := make(map[string]specs.LinuxSeccompAction)
+		for _, rule := range p.Syscalls {
+			for _, name := range rule.Names {
+				if prev, dup := m[name]; dup && prev != rule.Action {
+					t.Errorf("%s has conflicting rules", name)
+				}
+				m[name] = rule.Action
+			}
+		}
+		return m
+	}
+	for _, network := range []bool{false, true} {
+		got := actions(TraceProfile(network))
+		if got["ptrace"] != specs.ActAllow {
+			t.Errorf("network %v: ptrace %v, want allowed", network, got["ptrace"])
+		}
+		if got["process_vm_writev"] != specs.ActTrap || got["bpf"] != specs.ActTrap {
+			t.Errorf("network %v: the other dangerous syscalls aren't trapped", network)
+		}
+		if _, ok := got["socket"]; ok != network {
+			t.Errorf("network %v: socket in the profile %v", network, ok)
+		}
+	}
+	if got := actions(DefaultProfile()); got["ptrace"] != specs.ActTrap {
+		t.Errorf("default profile: ptrace %v, want trapped", got["ptrace"])
+	}
+}
@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// MaxEventSize bounds the bytes of one event, framing included, that a
+// Reader will buffer. The server's events are far smaller: output arrives in
+// pipe-sized chunks.
+const MaxEventSize = 4 << 20
+
+// ErrEventTooLarge is returned by Reader.Next for an event over MaxEventSize.
+var ErrEventTooLarge = errors.New("stream: event too large")
+
+var bom = []byte("\xEF\xBB\xBF")
+
+// Reader parses events from a stream of Server-Sent Events. It follows the
+// SSE spec except that only LF ends a line (see the package doc): comments,
+// such as heartbeats, are skipped, the id and retry fields and unknown fields
+// are ignored, and a block without data lines dispatches nothing. Unknown
+// event types are returned like any other.
+type Reader struct {
+	br      *bufio.Reader
+	started bool
+	err     error
+}
+
+// NewReader returns a Reader that parses events from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// Next returns the next event. It returns io.EOF when the stream ends
+// between events and io.ErrUnexpectedEOF when it ends partway through one,
+// which the server never does on purpose: the connection was cut. Errors are
+// sticky.
+func (r *Reader) Next() (Event, error) {
+	if r.err != nil {
+		return Event{}, r.err
+	}
+	var (
+		eventType string
+		data      []byte
+		hasData   bool
+		pending   bool // a field of the next event has been read
+		size      int
+	)
+	for {
+		line, err := r.readLine(MaxEventSize - size)
+		if err != nil {
+			if err == io.EOF && (pending || len(line) > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			r.err = err
+			return Event{}, err
+		}
+		size += len(line) + 1
+
+		if len(line) == 0 {
+			if hasData {
+				if eventType == "" {
+					eventType = "message"
+				}
+				return Event{Type: eventType, Data: string(data)}, nil
+			}
+			eventType, pending, size = "", false, 0
+			continue
+		}
+
+		name, value, found := bytes.Cut(line, []byte(":"))
+		if len(name) == 0 { // comment
+			if !pending {
+				size = 0 // heartbeats between events don't add up
+			}
+			continue
+		}
+		if found {
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+		pending = true
+		switch string(name) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			hasData = true
+		}
+	}
+}
+
+// readLine returns the next line without its LF. At the end of the stream it
+// returns io.EOF with whatever partial line there was.
+func (r *Reader) readLine(limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, ErrEventTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if !r.started && len(line) > 0 {
+			r.started = true
+			line = bytes.TrimPrefix(line, bom)
+		}
+		if err != nil {
+			return line, err
+		}
+		return line[:len(line)-1], nil
+	}
+}
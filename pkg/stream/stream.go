@@ -0,0 +1,355 @@
+// Package stream is the wire format of POST /execute/stream: Server-Sent
+// Events carrying an execution's output, progress and result.
+//
+// Each event is an "event:" line naming its type followed by one "data:"
+// line per line of payload, and a blank line. A payload containing LF is
+// split across data lines and joined back with LF by the Reader, so output
+// round-trips byte for byte. Lines end in LF only: a CR is payload, so
+// parsers that also break lines on CR may split such output.
+//
+// The output events are stdout and stderr, in the order the execution wrote
+// them. A stream ends with exactly one done event (the result), error event
+// (the execution could not run) or cancelled event (DELETE
+// /cancellation-groups/{name} killed it). A warning event comes ahead of an
+// execution being stopped, such as for its idle_output_timeout. Status events
+// come before any output, while the server pulls a runtime image the host
+// doesn't have yet. Output past
+// the server's caps (sandbox.output: by default 1MB of stdout, 256KB of
+// stderr, 1MB in all) is silently dropped; what is sent is the same bytes
+// the result keeps, and no output event ends mid-rune. Output a slow client could not keep up with is dropped and
+// counted in Done.DroppedBytes. Clients should ignore event types they don't
+// know.
+//
+// The terminal events' payloads are versioned (see Versions): a client that
+// sends Accept-Stream-Version: 2 gets DoneV2 and ErrorV2, and one that sends
+// nothing gets version 1, Done and Error, whose fields stay as they are.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types sent by the server.
+const (
+	EventStdout    = "stdout"
+	EventStderr    = "stderr"
+	EventProgress  = "progress" // claude only, every few seconds
+	EventWarning   = "warning"  // the execution will be stopped soon unless something changes
+	EventStatus    = "status"   // setup is under way, e.g. pulling the image; every second or so
+	EventDone      = "done"
+	EventError     = "error"
+	EventCancelled = "cancelled"
+)
+
+// Event is one Server-Sent Event. Type is "message" when the stream didn't
+// name one, as the SSE spec has it.
+type Event struct {
+	Type string
+	Data string
+}
+
+// Terminal reports whether e ends the stream.
+func (e Event) Terminal() bool {
+	return e.Type == EventDone || e.Type == EventError || e.Type == EventCancelled
+}
+
+// Decode unmarshals a JSON payload (done, error, progress, warning or
+// status) into v.
+func (e Event) Decode(v any) error {
+	if err := json.Unmarshal([]byte(e.Data), v); err != nil {
+		return fmt.Errorf("decoding %s event: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Done is the payload of the done event.
+type Done struct {
+	ID             string           `json:"id"`
+	ExitCode       int              `json:"exit_code"`
+	ExitClass      string           `json:"exit_class"`
+	Duration       string           `json:"duration"`
+	Timeout        string           `json:"timeout,omitempty"` // the timeout enforced, from timeout or deadline
+	Deadline       time.Time        `json:"deadline,omitzero"` // server time the timeout ran out at, counted from when the request arrived
+	Chaos          bool             `json:"chaos,omitempty"`
+	SharedMounts   []string         `json:"shared_mounts,omitempty"`
+	SecurityEvents []SecurityEvent  `json:"security_events,omitempty"`
+	Environment    *Environment     `json:"environment,omitempty"`
+	DroppedBytes   map[string]int64 `json:"dropped_bytes,omitempty"` // by event type, when the client fell behind
+	IdleTimeout    *IdleTimeout     `json:"idle_timeout,omitempty"`  // set when exit_class is idle_timeout
+	// NetworkConnections is what an execution with a network connected to,
+	// when the server audits egress.
+	NetworkConnections *NetworkAudit `json:"network_connections,omitempty"`
+	// PartialAnalysis is set when the escape detector ran out of its
+	// analysis budget and only sampled the rest of the code.
+	PartialAnalysis bool `json:"partial_analysis,omitempty"`
+	// Analysis is not_applicable when the code is an executable (language
+	// binary), which the escape detector can't read.
+	Analysis string `json:"analysis,omitempty"`
+	// Deduplicated is set when the request shared the execution of an
+	// identical one already running (sandbox.dedup); ID is that execution's.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// Retrievable is set when GET on RetrievalPath, /executions/{id},
+	// returns the output streamed once the execution is recorded.
+	Retrievable   bool   `json:"retrievable"`
+	RetrievalPath string `json:"retrieval_path,omitempty"`
+	// Recommendation is set when ExitClass is oom_kill and there is more
+	// memory to ask for.
+	Recommendation *Recommendation `json:"recommendation,omitempty"`
+}
+
+// Recommendation is the limits.memory_mb an execution the OOM killer
+// stopped might try next: the next rung of its language's ladder, which
+// doubles from the language's default.
+type Recommendation struct {
+	MemoryMB int64  `json:"memory_mb"`
+	Message  string `json:"message"`
+}
+
+// IdleTimeout explains an execution stopped, as exit class idle_timeout,
+// because it wrote no output for its idle_output_timeout.
+type IdleTimeout struct {
+	Timeout    string    `json:"timeout"`              // the idle_output_timeout
+	LastOutput time.Time `json:"last_output,omitzero"` // when output last appeared; omitted if it never did
+	OutputAt   string    `json:"output_at,omitempty"`  // how far into the run that was
+}
+
+// Warning is the payload of the warning event. Code idle_output is sent
+// when an execution has been silent for half its idle_output_timeout.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	AbortIn string `json:"abort_in,omitempty"` // time left before the execution is stopped
+}
+
+// Status is the payload of the status event. Phase pulling is sent while
+// the runtime image is pulled: BytesTotal is what the pull has found to
+// fetch so far, so it can grow as manifests are read. The docker backend
+// can't tell how far a pull has got and sends one event, with no bytes.
+type Status struct {
+	Phase      string `json:"phase"`
+	Image      string `json:"image"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+}
+
+// Error is the payload of the error event: the execution failed after the
+// stream had started, so there is no result.
+type Error struct {
+	Message   string `json:"error"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Retrievable and RetrievalPath are as in Done, for an execution that
+	// failed after it started and was recorded with the output it wrote.
+	Retrievable   bool   `json:"retrievable,omitempty"`
+	RetrievalPath string `json:"retrieval_path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// Cancelled is the payload of the cancelled event: the execution was killed
+// with its cancellation group before it finished, so there is no result.
+type Cancelled struct {
+	ID                string `json:"id"`
+	CancellationGroup string `json:"cancellation_group"`
+	Message           string `json:"message"`
+	RequestID         string `json:"request_id,omitempty"`
+}
+
+// Progress is the coarse state of a running or recently finished execution,
+// sent as progress events and returned by GET /executions/{id}/progress.
+// Turn, LastTool and FilesEdited are only tracked for claude.
+type Progress struct {
+	ID          string `json:"id"`
+	Language    string `json:"language"`
+	State       string `json:"state"` // running, finished
+	Turn        int    `json:"turn"`
+	LastTool    string `json:"last_tool,omitempty"`
+	FilesEdited int    `json:"files_edited"`
+	Elapsed     string `json:"elapsed"`
+	Timeout     string `json:"timeout"`
+	ExitClass   string `json:"exit_class,omitempty"` // set once finished
+}
+
+// SecurityEvent records suspicious activity in the code, the output or during
+// execution. Source is code, output or runtime.
+type SecurityEvent struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Severity string `json:"severity,omitempty"`
+	Syscall  string `json:"syscall,omitempty"`
+	Detail   string `json:"detail"`
+	Line     int    `json:"line,omitempty"`  // First matching line of a code detection
+	Count    int    `json:"count,omitempty"` // Lines the pattern matched on, for code detections
+}
+
+// Environment describes how the sandboxed process was started. Cwd is
+// omitted when the runtime image's default working directory applied;
+// Claude holds the options a claude session ran with, defaults included.
+type Environment struct {
+	Argv    []string       `json:"argv"`
+	Cwd     string         `json:"cwd,omitempty"`
+	Claude  *ClaudeOptions `json:"claude,omitempty"`
+	Warmups []string       `json:"warmups,omitempty"` // Startup optimizations applied, e.g. no_site
+	Env     []string       `json:"env,omitempty"`     // Names of the env vars set, from the request and the server's sandbox.default_env
+	Seccomp *Seccomp       `json:"seccomp,omitempty"` // Checked at startup when the server verifies seccomp
+	IP      string         `json:"ip,omitempty"`      // Container address, for network_enabled executions on the containerd backend
+	Ulimits *Ulimits       `json:"ulimits,omitempty"` // Rlimits the process ran under
+	Workdir *WorkdirSize   `json:"workdir,omitempty"` // Size of the claude work_dir, measured before it was mounted
+	Network string         `json:"network,omitempty"` // Why the process had a network or not: requested_and_allowed, requested_but_denied_by_policy, forced_for_claude or not_requested
+	// WorkdirGit is the claude work_dir's git state before it was mounted.
+	WorkdirGit *WorkdirGit `json:"workdir_git,omitempty"`
+	// ServerVersion is the build of the server that ran the execution, and
+	// ImageDigest what the runtime image resolved to when it did.
+	ServerVersion string `json:"server_version,omitempty"`
+	ImageDigest   string `json:"image_digest,omitempty"`
+	// ImagePull is set when the execution had to pull its image first, in
+	// the setup phase: the time it took isn't in Duration.
+	ImagePull *ImagePull `json:"image_pull,omitempty"`
+	// Timezone and Locale are the TZ and LANG the process ran with; an
+	// empty timezone is the image's. Passwd says where the sandbox user's
+	// passwd entry came from, "generated" or "image", when the server
+	// generates one for images without it.
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	Passwd   string `json:"passwd,omitempty"`
+	// Limits are the limits the process was given against those the host
+	// was verified to enforce, when the server checks.
+	Limits *LimitReport `json:"limits,omitempty"`
+	// CompileCache is "hit" when the code ran from its compiled form, kept
+	// from an earlier execution of the same code, and "miss" when it was
+	// compiled, when the server caches compiled code for the language.
+	CompileCache string `json:"compile_cache,omitempty"`
+}
+
+// LimitReport is an execution's requested limits against the ones the
+// host enforced of them. A limit that wasn't enforced is left out of
+// Enforced and named in NotEnforced: memory, memory_swap, pids or cpu.
+type LimitReport struct {
+	Requested   LimitValues `json:"requested"`
+	Enforced    LimitValues `json:"enforced"`
+	NotEnforced []string    `json:"not_enforced,omitempty"`
+}
+
+// LimitValues are resource limits: memory_swap_mb is memory and swap
+// together.
+type LimitValues struct {
+	CPUShares    int64 `json:"cpu_shares,omitempty"`
+	MemoryMB     int64 `json:"memory_mb,omitempty"`
+	MemorySwapMB int64 `json:"memory_swap_mb,omitempty"`
+	PidsLimit    int64 `json:"pids_limit,omitempty"`
+}
+
+// DebugTrace is what strace recorded of an execution's file and network
+// syscalls, when its request had debug_trace. Trace is strace's output, cut
+// at the server's cap when Truncated; Summary is read from what was kept.
+type DebugTrace struct {
+	Trace     string       `json:"trace"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Summary   TraceSummary `json:"summary"`
+}
+
+// TraceSummary sums up a DebugTrace: how many calls it holds and failed,
+// the failures by syscall and errno, most frequent first, and the paths
+// refused with EACCES, EPERM or EROFS. Both lists are capped at 20.
+type TraceSummary struct {
+	Calls       int            `json:"calls"`
+	Failed      int            `json:"failed"`
+	Failures    []TraceFailure `json:"failures,omitempty"`
+	DeniedPaths []string       `json:"denied_paths,omitempty"`
+}
+
+// TraceFailure counts the calls of one syscall that failed with one errno.
+// Path is the first such call's, when it named one.
+type TraceFailure struct {
+	Syscall string `json:"syscall"`
+	Errno   string `json:"errno"`
+	Count   int    `json:"count"`
+	Path    string `json:"path,omitempty"`
+}
+
+// ImagePull is an image pull an execution waited on before its code ran.
+// Bytes is what was fetched, when the backend can tell.
+type ImagePull struct {
+	Image    string `json:"image"`
+	Duration string `json:"duration"`
+	Bytes    int64  `json:"bytes,omitempty"`
+}
+
+// Seccomp is the seccomp state the sandboxed process started under, as its
+// /proc/self/status reported it.
+type Seccomp struct {
+	Mode    string `json:"mode"`              // disabled, strict or filter
+	Filters int    `json:"filters,omitempty"` // Filters attached; omitted on kernels that don't report them
+}
+
+// Ulimits are rlimits, soft and hard alike. fsize and core are in bytes. In
+// a request's limits, a field left 0 takes the default the server derives
+// from the other limits.
+type Ulimits struct {
+	NoFile int64 `json:"nofile"`
+	NProc  int64 `json:"nproc"`
+	FSize  int64 `json:"fsize"`
+	Core   int64 `json:"core"`
+}
+
+// WorkdirSize is what the server counted in a claude work_dir before
+// mounting it. Symlinks and sandbox.workdir_size.exclude matches aren't
+// counted; partial means the walk stopped early and the counts are a lower
+// bound.
+type WorkdirSize struct {
+	Files   int64 `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Partial bool  `json:"partial,omitempty"`
+}
+
+// WorkdirGit is the git state of a claude work_dir before it was mounted.
+// Repo is false for a directory that isn't a repository's work tree. Dirty
+// counts changes staged, unstaged and conflicted, and untracked files that
+// the repository doesn't ignore; changed lists the first paths found.
+// Unchecked says why dirty couldn't be determined, such as a repository
+// format the server doesn't read.
+type WorkdirGit struct {
+	Repo      bool     `json:"repo"`
+	Head      string   `json:"head,omitempty"`
+	Branch    string   `json:"branch,omitempty"`
+	Detached  bool     `json:"detached,omitempty"`
+	Dirty     bool     `json:"dirty"`
+	Changed   []string `json:"changed,omitempty"`
+	Unchecked string   `json:"unchecked,omitempty"`
+}
+
+// NetworkAudit lists the destinations an execution connected to, in the
+// order they were first seen, as the server read them from the host's
+// connection tracking table. A connection opened and closed between two
+// reads may be missing. Unavailable says why nothing could be recorded, on
+// hosts where the table can't be read.
+type NetworkAudit struct {
+	Connections []NetworkConnection `json:"connections"`
+	Dropped     int                 `json:"dropped,omitempty"` // Connections to destinations past the server's cap, not listed
+	Unavailable string              `json:"unavailable,omitempty"`
+}
+
+// NetworkConnection is one destination: its address, port (0 for icmp)
+// and protocol, how many connections were made to it, and about how many
+// bytes they carried both ways, 0 where the host doesn't count them.
+type NetworkConnection struct {
+	DstIP         string    `json:"dst_ip"`
+	DstPort       int       `json:"dst_port,omitempty"`
+	Protocol      string    `json:"protocol"`
+	Connections   int       `json:"connections"`
+	BytesEstimate int64     `json:"bytes_estimate"`
+	FirstSeen     time.Time `json:"first_seen"`
+}
+
+// ClaudeOptions restrict a claude session. Omitted fields take the server's
+// security.claude defaults, and the server's ceilings apply either way.
+type ClaudeOptions struct {
+	AllowedTools    []string `json:"allowed_tools,omitempty"`
+	DisallowedTools []string `json:"disallowed_tools,omitempty"`
+	MaxTurns        int      `json:"max_turns,omitempty"`
+	Model           string   `json:"model,omitempty"`
+}
@@ -0,0 +1,290 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll returns the events in input and the error that ended them.
+func readAll(input string) ([]Event, error) {
+	r := NewReader(strings.NewReader(input))
+	var events []Event
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"single line", Event{"stdout", "hello"}, "event: stdout\ndata: hello\n\n"},
+		{"multi-line", Event{"stdout", "a\nb\n"}, "event: stdout\ndata: a\ndata: b\ndata: \n\n"},
+		{"empty", Event{"stdout", ""}, "event: stdout\ndata: \n\n"},
+		{"injection", Event{"stdout", "x\n\nevent: done\ndata: {}"}, "event: stdout\ndata: x\ndata: \ndata: event: done\ndata: data: {}\n\n"},
+		{"carriage return", Event{"stderr", "50%\r100%"}, "event: stderr\ndata: 50%\r100%\n\n"},
+		{"leading space", Event{"stdout", " indented"}, "event: stdout\ndata:  indented\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := NewWriter(&b).WriteEvent(tt.event); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteEvent_InvalidType(t *testing.T) {
+	for _, eventType := range []string{"", "std\nout", "std\rout"} {
+		var b bytes.Buffer
+		if err := NewWriter(&b).WriteEvent(Event{Type: eventType, Data: "x"}); !errors.Is(err, ErrInvalidType) {
+			t.Errorf("type %q: got %v, want ErrInvalidType", eventType, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("type %q: wrote %q", eventType, b.String())
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := NewWriter(&b).WriteJSON(EventError, &Error{Message: "execution failed", Code: "EXECUTION_FAILED"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "event: error\ndata: {\"error\":\"execution failed\",\"code\":\"EXECUTION_FAILED\"}\n\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	if err := NewWriter(&b).WriteJSON(EventDone, func() {}); err == nil {
+		t.Error("expected an error for a value JSON can't encode")
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Event
+		wantErr error
+	}{
+		{"empty stream", "", nil, io.EOF},
+		{"single event", "event: stdout\ndata: hi\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"multi-line data", "event: stdout\ndata: a\ndata: \ndata: b\n\n", []Event{{"stdout", "a\n\nb"}}, io.EOF},
+		{"several events", "event: stdout\ndata: 1\n\nevent: stderr\ndata: 2\n\nevent: done\ndata: {}\n\n",
+			[]Event{{"stdout", "1"}, {"stderr", "2"}, {"done", "{}"}}, io.EOF},
+		{"no space after colon", "event:stdout\ndata:hi\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"only one space stripped", "event: stdout\ndata:   hi\n\n", []Event{{"stdout", "  hi"}}, io.EOF},
+		{"colon in data", "event: stdout\ndata: a: b\n\n", []Event{{"stdout", "a: b"}}, io.EOF},
+		{"field without colon", "event: stdout\ndata\n\n", []Event{{"stdout", ""}}, io.EOF},
+		{"default type", "data: hi\n\n", []Event{{"message", "hi"}}, io.EOF},
+		{"unknown type", "event: telemetry\ndata: x\n\n", []Event{{"telemetry", "x"}}, io.EOF},
+		{"comments and heartbeats", ": ping\n\nevent: stdout\n: mid-event\ndata: hi\n\n:\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"ignored fields", "id: 7\nretry: 1000\nfoo: bar\nevent: stdout\ndata: hi\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"no data dispatches nothing", "event: stdout\n\nevent: stderr\ndata: x\n\n", []Event{{"stderr", "x"}}, io.EOF},
+		{"last event type wins", "event: stdout\nevent: stderr\ndata: x\n\n", []Event{{"stderr", "x"}}, io.EOF},
+		{"extra blank lines", "\n\nevent: stdout\ndata: hi\n\n\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"carriage return is data", "event: stdout\ndata: a\r\n\n", []Event{{"stdout", "a\r"}}, io.EOF},
+		{"byte order mark", "\xEF\xBB\xBFevent: stdout\ndata: hi\n\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"partial final line", "event: stdout\ndata: hi\n\nevent: do", []Event{{"stdout", "hi"}}, io.ErrUnexpectedEOF},
+		{"missing final blank line", "event: done\ndata: {}\n", nil, io.ErrUnexpectedEOF},
+		{"trailing comment", "event: stdout\ndata: hi\n\n: bye\n", []Event{{"stdout", "hi"}}, io.EOF},
+		{"partial comment", "event: stdout\ndata: hi\n\n: by", []Event{{"stdout", "hi"}}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(tt.input)
+			if err != tt.wantErr {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_OneByteReads(t *testing.T) {
+	input := ": ping\nevent: stdout\ndata: a\ndata: b\n\nevent: done\ndata: {}\n\n"
+	r := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+	var got []Event
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if want := []Event{{"stdout", "a\nb"}, {"done", "{}"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReader_ErrorsAreSticky(t *testing.T) {
+	boom := errors.New("boom")
+	r := NewReader(io.MultiReader(strings.NewReader("event: stdout\ndata: hi\n\nevent: "), iotest.ErrReader(boom)))
+	if e, err := r.Next(); err != nil || e.Data != "hi" {
+		t.Fatalf("Next() = %q, %v", e, err)
+	}
+	for range 2 {
+		if _, err := r.Next(); err != boom {
+			t.Errorf("err = %v, want boom", err)
+		}
+	}
+}
+
+func TestReader_EventTooLarge(t *testing.T) {
+	big := strings.Repeat("x", MaxEventSize/2)
+	if _, err := readAll("event: stdout\ndata: " + big + "\ndata: " + big + "\n\n"); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("err = %v, want ErrEventTooLarge", err)
+	}
+
+	// Heartbeats between events don't count against the next event.
+	heartbeats := strings.Repeat(": "+strings.Repeat("-", 1000)+"\n", MaxEventSize/1000)
+	got, err := readAll(heartbeats + "event: stdout\ndata: " + big + "\n\n")
+	if err != io.EOF || len(got) != 1 || got[0].Data != big {
+		t.Errorf("got %d events, err %v", len(got), err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	events := []Event{
+		{EventStdout, "line 1\nline 2\n"},
+		{EventStderr, "\n\n"},
+		{EventStdout, "\r\n\x00 ünïcode :colon data: event:"},
+		{EventProgress, `{"id":"x","turn":2}`},
+		{"custom", ""},
+		{EventDone, `{"id":"x","exit_code":0}`},
+	}
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for _, e := range events {
+		if err := w.WriteEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wire := b.String()
+
+	got, err := readAll(wire)
+	if err != io.EOF {
+		t.Fatalf("err = %v", err)
+	}
+	if !reflect.DeepEqual(got, events) {
+		t.Fatalf("got %q, want %q", got, events)
+	}
+
+	b.Reset()
+	for _, e := range got {
+		if err := w.WriteEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.String() != wire {
+		t.Errorf("rewritten stream differs:\n got %q\nwant %q", b.String(), wire)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	e := Event{Type: EventDone, Data: `{"id":"x","exit_code":3,"exit_class":"error","duration":"1s","dropped_bytes":{"stdout":10}}`}
+	var done Done
+	if err := e.Decode(&done); err != nil {
+		t.Fatal(err)
+	}
+	want := Done{ID: "x", ExitCode: 3, ExitClass: "error", Duration: "1s", DroppedBytes: map[string]int64{"stdout": 10}}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %+v, want %+v", done, want)
+	}
+	if err := (Event{Type: EventError, Data: "execution failed"}).Decode(&Error{}); err == nil || !strings.Contains(err.Error(), "error event") {
+		t.Errorf("err = %v, want a decoding error naming the event", err)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	for eventType, want := range map[string]bool{EventDone: true, EventError: true, EventStdout: false, EventProgress: false, "message": false} {
+		if got := (Event{Type: eventType}).Terminal(); got != want {
+			t.Errorf("%s: Terminal() = %v", eventType, got)
+		}
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("stdout", "hello\n")
+	f.Add("stderr", "\n\n\r\n")
+	f.Add("done", `{"exit_code":0}`)
+	f.Add("x", "data: event: done\n\n")
+	f.Fuzz(func(t *testing.T, eventType, data string) {
+		var b bytes.Buffer
+		err := NewWriter(&b).WriteEvent(Event{Type: eventType, Data: data})
+		if eventType == "" || strings.ContainsAny(eventType, "\r\n") {
+			if err == nil {
+				t.Fatalf("type %q accepted", eventType)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Len() > MaxEventSize {
+			return
+		}
+		got, err := readAll(b.String())
+		if err != io.EOF || len(got) != 1 {
+			t.Fatalf("read %q: %d events, err %v", b.String(), len(got), err)
+		}
+		if got[0].Data != data {
+			t.Errorf("data = %q, want %q", got[0].Data, data)
+		}
+		if got[0].Type != eventType {
+			t.Errorf("type = %q, want %q", got[0].Type, eventType)
+		}
+	})
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add("event: stdout\ndata: hi\n\n")
+	f.Add(": ping\n\ndata\n\nevent:done\ndata:{}\n\n")
+	f.Add("\xEF\xBB\xBFdata: a\ndata: b\n\nevent: x")
+	f.Fuzz(func(t *testing.T, input string) {
+		events, err := readAll(input)
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, ErrEventTooLarge) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		// Whatever was parsed survives a write and a second read unchanged.
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		var written []Event
+		for _, e := range events {
+			if w.WriteEvent(e) == nil {
+				written = append(written, e)
+			}
+		}
+		again, err := readAll(b.String())
+		if err != io.EOF {
+			t.Fatalf("re-read: %v", err)
+		}
+		if len(again) != len(written) {
+			t.Fatalf("re-read %d events, wrote %d", len(again), len(written))
+		}
+		for i := range again {
+			if again[i] != written[i] {
+				t.Fatalf("event %d: got %q, want %q", i, again[i], written[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Wire format versions. A client asks for one with the AcceptVersionHeader
+// request header and the server names the one it speaks in VersionHeader.
+// Only the terminal events' payloads differ between them: a version's
+// payloads only ever gain fields, and never within a version.
+const (
+	// Version1 is the format streams were first sent in: Done and Error.
+	Version1 = 1
+	// Version2 adds the execution's status, a summary of its security
+	// events, the absolute retrieval URL and its resource usage: DoneV2
+	// and ErrorV2.
+	Version2 = 2
+	// DefaultVersion is what a client that doesn't ask gets, so clients
+	// written against version 1 keep working.
+	DefaultVersion = Version1
+)
+
+// Versions are the versions the server speaks, oldest first.
+var Versions = []int{Version1, Version2}
+
+const (
+	VersionHeader       = "X-Sandbox-Stream-Version"
+	AcceptVersionHeader = "Accept-Stream-Version"
+)
+
+// ErrUnsupportedVersion is returned by ParseVersion for a version the
+// server doesn't speak.
+var ErrUnsupportedVersion = errors.New("stream: unsupported version")
+
+// ParseVersion reads an AcceptVersionHeader value: empty is
+// DefaultVersion.
+func ParseVersion(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return DefaultVersion, nil
+	}
+	v, err := strconv.Atoi(header)
+	if err != nil || !slices.Contains(Versions, v) {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, header)
+	}
+	return v, nil
+}
+
+// DoneV2 is the payload of the done event in version 2: Done's fields and
+// more.
+type DoneV2 struct {
+	Done
+	// Status is the execution's final state, as GET /executions/{id}
+	// reports it: completed, failed, timeout, oom or killed.
+	Status   string          `json:"status"`
+	Security SecuritySummary `json:"security"`
+	// RetrievalURL is RetrievalPath as an absolute URL.
+	RetrievalURL string `json:"retrieval_url,omitempty"`
+	// Usage is what the execution used, measured as it ended.
+	Usage Usage `json:"usage"`
+	// DebugTrace is set when the request had debug_trace.
+	DebugTrace *DebugTrace `json:"debug_trace,omitempty"`
+}
+
+// ErrorV2 is the payload of the error event in version 2.
+type ErrorV2 struct {
+	Error
+	Status       string `json:"status"` // failed or timeout
+	RetrievalURL string `json:"retrieval_url,omitempty"`
+}
+
+// SecuritySummary sums up a done event's security events, so a client can
+// tell whether to look at them without walking the list.
+type SecuritySummary struct {
+	Events          int      `json:"events"`
+	HighestSeverity string   `json:"highest_severity,omitempty"` // critical, high, medium or low
+	Types           []string `json:"types,omitempty"`            // distinct, in the order first seen
+}
+
+// Usage is an execution's resource consumption.
+type Usage struct {
+	CPUTimeMS    int64 `json:"cpu_time_ms"`
+	MemoryPeakMB int64 `json:"memory_peak_mb"`
+	PidsUsed     int64 `json:"pids_used"`
+}
+
+// severityRank orders SecurityEvent severities, unknown ones lowest.
+var severityRank = map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// Summarize sums up events.
+func Summarize(events []SecurityEvent) SecuritySummary {
+	s := SecuritySummary{Events: len(events)}
+	for _, ev := range events {
+		if severityRank[ev.Severity] > severityRank[s.HighestSeverity] {
+			s.HighestSeverity = ev.Severity
+		}
+		if !slices.Contains(s.Types, ev.Type) {
+			s.Types = append(s.Types, ev.Type)
+		}
+	}
+	return s
+}
+
+// Payload returns what v, a terminal event's payload, marshals as in
+// version: the latest payload types carry every field, and an older
+// version gets the part of them it had.
+func Payload(version int, v any) any {
+	if version >= Version2 {
+		return v
+	}
+	switch p := v.(type) {
+	case *DoneV2:
+		return &p.Done
+	case *ErrorV2:
+		return &p.Error
+	}
+	return v
+}
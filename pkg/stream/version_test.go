@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenDone and goldenError set every field of the terminal payloads, so a
+// field added, renamed or dropped in any version shows up as a diff.
+var (
+	goldenDone = &DoneV2{
+		Done: Done{
+			ID:             "6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b",
+			ExitCode:       137,
+			ExitClass:      "oom_kill",
+			Duration:       "1.5s",
+			Timeout:        "30s",
+			Deadline:       time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC),
+			Chaos:          true,
+			SharedMounts:   []string{"datasets"},
+			SecurityEvents: []SecurityEvent{{Type: "oom_kill", Source: "runtime", Severity: "medium", Detail: "killed at 256MB"}},
+			Environment:    &Environment{Argv: []string{"python3", "-u", "-B", "/workspace/code.py"}, Network: "not_requested"},
+			DroppedBytes:   map[string]int64{"stdout": 4096},
+			IdleTimeout:    &IdleTimeout{Timeout: "10s", OutputAt: "500ms"},
+			NetworkConnections: &NetworkAudit{Connections: []NetworkConnection{
+				{DstIP: "93.184.216.34", DstPort: 443, Protocol: "tcp", Connections: 1, BytesEstimate: 2048, FirstSeen: time.Date(2026, 3, 1, 12, 0, 1, 0, time.UTC)},
+			}},
+			PartialAnalysis: true,
+			Analysis:        "not_applicable",
+			Deduplicated:    true,
+			Retrievable:     true,
+			RetrievalPath:   "/executions/6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b",
+			Recommendation:  &Recommendation{MemoryMB: 512, Message: "python ran out of its 256MB of memory; try limits.memory_mb 512"},
+		},
+		Status:       "oom",
+		Security:     SecuritySummary{Events: 1, HighestSeverity: "medium", Types: []string{"oom_kill"}},
+		RetrievalURL: "https://sandbox.example.com/executions/6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b",
+		Usage:        Usage{CPUTimeMS: 1200, MemoryPeakMB: 256, PidsUsed: 3},
+		DebugTrace: &DebugTrace{
+			Trace:     "7 openat(AT_FDCWD, \"/etc/app.conf\", O_RDONLY) = -1 EACCES (Permission denied)\n",
+			Truncated: true,
+			Summary: TraceSummary{Calls: 1, Failed: 1, Failures: []TraceFailure{{Syscall: "openat", Errno: "EACCES", Count: 1, Path: "/etc/app.conf"}},
+				DeniedPaths: []string{"/etc/app.conf"}},
+		},
+	}
+	goldenError = &ErrorV2{
+		Error: Error{
+			Message:       "no output for 5m0s; execution aborted",
+			Code:          "IDLE_OUTPUT_TIMEOUT",
+			RequestID:     "req-1",
+			Retrievable:   true,
+			RetrievalPath: "/executions/6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b",
+		},
+		Status:       "timeout",
+		RetrievalURL: "https://sandbox.example.com/executions/6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b",
+	}
+)
+
+func TestPayload_Golden(t *testing.T) {
+	for _, version := range Versions {
+		for event, v := range map[string]any{EventDone: goldenDone, EventError: goldenError} {
+			name := filepath.Join("testdata", fmt.Sprintf("%s.v%d.json", event, version))
+			got, err := json.MarshalIndent(Payload(version, v), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			if *update {
+				if err := os.WriteFile(name, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s changed; if that's meant to be, run go test ./pkg/stream -update and bump the version for a field removed or renamed.\ngot:\n%s", name, got)
+			}
+		}
+	}
+}
+
+func TestPayload_V1IsDone(t *testing.T) {
+	// A version 1 client decoding into Done gets what a version 2 one does
+	// of Done's fields.
+	for _, version := range Versions {
+		data, _ := json.Marshal(Payload(version, goldenDone))
+		var got Done
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&got, &goldenDone.Done) {
+			t.Errorf("version %d: Done = %+v", version, got)
+		}
+	}
+	if p := Payload(Version1, &Cancelled{ID: "x"}); !reflect.DeepEqual(p, &Cancelled{ID: "x"}) {
+		t.Errorf("cancelled at version 1: %+v", p)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   int
+		ok     bool
+	}{
+		{"", DefaultVersion, true},
+		{"1", Version1, true},
+		{" 2 ", Version2, true},
+		{"3", 0, false},
+		{"0", 0, false},
+		{"v2", 0, false},
+		{"1, 2", 0, false},
+	} {
+		got, err := ParseVersion(tt.header)
+		if got != tt.want || (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrUnsupportedVersion)) {
+			t.Errorf("ParseVersion(%q) = %d, %v; want %d", tt.header, got, err, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	got := Summarize([]SecurityEvent{
+		{Type: "fork_bomb", Severity: "high"},
+		{Type: "oom_kill", Severity: "medium"},
+		{Type: "fork_bomb", Severity: "critical"},
+		{Type: "network_attempt"},
+	})
+	want := SecuritySummary{Events: 4, HighestSeverity: "critical", Types: []string{"fork_bomb", "oom_kill", "network_attempt"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summarize = %+v, want %+v", got, want)
+	}
+	if got := Summarize(nil); !reflect.DeepEqual(got, SecuritySummary{}) {
+		t.Errorf("no events: %+v", got)
+	}
+}
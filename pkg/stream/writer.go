@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrInvalidType is returned for an event type that is empty or spans lines.
+var ErrInvalidType = errors.New("stream: invalid event type")
+
+// Writer frames events onto an io.Writer. It does no buffering or flushing
+// of its own; each event is a single Write.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer that writes events to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteEvent writes e. Each LF-separated line of the data gets its own
+// "data:" line, so data can't end the event early or inject another one.
+func (w *Writer) WriteEvent(e Event) error {
+	if e.Type == "" || strings.ContainsAny(e.Type, "\r\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidType, e.Type)
+	}
+	var b strings.Builder
+	b.Grow(len("event: \n\n") + len(e.Type) + len(e.Data) + len("data: \n")*(1+strings.Count(e.Data, "\n")))
+	b.WriteString("event: ")
+	b.WriteString(e.Type)
+	b.WriteByte('\n')
+	for line := range strings.SplitSeq(e.Data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w.w, b.String())
+	return err
+}
+
+// WriteJSON writes an event whose data is v encoded as JSON.
+func (w *Writer) WriteJSON(eventType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", eventType, err)
+	}
+	return w.WriteEvent(Event{Type: eventType, Data: string(data)})
+}
@@ -3,10 +3,13 @@ package tests
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/monitor"
 	"safe-agent-sandbox/internal/sandbox"
 )
@@ -89,6 +92,54 @@ func BenchmarkConcurrentExecutions(b *testing.B) {
 	}
 }
 
+// BenchmarkWarmup compares python and node runs with warmups (the default)
+// against runs with sandbox.warmup turned off. exec-ms/op is the execution's
+// own duration, the part warmups shorten.
+func BenchmarkWarmup(b *testing.B) {
+	requireDocker(b)
+
+	warm := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer warm.Close()
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Warmup = map[string][]string{"python": {}, "node": {}}
+	cold, err := sandbox.NewLocalDockerRunner(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cold.Close()
+
+	ctx := context.Background()
+	programs := []struct{ language, code string }{
+		{"python", "print('hello')"},
+		{"node", "console.log('hello')"},
+	}
+	for _, p := range programs {
+		// Pull the image and probe it outside the timings.
+		if _, err := warm.Execute(ctx, sandbox.ExecutionRequest{Language: p.language, Code: p.code, Timeout: 2 * time.Minute}); err != nil {
+			b.Fatal(err)
+		}
+		for _, r := range []struct {
+			name   string
+			runner *sandbox.DockerRunner
+		}{{"warm", warm}, {"cold", cold}} {
+			b.Run(p.language+"/"+r.name, func(b *testing.B) {
+				var total time.Duration
+				var warmups []string
+				for i := 0; i < b.N; i++ {
+					result, err := r.runner.Execute(ctx, sandbox.ExecutionRequest{Language: p.language, Code: p.code, Timeout: 10 * time.Second})
+					if err != nil {
+						b.Fatalf("execution failed: %v", err)
+					}
+					total += result.Duration
+					warmups = result.Warmups
+				}
+				b.ReportMetric(float64(total.Milliseconds())/float64(b.N), "exec-ms/op")
+				b.Logf("warmups: %v", warmups)
+			})
+		}
+	}
+}
+
 func BenchmarkEscapeDetector(b *testing.B) {
 	detector := monitor.NewEscapeDetector()
 
@@ -119,6 +170,53 @@ urllib.request.urlopen('http://169.254.169.254/latest/meta-data/')
 	}
 }
 
+// BenchmarkEscapeDetector_WorstCase runs the detector over 1MB inputs with
+// its default bounds, and unbounded: no analysis budget and no line
+// splitting. Minified code is one huge line; "anchors" repeats the anchor
+// of a pattern that never matches, so every regex still has to run.
+func BenchmarkEscapeDetector_WorstCase(b *testing.B) {
+	const size = 1 << 20
+	minified := strings.Repeat("function f(a,b){return a.map(function(c){return c+b})};", size/56)
+	lines := strings.Repeat("for i in range(10):\n    total += compute(i, data[i])\n", size/52)
+	anchors := strings.Repeat("nc x bash dirty ", size/16)
+
+	var custom []monitor.DetectionPattern
+	for i := range 50 {
+		custom = append(custom, monitor.DetectionPattern{
+			Name:     fmt.Sprintf("custom_%d", i),
+			Regex:    regexp.MustCompile(fmt.Sprintf(`(?i)evil_%d\s*\(.*\)`, i)),
+			Severity: monitor.SeverityLow,
+			Anchors:  []string{fmt.Sprintf("evil_%d", i)},
+		})
+	}
+	detectors := []struct {
+		name string
+		opts monitor.DetectorOptions
+	}{
+		{"unbounded", monitor.DetectorOptions{}},
+		{"bounded", monitor.DetectorOptions{Budget: monitor.DefaultAnalysisBudget, MaxLineLength: monitor.DefaultMaxLineLength}},
+		{"bounded_custom", monitor.DetectorOptions{Budget: monitor.DefaultAnalysisBudget, MaxLineLength: monitor.DefaultMaxLineLength, Patterns: custom}},
+	}
+	for _, det := range detectors {
+		d, err := monitor.NewEscapeDetectorWithOptions(det.opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, in := range []struct{ name, code string }{{"minified", minified}, {"lines", lines}, {"anchors", anchors}} {
+			b.Run(det.name+"/"+in.name, func(b *testing.B) {
+				b.SetBytes(int64(len(in.code)))
+				partial := 0
+				for i := 0; i < b.N; i++ {
+					if d.Analyze(in.code).Partial {
+						partial++
+					}
+				}
+				b.ReportMetric(float64(partial)/float64(b.N), "partial/op")
+			})
+		}
+	}
+}
+
 func TestStartupLatency(t *testing.T) {
 	ctx := context.Background()
 	client, err := sandbox.NewClient(ctx, "/run/containerd/containerd.sock", "sandbox-latency")
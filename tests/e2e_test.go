@@ -1,17 +1,35 @@
 package tests
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"safe-agent-sandbox/internal/api"
+	"safe-agent-sandbox/internal/config"
+	"safe-agent-sandbox/internal/monitor"
+	authproxy "safe-agent-sandbox/internal/proxy"
+	"safe-agent-sandbox/internal/runtime"
 	"safe-agent-sandbox/internal/sandbox"
 )
 
 // requireDocker skips the test if Docker is not installed or not running.
-func requireDocker(t *testing.T) {
+func requireDocker(t testing.TB) {
 	t.Helper()
 	if _, err := exec.LookPath("docker"); err != nil {
 		t.Skip("Docker not installed, skipping")
@@ -68,6 +86,27 @@ func TestE2E(t *testing.T) {
 			wantExit:   0,
 			wantOutput: "Hello from Bash!",
 		},
+		{
+			name:       "ruby_hello_world",
+			language:   "ruby",
+			code:       `puts "Hello from Ruby!"`,
+			wantExit:   0,
+			wantOutput: "Hello from Ruby!",
+		},
+		{
+			name:       "deno_hello_world",
+			language:   "deno",
+			code:       `const msg: string = "Hello from Deno!"; console.log(msg)`,
+			wantExit:   0,
+			wantOutput: "Hello from Deno!",
+		},
+		{
+			name:       "bun_hello_world",
+			language:   "bun",
+			code:       `const msg: string = "Hello from Bun!"; console.log(msg)`,
+			wantExit:   0,
+			wantOutput: "Hello from Bun!",
+		},
 		{
 			name:     "python_write_tmp",
 			language: "python",
@@ -326,6 +365,867 @@ func TestE2ETimeout(t *testing.T) {
 	}
 }
 
+// TestE2EDenoDenyNet checks that Deno's own permission layer rejects fetch.
+// Deno checks permissions before opening a socket, so a NotCapable /
+// "Requires net access" error shows --deny-net fired rather than the missing
+// network interface.
+func TestE2EDenoDenyNet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:     `await fetch("https://example.com")`,
+		Language: "deno",
+		Timeout:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ExitCode == 0 {
+		t.Fatalf("fetch should fail under --deny-net, output: %s", result.Output)
+	}
+	if !strings.Contains(result.Stderr, "net access") && !strings.Contains(result.Stderr, "NotCapable") {
+		t.Errorf("expected a Deno permission error, got stderr: %s", result.Stderr)
+	}
+}
+
+func TestE2EGoHelloWorld(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	result, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:     "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hello from go\") }\n",
+		Language: "go",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", result.ExitCode, result.Stderr)
+	}
+	if strings.TrimSpace(result.Output) != "hello from go" {
+		t.Errorf("output = %q, want %q", result.Output, "hello from go")
+	}
+}
+
+// TestE2EGoGetOffline verifies a module download fails at once without
+// network instead of waiting out the timeout.
+func TestE2EGoGetOffline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	result, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:     "package main\n\nimport \"golang.org/x/example/hello/reverse\"\n\nfunc main() { println(reverse.String(\"olleh\")) }\n",
+		Language: "go",
+		Timeout:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ExitCode == 0 || result.ExitClass != sandbox.ExitUser {
+		t.Fatalf("exit code = %d, class %s; want the build to fail on its own", result.ExitCode, result.ExitClass)
+	}
+	if !strings.Contains(result.Stderr, "GOPROXY=off") && !strings.Contains(result.Stderr, "go.mod") {
+		t.Errorf("expected a module lookup error, got stderr: %s", result.Stderr)
+	}
+}
+
+// TestE2EExitClass verifies a genuine OOM and a timeout kill are classified
+// differently even though both end in SIGKILL.
+func TestE2EExitClass(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	oom, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:     `x = bytearray(256 * 1024 * 1024)`,
+		Language: "python",
+		Timeout:  15 * time.Second,
+		Limits:   sandbox.ResourceLimits{CPUShares: 512, MemoryMB: 32, PidsLimit: 50, DiskMB: 10},
+	})
+	if err != nil {
+		t.Fatalf("oom run: unexpected error: %v", err)
+	}
+	if oom.ExitClass != sandbox.ExitOOMKill {
+		t.Errorf("oom run: exit class = %q (exit %d), want %q", oom.ExitClass, oom.ExitCode, sandbox.ExitOOMKill)
+	}
+
+	timedOut, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:     `import time; time.sleep(60)`,
+		Language: "python",
+		Timeout:  2 * time.Second,
+	})
+	if err != sandbox.ErrTimeout {
+		t.Fatalf("timeout run: err = %v, want ErrTimeout", err)
+	}
+	if timedOut.ExitClass != sandbox.ExitTimeoutKill {
+		t.Errorf("timeout run: exit class = %q, want %q", timedOut.ExitClass, sandbox.ExitTimeoutKill)
+	}
+	for _, e := range timedOut.SecurityEvents {
+		if e.Type == "oom_kill" {
+			t.Error("timeout run should not report an oom_kill security event")
+		}
+	}
+}
+
+// TestE2EIdleOutputTimeout runs a program that prints and then sleeps: it
+// is stopped at its idle_output_timeout, well before its timeout, and
+// classified apart from a timeout kill.
+func TestE2EIdleOutputTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	warned := make(chan time.Duration, 1)
+	start := time.Now()
+	result, err := runner.Execute(ctx, sandbox.ExecutionRequest{
+		Code:              "import time\nprint('started', flush=True)\ntime.sleep(3600)",
+		Language:          "python",
+		Timeout:           45 * time.Second,
+		IdleOutputTimeout: 3 * time.Second,
+		IdleWarning:       func(left time.Duration) { warned <- left },
+	})
+	elapsed := time.Since(start)
+	if err != sandbox.ErrIdleTimeout {
+		t.Fatalf("err = %v, want ErrIdleTimeout", err)
+	}
+	if elapsed > 20*time.Second {
+		t.Errorf("stopped after %s, want near the 3s idle threshold", elapsed)
+	}
+	if result.ExitClass != sandbox.ExitIdleTimeout || !strings.Contains(result.Output, "started") {
+		t.Errorf("exit class %q, output %q", result.ExitClass, result.Output)
+	}
+	if result.Idle == nil || result.Idle.LastOutput.IsZero() || result.Idle.Timeout != 3*time.Second {
+		t.Errorf("idle = %+v", result.Idle)
+	}
+	select {
+	case <-warned:
+	default:
+		t.Error("no warning at half the idle threshold")
+	}
+}
+
+func TestE2ECPUAbuse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.CPUAbuse = config.CPUAbuseConfig{
+		Enabled:       true,
+		Mode:          "aggressive",
+		UsageFraction: 0.8,
+		Sustain:       3 * time.Second,
+		PollInterval:  250 * time.Millisecond,
+	}
+	runner, err := sandbox.NewLocalDockerRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	// Output doesn't hold the guard off in aggressive mode.
+	code := "import time\nlast = 0\nwhile True:\n    if time.time() - last > 1:\n        print('busy', flush=True)\n        last = time.time()\n"
+	start := time.Now()
+	result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: code, Timeout: 20 * time.Second})
+	elapsed := time.Since(start)
+	if errors.Is(err, sandbox.ErrTimeout) {
+		t.Skip("cpu guard not enforced: needs a local Linux daemon with cgroup v2")
+	}
+	if !errors.Is(err, sandbox.ErrCPUAbuse) {
+		t.Fatalf("err = %v, want ErrCPUAbuse", err)
+	}
+	if elapsed > 15*time.Second {
+		t.Errorf("killed after %s, want near the 3s sustain", elapsed)
+	}
+	if result.ExitClass != sandbox.ExitCPUAbuse || !strings.Contains(result.Output, "busy") {
+		t.Errorf("exit class %q, output %q", result.ExitClass, result.Output)
+	}
+	i := slices.IndexFunc(result.SecurityEvents, func(ev sandbox.SecurityEvent) bool { return ev.Type == "resource_abuse" })
+	if i < 0 || !strings.Contains(result.SecurityEvents[i].Detail, "aggressive mode") {
+		t.Errorf("security events %+v, want a resource_abuse event", result.SecurityEvents)
+	}
+}
+
+func TestE2EEgressAudit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.EgressAudit.Enabled = true
+	cfg.Sandbox.EgressAudit.PollInterval = 200 * time.Millisecond
+	runner, err := sandbox.NewLocalDockerRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	// Unanswered datagrams to documentation addresses: each leaves a
+	// conntrack entry without needing a way out to the internet.
+	code := `import socket, time
+time.sleep(0.5)
+for addr in [("192.0.2.10", 9), ("198.51.100.20", 7)]:
+    s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+    try:
+        s.sendto(b"x", addr)
+    except OSError as e:
+        print(e)
+    s.close()
+print("sent")
+`
+	result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: code, NetworkEnabled: true, Timeout: 20 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := result.NetworkConnections
+	if audit == nil {
+		t.Fatal("no network_connections in the result")
+	}
+	if audit.Unavailable != "" {
+		t.Skipf("egress audit unavailable: %s", audit.Unavailable)
+	}
+	for _, want := range []string{"192.0.2.10", "198.51.100.20"} {
+		if !slices.ContainsFunc(audit.Connections, func(c sandbox.NetworkConnection) bool { return c.DstIP == want && c.Protocol == "udp" }) {
+			t.Errorf("connections %+v, want udp to %s", audit.Connections, want)
+		}
+	}
+}
+
+func TestE2EWorkspace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	cfg.Sandbox.Workspaces.Root = t.TempDir()
+	cfg.Security.AllowUnauthenticated = true
+
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	server := api.NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	defer server.Shutdown(context.Background())
+
+	resp, err := http.Post(ts.URL+"/workspaces", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ws api.WorkspaceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ws); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create workspace: status %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/workspaces/"+ws.ID+"/files/data/numbers.txt", strings.NewReader("1\n2\n3\n"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload: status %d", resp.StatusCode)
+	}
+
+	body, _ := json.Marshal(api.ExecutionRequest{
+		Language:    "python",
+		WorkspaceID: ws.ID,
+		Code: `
+nums = [int(l) for l in open("data/numbers.txt")]
+print("sum", sum(nums))
+try:
+    open("/workspace/out.txt", "w")
+    print("write allowed")
+except OSError:
+    print("write blocked")
+`,
+	})
+	resp, err = http.Post(ts.URL+"/execute", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result api.ExecutionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || result.ExitCode != 0 {
+		t.Fatalf("execute: status %d exit %d stderr %q", resp.StatusCode, result.ExitCode, result.Stderr)
+	}
+	if !strings.Contains(result.Output, "sum 6") {
+		t.Errorf("expected uploaded data to be readable, got %q", result.Output)
+	}
+	if !strings.Contains(result.Output, "write blocked") {
+		t.Errorf("expected read-only workspace for python, got %q", result.Output)
+	}
+}
+
+func TestE2EBundle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	cfg.Sandbox.Workspaces.Root = t.TempDir()
+	cfg.Sandbox.Bundles.Store = "disk"
+	cfg.Sandbox.Bundles.Dir = t.TempDir()
+	cfg.Security.AllowUnauthenticated = true
+
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	server := api.NewServer(cfg, backend, nil, nil, monitor.NewMetrics())
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	defer server.Shutdown(context.Background())
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct{ name, body string }{
+		{"manifest.json", `{"entrypoint": "main.py", "language": "python"}`},
+		{"main.py", `
+import sys
+sys.path.insert(0, "/workspace")
+from stats import total
+print("sum", total([int(l) for l in open("data/numbers.txt")]))
+try:
+    open("/workspace/out.txt", "w")
+    print("write allowed")
+except OSError:
+    print("write blocked")
+`},
+		{"stats.py", "def total(nums):\n    return sum(nums)\n"},
+		{"data/numbers.txt", "1\n2\n3\n"},
+	} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	gz.Close()
+
+	resp, err := http.Post(ts.URL+"/bundles", "application/gzip", &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle api.BundleResponse
+	if err := json.NewDecoder(resp.Body).Decode(&bundle); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload bundle: status %d", resp.StatusCode)
+	}
+
+	body, _ := json.Marshal(api.ExecutionRequest{BundleDigest: bundle.Digest})
+	resp, err = http.Post(ts.URL+"/execute", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result api.ExecutionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || result.ExitCode != 0 {
+		t.Fatalf("execute: status %d exit %d stderr %q", resp.StatusCode, result.ExitCode, result.Stderr)
+	}
+	if !strings.Contains(result.Output, "sum 6") {
+		t.Errorf("expected the bundle's module and data to be used, got %q", result.Output)
+	}
+	if !strings.Contains(result.Output, "write blocked") {
+		t.Errorf("expected the bundle to be read-only, got %q", result.Output)
+	}
+}
+
+func TestE2ESharedMount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	dataset := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataset, "prices.csv"), []byte("item,price\na,3\nb,4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dataset, 0o755); err != nil { // readable by the sandbox user
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	cfg.Sandbox.SharedMounts = []config.SharedMountConfig{
+		{Name: "datasets", HostPath: dataset, ContainerPath: "/data/datasets", AllowedLanguages: []string{"python"}},
+	}
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	result, err := backend.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language:     "python",
+		SharedMounts: []string{"datasets"},
+		Timeout:      30 * time.Second,
+		Code: `
+import csv
+rows = list(csv.DictReader(open("/data/datasets/prices.csv")))
+print("total", sum(int(r["price"]) for r in rows))
+try:
+    open("/data/datasets/new.csv", "w")
+    print("write allowed")
+except OSError:
+    print("write blocked")
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 0 || !strings.Contains(result.Output, "total 7") {
+		t.Fatalf("exit %d output %q stderr %q", result.ExitCode, result.Output, result.Stderr)
+	}
+	if !strings.Contains(result.Output, "write blocked") {
+		t.Errorf("expected read-only shared mount, got %q", result.Output)
+	}
+
+	_, err = backend.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "bash", Code: "ls /data/datasets", SharedMounts: []string{"datasets"},
+	})
+	if !errors.Is(err, sandbox.ErrInvalidRequest) {
+		t.Errorf("bash with python-only mount: error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestE2EDefaultEnv(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	cfg.Sandbox.DefaultEnv = config.DefaultEnvConfig{
+		Global:    map[string]string{"TZ": "UTC", "SANDBOX_E2E": "global"},
+		Languages: map[string]map[string]string{"python": {"PYTHONDONTWRITEBYTECODE": "1", "SANDBOX_E2E": "python"}},
+	}
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	result, err := backend.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python",
+		Code:     "import os\nprint(os.environ['TZ'], os.environ['SANDBOX_E2E'], os.environ['PYTHONDONTWRITEBYTECODE'], os.environ['DEBUG'])",
+		EnvVars:  []string{"TZ=Asia/Tokyo", "DEBUG=1"},
+		Timeout:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Asia/Tokyo python 1 1\n"; result.ExitCode != 0 || result.Output != want {
+		t.Fatalf("exit %d output %q stderr %q, want %q", result.ExitCode, result.Output, result.Stderr, want)
+	}
+	if slices.Contains(result.Warmups, runtime.WarmupIgnoreEnv) {
+		t.Errorf("warmups %v: python -E would drop the PYTHON* default", result.Warmups)
+	}
+	if want := []string{"PYTHONDONTWRITEBYTECODE", "SANDBOX_E2E", "TZ", "DEBUG"}; !slices.Equal(result.Env, want) {
+		t.Errorf("reported env %v, want %v", result.Env, want)
+	}
+}
+
+func TestE2EIdentityShaping(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	cfg.Sandbox.GeneratePasswd = true
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	// getpwuid must find the sandbox user whether the image had it or the
+	// runner generated it, and local time must be the request's zone.
+	result, err := backend.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python",
+		Code: "import os, pwd\nfrom datetime import datetime\nfrom zoneinfo import ZoneInfo\n" +
+			"print(os.path.expanduser('~'), pwd.getpwuid(os.getuid()).pw_uid)\n" +
+			"print(datetime.now().astimezone().tzname(), datetime.now(ZoneInfo(os.environ['TZ'])).utcoffset())\n" +
+			"print(os.environ['LANG'])",
+		EnvVars:  []string{"TZ=UTC"},
+		Timezone: "Asia/Tokyo",
+		Locale:   "C.UTF-8",
+		Timeout:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/tmp 65534\nJST 9:00:00\nC.UTF-8\n"; result.ExitCode != 0 || result.Output != want {
+		t.Fatalf("exit %d output %q stderr %q, want %q", result.ExitCode, result.Output, result.Stderr, want)
+	}
+	if result.Timezone != "Asia/Tokyo" || result.Locale != "C.UTF-8" || (result.Passwd != sandbox.PasswdImage && result.Passwd != sandbox.PasswdGenerated) {
+		t.Errorf("reported timezone %q, locale %q, passwd %q", result.Timezone, result.Locale, result.Passwd)
+	}
+
+	_, err = backend.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python", Code: "print(1)", Timezone: "Mars/Olympus_Mons", Timeout: 30 * time.Second,
+	})
+	if !errors.Is(err, sandbox.ErrInvalidRequest) {
+		t.Errorf("bogus timezone: error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestE2ELimitEnforcement(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "docker"
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	checker, ok := backend.(sandbox.LimitEnforcementChecker)
+	if !ok {
+		t.Fatalf("%T doesn't report limit enforcement", backend)
+	}
+	enforcement, ok := checker.LimitEnforcement(context.Background())
+	if !ok || enforcement.Error != "" {
+		t.Fatalf("canary: %+v, %v", enforcement, ok)
+	}
+	if enforcement.Enforced[sandbox.LimitMemorySwap] {
+		t.Skip("the host enforces memory_swap; needs one without swap accounting")
+	}
+
+	// Permissive runs it anyway and says what didn't hold.
+	result, err := backend.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: "print(1)", Timeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Limits == nil || !slices.Contains(result.Limits.NotEnforced, sandbox.LimitMemorySwap) || result.Limits.Enforced.MemorySwapMB != 0 {
+		t.Errorf("limits %+v", result.Limits)
+	}
+	if !slices.ContainsFunc(result.SecurityEvents, func(ev sandbox.SecurityEvent) bool { return ev.Type == "limit_not_enforced" }) {
+		t.Errorf("no limit_not_enforced event in %+v", result.SecurityEvents)
+	}
+
+	cfg.Sandbox.LimitEnforcement = sandbox.LimitEnforcementStrict
+	strict, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strict.Close()
+	if _, err := strict.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: "print(1)", Timeout: 30 * time.Second}); !errors.Is(err, sandbox.ErrLimitNotEnforced) {
+		t.Errorf("strict: error = %v, want ErrLimitNotEnforced", err)
+	}
+}
+
+func TestE2EArgsAndCwd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python",
+		Code:     "import os, sys\nprint(sys.argv[1:])\nprint(os.getcwd())",
+		Args:     []string{"first", "second arg", "--flag=3"},
+		Cwd:      "/tmp",
+		Timeout:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "['first', 'second arg', '--flag=3']\n/tmp\n"
+	if result.ExitCode != 0 || result.Output != want {
+		t.Fatalf("exit %d output %q stderr %q, want %q", result.ExitCode, result.Output, result.Stderr, want)
+	}
+	if result.Cwd != "/tmp" || result.Argv[len(result.Argv)-1] != "--flag=3" {
+		t.Errorf("reported argv %v cwd %q", result.Argv, result.Cwd)
+	}
+
+	_, err = runner.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python", Code: "print(1)", Cwd: "/etc",
+	})
+	if !errors.Is(err, sandbox.ErrInvalidRequest) {
+		t.Errorf("cwd /etc: error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+// TestE2EUlimitNoFile opens 500 files at once, which the default nofile of
+// 256 refuses and a raised one allows.
+func TestE2EUlimitNoFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+
+	code := "import resource\nprint(resource.getrlimit(resource.RLIMIT_NOFILE)[0])\n" +
+		"files = [open('/tmp/f%d' % i, 'w') for i in range(500)]\nprint('opened', len(files))"
+	run := func(limits sandbox.ResourceLimits) *sandbox.ExecutionResult {
+		t.Helper()
+		result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{
+			Language: "python", Code: code, Limits: limits, Timeout: 30 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := run(sandbox.DefaultLimits())
+	if result.ExitCode == 0 || !strings.Contains(result.Stderr, "Too many open files") {
+		t.Errorf("default nofile: exit %d stderr %q, want EMFILE", result.ExitCode, result.Stderr)
+	}
+	if result.Ulimits == nil || result.Ulimits.NoFile != 256 {
+		t.Errorf("default nofile: reported ulimits %+v", result.Ulimits)
+	}
+
+	limits := sandbox.DefaultLimits()
+	limits.Ulimits = &sandbox.Ulimits{NoFile: 1024}
+	result = run(limits)
+	if result.ExitCode != 0 || result.Output != "1024\nopened 500\n" {
+		t.Errorf("nofile 1024: exit %d output %q stderr %q", result.ExitCode, result.Output, result.Stderr)
+	}
+	if result.Ulimits == nil || result.Ulimits.NoFile != 1024 || result.Ulimits.NProc != 50 {
+		t.Errorf("nofile 1024: reported ulimits %+v", result.Ulimits)
+	}
+
+	limits.Ulimits = &sandbox.Ulimits{NoFile: 1 << 20}
+	_, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: code, Limits: limits})
+	if !errors.Is(err, sandbox.ErrInvalidRequest) || !strings.Contains(err.Error(), "sandbox.max_ulimits.nofile") {
+		t.Errorf("nofile over the ceiling: error = %v", err)
+	}
+}
+
+// TestE2EPythonNoSite runs benign programs under python -S, and checks that
+// code calling a site-only builtin keeps site.
+func TestE2EPythonNoSite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner := sandbox.NewDockerRunner(10, nil, 0, "", 5)
+	defer runner.Close()
+	ctx := context.Background()
+	// The first run pulls the image; warmups need it probed.
+	if _, err := runner.Execute(ctx, sandbox.ExecutionRequest{Language: "python", Code: "pass", Timeout: 2 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	corpus := []struct {
+		name, code, want string
+		wantExit         int
+		noSite           bool
+	}{
+		{"hello", `print("Hello from sandbox!")`, "Hello from sandbox!", 0, true},
+		{"math", `print(sum(range(101)))`, "5050", 0, true},
+		{"stdlib", "import json, re, collections\nprint(json.dumps(collections.Counter(re.findall(r'\\w', 'aab'))))", `{"a": 2, "b": 1}`, 0, true},
+		{"tmp", "with open('/tmp/t.txt', 'w') as f:\n    f.write('tmpfs works')\nprint(open('/tmp/t.txt').read())", "tmpfs works", 0, true},
+		{"unicode", `print("héllo ✓")`, "héllo ✓", 0, true},
+		{"sys.exit", "import sys\nprint('bye')\nsys.exit(3)", "bye", 3, true},
+		{"exit builtin", "print('bye')\nexit(4)", "bye", 4, false},
+	}
+	for _, tc := range corpus {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := runner.Execute(ctx, sandbox.ExecutionRequest{Language: "python", Code: tc.code, Timeout: 30 * time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tc.wantExit || !strings.Contains(result.Output, tc.want) {
+				t.Fatalf("exit %d output %q stderr %q, want exit %d and %q", result.ExitCode, result.Output, result.Stderr, tc.wantExit, tc.want)
+			}
+			if got := slices.Contains(result.Warmups, runtime.WarmupNoSite); got != tc.noSite {
+				t.Errorf("warmups %v, argv %v: no_site = %v, want %v", result.Warmups, result.Argv, got, tc.noSite)
+			}
+			if got := slices.Contains(result.Argv, "-S"); got != tc.noSite {
+				t.Errorf("argv %v: -S = %v, want %v", result.Argv, got, tc.noSite)
+			}
+		})
+	}
+}
+
+// TestE2ESeccompVerified checks that a normal run reports the filter the
+// wrapper found, and that code exiting with the wrapper's refusal code still
+// gets its result.
+func TestE2ESeccompVerified(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner, err := sandbox.NewLocalDockerRunner(config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		language, code string
+		wantExit       int
+	}{
+		{"python", `print("hi")`, 0},
+		{"node", `console.log("hi")`, 0},
+		{"bash", `echo hi`, 0},
+		{"python", "import sys\nsys.exit(97)", 97},
+	} {
+		t.Run(tc.language, func(t *testing.T) {
+			result, err := runner.Execute(ctx, sandbox.ExecutionRequest{Language: tc.language, Code: tc.code, Timeout: 2 * time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tc.wantExit {
+				t.Fatalf("exit %d stderr %q, want %d", result.ExitCode, result.Stderr, tc.wantExit)
+			}
+			if result.Seccomp == nil || result.Seccomp.Mode != "filter" {
+				t.Fatalf("seccomp = %+v, want filter mode", result.Seccomp)
+			}
+			if slices.Contains(result.Argv, "/bin/sh") {
+				t.Errorf("argv %v reports the wrapper", result.Argv)
+			}
+		})
+	}
+}
+
+// TestE2EStdin checks a request's stdin reaches the program and is closed
+// after it, on the docker backend with the seccomp and code integrity
+// wrappers in between.
+func TestE2EStdin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	runner, err := sandbox.NewLocalDockerRunner(config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	input := "alpha\nbeta\n"
+	result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{
+		Language: "python", Code: `import sys; print(sys.stdin.read(), end="")`, Stdin: input, Timeout: 2 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 0 || result.Output != input {
+		t.Errorf("exit %d output %q stderr %q, want %q back", result.ExitCode, result.Output, result.Stderr, input)
+	}
+}
+
+func TestE2EMaskedPaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.VerifyMaskedPaths = true
+	runner, err := sandbox.NewLocalDockerRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	// Slow enough for the probe to get in before the container exits.
+	code := `import time
+time.sleep(1)
+try:
+    data = open("/proc/kcore", "rb").read(16)
+except OSError as e:
+    print("denied", e)
+else:
+    print("read", len(data))
+`
+	result, err := runner.Execute(context.Background(), sandbox.ExecutionRequest{Language: "python", Code: code, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := strings.TrimSpace(result.Output); !strings.HasPrefix(out, "denied") && out != "read 0" {
+		t.Fatalf("/proc/kcore readable: output %q stderr %q", result.Output, result.Stderr)
+	}
+	for _, ev := range result.SecurityEvents {
+		if ev.Type == "unmasked_path" || ev.Type == "writable_path" {
+			t.Errorf("security event %s: %s", ev.Type, ev.Detail)
+		}
+	}
+}
+
 func TestE2EClaudeRuntime(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping e2e test in short mode")
@@ -354,3 +1254,108 @@ func TestE2EClaudeRuntime(t *testing.T) {
 		t.Fatal("expected validation error for empty prompt")
 	}
 }
+
+// TestE2EClaudeContract runs the contract check against the locally built
+// claude image, which must meet it with and without the auth proxy.
+func TestE2EClaudeContract(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+	out, err := exec.Command("docker", "images", "-q", "sandbox-claude:latest").Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		t.Skip("sandbox-claude:latest image not built, skipping (run: make claude-image)")
+	}
+
+	runner, err := sandbox.NewLocalDockerRunner(config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	status := runner.ClaudeContract(context.Background(), true)
+	if !status.Compatible() {
+		t.Fatalf("contract check: %+v", status)
+	}
+	if status.Digest == "" || status.Manifest.ClaudeVersion == "" {
+		t.Errorf("status = %+v, want the digest and claude version", status)
+	}
+	for _, flag := range []string{"--model", "--max-turns", "--allowedTools", "--disallowedTools"} {
+		if !slices.Contains(status.Manifest.Flags, flag) {
+			t.Errorf("manifest flags %v lack %s", status.Manifest.Flags, flag)
+		}
+	}
+	if !slices.Contains(status.Manifest.Env, "ANTHROPIC_BASE_URL") {
+		t.Errorf("manifest env %v lacks ANTHROPIC_BASE_URL, which the auth proxy needs", status.Manifest.Env)
+	}
+}
+
+// TestE2EAuthProxyTLS starts the auth proxy on the bridge network's gateway
+// with TLS and the ACL on, and checks that Node in the claude image
+// completes the handshake trusting only the CA it is given the way claude
+// containers are.
+func TestE2EAuthProxyTLS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	requireDocker(t)
+	out, err := exec.Command("docker", "images", "-q", "sandbox-claude:latest").Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		t.Skip("sandbox-claude:latest image not built, skipping (run: make claude-image)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	bridge, err := sandbox.InspectDockerBridge(ctx)
+	if err != nil {
+		t.Skipf("no bridge network: %v", err)
+	}
+	ip, err := sandbox.AuthProxyBind("docker_gateway", func() (sandbox.DockerBridge, error) { return bridge, nil })
+	if err != nil {
+		t.Skipf("no gateway to bind: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Skipf("can't listen on the bridge gateway %s (Docker Desktop?): %v", ip, err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	proxy := authproxy.New(port, "tok", "sec", authproxy.TransportConfig{})
+	proxy.SetBind(ip)
+	acl := authproxy.NewSourceACL(nil, func(context.Context) ([]netip.Prefix, error) { return bridge.Subnets, nil })
+	if err := acl.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetSourceACL(acl)
+	caPEM, err := proxy.UseTLS(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxy.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close(context.Background())
+
+	// Without the secret the proxy answers 403, which takes a handshake.
+	fetch := fmt.Sprintf(`fetch("https://host.docker.internal:%d/v1/messages").then(r => console.log(r.status), e => { console.log(e.cause ? e.cause.code : e.message); process.exit(1) })`, port)
+	run := func(extra ...string) (string, error) {
+		args := append([]string{"run", "--rm", "--network", "bridge", "--add-host", "host.docker.internal:" + ip.String()}, extra...)
+		args = append(args, "--entrypoint", "node", "sandbox-claude:latest", "-e", fetch)
+		out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	got, err := run("-v", caFile+":/run/sandbox/proxy-ca.pem:ro", "-e", "NODE_EXTRA_CA_CERTS=/run/sandbox/proxy-ca.pem")
+	if err != nil || got != "403" {
+		t.Errorf("with the CA: %q, %v; want 403", got, err)
+	}
+	if got, err := run(); err == nil {
+		t.Errorf("without the CA the handshake succeeded: %q", got)
+	}
+}
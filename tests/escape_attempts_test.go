@@ -2,9 +2,17 @@ package tests
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"safe-agent-sandbox/internal/config"
 	"safe-agent-sandbox/internal/sandbox"
 )
 
@@ -254,3 +262,75 @@ func TestResourceIsolation(t *testing.T) {
 		t.Error("concurrent executions should have different IDs")
 	}
 }
+
+// TestContainerdCNINetwork checks network_enabled on the containerd backend
+// means a working network: a fetch from the host succeeds with it, and the
+// same code gets nowhere without it. It needs root, containerd and the CNI
+// reference plugins in /opt/cni/bin.
+func TestContainerdCNINetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+	cfg := config.DefaultConfig()
+	cfg.Sandbox.Backend = "containerd"
+	cfg.Sandbox.Namespace = "sandbox-test"
+	cfg.Sandbox.CNI.Enabled = true
+	for _, plugin := range []string{"bridge", "host-local", "firewall"} {
+		if _, err := os.Stat(filepath.Join(cfg.Sandbox.CNI.PluginDir, plugin)); err != nil {
+			t.Skipf("CNI %s plugin not installed, skipping: %v", plugin, err)
+		}
+	}
+	backend, err := sandbox.NewBackend(context.Background(), cfg, nil)
+	if err != nil {
+		t.Skipf("containerd not available, skipping: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	// The host answers on the bridge's gateway address.
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("pong")) })}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { srv.Close() })
+	gateway := netip.MustParsePrefix(cfg.Sandbox.CNI.Subnet).Addr().Next()
+	url := fmt.Sprintf("http://%s:%d/", gateway, ln.Addr().(*net.TCPAddr).Port)
+
+	code := fmt.Sprintf(`import urllib.request
+try:
+    print(urllib.request.urlopen(%q, timeout=5).read().decode())
+except OSError as e:
+    print("failed", e)
+`, url)
+	run := func(network bool) *sandbox.ExecutionResult {
+		t.Helper()
+		result, err := backend.Execute(context.Background(), sandbox.ExecutionRequest{
+			Code:           code,
+			Language:       "python",
+			Timeout:        20 * time.Second,
+			Limits:         sandbox.DefaultLimits(),
+			NetworkEnabled: network,
+		})
+		if err != nil {
+			t.Fatalf("network=%v: %v", network, err)
+		}
+		return result
+	}
+
+	result := run(true)
+	if strings.TrimSpace(result.Output) != "pong" {
+		t.Errorf("with network: output %q, stderr %q", result.Output, result.Stderr)
+	}
+	if addr, err := netip.ParseAddr(result.IP); err != nil || !netip.MustParsePrefix(cfg.Sandbox.CNI.Subnet).Contains(addr) {
+		t.Errorf("with network: ip %q not in %s", result.IP, cfg.Sandbox.CNI.Subnet)
+	}
+
+	result = run(false)
+	if strings.Contains(result.Output, "pong") {
+		t.Errorf("without network: fetch succeeded")
+	}
+	if result.IP != "" {
+		t.Errorf("without network: ip %q", result.IP)
+	}
+}
@@ -27,7 +27,7 @@ func setupTestServer(t *testing.T) *httptest.Server {
 	// Try to create a backend (Docker or containerd)
 	var backend sandbox.Backend
 	ctx := context.Background()
-	b, err := sandbox.NewBackend(ctx, cfg)
+	b, err := sandbox.NewBackend(ctx, cfg, nil)
 	if err == nil {
 		backend = b
 		t.Cleanup(func() { backend.Close() })
@@ -143,7 +143,7 @@ func TestExecuteValidation(t *testing.T) {
 
 			var errResp api.ErrorResponse
 			_ = json.NewDecoder(resp.Body).Decode(&errResp)
-			if errResp.Code != tt.wantCode {
+			if string(errResp.Code) != tt.wantCode {
 				t.Errorf("expected error code %q, got %q", tt.wantCode, errResp.Code)
 			}
 		})